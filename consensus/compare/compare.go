@@ -0,0 +1,358 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+/*
+Package compare runs the same workload through consensus plugins on
+identical in-process networks, and reports their throughput, latency and
+message counts side by side.
+
+Every validator of a network runs its own instance of the plugin over an
+in-memory stack: executing a transaction only adds it to the block being
+built, and the messages between validators are delivered in order, without
+loss or delay. The reports measure the cost of ordering alone, to compare the
+plugins with each other, rather than predict the performance of a deployed
+network.
+
+The workload is either synthetic, or read from the message trace a validator
+recorded (peer.validator.consensus.record), whose client transactions are
+submitted in their recorded order and at their recorded pace. Transactions
+are all submitted to validator vp0, as the clients of a peer do, and the
+latency of a transaction is the time from its submission until vp0 commits
+it.
+
+The plugins read their configuration as they do in the peer, so the number of
+validators must match theirs, and NOOPS needs an orderer, such as
+CORE_NOOPS_ORDERER=vp0, to keep a network of more than one validator
+consistent.
+*/
+package compare
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"text/tabwriter"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/op/go-logging"
+
+	"github.com/hyperledger/fabric/consensus"
+	"github.com/hyperledger/fabric/consensus/replay"
+	"github.com/hyperledger/fabric/core/util"
+	pb "github.com/hyperledger/fabric/protos"
+
+	// the plugins of the peer register themselves
+	_ "github.com/hyperledger/fabric/consensus/noops"
+	_ "github.com/hyperledger/fabric/consensus/obcpbft"
+	_ "github.com/hyperledger/fabric/consensus/raft"
+)
+
+var logger = logging.MustGetLogger("consensus/compare")
+
+// Workload is a sequence of client transactions, each submitted the delay of
+// the same index after the previous one
+type Workload struct {
+	Transactions []*pb.Transaction
+	Delays       []time.Duration
+}
+
+// SyntheticWorkload returns a workload of count invocations, submitted
+// interval apart
+func SyntheticWorkload(count int, interval time.Duration) (*Workload, error) {
+	w := &Workload{}
+	for i := 0; i < count; i++ {
+		tx, err := pb.NewTransaction(pb.ChaincodeID{Name: "compare"}, util.GenerateUUID(), "invoke", []string{fmt.Sprintf("%d", i)})
+		if err != nil {
+			return nil, err
+		}
+		tx.Type = pb.Transaction_CHAINCODE_INVOKE
+		w.Transactions = append(w.Transactions, tx)
+		if i == 0 {
+			w.Delays = append(w.Delays, 0)
+		} else {
+			w.Delays = append(w.Delays, interval)
+		}
+	}
+	return w, nil
+}
+
+// ReadWorkload reads the client transactions of the message trace read from
+// r, with the time elapsed between them as they were recorded
+func ReadWorkload(r io.Reader) (*Workload, error) {
+	w := &Workload{}
+	reader := replay.NewReader(r)
+	var last time.Time
+	for {
+		rec, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if rec.Msg.Type != pb.Message_CHAIN_TRANSACTION {
+			continue
+		}
+		tx := &pb.Transaction{}
+		if err = proto.Unmarshal(rec.Msg.Payload, tx); err != nil {
+			return nil, fmt.Errorf("Cannot unmarshal recorded transaction: %s", err)
+		}
+		at := time.Unix(rec.Timestamp.Seconds, int64(rec.Timestamp.Nanos))
+		var delay time.Duration
+		if len(w.Transactions) > 0 && at.After(last) {
+			delay = at.Sub(last)
+		}
+		last = at
+		w.Transactions = append(w.Transactions, tx)
+		w.Delays = append(w.Delays, delay)
+	}
+	if len(w.Transactions) == 0 {
+		return nil, errors.New("The trace holds no client transaction")
+	}
+	return w, nil
+}
+
+// Options of a comparison
+type Options struct {
+	Validators int           // Number of validators of the networks, 4 if 0
+	Speedup    float64       // Factor the delays of the workload are divided by, 1 if 0
+	Timeout    time.Duration // Time to wait for the transactions to commit after the last submission, 30s if 0
+}
+
+func (opts Options) withDefaults() Options {
+	if opts.Validators <= 0 {
+		opts.Validators = 4
+	}
+	if opts.Speedup <= 0 {
+		opts.Speedup = 1
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = 30 * time.Second
+	}
+	return opts
+}
+
+// Report is the outcome of a workload run through a plugin
+type Report struct {
+	Plugin     string
+	Validators int
+	Submitted  int
+	Committed  int           // Transactions committed by vp0
+	Elapsed    time.Duration // From the first submission to the last commit by vp0
+	Throughput float64       // Transactions committed by vp0 per second
+	Mean       time.Duration // Latencies of the transactions committed by vp0
+	P50        time.Duration
+	P99        time.Duration
+	Max        time.Duration
+	Blocks     uint64 // Blocks committed by vp0, besides the genesis block
+	Messages   uint64 // Consensus messages sent between validators
+	Bytes      uint64 // Size of those messages
+	Consistent bool   // Whether all validators ended with the same chain
+	Err        error  // Why the plugin could not run the workload
+}
+
+// tracker records the submissions and commits of the transactions
+type tracker struct {
+	lock      sync.Mutex
+	submitted map[string]time.Time
+	latencies []time.Duration
+	last      time.Time
+	committed []map[string]bool // by validator
+	remaining int               // commits missing for every validator to commit every transaction
+	done      chan struct{}
+}
+
+func (t *tracker) submit(uuid string) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.submitted[uuid] = time.Now()
+}
+
+func (t *tracker) commit(v *validator, blocks []*pb.Block, at time.Time) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	for _, block := range blocks {
+		for _, tx := range block.Transactions {
+			submitted, ok := t.submitted[tx.Uuid]
+			if !ok || t.committed[v.id][tx.Uuid] {
+				continue
+			}
+			t.committed[v.id][tx.Uuid] = true
+			if v.id == 0 {
+				t.latencies = append(t.latencies, at.Sub(submitted))
+				t.last = at
+			}
+			if t.remaining--; t.remaining == 0 {
+				close(t.done)
+			}
+		}
+	}
+}
+
+// Run submits the workload to a network of validators running plugin, and
+// reports how the network ordered it
+func Run(plugin string, workload *Workload, opts Options) (*Report, error) {
+	factory, ok := consensus.GetPluginFactory(plugin)
+	if !ok {
+		return nil, fmt.Errorf("Unknown consensus plugin %s", plugin)
+	}
+	opts = opts.withDefaults()
+
+	uuids := make(map[string]bool)
+	for _, tx := range workload.Transactions {
+		uuids[tx.Uuid] = true
+	}
+	t := &tracker{
+		submitted: make(map[string]time.Time),
+		remaining: opts.Validators * len(uuids),
+		done:      make(chan struct{}),
+	}
+	for i := 0; i < opts.Validators; i++ {
+		t.committed = append(t.committed, make(map[string]bool))
+	}
+	net := newNetwork(opts.Validators, t.commit)
+	if err := net.start(factory); err != nil {
+		return nil, err
+	}
+	defer net.stop()
+
+	logger.Infof("Running %d transactions through %d validators of %s", len(workload.Transactions), opts.Validators, plugin)
+	var start time.Time
+	for i, tx := range workload.Transactions {
+		if delay := time.Duration(float64(workload.Delays[i]) / opts.Speedup); delay > 0 {
+			time.Sleep(delay)
+		}
+		if i == 0 {
+			start = time.Now()
+		}
+		t.submit(tx.Uuid)
+		if err := net.submit(net.validators[0], tx); err != nil {
+			return nil, err
+		}
+	}
+	select {
+	case <-t.done:
+	case <-time.After(opts.Timeout):
+		logger.Warningf("Not all transactions committed on every validator of %s within %s", plugin, opts.Timeout)
+	}
+
+	report := &Report{
+		Plugin:     plugin,
+		Validators: opts.Validators,
+		Submitted:  len(workload.Transactions),
+		Messages:   atomic.LoadUint64(&net.messages),
+		Bytes:      atomic.LoadUint64(&net.bytes),
+		Consistent: true,
+	}
+	height, hash := net.validators[0].head()
+	report.Blocks = height - 1
+	for _, v := range net.validators[1:] {
+		if h, hh := v.head(); h != height || !bytes.Equal(hh, hash) {
+			report.Consistent = false
+		}
+	}
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	latencies := append([]time.Duration(nil), t.latencies...)
+	report.Committed = len(latencies)
+	if report.Committed == 0 {
+		return report, nil
+	}
+	report.Elapsed = t.last.Sub(start)
+	if report.Elapsed > 0 {
+		report.Throughput = float64(report.Committed) / report.Elapsed.Seconds()
+	}
+	sort.Sort(durations(latencies))
+	var sum time.Duration
+	for _, latency := range latencies {
+		sum += latency
+	}
+	report.Mean = sum / time.Duration(len(latencies))
+	report.P50 = latencies[len(latencies)/2]
+	report.P99 = latencies[(len(latencies)*99)/100]
+	report.Max = latencies[len(latencies)-1]
+	return report, nil
+}
+
+type durations []time.Duration
+
+func (d durations) Len() int           { return len(d) }
+func (d durations) Less(i, j int) bool { return d[i] < d[j] }
+func (d durations) Swap(i, j int)      { d[i], d[j] = d[j], d[i] }
+
+// Compare runs the workload through each of plugins in turn, on networks of
+// the same size. A plugin which cannot run it is reported with its error.
+func Compare(plugins []string, workload *Workload, opts Options) []*Report {
+	var reports []*Report
+	opts = opts.withDefaults()
+	for _, plugin := range plugins {
+		report, err := Run(plugin, workload, opts)
+		if err != nil {
+			report = &Report{Plugin: plugin, Validators: opts.Validators, Submitted: len(workload.Transactions), Err: err}
+		}
+		reports = append(reports, report)
+	}
+	return reports
+}
+
+// WriteReports writes reports as a table, a plugin per row, followed by the
+// errors of the plugins which could not run the workload
+func WriteReports(w io.Writer, reports []*Report) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "PLUGIN\tVALIDATORS\tCOMMITTED\tTX/S\tMEAN\tP50\tP99\tMAX\tBLOCKS\tMESSAGES\tMSG/TX\tKB\tCONSISTENT\t")
+	var failed []*Report
+	for _, r := range reports {
+		if r.Err != nil {
+			failed = append(failed, r)
+			continue
+		}
+		var perTx float64
+		if r.Committed > 0 {
+			perTx = float64(r.Messages) / float64(r.Committed)
+		}
+		fmt.Fprintf(tw, "%s\t%d\t%d/%d\t%.1f\t%s\t%s\t%s\t%s\t%d\t%d\t%.1f\t%d\t%t\t\n",
+			r.Plugin, r.Validators, r.Committed, r.Submitted, r.Throughput,
+			round(r.Mean), round(r.P50), round(r.P99), round(r.Max),
+			r.Blocks, r.Messages, perTx, r.Bytes>>10, r.Consistent)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	for _, r := range failed {
+		if _, err := fmt.Fprintf(w, "%s: %s\n", r.Plugin, r.Err); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// round trims latencies to the microsecond under a millisecond, to tens of
+// microseconds under a second, and to the millisecond beyond
+func round(d time.Duration) time.Duration {
+	switch {
+	case d < time.Millisecond:
+		return d - d%time.Microsecond
+	case d < time.Second:
+		return d - d%(10*time.Microsecond)
+	}
+	return d - d%time.Millisecond
+}
@@ -0,0 +1,97 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package compare
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+
+	"github.com/hyperledger/fabric/consensus/replay"
+	pb "github.com/hyperledger/fabric/protos"
+)
+
+func TestReadWorkload(t *testing.T) {
+	synthetic, err := SyntheticWorkload(3, 0)
+	if err != nil {
+		t.Fatalf("Failed to create workload: %s", err)
+	}
+	var trace bytes.Buffer
+	recorder := replay.NewRecorder(&trace)
+	for i, tx := range synthetic.Transactions {
+		payload, _ := proto.Marshal(tx)
+		recorder.Record(&pb.Message{Type: pb.Message_CONSENSUS, Payload: []byte("vote")}, &pb.PeerID{Name: "vp1"})
+		recorder.Record(&pb.Message{Type: pb.Message_CHAIN_TRANSACTION, Payload: payload}, &pb.PeerID{Name: "vp0"})
+		if i == 0 {
+			time.Sleep(20 * time.Millisecond)
+		}
+	}
+	recorder.Close()
+
+	workload, err := ReadWorkload(&trace)
+	if err != nil {
+		t.Fatalf("Failed to read workload: %s", err)
+	}
+	if len(workload.Transactions) != 3 || workload.Transactions[2].Uuid != synthetic.Transactions[2].Uuid {
+		t.Fatalf("Expected the 3 client transactions of the trace, got %v", workload.Transactions)
+	}
+	if workload.Delays[0] != 0 || workload.Delays[1] < 20*time.Millisecond {
+		t.Fatalf("Expected the transactions to keep their recorded pace, got %v", workload.Delays)
+	}
+
+	if _, err = ReadWorkload(&bytes.Buffer{}); err == nil {
+		t.Fatalf("Expected an error reading a trace without transactions")
+	}
+}
+
+func TestCompare(t *testing.T) {
+	os.Setenv("CORE_NOOPS_ORDERER", "vp0")
+	defer os.Unsetenv("CORE_NOOPS_ORDERER")
+
+	workload, err := SyntheticWorkload(20, time.Millisecond)
+	if err != nil {
+		t.Fatalf("Failed to create workload: %s", err)
+	}
+	plugins := []string{"noops", "pbft", "raft", "none"}
+	reports := Compare(plugins, workload, Options{Timeout: 15 * time.Second})
+	for i, r := range reports[:3] {
+		if r.Plugin != plugins[i] || r.Err != nil {
+			t.Fatalf("Expected %s to run the workload, got %+v", plugins[i], r)
+		}
+		if r.Committed != 20 || !r.Consistent || r.Blocks == 0 || r.Throughput <= 0 || r.Max < r.P50 {
+			t.Errorf("Expected %s to commit the workload consistently, got %+v", r.Plugin, r)
+		}
+	}
+	if reports[3].Err == nil {
+		t.Errorf("Expected an error running an unknown plugin")
+	}
+	// PBFT orders with three phases among all validators
+	if reports[1].Messages <= reports[0].Messages {
+		t.Errorf("Expected pbft to send more messages than noops, got %d and %d", reports[1].Messages, reports[0].Messages)
+	}
+
+	var out bytes.Buffer
+	WriteReports(&out, reports)
+	if lines := strings.Split(strings.TrimSpace(out.String()), "\n"); len(lines) != 5 || lines[4] != "none: Unknown consensus plugin none" {
+		t.Errorf("Unexpected report:\n%s", out.String())
+	}
+	t.Logf("\n%s", out.String())
+}
@@ -0,0 +1,527 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package compare
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	google_protobuf "google/protobuf"
+
+	"github.com/hyperledger/fabric/consensus"
+	pb "github.com/hyperledger/fabric/protos"
+)
+
+// queue runs functions in the order they were pushed, on a goroutine of its
+// own. It is unbounded, so that a validator sending to another never blocks
+// on it.
+type queue struct {
+	lock   sync.Mutex
+	cond   *sync.Cond
+	items  []func()
+	closed bool
+}
+
+func newQueue() *queue {
+	q := &queue{}
+	q.cond = sync.NewCond(&q.lock)
+	go q.run()
+	return q
+}
+
+func (q *queue) push(f func()) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	if q.closed {
+		return
+	}
+	q.items = append(q.items, f)
+	q.cond.Signal()
+}
+
+// close drops the functions not run yet
+func (q *queue) close() {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	q.closed = true
+	q.items = nil
+	q.cond.Signal()
+}
+
+func (q *queue) run() {
+	for {
+		q.lock.Lock()
+		for len(q.items) == 0 && !q.closed {
+			q.cond.Wait()
+		}
+		if q.closed {
+			q.lock.Unlock()
+			return
+		}
+		f := q.items[0]
+		q.items = q.items[1:]
+		q.lock.Unlock()
+		f()
+	}
+}
+
+// network is an in-process network of validators running the same plugin.
+// Messages between validators are delivered in the order they were sent,
+// without loss, and counted.
+type network struct {
+	validators []*validator
+	messages   uint64 // atomic, consensus messages sent between validators
+	bytes      uint64 // atomic, their size
+
+	committed func(v *validator, blocks []*pb.Block, at time.Time)
+}
+
+func newNetwork(n int, committed func(v *validator, blocks []*pb.Block, at time.Time)) *network {
+	net := &network{committed: committed}
+	for i := 0; i < n; i++ {
+		net.validators = append(net.validators, &validator{
+			net:    net,
+			id:     i,
+			handle: &pb.PeerID{Name: fmt.Sprintf("vp%d", i)},
+			blocks: []*pb.Block{{}},
+			state:  make(map[string][]byte),
+		})
+	}
+	return net
+}
+
+// start creates, initializes and starts the plugin of every validator
+func (net *network) start(factory consensus.PluginFactory) error {
+	for _, v := range net.validators {
+		v.plugin = factory()
+		v.inbox = newQueue()
+		v.execs = newQueue()
+		if err := v.plugin.Init(v, consensus.NewTimerFactory()); err != nil {
+			net.stop()
+			return fmt.Errorf("Cannot initialize validator %s: %s", v.handle.Name, err)
+		}
+	}
+	for _, v := range net.validators {
+		if err := v.plugin.Start(); err != nil {
+			net.stop()
+			return fmt.Errorf("Cannot start validator %s: %s", v.handle.Name, err)
+		}
+	}
+	return nil
+}
+
+func (net *network) stop() {
+	for _, v := range net.validators {
+		if v.inbox == nil {
+			continue
+		}
+		v.inbox.close()
+		v.execs.close()
+		v.plugin.Stop()
+	}
+}
+
+// submit hands a client transaction to validator v, as its peer does
+func (net *network) submit(v *validator, tx *pb.Transaction) error {
+	payload, err := proto.Marshal(tx)
+	if err != nil {
+		return err
+	}
+	msg := &pb.Message{Type: pb.Message_CHAIN_TRANSACTION, Payload: payload}
+	v.inbox.push(func() { v.recv(msg, v.handle) })
+	return nil
+}
+
+func (net *network) send(from, to *validator, msg *pb.Message) {
+	atomic.AddUint64(&net.messages, 1)
+	atomic.AddUint64(&net.bytes, uint64(proto.Size(msg)))
+	msg = proto.Clone(msg).(*pb.Message)
+	to.inbox.push(func() { to.recv(msg, from.handle) })
+}
+
+// validator implements the stack of a plugin, keeping its chain and its
+// persisted state in memory. Executions requested through the Executor
+// interface run in order, on a goroutine of their own.
+type validator struct {
+	net    *network
+	id     int
+	handle *pb.PeerID
+	plugin consensus.Plugin
+	inbox  *queue
+	execs  *queue
+
+	lock      sync.Mutex
+	blocks    []*pb.Block
+	batchID   interface{}
+	batch     []*pb.Transaction
+	timestamp *google_protobuf.Timestamp
+	state     map[string][]byte
+}
+
+func (v *validator) recv(msg *pb.Message, sender *pb.PeerID) {
+	if err := v.plugin.RecvMsg(msg, sender); err != nil {
+		logger.Debugf("Validator %s rejected a message from %s: %s", v.handle.Name, sender.Name, err)
+	}
+}
+
+// head returns the height of the chain of v and the hash of its head block
+func (v *validator) head() (uint64, []byte) {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	hash, _ := v.blocks[len(v.blocks)-1].GetHash()
+	return uint64(len(v.blocks)), hash
+}
+
+// GetNetworkInfo implements the Inquirer interface
+func (v *validator) GetNetworkInfo() (self *pb.PeerEndpoint, network []*pb.PeerEndpoint, err error) {
+	for _, other := range v.net.validators {
+		ep := &pb.PeerEndpoint{ID: other.handle, Address: other.handle.Name, Type: pb.PeerEndpoint_VALIDATOR}
+		if other == v {
+			self = ep
+		}
+		network = append(network, ep)
+	}
+	return
+}
+
+// GetNetworkHandles implements the Inquirer interface
+func (v *validator) GetNetworkHandles() (self *pb.PeerID, network []*pb.PeerID, err error) {
+	for _, other := range v.net.validators {
+		network = append(network, other.handle)
+	}
+	return v.handle, network, nil
+}
+
+// Broadcast implements the Communicator interface. There are no non
+// validating peers to broadcast to.
+func (v *validator) Broadcast(msg *pb.Message, peerType pb.PeerEndpoint_Type) error {
+	if peerType == pb.PeerEndpoint_NON_VALIDATOR {
+		return nil
+	}
+	for _, other := range v.net.validators {
+		if other != v {
+			v.net.send(v, other, msg)
+		}
+	}
+	return nil
+}
+
+// Unicast implements the Communicator interface
+func (v *validator) Unicast(msg *pb.Message, receiverHandle *pb.PeerID) error {
+	for _, other := range v.net.validators {
+		if other.handle.Name == receiverHandle.Name {
+			v.net.send(v, other, msg)
+			return nil
+		}
+	}
+	return fmt.Errorf("Unknown validator %s", receiverHandle.Name)
+}
+
+// Sign implements the SecurityUtils interface, without signing
+func (v *validator) Sign(msg []byte) ([]byte, error) {
+	return msg, nil
+}
+
+// Verify implements the SecurityUtils interface, accepting every signature
+func (v *validator) Verify(peerID *pb.PeerID, signature []byte, message []byte) error {
+	return nil
+}
+
+// SetBatchTimestamp implements the BatchClock interface
+func (v *validator) SetBatchTimestamp(timestamp *google_protobuf.Timestamp) {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	v.timestamp = timestamp
+}
+
+// BeginTxBatch implements the LegacyExecutor interface
+func (v *validator) BeginTxBatch(id interface{}) error {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	if v.batchID != nil {
+		return fmt.Errorf("Tx batch is already active")
+	}
+	v.batchID = id
+	v.batch = nil
+	return nil
+}
+
+// ExecTxs implements the LegacyExecutor interface. Executing a transaction
+// only adds it to the block being built.
+func (v *validator) ExecTxs(id interface{}, txs []*pb.Transaction) ([]byte, error) {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	if !reflect.DeepEqual(v.batchID, id) {
+		return nil, fmt.Errorf("Invalid batch ID")
+	}
+	v.batch = append(v.batch, txs...)
+	return v.stateHash(), nil
+}
+
+// CommitTxBatch implements the LegacyExecutor interface
+func (v *validator) CommitTxBatch(id interface{}, metadata []byte) (*pb.Block, error) {
+	v.lock.Lock()
+	if !reflect.DeepEqual(v.batchID, id) {
+		v.lock.Unlock()
+		return nil, fmt.Errorf("Invalid batch ID")
+	}
+	block := v.commit(metadata)
+	v.lock.Unlock()
+	v.net.committed(v, []*pb.Block{block}, time.Now())
+	return block, nil
+}
+
+// RollbackTxBatch implements the LegacyExecutor interface
+func (v *validator) RollbackTxBatch(id interface{}) error {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	if !reflect.DeepEqual(v.batchID, id) {
+		return fmt.Errorf("Invalid batch ID")
+	}
+	v.batchID, v.batch = nil, nil
+	return nil
+}
+
+// PreviewCommitTxBatch implements the LegacyExecutor interface
+func (v *validator) PreviewCommitTxBatch(id interface{}, metadata []byte) ([]byte, error) {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	if !reflect.DeepEqual(v.batchID, id) {
+		return nil, fmt.Errorf("Invalid batch ID")
+	}
+	return proto.Marshal(info(uint64(len(v.blocks))+1, v.block(metadata)))
+}
+
+// block returns the block of the batch being executed, to be committed on top
+// of the chain. Called with the lock held.
+func (v *validator) block(metadata []byte) *pb.Block {
+	previous, _ := v.blocks[len(v.blocks)-1].GetHash()
+	return &pb.Block{
+		Timestamp:         v.timestamp,
+		Transactions:      v.batch,
+		StateHash:         v.stateHash(),
+		PreviousBlockHash: previous,
+		ConsensusMetadata: metadata,
+	}
+}
+
+// commit appends the block of the batch being executed to the chain. Called
+// with the lock held.
+func (v *validator) commit(metadata []byte) *pb.Block {
+	block := v.block(metadata)
+	v.blocks = append(v.blocks, block)
+	v.batchID, v.batch, v.timestamp = nil, nil, nil
+	return block
+}
+
+// stateHash chains the hash of the state of the head block with the
+// transactions being executed. Called with the lock held.
+func (v *validator) stateHash() []byte {
+	h := sha256.New()
+	h.Write(v.blocks[len(v.blocks)-1].StateHash)
+	for _, tx := range v.batch {
+		h.Write([]byte(tx.Uuid))
+		h.Write(tx.Payload)
+	}
+	return h.Sum(nil)
+}
+
+func info(height uint64, head *pb.Block) *pb.BlockchainInfo {
+	hash, _ := head.GetHash()
+	return &pb.BlockchainInfo{Height: height, CurrentBlockHash: hash, PreviousBlockHash: head.PreviousBlockHash}
+}
+
+// Start implements the Executor interface
+func (v *validator) Start() {}
+
+// Halt implements the Executor interface
+func (v *validator) Halt() {}
+
+// Execute implements the Executor interface
+func (v *validator) Execute(tag interface{}, txs []*pb.Transaction) {
+	v.execs.push(func() {
+		v.lock.Lock()
+		if v.batchID == nil {
+			v.batchID = v
+		}
+		v.batch = append(v.batch, txs...)
+		v.lock.Unlock()
+		v.plugin.Executed(tag)
+	})
+}
+
+// Commit implements the Executor interface
+func (v *validator) Commit(tag interface{}, metadata []byte) {
+	v.execs.push(func() {
+		v.lock.Lock()
+		block := v.commit(metadata)
+		v.lock.Unlock()
+		v.net.committed(v, []*pb.Block{block}, time.Now())
+		v.plugin.Committed(tag, v.GetBlockchainInfo())
+	})
+}
+
+// Rollback implements the Executor interface
+func (v *validator) Rollback(tag interface{}) {
+	v.execs.push(func() {
+		v.lock.Lock()
+		v.batchID, v.batch = nil, nil
+		v.lock.Unlock()
+		v.plugin.RolledBack(tag)
+	})
+}
+
+// UpdateState implements the Executor interface, copying the blocks of the
+// first of peers which holds the target
+func (v *validator) UpdateState(tag interface{}, target *pb.BlockchainInfo, peers []*pb.PeerID) {
+	v.execs.push(func() {
+		if !v.transfer(target, peers) {
+			v.plugin.StateUpdated(tag, nil)
+			return
+		}
+		v.plugin.StateUpdated(tag, target)
+	})
+}
+
+// transfer replaces the chain of v with the chain of a peer which reached
+// target, waiting a little for a peer which did not yet
+func (v *validator) transfer(target *pb.BlockchainInfo, peers []*pb.PeerID) bool {
+	if len(peers) == 0 {
+		_, peers, _ = v.GetNetworkHandles()
+	}
+	for attempt := 0; attempt < 10; attempt++ {
+		for _, handle := range peers {
+			for _, other := range v.net.validators {
+				if other == v || other.handle.Name != handle.Name {
+					continue
+				}
+				other.lock.Lock()
+				var blocks []*pb.Block
+				if uint64(len(other.blocks)) >= target.Height {
+					hash, _ := other.blocks[target.Height-1].GetHash()
+					if reflect.DeepEqual(hash, target.CurrentBlockHash) {
+						blocks = append(blocks, other.blocks[:target.Height]...)
+					}
+				}
+				other.lock.Unlock()
+				if blocks == nil {
+					continue
+				}
+
+				v.lock.Lock()
+				var added []*pb.Block
+				if len(blocks) > len(v.blocks) {
+					added = blocks[len(v.blocks):]
+				}
+				v.blocks = blocks
+				v.batchID, v.batch = nil, nil
+				v.lock.Unlock()
+				v.net.committed(v, added, time.Now())
+				return true
+			}
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	return false
+}
+
+// InvalidateState implements the LedgerManager interface
+func (v *validator) InvalidateState() {}
+
+// ValidateState implements the LedgerManager interface
+func (v *validator) ValidateState() {}
+
+// GetBlock implements the ReadOnlyLedger interface
+func (v *validator) GetBlock(id uint64) (*pb.Block, error) {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	if id >= uint64(len(v.blocks)) {
+		return nil, fmt.Errorf("Block %d not found", id)
+	}
+	return v.blocks[id], nil
+}
+
+// GetBlockchainSize implements the ReadOnlyLedger interface
+func (v *validator) GetBlockchainSize() uint64 {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	return uint64(len(v.blocks))
+}
+
+// GetBlockchainInfo implements the ReadOnlyLedger interface
+func (v *validator) GetBlockchainInfo() *pb.BlockchainInfo {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	return info(uint64(len(v.blocks)), v.blocks[len(v.blocks)-1])
+}
+
+// GetBlockchainInfoBlob implements the ReadOnlyLedger interface
+func (v *validator) GetBlockchainInfoBlob() []byte {
+	raw, _ := proto.Marshal(v.GetBlockchainInfo())
+	return raw
+}
+
+// GetBlockHeadMetadata implements the ReadOnlyLedger interface
+func (v *validator) GetBlockHeadMetadata() ([]byte, error) {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	return v.blocks[len(v.blocks)-1].ConsensusMetadata, nil
+}
+
+// StoreState implements the StatePersistor interface
+func (v *validator) StoreState(key string, value []byte) error {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	v.state[key] = append([]byte(nil), value...)
+	return nil
+}
+
+// ReadState implements the StatePersistor interface
+func (v *validator) ReadState(key string) ([]byte, error) {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	if value, ok := v.state[key]; ok {
+		return value, nil
+	}
+	return nil, fmt.Errorf("cannot find key %s", key)
+}
+
+// ReadStateSet implements the StatePersistor interface
+func (v *validator) ReadStateSet(prefix string) (map[string][]byte, error) {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	set := make(map[string][]byte)
+	for key, value := range v.state {
+		if strings.HasPrefix(key, prefix) {
+			set[key] = value
+		}
+	}
+	return set, nil
+}
+
+// DelState implements the StatePersistor interface
+func (v *validator) DelState(key string) {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	delete(v.state, key)
+}
@@ -17,7 +17,12 @@ limitations under the License.
 package consensus
 
 import (
+	"errors"
+
+	"github.com/hyperledger/fabric/core/ledger/statemgmt"
 	pb "github.com/hyperledger/fabric/protos"
+
+	"google/protobuf"
 )
 
 // ExecutionConsumer allows callbacks from asycnhronous execution and statetransfer
@@ -35,6 +40,41 @@ type Consenter interface {
 	ExecutionConsumer
 }
 
+// StatusReporter is optionally implemented by consenters which can report a
+// JSON serializable snapshot of their internal state for diagnostics
+type StatusReporter interface {
+	GetStatus() interface{}
+}
+
+// MessageTracer is optionally implemented by consenters which can stream the
+// consensus messages they send and receive, decoded, for diagnostics. Events
+// are delivered on the returned channel until cancel is called.
+type MessageTracer interface {
+	TraceMessages(filter *pb.ConsensusTraceRequest) (events <-chan *pb.ConsensusTraceEvent, cancel func())
+}
+
+// MessageReplayer is optionally implemented by consenters which can replay a
+// consensus message as if sender had sent it, to reproduce how they handle it.
+// It returns why the consenter rejects the message, if it does.
+type MessageReplayer interface {
+	ReplayMessage(msg *pb.Message, sender *pb.PeerID) error
+}
+
+// Reconfigurer is optionally implemented by consenters which can change the
+// number of validators of a running network. The change is ordered through
+// consensus once enough validators requested it, and takes effect on all
+// validators at the same point.
+type Reconfigurer interface {
+	Reconfigure(N int, f int) error
+}
+
+// CatchUpper is optionally implemented by consenters which can be asked to
+// catch up with the network through state transfer, fetching the state from
+// peer, or from any peer which attests to it if peer is nil
+type CatchUpper interface {
+	CatchUp(peer *pb.PeerID) error
+}
+
 // Inquirer is used to retrieve info about the validating network
 type Inquirer interface {
 	GetNetworkInfo() (self *pb.PeerEndpoint, network []*pb.PeerEndpoint, err error)
@@ -103,6 +143,52 @@ type StatePersistor interface {
 	DelState(key string)
 }
 
+// BatchClock is implemented by stacks which record the consensus time of the
+// batch being executed
+type BatchClock interface {
+	SetBatchTimestamp(timestamp *google_protobuf.Timestamp) // Called before executing a batch, nil if it has no timestamp
+}
+
+// BatchBeacon is implemented by stacks which record the random value of the
+// batch being executed
+type BatchBeacon interface {
+	SetBatchRandom(random []byte) // Called before executing a batch, nil if it has no random value
+}
+
+// StateDeltaReader is implemented by stacks which keep the state delta of each
+// block, for plugins to relay them to the non validating peers
+type StateDeltaReader interface {
+	GetStateDelta(blockNumber uint64) (*statemgmt.StateDelta, error)
+}
+
+// ValidatorSetListener is implemented by stacks which track the validators
+// the consensus plugin orders with
+type ValidatorSetListener interface {
+	ValidatorsChanged(epoch uint64, validators []*pb.PeerID) // Called when a reconfiguration of the network takes effect
+}
+
+// ReadOnlyExecutor is implemented by stacks which execute a query against
+// their committed state on behalf of another replica, outside of the ordered
+// execution of transactions
+type ReadOnlyExecutor interface {
+	ExecuteReadOnly(tx *pb.Transaction) ([]byte, error) // May be called concurrently with the execution of transactions
+}
+
+// ReadOnlyConsenter is implemented by plugins which answer a query with the
+// result f+1 replicas agree on, without ordering it. Query returns
+// ErrReadOnlyDisabled if the plugin is not configured to
+type ReadOnlyConsenter interface {
+	Query(tx *pb.Transaction) ([]byte, error)
+}
+
+// ErrReadOnlyDisabled is returned by the plugins which do not answer queries
+var ErrReadOnlyDisabled = errors.New("queries are not answered by the consensus plugin")
+
+// ErrBackpressure is returned by the plugins which turn down a transaction
+// because they already hold more requests than they can order for now. The
+// transaction may be submitted again later
+var ErrBackpressure = errors.New("too many transactions waiting to be ordered, retry later")
+
 // Stack is the set of stack-facing methods available to the consensus plugin
 type Stack interface {
 	NetworkStack
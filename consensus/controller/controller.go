@@ -17,32 +17,83 @@ limitations under the License.
 package controller
 
 import (
+	"fmt"
 	"strings"
 
 	"github.com/op/go-logging"
 	"github.com/spf13/viper"
 
 	"github.com/hyperledger/fabric/consensus"
-	"github.com/hyperledger/fabric/consensus/noops"
-	"github.com/hyperledger/fabric/consensus/obcpbft"
+	// the plugins register themselves with consensus.RegisterPlugin
+	_ "github.com/hyperledger/fabric/consensus/noops"
+	_ "github.com/hyperledger/fabric/consensus/obcpbft"
+	_ "github.com/hyperledger/fabric/consensus/raft"
 )
 
 var logger *logging.Logger // package-level logger
-var consenter consensus.Consenter
 
 func init() {
 	logger = logging.MustGetLogger("consensus/controller")
 }
 
-// NewConsenter constructs a Consenter object if not already present
-func NewConsenter(stack consensus.Stack) consensus.Consenter {
+// defaultPlugin is the plugin used when none is configured
+const defaultPlugin = "noops"
 
-	plugin := strings.ToLower(viper.GetString("peer.validator.consensus.plugin"))
-	if plugin == "pbft" {
-		logger.Infof("Creating consensus plugin %s", plugin)
-		return obcpbft.GetPlugin(stack)
+// pluginName returns the name of the configured plugin
+func pluginName() string {
+	name := strings.ToLower(viper.GetString("peer.validator.consensus.plugin"))
+	if name == "" {
+		return defaultPlugin
 	}
-	logger.Info("Creating default consensus plugin (noops)")
-	return noops.GetNoops(stack)
+	return name
+}
+
+// newPlugin creates the configured plugin, uninitialized
+func newPlugin() (name string, plugin consensus.Plugin, err error) {
+	name = pluginName()
+	factory, ok := consensus.GetPluginFactory(name)
+	if !ok {
+		return name, nil, fmt.Errorf("Unknown consensus plugin %q in peer.validator.consensus.plugin, the registered plugins are %v", name, consensus.PluginNames())
+	}
+	// Plugins which cannot make sense of their configuration panic
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("Error creating consensus plugin %s: %v", name, r)
+		}
+	}()
+	return name, factory(), nil
+}
 
+// CheckConsenter returns an error if the plugin named by
+// peer.validator.consensus.plugin is not registered, or if it rejects its
+// configuration. The peer calls it as it starts, so that a misconfigured
+// validator fails right away.
+func CheckConsenter() error {
+	name, plugin, err := newPlugin()
+	if err != nil {
+		return err
+	}
+	if validator, ok := plugin.(consensus.ConfigValidator); ok {
+		if err := validator.ValidateConfig(); err != nil {
+			return fmt.Errorf("Invalid configuration of consensus plugin %s: %s", name, err)
+		}
+	}
+	return nil
+}
+
+// NewConsenter creates, initializes and starts the consensus plugin named by
+// peer.validator.consensus.plugin
+func NewConsenter(stack consensus.Stack) consensus.Plugin {
+	name, plugin, err := newPlugin()
+	if err != nil {
+		logger.Panic(err)
+	}
+	logger.Infof("Creating consensus plugin %s", name)
+	if err := plugin.Init(stack, consensus.NewTimerFactory()); err != nil {
+		logger.Panicf("Error initializing consensus plugin %s: %s", name, err)
+	}
+	if err := plugin.Start(); err != nil {
+		logger.Panicf("Error starting consensus plugin %s: %s", name, err)
+	}
+	return plugin
 }
@@ -0,0 +1,65 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/spf13/viper"
+
+	"github.com/hyperledger/fabric/consensus"
+)
+
+type invalidPlugin struct {
+	consensus.Plugin
+}
+
+func (p *invalidPlugin) ValidateConfig() error {
+	return fmt.Errorf("invalid")
+}
+
+func init() {
+	consensus.RegisterPlugin("invalid", func() consensus.Plugin { return &invalidPlugin{} })
+}
+
+func TestCheckConsenter(t *testing.T) {
+	defer viper.Set("peer.validator.consensus.plugin", "")
+
+	viper.Set("peer.validator.consensus.plugin", "")
+	if err := CheckConsenter(); err != nil {
+		t.Fatalf("Expected noops by default, got %s", err)
+	}
+	if name, _, _ := newPlugin(); name != "noops" {
+		t.Fatalf("Expected noops by default, got %s", name)
+	}
+
+	viper.Set("peer.validator.consensus.plugin", "NoOps")
+	if err := CheckConsenter(); err != nil {
+		t.Fatalf("Expected noops to be selected case insensitively, got %s", err)
+	}
+
+	viper.Set("peer.validator.consensus.plugin", "unknown")
+	if err := CheckConsenter(); err == nil {
+		t.Fatalf("Expected an unknown plugin to be rejected")
+	}
+
+	viper.Set("peer.validator.consensus.plugin", "invalid")
+	if err := CheckConsenter(); err == nil {
+		t.Fatalf("Expected the configuration of the plugin to be rejected")
+	}
+}
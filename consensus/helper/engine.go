@@ -20,13 +20,24 @@ import (
 	"github.com/hyperledger/fabric/consensus"
 	"github.com/hyperledger/fabric/core/peer"
 
+	"errors"
 	"fmt"
 	"sync"
+	"time"
+
+	"google/protobuf"
 
 	"github.com/hyperledger/fabric/consensus/controller"
+	"github.com/hyperledger/fabric/consensus/replay"
 	"github.com/hyperledger/fabric/consensus/util"
 	"github.com/hyperledger/fabric/core/chaincode"
+	"github.com/hyperledger/fabric/core/ledger"
+	"github.com/hyperledger/fabric/core/peer/statetransfer"
+	"github.com/hyperledger/fabric/core/rejections"
+	"github.com/hyperledger/fabric/core/txid"
+	"github.com/hyperledger/fabric/core/watchdog"
 	pb "github.com/hyperledger/fabric/protos"
+	"github.com/spf13/viper"
 	"golang.org/x/net/context"
 )
 
@@ -36,6 +47,7 @@ type EngineImpl struct {
 	helper       *Helper
 	peerEndpoint *pb.PeerEndpoint
 	consensusFan *util.MessageFan
+	recorder     *replay.Recorder
 }
 
 // GetHandlerFactory returns new NewConsensusHandler
@@ -46,25 +58,36 @@ func (eng *EngineImpl) GetHandlerFactory() peer.HandlerFactory {
 // ProcessTransactionMsg processes a Message in context of a Transaction
 func (eng *EngineImpl) ProcessTransactionMsg(msg *pb.Message, tx *pb.Transaction) (response *pb.Response) {
 	//TODO: Do we always verify security, or can we supply a flag on the invoke ot this functions so to bypass check for locally generated transactions?
-	if tx.Type == pb.Transaction_CHAINCODE_QUERY {
-		if !engine.helper.valid {
-			logger.Warning("Rejecting query because state is currently not valid")
-			return &pb.Response{Status: pb.Response_FAILURE,
-				Msg: []byte("Error: state may be inconsistent, cannot query")}
+	if err := chaincode.ValidateTransactionArgs(tx); err != nil {
+		logger.Debugf("Rejecting transaction %s: %s", tx.Uuid, err)
+		if tx.Type != pb.Transaction_CHAINCODE_QUERY {
+			rejections.Record(tx.Uuid, rejections.StageValidation, rejections.ReasonInvalidArguments, err)
 		}
+		return &pb.Response{Status: pb.Response_FAILURE, Msg: []byte(fmt.Sprintf("Error:%s", err))}
+	}
+	if err := chaincode.CheckBreaker(tx); err != nil {
+		logger.Debugf("Rejecting transaction %s: %s", tx.Uuid, err)
+		rejections.Record(tx.Uuid, rejections.StageValidation, rejections.ReasonBreakerOpen, err)
+		return &pb.Response{Status: pb.Response_FAILURE, Msg: []byte(fmt.Sprintf("Error:%s", err))}
+	}
 
+	if err := txid.Check(tx); err != nil {
+		logger.Debugf("Rejecting transaction %s: %s", tx.Uuid, err)
+		reason := rejections.ReasonInvalidID
+		if _, ok := err.(*txid.DuplicateIDError); ok {
+			reason = rejections.ReasonDuplicate
+		}
+		rejections.Record(tx.Uuid, rejections.StageValidation, reason, err)
+		return &pb.Response{Status: pb.Response_FAILURE, Msg: []byte(fmt.Sprintf("Error:%s", err))}
+	}
+
+	if tx.Type == pb.Transaction_CHAINCODE_QUERY {
+		if response = eng.readOnlyQuery(tx); response != nil {
+			return response
+		}
 		// The secHelper is set during creat ChaincodeSupport, so we don't need this step
 		// cxt := context.WithValue(context.Background(), "security", secHelper)
-		cxt := context.Background()
-		//query will ignore events as these are not stored on ledger (and query can report
-		//"event" data synchronously anyway)
-		result, _, err := chaincode.Execute(cxt, chaincode.GetChain(chaincode.DefaultChain), tx)
-		if err != nil {
-			response = &pb.Response{Status: pb.Response_FAILURE,
-				Msg: []byte(fmt.Sprintf("Error:%s", err))}
-		} else {
-			response = &pb.Response{Status: pb.Response_SUCCESS, Msg: result}
-		}
+		response = eng.executeQuery(context.Background(), tx)
 	} else {
 		// Chaincode Transaction
 		response = &pb.Response{Status: pb.Response_SUCCESS, Msg: []byte(tx.Uuid)}
@@ -77,19 +100,132 @@ func (eng *EngineImpl) ProcessTransactionMsg(msg *pb.Message, tx *pb.Transaction
 
 		// Pass the message to the consenter (eg. PBFT) NOTE: Make sure engine has been initialized
 		if eng.consenter == nil {
+			txid.Forget(tx.Uuid)
+			rejections.Record(tx.Uuid, rejections.StageConsensus, rejections.ReasonNotOrdered, errors.New("Engine not initialized"))
 			return &pb.Response{Status: pb.Response_FAILURE, Msg: []byte("Engine not initialized")}
 		}
 		// TODO, do we want to put these requests into a queue? This will block until
 		// the consenter gets around to handling the message, but it also provides some
 		// natural feedback to the REST API to determine how long it takes to queue messages
+		eng.record(msg, eng.peerEndpoint.ID)
 		err := eng.consenter.RecvMsg(msg, eng.peerEndpoint.ID)
 		if err != nil {
+			reason := rejections.ReasonNotOrdered
+			if err == consensus.ErrBackpressure {
+				reason = rejections.ReasonBackpressure
+			}
+			txid.Forget(tx.Uuid)
+			rejections.Record(tx.Uuid, rejections.StageConsensus, reason, err)
 			response = &pb.Response{Status: pb.Response_FAILURE, Msg: []byte(err.Error())}
 		}
 	}
 	return response
 }
 
+// StreamQuery executes a query within ctx, passing its result to sink in the
+// chunks the chaincode emits it, if sink is not nil
+func (eng *EngineImpl) StreamQuery(ctx context.Context, tx *pb.Transaction, sink func(chunk []byte) error) *pb.Response {
+	if tx.Type != pb.Transaction_CHAINCODE_QUERY {
+		return &pb.Response{Status: pb.Response_FAILURE, Msg: []byte(fmt.Sprintf("Error: cannot stream the result of a %s transaction", tx.Type))}
+	}
+	if err := chaincode.ValidateTransactionArgs(tx); err != nil {
+		logger.Debugf("Rejecting transaction %s: %s", tx.Uuid, err)
+		return &pb.Response{Status: pb.Response_FAILURE, Msg: []byte(fmt.Sprintf("Error:%s", err))}
+	}
+	if sink != nil {
+		ctx = chaincode.WithQueryResultSink(ctx, sink)
+	}
+	return eng.executeQuery(ctx, tx)
+}
+
+// Simulate executes an invocation without committing its changes
+func (eng *EngineImpl) Simulate(ctx context.Context, tx *pb.Transaction) (*pb.SimulationResult, error) {
+	if err := chaincode.ValidateTransactionArgs(tx); err != nil {
+		logger.Debugf("Rejecting transaction %s: %s", tx.Uuid, err)
+		return nil, err
+	}
+	if !engine.helper.valid {
+		return nil, fmt.Errorf("State may be inconsistent, cannot simulate")
+	}
+	return chaincode.Simulate(ctx, chaincode.GetChain(chaincode.DefaultChain), tx)
+}
+
+// readOnlyQuery answers a query with the result f+1 validators agree on, if the
+// consenter does, nil if the query should execute locally
+func (eng *EngineImpl) readOnlyQuery(tx *pb.Transaction) *pb.Response {
+	consenter, ok := eng.consenter.(consensus.ReadOnlyConsenter)
+	if !ok {
+		return nil
+	}
+	result, err := consenter.Query(tx)
+	if err == consensus.ErrReadOnlyDisabled {
+		return nil
+	}
+	if err != nil {
+		return &pb.Response{Status: pb.Response_FAILURE, Msg: []byte(fmt.Sprintf("Error:%s", err))}
+	}
+	return &pb.Response{Status: pb.Response_SUCCESS, Msg: result}
+}
+
+func (eng *EngineImpl) executeQuery(cxt context.Context, tx *pb.Transaction) *pb.Response {
+	if !engine.helper.valid {
+		logger.Warning("Rejecting query because state is currently not valid")
+		return &pb.Response{Status: pb.Response_FAILURE,
+			Msg: []byte("Error: state may be inconsistent, cannot query")}
+	}
+
+	// the answer is attested with the position of the chain before the query,
+	// as the committed state it reads can only be as recent or more
+	height, stateHash, err := queryPosition()
+	if err != nil {
+		return &pb.Response{Status: pb.Response_FAILURE,
+			Msg: []byte(fmt.Sprintf("Error:%s", err))}
+	}
+	if height < tx.MinHeight {
+		logger.Debugf("Rejecting query %s requiring block height %d at height %d", tx.Uuid, tx.MinHeight, height)
+		return &pb.Response{Status: pb.Response_FAILURE, Height: height, StateHash: stateHash,
+			Msg: []byte(fmt.Sprintf("Error: peer is at block height %d, behind the required height %d", height, tx.MinHeight))}
+	}
+
+	//query will ignore events as these are not stored on ledger (and query can report
+	//"event" data synchronously anyway)
+	result, _, err := chaincode.Execute(cxt, chaincode.GetChain(chaincode.DefaultChain), tx)
+	if err != nil {
+		return &pb.Response{Status: pb.Response_FAILURE,
+			Msg: []byte(fmt.Sprintf("Error:%s", err))}
+	}
+	return &pb.Response{Status: pb.Response_SUCCESS, Msg: result, Height: height, StateHash: stateHash}
+}
+
+// queryPosition returns the height of the chain and the state hash of its
+// last block
+func queryPosition() (uint64, []byte, error) {
+	ledger, err := ledger.GetLedger()
+	if err != nil {
+		return 0, nil, err
+	}
+	height := ledger.GetBlockchainSize()
+	if height == 0 {
+		return 0, nil, nil
+	}
+	block, err := ledger.GetBlockByNumber(height - 1)
+	if err != nil {
+		return 0, nil, err
+	}
+	return height, block.StateHash, nil
+}
+
+// record appends a message delivered to the consenter to the message trace,
+// if recording is enabled
+func (eng *EngineImpl) record(msg *pb.Message, sender *pb.PeerID) {
+	if eng.recorder == nil {
+		return
+	}
+	if err := eng.recorder.Record(msg, sender); err != nil {
+		logger.Warningf("Error recording message from %v: %s", sender, err)
+	}
+}
+
 func (eng *EngineImpl) setConsenter(consenter consensus.Consenter) *EngineImpl {
 	eng.consenter = consenter
 	return eng
@@ -118,15 +254,127 @@ func GetEngine(coord peer.MessageHandlerCoordinator) (peer.Engine, error) {
 		engine.helper.setConsenter(engine.consenter)
 		engine.peerEndpoint, err = coord.GetPeerEndpoint()
 		engine.consensusFan = util.NewMessageFan()
+		watchdog.RegisterQueue("consensus", engine.consensusFan.Depth)
+		if path := viper.GetString("peer.validator.consensus.record"); path != "" {
+			recorder, recErr := replay.OpenRecorder(path)
+			if recErr != nil {
+				logger.Errorf("Not recording the messages delivered to the consenter: %s", recErr)
+			} else {
+				logger.Infof("Recording the messages delivered to the consenter to %s", path)
+				engine.recorder = recorder
+			}
+		}
 
 		go func() {
 			logger.Debug("Starting up message thread for consenter")
 
 			// The channel never closes, so this should never break
 			for msg := range engine.consensusFan.GetOutChannel() {
+				engine.record(msg.Msg, msg.Sender)
 				engine.consenter.RecvMsg(msg.Msg, msg.Sender)
 			}
 		}()
 	})
 	return engine, err
 }
+
+// GetConsensusStatus returns a snapshot of the internal state of the consenter,
+// provided the consenter supports reporting it
+func GetConsensusStatus() (interface{}, error) {
+	eng := getEngineImpl()
+	if eng == nil || eng.consenter == nil {
+		return nil, fmt.Errorf("Consensus engine is not running")
+	}
+	reporter, ok := eng.consenter.(consensus.StatusReporter)
+	if !ok {
+		return nil, fmt.Errorf("Consenter %T does not report its status", eng.consenter)
+	}
+	return reporter.GetStatus(), nil
+}
+
+// ReplayConsensus hands a consensus message to the consenter as if the peer
+// named sender had sent it, provided the consenter supports replaying messages
+func ReplayConsensus(payload []byte, sender string) error {
+	eng := getEngineImpl()
+	if eng == nil || eng.consenter == nil {
+		return fmt.Errorf("Consensus engine is not running")
+	}
+	replayer, ok := eng.consenter.(consensus.MessageReplayer)
+	if !ok {
+		return fmt.Errorf("Consenter %T does not replay messages", eng.consenter)
+	}
+	msg := &pb.Message{Type: pb.Message_CONSENSUS, Payload: payload}
+	return replayer.ReplayMessage(msg, &pb.PeerID{Name: sender})
+}
+
+// ReconfigureConsensus requests the reconfiguration of the validating network
+// to N validators tolerating f faults, provided the consenter supports it
+func ReconfigureConsensus(N int, f int) error {
+	eng := getEngineImpl()
+	if eng == nil || eng.consenter == nil {
+		return fmt.Errorf("Consensus engine is not running")
+	}
+	reconfigurer, ok := eng.consenter.(consensus.Reconfigurer)
+	if !ok {
+		return fmt.Errorf("Consenter %T does not support reconfiguration", eng.consenter)
+	}
+	return reconfigurer.Reconfigure(N, f)
+}
+
+// TraceConsensus streams the consensus messages of the consenter matching
+// filter, provided the consenter supports tracing them
+func TraceConsensus(filter *pb.ConsensusTraceRequest) (<-chan *pb.ConsensusTraceEvent, func(), error) {
+	eng := getEngineImpl()
+	if eng == nil || eng.consenter == nil {
+		return nil, nil, fmt.Errorf("Consensus engine is not running")
+	}
+	tracer, ok := eng.consenter.(consensus.MessageTracer)
+	if !ok {
+		return nil, nil, fmt.Errorf("Consenter %T does not trace its messages", eng.consenter)
+	}
+	events, cancel := tracer.TraceMessages(filter)
+	return events, cancel, nil
+}
+
+// CatchUpConsensus asks the consenter to catch up with the network through
+// state transfer from the named peer, or from any peer if peer is empty,
+// provided the consenter supports it
+func CatchUpConsensus(peer string) error {
+	eng := getEngineImpl()
+	if eng == nil || eng.consenter == nil {
+		return fmt.Errorf("Consensus engine is not running")
+	}
+	catchUpper, ok := eng.consenter.(consensus.CatchUpper)
+	if !ok {
+		return fmt.Errorf("Consenter %T does not catch up on request", eng.consenter)
+	}
+	var peerID *pb.PeerID
+	if peer != "" {
+		peerID = &pb.PeerID{Name: peer}
+	}
+	return catchUpper.CatchUp(peerID)
+}
+
+// StateTransferProgress returns the progress of the latest state transfer of
+// the peer, nil if it never transferred state
+func StateTransferProgress() *pb.StateTransferProgress {
+	p := statetransfer.GetProgress()
+	if p.Started.IsZero() {
+		return nil
+	}
+	elapsed := p.Updated.Sub(p.Started)
+	if p.InProgress {
+		elapsed = time.Since(p.Started)
+	}
+	return &pb.StateTransferProgress{
+		InProgress:    p.InProgress,
+		StartBlock:    p.StartBlock,
+		TargetBlock:   p.TargetBlock,
+		BlocksFetched: p.BlocksFetched,
+		BytesFetched:  p.BytesFetched,
+		Started:       &google_protobuf.Timestamp{Seconds: p.Started.Unix(), Nanos: int32(p.Started.Nanosecond())},
+		ElapsedMs:     uint64(elapsed / time.Millisecond),
+		EtaMs:         uint64(p.ETA() / time.Millisecond),
+		Error:         p.Err,
+	}
+}
@@ -22,6 +22,7 @@ import (
 	"github.com/golang/protobuf/proto"
 	"github.com/spf13/viper"
 	"golang.org/x/net/context"
+	google_protobuf "google/protobuf"
 
 	"github.com/hyperledger/fabric/consensus"
 	"github.com/hyperledger/fabric/consensus/executor"
@@ -29,7 +30,10 @@ import (
 	"github.com/hyperledger/fabric/core/chaincode"
 	crypto "github.com/hyperledger/fabric/core/crypto"
 	"github.com/hyperledger/fabric/core/ledger"
+	"github.com/hyperledger/fabric/core/ledger/statemgmt"
 	"github.com/hyperledger/fabric/core/peer"
+	"github.com/hyperledger/fabric/core/rejections"
+	"github.com/hyperledger/fabric/core/system_chaincode/scheduler"
 	pb "github.com/hyperledger/fabric/protos"
 )
 
@@ -40,8 +44,10 @@ type Helper struct {
 	secOn        bool
 	valid        bool // Whether we believe the state is up to date
 	secHelper    crypto.Peer
-	curBatch     []*pb.Transaction       // TODO, remove after issue 579
-	curBatchErrs []*pb.TransactionResult // TODO, remove after issue 579
+	curBatch     []*pb.Transaction          // TODO, remove after issue 579
+	curBatchErrs []*pb.TransactionResult    // TODO, remove after issue 579
+	curBatchTime *google_protobuf.Timestamp // consensus time of the batch, nil if the consenter does not stamp batches
+	curBatchRand []byte                     // random value of the batch, nil if the consenter gives it none
 	persist.Helper
 
 	executor consensus.Executor
@@ -180,6 +186,21 @@ func (h *Helper) ExecTxs(id interface{}, txs []*pb.Transaction) ([]byte, error)
 	// cxt := context.WithValue(context.Background(), "security", h.coordinator.GetSecHelper())
 	// TODO return directly once underlying implementation no longer returns []error
 
+	if ledger, err := ledger.GetLedger(); err != nil {
+		return nil, fmt.Errorf("Failed to get the ledger: %v", err)
+	} else if err := ledger.SetTxBatchTimestamp(id, h.curBatchTime); err != nil {
+		return nil, fmt.Errorf("Failed to set the time of the transaction batch: %v", err)
+	} else if err := ledger.SetTxBatchRandom(id, h.curBatchRand); err != nil {
+		return nil, fmt.Errorf("Failed to set the random value of the transaction batch: %v", err)
+	} else if len(h.curBatch) == 0 && scheduler.Enabled() {
+		// The scheduled transactions due in this block run ahead of the batch
+		due, err := scheduler.DueTransactions(ledger, h.curBatchTime)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to get the scheduled transactions: %v", err)
+		}
+		txs = append(due, txs...)
+	}
+
 	res, ccevents, txerrs, err := chaincode.ExecuteTransactions(context.Background(), chaincode.DefaultChain, txs)
 	h.curBatch = append(h.curBatch, txs...) // TODO, remove after issue 579
 
@@ -190,6 +211,11 @@ func (h *Helper) ExecTxs(id interface{}, txs []*pb.Transaction) ([]byte, error)
 	for i, e := range txerrs {
 		//NOTE- it'll be nice if we can have error values. For now success == 0, error == 1
 		if txerrs[i] != nil {
+			reason := rejections.ReasonExecutionFailed
+			if _, ok := e.(*chaincode.DuplicateTransactionError); ok {
+				reason = rejections.ReasonDuplicate
+			}
+			rejections.Record(txs[i].Uuid, rejections.StageExecution, reason, e)
 			txresults[i] = &pb.TransactionResult{Uuid: txs[i].Uuid, Error: e.Error(), ErrorCode: 1, ChaincodeEvent: ccevents[i]}
 		} else {
 			txresults[i] = &pb.TransactionResult{Uuid: txs[i].Uuid, ChaincodeEvent: ccevents[i]}
@@ -273,6 +299,15 @@ func (h *Helper) GetBlock(blockNumber uint64) (block *pb.Block, err error) {
 	return ledger.GetBlockByNumber(blockNumber)
 }
 
+// GetStateDelta returns the state delta of a block of the chain
+func (h *Helper) GetStateDelta(blockNumber uint64) (*statemgmt.StateDelta, error) {
+	ledger, err := ledger.GetLedger()
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get the ledger :%v", err)
+	}
+	return ledger.GetStateDelta(blockNumber)
+}
+
 // GetCurrentStateHash returns the current/temporary state hash
 func (h *Helper) GetCurrentStateHash() (stateHash []byte, err error) {
 	ledger, err := ledger.GetLedger()
@@ -328,6 +363,41 @@ func (h *Helper) ValidateState() {
 	h.valid = true
 }
 
+// ExecuteReadOnly executes a query for another replica against the committed state
+func (h *Helper) ExecuteReadOnly(tx *pb.Transaction) ([]byte, error) {
+	if tx.Type != pb.Transaction_CHAINCODE_QUERY {
+		return nil, fmt.Errorf("Cannot execute a %s transaction read-only", tx.Type)
+	}
+	if !h.valid {
+		return nil, fmt.Errorf("State may be inconsistent, cannot query")
+	}
+	if err := chaincode.ValidateTransactionArgs(tx); err != nil {
+		return nil, err
+	}
+	result, _, err := chaincode.Execute(context.Background(), chaincode.GetChain(chaincode.DefaultChain), tx)
+	return result, err
+}
+
+// SetBatchTimestamp sets the consensus time of the batch executed next
+func (h *Helper) SetBatchTimestamp(timestamp *google_protobuf.Timestamp) {
+	h.curBatchTime = timestamp
+}
+
+// SetBatchRandom sets the random value of the batch executed next
+func (h *Helper) SetBatchRandom(random []byte) {
+	h.curBatchRand = random
+}
+
+// ValidatorsChanged is called by the consenter when a reconfiguration of the
+// validating network takes effect
+func (h *Helper) ValidatorsChanged(epoch uint64, validators []*pb.PeerID) {
+	names := make([]string, len(validators))
+	for i, v := range validators {
+		names[i] = v.Name
+	}
+	logger.Infof("Validating network reconfigured in epoch %d, validators are %v", epoch, names)
+}
+
 // Execute will execute a set of transactions, this may be called in succession
 func (h *Helper) Execute(tag interface{}, txs []*pb.Transaction) {
 	h.executor.Execute(tag, txs)
@@ -0,0 +1,100 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package consensus
+
+import (
+	"sync"
+
+	"github.com/op/go-logging"
+
+	pb "github.com/hyperledger/fabric/protos"
+)
+
+var logger = logging.MustGetLogger("consensus")
+
+// Consensus plugins publish the progress of their replica, such as the
+// requests they commit and the checkpoints which become stable, as lifecycle
+// events, for the event hub and monitors to surface without parsing the logs.
+// Each subscriber receives the events in the order they were published, from
+// a goroutine of its own. A subscriber which falls behind by more than
+// lifecycleBuffer events misses the events which follow, rather than holding
+// up the plugin.
+
+// lifecycleBuffer is the number of events queued for a subscriber before
+// further events are dropped
+const lifecycleBuffer = 1000
+
+// LifecycleSubscriber receives the lifecycle events of the consensus plugin
+type LifecycleSubscriber interface {
+	ConsensusEvent(ev *pb.ConsensusEvent)
+}
+
+type lifecycleSubscription struct {
+	subscriber LifecycleSubscriber
+	events     chan *pb.ConsensusEvent
+	dropped    uint64
+}
+
+var lifecycle = struct {
+	sync.Mutex
+	subscriptions map[*lifecycleSubscription]struct{}
+}{subscriptions: make(map[*lifecycleSubscription]struct{})}
+
+// SubscribeLifecycle passes the lifecycle events published from now on to
+// subscriber, until the returned function is called
+func SubscribeLifecycle(subscriber LifecycleSubscriber) (unsubscribe func()) {
+	s := &lifecycleSubscription{
+		subscriber: subscriber,
+		events:     make(chan *pb.ConsensusEvent, lifecycleBuffer),
+	}
+	lifecycle.Lock()
+	lifecycle.subscriptions[s] = struct{}{}
+	lifecycle.Unlock()
+
+	go func() {
+		for ev := range s.events {
+			s.subscriber.ConsensusEvent(ev)
+		}
+	}()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			lifecycle.Lock()
+			delete(lifecycle.subscriptions, s)
+			lifecycle.Unlock()
+			close(s.events)
+		})
+	}
+}
+
+// PublishLifecycle passes ev to the lifecycle subscribers. It does not block.
+func PublishLifecycle(ev *pb.ConsensusEvent) {
+	lifecycle.Lock()
+	defer lifecycle.Unlock()
+	for s := range lifecycle.subscriptions {
+		select {
+		case s.events <- ev:
+			if s.dropped > 0 {
+				logger.Warningf("Lifecycle subscriber %T missed %d consensus events", s.subscriber, s.dropped)
+				s.dropped = 0
+			}
+		default:
+			s.dropped++
+		}
+	}
+}
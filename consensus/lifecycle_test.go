@@ -0,0 +1,58 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package consensus
+
+import (
+	"testing"
+	"time"
+
+	pb "github.com/hyperledger/fabric/protos"
+)
+
+type chanSubscriber chan *pb.ConsensusEvent
+
+func (s chanSubscriber) ConsensusEvent(ev *pb.ConsensusEvent) {
+	s <- ev
+}
+
+func TestLifecycle(t *testing.T) {
+	events := make(chanSubscriber, 10)
+	unsubscribe := SubscribeLifecycle(events)
+
+	for n := uint64(1); n <= 3; n++ {
+		PublishLifecycle(&pb.ConsensusEvent{Type: pb.ConsensusEvent_COMMITTED, SeqNo: n})
+	}
+	for n := uint64(1); n <= 3; n++ {
+		select {
+		case ev := <-events:
+			if ev.SeqNo != n {
+				t.Fatalf("Expected the events in order, got seqNo %d instead of %d", ev.SeqNo, n)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("Expected the event of seqNo %d", n)
+		}
+	}
+
+	unsubscribe()
+	unsubscribe()
+	PublishLifecycle(&pb.ConsensusEvent{Type: pb.ConsensusEvent_COMMITTED, SeqNo: 4})
+	select {
+	case ev := <-events:
+		t.Fatalf("Expected no event once unsubscribed, got %v", ev)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
@@ -0,0 +1,105 @@
+// Code generated by protoc-gen-go.
+// source: noops/messages.proto
+// DO NOT EDIT!
+
+/*
+Package noops is a generated protocol buffer package.
+
+It is generated from these files:
+
+	noops/messages.proto
+
+It has these top-level messages:
+
+	Message
+	Batch
+	Metadata
+*/
+package noops
+
+import proto "github.com/golang/protobuf/proto"
+import fmt "fmt"
+import math "math"
+import google_protobuf "google/protobuf"
+
+// Reference imports to suppress errors if they are not otherwise used.
+var _ = proto.Marshal
+var _ = fmt.Errorf
+var _ = math.Inf
+
+type Message_Type int32
+
+const (
+	Message_UNDEFINED Message_Type = 0
+	// a validator forwards a transaction to the orderer
+	Message_TRANSACTION Message_Type = 1
+	// the orderer broadcasts a batch of transactions
+	Message_BATCH Message_Type = 2
+)
+
+var Message_Type_name = map[int32]string{
+	0: "UNDEFINED",
+	1: "TRANSACTION",
+	2: "BATCH",
+}
+var Message_Type_value = map[string]int32{
+	"UNDEFINED":   0,
+	"TRANSACTION": 1,
+	"BATCH":       2,
+}
+
+func (x Message_Type) String() string {
+	return proto.EnumName(Message_Type_name, int32(x))
+}
+
+// Message is a message of the NOOPS plugin between validators
+type Message struct {
+	Type Message_Type `protobuf:"varint,1,opt,name=type,enum=noops.Message_Type" json:"type,omitempty"`
+	// marshaled protos.Transaction, for TRANSACTION
+	Transaction []byte `protobuf:"bytes,2,opt,name=transaction,proto3" json:"transaction,omitempty"`
+	Batch       *Batch `protobuf:"bytes,3,opt,name=batch" json:"batch,omitempty"`
+}
+
+func (m *Message) Reset()         { *m = Message{} }
+func (m *Message) String() string { return proto.CompactTextString(m) }
+func (*Message) ProtoMessage()    {}
+
+func (m *Message) GetBatch() *Batch {
+	if m != nil {
+		return m.Batch
+	}
+	return nil
+}
+
+// Batch is a batch of transactions the orderer numbered, every validator
+// executes the batches in the order of their numbers
+type Batch struct {
+	SeqNo     uint64                     `protobuf:"varint,1,opt,name=seqNo" json:"seqNo,omitempty"`
+	Timestamp *google_protobuf.Timestamp `protobuf:"bytes,2,opt,name=timestamp" json:"timestamp,omitempty"`
+	// marshaled protos.Transaction
+	Transactions [][]byte `protobuf:"bytes,3,rep,name=transactions,proto3" json:"transactions,omitempty"`
+}
+
+func (m *Batch) Reset()         { *m = Batch{} }
+func (m *Batch) String() string { return proto.CompactTextString(m) }
+func (*Batch) ProtoMessage()    {}
+
+func (m *Batch) GetTimestamp() *google_protobuf.Timestamp {
+	if m != nil {
+		return m.Timestamp
+	}
+	return nil
+}
+
+// Metadata is the consensus metadata of the blocks committed by NOOPS
+type Metadata struct {
+	SeqNo uint64 `protobuf:"varint,1,opt,name=seqNo" json:"seqNo,omitempty"`
+}
+
+func (m *Metadata) Reset()         { *m = Metadata{} }
+func (m *Metadata) String() string { return proto.CompactTextString(m) }
+func (*Metadata) ProtoMessage()    {}
+
+func init() {
+	proto.RegisterEnum("noops.Message_Type", Message_Type_name, Message_Type_value)
+}
@@ -25,7 +25,6 @@ import (
 	"github.com/op/go-logging"
 
 	"github.com/hyperledger/fabric/consensus"
-	"github.com/hyperledger/fabric/core/ledger"
 	"github.com/hyperledger/fabric/core/ledger/statemgmt"
 	"github.com/hyperledger/fabric/core/util"
 	pb "github.com/hyperledger/fabric/protos"
@@ -35,58 +34,118 @@ var logger *logging.Logger // package-level logger
 
 func init() {
 	logger = logging.MustGetLogger("consensus/noops")
+	consensus.RegisterPlugin("noops", func() consensus.Plugin { return &Noops{} })
 }
 
-// Noops is a plugin object implementing the consensus.Consenter interface.
+// Noops is a plugin object implementing the consensus.Plugin interface.
+//
+// One validator, the orderer, batches the transactions. The other validators
+// forward it the transactions they receive, and the orderer broadcasts each
+// batch, numbered, so that every validator executes the same batches in the
+// same order. The orderer is trusted and its failure stops the network:
+// NOOPS is meant for development, not for production.
 type Noops struct {
 	stack    consensus.Stack
 	txQ      *txq
-	timer    *time.Timer
+	timer    consensus.Timer
+	timeouts chan struct{}
+	stop     chan struct{}
 	duration time.Duration
 	channel  chan *pb.Transaction
-}
+	batches  chan *Batch
 
-// Setting up a singleton NOOPS consenter
-var iNoops consensus.Consenter
+	orderer *pb.PeerID // nil if this validator orders
+	seqNo   uint64     // number of the next batch to order or to execute
+	held    map[uint64]*Batch
+}
 
-// GetNoops returns a singleton of NOOPS
-func GetNoops(c consensus.Stack) consensus.Consenter {
-	if iNoops == nil {
-		iNoops = newNoops(c)
+// readConfig reads the block size and timeout of the configuration of NOOPS
+func readConfig() (blockSize int, duration time.Duration, orderer string, err error) {
+	config := loadConfig()
+	blockSize = config.GetInt("block.size")
+	if blockSize < 1 {
+		return 0, 0, "", fmt.Errorf("Block size must be at least 1, got %d", blockSize)
 	}
-	return iNoops
+	blockTimeout := config.GetString("block.timeout")
+	if _, err = strconv.Atoi(blockTimeout); err == nil {
+		blockTimeout = blockTimeout + "s" //if string does not have unit of measure, default to seconds
+	}
+	duration, err = time.ParseDuration(blockTimeout)
+	if err != nil || duration == 0 {
+		return 0, 0, "", fmt.Errorf("Cannot parse block timeout: %s", err)
+	}
+	return blockSize, duration, config.GetString("orderer"), nil
 }
 
-// newNoops is a constructor returning a consensus.Consenter object.
-func newNoops(c consensus.Stack) consensus.Consenter {
-	var err error
+// ValidateConfig checks the configuration of NOOPS
+func (i *Noops) ValidateConfig() error {
+	_, _, _, err := readConfig()
+	return err
+}
+
+// Init reads the configuration of NOOPS
+func (i *Noops) Init(c consensus.Stack, timers consensus.TimerFactory) error {
 	if logger.IsEnabledFor(logging.DEBUG) {
 		logger.Debug("Creating a NOOPS object")
 	}
-	i := &Noops{}
 	i.stack = c
-	config := loadConfig()
-	blockSize := config.GetInt("block.size")
-	blockTimeout := config.GetString("block.timeout")
-	if _, err = strconv.Atoi(blockTimeout); err == nil {
-		blockTimeout = blockTimeout + "s" //if string does not have unit of measure, default to seconds
+	blockSize, duration, orderer, err := readConfig()
+	if err != nil {
+		return err
 	}
-	i.duration, err = time.ParseDuration(blockTimeout)
-	if err != nil || i.duration == 0 {
-		panic(fmt.Errorf("Cannot parse block timeout: %s", err))
+	i.duration = duration
+
+	self, _, err := c.GetNetworkHandles()
+	if err != nil {
+		return err
+	}
+	if orderer != "" && orderer != self.Name {
+		i.orderer = &pb.PeerID{Name: orderer}
+	}
+	i.seqNo = 1
+	if raw, err := c.GetBlockHeadMetadata(); err == nil && len(raw) > 0 {
+		meta := &Metadata{}
+		if err := proto.Unmarshal(raw, meta); err != nil {
+			return fmt.Errorf("Cannot unmarshal the metadata of the head block: %s", err)
+		}
+		i.seqNo = meta.SeqNo + 1
 	}
 
 	logger.Infof("NOOPS consensus type = %T", i)
 	logger.Infof("NOOPS block size = %v", blockSize)
 	logger.Infof("NOOPS block timeout = %v", i.duration)
+	if i.orderer == nil {
+		logger.Infof("NOOPS orders the transactions, from batch %d", i.seqNo)
+	} else {
+		logger.Infof("NOOPS orderer = %s, next batch %d", i.orderer.Name, i.seqNo)
+	}
 
 	i.txQ = newTXQ(blockSize)
 
 	i.channel = make(chan *pb.Transaction, 100)
-	i.timer = time.NewTimer(i.duration) // start timer now so we can just reset it
-	i.timer.Stop()
+	i.batches = make(chan *Batch, 100)
+	i.held = make(map[uint64]*Batch)
+	i.timeouts = make(chan struct{})
+	i.stop = make(chan struct{})
+	i.timer = timers.NewTimer(func() {
+		select {
+		case i.timeouts <- struct{}{}:
+		case <-i.stop:
+		}
+	})
+	return nil
+}
+
+// Start starts processing the transactions received
+func (i *Noops) Start() error {
 	go i.handleChannels()
-	return i
+	return nil
+}
+
+// Stop stops processing the transactions received
+func (i *Noops) Stop() {
+	i.timer.Stop()
+	close(i.stop)
 }
 
 // RecvMsg is called for Message_CHAIN_TRANSACTION and Message_CONSENSUS messages.
@@ -95,43 +154,59 @@ func (i *Noops) RecvMsg(msg *pb.Message, senderHandle *pb.PeerID) error {
 		logger.Debugf("Handling Message of type: %s ", msg.Type)
 	}
 	if msg.Type == pb.Message_CHAIN_TRANSACTION {
-		if err := i.broadcastConsensusMsg(msg); nil != err {
-			return err
+		tx := &pb.Transaction{}
+		if err := proto.Unmarshal(msg.Payload, tx); err != nil {
+			return fmt.Errorf("Error unmarshalling payload of received Message:%s.", msg.Type)
 		}
-	}
-	if msg.Type == pb.Message_CONSENSUS {
-		tx, err := i.getTxFromMsg(msg)
-		if nil != err {
-			return err
+		if i.orderer != nil {
+			return i.forward(msg.Payload)
 		}
 		if logger.IsEnabledFor(logging.DEBUG) {
 			logger.Debugf("Sending to channel tx uuid: %s", tx.Uuid)
 		}
 		i.channel <- tx
 	}
+	if msg.Type == pb.Message_CONSENSUS {
+		nmsg := &Message{}
+		if err := proto.Unmarshal(msg.Payload, nmsg); err != nil {
+			return fmt.Errorf("Error unmarshalling payload of received Message:%s.", msg.Type)
+		}
+		switch nmsg.Type {
+		case Message_TRANSACTION:
+			if i.orderer != nil {
+				return fmt.Errorf("Transaction forwarded by %s to a validator which does not order", senderHandle.Name)
+			}
+			tx := &pb.Transaction{}
+			if err := proto.Unmarshal(nmsg.Transaction, tx); err != nil {
+				return fmt.Errorf("Error unmarshalling transaction forwarded by %s: %s", senderHandle.Name, err)
+			}
+			i.channel <- tx
+		case Message_BATCH:
+			if i.orderer == nil || senderHandle.Name != i.orderer.Name {
+				return fmt.Errorf("Batch from %s, which is not the orderer", senderHandle.Name)
+			}
+			if nmsg.Batch == nil {
+				return fmt.Errorf("Empty batch from %s", senderHandle.Name)
+			}
+			i.batches <- nmsg.Batch
+		default:
+			return fmt.Errorf("Unknown NOOPS message of type %s from %s", nmsg.Type, senderHandle.Name)
+		}
+	}
 	return nil
 }
 
-func (i *Noops) broadcastConsensusMsg(msg *pb.Message) error {
-	t := &pb.Transaction{}
-	if err := proto.Unmarshal(msg.Payload, t); err != nil {
-		return fmt.Errorf("Error unmarshalling payload of received Message:%s.", msg.Type)
-	}
-
-	// Change the msg type to consensus and broadcast to the network so that
-	// other validators may execute the transaction
-	msg.Type = pb.Message_CONSENSUS
-	if logger.IsEnabledFor(logging.DEBUG) {
-		logger.Debugf("Broadcasting %s", msg.Type)
-	}
-	txs := &pb.TransactionBlock{Transactions: []*pb.Transaction{t}}
-	payload, err := proto.Marshal(txs)
+// forward hands a transaction to the orderer
+func (i *Noops) forward(tx []byte) error {
+	payload, err := proto.Marshal(&Message{Type: Message_TRANSACTION, Transaction: tx})
 	if err != nil {
 		return err
 	}
-	msg.Payload = payload
-	if errs := i.stack.Broadcast(msg, pb.PeerEndpoint_VALIDATOR); nil != errs {
-		return fmt.Errorf("Failed to broadcast with errors: %v", errs)
+	if logger.IsEnabledFor(logging.DEBUG) {
+		logger.Debugf("Forwarding transaction to orderer %s", i.orderer.Name)
+	}
+	if err := i.stack.Unicast(&pb.Message{Type: pb.Message_CONSENSUS, Payload: payload}, i.orderer); err != nil {
+		return fmt.Errorf("Failed to forward the transaction to orderer %s: %v", i.orderer.Name, err)
 	}
 	return nil
 }
@@ -153,10 +228,10 @@ func (i *Noops) canProcessBlock(tx *pb.Transaction) bool {
 }
 
 func (i *Noops) handleChannels() {
-	// Noops is a singleton object and only exits when peer exits, so we
-	// don't need a condition to exit this loop
 	for {
 		select {
+		case <-i.stop:
+			return
 		case tx := <-i.channel:
 			if i.canProcessBlock(tx) {
 				if logger.IsEnabledFor(logging.DEBUG) {
@@ -166,17 +241,21 @@ func (i *Noops) handleChannels() {
 					logger.Error(err.Error())
 				}
 			}
-		case <-i.timer.C:
+		case <-i.timeouts:
 			if logger.IsEnabledFor(logging.DEBUG) {
 				logger.Debug("Process block due to time")
 			}
 			if err := i.processBlock(); nil != err {
 				logger.Error(err.Error())
 			}
+		case batch := <-i.batches:
+			i.recvBatch(batch)
 		}
 	}
 }
 
+// processBlock has the orderer number the queued transactions as a batch,
+// broadcast it to the other validators and execute it
 func (i *Noops) processBlock() error {
 	i.timer.Stop()
 
@@ -186,22 +265,99 @@ func (i *Noops) processBlock() error {
 		}
 		return nil
 	}
+
+	batch := &Batch{SeqNo: i.seqNo, Timestamp: util.CreateUtcTimestamp()}
+	for _, tx := range i.txQ.getTXs() {
+		raw, err := proto.Marshal(tx)
+		if err != nil {
+			return fmt.Errorf("Fail to marshal transaction %s: %v", tx.Uuid, err)
+		}
+		batch.Transactions = append(batch.Transactions, raw)
+	}
+	i.seqNo++
+	if err := i.broadcastBatch(batch); err != nil {
+		// The validators which missed the batch hold the next ones, as
+		// they cannot execute them in order
+		logger.Errorf("Batch %d did not reach every validator: %v", batch.SeqNo, err)
+	}
+	return i.executeBatch(batch)
+}
+
+func (i *Noops) broadcastBatch(batch *Batch) error {
+	payload, err := proto.Marshal(&Message{Type: Message_BATCH, Batch: batch})
+	if err != nil {
+		return err
+	}
+	if logger.IsEnabledFor(logging.DEBUG) {
+		logger.Debugf("Broadcasting batch %d of %d transactions", batch.SeqNo, len(batch.Transactions))
+	}
+	msg := &pb.Message{Type: pb.Message_CONSENSUS, Payload: payload}
+	if errs := i.stack.Broadcast(msg, pb.PeerEndpoint_VALIDATOR); nil != errs {
+		return fmt.Errorf("Failed to broadcast with errors: %v", errs)
+	}
+	return nil
+}
+
+// recvBatch executes the batches of the orderer in the order of their
+// numbers, holding those received ahead of a missing one
+func (i *Noops) recvBatch(batch *Batch) {
+	if batch.SeqNo < i.seqNo {
+		logger.Debugf("Dropping batch %d, already executed", batch.SeqNo)
+		return
+	}
+	i.held[batch.SeqNo] = batch
+	if batch.SeqNo > i.seqNo {
+		logger.Warningf("Holding batch %d until batch %d is received", batch.SeqNo, i.seqNo)
+		return
+	}
+	for {
+		next, ok := i.held[i.seqNo]
+		if !ok {
+			return
+		}
+		delete(i.held, i.seqNo)
+		i.seqNo++
+		if err := i.executeBatch(next); err != nil {
+			logger.Error(err.Error())
+		}
+	}
+}
+
+// executeBatch executes and commits a batch as a block, whose metadata
+// records the number of the batch
+func (i *Noops) executeBatch(batch *Batch) error {
+	var txs []*pb.Transaction
+	for _, raw := range batch.Transactions {
+		tx := &pb.Transaction{}
+		if err := proto.Unmarshal(raw, tx); err != nil {
+			return fmt.Errorf("Fail to unmarshal a transaction of batch %d: %v", batch.SeqNo, err)
+		}
+		txs = append(txs, tx)
+	}
+	if clock, ok := i.stack.(consensus.BatchClock); ok {
+		clock.SetBatchTimestamp(batch.Timestamp)
+	}
+	meta, err := proto.Marshal(&Metadata{SeqNo: batch.SeqNo})
+	if err != nil {
+		return err
+	}
+
 	var data *pb.Block
 	var delta *statemgmt.StateDelta
-	var err error
 
-	if err = i.processTransactions(); nil != err {
+	if err = i.processTransactions(batch.Timestamp, txs, meta); nil != err {
 		return err
 	}
 	if data, delta, err = i.getBlockData(); nil != err {
 		return err
 	}
-	go i.notifyBlockAdded(data, delta)
+	if data != nil {
+		go i.notifyBlockAdded(data, delta)
+	}
 	return nil
 }
 
-func (i *Noops) processTransactions() error {
-	timestamp := util.CreateUtcTimestamp()
+func (i *Noops) processTransactions(timestamp interface{}, txarr []*pb.Transaction, meta []byte) error {
 	if logger.IsEnabledFor(logging.DEBUG) {
 		logger.Debugf("Starting TX batch with timestamp: %v", timestamp)
 	}
@@ -209,8 +365,7 @@ func (i *Noops) processTransactions() error {
 		return err
 	}
 
-	// Grab all transactions from the FIFO queue and run them in order
-	txarr := i.txQ.getTXs()
+	// Run the transactions of the batch in order
 	if logger.IsEnabledFor(logging.DEBUG) {
 		logger.Debugf("Executing batch of %d transactions with timestamp %v", len(txarr), timestamp)
 	}
@@ -226,7 +381,7 @@ func (i *Noops) processTransactions() error {
 	if logger.IsEnabledFor(logging.DEBUG) {
 		logger.Debugf("Committing TX batch with timestamp: %v", timestamp)
 	}
-	if _, err := i.stack.CommitTxBatch(timestamp, nil); err != nil {
+	if _, err := i.stack.CommitTxBatch(timestamp, meta); err != nil {
 		logger.Debugf("Rolling back TX batch with timestamp: %v", timestamp)
 		i.stack.RollbackTxBatch(timestamp)
 		return err
@@ -234,30 +389,23 @@ func (i *Noops) processTransactions() error {
 	return nil
 }
 
-func (i *Noops) getTxFromMsg(msg *pb.Message) (*pb.Transaction, error) {
-	txs := &pb.TransactionBlock{}
-	if err := proto.Unmarshal(msg.Payload, txs); err != nil {
-		return nil, err
-	}
-	return txs.GetTransactions()[0], nil
-}
-
+// getBlockData returns the head block and its state delta, or nil if the
+// stack does not keep the state deltas
 func (i *Noops) getBlockData() (*pb.Block, *statemgmt.StateDelta, error) {
-	ledger, err := ledger.GetLedger()
-	if err != nil {
-		return nil, nil, fmt.Errorf("Fail to get the ledger: %v", err)
+	deltas, ok := i.stack.(consensus.StateDeltaReader)
+	if !ok {
+		return nil, nil, nil
 	}
 
-	blockHeight := ledger.GetBlockchainSize()
+	blockHeight := i.stack.GetBlockchainSize()
 	if logger.IsEnabledFor(logging.DEBUG) {
 		logger.Debugf("Preparing to broadcast with block number %v", blockHeight)
 	}
-	block, err := ledger.GetBlockByNumber(blockHeight - 1)
+	block, err := i.stack.GetBlock(blockHeight - 1)
 	if nil != err {
 		return nil, nil, err
 	}
-	//delta, err := ledger.GetStateDeltaBytes(blockHeight)
-	delta, err := ledger.GetStateDelta(blockHeight - 1)
+	delta, err := deltas.GetStateDelta(blockHeight - 1)
 	if nil != err {
 		return nil, nil, err
 	}
@@ -0,0 +1,298 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package obcpbft
+
+import (
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/spf13/viper"
+
+	pb "github.com/hyperledger/fabric/protos"
+)
+
+// With authentication, replicas do not take the peer connection on trust for
+// the pbft messages they exchange. Every replica generates an ephemeral key
+// pair when it starts, and sends its public key, signed with its identity, to
+// the other replicas. Each pair of replicas derives the session key they share
+// through Diffie-Hellman. A pbft message then travels with its authenticator,
+// the vector of its MACs under the session keys its sender shares with every
+// replica, and a replica only accepts a pbft message whose MAC at its own
+// index verifies. As in PBFT, one authenticator serves all the receivers of a
+// multicast, and MACs are cheap enough to be computed for every message, where
+// signatures are not.
+//
+// A replica missing the session key of another asks for it, by sending its
+// own key flagged for a reply. A replica which proactively recovers, see
+// recovery.go, generates a new key pair and sends its new public key, flagged
+// for a reply, to all replicas, renewing every session key it shares. The messages sent before the keys are
+// exchanged are dropped, and are recovered like any lost message.
+// Requests, complaints, and payloads are authenticated by the requests they
+// carry, and travel without authenticator.
+
+// sessionKeyRequestInterval is how often a replica asks another for its
+// session key at most
+const sessionKeyRequestInterval = time.Second
+
+var errNoSessionKey = errors.New("no session key")
+
+// authenticator holds the session keys a replica shares with the others
+type authenticator struct {
+	id        uint64
+	N         int
+	priv      []byte
+	pub       []byte
+	keysLock  sync.RWMutex // the verification workers check MACs concurrently
+	keys      map[uint64][]byte
+	pubs      map[uint64][]byte    // public keys of the replicas, to derive the session keys again once we refresh our key pair
+	requested map[uint64]time.Time // when we last asked a replica for its key
+}
+
+func newAuthenticator(id uint64, N int, config *viper.Viper) *authenticator {
+	if !config.GetBool("general.authentication.enabled") {
+		return nil
+	}
+	priv, x, y, err := elliptic.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		panic(fmt.Errorf("Cannot generate session key pair: %s", err))
+	}
+	return &authenticator{
+		id:        id,
+		N:         N,
+		priv:      priv,
+		pub:       elliptic.Marshal(elliptic.P256(), x, y),
+		keys:      make(map[uint64][]byte),
+		pubs:      make(map[uint64][]byte),
+		requested: make(map[uint64]time.Time),
+	}
+}
+
+// setKey derives the session key shared with a replica from its public key
+func (a *authenticator) setKey(replica uint64, pub []byte) error {
+	key, err := sharedKey(a.priv, pub)
+	if err != nil {
+		return err
+	}
+	a.keysLock.Lock()
+	a.keys[replica] = key
+	a.pubs[replica] = pub
+	a.keysLock.Unlock()
+	return nil
+}
+
+// refresh generates a new key pair, and derives again the session keys shared
+// with the replicas, which derive theirs once they get our new public key
+func (a *authenticator) refresh() error {
+	priv, x, y, err := elliptic.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+	a.keysLock.Lock()
+	defer a.keysLock.Unlock()
+	a.priv = priv
+	a.pub = elliptic.Marshal(elliptic.P256(), x, y)
+	for replica, pub := range a.pubs {
+		if a.keys[replica], err = sharedKey(a.priv, pub); err != nil {
+			delete(a.keys, replica)
+		}
+	}
+	a.requested = make(map[uint64]time.Time)
+	return nil
+}
+
+// sharedKey derives the session key shared with the holder of pub through
+// Diffie-Hellman
+func sharedKey(priv []byte, pub []byte) ([]byte, error) {
+	x, y := elliptic.Unmarshal(elliptic.P256(), pub)
+	if x == nil {
+		return nil, fmt.Errorf("invalid public key")
+	}
+	shared, _ := elliptic.P256().ScalarMult(x, y, priv)
+	key := sha256.Sum256(shared.Bytes())
+	return key[:], nil
+}
+
+// authenticate returns the authenticator of msg, along with the replicas we
+// share no session key with, whose MAC is left empty
+func (a *authenticator) authenticate(msg []byte) (*Authenticated, []uint64) {
+	auth := &Authenticated{
+		PbftMessage: msg,
+		Macs:        make([][]byte, a.N),
+	}
+	var missing []uint64
+	a.keysLock.RLock()
+	defer a.keysLock.RUnlock()
+	for i := uint64(0); i < uint64(a.N); i++ {
+		if i == a.id {
+			continue
+		}
+		key, ok := a.keys[i]
+		if !ok {
+			missing = append(missing, i)
+			continue
+		}
+		auth.Macs[i] = computeMAC(key, msg)
+	}
+	return auth, missing
+}
+
+// check verifies the MAC of a message from sender at our index. It returns
+// errNoSessionKey if either side has no session key for the other yet.
+func (a *authenticator) check(sender uint64, auth *Authenticated) error {
+	a.keysLock.RLock()
+	key, ok := a.keys[sender]
+	a.keysLock.RUnlock()
+	if !ok || uint64(len(auth.Macs)) <= a.id || len(auth.Macs[a.id]) == 0 {
+		return errNoSessionKey
+	}
+	if !hmac.Equal(auth.Macs[a.id], computeMAC(key, auth.PbftMessage)) {
+		return fmt.Errorf("MAC does not verify")
+	}
+	return nil
+}
+
+// shouldRequest returns whether to ask a replica for its session key, at most
+// once every sessionKeyRequestInterval
+func (a *authenticator) shouldRequest(replica uint64, now time.Time) bool {
+	if now.Sub(a.requested[replica]) < sessionKeyRequestInterval {
+		return false
+	}
+	a.requested[replica] = now
+	return true
+}
+
+func computeMAC(key []byte, msg []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write(msg)
+	return mac.Sum(nil)
+}
+
+func (sk *SessionKey) getSignature() []byte {
+	return sk.Signature
+}
+
+func (sk *SessionKey) setSignature(sig []byte) {
+	sk.Signature = sig
+}
+
+func (sk *SessionKey) getID() uint64 {
+	return sk.ReplicaId
+}
+
+func (sk *SessionKey) setID(id uint64) {
+	sk.ReplicaId = id
+}
+
+func (sk *SessionKey) serialize() ([]byte, error) {
+	return proto.Marshal(sk)
+}
+
+// sessionKeyEvent is sent when the replica starts, to hand out its session key
+type sessionKeyEvent struct{}
+
+// sessionKey returns our public key signed, or nil if it cannot be signed
+func (op *obcBatch) sessionKey(reply bool) *BatchMessage {
+	sk := &SessionKey{
+		ReplicaId: op.pbft.id,
+		PublicKey: op.auth.pub,
+		Reply:     reply,
+	}
+	if err := op.pbft.sign(sk); err != nil {
+		logger.Errorf("Replica %d could not sign its session key: %s", op.pbft.id, err)
+		return nil
+	}
+	return &BatchMessage{&BatchMessage_SessionKey{sk}}
+}
+
+// refreshKeys renews the session keys the replica shares with the others when
+// it recovers, and hands out its new public key for the others to send theirs
+// back, should ours be stale
+func (op *obcBatch) refreshKeys() {
+	if op.auth == nil {
+		return
+	}
+	if err := op.auth.refresh(); err != nil {
+		logger.Errorf("Replica %d could not refresh its session keys: %s", op.pbft.id, err)
+		return
+	}
+	logger.Infof("Replica %d refreshed its session keys", op.pbft.id)
+	if msg := op.sessionKey(true); msg != nil {
+		op.broadcastMsg(msg)
+	}
+}
+
+// requestSessionKey sends our key to a replica we share no session key with,
+// for it to send its own back
+func (op *obcBatch) requestSessionKey(replica uint64) {
+	if !op.auth.shouldRequest(replica, time.Now()) {
+		return
+	}
+	if msg := op.sessionKey(true); msg != nil {
+		logger.Debugf("Replica %d requesting the session key of replica %d", op.pbft.id, replica)
+		op.unicastMsg(msg, replica)
+	}
+}
+
+func (op *obcBatch) recvSessionKey(sk *SessionKey, senderHandle *pb.PeerID) {
+	if op.auth == nil {
+		return
+	}
+	senderID, err := getValidatorID(senderHandle)
+	if err != nil || senderID != sk.ReplicaId {
+		logger.Warningf("Replica %d received session key of replica %d from another peer", op.pbft.id, sk.ReplicaId)
+		return
+	}
+	if err := op.pbft.verify(sk); err != nil {
+		logger.Warningf("Replica %d received session key from replica %d with invalid signature: %s", op.pbft.id, senderID, err)
+		return
+	}
+	if err := op.auth.setKey(senderID, sk.PublicKey); err != nil {
+		logger.Warningf("Replica %d received session key from replica %d: %s", op.pbft.id, senderID, err)
+		return
+	}
+	logger.Debugf("Replica %d established session key with replica %d", op.pbft.id, senderID)
+	if sk.Reply {
+		if msg := op.sessionKey(false); msg != nil {
+			op.unicastMsg(msg, senderID)
+		}
+	}
+}
+
+// recvAuthenticated returns the pbft message of auth, once its MAC verifies
+func (op *obcBatch) recvAuthenticated(auth *Authenticated, senderID uint64) []byte {
+	if op.auth == nil {
+		return auth.PbftMessage
+	}
+	err := op.auth.check(senderID, auth)
+	if err == errNoSessionKey {
+		logger.Debugf("Replica %d dropping message from replica %d, no session key yet", op.pbft.id, senderID)
+		op.requestSessionKey(senderID)
+		return nil
+	}
+	if err != nil {
+		logger.Warningf("Replica %d dropping message from replica %d: %s", op.pbft.id, senderID, err)
+		return nil
+	}
+	return auth.PbftMessage
+}
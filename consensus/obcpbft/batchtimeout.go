@@ -0,0 +1,90 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package obcpbft
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// With an adaptive batch timeout the primary sets the batch timer to how long
+// the batch is expected to take to fill at the rate requests recently arrived
+// at, within bounds. As requests arrive more slowly the primary waits longer
+// for the batch to fill, and a batch not expected to fill before the upper
+// bound is cut after the lower one: at low load, requests are not held back
+// waiting for others which are unlikely to come. A full batch is cut right
+// away in any case.
+
+// arrivalSmoothing is the inverse of the weight of the latest interval
+// between two requests in the average interval
+const arrivalSmoothing = 8
+
+// batchTimeoutAdaptor estimates the interval between requests arriving at
+// the primary, and derives the batch timeout from it
+type batchTimeoutAdaptor struct {
+	min      time.Duration
+	max      time.Duration
+	interval time.Duration // moving average of the interval between requests
+	last     time.Time     // arrival of the latest request
+}
+
+func newBatchTimeoutAdaptor(config *viper.Viper) *batchTimeoutAdaptor {
+	if !config.GetBool("general.adaptivebatch.enabled") {
+		return nil
+	}
+	min, err := time.ParseDuration(config.GetString("general.adaptivebatch.min"))
+	if err != nil {
+		panic(fmt.Errorf("Cannot parse adaptive batch timeout lower bound: %s", err))
+	}
+	max, err := time.ParseDuration(config.GetString("general.adaptivebatch.max"))
+	if err != nil {
+		panic(fmt.Errorf("Cannot parse adaptive batch timeout upper bound: %s", err))
+	}
+	if min <= 0 || max < min {
+		panic(fmt.Errorf("Adaptive batch timeout bounds must satisfy 0 < min <= max, got min=%v max=%v", min, max))
+	}
+	// until requests arrive, assume they do slowly
+	return &batchTimeoutAdaptor{min: min, max: max, interval: max}
+}
+
+// arrived accounts for a request arriving at time now. Intervals are capped
+// at the upper bound, so that the primary quickly adapts once requests flow
+// again after an idle period.
+func (bta *batchTimeoutAdaptor) arrived(now time.Time) {
+	if !bta.last.IsZero() {
+		sample := now.Sub(bta.last)
+		if sample > bta.max {
+			sample = bta.max
+		}
+		bta.interval += (sample - bta.interval) / arrivalSmoothing
+	}
+	bta.last = now
+}
+
+// timeout returns how long to wait for a batch missing missing requests
+func (bta *batchTimeoutAdaptor) timeout(missing int) time.Duration {
+	expected := time.Duration(missing) * bta.interval
+	if missing <= 0 || expected > bta.max {
+		return bta.min
+	}
+	if expected < bta.min {
+		return bta.min
+	}
+	return expected
+}
@@ -0,0 +1,311 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package obcpbft
+
+import (
+	"bytes"
+	"crypto/rand"
+	"fmt"
+	"sort"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/spf13/viper"
+
+	"github.com/hyperledger/fabric/consensus"
+	"github.com/hyperledger/fabric/core/util"
+	pb "github.com/hyperledger/fabric/protos"
+)
+
+// The random beacon gives every block a random value all replicas agree on,
+// and which no f replicas can predict or choose. Every replica draws a hash
+// chain from a random seed. It first contributes the last value of the chain,
+// its anchor, and then the values of the chain in reverse, each the preimage
+// of the value it contributed before: a replica is bound to the values it
+// contributes, and the others only learn them once it reveals them.
+//
+// Replicas sign their shares and broadcast them, and the primary orders the
+// shares it holds with the next batch. On execution, a replica accepts the
+// anchors, and the shares which hash to the last value of their replica. The
+// random value of the block is the hash of the values revealed with the
+// batch, provided at least f+1 replicas, one of them correct, revealed one.
+// The last value of every replica is recorded in the metadata of the block,
+// for the replicas which catch up through state transfer to check the shares
+// that follow.
+//
+// A faulty primary may still choose which of the shares it holds to order,
+// and so pick among a few random values, or leave a block without one.
+
+// randomBeacon holds the hash chain of a replica, and, for the primary, the
+// shares to order
+type randomBeacon struct {
+	id     uint64
+	N      int
+	quorum int // how many replicas must reveal a value for a block to get a random value
+	length int // how many values a hash chain has after its anchor
+
+	chain  [][]byte                // our hash chain, the anchor first
+	sent   *BeaconShare            // the share we sent last
+	shares map[uint64]*BeaconShare // the latest share of each replica
+}
+
+// newRandomBeacon returns nil unless the random beacon is enabled
+func newRandomBeacon(id uint64, N int, f int, config *viper.Viper) *randomBeacon {
+	if !config.GetBool("general.beacon.enabled") {
+		return nil
+	}
+	length := config.GetInt("general.beacon.chainlength")
+	if length < 1 {
+		panic(fmt.Errorf("Random beacon hash chain length must be at least 1, got %d", length))
+	}
+	return &randomBeacon{
+		id:     id,
+		N:      N,
+		quorum: f + 1,
+		length: length,
+		shares: make(map[uint64]*BeaconShare),
+	}
+}
+
+// newChain draws a new hash chain
+func (rb *randomBeacon) newChain() {
+	seed := make([]byte, 32)
+	if _, err := rand.Read(seed); err != nil {
+		panic(fmt.Errorf("Cannot draw random beacon seed: %s", err))
+	}
+	rb.chain = make([][]byte, rb.length+1)
+	rb.chain[rb.length] = seed
+	for i := rb.length - 1; i >= 0; i-- {
+		rb.chain[i] = util.ComputeCryptoHash(rb.chain[i+1])
+	}
+}
+
+// next returns the share we contribute after the given beacon state: the
+// preimage of our last value, or the anchor of a new chain once ours is used
+// up or unknown to the others. It returns the share we sent last if it is
+// not ordered yet.
+func (rb *randomBeacon) next(state map[uint64][]byte) *BeaconShare {
+	var share *BeaconShare
+	if last, ok := state[rb.id]; ok && len(rb.chain) > 0 {
+		for i, value := range rb.chain[:rb.length] {
+			if bytes.Equal(value, last) {
+				share = &BeaconShare{ReplicaId: rb.id, Value: rb.chain[i+1]}
+				break
+			}
+		}
+	}
+	if share == nil {
+		if rb.sent != nil && rb.sent.Anchor {
+			return rb.sent
+		}
+		rb.newChain()
+		share = &BeaconShare{ReplicaId: rb.id, Value: rb.chain[0], Anchor: true}
+	}
+	if rb.sent != nil && !rb.sent.Anchor && bytes.Equal(rb.sent.Value, share.Value) {
+		return rb.sent
+	}
+	return share
+}
+
+// store keeps the latest share of a replica, for the primary to order it
+func (rb *randomBeacon) store(share *BeaconShare) {
+	rb.shares[share.ReplicaId] = share
+}
+
+// pending returns the shares to order with the next batch, by replica
+func (rb *randomBeacon) pending() []*BeaconShare {
+	var replicas []uint64
+	for replica := range rb.shares {
+		replicas = append(replicas, replica)
+	}
+	sort.Sort(sortableUint64Slice(replicas))
+	var shares []*BeaconShare
+	for _, replica := range replicas {
+		shares = append(shares, rb.shares[replica])
+	}
+	return shares
+}
+
+// apply returns the random value of a batch carrying the given shares, nil
+// if too few replicas revealed a value, along with the beacon state after it.
+// valid tells the shares whose signature verifies.
+func (rb *randomBeacon) apply(state map[uint64][]byte, shares []*BeaconShare, valid func(*BeaconShare) bool) ([]byte, map[uint64][]byte) {
+	next := make(map[uint64][]byte)
+	for replica, value := range state {
+		next[replica] = value
+	}
+	seen := make(map[uint64]bool)
+	var revealed []*BeaconShare
+	for _, share := range shares {
+		if share.ReplicaId >= uint64(rb.N) || seen[share.ReplicaId] || !valid(share) {
+			continue
+		}
+		if share.Anchor {
+			seen[share.ReplicaId] = true
+			next[share.ReplicaId] = share.Value
+			continue
+		}
+		last, ok := state[share.ReplicaId]
+		if !ok || !bytes.Equal(util.ComputeCryptoHash(share.Value), last) {
+			continue
+		}
+		seen[share.ReplicaId] = true
+		next[share.ReplicaId] = share.Value
+		revealed = append(revealed, share)
+	}
+
+	// The shares which made it, or no longer can, need not be ordered again
+	for replica, share := range rb.shares {
+		if seen[replica] || (!share.Anchor && !bytes.Equal(util.ComputeCryptoHash(share.Value), next[replica])) {
+			delete(rb.shares, replica)
+		}
+	}
+
+	if len(revealed) < rb.quorum {
+		return nil, next
+	}
+	sort.Sort(sharesByReplica(revealed))
+	var values []byte
+	for _, share := range revealed {
+		values = append(values, share.Value...)
+	}
+	return util.ComputeCryptoHash(values), next
+}
+
+type sharesByReplica []*BeaconShare
+
+func (a sharesByReplica) Len() int {
+	return len(a)
+}
+func (a sharesByReplica) Swap(i, j int) {
+	a[i], a[j] = a[j], a[i]
+}
+func (a sharesByReplica) Less(i, j int) bool {
+	return a[i].ReplicaId < a[j].ReplicaId
+}
+
+// beaconState returns the beacon state recorded in the metadata of a block
+func beaconState(meta *Metadata) map[uint64][]byte {
+	state := make(map[uint64][]byte)
+	for _, share := range meta.GetBeacon() {
+		state[share.ReplicaId] = share.Value
+	}
+	return state
+}
+
+// beaconMetadata returns the beacon state to record in the metadata of a block
+func beaconMetadata(state map[uint64][]byte) []*BeaconShare {
+	var shares []*BeaconShare
+	for replica, value := range state {
+		shares = append(shares, &BeaconShare{ReplicaId: replica, Value: value})
+	}
+	sort.Sort(sharesByReplica(shares))
+	return shares
+}
+
+func (share *BeaconShare) getSignature() []byte {
+	return share.Signature
+}
+
+func (share *BeaconShare) setSignature(sig []byte) {
+	share.Signature = sig
+}
+
+func (share *BeaconShare) getID() uint64 {
+	return share.ReplicaId
+}
+
+func (share *BeaconShare) setID(id uint64) {
+	share.ReplicaId = id
+}
+
+func (share *BeaconShare) serialize() ([]byte, error) {
+	return proto.Marshal(share)
+}
+
+// beaconEvent is sent when the replica starts, or its state changes under
+// it, to hand out its share
+type beaconEvent struct{}
+
+// headBeaconState returns the beacon state after the block at the head of
+// the chain
+func (op *obcBatch) headBeaconState() map[uint64][]byte {
+	meta := &Metadata{}
+	raw, err := op.stack.GetBlockHeadMetadata()
+	if err != nil {
+		logger.Debugf("Batch replica %d could not get the metadata of the head block: %s", op.pbft.id, err)
+	} else if err = proto.Unmarshal(raw, meta); err != nil {
+		logger.Warningf("Batch replica %d could not unmarshal the metadata of the head block: %s", op.pbft.id, err)
+	}
+	return beaconState(meta)
+}
+
+// sendBeaconShare broadcasts our share after the given beacon state, unless
+// we sent it already and resend is false
+func (op *obcBatch) sendBeaconShare(state map[uint64][]byte, resend bool) {
+	share := op.beacon.next(state)
+	if share == op.beacon.sent && !resend {
+		return
+	}
+	if share != op.beacon.sent {
+		if err := op.pbft.sign(share); err != nil {
+			logger.Errorf("Replica %d could not sign its beacon share: %s", op.pbft.id, err)
+			return
+		}
+		op.beacon.sent = share
+	}
+	op.beacon.store(share)
+	op.broadcastMsg(&BatchMessage{&BatchMessage_BeaconShare{share}})
+}
+
+func (op *obcBatch) recvBeaconShare(share *BeaconShare, senderHandle *pb.PeerID) {
+	if op.beacon == nil {
+		return
+	}
+	senderID, err := getValidatorID(senderHandle)
+	if err != nil || senderID != share.ReplicaId {
+		logger.Warningf("Replica %d received beacon share of replica %d from another peer", op.pbft.id, share.ReplicaId)
+		return
+	}
+	if !op.validBeaconShare(share) {
+		return
+	}
+	op.beacon.store(share)
+}
+
+func (op *obcBatch) validBeaconShare(share *BeaconShare) bool {
+	if err := op.pbft.verify(share); err != nil {
+		logger.Warningf("Replica %d found beacon share of replica %d with invalid signature: %s", op.pbft.id, share.ReplicaId, err)
+		return false
+	}
+	return true
+}
+
+// executeBeacon records the random value of the batch carrying the given
+// shares in its metadata, and hands it to the stack
+func (op *obcBatch) executeBeacon(meta *Metadata, shares []*BeaconShare) {
+	var random []byte
+	if op.beacon != nil {
+		var state map[uint64][]byte
+		random, state = op.beacon.apply(op.headBeaconState(), shares, op.validBeaconShare)
+		meta.Random = random
+		meta.Beacon = beaconMetadata(state)
+		op.sendBeaconShare(state, false)
+	}
+	if beacon, ok := op.stack.(consensus.BatchBeacon); ok {
+		beacon.SetBatchRandom(random)
+	}
+}
@@ -33,6 +33,7 @@ type communicator interface {
 type broadcaster struct {
 	comm communicator
 
+	lock     sync.Mutex // protects f and msgChans, which change when the network is reconfigured
 	f        int
 	msgChans map[uint64]chan *pb.Message
 	closed   sync.WaitGroup
@@ -40,6 +41,17 @@ type broadcaster struct {
 }
 
 func newBroadcaster(self uint64, N int, f int, c communicator) *broadcaster {
+	b := &broadcaster{
+		comm:     c,
+		closedCh: make(chan struct{}),
+	}
+	b.reconfigure(self, N, f)
+	return b
+}
+
+// reconfigure sets the replicas messages are sent to, and how many of them
+// may fail to receive a broadcast
+func (b *broadcaster) reconfigure(self uint64, N int, f int) {
 	queueSize := 10 // XXX increase after testing
 
 	chans := make(map[uint64]chan *pb.Message)
@@ -49,13 +61,11 @@ func newBroadcaster(self uint64, N int, f int, c communicator) *broadcaster {
 		}
 		chans[uint64(i)] = make(chan *pb.Message, queueSize)
 	}
-	b := &broadcaster{
-		comm:     c,
-		f:        f,
-		msgChans: chans,
-		closedCh: make(chan struct{}),
-	}
-	return b
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.f = f
+	b.msgChans = chans
 }
 
 func (b *broadcaster) Close() {
@@ -103,26 +113,25 @@ func (b *broadcaster) send(msg *pb.Message, dest *uint64) error {
 	default:
 	}
 
-	var destCount int
+	var dests []uint64
 	var required int
 	if dest != nil {
-		destCount = 1
+		dests = []uint64{*dest}
 		required = 1
 	} else {
-		destCount = len(b.msgChans)
-		required = destCount - b.f
+		b.lock.Lock()
+		for i := range b.msgChans {
+			dests = append(dests, i)
+		}
+		required = len(dests) - b.f
+		b.lock.Unlock()
 	}
 
-	wait := make(chan bool, destCount)
+	wait := make(chan bool, len(dests))
 
-	if dest != nil {
-		b.closed.Add(1)
-		go b.unicastOne(msg, *dest, wait)
-	} else {
-		b.closed.Add(len(b.msgChans))
-		for i := range b.msgChans {
-			go b.unicastOne(msg, i, wait)
-		}
+	b.closed.Add(len(dests))
+	for _, i := range dests {
+		go b.unicastOne(msg, i, wait)
 	}
 
 	for i := 0; i < required; i++ {
@@ -0,0 +1,144 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package obcpbft
+
+import (
+	"bytes"
+	"testing"
+)
+
+// checkAgreement fails the test if two of the replicas executed different
+// requests at the same sequence number
+func checkAgreement(t *testing.T, net *pbftNetwork, replicas ...int) {
+	executed := make(map[uint64][]byte)
+	for _, id := range replicas {
+		for seqNo, tx := range net.pbftEndpoints[id].sc.history {
+			if other, ok := executed[seqNo]; ok && !bytes.Equal(other, tx) {
+				t.Errorf("Replicas executed different requests at sequence number %d", seqNo)
+			}
+			executed[seqNo] = tx
+		}
+	}
+}
+
+func TestByzantineEquivocatingPrimary(t *testing.T) {
+	validatorCount := 4
+	net := makePBFTNetwork(validatorCount, nil)
+	defer net.stop()
+	net.byzantine(0, equivocatePrePrepares(3))
+
+	req := createPbftRequestWithChainTx(1, 0)
+	net.pbftEndpoints[0].manager.Queue() <- req
+	if err := net.process(); err != nil {
+		t.Fatalf("Processing failed: %s", err)
+	}
+
+	for _, pep := range net.pbftEndpoints[1:3] {
+		if !bytes.Equal(pep.sc.history[1], req.Payload) {
+			t.Errorf("Instance %d did not execute the request the quorum pre-prepared", pep.id)
+		}
+	}
+	if pep := net.pbftEndpoints[3]; pep.sc.executions != 0 && !bytes.Equal(pep.sc.history[1], req.Payload) {
+		t.Errorf("Instance %d executed the request the primary equivocated with", pep.id)
+	}
+	checkAgreement(t, net, 1, 2, 3)
+}
+
+func TestByzantineWithheldCommits(t *testing.T) {
+	validatorCount := 4
+	net := makePBFTNetwork(validatorCount, nil)
+	defer net.stop()
+	net.byzantine(3, withholdCommits())
+
+	for i := int64(1); i <= 3; i++ {
+		net.pbftEndpoints[0].manager.Queue() <- createPbftRequestWithChainTx(i, 0)
+		if err := net.process(); err != nil {
+			t.Fatalf("Processing failed: %s", err)
+		}
+	}
+
+	// The commits of the honest replicas form a quorum on their own
+	for _, pep := range net.pbftEndpoints {
+		if pep.sc.executions != 3 {
+			t.Errorf("Instance %d executed %d requests instead of 3", pep.id, pep.sc.executions)
+		}
+	}
+	checkAgreement(t, net, 0, 1, 2, 3)
+}
+
+func TestByzantineOldViewReplay(t *testing.T) {
+	validatorCount := 4
+	net := makePBFTNetwork(validatorCount, nil)
+	defer net.stop()
+	net.byzantine(3, replayOldViews())
+
+	net.pbftEndpoints[0].manager.Queue() <- createPbftRequestWithChainTx(1, 0)
+	if err := net.process(); err != nil {
+		t.Fatalf("Processing failed: %s", err)
+	}
+	for _, pep := range net.pbftEndpoints {
+		pep.pbft.sendViewChange()
+	}
+	if err := net.process(); err != nil {
+		t.Fatalf("Processing failed: %s", err)
+	}
+	// The messages of replica 3 in view 1 come along with its messages of
+	// view 0
+	net.pbftEndpoints[1].manager.Queue() <- createPbftRequestWithChainTx(2, 1)
+	if err := net.process(); err != nil {
+		t.Fatalf("Processing failed: %s", err)
+	}
+
+	for _, pep := range net.pbftEndpoints {
+		if pep.pbft.view != 1 {
+			t.Errorf("Instance %d should be in view 1, is in view %d", pep.id, pep.pbft.view)
+		}
+		if pep.sc.executions != 2 {
+			t.Errorf("Instance %d executed %d requests instead of 2", pep.id, pep.sc.executions)
+		}
+	}
+	checkAgreement(t, net, 0, 1, 2)
+}
+
+func TestByzantineLyingCheckpoints(t *testing.T) {
+	validatorCount := 4
+	config := loadConfig()
+	config.Set("general.K", 2)
+	config.Set("general.logmultiplier", 2)
+	net := makePBFTNetwork(validatorCount, config)
+	defer net.stop()
+	net.byzantine(3, lieAboutCheckpoints())
+
+	for i := int64(1); i <= 4; i++ {
+		net.pbftEndpoints[0].manager.Queue() <- createPbftRequestWithChainTx(i, 0)
+		if err := net.process(); err != nil {
+			t.Fatalf("Processing failed: %s", err)
+		}
+	}
+
+	// The honest replicas agree on their checkpoints without the liar, and
+	// are not misled into state transfer
+	for _, pep := range net.pbftEndpoints[:3] {
+		if pep.pbft.h != 4 {
+			t.Errorf("Instance %d should have a low watermark of 4, has %d", pep.id, pep.pbft.h)
+		}
+		if pep.sc.skipOccurred {
+			t.Errorf("Instance %d transferred state", pep.id)
+		}
+	}
+	checkAgreement(t, net, 0, 1, 2)
+}
@@ -0,0 +1,78 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package obcpbft
+
+import (
+	"fmt"
+
+	pb "github.com/hyperledger/fabric/protos"
+)
+
+// CatchUp starts a state transfer to the highest checkpoint f+1 replicas
+// attest to, fetching it from peer if given, which must be one of them. The
+// state transfer then proceeds as if the replica had found itself behind.
+func (op *obcBatch) CatchUp(peer *pb.PeerID) error {
+	var replica uint64
+	if peer != nil {
+		var err error
+		if replica, err = getValidatorID(peer); err != nil {
+			return err
+		}
+	}
+
+	result := make(chan error)
+	op.manager.Queue() <- workEvent(func() {
+		result <- op.catchUp(peer, replica)
+	})
+	return <-result
+}
+
+func (op *obcBatch) catchUp(peer *pb.PeerID, replica uint64) error {
+	instance := op.pbft
+	if instance.stateTransferring {
+		if peer != nil {
+			return fmt.Errorf("Replica %d is already transferring state, cannot restart it from %s", instance.id, peer.Name)
+		}
+		return nil
+	}
+
+	target := instance.highStateTarget
+	if target == nil || target.seqNo <= instance.lastExec {
+		return fmt.Errorf("Replica %d is not behind the network, it executed up to seqNo %d and knows of no later checkpoint", instance.id, instance.lastExec)
+	}
+
+	if peer != nil {
+		attested := false
+		for _, r := range target.replicas {
+			if r == replica {
+				attested = true
+			}
+		}
+		if !attested {
+			return fmt.Errorf("Replica %d cannot catch up from %s, which does not attest to the checkpoint for seqNo %d (attested by %v)",
+				instance.id, peer.Name, target.seqNo, target.replicas)
+		}
+		target = &stateUpdateTarget{
+			checkpointMessage: target.checkpointMessage,
+			replicas:          []uint64{replica},
+		}
+	}
+
+	logger.Infof("Replica %d catching up to seqNo %d on request, from replicas %v", instance.id, target.seqNo, target.replicas)
+	instance.stateTransfer(target)
+	return nil
+}
@@ -0,0 +1,179 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package obcpbft
+
+import (
+	"fmt"
+
+	"github.com/golang/protobuf/proto"
+)
+
+// A replica which missed some of the messages of a sequence number may commit
+// later sequence numbers but not that one, and cannot execute them. Rather
+// than wait for the network to move its watermarks past it, which leads to a
+// state transfer, or for a view change, once such a gap lasted
+// general.timeout.certfetch the replica asks all replicas for the sequence
+// numbers it did not commit, within its watermarks. A replica which committed
+// one of them answers with its pre-prepare, request included. The answers are
+// not signed, so the replica only takes the request of a sequence number once
+// f+1 replicas, so at least one correct replica, answered with the same view
+// and digest, and then executes it as if it had committed it itself.
+
+// certFetchTimerEvent is sent when a gap in the committed sequence numbers
+// lasted the certificate fetch timeout
+type certFetchTimerEvent struct{}
+
+// committedCert returns the certificate of a view which committed seqNo n, if any
+func (instance *pbftCore) committedCert(n uint64) (msgID, *msgCert, bool) {
+	for idx, cert := range instance.certStore {
+		if idx.n == n && cert.prePrepare != nil && instance.committed(cert.digest, idx.v, idx.n) {
+			return idx, cert, true
+		}
+	}
+	return msgID{}, nil, false
+}
+
+// missingCerts returns the sequence numbers we did not commit, below the
+// highest one we committed
+func (instance *pbftCore) missingCerts() []uint64 {
+	if instance.skipInProgress {
+		return nil
+	}
+	var highest uint64
+	for idx, cert := range instance.certStore {
+		if idx.n > highest && cert.prePrepare != nil && instance.committed(cert.digest, idx.v, idx.n) {
+			highest = idx.n
+		}
+	}
+	var missing []uint64
+	for n := instance.lastExec + 1; n < highest; n++ {
+		if _, _, ok := instance.committedCert(n); !ok {
+			missing = append(missing, n)
+		}
+	}
+	return missing
+}
+
+// checkCertGap starts the certificate fetch timer if we committed past a
+// sequence number we did not commit
+func (instance *pbftCore) checkCertGap() {
+	if instance.certFetchTimeout == 0 || instance.certFetchTimerActive {
+		return
+	}
+	if len(instance.missingCerts()) == 0 {
+		return
+	}
+	instance.certFetchTimerActive = true
+	instance.certFetchTimer.Reset(instance.certFetchTimeout, certFetchTimerEvent{})
+}
+
+// certFetchTimerExpired asks all replicas for the sequence numbers we are
+// still missing, and checks again after the timeout
+func (instance *pbftCore) certFetchTimerExpired() {
+	instance.certFetchTimerActive = false
+	for n := range instance.certReplies {
+		if n <= instance.lastExec {
+			delete(instance.certReplies, n)
+		}
+	}
+	missing := instance.missingCerts()
+	if len(missing) == 0 {
+		return
+	}
+	logger.Infof("Replica %d committed past seqNos %v but not them, fetching their certificates", instance.id, missing)
+	instance.innerBroadcast(&Message{&Message_FetchCertificate{&FetchCertificate{
+		SequenceNumbers: missing,
+		ReplicaId:       instance.id,
+	}}})
+	instance.certFetchTimerActive = true
+	instance.certFetchTimer.Reset(instance.certFetchTimeout, certFetchTimerEvent{})
+}
+
+func (instance *pbftCore) recvFetchCertificate(fetch *FetchCertificate) error {
+	logger.Debugf("Replica %d received fetch-certificate from replica %d for seqNos %v", instance.id, fetch.ReplicaId, fetch.SequenceNumbers)
+	for _, n := range fetch.SequenceNumbers {
+		_, cert, ok := instance.committedCert(n)
+		if !ok {
+			continue
+		}
+		preprep := *cert.prePrepare
+		preprep.Request = instance.reqStore[cert.digest]
+		msgRaw, err := proto.Marshal(&Message{&Message_CommittedCertificate{&CommittedCertificate{
+			PrePrepare: &preprep,
+			ReplicaId:  instance.id,
+		}}})
+		if err != nil {
+			return fmt.Errorf("Error marshalling committed-certificate message: %v", err)
+		}
+		instance.consumer.unicast(msgRaw, fetch.ReplicaId)
+	}
+	return nil
+}
+
+func (instance *pbftCore) recvCommittedCertificate(cc *CommittedCertificate) error {
+	preprep := cc.PrePrepare
+	if preprep == nil {
+		return nil
+	}
+	n, digest := preprep.SequenceNumber, preprep.RequestDigest
+	logger.Debugf("Replica %d received committed-certificate from replica %d for view=%d/seqNo=%d",
+		instance.id, cc.ReplicaId, preprep.View, n)
+
+	if n <= instance.lastExec || !instance.inW(n) {
+		return nil
+	}
+	if _, _, ok := instance.committedCert(n); ok {
+		return nil
+	}
+	if digest != "" && (preprep.Request == nil || hashReq(preprep.Request) != digest) {
+		logger.Warningf("Replica %d received committed-certificate from replica %d for seqNo %d whose request does not match digest %s",
+			instance.id, cc.ReplicaId, n, digest)
+		return nil
+	}
+
+	replies, ok := instance.certReplies[n]
+	if !ok {
+		replies = make(map[uint64]*PrePrepare)
+		instance.certReplies[n] = replies
+	}
+	replies[cc.ReplicaId] = preprep
+	matching := 0
+	for _, other := range replies {
+		if other.View == preprep.View && other.RequestDigest == digest {
+			matching++
+		}
+	}
+	if matching < instance.f+1 {
+		return nil
+	}
+	delete(instance.certReplies, n)
+
+	logger.Infof("Replica %d taking view=%d/seqNo=%d with digest %s, committed according to %d replicas",
+		instance.id, preprep.View, n, digest, matching)
+	if digest != "" {
+		instance.reqStore[digest] = preprep.Request
+		instance.persistRequest(digest)
+	}
+	cert := instance.getCert(preprep.View, n)
+	cert.prePrepare = preprep
+	cert.digest = digest
+	cert.fetched = true
+	certificatesFetched.Inc()
+
+	instance.executeOutstanding()
+	return nil
+}
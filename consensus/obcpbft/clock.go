@@ -0,0 +1,79 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package obcpbft
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"google/protobuf"
+
+	"github.com/spf13/viper"
+)
+
+// The primary stamps every batch with its clock. Replicas only accept a batch
+// whose timestamp is within the maximum skew of their own clock, so that a
+// faulty primary cannot move the time of the chain arbitrarily. The time of
+// the batch is the one it was stamped with, unless that is not after the time
+// of the previous block, in which case the batch is 1ns later: the time of
+// the chain never goes back, and all replicas derive the same time from the
+// ordered batch.
+
+var errClockSkew = errors.New("batch timestamp too far from the local clock")
+
+func parseMaxClockSkew(config *viper.Viper) time.Duration {
+	maxSkew, err := time.ParseDuration(config.GetString("general.clock.maxskew"))
+	if err != nil {
+		panic(fmt.Errorf("Cannot parse maximum clock skew: %s", err))
+	}
+	return maxSkew
+}
+
+func toTimestamp(t time.Time) *google_protobuf.Timestamp {
+	return &google_protobuf.Timestamp{Seconds: t.Unix(), Nanos: int32(t.Nanosecond())}
+}
+
+func fromTimestamp(ts *google_protobuf.Timestamp) time.Time {
+	return time.Unix(ts.Seconds, int64(ts.Nanos)).UTC()
+}
+
+// checkClockSkew returns whether the proposed timestamp is within maxSkew of
+// now, any timestamp is if maxSkew is 0
+func checkClockSkew(proposed *google_protobuf.Timestamp, now time.Time, maxSkew time.Duration) error {
+	if maxSkew <= 0 {
+		return nil
+	}
+	skew := fromTimestamp(proposed).Sub(now)
+	if skew > maxSkew || -skew > maxSkew {
+		return fmt.Errorf("%s: off by %v", errClockSkew, skew)
+	}
+	return nil
+}
+
+// batchTime returns the time of a batch proposed at proposed, following a
+// block of the given time, nil if the previous block has none
+func batchTime(proposed *google_protobuf.Timestamp, previous *google_protobuf.Timestamp) *google_protobuf.Timestamp {
+	if previous == nil {
+		return proposed
+	}
+	prev := fromTimestamp(previous)
+	if t := fromTimestamp(proposed); t.After(prev) {
+		return proposed
+	}
+	return toTimestamp(prev.Add(time.Nanosecond))
+}
@@ -0,0 +1,106 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package obcpbft
+
+import (
+	"encoding/base64"
+	"sort"
+)
+
+// The result of executing a request is the state id the consumer reports once the
+// request has executed, b64 encoded as in checkpoints. Each replica piggybacks the
+// result of its last execution on the commits it sends, so that an execution which
+// diverged from the rest of the network is detected on the next request, rather
+// than at the next checkpoint. A replica which finds f+1 other replicas agreeing on
+// a different result than its own knows its own execution is wrong, and quarantines
+// itself until it recovers through state transfer to the result attested to by
+// those replicas.
+
+// recordExecResult stores our own result for seqNo, it must be invoked once the
+// state reflects the execution of seqNo
+func (instance *pbftCore) recordExecResult(seqNo uint64) {
+	// Whatever we reported beyond seqNo no longer reflects our state
+	for n, results := range instance.execResultStore {
+		if n > seqNo {
+			delete(results, instance.id)
+		}
+	}
+	id := base64.StdEncoding.EncodeToString(instance.consumer.getState())
+	instance.recvExecResult(instance.id, seqNo, id)
+}
+
+// lastExecResult returns the result of the last request we executed, if we know it
+func (instance *pbftCore) lastExecResult() (uint64, string, bool) {
+	id, ok := instance.execResultStore[instance.lastExec][instance.id]
+	return instance.lastExec, id, ok
+}
+
+func (instance *pbftCore) recvExecResult(replicaID uint64, seqNo uint64, id string) {
+	if replicaID >= uint64(instance.N) || seqNo < instance.h || seqNo > instance.h+instance.L {
+		logger.Debugf("Replica %d ignoring execution result from replica %d for seqNo %d, low watermark %d",
+			instance.id, replicaID, seqNo, instance.h)
+		return
+	}
+
+	results, ok := instance.execResultStore[seqNo]
+	if !ok {
+		results = make(map[uint64]string)
+		instance.execResultStore[seqNo] = results
+	}
+	if _, ok := results[replicaID]; ok && replicaID != instance.id {
+		// Only the first result a replica reports for a sequence number counts
+		return
+	}
+	results[replicaID] = id
+
+	instance.checkExecResult(seqNo, replicaID)
+}
+
+// checkExecResult compares our result for seqNo with the results reported by the
+// other replicas, replicaID is the replica whose result was just recorded
+func (instance *pbftCore) checkExecResult(seqNo uint64, replicaID uint64) {
+	results := instance.execResultStore[seqNo]
+	ours, ok := results[instance.id]
+	if !ok || instance.skipInProgress {
+		return
+	}
+
+	members := make(map[string][]uint64)
+	for replica, id := range results {
+		members[id] = append(members[id], replica)
+	}
+
+	for id, replicas := range members {
+		if id == ours {
+			continue
+		}
+		if len(replicas) < instance.f+1 {
+			for _, replica := range replicas {
+				if replicaID == instance.id || replicaID == replica {
+					logger.Warningf("Replica %d found that replica %d executed seqNo %d with result %s, differing from our result %s",
+						instance.id, replica, seqNo, id, ours)
+				}
+			}
+			continue
+		}
+
+		// f+1 replicas, so at least one correct replica, agree on a different result
+		sort.Sort(sortableUint64Slice(replicas))
+		instance.quarantine(seqNo, ours, id, replicas)
+		return
+	}
+}
@@ -0,0 +1,124 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package obcpbft
+
+import (
+	"fmt"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/spf13/cast"
+	"github.com/spf13/viper"
+
+	pb "github.com/hyperledger/fabric/protos"
+)
+
+// With fair batching the primary only cuts a batch when it can issue its
+// pre-prepare right away, and keeps the requests arriving meanwhile. Batches
+// are cut from the kept requests in weighted round-robin between chaincodes,
+// so that a chatty chaincode does not keep the requests of the others waiting
+// behind its own. A chaincode takes up to its weight in requests per round,
+// its requests are taken in the order they arrived, and a chaincode alone
+// fills the whole batch.
+
+// batchFairness holds the weights of the chaincodes, by chaincode name
+type batchFairness struct {
+	weights       map[string]int
+	defaultWeight int
+}
+
+func newBatchFairness(config *viper.Viper) *batchFairness {
+	if !config.GetBool("general.fairness.enabled") {
+		return nil
+	}
+	bf := &batchFairness{
+		weights:       make(map[string]int),
+		defaultWeight: 1,
+	}
+	for name, weight := range config.GetStringMap("general.fairness.weights") {
+		w := cast.ToInt(weight)
+		if w < 1 {
+			panic(fmt.Errorf("Batch weight of chaincode %s must be a positive integer, got %v", name, weight))
+		}
+		bf.weights[name] = w
+	}
+	return bf
+}
+
+func (bf *batchFairness) weight(chaincode string) int {
+	if w, ok := bf.weights[chaincode]; ok {
+		return w
+	}
+	return bf.defaultWeight
+}
+
+// cut takes a batch of up to size requests out of reqs, and returns it along
+// with the requests left
+func (bf *batchFairness) cut(reqs []*Request, size int, chaincodeOf func(*Request) string) (batch []*Request, rest []*Request) {
+	if len(reqs) <= size {
+		return reqs, nil
+	}
+
+	// Queue the requests of each chaincode, chaincodes in the order their
+	// first request arrived
+	var order []string
+	queues := make(map[string][]int)
+	for i, req := range reqs {
+		cc := chaincodeOf(req)
+		if _, ok := queues[cc]; !ok {
+			order = append(order, cc)
+		}
+		queues[cc] = append(queues[cc], i)
+	}
+
+	taken := make([]bool, len(reqs))
+	for n := 0; n < size; {
+		for _, cc := range order {
+			for w := bf.weight(cc); w > 0 && len(queues[cc]) > 0 && n < size; w-- {
+				taken[queues[cc][0]] = true
+				queues[cc] = queues[cc][1:]
+				n++
+			}
+		}
+	}
+
+	for i, req := range reqs {
+		if taken[i] {
+			batch = append(batch, req)
+		} else {
+			rest = append(rest, req)
+		}
+	}
+	return batch, rest
+}
+
+// chaincodeOf returns the name of the chaincode the transaction of req
+// targets, or "" if it cannot be told, e.g. for confidential transactions
+func (op *obcBatch) chaincodeOf(req *Request) string {
+	payload := req.Payload
+	if req.PayloadHash != nil {
+		payload = op.payloads.get(req.PayloadHash)
+	}
+	tx := &pb.Transaction{}
+	if err := proto.Unmarshal(payload, tx); err != nil {
+		return ""
+	}
+	cID := &pb.ChaincodeID{}
+	if err := proto.Unmarshal(tx.ChaincodeID, cID); err != nil {
+		return ""
+	}
+	return cID.Name
+}
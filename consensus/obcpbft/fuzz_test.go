@@ -17,121 +17,408 @@ limitations under the License.
 package obcpbft
 
 import (
+	"flag"
+	"fmt"
 	gp "google/protobuf"
+	"io/ioutil"
 	"math/rand"
+	"os"
+	"path/filepath"
 	"reflect"
 	"testing"
+	"time"
 
 	"github.com/golang/protobuf/proto"
-	"github.com/google/gofuzz"
 	"github.com/op/go-logging"
 
-	"fmt"
-
+	"github.com/hyperledger/fabric/consensus/obcpbft/events"
 	pb "github.com/hyperledger/fabric/protos"
 )
 
-func newFuzzMock() *omniProto {
-	return &omniProto{
-		broadcastImpl: func(msgPayload []byte) {
-			// No-op
-		},
-		verifyImpl: func(senderID uint64, signature []byte, message []byte) error {
-			return nil
-		},
-		validateImpl: func(txRaw []byte) error {
-			return nil
-		},
-		signImpl: func(msg []byte) ([]byte, error) {
-			return msg, nil
-		},
-		viewChangeImpl: func(curView uint64) {
-		},
-		validateStateImpl:   func() {},
-		invalidateStateImpl: func() {},
-	}
-}
-
-func TestFuzz(t *testing.T) {
-	if testing.Short() {
-		t.Skip("Skipping fuzz test")
+// The fuzz targets deliver a single message, as a faulty replica could send
+// it, to a network of replicas which already ordered a few requests, and check
+// the correct replicas never execute different requests at the same sequence
+// number. Run them continuously with
+//
+//	go test -run NONE -fuzz FuzzRecvMsg ./consensus/obcpbft
+//	go test -run NONE -fuzz FuzzViewChange ./consensus/obcpbft
+//
+// Their seed corpus in testdata/fuzz holds the messages the replicas exchange
+// while ordering requests and changing view; after a deliberate change of the
+// protocol, regenerate it with
+//
+//	go test -run TestFuzzCorpus -generateFuzzCorpus
+var generateFuzzCorpus = flag.Bool("generateFuzzCorpus", false, "Regenerate the seed corpus of the fuzz targets in testdata")
+
+// fuzzDeliveryLimit bounds the messages delivered in reaction to a fuzzed
+// message. Without timers the replicas only exchange a bounded number of
+// messages, going past it means a faulty replica can make them loop.
+const fuzzDeliveryLimit = 10000
+
+type fuzzPacket struct {
+	src     uint64
+	dst     uint64
+	payload []byte
+}
+
+// fuzzNet connects pbft replicas with inert timers, and delivers their
+// messages synchronously, in the order they are sent
+type fuzzNet struct {
+	replicas []*fuzzReplica
+	packets  []fuzzPacket
+	sent     [][]byte // every payload sent, if recording
+	record   bool
+}
+
+// fuzzReplica is the stack of a replica of a fuzzNet. Executions and state
+// transfers complete as soon as the replica is done with the current event.
+type fuzzReplica struct {
+	id        uint64
+	net       *fuzzNet
+	pbft      *pbftCore
+	lastSeqNo uint64
+	executed  map[uint64][]byte
+	pending   []events.Event
+	mockPersist
+}
+
+func newFuzzNet() *fuzzNet {
+	config := loadConfig()
+	config.Set("general.K", 2)
+	config.Set("general.logmultiplier", 2)
+	config.Set("general.dissemination.mode", "broadcast")
+
+	net := &fuzzNet{}
+	for id := uint64(0); id < uint64(config.GetInt("general.N")); id++ {
+		r := &fuzzReplica{id: id, net: net, executed: make(map[uint64][]byte)}
+		r.pbft = newPbftCore(id, config, r, &inertTimerFactory{})
+		net.replicas = append(net.replicas, r)
+	}
+	return net
+}
+
+func (net *fuzzNet) close() {
+	for _, r := range net.replicas {
+		r.pbft.close()
+	}
+}
+
+func (net *fuzzNet) send(src uint64, dsts []uint64, payload []byte) {
+	for _, dst := range dsts {
+		net.packets = append(net.packets, fuzzPacket{src, dst, payload})
+	}
+	if net.record {
+		net.sent = append(net.sent, payload)
+	}
+}
+
+// deliver hands an event to a replica, then completes what it executed
+func (net *fuzzNet) deliver(r *fuzzReplica, event events.Event) {
+	events.SendEvent(r.pbft, event)
+	for len(r.pending) > 0 {
+		next := r.pending[0]
+		r.pending = r.pending[1:]
+		events.SendEvent(r.pbft, next)
+	}
+}
+
+// inject delivers a message from sender to all the other replicas
+func (net *fuzzNet) inject(sender uint64, msg *Message) {
+	for _, r := range net.replicas {
+		if r.id != sender {
+			net.deliver(r, pbftMessageEvent{msg: msg, sender: sender})
+		}
+	}
+}
+
+// submit delivers a new request to all the replicas
+func (net *fuzzNet) submit(iter int64) {
+	req := createPbftRequestWithChainTx(iter, uint64(iter)%uint64(len(net.replicas)))
+	msg := &Message{&Message_Request{req}}
+	if net.record {
+		raw, _ := proto.Marshal(msg)
+		net.sent = append(net.sent, raw)
+	}
+	for _, r := range net.replicas {
+		net.deliver(r, pbftMessageEvent{msg: msg, sender: req.ReplicaId})
+	}
+}
+
+// route delivers the messages in flight until the replicas are quiet
+func (net *fuzzNet) route() error {
+	for delivered := 0; len(net.packets) > 0; delivered++ {
+		if delivered == fuzzDeliveryLimit {
+			return fmt.Errorf("%d messages still in flight after delivering %d", len(net.packets), delivered)
+		}
+		p := net.packets[0]
+		net.packets = net.packets[1:]
+		msg := &Message{}
+		if err := proto.Unmarshal(p.payload, msg); err != nil {
+			return fmt.Errorf("Replica %d sent a message which does not unmarshal: %v", p.src, err)
+		}
+		net.deliver(net.replicas[p.dst], pbftMessageEvent{msg: msg, sender: p.src})
+	}
+	return nil
+}
+
+// prime has the replicas order a few requests, past a stable checkpoint
+func (net *fuzzNet) prime() error {
+	for iter := int64(1); iter <= 3; iter++ {
+		net.submit(iter)
+		if err := net.route(); err != nil {
+			return err
+		}
+	}
+	for _, r := range net.replicas {
+		if r.lastSeqNo != 3 {
+			return fmt.Errorf("Replica %d executed up to %d instead of 3 while priming", r.id, r.lastSeqNo)
+		}
+	}
+	return nil
+}
+
+// checkSafety returns an error if two replicas other than the faulty one
+// executed different requests at the same sequence number
+func (net *fuzzNet) checkSafety(faulty uint64) error {
+	agreed := make(map[uint64][]byte)
+	for _, r := range net.replicas {
+		if r.id == faulty {
+			continue
+		}
+		for seqNo, tx := range r.executed {
+			if other, ok := agreed[seqNo]; ok && !reflect.DeepEqual(other, tx) {
+				return fmt.Errorf("Replica %d executed %x at sequence number %d, another correct replica %x", r.id, tx, seqNo, other)
+			}
+			agreed[seqNo] = tx
+		}
+	}
+	return nil
+}
+
+func (r *fuzzReplica) broadcast(msgPayload []byte) {
+	var dsts []uint64
+	for _, other := range r.net.replicas {
+		if other.id != r.id {
+			dsts = append(dsts, other.id)
+		}
+	}
+	r.net.send(r.id, dsts, msgPayload)
+}
+
+func (r *fuzzReplica) unicast(msgPayload []byte, receiverID uint64) error {
+	if receiverID >= uint64(len(r.net.replicas)) {
+		return fmt.Errorf("no replica %d", receiverID)
+	}
+	r.net.send(r.id, []uint64{receiverID}, msgPayload)
+	return nil
+}
+
+func (r *fuzzReplica) execute(seqNo uint64, txRaw []byte) {
+	r.executed[seqNo] = txRaw
+	r.lastSeqNo = seqNo
+	r.pending = append(r.pending, execDoneEvent{})
+}
+
+func (r *fuzzReplica) getState() []byte {
+	return []byte(fmt.Sprintf("state %d", r.lastSeqNo))
+}
+
+func (r *fuzzReplica) getLastSeqNo() (uint64, error) {
+	if r.lastSeqNo == 0 {
+		return 0, fmt.Errorf("no execution yet")
+	}
+	return r.lastSeqNo, nil
+}
+
+func (r *fuzzReplica) skipTo(seqNo uint64, id []byte, replicas []uint64) {
+	r.lastSeqNo = seqNo
+	r.pending = append(r.pending, stateUpdatedEvent{
+		chkpt:  &checkpointMessage{seqNo: seqNo, id: id},
+		target: &pb.BlockchainInfo{},
+	})
+}
+
+func (r *fuzzReplica) validate(txRaw []byte) error {
+	return nil
+}
+
+func (r *fuzzReplica) sign(msg []byte) ([]byte, error) {
+	return msg, nil
+}
+
+func (r *fuzzReplica) verify(senderID uint64, signature []byte, message []byte) error {
+	return nil
+}
+
+func (r *fuzzReplica) invalidateState()                     {}
+func (r *fuzzReplica) validateState()                       {}
+func (r *fuzzReplica) reconfigured(config *Reconfiguration) {}
+
+// fuzzSender returns the replica a message claims to be sent by
+func fuzzSender(msg *Message) uint64 {
+	switch p := msg.Payload.(type) {
+	case *Message_Request:
+		return p.Request.ReplicaId
+	case *Message_PrePrepare:
+		return p.PrePrepare.ReplicaId
+	case *Message_Prepare:
+		return p.Prepare.ReplicaId
+	case *Message_Commit:
+		return p.Commit.ReplicaId
+	case *Message_Checkpoint:
+		return p.Checkpoint.ReplicaId
+	case *Message_ViewChange:
+		return p.ViewChange.ReplicaId
+	case *Message_NewView:
+		return p.NewView.ReplicaId
+	case *Message_FetchRequest:
+		return p.FetchRequest.ReplicaId
+	case *Message_ReturnRequest:
+		return p.ReturnRequest.ReplicaId
+	case *Message_RelayRequest:
+		return p.RelayRequest.ReplicaId
 	}
+	return 0
+}
 
+// FuzzRecvMsg delivers an arbitrary message from the replica it claims to be
+// sent by, then has the network order another request
+func FuzzRecvMsg(f *testing.F) {
 	logging.SetBackend(logging.InitForTesting(logging.ERROR))
+	f.Cleanup(logging.Reset)
 
-	mock := newFuzzMock()
-	primary, pmanager := createRunningPbftWithManager(0, loadConfig(), mock)
-	defer primary.close()
-	defer pmanager.Halt()
-	mock = newFuzzMock()
-	backup, bmanager := createRunningPbftWithManager(1, loadConfig(), mock)
-	defer backup.close()
-	defer bmanager.Halt()
+	f.Fuzz(func(t *testing.T, payload []byte) {
+		msg := &Message{}
+		if proto.Unmarshal(payload, msg) != nil {
+			return
+		}
+		net := newFuzzNet()
+		defer net.close()
+		if err := net.prime(); err != nil {
+			t.Fatal(err)
+		}
 
-	f := fuzz.New()
+		sender := fuzzSender(msg)
+		net.inject(sender, msg)
+		net.submit(4)
+		if err := net.route(); err != nil {
+			t.Fatal(err)
+		}
+		if err := net.checkSafety(sender); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
 
-	for i := 0; i < 30; i++ {
+// FuzzViewChange delivers an arbitrary view-change message, then has all but
+// one of the other replicas ask for a view change, so that the new primary
+// needs the fuzzed one to compute the new view
+func FuzzViewChange(f *testing.F) {
+	logging.SetBackend(logging.InitForTesting(logging.ERROR))
+	f.Cleanup(logging.Reset)
+
+	f.Fuzz(func(t *testing.T, payload []byte) {
+		vc := &ViewChange{}
+		if proto.Unmarshal(payload, vc) != nil {
+			return
+		}
+		net := newFuzzNet()
+		defer net.close()
+		if err := net.prime(); err != nil {
+			t.Fatal(err)
+		}
+
+		net.inject(vc.ReplicaId, &Message{&Message_ViewChange{vc}})
+		skipped := false
+		for _, r := range net.replicas {
+			if r.id == vc.ReplicaId {
+				continue
+			}
+			if !skipped {
+				skipped = true
+				continue
+			}
+			net.deliver(r, viewChangeTimerEvent{})
+		}
+		net.submit(4)
+		if err := net.route(); err != nil {
+			t.Fatal(err)
+		}
+		if err := net.checkSafety(vc.ReplicaId); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
+
+// TestFuzzCorpus regenerates the seed corpus of the fuzz targets from the
+// messages of a network ordering requests, then changing view
+func TestFuzzCorpus(t *testing.T) {
+	if !*generateFuzzCorpus {
+		t.Skip("Regenerate the fuzz corpus with -generateFuzzCorpus")
+	}
+
+	net := newFuzzNet()
+	defer net.close()
+	net.record = true
+	if err := net.prime(); err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range net.replicas[1:] {
+		net.deliver(r, viewChangeTimerEvent{})
+	}
+	net.submit(4)
+	if err := net.route(); err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range net.replicas {
+		if r.pbft.view != 1 || r.lastSeqNo != 4 {
+			t.Fatalf("Replica %d is in view %d and executed up to %d, expected view 1 and 4", r.id, r.pbft.view, r.lastSeqNo)
+		}
+	}
+
+	// Only the generated <type>-<n> entries are replaced, the hash-named ones
+	// are failing inputs found by the fuzzer and kept as regression tests
+	for _, target := range []string{"FuzzRecvMsg", "FuzzViewChange"} {
+		dir := filepath.Join("testdata", "fuzz", target)
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+		generated, err := filepath.Glob(filepath.Join(dir, "*-[0-9]*"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, name := range generated {
+			if err := os.Remove(name); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	write := func(target, name string, payload []byte) {
+		entry := fmt.Sprintf("go test fuzz v1\n[]byte(%q)\n", payload)
+		if err := ioutil.WriteFile(filepath.Join("testdata", "fuzz", target, name), []byte(entry), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// A couple of messages of each type is enough for the fuzzer to mutate
+	count := make(map[string]int)
+	for _, raw := range net.sent {
 		msg := &Message{}
-		f.Fuzz(msg)
-		// roundtrip through protobufs to translate
-		// nil slices into empty slices
-		raw, _ := proto.Marshal(msg)
-		proto.Unmarshal(raw, msg)
-
-		var senderID uint64
-		if req := msg.GetRequest(); req != nil {
-			senderID = req.ReplicaId
-		} else if preprep := msg.GetPrePrepare(); preprep != nil {
-			senderID = preprep.ReplicaId
-		} else if prep := msg.GetPrepare(); prep != nil {
-			senderID = prep.ReplicaId
-		} else if commit := msg.GetCommit(); commit != nil {
-			senderID = commit.ReplicaId
-		} else if chkpt := msg.GetCheckpoint(); chkpt != nil {
-			senderID = chkpt.ReplicaId
-		} else if vc := msg.GetViewChange(); vc != nil {
-			senderID = vc.ReplicaId
-		} else if nv := msg.GetNewView(); nv != nil {
-			senderID = nv.ReplicaId
-		}
-
-		pmanager.Queue() <- &pbftMessageEvent{msg: msg, sender: senderID}
-		bmanager.Queue() <- &pbftMessageEvent{msg: msg, sender: senderID}
-	}
-
-	logging.Reset()
-}
-
-func (msg *Message) Fuzz(c fuzz.Continue) {
-	switch c.RandUint64() % 7 {
-	case 0:
-		m := &Message_Request{}
-		c.Fuzz(m)
-		msg.Payload = m
-	case 1:
-		m := &Message_PrePrepare{}
-		c.Fuzz(m)
-		msg.Payload = m
-	case 2:
-		m := &Message_Prepare{}
-		c.Fuzz(m)
-		msg.Payload = m
-	case 3:
-		m := &Message_Commit{}
-		c.Fuzz(m)
-		msg.Payload = m
-	case 4:
-		m := &Message_Checkpoint{}
-		c.Fuzz(m)
-		msg.Payload = m
-	case 5:
-		m := &Message_ViewChange{}
-		c.Fuzz(m)
-		msg.Payload = m
-	case 6:
-		m := &Message_NewView{}
-		c.Fuzz(m)
-		msg.Payload = m
+		if err := proto.Unmarshal(raw, msg); err != nil {
+			t.Fatal(err)
+		}
+		kind := messageType(msg)
+		if vc := msg.GetViewChange(); vc != nil {
+			vcRaw, err := proto.Marshal(vc)
+			if err != nil {
+				t.Fatal(err)
+			}
+			write("FuzzViewChange", fmt.Sprintf("%s-%d", kind, count[kind]), vcRaw)
+		}
+		if count[kind] < 2 {
+			write("FuzzRecvMsg", fmt.Sprintf("%s-%d", kind, count[kind]), raw)
+		}
+		count[kind]++
 	}
 }
 
@@ -145,6 +432,7 @@ func TestMinimalFuzz(t *testing.T) {
 	defer net.stop()
 	fuzzer := &protoFuzzer{r: rand.New(rand.NewSource(0))}
 	net.filterFn = fuzzer.fuzzPacket
+	net.simulate(newNetSim(0, linkProfile{latency: uniformLatency(0, 2*time.Millisecond)}))
 
 	noExec := 0
 	for reqID := 1; reqID < 30; reqID++ {
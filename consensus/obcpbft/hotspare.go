@@ -0,0 +1,137 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package obcpbft
+
+import (
+	"github.com/spf13/viper"
+)
+
+// A hot spare is the replica next in line to be primary. It keeps the
+// requests the primary is expected to batch, in the order it received them,
+// and fetches their off-consensus payloads ahead of time. When the primary
+// fails and the view changes to the hot spare, it proposes those requests
+// right away, instead of gathering them anew and waiting for the batch timer.
+
+// hotSpare holds the requests shadowed by the next primary
+type hotSpare struct {
+	reqs   []*Request
+	hashes map[string]bool
+}
+
+func newHotSpare(config *viper.Viper) *hotSpare {
+	if !config.GetBool("general.hotspare.enabled") {
+		return nil
+	}
+	return &hotSpare{hashes: make(map[string]bool)}
+}
+
+// add appends a request to the shadowed requests, and returns whether it was
+// not shadowed yet
+func (hs *hotSpare) add(req *Request) bool {
+	hash := hashReq(req)
+	if hs.hashes[hash] {
+		return false
+	}
+	hs.hashes[hash] = true
+	hs.reqs = append(hs.reqs, req)
+	return true
+}
+
+// remove drops a request, once it has been executed
+func (hs *hotSpare) remove(req *Request) {
+	hash := hashReq(req)
+	if !hs.hashes[hash] {
+		return
+	}
+	delete(hs.hashes, hash)
+	for i, sreq := range hs.reqs {
+		if hashReq(sreq) == hash {
+			hs.reqs = append(hs.reqs[:i], hs.reqs[i+1:]...)
+			break
+		}
+	}
+}
+
+func (hs *hotSpare) clear() {
+	hs.reqs = nil
+	hs.hashes = make(map[string]bool)
+}
+
+// isHotSpare returns whether we shadow the requests of the primary, that is
+// whether we are the primary of the next view
+func (op *obcBatch) isHotSpare() bool {
+	return op.spare != nil && op.pbft.primary(op.pbft.view+1) == op.pbft.id && op.pbft.primary(op.pbft.view) != op.pbft.id
+}
+
+// shadowReq keeps a request the primary is expected to batch, should we
+// have to take over
+func (op *obcBatch) shadowReq(req *Request) {
+	if !op.isHotSpare() {
+		return
+	}
+	if op.spare.add(req) {
+		op.fetchPayloads([]*Request{req})
+	}
+}
+
+// takeOverSpare is called on a view change once the pending requests are
+// known. If we became the primary, it batches the shadowed requests which are
+// still outstanding, in the order we received them, and proposes them without
+// waiting for the batch timer. If we are the next primary, it starts
+// shadowing the outstanding requests.
+func (op *obcBatch) takeOverSpare() {
+	if op.spare == nil {
+		return
+	}
+	shadowed := op.spare.reqs
+	op.spare.clear()
+
+	if op.isHotSpare() {
+		for _, req := range op.reqStore.getNextNonPending(len(*op.reqStore.outstandingRequests)) {
+			op.shadowReq(req)
+		}
+		return
+	}
+
+	if op.pbft.primary(op.pbft.view) != op.pbft.id || !op.pbft.activeView || len(shadowed) == 0 {
+		return
+	}
+
+	outstanding := make(map[string]bool)
+	for _, req := range op.reqStore.getNextNonPending(len(*op.reqStore.outstandingRequests)) {
+		outstanding[hashReq(req)] = true
+	}
+	taken := 0
+	for _, req := range shadowed {
+		if !outstanding[hashReq(req)] {
+			continue
+		}
+		taken++
+		if msg := op.leaderProcReq(req); msg != nil {
+			op.manager.Inject(msg)
+		}
+	}
+	logger.Infof("Batch primary %d took over %d shadowed requests", op.pbft.id, taken)
+
+	// Propose what is left of the shadowed requests right away, they already
+	// waited for the view change
+	if len(op.batchStore) == 0 {
+		return
+	}
+	op.batchDue = true
+	op.cutBatches()
+}
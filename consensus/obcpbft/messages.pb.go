@@ -11,6 +11,7 @@ It is generated from these files:
 It has these top-level messages:
 	Message
 	Request
+	Reconfiguration
 	PrePrepare
 	Prepare
 	Commit
@@ -18,10 +19,21 @@ It has these top-level messages:
 	Checkpoint
 	ViewChange
 	PQset
+	Certificate
 	NewView
 	FetchRequest
+	RelayRequest
+	FetchCertificate
+	CommittedCertificate
+	RecoveryRequest
+	RecoveryReply
 	RequestBlock
 	BatchMessage
+	PayloadData
+	Authenticated
+	SessionKey
+	BeaconShare
+	ReadOnlyReply
 	SieveMessage
 	Execute
 	Verify
@@ -53,6 +65,11 @@ type Message struct {
 	//	*Message_NewView
 	//	*Message_FetchRequest
 	//	*Message_ReturnRequest
+	//	*Message_RelayRequest
+	//	*Message_FetchCertificate
+	//	*Message_CommittedCertificate
+	//	*Message_RecoveryRequest
+	//	*Message_RecoveryReply
 	Payload isMessage_Payload `protobuf_oneof:"payload"`
 }
 
@@ -91,16 +108,36 @@ type Message_FetchRequest struct {
 type Message_ReturnRequest struct {
 	ReturnRequest *Request `protobuf:"bytes,9,opt,name=return_request,oneof"`
 }
+type Message_RelayRequest struct {
+	RelayRequest *RelayRequest `protobuf:"bytes,10,opt,name=relay_request,oneof"`
+}
+type Message_FetchCertificate struct {
+	FetchCertificate *FetchCertificate `protobuf:"bytes,11,opt,name=fetch_certificate,oneof"`
+}
+type Message_CommittedCertificate struct {
+	CommittedCertificate *CommittedCertificate `protobuf:"bytes,12,opt,name=committed_certificate,oneof"`
+}
+type Message_RecoveryRequest struct {
+	RecoveryRequest *RecoveryRequest `protobuf:"bytes,13,opt,name=recovery_request,oneof"`
+}
+type Message_RecoveryReply struct {
+	RecoveryReply *RecoveryReply `protobuf:"bytes,14,opt,name=recovery_reply,oneof"`
+}
 
-func (*Message_Request) isMessage_Payload()       {}
-func (*Message_PrePrepare) isMessage_Payload()    {}
-func (*Message_Prepare) isMessage_Payload()       {}
-func (*Message_Commit) isMessage_Payload()        {}
-func (*Message_Checkpoint) isMessage_Payload()    {}
-func (*Message_ViewChange) isMessage_Payload()    {}
-func (*Message_NewView) isMessage_Payload()       {}
-func (*Message_FetchRequest) isMessage_Payload()  {}
-func (*Message_ReturnRequest) isMessage_Payload() {}
+func (*Message_Request) isMessage_Payload()              {}
+func (*Message_PrePrepare) isMessage_Payload()           {}
+func (*Message_Prepare) isMessage_Payload()              {}
+func (*Message_Commit) isMessage_Payload()               {}
+func (*Message_Checkpoint) isMessage_Payload()           {}
+func (*Message_ViewChange) isMessage_Payload()           {}
+func (*Message_NewView) isMessage_Payload()              {}
+func (*Message_FetchRequest) isMessage_Payload()         {}
+func (*Message_ReturnRequest) isMessage_Payload()        {}
+func (*Message_RelayRequest) isMessage_Payload()         {}
+func (*Message_FetchCertificate) isMessage_Payload()     {}
+func (*Message_CommittedCertificate) isMessage_Payload() {}
+func (*Message_RecoveryRequest) isMessage_Payload()      {}
+func (*Message_RecoveryReply) isMessage_Payload()        {}
 
 func (m *Message) GetPayload() isMessage_Payload {
 	if m != nil {
@@ -172,6 +209,41 @@ func (m *Message) GetReturnRequest() *Request {
 	return nil
 }
 
+func (m *Message) GetRelayRequest() *RelayRequest {
+	if x, ok := m.GetPayload().(*Message_RelayRequest); ok {
+		return x.RelayRequest
+	}
+	return nil
+}
+
+func (m *Message) GetFetchCertificate() *FetchCertificate {
+	if x, ok := m.GetPayload().(*Message_FetchCertificate); ok {
+		return x.FetchCertificate
+	}
+	return nil
+}
+
+func (m *Message) GetCommittedCertificate() *CommittedCertificate {
+	if x, ok := m.GetPayload().(*Message_CommittedCertificate); ok {
+		return x.CommittedCertificate
+	}
+	return nil
+}
+
+func (m *Message) GetRecoveryRequest() *RecoveryRequest {
+	if x, ok := m.GetPayload().(*Message_RecoveryRequest); ok {
+		return x.RecoveryRequest
+	}
+	return nil
+}
+
+func (m *Message) GetRecoveryReply() *RecoveryReply {
+	if x, ok := m.GetPayload().(*Message_RecoveryReply); ok {
+		return x.RecoveryReply
+	}
+	return nil
+}
+
 // XXX_OneofFuncs is for the internal use of the proto package.
 func (*Message) XXX_OneofFuncs() (func(msg proto.Message, b *proto.Buffer) error, func(msg proto.Message, tag, wire int, b *proto.Buffer) (bool, error), []interface{}) {
 	return _Message_OneofMarshaler, _Message_OneofUnmarshaler, []interface{}{
@@ -184,6 +256,11 @@ func (*Message) XXX_OneofFuncs() (func(msg proto.Message, b *proto.Buffer) error
 		(*Message_NewView)(nil),
 		(*Message_FetchRequest)(nil),
 		(*Message_ReturnRequest)(nil),
+		(*Message_RelayRequest)(nil),
+		(*Message_FetchCertificate)(nil),
+		(*Message_CommittedCertificate)(nil),
+		(*Message_RecoveryRequest)(nil),
+		(*Message_RecoveryReply)(nil),
 	}
 }
 
@@ -236,6 +313,31 @@ func _Message_OneofMarshaler(msg proto.Message, b *proto.Buffer) error {
 		if err := b.EncodeMessage(x.ReturnRequest); err != nil {
 			return err
 		}
+	case *Message_RelayRequest:
+		b.EncodeVarint(10<<3 | proto.WireBytes)
+		if err := b.EncodeMessage(x.RelayRequest); err != nil {
+			return err
+		}
+	case *Message_FetchCertificate:
+		b.EncodeVarint(11<<3 | proto.WireBytes)
+		if err := b.EncodeMessage(x.FetchCertificate); err != nil {
+			return err
+		}
+	case *Message_CommittedCertificate:
+		b.EncodeVarint(12<<3 | proto.WireBytes)
+		if err := b.EncodeMessage(x.CommittedCertificate); err != nil {
+			return err
+		}
+	case *Message_RecoveryRequest:
+		b.EncodeVarint(13<<3 | proto.WireBytes)
+		if err := b.EncodeMessage(x.RecoveryRequest); err != nil {
+			return err
+		}
+	case *Message_RecoveryReply:
+		b.EncodeVarint(14<<3 | proto.WireBytes)
+		if err := b.EncodeMessage(x.RecoveryReply); err != nil {
+			return err
+		}
 	case nil:
 	default:
 		return fmt.Errorf("Message.Payload has unexpected type %T", x)
@@ -318,6 +420,46 @@ func _Message_OneofUnmarshaler(msg proto.Message, tag, wire int, b *proto.Buffer
 		err := b.DecodeMessage(msg)
 		m.Payload = &Message_ReturnRequest{msg}
 		return true, err
+	case 10: // payload.relay_request
+		if wire != proto.WireBytes {
+			return true, proto.ErrInternalBadWireType
+		}
+		msg := new(RelayRequest)
+		err := b.DecodeMessage(msg)
+		m.Payload = &Message_RelayRequest{msg}
+		return true, err
+	case 11: // payload.fetch_certificate
+		if wire != proto.WireBytes {
+			return true, proto.ErrInternalBadWireType
+		}
+		msg := new(FetchCertificate)
+		err := b.DecodeMessage(msg)
+		m.Payload = &Message_FetchCertificate{msg}
+		return true, err
+	case 12: // payload.committed_certificate
+		if wire != proto.WireBytes {
+			return true, proto.ErrInternalBadWireType
+		}
+		msg := new(CommittedCertificate)
+		err := b.DecodeMessage(msg)
+		m.Payload = &Message_CommittedCertificate{msg}
+		return true, err
+	case 13: // payload.recovery_request
+		if wire != proto.WireBytes {
+			return true, proto.ErrInternalBadWireType
+		}
+		msg := new(RecoveryRequest)
+		err := b.DecodeMessage(msg)
+		m.Payload = &Message_RecoveryRequest{msg}
+		return true, err
+	case 14: // payload.recovery_reply
+		if wire != proto.WireBytes {
+			return true, proto.ErrInternalBadWireType
+		}
+		msg := new(RecoveryReply)
+		err := b.DecodeMessage(msg)
+		m.Payload = &Message_RecoveryReply{msg}
+		return true, err
 	default:
 		return false, nil
 	}
@@ -328,6 +470,15 @@ type Request struct {
 	Payload   []byte                     `protobuf:"bytes,2,opt,name=payload,proto3" json:"payload,omitempty"`
 	ReplicaId uint64                     `protobuf:"varint,3,opt,name=replica_id" json:"replica_id,omitempty"`
 	Signature []byte                     `protobuf:"bytes,4,opt,name=signature,proto3" json:"signature,omitempty"`
+	// Set instead of payload when the payload is too large to be ordered,
+	// the payload is then distributed off consensus and fetched by hash
+	PayloadHash []byte `protobuf:"bytes,5,opt,name=payload_hash,proto3" json:"payload_hash,omitempty"`
+	// Set instead of payload to change the number of replicas of the
+	// network, the request is then signed by the replica submitting it
+	Reconfiguration *Reconfiguration `protobuf:"bytes,6,opt,name=reconfiguration" json:"reconfiguration,omitempty"`
+	// Set for a query, which is not ordered, but executed by every replica
+	// on receipt, each sending its result back to the submitting replica
+	ReadOnly bool `protobuf:"varint,7,opt,name=read_only" json:"read_only,omitempty"`
 }
 
 func (m *Request) Reset()         { *m = Request{} }
@@ -341,6 +492,25 @@ func (m *Request) GetTimestamp() *google_protobuf.Timestamp {
 	return nil
 }
 
+func (m *Request) GetReconfiguration() *Reconfiguration {
+	if m != nil {
+		return m.Reconfiguration
+	}
+	return nil
+}
+
+type Reconfiguration struct {
+	Epoch uint64   `protobuf:"varint,1,opt,name=epoch" json:"epoch,omitempty"`
+	N     uint32   `protobuf:"varint,2,opt,name=n" json:"n,omitempty"`
+	F     uint32   `protobuf:"varint,3,opt,name=f" json:"f,omitempty"`
+	SeqNo uint64   `protobuf:"varint,4,opt,name=seqNo" json:"seqNo,omitempty"`
+	Votes []uint64 `protobuf:"varint,5,rep,packed,name=votes" json:"votes,omitempty"`
+}
+
+func (m *Reconfiguration) Reset()         { *m = Reconfiguration{} }
+func (m *Reconfiguration) String() string { return proto.CompactTextString(m) }
+func (*Reconfiguration) ProtoMessage()    {}
+
 type PrePrepare struct {
 	View           uint64   `protobuf:"varint,1,opt,name=view" json:"view,omitempty"`
 	SequenceNumber uint64   `protobuf:"varint,2,opt,name=sequence_number" json:"sequence_number,omitempty"`
@@ -376,6 +546,10 @@ type Commit struct {
 	SequenceNumber uint64 `protobuf:"varint,2,opt,name=sequence_number" json:"sequence_number,omitempty"`
 	RequestDigest  string `protobuf:"bytes,3,opt,name=request_digest" json:"request_digest,omitempty"`
 	ReplicaId      uint64 `protobuf:"varint,4,opt,name=replica_id" json:"replica_id,omitempty"`
+	// Result of the last request the sender executed, so that replicas
+	// can cross-check their execution without waiting for a checkpoint
+	ExecSequenceNumber uint64 `protobuf:"varint,5,opt,name=exec_sequence_number" json:"exec_sequence_number,omitempty"`
+	ExecId             string `protobuf:"bytes,6,opt,name=exec_id" json:"exec_id,omitempty"`
 }
 
 func (m *Commit) Reset()         { *m = Commit{} }
@@ -395,6 +569,7 @@ type Checkpoint struct {
 	SequenceNumber uint64 `protobuf:"varint,1,opt,name=sequence_number" json:"sequence_number,omitempty"`
 	ReplicaId      uint64 `protobuf:"varint,2,opt,name=replica_id" json:"replica_id,omitempty"`
 	Id             string `protobuf:"bytes,3,opt,name=id" json:"id,omitempty"`
+	Signature      []byte `protobuf:"bytes,4,opt,name=signature,proto3" json:"signature,omitempty"`
 }
 
 func (m *Checkpoint) Reset()         { *m = Checkpoint{} }
@@ -409,6 +584,9 @@ type ViewChange struct {
 	Qset      []*ViewChange_PQ `protobuf:"bytes,5,rep,name=qset" json:"qset,omitempty"`
 	ReplicaId uint64           `protobuf:"varint,6,opt,name=replica_id" json:"replica_id,omitempty"`
 	Signature []byte           `protobuf:"bytes,7,opt,name=signature,proto3" json:"signature,omitempty"`
+	// Latest view-change messages the sender received from other replicas,
+	// not covered by the signature as each of them is signed by its sender
+	Votes []*ViewChange `protobuf:"bytes,8,rep,name=votes" json:"votes,omitempty"`
 }
 
 func (m *ViewChange) Reset()         { *m = ViewChange{} }
@@ -436,6 +614,13 @@ func (m *ViewChange) GetQset() []*ViewChange_PQ {
 	return nil
 }
 
+func (m *ViewChange) GetVotes() []*ViewChange {
+	if m != nil {
+		return m.Votes
+	}
+	return nil
+}
+
 // This message should go away and become a checkpoint once replica_id is removed
 type ViewChange_C struct {
 	SequenceNumber uint64 `protobuf:"varint,1,opt,name=sequence_number" json:"sequence_number,omitempty"`
@@ -471,11 +656,45 @@ func (m *PQset) GetSet() []*ViewChange_PQ {
 	return nil
 }
 
+// certificate is the persisted form of the prepared or committed certificate
+// of a sequence number, its pre-prepare is stored without its request
+type Certificate struct {
+	PrePrepare *PrePrepare `protobuf:"bytes,1,opt,name=pre_prepare" json:"pre_prepare,omitempty"`
+	Prepare    []*Prepare  `protobuf:"bytes,2,rep,name=prepare" json:"prepare,omitempty"`
+	Commit     []*Commit   `protobuf:"bytes,3,rep,name=commit" json:"commit,omitempty"`
+}
+
+func (m *Certificate) Reset()         { *m = Certificate{} }
+func (m *Certificate) String() string { return proto.CompactTextString(m) }
+func (*Certificate) ProtoMessage()    {}
+
+func (m *Certificate) GetPrePrepare() *PrePrepare {
+	if m != nil {
+		return m.PrePrepare
+	}
+	return nil
+}
+
+func (m *Certificate) GetPrepare() []*Prepare {
+	if m != nil {
+		return m.Prepare
+	}
+	return nil
+}
+
+func (m *Certificate) GetCommit() []*Commit {
+	if m != nil {
+		return m.Commit
+	}
+	return nil
+}
+
 type NewView struct {
 	View      uint64            `protobuf:"varint,1,opt,name=view" json:"view,omitempty"`
 	Vset      []*ViewChange     `protobuf:"bytes,2,rep,name=vset" json:"vset,omitempty"`
 	Xset      map[uint64]string `protobuf:"bytes,3,rep,name=xset" json:"xset,omitempty" protobuf_key:"varint,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
 	ReplicaId uint64            `protobuf:"varint,4,opt,name=replica_id" json:"replica_id,omitempty"`
+	Signature []byte            `protobuf:"bytes,5,opt,name=signature,proto3" json:"signature,omitempty"`
 }
 
 func (m *NewView) Reset()         { *m = NewView{} }
@@ -505,8 +724,92 @@ func (m *FetchRequest) Reset()         { *m = FetchRequest{} }
 func (m *FetchRequest) String() string { return proto.CompactTextString(m) }
 func (*FetchRequest) ProtoMessage()    {}
 
+// Carries the request of a pre-prepare down the fan-out tree rooted at the
+// primary, when pre-prepares are sent without their request
+type RelayRequest struct {
+	View           uint64   `protobuf:"varint,1,opt,name=view" json:"view,omitempty"`
+	SequenceNumber uint64   `protobuf:"varint,2,opt,name=sequence_number" json:"sequence_number,omitempty"`
+	Request        *Request `protobuf:"bytes,3,opt,name=request" json:"request,omitempty"`
+	ReplicaId      uint64   `protobuf:"varint,4,opt,name=replica_id" json:"replica_id,omitempty"`
+}
+
+func (m *RelayRequest) Reset()         { *m = RelayRequest{} }
+func (m *RelayRequest) String() string { return proto.CompactTextString(m) }
+func (*RelayRequest) ProtoMessage()    {}
+
+func (m *RelayRequest) GetRequest() *Request {
+	if m != nil {
+		return m.Request
+	}
+	return nil
+}
+
+// Asks the other replicas for the sequence numbers the sender did not
+// commit, while it committed later ones
+type FetchCertificate struct {
+	SequenceNumbers []uint64 `protobuf:"varint,1,rep,packed,name=sequence_numbers" json:"sequence_numbers,omitempty"`
+	ReplicaId       uint64   `protobuf:"varint,2,opt,name=replica_id" json:"replica_id,omitempty"`
+}
+
+func (m *FetchCertificate) Reset()         { *m = FetchCertificate{} }
+func (m *FetchCertificate) String() string { return proto.CompactTextString(m) }
+func (*FetchCertificate) ProtoMessage()    {}
+
+// Answers a fetch_certificate for a sequence number the sender committed,
+// with its pre-prepare, which carries the request
+type CommittedCertificate struct {
+	PrePrepare *PrePrepare `protobuf:"bytes,1,opt,name=pre_prepare" json:"pre_prepare,omitempty"`
+	ReplicaId  uint64      `protobuf:"varint,2,opt,name=replica_id" json:"replica_id,omitempty"`
+}
+
+func (m *CommittedCertificate) Reset()         { *m = CommittedCertificate{} }
+func (m *CommittedCertificate) String() string { return proto.CompactTextString(m) }
+func (*CommittedCertificate) ProtoMessage()    {}
+
+func (m *CommittedCertificate) GetPrePrepare() *PrePrepare {
+	if m != nil {
+		return m.PrePrepare
+	}
+	return nil
+}
+
+// Sent by a replica which proactively recovers, asking the other replicas
+// for the checkpoints its state is checked against
+type RecoveryRequest struct {
+	ReplicaId uint64 `protobuf:"varint,1,opt,name=replica_id" json:"replica_id,omitempty"`
+	Nonce     uint64 `protobuf:"varint,2,opt,name=nonce" json:"nonce,omitempty"`
+}
+
+func (m *RecoveryRequest) Reset()         { *m = RecoveryRequest{} }
+func (m *RecoveryRequest) String() string { return proto.CompactTextString(m) }
+func (*RecoveryRequest) ProtoMessage()    {}
+
+// Answers a recovery_request with the checkpoints of the sender within its
+// watermarks, each signed
+type RecoveryReply struct {
+	ReplicaId   uint64        `protobuf:"varint,1,opt,name=replica_id" json:"replica_id,omitempty"`
+	Nonce       uint64        `protobuf:"varint,2,opt,name=nonce" json:"nonce,omitempty"`
+	Checkpoints []*Checkpoint `protobuf:"bytes,3,rep,name=checkpoints" json:"checkpoints,omitempty"`
+}
+
+func (m *RecoveryReply) Reset()         { *m = RecoveryReply{} }
+func (m *RecoveryReply) String() string { return proto.CompactTextString(m) }
+func (*RecoveryReply) ProtoMessage()    {}
+
+func (m *RecoveryReply) GetCheckpoints() []*Checkpoint {
+	if m != nil {
+		return m.Checkpoints
+	}
+	return nil
+}
+
 type RequestBlock struct {
 	Requests []*Request `protobuf:"bytes,1,rep,name=requests" json:"requests,omitempty"`
+	// time the primary proposed the batch at, which the replicas check
+	// against their clock
+	Timestamp *google_protobuf.Timestamp `protobuf:"bytes,2,opt,name=timestamp" json:"timestamp,omitempty"`
+	// contributions of the replicas to the random beacon
+	Beacon []*BeaconShare `protobuf:"bytes,3,rep,name=beacon" json:"beacon,omitempty"`
 }
 
 func (m *RequestBlock) Reset()         { *m = RequestBlock{} }
@@ -520,11 +823,31 @@ func (m *RequestBlock) GetRequests() []*Request {
 	return nil
 }
 
+func (m *RequestBlock) GetTimestamp() *google_protobuf.Timestamp {
+	if m != nil {
+		return m.Timestamp
+	}
+	return nil
+}
+
+func (m *RequestBlock) GetBeacon() []*BeaconShare {
+	if m != nil {
+		return m.Beacon
+	}
+	return nil
+}
+
 type BatchMessage struct {
 	// Types that are valid to be assigned to Payload:
 	//	*BatchMessage_Request
 	//	*BatchMessage_PbftMessage
 	//	*BatchMessage_Complaint
+	//	*BatchMessage_FetchPayload
+	//	*BatchMessage_PayloadData
+	//	*BatchMessage_Authenticated
+	//	*BatchMessage_SessionKey
+	//	*BatchMessage_BeaconShare
+	//	*BatchMessage_ReadOnlyReply
 	Payload isBatchMessage_Payload `protobuf_oneof:"payload"`
 }
 
@@ -545,10 +868,34 @@ type BatchMessage_PbftMessage struct {
 type BatchMessage_Complaint struct {
 	Complaint *Request `protobuf:"bytes,5,opt,name=complaint,oneof"`
 }
+type BatchMessage_FetchPayload struct {
+	FetchPayload []byte `protobuf:"bytes,6,opt,name=fetch_payload,proto3,oneof"`
+}
+type BatchMessage_PayloadData struct {
+	PayloadData *PayloadData `protobuf:"bytes,7,opt,name=payload_data,oneof"`
+}
+type BatchMessage_Authenticated struct {
+	Authenticated *Authenticated `protobuf:"bytes,8,opt,name=authenticated,oneof"`
+}
+type BatchMessage_SessionKey struct {
+	SessionKey *SessionKey `protobuf:"bytes,9,opt,name=session_key,oneof"`
+}
+type BatchMessage_BeaconShare struct {
+	BeaconShare *BeaconShare `protobuf:"bytes,10,opt,name=beacon_share,oneof"`
+}
+type BatchMessage_ReadOnlyReply struct {
+	ReadOnlyReply *ReadOnlyReply `protobuf:"bytes,11,opt,name=read_only_reply,oneof"`
+}
 
-func (*BatchMessage_Request) isBatchMessage_Payload()     {}
-func (*BatchMessage_PbftMessage) isBatchMessage_Payload() {}
-func (*BatchMessage_Complaint) isBatchMessage_Payload()   {}
+func (*BatchMessage_Request) isBatchMessage_Payload()       {}
+func (*BatchMessage_PbftMessage) isBatchMessage_Payload()   {}
+func (*BatchMessage_Complaint) isBatchMessage_Payload()     {}
+func (*BatchMessage_FetchPayload) isBatchMessage_Payload()  {}
+func (*BatchMessage_PayloadData) isBatchMessage_Payload()   {}
+func (*BatchMessage_Authenticated) isBatchMessage_Payload() {}
+func (*BatchMessage_SessionKey) isBatchMessage_Payload()    {}
+func (*BatchMessage_BeaconShare) isBatchMessage_Payload()   {}
+func (*BatchMessage_ReadOnlyReply) isBatchMessage_Payload() {}
 
 func (m *BatchMessage) GetPayload() isBatchMessage_Payload {
 	if m != nil {
@@ -578,12 +925,60 @@ func (m *BatchMessage) GetComplaint() *Request {
 	return nil
 }
 
+func (m *BatchMessage) GetFetchPayload() []byte {
+	if x, ok := m.GetPayload().(*BatchMessage_FetchPayload); ok {
+		return x.FetchPayload
+	}
+	return nil
+}
+
+func (m *BatchMessage) GetPayloadData() *PayloadData {
+	if x, ok := m.GetPayload().(*BatchMessage_PayloadData); ok {
+		return x.PayloadData
+	}
+	return nil
+}
+
+func (m *BatchMessage) GetAuthenticated() *Authenticated {
+	if x, ok := m.GetPayload().(*BatchMessage_Authenticated); ok {
+		return x.Authenticated
+	}
+	return nil
+}
+
+func (m *BatchMessage) GetSessionKey() *SessionKey {
+	if x, ok := m.GetPayload().(*BatchMessage_SessionKey); ok {
+		return x.SessionKey
+	}
+	return nil
+}
+
+func (m *BatchMessage) GetBeaconShare() *BeaconShare {
+	if x, ok := m.GetPayload().(*BatchMessage_BeaconShare); ok {
+		return x.BeaconShare
+	}
+	return nil
+}
+
+func (m *BatchMessage) GetReadOnlyReply() *ReadOnlyReply {
+	if x, ok := m.GetPayload().(*BatchMessage_ReadOnlyReply); ok {
+		return x.ReadOnlyReply
+	}
+	return nil
+}
+
 // XXX_OneofFuncs is for the internal use of the proto package.
 func (*BatchMessage) XXX_OneofFuncs() (func(msg proto.Message, b *proto.Buffer) error, func(msg proto.Message, tag, wire int, b *proto.Buffer) (bool, error), []interface{}) {
 	return _BatchMessage_OneofMarshaler, _BatchMessage_OneofUnmarshaler, []interface{}{
 		(*BatchMessage_Request)(nil),
 		(*BatchMessage_PbftMessage)(nil),
 		(*BatchMessage_Complaint)(nil),
+		(*BatchMessage_FetchPayload)(nil),
+		(*BatchMessage_PayloadData)(nil),
+		(*BatchMessage_Authenticated)(nil),
+		(*BatchMessage_SessionKey)(nil),
+		(*BatchMessage_BeaconShare)(nil),
+		(*BatchMessage_ReadOnlyReply)(nil),
 	}
 }
 
@@ -604,6 +999,34 @@ func _BatchMessage_OneofMarshaler(msg proto.Message, b *proto.Buffer) error {
 		if err := b.EncodeMessage(x.Complaint); err != nil {
 			return err
 		}
+	case *BatchMessage_FetchPayload:
+		b.EncodeVarint(6<<3 | proto.WireBytes)
+		b.EncodeRawBytes(x.FetchPayload)
+	case *BatchMessage_PayloadData:
+		b.EncodeVarint(7<<3 | proto.WireBytes)
+		if err := b.EncodeMessage(x.PayloadData); err != nil {
+			return err
+		}
+	case *BatchMessage_Authenticated:
+		b.EncodeVarint(8<<3 | proto.WireBytes)
+		if err := b.EncodeMessage(x.Authenticated); err != nil {
+			return err
+		}
+	case *BatchMessage_SessionKey:
+		b.EncodeVarint(9<<3 | proto.WireBytes)
+		if err := b.EncodeMessage(x.SessionKey); err != nil {
+			return err
+		}
+	case *BatchMessage_BeaconShare:
+		b.EncodeVarint(10<<3 | proto.WireBytes)
+		if err := b.EncodeMessage(x.BeaconShare); err != nil {
+			return err
+		}
+	case *BatchMessage_ReadOnlyReply:
+		b.EncodeVarint(11<<3 | proto.WireBytes)
+		if err := b.EncodeMessage(x.ReadOnlyReply); err != nil {
+			return err
+		}
 	case nil:
 	default:
 		return fmt.Errorf("BatchMessage.Payload has unexpected type %T", x)
@@ -637,11 +1060,115 @@ func _BatchMessage_OneofUnmarshaler(msg proto.Message, tag, wire int, b *proto.B
 		err := b.DecodeMessage(msg)
 		m.Payload = &BatchMessage_Complaint{msg}
 		return true, err
+	case 6: // payload.fetch_payload
+		if wire != proto.WireBytes {
+			return true, proto.ErrInternalBadWireType
+		}
+		x, err := b.DecodeRawBytes(true)
+		m.Payload = &BatchMessage_FetchPayload{x}
+		return true, err
+	case 7: // payload.payload_data
+		if wire != proto.WireBytes {
+			return true, proto.ErrInternalBadWireType
+		}
+		msg := new(PayloadData)
+		err := b.DecodeMessage(msg)
+		m.Payload = &BatchMessage_PayloadData{msg}
+		return true, err
+	case 8: // payload.authenticated
+		if wire != proto.WireBytes {
+			return true, proto.ErrInternalBadWireType
+		}
+		msg := new(Authenticated)
+		err := b.DecodeMessage(msg)
+		m.Payload = &BatchMessage_Authenticated{msg}
+		return true, err
+	case 9: // payload.session_key
+		if wire != proto.WireBytes {
+			return true, proto.ErrInternalBadWireType
+		}
+		msg := new(SessionKey)
+		err := b.DecodeMessage(msg)
+		m.Payload = &BatchMessage_SessionKey{msg}
+		return true, err
+	case 10: // payload.beacon_share
+		if wire != proto.WireBytes {
+			return true, proto.ErrInternalBadWireType
+		}
+		msg := new(BeaconShare)
+		err := b.DecodeMessage(msg)
+		m.Payload = &BatchMessage_BeaconShare{msg}
+		return true, err
+	case 11: // payload.read_only_reply
+		if wire != proto.WireBytes {
+			return true, proto.ErrInternalBadWireType
+		}
+		msg := new(ReadOnlyReply)
+		err := b.DecodeMessage(msg)
+		m.Payload = &BatchMessage_ReadOnlyReply{msg}
+		return true, err
 	default:
 		return false, nil
 	}
 }
 
+type PayloadData struct {
+	Hash []byte `protobuf:"bytes,1,opt,name=hash,proto3" json:"hash,omitempty"`
+	Data []byte `protobuf:"bytes,2,opt,name=data,proto3" json:"data,omitempty"`
+}
+
+func (m *PayloadData) Reset()         { *m = PayloadData{} }
+func (m *PayloadData) String() string { return proto.CompactTextString(m) }
+func (*PayloadData) ProtoMessage()    {}
+
+// A pbft message with its authenticator: the MAC of the message under the
+// session key the sender shares with each replica, indexed by replica id
+type Authenticated struct {
+	PbftMessage []byte   `protobuf:"bytes,1,opt,name=pbft_message,proto3" json:"pbft_message,omitempty"`
+	Macs        [][]byte `protobuf:"bytes,2,rep,name=macs,proto3" json:"macs,omitempty"`
+}
+
+func (m *Authenticated) Reset()         { *m = Authenticated{} }
+func (m *Authenticated) String() string { return proto.CompactTextString(m) }
+func (*Authenticated) ProtoMessage()    {}
+
+// The ephemeral public key a replica derives the session keys it shares with
+// the other replicas from, signed with its identity
+type SessionKey struct {
+	ReplicaId uint64 `protobuf:"varint,1,opt,name=replica_id" json:"replica_id,omitempty"`
+	PublicKey []byte `protobuf:"bytes,2,opt,name=public_key,proto3" json:"public_key,omitempty"`
+	Reply     bool   `protobuf:"varint,3,opt,name=reply" json:"reply,omitempty"`
+	Signature []byte `protobuf:"bytes,4,opt,name=signature,proto3" json:"signature,omitempty"`
+}
+
+func (m *SessionKey) Reset()         { *m = SessionKey{} }
+func (m *SessionKey) String() string { return proto.CompactTextString(m) }
+func (*SessionKey) ProtoMessage()    {}
+
+// The contribution of a replica to the random beacon: the anchor of a new
+// hash chain, or the preimage of the value it contributed last
+type BeaconShare struct {
+	ReplicaId uint64 `protobuf:"varint,1,opt,name=replica_id" json:"replica_id,omitempty"`
+	Value     []byte `protobuf:"bytes,2,opt,name=value,proto3" json:"value,omitempty"`
+	Anchor    bool   `protobuf:"varint,3,opt,name=anchor" json:"anchor,omitempty"`
+	Signature []byte `protobuf:"bytes,4,opt,name=signature,proto3" json:"signature,omitempty"`
+}
+
+func (m *BeaconShare) Reset()         { *m = BeaconShare{} }
+func (m *BeaconShare) String() string { return proto.CompactTextString(m) }
+func (*BeaconShare) ProtoMessage()    {}
+
+// The result of a read-only request, as executed by the sender
+type ReadOnlyReply struct {
+	RequestDigest string `protobuf:"bytes,1,opt,name=request_digest" json:"request_digest,omitempty"`
+	Result        []byte `protobuf:"bytes,2,opt,name=result,proto3" json:"result,omitempty"`
+	Error         string `protobuf:"bytes,3,opt,name=error" json:"error,omitempty"`
+}
+
+func (m *ReadOnlyReply) Reset()         { *m = ReadOnlyReply{} }
+func (m *ReadOnlyReply) String() string { return proto.CompactTextString(m) }
+func (*ReadOnlyReply) ProtoMessage()    {}
+
 type SieveMessage struct {
 	// Types that are valid to be assigned to Payload:
 	//	*SieveMessage_Request
@@ -978,8 +1505,37 @@ func (*Flush) ProtoMessage()    {}
 
 type Metadata struct {
 	SeqNo uint64 `protobuf:"varint,1,opt,name=seqNo" json:"seqNo,omitempty"`
+	// random value of the block, unset if too few replicas contributed
+	Random []byte `protobuf:"bytes,2,opt,name=random,proto3" json:"random,omitempty"`
+	// last value each replica contributed to the random beacon
+	Beacon []*BeaconShare `protobuf:"bytes,3,rep,name=beacon" json:"beacon,omitempty"`
+	// latest reconfiguration ordered, unset if the network was never reconfigured
+	Reconfiguration *Reconfiguration `protobuf:"bytes,4,opt,name=reconfiguration" json:"reconfiguration,omitempty"`
+	// reconfigurations to the next epoch requested by too few replicas yet
+	Proposals []*Reconfiguration `protobuf:"bytes,5,rep,name=proposals" json:"proposals,omitempty"`
 }
 
 func (m *Metadata) Reset()         { *m = Metadata{} }
 func (m *Metadata) String() string { return proto.CompactTextString(m) }
 func (*Metadata) ProtoMessage()    {}
+
+func (m *Metadata) GetBeacon() []*BeaconShare {
+	if m != nil {
+		return m.Beacon
+	}
+	return nil
+}
+
+func (m *Metadata) GetReconfiguration() *Reconfiguration {
+	if m != nil {
+		return m.Reconfiguration
+	}
+	return nil
+}
+
+func (m *Metadata) GetProposals() []*Reconfiguration {
+	if m != nil {
+		return m.Proposals
+	}
+	return nil
+}
@@ -0,0 +1,101 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package obcpbft
+
+import (
+	"time"
+
+	"github.com/hyperledger/fabric/core/metrics"
+	pb "github.com/hyperledger/fabric/protos"
+)
+
+// The metrics of PBFT are served by the profiling server of the peer. They
+// let operators alert on view change storms, through the rate of
+// pbft_view_changes_total, and on stalled replicas, through a low watermark
+// which stops moving while requests keep coming.
+var (
+	requestsReceived = metrics.NewCounter("pbft_requests_received_total",
+		"Transactions the peer submitted to the batch plugin.")
+	duplicateRequests = metrics.NewCounter("pbft_duplicate_requests_total",
+		"Retransmitted requests answered from the reply cache instead of ordered again.")
+	payloadsDetached = metrics.NewCounter("pbft_payloads_detached_total",
+		"Transactions above the payload threshold, pushed to the replicas and ordered by hash.")
+	payloadsFetched = metrics.NewCounter("pbft_payloads_fetched_total",
+		"Payloads the replica missed, and fetched by hash from another replica.")
+	payloadsRefused = metrics.NewCounter("pbft_payloads_refused_total",
+		"Payloads pushed to the replica before it needed them, and not kept for lack of room.")
+	certificatesFetched = metrics.NewCounter("pbft_certificates_fetched_total",
+		"Sequence numbers the replica missed the commit of, and took from f+1 replicas.")
+	tentativeExecutions = metrics.NewCounter("pbft_tentative_executions_total",
+		"Requests executed tentatively, once prepared, with speculative execution.")
+	tentativeRollbacks = metrics.NewCounter("pbft_tentative_rollbacks_total",
+		"Requests executed tentatively and rolled back, reordered by a view change or for a state transfer.")
+	recoveries = metrics.NewCounter("pbft_recoveries_total",
+		"Proactive recoveries the replica started.")
+	recoveryResyncs = metrics.NewCounter("pbft_recovery_resyncs_total",
+		"Proactive recoveries which found the replica behind or diverged, and re-fetched its state.")
+	backpressureRejections = metrics.NewCounter("pbft_backpressure_rejections_total",
+		"Transactions turned down while the replica applied backpressure.")
+	batchesOrdered = metrics.NewCounter("pbft_batches_ordered_total",
+		"Requests, batches in batch mode, committed and handed over for execution.")
+	viewChanges = metrics.NewCounter("pbft_view_changes_total",
+		"View changes the replica started.")
+	checkpoints = metrics.NewCounter("pbft_checkpoints_total",
+		"Stable checkpoints, which move the low watermark.")
+	messagesReceived = metrics.NewCounterVec("pbft_messages_received_total",
+		"PBFT messages received from other replicas, by type.", "type")
+	prepareLatency = metrics.NewHistogram("pbft_prepare_latency_seconds",
+		"Time from the pre-prepare of a sequence number to its prepare certificate.", metrics.DefaultBuckets)
+	commitLatency = metrics.NewHistogram("pbft_commit_latency_seconds",
+		"Time from the prepare certificate of a sequence number to its commit certificate.", metrics.DefaultBuckets)
+	viewGauge = metrics.NewGauge("pbft_view",
+		"Current view of the replica.")
+	lowWatermark = metrics.NewGauge("pbft_low_watermark",
+		"Sequence number of the last stable checkpoint.")
+	lastExecuted = metrics.NewGauge("pbft_last_executed",
+		"Sequence number of the last executed request.")
+	logCertificates = metrics.NewGauge("pbft_log_certificates",
+		"Certificates held in the message log, between the watermarks.")
+	logRequests = metrics.NewGauge("pbft_log_requests",
+		"Requests held in the message log.")
+	backpressureGauge = metrics.NewGauge("pbft_backpressure",
+		"1 while the replica turns down transactions, its backlog exceeding the pipelining window, 0 otherwise.")
+)
+
+// messageType returns the name of the type of msg, as used for tracing
+func messageType(msg *Message) string {
+	ev := &pb.ConsensusTraceEvent{}
+	if decodePbftTraceEvent(ev, msg) == nil {
+		return "unknown"
+	}
+	return ev.Type
+}
+
+// observeSince records the time elapsed since start, unless start is unknown,
+// as for certificates restored from the persisted state
+func observeSince(h *metrics.Histogram, start time.Time) {
+	if start.IsZero() {
+		return
+	}
+	h.Observe(time.Since(start).Seconds())
+}
+
+// updateLogMetrics records the size of the message log
+func (instance *pbftCore) updateLogMetrics() {
+	logCertificates.Set(float64(len(instance.certStore)))
+	logRequests.Set(float64(len(instance.reqStore)))
+}
@@ -0,0 +1,202 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package obcpbft
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/golang/protobuf/proto"
+)
+
+// A byzantineBehavior rewrites the messages a replica sends to another one,
+// as a faulty replica would. Behaviors are attached to a replica of a
+// pbftNetwork with byzantine, and apply to the messages it sends after the
+// filterFn of the network. As broadcasts are delivered concurrently, a
+// behavior may be invoked concurrently.
+type byzantineBehavior interface {
+	// tamper returns the messages to deliver to dst instead of msg
+	tamper(dst int, msg *Message) []*Message
+}
+
+// byzantine makes the replica with the given ID apply the behaviors to the
+// messages it sends, each to the messages the previous one returned
+func (net *pbftNetwork) byzantine(id int, behaviors ...byzantineBehavior) {
+	prev := net.tamperFn
+	net.tamperFn = func(src, dst int, payload []byte) [][]byte {
+		payloads := [][]byte{payload}
+		if prev != nil {
+			payloads = prev(src, dst, payload)
+		}
+		if src != id {
+			return payloads
+		}
+		var tampered [][]byte
+		for _, payload := range payloads {
+			msg := &Message{}
+			if err := proto.Unmarshal(payload, msg); err != nil {
+				tampered = append(tampered, payload)
+				continue
+			}
+			msgs := []*Message{msg}
+			for _, b := range behaviors {
+				var next []*Message
+				for _, msg := range msgs {
+					next = append(next, b.tamper(dst, msg)...)
+				}
+				msgs = next
+			}
+			for _, msg := range msgs {
+				raw, err := proto.Marshal(msg)
+				if err != nil {
+					panic(err)
+				}
+				tampered = append(tampered, raw)
+			}
+		}
+		return tampered
+	}
+}
+
+// targetSet holds the replicas a behavior applies to, all if empty
+type targetSet map[int]bool
+
+func newTargetSet(targets []int) targetSet {
+	ts := make(targetSet)
+	for _, id := range targets {
+		ts[id] = true
+	}
+	return ts
+}
+
+func (ts targetSet) has(id int) bool {
+	return len(ts) == 0 || ts[id]
+}
+
+// equivocation sends the targets a pre-prepare for another request than the
+// one the other replicas get, at the same view and sequence number
+type equivocation struct {
+	targets targetSet
+}
+
+func equivocatePrePrepares(targets ...int) byzantineBehavior {
+	return &equivocation{newTargetSet(targets)}
+}
+
+func (b *equivocation) tamper(dst int, msg *Message) []*Message {
+	preprep := msg.GetPrePrepare()
+	if preprep == nil || preprep.Request == nil || !b.targets.has(dst) {
+		return []*Message{msg}
+	}
+	req := *preprep.Request
+	req.Payload = append(append([]byte{}, req.Payload...), []byte("equivocation")...)
+	other := *preprep
+	other.Request = &req
+	other.RequestDigest = hashReq(&req)
+	return []*Message{{Payload: &Message_PrePrepare{PrePrepare: &other}}}
+}
+
+// commitWithholding does not send its commits to the targets
+type commitWithholding struct {
+	targets targetSet
+}
+
+func withholdCommits(targets ...int) byzantineBehavior {
+	return &commitWithholding{newTargetSet(targets)}
+}
+
+func (b *commitWithholding) tamper(dst int, msg *Message) []*Message {
+	if msg.GetCommit() != nil && b.targets.has(dst) {
+		return nil
+	}
+	return []*Message{msg}
+}
+
+// oldViewReplay sends each replica again the messages it sent in the views
+// before the one of the message it sends, once
+type oldViewReplay struct {
+	sync.Mutex
+	sent     []*Message
+	known    map[string]bool      // the messages of sent
+	replayed map[int]map[int]bool // indexes of the messages of sent replayed to each replica
+}
+
+func replayOldViews() byzantineBehavior {
+	return &oldViewReplay{known: make(map[string]bool), replayed: make(map[int]map[int]bool)}
+}
+
+// messageView returns the view a message belongs to, if any
+func messageView(msg *Message) (uint64, bool) {
+	switch {
+	case msg.GetPrePrepare() != nil:
+		return msg.GetPrePrepare().View, true
+	case msg.GetPrepare() != nil:
+		return msg.GetPrepare().View, true
+	case msg.GetCommit() != nil:
+		return msg.GetCommit().View, true
+	case msg.GetViewChange() != nil:
+		return msg.GetViewChange().View, true
+	case msg.GetNewView() != nil:
+		return msg.GetNewView().View, true
+	}
+	return 0, false
+}
+
+func (b *oldViewReplay) tamper(dst int, msg *Message) []*Message {
+	msgs := []*Message{msg}
+	view, ok := messageView(msg)
+	if !ok {
+		return msgs
+	}
+	b.Lock()
+	defer b.Unlock()
+	if b.replayed[dst] == nil {
+		b.replayed[dst] = make(map[int]bool)
+	}
+	for i, old := range b.sent {
+		if oldView, _ := messageView(old); oldView < view && !b.replayed[dst][i] {
+			msgs = append(msgs, old)
+			b.replayed[dst][i] = true
+		}
+	}
+	// A broadcast reaches the behavior once for each replica
+	if key := msg.String(); !b.known[key] {
+		b.known[key] = true
+		b.sent = append(b.sent, msg)
+	}
+	return msgs
+}
+
+// checkpointLie claims a state other than its own in its checkpoints to the
+// targets
+type checkpointLie struct {
+	targets targetSet
+}
+
+func lieAboutCheckpoints(targets ...int) byzantineBehavior {
+	return &checkpointLie{newTargetSet(targets)}
+}
+
+func (b *checkpointLie) tamper(dst int, msg *Message) []*Message {
+	chkpt := msg.GetCheckpoint()
+	if chkpt == nil || !b.targets.has(dst) {
+		return []*Message{msg}
+	}
+	lie := *chkpt
+	lie.Id = fmt.Sprintf("lie-%d", chkpt.SequenceNumber)
+	return []*Message{{Payload: &Message_Checkpoint{Checkpoint: &lie}}}
+}
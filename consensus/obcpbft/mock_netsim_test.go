@@ -0,0 +1,179 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package obcpbft
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// A netSim sits between the testnet and the endpoints, and delivers every
+// message over a simulated link instead of right away. Each link draws the
+// latency of every message from a distribution, so messages sent one after
+// the other may overtake each other, and may drop or duplicate messages.
+// Partitions cut the links between groups of replicas until they heal; the
+// messages still in flight on a cut link are lost.
+
+// latencyDist draws the latency of a message
+type latencyDist func(r *rand.Rand) time.Duration
+
+// fixedLatency delays every message by d
+func fixedLatency(d time.Duration) latencyDist {
+	return func(r *rand.Rand) time.Duration {
+		return d
+	}
+}
+
+// uniformLatency delays messages by min to max, uniformly distributed
+func uniformLatency(min, max time.Duration) latencyDist {
+	return func(r *rand.Rand) time.Duration {
+		if max <= min {
+			return min
+		}
+		return min + time.Duration(r.Int63n(int64(max-min)))
+	}
+}
+
+// normalLatency delays messages by mean, with a jitter of stddev
+func normalLatency(mean, stddev time.Duration) latencyDist {
+	return func(r *rand.Rand) time.Duration {
+		d := mean + time.Duration(r.NormFloat64()*float64(stddev))
+		if d < 0 {
+			return 0
+		}
+		return d
+	}
+}
+
+// linkProfile describes how a link delivers messages
+type linkProfile struct {
+	latency   latencyDist // nil delivers without delay
+	drop      float64     // probability a message is lost
+	duplicate float64     // probability a message is delivered twice
+}
+
+type netLink struct {
+	src, dst int
+}
+
+type netSim struct {
+	sync.Mutex
+	r       *rand.Rand
+	profile linkProfile
+	links   map[netLink]linkProfile
+	group   map[int]int // partition group of the replicas, nil when healed
+	pending int
+	closed  chan struct{}
+}
+
+// newNetSim creates a simulated network whose links all follow profile, and
+// which draws from a source seeded with seed, for the runs to be repeatable
+func newNetSim(seed int64, profile linkProfile) *netSim {
+	return &netSim{
+		r:       rand.New(rand.NewSource(seed)),
+		profile: profile,
+		links:   make(map[netLink]linkProfile),
+	}
+}
+
+// setLink overrides the profile of the link from src to dst
+func (sim *netSim) setLink(src, dst int, profile linkProfile) {
+	sim.Lock()
+	defer sim.Unlock()
+	sim.links[netLink{src, dst}] = profile
+}
+
+// partition cuts the links between the groups of replicas. A replica which
+// is not in any group is cut off from all the others.
+func (sim *netSim) partition(groups ...[]int) {
+	sim.Lock()
+	defer sim.Unlock()
+	sim.group = make(map[int]int)
+	for i, group := range groups {
+		for _, id := range group {
+			sim.group[id] = i + 1
+		}
+	}
+}
+
+// heal restores the links cut by a partition
+func (sim *netSim) heal() {
+	sim.Lock()
+	defer sim.Unlock()
+	sim.group = nil
+}
+
+func (sim *netSim) cut(src, dst int) bool {
+	if sim.group == nil || src == dst {
+		return false
+	}
+	group, ok := sim.group[src]
+	return !ok || group != sim.group[dst]
+}
+
+// send delivers a message over the link from src to dst, once its latency
+// elapsed
+func (sim *netSim) send(src, dst int, deliver func()) {
+	sim.Lock()
+	defer sim.Unlock()
+	profile, ok := sim.links[netLink{src, dst}]
+	if !ok {
+		profile = sim.profile
+	}
+	if sim.cut(src, dst) || sim.r.Float64() < profile.drop {
+		return
+	}
+	copies := 1
+	if sim.r.Float64() < profile.duplicate {
+		copies = 2
+	}
+	for i := 0; i < copies; i++ {
+		var delay time.Duration
+		if profile.latency != nil {
+			delay = profile.latency(sim.r)
+		}
+		sim.pending++
+		time.AfterFunc(delay, func() {
+			sim.Lock()
+			sim.pending--
+			lost := sim.cut(src, dst)
+			sim.Unlock()
+			select {
+			case <-sim.closed:
+				return
+			default:
+			}
+			if !lost {
+				deliver()
+			}
+		})
+	}
+}
+
+// busy returns whether messages are still in flight
+func (sim *netSim) busy() bool {
+	sim.Lock()
+	defer sim.Unlock()
+	return sim.pending > 0
+}
+
+// simulate makes the testnet deliver its messages over the simulated network
+func (net *testnet) simulate(sim *netSim) {
+	sim.closed = net.closed
+	net.sim = sim
+}
@@ -45,6 +45,8 @@ type testnet struct {
 	endpoints []endpoint
 	msgs      chan taggedMsg
 	filterFn  func(int, int, []byte) []byte
+	tamperFn  func(int, int, []byte) [][]byte
+	sim       *netSim
 }
 
 type testEndpoint struct {
@@ -181,7 +183,7 @@ func (net *testnet) deliverFilter(msg taggedMsg) {
 				net.debugMsg("TEST: Delivering %d\n", lid)
 				if payload != nil {
 					net.debugMsg("TEST: Sending message %d\n", lid)
-					lep.deliver(payload, senderHandle)
+					net.send(msg.src, lid, lep, payload, senderHandle)
 					net.debugMsg("TEST: Sent message %d\n", lid)
 				}
 			}()
@@ -195,11 +197,28 @@ func (net *testnet) deliverFilter(msg taggedMsg) {
 		}
 		if payload != nil {
 			net.debugMsg("TEST: Sending unicast\n")
-			net.endpoints[msg.dst].deliver(msg.msg, senderHandle)
+			net.send(msg.src, msg.dst, net.endpoints[msg.dst], payload, senderHandle)
 		}
 	}
 }
 
+// send delivers the payload to the endpoint, over the simulated network if
+// there is one. tamperFn may replace it with any number of payloads.
+func (net *testnet) send(src, dst int, ep endpoint, payload []byte, senderHandle *pb.PeerID) {
+	payloads := [][]byte{payload}
+	if net.tamperFn != nil {
+		payloads = net.tamperFn(src, dst, payload)
+	}
+	for _, payload := range payloads {
+		if net.sim == nil {
+			ep.deliver(payload, senderHandle)
+			continue
+		}
+		payload := payload
+		net.sim.send(src, dst, func() { ep.deliver(payload, senderHandle) })
+	}
+}
+
 func (net *testnet) processMessageFromChannel(msg taggedMsg, ok bool) bool {
 	if !ok {
 		net.debugMsg("TEST: message channel closed, exiting\n")
@@ -237,7 +256,7 @@ func (net *testnet) process() error {
 					busy = append(busy, i)
 				}
 			}
-			if len(busy) == 0 {
+			if len(busy) == 0 && (net.sim == nil || !net.sim.busy()) {
 				retry = false
 				continue
 			}
@@ -196,6 +196,7 @@ type omniProto struct {
 	getLastSeqNoImpl    func() (uint64, error)
 	validateStateImpl   func()
 	invalidateStateImpl func()
+	reconfiguredImpl    func(config *Reconfiguration)
 
 	// Closable Consenter methods
 	RecvMsgImpl func(ocMsg *pb.Message, senderHandle *pb.PeerID) error
@@ -575,6 +576,13 @@ func (op *omniProto) invalidateState() {
 	}
 	panic("unimplemented")
 }
+func (op *omniProto) reconfigured(config *Reconfiguration) {
+	if nil != op.reconfiguredImpl {
+		op.reconfiguredImpl(config)
+		return
+	}
+	panic("unimplemented")
+}
 func (op *omniProto) Commit(tag interface{}, meta []byte) {
 	if nil != op.CommitImpl {
 		op.CommitImpl(tag, meta)
@@ -22,6 +22,7 @@ import (
 
 	"github.com/hyperledger/fabric/consensus"
 	"github.com/hyperledger/fabric/consensus/obcpbft/events"
+	"github.com/hyperledger/fabric/core/chaos"
 	pb "github.com/hyperledger/fabric/protos"
 
 	"google/protobuf"
@@ -38,10 +39,17 @@ type obcBatch struct {
 	broadcaster *broadcaster
 
 	batchSize        int
+	batchBytes       int // Size in bytes of the requests at which a batch is cut, 0 for no limit
 	batchStore       []*Request
 	batchTimer       events.Timer
 	batchTimerActive bool
 	batchTimeout     time.Duration
+	adaptiveTimeout  *batchTimeoutAdaptor // nil unless the batch timeout adapts to the load
+	fairness         *batchFairness       // nil unless batches are cut fairly between chaincodes
+	spare            *hotSpare            // nil unless the next primary shadows the requests of the primary
+	batchDue         bool                 // the batch timer expired while we could not order a batch
+	backpressured    int32                // 1 while transactions are turned down, accessed atomically
+	maxClockSkew     time.Duration        // How far the timestamp of a batch may be from our clock, 0 for any
 
 	manager events.Manager // TODO, remove eventually, the event manager
 
@@ -49,6 +57,29 @@ type obcBatch struct {
 	idleChan     chan struct{}      // Idle channel, to be removed
 
 	reqStore *requestStore // Holds the outstanding and pending requests
+	replies  *replyCache   // Answers the requests which already executed
+
+	payloadThreshold    int           // Size above which payloads are distributed off consensus, 0 to order all payloads
+	payloadFetchTimeout time.Duration // How long a replica may take to deliver a payload we fetch
+	payloadTimer        events.Timer
+	payloadTimerActive  bool
+	payloads            *payloadStore
+	payloadWaiting      []*Request // Requests the primary holds back until it has their payload
+	pendingExec         *execInfo  // Execution waiting for its payloads
+
+	tentative *tentativeBatch // batch executing ahead of its commit certificate, nil if none
+
+	auth *authenticator // nil unless pbft messages are authenticated
+
+	verifier *verifier // nil unless workers check the authenticators of the consensus messages
+
+	rejects *rejectStore // nil unless malformed messages are kept
+
+	beacon *randomBeacon // nil unless blocks get a random value
+
+	readOnly *readOnlyQueries // nil unless queries are answered by f+1 replicas
+
+	tracer *tracer
 
 	persistForward
 }
@@ -72,12 +103,17 @@ type batchMessageEvent batchMessage
 type batchTimerEvent struct{}
 
 func newObcBatch(id uint64, config *viper.Viper, stack consensus.Stack) *obcBatch {
-	var err error
+	op := &obcBatch{}
+	op.init(id, config, stack)
+	op.Start()
+	return op
+}
 
-	op := &obcBatch{
-		obcGeneric: obcGeneric{stack: stack},
-	}
+// init sets the replica up, Start starts its event manager
+func (op *obcBatch) init(id uint64, config *viper.Viper, stack consensus.Stack) {
+	var err error
 
+	op.obcGeneric = obcGeneric{stack: stack}
 	op.persistForward.persistor = stack
 
 	logger.Debugf("Replica %d obtaining startup information", id)
@@ -86,7 +122,6 @@ func newObcBatch(id uint64, config *viper.Viper, stack consensus.Stack) *obcBatc
 	op.manager.SetReceiver(op)
 	etf := events.NewTimerFactoryImpl(op.manager)
 	op.pbft = newPbftCore(id, config, op, etf)
-	op.manager.Start()
 	op.externalEventReceiver.manager = op.manager
 	op.broadcaster = newBroadcaster(id, op.pbft.N, op.pbft.f, stack)
 
@@ -96,24 +131,134 @@ func newObcBatch(id uint64, config *viper.Viper, stack consensus.Stack) *obcBatc
 	if err != nil {
 		panic(fmt.Errorf("Cannot parse batch timeout: %s", err))
 	}
+	op.batchBytes = config.GetInt("general.batchbytes")
 	logger.Infof("PBFT Batch size = %d", op.batchSize)
+	if op.batchBytes > 0 {
+		logger.Infof("PBFT Batch size in bytes = %d", op.batchBytes)
+	}
 	logger.Infof("PBFT Batch timeout = %v", op.batchTimeout)
 
+	op.adaptiveTimeout = newBatchTimeoutAdaptor(config)
+	if op.adaptiveTimeout != nil {
+		logger.Infof("PBFT Batch timeout adapted to the load, between %v and %v", op.adaptiveTimeout.min, op.adaptiveTimeout.max)
+	}
+
+	op.maxClockSkew = parseMaxClockSkew(config)
+	logger.Infof("PBFT Batch maximum clock skew = %v", op.maxClockSkew)
+
+	op.fairness = newBatchFairness(config)
+	if op.fairness != nil {
+		logger.Infof("PBFT Batch cut fairly between chaincodes, weights = %v", op.fairness.weights)
+	}
+
+	op.spare = newHotSpare(config)
+	if op.spare != nil {
+		logger.Infof("PBFT Batch next primary shadows the requests of the primary")
+	}
+
+	op.payloadThreshold = config.GetInt("general.payloadthreshold")
+	op.payloadFetchTimeout, err = time.ParseDuration(config.GetString("general.timeout.payloadfetch"))
+	if err != nil {
+		panic(fmt.Errorf("Cannot parse payload fetch timeout: %s", err))
+	}
+	if op.payloadThreshold > 0 {
+		logger.Infof("PBFT Batch payloads above %d bytes distributed off consensus", op.payloadThreshold)
+	}
+	logger.Infof("PBFT Batch payload fetch timeout = %v", op.payloadFetchTimeout)
+
+	op.auth = newAuthenticator(id, op.pbft.N, config)
+	if op.auth != nil {
+		logger.Infof("PBFT Batch messages between replicas authenticated")
+	}
+
+	op.verifier = newVerifier(id, config, stack, op.auth, op.manager.Queue())
+	if op.verifier != nil {
+		logger.Infof("PBFT Batch authenticators checked by %d workers", len(op.verifier.queues))
+	}
+
+	op.rejects = newRejectStore(config)
+	if op.rejects != nil {
+		logger.Infof("PBFT Batch keeping up to %d malformed messages in %s", op.rejects.max, op.rejects.dir)
+	}
+
+	op.beacon = newRandomBeacon(id, op.pbft.N, op.pbft.f, config)
+	if op.beacon != nil {
+		logger.Infof("PBFT Batch random beacon with hash chains of %d values", op.beacon.length)
+	}
+
+	op.readOnly = newReadOnlyQueries(config)
+	if op.readOnly != nil {
+		logger.Infof("PBFT Batch queries answered by f+1 replicas, read-only timeout = %v", op.readOnly.timeout)
+	}
+
+	// The network may have been reconfigured before we restarted
+	op.pbft.scheduleReconfiguration(op.headReconfiguration())
+
 	op.incomingChan = make(chan *batchMessage)
 
 	op.batchTimer = etf.CreateTimer()
+	op.payloadTimer = etf.CreateTimer()
+	op.payloads = newPayloadStore(config.GetInt("general.unsolicitedpayloads.count"), config.GetInt("general.unsolicitedpayloads.bytes"))
+	op.tracer = newTracer()
 
 	op.reqStore = newRequestStore()
+	op.replies = newReplyCache()
 
 	op.idleChan = make(chan struct{})
 	close(op.idleChan) // TODO remove eventually
 
-	return op
+	chaos.RegisterViewChangeTrigger(func() {
+		op.manager.Queue() <- viewChangeTimerEvent{}
+	})
+}
+
+// Init sets the replica up as the validator of stack. PBFT arms its timers on
+// its event manager, so that their expirations are ordered with the messages,
+// rather than with timers.
+func (op *obcBatch) Init(stack consensus.Stack, timers consensus.TimerFactory) error {
+	id, err := getStackValidatorID(stack)
+	if err != nil {
+		return err
+	}
+	op.init(id, config, stack)
+	return nil
+}
+
+// ValidateConfig checks the PBFT configuration before the peer starts
+func (op *obcBatch) ValidateConfig() error {
+	if err := validateConfig(config); err != nil {
+		return err
+	}
+	if _, err := time.ParseDuration(config.GetString("general.timeout.batch")); err != nil {
+		return fmt.Errorf("Cannot parse batch timeout: %s", err)
+	}
+	return nil
+}
+
+// Start starts processing the messages and events of the replica
+func (op *obcBatch) Start() error {
+	op.manager.Start()
+	if op.auth != nil {
+		op.manager.Queue() <- sessionKeyEvent{}
+	}
+	if op.beacon != nil {
+		op.manager.Queue() <- beaconEvent{}
+	}
+	return nil
+}
+
+// Stop releases the resources of the replica
+func (op *obcBatch) Stop() {
+	op.Close()
 }
 
 // Close tells us to release resources we are holding
 func (op *obcBatch) Close() {
+	if op.verifier != nil {
+		op.verifier.halt()
+	}
 	op.batchTimer.Halt()
+	op.payloadTimer.Halt()
 	op.pbft.close()
 }
 
@@ -123,6 +268,7 @@ func (op *obcBatch) submitToLeader(req *Request) events.Event {
 
 	op.logAddTxFromRequest(req)
 	op.reqStore.storeOutstanding(req)
+	op.updateBackpressure()
 
 	// if we believe we are the leader, then process this request
 	leader := op.pbft.primary(op.pbft.view)
@@ -131,12 +277,14 @@ func (op *obcBatch) submitToLeader(req *Request) events.Event {
 	}
 
 	op.reqStore.storeOutstanding(req)
+	op.shadowReq(req)
 	op.startTimerIfOutstandingRequests()
 
 	return nil
 }
 
 func (op *obcBatch) broadcastMsg(msg *BatchMessage) {
+	op.tracer.trace(true, traceBroadcast, msg)
 	msgPayload, _ := proto.Marshal(msg)
 	ocMsg := &pb.Message{
 		Type:    pb.Message_CONSENSUS,
@@ -147,6 +295,7 @@ func (op *obcBatch) broadcastMsg(msg *BatchMessage) {
 
 // send a message to a specific replica
 func (op *obcBatch) unicastMsg(msg *BatchMessage, receiverID uint64) {
+	op.tracer.trace(true, int64(receiverID), msg)
 	msgPayload, _ := proto.Marshal(msg)
 	ocMsg := &pb.Message{
 		Type:    pb.Message_CONSENSUS,
@@ -161,11 +310,13 @@ func (op *obcBatch) unicastMsg(msg *BatchMessage, receiverID uint64) {
 
 // multicast a message to all replicas
 func (op *obcBatch) broadcast(msgPayload []byte) {
+	op.tracer.trace(true, traceBroadcast, &BatchMessage{&BatchMessage_PbftMessage{msgPayload}})
 	op.broadcaster.Broadcast(op.wrapMessage(msgPayload))
 }
 
 // send a message to a specific replica
 func (op *obcBatch) unicast(msgPayload []byte, receiverID uint64) (err error) {
+	op.tracer.trace(true, int64(receiverID), &BatchMessage{&BatchMessage_PbftMessage{msgPayload}})
 	return op.broadcaster.Unicast(op.wrapMessage(msgPayload), receiverID)
 }
 
@@ -175,6 +326,11 @@ func (op *obcBatch) sign(msg []byte) ([]byte, error) {
 
 // verify message signature
 func (op *obcBatch) verify(senderID uint64, signature []byte, message []byte) error {
+	if op.verifier != nil {
+		if checked, err := op.verifier.lookup(senderID, signature, message); checked {
+			return err
+		}
+	}
 	senderHandle, err := getValidatorHandle(senderID)
 	if err != nil {
 		return err
@@ -182,9 +338,35 @@ func (op *obcBatch) verify(senderID uint64, signature []byte, message []byte) er
 	return op.stack.Verify(senderHandle, signature, message)
 }
 
-// validate checks whether the request is valid syntactically
-// not used in obc-batch at the moment
+// validate checks that the timestamp of the batch agrees with our clock,
+// and whether we hold all payloads of the batch, fetching those we miss
 func (op *obcBatch) validate(txRaw []byte) error {
+	reqs := &RequestBlock{}
+	if err := proto.Unmarshal(txRaw, reqs); err != nil {
+		return nil // execute deals with it
+	}
+	if reqs.Timestamp != nil {
+		if err := checkClockSkew(reqs.Timestamp, time.Now(), op.maxClockSkew); err != nil {
+			// Replace the primary if its clock is off
+			op.pbft.softStartTimer(op.pbft.requestTimeout, "skewed batch timestamp")
+			return err
+		}
+	}
+	for _, req := range reqs.Requests {
+		if req.Reconfiguration == nil {
+			continue
+		}
+		if err := op.validReconfiguration(req); err != nil {
+			// Replace the primary if it orders a forged reconfiguration
+			op.pbft.softStartTimer(op.pbft.requestTimeout, "invalid reconfiguration")
+			return err
+		}
+	}
+	if op.fetchPayloads(reqs.Requests) {
+		// Replace the primary if the payloads cannot be found
+		op.pbft.softStartTimer(op.pbft.requestTimeout, "missing payloads")
+		return errMissingPayloads
+	}
 	return nil
 }
 
@@ -196,56 +378,174 @@ func (op *obcBatch) execute(seqNo uint64, raw []byte) {
 		return
 	}
 
+	if op.fetchPayloads(reqs.Requests) {
+		logger.Debugf("Batch replica %d waiting for payloads to execute seqNo %d", op.pbft.id, seqNo)
+		op.pendingExec = &execInfo{seqNo, raw}
+		return
+	}
+	// Payloads are kept for a log size, so that lagging replicas can
+	// still fetch them
+	if seqNo > op.pbft.L {
+		op.payloads.collect(seqNo - op.pbft.L)
+	}
+
 	var txs []*pb.Transaction
+	head := op.headMetadata()
+	config, proposals := head.Reconfiguration, head.Proposals
+
+	// A batch executing tentatively may still be rolled back, its requests
+	// remain outstanding until it commits
+	tentative := op.tentative != nil && op.tentative.seqNo == seqNo
 
 	for _, req := range reqs.Requests {
+		if !tentative {
+			op.requestExecuted(req, seqNo)
+		}
 
-		tx := &pb.Transaction{}
-		if err := proto.Unmarshal(req.Payload, tx); err != nil {
-			logger.Warningf("Batch replica %d could not unmarshal transaction: %s", op.pbft.id, err)
+		if req.Reconfiguration != nil {
+			config, proposals = op.executeReconfiguration(seqNo, req, config, proposals)
 			continue
 		}
 
-		// TODO, this is a really and inefficient way to do this, but because reqs aren't comparable, they cannot be retrieved from the map directly
-		logger.Debugf("Batch replica %d executing request with transaction %s from outstandingReqs", op.pbft.id, tx.Uuid)
+		payload := req.Payload
+		if req.PayloadHash != nil {
+			payload = op.payloads.get(req.PayloadHash)
+		}
 
-		if outstanding, pending := op.reqStore.remove(req); !outstanding || !pending {
-			logger.Debugf("Batch replica %d missing transaction %s outstanding=%v, pending=%v", op.pbft.id, tx.Uuid, outstanding, pending)
+		tx := &pb.Transaction{}
+		if err := proto.Unmarshal(payload, tx); err != nil {
+			logger.Warningf("Batch replica %d could not unmarshal transaction: %s", op.pbft.id, err)
+			continue
 		}
+
+		logger.Debugf("Batch replica %d executing request with transaction %s", op.pbft.id, tx.Uuid)
 		txs = append(txs, tx)
 	}
 
-	meta, _ := proto.Marshal(&Metadata{seqNo})
+	op.replies.prune(op.pbft.h)
+	op.updateBackpressure()
+
+	metadata := &Metadata{SeqNo: seqNo, Reconfiguration: config, Proposals: proposals}
+	op.executeBeacon(metadata, reqs.Beacon)
+	meta, _ := proto.Marshal(metadata)
 
 	logger.Debugf("Batch replica %d received exec for seqNo %d containing %d transactions", op.pbft.id, seqNo, len(txs))
 
+	if clock, ok := op.stack.(consensus.BatchClock); ok {
+		var timestamp *google_protobuf.Timestamp
+		if reqs.Timestamp != nil {
+			timestamp = batchTime(reqs.Timestamp, op.headTimestamp())
+		}
+		clock.SetBatchTimestamp(timestamp)
+	}
+
 	op.stack.Execute(meta, txs) // This executes in the background, we will receive an executedEvent once it completes
 }
 
+// requestExecuted records that req executed at seqNo, and forgets it as
+// outstanding
+func (op *obcBatch) requestExecuted(req *Request, seqNo uint64) {
+	op.replies.executed(req, seqNo)
+	if req.PayloadHash != nil {
+		op.payloads.executedAt(req.PayloadHash, seqNo)
+	}
+	// TODO, this is a really and inefficient way to do this, but because reqs aren't comparable, they cannot be retrieved from the map directly
+	if outstanding, pending := op.reqStore.remove(req); !outstanding || !pending {
+		logger.Debugf("Batch replica %d missing request %s outstanding=%v, pending=%v", op.pbft.id, hashReq(req), outstanding, pending)
+	}
+	if op.spare != nil {
+		op.spare.remove(req)
+	}
+}
+
 // =============================================================================
 // functions specific to batch mode
 // =============================================================================
 
+// headTimestamp returns the timestamp of the block at the head of the chain,
+// nil if it has none
+func (op *obcBatch) headTimestamp() *google_protobuf.Timestamp {
+	size := op.stack.GetBlockchainSize()
+	if size == 0 {
+		return nil
+	}
+	block, err := op.stack.GetBlock(size - 1)
+	if err != nil {
+		logger.Warningf("Batch replica %d could not get the block at the head of the chain: %s", op.pbft.id, err)
+		return nil
+	}
+	return block.Timestamp
+}
+
 func (op *obcBatch) leaderProcReq(req *Request) events.Event {
 	// XXX check req sig
 
 	hash := hashReq(req)
 
+	if op.adaptiveTimeout != nil {
+		op.adaptiveTimeout.arrived(time.Now())
+	}
+
+	if op.fetchPayloads([]*Request{req}) {
+		logger.Debugf("Batch primary %d holding back request %s until it has its payload", op.pbft.id, hash)
+		op.reqStore.storePending(req)
+		op.payloadWaiting = append(op.payloadWaiting, req)
+		return nil
+	}
+
 	logger.Debugf("Batch primary %d queueing new request %s", op.pbft.id, hash)
 	op.batchStore = append(op.batchStore, req)
 	op.reqStore.storePending(req)
+	op.updateBackpressure()
 
 	if !op.batchTimerActive {
 		op.startBatchTimer()
 	}
 
-	if len(op.batchStore) >= op.batchSize {
+	if op.fairness != nil || !op.canOrder() {
+		op.cutBatches()
+		return nil
+	}
+
+	if op.batchFull() {
 		return op.sendBatch()
 	}
 
 	return nil
 }
 
+// batchFull reports whether the primary holds enough requests to cut a batch,
+// by count or by size
+func (op *obcBatch) batchFull() bool {
+	if len(op.batchStore) >= op.batchSize {
+		return true
+	}
+	return op.batchBytes > 0 && requestsSize(op.batchStore) >= op.batchBytes
+}
+
+// cutBatchBytes splits batch after as many requests as fit in the size limit
+// of a batch, at least one
+func (op *obcBatch) cutBatchBytes(batch []*Request) ([]*Request, []*Request) {
+	if op.batchBytes <= 0 {
+		return batch, nil
+	}
+	size := 0
+	for i, req := range batch {
+		size += len(req.Payload)
+		if i > 0 && size > op.batchBytes {
+			return batch[:i], batch[i:]
+		}
+	}
+	return batch, nil
+}
+
+func requestsSize(reqs []*Request) (size int) {
+	for _, req := range reqs {
+		size += len(req.Payload)
+	}
+	return size
+}
+
 func (op *obcBatch) sendBatch() events.Event {
 	op.stopBatchTimer()
 
@@ -254,10 +554,27 @@ func (op *obcBatch) sendBatch() events.Event {
 		return nil
 	}
 
-	earliestRequest := op.batchStore[0]
-
-	reqBlock := &RequestBlock{op.batchStore}
+	batch := op.batchStore
 	op.batchStore = nil
+	op.batchDue = false
+	if op.fairness != nil {
+		batch, op.batchStore = op.fairness.cut(batch, op.batchSize, op.chaincodeOf)
+	} else if len(batch) > op.batchSize {
+		// Requests were kept while the window was full
+		batch, op.batchStore = batch[:op.batchSize], batch[op.batchSize:]
+	}
+	batch, over := op.cutBatchBytes(batch)
+	op.batchStore = append(over, op.batchStore...)
+	if len(op.batchStore) > 0 {
+		op.startBatchTimer()
+	}
+
+	earliestRequest := batch[0]
+
+	reqBlock := &RequestBlock{Requests: batch, Timestamp: toTimestamp(time.Now())}
+	if op.beacon != nil {
+		reqBlock.Beacon = op.beacon.pending()
+	}
 
 	reqsPacked, err := proto.Marshal(reqBlock)
 	if err != nil {
@@ -292,8 +609,16 @@ func (op *obcBatch) txToReq(tx []byte) *Request {
 }
 
 func (op *obcBatch) processMessage(ocMsg *pb.Message, senderHandle *pb.PeerID) events.Event {
+	return op.processCheckedMessage(ocMsg, senderHandle, false)
+}
+
+// processCheckedMessage handles a message, whose MAC a verification worker
+// already checked if authenticated is set
+func (op *obcBatch) processCheckedMessage(ocMsg *pb.Message, senderHandle *pb.PeerID, authenticated bool) events.Event {
 	if ocMsg.Type == pb.Message_CHAIN_TRANSACTION {
+		requestsReceived.Inc()
 		req := op.txToReq(ocMsg.Payload)
+		op.detachPayload(req)
 		return op.submitToLeader(req)
 	}
 
@@ -302,30 +627,72 @@ func (op *obcBatch) processMessage(ocMsg *pb.Message, senderHandle *pb.PeerID) e
 		return nil
 	}
 
-	batchMsg := &BatchMessage{}
-	err := proto.Unmarshal(ocMsg.Payload, batchMsg)
+	batchMsg, msg, err := op.unpackMessage(ocMsg.Payload, senderHandle)
 	if err != nil {
-		logger.Errorf("Error unmarshaling message: %s", err)
+		logger.Errorf("Replica %d rejecting message from %v: %s", op.pbft.id, senderHandle, err)
+		if op.rejects != nil {
+			op.rejects.capture(ocMsg.Payload, senderHandle, err)
+		}
 		return nil
 	}
 
+	if op.tracer.active() {
+		if senderID, err := getValidatorID(senderHandle); err == nil {
+			op.tracer.trace(false, int64(senderID), batchMsg)
+		}
+	}
+
 	if req := batchMsg.GetRequest(); req != nil {
+		if req.ReadOnly {
+			op.recvReadOnlyRequest(req, senderHandle)
+			return nil
+		}
+		if seqNo, executed := op.replies.lookup(req); executed {
+			// A retransmission, ordering it again would execute it twice
+			duplicateRequests.Inc()
+			logger.Debugf("Batch replica %d answering request %s of replica %d from the reply cache, executed at seqNo %d",
+				op.pbft.id, hashReq(req), req.ReplicaId, seqNo)
+			return nil
+		}
+		if req.Reconfiguration != nil {
+			if err := op.validReconfiguration(req); err != nil {
+				logger.Warningf("Batch replica %d dropping request: %s", op.pbft.id, err)
+				return nil
+			}
+		}
 		if (op.pbft.primary(op.pbft.view) == op.pbft.id) && op.pbft.activeView {
 			return op.leaderProcReq(req)
 		}
 		op.logAddTxFromRequest(req)
 		op.reqStore.storeOutstanding(req)
+		op.updateBackpressure()
+		op.shadowReq(req)
 		op.startTimerIfOutstandingRequests()
 		return nil
-	} else if pbftMsg := batchMsg.GetPbftMessage(); pbftMsg != nil {
-		senderID, err := getValidatorID(senderHandle) // who sent this?
-		if err != nil {
-			panic("Cannot map sender's PeerID to a valid replica ID")
+	} else if hash := batchMsg.GetFetchPayload(); hash != nil {
+		op.recvFetchPayload(hash, senderHandle)
+		return nil
+	} else if data := batchMsg.GetPayloadData(); data != nil {
+		return op.recvPayloadData(data)
+	} else if sk := batchMsg.GetSessionKey(); sk != nil {
+		op.recvSessionKey(sk, senderHandle)
+		return nil
+	} else if share := batchMsg.GetBeaconShare(); share != nil {
+		op.recvBeaconShare(share, senderHandle)
+		return nil
+	} else if reply := batchMsg.GetReadOnlyReply(); reply != nil {
+		if senderID, err := getValidatorID(senderHandle); err == nil {
+			op.recvReadOnlyReply(reply, senderID)
 		}
-		msg := &Message{}
-		err = proto.Unmarshal(pbftMsg, msg)
-		if err != nil {
-			logger.Errorf("Error unpacking payload from message: %s", err)
+		return nil
+	} else if msg != nil {
+		senderID, _ := getValidatorID(senderHandle) // checked by unpackMessage
+		if auth := batchMsg.GetAuthenticated(); auth != nil {
+			if !authenticated && op.recvAuthenticated(auth, senderID) == nil {
+				return nil
+			}
+		} else if op.auth != nil {
+			logger.Warningf("Replica %d dropping unauthenticated message from replica %d", op.pbft.id, senderID)
 			return nil
 		}
 		return pbftMessageEvent{
@@ -339,6 +706,43 @@ func (op *obcBatch) processMessage(ocMsg *pb.Message, senderHandle *pb.PeerID) e
 	return nil
 }
 
+// unpackMessage unmarshals a consensus message, and the pbft message it
+// carries, if any, which it checks comes from the sender
+func (op *obcBatch) unpackMessage(payload []byte, senderHandle *pb.PeerID) (*BatchMessage, *Message, error) {
+	batchMsg := &BatchMessage{}
+	if err := proto.Unmarshal(payload, batchMsg); err != nil {
+		return nil, nil, fmt.Errorf("Error unmarshaling message: %s", err)
+	}
+	if batchMsg.Payload == nil {
+		return nil, nil, fmt.Errorf("Unknown request: %+v", batchMsg)
+	}
+	pbftMsg := op.getPbftMessage(batchMsg)
+	if pbftMsg == nil {
+		return batchMsg, nil, nil
+	}
+	senderID, err := getValidatorID(senderHandle) // who sent this?
+	if err != nil {
+		return nil, nil, fmt.Errorf("Cannot map sender's PeerID to a valid replica ID: %s", err)
+	}
+	msg := &Message{}
+	if err := proto.Unmarshal(pbftMsg, msg); err != nil {
+		return nil, nil, fmt.Errorf("Error unpacking payload from message: %s", err)
+	}
+	if _, err := op.pbft.recvMsg(msg, senderID); err != nil {
+		return nil, nil, err
+	}
+	return batchMsg, msg, nil
+}
+
+// getPbftMessage returns the pbft message of a batch message, authenticated
+// or not
+func (op *obcBatch) getPbftMessage(batchMsg *BatchMessage) []byte {
+	if auth := batchMsg.GetAuthenticated(); auth != nil {
+		return auth.PbftMessage
+	}
+	return batchMsg.GetPbftMessage()
+}
+
 func (op *obcBatch) logAddTxFromRequest(req *Request) {
 	if logger.IsEnabledFor(logging.DEBUG) {
 		// This is potentially a very large expensive debug statement, guard
@@ -360,6 +764,9 @@ func (op *obcBatch) resubmitOutstandingReqs() events.Event {
 	// Do not enter while an execution is in progress to prevent duplicating a request
 	if op.pbft.primary(op.pbft.view) == op.pbft.id && op.pbft.activeView && op.pbft.currentExec == nil {
 		needed := op.batchSize - len(op.batchStore)
+		if needed < 1 {
+			needed = 1 // with fair batching we may keep more than a batch
+		}
 
 		for op.reqStore.hasNonPending() {
 			outstanding := op.reqStore.getNextNonPending(needed)
@@ -382,19 +789,52 @@ func (op *obcBatch) ProcessEvent(event events.Event) events.Event {
 	case batchMessageEvent:
 		ocMsg := et
 		return op.processMessage(ocMsg.msg, ocMsg.sender)
+	case verifiedMessageEvent:
+		return op.processCheckedMessage(et.msg, et.sender, et.authenticated)
+	case sessionKeyEvent:
+		if msg := op.sessionKey(true); msg != nil {
+			op.broadcastMsg(msg)
+		}
+	case beaconEvent:
+		op.sendBeaconShare(op.headBeaconState(), false)
 	case executedEvent:
+		if op.tentative != nil {
+			op.tentativeExecuted(et.tag.([]byte))
+			return nil
+		}
 		op.stack.Commit(nil, et.tag.([]byte))
 	case committedEvent:
 		logger.Debugf("Replica %d received committedEvent", op.pbft.id)
 		return execDoneEvent{}
+	case rolledBackEvent:
+		logger.Debugf("Replica %d received rolledBackEvent", op.pbft.id)
+		op.tentative = nil
+		if res := op.pbft.ProcessEvent(event); res != nil {
+			return res
+		}
+		return op.resubmitOutstandingReqs()
 	case execDoneEvent:
 		if res := op.pbft.ProcessEvent(event); res != nil {
 			// This may trigger a view change, if so, process it, we will resubmit on new view
 			return res
 		}
-		return op.resubmitOutstandingReqs()
+		res := op.resubmitOutstandingReqs()
+		op.cutBatches()
+		return res
+	case payloadTimerEvent:
+		op.payloadTimerExpired()
 	case batchTimerEvent:
 		logger.Infof("Replica %d batch timer expired", op.pbft.id)
+		if op.fairness != nil || (op.pbft.activeView && !op.canOrder()) {
+			op.batchTimerActive = false
+			op.batchDue = true
+			op.cutBatches()
+			if len(op.batchStore) > 0 && !op.batchTimerActive {
+				// Retry, should we not get to order the batch otherwise
+				op.startBatchTimer()
+			}
+			return nil
+		}
 		if op.pbft.activeView && (len(op.batchStore) > 0) {
 			return op.sendBatch()
 		}
@@ -408,6 +848,7 @@ func (op *obcBatch) ProcessEvent(event events.Event) events.Event {
 		if op.batchTimerActive {
 			op.stopBatchTimer()
 		}
+		op.payloadWaiting = nil
 
 		op.reqStore.pendingRequests.empty()
 		for i := op.pbft.h + 1; i <= op.pbft.h+op.pbft.L; i++ {
@@ -439,21 +880,46 @@ func (op *obcBatch) ProcessEvent(event events.Event) events.Event {
 			op.reqStore.storePendings(reqs.Requests)
 		}
 
+		op.takeOverSpare()
+		if op.beacon != nil {
+			// The new primary may not hold our share
+			op.sendBeaconShare(op.headBeaconState(), true)
+		}
 		return op.resubmitOutstandingReqs()
 	case stateUpdatedEvent:
 		// When the state is updated, clear any outstanding requests, they may have been processed while we were gone
 		op.reqStore = newRequestStore()
+		op.tentative = nil
+		op.updateBackpressure()
+		// The reconfigurations we missed take effect as the watermarks move
+		op.pbft.scheduleReconfiguration(op.headReconfiguration())
+		if op.spare != nil {
+			op.spare.clear()
+		}
+		if op.beacon != nil {
+			op.sendBeaconShare(op.headBeaconState(), false)
+		}
 		return op.pbft.ProcessEvent(event)
 	default:
-		return op.pbft.ProcessEvent(event)
+		h := op.pbft.h
+		res := op.pbft.ProcessEvent(event)
+		if op.pbft.h != h {
+			// A checkpoint moved the window, the kept requests may be ordered
+			op.cutBatches()
+		}
+		return res
 	}
 
 	return nil
 }
 
 func (op *obcBatch) startBatchTimer() {
-	op.batchTimer.Reset(op.batchTimeout, batchTimerEvent{})
-	logger.Debugf("Replica %d started the batch timer", op.pbft.id)
+	timeout := op.batchTimeout
+	if op.adaptiveTimeout != nil {
+		timeout = op.adaptiveTimeout.timeout(op.batchSize - len(op.batchStore))
+	}
+	op.batchTimer.Reset(timeout, batchTimerEvent{})
+	logger.Debugf("Replica %d started the batch timer for %v", op.pbft.id, timeout)
 	op.batchTimerActive = true
 }
 
@@ -467,6 +933,13 @@ func (op *obcBatch) stopBatchTimer() {
 // a Fabric message. Called by broadcast before transmission.
 func (op *obcBatch) wrapMessage(msgPayload []byte) *pb.Message {
 	batchMsg := &BatchMessage{&BatchMessage_PbftMessage{msgPayload}}
+	if op.auth != nil {
+		auth, missing := op.auth.authenticate(msgPayload)
+		for _, replica := range missing {
+			op.requestSessionKey(replica)
+		}
+		batchMsg = &BatchMessage{&BatchMessage_Authenticated{auth}}
+	}
 	packedBatchMsg, _ := proto.Marshal(batchMsg)
 	ocMsg := &pb.Message{
 		Type:    pb.Message_CONSENSUS,
@@ -486,7 +959,7 @@ func (op *obcBatch) getManager() events.Manager {
 }
 
 func (op *obcBatch) startTimerIfOutstandingRequests() {
-	if op.pbft.skipInProgress || op.pbft.currentExec != nil {
+	if op.pbft.skipInProgress || (op.pbft.currentExec != nil && !op.pbft.awaitingCommit()) {
 		// Do not start view change timer if some background event is in progress
 		return
 	}
@@ -17,15 +17,24 @@ limitations under the License.
 package obcpbft
 
 import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/hyperledger/fabric/consensus"
 	"github.com/hyperledger/fabric/consensus/obcpbft/events"
+	"github.com/hyperledger/fabric/core/util"
 	pb "github.com/hyperledger/fabric/protos"
 
 	"github.com/golang/protobuf/proto"
 	"github.com/spf13/viper"
+	gp "google/protobuf"
 )
 
 func (op *obcBatch) getPBFTCore() *pbftCore {
@@ -80,6 +89,289 @@ func TestNetworkBatch(t *testing.T) {
 	}
 }
 
+func TestNetworkBatchPayloadsOffConsensus(t *testing.T) {
+	validatorCount := 4
+	net := makeConsumerNetwork(validatorCount, obcBatchSizeOneHelper, func(ce *consumerEndpoint) {
+		ce.consumer.(*obcBatch).payloadThreshold = 1
+	})
+	defer net.stop()
+
+	// Replica 3 misses the payload pushed by replica 1 and has to fetch it
+	var lock sync.Mutex
+	dropped := false
+	net.filterFn = func(src int, dst int, payload []byte) []byte {
+		batchMsg := &BatchMessage{}
+		if err := proto.Unmarshal(payload, batchMsg); err != nil {
+			t.Fatalf("Failed to unmarshal batch message: %s", err)
+		}
+		if pbftMsg := batchMsg.GetPbftMessage(); pbftMsg != nil {
+			msg := &Message{}
+			proto.Unmarshal(pbftMsg, msg)
+			if preprep := msg.GetPrePrepare(); preprep != nil && preprep.Request != nil {
+				reqs := &RequestBlock{}
+				proto.Unmarshal(preprep.Request.Payload, reqs)
+				for _, req := range reqs.Requests {
+					if req.Payload != nil || req.PayloadHash == nil {
+						t.Errorf("Replica %d ordered a payload above the threshold", src)
+					}
+				}
+			}
+		}
+		lock.Lock()
+		defer lock.Unlock()
+		if batchMsg.GetPayloadData() != nil && dst == 3 && !dropped {
+			dropped = true
+			return nil
+		}
+		return payload
+	}
+
+	detached, fetched := payloadsDetached.Value(), payloadsFetched.Value()
+
+	txMsg := createOcMsgWithChainTx(1)
+	broadcaster := net.endpoints[generateBroadcaster(validatorCount)].getHandle()
+	net.endpoints[1].(*consumerEndpoint).consumer.RecvMsg(txMsg, broadcaster)
+	net.process()
+
+	tx := &pb.Transaction{}
+	proto.Unmarshal(txMsg.Payload, tx)
+	for _, ep := range net.endpoints {
+		ce := ep.(*consumerEndpoint)
+		block, err := ce.consumer.(*obcBatch).stack.GetBlock(1)
+		if nil != err {
+			t.Fatalf("Replica %d executed requests, expected a new block on the chain, but could not retrieve it : %s", ce.id, err)
+		}
+		if len(block.Transactions) != 1 || !proto.Equal(block.Transactions[0], tx) {
+			t.Errorf("Replica %d executed %v, expected %v", ce.id, block.Transactions, tx)
+		}
+	}
+	if !dropped {
+		t.Errorf("Expected the payload pushed to replica 3 to be dropped")
+	}
+	if d := payloadsDetached.Value() - detached; d != 1 {
+		t.Errorf("Expected 1 payload distributed off consensus, got %v", d)
+	}
+	if d := payloadsFetched.Value() - fetched; d != 1 {
+		t.Errorf("Expected 1 payload fetched by replica 3, got %v", d)
+	}
+}
+
+func obcBatchAuthenticatedHelper(id uint64, config *viper.Viper, stack consensus.Stack) pbftConsumer {
+	config.Set("general.batchsize", 1)
+	config.Set("general.authentication.enabled", true)
+	return newObcBatch(id, config, stack)
+}
+
+func TestNetworkBatchAuthenticated(t *testing.T) {
+	validatorCount := 4
+	net := makeConsumerNetwork(validatorCount, obcBatchAuthenticatedHelper)
+	defer net.stop()
+
+	// The primary's authenticator is forged for replica 3
+	var lock sync.Mutex
+	forged := 0
+	net.filterFn = func(src int, dst int, payload []byte) []byte {
+		batchMsg := &BatchMessage{}
+		if err := proto.Unmarshal(payload, batchMsg); err != nil {
+			t.Fatalf("Failed to unmarshal batch message: %s", err)
+		}
+		if batchMsg.GetPbftMessage() != nil {
+			t.Errorf("Replica %d sent a pbft message without authenticator", src)
+		}
+		auth := batchMsg.GetAuthenticated()
+		if auth == nil || src != 0 || dst != 3 {
+			return payload
+		}
+		lock.Lock()
+		forged++
+		lock.Unlock()
+		auth.Macs[3] = computeMAC([]byte("forged"), auth.PbftMessage)
+		forgedPayload, _ := proto.Marshal(batchMsg)
+		return forgedPayload
+	}
+
+	// Replicas exchange their session keys
+	net.process()
+	for _, ep := range net.endpoints {
+		ce := ep.(*consumerEndpoint)
+		if keys := len(ce.consumer.(*obcBatch).auth.keys); keys != validatorCount-1 {
+			t.Fatalf("Replica %d established %d session keys, expected %d", ce.id, keys, validatorCount-1)
+		}
+	}
+
+	broadcaster := net.endpoints[generateBroadcaster(validatorCount)].getHandle()
+	net.endpoints[1].(*consumerEndpoint).consumer.RecvMsg(createOcMsgWithChainTx(1), broadcaster)
+	net.process()
+
+	if forged == 0 {
+		t.Fatalf("Expected the primary to send authenticated messages to replica 3")
+	}
+	for _, ep := range net.endpoints {
+		ce := ep.(*consumerEndpoint)
+		_, err := ce.consumer.(*obcBatch).stack.GetBlock(1)
+		if ce.id == 3 {
+			if err == nil {
+				t.Errorf("Expected replica 3 to reject the pre-prepare with a forged MAC")
+			}
+		} else if err != nil {
+			t.Errorf("Replica %d executed requests, expected a new block on the chain, but could not retrieve it : %s", ce.id, err)
+		}
+	}
+}
+
+func TestAuthenticatorCheck(t *testing.T) {
+	config := loadConfig()
+	config.Set("general.authentication.enabled", true)
+	a := newAuthenticator(0, 4, config)
+	b := newAuthenticator(1, 4, config)
+	msg := []byte("pre-prepare")
+
+	auth, missing := a.authenticate(msg)
+	if !reflect.DeepEqual(missing, []uint64{1, 2, 3}) {
+		t.Fatalf("Expected no session keys yet, missing %v", missing)
+	}
+	if err := b.check(0, auth); err != errNoSessionKey {
+		t.Fatalf("Expected a message without session key to be rejected, got %v", err)
+	}
+
+	a.setKey(1, b.pub)
+	b.setKey(0, a.pub)
+	auth, missing = a.authenticate(msg)
+	if !reflect.DeepEqual(missing, []uint64{2, 3}) {
+		t.Fatalf("Expected the session key with replica 1, missing %v", missing)
+	}
+	if err := b.check(0, auth); err != nil {
+		t.Fatalf("Expected the MAC to verify, got %s", err)
+	}
+	auth.PbftMessage = []byte("commit")
+	if err := b.check(0, auth); err == nil || err == errNoSessionKey {
+		t.Fatalf("Expected a tampered message to be rejected, got %v", err)
+	}
+	if err := b.setKey(2, []byte("not a point")); err == nil {
+		t.Errorf("Expected an invalid public key to be rejected")
+	}
+}
+
+// TestAuthenticatorRefresh tests that a replica which refreshes its key pair
+// shares new session keys with the others once they get its new public key
+func TestAuthenticatorRefresh(t *testing.T) {
+	config := loadConfig()
+	config.Set("general.authentication.enabled", true)
+	a := newAuthenticator(0, 4, config)
+	b := newAuthenticator(1, 4, config)
+	a.setKey(1, b.pub)
+	b.setKey(0, a.pub)
+	msg := []byte("pre-prepare")
+
+	old, _ := a.authenticate(msg)
+	if err := a.refresh(); err != nil {
+		t.Fatalf("Failed to refresh the key pair: %s", err)
+	}
+	auth, missing := a.authenticate(msg)
+	if !reflect.DeepEqual(missing, []uint64{2, 3}) {
+		t.Fatalf("Expected the session key with replica 1 to be derived again, missing %v", missing)
+	}
+	if err := b.check(0, auth); err == nil || err == errNoSessionKey {
+		t.Fatalf("Expected the MAC under the new session key to be rejected before the new public key arrives, got %v", err)
+	}
+
+	b.setKey(0, a.pub)
+	if err := b.check(0, auth); err != nil {
+		t.Fatalf("Expected the MAC under the new session key to verify, got %s", err)
+	}
+	if err := b.check(0, old); err == nil {
+		t.Fatalf("Expected the MAC under the old session key to be rejected")
+	}
+}
+
+func TestPayloadFetchExclusion(t *testing.T) {
+	var asked []uint64
+	omni := &omniProto{
+		UnicastImpl: func(ocMsg *pb.Message, peer *pb.PeerID) error {
+			dest, _ := getValidatorID(peer)
+			asked = append(asked, dest)
+			return nil
+		},
+	}
+	b := newObcBatch(0, loadConfig(), omni)
+	defer b.Close()
+
+	req := &Request{PayloadHash: []byte("hash"), ReplicaId: 2}
+	batch, _ := proto.Marshal(&RequestBlock{Requests: []*Request{req}})
+	if b.validate(batch) == nil {
+		t.Fatalf("Expected a batch with a missing payload not to validate")
+	}
+	b.broadcaster.Wait()
+	if len(asked) != 1 || asked[0] != 2 {
+		t.Fatalf("Expected the payload to be fetched from replica 2, asked %v", asked)
+	}
+
+	// Replica 2 does not deliver in time
+	b.payloads.fetches["hash"].asked = time.Now().Add(-b.payloadFetchTimeout)
+	b.payloadTimerExpired()
+	b.broadcaster.Wait()
+	if !b.payloads.excluded[2] || len(asked) != 2 || asked[1] != 3 {
+		t.Fatalf("Expected replica 2 to be excluded and replica 3 to be asked, asked %v", asked)
+	}
+
+	// A new fetch skips the excluded replica
+	other := &Request{PayloadHash: []byte("other"), ReplicaId: 2}
+	b.fetchPayloads([]*Request{other})
+	b.broadcaster.Wait()
+	if len(asked) != 3 || asked[2] != 3 {
+		t.Fatalf("Expected the excluded replica to be skipped, asked %v", asked)
+	}
+}
+
+func TestUnsolicitedPayloads(t *testing.T) {
+	config := loadConfig()
+	config.Set("general.unsolicitedpayloads.count", 2)
+	config.Set("general.unsolicitedpayloads.bytes", 10)
+	b := newObcBatch(0, config, &omniProto{})
+	defer b.Close()
+
+	push := func(data string) []byte {
+		hash := util.ComputeCryptoHash([]byte(data))
+		b.recvPayloadData(&PayloadData{Hash: hash, Data: []byte(data)})
+		return hash
+	}
+
+	first := push("first")
+	if b.payloads.get(first) == nil {
+		t.Fatalf("Expected a payload pushed to us to be kept")
+	}
+	if tooLarge := push("too large"); b.payloads.get(tooLarge) != nil {
+		t.Errorf("Expected a payload pushed to us beyond the size cap not to be kept")
+	}
+	second := push("two")
+	if third := push("3"); b.payloads.get(third) != nil {
+		t.Errorf("Expected a payload pushed to us beyond the count cap not to be kept")
+	}
+
+	// A payload we fetch is kept regardless
+	fetched := util.ComputeCryptoHash([]byte("fetched payload"))
+	b.payloads.fetches[string(fetched)] = &payloadFetch{}
+	b.recvPayloadData(&PayloadData{Hash: fetched, Data: []byte("fetched payload")})
+	if b.payloads.get(fetched) == nil {
+		t.Fatalf("Expected a payload we fetched to be kept")
+	}
+
+	// Only the payload which executed outlives a log size
+	b.payloads.executedAt(second, b.pbft.L)
+	b.payloads.collect(1)
+	for _, hash := range [][]byte{first, fetched} {
+		if b.payloads.get(hash) != nil {
+			t.Errorf("Expected payload %x, which never executed, to be dropped", hash)
+		}
+	}
+	if b.payloads.get(second) == nil {
+		t.Errorf("Expected the payload which executed to be kept for a log size")
+	}
+	if len(b.payloads.unsolicited) != 1 || b.payloads.unsolicitedBytes != 3 {
+		t.Errorf("Expected the dropped payloads to make room, %d payloads of %d bytes left", len(b.payloads.unsolicited), b.payloads.unsolicitedBytes)
+	}
+}
+
 func TestClearOustandingReqsOnStateRecovery(t *testing.T) {
 	b := newObcBatch(0, loadConfig(), &omniProto{})
 	defer b.Close()
@@ -146,6 +438,43 @@ func TestOutstandingReqsIngestion(t *testing.T) {
 	}
 }
 
+func TestDuplicateRequestsAnswered(t *testing.T) {
+	omni := &omniProto{
+		UnicastImpl: func(ocMsg *pb.Message, dest *pb.PeerID) error { return nil },
+	}
+	b := newObcBatch(1, loadConfig(), omni)
+	defer b.Close()
+
+	req := createPbftRequestWithChainTx(2, 0)
+	b.replies.executed(req, 1)
+
+	// The request is retransmitted after it executed, while an older
+	// request of the same replica is still to be ordered
+	stale := createPbftRequestWithChainTx(1, 0)
+	for _, r := range []*Request{req, stale} {
+		payload, _ := proto.Marshal(&BatchMessage{&BatchMessage_Request{r}})
+		b.processMessage(&pb.Message{Type: pb.Message_CONSENSUS, Payload: payload}, &pb.PeerID{Name: "vp0"})
+	}
+	if seqNo, executed := b.replies.lookup(req); !executed || seqNo != 1 {
+		t.Errorf("Expected the request to be answered with seqNo 1, got %d, %v", seqNo, executed)
+	}
+	if _, executed := b.replies.lookup(stale); executed {
+		t.Errorf("Expected a request of replica 0 not to be stale while its replies are kept")
+	}
+	if count := len(*(b.reqStore.outstandingRequests)); count != 1 {
+		t.Fatalf("Expected only the request which did not execute to be outstanding, got %d", count)
+	}
+
+	// Once the reply is pruned, the older request is stale
+	b.replies.prune(1)
+	if _, executed := b.replies.lookup(stale); !executed {
+		t.Errorf("Expected a request older than the pruned replies to be stale")
+	}
+	if _, executed := b.replies.lookup(createPbftRequestWithChainTx(3, 0)); executed {
+		t.Errorf("Expected a newer request not to have executed")
+	}
+}
+
 func TestOutstandingReqsResubmission(t *testing.T) {
 	omni := &omniProto{}
 	b := newObcBatch(0, loadConfig(), omni)
@@ -200,7 +529,7 @@ func TestOutstandingReqsResubmission(t *testing.T) {
 	// Simulate changing views, with a request in the qSet, and one outstanding which is not
 	wreq := reqs[4]
 
-	reqsPacked, err := proto.Marshal(&RequestBlock{[]*Request{wreq}})
+	reqsPacked, err := proto.Marshal(&RequestBlock{Requests: []*Request{wreq}})
 	if err != nil {
 		t.Fatalf("Unable to pack block for new batch request")
 	}
@@ -294,7 +623,11 @@ func TestClassicStateTransfer(t *testing.T) {
 	// Move the seqNo to 9, at seqNo 6, Replica 3 will realize it's behind, transfer to seqNo 8, then execute seqNo 9
 	filterMsg = false
 	for n := 2; n <= 9; n++ {
-		net.endpoints[1].(*consumerEndpoint).consumer.RecvMsg(createOcMsgWithChainTx(int64(n)), broadcaster)
+		// Should replica 1 be the broadcaster, it turns down the requests
+		// exceeding its window until the network ordered the previous ones
+		for net.endpoints[1].(*consumerEndpoint).consumer.RecvMsg(createOcMsgWithChainTx(int64(n)), broadcaster) == consensus.ErrBackpressure {
+			net.process()
+		}
 	}
 
 	net.process()
@@ -341,7 +674,11 @@ func TestClassicBackToBackStateTransfer(t *testing.T) {
 	// Replica 3 will execute through seqNo 12
 	filterMsg = false
 	for n := 2; n <= 21; n++ {
-		net.endpoints[1].(*consumerEndpoint).consumer.RecvMsg(createOcMsgWithChainTx(int64(n)), broadcaster)
+		// Should replica 1 be the broadcaster, it turns down the requests
+		// exceeding its window until the network ordered the previous ones
+		for net.endpoints[1].(*consumerEndpoint).consumer.RecvMsg(createOcMsgWithChainTx(int64(n)), broadcaster) == consensus.ErrBackpressure {
+			net.process()
+		}
 	}
 
 	net.process()
@@ -358,3 +695,922 @@ func TestClassicBackToBackStateTransfer(t *testing.T) {
 		}
 	}
 }
+
+func TestBatchStatus(t *testing.T) {
+	b := newObcBatch(1, loadConfig(), &omniProto{})
+	defer b.Close()
+
+	b.reqStore.storeOutstanding(&Request{})
+	b.pbft.certStore[msgID{v: 0, n: 1}] = &msgCert{digest: "foo", prePrepare: &PrePrepare{}}
+
+	var reporter consensus.StatusReporter = b
+	status, ok := reporter.GetStatus().(*Status)
+	if !ok {
+		t.Fatalf("Expected a *Status, got %T", status)
+	}
+	if status.ReplicaID != 1 || status.View != 0 || status.Primary != 0 || !status.ActiveView {
+		t.Errorf("Unexpected view in status %+v", status)
+	}
+	if status.LowWatermark != 0 || status.HighWatermark != b.pbft.L {
+		t.Errorf("Expected watermarks 0 and %d, got %d and %d", b.pbft.L, status.LowWatermark, status.HighWatermark)
+	}
+	if status.OutstandingRequests != 1 || status.PendingRequests != 0 {
+		t.Errorf("Expected 1 outstanding and 0 pending requests, got %d and %d", status.OutstandingRequests, status.PendingRequests)
+	}
+	if len(status.Certificates) != 1 || !status.Certificates[0].PrePrepared || status.Certificates[0].SeqNo != 1 || status.Certificates[0].Prepared {
+		t.Errorf("Unexpected certificates %+v", status.Certificates)
+	}
+
+	if _, err := json.Marshal(status); err != nil {
+		t.Errorf("Could not marshal status: %s", err)
+	}
+}
+
+func TestBatchMetrics(t *testing.T) {
+	validatorCount := 4
+	net := makeConsumerNetwork(validatorCount, obcBatchSizeOneHelper)
+	defer net.stop()
+
+	requests, batches := requestsReceived.Value(), batchesOrdered.Value()
+	prepares := messagesReceived.With("prepare").Value()
+	prepareCount, commitCount := prepareLatency.Count(), commitLatency.Count()
+
+	broadcaster := net.endpoints[generateBroadcaster(validatorCount)].getHandle()
+	if err := net.endpoints[1].(*consumerEndpoint).consumer.RecvMsg(createOcMsgWithChainTx(1), broadcaster); err != nil {
+		t.Fatalf("External request was not processed by backup: %v", err)
+	}
+	net.process()
+
+	if d := requestsReceived.Value() - requests; d != 1 {
+		t.Errorf("Expected 1 more request received, got %v", d)
+	}
+	if d := batchesOrdered.Value() - batches; d != float64(validatorCount) {
+		t.Errorf("Expected each replica to order the batch, got %v batches ordered", d)
+	}
+	if d := messagesReceived.With("prepare").Value() - prepares; d == 0 {
+		t.Errorf("Expected prepares to be counted")
+	}
+	if prepareLatency.Count() == prepareCount || commitLatency.Count() == commitCount {
+		t.Errorf("Expected the prepare and commit latencies to be observed")
+	}
+	if lastExecuted.Value() != 1 {
+		t.Errorf("Expected the last executed sequence number to be 1, got %v", lastExecuted.Value())
+	}
+}
+
+func TestBatchTrace(t *testing.T) {
+	b := newObcBatch(1, loadConfig(), &omniProto{})
+	defer b.Close()
+
+	events, cancel := b.TraceMessages(&pb.ConsensusTraceRequest{Types: []string{"prepare"}, FilterSeqNo: true, SeqNo: 1})
+	recvPrepare := func(seqNo uint64) {
+		payload, _ := proto.Marshal(&Message{&Message_Prepare{&Prepare{SequenceNumber: seqNo, RequestDigest: "foo", ReplicaId: 2}}})
+		b.processMessage(b.wrapMessage(payload), &pb.PeerID{Name: "vp2"})
+	}
+	recvPrepare(2)
+	recvPrepare(1)
+	payload, _ := proto.Marshal(&Message{&Message_Commit{&Commit{SequenceNumber: 1, RequestDigest: "foo", ReplicaId: 2}}})
+	b.processMessage(b.wrapMessage(payload), &pb.PeerID{Name: "vp2"})
+
+	select {
+	case ev := <-events:
+		if ev.Sent || ev.Replica != 2 || ev.Type != "prepare" || !ev.HasView || ev.View != 0 || !ev.HasSeqNo || ev.SeqNo != 1 {
+			t.Errorf("Unexpected trace event %+v", ev)
+		}
+		if !strings.Contains(ev.Message, `"request_digest":"foo"`) {
+			t.Errorf("Expected trace event to carry the decoded prepare, got %s", ev.Message)
+		}
+	default:
+		t.Fatalf("Expected the prepare for sequence number 1 to be traced")
+	}
+	select {
+	case ev := <-events:
+		t.Errorf("Expected only the prepare for sequence number 1 to be traced, got %+v", ev)
+	default:
+	}
+
+	cancel()
+	if _, ok := <-events; ok {
+		t.Errorf("Expected trace events to stop once cancelled")
+	}
+	if b.tracer.active() {
+		t.Errorf("Expected no tracer to remain subscribed")
+	}
+}
+
+func createRequestForChaincode(iter int64, chaincode string) *Request {
+	cID, _ := proto.Marshal(&pb.ChaincodeID{Name: chaincode})
+	tx := &pb.Transaction{Type: pb.Transaction_CHAINCODE_INVOKE, ChaincodeID: cID, Payload: []byte(fmt.Sprint(iter))}
+	txPacked, _ := proto.Marshal(tx)
+	return &Request{Timestamp: &gp.Timestamp{Seconds: iter}, Payload: txPacked}
+}
+
+func TestBatchFairnessCut(t *testing.T) {
+	bf := &batchFairness{weights: map[string]int{"heavy": 2}, defaultWeight: 1}
+	chaincodeOf := func(req *Request) string { return string(req.Payload) }
+	reqs := func(chaincodes ...string) []*Request {
+		var r []*Request
+		for _, cc := range chaincodes {
+			r = append(r, &Request{Payload: []byte(cc)})
+		}
+		return r
+	}
+	names := func(r []*Request) string {
+		var s []string
+		for _, req := range r {
+			s = append(s, string(req.Payload))
+		}
+		return strings.Join(s, ",")
+	}
+
+	for _, tc := range []struct {
+		in, batch, rest []*Request
+	}{
+		// Fewer requests than a batch
+		{reqs("a", "a", "b"), reqs("a", "a", "b"), nil},
+		// A chatty chaincode gets as much as the others
+		{reqs("a", "a", "a", "a", "a", "b", "c"), reqs("a", "a", "b", "c"), reqs("a", "a", "a")},
+		// Unless it is alone
+		{reqs("a", "a", "a", "a", "a"), reqs("a", "a", "a", "a"), reqs("a")},
+		// A heavier chaincode gets more
+		{reqs("a", "a", "a", "heavy", "heavy", "heavy", "heavy"), reqs("a", "a", "heavy", "heavy"), reqs("a", "heavy", "heavy")},
+	} {
+		batch, rest := bf.cut(tc.in, 4, chaincodeOf)
+		if names(batch) != names(tc.batch) || names(rest) != names(tc.rest) {
+			t.Errorf("Cutting %s, expected batch %s and rest %s, got %s and %s",
+				names(tc.in), names(tc.batch), names(tc.rest), names(batch), names(rest))
+		}
+	}
+}
+
+func TestFairBatchingKeepsRequests(t *testing.T) {
+	config := loadConfig()
+	config.Set("general.batchsize", 4)
+	config.Set("general.K", 2)
+	config.Set("general.logmultiplier", 2)
+	config.Set("general.fairness.enabled", true)
+	omni := &omniProto{
+		UnicastImpl: func(ocMsg *pb.Message, dest *pb.PeerID) error { return nil },
+	}
+	b := newObcBatch(0, config, omni)
+	defer b.Close()
+
+	// Two batches in flight, the primary cannot order another one
+	b.pbft.seqNo = b.pbft.L / 2
+	for i := int64(0); i < 7; i++ {
+		b.leaderProcReq(createRequestForChaincode(i, "chatty"))
+	}
+	for i := int64(7); i < 9; i++ {
+		b.leaderProcReq(createRequestForChaincode(i, "quiet"))
+	}
+	if len(b.batchStore) != 9 || len(b.pbft.outstandingReqs) != 0 {
+		t.Fatalf("Expected the primary to keep 9 requests and order none, kept %d and ordered %d", len(b.batchStore), len(b.pbft.outstandingReqs))
+	}
+
+	// The batches executed, and a checkpoint moved the watermarks
+	b.pbft.lastExec = b.pbft.seqNo
+	b.pbft.moveWatermarks(b.pbft.seqNo)
+	b.cutBatches()
+
+	expected := [][]string{{"chatty", "chatty", "quiet", "quiet"}, {"chatty", "chatty", "chatty", "chatty"}}
+	for i, chaincodes := range expected {
+		n := b.pbft.h + uint64(i) + 1
+		cert, ok := b.pbft.certStore[msgID{v: 0, n: n}]
+		if !ok || cert.prePrepare == nil {
+			t.Fatalf("Expected a pre-prepare for seqNo %d", n)
+		}
+		block := &RequestBlock{}
+		if err := proto.Unmarshal(cert.prePrepare.Request.Payload, block); err != nil {
+			t.Fatalf("Could not unmarshal batch: %s", err)
+		}
+		var got []string
+		for _, req := range block.Requests {
+			got = append(got, b.chaincodeOf(req))
+		}
+		if !reflect.DeepEqual(got, chaincodes) {
+			t.Errorf("Expected batch %d to hold requests of %v, got %v", n, chaincodes, got)
+		}
+	}
+	if len(b.batchStore) != 1 {
+		t.Errorf("Expected the primary to keep the last request until it can order it, kept %d", len(b.batchStore))
+	}
+}
+
+func TestBatchBytes(t *testing.T) {
+	size := len(createRequestForChaincode(1, "mycc").Payload)
+	config := loadConfig()
+	config.Set("general.batchsize", 10)
+	config.Set("general.batchbytes", size*5/2)
+	omni := &omniProto{
+		UnicastImpl: func(ocMsg *pb.Message, dest *pb.PeerID) error { return nil },
+	}
+	b := newObcBatch(0, config, omni)
+	defer b.Close()
+
+	for i := int64(1); i <= 3; i++ {
+		events.SendEvent(b, b.leaderProcReq(createRequestForChaincode(i, "mycc")))
+	}
+
+	cert, ok := b.pbft.certStore[msgID{v: 0, n: 1}]
+	if !ok || cert.prePrepare == nil {
+		t.Fatalf("Expected the requests to fill a batch by size")
+	}
+	block := &RequestBlock{}
+	if err := proto.Unmarshal(cert.prePrepare.Request.Payload, block); err != nil {
+		t.Fatalf("Could not unmarshal batch: %s", err)
+	}
+	if len(block.Requests) != 2 {
+		t.Errorf("Expected the batch to hold the 2 requests which fit, got %d", len(block.Requests))
+	}
+	if len(b.batchStore) != 1 || !b.batchTimerActive {
+		t.Errorf("Expected the primary to keep the last request for the next batch, kept %d", len(b.batchStore))
+	}
+}
+
+func TestHotSpareTakesOver(t *testing.T) {
+	config := loadConfig()
+	config.Set("general.hotspare.enabled", true)
+	omni := &omniProto{
+		UnicastImpl: func(ocMsg *pb.Message, dest *pb.PeerID) error { return nil },
+	}
+	spare := newObcBatch(1, config, omni)
+	defer spare.Close()
+	backup := newObcBatch(2, config, omni)
+	defer backup.Close()
+
+	// Requests arrive at the backups while the primary is silent, the
+	// last one first
+	for i := int64(3); i > 0; i-- {
+		req := createPbftRequestWithChainTx(i, 0)
+		spare.submitToLeader(req)
+		backup.submitToLeader(req)
+	}
+	if len(spare.spare.reqs) != 3 {
+		t.Fatalf("Expected the next primary to shadow 3 requests, got %d", len(spare.spare.reqs))
+	}
+	if len(backup.spare.reqs) != 0 {
+		t.Fatalf("Expected a replica not next in line to shadow no requests, got %d", len(backup.spare.reqs))
+	}
+
+	// The view changes to the hot spare
+	spare.pbft.view = 1
+	events.SendEvent(spare, viewChangedEvent{})
+
+	// Both batches are proposed without waiting for the batch timer, in
+	// the order the requests arrived
+	expected := [][]int64{{3, 2}, {1}}
+	for i, iters := range expected {
+		n := uint64(i) + 1
+		cert, ok := spare.pbft.certStore[msgID{v: 1, n: n}]
+		if !ok || cert.prePrepare == nil {
+			t.Fatalf("Expected a pre-prepare for seqNo %d", n)
+		}
+		block := &RequestBlock{}
+		if err := proto.Unmarshal(cert.prePrepare.Request.Payload, block); err != nil {
+			t.Fatalf("Could not unmarshal batch: %s", err)
+		}
+		var got []int64
+		for _, req := range block.Requests {
+			got = append(got, req.Timestamp.Seconds)
+		}
+		if !reflect.DeepEqual(got, iters) {
+			t.Errorf("Expected batch %d to hold requests %v, got %v", n, iters, got)
+		}
+	}
+	if spare.batchTimerActive || len(spare.batchStore) != 0 {
+		t.Errorf("Expected the new primary to keep no requests back")
+	}
+
+	// The next primary in line now shadows the outstanding requests
+	backup.pbft.view = 1
+	events.SendEvent(backup, viewChangedEvent{})
+	if len(backup.spare.reqs) != 3 {
+		t.Errorf("Expected the new next primary to shadow 3 requests, got %d", len(backup.spare.reqs))
+	}
+}
+
+func TestReadOnlyReplies(t *testing.T) {
+	config := loadConfig()
+	config.Set("general.readonly.enabled", true)
+	omni := &omniProto{
+		UnicastImpl: func(ocMsg *pb.Message, dest *pb.PeerID) error { return nil },
+	}
+	b := newObcBatch(0, config, omni)
+	defer b.Close()
+
+	query := &readOnlyQuery{
+		replies: make(map[uint64]*ReadOnlyReply),
+		result:  make(chan *ReadOnlyReply, 1),
+	}
+	b.readOnly.queries["digest"] = query
+
+	// A diverging result, and the same result sent twice by one replica,
+	// do not make f+1 matching results
+	b.recvReadOnlyReply(&ReadOnlyReply{RequestDigest: "digest", Result: []byte("stale")}, 1)
+	b.recvReadOnlyReply(&ReadOnlyReply{RequestDigest: "digest", Result: []byte("value")}, 2)
+	b.recvReadOnlyReply(&ReadOnlyReply{RequestDigest: "digest", Result: []byte("value")}, 2)
+	select {
+	case reply := <-query.result:
+		t.Fatalf("Expected no answer before f+1 replicas agree, got %s", reply.Result)
+	default:
+	}
+
+	b.recvReadOnlyReply(&ReadOnlyReply{RequestDigest: "digest", Result: []byte("value")}, 3)
+	select {
+	case reply := <-query.result:
+		if string(reply.Result) != "value" {
+			t.Errorf("Expected the result f+1 replicas agree on, got %s", reply.Result)
+		}
+	default:
+		t.Fatalf("Expected an answer once f+1 replicas agree")
+	}
+	if _, ok := b.readOnly.queries["digest"]; ok {
+		t.Errorf("Expected the answered query to be forgotten")
+	}
+}
+
+// readOnlyStack is a stack which executes queries
+type readOnlyStack struct {
+	*omniProto
+	executeReadOnly func(tx *pb.Transaction) ([]byte, error)
+}
+
+func (s *readOnlyStack) ExecuteReadOnly(tx *pb.Transaction) ([]byte, error) {
+	return s.executeReadOnly(tx)
+}
+
+func TestReadOnlyRequestsDropped(t *testing.T) {
+	executed := make(chan struct{}, 10)
+	release := make(chan struct{})
+	stack := &readOnlyStack{
+		omniProto: &omniProto{
+			UnicastImpl: func(ocMsg *pb.Message, dest *pb.PeerID) error { return nil },
+		},
+		executeReadOnly: func(tx *pb.Transaction) ([]byte, error) {
+			executed <- struct{}{}
+			<-release
+			return nil, nil
+		},
+	}
+	defer close(release)
+	recvReadOnly := func(b *obcBatch, replicaID uint64, sender string) {
+		req := createPbftRequestWithChainTx(1, replicaID)
+		req.ReadOnly = true
+		payload, _ := proto.Marshal(&BatchMessage{&BatchMessage_Request{req}})
+		b.processMessage(&pb.Message{Type: pb.Message_CONSENSUS, Payload: payload}, &pb.PeerID{Name: sender})
+	}
+
+	// Without general.readonly.enabled, the queries of others are not executed
+	disabled := newObcBatch(0, loadConfig(), stack)
+	defer disabled.Close()
+	recvReadOnly(disabled, 1, "vp1")
+
+	config := loadConfig()
+	config.Set("general.readonly.enabled", true)
+	config.Set("general.readonly.executions", 1)
+	b := newObcBatch(0, config, stack)
+	defer b.Close()
+
+	// A request naming another replica than its sender is not executed
+	recvReadOnly(b, 2, "vp1")
+	select {
+	case <-executed:
+		t.Fatalf("Expected read-only requests to be dropped when disabled or spoofed")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	// Only general.readonly.executions requests are executed at a time
+	recvReadOnly(b, 1, "vp1")
+	recvReadOnly(b, 2, "vp2")
+	select {
+	case <-executed:
+	case <-time.After(time.Second):
+		t.Fatalf("Expected the read-only request to be executed")
+	}
+	select {
+	case <-executed:
+		t.Fatalf("Expected the read-only request beyond the execution limit to be dropped")
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestAdaptiveBatchTimeout(t *testing.T) {
+	config := loadConfig()
+	config.Set("general.adaptivebatch.enabled", true)
+	config.Set("general.adaptivebatch.min", "10ms")
+	config.Set("general.adaptivebatch.max", "1s")
+	bta := newBatchTimeoutAdaptor(config)
+
+	// Until requests flow, the primary does not wait for the batch to fill
+	if timeout := bta.timeout(9); timeout != 10*time.Millisecond {
+		t.Fatalf("Expected the lower bound without arrivals, got %v", timeout)
+	}
+
+	// Requests arriving every 20ms fill a batch missing 9 in 180ms
+	now := time.Now()
+	for i := 0; i < 100; i++ {
+		now = now.Add(20 * time.Millisecond)
+		bta.arrived(now)
+	}
+	if timeout := bta.timeout(9); timeout < 170*time.Millisecond || timeout > 190*time.Millisecond {
+		t.Fatalf("Expected the time to fill the batch at the arrival rate, got %v", timeout)
+	}
+	if timeout := bta.timeout(0); timeout != 10*time.Millisecond {
+		t.Fatalf("Expected the lower bound for a full batch, got %v", timeout)
+	}
+
+	// Requests arriving more slowly lengthen the timeout, until the batch
+	// is not expected to fill within the upper bound
+	for i := 0; i < 100; i++ {
+		now = now.Add(100 * time.Millisecond)
+		bta.arrived(now)
+	}
+	if timeout := bta.timeout(9); timeout < 850*time.Millisecond || timeout > time.Second {
+		t.Fatalf("Expected the timeout to lengthen as requests arrive more slowly, got %v", timeout)
+	}
+	now = now.Add(time.Hour)
+	bta.arrived(now)
+	if timeout := bta.timeout(9); timeout != 10*time.Millisecond {
+		t.Fatalf("Expected the lower bound for a batch not filling within the upper bound, got %v", timeout)
+	}
+
+	config.Set("general.adaptivebatch.enabled", false)
+	if newBatchTimeoutAdaptor(config) != nil {
+		t.Fatalf("Expected no adaptor when the batch timeout is fixed")
+	}
+}
+
+// clockStack records the timestamps of the batches it executes
+type clockStack struct {
+	*omniProto
+	timestamps []*gp.Timestamp
+}
+
+func (cs *clockStack) SetBatchTimestamp(timestamp *gp.Timestamp) {
+	cs.timestamps = append(cs.timestamps, timestamp)
+}
+
+func TestBatchClock(t *testing.T) {
+	head := toTimestamp(time.Now())
+	stack := &clockStack{omniProto: &omniProto{
+		GetBlockchainSizeImpl: func() uint64 { return 1 },
+		GetBlockImpl: func(id uint64) (*pb.Block, error) {
+			return &pb.Block{Timestamp: head}, nil
+		},
+		ExecuteImpl: func(tag interface{}, txs []*pb.Transaction) {},
+	}}
+	config := loadConfig()
+	config.Set("general.clock.maxskew", "1s")
+	b := newObcBatch(1, config, stack)
+	defer b.Close()
+
+	skewed, _ := proto.Marshal(&RequestBlock{Timestamp: toTimestamp(time.Now().Add(time.Minute))})
+	if err := b.validate(skewed); err == nil {
+		t.Fatalf("Expected a batch stamped a minute ahead not to validate")
+	}
+	stamped, _ := proto.Marshal(&RequestBlock{Timestamp: toTimestamp(time.Now())})
+	if err := b.validate(stamped); err != nil {
+		t.Fatalf("Expected a batch stamped now to validate, got %s", err)
+	}
+
+	// The time of the chain does not go back
+	early := toTimestamp(fromTimestamp(head).Add(-time.Second))
+	late := toTimestamp(fromTimestamp(head).Add(time.Second))
+	for _, ts := range []*gp.Timestamp{early, late, nil} {
+		raw, _ := proto.Marshal(&RequestBlock{Timestamp: ts})
+		b.execute(1, raw)
+	}
+	if len(stack.timestamps) != 3 {
+		t.Fatalf("Expected the timestamps of 3 batches, got %d", len(stack.timestamps))
+	}
+	if !fromTimestamp(stack.timestamps[0]).Equal(fromTimestamp(head).Add(time.Nanosecond)) {
+		t.Errorf("Expected a batch stamped before the head block to follow it by 1ns, got %v", stack.timestamps[0])
+	}
+	if !proto.Equal(stack.timestamps[1], late) {
+		t.Errorf("Expected a batch stamped after the head block to keep its timestamp, got %v", stack.timestamps[1])
+	}
+	if stack.timestamps[2] != nil {
+		t.Errorf("Expected a batch without timestamp to have none, got %v", stack.timestamps[2])
+	}
+}
+
+func TestRejectedMessagesKept(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pbft-rejects")
+	if err != nil {
+		t.Fatalf("Could not create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	config := loadConfig()
+	config.Set("general.rejects.dir", dir)
+	config.Set("general.rejects.max", 2)
+	b := newObcBatch(0, config, &omniProto{})
+	defer b.Close()
+
+	sender := &pb.PeerID{Name: "vp1"}
+	garbage := []byte{0xff, 0xff, 0xff}
+	forged, _ := proto.Marshal(&BatchMessage{&BatchMessage_PbftMessage{mustMarshal(&Message{&Message_Prepare{&Prepare{ReplicaId: 2}}})}})
+	valid, _ := proto.Marshal(&BatchMessage{&BatchMessage_PbftMessage{mustMarshal(&Message{&Message_Prepare{&Prepare{ReplicaId: 1}}})}})
+	for _, payload := range [][]byte{garbage, forged, valid, garbage} {
+		b.RecvMsg(&pb.Message{Type: pb.Message_CONSENSUS, Payload: payload}, sender)
+	}
+	b.manager.Queue() <- nil
+
+	rejected, err := ReadRejectedMessages(dir)
+	if err != nil {
+		t.Fatalf("Could not read rejected messages: %s", err)
+	}
+	if len(rejected) != 2 {
+		t.Fatalf("Expected the first 2 malformed messages to be kept, got %d", len(rejected))
+	}
+	for i, payload := range [][]byte{garbage, forged} {
+		if !reflect.DeepEqual(rejected[i].Payload, payload) || rejected[i].Sender != "vp1" || rejected[i].Replica != 1 {
+			t.Errorf("Expected rejected message %d to hold %x from vp1, got %+v", i, payload, rejected[i])
+		}
+	}
+
+	// Replayed messages are rejected again, and not kept
+	if err := b.ReplayMessage(&pb.Message{Type: pb.Message_CONSENSUS, Payload: rejected[1].Payload}, sender); err == nil {
+		t.Errorf("Expected the replayed prepare of another replica to be rejected")
+	}
+	if err := b.ReplayMessage(&pb.Message{Type: pb.Message_CONSENSUS, Payload: valid}, sender); err != nil {
+		t.Errorf("Expected a valid message to be accepted, got %s", err)
+	}
+}
+
+func mustMarshal(msg proto.Message) []byte {
+	raw, err := proto.Marshal(msg)
+	if err != nil {
+		panic(err)
+	}
+	return raw
+}
+
+// beaconStack records the random values of the batches it executes
+type beaconStack struct {
+	*omniProto
+	randoms [][]byte
+}
+
+func (bs *beaconStack) SetBatchRandom(random []byte) {
+	bs.randoms = append(bs.randoms, random)
+}
+
+func TestRandomBeacon(t *testing.T) {
+	var head []byte
+	stack := &beaconStack{omniProto: &omniProto{
+		GetBlockHeadMetadataImpl: func() ([]byte, error) { return head, nil },
+		ExecuteImpl:              func(tag interface{}, txs []*pb.Transaction) { head = tag.([]byte) },
+		UnicastImpl:              func(msg *pb.Message, receiverHandle *pb.PeerID) error { return nil },
+		SignImpl:                 func(msg []byte) ([]byte, error) { return []byte("signature"), nil },
+		VerifyImpl: func(peerID *pb.PeerID, signature []byte, message []byte) error {
+			if string(signature) != "signature" {
+				return fmt.Errorf("invalid signature")
+			}
+			return nil
+		},
+	}}
+	config := loadConfig()
+	config.Set("general.beacon.enabled", true)
+	config.Set("general.beacon.chainlength", 2)
+	b := newObcBatch(1, config, stack)
+	defer b.Close()
+
+	others := make(map[uint64]*randomBeacon)
+	for _, id := range []uint64{0, 2} {
+		others[id] = newRandomBeacon(id, 4, 1, config)
+	}
+	shares := func(state map[uint64][]byte) []*BeaconShare {
+		var shares []*BeaconShare
+		for _, rb := range others {
+			share := rb.next(state)
+			share.Signature = []byte("signature")
+			rb.sent = share
+			shares = append(shares, share)
+		}
+		return shares
+	}
+	execute := func(shares []*BeaconShare) *Metadata {
+		raw, _ := proto.Marshal(&RequestBlock{Beacon: shares})
+		done := make(chan struct{})
+		b.manager.Queue() <- workEvent(func() {
+			b.execute(1, raw)
+			close(done)
+		})
+		<-done
+		meta := &Metadata{}
+		proto.Unmarshal(head, meta)
+		return meta
+	}
+
+	// Anchors reveal nothing
+	meta := execute(shares(beaconState(&Metadata{})))
+	if meta.Random != nil || len(meta.Beacon) != 2 {
+		t.Fatalf("Expected anchors to record the beacon state of 2 replicas and no random value, got %v", meta)
+	}
+
+	revealed := shares(beaconState(meta))
+	meta = execute(revealed)
+	if meta.Random == nil {
+		t.Fatalf("Expected f+1 revealed values to give a random value")
+	}
+	if !reflect.DeepEqual(stack.randoms[1], meta.Random) {
+		t.Errorf("Expected the stack to be handed the random value of the block")
+	}
+
+	// A value which is not the preimage of the last one, or is not signed,
+	// does not count
+	next := shares(beaconState(meta))
+	next[0].Value = []byte("forged")
+	next[1].Signature = nil
+	if meta = execute(next); meta.Random != nil {
+		t.Fatalf("Expected no random value without f+1 valid revealed values, got %x", meta.Random)
+	}
+
+	if pending := b.beacon.pending(); len(pending) != 1 || pending[0].ReplicaId != 1 || !pending[0].Anchor {
+		t.Errorf("Expected our own anchor to be pending, got %v", pending)
+	}
+}
+
+// reconfigStack records the validator sets it is notified of
+type reconfigStack struct {
+	*omniProto
+	epochs     []uint64
+	validators [][]*pb.PeerID
+}
+
+func (rs *reconfigStack) ValidatorsChanged(epoch uint64, validators []*pb.PeerID) {
+	rs.epochs = append(rs.epochs, epoch)
+	rs.validators = append(rs.validators, validators)
+}
+
+// newReconfigBatch returns a batch replica 1 whose stack keeps the metadata
+// of the blocks executed, and a function running f on its thread
+func newReconfigBatch() (*obcBatch, *reconfigStack, func(f func())) {
+	var head []byte
+	stack := &reconfigStack{omniProto: &omniProto{
+		GetBlockHeadMetadataImpl: func() ([]byte, error) { return head, nil },
+		ExecuteImpl:              func(tag interface{}, txs []*pb.Transaction) { head = tag.([]byte) },
+		UnicastImpl:              func(msg *pb.Message, receiverHandle *pb.PeerID) error { return nil },
+		SignImpl:                 func(msg []byte) ([]byte, error) { return []byte("signature"), nil },
+		VerifyImpl: func(peerID *pb.PeerID, signature []byte, message []byte) error {
+			if string(signature) != "signature" {
+				return fmt.Errorf("invalid signature")
+			}
+			return nil
+		},
+	}}
+	b := newObcBatch(1, loadConfig(), stack)
+	onThread := func(f func()) {
+		done := make(chan struct{})
+		b.manager.Queue() <- workEvent(func() {
+			f()
+			close(done)
+		})
+		<-done
+	}
+	return b, stack, onThread
+}
+
+// reconfigVotes returns a block of the signed requests of the replicas ids
+// to reconfigure the network to N, f in epoch
+func reconfigVotes(epoch uint64, N uint32, f uint32, ids ...uint64) []byte {
+	reqs := &RequestBlock{}
+	for _, id := range ids {
+		reqs.Requests = append(reqs.Requests, &Request{
+			ReplicaId:       id,
+			Reconfiguration: &Reconfiguration{Epoch: epoch, N: N, F: f},
+			Signature:       []byte("signature"),
+		})
+	}
+	raw, _ := proto.Marshal(reqs)
+	return raw
+}
+
+func TestReconfiguration(t *testing.T) {
+	b, stack, onThread := newReconfigBatch()
+	defer b.Close()
+	headMeta := func() *Metadata {
+		var meta *Metadata
+		onThread(func() { meta = b.headMetadata() })
+		return meta
+	}
+
+	if err := b.Reconfigure(5, 2); err == nil {
+		t.Errorf("Expected a reconfiguration to 5 replicas tolerating 2 faults to be refused")
+	}
+
+	req := &Request{ReplicaId: 0, Reconfiguration: &Reconfiguration{Epoch: 1, N: 7, F: 2}}
+	raw, _ := proto.Marshal(&RequestBlock{Requests: []*Request{req}})
+	onThread(func() {
+		if err := b.validate(raw); err == nil {
+			t.Errorf("Expected an unsigned reconfiguration not to be ordered")
+		}
+	})
+
+	onThread(func() {
+		if err := b.validate(reconfigVotes(1, 7, 2, 4)); err == nil {
+			t.Errorf("Expected a reconfiguration requested by replica 4 of a network of 4 not to be ordered")
+		}
+	})
+
+	// 2f+1 replicas must request the reconfiguration
+	raw = reconfigVotes(1, 7, 2, 0, 2)
+	onThread(func() {
+		if err := b.validate(raw); err != nil {
+			t.Errorf("Expected signed reconfiguration requests to be ordered, got %s", err)
+		}
+		b.execute(2, raw)
+	})
+	if meta := headMeta(); meta.Reconfiguration != nil || len(meta.Proposals) != 1 || !reflect.DeepEqual(meta.Proposals[0].Votes, []uint64{0, 2}) {
+		t.Fatalf("Expected the votes of replicas 0 and 2 to be pending, got %v", meta)
+	}
+
+	onThread(func() { b.execute(3, reconfigVotes(1, 7, 2, 3)) })
+	expected := &Reconfiguration{Epoch: 1, N: 7, F: 2, SeqNo: 3}
+	meta := headMeta()
+	if !reflect.DeepEqual(meta.Reconfiguration, expected) || meta.Proposals != nil {
+		t.Fatalf("Expected the block metadata to carry %v, got %v", expected, meta)
+	}
+	if b.pbft.N != 4 || b.pbft.epoch != 0 {
+		t.Fatalf("Expected the reconfiguration to wait for the next stable checkpoint, N=%d in epoch %d", b.pbft.N, b.pbft.epoch)
+	}
+
+	// The next blocks carry the reconfiguration along
+	raw, _ = proto.Marshal(&RequestBlock{})
+	onThread(func() { b.execute(4, raw) })
+	if meta = headMeta(); !reflect.DeepEqual(meta.Reconfiguration, expected) {
+		t.Fatalf("Expected the block metadata to still carry %v, got %v", expected, meta.Reconfiguration)
+	}
+
+	onThread(func() { b.pbft.moveWatermarks(10) })
+	if b.pbft.N != 7 || b.pbft.f != 2 || b.pbft.epoch != 1 {
+		t.Fatalf("Expected N=7, f=2 in epoch 1, got N=%d, f=%d in epoch %d", b.pbft.N, b.pbft.f, b.pbft.epoch)
+	}
+	if len(stack.epochs) != 1 || stack.epochs[0] != 1 || len(stack.validators[0]) != 7 {
+		t.Errorf("Expected the stack to be notified of the 7 validators of epoch 1, got %v, %v", stack.epochs, stack.validators)
+	}
+	if len(b.broadcaster.msgChans) != 6 {
+		t.Errorf("Expected to broadcast to 6 replicas, got %d", len(b.broadcaster.msgChans))
+	}
+
+	if _, err := b.pbft.recvMsg(&Message{&Message_Prepare{&Prepare{ReplicaId: 6}}}, 6); err != nil {
+		t.Errorf("Expected replica 6 to be part of the network: %s", err)
+	}
+	if _, err := b.pbft.recvMsg(&Message{&Message_Prepare{&Prepare{ReplicaId: 7}}}, 7); err == nil {
+		t.Errorf("Expected replica 7 not to be part of the network")
+	}
+
+	// An invalid reconfiguration ordered by a faulty primary is skipped
+	onThread(func() { b.execute(11, reconfigVotes(2, 7, 3, 0, 1, 2, 3, 4)) })
+	if meta = headMeta(); !reflect.DeepEqual(meta.Reconfiguration, expected) || meta.Proposals != nil {
+		t.Errorf("Expected the invalid reconfiguration to be skipped, got %v", meta)
+	}
+
+	// The votes for a past epoch are stale
+	onThread(func() { b.execute(12, reconfigVotes(1, 4, 1, 0, 1, 2, 3, 4)) })
+	if meta = headMeta(); !reflect.DeepEqual(meta.Reconfiguration, expected) || meta.Proposals != nil {
+		t.Errorf("Expected the reconfiguration of a past epoch to be skipped, got %v", meta)
+	}
+}
+
+func TestReconfigurationSingleReplica(t *testing.T) {
+	b, _, onThread := newReconfigBatch()
+	defer b.Close()
+
+	// A faulty replica leaving itself as the only replica, or making
+	// quorums impossible
+	onThread(func() { b.execute(1, reconfigVotes(1, 1, 0, 2)) })
+	onThread(func() { b.execute(2, reconfigVotes(1, 100, 33, 2)) })
+	// ... and requesting again does not count twice
+	onThread(func() { b.execute(3, reconfigVotes(1, 100, 33, 2, 2)) })
+	onThread(func() { b.pbft.moveWatermarks(10) })
+
+	var meta *Metadata
+	onThread(func() { meta = b.headMetadata() })
+	if meta.Reconfiguration != nil {
+		t.Fatalf("Expected the requests of a single replica not to be ordered, got %v", meta.Reconfiguration)
+	}
+	if b.pbft.N != 4 || b.pbft.f != 1 || b.pbft.epoch != 0 {
+		t.Fatalf("Expected N=4, f=1 in epoch 0, got N=%d, f=%d in epoch %d", b.pbft.N, b.pbft.f, b.pbft.epoch)
+	}
+	// Its latest request replaced its previous vote
+	expected := []*Reconfiguration{{Epoch: 1, N: 100, F: 33, Votes: []uint64{2}}}
+	if !reflect.DeepEqual(meta.Proposals, expected) {
+		t.Errorf("Expected only the latest vote of replica 2 to be pending, got %v", meta.Proposals)
+	}
+}
+
+func TestPipeliningWindowBackpressure(t *testing.T) {
+	config := loadConfig()
+	config.Set("general.batchsize", 2)
+	config.Set("general.K", 1)
+	config.Set("general.logmultiplier", 2)
+	config.Set("general.window", 1)
+	omni := &omniProto{
+		UnicastImpl: func(ocMsg *pb.Message, dest *pb.PeerID) error { return nil },
+	}
+	b := newObcBatch(0, config, omni)
+	defer b.Close()
+
+	var reqs []*Request
+	for i := int64(0); i < 4; i++ {
+		req := createRequestForChaincode(i, "cc")
+		reqs = append(reqs, req)
+		if msg := b.leaderProcReq(req); msg != nil {
+			events.SendEvent(b, msg)
+		}
+	}
+	if b.pbft.seqNo != 1 || len(b.batchStore) != 2 {
+		t.Fatalf("Expected the primary to order one batch and keep 2 requests, ordered up to seqNo %d and kept %d", b.pbft.seqNo, len(b.batchStore))
+	}
+
+	// The window orders 2 requests, and the primary holds 4
+	tx := &pb.Message{Type: pb.Message_CHAIN_TRANSACTION, Payload: reqs[0].Payload}
+	if err := b.RecvMsg(tx, &pb.PeerID{Name: "vp0"}); err != consensus.ErrBackpressure {
+		t.Fatalf("Expected a transaction to be turned down while the window is full, got %v", err)
+	}
+
+	// The first batch committed and executed, and a checkpoint moved the
+	// watermarks
+	delete(b.pbft.outstandingReqs, b.pbft.certStore[msgID{0, 1}].digest)
+	for _, req := range reqs[:2] {
+		b.requestExecuted(req, 1)
+	}
+	b.pbft.lastExec = 1
+	b.pbft.moveWatermarks(1)
+	b.cutBatches()
+	if b.pbft.seqNo != 2 || len(b.batchStore) != 0 {
+		t.Fatalf("Expected the primary to order the kept requests once the window moved, ordered up to seqNo %d and kept %d", b.pbft.seqNo, len(b.batchStore))
+	}
+	b.updateBackpressure()
+	if b.backpressured != 0 {
+		t.Fatalf("Expected the backpressure to stop once the backlog drained")
+	}
+}
+
+func TestVerifierWorkers(t *testing.T) {
+	config := loadConfig()
+	config.Set("general.verification.workers", 2)
+	config.Set("general.verification.queuesize", 1)
+	var lock sync.Mutex
+	verified := 0
+	omni := &omniProto{
+		VerifyImpl: func(peerID *pb.PeerID, signature []byte, message []byte) error {
+			lock.Lock()
+			defer lock.Unlock()
+			verified++
+			if string(signature) != "sig" {
+				return fmt.Errorf("signature does not verify")
+			}
+			return nil
+		},
+	}
+	deliver := make(chan events.Event, 10)
+	v := newVerifier(1, config, omni, nil, deliver)
+	defer v.halt()
+
+	checkpoint := func(seqNo uint64, sig string) *pb.Message {
+		chkpt := &Checkpoint{SequenceNumber: seqNo, ReplicaId: 0, Id: "state", Signature: []byte(sig)}
+		payload := mustMarshal(&BatchMessage{&BatchMessage_PbftMessage{mustMarshal(&Message{&Message_Checkpoint{chkpt}})}})
+		return &pb.Message{Type: pb.Message_CONSENSUS, Payload: payload}
+	}
+	for seqNo := uint64(1); seqNo <= 5; seqNo++ {
+		sig := "sig"
+		if seqNo == 5 {
+			sig = "forged"
+		}
+		v.submit(batchMessage{msg: checkpoint(seqNo, sig), sender: &pb.PeerID{Name: "vp0"}})
+	}
+
+	// The messages of a sender reach the main thread in order
+	for seqNo := uint64(1); seqNo <= 5; seqNo++ {
+		event, ok := (<-deliver).(verifiedMessageEvent)
+		if !ok {
+			t.Fatalf("Expected a verified message")
+		}
+		batchMsg := &BatchMessage{}
+		msg := &Message{}
+		proto.Unmarshal(event.msg.Payload, batchMsg)
+		proto.Unmarshal(batchMsg.GetPbftMessage(), msg)
+		if msg.GetCheckpoint().SequenceNumber != seqNo {
+			t.Fatalf("Expected the checkpoint for seqNo %d, got %d", seqNo, msg.GetCheckpoint().SequenceNumber)
+		}
+	}
+	if verified != 5 {
+		t.Fatalf("Expected the workers to verify 5 signatures, verified %d", verified)
+	}
+
+	// The main thread takes the outcome of the checks
+	for seqNo, sig := range map[uint64]string{1: "sig", 5: "forged"} {
+		chkpt := &Checkpoint{SequenceNumber: seqNo, ReplicaId: 0, Id: "state", Signature: []byte(sig)}
+		raw, _ := signedBytes(chkpt)
+		checked, err := v.lookup(0, chkpt.Signature, raw)
+		if !checked || (err == nil) != (sig == "sig") {
+			t.Errorf("Expected the outcome of the signature of seqNo %d, got %v (%v)", seqNo, err, checked)
+		}
+		if checked, _ = v.lookup(0, chkpt.Signature, raw); checked {
+			t.Errorf("Expected the outcome of the signature of seqNo %d to be taken once", seqNo)
+		}
+	}
+}
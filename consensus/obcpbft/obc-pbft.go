@@ -22,6 +22,7 @@ import (
 	"path/filepath"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/hyperledger/fabric/consensus"
 	pb "github.com/hyperledger/fabric/protos"
@@ -32,40 +33,73 @@ import (
 
 const configPrefix = "CORE_PBFT"
 
-var pluginInstance consensus.Consenter // singleton service
 var config *viper.Viper
 
 func init() {
 	config = loadConfig()
+	consensus.RegisterPlugin("pbft", newPlugin)
+	consensus.RegisterPlugin("sieve", func() consensus.Plugin { return &obcSieve{} })
 }
 
-// GetPlugin returns the handle to the Consenter singleton
-func GetPlugin(c consensus.Stack) consensus.Consenter {
-	if pluginInstance == nil {
-		pluginInstance = New(c)
-	}
-	return pluginInstance
-}
-
-// New creates a new Obc* instance that provides the Consenter interface.
-// Internally, it uses an opaque pbft-core instance.
-func New(stack consensus.Stack) consensus.Consenter {
-	handle, _, _ := stack.GetNetworkHandles()
-	id, _ := getValidatorID(handle)
-
+// newPlugin returns an uninitialized Obc* instance of the configured mode,
+// which provides the Plugin interface
+func newPlugin() consensus.Plugin {
 	switch strings.ToLower(config.GetString("general.mode")) {
 	case "classic":
 		config.Set("general.batchsize", 1)
-		return newObcBatch(id, config, stack)
+		return &obcBatch{}
 	case "batch":
-		return newObcBatch(id, config, stack)
+		return &obcBatch{}
 	case "sieve":
-		return newObcSieve(id, config, stack)
+		return &obcSieve{}
 	default:
 		panic(fmt.Errorf("Invalid PBFT mode: %s", config.GetString("general.mode")))
 	}
 }
 
+// validateConfig checks the settings that pbft-core and the batch plugin would
+// otherwise panic on, once the peer is already connected to the network
+func validateConfig(config *viper.Viper) error {
+	N, f := config.GetInt("general.N"), config.GetInt("general.f")
+	if f*3+1 > N {
+		return fmt.Errorf("Need at least %d replicas to tolerate %d byzantine faults, but only %d replicas configured", f*3+1, f, N)
+	}
+	if config.GetInt("general.K") < 1 {
+		return fmt.Errorf("Checkpoint period must be greater than or equal to 1")
+	}
+	if config.GetInt("general.logmultiplier") < 2 {
+		return fmt.Errorf("Log multiplier must be greater than or equal to 2")
+	}
+	if window, L := config.GetInt("general.window"), config.GetInt("general.K")*config.GetInt("general.logmultiplier"); window < 0 || window > L {
+		return fmt.Errorf("Pipelining window %d must be between 0 and the log size %d", window, L)
+	}
+	if workers := config.GetInt("general.verification.workers"); workers < 0 {
+		return fmt.Errorf("Verification workers must be greater than or equal to 0")
+	} else if workers > 0 && config.GetInt("general.verification.queuesize") < 1 {
+		return fmt.Errorf("Verification queue size must be greater than or equal to 1")
+	}
+	if config.GetBool("general.readonly.enabled") && config.GetInt("general.readonly.executions") < 1 {
+		return fmt.Errorf("Read-only executions must be greater than or equal to 1")
+	}
+	for _, key := range []string{"general.timeout.request", "general.timeout.viewchange"} {
+		if _, err := time.ParseDuration(config.GetString(key)); err != nil {
+			return fmt.Errorf("Cannot parse %s: %s", key, err)
+		}
+	}
+	return nil
+}
+
+// New creates and starts a new Obc* instance that provides the Consenter
+// interface. Internally, it uses an opaque pbft-core instance.
+func New(stack consensus.Stack) consensus.Consenter {
+	plugin := newPlugin()
+	if err := plugin.Init(stack, consensus.NewTimerFactory()); err != nil {
+		panic(err)
+	}
+	plugin.Start()
+	return plugin
+}
+
 func loadConfig() (config *viper.Viper) {
 	config = viper.New()
 
@@ -110,6 +144,15 @@ func getValidatorID(handle *pb.PeerID) (id uint64, err error) {
 	return
 }
 
+// Returns the uint64 ID of the validator of the stack
+func getStackValidatorID(stack consensus.Stack) (uint64, error) {
+	handle, _, err := stack.GetNetworkHandles()
+	if err != nil {
+		return 0, err
+	}
+	return getValidatorID(handle)
+}
+
 // Returns the peer handle that corresponds to a validator ID (uint64 assigned to it for PBFT)
 func getValidatorHandle(id uint64) (handle *pb.PeerID, err error) {
 	// as requested here: https://github.com/hyperledger/fabric/issues/462#issuecomment-170785410
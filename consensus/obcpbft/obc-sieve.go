@@ -79,12 +79,18 @@ type msgWithSender struct {
 }
 
 func newObcSieve(id uint64, config *viper.Viper, stack consensus.Stack) *obcSieve {
-	op := &obcSieve{
-		legacyGenericShim: legacyGenericShim{
-			obcGeneric: &obcGeneric{stack: stack},
-		},
-		id: id,
+	op := &obcSieve{}
+	op.init(id, config, stack)
+	op.Start()
+	return op
+}
+
+// init sets the replica up, Start starts its main loop
+func (op *obcSieve) init(id uint64, config *viper.Viper, stack consensus.Stack) {
+	op.legacyGenericShim = legacyGenericShim{
+		obcGeneric: &obcGeneric{stack: stack},
 	}
+	op.id = id
 	op.queuedExec = make(map[uint64]*Execute)
 	op.persistForward.persistor = stack
 
@@ -100,10 +106,33 @@ func newObcSieve(id uint64, config *viper.Viper, stack consensus.Stack) *obcSiev
 	op.stateUpdatedChan = make(chan *checkpointMessage)
 
 	op.idleChan = make(chan struct{})
+}
+
+// Init sets the replica up as the validator of stack. PBFT arms its timers on
+// its event manager, rather than with timers.
+func (op *obcSieve) Init(stack consensus.Stack, timers consensus.TimerFactory) error {
+	id, err := getStackValidatorID(stack)
+	if err != nil {
+		return err
+	}
+	op.init(id, config, stack)
+	return nil
+}
+
+// ValidateConfig checks the PBFT configuration before the peer starts
+func (op *obcSieve) ValidateConfig() error {
+	return validateConfig(config)
+}
 
+// Start starts processing the messages and events of the replica
+func (op *obcSieve) Start() error {
 	go op.main()
+	return nil
+}
 
-	return op
+// Stop releases the resources of the replica
+func (op *obcSieve) Stop() {
+	op.Close()
 }
 
 // moreCorrectThanByzantineQuorum returns the number of replicas that
@@ -426,7 +455,7 @@ func (op *obcSieve) processExecute() {
 
 	logger.Debugf("Sieve replica %d results=%x err=%v using lastPbftExec of %d", op.id, results, err, op.lastExecPbftSeqNo)
 
-	meta, _ := proto.Marshal(&Metadata{op.lastExecPbftSeqNo})
+	meta, _ := proto.Marshal(&Metadata{SeqNo: op.lastExecPbftSeqNo})
 	op.currentResult, err = op.stack.PreviewCommitTxBatch(op.currentReq, meta)
 	if err != nil {
 		logger.Errorf("could not preview next block: %s", err)
@@ -828,7 +857,7 @@ func (op *obcSieve) rollback() {
 }
 
 func (op *obcSieve) commit() {
-	meta, _ := proto.Marshal(&Metadata{op.lastExecPbftSeqNo})
+	meta, _ := proto.Marshal(&Metadata{SeqNo: op.lastExecPbftSeqNo})
 	op.stack.CommitTxBatch(op.currentReq, meta)
 	op.currentReq = ""
 }
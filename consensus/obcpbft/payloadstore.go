@@ -0,0 +1,283 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package obcpbft
+
+import (
+	"bytes"
+	"errors"
+	"time"
+
+	"github.com/hyperledger/fabric/consensus/obcpbft/events"
+	"github.com/hyperledger/fabric/core/util"
+	pb "github.com/hyperledger/fabric/protos"
+)
+
+// Payloads larger than the payload threshold are not ordered: their request
+// only carries the hash of the payload, and the payload travels on a side
+// channel. The replica the transaction was submitted to pushes the payload to
+// all replicas, a replica missing a payload fetches it by hash from the
+// replica that submitted it, and then from the others in turn. A replica
+// failing to deliver a payload within the fetch timeout is excluded from
+// further fetches.
+//
+// The primary only batches requests it holds the payload of, and backups only
+// prepare a batch once they hold all of its payloads. A committed batch is
+// thus held by at least one correct replica, while a primary ordering
+// payloads no one holds is replaced on request timeout.
+//
+// Any replica, or a peer relaying for one, may push payloads no request ever
+// orders, and the batches of the payloads we fetch may be skipped by a state
+// transfer. The payloads we hold but did not submit are thus dropped once a
+// log size of sequence numbers executed since they arrived, unless they
+// executed meanwhile. Those pushed to us before we needed them are only kept
+// up to a count and a size. A payload dropped, or not kept for lack of room,
+// is fetched again should a batch need it.
+
+// errMissingPayloads is returned by validate for batches whose payloads we do
+// not all hold yet
+var errMissingPayloads = errors.New("waiting for the payloads of the batch")
+
+// payloadTimerEvent is sent when the payload fetch timer expires
+type payloadTimerEvent struct{}
+
+type payloadFetch struct {
+	target uint64    // replica we last asked for the payload
+	asked  time.Time // when we asked it
+}
+
+type payloadStore struct {
+	payloads map[string][]byte        // keyed by hash
+	executed map[string]uint64        // sequence number payloads were executed at
+	received map[string]uint64        // payloads we did not submit, not executed yet, to the last sequence number executed when they arrived
+	fetches  map[string]*payloadFetch // payloads we are missing
+	excluded map[uint64]bool          // replicas which failed to deliver a payload
+
+	unsolicited         map[string]bool // payloads pushed to us before we needed them
+	unsolicitedBytes    int
+	maxUnsolicited      int // how many payloads pushed to us before we needed them we keep
+	maxUnsolicitedBytes int // and how many bytes of them
+}
+
+func newPayloadStore(maxUnsolicited int, maxUnsolicitedBytes int) *payloadStore {
+	return &payloadStore{
+		payloads:            make(map[string][]byte),
+		executed:            make(map[string]uint64),
+		received:            make(map[string]uint64),
+		fetches:             make(map[string]*payloadFetch),
+		excluded:            make(map[uint64]bool),
+		unsolicited:         make(map[string]bool),
+		maxUnsolicited:      maxUnsolicited,
+		maxUnsolicitedBytes: maxUnsolicitedBytes,
+	}
+}
+
+func (ps *payloadStore) get(hash []byte) []byte {
+	return ps.payloads[string(hash)]
+}
+
+func (ps *payloadStore) put(hash []byte, payload []byte) {
+	ps.payloads[string(hash)] = payload
+	delete(ps.fetches, string(hash))
+}
+
+// putReceived keeps a payload another replica sent us, after seqNo executed.
+// A payload we did not fetch is only kept if there is room for it, and
+// putReceived returns whether there was.
+func (ps *payloadStore) putReceived(hash []byte, payload []byte, seqNo uint64) bool {
+	_, fetched := ps.fetches[string(hash)]
+	if !fetched {
+		if len(ps.unsolicited) >= ps.maxUnsolicited || ps.unsolicitedBytes+len(payload) > ps.maxUnsolicitedBytes {
+			return false
+		}
+		ps.unsolicited[string(hash)] = true
+		ps.unsolicitedBytes += len(payload)
+	}
+	ps.put(hash, payload)
+	ps.received[string(hash)] = seqNo
+	return true
+}
+
+// executedAt records that the payload executed at seqNo
+func (ps *payloadStore) executedAt(hash []byte, seqNo uint64) {
+	ps.executed[string(hash)] = seqNo
+	delete(ps.received, string(hash))
+}
+
+func (ps *payloadStore) drop(hash string) {
+	if ps.unsolicited[hash] {
+		ps.unsolicitedBytes -= len(ps.payloads[hash])
+		delete(ps.unsolicited, hash)
+	}
+	delete(ps.payloads, hash)
+	delete(ps.executed, hash)
+	delete(ps.received, hash)
+}
+
+// collect drops the payloads executed before seqNo, and those received
+// before seqNo executed which did not execute since
+func (ps *payloadStore) collect(seqNo uint64) {
+	for hash, n := range ps.executed {
+		if n < seqNo {
+			ps.drop(hash)
+		}
+	}
+	for hash, n := range ps.received {
+		if n < seqNo {
+			ps.drop(hash)
+		}
+	}
+}
+
+// detachPayload replaces the payload of a request above the payload
+// threshold by its hash, and pushes the payload to all replicas
+func (op *obcBatch) detachPayload(req *Request) {
+	if op.payloadThreshold <= 0 || len(req.Payload) <= op.payloadThreshold {
+		return
+	}
+	hash := util.ComputeCryptoHash(req.Payload)
+	logger.Debugf("Replica %d distributing payload %x of %d bytes off consensus", op.pbft.id, hash, len(req.Payload))
+	payloadsDetached.Inc()
+	op.payloads.put(hash, req.Payload)
+	op.broadcastMsg(&BatchMessage{&BatchMessage_PayloadData{&PayloadData{Hash: hash, Data: req.Payload}}})
+	req.Payload = nil
+	req.PayloadHash = hash
+}
+
+// fetchPayloads fetches the payloads of reqs we do not hold, and returns
+// whether there are any
+func (op *obcBatch) fetchPayloads(reqs []*Request) bool {
+	missing := false
+	for _, req := range reqs {
+		if req.PayloadHash == nil || op.payloads.get(req.PayloadHash) != nil {
+			continue
+		}
+		missing = true
+		if _, ok := op.payloads.fetches[string(req.PayloadHash)]; !ok {
+			op.askForPayload(req.PayloadHash, op.payloadSource(req.ReplicaId))
+		}
+	}
+	return missing
+}
+
+// payloadSource returns the first replica, starting with replica from, which
+// was not excluded from payload fetches
+func (op *obcBatch) payloadSource(from uint64) uint64 {
+	N := uint64(op.pbft.N)
+	for i := uint64(0); i < N; i++ {
+		id := (from + i) % N
+		if id != op.pbft.id && !op.payloads.excluded[id] {
+			return id
+		}
+	}
+	logger.Warningf("Replica %d excluded all replicas from payload fetches, readmitting them", op.pbft.id)
+	op.payloads.excluded = make(map[uint64]bool)
+	if from%N == op.pbft.id {
+		return (from + 1) % N
+	}
+	return from % N
+}
+
+func (op *obcBatch) askForPayload(hash []byte, target uint64) {
+	logger.Debugf("Replica %d fetching payload %x from replica %d", op.pbft.id, hash, target)
+	op.payloads.fetches[string(hash)] = &payloadFetch{target: target, asked: time.Now()}
+	op.unicastMsg(&BatchMessage{&BatchMessage_FetchPayload{hash}}, target)
+	if !op.payloadTimerActive {
+		op.payloadTimer.Reset(op.payloadFetchTimeout, payloadTimerEvent{})
+		op.payloadTimerActive = true
+	}
+}
+
+// payloadTimerExpired excludes the replicas which did not deliver the payload
+// we asked them for in time, and asks the next replica instead
+func (op *obcBatch) payloadTimerExpired() {
+	op.payloadTimerActive = false
+	now := time.Now()
+	for hash, fetch := range op.payloads.fetches {
+		if now.Sub(fetch.asked) < op.payloadFetchTimeout {
+			continue
+		}
+		logger.Warningf("Replica %d excluding replica %d from payload fetches, it did not deliver payload %x in time",
+			op.pbft.id, fetch.target, []byte(hash))
+		op.payloads.excluded[fetch.target] = true
+		op.askForPayload([]byte(hash), op.payloadSource(fetch.target+1))
+	}
+	if len(op.payloads.fetches) > 0 && !op.payloadTimerActive {
+		op.payloadTimer.Reset(op.payloadFetchTimeout, payloadTimerEvent{})
+		op.payloadTimerActive = true
+	}
+}
+
+func (op *obcBatch) recvFetchPayload(hash []byte, senderHandle *pb.PeerID) {
+	payload := op.payloads.get(hash)
+	if payload == nil {
+		return // we don't have it either
+	}
+	senderID, err := getValidatorID(senderHandle)
+	if err != nil {
+		logger.Warningf("Replica %d cannot map sender's PeerID to a valid replica ID: %s", op.pbft.id, err)
+		return
+	}
+	op.unicastMsg(&BatchMessage{&BatchMessage_PayloadData{&PayloadData{Hash: hash, Data: payload}}}, senderID)
+}
+
+func (op *obcBatch) recvPayloadData(data *PayloadData) events.Event {
+	if !bytes.Equal(util.ComputeCryptoHash(data.Data), data.Hash) {
+		logger.Warningf("Replica %d received payload not matching its hash %x", op.pbft.id, data.Hash)
+		return nil
+	}
+	if op.payloads.get(data.Hash) != nil {
+		return nil
+	}
+	if _, ok := op.payloads.fetches[string(data.Hash)]; ok {
+		payloadsFetched.Inc()
+	}
+	if !op.payloads.putReceived(data.Hash, data.Data, op.pbft.lastExec) {
+		logger.Debugf("Replica %d dropping payload %x pushed to it, it holds too many payloads it did not fetch", op.pbft.id, data.Hash)
+		payloadsRefused.Inc()
+		return nil
+	}
+
+	// Requests the primary held back
+	waiting := op.payloadWaiting
+	op.payloadWaiting = nil
+	if op.pbft.primary(op.pbft.view) == op.pbft.id && op.pbft.activeView {
+		for _, req := range waiting {
+			if msg := op.leaderProcReq(req); msg != nil {
+				op.manager.Inject(msg)
+			}
+		}
+	}
+
+	// Pre-prepares we did not prepare yet
+	for idx, cert := range op.pbft.certStore {
+		if idx.v != op.pbft.view || cert.prePrepare == nil || cert.digest == "" {
+			continue
+		}
+		if _, ok := op.pbft.reqStore[cert.digest]; !ok {
+			if err := op.pbft.recvPrePrepare(cert.prePrepare); err != nil {
+				logger.Debugf("Replica %d still cannot prepare view=%d/seqNo=%d: %s", op.pbft.id, idx.v, idx.n, err)
+			}
+		}
+	}
+
+	// The execution waiting for its payloads
+	if exec := op.pendingExec; exec != nil {
+		op.pendingExec = nil
+		op.execute(exec.seqNo, exec.raw)
+	}
+	return nil
+}
@@ -21,12 +21,15 @@ import (
 	"fmt"
 	"math/rand"
 	"sort"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/hyperledger/fabric/consensus"
 	"github.com/hyperledger/fabric/consensus/obcpbft/events"
 	_ "github.com/hyperledger/fabric/core" // Needed for logging format init
+	"github.com/hyperledger/fabric/core/util"
+	pb "github.com/hyperledger/fabric/protos"
 
 	"github.com/golang/protobuf/proto"
 	"github.com/op/go-logging"
@@ -92,6 +95,8 @@ type innerStack interface {
 	invalidateState()
 	validateState()
 
+	reconfigured(config *Reconfiguration) // Called once a reconfiguration takes effect
+
 	consensus.StatePersistor
 }
 
@@ -131,24 +136,32 @@ type pbftCore struct {
 	K             uint64            // checkpoint period
 	logMultiplier uint64            // use this value to calculate log size : k*logMultiplier
 	L             uint64            // log size
+	window        uint64            // how many sequence numbers past h the primary may assign, 0 for half the log
 	lastExec      uint64            // last request we executed
 	replicaCount  int               // number of replicas; PBFT `|R|`
+	epoch         uint64            // reconfigurations in effect, 0 while N and f are those configured
+	nextConfig    *Reconfiguration  // reconfiguration ordered, waiting for the stable checkpoint after it
 	seqNo         uint64            // PBFT "n", strictly monotonic increasing sequence number
 	view          uint64            // current view
 	chkpts        map[uint64]string // state checkpoints; map lastExec to global hash
 	pset          map[uint64]*ViewChange_PQ
 	qset          map[qidx]*ViewChange_PQ
 
-	skipInProgress    bool               // Set when we have detected a fall behind scenario until we pick a new starting point
-	stateTransferring bool               // Set when state transfer is executing
-	highStateTarget   *stateUpdateTarget // Set to the highest weak checkpoint cert we have observed
-	hChkpts           map[uint64]uint64  // highest checkpoint sequence number observed for each replica
+	skipInProgress    bool                   // Set when we have detected a fall behind scenario until we pick a new starting point
+	stateTransferring bool                   // Set when state transfer is executing
+	highStateTarget   *stateUpdateTarget     // Set to the highest weak checkpoint cert we have observed
+	quarantined       bool                   // Set when our state diverged from the network, until we resync
+	autoResync        bool                   // whether to resync through state transfer when quarantined
+	hChkpts           map[uint64]*Checkpoint // highest checkpoint observed above our high watermark for each replica
 
 	currentExec        *uint64             // currently executing request
+	speculative        bool                // execute requests once prepared, see speculation.go
+	tentative          *tentativeExec      // request executing ahead of its commit certificate, nil if none
 	timerActive        bool                // is the timer running?
 	newViewTimer       events.Timer        // timeout triggering a view change
 	requestTimeout     time.Duration       // progress timeout for requests
 	newViewTimeout     time.Duration       // progress timeout for new views
+	newViewTimeoutMax  time.Duration       // cap on the doubled progress timeout for new views, 0 for none
 	newViewTimerReason string              // what triggered the timer
 	lastNewViewTimeout time.Duration       // last timeout we used during this view change
 	outstandingReqs    map[string]*Request // track whether we are waiting for requests to execute
@@ -160,12 +173,28 @@ type pbftCore struct {
 
 	missingReqs map[string]bool // for all the assigned, non-checkpointed requests we might be missing during view-change
 
+	relayFanout      int                // children of each replica in the request relay tree, 0 if requests are sent along with pre-prepares
+	relayStore       map[msgID]*Request // requests relayed to us, waiting to be checked against their pre-prepare
+	relayTimer       events.Timer       // timeout triggering a fetch of the requests our parent in the relay tree did not pass on
+	relayTimeout     time.Duration      // how long our parent in the relay tree may take to pass a request on
+	relayTimerActive bool               // is the relay timer running?
+
+	certFetchTimer       events.Timer                      // timeout triggering a fetch of the sequence numbers we did not commit
+	certFetchTimeout     time.Duration                     // how long a gap in the committed sequence numbers may last, 0 to never fetch
+	certFetchTimerActive bool                              // is the certificate fetch timer running?
+	certReplies          map[uint64]map[uint64]*PrePrepare // pre-prepares replicas answered our fetch with, by seqNo and replica
+
+	recoveryTimer  events.Timer   // timeout triggering the next proactive recovery
+	recoveryPeriod time.Duration  // how often the replica recovers, 0 to never
+	recovery       *recoveryState // recovery in progress, nil if none
+
 	// implementation of PBFT `in`
-	reqStore        map[string]*Request   // track requests
-	certStore       map[msgID]*msgCert    // track quorum certificates for requests
-	checkpointStore map[Checkpoint]bool   // track checkpoints as set
-	viewChangeStore map[vcidx]*ViewChange // track view-change messages
-	newViewStore    map[uint64]*NewView   // track last new-view we received or sent
+	reqStore        map[string]*Request          // track requests
+	certStore       map[msgID]*msgCert           // track quorum certificates for requests
+	checkpointStore map[chkptidx]*Checkpoint     // track checkpoints as set
+	viewChangeStore map[vcidx]*ViewChange        // track view-change messages
+	newViewStore    map[uint64]*NewView          // track last new-view we received or sent
+	execResultStore map[uint64]map[uint64]string // track execution results reported for a seqNo, by replica
 }
 
 type qidx struct {
@@ -173,6 +202,12 @@ type qidx struct {
 	n uint64
 }
 
+type chkptidx struct { // our index through checkpointStore
+	n       uint64
+	id      string
+	replica uint64
+}
+
 type msgID struct { // our index through certStore
 	v uint64
 	n uint64
@@ -182,9 +217,18 @@ type msgCert struct {
 	digest      string
 	prePrepare  *PrePrepare
 	sentPrepare bool
+	relayed     bool
+	relayFetch  bool // the request was fetched from all replicas, as our relay parent withheld it
 	prepare     []*Prepare
 	sentCommit  bool
 	commit      []*Commit
+	fetched     bool // committed according to f+1 replicas we fetched it from
+
+	// when the certificate got its pre-prepare, prepare and commit quorums,
+	// for the latency metrics
+	prePreparedAt time.Time
+	preparedAt    time.Time
+	committedAt   time.Time
 }
 
 type vcidx struct {
@@ -220,6 +264,9 @@ func newPbftCore(id uint64, config *viper.Viper, consumer innerStack, etf events
 
 	instance.newViewTimer = etf.CreateTimer()
 	instance.nullRequestTimer = etf.CreateTimer()
+	instance.certFetchTimer = etf.CreateTimer()
+	instance.recoveryTimer = etf.CreateTimer()
+	instance.relayTimer = etf.CreateTimer()
 
 	instance.N = config.GetInt("general.N")
 	instance.f = config.GetInt("general.f")
@@ -228,28 +275,76 @@ func newPbftCore(id uint64, config *viper.Viper, consumer innerStack, etf events
 	}
 
 	instance.K = uint64(config.GetInt("general.K"))
+	if instance.K < 1 {
+		panic("Checkpoint period must be greater than or equal to 1")
+	}
 
 	instance.logMultiplier = uint64(config.GetInt("general.logmultiplier"))
 	if instance.logMultiplier < 2 {
 		panic("Log multiplier must be greater than or equal to 2")
 	}
 	instance.L = instance.logMultiplier * instance.K // log size
+	instance.window = uint64(config.GetInt("general.window"))
+	if instance.window > instance.L {
+		panic(fmt.Sprintf("Pipelining window %d exceeds the log size %d", instance.window, instance.L))
+	}
 	instance.viewChangePeriod = uint64(config.GetInt("general.viewchangeperiod"))
 
 	instance.byzantine = config.GetBool("general.byzantine")
+	instance.autoResync = !config.IsSet("general.quarantine.autoresync") || config.GetBool("general.quarantine.autoresync")
+
+	switch mode := strings.ToLower(config.GetString("general.dissemination.mode")); mode {
+	case "", "broadcast":
+	case "tree":
+		instance.relayFanout = config.GetInt("general.dissemination.fanout")
+		if instance.relayFanout < 1 {
+			panic(fmt.Sprintf("Request relay fanout must be at least 1, got %d", instance.relayFanout))
+		}
+	default:
+		panic(fmt.Sprintf("Unknown request dissemination mode %s, expected broadcast or tree", mode))
+	}
 
 	instance.requestTimeout, err = time.ParseDuration(config.GetString("general.timeout.request"))
 	if err != nil {
 		panic(fmt.Errorf("Cannot parse request timeout: %s", err))
 	}
+	if instance.relayFanout > 0 {
+		instance.relayTimeout, err = time.ParseDuration(config.GetString("general.dissemination.relaytimeout"))
+		if err != nil {
+			panic(fmt.Errorf("Cannot parse relay timeout: %s", err))
+		}
+		if instance.relayTimeout >= instance.requestTimeout {
+			panic(fmt.Errorf("Relay timeout %v must be below the request timeout %v", instance.relayTimeout, instance.requestTimeout))
+		}
+	}
 	instance.newViewTimeout, err = time.ParseDuration(config.GetString("general.timeout.viewchange"))
 	if err != nil {
 		panic(fmt.Errorf("Cannot parse new view timeout: %s", err))
 	}
+	if max := config.GetString("general.timeout.viewchangemax"); max != "" {
+		instance.newViewTimeoutMax, err = time.ParseDuration(max)
+		if err != nil {
+			panic(fmt.Errorf("Cannot parse maximum new view timeout: %s", err))
+		}
+		if instance.newViewTimeoutMax != 0 && instance.newViewTimeoutMax < instance.newViewTimeout {
+			panic(fmt.Errorf("Maximum new view timeout %v is less than the new view timeout %v", instance.newViewTimeoutMax, instance.newViewTimeout))
+		}
+	}
 	instance.nullRequestTimeout, err = time.ParseDuration(config.GetString("general.timeout.nullrequest"))
 	if err != nil {
 		instance.nullRequestTimeout = 0
 	}
+	instance.certFetchTimeout, err = time.ParseDuration(config.GetString("general.timeout.certfetch"))
+	if err != nil {
+		instance.certFetchTimeout = 0
+	}
+	instance.recoveryPeriod, err = time.ParseDuration(config.GetString("general.recovery.period"))
+	if err != nil {
+		instance.recoveryPeriod = 0
+	}
+	if _, ok := consumer.(speculativeStack); ok {
+		instance.speculative = config.GetBool("general.speculative")
+	}
 
 	instance.activeView = true
 	instance.replicaCount = instance.N
@@ -258,16 +353,38 @@ func newPbftCore(id uint64, config *viper.Viper, consumer innerStack, etf events
 	logger.Infof("PBFT Max number of validating peers (N) = %v", instance.N)
 	logger.Infof("PBFT Max number of failing peers (f) = %v", instance.f)
 	logger.Infof("PBFT byzantine flag = %v", instance.byzantine)
+	if instance.relayFanout > 0 {
+		logger.Infof("PBFT requests relayed over a tree of fanout %v, relay timeout = %v", instance.relayFanout, instance.relayTimeout)
+	} else {
+		logger.Infof("PBFT requests broadcast along with pre-prepares")
+	}
 	logger.Infof("PBFT request timeout = %v", instance.requestTimeout)
 	logger.Infof("PBFT view change timeout = %v", instance.newViewTimeout)
+	if instance.newViewTimeoutMax > 0 {
+		logger.Infof("PBFT maximum view change timeout = %v", instance.newViewTimeoutMax)
+	}
 	logger.Infof("PBFT Checkpoint period (K) = %v", instance.K)
 	logger.Infof("PBFT Log multiplier = %v", instance.logMultiplier)
 	logger.Infof("PBFT log size (L) = %v", instance.L)
+	logger.Infof("PBFT pipelining window = %v", instance.windowSize())
 	if instance.nullRequestTimeout > 0 {
 		logger.Infof("PBFT null requests timeout = %v", instance.nullRequestTimeout)
 	} else {
 		logger.Infof("PBFT null requests disabled")
 	}
+	if instance.certFetchTimeout > 0 {
+		logger.Infof("PBFT certificate fetch timeout = %v", instance.certFetchTimeout)
+	} else {
+		logger.Infof("PBFT certificate fetch disabled")
+	}
+	if instance.speculative {
+		logger.Infof("PBFT speculative execution enabled")
+	}
+	if instance.recoveryPeriod > 0 {
+		logger.Infof("PBFT proactive recovery period = %v", instance.recoveryPeriod)
+	} else {
+		logger.Infof("PBFT proactive recovery disabled")
+	}
 	if instance.viewChangePeriod > 0 {
 		logger.Infof("PBFT view change period = %v", instance.viewChangePeriod)
 	} else {
@@ -277,27 +394,32 @@ func newPbftCore(id uint64, config *viper.Viper, consumer innerStack, etf events
 	// init the logs
 	instance.certStore = make(map[msgID]*msgCert)
 	instance.reqStore = make(map[string]*Request)
-	instance.checkpointStore = make(map[Checkpoint]bool)
+	instance.checkpointStore = make(map[chkptidx]*Checkpoint)
 	instance.chkpts = make(map[uint64]string)
 	instance.viewChangeStore = make(map[vcidx]*ViewChange)
 	instance.pset = make(map[uint64]*ViewChange_PQ)
 	instance.qset = make(map[qidx]*ViewChange_PQ)
 	instance.newViewStore = make(map[uint64]*NewView)
+	instance.execResultStore = make(map[uint64]map[uint64]string)
 
 	// initialize state transfer
-	instance.hChkpts = make(map[uint64]uint64)
+	instance.hChkpts = make(map[uint64]*Checkpoint)
 
 	instance.chkpts[0] = "XXX GENESIS"
 
 	instance.lastNewViewTimeout = instance.newViewTimeout
 	instance.outstandingReqs = make(map[string]*Request)
 	instance.missingReqs = make(map[string]bool)
+	instance.relayStore = make(map[msgID]*Request)
+	instance.certReplies = make(map[uint64]map[uint64]*PrePrepare)
 
 	instance.restoreState()
 
 	instance.viewChangeSeqNo = ^uint64(0) // infinity
 	instance.updateViewChangeSeqNo()
 
+	instance.scheduleRecovery()
+
 	return instance
 }
 
@@ -305,6 +427,9 @@ func newPbftCore(id uint64, config *viper.Viper, consumer innerStack, etf events
 func (instance *pbftCore) close() {
 	instance.newViewTimer.Halt()
 	instance.nullRequestTimer.Halt()
+	instance.certFetchTimer.Halt()
+	instance.recoveryTimer.Halt()
+	instance.relayTimer.Halt()
 }
 
 // allow the view-change protocol to kick-off when the timer expires
@@ -323,6 +448,7 @@ func (instance *pbftCore) ProcessEvent(e events.Event) events.Event {
 	case pbftMessageEvent:
 		msg := et
 		logger.Debugf("Replica %d received incoming message from %v", instance.id, msg.sender)
+		messagesReceived.With(messageType(msg.msg)).Inc()
 		next, err := instance.recvMsg(msg.msg, msg.sender)
 		if err != nil {
 			break
@@ -346,11 +472,28 @@ func (instance *pbftCore) ProcessEvent(e events.Event) events.Event {
 		err = instance.recvFetchRequest(et)
 	case returnRequestEvent:
 		return instance.recvReturnRequest(et)
+	case *RelayRequest:
+		err = instance.recvRelayRequest(et)
+	case *FetchCertificate:
+		err = instance.recvFetchCertificate(et)
+	case *CommittedCertificate:
+		err = instance.recvCommittedCertificate(et)
+	case certFetchTimerEvent:
+		instance.certFetchTimerExpired()
+	case relayTimerEvent:
+		instance.relayTimerExpired()
+	case *RecoveryRequest:
+		err = instance.recvRecoveryRequest(et)
+	case *RecoveryReply:
+		instance.recvRecoveryReply(et)
+	case recoveryTimerEvent:
+		instance.recover()
 	case stateUpdatedEvent:
 		update := et.chkpt
 		instance.stateTransferring = false
 		// If state transfer did not complete successfully, or if it did not reach our low watermark, do it again
 		if et.target == nil || update.seqNo < instance.h {
+			instance.publish(&pb.ConsensusEvent{Type: pb.ConsensusEvent_STATE_TRANSFER_FINISHED, View: instance.view, SeqNo: update.seqNo})
 			if et.target == nil {
 				logger.Warningf("Replica %d attempted state transfer target was not reachable (%v)", instance.id, et.chkpt)
 			} else {
@@ -367,12 +510,18 @@ func (instance *pbftCore) ProcessEvent(e events.Event) events.Event {
 			return nil
 		}
 		logger.Infof("Replica %d application caught up via state transfer, lastExec now %d", instance.id, update.seqNo)
+		instance.publish(&pb.ConsensusEvent{Type: pb.ConsensusEvent_STATE_TRANSFER_FINISHED, View: instance.view, SeqNo: update.seqNo, Success: true})
 		// XXX create checkpoint
 		instance.lastExec = update.seqNo
 		instance.moveWatermarks(instance.lastExec) // The watermark movement handles moving this to a checkpoint boundary
 		instance.skipInProgress = false
+		instance.leaveQuarantine()
 		instance.consumer.validateState()
+		instance.recordExecResult(instance.lastExec)
 		instance.executeOutstanding()
+	case rolledBackEvent:
+		instance.tentativeRolledBack()
+		return instance.processNewView()
 	case execDoneEvent:
 		instance.execDoneSync()
 		if instance.skipInProgress {
@@ -434,9 +583,18 @@ func (instance *pbftCore) getCert(v uint64, n uint64) (cert *msgCert) {
 
 	cert = &msgCert{}
 	instance.certStore[idx] = cert
+	instance.updateLogMetrics()
 	return
 }
 
+// publish passes a lifecycle event of the replica to the subscribers of the
+// consensus package
+func (instance *pbftCore) publish(ev *pb.ConsensusEvent) {
+	ev.Timestamp = util.CreateUtcTimestamp()
+	ev.Replica = instance.id
+	consensus.PublishLifecycle(ev)
+}
+
 // =============================================================================
 // preprepare/prepare/commit quorum checks
 // =============================================================================
@@ -504,6 +662,10 @@ func (instance *pbftCore) prepared(digest string, v uint64, n uint64) bool {
 }
 
 func (instance *pbftCore) committed(digest string, v uint64, n uint64) bool {
+	if cert := instance.certStore[msgID{v, n}]; cert != nil && cert.fetched && cert.digest == digest {
+		return true
+	}
+
 	if !instance.prepared(digest, v, n) {
 		return false
 	}
@@ -537,18 +699,22 @@ func (instance *pbftCore) nullRequestHandler() {
 
 	if instance.primary(instance.view) != instance.id {
 		// backup expected a null request, but primary never sent one
-		logger.Info("Replica %d null request timer expired, sending view change", instance.id)
+		logger.Infof("Replica %d null request timer expired, sending view change", instance.id)
 		instance.sendViewChange()
 	} else {
 		// time for the primary to send a null request
 		// pre-prepare with null digest
-		logger.Info("Primary %d null request timer expired, sending null request", instance.id)
+		logger.Infof("Primary %d null request timer expired, sending null request", instance.id)
 		instance.sendPrePrepare(nil, "")
 	}
 }
 
 func (instance *pbftCore) recvMsg(msg *Message, senderID uint64) (interface{}, error) {
 
+	if senderID >= uint64(instance.N) {
+		return nil, fmt.Errorf("Sender %d is not a replica of the network of %d replicas in epoch %d", senderID, instance.N, instance.epoch)
+	}
+
 	if req := msg.GetRequest(); req != nil {
 		if senderID != req.ReplicaId {
 			return nil, fmt.Errorf("Sender ID included in request message (%v) doesn't match ID corresponding to the receiving stream (%v)", req.ReplicaId, senderID)
@@ -592,6 +758,31 @@ func (instance *pbftCore) recvMsg(msg *Message, senderID uint64) (interface{}, e
 	} else if req := msg.GetReturnRequest(); req != nil {
 		// it's ok for sender ID and replica ID to differ; we're sending the original request message
 		return returnRequestEvent(req), nil
+	} else if relay := msg.GetRelayRequest(); relay != nil {
+		if senderID != relay.ReplicaId {
+			return nil, fmt.Errorf("Sender ID included in relay-request message (%v) doesn't match ID corresponding to the receiving stream (%v)", relay.ReplicaId, senderID)
+		}
+		return relay, nil
+	} else if fc := msg.GetFetchCertificate(); fc != nil {
+		if senderID != fc.ReplicaId {
+			return nil, fmt.Errorf("Sender ID included in fetch-certificate message (%v) doesn't match ID corresponding to the receiving stream (%v)", fc.ReplicaId, senderID)
+		}
+		return fc, nil
+	} else if cc := msg.GetCommittedCertificate(); cc != nil {
+		if senderID != cc.ReplicaId {
+			return nil, fmt.Errorf("Sender ID included in committed-certificate message (%v) doesn't match ID corresponding to the receiving stream (%v)", cc.ReplicaId, senderID)
+		}
+		return cc, nil
+	} else if rr := msg.GetRecoveryRequest(); rr != nil {
+		if senderID != rr.ReplicaId {
+			return nil, fmt.Errorf("Sender ID included in recovery-request message (%v) doesn't match ID corresponding to the receiving stream (%v)", rr.ReplicaId, senderID)
+		}
+		return rr, nil
+	} else if reply := msg.GetRecoveryReply(); reply != nil {
+		if senderID != reply.ReplicaId {
+			return nil, fmt.Errorf("Sender ID included in recovery-reply message (%v) doesn't match ID corresponding to the receiving stream (%v)", reply.ReplicaId, senderID)
+		}
+		return reply, nil
 	}
 
 	return nil, fmt.Errorf("Invalid message: %v", msg)
@@ -636,7 +827,7 @@ func (instance *pbftCore) sendPrePrepare(req *Request, digest string) {
 		}
 	}
 
-	if !instance.inWV(instance.view, n) || n > instance.h+instance.L/2 {
+	if !instance.inWV(instance.view, n) || n > instance.h+instance.windowSize() {
 		logger.Debugf("Replica %d is primary, not sending pre-prepare for request %s because it is out of sequence numbers", instance.id, digest)
 		return
 	}
@@ -658,10 +849,23 @@ func (instance *pbftCore) sendPrePrepare(req *Request, digest string) {
 	}
 	cert := instance.getCert(instance.view, n)
 	cert.prePrepare = preprep
+	cert.prePreparedAt = time.Now()
 	cert.digest = digest
 	instance.persistQSet()
+	instance.publish(&pb.ConsensusEvent{Type: pb.ConsensusEvent_PRE_PREPARED, View: instance.view, SeqNo: n, Digest: digest})
 
-	instance.innerBroadcast(&Message{&Message_PrePrepare{preprep}})
+	if instance.relayFanout > 0 && req != nil {
+		// The replicas get the request down the relay tree
+		instance.innerBroadcast(&Message{&Message_PrePrepare{&PrePrepare{
+			View:           preprep.View,
+			SequenceNumber: preprep.SequenceNumber,
+			RequestDigest:  preprep.RequestDigest,
+			ReplicaId:      preprep.ReplicaId,
+		}}})
+		instance.relayRequest(preprep.View, preprep.SequenceNumber, req)
+	} else {
+		instance.innerBroadcast(&Message{&Message_PrePrepare{preprep}})
+	}
 	instance.maybeSendCommit(digest, instance.view, n)
 }
 
@@ -739,26 +943,57 @@ func (instance *pbftCore) recvPrePrepare(preprep *PrePrepare) error {
 	}
 
 	cert.prePrepare = preprep
+	cert.prePreparedAt = time.Now()
 	cert.digest = preprep.RequestDigest
+	instance.publish(&pb.ConsensusEvent{Type: pb.ConsensusEvent_PRE_PREPARED, View: preprep.View, SeqNo: preprep.SequenceNumber, Digest: preprep.RequestDigest})
+
+	idx := msgID{v: preprep.View, n: preprep.SequenceNumber}
+	relayed := preprep.Request == nil && preprep.RequestDigest != ""
 
 	// Store the request if, for whatever reason, haven't received it from an earlier broadcast.
 	if _, ok := instance.reqStore[preprep.RequestDigest]; !ok && preprep.RequestDigest != "" {
-		digest := hashReq(preprep.Request)
+		req := preprep.Request
+		if relayed {
+			if req, ok = instance.relayStore[idx]; !ok {
+				logger.Debugf("Replica %d waiting for relayed request %s", instance.id, preprep.RequestDigest)
+				instance.softStartTimer(instance.requestTimeout, fmt.Sprintf("new pre-prepare for %s", preprep.RequestDigest))
+				instance.nullRequestTimer.Stop()
+				instance.startRelayTimer()
+				return nil
+			}
+		}
+		digest := hashReq(req)
 		if digest != preprep.RequestDigest {
 			logger.Warningf("Pre-prepare request and request digest do not match: request %s, digest %s",
 				digest, preprep.RequestDigest)
+			if relayed {
+				// Our parent in the relay tree is faulty, ask everyone else
+				delete(instance.relayStore, idx)
+				cert.relayFetch = true
+				return instance.fetchRequest(preprep.RequestDigest)
+			}
 			return nil
 		}
-		if err := instance.consumer.validate(preprep.Request.Payload); err != nil {
+		if err := instance.consumer.validate(req.Payload); err != nil {
 			logger.Warningf("Request %s did not verify: %s", digest, err)
 			return err
 		}
 
-		instance.reqStore[digest] = preprep.Request
+		instance.reqStore[digest] = req
 		logger.Debugf("Replica %d storing request %s in outstanding request store", instance.id, digest)
-		instance.outstandingReqs[digest] = preprep.Request
+		instance.outstandingReqs[digest] = req
 		instance.persistRequest(digest)
 	}
+	delete(instance.relayStore, idx)
+
+	if relayed {
+		// Keep the request with the pre-prepare, as if it came along
+		preprep.Request = instance.reqStore[preprep.RequestDigest]
+		if !cert.relayed {
+			cert.relayed = true
+			instance.relayRequest(preprep.View, preprep.SequenceNumber, preprep.Request)
+		}
+	}
 
 	instance.softStartTimer(instance.requestTimeout, fmt.Sprintf("new pre-prepare for %s", preprep.RequestDigest))
 	instance.nullRequestTimer.Stop()
@@ -812,6 +1047,9 @@ func (instance *pbftCore) recvPrepare(prep *Prepare) error {
 	}
 	cert.prepare = append(cert.prepare, prep)
 	instance.persistPSet()
+	if instance.prepared(prep.RequestDigest, prep.View, prep.SequenceNumber) {
+		instance.persistCert(msgID{prep.View, prep.SequenceNumber})
+	}
 
 	return instance.maybeSendCommit(prep.RequestDigest, prep.View, prep.SequenceNumber)
 }
@@ -829,10 +1067,19 @@ func (instance *pbftCore) maybeSendCommit(digest string, v uint64, n uint64) err
 			RequestDigest:  digest,
 			ReplicaId:      instance.id,
 		}
+		if execSeqNo, execID, ok := instance.lastExecResult(); ok {
+			commit.ExecSequenceNumber = execSeqNo
+			commit.ExecId = execID
+		}
 
 		cert.sentCommit = true
+		cert.preparedAt = time.Now()
+		observeSince(prepareLatency, cert.prePreparedAt)
 
 		instance.recvCommit(commit)
+		if instance.speculative {
+			instance.executeOutstanding()
+		}
 		return instance.innerBroadcast(&Message{&Message_Commit{commit}})
 	}
 
@@ -843,6 +1090,10 @@ func (instance *pbftCore) recvCommit(commit *Commit) error {
 	logger.Debugf("Replica %d received commit from replica %d for view=%d/seqNo=%d",
 		instance.id, commit.ReplicaId, commit.View, commit.SequenceNumber)
 
+	if commit.ReplicaId != instance.id && commit.ExecId != "" {
+		instance.recvExecResult(commit.ReplicaId, commit.ExecSequenceNumber, commit.ExecId)
+	}
+
 	if !instance.inWV(commit.View, commit.SequenceNumber) {
 		if commit.SequenceNumber != instance.h && !instance.skipInProgress {
 			logger.Warningf("Replica %d ignoring commit for view=%d/seqNo=%d: not in-wv, in view %d, high water mark %d", instance.id, commit.View, commit.SequenceNumber, instance.view, instance.h)
@@ -861,8 +1112,16 @@ func (instance *pbftCore) recvCommit(commit *Commit) error {
 		}
 	}
 	cert.commit = append(cert.commit, commit)
+	if instance.prepared(commit.RequestDigest, commit.View, commit.SequenceNumber) {
+		instance.persistCert(msgID{commit.View, commit.SequenceNumber})
+	}
 
 	if instance.committed(commit.RequestDigest, commit.View, commit.SequenceNumber) {
+		if cert.committedAt.IsZero() {
+			cert.committedAt = time.Now()
+			observeSince(commitLatency, cert.preparedAt)
+			instance.publish(&pb.ConsensusEvent{Type: pb.ConsensusEvent_COMMITTED, View: commit.View, SeqNo: commit.SequenceNumber, Digest: commit.RequestDigest})
+		}
 		instance.stopTimer()
 		instance.lastNewViewTimeout = instance.newViewTimeout
 		delete(instance.outstandingReqs, commit.RequestDigest)
@@ -898,7 +1157,16 @@ func (instance *pbftCore) stateTransfer(optional *stateUpdateTarget) {
 }
 
 func (instance *pbftCore) retryStateTransfer(optional *stateUpdateTarget) {
+	if instance.quarantined && !instance.autoResync {
+		logger.Debugf("Replica %d is quarantined without automatic resync, not initiating state transfer", instance.id)
+		return
+	}
+
 	if instance.currentExec != nil {
+		if instance.awaitingCommit() {
+			logger.Infof("Replica %d rolling back seqNo %d executed tentatively, to perform state transfer", instance.id, *instance.currentExec)
+			instance.rollbackTentative()
+		}
 		logger.Debugf("Replica %d is currently mid-execution, it must wait for the execution to complete before performing state transfer", instance.id)
 		return
 	}
@@ -918,6 +1186,7 @@ func (instance *pbftCore) retryStateTransfer(optional *stateUpdateTarget) {
 	}
 
 	instance.stateTransferring = true
+	instance.publish(&pb.ConsensusEvent{Type: pb.ConsensusEvent_STATE_TRANSFER_STARTED, View: instance.view, SeqNo: target.seqNo})
 
 	logger.Debugf("Replica %d is initiating state transfer to seqNo %d", instance.id, target.seqNo)
 	instance.consumer.skipTo(target.seqNo, target.id, target.replicas)
@@ -926,6 +1195,7 @@ func (instance *pbftCore) retryStateTransfer(optional *stateUpdateTarget) {
 
 func (instance *pbftCore) executeOutstanding() {
 	if instance.currentExec != nil {
+		instance.commitTentativeIfCommitted()
 		logger.Debugf("Replica %d not attempting to executeOutstanding because it is currently executing %d", instance.id, *instance.currentExec)
 		return
 	}
@@ -939,6 +1209,7 @@ func (instance *pbftCore) executeOutstanding() {
 
 	logger.Debugf("Replica %d certstore %+v", instance.id, instance.certStore)
 
+	instance.checkCertGap()
 	instance.startTimerIfOutstandingRequests()
 }
 
@@ -960,7 +1231,7 @@ func (instance *pbftCore) executeOne(idx msgID) bool {
 	req := instance.reqStore[digest]
 
 	if !instance.committed(digest, idx.v, idx.n) {
-		return false
+		return instance.maybeExecuteTentatively(idx, digest)
 	}
 
 	// we have a commit certificate for this request
@@ -977,6 +1248,7 @@ func (instance *pbftCore) executeOne(idx msgID) bool {
 			instance.id, idx.v, idx.n, digest)
 
 		// synchronously execute, it is the other side's responsibility to execute in the background if needed
+		batchesOrdered.Inc()
 		instance.consumer.execute(idx.n, req.Payload)
 	}
 	return true
@@ -998,6 +1270,7 @@ func (instance *pbftCore) Checkpoint(seqNo uint64, id []byte) {
 		ReplicaId:      instance.id,
 		Id:             idAsString,
 	}
+	instance.sign(chkpt)
 	instance.chkpts[seqNo] = idAsString
 
 	instance.persistCheckpoint(seqNo, id)
@@ -1009,6 +1282,7 @@ func (instance *pbftCore) execDoneSync() {
 	if instance.currentExec != nil {
 		logger.Infof("Replica %d finished execution %d, trying next", instance.id, *instance.currentExec)
 		instance.lastExec = *instance.currentExec
+		lastExecuted.Set(float64(instance.lastExec))
 		if instance.lastExec%instance.K == 0 {
 			instance.Checkpoint(instance.lastExec, instance.consumer.getState())
 		}
@@ -1019,6 +1293,11 @@ func (instance *pbftCore) execDoneSync() {
 		instance.skipInProgress = true
 	}
 	instance.currentExec = nil
+	instance.tentative = nil
+
+	if !instance.skipInProgress {
+		instance.recordExecResult(instance.lastExec)
+	}
 
 	instance.executeOutstanding()
 }
@@ -1032,16 +1311,23 @@ func (instance *pbftCore) moveWatermarks(n uint64) {
 			logger.Debugf("Replica %d cleaning quorum certificate for view=%d/seqNo=%d",
 				instance.id, idx.v, idx.n)
 			instance.persistDelRequest(cert.digest)
+			instance.persistDelCert(idx)
 			delete(instance.reqStore, cert.digest)
 			delete(instance.certStore, idx)
 		}
 	}
 
-	for testChkpt := range instance.checkpointStore {
+	for idx := range instance.relayStore {
+		if idx.n <= h {
+			delete(instance.relayStore, idx)
+		}
+	}
+
+	for idx, testChkpt := range instance.checkpointStore {
 		if testChkpt.SequenceNumber <= h {
 			logger.Debugf("Replica %d cleaning checkpoint message from replica %d, seqNo %d, b64 snapshot id %s",
 				instance.id, testChkpt.ReplicaId, testChkpt.SequenceNumber, testChkpt.Id)
-			delete(instance.checkpointStore, testChkpt)
+			delete(instance.checkpointStore, idx)
 		}
 	}
 
@@ -1064,14 +1350,55 @@ func (instance *pbftCore) moveWatermarks(n uint64) {
 		}
 	}
 
+	for n := range instance.execResultStore {
+		if n < h {
+			delete(instance.execResultStore, n)
+		}
+	}
+
+	instance.compactReqStore()
+
 	instance.h = h
+	instance.publish(&pb.ConsensusEvent{Type: pb.ConsensusEvent_CHECKPOINT_STABLE, View: instance.view, SeqNo: h})
+	checkpoints.Inc()
+	lowWatermark.Set(float64(h))
+	instance.updateLogMetrics()
 
 	logger.Debugf("Replica %d updated low watermark to %d",
 		instance.id, instance.h)
 
+	instance.applyReconfiguration()
 	instance.resubmitRequests()
 }
 
+// compactReqStore drops the requests no certificate refers to anymore, such
+// as those of the certificates dropped at a view change, unless a view change
+// may still need them, or we still wait for them to execute or for their
+// pre-prepare
+func (instance *pbftCore) compactReqStore() {
+	inUse := make(map[string]bool)
+	for _, cert := range instance.certStore {
+		inUse[cert.digest] = true
+	}
+	for _, p := range instance.pset {
+		inUse[p.Digest] = true
+	}
+	for _, q := range instance.qset {
+		inUse[q.Digest] = true
+	}
+	for digest := range instance.missingReqs {
+		inUse[digest] = true
+	}
+	for digest := range instance.reqStore {
+		if _, outstanding := instance.outstandingReqs[digest]; inUse[digest] || outstanding {
+			continue
+		}
+		logger.Debugf("Replica %d cleaning request %s no certificate refers to", instance.id, digest)
+		instance.persistDelRequest(digest)
+		delete(instance.reqStore, digest)
+	}
+}
+
 func (instance *pbftCore) weakCheckpointSetOutOfRange(chkpt *Checkpoint) bool {
 
 	H := instance.h + instance.L
@@ -1083,7 +1410,7 @@ func (instance *pbftCore) weakCheckpointSetOutOfRange(chkpt *Checkpoint) bool {
 	} else {
 		// We do not track the highest one, as a byzantine node could pick an arbitrarilly high sequence number
 		// and even if it recovered to be non-byzantine, we would still believe it to be far ahead
-		instance.hChkpts[chkpt.ReplicaId] = chkpt.SequenceNumber
+		instance.hChkpts[chkpt.ReplicaId] = chkpt
 
 		// If f+1 other replicas have reported checkpoints that were (at one time) outside our watermarks
 		// we need to check to see if we have fallen behind.
@@ -1091,9 +1418,9 @@ func (instance *pbftCore) weakCheckpointSetOutOfRange(chkpt *Checkpoint) bool {
 			chkptSeqNumArray := make([]uint64, len(instance.hChkpts))
 			index := 0
 			for replicaID, hChkpt := range instance.hChkpts {
-				chkptSeqNumArray[index] = hChkpt
+				chkptSeqNumArray[index] = hChkpt.SequenceNumber
 				index++
-				if hChkpt < H {
+				if hChkpt.SequenceNumber < H {
 					delete(instance.hChkpts, replicaID)
 				}
 			}
@@ -1111,8 +1438,7 @@ func (instance *pbftCore) weakCheckpointSetOutOfRange(chkpt *Checkpoint) bool {
 				instance.skipInProgress = true
 				instance.consumer.invalidateState()
 				instance.stopTimer()
-
-				// TODO, reprocess the already gathered checkpoints, this will make recovery faster, though it is presently correct
+				instance.reprocessHighCheckpoints()
 
 				return true
 			}
@@ -1122,17 +1448,53 @@ func (instance *pbftCore) weakCheckpointSetOutOfRange(chkpt *Checkpoint) bool {
 	return false
 }
 
+// reprocessHighCheckpoints is invoked once we have concluded we fell behind
+// and moved our watermarks to the checkpoint f+1 replicas have reported.
+// The checkpoints which led us to that conclusion were outside our old
+// watermarks and so were never stored; rather than wait for the network to
+// produce another weak certificate, look for one among them now. Those
+// checkpoints which now fall within our watermarks are stored as though they
+// had just arrived.
+func (instance *pbftCore) reprocessHighCheckpoints() {
+	var best *Checkpoint
+	var bestMembers []uint64
+	certs := make(map[chkptidx][]uint64)
+	for _, chkpt := range instance.hChkpts {
+		if chkpt.SequenceNumber < instance.h {
+			continue
+		}
+		if instance.inW(chkpt.SequenceNumber) {
+			instance.storeCheckpoint(chkpt)
+		}
+		key := chkptidx{n: chkpt.SequenceNumber, id: chkpt.Id}
+		certs[key] = append(certs[key], chkpt.ReplicaId)
+		if members := certs[key]; len(members) >= instance.f+1 && (best == nil || chkpt.SequenceNumber > best.SequenceNumber) {
+			best, bestMembers = chkpt, members
+		}
+	}
+
+	if best == nil {
+		logger.Debugf("Replica %d found no weak checkpoint certificate among the checkpoints which showed it had fallen behind", instance.id)
+		return
+	}
+
+	logger.Debugf("Replica %d found a weak checkpoint certificate for seqNo %d among the checkpoints which showed it had fallen behind", instance.id, best.SequenceNumber)
+	instance.witnessWeakCert(best, bestMembers)
+}
+
 func (instance *pbftCore) witnessCheckpointWeakCert(chkpt *Checkpoint) {
-	checkpointMembers := make([]uint64, instance.f+1) // Only ever invoked for the first weak cert, so guaranteed to be f+1
-	i := 0
-	for testChkpt := range instance.checkpointStore {
+	var checkpointMembers []uint64
+	for _, testChkpt := range instance.checkpointStore {
 		if testChkpt.SequenceNumber == chkpt.SequenceNumber && testChkpt.Id == chkpt.Id {
-			checkpointMembers[i] = testChkpt.ReplicaId
-			logger.Debugf("Replica %d adding replica %d (handle %v) to weak cert", instance.id, testChkpt.ReplicaId, checkpointMembers[i])
-			i++
+			checkpointMembers = append(checkpointMembers, testChkpt.ReplicaId)
+			logger.Debugf("Replica %d adding replica %d to weak cert", instance.id, testChkpt.ReplicaId)
 		}
 	}
 
+	instance.witnessWeakCert(chkpt, checkpointMembers)
+}
+
+func (instance *pbftCore) witnessWeakCert(chkpt *Checkpoint, checkpointMembers []uint64) {
 	snapshotID, err := base64.StdEncoding.DecodeString(chkpt.Id)
 	if nil != err {
 		err = fmt.Errorf("Replica %d received a weak checkpoint cert which could not be decoded (%s)", instance.id, chkpt.Id)
@@ -1151,16 +1513,25 @@ func (instance *pbftCore) witnessCheckpointWeakCert(chkpt *Checkpoint) {
 
 	if instance.skipInProgress {
 		logger.Debugf("Replica %d is catching up and witnessed a weak certificate for checkpoint %d, weak cert attested to by %d of %d (%v)",
-			instance.id, chkpt.SequenceNumber, i, instance.replicaCount, checkpointMembers)
+			instance.id, chkpt.SequenceNumber, len(checkpointMembers), instance.replicaCount, checkpointMembers)
 		// The view should not be set to active, this should be handled by the yet unimplemented SUSPECT, see https://github.com/hyperledger/fabric/issues/1120
 		instance.retryStateTransfer(target)
 	}
 }
 
+func (instance *pbftCore) storeCheckpoint(chkpt *Checkpoint) {
+	instance.checkpointStore[chkptidx{n: chkpt.SequenceNumber, id: chkpt.Id, replica: chkpt.ReplicaId}] = chkpt
+}
+
 func (instance *pbftCore) recvCheckpoint(chkpt *Checkpoint) events.Event {
 	logger.Debugf("Replica %d received checkpoint from replica %d, seqNo %d, digest %s",
 		instance.id, chkpt.ReplicaId, chkpt.SequenceNumber, chkpt.Id)
 
+	if err := instance.verify(chkpt); err != nil {
+		logger.Warningf("Replica %d found incorrect signature in checkpoint from replica %d: %s", instance.id, chkpt.ReplicaId, err)
+		return nil
+	}
+
 	if instance.weakCheckpointSetOutOfRange(chkpt) {
 		return nil
 	}
@@ -1175,10 +1546,14 @@ func (instance *pbftCore) recvCheckpoint(chkpt *Checkpoint) events.Event {
 		return nil
 	}
 
-	instance.checkpointStore[*chkpt] = true
+	instance.storeCheckpoint(chkpt)
+
+	if instance.checkCheckpointDivergence(chkpt.SequenceNumber) {
+		return nil
+	}
 
 	matching := 0
-	for testChkpt := range instance.checkpointStore {
+	for _, testChkpt := range instance.checkpointStore {
 		if testChkpt.SequenceNumber == chkpt.SequenceNumber && testChkpt.Id == chkpt.Id {
 			matching++
 		}
@@ -1241,6 +1616,14 @@ func (instance *pbftCore) fetchRequests() (err error) {
 	return
 }
 
+// fetchRequest asks all replicas for the request with the given digest
+func (instance *pbftCore) fetchRequest(digest string) error {
+	return instance.innerBroadcast(&Message{&Message_FetchRequest{&FetchRequest{
+		RequestDigest: digest,
+		ReplicaId:     instance.id,
+	}}})
+}
+
 func (instance *pbftCore) recvFetchRequest(fr *FetchRequest) (err error) {
 	digest := fr.RequestDigest
 	if _, ok := instance.reqStore[digest]; !ok {
@@ -1263,7 +1646,7 @@ func (instance *pbftCore) recvFetchRequest(fr *FetchRequest) (err error) {
 func (instance *pbftCore) recvReturnRequest(req *Request) events.Event {
 	digest := hashReq(req)
 	if _, ok := instance.missingReqs[digest]; !ok {
-		return nil // either the wrong digest, or we got it already from someone else
+		return instance.recvFetchedRelayRequest(req, digest)
 	}
 
 	instance.reqStore[digest] = req
@@ -1280,6 +1663,11 @@ func (instance *pbftCore) recvReturnRequest(req *Request) events.Event {
 // Marshals a Message and hands it to the Stack. If toSelf is true,
 // the message is also dispatched to the local instance's RecvMsgSync.
 func (instance *pbftCore) innerBroadcast(msg *Message) error {
+	if instance.quarantined && isVote(msg) {
+		logger.Debugf("Replica %d is quarantined, not broadcasting %T", instance.id, msg.Payload)
+		return nil
+	}
+
 	msgRaw, err := proto.Marshal(msg)
 	if err != nil {
 		return fmt.Errorf("[innerBroadcast] Cannot marshal message: %s", err)
@@ -1321,7 +1709,7 @@ func (instance *pbftCore) updateViewChangeSeqNo() {
 }
 
 func (instance *pbftCore) startTimerIfOutstandingRequests() {
-	if instance.skipInProgress || instance.currentExec != nil {
+	if instance.skipInProgress || (instance.currentExec != nil && !instance.awaitingCommit()) {
 		// Do not start the view change timer if we are executing or state transferring, these take arbitrarilly long amounts of time
 		return
 	}
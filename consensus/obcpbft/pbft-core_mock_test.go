@@ -79,6 +79,7 @@ type simpleConsumer struct {
 	lastSeqNo     uint64
 	skipOccurred  bool
 	lastExecution []byte
+	history       map[uint64][]byte // requests executed at each sequence number
 	mockPersist
 }
 
@@ -113,8 +114,9 @@ func (sc *simpleConsumer) verify(senderID uint64, signature []byte, message []by
 func (sc *simpleConsumer) viewChange(curView uint64) {
 }
 
-func (sc *simpleConsumer) invalidateState() {}
-func (sc *simpleConsumer) validateState()   {}
+func (sc *simpleConsumer) invalidateState()                     {}
+func (sc *simpleConsumer) validateState()                       {}
+func (sc *simpleConsumer) reconfigured(config *Reconfiguration) {}
 
 func (sc *simpleConsumer) skipTo(seqNo uint64, id []byte, replicas []uint64) {
 	sc.skipOccurred = true
@@ -136,6 +138,10 @@ func (sc *simpleConsumer) execute(seqNo uint64, tx []byte) {
 	sc.lastExecution = tx
 	sc.executions++
 	sc.lastSeqNo = seqNo
+	if sc.history == nil {
+		sc.history = make(map[uint64][]byte)
+	}
+	sc.history[seqNo] = tx
 	go func() { sc.pe.manager.Queue() <- execDoneEvent{} }()
 }
 
@@ -68,6 +68,30 @@ func TestEnvOverride(t *testing.T) {
 
 }
 
+func TestCheckpointConfig(t *testing.T) {
+	config := loadConfig()
+	config.Set("general.K", 5)
+	config.Set("general.logmultiplier", 3)
+	instance := newPbftCore(0, config, &omniProto{}, &inertTimerFactory{})
+	instance.close()
+	if instance.K != 5 || instance.L != 15 {
+		t.Fatalf("Expected K 5 and L 15, got K %d and L %d", instance.K, instance.L)
+	}
+
+	for _, c := range []struct{ K, logMultiplier int }{{0, 4}, {10, 1}} {
+		config.Set("general.K", c.K)
+		config.Set("general.logmultiplier", c.logMultiplier)
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("Expected K %d with log multiplier %d to be rejected", c.K, c.logMultiplier)
+				}
+			}()
+			newPbftCore(0, config, &omniProto{}, &inertTimerFactory{})
+		}()
+	}
+}
+
 func TestMaliciousPrePrepare(t *testing.T) {
 	mock := &omniProto{
 		broadcastImpl: func(msgPayload []byte) {
@@ -172,6 +196,373 @@ func TestNetwork(t *testing.T) {
 	}
 }
 
+func TestNetworkRelayTree(t *testing.T) {
+	validatorCount := 7
+	config := loadConfig()
+	config.Set("general.dissemination.mode", "tree")
+	config.Set("general.dissemination.fanout", 2)
+	net := makePBFTNetwork(validatorCount, config)
+	defer net.stop()
+
+	relays := make(map[int]int)
+	var lock sync.Mutex
+	net.filterFn = func(src int, dst int, payload []byte) []byte {
+		msg := &Message{}
+		if err := proto.Unmarshal(payload, msg); err != nil {
+			t.Fatalf("Failed to unmarshal message: %s", err)
+		}
+		if preprep := msg.GetPrePrepare(); preprep != nil && preprep.Request != nil {
+			t.Errorf("Replica %d sent a pre-prepare along with its request", src)
+		}
+		if relay := msg.GetRelayRequest(); relay != nil {
+			lock.Lock()
+			relays[src]++
+			lock.Unlock()
+		}
+		return payload
+	}
+
+	msg := createPbftRequestWithChainTx(1, uint64(generateBroadcaster(validatorCount)))
+	net.pbftEndpoints[0].manager.Queue() <- msg
+
+	err := net.process()
+	if err != nil {
+		t.Fatalf("Processing failed: %s", err)
+	}
+
+	for _, pep := range net.pbftEndpoints {
+		if pep.sc.executions != 1 {
+			t.Errorf("Instance %d executed %d transactions, expected one", pep.id, pep.sc.executions)
+			continue
+		}
+		if !reflect.DeepEqual(pep.sc.lastExecution, msg.Payload) {
+			t.Errorf("Instance %d executed wrong transaction, %x should be %x",
+				pep.id, pep.sc.lastExecution, msg.Payload)
+		}
+	}
+
+	// Replicas 1 and 2 relay to 3, 4, 5 and 6
+	expected := map[int]int{0: 2, 1: 2, 2: 2}
+	if !reflect.DeepEqual(relays, expected) {
+		t.Errorf("Expected relayed requests %v, got %v", expected, relays)
+	}
+}
+
+func TestRelayedRequestMismatch(t *testing.T) {
+	var sent []*Message
+	mock := &omniProto{
+		validateImpl: func(msg []byte) error {
+			return nil
+		},
+		broadcastImpl: func(msgPayload []byte) {
+			msg := &Message{}
+			proto.Unmarshal(msgPayload, msg)
+			sent = append(sent, msg)
+		},
+	}
+	config := loadConfig()
+	config.Set("general.N", 7)
+	config.Set("general.f", 2)
+	config.Set("general.dissemination.mode", "tree")
+	config.Set("general.dissemination.fanout", 2)
+	instance := newPbftCore(3, config, mock, &inertTimerFactory{})
+	defer instance.close()
+
+	req := createPbftRequestWithChainTx(1, 0)
+	digest := hashReq(req)
+	events.SendEvent(instance, &PrePrepare{
+		View:           0,
+		SequenceNumber: 1,
+		RequestDigest:  digest,
+		ReplicaId:      0,
+	})
+	if len(sent) != 0 {
+		t.Fatalf("Expected no prepare before the request was relayed, got %v", sent)
+	}
+
+	// Replica 1 is the parent of replica 3, and relays a forged request
+	events.SendEvent(instance, &RelayRequest{
+		View:           0,
+		SequenceNumber: 1,
+		Request:        createPbftRequestWithChainTx(2, 0),
+		ReplicaId:      1,
+	})
+	if len(sent) != 1 || sent[0].GetFetchRequest() == nil || sent[0].GetFetchRequest().RequestDigest != digest {
+		t.Fatalf("Expected a fetch-request for %s, got %v", digest, sent)
+	}
+
+	events.SendEvent(instance, returnRequestEvent(req))
+	if len(sent) != 2 || sent[1].GetPrepare() == nil || sent[1].GetPrepare().RequestDigest != digest {
+		t.Fatalf("Expected a prepare for %s, got %v", digest, sent)
+	}
+}
+
+// TestRelayedRequestWithheld tests that a replica whose parent in the relay
+// tree withholds a request fetches it from all replicas on relay timeout,
+// without waiting for the request timeout
+func TestRelayedRequestWithheld(t *testing.T) {
+	var sent []*Message
+	mock := &omniProto{
+		validateImpl: func(msg []byte) error {
+			return nil
+		},
+		broadcastImpl: func(msgPayload []byte) {
+			msg := &Message{}
+			proto.Unmarshal(msgPayload, msg)
+			sent = append(sent, msg)
+		},
+	}
+	config := loadConfig()
+	config.Set("general.N", 7)
+	config.Set("general.f", 2)
+	config.Set("general.dissemination.mode", "tree")
+	config.Set("general.dissemination.fanout", 2)
+	instance := newPbftCore(3, config, mock, &inertTimerFactory{})
+	defer instance.close()
+
+	req := createPbftRequestWithChainTx(1, 0)
+	digest := hashReq(req)
+	events.SendEvent(instance, &PrePrepare{
+		View:           0,
+		SequenceNumber: 1,
+		RequestDigest:  digest,
+		ReplicaId:      0,
+	})
+	if !instance.relayTimerActive {
+		t.Fatalf("Expected the relay timer to run while waiting for the relayed request")
+	}
+	if len(sent) != 0 {
+		t.Fatalf("Expected no message before the relay timeout, got %v", sent)
+	}
+
+	// Replica 1, the parent of replica 3, never relays the request
+	events.SendEvent(instance, relayTimerEvent{})
+	if len(sent) != 1 || sent[0].GetFetchRequest() == nil || sent[0].GetFetchRequest().RequestDigest != digest {
+		t.Fatalf("Expected a fetch-request for %s, got %v", digest, sent)
+	}
+
+	// The request is only fetched once
+	events.SendEvent(instance, relayTimerEvent{})
+	if len(sent) != 1 {
+		t.Fatalf("Expected a single fetch-request, got %v", sent)
+	}
+
+	events.SendEvent(instance, returnRequestEvent(req))
+	if len(sent) != 2 || sent[1].GetPrepare() == nil || sent[1].GetPrepare().RequestDigest != digest {
+		t.Fatalf("Expected a prepare for %s, got %v", digest, sent)
+	}
+}
+
+// TestFetchCommittedCertificate tests that a replica which committed past a
+// sequence number it missed fetches the certificate of that sequence number,
+// and executes it once f+1 replicas answered with the same request
+func TestFetchCommittedCertificate(t *testing.T) {
+	var broadcast []*Message
+	var unicast []*Message
+	var executed []uint64
+	instance := newPbftCore(3, loadConfig(), &omniProto{
+		broadcastImpl: func(b []byte) {
+			msg := &Message{}
+			proto.Unmarshal(b, msg)
+			broadcast = append(broadcast, msg)
+		},
+		unicastImpl: func(b []byte, receiverID uint64) error {
+			msg := &Message{}
+			proto.Unmarshal(b, msg)
+			unicast = append(unicast, msg)
+			return nil
+		},
+		executeImpl:  func(seqNo uint64, txRaw []byte) { executed = append(executed, seqNo) },
+		validateImpl: func(txRaw []byte) error { return nil },
+	}, &inertTimerFactory{})
+	defer instance.close()
+
+	// Replica 3 misses seqNo 1, and commits seqNo 2
+	req2 := createPbftRequestWithChainTx(2, 0)
+	digest2 := hashReq(req2)
+	events.SendEvent(instance, &PrePrepare{View: 0, SequenceNumber: 2, RequestDigest: digest2, Request: req2, ReplicaId: 0})
+	for _, id := range []uint64{1, 2} {
+		events.SendEvent(instance, &Prepare{View: 0, SequenceNumber: 2, RequestDigest: digest2, ReplicaId: id})
+	}
+	for _, id := range []uint64{0, 1, 2} {
+		events.SendEvent(instance, &Commit{View: 0, SequenceNumber: 2, RequestDigest: digest2, ReplicaId: id})
+	}
+	if len(executed) != 0 {
+		t.Fatalf("Expected no execution past the missing seqNo 1, got %v", executed)
+	}
+
+	broadcast = nil
+	events.SendEvent(instance, certFetchTimerEvent{})
+	if len(broadcast) != 1 || broadcast[0].GetFetchCertificate() == nil ||
+		!reflect.DeepEqual(broadcast[0].GetFetchCertificate().SequenceNumbers, []uint64{1}) {
+		t.Fatalf("Expected a fetch-certificate for seqNo 1, got %v", broadcast)
+	}
+
+	req1 := createPbftRequestWithChainTx(1, 0)
+	preprep1 := &PrePrepare{View: 0, SequenceNumber: 1, RequestDigest: hashReq(req1), Request: req1, ReplicaId: 0}
+	events.SendEvent(instance, &CommittedCertificate{PrePrepare: preprep1, ReplicaId: 1})
+	if len(executed) != 0 {
+		t.Fatalf("Expected no execution on the word of a single replica, got %v", executed)
+	}
+	events.SendEvent(instance, &CommittedCertificate{PrePrepare: preprep1, ReplicaId: 2})
+	if len(executed) != 1 || executed[0] != 1 {
+		t.Fatalf("Expected seqNo 1 to execute once f+1 replicas answered, got %v", executed)
+	}
+
+	// Replica 3 answers the fetches of the seqNos it committed
+	events.SendEvent(instance, &FetchCertificate{SequenceNumbers: []uint64{2, 3}, ReplicaId: 0})
+	if len(unicast) != 1 || unicast[0].GetCommittedCertificate() == nil {
+		t.Fatalf("Expected a single committed-certificate, got %v", unicast)
+	}
+	if preprep := unicast[0].GetCommittedCertificate().PrePrepare; preprep.SequenceNumber != 2 || hashReq(preprep.Request) != digest2 {
+		t.Fatalf("Expected the committed-certificate of seqNo 2 with its request, got %v", preprep)
+	}
+}
+
+// TestProactiveRecovery tests that a recovering replica asks the others for
+// their checkpoints, and re-fetches its state once f+1 replicas attest to a
+// checkpoint past its log
+func TestProactiveRecovery(t *testing.T) {
+	var broadcast []*Message
+	var unicast []*Message
+	var skipped []uint64
+	config := loadConfig()
+	config.Set("general.recovery.period", "1h")
+	instance := newPbftCore(3, config, &omniProto{
+		broadcastImpl: func(b []byte) {
+			msg := &Message{}
+			proto.Unmarshal(b, msg)
+			broadcast = append(broadcast, msg)
+		},
+		unicastImpl: func(b []byte, receiverID uint64) error {
+			msg := &Message{}
+			proto.Unmarshal(b, msg)
+			unicast = append(unicast, msg)
+			return nil
+		},
+		signImpl:            func(msg []byte) ([]byte, error) { return msg, nil },
+		verifyImpl:          func(senderID uint64, signature []byte, message []byte) error { return nil },
+		skipToImpl:          func(seqNo uint64, id []byte, replicas []uint64) { skipped = append(skipped, seqNo) },
+		invalidateStateImpl: func() {},
+	}, &inertTimerFactory{})
+	defer instance.close()
+
+	if wait := instance.untilRecovery(time.Unix(0, 0)); wait != 45*time.Minute {
+		t.Fatalf("Expected replica 3 of 4 to recover three quarters into the period, got %v", wait)
+	}
+
+	// Replica 3 answers the recoveries of others with its checkpoints
+	instance.Checkpoint(10, []byte("state"))
+	events.SendEvent(instance, &RecoveryRequest{ReplicaId: 0, Nonce: 7})
+	if len(unicast) != 1 || unicast[0].GetRecoveryReply() == nil {
+		t.Fatalf("Expected a recovery-reply, got %v", unicast)
+	}
+	if reply := unicast[0].GetRecoveryReply(); reply.Nonce != 7 || len(reply.Checkpoints) != 1 || reply.Checkpoints[0].SequenceNumber != 10 {
+		t.Fatalf("Expected the recovery-reply to carry the checkpoint for seqNo 10, got %v", reply)
+	}
+
+	// Replica 3 recovers, and finds itself behind
+	broadcast = nil
+	events.SendEvent(instance, recoveryTimerEvent{})
+	if len(broadcast) != 1 || broadcast[0].GetRecoveryRequest() == nil {
+		t.Fatalf("Expected a recovery-request, got %v", broadcast)
+	}
+	nonce := broadcast[0].GetRecoveryRequest().Nonce
+
+	chkpt := func(replica uint64) []*Checkpoint {
+		return []*Checkpoint{{SequenceNumber: 50, ReplicaId: replica, Id: base64.StdEncoding.EncodeToString([]byte("state"))}}
+	}
+	events.SendEvent(instance, &RecoveryReply{ReplicaId: 0, Nonce: nonce + 1, Checkpoints: chkpt(0)})
+	events.SendEvent(instance, &RecoveryReply{ReplicaId: 1, Nonce: nonce, Checkpoints: chkpt(1)})
+	if len(skipped) != 0 {
+		t.Fatalf("Expected no state transfer before f+1 replies to this recovery, got %v", skipped)
+	}
+	events.SendEvent(instance, &RecoveryReply{ReplicaId: 2, Nonce: nonce, Checkpoints: chkpt(2)})
+	if !reflect.DeepEqual(skipped, []uint64{50}) {
+		t.Fatalf("Expected a state transfer to seqNo 50 once f+1 replicas attest to it, got %v", skipped)
+	}
+
+}
+
+type speculativeConsumer struct {
+	omniProto
+	tentative  []uint64
+	committed  int
+	rolledBack int
+}
+
+func (sc *speculativeConsumer) executeTentatively(seqNo uint64, txRaw []byte) bool {
+	sc.tentative = append(sc.tentative, seqNo)
+	return true
+}
+
+func (sc *speculativeConsumer) commitTentative() {
+	sc.committed++
+}
+
+func (sc *speculativeConsumer) rollbackTentative() {
+	sc.rolledBack++
+}
+
+func TestSpeculativeExecution(t *testing.T) {
+	config := loadConfig()
+	config.Set("general.speculative", true)
+	consumer := &speculativeConsumer{omniProto: omniProto{
+		broadcastImpl: func(b []byte) {},
+		executeImpl:   func(seqNo uint64, txRaw []byte) { t.Fatalf("Expected seqNo %d to execute tentatively", seqNo) },
+		getStateImpl:  func() []byte { return []byte("state") },
+		validateImpl:  func(txRaw []byte) error { return nil },
+	}}
+	instance := newPbftCore(3, config, consumer, &inertTimerFactory{})
+	defer instance.close()
+
+	prepare := func(v uint64, n uint64, req *Request) {
+		digest := hashReq(req)
+		events.SendEvent(instance, &PrePrepare{View: v, SequenceNumber: n, RequestDigest: digest, Request: req, ReplicaId: v % 4})
+		for _, id := range []uint64{1, 2} {
+			events.SendEvent(instance, &Prepare{View: v, SequenceNumber: n, RequestDigest: digest, ReplicaId: id})
+		}
+	}
+
+	req1 := createPbftRequestWithChainTx(1, 0)
+	prepare(0, 1, req1)
+	if !reflect.DeepEqual(consumer.tentative, []uint64{1}) {
+		t.Fatalf("Expected seqNo 1 to execute tentatively once prepared, got %v", consumer.tentative)
+	}
+	for _, id := range []uint64{0, 1} {
+		events.SendEvent(instance, &Commit{View: 0, SequenceNumber: 1, RequestDigest: hashReq(req1), ReplicaId: id})
+	}
+	if consumer.committed != 1 {
+		t.Fatalf("Expected seqNo 1 to commit once committed, got %d commits", consumer.committed)
+	}
+	events.SendEvent(instance, execDoneEvent{})
+	if instance.lastExec != 1 || instance.currentExec != nil {
+		t.Fatalf("Expected seqNo 1 executed, lastExec is %d", instance.lastExec)
+	}
+
+	// seqNo 2 prepares, and the next view assigns it another request
+	req2 := createPbftRequestWithChainTx(2, 0)
+	prepare(0, 2, req2)
+	if !reflect.DeepEqual(consumer.tentative, []uint64{1, 2}) {
+		t.Fatalf("Expected seqNo 2 to execute tentatively once prepared, got %v", consumer.tentative)
+	}
+	instance.view = 1
+	instance.rollbackIfReordered()
+	if consumer.rolledBack != 1 {
+		t.Fatalf("Expected seqNo 2 to roll back once reordered")
+	}
+	events.SendEvent(instance, rolledBackEvent{})
+	if instance.lastExec != 1 || instance.currentExec != nil || consumer.committed != 1 {
+		t.Fatalf("Expected seqNo 2 not to execute, lastExec is %d", instance.lastExec)
+	}
+
+	req3 := createPbftRequestWithChainTx(3, 0)
+	prepare(1, 2, req3)
+	if !reflect.DeepEqual(consumer.tentative, []uint64{1, 2, 2}) {
+		t.Fatalf("Expected the request of the new view to execute tentatively, got %v", consumer.tentative)
+	}
+}
+
 type checkpointConsumer struct {
 	simpleConsumer
 	execWait *sync.WaitGroup
@@ -259,6 +650,73 @@ func TestCheckpoint(t *testing.T) {
 	}
 }
 
+// TestCheckpointBadSignature tests that a checkpoint whose signature does not
+// verify is ignored, and that the same checkpoint is stored once signed
+func TestCheckpointBadSignature(t *testing.T) {
+	mock := &omniProto{
+		signImpl: func(b []byte) ([]byte, error) { return []byte("signature"), nil },
+		verifyImpl: func(senderID uint64, signature []byte, message []byte) error {
+			if string(signature) != "signature" {
+				return fmt.Errorf("invalid signature")
+			}
+			return nil
+		},
+	}
+	instance := newPbftCore(1, loadConfig(), mock, &inertTimerFactory{})
+	defer instance.close()
+
+	events.SendEvent(instance, &Checkpoint{
+		SequenceNumber: 2,
+		ReplicaId:      0,
+		Id:             "state",
+		Signature:      []byte("forged"),
+	})
+	if len(instance.checkpointStore) != 0 {
+		t.Fatalf("Expected checkpoint with bad signature to be ignored, got %d checkpoints", len(instance.checkpointStore))
+	}
+
+	chkpt := &Checkpoint{
+		SequenceNumber: 2,
+		ReplicaId:      0,
+		Id:             "state",
+	}
+	instance.sign(chkpt)
+	events.SendEvent(instance, chkpt)
+	if len(instance.checkpointStore) != 1 {
+		t.Fatalf("Expected signed checkpoint to be stored, got %d checkpoints", len(instance.checkpointStore))
+	}
+}
+
+// TestMoveWatermarksCompactsReqStore tests that moving the watermarks drops the
+// stored requests which are neither outstanding nor referred to by a certificate
+func TestMoveWatermarksCompactsReqStore(t *testing.T) {
+	instance := newPbftCore(1, loadConfig(), &omniProto{}, &inertTimerFactory{})
+	defer instance.close()
+
+	// A request whose certificate went at a view change, one we wait for,
+	// and one in a certificate above the new low watermark
+	orphan := &Request{Payload: []byte("orphan")}
+	outstanding := &Request{Payload: []byte("outstanding")}
+	certified := &Request{Payload: []byte("certified")}
+	for _, req := range []*Request{orphan, outstanding, certified} {
+		instance.reqStore[hashReq(req)] = req
+	}
+	instance.outstandingReqs[hashReq(outstanding)] = outstanding
+	instance.certStore[msgID{v: 0, n: 12}] = &msgCert{digest: hashReq(certified)}
+
+	instance.moveWatermarks(10)
+
+	if _, ok := instance.reqStore[hashReq(orphan)]; ok {
+		t.Errorf("Expected the request no certificate refers to to be dropped")
+	}
+	if _, ok := instance.reqStore[hashReq(outstanding)]; !ok {
+		t.Errorf("Expected the outstanding request to be kept")
+	}
+	if _, ok := instance.reqStore[hashReq(certified)]; !ok {
+		t.Errorf("Expected the request of a certificate above the low watermark to be kept")
+	}
+}
+
 func TestLostPrePrepare(t *testing.T) {
 	validatorCount := 4
 	net := makePBFTNetwork(validatorCount, nil)
@@ -352,6 +810,109 @@ func TestInconsistentPrePrepare(t *testing.T) {
 	}
 }
 
+type divergentConsumer struct {
+	*simpleConsumer
+}
+
+// getState reports a bogus state until the replica recovers through state transfer
+func (dc *divergentConsumer) getState() []byte {
+	if !dc.skipOccurred {
+		return []byte("diverged")
+	}
+	return dc.simpleConsumer.getState()
+}
+
+func TestExecResultDivergence(t *testing.T) {
+	validatorCount := 4
+	net := makePBFTNetwork(validatorCount, nil)
+	defer net.stop()
+
+	divergent := net.pbftEndpoints[3]
+	divergent.manager.Queue() <- workEvent(func() {
+		divergent.pbft.consumer = &divergentConsumer{divergent.sc}
+	})
+
+	for i := int64(1); i <= 2; i++ {
+		net.pbftEndpoints[0].manager.Queue() <- createPbftRequestWithChainTx(i, uint64(generateBroadcaster(validatorCount)))
+		if err := net.process(); err != nil {
+			t.Fatalf("Processing failed: %s", err)
+		}
+	}
+
+	if !divergent.sc.skipOccurred {
+		t.Fatalf("Expected replica %d to detect its diverged execution and perform state transfer", divergent.id)
+	}
+	for _, pep := range net.pbftEndpoints {
+		if pep.sc.skipOccurred && pep.id != divergent.id {
+			t.Errorf("Expected replica %d not to perform state transfer", pep.id)
+		}
+		if pep.pbft.lastExec != 2 {
+			t.Errorf("Expected replica %d to have executed seqNo 2, lastExec is %d", pep.id, pep.pbft.lastExec)
+		}
+		if _, id, _ := pep.pbft.lastExecResult(); id != base64.StdEncoding.EncodeToString([]byte("2")) {
+			t.Errorf("Expected replica %d to agree on the execution result of seqNo 2, got %s", pep.id, id)
+		}
+	}
+}
+
+func TestQuarantine(t *testing.T) {
+	for _, autoResync := range []bool{true, false} {
+		var skipped, broadcasts int
+		instance := newPbftCore(3, loadConfig(), &omniProto{
+			broadcastImpl:       func(b []byte) { broadcasts++ },
+			signImpl:            func(b []byte) ([]byte, error) { return b, nil },
+			verifyImpl:          func(senderID uint64, signature []byte, message []byte) error { return nil },
+			skipToImpl:          func(s uint64, id []byte, replicas []uint64) { skipped++ },
+			invalidateStateImpl: func() {},
+			validateStateImpl:   func() {},
+			getStateImpl:        func() []byte { return []byte("theirs") },
+		}, &inertTimerFactory{})
+		instance.autoResync = autoResync
+		instance.lastExec = 10
+		instance.chkpts[10] = base64.StdEncoding.EncodeToString([]byte("ours"))
+
+		theirs := base64.StdEncoding.EncodeToString([]byte("theirs"))
+		instance.recvCheckpoint(&Checkpoint{SequenceNumber: 10, ReplicaId: 0, Id: theirs})
+		if instance.quarantined {
+			t.Fatalf("Replica should not quarantine itself on the checkpoint of a single replica")
+		}
+		instance.recvCheckpoint(&Checkpoint{SequenceNumber: 10, ReplicaId: 1, Id: theirs})
+		if !instance.quarantined || !instance.skipInProgress {
+			t.Fatalf("Replica should quarantine itself once f+1 replicas agree on another checkpoint")
+		}
+		if !instance.getStatus().Quarantined {
+			t.Errorf("Status should report the replica as quarantined")
+		}
+
+		instance.innerBroadcast(&Message{&Message_Commit{&Commit{}}})
+		instance.innerBroadcast(&Message{&Message_Checkpoint{&Checkpoint{}}})
+		if broadcasts != 0 {
+			t.Errorf("Quarantined replica should not vote, sent %d messages", broadcasts)
+		}
+		instance.innerBroadcast(&Message{&Message_FetchRequest{&FetchRequest{}}})
+		if broadcasts != 1 {
+			t.Errorf("Quarantined replica should still fetch requests")
+		}
+
+		if !autoResync {
+			if skipped != 0 {
+				t.Errorf("Replica without automatic resync should not initiate state transfer")
+			}
+			continue
+		}
+		if skipped != 1 {
+			t.Fatalf("Quarantined replica should resync through state transfer, got %d transfers", skipped)
+		}
+		instance.ProcessEvent(stateUpdatedEvent{
+			chkpt:  &checkpointMessage{seqNo: 10, id: []byte("theirs")},
+			target: &pb.BlockchainInfo{},
+		})
+		if instance.quarantined {
+			t.Errorf("Replica should leave quarantine once resynced")
+		}
+	}
+}
+
 // This test is designed to detect a conflation of S and S' from the paper in the view change
 func TestViewChangeWatermarksMovement(t *testing.T) {
 	instance := newPbftCore(0, loadConfig(), &omniProto{
@@ -463,19 +1024,172 @@ func TestViewChangeCheckpointSelection(t *testing.T) {
 		},
 	}
 
-	checkpoint, ok, _ := instance.selectInitialCheckpoint(vset)
+	checkpoint, ok, _ := instance.selectInitialCheckpoint(vset)
+
+	if !ok {
+		t.Fatalf("Failed to pick correct a checkpoint for view change")
+	}
+
+	expected := uint64(10)
+	if checkpoint.SequenceNumber != expected {
+		t.Fatalf("Expected to pick checkpoint %d, but picked %d", expected, checkpoint.SequenceNumber)
+	}
+}
+
+func TestViewChange(t *testing.T) {
+	validatorCount := 4
+	config := loadConfig()
+	config.Set("general.K", 2)
+	config.Set("general.logmultiplier", 2)
+	net := makePBFTNetwork(validatorCount, config)
+	defer net.stop()
+
+	execReq := func(iter int64) {
+		txTime := &gp.Timestamp{Seconds: iter, Nanos: 0}
+		tx := &pb.Transaction{Type: pb.Transaction_CHAINCODE_DEPLOY, Timestamp: txTime}
+		txPacked, err := proto.Marshal(tx)
+		if err != nil {
+			t.Fatalf("Failed to marshal TX block: %s", err)
+		}
+		msg := &Message{&Message_Request{&Request{Payload: txPacked, ReplicaId: uint64(generateBroadcaster(validatorCount))}}}
+		net.pbftEndpoints[0].manager.Queue() <- pbftMessageEvent{msg: msg, sender: msg.GetRequest().ReplicaId}
+		if err != nil {
+			t.Fatalf("Request failed: %s", err)
+		}
+
+		err = net.process()
+		if err != nil {
+			t.Fatalf("Processing failed: %s", err)
+		}
+	}
+
+	execReq(1)
+	execReq(2)
+	execReq(3)
+
+	for i := 2; i < len(net.pbftEndpoints); i++ {
+		net.pbftEndpoints[i].pbft.sendViewChange()
+	}
+
+	err := net.process()
+	if err != nil {
+		t.Fatalf("Processing failed: %s", err)
+	}
+
+	if net.pbftEndpoints[1].pbft.view != 1 || net.pbftEndpoints[0].pbft.view != 1 {
+		t.Fatalf("Replicas did not follow f+1 crowd to trigger view-change")
+	}
+
+	cp, ok, _ := net.pbftEndpoints[1].pbft.selectInitialCheckpoint(net.pbftEndpoints[1].pbft.getViewChanges())
+	if !ok || cp.SequenceNumber != 2 {
+		t.Fatalf("Wrong new initial checkpoint: %+v",
+			net.pbftEndpoints[1].pbft.viewChangeStore)
+	}
+
+	msgList := net.pbftEndpoints[1].pbft.assignSequenceNumbers(net.pbftEndpoints[1].pbft.getViewChanges(), cp.SequenceNumber)
+	if msgList[4] != "" || msgList[5] != "" || msgList[3] == "" {
+		t.Fatalf("Wrong message list: %+v", msgList)
+	}
+}
+
+// TestViewChangeAssignGaps tests that the new-view re-proposes the prepared
+// requests at their sequence numbers, and fills the gaps with null requests
+func TestViewChangeAssignGaps(t *testing.T) {
+	instance := &pbftCore{
+		f:  1,
+		N:  4,
+		id: 0,
+		L:  10,
+	}
+
+	vset := make([]*ViewChange, 3)
+	for i := range vset {
+		vset[i] = &ViewChange{
+			View:      1,
+			ReplicaId: uint64(i),
+			Pset: []*ViewChange_PQ{
+				{SequenceNumber: 1, Digest: "one", View: 0},
+				{SequenceNumber: 3, Digest: "three", View: 0},
+			},
+			Qset: []*ViewChange_PQ{
+				{SequenceNumber: 1, Digest: "one", View: 0},
+				{SequenceNumber: 3, Digest: "three", View: 0},
+			},
+		}
+	}
+
+	msgList := instance.assignSequenceNumbers(vset, 0)
+	expected := map[uint64]string{1: "one", 2: "", 3: "three"}
+	if !reflect.DeepEqual(msgList, expected) {
+		t.Fatalf("Expected message list %+v, got %+v", expected, msgList)
+	}
+}
+
+// TestViewChangeAssignOnlyAtPreparedSeqNo tests that a request prepared at one
+// sequence number is not assigned to another one, even if it pre-prepared there
+func TestViewChangeAssignOnlyAtPreparedSeqNo(t *testing.T) {
+	instance := &pbftCore{
+		f:  1,
+		N:  4,
+		id: 0,
+		L:  10,
+	}
+
+	vset := make([]*ViewChange, 3)
+	for i := range vset {
+		vset[i] = &ViewChange{
+			View:      1,
+			ReplicaId: uint64(i),
+			Pset: []*ViewChange_PQ{
+				{SequenceNumber: 1, Digest: "one", View: 0},
+			},
+			Qset: []*ViewChange_PQ{
+				{SequenceNumber: 1, Digest: "one", View: 0},
+				// a faulty primary also pre-prepared the request at 2
+				{SequenceNumber: 2, Digest: "one", View: 0},
+			},
+		}
+	}
+
+	msgList := instance.assignSequenceNumbers(vset, 0)
+	if msgList[1] != "one" {
+		t.Fatalf("Expected request to be assigned to seqNo 1: %+v", msgList)
+	}
+	if d, ok := msgList[2]; ok && d != "" {
+		t.Fatalf("Expected no request to be assigned to seqNo 2: %+v", msgList)
+	}
+}
+
+// TestNewViewIncompleteVset tests that a new-view which does not carry view-changes
+// for its view from a quorum of distinct replicas is rejected
+func TestNewViewIncompleteVset(t *testing.T) {
+	instance := newPbftCore(3, loadConfig(), &omniProto{
+		signImpl:   func(b []byte) ([]byte, error) { return b, nil },
+		verifyImpl: func(senderID uint64, signature []byte, message []byte) error { return nil },
+	}, &inertTimerFactory{})
+	instance.activeView = false
+	instance.view = 1
 
-	if !ok {
-		t.Fatalf("Failed to pick correct a checkpoint for view change")
+	vc := func(view uint64, id uint64) *ViewChange {
+		return &ViewChange{View: view, ReplicaId: id}
 	}
 
-	expected := uint64(10)
-	if checkpoint.SequenceNumber != expected {
-		t.Fatalf("Expected to pick checkpoint %d, but picked %d", expected, checkpoint.SequenceNumber)
+	for _, vset := range [][]*ViewChange{
+		{vc(1, 0), vc(1, 1)},
+		{vc(1, 0), vc(1, 1), vc(1, 1)},
+		{vc(1, 0), vc(1, 1), vc(2, 2)},
+	} {
+		nv := &NewView{View: 1, Vset: vset, ReplicaId: 1}
+		instance.recvNewView(nv)
+		if _, ok := instance.newViewStore[1]; ok {
+			t.Fatalf("Replica accepted new-view with invalid view-change set %+v", vset)
+		}
 	}
 }
 
-func TestViewChange(t *testing.T) {
+// TestNetworkMultipleViewChanges tests that the network keeps executing requests
+// across consecutive view changes
+func TestNetworkMultipleViewChanges(t *testing.T) {
 	validatorCount := 4
 	config := loadConfig()
 	config.Set("general.K", 2)
@@ -484,50 +1198,145 @@ func TestViewChange(t *testing.T) {
 	defer net.stop()
 
 	execReq := func(iter int64) {
-		txTime := &gp.Timestamp{Seconds: iter, Nanos: 0}
-		tx := &pb.Transaction{Type: pb.Transaction_CHAINCODE_DEPLOY, Timestamp: txTime}
-		txPacked, err := proto.Marshal(tx)
-		if err != nil {
-			t.Fatalf("Failed to marshal TX block: %s", err)
+		msg := createPbftRequestWithChainTx(iter, 0)
+		for _, pep := range net.pbftEndpoints {
+			pep.manager.Queue() <- msg
 		}
-		msg := &Message{&Message_Request{&Request{Payload: txPacked, ReplicaId: uint64(generateBroadcaster(validatorCount))}}}
-		net.pbftEndpoints[0].manager.Queue() <- pbftMessageEvent{msg: msg, sender: msg.GetRequest().ReplicaId}
-		if err != nil {
-			t.Fatalf("Request failed: %s", err)
+		if err := net.process(); err != nil {
+			t.Fatalf("Processing failed: %s", err)
 		}
+	}
 
-		err = net.process()
-		if err != nil {
+	execReq(1)
+	for view := uint64(1); view <= 3; view++ {
+		for _, pep := range net.pbftEndpoints {
+			pep.pbft.sendViewChange()
+		}
+		if err := net.process(); err != nil {
 			t.Fatalf("Processing failed: %s", err)
 		}
+		execReq(int64(view) + 1)
 	}
 
-	execReq(1)
-	execReq(2)
-	execReq(3)
+	for _, pep := range net.pbftEndpoints {
+		if pep.pbft.view != 3 || !pep.pbft.activeView {
+			t.Errorf("Instance %d expected to be active in view 3, is in view %d (active: %v)", pep.id, pep.pbft.view, pep.pbft.activeView)
+		}
+		if pep.sc.executions != 4 {
+			t.Errorf("Instance %d executed incorrect number of transactions: %d", pep.id, pep.sc.executions)
+		}
+		if pep.pbft.lastExec != net.pbftEndpoints[0].pbft.lastExec {
+			t.Errorf("Instance %d executed up to seqNo %d, instance 0 up to %d", pep.id, pep.pbft.lastExec, net.pbftEndpoints[0].pbft.lastExec)
+		}
+	}
+}
 
-	for i := 2; i < len(net.pbftEndpoints); i++ {
-		net.pbftEndpoints[i].pbft.sendViewChange()
+func TestViewChangeHighestCertifiedView(t *testing.T) {
+	instance := &pbftCore{
+		f:               1,
+		N:               4,
+		id:              0,
+		viewChangeStore: make(map[vcidx]*ViewChange),
 	}
 
-	err := net.process()
-	if err != nil {
-		t.Fatalf("Processing failed: %s", err)
+	if _, ok := instance.highestCertifiedView(); ok {
+		t.Fatalf("Expected no certified view without view-changes")
 	}
 
-	if net.pbftEndpoints[1].pbft.view != 1 || net.pbftEndpoints[0].pbft.view != 1 {
-		t.Fatalf("Replicas did not follow f+1 crowd to trigger view-change")
+	instance.viewChangeStore[vcidx{3, 1}] = &ViewChange{View: 3, ReplicaId: 1}
+	instance.viewChangeStore[vcidx{4, 1}] = &ViewChange{View: 4, ReplicaId: 1}
+	if _, ok := instance.highestCertifiedView(); ok {
+		t.Fatalf("Expected no certified view with view-changes from a single replica")
 	}
 
-	cp, ok, _ := net.pbftEndpoints[1].pbft.selectInitialCheckpoint(net.pbftEndpoints[1].pbft.getViewChanges())
-	if !ok || cp.SequenceNumber != 2 {
-		t.Fatalf("Wrong new initial checkpoint: %+v",
-			net.pbftEndpoints[1].pbft.viewChangeStore)
+	instance.viewChangeStore[vcidx{6, 2}] = &ViewChange{View: 6, ReplicaId: 2}
+	if v, ok := instance.highestCertifiedView(); !ok || v != 4 {
+		t.Fatalf("Expected view 4 to be certified, got %d (%v)", v, ok)
 	}
 
-	msgList := net.pbftEndpoints[1].pbft.assignSequenceNumbers(net.pbftEndpoints[1].pbft.getViewChanges(), cp.SequenceNumber)
-	if msgList[4] != "" || msgList[5] != "" || msgList[3] == "" {
-		t.Fatalf("Wrong message list: %+v", msgList)
+	instance.viewChangeStore[vcidx{9, 3}] = &ViewChange{View: 9, ReplicaId: 3}
+	if v, ok := instance.highestCertifiedView(); !ok || v != 6 {
+		t.Fatalf("Expected view 6 to be certified, got %d (%v)", v, ok)
+	}
+}
+
+// TestViewChangePiggybackedVotes tests that a replica learns about the view-changes
+// of other replicas from the votes piggybacked on a single view-change message, and
+// jumps to the highest view these certify
+func TestViewChangePiggybackedVotes(t *testing.T) {
+	var broadcast []*Message
+	instance := newPbftCore(0, loadConfig(), &omniProto{
+		broadcastImpl: func(b []byte) {
+			msg := &Message{}
+			if err := proto.Unmarshal(b, msg); err != nil {
+				t.Fatalf("Failed to unmarshal broadcast message: %s", err)
+			}
+			broadcast = append(broadcast, msg)
+		},
+		signImpl:   func(b []byte) ([]byte, error) { return b, nil },
+		verifyImpl: func(senderID uint64, signature []byte, message []byte) error { return nil },
+	}, &inertTimerFactory{})
+
+	vc := &ViewChange{
+		View:      3,
+		ReplicaId: 1,
+		Votes: []*ViewChange{
+			{View: 6, ReplicaId: 2},
+			{View: 6, ReplicaId: 3},
+		},
+	}
+	instance.recvViewChange(vc)
+
+	if instance.view != 6 {
+		t.Fatalf("Expected replica to jump to view 6, is in view %d", instance.view)
+	}
+	if len(broadcast) != 1 || broadcast[0].GetViewChange() == nil {
+		t.Fatalf("Expected replica to broadcast a single view-change, got %+v", broadcast)
+	}
+	sent := broadcast[0].GetViewChange()
+	if sent.View != 6 || len(sent.Votes) != 2 {
+		t.Fatalf("Expected view-change for view 6 carrying the votes of replicas 2 and 3, got %+v", sent)
+	}
+	for _, vote := range sent.Votes {
+		if vote.View != 6 || len(vote.Votes) != 0 {
+			t.Fatalf("Unexpected piggybacked vote %+v", vote)
+		}
+	}
+}
+
+// TestNetworkSimultaneousViewChanges tests that replicas which time out at the same
+// time, while in different views, converge on the highest view f+1 of them moved to
+func TestNetworkSimultaneousViewChanges(t *testing.T) {
+	validatorCount := 4
+	net := makePBFTNetwork(validatorCount, nil)
+	defer net.stop()
+
+	// the replicas send view-changes for views 1, 2, 3 and 3
+	for i, pep := range net.pbftEndpoints {
+		pep.pbft.view = []uint64{0, 1, 2, 2}[i]
+		pep.pbft.sendViewChange()
+	}
+	if err := net.process(); err != nil {
+		t.Fatalf("Processing failed: %s", err)
+	}
+
+	for _, pep := range net.pbftEndpoints {
+		if pep.pbft.view != 3 || !pep.pbft.activeView {
+			t.Errorf("Instance %d expected to be active in view 3, is in view %d (active: %v)", pep.id, pep.pbft.view, pep.pbft.activeView)
+		}
+	}
+
+	msg := createPbftRequestWithChainTx(1, 0)
+	for _, pep := range net.pbftEndpoints {
+		pep.manager.Queue() <- msg
+	}
+	if err := net.process(); err != nil {
+		t.Fatalf("Processing failed: %s", err)
+	}
+	for _, pep := range net.pbftEndpoints {
+		if pep.sc.executions != 1 {
+			t.Errorf("Instance %d executed incorrect number of transactions: %d", pep.id, pep.sc.executions)
+		}
 	}
 }
 
@@ -720,6 +1529,31 @@ func TestNewViewTimeout(t *testing.T) {
 	}
 }
 
+func TestNewViewTimeoutBackoff(t *testing.T) {
+	config := loadConfig()
+	config.Set("general.timeout.viewchange", "1s")
+	config.Set("general.timeout.viewchangemax", "3s")
+	instance := newPbftCore(0, config, &omniProto{}, &inertTimerFactory{})
+	defer instance.close()
+
+	for i, expected := range []time.Duration{2 * time.Second, 3 * time.Second, 3 * time.Second} {
+		instance.backoffNewViewTimeout()
+		if instance.lastNewViewTimeout != expected {
+			t.Fatalf("Expected view change timeout %v after %d backoffs, got %v", expected, i+1, instance.lastNewViewTimeout)
+		}
+	}
+
+	config.Set("general.timeout.viewchangemax", "0s")
+	instance = newPbftCore(0, config, &omniProto{}, &inertTimerFactory{})
+	defer instance.close()
+	for i := 0; i < 4; i++ {
+		instance.backoffNewViewTimeout()
+	}
+	if instance.lastNewViewTimeout != 16*time.Second {
+		t.Fatalf("Expected uncapped view change timeout of 16s, got %v", instance.lastNewViewTimeout)
+	}
+}
+
 func TestViewChangeUpdateSeqNo(t *testing.T) {
 	millisUntilTimeout := 400 * time.Millisecond
 
@@ -851,7 +1685,10 @@ func TestSendQueueOrdering(t *testing.T) {
 
 // From issue #687
 func TestWitnessCheckpointOutOfBounds(t *testing.T) {
-	mock := &omniProto{}
+	mock := &omniProto{
+		signImpl:   func(b []byte) ([]byte, error) { return b, nil },
+		verifyImpl: func(senderID uint64, signature []byte, message []byte) error { return nil },
+	}
 	instance := newPbftCore(1, loadConfig(), mock, &inertTimerFactory{})
 	instance.f = 1
 	instance.K = 2
@@ -893,6 +1730,48 @@ func TestWitnessFallBehindMissingPrePrepare(t *testing.T) {
 	instance.moveWatermarks(6)
 }
 
+func TestFallBehindReprocessesHighCheckpoints(t *testing.T) {
+	var skippedTo uint64
+	var skippedReplicas []uint64
+	instance := newPbftCore(1, loadConfig(), &omniProto{
+		skipToImpl: func(s uint64, id []byte, replicas []uint64) {
+			skippedTo = s
+			skippedReplicas = replicas
+		},
+		invalidateStateImpl: func() {},
+		signImpl:            func(b []byte) ([]byte, error) { return b, nil },
+		verifyImpl:          func(senderID uint64, signature []byte, message []byte) error { return nil },
+	}, &inertTimerFactory{})
+	instance.f = 1
+	instance.K = 2
+	instance.L = 4
+	defer instance.close()
+
+	for _, replica := range []uint64{0, 2} {
+		events.SendEvent(instance, &Checkpoint{
+			SequenceNumber: 10,
+			ReplicaId:      replica,
+			Id:             base64.StdEncoding.EncodeToString([]byte("ten")),
+		})
+	}
+
+	if !instance.skipInProgress {
+		t.Fatalf("Replica did not detect that it has fallen behind")
+	}
+
+	if instance.h != 10 {
+		t.Fatalf("Expected low water mark to be 10, got %d", instance.h)
+	}
+
+	if skippedTo != 10 {
+		t.Fatalf("Expected state transfer to seqNo 10 from the checkpoints which showed we fell behind, got %d", skippedTo)
+	}
+
+	if len(skippedReplicas) != 2 {
+		t.Fatalf("Expected state transfer from the 2 replicas in the weak cert, got %v", skippedReplicas)
+	}
+}
+
 func TestFallBehind(t *testing.T) {
 	validatorCount := 4
 	config := loadConfig()
@@ -942,7 +1821,9 @@ func TestFallBehind(t *testing.T) {
 		execReq(request, false)
 	}
 
-	if !pbft.skipInProgress {
+	// The f+1 checkpoints which showed the replica it had fallen behind form a
+	// weak certificate, so state transfer should not wait for the next one
+	if !pep.sc.skipOccurred {
 		t.Fatalf("Replica did not detect that it has fallen behind.")
 	}
 
@@ -954,19 +1835,16 @@ func TestFallBehind(t *testing.T) {
 		t.Fatalf("Expected low water mark to be %d, got %d", pbft.L+pbft.K, pbft.h)
 	}
 
-	// Send enough requests to get to a weak checkpoint certificate certain with sequence number L+K*2
-	for request := int64(pbft.L + pbft.K + 1); uint64(request) <= pbft.L+pbft.K*2; request++ {
-		execReq(request, false)
+	if pep.sc.executions < pbft.L+pbft.K {
+		t.Fatalf("Replica did not perform state transfer")
 	}
 
-	if !pep.sc.skipOccurred {
-		t.Fatalf("Request failed to kick off state transfer")
+	for request := int64(pbft.L + pbft.K + 1); uint64(request) <= pbft.L+pbft.K*2+1; request++ {
+		execReq(request, false)
 	}
 
-	execReq(int64(pbft.L+pbft.K*2+1), false)
-
 	if pep.sc.executions < pbft.L+pbft.K*2 {
-		t.Fatalf("Replica did not perform state transfer")
+		t.Fatalf("Replica did not keep up after state transfer")
 	}
 
 	// XXX currently disabled, need to resync view# during/after state transfer
@@ -1304,6 +2182,83 @@ func TestReplicaPersistQSet(t *testing.T) {
 	}
 }
 
+func TestReplicaPersistCertsAndView(t *testing.T) {
+	persist := make(map[string][]byte)
+
+	stack := &omniProto{
+		validateImpl: func(b []byte) error {
+			return nil
+		},
+		verifyImpl: func(senderID uint64, signature []byte, message []byte) error {
+			return nil
+		},
+		signImpl: func(msg []byte) ([]byte, error) {
+			return msg, nil
+		},
+		broadcastImpl: func(msg []byte) {
+		},
+		StoreStateImpl: func(key string, value []byte) error {
+			persist[key] = value
+			return nil
+		},
+		DelStateImpl: func(key string) {
+			delete(persist, key)
+		},
+		ReadStateImpl: func(key string) ([]byte, error) {
+			if val, ok := persist[key]; ok {
+				return val, nil
+			}
+			return nil, fmt.Errorf("key not found")
+		},
+		ReadStateSetImpl: func(prefix string) (map[string][]byte, error) {
+			r := make(map[string][]byte)
+			for k, v := range persist {
+				if strings.HasPrefix(k, prefix) {
+					r[k] = v
+				}
+			}
+			return r, nil
+		},
+	}
+	p := newPbftCore(1, loadConfig(), stack, &inertTimerFactory{})
+	req := &Request{
+		Timestamp: &gp.Timestamp{Seconds: 1, Nanos: 0},
+		Payload:   []byte("foo"),
+		ReplicaId: uint64(0),
+	}
+	digest := hashReq(req)
+	events.SendEvent(p, &PrePrepare{View: 0, SequenceNumber: 1, RequestDigest: digest, Request: req, ReplicaId: 0})
+	for _, id := range []uint64{2, 3} {
+		events.SendEvent(p, &Prepare{View: 0, SequenceNumber: 1, RequestDigest: digest, ReplicaId: id})
+	}
+	events.SendEvent(p, &Commit{View: 0, SequenceNumber: 1, RequestDigest: digest, ReplicaId: 0})
+	p.close()
+
+	p = newPbftCore(1, loadConfig(), stack, &inertTimerFactory{})
+	cert, ok := p.certStore[msgID{v: 0, n: 1}]
+	if !ok || cert.prePrepare == nil || cert.prePrepare.Request == nil {
+		t.Fatalf("did not restore the certificate with its request")
+	}
+	if len(cert.prepare) != 3 || len(cert.commit) != 2 || !cert.sentCommit {
+		t.Errorf("expected 3 prepares and 2 commits including ours, got %d and %d, sent commit %v", len(cert.prepare), len(cert.commit), cert.sentCommit)
+	}
+	if !p.prepared(digest, 0, 1) {
+		t.Errorf("expected the restored certificate to be prepared")
+	}
+
+	// A view change without anything prepared in the new view is remembered too
+	p.sendViewChange()
+	p.sendViewChange()
+	p.close()
+	p = newPbftCore(1, loadConfig(), stack, &inertTimerFactory{})
+	if p.view != 2 {
+		t.Errorf("expected to restore view 2, got %d", p.view)
+	}
+	if _, ok := p.certStore[msgID{v: 0, n: 1}]; ok {
+		t.Errorf("expected the certificate of view 0 to be forgotten after the view change")
+	}
+}
+
 func TestReplicaPersistDelete(t *testing.T) {
 	persist := make(map[string][]byte)
 
@@ -1749,9 +2704,9 @@ func TestStateNetworkMovesOnDuringSlowStateTransfer(t *testing.T) {
 	instance := newPbftCore(3, loadConfig(), &omniProto{
 		skipToImpl:          func(s uint64, id []byte, replicas []uint64) {},
 		invalidateStateImpl: func() {},
+		signImpl:            func(b []byte) ([]byte, error) { return b, nil },
+		verifyImpl:          func(senderID uint64, signature []byte, message []byte) error { return nil },
 		//broadcastImpl:       func(b []byte) {},
-		//signImpl:            func(b []byte) ([]byte, error) { return b, nil },
-		//verifyImpl:          func(senderID uint64, signature []byte, message []byte) error { return nil },
 	}, &inertTimerFactory{})
 	instance.skipInProgress = true
 
@@ -1769,3 +2724,112 @@ func TestStateNetworkMovesOnDuringSlowStateTransfer(t *testing.T) {
 		t.Fatalf("Expected watermark movement to %d because of state transfer, but low watermark is %d", seqNo, instance.h)
 	}
 }
+
+func TestNetworkSimulatedLatency(t *testing.T) {
+	validatorCount := 4
+	net := makePBFTNetwork(validatorCount, nil)
+	defer net.stop()
+	// Messages overtake each other, and some arrive twice
+	net.simulate(newNetSim(0, linkProfile{latency: normalLatency(5*time.Millisecond, 5*time.Millisecond), duplicate: 0.2}))
+
+	var last *Request
+	for i := int64(1); i <= 10; i++ {
+		last = createPbftRequestWithChainTx(i, uint64(generateBroadcaster(validatorCount)))
+		net.pbftEndpoints[0].manager.Queue() <- last
+	}
+	if err := net.process(); err != nil {
+		t.Fatalf("Processing failed: %s", err)
+	}
+
+	for _, pep := range net.pbftEndpoints {
+		if pep.sc.executions != 10 {
+			t.Errorf("Instance %d executed %d transactions instead of 10", pep.id, pep.sc.executions)
+		}
+		if !reflect.DeepEqual(pep.sc.lastExecution, last.Payload) {
+			t.Errorf("Instance %d did not execute the last request last", pep.id)
+		}
+		if pep.pbft.view != 0 {
+			t.Errorf("Instance %d should still be in view 0, is in view %d", pep.id, pep.pbft.view)
+		}
+	}
+}
+
+func TestNetworkSimulatedPrimaryPartition(t *testing.T) {
+	validatorCount := 4
+	config := loadConfig()
+	config.Set("general.timeout.request", "500ms")
+	net := makePBFTNetwork(validatorCount, config)
+	defer net.stop()
+	sim := newNetSim(0, linkProfile{latency: uniformLatency(time.Millisecond, 10*time.Millisecond)})
+	net.simulate(sim)
+
+	// Cut the primary off, the others change view once the request times out
+	sim.partition([]int{1, 2, 3})
+	req := createPbftRequestWithChainTx(1, uint64(generateBroadcaster(validatorCount)))
+	for _, pep := range net.pbftEndpoints {
+		pep.manager.Queue() <- req
+	}
+	if err := net.process(); err != nil {
+		t.Fatalf("Processing failed: %s", err)
+	}
+
+	// A pre-prepare which overtakes the new-view message is ignored, so the
+	// request may take more than one view change
+	view := net.pbftEndpoints[1].pbft.view
+	if view == 0 {
+		t.Errorf("Instance 1 should have changed view")
+	}
+	for _, pep := range net.pbftEndpoints[1:] {
+		if pep.sc.executions != 1 {
+			t.Errorf("Instance %d executed %d transactions instead of 1", pep.id, pep.sc.executions)
+		}
+		if pep.pbft.view != view {
+			t.Errorf("Instance %d should be in view %d, is in view %d", pep.id, view, pep.pbft.view)
+		}
+	}
+	if pep := net.pbftEndpoints[0]; pep.sc.executions != 0 {
+		t.Errorf("Instance %d executed a transaction while cut off", pep.id)
+	}
+}
+
+func TestNetworkSimulatedPartitionHeal(t *testing.T) {
+	validatorCount := 4
+	config := loadConfig()
+	config.Set("general.K", 2)
+	config.Set("general.logmultiplier", 2)
+	net := makePBFTNetwork(validatorCount, config)
+	defer net.stop()
+	sim := newNetSim(0, linkProfile{latency: uniformLatency(time.Millisecond, 5*time.Millisecond)})
+	net.simulate(sim)
+
+	execReq := func(iter int64) {
+		net.pbftEndpoints[0].manager.Queue() <- createPbftRequestWithChainTx(iter, uint64(generateBroadcaster(validatorCount)))
+		if err := net.process(); err != nil {
+			t.Fatalf("Processing failed: %s", err)
+		}
+	}
+
+	pep := net.pbftEndpoints[3]
+	pbft := pep.pbft
+	sim.partition([]int{0, 1, 2}, []int{3})
+	request := int64(1)
+	for ; uint64(request) <= pbft.L+pbft.K; request++ {
+		execReq(request)
+	}
+	if pep.sc.executions != 0 {
+		t.Fatalf("Replica %d executed %d transactions while partitioned", pep.id, pep.sc.executions)
+	}
+
+	// Once the partition heals, the checkpoints of the others show the
+	// replica it fell behind
+	sim.heal()
+	for ; uint64(request) <= pbft.L+pbft.K*2; request++ {
+		execReq(request)
+	}
+	if !pep.sc.skipOccurred {
+		t.Fatalf("Replica did not detect that it has fallen behind")
+	}
+	if pep.sc.executions < pbft.L+pbft.K*2 {
+		t.Fatalf("Replica did not catch up after the partition healed, executed %d transactions", pep.sc.executions)
+	}
+}
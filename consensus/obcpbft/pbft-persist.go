@@ -18,6 +18,7 @@ package obcpbft
 
 import (
 	"encoding/base64"
+	"encoding/binary"
 	"fmt"
 
 	"github.com/golang/protobuf/proto"
@@ -100,6 +101,93 @@ func (instance *pbftCore) persistDelCheckpoint(seqNo uint64) {
 	instance.consumer.DelState(key)
 }
 
+// persistView records the view we moved to, which the pset and qset do not
+// tell once no request was prepared in it
+func (instance *pbftCore) persistView() {
+	raw := make([]byte, 8)
+	binary.BigEndian.PutUint64(raw, instance.view)
+	instance.consumer.StoreState("view", raw)
+}
+
+func certKey(idx msgID) string {
+	return fmt.Sprintf("cert.%d.%d", idx.v, idx.n)
+}
+
+// persistCert records the certificate of idx once it is prepared, and again
+// as it collects commits, so that a restarted replica neither forgets the
+// commits it sent nor the requests it may execute
+func (instance *pbftCore) persistCert(idx msgID) {
+	cert := instance.certStore[idx]
+	if cert == nil || cert.prePrepare == nil {
+		return
+	}
+	preprep := *cert.prePrepare
+	preprep.Request = nil // persisted along with the requests
+	raw, err := proto.Marshal(&Certificate{PrePrepare: &preprep, Prepare: cert.prepare, Commit: cert.commit})
+	if err != nil {
+		logger.Warningf("Replica %d could not persist certificate for view=%d/seqNo=%d: %s", instance.id, idx.v, idx.n, err)
+		return
+	}
+	instance.consumer.StoreState(certKey(idx), raw)
+}
+
+func (instance *pbftCore) persistDelCert(idx msgID) {
+	instance.consumer.DelState(certKey(idx))
+}
+
+// restoreCerts restores the certificates above the low watermark
+func (instance *pbftCore) restoreCerts() {
+	certs, err := instance.consumer.ReadStateSet("cert.")
+	if err != nil {
+		logger.Warningf("Replica %d could not restore certificates: %s", instance.id, err)
+		return
+	}
+	for key, raw := range certs {
+		var idx msgID
+		if _, err = fmt.Sscanf(key, "cert.%d.%d", &idx.v, &idx.n); err != nil {
+			logger.Warningf("Replica %d could not restore certificate key %s", instance.id, key)
+			continue
+		}
+		if idx.n <= instance.h {
+			instance.consumer.DelState(key)
+			continue
+		}
+		val := &Certificate{}
+		if err = proto.Unmarshal(raw, val); err != nil || val.PrePrepare == nil {
+			logger.Errorf("Replica %d could not unmarshal %s - local state is damaged: %v", instance.id, key, err)
+			continue
+		}
+		digest := val.PrePrepare.RequestDigest
+		if digest != "" {
+			req, ok := instance.reqStore[digest]
+			if !ok {
+				logger.Warningf("Replica %d could not restore certificate %s, its request %s is missing", instance.id, key, digest)
+				continue
+			}
+			val.PrePrepare.Request = req
+		}
+		cert := &msgCert{
+			digest:      digest,
+			prePrepare:  val.PrePrepare,
+			sentPrepare: true,
+			prepare:     val.Prepare,
+			commit:      val.Commit,
+		}
+		for _, commit := range val.Commit {
+			if commit.ReplicaId == instance.id {
+				cert.sentCommit = true
+			}
+		}
+		instance.certStore[idx] = cert
+		if instance.view < idx.v {
+			instance.view = idx.v
+		}
+		if instance.seqNo < idx.n {
+			instance.seqNo = idx.n
+		}
+	}
+}
+
 func (instance *pbftCore) restoreState() {
 	updateSeqView := func(set []*ViewChange_PQ) {
 		for _, e := range set {
@@ -160,10 +248,18 @@ func (instance *pbftCore) restoreState() {
 		logger.Warningf("Replica %d could not restore checkpoints: %s", instance.id, err)
 	}
 
+	instance.restoreCerts()
+
+	if raw, err := instance.consumer.ReadState("view"); err == nil && len(raw) == 8 {
+		if view := binary.BigEndian.Uint64(raw); instance.view < view {
+			instance.view = view
+		}
+	}
+
 	instance.restoreLastSeqNo()
 
-	logger.Infof("Replica %d restored state: view: %d, seqNo: %d, pset: %d, qset: %d, reqs: %d, chkpts: %d",
-		instance.id, instance.view, instance.seqNo, len(instance.pset), len(instance.qset), len(instance.reqStore), len(instance.chkpts))
+	logger.Infof("Replica %d restored state: view: %d, seqNo: %d, pset: %d, qset: %d, reqs: %d, chkpts: %d, certs: %d",
+		instance.id, instance.view, instance.seqNo, len(instance.pset), len(instance.qset), len(instance.reqStore), len(instance.chkpts), len(instance.certStore))
 }
 
 func (instance *pbftCore) restoreLastSeqNo() {
@@ -0,0 +1,109 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package obcpbft
+
+import (
+	"encoding/base64"
+	"sort"
+)
+
+// A replica which finds f+1 other replicas agreeing on a different execution
+// result or checkpoint than its own knows its state is corrupt, and that its
+// votes would attest to it. It quarantines itself: it stops sending
+// pre-prepares, prepares, commits, checkpoints and view changes, stops
+// executing, and raises a critical alert for the operators. With autoresync,
+// it then resyncs through state transfer to the checkpoint attested to by
+// those replicas, and leaves quarantine once the transfer completes, as the
+// state it reached is checked again against the network. Without, it stays
+// quarantined until the operators repair its ledger and restart it.
+
+func (instance *pbftCore) quarantine(seqNo uint64, ours string, theirs string, replicas []uint64) {
+	snapshotID, err := base64.StdEncoding.DecodeString(theirs)
+	if err != nil {
+		logger.Errorf("Replica %d could not decode state id %s for seqNo %d: %s", instance.id, theirs, seqNo, err)
+		return
+	}
+	target := &stateUpdateTarget{
+		checkpointMessage: checkpointMessage{
+			seqNo: seqNo,
+			id:    snapshotID,
+		},
+		replicas: replicas,
+	}
+	instance.updateHighStateTarget(target)
+
+	if !instance.quarantined {
+		instance.quarantined = true
+		logger.Criticalf("Replica %d state diverged from the network at seqNo %d, our state is %s but replicas %v report %s: quarantining this replica, it no longer votes",
+			instance.id, seqNo, ours, replicas, theirs)
+		if !instance.autoResync {
+			logger.Criticalf("Replica %d automatic resync is disabled, restore its ledger and restart it to leave quarantine", instance.id)
+		}
+	}
+
+	// Stop executing on top of the corrupt state
+	if !instance.skipInProgress {
+		instance.skipInProgress = true
+		instance.consumer.invalidateState()
+	}
+	instance.retryStateTransfer(target)
+}
+
+// leaveQuarantine is invoked once state transfer has caught up with the network
+func (instance *pbftCore) leaveQuarantine() {
+	if !instance.quarantined {
+		return
+	}
+	instance.quarantined = false
+	logger.Noticef("Replica %d resynced to seqNo %d, leaving quarantine", instance.id, instance.lastExec)
+}
+
+// checkCheckpointDivergence quarantines the replica if f+1 other replicas
+// agree on a different checkpoint for seqNo than its own, and returns whether
+// it did
+func (instance *pbftCore) checkCheckpointDivergence(seqNo uint64) bool {
+	ours, ok := instance.chkpts[seqNo]
+	if !ok {
+		return false
+	}
+
+	members := make(map[string][]uint64)
+	for _, chkpt := range instance.checkpointStore {
+		if chkpt.SequenceNumber == seqNo && chkpt.Id != ours {
+			members[chkpt.Id] = append(members[chkpt.Id], chkpt.ReplicaId)
+		}
+	}
+	for id, replicas := range members {
+		if len(replicas) < instance.f+1 {
+			continue
+		}
+		sort.Sort(sortableUint64Slice(replicas))
+		instance.quarantine(seqNo, ours, id, replicas)
+		return true
+	}
+	return false
+}
+
+// isVote returns whether msg attests to the state of the replica, and so may
+// not be sent while quarantined
+func isVote(msg *Message) bool {
+	switch msg.Payload.(type) {
+	case *Message_PrePrepare, *Message_Prepare, *Message_Commit, *Message_Checkpoint, *Message_ViewChange, *Message_NewView:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,202 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package obcpbft
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric/consensus"
+	pb "github.com/hyperledger/fabric/protos"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/spf13/viper"
+)
+
+// A query does not change the state, so there is no need to order it. The
+// replica it is submitted to sends it to all replicas as a read-only request,
+// which every replica executes on receipt against its committed state, and
+// answers with its result. Once f+1 replicas, so at least one correct replica,
+// return the same result, the query is answered with it. Replicas which are
+// behind may return an older result, so a query during which the state changes
+// may not gather f+1 matching results, and fails after the read-only timeout.
+
+// readOnlyQuery is a read-only request waiting for matching results
+type readOnlyQuery struct {
+	replies map[uint64]*ReadOnlyReply // By replica
+	result  chan *ReadOnlyReply       // Receives the result f+1 replicas agree on
+}
+
+// readOnlyQueries holds the read-only requests the replica submitted
+type readOnlyQueries struct {
+	timeout   time.Duration
+	queries   map[string]*readOnlyQuery // By request digest
+	executing chan struct{}             // Holds a token per read-only request being executed
+}
+
+func newReadOnlyQueries(config *viper.Viper) *readOnlyQueries {
+	if !config.GetBool("general.readonly.enabled") {
+		return nil
+	}
+	timeout, err := time.ParseDuration(config.GetString("general.timeout.readonly"))
+	if err != nil {
+		panic(fmt.Errorf("Cannot parse read-only timeout: %s", err))
+	}
+	executions := config.GetInt("general.readonly.executions")
+	if executions < 1 {
+		panic(fmt.Errorf("Read-only executions must be greater than or equal to 1. Current value is %d.", executions))
+	}
+	return &readOnlyQueries{
+		timeout:   timeout,
+		queries:   make(map[string]*readOnlyQuery),
+		executing: make(chan struct{}, executions),
+	}
+}
+
+// Query answers a query with the result f+1 replicas agree on
+func (op *obcBatch) Query(tx *pb.Transaction) ([]byte, error) {
+	if op.readOnly == nil {
+		return nil, consensus.ErrReadOnlyDisabled
+	}
+	raw, err := proto.Marshal(tx)
+	if err != nil {
+		return nil, err
+	}
+
+	var digest string
+	var query *readOnlyQuery
+	submitted := make(chan struct{})
+	op.manager.Queue() <- workEvent(func() {
+		req := op.txToReq(raw)
+		req.ReadOnly = true
+		digest = hashReq(req)
+		query = &readOnlyQuery{
+			replies: make(map[uint64]*ReadOnlyReply),
+			result:  make(chan *ReadOnlyReply, 1),
+		}
+		op.readOnly.queries[digest] = query
+		logger.Debugf("Batch replica %d submitting read-only request %s", op.pbft.id, digest)
+		op.broadcastMsg(&BatchMessage{&BatchMessage_Request{req}})
+		op.executeReadOnly(req, digest)
+		close(submitted)
+	})
+	<-submitted
+
+	defer func() {
+		op.manager.Queue() <- workEvent(func() {
+			delete(op.readOnly.queries, digest)
+		})
+	}()
+	select {
+	case reply := <-query.result:
+		if reply.Error != "" {
+			return nil, errors.New(reply.Error)
+		}
+		return reply.Result, nil
+	case <-time.After(op.readOnly.timeout):
+		return nil, fmt.Errorf("No %d replicas agreed on the result of the query within %v", op.pbft.f+1, op.readOnly.timeout)
+	}
+}
+
+// recvReadOnlyRequest executes the read-only request another replica
+// submitted, provided queries are answered by f+1 replicas and the request
+// comes from the replica it names, which the result is sent to
+func (op *obcBatch) recvReadOnlyRequest(req *Request, senderHandle *pb.PeerID) {
+	digest := hashReq(req)
+	if op.readOnly == nil {
+		logger.Debugf("Batch replica %d dropping read-only request %s, queries are not answered by f+1 replicas", op.pbft.id, digest)
+		return
+	}
+	senderID, err := getValidatorID(senderHandle)
+	if err != nil || senderID != req.ReplicaId {
+		logger.Warningf("Batch replica %d dropping read-only request %s of replica %d, sent by %v", op.pbft.id, digest, req.ReplicaId, senderHandle)
+		return
+	}
+	op.executeReadOnly(req, digest)
+}
+
+// executeReadOnly executes a read-only request in the background, and sends
+// the result to the replica which submitted it. Requests arriving while
+// general.readonly.executions others are being executed are dropped
+func (op *obcBatch) executeReadOnly(req *Request, digest string) {
+	executor, ok := op.stack.(consensus.ReadOnlyExecutor)
+	if !ok {
+		logger.Warningf("Batch replica %d cannot execute read-only request %s, the stack does not support it", op.pbft.id, digest)
+		return
+	}
+	tx := &pb.Transaction{}
+	if err := proto.Unmarshal(req.Payload, tx); err != nil {
+		logger.Warningf("Batch replica %d could not unmarshal read-only request %s: %s", op.pbft.id, digest, err)
+		return
+	}
+	select {
+	case op.readOnly.executing <- struct{}{}:
+	default:
+		logger.Warningf("Batch replica %d dropping read-only request %s, %d read-only requests are being executed",
+			op.pbft.id, digest, cap(op.readOnly.executing))
+		return
+	}
+	go func() {
+		reply := &ReadOnlyReply{RequestDigest: digest}
+		result, err := executor.ExecuteReadOnly(tx)
+		<-op.readOnly.executing
+		if err != nil {
+			reply.Error = err.Error()
+		} else {
+			reply.Result = result
+		}
+		op.manager.Queue() <- workEvent(func() {
+			if req.ReplicaId == op.pbft.id {
+				op.recvReadOnlyReply(reply, op.pbft.id)
+				return
+			}
+			op.unicastMsg(&BatchMessage{&BatchMessage_ReadOnlyReply{reply}}, req.ReplicaId)
+		})
+	}()
+}
+
+// recvReadOnlyReply records the result of a read-only request we submitted,
+// and answers the query once f+1 replicas agree on it
+func (op *obcBatch) recvReadOnlyReply(reply *ReadOnlyReply, senderID uint64) {
+	if op.readOnly == nil {
+		return
+	}
+	query, ok := op.readOnly.queries[reply.RequestDigest]
+	if !ok {
+		logger.Debugf("Batch replica %d ignoring result of read-only request %s from replica %d, the request is not outstanding",
+			op.pbft.id, reply.RequestDigest, senderID)
+		return
+	}
+	if _, ok := query.replies[senderID]; ok {
+		return
+	}
+	query.replies[senderID] = reply
+
+	matching := 0
+	for _, other := range query.replies {
+		if other.Error == reply.Error && string(other.Result) == string(reply.Result) {
+			matching++
+		}
+	}
+	if matching < op.pbft.f+1 {
+		return
+	}
+	logger.Debugf("Batch replica %d answering read-only request %s with the result of %d replicas", op.pbft.id, reply.RequestDigest, matching)
+	delete(op.readOnly.queries, reply.RequestDigest)
+	query.result <- reply
+}
@@ -0,0 +1,246 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package obcpbft
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/golang/protobuf/proto"
+
+	"github.com/hyperledger/fabric/consensus"
+	"github.com/hyperledger/fabric/consensus/obcpbft/events"
+)
+
+// A reconfiguration changes the number of replicas N, and the number of
+// faults f tolerated, of a running network. A validating peer requests it
+// through the admin API; the request is signed by the replica and ordered
+// like any other, so that all replicas execute it at the same sequence
+// number. A single faulty replica must not change the fault model, so the
+// requests are votes: the reconfiguration is only ordered once 2f+1 distinct
+// replicas of the network requested the same N and f for the next epoch, and
+// each replica votes for one reconfiguration at a time. Each reconfiguration
+// starts a new epoch. The latest one ordered, and the votes for the next one,
+// are carried in the metadata of the blocks, so that replicas restarting,
+// catching up through state transfer or joining the network learn them from
+// the chain.
+//
+// A reconfiguration takes effect when the first checkpoint at or after its
+// sequence number becomes stable: from the next sequence number on, quorums
+// are computed over the new N and f, the primary of a view is chosen among
+// the new replicas, and messages from replicas outside of the network are
+// dropped. The consensus stack is then notified of the new validators.
+//
+// Replicas keep their IDs, vp<id>: growing the network adds the replicas with
+// the next IDs, which must be started with the new N and f, and shrinking it
+// removes the replicas with the highest IDs. The random beacon keeps the
+// quorum of the configured f.
+
+// checkReconfiguration returns why the network cannot be reconfigured to N
+// replicas tolerating f faults, if it cannot
+func checkReconfiguration(N int, f int) error {
+	if f < 0 || N < 1 || N > math.MaxUint32 {
+		return fmt.Errorf("invalid reconfiguration to N=%d, f=%d", N, f)
+	}
+	if f*3+1 > N {
+		return fmt.Errorf("need at least %d replicas to tolerate %d byzantine faults, but only %d requested", f*3+1, f, N)
+	}
+	return nil
+}
+
+// reconfigurationQuorum returns how many distinct replicas of a network
+// tolerating f faults must request a reconfiguration before it is ordered
+func reconfigurationQuorum(f int) int {
+	return 2*f + 1
+}
+
+// scheduleReconfiguration records the latest reconfiguration ordered, which
+// takes effect once the watermarks move past it
+func (instance *pbftCore) scheduleReconfiguration(config *Reconfiguration) {
+	if config == nil || config.Epoch <= instance.epoch {
+		return
+	}
+	logger.Infof("Replica %d scheduling reconfiguration of epoch %d to N=%d, f=%d, ordered at seqNo %d",
+		instance.id, config.Epoch, config.N, config.F, config.SeqNo)
+	instance.nextConfig = config
+	instance.applyReconfiguration()
+}
+
+// applyReconfiguration puts the scheduled reconfiguration into effect, once
+// our low watermark reaches it
+func (instance *pbftCore) applyReconfiguration() {
+	config := instance.nextConfig
+	if config == nil || config.SeqNo > instance.h {
+		return
+	}
+	instance.nextConfig = nil
+	instance.epoch = config.Epoch
+	instance.N = int(config.N)
+	instance.f = int(config.F)
+	instance.replicaCount = instance.N
+	logger.Infof("Replica %d entering epoch %d at seqNo %d, N=%d, f=%d, primary of view %d is %d",
+		instance.id, instance.epoch, instance.h, instance.N, instance.f, instance.view, instance.primary(instance.view))
+	if instance.id >= uint64(instance.N) {
+		logger.Warningf("Replica %d is no longer a replica of the network", instance.id)
+	}
+	instance.consumer.reconfigured(config)
+}
+
+// reconfigured notifies the stack of the validators of the network
+func (op *obcGeneric) reconfigured(config *Reconfiguration) {
+	listener, ok := op.stack.(consensus.ValidatorSetListener)
+	if !ok {
+		return
+	}
+	ids := make([]uint64, config.N)
+	for i := range ids {
+		ids[i] = uint64(i)
+	}
+	listener.ValidatorsChanged(config.Epoch, getValidatorHandles(ids))
+}
+
+func (op *obcBatch) reconfigured(config *Reconfiguration) {
+	op.broadcaster.reconfigure(op.pbft.id, int(config.N), int(config.F))
+	if op.auth != nil {
+		op.auth.N = int(config.N)
+	}
+	op.obcGeneric.reconfigured(config)
+}
+
+// Reconfigure submits our vote to reconfigure the network to N replicas
+// tolerating f faults in the next epoch
+func (op *obcBatch) Reconfigure(N int, f int) error {
+	if err := checkReconfiguration(N, f); err != nil {
+		return err
+	}
+	result := make(chan error)
+	op.manager.Queue() <- workEvent(func() {
+		req := op.txToReq(nil)
+		req.Reconfiguration = &Reconfiguration{N: uint32(N), F: uint32(f), Epoch: 1}
+		if latest := op.headReconfiguration(); latest != nil {
+			req.Reconfiguration.Epoch = latest.Epoch + 1
+		}
+		if err := op.pbft.sign(req); err != nil {
+			result <- fmt.Errorf("cannot sign the reconfiguration request: %s", err)
+			return
+		}
+		logger.Infof("Batch replica %d requesting the reconfiguration of the network to N=%d, f=%d", op.pbft.id, N, f)
+		events.SendEvent(op, op.submitToLeader(req))
+		result <- nil
+	})
+	return <-result
+}
+
+// validReconfiguration returns why a reconfiguration request must not be
+// ordered, if it must not
+func (op *obcBatch) validReconfiguration(req *Request) error {
+	if req.ReplicaId >= uint64(op.pbft.N) {
+		return fmt.Errorf("reconfiguration request from replica %d, which is not a replica of the network", req.ReplicaId)
+	}
+	if err := op.pbft.verify(req); err != nil {
+		return fmt.Errorf("reconfiguration request from replica %d has an incorrect signature: %s", req.ReplicaId, err)
+	}
+	return checkReconfiguration(int(req.Reconfiguration.N), int(req.Reconfiguration.F))
+}
+
+// headMetadata returns the consensus metadata of the block at the head of
+// the chain, empty if there is none
+func (op *obcBatch) headMetadata() *Metadata {
+	meta := &Metadata{}
+	raw, err := op.stack.GetBlockHeadMetadata()
+	if err != nil {
+		logger.Debugf("Batch replica %d could not get the metadata of the head block: %s", op.pbft.id, err)
+	} else if err = proto.Unmarshal(raw, meta); err != nil {
+		logger.Warningf("Batch replica %d could not unmarshal the metadata of the head block: %s", op.pbft.id, err)
+	}
+	return meta
+}
+
+// headReconfiguration returns the latest reconfiguration ordered up to the
+// block at the head of the chain, nil if there is none
+func (op *obcBatch) headReconfiguration() *Reconfiguration {
+	return op.headMetadata().Reconfiguration
+}
+
+// executeReconfiguration counts the vote of req at seqNo for a
+// reconfiguration after the latest one, and orders the reconfiguration once
+// a quorum voted for it. It returns the latest reconfiguration and the
+// proposals still short of a quorum then.
+func (op *obcBatch) executeReconfiguration(seqNo uint64, req *Request, latest *Reconfiguration, proposals []*Reconfiguration) (*Reconfiguration, []*Reconfiguration) {
+	requested := req.Reconfiguration
+	if err := checkReconfiguration(int(requested.N), int(requested.F)); err != nil {
+		logger.Warningf("Batch replica %d ignoring reconfiguration request from replica %d: %s", op.pbft.id, req.ReplicaId, err)
+		return latest, proposals
+	}
+
+	// The votes are counted among the replicas of the latest reconfiguration
+	// ordered, the configured ones if there is none
+	N, f, epoch := op.pbft.N, op.pbft.f, uint64(0)
+	if latest != nil {
+		N, f, epoch = int(latest.N), int(latest.F), latest.Epoch
+	}
+	if requested.Epoch != epoch+1 {
+		logger.Warningf("Batch replica %d ignoring reconfiguration request from replica %d for epoch %d, the next epoch is %d",
+			op.pbft.id, req.ReplicaId, requested.Epoch, epoch+1)
+		return latest, proposals
+	}
+	if req.ReplicaId >= uint64(N) {
+		logger.Warningf("Batch replica %d ignoring reconfiguration request from replica %d, which is not a replica of the network",
+			op.pbft.id, req.ReplicaId)
+		return latest, proposals
+	}
+
+	// The latest request of a replica replaces its previous vote
+	var proposal *Reconfiguration
+	var kept []*Reconfiguration
+	for _, p := range proposals {
+		var votes []uint64
+		for _, id := range p.Votes {
+			if id != req.ReplicaId {
+				votes = append(votes, id)
+			}
+		}
+		p.Votes = votes
+		if p.N == requested.N && p.F == requested.F {
+			proposal = p
+		} else if len(p.Votes) == 0 {
+			continue
+		}
+		kept = append(kept, p)
+	}
+	if proposal == nil {
+		proposal = &Reconfiguration{Epoch: epoch + 1, N: requested.N, F: requested.F}
+		kept = append(kept, proposal)
+	}
+	proposal.Votes = append(proposal.Votes, req.ReplicaId)
+
+	if len(proposal.Votes) < reconfigurationQuorum(f) {
+		logger.Infof("Batch replica %d counted the vote of replica %d for the reconfiguration of epoch %d to N=%d, f=%d, %d of %d votes",
+			op.pbft.id, req.ReplicaId, proposal.Epoch, proposal.N, proposal.F, len(proposal.Votes), reconfigurationQuorum(f))
+		return latest, kept
+	}
+
+	config := &Reconfiguration{
+		Epoch: epoch + 1,
+		N:     requested.N,
+		F:     requested.F,
+		SeqNo: seqNo,
+	}
+	op.pbft.scheduleReconfiguration(config)
+	// The other proposals were for the epoch just decided
+	return config, nil
+}
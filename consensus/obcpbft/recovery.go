@@ -0,0 +1,223 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package obcpbft
+
+import (
+	"encoding/base64"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+)
+
+// With general.recovery.period set, replicas proactively recover, as in
+// PBFT-PR, so that an attacker who compromised a replica loses its hold on
+// it within a period, and must compromise more than f replicas within a
+// single period to break safety. The replicas recover in turn, replica i at
+// offset i*period/N of every period by its clock, so that at most one of them
+// recovers at a time.
+//
+// A recovering replica starts over from a clean state: it refreshes its
+// session keys, which renews the key it shares with every other replica, and
+// forgets the hints other replicas gave it which no certificate backs. It
+// then asks all replicas for their checkpoints, the replies echoing the nonce
+// of its request. Once f+1 other replicas, so at least one correct replica,
+// attest to the same checkpoint, the replica checks its state against it. A
+// replica whose checkpoint for that sequence number differs quarantines
+// itself and resyncs, and a replica whose log lies below that sequence number
+// re-fetches its state through state transfer. Otherwise its state is the one
+// the network agrees on, and it carries on. A recovery which gathers no such
+// checkpoint is superseded by the next one.
+
+// recoveryTimerEvent is sent when it is the turn of the replica to recover
+type recoveryTimerEvent struct{}
+
+// recoveringStack is implemented by consumers which hold keys to refresh
+// when the replica recovers
+type recoveringStack interface {
+	refreshKeys()
+}
+
+// recoveryState is a recovery in progress
+type recoveryState struct {
+	nonce   uint64
+	replies map[uint64][]*Checkpoint // checkpoints of the replicas which replied, by replica
+}
+
+// scheduleRecovery arms the timer of the next recovery of the replica
+func (instance *pbftCore) scheduleRecovery() {
+	if instance.recoveryPeriod <= 0 {
+		return
+	}
+	instance.recoveryTimer.Reset(instance.untilRecovery(time.Now()), recoveryTimerEvent{})
+}
+
+// untilRecovery returns how long until the next turn of the replica to recover
+func (instance *pbftCore) untilRecovery(now time.Time) time.Duration {
+	period := instance.recoveryPeriod
+	offset := period / time.Duration(instance.N) * time.Duration(instance.id%uint64(instance.N))
+	wait := offset - time.Duration(now.UnixNano())%period
+	if wait <= 0 {
+		wait += period
+	}
+	return wait
+}
+
+// recover starts a proactive recovery of the replica
+func (instance *pbftCore) recover() {
+	instance.scheduleRecovery()
+	if instance.id >= uint64(instance.N) {
+		return
+	}
+
+	if instance.recovery != nil {
+		logger.Warningf("Replica %d recovering again, no checkpoint gathered f+1 replies during its last recovery", instance.id)
+	}
+	logger.Infof("Replica %d proactively recovering", instance.id)
+	recoveries.Inc()
+
+	instance.recovery = &recoveryState{
+		nonce:   uint64(time.Now().UnixNano()),
+		replies: make(map[uint64][]*Checkpoint),
+	}
+
+	// Forget the hints of other replicas which no certificate backs
+	instance.hChkpts = make(map[uint64]*Checkpoint)
+	instance.certReplies = make(map[uint64]map[uint64]*PrePrepare)
+
+	// The new keys authenticate the recovery request already
+	if rs, ok := instance.consumer.(recoveringStack); ok {
+		rs.refreshKeys()
+	}
+
+	instance.innerBroadcast(&Message{&Message_RecoveryRequest{&RecoveryRequest{
+		ReplicaId: instance.id,
+		Nonce:     instance.recovery.nonce,
+	}}})
+}
+
+// recvRecoveryRequest answers a recovering replica with our checkpoints
+func (instance *pbftCore) recvRecoveryRequest(rr *RecoveryRequest) error {
+	logger.Debugf("Replica %d received recovery-request from replica %d", instance.id, rr.ReplicaId)
+	if instance.quarantined {
+		// Our checkpoints attest to a corrupt state
+		return nil
+	}
+
+	reply := &RecoveryReply{
+		ReplicaId: instance.id,
+		Nonce:     rr.Nonce,
+	}
+	for idx, chkpt := range instance.checkpointStore {
+		if idx.replica == instance.id {
+			reply.Checkpoints = append(reply.Checkpoints, chkpt)
+		}
+	}
+	msgRaw, err := proto.Marshal(&Message{&Message_RecoveryReply{reply}})
+	if err != nil {
+		return fmt.Errorf("Error marshalling recovery-reply message: %v", err)
+	}
+	return instance.consumer.unicast(msgRaw, rr.ReplicaId)
+}
+
+// recvRecoveryReply checks our state once f+1 replicas attest to the same
+// checkpoint
+func (instance *pbftCore) recvRecoveryReply(reply *RecoveryReply) {
+	r := instance.recovery
+	if r == nil || reply.Nonce != r.nonce {
+		logger.Debugf("Replica %d ignoring recovery-reply from replica %d, not for a recovery in progress", instance.id, reply.ReplicaId)
+		return
+	}
+	for _, chkpt := range reply.Checkpoints {
+		if chkpt.ReplicaId != reply.ReplicaId {
+			logger.Warningf("Replica %d received recovery-reply from replica %d carrying a checkpoint of replica %d", instance.id, reply.ReplicaId, chkpt.ReplicaId)
+			return
+		}
+		if err := instance.verify(chkpt); err != nil {
+			logger.Warningf("Replica %d found incorrect signature in recovery-reply from replica %d: %s", instance.id, reply.ReplicaId, err)
+			return
+		}
+	}
+	r.replies[reply.ReplicaId] = reply.Checkpoints
+
+	seqNo, id, replicas := r.attested(instance.f + 1)
+	if replicas == nil {
+		return
+	}
+	instance.recovery = nil
+	instance.checkRecoveredState(seqNo, id, replicas)
+}
+
+// attested returns the highest checkpoint quorum replicas replied with
+func (r *recoveryState) attested(quorum int) (uint64, string, []uint64) {
+	members := make(map[chkptidx][]uint64)
+	for replica, chkpts := range r.replies {
+		for _, chkpt := range chkpts {
+			idx := chkptidx{n: chkpt.SequenceNumber, id: chkpt.Id}
+			members[idx] = append(members[idx], replica)
+		}
+	}
+	var best chkptidx
+	var replicas []uint64
+	for idx, m := range members {
+		if len(m) >= quorum && (replicas == nil || idx.n > best.n) {
+			best, replicas = idx, m
+		}
+	}
+	sort.Sort(sortableUint64Slice(replicas))
+	return best.n, best.id, replicas
+}
+
+// checkRecoveredState checks our state against the checkpoint replicas attest
+// to, and re-fetches it if it diverged or lags behind our log
+func (instance *pbftCore) checkRecoveredState(seqNo uint64, id string, replicas []uint64) {
+	if ours, ok := instance.chkpts[seqNo]; ok {
+		if ours == id {
+			logger.Infof("Replica %d recovered, its checkpoint for seqNo %d is the one replicas %v attest to", instance.id, seqNo, replicas)
+			return
+		}
+		recoveryResyncs.Inc()
+		instance.quarantine(seqNo, ours, id, replicas)
+		return
+	}
+
+	if seqNo <= instance.h+instance.L || instance.skipInProgress {
+		// We executed past it, or will reach it executing our log, and
+		// check it then against the checkpoints of the network
+		logger.Infof("Replica %d recovered, replicas %v attest to seqNo %d, it executed up to %d", instance.id, replicas, seqNo, instance.lastExec)
+		return
+	}
+
+	snapshotID, err := base64.StdEncoding.DecodeString(id)
+	if err != nil {
+		logger.Errorf("Replica %d could not decode state id %s for seqNo %d: %s", instance.id, id, seqNo, err)
+		return
+	}
+	logger.Warningf("Replica %d recovering is behind, replicas %v attest to seqNo %d past its high watermark %d, fetching state",
+		instance.id, replicas, seqNo, instance.h+instance.L)
+	recoveryResyncs.Inc()
+	target := &stateUpdateTarget{
+		checkpointMessage: checkpointMessage{
+			seqNo: seqNo,
+			id:    snapshotID,
+		},
+		replicas: replicas,
+	}
+	instance.updateHighStateTarget(target)
+	instance.stateTransfer(target)
+}
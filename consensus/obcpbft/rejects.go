@@ -0,0 +1,152 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package obcpbft
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/spf13/viper"
+
+	pb "github.com/hyperledger/fabric/protos"
+)
+
+// A replica drops the consensus messages it cannot unpack, which are either
+// corrupt or crafted. To reproduce those, it keeps the first of them, along
+// with their sender, in files of the rejects directory, which "peer consensus
+// replay" hands back to a replica to debug it.
+
+// RejectedMessage is a consensus message a replica rejected as malformed, as
+// kept in the rejects directory
+type RejectedMessage struct {
+	Time    time.Time `json:"time"`
+	Sender  string    `json:"sender"`  // name of the peer which sent the message
+	Replica int64     `json:"replica"` // replica ID of the sender, -1 if unknown
+	Reason  string    `json:"reason"`
+	Payload []byte    `json:"payload"` // payload of the consensus message as received
+}
+
+// rejectStore keeps rejected messages in a directory, up to max of them
+type rejectStore struct {
+	dir   string
+	max   int
+	count int
+}
+
+func newRejectStore(config *viper.Viper) *rejectStore {
+	dir := config.GetString("general.rejects.dir")
+	if dir == "" {
+		return nil
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		logger.Errorf("Cannot create rejects directory %s, not keeping rejected messages: %s", dir, err)
+		return nil
+	}
+	existing, _ := filepath.Glob(filepath.Join(dir, "*.json"))
+	return &rejectStore{
+		dir:   dir,
+		max:   config.GetInt("general.rejects.max"),
+		count: len(existing),
+	}
+}
+
+// capture writes a rejected message to the directory, unless it is full
+func (rs *rejectStore) capture(payload []byte, senderHandle *pb.PeerID, reason error) {
+	if rs.count >= rs.max {
+		if rs.count == rs.max {
+			logger.Warningf("Rejects directory %s holds %d messages, not keeping further rejected messages", rs.dir, rs.max)
+			rs.count++
+		}
+		return
+	}
+	rejected := &RejectedMessage{
+		Time:    time.Now(),
+		Replica: -1,
+		Reason:  reason.Error(),
+		Payload: payload,
+	}
+	if senderHandle != nil {
+		rejected.Sender = senderHandle.Name
+		if id, err := getValidatorID(senderHandle); err == nil {
+			rejected.Replica = int64(id)
+		}
+	}
+	raw, err := json.MarshalIndent(rejected, "", "  ")
+	if err != nil {
+		logger.Errorf("Cannot marshal rejected message: %s", err)
+		return
+	}
+	name := filepath.Join(rs.dir, fmt.Sprintf("%d-%s.json", rejected.Time.UnixNano(), strings.Replace(rejected.Sender, string(filepath.Separator), "_", -1)))
+	if err := ioutil.WriteFile(name, raw, 0644); err != nil {
+		logger.Errorf("Cannot write rejected message to %s: %s", name, err)
+		return
+	}
+	rs.count++
+	logger.Infof("Rejected message from %s kept in %s", rejected.Sender, name)
+}
+
+// ReadRejectedMessages reads the rejected messages kept in path, which is
+// either a rejects directory or one of its files, in the order they were
+// rejected
+func ReadRejectedMessages(path string) ([]*RejectedMessage, error) {
+	files := []string{path}
+	if info, err := os.Stat(path); err != nil {
+		return nil, err
+	} else if info.IsDir() {
+		if files, err = filepath.Glob(filepath.Join(path, "*.json")); err != nil {
+			return nil, err
+		}
+		sort.Strings(files)
+	}
+	var rejected []*RejectedMessage
+	for _, file := range files {
+		raw, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		msg := &RejectedMessage{}
+		if err := json.Unmarshal(raw, msg); err != nil {
+			return nil, fmt.Errorf("Cannot read rejected message from %s: %s", file, err)
+		}
+		rejected = append(rejected, msg)
+	}
+	return rejected, nil
+}
+
+// ReplayMessage hands a consensus message to the replica as if sender had
+// sent it, and returns why the replica rejects it, if it does. Replayed
+// messages are not kept as rejects.
+func (op *obcBatch) ReplayMessage(msg *pb.Message, senderHandle *pb.PeerID) error {
+	if msg.Type != pb.Message_CONSENSUS {
+		return fmt.Errorf("Only consensus messages can be replayed, not %s", msg.Type)
+	}
+	result := make(chan error)
+	op.manager.Queue() <- workEvent(func() {
+		_, _, err := op.unpackMessage(msg.Payload, senderHandle)
+		if err == nil {
+			op.manager.Inject(batchMessageEvent{msg: msg, sender: senderHandle})
+		}
+		result <- err
+	})
+	return <-result
+}
@@ -0,0 +1,170 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package obcpbft
+
+import (
+	"fmt"
+
+	"github.com/hyperledger/fabric/consensus/obcpbft/events"
+
+	"github.com/golang/protobuf/proto"
+)
+
+// In "tree" dissemination mode the primary broadcasts pre-prepares without
+// their request, and sends the request to its relayFanout children only. Each
+// replica forwards the request to its own children once it checked it against
+// the digest of the pre-prepare, so that the primary no longer sends N-1
+// copies of every request. The tree of a view is rooted at its primary, the
+// replica at position p, counting from the primary, relays to the replicas at
+// positions p*relayFanout+1 to p*relayFanout+relayFanout.
+
+// relayTimerEvent is sent when a request our parent in the relay tree was
+// to pass on may be overdue
+type relayTimerEvent struct{}
+
+// relayPosition returns the position of replica id in the tree of view v
+func (instance *pbftCore) relayPosition(id uint64, v uint64) int {
+	N := uint64(instance.replicaCount)
+	return int((id + N - instance.primary(v)) % N)
+}
+
+// relayReplica returns the replica at position pos in the tree of view v
+func (instance *pbftCore) relayReplica(pos int, v uint64) uint64 {
+	return (uint64(pos) + instance.primary(v)) % uint64(instance.replicaCount)
+}
+
+// relayParent returns the replica relaying requests of view v to replica id,
+// which must not be the primary of the view
+func (instance *pbftCore) relayParent(id uint64, v uint64) uint64 {
+	pos := instance.relayPosition(id, v)
+	return instance.relayReplica((pos-1)/instance.relayFanout, v)
+}
+
+// relayChildren returns the replicas replica id relays requests of view v to
+func (instance *pbftCore) relayChildren(id uint64, v uint64) []uint64 {
+	var children []uint64
+	first := instance.relayPosition(id, v)*instance.relayFanout + 1
+	for pos := first; pos < first+instance.relayFanout && pos < instance.replicaCount; pos++ {
+		children = append(children, instance.relayReplica(pos, v))
+	}
+	return children
+}
+
+// relayRequest sends the request of the pre-prepare for view=v/seqNo=n to our
+// children in the tree
+func (instance *pbftCore) relayRequest(v uint64, n uint64, req *Request) error {
+	msg := &Message{&Message_RelayRequest{&RelayRequest{
+		View:           v,
+		SequenceNumber: n,
+		Request:        req,
+		ReplicaId:      instance.id,
+	}}}
+	msgRaw, err := proto.Marshal(msg)
+	if err != nil {
+		return fmt.Errorf("Error marshalling relay-request message: %v", err)
+	}
+	for _, child := range instance.relayChildren(instance.id, v) {
+		logger.Debugf("Replica %d relaying request for view=%d/seqNo=%d to replica %d", instance.id, v, n, child)
+		instance.consumer.unicast(msgRaw, child)
+	}
+	return nil
+}
+
+func (instance *pbftCore) recvRelayRequest(relay *RelayRequest) error {
+	logger.Debugf("Replica %d received relayed request from replica %d for view=%d/seqNo=%d",
+		instance.id, relay.ReplicaId, relay.View, relay.SequenceNumber)
+
+	if instance.relayFanout == 0 {
+		logger.Warningf("Replica %d ignoring relayed request as it does not take part in a relay tree", instance.id)
+		return nil
+	}
+
+	if !instance.activeView || relay.View != instance.view || !instance.inW(relay.SequenceNumber) || relay.Request == nil {
+		logger.Debugf("Replica %d ignoring relayed request for view=%d/seqNo=%d", instance.id, relay.View, relay.SequenceNumber)
+		return nil
+	}
+
+	if instance.primary(relay.View) == instance.id {
+		return nil
+	}
+
+	if parent := instance.relayParent(instance.id, relay.View); relay.ReplicaId != parent {
+		logger.Warningf("Replica %d received relayed request from replica %d, expected it from replica %d", instance.id, relay.ReplicaId, parent)
+		return nil
+	}
+
+	// The request is checked against the digest of the pre-prepare, which
+	// may not have arrived yet
+	idx := msgID{v: relay.View, n: relay.SequenceNumber}
+	instance.relayStore[idx] = relay.Request
+	if cert, ok := instance.certStore[idx]; ok && cert.prePrepare != nil {
+		return instance.recvPrePrepare(cert.prePrepare)
+	}
+	return nil
+}
+
+// startRelayTimer starts the relay timer, unless it is running already
+func (instance *pbftCore) startRelayTimer() {
+	if instance.relayTimerActive {
+		return
+	}
+	instance.relayTimerActive = true
+	instance.relayTimer.Reset(instance.relayTimeout, relayTimerEvent{})
+}
+
+// relayTimerExpired asks all replicas for the requests of the pre-prepares
+// still waiting for our parent in the relay tree to pass them on, so that a
+// faulty parent withholding them does not force a view change
+func (instance *pbftCore) relayTimerExpired() {
+	instance.relayTimerActive = false
+	if !instance.activeView {
+		return
+	}
+	for idx, cert := range instance.certStore {
+		if idx.v != instance.view || cert.prePrepare == nil || cert.prePrepare.Request != nil || cert.relayFetch {
+			continue
+		}
+		if _, ok := instance.reqStore[cert.digest]; ok {
+			continue
+		}
+		if _, ok := instance.relayStore[idx]; ok {
+			continue
+		}
+		logger.Warningf("Replica %d did not get request %s for view=%d/seqNo=%d from replica %d in time, asking everyone else",
+			instance.id, cert.digest, idx.v, idx.n, instance.relayParent(instance.id, idx.v))
+		cert.relayFetch = true
+		instance.fetchRequest(cert.digest)
+	}
+}
+
+// recvFetchedRelayRequest hands a request we fetched, as our parent in the
+// relay tree failed to pass it on, to the pre-prepare waiting for it
+func (instance *pbftCore) recvFetchedRelayRequest(req *Request, digest string) events.Event {
+	if instance.relayFanout == 0 || !instance.activeView {
+		return nil // either the wrong digest, or we got it already from someone else
+	}
+	for idx, cert := range instance.certStore {
+		if idx.v == instance.view && cert.digest == digest && cert.prePrepare != nil && cert.prePrepare.Request == nil {
+			instance.relayStore[idx] = req
+			if err := instance.recvPrePrepare(cert.prePrepare); err != nil {
+				logger.Warning(err.Error())
+			}
+			return nil
+		}
+	}
+	return nil // either the wrong digest, or we got it already from someone else
+}
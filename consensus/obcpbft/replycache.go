@@ -0,0 +1,94 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package obcpbft
+
+import (
+	"time"
+)
+
+// replyCache remembers, for each submitting replica, the requests it executed
+// and the sequence number each executed at, its reply. A request which is
+// retransmitted after it executed is answered from the cache, rather than
+// ordered and executed a second time.
+//
+// As the requests of a replica may be ordered out of their timestamp order, the
+// replies are kept until their sequence number falls below the low watermark.
+// Past that, only the highest timestamp of the pruned replies is kept, and any
+// request not newer than it is stale.
+type replyCache struct {
+	clients map[uint64]*clientReplies
+}
+
+type clientReplies struct {
+	executed time.Time         // Highest timestamp of the pruned replies
+	replies  map[string]uint64 // Sequence number of the executed requests, by request hash
+	times    map[string]time.Time
+}
+
+func newReplyCache() *replyCache {
+	return &replyCache{clients: make(map[uint64]*clientReplies)}
+}
+
+// lookup returns the sequence number req executed at, if it did. A request
+// which is stale, as older than the pruned replies of its replica, has executed
+// at an unknown sequence number, reported as 0.
+func (rc *replyCache) lookup(req *Request) (seqNo uint64, executed bool) {
+	client, ok := rc.clients[req.ReplicaId]
+	if !ok || req.Timestamp == nil {
+		return 0, false
+	}
+	if seqNo, ok := client.replies[hashReq(req)]; ok {
+		return seqNo, true
+	}
+	reqTime := time.Unix(req.Timestamp.Seconds, int64(req.Timestamp.Nanos))
+	return 0, !reqTime.After(client.executed)
+}
+
+// executed records that req executed at seqNo
+func (rc *replyCache) executed(req *Request, seqNo uint64) {
+	if req.Timestamp == nil {
+		return
+	}
+	client, ok := rc.clients[req.ReplicaId]
+	if !ok {
+		client = &clientReplies{
+			replies: make(map[string]uint64),
+			times:   make(map[string]time.Time),
+		}
+		rc.clients[req.ReplicaId] = client
+	}
+	hash := hashReq(req)
+	client.replies[hash] = seqNo
+	client.times[hash] = time.Unix(req.Timestamp.Seconds, int64(req.Timestamp.Nanos))
+}
+
+// prune drops the replies of the requests which executed at or below h,
+// raising the timestamp below which requests are stale
+func (rc *replyCache) prune(h uint64) {
+	for _, client := range rc.clients {
+		for hash, seqNo := range client.replies {
+			if seqNo > h {
+				continue
+			}
+			if t := client.times[hash]; t.After(client.executed) {
+				client.executed = t
+			}
+			delete(client.replies, hash)
+			delete(client.times, hash)
+		}
+	}
+}
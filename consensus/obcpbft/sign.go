@@ -16,7 +16,11 @@ limitations under the License.
 
 package obcpbft
 
-import pb "github.com/golang/protobuf/proto"
+import (
+	"sort"
+
+	pb "github.com/golang/protobuf/proto"
+)
 
 type signable interface {
 	getSignature() []byte
@@ -42,14 +46,20 @@ func (instance *pbftCore) sign(s signable) error {
 }
 
 func (instance *pbftCore) verify(s signable) error {
+	raw, err := signedBytes(s)
+	if err != nil {
+		return err
+	}
+	return instance.consumer.verify(s.getID(), s.getSignature(), raw)
+}
+
+// signedBytes returns the bytes the signature of s is computed over
+func signedBytes(s signable) ([]byte, error) {
 	origSig := s.getSignature()
 	s.setSignature(nil)
 	raw, err := s.serialize()
 	s.setSignature(origSig)
-	if err != nil {
-		return err
-	}
-	return instance.consumer.verify(s.getID(), origSig, raw)
+	return raw, err
 }
 
 func (vc *ViewChange) getSignature() []byte {
@@ -69,7 +79,92 @@ func (vc *ViewChange) setID(id uint64) {
 }
 
 func (vc *ViewChange) serialize() ([]byte, error) {
-	return pb.Marshal(vc)
+	// The piggybacked votes are signed by their own senders
+	votes := vc.Votes
+	vc.Votes = nil
+	raw, err := pb.Marshal(vc)
+	vc.Votes = votes
+	return raw, err
+}
+
+// Only the requests reconfiguring the network are signed
+
+func (req *Request) getSignature() []byte {
+	return req.Signature
+}
+
+func (req *Request) setSignature(sig []byte) {
+	req.Signature = sig
+}
+
+func (req *Request) getID() uint64 {
+	return req.ReplicaId
+}
+
+func (req *Request) setID(id uint64) {
+	req.ReplicaId = id
+}
+
+func (req *Request) serialize() ([]byte, error) {
+	return pb.Marshal(req)
+}
+
+func (chkpt *Checkpoint) getSignature() []byte {
+	return chkpt.Signature
+}
+
+func (chkpt *Checkpoint) setSignature(sig []byte) {
+	chkpt.Signature = sig
+}
+
+func (chkpt *Checkpoint) getID() uint64 {
+	return chkpt.ReplicaId
+}
+
+func (chkpt *Checkpoint) setID(id uint64) {
+	chkpt.ReplicaId = id
+}
+
+func (chkpt *Checkpoint) serialize() ([]byte, error) {
+	return pb.Marshal(chkpt)
+}
+
+func (nv *NewView) getSignature() []byte {
+	return nv.Signature
+}
+
+func (nv *NewView) setSignature(sig []byte) {
+	nv.Signature = sig
+}
+
+func (nv *NewView) getID() uint64 {
+	return nv.ReplicaId
+}
+
+func (nv *NewView) setID(id uint64) {
+	nv.ReplicaId = id
+}
+
+func (nv *NewView) serialize() ([]byte, error) {
+	// Maps are marshaled in random order, the X set is appended sorted
+	xset := nv.Xset
+	nv.Xset = nil
+	raw, err := pb.Marshal(nv)
+	nv.Xset = xset
+	if err != nil {
+		return nil, err
+	}
+	var seqNos []uint64
+	for n := range xset {
+		seqNos = append(seqNos, n)
+	}
+	sort.Sort(sortableUint64Slice(seqNos))
+	for _, n := range seqNos {
+		raw = append(raw, pb.EncodeVarint(n)...)
+		raw = append(raw, pb.EncodeVarint(uint64(len(xset[n])))...)
+		raw = append(raw, xset[n]...)
+	}
+	return raw, nil
 }
 
 func (v *Verify) getSignature() []byte {
@@ -0,0 +1,197 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package obcpbft
+
+import (
+	"github.com/golang/protobuf/proto"
+)
+
+// With general.speculative set, a replica executes the next request as soon
+// as it is prepared, rather than once it is committed, so that the execution
+// overlaps the commit phase. The execution is tentative: the stack holds it
+// uncommitted until the request gathers its commit certificate, and only
+// then commits it to the ledger. A view change may reorder a request which
+// prepared but did not commit; if the new view assigns its sequence number
+// another request, the execution is rolled back and the new request is
+// executed in its place. A replica which must transfer its state first rolls
+// back its tentative execution. At most one request executes tentatively at
+// a time, as the stack holds a single uncommitted batch.
+
+// speculativeStack is implemented by consumers which can execute a request
+// ahead of its commit certificate
+type speculativeStack interface {
+	executeTentatively(seqNo uint64, txRaw []byte) bool // Like execute, false if the request must wait for its commit certificate
+	commitTentative()                                   // Called once the request executing tentatively committed, the consumer then completes with an execDoneEvent
+	rollbackTentative()                                 // Called if the request executing tentatively is reordered, the consumer then completes with a rolledBackEvent
+}
+
+// tentativeExec is the request executing ahead of its commit certificate
+type tentativeExec struct {
+	n          uint64
+	digest     string
+	committing bool // it committed, the stack is committing it
+}
+
+// maybeExecuteTentatively executes the prepared request of idx ahead of its
+// commit certificate, if speculation is enabled
+func (instance *pbftCore) maybeExecuteTentatively(idx msgID, digest string) bool {
+	if !instance.speculative || digest == "" || !instance.activeView || idx.v != instance.view || !instance.prepared(digest, idx.v, idx.n) {
+		return false
+	}
+	req := instance.reqStore[digest]
+	if req == nil || !instance.consumer.(speculativeStack).executeTentatively(idx.n, req.Payload) {
+		return false
+	}
+
+	logger.Infof("Replica %d executing tentatively request for view=%d/seqNo=%d and digest %s",
+		instance.id, idx.v, idx.n, digest)
+	currentExec := idx.n
+	instance.currentExec = &currentExec
+	instance.tentative = &tentativeExec{n: idx.n, digest: digest}
+	tentativeExecutions.Inc()
+	return true
+}
+
+// commitTentativeIfCommitted commits the request executing tentatively once
+// it gathered its commit certificate, in any view
+func (instance *pbftCore) commitTentativeIfCommitted() {
+	t := instance.tentative
+	if t == nil || t.committing {
+		return
+	}
+	for idx, cert := range instance.certStore {
+		if idx.n == t.n && cert.digest == t.digest && instance.committed(t.digest, idx.v, idx.n) {
+			logger.Infof("Replica %d committing request for view=%d/seqNo=%d executed tentatively",
+				instance.id, idx.v, idx.n)
+			t.committing = true
+			batchesOrdered.Inc()
+			instance.consumer.(speculativeStack).commitTentative()
+			return
+		}
+	}
+}
+
+// rollbackIfReordered rolls back the request executing tentatively, if the
+// new view assigned its sequence number another request
+func (instance *pbftCore) rollbackIfReordered() {
+	t := instance.tentative
+	if t == nil || t.committing {
+		return
+	}
+	if cert, ok := instance.certStore[msgID{instance.view, t.n}]; ok && cert.prePrepare != nil && cert.digest == t.digest {
+		return
+	}
+	logger.Infof("Replica %d rolling back request for seqNo=%d, reordered by view %d", instance.id, t.n, instance.view)
+	instance.rollbackTentative()
+}
+
+// rollbackTentative rolls back the request executing tentatively
+func (instance *pbftCore) rollbackTentative() {
+	t := instance.tentative
+	if t == nil || t.committing {
+		return
+	}
+	instance.tentative = nil
+	tentativeRollbacks.Inc()
+	instance.consumer.(speculativeStack).rollbackTentative()
+}
+
+// tentativeRolledBack is called once the stack rolled back the request which
+// executed tentatively
+func (instance *pbftCore) tentativeRolledBack() {
+	if instance.currentExec != nil {
+		logger.Infof("Replica %d rolled back seqNo %d, trying next", instance.id, *instance.currentExec)
+	}
+	instance.currentExec = nil
+	if instance.skipInProgress {
+		instance.retryStateTransfer(nil)
+		return
+	}
+	instance.executeOutstanding()
+}
+
+// awaitingCommit returns whether the request executing, if any, executes
+// tentatively and may still be rolled back
+func (instance *pbftCore) awaitingCommit() bool {
+	return instance.tentative != nil && !instance.tentative.committing
+}
+
+// tentativeBatch is the batch executing ahead of its commit certificate
+type tentativeBatch struct {
+	seqNo       uint64
+	raw         []byte
+	meta        []byte // metadata of its block, once executed
+	executed    bool
+	committed   bool // it committed, and is committed to the ledger once executed
+	rollingBack bool
+}
+
+func (op *obcBatch) executeTentatively(seqNo uint64, raw []byte) bool {
+	// The random value of a batch and reconfigurations take effect as they
+	// execute, and cannot be rolled back
+	if op.beacon != nil {
+		return false
+	}
+	reqs := &RequestBlock{}
+	if err := proto.Unmarshal(raw, reqs); err != nil {
+		return false
+	}
+	for _, req := range reqs.Requests {
+		if req.Reconfiguration != nil || (req.PayloadHash != nil && op.payloads.get(req.PayloadHash) == nil) {
+			return false
+		}
+	}
+	op.tentative = &tentativeBatch{seqNo: seqNo, raw: raw}
+	op.execute(seqNo, raw)
+	return true
+}
+
+// tentativeExecuted holds the batch executed tentatively until it commits
+func (op *obcBatch) tentativeExecuted(meta []byte) {
+	t := op.tentative
+	t.meta = meta
+	t.executed = true
+	if t.committed && !t.rollingBack {
+		op.commitTentativeBatch()
+	}
+}
+
+func (op *obcBatch) commitTentative() {
+	op.tentative.committed = true
+	if op.tentative.executed {
+		op.commitTentativeBatch()
+	}
+}
+
+// commitTentativeBatch commits the batch executed tentatively, and forgets
+// its requests as outstanding
+func (op *obcBatch) commitTentativeBatch() {
+	t := op.tentative
+	op.tentative = nil
+	reqs := &RequestBlock{}
+	proto.Unmarshal(t.raw, reqs)
+	for _, req := range reqs.Requests {
+		op.requestExecuted(req, t.seqNo)
+	}
+	op.updateBackpressure()
+	op.stack.Commit(nil, t.meta)
+}
+
+func (op *obcBatch) rollbackTentative() {
+	op.tentative.rollingBack = true
+	op.stack.Rollback(nil)
+}
@@ -0,0 +1,152 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package obcpbft
+
+import (
+	"sort"
+	"sync/atomic"
+)
+
+// Status is a snapshot of the state of a replica, reported for diagnostics
+type Status struct {
+	ReplicaID           uint64              `json:"replicaId"`
+	N                   int                 `json:"n"`
+	F                   int                 `json:"f"`
+	Epoch               uint64              `json:"epoch"`
+	View                uint64              `json:"view"`
+	Primary             uint64              `json:"primary"`
+	ActiveView          bool                `json:"activeView"`
+	LowWatermark        uint64              `json:"lowWatermark"`
+	HighWatermark       uint64              `json:"highWatermark"`
+	SeqNo               uint64              `json:"seqNo"`
+	Window              uint64              `json:"window"`
+	LastExec            uint64              `json:"lastExec"`
+	CurrentExec         *uint64             `json:"currentExec,omitempty"`
+	SkipInProgress      bool                `json:"skipInProgress"`
+	StateTransferring   bool                `json:"stateTransferring"`
+	Quarantined         bool                `json:"quarantined"`
+	OutstandingRequests int                 `json:"outstandingRequests"`
+	PendingRequests     int                 `json:"pendingRequests"`
+	Backpressured       bool                `json:"backpressured"`
+	Checkpoints         []uint64            `json:"checkpoints"`
+	ViewChanges         map[uint64]int      `json:"viewChanges"`
+	Certificates        []CertificateStatus `json:"certificates"`
+}
+
+// CertificateStatus summarizes the messages received for a view and sequence number
+type CertificateStatus struct {
+	View        uint64 `json:"view"`
+	SeqNo       uint64 `json:"seqNo"`
+	Digest      string `json:"digest"`
+	PrePrepared bool   `json:"prePrepared"`
+	Prepares    int    `json:"prepares"`
+	Commits     int    `json:"commits"`
+	Prepared    bool   `json:"prepared"`
+	Committed   bool   `json:"committed"`
+}
+
+type certificateStatuses []CertificateStatus
+
+func (a certificateStatuses) Len() int {
+	return len(a)
+}
+func (a certificateStatuses) Swap(i, j int) {
+	a[i], a[j] = a[j], a[i]
+}
+func (a certificateStatuses) Less(i, j int) bool {
+	if a[i].SeqNo == a[j].SeqNo {
+		return a[i].View < a[j].View
+	}
+	return a[i].SeqNo < a[j].SeqNo
+}
+
+// getStatus must only be invoked from the main pbft thread
+func (instance *pbftCore) getStatus() *Status {
+	status := &Status{
+		ReplicaID:           instance.id,
+		N:                   instance.N,
+		F:                   instance.f,
+		Epoch:               instance.epoch,
+		View:                instance.view,
+		Primary:             instance.primary(instance.view),
+		ActiveView:          instance.activeView,
+		LowWatermark:        instance.h,
+		HighWatermark:       instance.h + instance.L,
+		SeqNo:               instance.seqNo,
+		Window:              instance.windowSize(),
+		LastExec:            instance.lastExec,
+		SkipInProgress:      instance.skipInProgress,
+		StateTransferring:   instance.stateTransferring,
+		Quarantined:         instance.quarantined,
+		OutstandingRequests: len(instance.outstandingReqs),
+		Checkpoints:         []uint64{},
+		ViewChanges:         make(map[uint64]int),
+		Certificates:        []CertificateStatus{},
+	}
+
+	if instance.currentExec != nil {
+		currentExec := *instance.currentExec
+		status.CurrentExec = &currentExec
+	}
+
+	for n := range instance.chkpts {
+		status.Checkpoints = append(status.Checkpoints, n)
+	}
+	sort.Sort(sortableUint64Slice(status.Checkpoints))
+
+	for idx := range instance.viewChangeStore {
+		status.ViewChanges[idx.v]++
+	}
+
+	for idx, cert := range instance.certStore {
+		status.Certificates = append(status.Certificates, CertificateStatus{
+			View:        idx.v,
+			SeqNo:       idx.n,
+			Digest:      cert.digest,
+			PrePrepared: cert.prePrepare != nil,
+			Prepares:    len(cert.prepare),
+			Commits:     len(cert.commit),
+			Prepared:    instance.prepared(cert.digest, idx.v, idx.n),
+			Committed:   instance.committed(cert.digest, idx.v, idx.n),
+		})
+	}
+	sort.Sort(certificateStatuses(status.Certificates))
+
+	return status
+}
+
+// GetStatus returns a snapshot of the state of the replica
+func (op *obcBatch) GetStatus() interface{} {
+	statusChan := make(chan *Status)
+	op.manager.Queue() <- workEvent(func() {
+		status := op.pbft.getStatus()
+		status.OutstandingRequests = op.reqStore.outstandingRequests.Len()
+		status.PendingRequests = op.reqStore.pendingRequests.Len()
+		status.Backpressured = atomic.LoadInt32(&op.backpressured) != 0
+		statusChan <- status
+	})
+	return <-statusChan
+}
+
+// GetStatus returns a snapshot of the state of the replica
+func (op *obcSieve) GetStatus() interface{} {
+	statusChan := make(chan *Status)
+	op.pbft.inject(func() {
+		statusChan <- op.pbft.getStatus()
+	})
+	return <-statusChan
+}
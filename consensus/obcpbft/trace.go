@@ -0,0 +1,238 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package obcpbft
+
+import (
+	"sync"
+
+	"github.com/golang/protobuf/jsonpb"
+	"github.com/golang/protobuf/proto"
+
+	"github.com/hyperledger/fabric/core/util"
+	pb "github.com/hyperledger/fabric/protos"
+)
+
+// Operators may trace the consensus messages of a replica while it runs. The
+// messages the replica sends and receives are only decoded while someone
+// traces them, and a slow tracer misses events rather than holding up the
+// replica.
+
+// traceBuffer is the number of events queued for a tracer before further
+// events are dropped
+const traceBuffer = 1000
+
+// traceBroadcast is the replica of the events of broadcast messages
+const traceBroadcast = -1
+
+type traceSubscription struct {
+	filter  *pb.ConsensusTraceRequest
+	types   map[string]bool
+	events  chan *pb.ConsensusTraceEvent
+	dropped uint64
+}
+
+func (s *traceSubscription) matches(ev *pb.ConsensusTraceEvent) bool {
+	if len(s.types) > 0 && !s.types[ev.Type] {
+		return false
+	}
+	if s.filter.FilterView && (!ev.HasView || ev.View != s.filter.View) {
+		return false
+	}
+	if s.filter.FilterSeqNo && (!ev.HasSeqNo || ev.SeqNo != s.filter.SeqNo) {
+		return false
+	}
+	return true
+}
+
+// tracer hands the decoded consensus messages over to the subscribed tracers
+type tracer struct {
+	lock          sync.Mutex
+	subscriptions map[*traceSubscription]struct{}
+}
+
+func newTracer() *tracer {
+	return &tracer{subscriptions: make(map[*traceSubscription]struct{})}
+}
+
+func (t *tracer) subscribe(filter *pb.ConsensusTraceRequest) (<-chan *pb.ConsensusTraceEvent, func()) {
+	s := &traceSubscription{
+		filter: filter,
+		types:  make(map[string]bool),
+		events: make(chan *pb.ConsensusTraceEvent, traceBuffer),
+	}
+	for _, typ := range filter.Types {
+		s.types[typ] = true
+	}
+	t.lock.Lock()
+	t.subscriptions[s] = struct{}{}
+	t.lock.Unlock()
+
+	var once sync.Once
+	return s.events, func() {
+		once.Do(func() {
+			t.lock.Lock()
+			delete(t.subscriptions, s)
+			t.lock.Unlock()
+			close(s.events)
+		})
+	}
+}
+
+func (t *tracer) active() bool {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	return len(t.subscriptions) > 0
+}
+
+// trace decodes msg, sent to or received from replica, and passes it on to
+// the tracers interested in it
+func (t *tracer) trace(sent bool, replica int64, msg *BatchMessage) {
+	if !t.active() {
+		return
+	}
+	ev := decodeTraceEvent(msg)
+	if ev == nil {
+		return
+	}
+	ev.Timestamp = util.CreateUtcTimestamp()
+	ev.Sent = sent
+	ev.Replica = replica
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	for s := range t.subscriptions {
+		if !s.matches(ev) {
+			continue
+		}
+		sev := *ev
+		sev.Dropped = s.dropped
+		select {
+		case s.events <- &sev:
+			s.dropped = 0
+		default:
+			s.dropped++
+		}
+	}
+}
+
+// decodeTraceEvent returns the type, view, sequence number and JSON encoding
+// of msg, or nil if msg cannot be decoded
+func decodeTraceEvent(msg *BatchMessage) *pb.ConsensusTraceEvent {
+	ev := &pb.ConsensusTraceEvent{}
+	var inner proto.Message
+	switch p := msg.Payload.(type) {
+	case *BatchMessage_Request:
+		ev.Type, inner = "request", p.Request
+	case *BatchMessage_Complaint:
+		ev.Type, inner = "complaint", p.Complaint
+	case *BatchMessage_FetchPayload:
+		ev.Type, inner = "fetch_payload", &PayloadData{Hash: p.FetchPayload}
+	case *BatchMessage_PayloadData:
+		ev.Type, inner = "payload_data", p.PayloadData
+	case *BatchMessage_PbftMessage:
+		pbftMsg := &Message{}
+		if err := proto.Unmarshal(p.PbftMessage, pbftMsg); err != nil {
+			return nil
+		}
+		inner = decodePbftTraceEvent(ev, pbftMsg)
+	}
+	if inner == nil {
+		return nil
+	}
+	js, err := (&jsonpb.Marshaler{}).MarshalToString(inner)
+	if err != nil {
+		logger.Warningf("Error encoding %s message for tracing: %s", ev.Type, err)
+		return nil
+	}
+	ev.Message = js
+	return ev
+}
+
+func decodePbftTraceEvent(ev *pb.ConsensusTraceEvent, msg *Message) proto.Message {
+	setView := func(view uint64) {
+		ev.HasView, ev.View = true, view
+	}
+	setSeqNo := func(seqNo uint64) {
+		ev.HasSeqNo, ev.SeqNo = true, seqNo
+	}
+	switch p := msg.Payload.(type) {
+	case *Message_Request:
+		ev.Type = "request"
+		return p.Request
+	case *Message_PrePrepare:
+		ev.Type = "pre_prepare"
+		setView(p.PrePrepare.View)
+		setSeqNo(p.PrePrepare.SequenceNumber)
+		return p.PrePrepare
+	case *Message_Prepare:
+		ev.Type = "prepare"
+		setView(p.Prepare.View)
+		setSeqNo(p.Prepare.SequenceNumber)
+		return p.Prepare
+	case *Message_Commit:
+		ev.Type = "commit"
+		setView(p.Commit.View)
+		setSeqNo(p.Commit.SequenceNumber)
+		return p.Commit
+	case *Message_Checkpoint:
+		ev.Type = "checkpoint"
+		setSeqNo(p.Checkpoint.SequenceNumber)
+		return p.Checkpoint
+	case *Message_ViewChange:
+		ev.Type = "view_change"
+		setView(p.ViewChange.View)
+		return p.ViewChange
+	case *Message_NewView:
+		ev.Type = "new_view"
+		setView(p.NewView.View)
+		return p.NewView
+	case *Message_FetchRequest:
+		ev.Type = "fetch_request"
+		return p.FetchRequest
+	case *Message_ReturnRequest:
+		ev.Type = "return_request"
+		return p.ReturnRequest
+	case *Message_RelayRequest:
+		ev.Type = "relay_request"
+		setView(p.RelayRequest.View)
+		setSeqNo(p.RelayRequest.SequenceNumber)
+		return p.RelayRequest
+	case *Message_FetchCertificate:
+		ev.Type = "fetch_certificate"
+		return p.FetchCertificate
+	case *Message_CommittedCertificate:
+		ev.Type = "committed_certificate"
+		if preprep := p.CommittedCertificate.PrePrepare; preprep != nil {
+			setView(preprep.View)
+			setSeqNo(preprep.SequenceNumber)
+		}
+		return p.CommittedCertificate
+	case *Message_RecoveryRequest:
+		ev.Type = "recovery_request"
+		return p.RecoveryRequest
+	case *Message_RecoveryReply:
+		ev.Type = "recovery_reply"
+		return p.RecoveryReply
+	}
+	return nil
+}
+
+// TraceMessages streams the consensus messages the replica sends and
+// receives which match filter
+func (op *obcBatch) TraceMessages(filter *pb.ConsensusTraceRequest) (<-chan *pb.ConsensusTraceEvent, func()) {
+	return op.tracer.subscribe(filter)
+}
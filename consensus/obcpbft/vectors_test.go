@@ -0,0 +1,456 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package obcpbft
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"reflect"
+	"testing"
+
+	"github.com/golang/protobuf/jsonpb"
+	"github.com/golang/protobuf/proto"
+	google_protobuf "google/protobuf"
+
+	"github.com/hyperledger/fabric/consensus/obcpbft/events"
+	"github.com/hyperledger/fabric/core/util"
+)
+
+// The test vectors in testdata/vectors.json let implementations of the
+// protocol in other languages check they encode messages, compute digests and
+// react to messages the way this implementation does. TestVectors checks this
+// implementation against the vectors; after a deliberate change of the
+// protocol, regenerate them with
+//
+//	go test -run TestVectors -generateVectors
+var generateVectors = flag.Bool("generateVectors", false, "Regenerate the consensus test vectors in testdata")
+
+const vectorsFile = "testdata/vectors.json"
+
+const vectorsDescription = "Consensus test vectors generated from the Go implementation. " +
+	"Messages are given as the hex of their protobuf encoding and as their JSON mapping. " +
+	"Digests are the base64 of the SHA3 hash of the protobuf encoding of the request. " +
+	"Transitions feed messages to a single replica with a fresh state and list what it sends and executes in response to each of them; " +
+	"its application state after executing n requests is the ASCII string 'state n', executions complete only on an 'exec_done' step, " +
+	"and it signs every message with the ASCII string 'signature', the only signature it accepts."
+
+type testVectors struct {
+	Description string             `json:"description"`
+	Messages    []messageVector    `json:"messages"`
+	Digests     []digestVector     `json:"digests"`
+	Transitions []transitionVector `json:"transitions"`
+}
+
+type messageVector struct {
+	Name     string          `json:"name"`
+	Type     string          `json:"type"` // "message", "batch_message" or "request_block"
+	Encoding string          `json:"encoding"`
+	JSON     json.RawMessage `json:"json"`
+}
+
+type digestVector struct {
+	Name    string `json:"name"`
+	Request string `json:"request"`
+	Digest  string `json:"digest"`
+}
+
+type transitionVector struct {
+	Name          string           `json:"name"`
+	Replica       uint64           `json:"replica"`
+	N             int              `json:"n"`
+	F             int              `json:"f"`
+	K             int              `json:"k"`
+	LogMultiplier int              `json:"logmultiplier"`
+	Steps         []transitionStep `json:"steps"`
+}
+
+type transitionStep struct {
+	// Either a message from sender, or the completion of an execution
+	Sender   uint64 `json:"sender,omitempty"`
+	Message  string `json:"message,omitempty"`
+	ExecDone bool   `json:"exec_done,omitempty"`
+
+	Broadcasts []string          `json:"broadcasts"`
+	Unicasts   []unicastVector   `json:"unicasts"`
+	Executions []executionVector `json:"executions"`
+}
+
+type unicastVector struct {
+	Receiver uint64 `json:"receiver"`
+	Message  string `json:"message"`
+}
+
+type executionVector struct {
+	SequenceNumber uint64 `json:"sequence_number"`
+	Payload        string `json:"payload"`
+}
+
+func mustMarshalHex(msg proto.Message) string {
+	raw, err := proto.Marshal(msg)
+	if err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(raw)
+}
+
+func vectorRequests() (*Request, *Request) {
+	req := &Request{
+		Timestamp: &google_protobuf.Timestamp{Seconds: 1467331200},
+		Payload:   []byte("vector payload"),
+		ReplicaId: 0,
+	}
+	hashed := &Request{
+		Timestamp:   &google_protobuf.Timestamp{Seconds: 1467331201, Nanos: 500},
+		ReplicaId:   2,
+		PayloadHash: util.ComputeCryptoHash([]byte("large vector payload")),
+	}
+	return req, hashed
+}
+
+func newMessageVector(name string, msg proto.Message) messageVector {
+	var kind string
+	switch msg.(type) {
+	case *Message:
+		kind = "message"
+	case *BatchMessage:
+		kind = "batch_message"
+	case *RequestBlock:
+		kind = "request_block"
+	default:
+		panic(fmt.Sprintf("no vector type for %T", msg))
+	}
+	js, err := (&jsonpb.Marshaler{}).MarshalToString(msg)
+	if err != nil {
+		panic(err)
+	}
+	return messageVector{Name: name, Type: kind, Encoding: mustMarshalHex(msg), JSON: json.RawMessage(js)}
+}
+
+func generateMessageVectors() []messageVector {
+	req, hashed := vectorRequests()
+	digest := hashReq(req)
+	prepare := &Message{&Message_Prepare{&Prepare{View: 0, SequenceNumber: 1, RequestDigest: digest, ReplicaId: 2}}}
+	viewChange := &ViewChange{
+		View:      1,
+		H:         2,
+		Cset:      []*ViewChange_C{{SequenceNumber: 2, Id: "c3RhdGUgMg=="}},
+		Pset:      []*ViewChange_PQ{{SequenceNumber: 3, Digest: digest, View: 0}},
+		Qset:      []*ViewChange_PQ{{SequenceNumber: 3, Digest: digest, View: 0}},
+		ReplicaId: 1,
+		Signature: []byte("signature"),
+	}
+
+	return []messageVector{
+		newMessageVector("request", &Message{&Message_Request{req}}),
+		newMessageVector("pre_prepare", &Message{&Message_PrePrepare{&PrePrepare{View: 0, SequenceNumber: 1, RequestDigest: digest, Request: req, ReplicaId: 0}}}),
+		newMessageVector("pre_prepare_without_request", &Message{&Message_PrePrepare{&PrePrepare{View: 0, SequenceNumber: 1, RequestDigest: digest, ReplicaId: 0}}}),
+		newMessageVector("prepare", prepare),
+		newMessageVector("commit", &Message{&Message_Commit{&Commit{View: 0, SequenceNumber: 1, RequestDigest: digest, ReplicaId: 3, ExecSequenceNumber: 1, ExecId: "c3RhdGUgMQ=="}}}),
+		newMessageVector("checkpoint", &Message{&Message_Checkpoint{&Checkpoint{SequenceNumber: 2, ReplicaId: 1, Id: "c3RhdGUgMg==", Signature: []byte("signature")}}}),
+		newMessageVector("view_change", &Message{&Message_ViewChange{viewChange}}),
+		// A single entry, as the encoding of maps does not order their entries
+		newMessageVector("new_view", &Message{&Message_NewView{&NewView{View: 1, Vset: []*ViewChange{viewChange}, Xset: map[uint64]string{3: digest}, ReplicaId: 1, Signature: []byte("signature")}}}),
+		newMessageVector("fetch_request", &Message{&Message_FetchRequest{&FetchRequest{RequestDigest: digest, ReplicaId: 3}}}),
+		newMessageVector("return_request", &Message{&Message_ReturnRequest{req}}),
+		newMessageVector("relay_request", &Message{&Message_RelayRequest{&RelayRequest{View: 0, SequenceNumber: 1, Request: req, ReplicaId: 1}}}),
+		newMessageVector("batch_request", &BatchMessage{&BatchMessage_Request{req}}),
+		newMessageVector("batch_request_payload_hash", &BatchMessage{&BatchMessage_Request{hashed}}),
+		newMessageVector("batch_pbft_message", &BatchMessage{&BatchMessage_PbftMessage{mustDecodeHex(mustMarshalHex(prepare))}}),
+		newMessageVector("batch_complaint", &BatchMessage{&BatchMessage_Complaint{req}}),
+		newMessageVector("batch_fetch_payload", &BatchMessage{&BatchMessage_FetchPayload{hashed.PayloadHash}}),
+		newMessageVector("batch_payload_data", &BatchMessage{&BatchMessage_PayloadData{&PayloadData{Hash: hashed.PayloadHash, Data: []byte("large vector payload")}}}),
+		newMessageVector("request_block", &RequestBlock{Requests: []*Request{req, hashed}}),
+	}
+}
+
+func generateDigestVectors() []digestVector {
+	req, hashed := vectorRequests()
+	block, err := proto.Marshal(&RequestBlock{Requests: []*Request{req, hashed}})
+	if err != nil {
+		panic(err)
+	}
+	batch := &Request{Timestamp: &google_protobuf.Timestamp{Seconds: 1467331202}, Payload: block, ReplicaId: 0}
+
+	var vectors []digestVector
+	for _, v := range []struct {
+		name string
+		req  *Request
+	}{
+		{"request", req},
+		{"request_payload_hash", hashed},
+		{"request_batch", batch},
+		{"request_empty", &Request{}},
+	} {
+		vectors = append(vectors, digestVector{Name: v.name, Request: mustMarshalHex(v.req), Digest: hashReq(v.req)})
+	}
+	return vectors
+}
+
+func generateTransitionVectors() []transitionVector {
+	req, _ := vectorRequests()
+	other := &Request{Timestamp: &google_protobuf.Timestamp{Seconds: 1467331203}, Payload: []byte("second vector payload"), ReplicaId: 3}
+
+	msg := func(m proto.Message) string { return mustMarshalHex(m) }
+	prePrepare := func(n uint64, r *Request) transitionStep {
+		return transitionStep{Sender: 0, Message: msg(&Message{&Message_PrePrepare{&PrePrepare{View: 0, SequenceNumber: n, RequestDigest: hashReq(r), Request: r, ReplicaId: 0}}})}
+	}
+	prepare := func(n uint64, r *Request, from uint64) transitionStep {
+		return transitionStep{Sender: from, Message: msg(&Message{&Message_Prepare{&Prepare{View: 0, SequenceNumber: n, RequestDigest: hashReq(r), ReplicaId: from}}})}
+	}
+	commit := func(n uint64, r *Request, from uint64) transitionStep {
+		return transitionStep{Sender: from, Message: msg(&Message{&Message_Commit{&Commit{View: 0, SequenceNumber: n, RequestDigest: hashReq(r), ReplicaId: from}}})}
+	}
+	checkpoint := func(n uint64, from uint64) transitionStep {
+		return transitionStep{Sender: from, Message: msg(&Message{&Message_Checkpoint{&Checkpoint{SequenceNumber: n, ReplicaId: from, Id: "c3RhdGUgMg==", Signature: []byte("signature")}}})}
+	}
+	execDone := transitionStep{ExecDone: true}
+
+	mismatch := &Message{&Message_PrePrepare{&PrePrepare{View: 0, SequenceNumber: 2, RequestDigest: hashReq(other), Request: req, ReplicaId: 0}}}
+
+	return []transitionVector{
+		{
+			Name: "backup_normal_case_to_stable_checkpoint", Replica: 1, N: 4, F: 1, K: 2, LogMultiplier: 2,
+			Steps: []transitionStep{
+				prePrepare(1, req), prepare(1, req, 2), commit(1, req, 2), commit(1, req, 3), execDone,
+				prePrepare(2, other), prepare(2, other, 3), prepare(2, other, 2), commit(2, other, 0), commit(2, other, 3), execDone,
+				checkpoint(2, 0), checkpoint(2, 2),
+			},
+		},
+		{
+			Name: "backup_rejects_invalid_pre_prepares", Replica: 1, N: 4, F: 1, K: 2, LogMultiplier: 2,
+			Steps: []transitionStep{
+				{Sender: 2, Message: msg(&Message{&Message_PrePrepare{&PrePrepare{View: 0, SequenceNumber: 1, RequestDigest: hashReq(req), Request: req, ReplicaId: 2}}})},
+				{Sender: 0, Message: msg(&Message{&Message_PrePrepare{&PrePrepare{View: 0, SequenceNumber: 5, RequestDigest: hashReq(req), Request: req, ReplicaId: 0}}})},
+				prePrepare(1, req),
+				{Sender: 0, Message: msg(mismatch)},
+				// The primary assigning a sequence number twice is faulty
+				{Sender: 0, Message: msg(&Message{&Message_PrePrepare{&PrePrepare{View: 0, SequenceNumber: 1, RequestDigest: hashReq(other), Request: other, ReplicaId: 0}}})},
+			},
+		},
+	}
+}
+
+func mustDecodeHex(s string) []byte {
+	raw, err := hex.DecodeString(s)
+	if err != nil {
+		panic(err)
+	}
+	return raw
+}
+
+// replayTransition feeds the steps of tv to a fresh replica, and returns the
+// steps along with what the replica did in response to each of them
+func replayTransition(tv transitionVector) ([]transitionStep, error) {
+	var out *transitionStep
+	executed := 0
+	mock := &omniProto{
+		broadcastImpl: func(msgPayload []byte) {
+			out.Broadcasts = append(out.Broadcasts, hex.EncodeToString(msgPayload))
+		},
+		unicastImpl: func(msgPayload []byte, receiverID uint64) error {
+			out.Unicasts = append(out.Unicasts, unicastVector{Receiver: receiverID, Message: hex.EncodeToString(msgPayload)})
+			return nil
+		},
+		executeImpl: func(seqNo uint64, txRaw []byte) {
+			executed++
+			out.Executions = append(out.Executions, executionVector{SequenceNumber: seqNo, Payload: hex.EncodeToString(txRaw)})
+		},
+		getStateImpl: func() []byte {
+			return []byte(fmt.Sprintf("state %d", executed))
+		},
+		validateImpl: func(txRaw []byte) error {
+			return nil
+		},
+		signImpl: func(msg []byte) ([]byte, error) {
+			return []byte("signature"), nil
+		},
+		verifyImpl: func(senderID uint64, signature []byte, message []byte) error {
+			if string(signature) != "signature" {
+				return fmt.Errorf("invalid signature")
+			}
+			return nil
+		},
+		viewChangeImpl: func(curView uint64) {},
+		StoreStateImpl: func(key string, value []byte) error {
+			return nil
+		},
+	}
+
+	config := loadConfig()
+	config.Set("general.N", tv.N)
+	config.Set("general.f", tv.F)
+	config.Set("general.K", tv.K)
+	config.Set("general.logmultiplier", tv.LogMultiplier)
+	config.Set("general.dissemination.mode", "broadcast")
+	instance := newPbftCore(tv.Replica, config, mock, &inertTimerFactory{})
+	defer instance.close()
+
+	var steps []transitionStep
+	for i, step := range tv.Steps {
+		out = &transitionStep{Sender: step.Sender, Message: step.Message, ExecDone: step.ExecDone}
+		if step.ExecDone {
+			events.SendEvent(instance, execDoneEvent{})
+		} else {
+			raw, err := hex.DecodeString(step.Message)
+			if err != nil {
+				return nil, fmt.Errorf("step %d: %v", i, err)
+			}
+			msg := &Message{}
+			if err := proto.Unmarshal(raw, msg); err != nil {
+				return nil, fmt.Errorf("step %d: %v", i, err)
+			}
+			events.SendEvent(instance, pbftMessageEvent{msg: msg, sender: step.Sender})
+		}
+		steps = append(steps, *out)
+	}
+	return steps, nil
+}
+
+func generateTestVectors() (*testVectors, error) {
+	vectors := &testVectors{
+		Description: vectorsDescription,
+		Messages:    generateMessageVectors(),
+		Digests:     generateDigestVectors(),
+	}
+	for _, tv := range generateTransitionVectors() {
+		steps, err := replayTransition(tv)
+		if err != nil {
+			return nil, fmt.Errorf("transition %s: %v", tv.Name, err)
+		}
+		tv.Steps = steps
+		vectors.Transitions = append(vectors.Transitions, tv)
+	}
+	return vectors, nil
+}
+
+func checkMessageVector(mv messageVector) error {
+	var msg proto.Message
+	switch mv.Type {
+	case "message":
+		msg = &Message{}
+	case "batch_message":
+		msg = &BatchMessage{}
+	case "request_block":
+		msg = &RequestBlock{}
+	default:
+		return fmt.Errorf("unknown message type %s", mv.Type)
+	}
+	raw, err := hex.DecodeString(mv.Encoding)
+	if err != nil {
+		return err
+	}
+	if err = proto.Unmarshal(raw, msg); err != nil {
+		return fmt.Errorf("could not decode: %v", err)
+	}
+	reencoded, err := proto.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(raw, reencoded) {
+		return fmt.Errorf("encoded as %x", reencoded)
+	}
+
+	js, err := (&jsonpb.Marshaler{}).MarshalToString(msg)
+	if err != nil {
+		return err
+	}
+	var expected, actual interface{}
+	if err = json.Unmarshal(mv.JSON, &expected); err != nil {
+		return err
+	}
+	if err = json.Unmarshal([]byte(js), &actual); err != nil {
+		return err
+	}
+	if !reflect.DeepEqual(expected, actual) {
+		return fmt.Errorf("mapped to JSON as %s", js)
+	}
+	return nil
+}
+
+func checkDigestVector(dv digestVector) error {
+	raw, err := hex.DecodeString(dv.Request)
+	if err != nil {
+		return err
+	}
+	req := &Request{}
+	if err = proto.Unmarshal(raw, req); err != nil {
+		return fmt.Errorf("could not decode: %v", err)
+	}
+	if digest := hashReq(req); digest != dv.Digest {
+		return fmt.Errorf("digest is %s", digest)
+	}
+	return nil
+}
+
+func TestVectors(t *testing.T) {
+	if *generateVectors {
+		vectors, err := generateTestVectors()
+		if err != nil {
+			t.Fatalf("Failed to generate test vectors: %s", err)
+		}
+		out, err := json.MarshalIndent(vectors, "", "  ")
+		if err != nil {
+			t.Fatalf("Failed to marshal test vectors: %s", err)
+		}
+		if err = ioutil.WriteFile(vectorsFile, append(out, '\n'), 0644); err != nil {
+			t.Fatalf("Failed to write test vectors: %s", err)
+		}
+		t.Logf("Wrote test vectors to %s", vectorsFile)
+	}
+
+	raw, err := ioutil.ReadFile(vectorsFile)
+	if err != nil {
+		t.Fatalf("Failed to read test vectors: %s", err)
+	}
+	vectors := &testVectors{}
+	if err = json.Unmarshal(raw, vectors); err != nil {
+		t.Fatalf("Failed to parse test vectors: %s", err)
+	}
+
+	for _, mv := range vectors.Messages {
+		if err := checkMessageVector(mv); err != nil {
+			t.Errorf("Message vector %s: %s", mv.Name, err)
+		}
+	}
+
+	for _, dv := range vectors.Digests {
+		if err := checkDigestVector(dv); err != nil {
+			t.Errorf("Digest vector %s: %s", dv.Name, err)
+		}
+	}
+
+	for _, tv := range vectors.Transitions {
+		steps, err := replayTransition(tv)
+		if err != nil {
+			t.Errorf("Transition vector %s: %s", tv.Name, err)
+			continue
+		}
+		for i, step := range steps {
+			expected := tv.Steps[i]
+			if !reflect.DeepEqual(expected.Broadcasts, step.Broadcasts) ||
+				!reflect.DeepEqual(expected.Unicasts, step.Unicasts) ||
+				!reflect.DeepEqual(expected.Executions, step.Executions) {
+				t.Errorf("Transition vector %s, step %d: expected broadcasts %v, unicasts %v and executions %v, got %v, %v and %v",
+					tv.Name, i, expected.Broadcasts, expected.Unicasts, expected.Executions, step.Broadcasts, step.Unicasts, step.Executions)
+			}
+		}
+	}
+}
@@ -0,0 +1,262 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package obcpbft
+
+import (
+	"fmt"
+	"hash/fnv"
+	"sync"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/spf13/viper"
+
+	"github.com/hyperledger/fabric/consensus"
+	"github.com/hyperledger/fabric/consensus/obcpbft/events"
+	pb "github.com/hyperledger/fabric/protos"
+)
+
+// Checking the MACs and signatures of the consensus messages is most of the
+// work of a replica, and the main thread handles one message at a time. With
+// general.verification.workers set, a pool of workers checks the
+// authenticators of the consensus messages before they reach the main thread.
+// All the messages of a sender go to the same worker, so they reach the main
+// thread in the order they arrived. Each worker queues up to
+// general.verification.queuesize messages, past which receiving further
+// messages from its senders blocks.
+//
+// A worker drops the messages whose MAC does not verify, and checks the
+// signatures the others carry. The main thread then takes the outcome of
+// those checks rather than check them again. A message whose MAC cannot be
+// checked yet, for lack of a session key, reaches the main thread unchecked,
+// which handles it as without workers. The workers call the Verify method of
+// the stack concurrently.
+
+// verifiedSignaturesPerMessage is how many signature outcomes the verifier
+// keeps per queued message, a new-view carrying a quorum of view-changes
+const verifiedSignaturesPerMessage = 4
+
+// verifiedMessageEvent is sent when a worker checked the authenticators of a
+// consensus message
+type verifiedMessageEvent struct {
+	batchMessage
+	authenticated bool // the MAC of the pbft message it carries verified
+}
+
+// verifier is the pool of workers checking the authenticators of the
+// consensus messages
+type verifier struct {
+	id      uint64
+	stack   consensus.SecurityUtils
+	auth    *authenticator // nil unless pbft messages are authenticated
+	queues  []chan batchMessage
+	deliver chan<- events.Event
+	done    chan struct{}
+
+	lock    sync.Mutex
+	results map[string]error // outcome of the signatures checked, by signer, signature and signed bytes
+	order   []string         // keys of results, oldest first
+	max     int
+}
+
+func newVerifier(id uint64, config *viper.Viper, stack consensus.SecurityUtils, auth *authenticator, deliver chan<- events.Event) *verifier {
+	workers := config.GetInt("general.verification.workers")
+	if workers < 0 {
+		panic(fmt.Errorf("Verification workers must be greater than or equal to 0. Current value is %d.", workers))
+	}
+	if workers == 0 {
+		return nil
+	}
+	queueSize := config.GetInt("general.verification.queuesize")
+	if queueSize < 1 {
+		panic(fmt.Errorf("Verification queue size must be greater than or equal to 1. Current value is %d.", queueSize))
+	}
+	v := &verifier{
+		id:      id,
+		stack:   stack,
+		auth:    auth,
+		queues:  make([]chan batchMessage, workers),
+		deliver: deliver,
+		done:    make(chan struct{}),
+		results: make(map[string]error),
+		max:     workers * queueSize * verifiedSignaturesPerMessage,
+	}
+	for i := range v.queues {
+		v.queues[i] = make(chan batchMessage, queueSize)
+		go v.run(v.queues[i])
+	}
+	return v
+}
+
+// submit queues a consensus message on the worker of its sender, blocking
+// while that worker is full
+func (v *verifier) submit(msg batchMessage) {
+	h := fnv.New32a()
+	h.Write([]byte(msg.sender.Name))
+	select {
+	case v.queues[h.Sum32()%uint32(len(v.queues))] <- msg:
+	case <-v.done:
+	}
+}
+
+// halt stops the workers
+func (v *verifier) halt() {
+	close(v.done)
+}
+
+func (v *verifier) run(queue chan batchMessage) {
+	for {
+		select {
+		case msg := <-queue:
+			event := v.check(msg)
+			if event == nil {
+				continue
+			}
+			select {
+			case v.deliver <- event:
+			case <-v.done:
+				return
+			}
+		case <-v.done:
+			return
+		}
+	}
+}
+
+// check checks the MAC and the signatures of a consensus message, and returns
+// the event handing it to the main thread, nil if it is dropped
+func (v *verifier) check(msg batchMessage) events.Event {
+	batchMsg := &BatchMessage{}
+	if err := proto.Unmarshal(msg.msg.Payload, batchMsg); err != nil {
+		return batchMessageEvent(msg) // rejected by the main thread
+	}
+	senderID, err := getValidatorID(msg.sender)
+	if err != nil {
+		return batchMessageEvent(msg)
+	}
+
+	authenticated := false
+	pbftRaw := batchMsg.GetPbftMessage()
+	if auth := batchMsg.GetAuthenticated(); auth != nil {
+		pbftRaw = auth.PbftMessage
+		if v.auth != nil {
+			err := v.auth.check(senderID, auth)
+			if err == errNoSessionKey {
+				return batchMessageEvent(msg)
+			}
+			if err != nil {
+				logger.Warningf("Replica %d dropping message from replica %d: %s", v.id, senderID, err)
+				return nil
+			}
+			authenticated = true
+		}
+	}
+
+	var signed []signable
+	if sk := batchMsg.GetSessionKey(); sk != nil {
+		signed = append(signed, sk)
+	} else if share := batchMsg.GetBeaconShare(); share != nil {
+		signed = append(signed, share)
+	} else if req := batchMsg.GetRequest(); req != nil && len(req.Signature) > 0 {
+		signed = append(signed, req)
+	} else if pbftRaw != nil {
+		pbftMsg := &Message{}
+		if err := proto.Unmarshal(pbftRaw, pbftMsg); err == nil {
+			signed = signedMessages(pbftMsg)
+		}
+	}
+	for _, s := range signed {
+		v.checkSignature(s)
+	}
+
+	return verifiedMessageEvent{batchMessage: msg, authenticated: authenticated}
+}
+
+// signedMessages returns the signed messages a pbft message carries
+func signedMessages(msg *Message) []signable {
+	var signed []signable
+	if vc := msg.GetViewChange(); vc != nil {
+		signed = append(signed, vc)
+		for _, vote := range vc.Votes {
+			signed = append(signed, vote)
+		}
+	} else if nv := msg.GetNewView(); nv != nil {
+		signed = append(signed, nv)
+		for _, vc := range nv.Vset {
+			signed = append(signed, vc)
+		}
+	} else if chkpt := msg.GetCheckpoint(); chkpt != nil {
+		signed = append(signed, chkpt)
+	} else if req := msg.GetRequest(); req != nil && len(req.Signature) > 0 {
+		signed = append(signed, req)
+	}
+	return signed
+}
+
+func (v *verifier) checkSignature(s signable) {
+	raw, err := signedBytes(s)
+	if err != nil {
+		return
+	}
+	handle, err := getValidatorHandle(s.getID())
+	if err != nil {
+		return
+	}
+	err = v.stack.Verify(handle, s.getSignature(), raw)
+
+	key := signatureKey(s.getID(), s.getSignature(), raw)
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	if _, ok := v.results[key]; ok {
+		return
+	}
+	if len(v.order) >= v.max {
+		delete(v.results, v.order[0])
+		v.order = v.order[1:]
+	}
+	v.results[key] = err
+	v.order = append(v.order, key)
+}
+
+// lookup returns the outcome of the check of a signature, if a worker checked
+// it, and forgets it
+func (v *verifier) lookup(signer uint64, signature []byte, raw []byte) (bool, error) {
+	key := signatureKey(signer, signature, raw)
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	err, ok := v.results[key]
+	if ok {
+		delete(v.results, key)
+	}
+	return ok, err
+}
+
+// signatureKey identifies a signature, the length of the signature keeping
+// it apart from the signed bytes
+func signatureKey(signer uint64, signature []byte, raw []byte) string {
+	prefix := append(proto.EncodeVarint(signer), proto.EncodeVarint(uint64(len(signature)))...)
+	return string(prefix) + string(signature) + string(raw)
+}
+
+// submitOrQueue hands a consensus message to the verifier, other messages
+// straight to the main thread
+func (op *obcBatch) submitOrQueue(ocMsg *pb.Message, senderHandle *pb.PeerID) error {
+	if op.verifier == nil || ocMsg.Type != pb.Message_CONSENSUS {
+		return op.externalEventReceiver.RecvMsg(ocMsg, senderHandle)
+	}
+	op.verifier.submit(batchMessage{msg: ocMsg, sender: senderHandle})
+	return nil
+}
@@ -20,8 +20,10 @@ import (
 	"encoding/base64"
 	"fmt"
 	"reflect"
+	"sort"
 
 	"github.com/hyperledger/fabric/consensus/obcpbft/events"
+	pb "github.com/hyperledger/fabric/protos"
 )
 
 // viewChangeQuorumEvent is returned to the event loop when a new ViewChange message is received which is part of a quorum cert
@@ -128,6 +130,9 @@ func (instance *pbftCore) sendViewChange() events.Event {
 	delete(instance.newViewStore, instance.view)
 	instance.view++
 	instance.activeView = false
+	instance.persistView()
+	viewChanges.Inc()
+	viewGauge.Set(float64(instance.view))
 
 	instance.pset = instance.calcPSet()
 	instance.qset = instance.calcQSet()
@@ -135,6 +140,7 @@ func (instance *pbftCore) sendViewChange() events.Event {
 	// clear old messages
 	for idx := range instance.certStore {
 		if idx.v < instance.view {
+			instance.persistDelCert(idx)
 			delete(instance.certStore, idx)
 		}
 	}
@@ -143,6 +149,7 @@ func (instance *pbftCore) sendViewChange() events.Event {
 			delete(instance.viewChangeStore, idx)
 		}
 	}
+	instance.updateLogMetrics()
 
 	vc := &ViewChange{
 		View:      instance.view,
@@ -173,8 +180,12 @@ func (instance *pbftCore) sendViewChange() events.Event {
 
 	instance.sign(vc)
 
-	logger.Infof("Replica %d sending view-change, v:%d, h:%d, |C|:%d, |P|:%d, |Q|:%d",
-		instance.id, vc.View, vc.H, len(vc.Cset), len(vc.Pset), len(vc.Qset))
+	// Piggyback the latest view-change of every other replica, so that replicas
+	// which timed out at the same time learn about each other in a single round
+	vc.Votes = instance.getViewChangeVotes()
+
+	logger.Infof("Replica %d sending view-change, v:%d, h:%d, |C|:%d, |P|:%d, |Q|:%d, |votes|:%d",
+		instance.id, vc.View, vc.H, len(vc.Cset), len(vc.Pset), len(vc.Qset), len(vc.Votes))
 
 	instance.innerBroadcast(&Message{&Message_ViewChange{vc}})
 
@@ -182,55 +193,40 @@ func (instance *pbftCore) sendViewChange() events.Event {
 }
 
 func (instance *pbftCore) recvViewChange(vc *ViewChange) events.Event {
-	logger.Infof("Replica %d received view-change from replica %d, v:%d, h:%d, |C|:%d, |P|:%d, |Q|:%d",
-		instance.id, vc.ReplicaId, vc.View, vc.H, len(vc.Cset), len(vc.Pset), len(vc.Qset))
-
-	if err := instance.verify(vc); err != nil {
-		logger.Warningf("Replica %d found incorrect signature in view-change message: %s", instance.id, err)
-		return nil
-	}
-
-	if vc.View < instance.view {
-		logger.Warningf("Replica %d found view-change message for old view", instance.id)
-		return nil
-	}
+	logger.Infof("Replica %d received view-change from replica %d, v:%d, h:%d, |C|:%d, |P|:%d, |Q|:%d, |votes|:%d",
+		instance.id, vc.ReplicaId, vc.View, vc.H, len(vc.Cset), len(vc.Pset), len(vc.Qset), len(vc.Votes))
 
-	if !instance.correctViewChange(vc) {
-		logger.Warningf("Replica %d found view-change message incorrect", instance.id)
+	if err := instance.storeViewChange(vc); err != nil {
+		logger.Warningf("Replica %d rejecting view-change from replica %d: %s", instance.id, vc.ReplicaId, err)
 		return nil
 	}
 
-	if _, ok := instance.viewChangeStore[vcidx{vc.View, vc.ReplicaId}]; ok {
-		logger.Warningf("Replica %d already has a view change message for view %d from replica %d", instance.id, vc.View, vc.ReplicaId)
-		return nil
+	for _, vote := range vc.Votes {
+		if vote.ReplicaId == instance.id {
+			continue
+		}
+		if err := instance.storeViewChange(vote); err != nil {
+			logger.Debugf("Replica %d ignoring view-change vote of replica %d piggybacked by replica %d: %s",
+				instance.id, vote.ReplicaId, vc.ReplicaId, err)
+		}
 	}
 
-	instance.viewChangeStore[vcidx{vc.View, vc.ReplicaId}] = vc
-
 	// PBFT TOCS 4.5.1 Liveness: "if a replica receives a set of
 	// f+1 valid VIEW-CHANGE messages from other replicas for
 	// views greater than its current view, it sends a VIEW-CHANGE
 	// message for the smallest view in the set, even if its timer
 	// has not expired"
-	replicas := make(map[uint64]bool)
-	minView := uint64(0)
-	for idx := range instance.viewChangeStore {
-		if idx.v <= instance.view {
-			continue
-		}
-
-		replicas[idx.id] = true
-		if minView == 0 || idx.v < minView {
-			minView = idx.v
-		}
-	}
-
-	// We only enter this if there are enough view change messages _greater_ than our current view
-	if len(replicas) >= instance.f+1 {
+	//
+	// Rather than the smallest view in the set, we move to the highest
+	// view that f+1 replicas have moved to. At least one of them is
+	// correct, so the faulty replicas cannot drive the view up on their
+	// own, and replicas which timed out at different times converge on
+	// the same view instead of chasing each other view by view.
+	if view, ok := instance.highestCertifiedView(); ok {
 		logger.Infof("Replica %d received f+1 view-change messages, triggering view-change to view %d",
-			instance.id, minView)
+			instance.id, view)
 		// subtract one, because sendViewChange() increments
-		instance.view = minView - 1
+		instance.view = view - 1
 		return instance.sendViewChange()
 	}
 
@@ -245,7 +241,7 @@ func (instance *pbftCore) recvViewChange(vc *ViewChange) events.Event {
 	if !instance.activeView && vc.View == instance.view && quorum >= instance.allCorrectReplicasQuorum() {
 		if quorum >= instance.allCorrectReplicasQuorum() {
 			instance.startTimer(instance.lastNewViewTimeout, "new view change")
-			instance.lastNewViewTimeout = 2 * instance.lastNewViewTimeout
+			instance.backoffNewViewTimeout()
 			return viewChangeQuorumEvent{}
 		}
 
@@ -254,6 +250,83 @@ func (instance *pbftCore) recvViewChange(vc *ViewChange) events.Event {
 	return nil
 }
 
+// backoffNewViewTimeout doubles the timeout of the next view change, up to
+// the configured maximum, so that replicas waiting on a slow primary do not
+// thrash through views. The timeout is reset once a request commits.
+func (instance *pbftCore) backoffNewViewTimeout() {
+	instance.lastNewViewTimeout = 2 * instance.lastNewViewTimeout
+	if instance.newViewTimeoutMax > 0 && instance.lastNewViewTimeout > instance.newViewTimeoutMax {
+		instance.lastNewViewTimeout = instance.newViewTimeoutMax
+	}
+}
+
+// storeViewChange validates a view-change message and adds it to the view-change
+// store. The votes it carries are not retained
+func (instance *pbftCore) storeViewChange(vc *ViewChange) error {
+	if err := instance.verify(vc); err != nil {
+		return fmt.Errorf("incorrect signature in view-change message: %s", err)
+	}
+
+	if vc.View < instance.view {
+		return fmt.Errorf("view-change message for old view %d", vc.View)
+	}
+
+	if !instance.correctViewChange(vc) {
+		return fmt.Errorf("view-change message incorrect")
+	}
+
+	if _, ok := instance.viewChangeStore[vcidx{vc.View, vc.ReplicaId}]; ok {
+		return fmt.Errorf("already has a view change message for view %d from replica %d", vc.View, vc.ReplicaId)
+	}
+
+	stored := *vc
+	stored.Votes = nil
+	instance.viewChangeStore[vcidx{vc.View, vc.ReplicaId}] = &stored
+	return nil
+}
+
+// getViewChangeVotes returns, for every other replica, the view-change message for
+// the highest view not lower than the current one
+func (instance *pbftCore) getViewChangeVotes() (votes []*ViewChange) {
+	latest := make(map[uint64]*ViewChange)
+	for idx, vc := range instance.viewChangeStore {
+		if idx.id == instance.id || idx.v < instance.view {
+			continue
+		}
+		if l, ok := latest[idx.id]; !ok || l.View < idx.v {
+			latest[idx.id] = vc
+		}
+	}
+
+	for _, vc := range latest {
+		votes = append(votes, vc)
+	}
+	return
+}
+
+// highestCertifiedView returns the highest view greater than the current one, such
+// that f+1 replicas sent a view-change message for this view or a later one
+func (instance *pbftCore) highestCertifiedView() (uint64, bool) {
+	latest := make(map[uint64]uint64)
+	for idx := range instance.viewChangeStore {
+		if idx.v > instance.view && idx.v > latest[idx.id] {
+			latest[idx.id] = idx.v
+		}
+	}
+
+	if len(latest) < instance.f+1 {
+		return 0, false
+	}
+
+	views := make([]uint64, 0, len(latest))
+	for _, v := range latest {
+		views = append(views, v)
+	}
+	sort.Sort(sort.Reverse(sortableUint64Slice(views)))
+
+	return views[instance.f], true
+}
+
 func (instance *pbftCore) sendNewView() events.Event {
 
 	if _, ok := instance.newViewStore[instance.view]; ok {
@@ -281,6 +354,7 @@ func (instance *pbftCore) sendNewView() events.Event {
 		Xset:      msgList,
 		ReplicaId: instance.id,
 	}
+	instance.sign(nv)
 
 	logger.Infof("Replica %d is new primary, sending new-view, v:%d, X:%+v",
 		instance.id, nv.View, nv.Xset)
@@ -300,15 +374,54 @@ func (instance *pbftCore) recvNewView(nv *NewView) events.Event {
 		return nil
 	}
 
+	if err := instance.verify(nv); err != nil {
+		logger.Warningf("Replica %d rejecting new-view from %d, v:%d: incorrect signature: %s",
+			instance.id, nv.ReplicaId, nv.View, err)
+		return nil
+	}
+
+	if !instance.correctNewView(nv) {
+		logger.Warningf("Replica %d rejecting new-view from %d, v:%d: invalid view-change set",
+			instance.id, nv.ReplicaId, nv.View)
+		return nil
+	}
+
+	instance.newViewStore[nv.View] = nv
+	return instance.processNewView()
+}
+
+// correctNewView checks that the view-change messages a new-view is built on are
+// signed, well formed, for the new view and from a quorum of distinct replicas.
+// Otherwise the primary could leave out the view-changes carrying prepared
+// requests, and have the backups accept an O set that does not re-propose them
+func (instance *pbftCore) correctNewView(nv *NewView) bool {
+	replicas := make(map[uint64]bool)
 	for _, vc := range nv.Vset {
 		if err := instance.verify(vc); err != nil {
 			logger.Warningf("Replica %d found incorrect view-change signature in new-view message: %s", instance.id, err)
-			return nil
+			return false
+		}
+		if vc.View != nv.View {
+			logger.Warningf("Replica %d found view-change for view %d in new-view message for view %d", instance.id, vc.View, nv.View)
+			return false
 		}
+		if !instance.correctViewChange(vc) {
+			logger.Warningf("Replica %d found incorrect view-change from replica %d in new-view message", instance.id, vc.ReplicaId)
+			return false
+		}
+		if replicas[vc.ReplicaId] {
+			logger.Warningf("Replica %d found duplicate view-change from replica %d in new-view message", instance.id, vc.ReplicaId)
+			return false
+		}
+		replicas[vc.ReplicaId] = true
 	}
 
-	instance.newViewStore[nv.View] = nv
-	return instance.processNewView()
+	if len(replicas) < instance.allCorrectReplicasQuorum() {
+		logger.Warningf("Replica %d found only %d view-changes in new-view message, need %d", instance.id, len(replicas), instance.allCorrectReplicasQuorum())
+		return false
+	}
+
+	return true
 }
 
 func (instance *pbftCore) processNewView() events.Event {
@@ -325,6 +438,10 @@ func (instance *pbftCore) processNewView() events.Event {
 		return nil
 	}
 
+	// A request executing tentatively which committed before the view change
+	// completes as any other execution
+	instance.commitTentativeIfCommitted()
+
 	cp, ok, replicas := instance.selectInitialCheckpoint(nv.Vset)
 	if !ok {
 		logger.Warningf("Replica %d could not determine initial checkpoint: %+v",
@@ -333,7 +450,7 @@ func (instance *pbftCore) processNewView() events.Event {
 	}
 
 	speculativeLastExec := instance.lastExec
-	if instance.currentExec != nil {
+	if instance.currentExec != nil && !instance.awaitingCommit() {
 		speculativeLastExec = *instance.currentExec
 	}
 
@@ -462,6 +579,8 @@ func (instance *pbftCore) processNewView2(nv *NewView) events.Event {
 
 	instance.activeView = true
 	delete(instance.newViewStore, instance.view-1)
+	viewGauge.Set(float64(instance.view))
+	instance.publish(&pb.ConsensusEvent{Type: pb.ConsensusEvent_NEW_VIEW, View: instance.view, SeqNo: instance.h})
 
 	instance.seqNo = instance.h
 	for n, d := range nv.Xset {
@@ -469,9 +588,12 @@ func (instance *pbftCore) processNewView2(nv *NewView) events.Event {
 			continue
 		}
 
-		req, ok := instance.reqStore[d]
-		if !ok {
-			logger.Criticalf("Replica %d is missing request for assigned prepare after fetching, this indicates a serious bug", instance.id)
+		var req *Request
+		if d != "" {
+			var ok bool
+			if req, ok = instance.reqStore[d]; !ok {
+				logger.Criticalf("Replica %d is missing request for assigned prepare after fetching, this indicates a serious bug", instance.id)
+			}
 		}
 		preprep := &PrePrepare{
 			View:           instance.view,
@@ -488,22 +610,24 @@ func (instance *pbftCore) processNewView2(nv *NewView) events.Event {
 		}
 		instance.persistQSet()
 	}
+	instance.rollbackIfReordered()
 
 	instance.updateViewChangeSeqNo()
 
 	if instance.primary(instance.view) != instance.id {
 		for n, d := range nv.Xset {
+			if n <= instance.h {
+				continue
+			}
 			prep := &Prepare{
 				View:           instance.view,
 				SequenceNumber: n,
 				RequestDigest:  d,
 				ReplicaId:      instance.id,
 			}
-			if n > instance.h {
-				cert := instance.getCert(instance.view, n)
-				cert.sentPrepare = true
-				instance.recvPrepare(prep)
-			}
+			cert := instance.getCert(instance.view, n)
+			cert.sentPrepare = true
+			instance.recvPrepare(prep)
 			instance.innerBroadcast(&Message{&Message_Prepare{prep}})
 		}
 	} else {
@@ -518,8 +642,14 @@ func (instance *pbftCore) processNewView2(nv *NewView) events.Event {
 	return viewChangedEvent{}
 }
 
+// getViewChanges returns the view-change messages received for the current view.
+// The store may also hold view-changes for later views, these must not take part
+// in the computation of the new-view for this one
 func (instance *pbftCore) getViewChanges() (vset []*ViewChange) {
-	for _, vc := range instance.viewChangeStore {
+	for idx, vc := range instance.viewChangeStore {
+		if idx.v != instance.view {
+			continue
+		}
 		vset = append(vset, vc)
 	}
 
@@ -590,6 +720,9 @@ nLoop:
 		for _, m := range vset {
 			// "...with <n,d,v> ∈ m.P"
 			for _, em := range m.Pset {
+				if em.SequenceNumber != n {
+					continue
+				}
 				quorum := 0
 				// "A1. ∃2f+1 messages m' ∈ S"
 			mpLoop:
@@ -0,0 +1,98 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package obcpbft
+
+import (
+	"sync/atomic"
+
+	"github.com/hyperledger/fabric/consensus"
+	"github.com/hyperledger/fabric/consensus/obcpbft/events"
+	pb "github.com/hyperledger/fabric/protos"
+)
+
+// The pipelining window, general.window, is how many sequence numbers past
+// the low watermark the primary may assign, so how many batches may be in
+// flight at once. Once it assigned them all, the primary stops cutting
+// batches, rather than cut batches it could not issue the pre-prepare of, and
+// keeps the requests until a checkpoint moves the low watermark. Meanwhile
+// the requests queue up on every replica. A replica holding more outstanding
+// requests than a full window orders, batchsize requests per sequence number,
+// applies backpressure: it turns down the transactions submitted to it with
+// consensus.ErrBackpressure, for their submitter to retry later, until its
+// backlog drains. Transactions forwarded by other peers are still accepted,
+// as there is no submitter to report to.
+
+// windowSize returns the pipelining window, half the log unless configured
+func (instance *pbftCore) windowSize() uint64 {
+	if instance.window == 0 {
+		return instance.L / 2
+	}
+	return instance.window
+}
+
+// canOrder reports whether the primary can issue the pre-prepare of a new
+// batch right away
+func (op *obcBatch) canOrder() bool {
+	n := op.pbft.seqNo + 1
+	return op.pbft.activeView && op.pbft.inWV(op.pbft.view, n) && n <= op.pbft.h+op.pbft.windowSize() && n <= op.pbft.viewChangeSeqNo
+}
+
+// cutBatches cuts batches from the kept requests for as long as the primary
+// can order them. A partial batch is only cut once the batch timer expired.
+func (op *obcBatch) cutBatches() {
+	for op.canOrder() && (op.batchFull() || (len(op.batchStore) > 0 && op.batchDue)) {
+		events.SendEvent(op, op.sendBatch())
+	}
+}
+
+// RecvMsg queues a message for the main thread, through the verifier if any,
+// unless it is a transaction submitted to us while the replica applies
+// backpressure
+func (op *obcBatch) RecvMsg(ocMsg *pb.Message, senderHandle *pb.PeerID) error {
+	if ocMsg.Type == pb.Message_CHAIN_TRANSACTION && atomic.LoadInt32(&op.backpressured) != 0 {
+		if id, err := getValidatorID(senderHandle); err == nil && id == op.pbft.id {
+			backpressureRejections.Inc()
+			return consensus.ErrBackpressure
+		}
+	}
+	return op.submitOrQueue(ocMsg, senderHandle)
+}
+
+// updateBackpressure applies backpressure while the requests waiting to be
+// ordered exceed what a full window orders
+func (op *obcBatch) updateBackpressure() {
+	backlog := op.reqStore.outstandingRequests.Len()
+	if pending := op.reqStore.pendingRequests.Len(); pending > backlog {
+		backlog = pending
+	}
+	capacity := int(op.pbft.windowSize()) * op.batchSize
+
+	var on int32
+	if backlog > capacity {
+		on = 1
+	}
+	if atomic.SwapInt32(&op.backpressured, on) == on {
+		return
+	}
+	backpressureGauge.Set(float64(on))
+	if on != 0 {
+		logger.Warningf("Batch replica %d holds %d requests, more than the window of %d sequence numbers orders, turning down new transactions",
+			op.pbft.id, backlog, op.pbft.windowSize())
+	} else {
+		logger.Infof("Batch replica %d backlog drained to %d requests, accepting new transactions again", op.pbft.id, backlog)
+	}
+}
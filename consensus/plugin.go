@@ -0,0 +1,164 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package consensus
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A consensus plugin implements Plugin, and registers a factory of it under
+// its name with RegisterPlugin, usually in an init function. The peer creates
+// the plugin named by peer.validator.consensus.plugin, and drives it through
+// its lifecycle:
+//
+//   - ValidateConfig is called as the peer starts, if the plugin implements
+//     ConfigValidator, on a plugin of its own
+//   - Init is called once, with the stack of the peer and a timer factory
+//   - Start is called once, before any message is delivered
+//   - RecvMsg delivers the messages of the network to the plugin, serially
+//   - the ExecutionConsumer callbacks deliver the completions of the
+//     executions, commits, rollbacks and state transfers the plugin requested
+//   - Stop is called on shutdown, after which no message is delivered
+//
+// Through the stack the plugin unicasts and broadcasts messages
+// (Communicator), learns about the network (Inquirer), executes and commits
+// transactions (Executor), and persists the state it needs to recover from a
+// crash (StatePersistor).
+
+// Plugin is the interface every consensus plugin implements
+type Plugin interface {
+	Consenter
+	Init(stack Stack, timers TimerFactory) error // Called once, before Start
+	Start() error                                // Called once, before any message is delivered
+	Stop()                                       // Called on shutdown, releases the resources of the plugin
+}
+
+// ConfigValidator is optionally implemented by plugins which can check their
+// configuration before they are initialized, so that a misconfigured peer
+// fails as it starts rather than once it joins the network
+type ConfigValidator interface {
+	ValidateConfig() error // Called on an uninitialized plugin
+}
+
+// PluginFactory creates an uninitialized plugin
+type PluginFactory func() Plugin
+
+var plugins = struct {
+	sync.RWMutex
+	factories map[string]PluginFactory
+}{factories: make(map[string]PluginFactory)}
+
+// RegisterPlugin registers the factory of a plugin under name, which is case
+// insensitive. It panics if a plugin is already registered under name.
+func RegisterPlugin(name string, factory PluginFactory) {
+	plugins.Lock()
+	defer plugins.Unlock()
+	name = strings.ToLower(name)
+	if _, ok := plugins.factories[name]; ok {
+		panic(fmt.Errorf("Consensus plugin %s registered twice", name))
+	}
+	plugins.factories[name] = factory
+}
+
+// GetPluginFactory returns the factory of the plugin registered under name
+func GetPluginFactory(name string) (PluginFactory, bool) {
+	plugins.RLock()
+	defer plugins.RUnlock()
+	factory, ok := plugins.factories[strings.ToLower(name)]
+	return factory, ok
+}
+
+// PluginNames returns the names of the registered plugins, sorted
+func PluginNames() []string {
+	plugins.RLock()
+	defer plugins.RUnlock()
+	names := make([]string, 0, len(plugins.factories))
+	for name := range plugins.factories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Timer is a one-shot timer of a plugin
+type Timer interface {
+	Reset(d time.Duration) // Arms the timer to expire after d, disarming it first if armed
+	Stop()                 // Disarms the timer
+}
+
+// TimerFactory creates the timers of a plugin. The expired function of a
+// timer is called on a goroutine of its own when the timer expires, unless
+// the timer was disarmed before.
+type TimerFactory interface {
+	NewTimer(expired func()) Timer
+}
+
+// NewTimerFactory returns a factory of timers following the clock of the host
+func NewTimerFactory() TimerFactory {
+	return timerFactory{}
+}
+
+type timerFactory struct{}
+
+func (timerFactory) NewTimer(expired func()) Timer {
+	return &timer{expired: expired}
+}
+
+// timer counts its arming generations, so that an expiration racing a
+// Reset or a Stop is dropped
+type timer struct {
+	sync.Mutex
+	expired    func()
+	generation uint64
+	t          *time.Timer
+}
+
+func (t *timer) Reset(d time.Duration) {
+	t.Lock()
+	defer t.Unlock()
+	t.disarm()
+	generation := t.generation
+	t.t = time.AfterFunc(d, func() {
+		t.Lock()
+		current := t.generation == generation
+		if current {
+			t.t = nil
+		}
+		t.Unlock()
+		if current {
+			t.expired()
+		}
+	})
+}
+
+func (t *timer) Stop() {
+	t.Lock()
+	defer t.Unlock()
+	t.disarm()
+}
+
+func (t *timer) disarm() {
+	t.generation++
+	if t.t != nil {
+		t.t.Stop()
+		t.t = nil
+	}
+}
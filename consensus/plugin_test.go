@@ -0,0 +1,62 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package consensus
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestRegisterPlugin(t *testing.T) {
+	RegisterPlugin("Test", func() Plugin { return nil })
+	if _, ok := GetPluginFactory("test"); !ok {
+		t.Fatalf("Expected the plugin to be registered, case insensitively")
+	}
+	if names := PluginNames(); !reflect.DeepEqual(names, []string{"test"}) {
+		t.Fatalf("Expected the registered plugins to be [test], got %v", names)
+	}
+	defer func() {
+		if recover() == nil {
+			t.Fatalf("Expected a second registration under the same name to panic")
+		}
+	}()
+	RegisterPlugin("test", func() Plugin { return nil })
+}
+
+func TestTimer(t *testing.T) {
+	expired := make(chan struct{}, 10)
+	timer := NewTimerFactory().NewTimer(func() { expired <- struct{}{} })
+
+	timer.Reset(10 * time.Millisecond)
+	select {
+	case <-expired:
+	case <-time.After(time.Second):
+		t.Fatalf("Expected the timer to expire")
+	}
+
+	// Disarmed, and rearmed for later, a timer does not expire early
+	timer.Reset(10 * time.Millisecond)
+	timer.Stop()
+	timer.Reset(time.Hour)
+	select {
+	case <-expired:
+		t.Fatalf("Expected a disarmed timer not to expire")
+	case <-time.After(50 * time.Millisecond):
+	}
+	timer.Stop()
+}
@@ -0,0 +1,155 @@
+// Code generated by protoc-gen-go.
+// source: raft/messages.proto
+// DO NOT EDIT!
+
+/*
+Package raft is a generated protocol buffer package.
+
+It is generated from these files:
+
+	raft/messages.proto
+
+It has these top-level messages:
+
+	Message
+	Entry
+	Snapshot
+	Metadata
+*/
+package raft
+
+import proto "github.com/golang/protobuf/proto"
+import fmt "fmt"
+import math "math"
+import google_protobuf "google/protobuf"
+
+// Reference imports to suppress errors if they are not otherwise used.
+var _ = proto.Marshal
+var _ = fmt.Errorf
+var _ = math.Inf
+
+type Message_Type int32
+
+const (
+	Message_UNDEFINED Message_Type = 0
+	// a candidate asks for the vote of a validator
+	Message_VOTE          Message_Type = 1
+	Message_VOTE_RESPONSE Message_Type = 2
+	// the leader replicates entries, or asserts its leadership with none
+	Message_APPEND          Message_Type = 3
+	Message_APPEND_RESPONSE Message_Type = 4
+	// the leader brings a follower whose entries it compacted up to date
+	Message_SNAPSHOT          Message_Type = 5
+	Message_SNAPSHOT_RESPONSE Message_Type = 6
+	// a follower hands transactions to the leader
+	Message_PROPOSAL Message_Type = 7
+)
+
+var Message_Type_name = map[int32]string{
+	0: "UNDEFINED",
+	1: "VOTE",
+	2: "VOTE_RESPONSE",
+	3: "APPEND",
+	4: "APPEND_RESPONSE",
+	5: "SNAPSHOT",
+	6: "SNAPSHOT_RESPONSE",
+	7: "PROPOSAL",
+}
+var Message_Type_value = map[string]int32{
+	"UNDEFINED":         0,
+	"VOTE":              1,
+	"VOTE_RESPONSE":     2,
+	"APPEND":            3,
+	"APPEND_RESPONSE":   4,
+	"SNAPSHOT":          5,
+	"SNAPSHOT_RESPONSE": 6,
+	"PROPOSAL":          7,
+}
+
+func (x Message_Type) String() string {
+	return proto.EnumName(Message_Type_name, int32(x))
+}
+
+// Message is a message of the Raft protocol between validators
+type Message struct {
+	Type Message_Type `protobuf:"varint,1,opt,name=type,enum=raft.Message_Type" json:"type,omitempty"`
+	Term uint64       `protobuf:"varint,2,opt,name=term" json:"term,omitempty"`
+	// VOTE: index and term of the last entry of the candidate
+	// APPEND: index and term of the entry preceding the entries
+	// SNAPSHOT: index and term of the last entry covered by the snapshot
+	// APPEND_RESPONSE, SNAPSHOT_RESPONSE: index of the last entry the
+	// follower holds matching the log of the leader, on success, or from
+	// which the leader should retry, on failure
+	Index   uint64   `protobuf:"varint,3,opt,name=index" json:"index,omitempty"`
+	LogTerm uint64   `protobuf:"varint,4,opt,name=logTerm" json:"logTerm,omitempty"`
+	Entries []*Entry `protobuf:"bytes,5,rep,name=entries" json:"entries,omitempty"`
+	// APPEND: index of the last entry the leader knows to be committed
+	Commit uint64 `protobuf:"varint,6,opt,name=commit" json:"commit,omitempty"`
+	// VOTE_RESPONSE: whether the vote is granted
+	// APPEND_RESPONSE: whether the entries matched the log of the follower
+	Success bool `protobuf:"varint,7,opt,name=success" json:"success,omitempty"`
+	// SNAPSHOT: BlockchainInfo of the chain once the entries covered by the
+	// snapshot are applied
+	Snapshot []byte `protobuf:"bytes,8,opt,name=snapshot,proto3" json:"snapshot,omitempty"`
+	// PROPOSAL: marshaled protos.TransactionBlock
+	Payload []byte `protobuf:"bytes,9,opt,name=payload,proto3" json:"payload,omitempty"`
+}
+
+func (m *Message) Reset()         { *m = Message{} }
+func (m *Message) String() string { return proto.CompactTextString(m) }
+func (*Message) ProtoMessage()    {}
+
+func (m *Message) GetEntries() []*Entry {
+	if m != nil {
+		return m.Entries
+	}
+	return nil
+}
+
+// Entry is an entry of the replicated log, a batch of transactions
+type Entry struct {
+	Term  uint64 `protobuf:"varint,1,opt,name=term" json:"term,omitempty"`
+	Index uint64 `protobuf:"varint,2,opt,name=index" json:"index,omitempty"`
+	// consensus time of the batch, as the leader saw it
+	Timestamp *google_protobuf.Timestamp `protobuf:"bytes,3,opt,name=timestamp" json:"timestamp,omitempty"`
+	// marshaled protos.TransactionBlock
+	Payload []byte `protobuf:"bytes,4,opt,name=payload,proto3" json:"payload,omitempty"`
+}
+
+func (m *Entry) Reset()         { *m = Entry{} }
+func (m *Entry) String() string { return proto.CompactTextString(m) }
+func (*Entry) ProtoMessage()    {}
+
+func (m *Entry) GetTimestamp() *google_protobuf.Timestamp {
+	if m != nil {
+		return m.Timestamp
+	}
+	return nil
+}
+
+// Snapshot is the last entry applied to the chain the log is compacted up to
+type Snapshot struct {
+	Index uint64 `protobuf:"varint,1,opt,name=index" json:"index,omitempty"`
+	Term  uint64 `protobuf:"varint,2,opt,name=term" json:"term,omitempty"`
+	// BlockchainInfo of the chain once the entry is applied
+	Info []byte `protobuf:"bytes,3,opt,name=info,proto3" json:"info,omitempty"`
+}
+
+func (m *Snapshot) Reset()         { *m = Snapshot{} }
+func (m *Snapshot) String() string { return proto.CompactTextString(m) }
+func (*Snapshot) ProtoMessage()    {}
+
+// Metadata is the consensus metadata of the blocks committed by Raft
+type Metadata struct {
+	// the entry of the block
+	Index uint64 `protobuf:"varint,1,opt,name=index" json:"index,omitempty"`
+	Term  uint64 `protobuf:"varint,2,opt,name=term" json:"term,omitempty"`
+}
+
+func (m *Metadata) Reset()         { *m = Metadata{} }
+func (m *Metadata) String() string { return proto.CompactTextString(m) }
+func (*Metadata) ProtoMessage()    {}
+
+func init() {
+	proto.RegisterEnum("raft.Message_Type", Message_Type_name, Message_Type_value)
+}
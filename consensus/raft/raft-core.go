@@ -0,0 +1,613 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package raft
+
+import (
+	"fmt"
+
+	google_protobuf "google/protobuf"
+
+	"github.com/hyperledger/fabric/consensus"
+)
+
+// raftCore implements the Raft algorithm, as described in "In Search of an
+// Understandable Consensus Algorithm" by Ongaro and Ousterhout. It is a
+// state machine driven by the messages of the other validators and by the
+// expiration of its timers, and acts through its stack, so that it can be
+// tested without a network.
+//
+// The log holds batches of transactions. The chain is the state machine the
+// log is applied to: an entry is applied by executing its transactions and
+// committing them as a block, whose metadata records the index of the entry.
+// As the chain holds what was applied, a snapshot is no more than the
+// BlockchainInfo of the chain once an entry is applied: the log is compacted
+// up to the snapshot, and a follower missing compacted entries is brought up
+// to date through state transfer to the chain of the snapshot.
+
+// raftStack is what the core needs from the plugin
+type raftStack interface {
+	send(to uint64, msg *Message)
+	execute(entry *Entry)                 // applies an entry to the chain, applied must be called once done
+	transfer(snap *Snapshot, from uint64) // brings the chain to a snapshot, transferred must be called once done
+	chainInfo() []byte                    // marshaled BlockchainInfo of the chain
+	leaderChanged(leader uint64)
+	resetElectionTimer()
+	stopElectionTimer()
+	resetHeartbeatTimer()
+	stopHeartbeatTimer()
+}
+
+type role int
+
+const (
+	follower role = iota
+	candidate
+	leader
+)
+
+func (r role) String() string {
+	switch r {
+	case follower:
+		return "follower"
+	case candidate:
+		return "candidate"
+	default:
+		return "leader"
+	}
+}
+
+// none stands for no validator, as leader or vote
+const none = ^uint64(0)
+
+type raftCore struct {
+	stack   raftStack
+	persist consensus.StatePersistor
+
+	id               uint64
+	N                uint64
+	snapshotInterval uint64 // number of entries applied between snapshots
+	maxAppend        int    // maximum number of entries per message
+
+	role     role
+	term     uint64
+	votedFor uint64 // in term
+	leader   uint64
+	votes    map[uint64]bool // granted to us as candidate
+
+	snapshot *Snapshot // last entry the log is compacted up to
+	log      []*Entry  // entries after the snapshot
+	commit   uint64    // index of the last entry known to be committed
+	applied  uint64    // index of the last entry applied to the chain
+	applying bool      // whether an entry is being applied
+
+	// snapshot the chain must be brought to, and whether the state transfer
+	// to it is in progress
+	transferring *Snapshot
+	transferFrom uint64
+	transferOn   bool
+
+	next  map[uint64]uint64 // index of the next entry to send to each follower
+	match map[uint64]uint64 // index of the last entry known to be replicated on each follower
+}
+
+// newRaftCore returns the core of validator id, restoring the state it
+// persisted. head is the metadata of the block at the head of the chain, nil
+// if none was committed by Raft.
+func newRaftCore(id, N uint64, snapshotInterval uint64, maxAppend int, stack raftStack, persist consensus.StatePersistor, head *Metadata) *raftCore {
+	rc := &raftCore{
+		stack:            stack,
+		persist:          persist,
+		id:               id,
+		N:                N,
+		snapshotInterval: snapshotInterval,
+		maxAppend:        maxAppend,
+		votedFor:         none,
+		leader:           none,
+		snapshot:         &Snapshot{},
+	}
+	rc.restore(head)
+	return rc
+}
+
+func (rc *raftCore) quorum() int {
+	return int(rc.N/2 + 1)
+}
+
+func (rc *raftCore) lastIndex() uint64 {
+	return rc.snapshot.Index + uint64(len(rc.log))
+}
+
+func (rc *raftCore) lastTerm() uint64 {
+	if len(rc.log) == 0 {
+		return rc.snapshot.Term
+	}
+	return rc.log[len(rc.log)-1].Term
+}
+
+// termAt returns the term of the entry at index, and whether the log still
+// knows it
+func (rc *raftCore) termAt(index uint64) (uint64, bool) {
+	switch {
+	case index == rc.snapshot.Index:
+		return rc.snapshot.Term, true
+	case index < rc.snapshot.Index || index > rc.lastIndex():
+		return 0, false
+	default:
+		return rc.log[index-rc.snapshot.Index-1].Term, true
+	}
+}
+
+// entry returns the entry at index, which must be in the log
+func (rc *raftCore) entry(index uint64) *Entry {
+	return rc.log[index-rc.snapshot.Index-1]
+}
+
+// start arms the election timer, and resumes the state transfer interrupted
+// by a crash, if any
+func (rc *raftCore) start() {
+	if rc.N == 1 {
+		rc.becomeCandidate()
+		return
+	}
+	rc.stack.resetElectionTimer()
+	rc.maybeTransfer()
+}
+
+// =============================================================================
+// roles
+// =============================================================================
+
+func (rc *raftCore) becomeFollower(term uint64, lead uint64) {
+	if term > rc.term {
+		rc.term = term
+		rc.votedFor = none
+		rc.persistTerm()
+	}
+	wasLeader := rc.role == leader
+	rc.role = follower
+	if wasLeader {
+		rc.stack.stopHeartbeatTimer()
+	}
+	rc.stack.resetElectionTimer()
+	if lead != rc.leader {
+		if lead != none {
+			logger.Infof("Raft validator %d follows validator %d in term %d", rc.id, lead, rc.term)
+		}
+		rc.leader = lead
+		rc.stack.leaderChanged(lead)
+	}
+}
+
+func (rc *raftCore) becomeCandidate() {
+	rc.role = candidate
+	rc.term++
+	rc.votedFor = rc.id
+	rc.persistTerm()
+	if rc.leader != none {
+		rc.leader = none
+		rc.stack.leaderChanged(none)
+	}
+	rc.votes = map[uint64]bool{rc.id: true}
+	logger.Infof("Raft validator %d campaigns in term %d", rc.id, rc.term)
+	if len(rc.votes) >= rc.quorum() {
+		rc.becomeLeader()
+		return
+	}
+	rc.stack.resetElectionTimer()
+	for to := uint64(0); to < rc.N; to++ {
+		if to != rc.id {
+			rc.stack.send(to, &Message{Type: Message_VOTE, Term: rc.term, Index: rc.lastIndex(), LogTerm: rc.lastTerm()})
+		}
+	}
+}
+
+func (rc *raftCore) becomeLeader() {
+	logger.Infof("Raft validator %d is leader in term %d", rc.id, rc.term)
+	rc.role = leader
+	rc.leader = rc.id
+	rc.next = make(map[uint64]uint64)
+	rc.match = make(map[uint64]uint64)
+	for to := uint64(0); to < rc.N; to++ {
+		rc.next[to] = rc.lastIndex() + 1
+		rc.match[to] = 0
+	}
+	rc.stack.stopElectionTimer()
+	rc.stack.leaderChanged(rc.id)
+	// Entries of previous terms are only known committed once an entry of
+	// the current term is, so start the term with an empty one
+	rc.appendEntry(&Entry{})
+	rc.heartbeat()
+	rc.advanceCommit()
+}
+
+// electionTimeout is called when the election timer expires
+func (rc *raftCore) electionTimeout() {
+	if rc.role == leader {
+		return
+	}
+	rc.becomeCandidate()
+}
+
+// heartbeat is called when the heartbeat timer expires, it sends the
+// followers the entries they miss, none if they are up to date
+func (rc *raftCore) heartbeat() {
+	if rc.role != leader {
+		return
+	}
+	for to := uint64(0); to < rc.N; to++ {
+		if to != rc.id {
+			rc.sendAppend(to)
+		}
+	}
+	rc.stack.resetHeartbeatTimer()
+}
+
+// =============================================================================
+// log replication
+// =============================================================================
+
+// propose appends a batch of transactions to the log of the leader
+func (rc *raftCore) propose(payload []byte, timestamp *google_protobuf.Timestamp) error {
+	if rc.role != leader {
+		return fmt.Errorf("Raft validator %d is not the leader", rc.id)
+	}
+	rc.appendEntry(&Entry{Timestamp: timestamp, Payload: payload})
+	for to := uint64(0); to < rc.N; to++ {
+		if to != rc.id {
+			rc.sendAppend(to)
+		}
+	}
+	rc.advanceCommit()
+	return nil
+}
+
+func (rc *raftCore) appendEntry(e *Entry) {
+	e.Term = rc.term
+	e.Index = rc.lastIndex() + 1
+	rc.log = append(rc.log, e)
+	rc.persistEntry(e)
+	rc.match[rc.id] = e.Index
+}
+
+func (rc *raftCore) sendAppend(to uint64) {
+	next := rc.next[to]
+	if next <= rc.snapshot.Index {
+		rc.stack.send(to, &Message{Type: Message_SNAPSHOT, Term: rc.term, Index: rc.snapshot.Index, LogTerm: rc.snapshot.Term, Snapshot: rc.snapshot.Info})
+		return
+	}
+	prevTerm, _ := rc.termAt(next - 1)
+	var entries []*Entry
+	for i := next; i <= rc.lastIndex() && len(entries) < rc.maxAppend; i++ {
+		entries = append(entries, rc.entry(i))
+	}
+	rc.stack.send(to, &Message{Type: Message_APPEND, Term: rc.term, Index: next - 1, LogTerm: prevTerm, Entries: entries, Commit: rc.commit})
+}
+
+// advanceCommit commits the last entry of the current term a quorum holds
+func (rc *raftCore) advanceCommit() {
+	for index := rc.lastIndex(); index > rc.commit; index-- {
+		if term, _ := rc.termAt(index); term != rc.term {
+			break
+		}
+		replicas := 0
+		for _, match := range rc.match {
+			if match >= index {
+				replicas++
+			}
+		}
+		if replicas >= rc.quorum() {
+			rc.commit = index
+			rc.maybeApply()
+			return
+		}
+	}
+}
+
+// =============================================================================
+// messages
+// =============================================================================
+
+// step processes a message of another validator
+func (rc *raftCore) step(from uint64, msg *Message) {
+	if from >= rc.N || from == rc.id {
+		logger.Warningf("Raft validator %d dropping %s from unknown validator %d", rc.id, msg.Type, from)
+		return
+	}
+	if msg.Term > rc.term {
+		lead := none
+		if msg.Type == Message_APPEND || msg.Type == Message_SNAPSHOT {
+			lead = from
+		}
+		rc.becomeFollower(msg.Term, lead)
+	}
+	if msg.Term < rc.term {
+		// Let a stale leader or candidate learn about the current term
+		switch msg.Type {
+		case Message_VOTE:
+			rc.stack.send(from, &Message{Type: Message_VOTE_RESPONSE, Term: rc.term})
+		case Message_APPEND, Message_SNAPSHOT:
+			rc.stack.send(from, &Message{Type: Message_APPEND_RESPONSE, Term: rc.term, Index: rc.lastIndex() + 1})
+		}
+		return
+	}
+
+	switch msg.Type {
+	case Message_VOTE:
+		rc.recvVote(from, msg)
+	case Message_VOTE_RESPONSE:
+		rc.recvVoteResponse(from, msg)
+	case Message_APPEND:
+		rc.recvAppend(from, msg)
+	case Message_APPEND_RESPONSE:
+		rc.recvAppendResponse(from, msg)
+	case Message_SNAPSHOT:
+		rc.recvSnapshot(from, msg)
+	case Message_SNAPSHOT_RESPONSE:
+		rc.recvAppendResponse(from, msg)
+	default:
+		logger.Warningf("Raft validator %d dropping message of type %s from validator %d", rc.id, msg.Type, from)
+	}
+}
+
+func (rc *raftCore) recvVote(from uint64, msg *Message) {
+	upToDate := msg.LogTerm > rc.lastTerm() || (msg.LogTerm == rc.lastTerm() && msg.Index >= rc.lastIndex())
+	grant := rc.role == follower && (rc.votedFor == none || rc.votedFor == from) && upToDate
+	if grant {
+		rc.votedFor = from
+		rc.persistTerm()
+		rc.stack.resetElectionTimer()
+		logger.Debugf("Raft validator %d votes for validator %d in term %d", rc.id, from, rc.term)
+	}
+	rc.stack.send(from, &Message{Type: Message_VOTE_RESPONSE, Term: rc.term, Success: grant})
+}
+
+func (rc *raftCore) recvVoteResponse(from uint64, msg *Message) {
+	if rc.role != candidate || !msg.Success {
+		return
+	}
+	rc.votes[from] = true
+	if len(rc.votes) >= rc.quorum() {
+		rc.becomeLeader()
+	}
+}
+
+func (rc *raftCore) recvAppend(from uint64, msg *Message) {
+	if rc.role != follower || rc.leader != from {
+		rc.becomeFollower(msg.Term, from)
+	}
+	rc.stack.resetElectionTimer()
+
+	prev, prevTerm, entries := msg.Index, msg.LogTerm, msg.Entries
+	if prev < rc.snapshot.Index {
+		// The entries up to the snapshot are committed, and so match ours
+		for len(entries) > 0 && entries[0].Index <= rc.snapshot.Index {
+			entries = entries[1:]
+		}
+		prev, prevTerm = rc.snapshot.Index, rc.snapshot.Term
+	}
+	if term, ok := rc.termAt(prev); !ok || term != prevTerm {
+		// Have the leader retry from the first entry of the conflicting
+		// term, or from the end of our log
+		retry := rc.lastIndex() + 1
+		if ok {
+			retry = prev
+			for retry-1 > rc.snapshot.Index {
+				if t, _ := rc.termAt(retry - 1); t != term {
+					break
+				}
+				retry--
+			}
+		}
+		rc.stack.send(from, &Message{Type: Message_APPEND_RESPONSE, Term: rc.term, Index: retry})
+		return
+	}
+
+	for _, e := range entries {
+		if term, ok := rc.termAt(e.Index); ok {
+			if term == e.Term {
+				continue
+			}
+			if e.Index <= rc.commit {
+				logger.Panicf("Raft validator %d asked to replace committed entry %d", rc.id, e.Index)
+			}
+			rc.truncate(e.Index)
+		}
+		rc.log = append(rc.log, e)
+		rc.persistEntry(e)
+	}
+
+	last := prev + uint64(len(entries))
+	if msg.Commit > rc.commit {
+		rc.commit = msg.Commit
+		if rc.commit > last {
+			rc.commit = last
+		}
+		rc.maybeApply()
+	}
+	rc.stack.send(from, &Message{Type: Message_APPEND_RESPONSE, Term: rc.term, Index: last, Success: true})
+}
+
+func (rc *raftCore) recvAppendResponse(from uint64, msg *Message) {
+	if rc.role != leader {
+		return
+	}
+	if msg.Success || msg.Type == Message_SNAPSHOT_RESPONSE {
+		if msg.Index > rc.match[from] {
+			rc.match[from] = msg.Index
+		}
+		rc.next[from] = rc.match[from] + 1
+		rc.advanceCommit()
+		if rc.next[from] <= rc.lastIndex() {
+			rc.sendAppend(from)
+		}
+		return
+	}
+	next := msg.Index
+	if next >= rc.next[from] {
+		next = rc.next[from] - 1
+	}
+	if next <= rc.match[from] {
+		next = rc.match[from] + 1
+	}
+	rc.next[from] = next
+	rc.sendAppend(from)
+}
+
+// recvSnapshot replaces the log of a follower missing entries the leader
+// compacted with the snapshot, and brings the chain to it
+func (rc *raftCore) recvSnapshot(from uint64, msg *Message) {
+	if rc.role != follower || rc.leader != from {
+		rc.becomeFollower(msg.Term, from)
+	}
+	rc.stack.resetElectionTimer()
+
+	if msg.Index > rc.applied && (rc.transferring == nil || msg.Index > rc.transferring.Index) {
+		snap := &Snapshot{Index: msg.Index, Term: msg.LogTerm, Info: msg.Snapshot}
+		logger.Infof("Raft validator %d installing snapshot at entry %d from validator %d", rc.id, snap.Index, from)
+		rc.truncate(rc.snapshot.Index + 1)
+		rc.snapshot = snap
+		rc.persistSnapshot()
+		if snap.Index > rc.commit {
+			rc.commit = snap.Index
+		}
+		rc.transferring, rc.transferFrom, rc.transferOn = snap, from, false
+		rc.maybeTransfer()
+	}
+	rc.stack.send(from, &Message{Type: Message_SNAPSHOT_RESPONSE, Term: rc.term, Index: msg.Index, Success: true})
+}
+
+// truncate drops the entries from index on
+func (rc *raftCore) truncate(index uint64) {
+	for i := index; i <= rc.lastIndex(); i++ {
+		rc.persist.DelState(entryKey(i))
+	}
+	if index <= rc.snapshot.Index {
+		rc.log = nil
+		return
+	}
+	rc.log = rc.log[:index-rc.snapshot.Index-1]
+}
+
+// =============================================================================
+// application of the log to the chain
+// =============================================================================
+
+// maybeApply applies the next committed entry, unless an entry is being
+// applied or the chain is being brought to a snapshot
+func (rc *raftCore) maybeApply() {
+	if rc.applying || rc.transferring != nil {
+		return
+	}
+	for rc.applied < rc.commit {
+		e := rc.entry(rc.applied + 1)
+		if len(e.Payload) == 0 {
+			// The empty entries of new leaders leave the chain as it is
+			rc.applied = e.Index
+			continue
+		}
+		rc.applying = true
+		rc.stack.execute(e)
+		return
+	}
+	rc.maybeCompact()
+}
+
+// entryApplied is called once an entry is applied to the chain
+func (rc *raftCore) entryApplied(index uint64) {
+	rc.applying = false
+	if index > rc.applied {
+		rc.applied = index
+	}
+	if rc.maybeTransfer() {
+		return
+	}
+	rc.maybeApply()
+}
+
+// maybeTransfer starts the pending state transfer, once no entry is being
+// applied, and returns whether one is pending
+func (rc *raftCore) maybeTransfer() bool {
+	if rc.transferring == nil {
+		return false
+	}
+	if !rc.applying && !rc.transferOn {
+		rc.transferOn = true
+		rc.stack.transfer(rc.transferring, rc.transferFrom)
+	}
+	return true
+}
+
+// transferred is called once a state transfer completes, ok is false if it
+// failed
+func (rc *raftCore) transferred(ok bool) {
+	rc.transferOn = false
+	if !ok {
+		logger.Warningf("Raft validator %d could not bring its chain to entry %d, retrying", rc.id, rc.transferring.Index)
+		rc.maybeTransfer()
+		return
+	}
+	rc.applied = rc.transferring.Index
+	rc.transferring = nil
+	rc.maybeApply()
+}
+
+// maybeCompact drops the applied entries from the log once there are
+// snapshotInterval of them
+func (rc *raftCore) maybeCompact() {
+	if rc.applied < rc.snapshot.Index+rc.snapshotInterval {
+		return
+	}
+	term, _ := rc.termAt(rc.applied)
+	snap := &Snapshot{Index: rc.applied, Term: term, Info: rc.stack.chainInfo()}
+	for i := rc.snapshot.Index + 1; i <= snap.Index; i++ {
+		rc.persist.DelState(entryKey(i))
+	}
+	rc.log = append([]*Entry(nil), rc.log[snap.Index-rc.snapshot.Index:]...)
+	rc.snapshot = snap
+	rc.persistSnapshot()
+	logger.Debugf("Raft validator %d compacted its log up to entry %d", rc.id, snap.Index)
+}
+
+// Status is a snapshot of the state of a validator, for diagnostics
+type Status struct {
+	ID       uint64  `json:"id"`
+	Role     string  `json:"role"`
+	Term     uint64  `json:"term"`
+	Leader   *uint64 `json:"leader,omitempty"`
+	Snapshot uint64  `json:"snapshot"`
+	Last     uint64  `json:"last"`
+	Commit   uint64  `json:"commit"`
+	Applied  uint64  `json:"applied"`
+	Pending  int     `json:"pending"`
+}
+
+func (rc *raftCore) getStatus() *Status {
+	status := &Status{
+		ID:       rc.id,
+		Role:     rc.role.String(),
+		Term:     rc.term,
+		Snapshot: rc.snapshot.Index,
+		Last:     rc.lastIndex(),
+		Commit:   rc.commit,
+		Applied:  rc.applied,
+	}
+	if rc.leader != none {
+		leader := rc.leader
+		status.Leader = &leader
+	}
+	return status
+}
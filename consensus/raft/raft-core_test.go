@@ -0,0 +1,354 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package raft
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+
+	pb "github.com/hyperledger/fabric/protos"
+)
+
+type mockPersist struct {
+	store map[string][]byte
+}
+
+func newMockPersist() *mockPersist {
+	return &mockPersist{store: make(map[string][]byte)}
+}
+
+func (p *mockPersist) ReadState(key string) ([]byte, error) {
+	if val, ok := p.store[key]; ok {
+		return val, nil
+	}
+	return nil, fmt.Errorf("cannot find key %s", key)
+}
+
+func (p *mockPersist) ReadStateSet(prefix string) (map[string][]byte, error) {
+	ret := make(map[string][]byte)
+	for k, v := range p.store {
+		if len(k) >= len(prefix) && k[0:len(prefix)] == prefix {
+			ret[k] = v
+		}
+	}
+	return ret, nil
+}
+
+func (p *mockPersist) StoreState(key string, value []byte) error {
+	p.store[key] = value
+	return nil
+}
+
+func (p *mockPersist) DelState(key string) {
+	delete(p.store, key)
+}
+
+type testMsg struct {
+	from, to uint64
+	msg      *Message
+}
+
+// testNode is the stack of a core of a testNet, whose chain is the list of
+// the payloads applied
+type testNode struct {
+	net        *testNet
+	id         uint64
+	core       *raftCore
+	persist    *mockPersist
+	chain      [][]byte
+	executing  *Entry
+	transferTo *Snapshot
+	down       bool
+}
+
+func (n *testNode) send(to uint64, msg *Message) {
+	raw, _ := proto.Marshal(msg)
+	clone := &Message{}
+	proto.Unmarshal(raw, clone)
+	n.net.msgs = append(n.net.msgs, testMsg{n.id, to, clone})
+}
+
+func (n *testNode) execute(entry *Entry) {
+	n.executing = entry
+}
+
+func (n *testNode) transfer(snap *Snapshot, from uint64) {
+	n.transferTo = snap
+}
+
+func (n *testNode) chainInfo() []byte {
+	raw, _ := proto.Marshal(&pb.BlockchainInfo{Height: uint64(len(n.chain))})
+	return raw
+}
+
+func (n *testNode) leaderChanged(leader uint64) {}
+func (n *testNode) resetElectionTimer()         {}
+func (n *testNode) stopElectionTimer()          {}
+func (n *testNode) resetHeartbeatTimer()        {}
+func (n *testNode) stopHeartbeatTimer()         {}
+
+// head returns the metadata the head block of the chain would carry
+func (n *testNode) head() *Metadata {
+	if len(n.chain) == 0 {
+		return nil
+	}
+	// The payloads of the test are the index of their entry
+	var index uint64
+	fmt.Sscanf(string(n.chain[len(n.chain)-1]), "%d", &index)
+	term, _ := n.core.termAt(index)
+	return &Metadata{Index: index, Term: term}
+}
+
+type testNet struct {
+	nodes []*testNode
+	msgs  []testMsg
+}
+
+func newTestNet(N int, snapshotInterval uint64) *testNet {
+	net := &testNet{}
+	for id := uint64(0); id < uint64(N); id++ {
+		n := &testNode{net: net, id: id, persist: newMockPersist()}
+		n.core = newRaftCore(id, uint64(N), snapshotInterval, 2, n, n.persist, nil)
+		net.nodes = append(net.nodes, n)
+	}
+	return net
+}
+
+// run delivers the messages and completes the executions and state
+// transfers, until none is left
+func (net *testNet) run() {
+	for {
+		progress := false
+		for len(net.msgs) > 0 {
+			m := net.msgs[0]
+			net.msgs = net.msgs[1:]
+			if net.nodes[m.from].down || net.nodes[m.to].down {
+				continue
+			}
+			net.nodes[m.to].core.step(m.from, m.msg)
+			progress = true
+		}
+		for _, n := range net.nodes {
+			if n.down {
+				continue
+			}
+			if e := n.executing; e != nil {
+				n.executing = nil
+				n.chain = append(n.chain, e.Payload)
+				n.core.entryApplied(e.Index)
+				progress = true
+			}
+			if snap := n.transferTo; snap != nil {
+				n.transferTo = nil
+				info := &pb.BlockchainInfo{}
+				proto.Unmarshal(snap.Info, info)
+				for _, peer := range net.nodes {
+					if uint64(len(peer.chain)) >= info.Height {
+						n.chain = append([][]byte(nil), peer.chain[:info.Height]...)
+						break
+					}
+				}
+				n.core.transferred(true)
+				progress = true
+			}
+		}
+		if !progress {
+			return
+		}
+	}
+}
+
+// elect has node id win an election
+func (net *testNet) elect(t *testing.T, id uint64) {
+	net.nodes[id].core.electionTimeout()
+	net.run()
+	for _, n := range net.nodes {
+		if n.down {
+			continue
+		}
+		if n.core.leader != id {
+			t.Fatalf("Expected validator %d to follow validator %d, follows %d", n.id, id, n.core.leader)
+		}
+	}
+	if net.nodes[id].core.role != leader {
+		t.Fatalf("Expected validator %d to lead", id)
+	}
+}
+
+// propose has the leader propose count entries, and waits for them to be
+// applied everywhere
+func (net *testNet) propose(t *testing.T, id uint64, count int) {
+	for i := 0; i < count; i++ {
+		core := net.nodes[id].core
+		if err := core.propose([]byte(fmt.Sprint(core.lastIndex()+1)), nil); err != nil {
+			t.Fatalf("Could not propose: %s", err)
+		}
+		net.run()
+	}
+	net.nodes[id].core.heartbeat()
+	net.run()
+}
+
+func (net *testNet) checkChains(t *testing.T, length int) {
+	var expected [][]byte
+	for _, n := range net.nodes {
+		if n.down {
+			continue
+		}
+		if len(n.chain) != length {
+			t.Fatalf("Expected validator %d to have applied %d entries, applied %d", n.id, length, len(n.chain))
+		}
+		if expected == nil {
+			expected = n.chain
+			continue
+		}
+		for i := range expected {
+			if !bytes.Equal(n.chain[i], expected[i]) {
+				t.Fatalf("Validator %d applied %s at %d, expected %s", n.id, n.chain[i], i, expected[i])
+			}
+		}
+	}
+}
+
+func TestElection(t *testing.T) {
+	net := newTestNet(3, 100)
+	net.elect(t, 1)
+	for _, n := range net.nodes {
+		if n.core.term != 1 {
+			t.Errorf("Expected validator %d in term 1, is in term %d", n.id, n.core.term)
+		}
+	}
+
+	// A candidate whose log is behind cannot win
+	net.propose(t, 1, 1)
+	net.nodes[0].down = true
+	net.propose(t, 1, 1)
+	net.nodes[1].down = true
+	net.nodes[0].down = false
+	net.nodes[0].core.electionTimeout()
+	net.run()
+	if net.nodes[0].core.role == leader {
+		t.Fatalf("Expected validator 0, whose log is behind, to lose the election")
+	}
+	net.elect(t, 2)
+}
+
+func TestReplication(t *testing.T) {
+	net := newTestNet(4, 100)
+	net.elect(t, 0)
+	net.propose(t, 0, 5)
+	net.checkChains(t, 5)
+
+	// A minority down does not stop the network
+	net.nodes[3].down = true
+	net.propose(t, 0, 2)
+	net.checkChains(t, 7)
+
+	// The follower catches up once back
+	net.nodes[3].down = false
+	net.nodes[0].core.heartbeat()
+	net.run()
+	net.checkChains(t, 7)
+}
+
+func TestReelection(t *testing.T) {
+	net := newTestNet(3, 100)
+	net.elect(t, 0)
+	net.propose(t, 0, 2)
+
+	// Entries replicated on no majority are dropped by the next leader
+	net.nodes[1].down = true
+	net.nodes[2].down = true
+	net.nodes[0].core.propose([]byte("lost"), nil)
+	net.run()
+	net.nodes[0].down = true
+	net.nodes[1].down = false
+	net.nodes[2].down = false
+	net.elect(t, 1)
+	net.propose(t, 1, 2)
+	net.checkChains(t, 4)
+
+	net.nodes[0].down = false
+	net.nodes[1].core.heartbeat()
+	net.run()
+	net.checkChains(t, 4)
+	if net.nodes[0].core.role != follower || net.nodes[0].core.term != 2 {
+		t.Fatalf("Expected the former leader to follow in term 2, is %s in term %d", net.nodes[0].core.role, net.nodes[0].core.term)
+	}
+}
+
+func TestSnapshotCatchUp(t *testing.T) {
+	net := newTestNet(3, 3)
+	net.elect(t, 0)
+	net.nodes[2].down = true
+	net.propose(t, 0, 8)
+	if net.nodes[0].core.snapshot.Index == 0 {
+		t.Fatalf("Expected the leader to have compacted its log")
+	}
+	if _, ok := net.nodes[0].persist.store[entryKey(1)]; ok {
+		t.Fatalf("Expected the compacted entries to be deleted")
+	}
+
+	net.nodes[2].down = false
+	net.nodes[0].core.heartbeat()
+	net.run()
+	net.checkChains(t, 8)
+	if net.nodes[2].core.applied != net.nodes[0].core.applied {
+		t.Fatalf("Expected the lagging follower to have applied entry %d, applied %d", net.nodes[0].core.applied, net.nodes[2].core.applied)
+	}
+}
+
+func TestRestore(t *testing.T) {
+	net := newTestNet(3, 4)
+	net.elect(t, 0)
+	net.propose(t, 0, 6)
+
+	n := net.nodes[1]
+	core := newRaftCore(1, 3, 4, 2, n, n.persist, n.head())
+	if core.term != n.core.term || core.votedFor != 0 {
+		t.Fatalf("Expected term %d and vote for 0, restored term %d and vote for %d", n.core.term, core.term, core.votedFor)
+	}
+	if core.snapshot.Index != n.core.snapshot.Index || core.lastIndex() != n.core.lastIndex() {
+		t.Fatalf("Expected snapshot %d and last entry %d, restored %d and %d",
+			n.core.snapshot.Index, n.core.lastIndex(), core.snapshot.Index, core.lastIndex())
+	}
+	if core.applied != n.core.applied {
+		t.Fatalf("Expected to have applied entry %d, restored %d", n.core.applied, core.applied)
+	}
+
+	// A validator which crashed installing a snapshot resumes the transfer
+	n.persist.StoreState(snapshotKey, mustMarshal(&Snapshot{Index: 20, Term: 1}))
+	core = newRaftCore(1, 3, 4, 2, n, n.persist, n.head())
+	core.start()
+	if n.transferTo == nil || n.transferTo.Index != 20 {
+		t.Fatalf("Expected the transfer to snapshot 20 to be resumed")
+	}
+	if core.lastIndex() != 20 {
+		t.Fatalf("Expected the entries below the snapshot to be dropped, last entry is %d", core.lastIndex())
+	}
+}
+
+func mustMarshal(msg proto.Message) []byte {
+	raw, err := proto.Marshal(msg)
+	if err != nil {
+		panic(err)
+	}
+	return raw
+}
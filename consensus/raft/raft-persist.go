@@ -0,0 +1,146 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package raft
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/golang/protobuf/proto"
+)
+
+const (
+	termKey        = "raft.term"
+	voteKey        = "raft.vote"
+	snapshotKey    = "raft.snapshot"
+	entryKeyPrefix = "raft.entry."
+)
+
+func entryKey(index uint64) string {
+	return fmt.Sprintf("%s%020d", entryKeyPrefix, index)
+}
+
+func (rc *raftCore) persistTerm() {
+	rc.persist.StoreState(termKey, []byte(strconv.FormatUint(rc.term, 10)))
+	if rc.votedFor == none {
+		rc.persist.DelState(voteKey)
+		return
+	}
+	rc.persist.StoreState(voteKey, []byte(strconv.FormatUint(rc.votedFor, 10)))
+}
+
+func (rc *raftCore) persistEntry(e *Entry) {
+	raw, err := proto.Marshal(e)
+	if err != nil {
+		logger.Warningf("Raft validator %d could not persist entry %d: %s", rc.id, e.Index, err)
+		return
+	}
+	rc.persist.StoreState(entryKey(e.Index), raw)
+}
+
+func (rc *raftCore) persistSnapshot() {
+	raw, err := proto.Marshal(rc.snapshot)
+	if err != nil {
+		logger.Warningf("Raft validator %d could not persist snapshot: %s", rc.id, err)
+		return
+	}
+	rc.persist.StoreState(snapshotKey, raw)
+}
+
+func (rc *raftCore) restoreUint(key string) (uint64, bool) {
+	raw, err := rc.persist.ReadState(key)
+	if err != nil {
+		return 0, false
+	}
+	val, err := strconv.ParseUint(string(raw), 10, 64)
+	if err != nil {
+		logger.Errorf("Raft validator %d could not parse %s - local state is damaged: %s", rc.id, key, err)
+		return 0, false
+	}
+	return val, true
+}
+
+// restore reads the term, vote, snapshot and log persisted, and reconciles
+// them with the chain, whose head block was committed for the entry of head
+func (rc *raftCore) restore(head *Metadata) {
+	rc.term, _ = rc.restoreUint(termKey)
+	if vote, ok := rc.restoreUint(voteKey); ok {
+		rc.votedFor = vote
+	}
+
+	if raw, err := rc.persist.ReadState(snapshotKey); err == nil {
+		snap := &Snapshot{}
+		if err := proto.Unmarshal(raw, snap); err != nil {
+			logger.Errorf("Raft validator %d could not unmarshal snapshot - local state is damaged: %s", rc.id, err)
+		} else {
+			rc.snapshot = snap
+		}
+	}
+
+	entries, err := rc.persist.ReadStateSet(entryKeyPrefix)
+	if err != nil {
+		logger.Debugf("Raft validator %d could not restore entries: %s", rc.id, err)
+	}
+	var keys []string
+	for key := range entries {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		e := &Entry{}
+		if err := proto.Unmarshal(entries[key], e); err != nil {
+			logger.Errorf("Raft validator %d could not unmarshal %s - local state is damaged: %s", rc.id, key, err)
+			break
+		}
+		if e.Index <= rc.snapshot.Index {
+			rc.persist.DelState(key)
+			continue
+		}
+		if e.Index != rc.lastIndex()+1 {
+			logger.Errorf("Raft validator %d found entry %d after entry %d - local state is damaged", rc.id, e.Index, rc.lastIndex())
+			break
+		}
+		rc.log = append(rc.log, e)
+	}
+	for _, key := range keys {
+		if index, err := strconv.ParseUint(strings.TrimPrefix(key, entryKeyPrefix), 10, 64); err == nil && index > rc.lastIndex() {
+			rc.persist.DelState(key)
+		}
+	}
+
+	if head != nil {
+		rc.applied = head.Index
+	}
+	switch {
+	case rc.applied > rc.lastIndex():
+		// The chain is ahead of the log, which was lost
+		rc.log = nil
+		rc.snapshot = &Snapshot{Index: head.Index, Term: head.Term, Info: rc.stack.chainInfo()}
+		rc.persistSnapshot()
+	case rc.applied < rc.snapshot.Index:
+		// We crashed while bringing the chain to the snapshot
+		rc.transferring, rc.transferFrom = rc.snapshot, none
+	}
+	rc.commit = rc.applied
+	if rc.snapshot.Index > rc.commit {
+		rc.commit = rc.snapshot.Index
+	}
+	logger.Infof("Raft validator %d restored term %d, snapshot at entry %d, %d entries, chain at entry %d",
+		rc.id, rc.term, rc.snapshot.Index, len(rc.log), rc.applied)
+}
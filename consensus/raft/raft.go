@@ -0,0 +1,433 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package raft
+
+import (
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/op/go-logging"
+
+	"github.com/hyperledger/fabric/consensus"
+	"github.com/hyperledger/fabric/core/util"
+	pb "github.com/hyperledger/fabric/protos"
+)
+
+var logger *logging.Logger // package-level logger
+
+func init() {
+	logger = logging.MustGetLogger("consensus/raft")
+	consensus.RegisterPlugin("raft", func() consensus.Plugin { return &Raft{} })
+}
+
+// Raft is a plugin ordering transactions with the Raft algorithm. It
+// tolerates the crash of a minority of the validators, but not validators
+// misbehaving, in exchange for fewer messages and validators than PBFT needs.
+//
+// Validators send the transactions they receive to the leader, which batches
+// them into the entries of its log. Every validator applies the committed
+// entries of the log to its chain, one block per entry. Transactions a
+// validator forwarded to a leader which crashed before replicating them are
+// lost, and must be submitted again.
+type Raft struct {
+	stack consensus.Stack
+	core  *raftCore
+
+	id        uint64
+	batchSize int
+	election  time.Duration
+	heartbeat time.Duration
+	batch     time.Duration
+
+	electionTimer  consensus.Timer
+	heartbeatTimer consensus.Timer
+	batchTimer     consensus.Timer
+
+	pending []*pb.Transaction // received, not yet proposed or forwarded to the leader
+
+	events chan func()
+	stop   chan struct{}
+}
+
+// readConfig reads the batch settings and timeouts of the configuration of
+// Raft into r, and returns the settings of the core
+func (r *Raft) readConfig() (N uint64, snapshotInterval, maxAppend int, err error) {
+	config := loadConfig()
+	N = uint64(config.GetInt("general.N"))
+	if N < 1 {
+		return 0, 0, 0, fmt.Errorf("Raft needs at least 1 validator, got %d", N)
+	}
+	snapshotInterval = config.GetInt("general.snapshotinterval")
+	if snapshotInterval < 1 {
+		return 0, 0, 0, fmt.Errorf("Raft snapshot interval must be at least 1, got %d", snapshotInterval)
+	}
+	maxAppend = config.GetInt("general.maxappend")
+	if maxAppend < 1 {
+		return 0, 0, 0, fmt.Errorf("Raft maximum number of entries per message must be at least 1, got %d", maxAppend)
+	}
+	r.batchSize = config.GetInt("general.batchsize")
+	if r.batchSize < 1 {
+		return 0, 0, 0, fmt.Errorf("Raft batch size must be at least 1, got %d", r.batchSize)
+	}
+	if r.election, err = time.ParseDuration(config.GetString("general.timeout.election")); err != nil {
+		return 0, 0, 0, fmt.Errorf("Cannot parse election timeout: %s", err)
+	}
+	if r.heartbeat, err = time.ParseDuration(config.GetString("general.timeout.heartbeat")); err != nil {
+		return 0, 0, 0, fmt.Errorf("Cannot parse heartbeat timeout: %s", err)
+	}
+	if r.batch, err = time.ParseDuration(config.GetString("general.timeout.batch")); err != nil {
+		return 0, 0, 0, fmt.Errorf("Cannot parse batch timeout: %s", err)
+	}
+	if r.heartbeat >= r.election {
+		return 0, 0, 0, fmt.Errorf("Raft heartbeat timeout %s must be below the election timeout %s", r.heartbeat, r.election)
+	}
+	return N, snapshotInterval, maxAppend, nil
+}
+
+// ValidateConfig checks the configuration of Raft before the peer starts
+func (r *Raft) ValidateConfig() error {
+	_, _, _, err := r.readConfig()
+	return err
+}
+
+// Init reads the configuration of Raft, and restores the state the validator
+// of stack persisted
+func (r *Raft) Init(stack consensus.Stack, timers consensus.TimerFactory) error {
+	N, snapshotInterval, maxAppend, err := r.readConfig()
+	if err != nil {
+		return err
+	}
+	handle, _, err := stack.GetNetworkHandles()
+	if err != nil {
+		return err
+	}
+	id, err := getValidatorID(handle)
+	if err != nil {
+		return err
+	}
+	if id >= N {
+		return fmt.Errorf("Raft validator %d is not among the %d validators of the network", id, N)
+	}
+	logger.Infof("Raft validator %d of %d, batch size %d, election timeout %s, heartbeat %s, batch timeout %s",
+		id, N, r.batchSize, r.election, r.heartbeat, r.batch)
+
+	r.stack = stack
+	r.id = id
+	r.events = make(chan func(), 100)
+	r.stop = make(chan struct{})
+	r.electionTimer = timers.NewTimer(func() { r.queue(func() { r.core.electionTimeout() }) })
+	r.heartbeatTimer = timers.NewTimer(func() { r.queue(func() { r.core.heartbeat() }) })
+	r.batchTimer = timers.NewTimer(func() { r.queue(r.batchTimeout) })
+
+	var head *Metadata
+	if raw, err := stack.GetBlockHeadMetadata(); err == nil && len(raw) > 0 {
+		head = &Metadata{}
+		if err := proto.Unmarshal(raw, head); err != nil {
+			return fmt.Errorf("Cannot unmarshal the metadata of the head block: %s", err)
+		}
+	}
+	r.core = newRaftCore(id, N, uint64(snapshotInterval), maxAppend, r, stack, head)
+	return nil
+}
+
+// Start starts processing the messages and events of the validator
+func (r *Raft) Start() error {
+	go r.loop()
+	r.queue(r.core.start)
+	return nil
+}
+
+// Stop stops processing the messages and events of the validator
+func (r *Raft) Stop() {
+	r.electionTimer.Stop()
+	r.heartbeatTimer.Stop()
+	r.batchTimer.Stop()
+	close(r.stop)
+}
+
+func (r *Raft) loop() {
+	for {
+		select {
+		case <-r.stop:
+			return
+		case event := <-r.events:
+			event()
+		}
+	}
+}
+
+// queue has the event loop run event, after the events queued before
+func (r *Raft) queue(event func()) {
+	select {
+	case r.events <- event:
+	case <-r.stop:
+	}
+}
+
+// RecvMsg is called for Message_CHAIN_TRANSACTION and Message_CONSENSUS messages
+func (r *Raft) RecvMsg(msg *pb.Message, senderHandle *pb.PeerID) error {
+	switch msg.Type {
+	case pb.Message_CHAIN_TRANSACTION:
+		tx := &pb.Transaction{}
+		if err := proto.Unmarshal(msg.Payload, tx); err != nil {
+			return fmt.Errorf("Error unmarshalling payload of received Message:%s.", msg.Type)
+		}
+		r.queue(func() { r.addTransactions([]*pb.Transaction{tx}) })
+	case pb.Message_CONSENSUS:
+		from, err := getValidatorID(senderHandle)
+		if err != nil {
+			return err
+		}
+		rmsg := &Message{}
+		if err := proto.Unmarshal(msg.Payload, rmsg); err != nil {
+			return fmt.Errorf("Error unmarshalling Raft message from %s: %s", senderHandle.Name, err)
+		}
+		if rmsg.Type == Message_PROPOSAL {
+			txs := &pb.TransactionBlock{}
+			if err := proto.Unmarshal(rmsg.Payload, txs); err != nil {
+				return fmt.Errorf("Error unmarshalling proposal from %s: %s", senderHandle.Name, err)
+			}
+			r.queue(func() { r.addTransactions(txs.Transactions) })
+			return nil
+		}
+		r.queue(func() { r.core.step(from, rmsg) })
+	default:
+		return fmt.Errorf("Raft cannot handle message of type %s", msg.Type)
+	}
+	return nil
+}
+
+// =============================================================================
+// batching
+// =============================================================================
+
+// addTransactions batches transactions if we lead, or forwards them to the
+// leader
+func (r *Raft) addTransactions(txs []*pb.Transaction) {
+	if len(r.pending) == 0 {
+		r.batchTimer.Reset(r.batch)
+	}
+	r.pending = append(r.pending, txs...)
+	r.flush(false)
+}
+
+func (r *Raft) batchTimeout() {
+	r.flush(true)
+}
+
+// flush proposes the pending transactions, once they fill a batch or due is
+// set, if we lead, or forwards them to the leader if another validator
+// leads. They are held while no leader is known.
+func (r *Raft) flush(due bool) {
+	switch r.core.leader {
+	case none:
+		// Keep the timer armed, should we lead once elected
+		if due {
+			r.batchTimer.Reset(r.batch)
+		}
+		return
+	case r.id:
+		for len(r.pending) >= r.batchSize || (due && len(r.pending) > 0) {
+			n := len(r.pending)
+			if n > r.batchSize {
+				n = r.batchSize
+			}
+			r.propose(r.pending[:n])
+			r.pending = r.pending[n:]
+		}
+	default:
+		if len(r.pending) == 0 {
+			break
+		}
+		payload, err := proto.Marshal(&pb.TransactionBlock{Transactions: r.pending})
+		if err != nil {
+			logger.Errorf("Raft validator %d could not marshal transactions: %s", r.id, err)
+			return
+		}
+		logger.Debugf("Raft validator %d forwarding %d transactions to leader %d", r.id, len(r.pending), r.core.leader)
+		r.send(r.core.leader, &Message{Type: Message_PROPOSAL, Payload: payload})
+		r.pending = nil
+	}
+	if len(r.pending) == 0 {
+		r.batchTimer.Stop()
+	} else if due {
+		r.batchTimer.Reset(r.batch)
+	}
+}
+
+func (r *Raft) propose(txs []*pb.Transaction) {
+	payload, err := proto.Marshal(&pb.TransactionBlock{Transactions: txs})
+	if err != nil {
+		logger.Errorf("Raft validator %d could not marshal transactions: %s", r.id, err)
+		return
+	}
+	if err := r.core.propose(payload, util.CreateUtcTimestamp()); err != nil {
+		logger.Errorf("Raft validator %d could not propose %d transactions: %s", r.id, len(txs), err)
+		return
+	}
+	logger.Debugf("Raft validator %d proposed %d transactions", r.id, len(txs))
+}
+
+// =============================================================================
+// raftStack
+// =============================================================================
+
+func (r *Raft) send(to uint64, msg *Message) {
+	payload, err := proto.Marshal(msg)
+	if err != nil {
+		logger.Errorf("Raft validator %d could not marshal %s: %s", r.id, msg.Type, err)
+		return
+	}
+	if err := r.stack.Unicast(&pb.Message{Type: pb.Message_CONSENSUS, Payload: payload}, getValidatorHandle(to)); err != nil {
+		logger.Debugf("Raft validator %d could not send %s to validator %d: %s", r.id, msg.Type, to, err)
+	}
+}
+
+func (r *Raft) execute(entry *Entry) {
+	txs := &pb.TransactionBlock{}
+	if err := proto.Unmarshal(entry.Payload, txs); err != nil {
+		logger.Panicf("Raft validator %d could not unmarshal the transactions of entry %d: %s", r.id, entry.Index, err)
+	}
+	if clock, ok := r.stack.(consensus.BatchClock); ok {
+		clock.SetBatchTimestamp(entry.Timestamp)
+	}
+	logger.Debugf("Raft validator %d executing entry %d of %d transactions", r.id, entry.Index, len(txs.Transactions))
+	r.stack.Execute(entry, txs.Transactions)
+}
+
+func (r *Raft) transfer(snap *Snapshot, from uint64) {
+	info := &pb.BlockchainInfo{}
+	if err := proto.Unmarshal(snap.Info, info); err != nil {
+		logger.Errorf("Raft validator %d could not unmarshal the chain of snapshot %d: %s", r.id, snap.Index, err)
+		return
+	}
+	var peers []*pb.PeerID
+	if from != none {
+		peers = append(peers, getValidatorHandle(from))
+	} else {
+		for id := uint64(0); id < r.core.N; id++ {
+			if id != r.id {
+				peers = append(peers, getValidatorHandle(id))
+			}
+		}
+	}
+	logger.Infof("Raft validator %d bringing its chain to entry %d, at height %d", r.id, snap.Index, info.Height)
+	r.stack.InvalidateState()
+	r.stack.UpdateState(snap, info, peers)
+}
+
+func (r *Raft) chainInfo() []byte {
+	return r.stack.GetBlockchainInfoBlob()
+}
+
+func (r *Raft) leaderChanged(leader uint64) {
+	if leader != r.id {
+		r.heartbeatTimer.Stop()
+	}
+	r.flush(false)
+}
+
+func (r *Raft) resetElectionTimer() {
+	r.electionTimer.Reset(r.election + time.Duration(rand.Int63n(int64(r.election))))
+}
+
+func (r *Raft) stopElectionTimer() {
+	r.electionTimer.Stop()
+}
+
+func (r *Raft) resetHeartbeatTimer() {
+	r.heartbeatTimer.Reset(r.heartbeat)
+}
+
+func (r *Raft) stopHeartbeatTimer() {
+	r.heartbeatTimer.Stop()
+}
+
+// =============================================================================
+// consensus.ExecutionConsumer
+// =============================================================================
+
+// Executed is called once the transactions of an entry are executed
+func (r *Raft) Executed(tag interface{}) {
+	entry := tag.(*Entry)
+	r.queue(func() {
+		meta, err := proto.Marshal(&Metadata{Index: entry.Index, Term: entry.Term})
+		if err != nil {
+			logger.Panicf("Raft validator %d could not marshal the metadata of entry %d: %s", r.id, entry.Index, err)
+		}
+		r.stack.Commit(entry, meta)
+	})
+}
+
+// Committed is called once the block of an entry is committed
+func (r *Raft) Committed(tag interface{}, target *pb.BlockchainInfo) {
+	entry := tag.(*Entry)
+	r.queue(func() { r.core.entryApplied(entry.Index) })
+}
+
+// RolledBack is not expected, Raft never rolls back
+func (r *Raft) RolledBack(tag interface{}) {
+	logger.Warningf("Raft validator %d was told of an unexpected rollback", r.id)
+}
+
+// StateUpdated is called once the chain is brought to a snapshot, target is
+// nil if the state transfer failed
+func (r *Raft) StateUpdated(tag interface{}, target *pb.BlockchainInfo) {
+	r.queue(func() {
+		if target != nil {
+			r.stack.ValidateState()
+		}
+		r.core.transferred(target != nil)
+	})
+}
+
+// GetStatus returns a snapshot of the state of the validator
+func (r *Raft) GetStatus() interface{} {
+	statusChan := make(chan *Status)
+	r.queue(func() {
+		status := r.core.getStatus()
+		status.Pending = len(r.pending)
+		statusChan <- status
+	})
+	select {
+	case status := <-statusChan:
+		return status
+	case <-r.stop:
+		return nil
+	}
+}
+
+// Returns the uint64 ID corresponding to a peer handle
+func getValidatorID(handle *pb.PeerID) (uint64, error) {
+	if !strings.HasPrefix(handle.Name, "vp") {
+		return 0, fmt.Errorf("Raft validators must be named vpX, where X is a unique integer between 0 and N-1, got \"%s\"", handle.Name)
+	}
+	id, err := strconv.ParseUint(handle.Name[2:], 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("Error extracting ID from \"%s\" handle: %v", handle.Name, err)
+	}
+	return id, nil
+}
+
+// Returns the peer handle that corresponds to a validator ID
+func getValidatorHandle(id uint64) *pb.PeerID {
+	return &pb.PeerID{Name: "vp" + strconv.FormatUint(id, 10)}
+}
@@ -0,0 +1,159 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package replay
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/op/go-logging"
+
+	"github.com/hyperledger/fabric/consensus"
+	"github.com/hyperledger/fabric/core/util"
+	pb "github.com/hyperledger/fabric/protos"
+)
+
+var logger = logging.MustGetLogger("consensus/replay")
+
+// A message trace records the messages delivered to the consensus plugin of a
+// validator, in the order they were delivered, with the time they were
+// received and their sender. Replaying the trace into a fresh instance of the
+// plugin, started from the same persisted state and ledger, delivers it the
+// same messages in the same order, which reproduces what the plugin did in
+// the field. Timers are not part of the trace: replays are only deterministic
+// with timers which do not fire on their own, or with timeouts long enough
+// for the replay to complete first.
+//
+// A trace is a sequence of RecordedMessage, each preceded by its length as a
+// uvarint.
+
+// Recorder appends the messages delivered to a consensus plugin to a trace
+type Recorder struct {
+	lock   sync.Mutex
+	w      *bufio.Writer
+	closer io.Closer
+}
+
+// NewRecorder returns a recorder writing a trace to w
+func NewRecorder(w io.Writer) *Recorder {
+	return &Recorder{w: bufio.NewWriter(w)}
+}
+
+// OpenRecorder returns a recorder appending to the trace at path, which is
+// created if it does not exist
+func OpenRecorder(path string) (*Recorder, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot open message trace %s: %s", path, err)
+	}
+	r := NewRecorder(f)
+	r.closer = f
+	return r, nil
+}
+
+// Record appends msg, received from sender, to the trace. Every record is
+// flushed, so that the trace of a crashed validator is complete.
+func (r *Recorder) Record(msg *pb.Message, sender *pb.PeerID) error {
+	raw, err := proto.Marshal(&pb.RecordedMessage{Timestamp: util.CreateUtcTimestamp(), Sender: sender, Msg: msg})
+	if err != nil {
+		return err
+	}
+	var size [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(size[:], uint64(len(raw)))
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if _, err = r.w.Write(size[:n]); err != nil {
+		return err
+	}
+	if _, err = r.w.Write(raw); err != nil {
+		return err
+	}
+	return r.w.Flush()
+}
+
+// Close flushes the trace, and closes its file if the recorder opened it
+func (r *Recorder) Close() error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	err := r.w.Flush()
+	if r.closer != nil {
+		if cerr := r.closer.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+// Reader reads the messages of a trace in the order they were recorded
+type Reader struct {
+	r *bufio.Reader
+}
+
+// NewReader returns a reader of the trace read from r
+func NewReader(r io.Reader) *Reader {
+	return &Reader{r: bufio.NewReader(r)}
+}
+
+// Next returns the next message of the trace. It returns io.EOF at the end of
+// the trace, and io.ErrUnexpectedEOF if the trace ends within a record, as
+// the trace of a validator which crashed while recording may.
+func (r *Reader) Next() (*pb.RecordedMessage, error) {
+	size, err := binary.ReadUvarint(r.r)
+	if err != nil {
+		if err == io.EOF {
+			return nil, io.EOF
+		}
+		return nil, io.ErrUnexpectedEOF
+	}
+	raw := make([]byte, size)
+	if _, err = io.ReadFull(r.r, raw); err != nil {
+		return nil, io.ErrUnexpectedEOF
+	}
+	rec := &pb.RecordedMessage{}
+	if err = proto.Unmarshal(raw, rec); err != nil {
+		return nil, fmt.Errorf("Cannot unmarshal recorded message: %s", err)
+	}
+	return rec, nil
+}
+
+// Replay delivers the messages of the trace read from r to consenter, in the
+// order they were recorded, and returns the number of messages delivered.
+// Messages the consenter rejects are logged and replayed nonetheless, as they
+// were when recorded.
+func Replay(r io.Reader, consenter consensus.Consenter) (int, error) {
+	reader := NewReader(r)
+	n := 0
+	for {
+		rec, err := reader.Next()
+		if err == io.EOF {
+			return n, nil
+		}
+		if err != nil {
+			return n, err
+		}
+		if err = consenter.RecvMsg(rec.Msg, rec.Sender); err != nil {
+			logger.Warningf("Replayed message %d from %v was rejected: %s", n, rec.Sender, err)
+		}
+		n++
+	}
+}
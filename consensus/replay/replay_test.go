@@ -0,0 +1,87 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package replay
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"reflect"
+	"testing"
+
+	"github.com/hyperledger/fabric/consensus"
+	pb "github.com/hyperledger/fabric/protos"
+)
+
+type recvCollector struct {
+	consensus.Consenter
+	msgs    []*pb.Message
+	senders []*pb.PeerID
+}
+
+func (c *recvCollector) RecvMsg(msg *pb.Message, sender *pb.PeerID) error {
+	c.msgs = append(c.msgs, msg)
+	c.senders = append(c.senders, sender)
+	if len(c.msgs) == 2 {
+		return fmt.Errorf("rejected")
+	}
+	return nil
+}
+
+func TestRecordAndReplay(t *testing.T) {
+	buf := &bytes.Buffer{}
+	r := NewRecorder(buf)
+	var msgs []*pb.Message
+	var senders []*pb.PeerID
+	for i := 0; i < 5; i++ {
+		msg := &pb.Message{Type: pb.Message_CONSENSUS, Payload: []byte(fmt.Sprintf("payload%d", i))}
+		sender := &pb.PeerID{Name: fmt.Sprintf("vp%d", i%4)}
+		if err := r.Record(msg, sender); err != nil {
+			t.Fatalf("Error recording message: %s", err)
+		}
+		msgs = append(msgs, msg)
+		senders = append(senders, sender)
+	}
+	if err := r.Close(); err != nil {
+		t.Fatalf("Error closing recorder: %s", err)
+	}
+	trace := buf.Bytes()
+
+	rec, err := NewReader(bytes.NewReader(trace)).Next()
+	if err != nil {
+		t.Fatalf("Error reading trace: %s", err)
+	}
+	if rec.Timestamp == nil {
+		t.Errorf("Expected the time the message was received to be recorded")
+	}
+
+	c := &recvCollector{}
+	n, err := Replay(bytes.NewReader(trace), c)
+	if err != nil || n != len(msgs) {
+		t.Fatalf("Expected %d messages to be replayed, got %d: %v", len(msgs), n, err)
+	}
+	if !reflect.DeepEqual(c.msgs, msgs) || !reflect.DeepEqual(c.senders, senders) {
+		t.Errorf("Replayed messages differ from the recorded ones: %v from %v", c.msgs, c.senders)
+	}
+
+	// A trace cut within its last record replays up to that record
+	c = &recvCollector{}
+	n, err = Replay(bytes.NewReader(trace[:len(trace)-3]), c)
+	if err != io.ErrUnexpectedEOF || n != len(msgs)-1 {
+		t.Errorf("Expected %d messages to be replayed from a truncated trace, got %d: %v", len(msgs)-1, n, err)
+	}
+}
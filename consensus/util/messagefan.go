@@ -82,3 +82,15 @@ func (fan *MessageFan) RegisterChannel(sender *pb.PeerID, channel <-chan *Messag
 func (fan *MessageFan) GetOutChannel() <-chan *Message {
 	return fan.out
 }
+
+// Depth returns the number of messages queued in the registered channels, and
+// their total capacity
+func (fan *MessageFan) Depth() (depth, capacity int) {
+	fan.lock.Lock()
+	defer fan.lock.Unlock()
+	for _, channel := range fan.ins {
+		depth += len(channel)
+		capacity += cap(channel)
+	}
+	return
+}
@@ -17,8 +17,11 @@ limitations under the License.
 package core
 
 import (
+	"encoding/json"
+	"fmt"
 	"os"
 	"runtime"
+	"time"
 
 	"github.com/op/go-logging"
 	"github.com/spf13/viper"
@@ -26,6 +29,11 @@ import (
 
 	"google/protobuf"
 
+	"github.com/hyperledger/fabric/core/chaincode"
+	"github.com/hyperledger/fabric/core/chaos"
+	"github.com/hyperledger/fabric/core/db"
+	"github.com/hyperledger/fabric/core/ledger"
+	"github.com/hyperledger/fabric/core/profiling"
 	pb "github.com/hyperledger/fabric/protos"
 )
 
@@ -39,6 +47,61 @@ func NewAdminServer() *ServerAdmin {
 
 // ServerAdmin implementation of the Admin service for the Peer
 type ServerAdmin struct {
+	consensusStatus func() (interface{}, error)
+	consensusTrace  func(*pb.ConsensusTraceRequest) (<-chan *pb.ConsensusTraceEvent, func(), error)
+	consensusReplay func(payload []byte, sender string) error
+	reconfigure     func(N int, f int) error
+	catchUp         func(peer string) error
+	stateTransfer   func() *pb.StateTransferProgress
+	denyList        PeerDenyList
+}
+
+// syncStateInterval is the interval at which SyncState reports progress
+const syncStateInterval = time.Second
+
+// PeerDenyList is implemented by the peer, to cut other peers off on the
+// operator's request
+type PeerDenyList interface {
+	DenyPeer(name, reason string) (*pb.DeniedPeers, error)
+	AllowPeer(name string) (*pb.DeniedPeers, error)
+	DeniedPeers() *pb.DeniedPeers
+}
+
+// SetConsensusStatusFunc sets the function reporting the state of the consensus plugin,
+// it is left unset on peers which do not run consensus
+func (s *ServerAdmin) SetConsensusStatusFunc(consensusStatus func() (interface{}, error)) {
+	s.consensusStatus = consensusStatus
+}
+
+// SetConsensusTraceFunc sets the function streaming the messages of the consensus plugin,
+// it is left unset on peers which do not run consensus
+func (s *ServerAdmin) SetConsensusTraceFunc(consensusTrace func(*pb.ConsensusTraceRequest) (<-chan *pb.ConsensusTraceEvent, func(), error)) {
+	s.consensusTrace = consensusTrace
+}
+
+// SetConsensusReplayFunc sets the function replaying messages to the consensus plugin,
+// it is left unset on peers which do not run consensus
+func (s *ServerAdmin) SetConsensusReplayFunc(consensusReplay func(payload []byte, sender string) error) {
+	s.consensusReplay = consensusReplay
+}
+
+// SetConsensusReconfigureFunc sets the function requesting the reconfiguration of the
+// validating network, it is left unset on peers which do not run consensus
+func (s *ServerAdmin) SetConsensusReconfigureFunc(reconfigure func(N int, f int) error) {
+	s.reconfigure = reconfigure
+}
+
+// SetStateTransferFunc sets the functions starting a state transfer to catch up with the
+// network, and reporting its progress, they are left unset on peers which do not run consensus
+func (s *ServerAdmin) SetStateTransferFunc(catchUp func(peer string) error, progress func() *pb.StateTransferProgress) {
+	s.catchUp = catchUp
+	s.stateTransfer = progress
+}
+
+// SetPeerDenyList sets the deny-list of the peer, through which the operator
+// cuts other peers off
+func (s *ServerAdmin) SetPeerDenyList(denyList PeerDenyList) {
+	s.denyList = denyList
 }
 
 func worker(id int, die chan struct{}) {
@@ -55,8 +118,11 @@ func worker(id int, die chan struct{}) {
 }
 
 // GetStatus reports the status of the server
-func (*ServerAdmin) GetStatus(context.Context, *google_protobuf.Empty) (*pb.ServerStatus, error) {
+func (s *ServerAdmin) GetStatus(context.Context, *google_protobuf.Empty) (*pb.ServerStatus, error) {
 	status := &pb.ServerStatus{Status: pb.ServerStatus_STARTED}
+	if s.stateTransfer != nil {
+		status.StateTransfer = s.stateTransfer()
+	}
 	log.Debugf("returning status: %s", status)
 	return status, nil
 }
@@ -79,3 +145,280 @@ func (*ServerAdmin) StopServer(context.Context, *google_protobuf.Empty) (*pb.Ser
 	defer os.Exit(0)
 	return status, nil
 }
+
+// GetStateUsage returns the number of bytes of state held by a chaincode and its quota
+func (*ServerAdmin) GetStateUsage(ctx context.Context, chaincodeID *pb.ChaincodeID) (*pb.StateUsage, error) {
+	ledgerObj, err := ledger.GetLedger()
+	if err != nil {
+		return nil, err
+	}
+	usage, quota, err := ledgerObj.GetStateUsage(chaincodeID.Name)
+	if err != nil {
+		return nil, err
+	}
+	log.Debugf("State usage of chaincode %s: %d bytes (quota %d)", chaincodeID.Name, usage, quota)
+	return &pb.StateUsage{ChaincodeID: chaincodeID.Name, Usage: usage, Quota: quota}, nil
+}
+
+// GetLedgerStatistics returns the key counts, state size and block size distribution of the ledger
+func (*ServerAdmin) GetLedgerStatistics(context.Context, *google_protobuf.Empty) (*pb.LedgerStatistics, error) {
+	ledgerObj, err := ledger.GetLedger()
+	if err != nil {
+		return nil, err
+	}
+	return ledgerObj.GetStatistics()
+}
+
+// CaptureProfile writes a heap or goroutine profile of the peer to its snapshot directory
+func (*ServerAdmin) CaptureProfile(ctx context.Context, req *pb.ProfileRequest) (*pb.ProfileSnapshot, error) {
+	return profiling.Capture(req.Type, profiling.ReasonRequest)
+}
+
+// GetDedupStats returns the dedup window of a chaincode and how often it caught a duplicate
+func (*ServerAdmin) GetDedupStats(ctx context.Context, chaincodeID *pb.ChaincodeID) (*pb.DedupStats, error) {
+	stats := chaincode.GetDedupStats(chaincodeID.Name)
+	log.Debugf("Dedup stats of chaincode %s: %+v", chaincodeID.Name, stats)
+	return &pb.DedupStats{
+		ChaincodeID: chaincodeID.Name,
+		Window:      stats.Window,
+		Policy:      stats.Policy,
+		Lookups:     stats.Lookups,
+		Hits:        stats.Hits,
+	}, nil
+}
+
+// GetChaincodeBreaker returns the breaker of a chaincode, and whether it disables the chaincode
+func (*ServerAdmin) GetChaincodeBreaker(ctx context.Context, chaincodeID *pb.ChaincodeID) (*pb.ChaincodeBreaker, error) {
+	return chaincodeBreaker(chaincodeID.Name), nil
+}
+
+// ResetChaincodeBreaker enables a chaincode disabled by its breaker again
+func (*ServerAdmin) ResetChaincodeBreaker(ctx context.Context, chaincodeID *pb.ChaincodeID) (*pb.ChaincodeBreaker, error) {
+	log.Infof("Resetting the breaker of chaincode %s", chaincodeID.Name)
+	chaincode.ResetBreaker(chaincodeID.Name)
+	return chaincodeBreaker(chaincodeID.Name), nil
+}
+
+func chaincodeBreaker(name string) *pb.ChaincodeBreaker {
+	state := chaincode.GetBreakerState(name)
+	return &pb.ChaincodeBreaker{
+		ChaincodeID: name,
+		Threshold:   state.Threshold,
+		Failures:    state.Failures,
+		Open:        state.Open,
+	}
+}
+
+// GetConsensusStatus returns a JSON snapshot of the internal state of the consensus plugin
+func (s *ServerAdmin) GetConsensusStatus(context.Context, *google_protobuf.Empty) (*pb.ConsensusStatus, error) {
+	if s.consensusStatus == nil {
+		return nil, fmt.Errorf("Consensus status is not available on this peer")
+	}
+	status, err := s.consensusStatus()
+	if err != nil {
+		return nil, err
+	}
+	statusJSON, err := json.Marshal(status)
+	if err != nil {
+		return nil, err
+	}
+	return &pb.ConsensusStatus{
+		Plugin: viper.GetString("peer.validator.consensus.plugin"),
+		Status: string(statusJSON),
+	}, nil
+}
+
+// TraceConsensus streams the consensus messages matching the request until the client goes away
+func (s *ServerAdmin) TraceConsensus(req *pb.ConsensusTraceRequest, stream pb.Admin_TraceConsensusServer) error {
+	if s.consensusTrace == nil {
+		return fmt.Errorf("Consensus tracing is not available on this peer")
+	}
+	events, cancel, err := s.consensusTrace(req)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+	log.Infof("Tracing consensus messages: %s", req)
+	for {
+		select {
+		case ev, ok := <-events:
+			if !ok {
+				return nil
+			}
+			if err := stream.Send(ev); err != nil {
+				return err
+			}
+		case <-stream.Context().Done():
+			log.Infof("Stopped tracing consensus messages: %s", stream.Context().Err())
+			return nil
+		}
+	}
+}
+
+// SyncState starts a state transfer from the requested peer, or any peer, and streams
+// its progress until it completes or the client goes away
+func (s *ServerAdmin) SyncState(req *pb.StateTransferRequest, stream pb.Admin_SyncStateServer) error {
+	if s.catchUp == nil {
+		return fmt.Errorf("State transfer is not available on this peer")
+	}
+	requested := time.Now()
+	if err := s.catchUp(req.Peer); err != nil {
+		return err
+	}
+	log.Infof("Catching up with the network through state transfer from peer %q", req.Peer)
+	ticker := time.NewTicker(syncStateInterval)
+	defer ticker.Stop()
+	// The state transfer may only start once the consensus plugin is done executing,
+	// until then the progress of a previous state transfer is ignored
+	started := false
+	for {
+		select {
+		case <-ticker.C:
+		case <-stream.Context().Done():
+			log.Infof("Stopped reporting the progress of state transfer: %s", stream.Context().Err())
+			return nil
+		}
+		progress := s.stateTransfer()
+		if progress == nil {
+			continue
+		}
+		since := time.Unix(progress.Started.Seconds, int64(progress.Started.Nanos))
+		started = started || progress.InProgress || !since.Before(requested)
+		if !started {
+			continue
+		}
+		if err := stream.Send(progress); err != nil {
+			return err
+		}
+		if !progress.InProgress {
+			if progress.Error != "" {
+				return fmt.Errorf("State transfer failed: %s", progress.Error)
+			}
+			return nil
+		}
+	}
+}
+
+// ReplayConsensus hands a consensus message to the consensus plugin as if sender had sent it
+func (s *ServerAdmin) ReplayConsensus(ctx context.Context, req *pb.ConsensusReplayRequest) (*pb.ConsensusReplayResult, error) {
+	if s.consensusReplay == nil {
+		return nil, fmt.Errorf("Consensus replay is not available on this peer")
+	}
+	log.Infof("Replaying consensus message from %s", req.Sender)
+	if err := s.consensusReplay(req.Payload, req.Sender); err != nil {
+		return &pb.ConsensusReplayResult{Reason: err.Error()}, nil
+	}
+	return &pb.ConsensusReplayResult{Accepted: true}, nil
+}
+
+// ReconfigureConsensus requests the reconfiguration of the validating network
+func (s *ServerAdmin) ReconfigureConsensus(ctx context.Context, req *pb.ConsensusReconfiguration) (*google_protobuf.Empty, error) {
+	if s.reconfigure == nil {
+		return nil, fmt.Errorf("Consensus reconfiguration is not available on this peer")
+	}
+	log.Infof("Requesting the reconfiguration of the validating network to N=%d, f=%d", req.N, req.F)
+	if err := s.reconfigure(int(req.N), int(req.F)); err != nil {
+		return nil, err
+	}
+	return &google_protobuf.Empty{}, nil
+}
+
+// CompactLedger compacts the ledger DB, streaming its progress after each column family
+func (*ServerAdmin) CompactLedger(e *google_protobuf.Empty, stream pb.Admin_CompactLedgerServer) error {
+	start := time.Now()
+	if err := stream.Send(&pb.CompactionProgress{}); err != nil {
+		return err
+	}
+	var sendErr error
+	err := db.GetDBHandle().Compact(func(columnFamily string, done int, total int) {
+		if sendErr != nil {
+			// the client went away, the compaction carries on regardless
+			return
+		}
+		sendErr = stream.Send(&pb.CompactionProgress{
+			ColumnFamily: columnFamily,
+			Done:         uint32(done),
+			Total:        uint32(total),
+			ElapsedMs:    uint64(time.Since(start) / time.Millisecond),
+		})
+	})
+	if err != nil {
+		return err
+	}
+	return sendErr
+}
+
+// SetChaosConfig changes the faults injected by the peer and returns the faults in effect
+func (*ServerAdmin) SetChaosConfig(ctx context.Context, config *pb.ChaosConfig) (*pb.ChaosConfig, error) {
+	err := chaos.SetConfig(chaos.Config{
+		MessageDelay:              time.Duration(config.MessageDelayMs) * time.Millisecond,
+		DropConnectionProbability: config.DropConnectionProbability,
+		CommitDelay:               time.Duration(config.CommitDelayMs) * time.Millisecond,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if config.ForceViewChange {
+		if err = chaos.ForceViewChange(); err != nil {
+			return nil, err
+		}
+	}
+	current := chaos.GetConfig()
+	return &pb.ChaosConfig{
+		MessageDelayMs:            uint64(current.MessageDelay / time.Millisecond),
+		DropConnectionProbability: current.DropConnectionProbability,
+		CommitDelayMs:             uint64(current.CommitDelay / time.Millisecond),
+	}, nil
+}
+
+// PauseSubsystem pauses a subsystem of the peer and returns the subsystems paused
+func (*ServerAdmin) PauseSubsystem(ctx context.Context, subsystem *pb.Subsystem) (*pb.PausedSubsystems, error) {
+	if err := chaos.Pause(chaos.Subsystem(subsystem.Name)); err != nil {
+		return nil, err
+	}
+	return pausedSubsystems(), nil
+}
+
+// ResumeSubsystem resumes a paused subsystem of the peer and returns the subsystems paused
+func (*ServerAdmin) ResumeSubsystem(ctx context.Context, subsystem *pb.Subsystem) (*pb.PausedSubsystems, error) {
+	if err := chaos.Resume(chaos.Subsystem(subsystem.Name)); err != nil {
+		return nil, err
+	}
+	return pausedSubsystems(), nil
+}
+
+func pausedSubsystems() *pb.PausedSubsystems {
+	paused := &pb.PausedSubsystems{}
+	for _, s := range chaos.Paused() {
+		paused.Names = append(paused.Names, string(s))
+	}
+	return paused
+}
+
+// DenyPeer cuts a peer off from this peer until it is allowed again, and
+// returns the peers denied
+func (s *ServerAdmin) DenyPeer(ctx context.Context, peer *pb.DeniedPeer) (*pb.DeniedPeers, error) {
+	if s.denyList == nil {
+		return nil, fmt.Errorf("The deny-list is not available on this peer")
+	}
+	log.Warningf("Denying peer %s: %s", peer.Name, peer.Reason)
+	return s.denyList.DenyPeer(peer.Name, peer.Reason)
+}
+
+// AllowPeer lets a denied peer connect to this peer again, and returns the
+// peers still denied
+func (s *ServerAdmin) AllowPeer(ctx context.Context, peer *pb.DeniedPeer) (*pb.DeniedPeers, error) {
+	if s.denyList == nil {
+		return nil, fmt.Errorf("The deny-list is not available on this peer")
+	}
+	log.Warningf("Allowing peer %s", peer.Name)
+	return s.denyList.AllowPeer(peer.Name)
+}
+
+// GetDeniedPeers returns the peers denied
+func (s *ServerAdmin) GetDeniedPeers(context.Context, *google_protobuf.Empty) (*pb.DeniedPeers, error) {
+	if s.denyList == nil {
+		return nil, fmt.Errorf("The deny-list is not available on this peer")
+	}
+	return s.denyList.DeniedPeers(), nil
+}
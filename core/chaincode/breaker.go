@@ -0,0 +1,140 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package chaincode
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/spf13/viper"
+
+	pb "github.com/hyperledger/fabric/protos"
+)
+
+// A chaincode whose last chaincode.breaker.threshold invokes all failed trips
+// its circuit breaker: the peer rejects further invokes of the chaincode when
+// they are submitted, rather than ordering them only to have them fail again.
+// The breaker closes when the operator resets it, or when the chaincode is
+// deployed again. The failures are counted as the ordered invokes execute, so
+// every validator trips the breaker of a chaincode at the same invoke, but the
+// breaker only acts on the submission of transactions to the local peer, which
+// does not affect the blocks.
+
+// BreakerOpenError is reported for an invoke rejected by the breaker of its chaincode
+type BreakerOpenError struct {
+	ChaincodeName string
+	Failures      uint64
+}
+
+func (e *BreakerOpenError) Error() string {
+	return fmt.Sprintf("Chaincode [%s] is disabled after %d consecutive failed invokes, until it is reset or deployed again", e.ChaincodeName, e.Failures)
+}
+
+// BreakerState holds the consecutive failed invokes of a chaincode, and whether
+// they tripped its breaker
+type BreakerState struct {
+	Threshold uint64
+	Failures  uint64
+	Open      bool
+}
+
+var breakersLock sync.Mutex
+var breakers = make(map[string]*BreakerState)
+
+func breakerThreshold() uint64 {
+	threshold := viper.GetInt("chaincode.breaker.threshold")
+	if threshold < 0 {
+		return 0
+	}
+	return uint64(threshold)
+}
+
+// invokedChaincode returns the name of the chaincode t invokes, "" if t is not
+// an invoke or its chaincode ID is confidential
+func invokedChaincode(t *pb.Transaction) string {
+	if t.Type != pb.Transaction_CHAINCODE_INVOKE {
+		return ""
+	}
+	cID := &pb.ChaincodeID{}
+	if err := proto.Unmarshal(t.ChaincodeID, cID); err != nil {
+		return ""
+	}
+	return cID.Name
+}
+
+// CheckBreaker returns an error if t invokes a chaincode whose breaker is open
+func CheckBreaker(t *pb.Transaction) error {
+	name := invokedChaincode(t)
+	if name == "" {
+		return nil
+	}
+	breakersLock.Lock()
+	defer breakersLock.Unlock()
+	if state, ok := breakers[name]; ok && state.Open {
+		return &BreakerOpenError{name, state.Failures}
+	}
+	return nil
+}
+
+// recordInvoke counts the outcome of an executed invoke of chaincodeName,
+// tripping its breaker once the failures reach the threshold
+func recordInvoke(chaincodeName string, err error) {
+	threshold := breakerThreshold()
+	if threshold == 0 || chaincodeName == "" {
+		return
+	}
+	breakersLock.Lock()
+	defer breakersLock.Unlock()
+	if err == nil {
+		delete(breakers, chaincodeName)
+		return
+	}
+	state, ok := breakers[chaincodeName]
+	if !ok {
+		state = &BreakerState{}
+		breakers[chaincodeName] = state
+	}
+	state.Failures++
+	if !state.Open && state.Failures >= threshold {
+		state.Open = true
+		chaincodeLogger.Warningf("Chaincode [%s] failed %d consecutive invokes, rejecting its invokes until it is reset or deployed again", chaincodeName, state.Failures)
+	}
+}
+
+// GetBreakerState returns the state of the breaker of the given chaincode
+func GetBreakerState(chaincodeName string) BreakerState {
+	breakersLock.Lock()
+	defer breakersLock.Unlock()
+	state := BreakerState{}
+	if s, ok := breakers[chaincodeName]; ok {
+		state = *s
+	}
+	state.Threshold = breakerThreshold()
+	return state
+}
+
+// ResetBreaker closes the breaker of the given chaincode, and forgets about its
+// failed invokes
+func ResetBreaker(chaincodeName string) {
+	breakersLock.Lock()
+	defer breakersLock.Unlock()
+	if s, ok := breakers[chaincodeName]; ok && s.Open {
+		chaincodeLogger.Infof("Breaker of chaincode [%s] reset", chaincodeName)
+	}
+	delete(breakers, chaincodeName)
+}
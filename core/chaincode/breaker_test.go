@@ -0,0 +1,66 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package chaincode
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/spf13/viper"
+
+	pb "github.com/hyperledger/fabric/protos"
+)
+
+func TestBreaker(t *testing.T) {
+	viper.Set("chaincode.breaker.threshold", 3)
+	defer viper.Set("chaincode.breaker.threshold", 0)
+	defer ResetBreaker("failing")
+
+	cID, _ := proto.Marshal(&pb.ChaincodeID{Name: "failing"})
+	invoke := &pb.Transaction{Type: pb.Transaction_CHAINCODE_INVOKE, ChaincodeID: cID}
+	query := &pb.Transaction{Type: pb.Transaction_CHAINCODE_QUERY, ChaincodeID: cID}
+
+	// A success in between resets the count of failures
+	failure := errors.New("panic")
+	for _, err := range []error{failure, failure, nil, failure, failure} {
+		recordInvoke("failing", err)
+	}
+	if err := CheckBreaker(invoke); err != nil {
+		t.Fatalf("Expected the breaker to stay closed after 2 consecutive failures, got %s", err)
+	}
+
+	recordInvoke("failing", failure)
+	err := CheckBreaker(invoke)
+	if _, ok := err.(*BreakerOpenError); !ok {
+		t.Fatalf("Expected the breaker to open after 3 consecutive failures, got %v", err)
+	}
+	if err := CheckBreaker(query); err != nil {
+		t.Fatalf("Expected queries not to be rejected, got %s", err)
+	}
+	if state := GetBreakerState("failing"); !state.Open || state.Failures != 3 || state.Threshold != 3 {
+		t.Fatalf("Unexpected breaker state %+v", state)
+	}
+
+	ResetBreaker("failing")
+	if err := CheckBreaker(invoke); err != nil {
+		t.Fatalf("Expected the breaker to close once reset, got %s", err)
+	}
+	if state := GetBreakerState("failing"); state.Open || state.Failures != 0 {
+		t.Fatalf("Unexpected breaker state %+v", state)
+	}
+}
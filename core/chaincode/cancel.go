@@ -0,0 +1,80 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package chaincode
+
+import (
+	"expvar"
+	"fmt"
+
+	"golang.org/x/net/context"
+)
+
+// A query runs within the context of the request of the client, and is
+// abandoned as soon as the client cancels the request or its deadline expires:
+// the peer stops waiting for the chaincode, and stops serving the ledger reads
+// of the chaincode for the query. Transactions are not abandoned once ordered,
+// as every validator must execute them alike.
+
+// Stages at which work is abandoned
+const (
+	// CancelStageSubmit is the submission of a request, before it reaches the chaincode
+	CancelStageSubmit = "submit"
+	// CancelStageQueryQueue is the wait of a query for the chaincode to complete others
+	CancelStageQueryQueue = "query_queue"
+	// CancelStageExecute is the wait for the chaincode to complete a query
+	CancelStageExecute = "execute"
+	// CancelStageLedgerRead is a read of the ledger by the chaincode for an abandoned query
+	CancelStageLedgerRead = "ledger_read"
+)
+
+// cancellations counts the requests abandoned, by stage and by cause, canceled
+// or deadline_exceeded. They are published with expvar, and served under
+// /debug/vars by the profiling server.
+var cancellations = expvar.NewMap("cancellations")
+
+// RecordCancellation counts a request abandoned at stage because of err, the
+// error of its context
+func RecordCancellation(stage string, err error) {
+	cause := "canceled"
+	if err == context.DeadlineExceeded {
+		cause = "deadline_exceeded"
+	}
+	cancellations.Add(stage+"."+cause, 1)
+}
+
+// GetCancellations returns the number of requests abandoned at stage, by cause
+func GetCancellations(stage string) (canceled int64, deadlineExceeded int64) {
+	get := func(key string) int64 {
+		if v, ok := cancellations.Get(key).(*expvar.Int); ok {
+			return v.Value()
+		}
+		return 0
+	}
+	return get(stage + ".canceled"), get(stage + ".deadline_exceeded")
+}
+
+// CheckContext returns an error, and records the cancellation, if the request
+// of ctxt was abandoned
+func CheckContext(ctxt context.Context, stage string) error {
+	select {
+	case <-ctxt.Done():
+		RecordCancellation(stage, ctxt.Err())
+		return fmt.Errorf("Request abandoned: %s", ctxt.Err())
+	default:
+		return nil
+	}
+}
@@ -27,6 +27,7 @@ import (
 	"github.com/spf13/viper"
 	"golang.org/x/net/context"
 
+	"github.com/hyperledger/fabric/core/chaos"
 	"github.com/hyperledger/fabric/core/container"
 	"github.com/hyperledger/fabric/core/container/ccintf"
 	"github.com/hyperledger/fabric/core/crypto"
@@ -116,12 +117,23 @@ func NewChaincodeSupport(chainname ChainName, getPeerEndpoint func() (*pb.PeerEn
 
 	s.ccStartupTimeout = ccstartuptimeout
 
+	//the deadline for Init is enforced on every validator, so it should be identical on all of them
+	s.ccInitTimeout = time.Duration(viper.GetInt("chaincode.inittimeout")) * time.Millisecond
+	if s.ccInitTimeout <= 0 {
+		s.ccInitTimeout = ccstartuptimeout
+	}
+
 	//TODO I'm not sure if this needs to be on a per chain basis... too lowel and just needs to be a global default ?
 	s.chaincodeInstallPath = viper.GetString("chaincode.installpath")
 	if s.chaincodeInstallPath == "" {
 		s.chaincodeInstallPath = chaincodeInstallPathDefault
 	}
 
+	//chaincodes built with a shim speaking an older protocol are refused
+	s.minProtocolVersion = uint32(viper.GetInt("chaincode.minProtocolVersion"))
+
+	s.queryPools = newQueryPools()
+
 	s.peerTLS = viper.GetBool("peer.tls.enabled")
 	if s.peerTLS {
 		s.peerTLSCertFile = viper.GetString("peer.tls.cert.file")
@@ -144,6 +156,7 @@ type ChaincodeSupport struct {
 	runningChaincodes    *runningChaincodes
 	peerAddress          string
 	ccStartupTimeout     time.Duration
+	ccInitTimeout        time.Duration
 	chaincodeInstallPath string
 	userRunsCC           bool
 	secHelper            crypto.Peer
@@ -153,6 +166,8 @@ type ChaincodeSupport struct {
 	peerTLSCertFile      string
 	peerTLSKeyFile       string
 	peerTLSSvrHostOrd    string
+	minProtocolVersion   uint32
+	queryPools           *queryPools
 }
 
 // DuplicateChaincodeHandlerError returned if attempt to register same chaincodeID while a stream already exists.
@@ -470,6 +485,11 @@ func (chaincodeSupport *ChaincodeSupport) Launch(context context.Context, t *pb.
 		if err != nil {
 			return cID, cMsg, fmt.Errorf("failed to unmarshal deployment transactions for %s - %s", chaincode, err)
 		}
+
+		//a deployment transaction which failed was rolled back, the chaincode does not exist
+		if result, err := ledger.GetTransactionResultByUUID(chaincode); err == nil && result.ErrorCode != 0 {
+			return cID, cMsg, fmt.Errorf("deployment transaction for %s failed - %s", chaincode, result.Error)
+		}
 	}
 
 	//from here on : if we launch the container and get an error, we need to stop the container
@@ -485,8 +505,12 @@ func (chaincodeSupport *ChaincodeSupport) Launch(context context.Context, t *pb.
 	}
 
 	if err == nil {
-		//send init (if (f,args)) and wait for ready state
-		err = chaincodeSupport.sendInitOrReady(context, t.Uuid, chaincode, f, initargs, chaincodeSupport.ccStartupTimeout, t, depTx)
+		//send init (if (f,args)) and wait for ready state. Init runs user code so it gets its own deadline
+		timeout := chaincodeSupport.ccStartupTimeout
+		if t.Type == pb.Transaction_CHAINCODE_DEPLOY {
+			timeout = chaincodeSupport.ccInitTimeout
+		}
+		err = chaincodeSupport.sendInitOrReady(context, t.Uuid, chaincode, f, initargs, timeout, t, depTx)
 		if err != nil {
 			chaincodeLogger.Debugf("sending init failed(%s)", err)
 			err = fmt.Errorf("Failed to init chaincode(%s)", err)
@@ -503,6 +527,30 @@ func (chaincodeSupport *ChaincodeSupport) Launch(context context.Context, t *pb.
 	return cID, cMsg, err
 }
 
+// Undeploy stops the chaincode and removes its image. It is used to roll back a
+// deployment transaction which failed, so that no trace of the chaincode is left
+func (chaincodeSupport *ChaincodeSupport) Undeploy(context context.Context, cds *pb.ChaincodeDeploymentSpec) error {
+	err := chaincodeSupport.Stop(context, cds)
+	if err != nil {
+		chaincodeLogger.Debugf("error on stop while undeploying %s(%s)", cds.ChaincodeSpec.ChaincodeID.Name, err)
+	}
+
+	if chaincodeSupport.userRunsCC || cds.ExecEnv == pb.ChaincodeDeploymentSpec_SYSTEM {
+		//no image was built
+		return nil
+	}
+
+	dir := container.DestroyImageReq{CCID: ccintf.CCID{ChaincodeSpec: cds.ChaincodeSpec, NetworkID: chaincodeSupport.peerNetworkID, PeerID: chaincodeSupport.peerID}, Force: true, NoPrune: true}
+
+	vmtype, _ := chaincodeSupport.getVMType(cds)
+
+	_, err = container.VMCProcess(context, vmtype, dir)
+	if err != nil {
+		return fmt.Errorf("Error destroying image: %s", err)
+	}
+	return nil
+}
+
 // getSecHelper returns the security help set from NewChaincodeSupport
 func (chaincodeSupport *ChaincodeSupport) getSecHelper() crypto.Peer {
 	return chaincodeSupport.secHelper
@@ -595,7 +643,28 @@ func createQueryMessage(uuid string, cMsg *pb.ChaincodeInput) (*pb.ChaincodeMess
 	return &pb.ChaincodeMessage{Type: pb.ChaincodeMessage_QUERY, Payload: payload, Uuid: uuid}, nil
 }
 
-// Execute executes a transaction and waits for it to complete until a timeout value.
+// QueryResultSink receives, in order, the chunks of the result of a query which
+// the chaincode streams before completing the query. Returning an error aborts
+// the query.
+type QueryResultSink func(chunk []byte) error
+
+type queryResultSinkKey struct{}
+
+// WithQueryResultSink returns a context in which the result of a query is
+// streamed to sink as the chaincode emits it. The payload of the completed query
+// only holds the part of the result emitted last.
+func WithQueryResultSink(ctxt context.Context, sink QueryResultSink) context.Context {
+	return context.WithValue(ctxt, queryResultSinkKey{}, sink)
+}
+
+func queryResultSinkFrom(ctxt context.Context) QueryResultSink {
+	sink, _ := ctxt.Value(queryResultSinkKey{}).(QueryResultSink)
+	return sink
+}
+
+// Execute executes a transaction and waits for it to complete until a timeout value,
+// or until ctxt is done. The timeout is restarted whenever a streamed query
+// delivers a chunk of its result.
 func (chaincodeSupport *ChaincodeSupport) Execute(ctxt context.Context, chaincode string, msg *pb.ChaincodeMessage, timeout time.Duration, tx *pb.Transaction) (*pb.ChaincodeMessage, error) {
 	chaincodeSupport.runningChaincodes.Lock()
 	//we expect the chaincode to be running... sanity check
@@ -607,19 +676,53 @@ func (chaincodeSupport *ChaincodeSupport) Execute(ctxt context.Context, chaincod
 	}
 	chaincodeSupport.runningChaincodes.Unlock()
 
-	var notfy chan *pb.ChaincodeMessage
-	var err error
-	if notfy, err = chrte.handler.sendExecuteMessage(msg, tx); err != nil {
+	if !chaos.WaitResumed(chaos.ChaincodeSupport, ctxt.Done()) {
+		return nil, fmt.Errorf("Cannot execute transaction or query for %s: %s", chaincode, ctxt.Err())
+	}
+
+	var sink QueryResultSink
+	if msg.Type == pb.ChaincodeMessage_QUERY {
+		sink = queryResultSinkFrom(ctxt)
+		release, err := chaincodeSupport.queryPools.acquire(ctxt, chaincode, timeout)
+		if err != nil {
+			return nil, err
+		}
+		defer release()
+	}
+
+	var sim *simulation
+	if msg.Type == pb.ChaincodeMessage_TRANSACTION {
+		sim = simulationFrom(ctxt)
+		chrte.handler.txGate.enter(sim != nil)
+		defer chrte.handler.txGate.leave(sim != nil)
+	}
+
+	txctx, err := chrte.handler.sendExecuteMessage(msg, tx, sink, sim)
+	if err != nil {
 		return nil, fmt.Errorf("Error sending %s: %s", msg.Type.String(), err)
 	}
 	var ccresp *pb.ChaincodeMessage
-	select {
-	case ccresp = <-notfy:
-		//response is sent to user or calling chaincode. ChaincodeMessage_ERROR and ChaincodeMessage_QUERY_ERROR
-		//are typically treated as error
-	case <-time.After(timeout):
-		err = fmt.Errorf("Timeout expired while executing transaction")
+	timer := time.NewTimer(timeout)
+	for ccresp == nil && err == nil {
+		select {
+		case ccresp = <-txctx.responseNotifier:
+			//response is sent to user or calling chaincode. ChaincodeMessage_ERROR and ChaincodeMessage_QUERY_ERROR
+			//are typically treated as error
+		case <-txctx.progress:
+			if !timer.Stop() {
+				<-timer.C
+			}
+			timer.Reset(timeout)
+		case <-timer.C:
+			err = fmt.Errorf("Timeout expired while executing transaction")
+		case <-ctxt.Done():
+			// The caller gave up, stop waiting. The chaincode is refused the
+			// ledger reads it makes for the transaction from now on
+			RecordCancellation(CancelStageExecute, ctxt.Err())
+			err = fmt.Errorf("Transaction abandoned: %s", ctxt.Err())
+		}
 	}
+	timer.Stop()
 
 	//our responsibility to delete transaction context if sendExecuteMessage succeeded
 	chrte.handler.deleteTxContext(msg.Uuid)
@@ -0,0 +1,146 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package chaincode
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"golang.org/x/net/context"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/core/container/inproccontroller"
+	"github.com/hyperledger/fabric/core/ledger"
+	pb "github.com/hyperledger/fabric/protos"
+)
+
+func TestChaincodeInitTimeout(t *testing.T) {
+	getPeerEndpoint := func() (*pb.PeerEndpoint, error) {
+		return &pb.PeerEndpoint{ID: &pb.PeerID{Name: "testpeer"}, Address: "0.0.0.0:30303"}, nil
+	}
+	chain := ChainName("inittimeout")
+	defer delete(chains, chain)
+	defer viper.Set("chaincode.inittimeout", viper.Get("chaincode.inittimeout"))
+
+	viper.Set("chaincode.inittimeout", 2500)
+	if s := NewChaincodeSupport(chain, getPeerEndpoint, false, time.Second, nil); s.ccInitTimeout != 2500*time.Millisecond {
+		t.Fatalf("Expected an init timeout of 2.5s, got %v", s.ccInitTimeout)
+	}
+
+	viper.Set("chaincode.inittimeout", 0)
+	if s := NewChaincodeSupport(chain, getPeerEndpoint, false, time.Second, nil); s.ccInitTimeout != time.Second {
+		t.Fatalf("Expected the init timeout to default to the startup timeout, got %v", s.ccInitTimeout)
+	}
+}
+
+// blockingInitCC writes a key in Init, then blocks in the Init of its first
+// deployment
+type blockingInitCC struct {
+	sync.Mutex
+	inits int
+}
+
+func (cc *blockingInitCC) Init(stub *shim.ChaincodeStub, function string, args []string) ([]byte, error) {
+	if err := stub.PutState("initialized", []byte("yes")); err != nil {
+		return nil, err
+	}
+	cc.Lock()
+	cc.inits++
+	first := cc.inits == 1
+	cc.Unlock()
+	if first {
+		// never released, the chaincode is stopped underneath
+		select {}
+	}
+	return nil, nil
+}
+
+func (cc *blockingInitCC) Invoke(stub *shim.ChaincodeStub, function string, args []string) ([]byte, error) {
+	return nil, errors.New("Not supported")
+}
+
+func (cc *blockingInitCC) Query(stub *shim.ChaincodeStub, function string, args []string) ([]byte, error) {
+	return nil, errors.New("Not supported")
+}
+
+func TestChaincodeInitBlocks(t *testing.T) {
+	viper.Set("peer.fileSystemPath", "/var/hyperledger/test/tmpdb")
+	getPeerEndpoint := func() (*pb.PeerEndpoint, error) {
+		return &pb.PeerEndpoint{ID: &pb.PeerID{Name: "testpeer"}, Address: "0.0.0.0:21213"}, nil
+	}
+	chainName := ChainName("initblocks")
+	defer delete(chains, chainName)
+	defer viper.Set("chaincode.inittimeout", viper.Get("chaincode.inittimeout"))
+	viper.Set("chaincode.inittimeout", 500)
+	chain := NewChaincodeSupport(chainName, getPeerEndpoint, false, 5*time.Second, nil)
+
+	path := "github.com/hyperledger/fabric/core/chaincode/blockinginit"
+	if err := inproccontroller.Register(path, &blockingInitCC{}); err != nil {
+		t.Fatalf("Error registering chaincode: %s", err)
+	}
+	cds := &pb.ChaincodeDeploymentSpec{
+		ExecEnv:       pb.ChaincodeDeploymentSpec_SYSTEM,
+		ChaincodeSpec: &pb.ChaincodeSpec{Type: pb.ChaincodeSpec_GOLANG, ChaincodeID: &pb.ChaincodeID{Name: "blockinginit", Path: path}, CtorMsg: &pb.ChaincodeInput{Function: "init"}},
+	}
+	ledger, err := ledger.GetLedger()
+	if err != nil {
+		t.Fatalf("Error getting ledger: %s", err)
+	}
+
+	tx, err := pb.NewChaincodeDeployTransaction(cds, "blockinginit")
+	if err != nil {
+		t.Fatalf("Error creating deploy transaction: %s", err)
+	}
+	ledger.BeginTxBatch("1")
+	start := time.Now()
+	if _, _, err = Execute(context.Background(), chain, tx); err == nil {
+		t.Fatal("Expected the deployment of a chaincode blocking in Init to fail")
+	}
+	if elapsed := time.Since(start); elapsed >= chain.ccStartupTimeout {
+		t.Fatalf("Expected Init to time out after %v, took %v", chain.ccInitTimeout, elapsed)
+	}
+
+	// the state written by Init is discarded, and the chaincode undeployed
+	if value, err := ledger.GetState("blockinginit", "initialized", false); err != nil || value != nil {
+		t.Fatalf("Expected the state written by Init to be discarded, got %s (%v)", value, err)
+	}
+	ledger.RollbackTxBatch("1")
+	chain.runningChaincodes.Lock()
+	_, running := chain.chaincodeHasBeenLaunched("blockinginit")
+	chain.runningChaincodes.Unlock()
+	if running {
+		t.Fatal("Expected the chaincode to be undeployed")
+	}
+
+	// nothing is left in the way of deploying the chaincode again
+	tx, err = pb.NewChaincodeDeployTransaction(cds, "blockinginit")
+	if err != nil {
+		t.Fatalf("Error creating deploy transaction: %s", err)
+	}
+	ledger.BeginTxBatch("2")
+	if _, _, err = Execute(context.Background(), chain, tx); err != nil {
+		t.Fatalf("Error deploying the chaincode again: %s", err)
+	}
+	if value, err := ledger.GetState("blockinginit", "initialized", false); err != nil || string(value) != "yes" {
+		t.Fatalf("Expected the state written by Init, got %s (%v)", value, err)
+	}
+	ledger.CommitTxBatch("2", []*pb.Transaction{tx}, nil, nil)
+	chain.Stop(context.Background(), cds)
+}
@@ -0,0 +1,189 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package chaincode
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/spf13/cast"
+	"github.com/spf13/viper"
+
+	"github.com/hyperledger/fabric/core/ledger"
+	pb "github.com/hyperledger/fabric/protos"
+)
+
+// Policies applied to an invoke transaction whose uuid was already executed
+// within the dedup window of its chaincode
+const (
+	// DedupPolicyReply skips the execution and reports the outcome of the original transaction
+	DedupPolicyReply = "reply"
+	// DedupPolicyReject skips the execution and fails the transaction
+	DedupPolicyReject = "reject"
+)
+
+// DuplicateTransactionError is reported for a transaction rejected by the dedup window
+type DuplicateTransactionError struct {
+	UUID        string
+	BlockNumber uint64
+}
+
+func (e *DuplicateTransactionError) Error() string {
+	return fmt.Sprintf("Duplicate transaction [%s], already executed in block [%d]", e.UUID, e.BlockNumber)
+}
+
+// DedupConfig is the dedup window of a chaincode. Window is the number of blocks
+// during which a committed transaction is remembered, zero disables the dedup
+type DedupConfig struct {
+	Window uint64
+	Policy string
+}
+
+// DedupStats holds the dedup configuration of a chaincode along with the number of
+// transactions that were checked against the window and found to be duplicates
+type DedupStats struct {
+	DedupConfig
+	Lookups uint64
+	Hits    uint64
+}
+
+var loadDedupConfigOnce sync.Once
+var defaultDedupConfig DedupConfig
+var dedupConfigs map[string]DedupConfig
+
+var dedupStatsLock sync.Mutex
+var dedupStats = make(map[string]*DedupStats)
+
+func loadDedupConfig() {
+	values := viper.GetStringMap("chaincode.dedup")
+	defaultDedupConfig = readDedupConfig("default", values, DedupConfig{Policy: DedupPolicyReply})
+	dedupConfigs = make(map[string]DedupConfig)
+	for chaincodeName, override := range cast.ToStringMap(values["chaincodes"]) {
+		dedupConfigs[chaincodeName] = readDedupConfig(chaincodeName, cast.ToStringMap(override), defaultDedupConfig)
+	}
+	chaincodeLogger.Infof("Dedup configuration loaded. default=%+v, overrides=%+v", defaultDedupConfig, dedupConfigs)
+}
+
+func readDedupConfig(name string, values map[string]interface{}, defaults DedupConfig) DedupConfig {
+	config := defaults
+	if value, ok := values["window"]; ok && value != nil {
+		window := cast.ToInt(value)
+		if window < 0 {
+			panic(fmt.Errorf("Dedup window of [%s] must be greater than or equal to 0. Current value is %d.", name, window))
+		}
+		config.Window = uint64(window)
+	}
+	if policy := cast.ToString(values["policy"]); policy != "" {
+		if policy != DedupPolicyReply && policy != DedupPolicyReject {
+			panic(fmt.Errorf("Dedup policy of [%s] must be '%s' or '%s'. Current value is '%s'.", name, DedupPolicyReply, DedupPolicyReject, policy))
+		}
+		config.Policy = policy
+	}
+	return config
+}
+
+// GetDedupConfig returns the dedup window configured for the given chaincode
+func GetDedupConfig(chaincodeName string) DedupConfig {
+	loadDedupConfigOnce.Do(loadDedupConfig)
+	if config, ok := dedupConfigs[chaincodeName]; ok {
+		return config
+	}
+	return defaultDedupConfig
+}
+
+// GetDedupStats returns the dedup configuration and the cache hit statistics of the given chaincode
+func GetDedupStats(chaincodeName string) DedupStats {
+	dedupStatsLock.Lock()
+	defer dedupStatsLock.Unlock()
+	stats := DedupStats{DedupConfig: GetDedupConfig(chaincodeName)}
+	if s, ok := dedupStats[chaincodeName]; ok {
+		stats.Lookups, stats.Hits = s.Lookups, s.Hits
+	}
+	return stats
+}
+
+func recordDedupLookup(chaincodeName string, hit bool) {
+	dedupStatsLock.Lock()
+	defer dedupStatsLock.Unlock()
+	stats, ok := dedupStats[chaincodeName]
+	if !ok {
+		stats = &DedupStats{}
+		dedupStats[chaincodeName] = stats
+	}
+	stats.Lookups++
+	if hit {
+		stats.Hits++
+	}
+}
+
+// checkDuplicate returns true if the invoke transaction t was already executed within
+// the dedup window of its chaincode, either in an earlier block or earlier in the
+// current batch (executed maps those uuids to their outcome). The returned error is
+// the outcome to report for t, according to the dedup policy of the chaincode.
+// As the outcome of the check only depends on the ledger and on the configuration,
+// the window and the policy MUST be identical on all validating peers
+func checkDuplicate(lgr *ledger.Ledger, t *pb.Transaction, executed map[string]error) (bool, error) {
+	if t.Type != pb.Transaction_CHAINCODE_INVOKE {
+		return false, nil
+	}
+	// Confidential transactions carry an encrypted chaincode ID, these are not deduplicated
+	cID := &pb.ChaincodeID{}
+	if err := proto.Unmarshal(t.ChaincodeID, cID); err != nil || cID.Name == "" {
+		return false, nil
+	}
+	config := GetDedupConfig(cID.Name)
+	if config.Window == 0 {
+		return false, nil
+	}
+
+	height := lgr.GetBlockchainSize()
+	if txerr, ok := executed[t.Uuid]; ok {
+		recordDedupLookup(cID.Name, true)
+		if config.Policy == DedupPolicyReject {
+			return true, &DuplicateTransactionError{t.Uuid, height}
+		}
+		return true, txerr
+	}
+
+	blockNumber, err := lgr.GetTransactionBlockNumberByUUID(t.Uuid)
+	if err != nil {
+		if err != ledger.ErrResourceNotFound {
+			chaincodeLogger.Warningf("Failed to look up transaction [%s] for dedup: %s", t.Uuid, err)
+		}
+		recordDedupLookup(cID.Name, false)
+		return false, nil
+	}
+	if height-blockNumber > config.Window {
+		recordDedupLookup(cID.Name, false)
+		return false, nil
+	}
+	recordDedupLookup(cID.Name, true)
+	chaincodeLogger.Debugf("Transaction [%s] of chaincode [%s] already executed in block [%d]", t.Uuid, cID.Name, blockNumber)
+	if config.Policy == DedupPolicyReject {
+		return true, &DuplicateTransactionError{t.Uuid, blockNumber}
+	}
+	result, err := lgr.GetTransactionResultByUUID(t.Uuid)
+	if err != nil {
+		return true, &DuplicateTransactionError{t.Uuid, blockNumber}
+	}
+	if result.ErrorCode != 0 {
+		return true, errors.New(result.Error)
+	}
+	return true, nil
+}
@@ -0,0 +1,59 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package chaincode
+
+import (
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func TestDedupConfig(t *testing.T) {
+	viper.Set("chaincode.dedup", map[string]interface{}{
+		"window": 10,
+		"policy": DedupPolicyReply,
+		"chaincodes": map[string]interface{}{
+			"strict":   map[string]interface{}{"window": 100, "policy": DedupPolicyReject},
+			"disabled": map[string]interface{}{"window": 0},
+		},
+	})
+	defer viper.Set("chaincode.dedup", nil)
+	loadDedupConfigOnce.Do(func() {})
+	loadDedupConfig()
+
+	expected := map[string]DedupConfig{
+		"other":    {Window: 10, Policy: DedupPolicyReply},
+		"strict":   {Window: 100, Policy: DedupPolicyReject},
+		"disabled": {Window: 0, Policy: DedupPolicyReply},
+	}
+	for name, config := range expected {
+		if actual := GetDedupConfig(name); actual != config {
+			t.Fatalf("Expected dedup config %+v for chaincode %s, got %+v", config, name, actual)
+		}
+	}
+
+	recordDedupLookup("strict", false)
+	recordDedupLookup("strict", true)
+	recordDedupLookup("strict", true)
+	stats := GetDedupStats("strict")
+	if stats.Lookups != 3 || stats.Hits != 2 || stats.Window != 100 {
+		t.Fatalf("Unexpected dedup stats %+v", stats)
+	}
+	if stats := GetDedupStats("other"); stats.Lookups != 0 || stats.Hits != 0 {
+		t.Fatalf("Unexpected dedup stats %+v", stats)
+	}
+}
@@ -48,7 +48,7 @@ func Execute(ctxt context.Context, chain *ChaincodeSupport, t *pb.Transaction) (
 	}
 
 	if t.Type == pb.Transaction_CHAINCODE_DEPLOY {
-		_, err := chain.Deploy(ctxt, t)
+		cds, err := chain.Deploy(ctxt, t)
 		if err != nil {
 			return nil, nil, fmt.Errorf("Failed to deploy chaincode spec(%s)", err)
 		}
@@ -57,11 +57,27 @@ func Execute(ctxt context.Context, chain *ChaincodeSupport, t *pb.Transaction) (
 		markTxBegin(ledger, t)
 		_, _, err = chain.Launch(ctxt, t)
 		if err != nil {
+			//Init failed or did not complete in time, discard the state it wrote and
+			//remove the chaincode so the deployment is undone on every validator alike
 			markTxFinish(ledger, t, false)
+			if cds != nil {
+				if errIgnore := chain.Undeploy(ctxt, cds); errIgnore != nil {
+					chaincodeLogger.Warningf("Failed to undeploy chaincode %s: %s", cds.ChaincodeSpec.ChaincodeID.Name, errIgnore)
+				}
+			}
 			return nil, nil, fmt.Errorf("%s", err)
 		}
 		markTxFinish(ledger, t, true)
+		if cds != nil {
+			// a new deployment gets a fresh start
+			ResetBreaker(cds.ChaincodeSpec.ChaincodeID.Name)
+		}
 	} else if t.Type == pb.Transaction_CHAINCODE_INVOKE || t.Type == pb.Transaction_CHAINCODE_QUERY {
+		// don't start on work whose caller already gave up
+		if err = CheckContext(ctxt, CancelStageSubmit); err != nil {
+			return nil, nil, err
+		}
+
 		//will launch if necessary (and wait for ready)
 		cID, cMsg, err := chain.Launch(ctxt, t)
 		if err != nil {
@@ -141,14 +157,25 @@ func ExecuteTransactions(ctxt context.Context, cname ChainName, xacts []*pb.Tran
 		// TODO: We should never get here, but otherwise a good reminder to better handle
 		panic(fmt.Sprintf("[ExecuteTransactions]Chain %s not found\n", cname))
 	}
+	var lgr *ledger.Ledger
+	lgr, err = ledger.GetLedger()
 	txerrs = make([]error, len(xacts))
 	ccevents = make([]*pb.ChaincodeEvent, len(xacts))
+	executed := make(map[string]error)
 	for i, t := range xacts {
+		if lgr != nil {
+			if duplicate, txerr := checkDuplicate(lgr, t, executed); duplicate {
+				txerrs[i] = txerr
+				continue
+			}
+		}
 		_, ccevents[i], txerrs[i] = Execute(ctxt, chain, t)
+		if t.Type == pb.Transaction_CHAINCODE_INVOKE {
+			executed[t.Uuid] = txerrs[i]
+			recordInvoke(invokedChaincode(t), txerrs[i])
+		}
 	}
 
-	var lgr *ledger.Ledger
-	lgr, err = ledger.GetLedger()
 	if err == nil {
 		stateHash, err = lgr.GetTempStateHash()
 	}
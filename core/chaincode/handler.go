@@ -25,12 +25,14 @@ import (
 	"github.com/golang/protobuf/proto"
 	ccintf "github.com/hyperledger/fabric/core/container/ccintf"
 	"github.com/hyperledger/fabric/core/crypto"
+	"github.com/hyperledger/fabric/core/crypto/utils"
 	"github.com/hyperledger/fabric/core/ledger/statemgmt"
 	"github.com/hyperledger/fabric/core/util"
 	pb "github.com/hyperledger/fabric/protos"
 	"github.com/looplab/fsm"
 	"github.com/op/go-logging"
 	"golang.org/x/net/context"
+	google_protobuf "google/protobuf"
 
 	"github.com/hyperledger/fabric/core/ledger"
 )
@@ -61,6 +63,18 @@ type transactionContext struct {
 
 	// tracks open iterators used for range queries
 	rangeQueryIteratorMap map[string]statemgmt.RangeScanIterator
+
+	// receives the chunks of a streamed query result, if nil the chunks are
+	// buffered in queryResult and returned with the completed query
+	queryResultSink QueryResultSink
+	queryResult     []byte
+
+	// signalled whenever the chaincode makes progress on a streamed query
+	progress chan struct{}
+
+	// holds the changes of a simulated transaction, which are not put in the
+	// ledger, nil for other transactions
+	simulation *simulation
 }
 
 type nextStateInfo struct {
@@ -77,6 +91,9 @@ type Handler struct {
 	FSM         *fsm.FSM
 	ChaincodeID *pb.ChaincodeID
 
+	// protocol version and capabilities the shim announced when registering
+	shimHandshake *pb.ChaincodeHandshake
+
 	// A copy of decrypted deploy tx this handler manages, no code
 	deployTXSecContext *pb.Transaction
 
@@ -94,6 +111,9 @@ type Handler struct {
 
 	// used to do Send after making sure the state transition is complete
 	nextState chan *nextStateInfo
+
+	// keeps simulations and transactions apart
+	txGate simulationGate
 }
 
 func shortuuid(uuid string) string {
@@ -104,6 +124,17 @@ func shortuuid(uuid string) string {
 }
 
 func (handler *Handler) serialSend(msg *pb.ChaincodeMessage) error {
+	return handler.serialSendReply(msg, pb.ChaincodeMessage_UNDEFINED)
+}
+
+// serialSendReply sends msg, answering a request of the shim of type
+// inReplyTo, translated to the protocol version of the shim
+func (handler *Handler) serialSendReply(msg *pb.ChaincodeMessage, inReplyTo pb.ChaincodeMessage_Type) error {
+	msg, err := handler.toShim(msg, inReplyTo)
+	if err != nil {
+		chaincodeLogger.Errorf("Error sending: %s", err)
+		return err
+	}
 	handler.serialLock.Lock()
 	defer handler.serialLock.Unlock()
 	if err := handler.ChatStream.Send(msg); err != nil {
@@ -113,7 +144,7 @@ func (handler *Handler) serialSend(msg *pb.ChaincodeMessage) error {
 	return nil
 }
 
-func (handler *Handler) createTxContext(uuid string, tx *pb.Transaction) (*transactionContext, error) {
+func (handler *Handler) createTxContext(uuid string, tx *pb.Transaction, sink QueryResultSink) (*transactionContext, error) {
 	if handler.txCtxs == nil {
 		return nil, fmt.Errorf("cannot create notifier for Uuid:%s", uuid)
 	}
@@ -123,7 +154,8 @@ func (handler *Handler) createTxContext(uuid string, tx *pb.Transaction) (*trans
 		return nil, fmt.Errorf("Uuid:%s exists", uuid)
 	}
 	txctx := &transactionContext{transactionSecContext: tx, responseNotifier: make(chan *pb.ChaincodeMessage, 1),
-		rangeQueryIteratorMap: make(map[string]statemgmt.RangeScanIterator)}
+		rangeQueryIteratorMap: make(map[string]statemgmt.RangeScanIterator), queryResultSink: sink,
+		progress: make(chan struct{}, 1)}
 	handler.txCtxs[uuid] = txctx
 	return txctx, nil
 }
@@ -134,6 +166,26 @@ func (handler *Handler) getTxContext(uuid string) *transactionContext {
 	return handler.txCtxs[uuid]
 }
 
+// getSimulation returns the changes of the transaction, if it is simulated
+func (handler *Handler) getSimulation(uuid string) *simulation {
+	if txContext := handler.getTxContext(uuid); txContext != nil {
+		return txContext.simulation
+	}
+	return nil
+}
+
+// abandonedRead returns the error answering a ledger read of the chaincode for
+// a transaction the peer no longer waits for, because its caller abandoned it
+// or it timed out, or nil if the transaction is in progress
+func (handler *Handler) abandonedRead(msg *pb.ChaincodeMessage) *pb.ChaincodeMessage {
+	if handler.getTxContext(msg.Uuid) != nil {
+		return nil
+	}
+	RecordCancellation(CancelStageLedgerRead, context.Canceled)
+	chaincodeLogger.Debugf("[%s]Transaction abandoned, not reading the ledger. Sending %s", shortuuid(msg.Uuid), pb.ChaincodeMessage_ERROR)
+	return &pb.ChaincodeMessage{Type: pb.ChaincodeMessage_ERROR, Payload: []byte("Transaction abandoned"), Uuid: msg.Uuid}
+}
+
 func (handler *Handler) deleteTxContext(uuid string) {
 	handler.Lock()
 	defer handler.Unlock()
@@ -244,6 +296,36 @@ func (handler *Handler) encrypt(uuid string, payload []byte) ([]byte, error) {
 	return handler.encryptOrDecrypt(true, uuid, payload)
 }
 
+// encryptEvent encrypts the payload of a chaincode event under the key of its
+// transaction. The payload is dropped if the transaction carries no key
+func (handler *Handler) encryptEvent(uuid string, payload []byte) ([]byte, error) {
+	secHelper := handler.chaincodeSupport.getSecHelper()
+	if secHelper == nil {
+		return payload, nil
+	}
+
+	txctx := handler.getTxContext(uuid)
+	if txctx == nil {
+		return nil, fmt.Errorf("[%s]No context for uuid %s", shortuuid(uuid), uuid)
+	}
+	if txctx.transactionSecContext == nil {
+		return nil, fmt.Errorf("[%s]transaction context is nil for uuid %s", shortuuid(uuid), uuid)
+	}
+	if txctx.transactionSecContext.ConfidentialityLevel == pb.ConfidentialityLevel_PUBLIC {
+		return payload, nil
+	}
+
+	enc, err := secHelper.GetEventEncryptor(txctx.transactionSecContext)
+	if err == utils.ErrNoEventKey {
+		chaincodeLogger.Warningf("[%s]Dropping the payload of the chaincode event, the transaction carries no event key", shortuuid(uuid))
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error getting event encryptor %s", err)
+	}
+	return enc.Encrypt(payload)
+}
+
 func (handler *Handler) getSecurityBinding(tx *pb.Transaction) ([]byte, error) {
 	secHelper := handler.chaincodeSupport.getSecHelper()
 	if secHelper == nil {
@@ -301,6 +383,10 @@ func (handler *Handler) processStream() error {
 				return err
 			}
 			chaincodeLogger.Debugf("[%s]Received message %s from shim", shortuuid(in.Uuid), in.Type.String())
+			if in, err = handler.fromShim(in); err != nil {
+				chaincodeLogger.Errorf("Error handling chaincode support stream: %s", err)
+				return err
+			}
 			if in.Type.String() == pb.ChaincodeMessage_ERROR.String() {
 				chaincodeLogger.Debugf("Got error: %s", string(in.Payload))
 			}
@@ -481,15 +567,29 @@ func (handler *Handler) beforeRegisterEvent(e *fsm.Event, state string) {
 		e.Cancel(fmt.Errorf("Received unexpected message type"))
 		return
 	}
-	chaincodeID := &pb.ChaincodeID{}
-	err := proto.Unmarshal(msg.Payload, chaincodeID)
+	// Shims predating the handshake register with a ChaincodeID, which
+	// unmarshals as a handshake of protocol version zero
+	hs := &pb.ChaincodeHandshake{}
+	err := proto.Unmarshal(msg.Payload, hs)
 	if err != nil {
 		e.Cancel(fmt.Errorf("Error in received %s, could NOT unmarshal registration info: %s", pb.ChaincodeMessage_REGISTER, err))
 		return
 	}
+	chaincodeID := hs.GetChaincodeID()
+
+	if min := handler.chaincodeSupport.minProtocolVersion; hs.ProtocolVersion < min {
+		err = fmt.Errorf("Chaincode %s speaks shim protocol version %d, this peer requires version %d or later: rebuild the chaincode with a newer shim", chaincodeID.Name, hs.ProtocolVersion, min)
+		chaincodeLogger.Errorf("Refusing registration: %s", err)
+		handler.serialSend(&pb.ChaincodeMessage{Type: pb.ChaincodeMessage_ERROR, Payload: []byte(err.Error())})
+		e.Cancel(err)
+		handler.notifyDuringStartup(false)
+		return
+	}
+	chaincodeLogger.Debugf("Chaincode %s speaks shim protocol version %d with capabilities %v", chaincodeID.Name, hs.ProtocolVersion, hs.Capabilities)
 
 	// Now register with the chaincodeSupport
 	handler.ChaincodeID = chaincodeID
+	handler.shimHandshake = hs
 	err = handler.chaincodeSupport.registerHandler(handler)
 	if err != nil {
 		e.Cancel(err)
@@ -497,8 +597,15 @@ func (handler *Handler) beforeRegisterEvent(e *fsm.Event, state string) {
 		return
 	}
 
+	// Shims predating the handshake ignore the payload of REGISTERED
+	payload, err := proto.Marshal(pb.NewChaincodeHandshake(nil))
+	if err != nil {
+		e.Cancel(fmt.Errorf("Error marshalling %s: %s", pb.ChaincodeMessage_REGISTERED, err))
+		handler.notifyDuringStartup(false)
+		return
+	}
 	chaincodeLogger.Debugf("Got %s for chaincodeID = %s, sending back %s", e.Event, chaincodeID, pb.ChaincodeMessage_REGISTERED)
-	if err := handler.serialSend(&pb.ChaincodeMessage{Type: pb.ChaincodeMessage_REGISTERED}); err != nil {
+	if err := handler.serialSend(&pb.ChaincodeMessage{Type: pb.ChaincodeMessage_REGISTERED, Payload: payload}); err != nil {
 		e.Cancel(fmt.Errorf("Error sending %s: %s", pb.ChaincodeMessage_REGISTERED, err))
 		handler.notifyDuringStartup(false)
 		return
@@ -572,9 +679,13 @@ func (handler *Handler) handleGetState(msg *pb.ChaincodeMessage) {
 		defer func() {
 			handler.deleteUUIDEntry(msg.Uuid)
 			chaincodeLogger.Debugf("[%s]handleGetState serial send %s", shortuuid(serialSendMsg.Uuid), serialSendMsg.Type)
-			handler.serialSend(serialSendMsg)
+			handler.serialSendReply(serialSendMsg, pb.ChaincodeMessage_GET_STATE)
 		}()
 
+		if serialSendMsg = handler.abandonedRead(msg); serialSendMsg != nil {
+			return
+		}
+
 		key := string(msg.Payload)
 		ledgerObj, ledgerErr := ledger.GetLedger()
 		if ledgerErr != nil {
@@ -589,8 +700,19 @@ func (handler *Handler) handleGetState(msg *pb.ChaincodeMessage) {
 		// Invoke ledger to get state
 		chaincodeID := handler.ChaincodeID.Name
 
-		readCommittedState := !handler.getIsTransaction(msg.Uuid)
-		res, err := ledgerObj.GetState(chaincodeID, key, readCommittedState)
+		sim := handler.getSimulation(msg.Uuid)
+		readCommittedState := !handler.getIsTransaction(msg.Uuid) || sim != nil
+		if pb.IsSystemMetadataKey(key) {
+			serialSendMsg = handleGetSystemMetadata(ledgerObj, msg.Uuid, key, readCommittedState)
+			return
+		}
+		var res []byte
+		var err error
+		if sim != nil {
+			res, err = sim.getState(ledgerObj, chaincodeID, key)
+		} else {
+			res, err = ledgerObj.GetState(chaincodeID, key, readCommittedState)
+		}
 		if err != nil {
 			// Send error msg back to chaincode. GetState will not trigger event
 			payload := []byte(err.Error())
@@ -598,14 +720,16 @@ func (handler *Handler) handleGetState(msg *pb.ChaincodeMessage) {
 			serialSendMsg = &pb.ChaincodeMessage{Type: pb.ChaincodeMessage_ERROR, Payload: payload, Uuid: msg.Uuid}
 		} else if res == nil {
 			//The state object being requested does not exist, so don't attempt to decrypt it
-			chaincodeLogger.Debugf("[%s]No state associated with key: %s. Sending %s with an empty payload", shortuuid(msg.Uuid), key, pb.ChaincodeMessage_RESPONSE)
-			serialSendMsg = &pb.ChaincodeMessage{Type: pb.ChaincodeMessage_RESPONSE, Payload: res, Uuid: msg.Uuid}
+			chaincodeLogger.Debugf("[%s]No state associated with key: %s. Sending %s not found", shortuuid(msg.Uuid), key, pb.ChaincodeMessage_RESPONSE)
+			payload, _ := proto.Marshal(&pb.GetStateResponse{})
+			serialSendMsg = &pb.ChaincodeMessage{Type: pb.ChaincodeMessage_RESPONSE, Payload: payload, Uuid: msg.Uuid}
 		} else {
 			// Decrypt the data if the confidential is enabled
 			if res, err = handler.decrypt(msg.Uuid, res); err == nil {
 				// Send response msg back to chaincode. GetState will not trigger event
 				chaincodeLogger.Debugf("[%s]Got state. Sending %s", shortuuid(msg.Uuid), pb.ChaincodeMessage_RESPONSE)
-				serialSendMsg = &pb.ChaincodeMessage{Type: pb.ChaincodeMessage_RESPONSE, Payload: res, Uuid: msg.Uuid}
+				payload, _ := proto.Marshal(&pb.GetStateResponse{Value: res, Found: true})
+				serialSendMsg = &pb.ChaincodeMessage{Type: pb.ChaincodeMessage_RESPONSE, Payload: payload, Uuid: msg.Uuid}
 			} else {
 				// Send err msg back to chaincode.
 				chaincodeLogger.Errorf("[%s]Got error (%s) while decrypting. Sending %s", shortuuid(msg.Uuid), err, pb.ChaincodeMessage_ERROR)
@@ -620,6 +744,22 @@ func (handler *Handler) handleGetState(msg *pb.ChaincodeMessage) {
 
 const maxRangeQueryStateLimit = 100
 
+// maxRangeQueryStatePrefetch caps the number of key-values a chaincode may
+// ask for per range query response
+const maxRangeQueryStatePrefetch = 1000
+
+// rangeQueryStateLimit returns the number of key-values to send per range
+// query response, given the prefetch hint of the chaincode
+func rangeQueryStateLimit(prefetch uint32) uint32 {
+	if prefetch == 0 {
+		return maxRangeQueryStateLimit
+	}
+	if prefetch > maxRangeQueryStatePrefetch {
+		return maxRangeQueryStatePrefetch
+	}
+	return prefetch
+}
+
 // afterRangeQueryState handles a RANGE_QUERY_STATE request from the chaincode.
 func (handler *Handler) afterRangeQueryState(e *fsm.Event, state string) {
 	msg, ok := e.Args[0].(*pb.ChaincodeMessage)
@@ -656,6 +796,10 @@ func (handler *Handler) handleRangeQueryState(msg *pb.ChaincodeMessage) {
 			handler.serialSend(serialSendMsg)
 		}()
 
+		if serialSendMsg = handler.abandonedRead(msg); serialSendMsg != nil {
+			return
+		}
+
 		rangeQueryState := &pb.RangeQueryState{}
 		unmarshalErr := proto.Unmarshal(msg.Payload, rangeQueryState)
 		if unmarshalErr != nil {
@@ -679,7 +823,23 @@ func (handler *Handler) handleRangeQueryState(msg *pb.ChaincodeMessage) {
 		chaincodeID := handler.ChaincodeID.Name
 
 		readCommittedState := !handler.getIsTransaction(msg.Uuid)
-		rangeIter, err := ledger.GetStateRangeScanIterator(chaincodeID, rangeQueryState.StartKey, rangeQueryState.EndKey, readCommittedState)
+		var rangeIter statemgmt.RangeScanIterator
+		var err error
+		if sim := handler.getSimulation(msg.Uuid); sim != nil {
+			if rangeQueryState.Bookmark != "" {
+				err = fmt.Errorf("Range queries cannot be resumed in a simulation")
+			} else {
+				rangeIter, err = sim.getRangeScanIterator(ledger, chaincodeID, rangeQueryState.StartKey, rangeQueryState.EndKey)
+			}
+		} else if rangeQueryState.Bookmark == "" {
+			rangeIter, err = ledger.GetStateRangeScanIterator(chaincodeID, rangeQueryState.StartKey, rangeQueryState.EndKey, readCommittedState)
+		} else {
+			// Resume the scan after the key of the bookmark
+			var lastKey string
+			if lastKey, err = pb.ParseRangeQueryBookmark(rangeQueryState.Bookmark); err == nil {
+				rangeIter, err = ledger.GetStateRangeScanIteratorAfter(chaincodeID, rangeQueryState.StartKey, rangeQueryState.EndKey, lastKey, readCommittedState)
+			}
+		}
 		if err != nil {
 			// Send error msg back to chaincode. GetState will not trigger event
 			payload := []byte(err.Error())
@@ -696,7 +856,8 @@ func (handler *Handler) handleRangeQueryState(msg *pb.ChaincodeMessage) {
 
 		var keysAndValues []*pb.RangeQueryStateKeyValue
 		var i = uint32(0)
-		for ; hasNext && i < maxRangeQueryStateLimit; i++ {
+		limit := rangeQueryStateLimit(rangeQueryState.Prefetch)
+		for ; hasNext && i < limit; i++ {
 			key, value := rangeIter.GetKeyValue()
 			// Decrypt the data if the confidential is enabled
 			decryptedValue, decryptErr := handler.decrypt(msg.Uuid, value)
@@ -776,6 +937,10 @@ func (handler *Handler) handleRangeQueryStateNext(msg *pb.ChaincodeMessage) {
 			handler.serialSend(serialSendMsg)
 		}()
 
+		if serialSendMsg = handler.abandonedRead(msg); serialSendMsg != nil {
+			return
+		}
+
 		rangeQueryStateNext := &pb.RangeQueryStateNext{}
 		unmarshalErr := proto.Unmarshal(msg.Payload, rangeQueryStateNext)
 		if unmarshalErr != nil {
@@ -798,7 +963,8 @@ func (handler *Handler) handleRangeQueryStateNext(msg *pb.ChaincodeMessage) {
 		var keysAndValues []*pb.RangeQueryStateKeyValue
 		var i = uint32(0)
 		hasNext := true
-		for ; hasNext && i < maxRangeQueryStateLimit; i++ {
+		limit := rangeQueryStateLimit(rangeQueryStateNext.Prefetch)
+		for ; hasNext && i < limit; i++ {
 			key, value := rangeIter.GetKeyValue()
 			// Decrypt the data if the confidential is enabled
 			decryptedValue, decryptErr := handler.decrypt(msg.Uuid, value)
@@ -878,6 +1044,10 @@ func (handler *Handler) handleRangeQueryStateClose(msg *pb.ChaincodeMessage) {
 			handler.serialSend(serialSendMsg)
 		}()
 
+		if serialSendMsg = handler.abandonedRead(msg); serialSendMsg != nil {
+			return
+		}
+
 		rangeQueryStateClose := &pb.RangeQueryStateClose{}
 		unmarshalErr := proto.Unmarshal(msg.Payload, rangeQueryStateClose)
 		if unmarshalErr != nil {
@@ -1000,21 +1170,40 @@ func (handler *Handler) enterBusyState(e *fsm.Event, state string) {
 				return
 			}
 
-			var pVal []byte
-			// Encrypt the data if the confidential is enabled
-			if pVal, err = handler.encrypt(msg.Uuid, putStateInfo.Value); err == nil {
-				// Invoke ledger to put state
-				err = ledgerObj.SetState(chaincodeID, putStateInfo.Key, pVal)
+			if pb.IsSystemMetadataKey(putStateInfo.Key) {
+				err = errSystemMetadataReadOnly
+			} else {
+				var pVal []byte
+				// Encrypt the data if the confidential is enabled
+				if pVal, err = handler.encrypt(msg.Uuid, putStateInfo.Value); err == nil {
+					if sim := handler.getSimulation(msg.Uuid); sim != nil {
+						sim.delta.Set(chaincodeID, putStateInfo.Key, pVal, nil)
+					} else {
+						// Invoke ledger to put state
+						err = ledgerObj.SetStateWithTTL(chaincodeID, putStateInfo.Key, pVal, putStateInfo.Ttl)
+					}
+				}
 			}
 		} else if msg.Type.String() == pb.ChaincodeMessage_DEL_STATE.String() {
 			// Invoke ledger to delete state
 			key := string(msg.Payload)
-			err = ledgerObj.DeleteState(chaincodeID, key)
+			if pb.IsSystemMetadataKey(key) {
+				err = errSystemMetadataReadOnly
+			} else if sim := handler.getSimulation(msg.Uuid); sim != nil {
+				sim.delta.Delete(chaincodeID, key, nil)
+			} else {
+				err = ledgerObj.DeleteState(chaincodeID, key)
+			}
 		} else if msg.Type.String() == pb.ChaincodeMessage_INVOKE_CHAINCODE.String() {
 			//check and prohibit C-call-C for CONFIDENTIAL txs
 			if triggerNextStateMsg = handler.canCallChaincode(msg.Uuid); triggerNextStateMsg != nil {
 				return
 			}
+			if handler.getSimulation(msg.Uuid) != nil {
+				payload := []byte("Chaincodes cannot invoke other chaincodes in a simulation")
+				triggerNextStateMsg = &pb.ChaincodeMessage{Type: pb.ChaincodeMessage_ERROR, Payload: payload, Uuid: msg.Uuid}
+				return
+			}
 			chaincodeSpec := &pb.ChaincodeSpec{}
 			unmarshalErr := proto.Unmarshal(msg.Payload, chaincodeSpec)
 			if unmarshalErr != nil {
@@ -1102,8 +1291,8 @@ func (handler *Handler) enterReadyState(e *fsm.Event, state string) {
 	//it is needed by the event system to filter clients by
 	if ok && msg.ChaincodeEvent != nil && msg.ChaincodeEvent.Payload != nil {
 		var err error
-		if msg.Payload, err = handler.encrypt(msg.Uuid, msg.Payload); nil != err {
-			chaincodeLogger.Debug("[%s]Failed to encrypt chaincode event payload", msg.Uuid)
+		if msg.ChaincodeEvent.Payload, err = handler.encryptEvent(msg.Uuid, msg.ChaincodeEvent.Payload); nil != err {
+			chaincodeLogger.Debugf("[%s]Failed to encrypt chaincode event payload", shortuuid(msg.Uuid))
 			msg.Payload = []byte(fmt.Sprintf("Failed to encrypt chaincode event payload %s", err.Error()))
 			msg.Type = pb.ChaincodeMessage_ERROR
 		}
@@ -1219,16 +1408,53 @@ func (handler *Handler) setChaincodeSecurityContext(tx *pb.Transaction, msg *pb.
 		}
 		msg.SecurityContext.TxTimestamp = tx.Timestamp
 	}
+	msg.SecurityContext.BlockTimestamp = getBlockTimestamp(msg.Type)
+	msg.SecurityContext.BlockRandom = getBlockRandom(msg.Type)
 	return nil
 }
 
+// getBlockTimestamp returns the consensus time seen by the chaincode: the
+// time of the transaction-batch being executed for transactions, and the
+// time of the latest block for queries. It is nil if the consensus does not
+// stamp batches.
+func getBlockTimestamp(msgType pb.ChaincodeMessage_Type) *google_protobuf.Timestamp {
+	ledgerObj, err := ledger.GetLedger()
+	if err != nil {
+		chaincodeLogger.Debugf("Failed getting the ledger for the block timestamp: %s", err)
+		return nil
+	}
+	if msgType != pb.ChaincodeMessage_QUERY {
+		return ledgerObj.GetTxBatchTimestamp()
+	}
+	chainTime, err := ledgerObj.GetChainTime()
+	if err != nil {
+		chaincodeLogger.Debugf("Failed getting the time of the chain: %s", err)
+		return nil
+	}
+	return chainTime.Timestamp
+}
+
+// getBlockRandom returns the random value of the transaction-batch being
+// executed, nil for queries or if the consensus gives batches none
+func getBlockRandom(msgType pb.ChaincodeMessage_Type) []byte {
+	if msgType == pb.ChaincodeMessage_QUERY {
+		return nil
+	}
+	ledgerObj, err := ledger.GetLedger()
+	if err != nil {
+		chaincodeLogger.Debugf("Failed getting the ledger for the block random value: %s", err)
+		return nil
+	}
+	return ledgerObj.GetTxBatchRandom()
+}
+
 //if initArgs is set (should be for "deploy" only) move to Init
 //else move to ready
 func (handler *Handler) initOrReady(uuid string, f *string, initArgs []string, tx *pb.Transaction, depTx *pb.Transaction) (chan *pb.ChaincodeMessage, error) {
 	var ccMsg *pb.ChaincodeMessage
 	var send bool
 
-	txctx, funcErr := handler.createTxContext(uuid, tx)
+	txctx, funcErr := handler.createTxContext(uuid, tx, nil)
 	if funcErr != nil {
 		return nil, funcErr
 	}
@@ -1270,6 +1496,73 @@ func (handler *Handler) initOrReady(uuid string, f *string, initArgs []string, t
 	return notfy, nil
 }
 
+// Handles a chunk of the result of a query, which is passed on to the sink of
+// the query if it is streamed and buffered otherwise. The chaincode waits for
+// the response before sending the next chunk, which throttles it to the pace
+// of the client.
+func (handler *Handler) handleQueryResultChunk(msg *pb.ChaincodeMessage) {
+	go func() {
+		// Check if this is the unique request from this chaincode uuid
+		uniqueReq := handler.createUUIDEntry(msg.Uuid)
+		if !uniqueReq {
+			// Drop this request
+			chaincodeLogger.Debugf("[%s]Another request pending for this Uuid. Cannot process.", shortuuid(msg.Uuid))
+			return
+		}
+
+		var serialSendMsg *pb.ChaincodeMessage
+
+		defer func() {
+			handler.deleteUUIDEntry(msg.Uuid)
+			handler.serialSend(serialSendMsg)
+		}()
+
+		txContext := handler.getTxContext(msg.Uuid)
+		if txContext == nil || handler.getIsTransaction(msg.Uuid) {
+			payload := []byte(fmt.Sprintf("Cannot handle %s outside of a query", msg.Type))
+			chaincodeLogger.Debugf("[%s]Not a query in progress. Sending %s", shortuuid(msg.Uuid), pb.ChaincodeMessage_ERROR)
+			serialSendMsg = &pb.ChaincodeMessage{Type: pb.ChaincodeMessage_ERROR, Payload: payload, Uuid: msg.Uuid}
+			return
+		}
+
+		if txContext.queryResultSink == nil {
+			handler.Lock()
+			txContext.queryResult = append(txContext.queryResult, msg.Payload...)
+			handler.Unlock()
+		} else {
+			chunk, err := handler.encrypt(msg.Uuid, msg.Payload)
+			if err == nil {
+				err = txContext.queryResultSink(chunk)
+			}
+			if err != nil {
+				payload := []byte(fmt.Sprintf("Failed to deliver query result: %s", err))
+				chaincodeLogger.Debugf("[%s]Failed to deliver query result chunk (%s). Sending %s", shortuuid(msg.Uuid), err, pb.ChaincodeMessage_ERROR)
+				serialSendMsg = &pb.ChaincodeMessage{Type: pb.ChaincodeMessage_ERROR, Payload: payload, Uuid: msg.Uuid}
+				return
+			}
+		}
+
+		select {
+		case txContext.progress <- struct{}{}:
+		default:
+		}
+		serialSendMsg = &pb.ChaincodeMessage{Type: pb.ChaincodeMessage_RESPONSE, Uuid: msg.Uuid}
+	}()
+}
+
+// takeQueryResult returns and clears the chunks buffered for a query
+func (handler *Handler) takeQueryResult(uuid string) []byte {
+	handler.Lock()
+	defer handler.Unlock()
+	txContext := handler.txCtxs[uuid]
+	if txContext == nil {
+		return nil
+	}
+	buffered := txContext.queryResult
+	txContext.queryResult = nil
+	return buffered
+}
+
 // Handles request to query another chaincode
 func (handler *Handler) handleQueryChaincode(msg *pb.ChaincodeMessage) {
 	go func() {
@@ -1358,6 +1651,10 @@ func (handler *Handler) HandleMessage(msg *pb.ChaincodeMessage) error {
 	if msg.Type == pb.ChaincodeMessage_QUERY_COMPLETED {
 		chaincodeLogger.Debugf("[%s]HandleMessage- QUERY_COMPLETED. Notify", msg.Uuid)
 		handler.deleteIsTransaction(msg.Uuid)
+		// the result of a query which is not streamed includes the chunks sent before completion
+		if buffered := handler.takeQueryResult(msg.Uuid); len(buffered) > 0 {
+			msg.Payload = append(buffered, msg.Payload...)
+		}
 		var err error
 		if msg.Payload, err = handler.encrypt(msg.Uuid, msg.Payload); nil != err {
 			chaincodeLogger.Debugf("[%s]Failed to encrypt query result %s", msg.Uuid, string(msg.Payload))
@@ -1376,6 +1673,11 @@ func (handler *Handler) HandleMessage(msg *pb.ChaincodeMessage) error {
 		chaincodeLogger.Debugf("[%s]HandleMessage- Received request to query another chaincode", msg.Uuid)
 		handler.handleQueryChaincode(msg)
 		return nil
+	} else if msg.Type == pb.ChaincodeMessage_QUERY_RESULT_CHUNK {
+		// Received a chunk of the result of a query in progress
+		chaincodeLogger.Debugf("[%s]HandleMessage- Received query result chunk of size %d", msg.Uuid, len(msg.Payload))
+		handler.handleQueryResultChunk(msg)
+		return nil
 	}
 	if handler.FSM.Cannot(msg.Type.String()) {
 		// Check if this is a request from validator in query context
@@ -1424,11 +1726,12 @@ func filterError(errFromFSMEvent error) error {
 	return nil
 }
 
-func (handler *Handler) sendExecuteMessage(msg *pb.ChaincodeMessage, tx *pb.Transaction) (chan *pb.ChaincodeMessage, error) {
-	txctx, err := handler.createTxContext(msg.Uuid, tx)
+func (handler *Handler) sendExecuteMessage(msg *pb.ChaincodeMessage, tx *pb.Transaction, sink QueryResultSink, sim *simulation) (*transactionContext, error) {
+	txctx, err := handler.createTxContext(msg.Uuid, tx, sink)
 	if err != nil {
 		return nil, err
 	}
+	txctx.simulation = sim
 
 	// Mark UUID as either transaction or query
 	chaincodeLogger.Debugf("[%s]Inside sendExecuteMessage. Message %s", shortuuid(msg.Uuid), msg.Type.String())
@@ -1456,7 +1759,7 @@ func (handler *Handler) sendExecuteMessage(msg *pb.ChaincodeMessage, tx *pb.Tran
 		}
 	}
 
-	return txctx.responseNotifier, nil
+	return txctx, nil
 }
 
 func (handler *Handler) isRunning() bool {
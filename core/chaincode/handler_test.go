@@ -0,0 +1,238 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package chaincode
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	pb "github.com/hyperledger/fabric/protos"
+	"golang.org/x/net/context"
+)
+
+type mockChaincodeStream struct {
+	sent chan *pb.ChaincodeMessage
+}
+
+func (s *mockChaincodeStream) Send(msg *pb.ChaincodeMessage) error {
+	s.sent <- msg
+	return nil
+}
+
+func (s *mockChaincodeStream) Recv() (*pb.ChaincodeMessage, error) {
+	select {}
+}
+
+func (s *mockChaincodeStream) expect(t *testing.T, msgType pb.ChaincodeMessage_Type) *pb.ChaincodeMessage {
+	select {
+	case msg := <-s.sent:
+		if msg.Type != msgType {
+			t.Fatalf("Expected %s to be sent to the chaincode, got %s: %s", msgType, msg.Type, msg.Payload)
+		}
+		return msg
+	case <-time.After(time.Second):
+		t.Fatalf("Expected %s to be sent to the chaincode", msgType)
+	}
+	return nil
+}
+
+func TestQueryResultChunks(t *testing.T) {
+	stream := &mockChaincodeStream{sent: make(chan *pb.ChaincodeMessage, 1)}
+	handler := newChaincodeSupportHandler(&ChaincodeSupport{}, stream)
+	handler.txCtxs = make(map[string]*transactionContext)
+	handler.uuidMap = make(map[string]bool)
+	handler.isTransaction = make(map[string]bool)
+
+	// Streamed query, chunks are passed to the sink as they arrive
+	var streamed []string
+	fail := false
+	txctx, _ := handler.createTxContext("streamed", nil, func(chunk []byte) error {
+		if fail {
+			return fmt.Errorf("client went away")
+		}
+		streamed = append(streamed, string(chunk))
+		return nil
+	})
+	handler.markIsTransaction("streamed", false)
+	for _, chunk := range []string{"a", "b"} {
+		handler.HandleMessage(&pb.ChaincodeMessage{Type: pb.ChaincodeMessage_QUERY_RESULT_CHUNK, Payload: []byte(chunk), Uuid: "streamed"})
+		stream.expect(t, pb.ChaincodeMessage_RESPONSE)
+	}
+	if len(streamed) != 2 || streamed[0] != "a" || streamed[1] != "b" {
+		t.Fatalf("Expected the chunks to be streamed in order, got %v", streamed)
+	}
+	select {
+	case <-txctx.progress:
+	default:
+		t.Errorf("Expected the query to report progress")
+	}
+	fail = true
+	handler.HandleMessage(&pb.ChaincodeMessage{Type: pb.ChaincodeMessage_QUERY_RESULT_CHUNK, Payload: []byte("c"), Uuid: "streamed"})
+	stream.expect(t, pb.ChaincodeMessage_ERROR)
+
+	// Query which is not streamed, chunks are returned with the completed query
+	txctx, _ = handler.createTxContext("buffered", nil, nil)
+	handler.markIsTransaction("buffered", false)
+	for _, chunk := range []string{"a", "b"} {
+		handler.HandleMessage(&pb.ChaincodeMessage{Type: pb.ChaincodeMessage_QUERY_RESULT_CHUNK, Payload: []byte(chunk), Uuid: "buffered"})
+		stream.expect(t, pb.ChaincodeMessage_RESPONSE)
+	}
+	handler.HandleMessage(&pb.ChaincodeMessage{Type: pb.ChaincodeMessage_QUERY_COMPLETED, Payload: []byte("c"), Uuid: "buffered"})
+	if resp := <-txctx.responseNotifier; string(resp.Payload) != "abc" {
+		t.Fatalf("Expected the completed query to hold the whole result, got %s", resp.Payload)
+	}
+
+	// Transactions cannot stream results
+	handler.createTxContext("transaction", nil, nil)
+	handler.markIsTransaction("transaction", true)
+	handler.HandleMessage(&pb.ChaincodeMessage{Type: pb.ChaincodeMessage_QUERY_RESULT_CHUNK, Payload: []byte("a"), Uuid: "transaction"})
+	stream.expect(t, pb.ChaincodeMessage_ERROR)
+}
+
+func TestRegisterHandshake(t *testing.T) {
+	chaincodeSupport := &ChaincodeSupport{runningChaincodes: &runningChaincodes{chaincodeMap: make(map[string]*chaincodeRTEnv)}}
+	register := func(hs proto.Message) (*mockChaincodeStream, *Handler, error) {
+		stream := &mockChaincodeStream{sent: make(chan *pb.ChaincodeMessage, 1)}
+		handler := newChaincodeSupportHandler(chaincodeSupport, stream)
+		payload, _ := proto.Marshal(hs)
+		return stream, handler, handler.HandleMessage(&pb.ChaincodeMessage{Type: pb.ChaincodeMessage_REGISTER, Payload: payload})
+	}
+
+	// Shims predating the handshake register with a ChaincodeID
+	stream, handler, err := register(&pb.ChaincodeID{Name: "old"})
+	if err != nil {
+		t.Fatalf("Expected chaincode with an old shim to register: %s", err)
+	}
+	if handler.ChaincodeID.Name != "old" || handler.shimHandshake.ProtocolVersion != 0 {
+		t.Fatalf("Expected chaincode old of protocol version 0, got %s of version %d", handler.ChaincodeID, handler.shimHandshake.ProtocolVersion)
+	}
+	registered := stream.expect(t, pb.ChaincodeMessage_REGISTERED)
+	peerHandshake := &pb.ChaincodeHandshake{}
+	if err = proto.Unmarshal(registered.Payload, peerHandshake); err != nil {
+		t.Fatalf("Failed to unmarshal the handshake of the peer: %s", err)
+	}
+	if peerHandshake.ProtocolVersion != pb.ChaincodeProtocolVersion || !peerHandshake.HasCapability(pb.ChaincodeCapabilityStateTTL) {
+		t.Fatalf("Expected the peer to announce its protocol version and capabilities, got %v", peerHandshake)
+	}
+
+	_, handler, err = register(pb.NewChaincodeHandshake(&pb.ChaincodeID{Name: "new"}))
+	if err != nil {
+		t.Fatalf("Expected chaincode with a new shim to register: %s", err)
+	}
+	if !handler.shimHandshake.HasCapability(pb.ChaincodeCapabilityQueryResultChunks) {
+		t.Fatalf("Expected the capabilities of the shim to be recorded, got %v", handler.shimHandshake)
+	}
+
+	// Old shims are refused once the peer requires a newer protocol
+	chaincodeSupport.minProtocolVersion = 1
+	stream, _, err = register(&pb.ChaincodeID{Name: "refused"})
+	if err == nil {
+		t.Fatalf("Expected chaincode with an old shim to be refused")
+	}
+	stream.expect(t, pb.ChaincodeMessage_ERROR)
+}
+
+func TestShimSchemaTranslation(t *testing.T) {
+	stream := &mockChaincodeStream{sent: make(chan *pb.ChaincodeMessage, 1)}
+	handler := newChaincodeSupportHandler(&ChaincodeSupport{}, stream)
+	found, _ := proto.Marshal(&pb.GetStateResponse{Value: []byte("value"), Found: true})
+	notFound, _ := proto.Marshal(&pb.GetStateResponse{})
+
+	for _, version := range []uint32{0, 1} {
+		handler.shimHandshake = &pb.ChaincodeHandshake{ProtocolVersion: version}
+		handler.serialSendReply(&pb.ChaincodeMessage{Type: pb.ChaincodeMessage_RESPONSE, Payload: found}, pb.ChaincodeMessage_GET_STATE)
+		if resp := stream.expect(t, pb.ChaincodeMessage_RESPONSE); string(resp.Payload) != "value" {
+			t.Fatalf("Expected a shim of version %d to get the bare value, got %q", version, resp.Payload)
+		}
+		handler.serialSendReply(&pb.ChaincodeMessage{Type: pb.ChaincodeMessage_RESPONSE, Payload: notFound}, pb.ChaincodeMessage_GET_STATE)
+		if resp := stream.expect(t, pb.ChaincodeMessage_RESPONSE); len(resp.Payload) != 0 {
+			t.Fatalf("Expected a shim of version %d to get an empty payload for a missing key, got %q", version, resp.Payload)
+		}
+		// Replies to other requests are left alone
+		handler.serialSendReply(&pb.ChaincodeMessage{Type: pb.ChaincodeMessage_RESPONSE, Payload: found}, pb.ChaincodeMessage_PUT_STATE)
+		if resp := stream.expect(t, pb.ChaincodeMessage_RESPONSE); string(resp.Payload) != string(found) {
+			t.Fatalf("Expected the reply to PUT_STATE to be sent as is, got %q", resp.Payload)
+		}
+	}
+
+	handler.shimHandshake = pb.NewChaincodeHandshake(nil)
+	handler.serialSendReply(&pb.ChaincodeMessage{Type: pb.ChaincodeMessage_RESPONSE, Payload: found}, pb.ChaincodeMessage_GET_STATE)
+	if resp := stream.expect(t, pb.ChaincodeMessage_RESPONSE); string(resp.Payload) != string(found) {
+		t.Fatalf("Expected a shim of the current version to get a GetStateResponse, got %q", resp.Payload)
+	}
+}
+
+func TestAbandonedQuery(t *testing.T) {
+	stream := &mockChaincodeStream{sent: make(chan *pb.ChaincodeMessage, 1)}
+	chaincodeSupport := &ChaincodeSupport{runningChaincodes: &runningChaincodes{chaincodeMap: make(map[string]*chaincodeRTEnv)}}
+	handler := newChaincodeSupportHandler(chaincodeSupport, stream)
+	handler.txCtxs = make(map[string]*transactionContext)
+	handler.uuidMap = make(map[string]bool)
+	handler.isTransaction = make(map[string]bool)
+	chaincodeSupport.runningChaincodes.chaincodeMap["slow"] = &chaincodeRTEnv{handler: handler}
+
+	// A query whose caller already gave up is not started
+	canceled, _ := GetCancellations(CancelStageSubmit)
+	ctxt, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := CheckContext(ctxt, CancelStageSubmit); err == nil {
+		t.Fatalf("Expected a canceled request not to be submitted")
+	}
+	if c, _ := GetCancellations(CancelStageSubmit); c != canceled+1 {
+		t.Fatalf("Expected %d canceled submissions, got %d", canceled+1, c)
+	}
+
+	// The peer stops waiting for the chaincode once the deadline of the query expires
+	_, deadlineExceeded := GetCancellations(CancelStageExecute)
+	ctxt, cancel = context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	done := make(chan error, 1)
+	go func() {
+		_, err := chaincodeSupport.Execute(ctxt, "slow", &pb.ChaincodeMessage{Type: pb.ChaincodeMessage_QUERY, Uuid: "abandoned"}, time.Minute, nil)
+		done <- err
+	}()
+	stream.expect(t, pb.ChaincodeMessage_QUERY)
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Fatalf("Expected the query to be abandoned")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Expected the query to be abandoned once its deadline expired")
+	}
+	if _, d := GetCancellations(CancelStageExecute); d != deadlineExceeded+1 {
+		t.Fatalf("Expected %d executions past their deadline, got %d", deadlineExceeded+1, d)
+	}
+
+	// and refuses the ledger reads the chaincode makes for it
+	reads, _ := GetCancellations(CancelStageLedgerRead)
+	handler.handleGetState(&pb.ChaincodeMessage{Type: pb.ChaincodeMessage_GET_STATE, Payload: []byte("key"), Uuid: "abandoned"})
+	stream.expect(t, pb.ChaincodeMessage_ERROR)
+	if r, _ := GetCancellations(CancelStageLedgerRead); r != reads+1 {
+		t.Fatalf("Expected %d refused ledger reads, got %d", reads+1, r)
+	}
+}
+
+func TestRangeQueryStateLimit(t *testing.T) {
+	for prefetch, expected := range map[uint32]uint32{0: maxRangeQueryStateLimit, 10: 10, 1000: 1000, 5000: maxRangeQueryStatePrefetch} {
+		if limit := rangeQueryStateLimit(prefetch); limit != expected {
+			t.Errorf("Expected a limit of %d for a prefetch of %d, got %d", expected, prefetch, limit)
+		}
+	}
+}
@@ -0,0 +1,217 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package chaincode
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"sync"
+
+	"github.com/golang/protobuf/proto"
+
+	"github.com/hyperledger/fabric/core/ledger"
+	pb "github.com/hyperledger/fabric/protos"
+)
+
+// Argument types which can be declared in a chaincode manifest
+const (
+	ArgTypeString = "string"
+	ArgTypeInt    = "int"
+	ArgTypeUint   = "uint"
+	ArgTypeFloat  = "float"
+	ArgTypeBool   = "bool"
+	ArgTypeBytes  = "bytes"
+	ArgTypeJSON   = "json"
+)
+
+var argValidators = map[string]func(string) error{
+	ArgTypeString: func(string) error { return nil },
+	ArgTypeInt: func(arg string) error {
+		_, err := strconv.ParseInt(arg, 10, 64)
+		return err
+	},
+	ArgTypeUint: func(arg string) error {
+		_, err := strconv.ParseUint(arg, 10, 64)
+		return err
+	},
+	ArgTypeFloat: func(arg string) error {
+		_, err := strconv.ParseFloat(arg, 64)
+		return err
+	},
+	ArgTypeBool: func(arg string) error {
+		_, err := strconv.ParseBool(arg)
+		return err
+	},
+	ArgTypeBytes: func(arg string) error {
+		_, err := base64.StdEncoding.DecodeString(arg)
+		return err
+	},
+	ArgTypeJSON: func(arg string) error {
+		var v interface{}
+		return json.Unmarshal([]byte(arg), &v)
+	},
+}
+
+// InvalidArgumentsError is returned for an invocation which does not match the
+// manifest of its chaincode
+type InvalidArgumentsError struct {
+	Function string
+	Reason   string
+}
+
+func (e *InvalidArgumentsError) Error() string {
+	return fmt.Sprintf("Invalid invocation of function [%s]: %s", e.Function, e.Reason)
+}
+
+// manifests caches the manifest of the chaincodes, which never change once deployed
+var manifests = struct {
+	sync.RWMutex
+	m map[string]*pb.ChaincodeManifest
+}{m: make(map[string]*pb.ChaincodeManifest)}
+
+// CheckManifest verifies that a manifest only declares known argument types
+func CheckManifest(manifest *pb.ChaincodeManifest) error {
+	if manifest == nil {
+		return nil
+	}
+	declared := make(map[string]bool)
+	for _, function := range manifest.Functions {
+		if declared[function.Name] {
+			return fmt.Errorf("Function [%s] is declared more than once in the chaincode manifest", function.Name)
+		}
+		declared[function.Name] = true
+		if function.Variadic && len(function.Args) == 0 {
+			return fmt.Errorf("Variadic function [%s] must declare at least one argument", function.Name)
+		}
+		for _, argType := range function.Args {
+			if _, ok := argValidators[argType]; !ok {
+				return fmt.Errorf("Unknown type [%s] for an argument of function [%s]", argType, function.Name)
+			}
+		}
+	}
+	return nil
+}
+
+// CheckArgs verifies that the function and arguments of input match the manifest.
+// A nil manifest accepts any invocation
+func CheckArgs(manifest *pb.ChaincodeManifest, input *pb.ChaincodeInput) error {
+	if manifest == nil || input == nil {
+		return nil
+	}
+
+	var function *pb.ChaincodeManifest_Function
+	for _, f := range manifest.Functions {
+		if f.Name == input.Function {
+			function = f
+			break
+		}
+	}
+	if function == nil {
+		if manifest.Strict {
+			return &InvalidArgumentsError{input.Function, "function is not declared in the chaincode manifest"}
+		}
+		return nil
+	}
+
+	if function.Variadic {
+		if len(input.Args) < len(function.Args)-1 {
+			return &InvalidArgumentsError{input.Function, fmt.Sprintf("expected at least %d arguments, got %d", len(function.Args)-1, len(input.Args))}
+		}
+	} else if len(input.Args) != len(function.Args) {
+		return &InvalidArgumentsError{input.Function, fmt.Sprintf("expected %d arguments, got %d", len(function.Args), len(input.Args))}
+	}
+
+	for i, arg := range input.Args {
+		argType := function.Args[len(function.Args)-1]
+		if i < len(function.Args) {
+			argType = function.Args[i]
+		}
+		validate, ok := argValidators[argType]
+		if !ok {
+			return &InvalidArgumentsError{input.Function, fmt.Sprintf("unknown type [%s] for argument %d", argType, i)}
+		}
+		if err := validate(arg); err != nil {
+			return &InvalidArgumentsError{input.Function, fmt.Sprintf("argument %d is not a valid %s (%s)", i, argType, err)}
+		}
+	}
+	return nil
+}
+
+// GetManifest returns the manifest declared by the deployment transaction of the
+// chaincode, or nil if it declared none or if the deployment is not known locally
+func GetManifest(chaincodeName string) (*pb.ChaincodeManifest, error) {
+	manifests.RLock()
+	manifest, ok := manifests.m[chaincodeName]
+	manifests.RUnlock()
+	if ok {
+		return manifest, nil
+	}
+
+	lgr, err := ledger.GetLedger()
+	if err != nil {
+		return nil, err
+	}
+	depTx, err := lgr.GetTransactionByUUID(chaincodeName)
+	if err != nil || depTx == nil {
+		// not deployed yet, or this peer does not hold the transaction
+		return nil, nil
+	}
+	if depTx.ConfidentialityLevel != pb.ConfidentialityLevel_PUBLIC {
+		// the deployment spec is encrypted
+		return nil, nil
+	}
+	cds := &pb.ChaincodeDeploymentSpec{}
+	if err = proto.Unmarshal(depTx.Payload, cds); err != nil {
+		return nil, fmt.Errorf("Failed to unmarshal deployment transaction for %s: %s", chaincodeName, err)
+	}
+	manifest = cds.GetChaincodeSpec().GetManifest()
+
+	manifests.Lock()
+	manifests.m[chaincodeName] = manifest
+	manifests.Unlock()
+	return manifest, nil
+}
+
+// ValidateTransactionArgs rejects a transaction whose tags exceed the limits,
+// or a public invoke or query transaction whose arguments do not match the
+// manifest of its chaincode
+func ValidateTransactionArgs(t *pb.Transaction) error {
+	if err := t.CheckTags(); err != nil {
+		return err
+	}
+	if t.Type != pb.Transaction_CHAINCODE_INVOKE && t.Type != pb.Transaction_CHAINCODE_QUERY {
+		return nil
+	}
+	if t.ConfidentialityLevel != pb.ConfidentialityLevel_PUBLIC {
+		return nil
+	}
+	ci := &pb.ChaincodeInvocationSpec{}
+	if err := proto.Unmarshal(t.Payload, ci); err != nil {
+		return fmt.Errorf("Failed to unmarshal invocation spec: %s", err)
+	}
+	spec := ci.GetChaincodeSpec()
+	if spec.GetChaincodeID() == nil {
+		return nil
+	}
+	manifest, err := GetManifest(spec.ChaincodeID.Name)
+	if err != nil {
+		return err
+	}
+	return CheckArgs(manifest, spec.CtorMsg)
+}
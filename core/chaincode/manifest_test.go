@@ -0,0 +1,77 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package chaincode
+
+import (
+	"testing"
+
+	pb "github.com/hyperledger/fabric/protos"
+)
+
+func TestCheckArgs(t *testing.T) {
+	manifest := &pb.ChaincodeManifest{
+		Functions: []*pb.ChaincodeManifest_Function{
+			{Name: "transfer", Args: []string{ArgTypeString, ArgTypeString, ArgTypeUint}},
+			{Name: "tag", Args: []string{ArgTypeBool, ArgTypeJSON}, Variadic: true},
+			{Name: "store", Args: []string{ArgTypeBytes, ArgTypeFloat, ArgTypeInt}},
+		},
+	}
+	if err := CheckManifest(manifest); err != nil {
+		t.Fatalf("Expected manifest to be valid, got %s", err)
+	}
+
+	valid := []*pb.ChaincodeInput{
+		{Function: "transfer", Args: []string{"a", "b", "10"}},
+		{Function: "tag", Args: []string{"true"}},
+		{Function: "tag", Args: []string{"false", `{"a":1}`, `[1,2]`}},
+		{Function: "store", Args: []string{"aGVsbG8=", "1.5", "-3"}},
+		{Function: "undeclared", Args: []string{"anything"}},
+	}
+	for _, input := range valid {
+		if err := CheckArgs(manifest, input); err != nil {
+			t.Errorf("Expected %v to be valid, got %s", input, err)
+		}
+	}
+
+	invalid := []*pb.ChaincodeInput{
+		{Function: "transfer", Args: []string{"a", "b"}},
+		{Function: "transfer", Args: []string{"a", "b", "-10"}},
+		{Function: "tag", Args: []string{}},
+		{Function: "tag", Args: []string{"true", "{"}},
+		{Function: "store", Args: []string{"not base64!", "1.5", "-3"}},
+		{Function: "store", Args: []string{"aGVsbG8=", "one", "-3"}},
+	}
+	for _, input := range invalid {
+		if err := CheckArgs(manifest, input); err == nil {
+			t.Errorf("Expected %v to be invalid", input)
+		} else if _, ok := err.(*InvalidArgumentsError); !ok {
+			t.Errorf("Expected an InvalidArgumentsError, got %T", err)
+		}
+	}
+
+	manifest.Strict = true
+	if err := CheckArgs(manifest, &pb.ChaincodeInput{Function: "undeclared"}); err == nil {
+		t.Errorf("Expected undeclared function to be rejected by a strict manifest")
+	}
+
+	if err := CheckManifest(&pb.ChaincodeManifest{Functions: []*pb.ChaincodeManifest_Function{{Name: "f", Args: []string{"date"}}}}); err == nil {
+		t.Errorf("Expected manifest with an unknown type to be invalid")
+	}
+	if err := CheckManifest(&pb.ChaincodeManifest{Functions: []*pb.ChaincodeManifest_Function{{Name: "f"}, {Name: "f"}}}); err == nil {
+		t.Errorf("Expected manifest declaring a function twice to be invalid")
+	}
+}
@@ -0,0 +1,116 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package chaincode
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/spf13/viper"
+	"golang.org/x/net/context"
+)
+
+// Queries to a chaincode execute concurrently in its container, up to a limit
+// per chaincode, while its transactions keep executing one at a time. The
+// limit is chaincode.query.concurrency, unless the manifest of the chaincode
+// declares its own, which is capped at chaincode.query.maxConcurrency.
+
+const (
+	defaultQueryConcurrency    = 4
+	defaultMaxQueryConcurrency = 32
+)
+
+// queryPools holds the slots of the queries of each chaincode, by chaincode name
+type queryPools struct {
+	sync.Mutex
+	slots          map[string]chan struct{}
+	concurrency    int
+	maxConcurrency int
+}
+
+func newQueryPools() *queryPools {
+	qp := &queryPools{
+		slots:          make(map[string]chan struct{}),
+		concurrency:    viper.GetInt("chaincode.query.concurrency"),
+		maxConcurrency: viper.GetInt("chaincode.query.maxConcurrency"),
+	}
+	if qp.concurrency <= 0 {
+		qp.concurrency = defaultQueryConcurrency
+	}
+	if qp.maxConcurrency <= 0 {
+		qp.maxConcurrency = defaultMaxQueryConcurrency
+	}
+	if qp.maxConcurrency < qp.concurrency {
+		qp.maxConcurrency = qp.concurrency
+	}
+	return qp
+}
+
+// limit returns the number of queries the chaincode may execute concurrently
+func (qp *queryPools) limit(chaincode string) int {
+	manifest, err := GetManifest(chaincode)
+	if err != nil {
+		chaincodeLogger.Warningf("Error getting the manifest of %s, using the default query concurrency: %s", chaincode, err)
+	}
+	if manifest == nil || manifest.QueryConcurrency == 0 {
+		return qp.concurrency
+	}
+	declared := int(manifest.QueryConcurrency)
+	if declared > qp.maxConcurrency {
+		return qp.maxConcurrency
+	}
+	return declared
+}
+
+func (qp *queryPools) get(chaincode string) chan struct{} {
+	qp.Lock()
+	defer qp.Unlock()
+	slots, ok := qp.slots[chaincode]
+	if !ok {
+		limit := qp.limit(chaincode)
+		chaincodeLogger.Debugf("Chaincode %s executes up to %d queries concurrently", chaincode, limit)
+		slots = make(chan struct{}, limit)
+		qp.slots[chaincode] = slots
+	}
+	return slots
+}
+
+// acquire waits for a slot to execute a query on the chaincode, and returns
+// the function releasing it. It gives up after timeout, or once ctxt is done.
+func (qp *queryPools) acquire(ctxt context.Context, chaincode string, timeout time.Duration) (func(), error) {
+	slots := qp.get(chaincode)
+	release := func() { <-slots }
+	select {
+	case slots <- struct{}{}:
+		return release, nil
+	default:
+	}
+
+	chaincodeLogger.Debugf("Chaincode %s executing %d queries already, waiting", chaincode, cap(slots))
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case slots <- struct{}{}:
+		return release, nil
+	case <-timer.C:
+		return nil, fmt.Errorf("Timeout expired while waiting to execute a query on %s", chaincode)
+	case <-ctxt.Done():
+		RecordCancellation(CancelStageQueryQueue, ctxt.Err())
+		return nil, fmt.Errorf("Query abandoned: %s", ctxt.Err())
+	}
+}
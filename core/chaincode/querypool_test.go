@@ -0,0 +1,79 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package chaincode
+
+import (
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+
+	pb "github.com/hyperledger/fabric/protos"
+)
+
+func TestQueryPools(t *testing.T) {
+	qp := &queryPools{slots: make(map[string]chan struct{}), concurrency: 2, maxConcurrency: 3}
+	manifests.Lock()
+	manifests.m["declared"] = &pb.ChaincodeManifest{QueryConcurrency: 1}
+	manifests.m["greedy"] = &pb.ChaincodeManifest{QueryConcurrency: 100}
+	manifests.m["default"] = nil
+	manifests.Unlock()
+
+	for chaincode, limit := range map[string]int{"declared": 1, "greedy": 3, "default": 2} {
+		if actual := qp.limit(chaincode); actual != limit {
+			t.Fatalf("Expected %s to execute up to %d queries, got %d", chaincode, limit, actual)
+		}
+	}
+
+	// The slots of the chaincode are taken, the next query waits
+	var releases []func()
+	for i := 0; i < 2; i++ {
+		release, err := qp.acquire(context.Background(), "default", time.Second)
+		if err != nil {
+			t.Fatalf("Expected query %d to execute right away: %s", i, err)
+		}
+		releases = append(releases, release)
+	}
+	if _, err := qp.acquire(context.Background(), "default", 10*time.Millisecond); err == nil {
+		t.Fatalf("Expected a query over the limit to time out")
+	}
+	ctxt, cancel := context.WithCancel(context.Background())
+	cancel()
+	canceled, _ := GetCancellations(CancelStageQueryQueue)
+	if _, err := qp.acquire(ctxt, "default", time.Second); err == nil {
+		t.Fatalf("Expected an abandoned query to give up its wait")
+	}
+	if after, _ := GetCancellations(CancelStageQueryQueue); after != canceled+1 {
+		t.Fatalf("Expected the abandoned query to be counted")
+	}
+
+	// Other chaincodes are not held up
+	if _, err := qp.acquire(context.Background(), "declared", 10*time.Millisecond); err != nil {
+		t.Fatalf("Expected a query to another chaincode to execute: %s", err)
+	}
+
+	// A completed query frees its slot
+	done := make(chan error)
+	go func() {
+		_, err := qp.acquire(context.Background(), "default", time.Second)
+		done <- err
+	}()
+	releases[0]()
+	if err := <-done; err != nil {
+		t.Fatalf("Expected the waiting query to execute once a slot is freed: %s", err)
+	}
+}
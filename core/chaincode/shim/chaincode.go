@@ -20,6 +20,7 @@ package shim
 
 import (
 	"bytes"
+	"encoding/json"
 	"errors"
 	"flag"
 	"fmt"
@@ -167,9 +168,10 @@ func chatWithPeer(chaincodename string, stream PeerChaincodeStream, cc Chaincode
 	handler = newChaincodeHandler(stream, cc)
 
 	defer stream.CloseSend()
-	// Send the ChaincodeID during register.
+	// Send the ChaincodeID during register, along with the protocol version
+	// and capabilities of the shim.
 	chaincodeID := &pb.ChaincodeID{Name: chaincodename}
-	payload, err := proto.Marshal(chaincodeID)
+	payload, err := proto.Marshal(pb.NewChaincodeHandshake(chaincodeID))
 	if err != nil {
 		return fmt.Errorf("Error marshalling chaincodeID during chaincode registration: %s", err)
 	}
@@ -262,6 +264,17 @@ func (stub *ChaincodeStub) QueryChaincode(chaincodeName string, function string,
 	return handler.handleQueryChaincode(chaincodeName, function, args, stub.UUID)
 }
 
+// SendQueryResult streams a chunk of the result of the current query to the
+// client, so that a large result need not be held in memory while it is being
+// produced. Chunks are delivered in the order they are sent, followed by the
+// value returned from Query, which may then be empty. It blocks until the peer
+// has accepted the chunk, and returns an error once the client has gone away,
+// in which case Query should return. It may only be invoked from Query, and
+// fails if the peer predates streamed query results.
+func (stub *ChaincodeStub) SendQueryResult(chunk []byte) error {
+	return handler.handleQueryResultChunk(chunk, stub.UUID)
+}
+
 // --------- State functions ----------
 
 // GetState returns the byte array value specified by the `key`.
@@ -271,7 +284,16 @@ func (stub *ChaincodeStub) GetState(key string) ([]byte, error) {
 
 // PutState writes the specified `value` and `key` into the ledger.
 func (stub *ChaincodeStub) PutState(key string, value []byte) error {
-	return handler.handlePutState(key, value, stub.UUID)
+	return handler.handlePutState(key, value, 0, stub.UUID)
+}
+
+// PutStateWithTTL writes the specified `value` and `key` into the ledger. The key
+// is deleted automatically once `ttl` more blocks have been added to the chain.
+// The expiry is applied at block boundaries, so every validator removes the key
+// at the same height. Writing or deleting the key again clears the TTL. It fails
+// if the peer predates key expiry.
+func (stub *ChaincodeStub) PutStateWithTTL(key string, value []byte, ttl uint64) error {
+	return handler.handlePutState(key, value, ttl, stub.UUID)
 }
 
 // DelState removes the specified `key` and its value from the ledger.
@@ -318,7 +340,9 @@ type StateRangeQueryIterator struct {
 	handler    *Handler
 	uuid       string
 	response   *pb.RangeQueryStateResponse
+	prefetch   uint32
 	currentLoc int
+	lastKey    *string // Key returned last by Next, nil before the first
 }
 
 // RangeQueryState function can be invoked by a chaincode to query of a range
@@ -327,11 +351,41 @@ type StateRangeQueryIterator struct {
 // between the startKey and endKey, inclusive. The order in which keys are
 // returned by the iterator is random.
 func (stub *ChaincodeStub) RangeQueryState(startKey, endKey string) (*StateRangeQueryIterator, error) {
-	response, err := handler.handleRangeQueryState(startKey, endKey, stub.UUID)
+	return stub.RangeQueryStateFrom(startKey, endKey, "")
+}
+
+// RangeQueryStateFrom resumes a range query from the bookmark of an earlier
+// iterator over the same range, returning the keys that iterator would have
+// returned next. An empty bookmark starts the range query from the beginning.
+// A scan running out of time can thereby return its bookmark to the client,
+// which continues the scan in a subsequent query. Bookmarks can only be used
+// in queries, not in transactions.
+func (stub *ChaincodeStub) RangeQueryStateFrom(startKey, endKey, bookmark string) (*StateRangeQueryIterator, error) {
+	return stub.RangeQueryStatePrefetch(startKey, endKey, bookmark, 0)
+}
+
+// RangeQueryStatePrefetch is RangeQueryStateFrom with a prefetch hint: the
+// peer sends the key-values of the range prefetch at a time, instead of its
+// default of 100, up to a maximum of 1000. Chaincodes reading through large
+// ranges cut the round trips to the peer by raising it, and chaincodes
+// reading only the first keys of a range save the peer reading the rest by
+// lowering it. A prefetch of 0 keeps the default of the peer.
+func (stub *ChaincodeStub) RangeQueryStatePrefetch(startKey, endKey, bookmark string, prefetch uint32) (*StateRangeQueryIterator, error) {
+	response, err := handler.handleRangeQueryState(startKey, endKey, bookmark, prefetch, stub.UUID)
 	if err != nil {
 		return nil, err
 	}
-	return &StateRangeQueryIterator{handler, stub.UUID, response, 0}, nil
+	return &StateRangeQueryIterator{handler: handler, uuid: stub.UUID, response: response, prefetch: prefetch}, nil
+}
+
+// Bookmark returns the bookmark of the position of the iterator, from which
+// RangeQueryStateFrom resumes the range query after the key returned last by
+// Next. It is empty before Next returned any key.
+func (iter *StateRangeQueryIterator) Bookmark() string {
+	if iter.lastKey == nil {
+		return ""
+	}
+	return pb.NewRangeQueryBookmark(*iter.lastKey)
 }
 
 // HasNext returns true if the range query iterator contains additional keys
@@ -348,11 +402,12 @@ func (iter *StateRangeQueryIterator) Next() (string, []byte, error) {
 	if iter.currentLoc < len(iter.response.KeysAndValues) {
 		keyValue := iter.response.KeysAndValues[iter.currentLoc]
 		iter.currentLoc++
+		iter.lastKey = &keyValue.Key
 		return keyValue.Key, keyValue.Value, nil
 	} else if !iter.response.HasMore {
 		return "", nil, errors.New("No such key")
 	} else {
-		response, err := iter.handler.handleRangeQueryStateNext(iter.response.ID, iter.uuid)
+		response, err := iter.handler.handleRangeQueryStateNext(iter.response.ID, iter.prefetch, iter.uuid)
 
 		if err != nil {
 			return "", nil, err
@@ -362,6 +417,7 @@ func (iter *StateRangeQueryIterator) Next() (string, []byte, error) {
 		iter.response = response
 		keyValue := iter.response.KeysAndValues[iter.currentLoc]
 		iter.currentLoc++
+		iter.lastKey = &keyValue.Key
 		return keyValue.Key, keyValue.Value, nil
 
 	}
@@ -647,6 +703,67 @@ func (stub *ChaincodeStub) GetTxTimestamp() (*gp.Timestamp, error) {
 	return stub.securityContext.TxTimestamp, nil
 }
 
+// GetBlockTimestamp returns the consensus time of the block the transaction
+// goes into, which all validating peers agree on, or the time of the latest
+// block for a query. It is not available if the consensus does not stamp
+// blocks, as with noops.
+func (stub *ChaincodeStub) GetBlockTimestamp() (*gp.Timestamp, error) {
+	if stub.securityContext == nil || stub.securityContext.BlockTimestamp == nil {
+		return nil, errors.New("Block timestamp not available")
+	}
+	return stub.securityContext.BlockTimestamp, nil
+}
+
+// GetBlockRandom returns the random value of the block the transaction goes
+// into, which all validating peers agree on, and which no faulty validating
+// peers can predict. It is not available for queries, nor if the consensus
+// does not draw random values, as with noops or when the pbft random beacon
+// is disabled, nor for the blocks too few validating peers contributed to.
+func (stub *ChaincodeStub) GetBlockRandom() ([]byte, error) {
+	if stub.securityContext == nil || stub.securityContext.BlockRandom == nil {
+		return nil, errors.New("Block random value not available")
+	}
+	return stub.securityContext.BlockRandom, nil
+}
+
+// GetSystemMetadata returns the entry name of the system metadata namespace,
+// which holds the validator set and configuration of the network, or nil if it
+// has none. The names of the entries are the SystemMetadata constants of the
+// protos package. All validating peers read the same entries in a transaction.
+func (stub *ChaincodeStub) GetSystemMetadata(name string) ([]byte, error) {
+	return handler.handleGetSystemMetadata(name, stub.UUID)
+}
+
+// GetBlockHeight returns the number of blocks in the chain. In a transaction,
+// the block the transaction goes into is not counted.
+func (stub *ChaincodeStub) GetBlockHeight() (uint64, error) {
+	value, err := stub.GetSystemMetadata(pb.SystemMetadataHeight)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseUint(string(value), 10, 64)
+}
+
+// GetValidators returns the identities of the validating peers of the
+// network, or nil if the network does not configure them.
+func (stub *ChaincodeStub) GetValidators() ([]string, error) {
+	value, err := stub.GetSystemMetadata(pb.SystemMetadataValidators)
+	if err != nil || value == nil {
+		return nil, err
+	}
+	var validators []string
+	if err = json.Unmarshal(value, &validators); err != nil {
+		return nil, fmt.Errorf("Invalid validators in the system metadata: %s", err)
+	}
+	return validators, nil
+}
+
+// GetSystemConfig returns the configuration value name of the network, or nil
+// if it is not set.
+func (stub *ChaincodeStub) GetSystemConfig(name string) ([]byte, error) {
+	return stub.GetSystemMetadata(pb.SystemMetadataConfigPrefix + name)
+}
+
 func (stub *ChaincodeStub) getTable(tableName string) (*Table, error) {
 
 	tableName, err := getTableNameKey(tableName)
@@ -57,6 +57,8 @@ type Handler struct {
 	// Track which UUIDs are transactions and which are queries, to decide whether get/put state and invoke chaincode are allowed.
 	isTransaction map[string]bool
 	nextState     chan *nextStateInfo
+	// protocol version and capabilities the peer announced in REGISTERED
+	peerHandshake *pb.ChaincodeHandshake
 }
 
 func shortuuid(uuid string) string {
@@ -166,6 +168,7 @@ func newChaincodeHandler(peerChatStream PeerChaincodeStream, chaincode Chaincode
 			{Name: pb.ChaincodeMessage_QUERY.String(), Src: []string{"transaction"}, Dst: "transaction"},
 			{Name: pb.ChaincodeMessage_QUERY.String(), Src: []string{"ready"}, Dst: "ready"},
 			{Name: pb.ChaincodeMessage_RESPONSE.String(), Src: []string{"ready"}, Dst: "ready"},
+			{Name: pb.ChaincodeMessage_ERROR.String(), Src: []string{"ready"}, Dst: "ready"},
 		},
 		fsm.Callbacks{
 			"before_" + pb.ChaincodeMessage_REGISTERED.String(): func(e *fsm.Event) { v.beforeRegistered(e) },
@@ -184,11 +187,37 @@ func newChaincodeHandler(peerChatStream PeerChaincodeStream, chaincode Chaincode
 
 // beforeRegistered is called to handle the REGISTERED message.
 func (handler *Handler) beforeRegistered(e *fsm.Event) {
-	if _, ok := e.Args[0].(*pb.ChaincodeMessage); !ok {
+	msg, ok := e.Args[0].(*pb.ChaincodeMessage)
+	if !ok {
 		e.Cancel(fmt.Errorf("Received unexpected message type"))
 		return
 	}
-	chaincodeLogger.Debugf("Received %s, ready for invocations", pb.ChaincodeMessage_REGISTERED)
+	// Peers predating the handshake send an empty payload, which unmarshals
+	// as a handshake of protocol version zero without any capability
+	hs := &pb.ChaincodeHandshake{}
+	if err := proto.Unmarshal(msg.Payload, hs); err != nil {
+		e.Cancel(fmt.Errorf("Error in received %s, could NOT unmarshal handshake: %s", pb.ChaincodeMessage_REGISTERED, err))
+		return
+	}
+	handler.peerHandshake = hs
+	chaincodeLogger.Debugf("Received %s, ready for invocations. Peer speaks shim protocol version %d with capabilities %v", pb.ChaincodeMessage_REGISTERED, hs.ProtocolVersion, hs.Capabilities)
+}
+
+// checkPeerCapability returns an error if the peer does not support the given
+// optional feature of the shim protocol
+func (handler *Handler) checkPeerCapability(capability string, feature string) error {
+	if handler.peerHandshake.HasCapability(capability) {
+		return nil
+	}
+	return fmt.Errorf("The peer does not support %s (shim protocol version %d, capability %s missing), upgrade the peer to use it", feature, handler.peerProtocolVersion(), capability)
+}
+
+// peerProtocolVersion returns the version of the shim protocol the peer speaks
+func (handler *Handler) peerProtocolVersion() uint32 {
+	if handler.peerHandshake == nil {
+		return 0
+	}
+	return handler.peerHandshake.ProtocolVersion
 }
 
 // handleInit handles request to initialize chaincode.
@@ -461,7 +490,20 @@ func (handler *Handler) handleGetState(key string, uuid string) ([]byte, error)
 	if responseMsg.Type.String() == pb.ChaincodeMessage_RESPONSE.String() {
 		// Success response
 		chaincodeLogger.Debugf("[%s]GetState received payload %s", shortuuid(responseMsg.Uuid), pb.ChaincodeMessage_RESPONSE)
-		return responseMsg.Payload, nil
+		if handler.peerProtocolVersion() < 2 {
+			return responseMsg.Payload, nil
+		}
+		resp := &pb.GetStateResponse{}
+		if err := proto.Unmarshal(responseMsg.Payload, resp); err != nil {
+			return nil, fmt.Errorf("Invalid %s to %s: %s", pb.ChaincodeMessage_RESPONSE, pb.ChaincodeMessage_GET_STATE, err)
+		}
+		if !resp.Found {
+			return nil, nil
+		}
+		if resp.Value == nil {
+			return []byte{}, nil
+		}
+		return resp.Value, nil
 	}
 	if responseMsg.Type.String() == pb.ChaincodeMessage_ERROR.String() {
 		// Error response
@@ -474,15 +516,29 @@ func (handler *Handler) handleGetState(key string, uuid string) ([]byte, error)
 	return nil, errors.New("Incorrect chaincode message received")
 }
 
+// handleGetSystemMetadata fetches the entry name of the system metadata
+// namespace through its reserved key.
+func (handler *Handler) handleGetSystemMetadata(name string, uuid string) ([]byte, error) {
+	if err := handler.checkPeerCapability(pb.ChaincodeCapabilitySystemMetadata, "system metadata"); err != nil {
+		return nil, err
+	}
+	return handler.handleGetState(pb.SystemMetadataPrefix+name, uuid)
+}
+
 // handlePutState communicates with the validator to put state information into the ledger.
-func (handler *Handler) handlePutState(key string, value []byte, uuid string) error {
+func (handler *Handler) handlePutState(key string, value []byte, ttl uint64, uuid string) error {
 	// Check if this is a transaction
 	chaincodeLogger.Debugf("[%s]Inside putstate, isTransaction = %t", shortuuid(uuid), handler.isTransaction[uuid])
 	if !handler.isTransaction[uuid] {
 		return errors.New("Cannot put state in query context")
 	}
+	if ttl > 0 {
+		if err := handler.checkPeerCapability(pb.ChaincodeCapabilityStateTTL, "key expiry"); err != nil {
+			return err
+		}
+	}
 
-	payload := &pb.PutStateInfo{Key: key, Value: value}
+	payload := &pb.PutStateInfo{Key: key, Value: value, Ttl: ttl}
 	payloadBytes, err := proto.Marshal(payload)
 	if err != nil {
 		return errors.New("Failed to process put state request")
@@ -577,7 +633,13 @@ func (handler *Handler) handleDelState(key string, uuid string) error {
 	return errors.New("Incorrect chaincode message received")
 }
 
-func (handler *Handler) handleRangeQueryState(startKey, endKey string, uuid string) (*pb.RangeQueryStateResponse, error) {
+func (handler *Handler) handleRangeQueryState(startKey, endKey, bookmark string, prefetch uint32, uuid string) (*pb.RangeQueryStateResponse, error) {
+	if bookmark != "" {
+		if err := handler.checkPeerCapability(pb.ChaincodeCapabilityRangeQueryBookmarks, "range query bookmarks"); err != nil {
+			return nil, err
+		}
+	}
+
 	// Create the channel on which to communicate the response from validating peer
 	respChan, uniqueReqErr := handler.createChannel(uuid)
 	if uniqueReqErr != nil {
@@ -588,7 +650,7 @@ func (handler *Handler) handleRangeQueryState(startKey, endKey string, uuid stri
 	defer handler.deleteChannel(uuid)
 
 	// Send RANGE_QUERY_STATE message to validator chaincode support
-	payload := &pb.RangeQueryState{StartKey: startKey, EndKey: endKey}
+	payload := &pb.RangeQueryState{StartKey: startKey, EndKey: endKey, Bookmark: bookmark, Prefetch: prefetch}
 	payloadBytes, err := proto.Marshal(payload)
 	if err != nil {
 		return nil, errors.New("Failed to process range query state request")
@@ -631,7 +693,7 @@ func (handler *Handler) handleRangeQueryState(startKey, endKey string, uuid stri
 	return nil, errors.New("Incorrect chaincode message received")
 }
 
-func (handler *Handler) handleRangeQueryStateNext(id, uuid string) (*pb.RangeQueryStateResponse, error) {
+func (handler *Handler) handleRangeQueryStateNext(id string, prefetch uint32, uuid string) (*pb.RangeQueryStateResponse, error) {
 	// Create the channel on which to communicate the response from validating peer
 	respChan, uniqueReqErr := handler.createChannel(uuid)
 	if uniqueReqErr != nil {
@@ -642,7 +704,7 @@ func (handler *Handler) handleRangeQueryStateNext(id, uuid string) (*pb.RangeQue
 	defer handler.deleteChannel(uuid)
 
 	// Send RANGE_QUERY_STATE_NEXT message to validator chaincode support
-	payload := &pb.RangeQueryStateNext{ID: id}
+	payload := &pb.RangeQueryStateNext{ID: id, Prefetch: prefetch}
 	payloadBytes, err := proto.Marshal(payload)
 	if err != nil {
 		return nil, errors.New("Failed to process range query state next request")
@@ -805,6 +867,57 @@ func (handler *Handler) handleInvokeChaincode(chaincodeName string, function str
 	return nil, errors.New("Incorrect chaincode message received")
 }
 
+// handleQueryResultChunk sends a chunk of the result of a query to the validator
+// and waits for the validator to accept it.
+func (handler *Handler) handleQueryResultChunk(chunk []byte, uuid string) error {
+	// Check if this is a query
+	if handler.isTransaction[uuid] {
+		return errors.New("Cannot send query result in transaction context")
+	}
+	if err := handler.checkPeerCapability(pb.ChaincodeCapabilityQueryResultChunks, "streamed query results"); err != nil {
+		return err
+	}
+
+	// Create the channel on which to communicate the response from validating peer
+	respChan, uniqueReqErr := handler.createChannel(uuid)
+	if uniqueReqErr != nil {
+		chaincodeLogger.Errorf("[%s]Another request pending for this Uuid. Cannot process.", shortuuid(uuid))
+		return uniqueReqErr
+	}
+
+	defer handler.deleteChannel(uuid)
+
+	// Send QUERY_RESULT_CHUNK message to validator chaincode support
+	msg := &pb.ChaincodeMessage{Type: pb.ChaincodeMessage_QUERY_RESULT_CHUNK, Payload: chunk, Uuid: uuid}
+	chaincodeLogger.Debugf("[%s]Sending %s", shortuuid(msg.Uuid), pb.ChaincodeMessage_QUERY_RESULT_CHUNK)
+	if err := handler.serialSend(msg); err != nil {
+		chaincodeLogger.Errorf("[%s]error sending %s", shortuuid(msg.Uuid), pb.ChaincodeMessage_QUERY_RESULT_CHUNK)
+		return errors.New("could not send msg")
+	}
+
+	// Wait on responseChannel for response
+	responseMsg, ok := handler.receiveChannel(respChan)
+	if !ok {
+		chaincodeLogger.Errorf("[%s]Received unexpected message type", shortuuid(msg.Uuid))
+		return errors.New("Received unexpected message type")
+	}
+
+	if responseMsg.Type.String() == pb.ChaincodeMessage_RESPONSE.String() {
+		// Success response
+		chaincodeLogger.Debugf("[%s]Received %s. Query result chunk accepted", shortuuid(responseMsg.Uuid), pb.ChaincodeMessage_RESPONSE)
+		return nil
+	}
+	if responseMsg.Type.String() == pb.ChaincodeMessage_ERROR.String() {
+		// Error response
+		chaincodeLogger.Errorf("[%s]Received %s. Payload: %s", shortuuid(responseMsg.Uuid), pb.ChaincodeMessage_ERROR, responseMsg.Payload)
+		return errors.New(string(responseMsg.Payload[:]))
+	}
+
+	// Incorrect chaincode message received
+	chaincodeLogger.Errorf("[%s]Incorrect chaincode message %s received. Expecting %s or %s", shortuuid(responseMsg.Uuid), responseMsg.Type, pb.ChaincodeMessage_RESPONSE, pb.ChaincodeMessage_ERROR)
+	return errors.New("Incorrect chaincode message received")
+}
+
 // handleQueryChaincode communicates with the validator to query another chaincode.
 func (handler *Handler) handleQueryChaincode(chaincodeName string, function string, args []string, uuid string) ([]byte, error) {
 	chaincodeID := &pb.ChaincodeID{Name: chaincodeName}
@@ -867,6 +980,10 @@ func (handler *Handler) handleQueryChaincode(chaincodeName string, function stri
 // handleMessage message handles loop for shim side of chaincode/validator stream.
 func (handler *Handler) handleMessage(msg *pb.ChaincodeMessage) error {
 	chaincodeLogger.Debugf("[%s]Handling ChaincodeMessage of type: %s(state:%s)", shortuuid(msg.Uuid), msg.Type, handler.FSM.Current())
+	if msg.Type == pb.ChaincodeMessage_ERROR && handler.FSM.Current() == "created" {
+		// The peer refused the registration, e.g. as the shim is too old
+		return fmt.Errorf("Peer refused to register the chaincode: %s", msg.Payload)
+	}
 	if handler.FSM.Cannot(msg.Type.String()) {
 		errStr := fmt.Sprintf("[%s]Chaincode handler FSM cannot handle message (%s) with payload size (%d) while in state: %s", msg.Uuid, msg.Type.String(), len(msg.Payload), handler.FSM.Current())
 		err := errors.New(errStr)
@@ -17,8 +17,11 @@ limitations under the License.
 package shim
 
 import (
+	"strings"
 	"testing"
 
+	"github.com/golang/protobuf/proto"
+	pb "github.com/hyperledger/fabric/protos"
 	"github.com/op/go-logging"
 )
 
@@ -117,3 +120,40 @@ func TestChaincodeLogging(t *testing.T) {
 		t.Errorf("'bar' should be enabled for LogCritical")
 	}
 }
+
+// TestPeerCapabilities tests that features the peer does not announce in the
+// REGISTERED handshake fail with a clear error.
+func TestPeerCapabilities(t *testing.T) {
+	// Peers predating the handshake send REGISTERED without payload
+	h := newChaincodeHandler(nil, nil)
+	if err := h.handleMessage(&pb.ChaincodeMessage{Type: pb.ChaincodeMessage_REGISTERED}); err != nil {
+		t.Fatalf("Expected REGISTERED of an old peer to be handled: %s", err)
+	}
+	h.isTransaction["tx"] = true
+	if err := h.handlePutState("key", []byte("value"), 10, "tx"); err == nil || !strings.Contains(err.Error(), pb.ChaincodeCapabilityStateTTL) {
+		t.Errorf("Expected put with TTL to fail naming the missing capability, got %v", err)
+	}
+	if err := h.handleQueryResultChunk([]byte("chunk"), "query"); err == nil || !strings.Contains(err.Error(), pb.ChaincodeCapabilityQueryResultChunks) {
+		t.Errorf("Expected streamed query result to fail naming the missing capability, got %v", err)
+	}
+	if _, err := h.handleGetSystemMetadata(pb.SystemMetadataHeight, "query"); err == nil || !strings.Contains(err.Error(), pb.ChaincodeCapabilitySystemMetadata) {
+		t.Errorf("Expected system metadata to fail naming the missing capability, got %v", err)
+	}
+
+	h = newChaincodeHandler(nil, nil)
+	payload, _ := proto.Marshal(pb.NewChaincodeHandshake(nil))
+	if err := h.handleMessage(&pb.ChaincodeMessage{Type: pb.ChaincodeMessage_REGISTERED, Payload: payload}); err != nil {
+		t.Fatalf("Expected REGISTERED to be handled: %s", err)
+	}
+	for _, c := range pb.ChaincodeCapabilities {
+		if err := h.checkPeerCapability(c, c); err != nil {
+			t.Errorf("Expected capability %s of the peer to be available: %s", c, err)
+		}
+	}
+
+	// The peer refuses shims speaking a protocol too old for it
+	h = newChaincodeHandler(nil, nil)
+	if err := h.handleMessage(&pb.ChaincodeMessage{Type: pb.ChaincodeMessage_ERROR, Payload: []byte("too old")}); err == nil || !strings.Contains(err.Error(), "too old") {
+		t.Errorf("Expected refused registration to fail with the reason of the peer, got %v", err)
+	}
+}
@@ -0,0 +1,112 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package chaincode
+
+import (
+	"fmt"
+
+	"github.com/golang/protobuf/proto"
+	pb "github.com/hyperledger/fabric/protos"
+)
+
+// The handler speaks the current version of the shim protocol, and translates
+// the messages it exchanges with chaincodes registered with an older shim, so
+// that chaincode containers keep running across peer upgrades. Each change to
+// the schema of the messages comes with a schema downgrade, which rewrites
+// messages of the version introducing the change into messages of the
+// previous version, and the other way around. A message is translated for a
+// shim of version v by applying, in turn, the downgrades of the versions
+// after v.
+
+// shimSchemaDowngrade translates between messages of version and of
+// version-1 of the shim protocol. Either function may be nil if messages in
+// that direction do not differ.
+type shimSchemaDowngrade struct {
+	version uint32
+	// toShim rewrites a message the peer sends, which answers a request of
+	// the shim of type inReplyTo, or UNDEFINED if it does not answer one
+	toShim func(msg *pb.ChaincodeMessage, inReplyTo pb.ChaincodeMessage_Type) (*pb.ChaincodeMessage, error)
+	// fromShim rewrites a message the shim sends into the newer version
+	fromShim func(msg *pb.ChaincodeMessage) (*pb.ChaincodeMessage, error)
+}
+
+// shimSchemaDowngrades are the changes to the schema of the messages, latest
+// first. Version 1 only introduced the REGISTER handshake, which the handler
+// deals with itself.
+var shimSchemaDowngrades = []shimSchemaDowngrade{
+	{version: 2, toShim: bareGetStateResponse},
+}
+
+// bareGetStateResponse answers GET_STATE with the bare value of the key
+func bareGetStateResponse(msg *pb.ChaincodeMessage, inReplyTo pb.ChaincodeMessage_Type) (*pb.ChaincodeMessage, error) {
+	if inReplyTo != pb.ChaincodeMessage_GET_STATE || msg.Type != pb.ChaincodeMessage_RESPONSE {
+		return msg, nil
+	}
+	resp := &pb.GetStateResponse{}
+	if err := proto.Unmarshal(msg.Payload, resp); err != nil {
+		return nil, err
+	}
+	downgraded := *msg
+	downgraded.Payload = resp.Value
+	return &downgraded, nil
+}
+
+// shimProtocolVersion returns the version of the shim protocol the chaincode
+// registered with
+func (handler *Handler) shimProtocolVersion() uint32 {
+	if handler.shimHandshake == nil {
+		return 0
+	}
+	return handler.shimHandshake.ProtocolVersion
+}
+
+// toShim translates a message of the peer, answering a request of type
+// inReplyTo, to the protocol version of the shim
+func (handler *Handler) toShim(msg *pb.ChaincodeMessage, inReplyTo pb.ChaincodeMessage_Type) (*pb.ChaincodeMessage, error) {
+	version := handler.shimProtocolVersion()
+	for _, d := range shimSchemaDowngrades {
+		if d.version <= version {
+			break
+		}
+		if d.toShim == nil {
+			continue
+		}
+		translated, err := d.toShim(msg, inReplyTo)
+		if err != nil {
+			return nil, fmt.Errorf("Error translating %s to shim protocol version %d: %s", msg.Type, d.version-1, err)
+		}
+		msg = translated
+	}
+	return msg, nil
+}
+
+// fromShim translates a message of the shim to the current protocol version
+func (handler *Handler) fromShim(msg *pb.ChaincodeMessage) (*pb.ChaincodeMessage, error) {
+	version := handler.shimProtocolVersion()
+	for i := len(shimSchemaDowngrades) - 1; i >= 0; i-- {
+		d := shimSchemaDowngrades[i]
+		if d.version <= version || d.fromShim == nil {
+			continue
+		}
+		translated, err := d.fromShim(msg)
+		if err != nil {
+			return nil, fmt.Errorf("Error translating %s from shim protocol version %d: %s", msg.Type, d.version-1, err)
+		}
+		msg = translated
+	}
+	return msg, nil
+}
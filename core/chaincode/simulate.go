@@ -0,0 +1,186 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package chaincode
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/hyperledger/fabric/core/ledger"
+	"github.com/hyperledger/fabric/core/ledger/statemgmt"
+	"github.com/hyperledger/fabric/core/ledger/statemgmt/state"
+	pb "github.com/hyperledger/fabric/protos"
+)
+
+// A simulation executes an invocation the way a transaction is executed,
+// except that the changes of the chaincode are kept apart from the ledger, and
+// dropped once the invocation completes. The chaincode reads the committed
+// state as changed by the invocation itself, and cannot invoke other
+// chaincodes. As the chaincode executes one transaction at a time, a
+// simulation waits for the transactions in progress in the chaincode, and the
+// transactions of the chaincode wait for a simulation in progress.
+
+// simulation holds the changes of a simulated invocation
+type simulation struct {
+	delta *statemgmt.StateDelta
+}
+
+func newSimulation() *simulation {
+	return &simulation{delta: statemgmt.NewStateDelta()}
+}
+
+// getState returns the value of key as changed by the simulated invocation
+func (sim *simulation) getState(lgr *ledger.Ledger, chaincodeID string, key string) ([]byte, error) {
+	if value := sim.delta.Get(chaincodeID, key); value != nil {
+		return value.GetValue(), nil
+	}
+	return lgr.GetState(chaincodeID, key, true)
+}
+
+// getRangeScanIterator returns an iterator over the key-values of a range, as
+// changed by the simulated invocation
+func (sim *simulation) getRangeScanIterator(lgr *ledger.Ledger, chaincodeID string, startKey string, endKey string) (statemgmt.RangeScanIterator, error) {
+	itr, err := lgr.GetStateRangeScanIterator(chaincodeID, startKey, endKey, true)
+	if err != nil {
+		return nil, err
+	}
+	return state.NewOverlayRangeScanIterator(sim.delta, chaincodeID, startKey, endKey, itr), nil
+}
+
+// simulationGate lets a simulation execute in a chaincode once no transaction
+// is executing in it, and holds off the transactions until it completes.
+// Transactions do not wait for each other, as a transaction may invoke the
+// chaincode it executes in.
+type simulationGate struct {
+	sync.Mutex
+	cond         *sync.Cond
+	transactions int
+	simulating   bool
+}
+
+func (g *simulationGate) enter(simulation bool) {
+	g.Lock()
+	defer g.Unlock()
+	if g.cond == nil {
+		g.cond = sync.NewCond(&g.Mutex)
+	}
+	for g.simulating || (simulation && g.transactions > 0) {
+		g.cond.Wait()
+	}
+	if simulation {
+		g.simulating = true
+	} else {
+		g.transactions++
+	}
+}
+
+func (g *simulationGate) leave(simulation bool) {
+	g.Lock()
+	defer g.Unlock()
+	if simulation {
+		g.simulating = false
+	} else {
+		g.transactions--
+	}
+	g.cond.Broadcast()
+}
+
+type simulationKey struct{}
+
+func withSimulation(ctxt context.Context, sim *simulation) context.Context {
+	return context.WithValue(ctxt, simulationKey{}, sim)
+}
+
+func simulationFrom(ctxt context.Context) *simulation {
+	sim, _ := ctxt.Value(simulationKey{}).(*simulation)
+	return sim
+}
+
+// Simulate executes the invocation t without committing its changes, and
+// returns the response of the chaincode, the keys it would change with their
+// values before and after, and the event it would emit
+func Simulate(ctxt context.Context, chain *ChaincodeSupport, t *pb.Transaction) (*pb.SimulationResult, error) {
+	if t.Type != pb.Transaction_CHAINCODE_INVOKE {
+		return nil, fmt.Errorf("Cannot simulate a %s transaction", t.Type)
+	}
+	if t.ConfidentialityLevel == pb.ConfidentialityLevel_CONFIDENTIAL {
+		return nil, fmt.Errorf("Confidential transactions cannot be simulated")
+	}
+	lgr, err := ledger.GetLedger()
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get handle to ledger (%s)", err)
+	}
+	if secHelper := chain.getSecHelper(); nil != secHelper {
+		if t, err = secHelper.TransactionPreExecution(t); err != nil {
+			return nil, err
+		}
+	}
+
+	cID, cMsg, err := chain.Launch(ctxt, t)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to launch chaincode spec(%s)", err)
+	}
+	ccMsg, err := createTransactionMessage(t.Uuid, cMsg)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to transaction message(%s)", err)
+	}
+
+	sim := newSimulation()
+	timeout := time.Duration(30000) * time.Millisecond
+	resp, err := chain.Execute(withSimulation(ctxt, sim), cID.Name, ccMsg, timeout, t)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to simulate transaction(%s)", err)
+	} else if resp == nil {
+		return nil, fmt.Errorf("Failed to receive a response for (%s)", t.Uuid)
+	} else if resp.Type != pb.ChaincodeMessage_COMPLETED {
+		return nil, fmt.Errorf("Transaction returned with failure: %s", string(resp.Payload))
+	}
+	if resp.ChaincodeEvent != nil {
+		resp.ChaincodeEvent.ChaincodeID = cID.Name
+		resp.ChaincodeEvent.TxID = t.Uuid
+	}
+
+	result := &pb.SimulationResult{Result: resp.Payload, Event: resp.ChaincodeEvent}
+	updates := sim.delta.GetUpdates(cID.Name)
+	keys := make([]string, 0, len(updates))
+	for key := range updates {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		before, err := lgr.GetState(cID.Name, key, true)
+		if err != nil {
+			return nil, err
+		}
+		if before == nil && updates[key].IsDelete() {
+			// Deleting a key that does not exist changes nothing
+			continue
+		}
+		change := &pb.SimulationResult_KeyChange{Key: key, Existed: before != nil, Before: before}
+		if updates[key].IsDelete() {
+			change.Deleted = true
+		} else {
+			change.After = updates[key].GetValue()
+		}
+		result.Changes = append(result.Changes, change)
+	}
+	return result, nil
+}
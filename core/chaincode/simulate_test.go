@@ -0,0 +1,71 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package chaincode
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSimulationGate(t *testing.T) {
+	var g simulationGate
+	entered := func(simulation bool) chan struct{} {
+		c := make(chan struct{})
+		go func() {
+			g.enter(simulation)
+			close(c)
+		}()
+		return c
+	}
+	blocked := func(c chan struct{}) bool {
+		select {
+		case <-c:
+			return false
+		case <-time.After(50 * time.Millisecond):
+			return true
+		}
+	}
+
+	// A transaction invoking its own chaincode does not wait
+	g.enter(false)
+	if blocked(entered(false)) {
+		t.Fatal("Transaction waits for another transaction")
+	}
+
+	sim := entered(true)
+	if !blocked(sim) {
+		t.Fatal("Simulation does not wait for the transactions in progress")
+	}
+	g.leave(false)
+	if !blocked(sim) {
+		t.Fatal("Simulation does not wait for the last transaction in progress")
+	}
+	g.leave(false)
+	if blocked(sim) {
+		t.Fatal("Simulation waits once no transaction is in progress")
+	}
+
+	tx := entered(false)
+	if !blocked(tx) {
+		t.Fatal("Transaction does not wait for the simulation in progress")
+	}
+	g.leave(true)
+	if blocked(tx) {
+		t.Fatal("Transaction waits once the simulation completed")
+	}
+	g.leave(false)
+}
@@ -0,0 +1,60 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package chaincode
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/hyperledger/fabric/core/ledger"
+	pb "github.com/hyperledger/fabric/protos"
+)
+
+// The system metadata namespace lets chaincodes, governance chaincodes in
+// particular, read the validator set and the configuration of the network with
+// GetState on reserved keys. The entries are stored in the ledger under
+// pb.SystemMetadataNamespace, so all validators read the same values at the
+// same point of the chain, and the block height is answered from the chain.
+// Chaincodes cannot put or delete the reserved keys.
+
+var errSystemMetadataReadOnly = errors.New("The system metadata namespace is read-only")
+
+// getSystemMetadata returns the value of a reserved key of the system
+// metadata namespace, nil if it has no value
+func getSystemMetadata(ledgerObj *ledger.Ledger, key string, committed bool) ([]byte, error) {
+	name := strings.TrimPrefix(key, pb.SystemMetadataPrefix)
+	if name == pb.SystemMetadataHeight {
+		return []byte(strconv.FormatUint(ledgerObj.GetBlockchainSize(), 10)), nil
+	}
+	return ledgerObj.GetState(pb.SystemMetadataNamespace, name, committed)
+}
+
+// handleGetSystemMetadata returns the reply to a GET_STATE of a reserved key
+// of the system metadata namespace. The entries are not encrypted for the
+// chaincode, so unlike its own state they are sent as they are stored.
+func handleGetSystemMetadata(ledgerObj *ledger.Ledger, uuid string, key string, committed bool) *pb.ChaincodeMessage {
+	res, err := getSystemMetadata(ledgerObj, key, committed)
+	if err != nil {
+		chaincodeLogger.Errorf("[%s]Failed to get system metadata %q (%s). Sending %s", shortuuid(uuid), key, err, pb.ChaincodeMessage_ERROR)
+		return &pb.ChaincodeMessage{Type: pb.ChaincodeMessage_ERROR, Payload: []byte(err.Error()), Uuid: uuid}
+	}
+	chaincodeLogger.Debugf("[%s]Got system metadata %q. Sending %s", shortuuid(uuid), key, pb.ChaincodeMessage_RESPONSE)
+	payload, _ := proto.Marshal(&pb.GetStateResponse{Value: res, Found: res != nil})
+	return &pb.ChaincodeMessage{Type: pb.ChaincodeMessage_RESPONSE, Payload: payload, Uuid: uuid}
+}
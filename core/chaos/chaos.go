@@ -0,0 +1,154 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package chaos injects faults into a running peer so that operators can
+// validate the resilience of a network in staging, and pauses subsystems of
+// the peer to reproduce partial failures. Fault injection is only
+// compiled into peers built with the "chaos" build tag; in any other build
+// the injection points are no-ops and the faults cannot be activated.
+package chaos
+
+import (
+	"errors"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/op/go-logging"
+)
+
+var logger = logging.MustGetLogger("chaos")
+
+// ErrNotAvailable is returned when trying to activate faults in a peer that was
+// not built with the "chaos" build tag
+var ErrNotAvailable = errors.New("Chaos mode is not available in this build of the peer, rebuild with the 'chaos' build tag")
+
+// Config describes the faults to inject. The zero value injects no fault
+type Config struct {
+	// MessageDelay is the maximum random delay applied to every message sent to another peer
+	MessageDelay time.Duration
+	// DropConnectionProbability is the probability, between 0 and 1, that a
+	// connection to another peer is dropped when a message is received on it
+	DropConnectionProbability float64
+	// CommitDelay is the delay applied to every commit of a block to the ledger
+	CommitDelay time.Duration
+}
+
+var (
+	lock              sync.RWMutex
+	config            Config
+	random            = rand.New(rand.NewSource(time.Now().UnixNano()))
+	viewChangeTrigger func()
+)
+
+// Available returns true if the peer was built with fault injection
+func Available() bool {
+	return available
+}
+
+// SetConfig replaces the faults being injected
+func SetConfig(c Config) error {
+	if !available {
+		return ErrNotAvailable
+	}
+	if c.DropConnectionProbability < 0 || c.DropConnectionProbability > 1 {
+		return errors.New("Drop connection probability must be between 0 and 1")
+	}
+	lock.Lock()
+	defer lock.Unlock()
+	logger.Warningf("Chaos configuration changed to %+v", c)
+	config = c
+	return nil
+}
+
+// GetConfig returns the faults being injected
+func GetConfig() Config {
+	lock.RLock()
+	defer lock.RUnlock()
+	return config
+}
+
+// RegisterViewChangeTrigger registers the function that forces the consensus
+// plugin into a view change
+func RegisterViewChangeTrigger(trigger func()) {
+	lock.Lock()
+	defer lock.Unlock()
+	viewChangeTrigger = trigger
+}
+
+// ForceViewChange forces the consensus plugin into a view change
+func ForceViewChange() error {
+	if !available {
+		return ErrNotAvailable
+	}
+	lock.RLock()
+	trigger := viewChangeTrigger
+	lock.RUnlock()
+	if trigger == nil {
+		return errors.New("The consensus plugin does not support forced view changes")
+	}
+	logger.Warning("Chaos forcing a view change")
+	trigger()
+	return nil
+}
+
+// DelayMessage sleeps for a random duration before a message is sent to another peer
+func DelayMessage() {
+	if !available {
+		return
+	}
+	c := GetConfig()
+	if c.MessageDelay <= 0 {
+		return
+	}
+	lock.Lock()
+	delay := time.Duration(random.Int63n(int64(c.MessageDelay)))
+	lock.Unlock()
+	logger.Warningf("Chaos delaying message by %v", delay)
+	time.Sleep(delay)
+}
+
+// DropConnection returns true if the connection on which a message was just
+// received must be dropped
+func DropConnection() bool {
+	if !available {
+		return false
+	}
+	c := GetConfig()
+	if c.DropConnectionProbability <= 0 {
+		return false
+	}
+	lock.Lock()
+	drop := random.Float64() < c.DropConnectionProbability
+	lock.Unlock()
+	if drop {
+		logger.Warning("Chaos dropping connection")
+	}
+	return drop
+}
+
+// DelayCommit sleeps before a block is committed to the ledger
+func DelayCommit() {
+	if !available {
+		return
+	}
+	c := GetConfig()
+	if c.CommitDelay <= 0 {
+		return
+	}
+	logger.Warningf("Chaos delaying commit by %v", c.CommitDelay)
+	time.Sleep(c.CommitDelay)
+}
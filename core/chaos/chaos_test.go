@@ -0,0 +1,105 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package chaos
+
+import (
+	"testing"
+	"time"
+)
+
+func TestChaosConfig(t *testing.T) {
+	defer func() { config = Config{} }()
+
+	err := SetConfig(Config{CommitDelay: time.Millisecond, DropConnectionProbability: 1})
+	if !Available() {
+		if err != ErrNotAvailable {
+			t.Fatalf("Expected chaos mode to be unavailable, got %v", err)
+		}
+		if DropConnection() {
+			t.Fatal("Connection must not be dropped when chaos mode is unavailable")
+		}
+		return
+	}
+	if err != nil {
+		t.Fatalf("Error setting chaos config: %s", err)
+	}
+	if !DropConnection() {
+		t.Fatal("Expected connection to be dropped")
+	}
+	if err := SetConfig(Config{DropConnectionProbability: 2}); err == nil {
+		t.Fatal("Expected error for invalid drop connection probability")
+	}
+	if err := ForceViewChange(); err == nil {
+		t.Fatal("Expected error when no view change trigger is registered")
+	}
+
+	triggered := false
+	RegisterViewChangeTrigger(func() { triggered = true })
+	defer RegisterViewChangeTrigger(nil)
+	if err := ForceViewChange(); err != nil || !triggered {
+		t.Fatalf("Expected view change to be triggered, got %v", err)
+	}
+}
+
+func TestPauseSubsystem(t *testing.T) {
+	err := Pause(BlockSync)
+	if !Available() {
+		if err != ErrNotAvailable {
+			t.Fatalf("Expected chaos mode to be unavailable, got %v", err)
+		}
+		if !WaitResumed(BlockSync, nil) {
+			t.Fatal("Subsystems must not be held when chaos mode is unavailable")
+		}
+		return
+	}
+	if err != nil {
+		t.Fatalf("Error pausing subsystem: %s", err)
+	}
+	defer Resume(BlockSync)
+	if err := Pause("unknown"); err == nil {
+		t.Fatal("Expected error pausing an unknown subsystem")
+	}
+	if paused := Paused(); len(paused) != 1 || paused[0] != BlockSync {
+		t.Fatalf("Expected only %s to be paused, got %v", BlockSync, paused)
+	}
+	if !WaitResumed(EventHub, nil) {
+		t.Fatal("Expected a running subsystem not to be held")
+	}
+
+	cancel := make(chan struct{})
+	close(cancel)
+	if WaitResumed(BlockSync, cancel) {
+		t.Fatal("Expected a paused subsystem to be held until cancelled")
+	}
+
+	done := make(chan bool)
+	go func() { done <- WaitResumed(BlockSync, nil) }()
+	select {
+	case <-done:
+		t.Fatal("Expected a paused subsystem to be held")
+	case <-time.After(10 * time.Millisecond):
+	}
+	if err := Resume(BlockSync); err != nil {
+		t.Fatalf("Error resuming subsystem: %s", err)
+	}
+	if !<-done {
+		t.Fatal("Expected a resumed subsystem to run")
+	}
+	if len(Paused()) != 0 {
+		t.Fatalf("Expected no subsystem to be paused, got %v", Paused())
+	}
+}
@@ -0,0 +1,131 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package chaos
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// Subsystem names a part of the peer which can be paused on its own, so
+// that operators can observe how the rest of the peer degrades without it
+type Subsystem string
+
+const (
+	// EventHub stops delivering events to the consumers, which pile up in
+	// the event buffer until producers time out
+	EventHub Subsystem = "eventhub"
+	// BlockSync holds the state transfers of the peer, leaving it behind
+	// the network
+	BlockSync Subsystem = "blocksync"
+	// ChaincodeSupport holds the transactions and queries sent to chaincodes,
+	// until their context is done
+	ChaincodeSupport Subsystem = "chaincode"
+)
+
+// Subsystems lists the subsystems which can be paused
+var Subsystems = []Subsystem{EventHub, BlockSync, ChaincodeSupport}
+
+var (
+	pausedLock sync.Mutex
+	// paused holds a channel per paused subsystem, closed when it resumes
+	paused = make(map[Subsystem]chan struct{})
+)
+
+func checkSubsystem(s Subsystem) error {
+	for _, known := range Subsystems {
+		if s == known {
+			return nil
+		}
+	}
+	return fmt.Errorf("Unknown subsystem %q, expected one of %v", s, Subsystems)
+}
+
+// Pause pauses a subsystem until Resume is called for it
+func Pause(s Subsystem) error {
+	if !available {
+		return ErrNotAvailable
+	}
+	if err := checkSubsystem(s); err != nil {
+		return err
+	}
+	pausedLock.Lock()
+	defer pausedLock.Unlock()
+	if _, ok := paused[s]; !ok {
+		logger.Warningf("Chaos pausing subsystem %s", s)
+		paused[s] = make(chan struct{})
+	}
+	return nil
+}
+
+// Resume resumes a paused subsystem, releasing the work it held
+func Resume(s Subsystem) error {
+	if !available {
+		return ErrNotAvailable
+	}
+	if err := checkSubsystem(s); err != nil {
+		return err
+	}
+	pausedLock.Lock()
+	defer pausedLock.Unlock()
+	if resumed, ok := paused[s]; ok {
+		logger.Warningf("Chaos resuming subsystem %s", s)
+		close(resumed)
+		delete(paused, s)
+	}
+	return nil
+}
+
+// Paused returns the subsystems paused, sorted
+func Paused() []Subsystem {
+	pausedLock.Lock()
+	defer pausedLock.Unlock()
+	var subsystems []Subsystem
+	for s := range paused {
+		subsystems = append(subsystems, s)
+	}
+	sort.Sort(subsystemSorter(subsystems))
+	return subsystems
+}
+
+// WaitResumed blocks while a subsystem is paused. It returns false if
+// cancel is closed or receives first, true once the subsystem runs.
+func WaitResumed(s Subsystem, cancel <-chan struct{}) bool {
+	if !available {
+		return true
+	}
+	pausedLock.Lock()
+	resumed, ok := paused[s]
+	pausedLock.Unlock()
+	if !ok {
+		return true
+	}
+	logger.Warningf("Chaos holding subsystem %s", s)
+	select {
+	case <-resumed:
+		return true
+	case <-cancel:
+		return false
+	}
+}
+
+type subsystemSorter []Subsystem
+
+func (a subsystemSorter) Len() int           { return len(a) }
+func (a subsystemSorter) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a subsystemSorter) Less(i, j int) bool { return a[i] < a[j] }
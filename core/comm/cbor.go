@@ -0,0 +1,341 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package comm
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+)
+
+// cborCodec carries the JSON mapping of protobuf messages in CBOR (RFC 7049).
+// Messages are encoded as maps with sorted text keys, the shortest encoding of
+// every integer, and bytes fields as base64 text as in JSON. When decoding,
+// byte strings are accepted in place of base64 text, and tags are ignored.
+type cborCodec struct{}
+
+func (cborCodec) Marshal(v interface{}) ([]byte, error) {
+	js, err := jsonCodec{}.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	dec := json.NewDecoder(bytes.NewReader(js))
+	dec.UseNumber()
+	var value interface{}
+	if err := dec.Decode(&value); err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err := encodeCBOR(&buf, value); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (cborCodec) Unmarshal(data []byte, v interface{}) error {
+	d := &cborDecoder{data: data}
+	value, err := d.decode()
+	if err != nil {
+		return err
+	}
+	if d.pos != len(data) {
+		return fmt.Errorf("Trailing %d bytes after CBOR item", len(data)-d.pos)
+	}
+	js, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	return jsonCodec{}.Unmarshal(js, v)
+}
+
+func (cborCodec) String() string {
+	return CBORCodecName
+}
+
+// CBOR major types
+const (
+	cborUint   = 0
+	cborNegInt = 1
+	cborBytes  = 2
+	cborText   = 3
+	cborArray  = 4
+	cborMap    = 5
+	cborTag    = 6
+	cborSimple = 7
+)
+
+func writeCBORHead(buf *bytes.Buffer, major byte, n uint64) {
+	switch {
+	case n < 24:
+		buf.WriteByte(major<<5 | byte(n))
+	case n <= math.MaxUint8:
+		buf.WriteByte(major<<5 | 24)
+		buf.WriteByte(byte(n))
+	case n <= math.MaxUint16:
+		buf.WriteByte(major<<5 | 25)
+		binary.Write(buf, binary.BigEndian, uint16(n))
+	case n <= math.MaxUint32:
+		buf.WriteByte(major<<5 | 26)
+		binary.Write(buf, binary.BigEndian, uint32(n))
+	default:
+		buf.WriteByte(major<<5 | 27)
+		binary.Write(buf, binary.BigEndian, n)
+	}
+}
+
+// encodeCBOR encodes a value decoded from JSON with numbers kept as json.Number
+func encodeCBOR(buf *bytes.Buffer, value interface{}) error {
+	switch v := value.(type) {
+	case nil:
+		buf.WriteByte(cborSimple<<5 | 22)
+	case bool:
+		if v {
+			buf.WriteByte(cborSimple<<5 | 21)
+		} else {
+			buf.WriteByte(cborSimple<<5 | 20)
+		}
+	case json.Number:
+		if n, err := strconv.ParseUint(string(v), 10, 64); err == nil {
+			writeCBORHead(buf, cborUint, n)
+		} else if n, err := strconv.ParseInt(string(v), 10, 64); err == nil {
+			writeCBORHead(buf, cborNegInt, uint64(-1-n))
+		} else if f, err := v.Float64(); err == nil {
+			buf.WriteByte(cborSimple<<5 | 27)
+			binary.Write(buf, binary.BigEndian, math.Float64bits(f))
+		} else {
+			return fmt.Errorf("Invalid number %s", v)
+		}
+	case string:
+		writeCBORHead(buf, cborText, uint64(len(v)))
+		buf.WriteString(v)
+	case []interface{}:
+		writeCBORHead(buf, cborArray, uint64(len(v)))
+		for _, item := range v {
+			if err := encodeCBOR(buf, item); err != nil {
+				return err
+			}
+		}
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		writeCBORHead(buf, cborMap, uint64(len(v)))
+		for _, key := range keys {
+			writeCBORHead(buf, cborText, uint64(len(key)))
+			buf.WriteString(key)
+			if err := encodeCBOR(buf, v[key]); err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("Cannot encode %T in CBOR", value)
+	}
+	return nil
+}
+
+// cborDecoder decodes a CBOR item into the values encoding/json marshals
+type cborDecoder struct {
+	data []byte
+	pos  int
+}
+
+var errCBORTruncated = errors.New("Truncated CBOR item")
+
+// errCBORBreak is returned when the break code ending an indefinite length
+// item is read
+var errCBORBreak = errors.New("Unexpected CBOR break")
+
+func (d *cborDecoder) next(n int) ([]byte, error) {
+	if n < 0 || len(d.data)-d.pos < n {
+		return nil, errCBORTruncated
+	}
+	b := d.data[d.pos : d.pos+n]
+	d.pos += n
+	return b, nil
+}
+
+// head reads the head of an item, returning its major type, additional
+// information and argument
+func (d *cborDecoder) head() (major byte, info byte, arg uint64, err error) {
+	b, err := d.next(1)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	major, info = b[0]>>5, b[0]&0x1f
+	switch {
+	case info < 24:
+		arg = uint64(info)
+	case info <= 27:
+		var raw []byte
+		if raw, err = d.next(1 << (info - 24)); err != nil {
+			return 0, 0, 0, err
+		}
+		for _, c := range raw {
+			arg = arg<<8 | uint64(c)
+		}
+	case info == 31:
+		if major == cborSimple {
+			return 0, 0, 0, errCBORBreak
+		}
+		if major < cborBytes || major == cborTag {
+			return 0, 0, 0, fmt.Errorf("Invalid indefinite length for CBOR major type %d", major)
+		}
+	default:
+		return 0, 0, 0, fmt.Errorf("Invalid CBOR additional information %d", info)
+	}
+	return major, info, arg, nil
+}
+
+func (d *cborDecoder) decode() (interface{}, error) {
+	major, info, arg, err := d.head()
+	if err != nil {
+		return nil, err
+	}
+	indefinite := info == 31
+	switch major {
+	case cborUint:
+		return arg, nil
+	case cborNegInt:
+		if arg > math.MaxInt64 {
+			return nil, fmt.Errorf("CBOR negative integer out of range")
+		}
+		return -1 - int64(arg), nil
+	case cborBytes, cborText:
+		s, err := d.decodeString(major, indefinite, arg)
+		if err != nil {
+			return nil, err
+		}
+		if major == cborBytes {
+			return base64.StdEncoding.EncodeToString(s), nil
+		}
+		return string(s), nil
+	case cborArray:
+		array := []interface{}{}
+		for i := uint64(0); indefinite || i < arg; i++ {
+			item, err := d.decode()
+			if err == errCBORBreak && indefinite {
+				break
+			}
+			if err != nil {
+				return nil, err
+			}
+			array = append(array, item)
+		}
+		return array, nil
+	case cborMap:
+		m := make(map[string]interface{})
+		for i := uint64(0); indefinite || i < arg; i++ {
+			key, err := d.decode()
+			if err == errCBORBreak && indefinite {
+				break
+			}
+			if err != nil {
+				return nil, err
+			}
+			k, ok := key.(string)
+			if !ok {
+				return nil, fmt.Errorf("CBOR map key %v is not text", key)
+			}
+			if m[k], err = d.decode(); err != nil {
+				return nil, err
+			}
+		}
+		return m, nil
+	case cborTag:
+		return d.decode()
+	default:
+		return d.decodeSimple(info, arg)
+	}
+}
+
+func (d *cborDecoder) decodeString(major byte, indefinite bool, length uint64) ([]byte, error) {
+	if !indefinite {
+		if length > uint64(len(d.data)) {
+			return nil, errCBORTruncated
+		}
+		return d.next(int(length))
+	}
+	// An indefinite length string is a sequence of definite length chunks
+	var s []byte
+	for {
+		chunkMajor, info, arg, err := d.head()
+		if err == errCBORBreak {
+			return s, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if chunkMajor != major || info == 31 {
+			return nil, fmt.Errorf("Invalid chunk in indefinite length CBOR string")
+		}
+		chunk, err := d.decodeString(major, false, arg)
+		if err != nil {
+			return nil, err
+		}
+		s = append(s, chunk...)
+	}
+}
+
+func (d *cborDecoder) decodeSimple(info byte, arg uint64) (interface{}, error) {
+	switch info {
+	case 20:
+		return false, nil
+	case 21:
+		return true, nil
+	case 22, 23:
+		// null and undefined
+		return nil, nil
+	case 25:
+		return halfToFloat64(uint16(arg)), nil
+	case 26:
+		return float64(math.Float32frombits(uint32(arg))), nil
+	case 27:
+		return math.Float64frombits(arg), nil
+	}
+	return nil, fmt.Errorf("Unsupported CBOR simple value %d", arg)
+}
+
+// halfToFloat64 converts an IEEE 754 half precision float
+func halfToFloat64(h uint16) float64 {
+	exp := int(h>>10) & 0x1f
+	mant := float64(h & 0x3ff)
+	var f float64
+	switch exp {
+	case 0:
+		f = math.Ldexp(mant, -24)
+	case 31:
+		if mant == 0 {
+			f = math.Inf(1)
+		} else {
+			f = math.NaN()
+		}
+	default:
+		f = math.Ldexp(mant+1024, exp-25)
+	}
+	if h&0x8000 != 0 {
+		f = -f
+	}
+	return f
+}
@@ -0,0 +1,148 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package comm
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/golang/protobuf/jsonpb"
+	"github.com/golang/protobuf/proto"
+	"google.golang.org/grpc"
+)
+
+// Codec encodes the messages of the peer protocol on the wire. The messages
+// are protobuf messages, but a codec may carry them in another format for
+// clients which have no protobuf tooling. The name returned by String is the
+// name a connection negotiates the codec by.
+type Codec interface {
+	grpc.Codec
+}
+
+// Names of the codecs shipped with the peer
+const (
+	// ProtobufCodecName is the protobuf binary format, the default
+	ProtobufCodecName = "proto"
+	// JSONCodecName is the JSON mapping of the protobuf messages
+	JSONCodecName = "json"
+	// CBORCodecName is the JSON mapping of the protobuf messages, encoded in CBOR
+	CBORCodecName = "cbor"
+)
+
+var codecs = struct {
+	sync.RWMutex
+	m map[string]Codec
+}{m: make(map[string]Codec)}
+
+func init() {
+	RegisterCodec(protobufCodec{})
+	RegisterCodec(jsonCodec{})
+	RegisterCodec(cborCodec{})
+}
+
+// RegisterCodec makes a codec available to connections, replacing any codec
+// registered under the same name
+func RegisterCodec(codec Codec) {
+	codecs.Lock()
+	defer codecs.Unlock()
+	codecs.m[codec.String()] = codec
+}
+
+// GetCodec returns the codec registered under name
+func GetCodec(name string) (Codec, error) {
+	codecs.RLock()
+	defer codecs.RUnlock()
+	codec, ok := codecs.m[name]
+	if !ok {
+		return nil, fmt.Errorf("Unknown codec %s", name)
+	}
+	return codec, nil
+}
+
+// CodecNames returns the names of the registered codecs, sorted
+func CodecNames() []string {
+	codecs.RLock()
+	defer codecs.RUnlock()
+	names := make([]string, 0, len(codecs.m))
+	for name := range codecs.m {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func asProto(v interface{}) (proto.Message, error) {
+	msg, ok := v.(proto.Message)
+	if !ok {
+		return nil, fmt.Errorf("%T is not a protobuf message", v)
+	}
+	return msg, nil
+}
+
+// protobufCodec is the protobuf binary format, which gRPC uses by default
+type protobufCodec struct{}
+
+func (protobufCodec) Marshal(v interface{}) ([]byte, error) {
+	msg, err := asProto(v)
+	if err != nil {
+		return nil, err
+	}
+	return proto.Marshal(msg)
+}
+
+func (protobufCodec) Unmarshal(data []byte, v interface{}) error {
+	msg, err := asProto(v)
+	if err != nil {
+		return err
+	}
+	return proto.Unmarshal(data, msg)
+}
+
+func (protobufCodec) String() string {
+	return ProtobufCodecName
+}
+
+// jsonCodec is the JSON mapping of protobuf messages, field names being the
+// names of the fields in the proto files, enums being encoded by name and
+// bytes in base64
+type jsonCodec struct{}
+
+func (jsonCodec) Marshal(v interface{}) ([]byte, error) {
+	msg, err := asProto(v)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err := (&jsonpb.Marshaler{}).Marshal(&buf, msg); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (jsonCodec) Unmarshal(data []byte, v interface{}) error {
+	msg, err := asProto(v)
+	if err != nil {
+		return err
+	}
+	return jsonpb.Unmarshal(bytes.NewReader(data), msg)
+}
+
+func (jsonCodec) String() string {
+	return JSONCodecName
+}
@@ -0,0 +1,217 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package comm
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+
+	"github.com/hyperledger/fabric/core/health"
+	healthpb "github.com/hyperledger/fabric/core/health/grpc_health_v1"
+	pb "github.com/hyperledger/fabric/protos"
+	google_protobuf "google/protobuf"
+)
+
+// Every codec must carry every message of the protocol unchanged
+func TestCodecConformance(t *testing.T) {
+	tx := &pb.Transaction{
+		Type:        pb.Transaction_CHAINCODE_INVOKE,
+		ChaincodeID: []byte("chaincode"),
+		Payload:     []byte{0, 1, 2, 0xff},
+		Uuid:        "uuid",
+		Timestamp:   &google_protobuf.Timestamp{Seconds: 1234567890, Nanos: 42},
+		Nonce:       []byte("nonce"),
+	}
+	payload, _ := proto.Marshal(tx)
+	messages := []proto.Message{
+		tx,
+		&pb.Message{Type: pb.Message_CHAIN_TRANSACTION, Payload: payload, Timestamp: tx.Timestamp},
+		&pb.Response{Status: pb.Response_FAILURE, Msg: []byte("error")},
+		&pb.BlockchainInfo{Height: 1 << 60, CurrentBlockHash: []byte("hash")},
+		&pb.Message{},
+	}
+
+	for _, name := range []string{ProtobufCodecName, JSONCodecName, CBORCodecName} {
+		codec, err := GetCodec(name)
+		if err != nil {
+			t.Fatalf("Expected codec %s to be registered: %s", name, err)
+		}
+		for _, msg := range messages {
+			data, err := codec.Marshal(msg)
+			if err != nil {
+				t.Fatalf("Codec %s failed to marshal %v: %s", name, msg, err)
+			}
+			decoded := reflect.New(reflect.TypeOf(msg).Elem()).Interface().(proto.Message)
+			if err := codec.Unmarshal(data, decoded); err != nil {
+				t.Fatalf("Codec %s failed to unmarshal %v: %s", name, msg, err)
+			}
+			if !proto.Equal(msg, decoded) {
+				t.Fatalf("Codec %s changed %v into %v", name, msg, decoded)
+			}
+		}
+		if _, err := codec.Marshal("not a message"); err == nil {
+			t.Fatalf("Expected codec %s to reject values which are not protobuf messages", name)
+		}
+	}
+
+	if _, err := GetCodec("xml"); err == nil {
+		t.Fatalf("Expected an unknown codec to be reported")
+	}
+}
+
+// CBOR encoding and decoding conform to the examples of RFC 7049, appendix A
+func TestCBOR(t *testing.T) {
+	decoded := []struct {
+		hex   string
+		value interface{}
+	}{
+		{"00", uint64(0)},
+		{"17", uint64(23)},
+		{"1818", uint64(24)},
+		{"1903e8", uint64(1000)},
+		{"1b000000e8d4a51000", uint64(1000000000000)},
+		{"20", int64(-1)},
+		{"3903e7", int64(-1000)},
+		{"f93c00", 1.0},
+		{"f9c400", -4.0},
+		{"fa47c35000", 100000.0},
+		{"fb3ff199999999999a", 1.1},
+		{"f4", false},
+		{"f5", true},
+		{"f6", nil},
+		{"4401020304", "AQIDBA=="},
+		{"6449455446", "IETF"},
+		{"80", []interface{}{}},
+		{"83010203", []interface{}{uint64(1), uint64(2), uint64(3)}},
+		{"a26161016162820203", map[string]interface{}{"a": uint64(1), "b": []interface{}{uint64(2), uint64(3)}}},
+		{"c074323031332d30332d32315432303a30343a30305a", "2013-03-21T20:04:00Z"},
+		{"9fff", []interface{}{}},
+		{"9f018202039f0405ffff", []interface{}{uint64(1), []interface{}{uint64(2), uint64(3)}, []interface{}{uint64(4), uint64(5)}}},
+		{"bf6346756ef563416d7421ff", map[string]interface{}{"Fun": true, "Amt": int64(-2)}},
+		{"7f657374726561646d696e67ff", "streaming"},
+	}
+	for _, c := range decoded {
+		data, _ := hex.DecodeString(c.hex)
+		d := &cborDecoder{data: data}
+		value, err := d.decode()
+		if err != nil {
+			t.Fatalf("Failed to decode %s: %s", c.hex, err)
+		}
+		if !reflect.DeepEqual(value, c.value) || d.pos != len(data) {
+			t.Fatalf("Expected %s to decode to %#v, got %#v", c.hex, c.value, value)
+		}
+	}
+
+	encoded := []struct {
+		json string
+		hex  string
+	}{
+		{`0`, "00"},
+		{`23`, "17"},
+		{`24`, "1818"},
+		{`1000000`, "1a000f4240"},
+		{`18446744073709551615`, "1bffffffffffffffff"},
+		{`-1000`, "3903e7"},
+		{`1.1`, "fb3ff199999999999a"},
+		{`null`, "f6"},
+		{`"IETF"`, "6449455446"},
+		{`[1,[2,3],[4,5]]`, "8301820203820405"},
+		{`{"b":[2,3],"a":1}`, "a26161016162820203"},
+	}
+	for _, c := range encoded {
+		dec := json.NewDecoder(bytes.NewReader([]byte(c.json)))
+		dec.UseNumber()
+		var value interface{}
+		dec.Decode(&value)
+		var buf bytes.Buffer
+		if err := encodeCBOR(&buf, value); err != nil {
+			t.Fatalf("Failed to encode %s: %s", c.json, err)
+		}
+		if actual := hex.EncodeToString(buf.Bytes()); actual != c.hex {
+			t.Fatalf("Expected %s to encode to %s, got %s", c.json, c.hex, actual)
+		}
+	}
+
+	for _, invalid := range []string{"", "18", "62", "a1016161", "ff", "9f01", "5f6161ff", "1c"} {
+		data, _ := hex.DecodeString(invalid)
+		if _, err := (&cborDecoder{data: data}).decode(); err == nil {
+			t.Fatalf("Expected %q to be rejected", invalid)
+		}
+	}
+}
+
+// A server serves clients of every codec, and clients which don't negotiate one
+func TestCodecNegotiation(t *testing.T) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %s", err)
+	}
+	server := NewServer()
+	healthServer := health.NewServer("protos.Peer")
+	healthServer.SetServingStatus("protos.Peer", healthpb.HealthCheckResponse_SERVING)
+	server.Register(func(g *grpc.Server) { healthpb.RegisterHealthServer(g, healthServer) })
+	go server.Serve(lis)
+	defer lis.Close()
+
+	check := func(opts ...grpc.DialOption) error {
+		opts = append(opts, grpc.WithInsecure(), grpc.WithBlock(), grpc.WithTimeout(5*time.Second))
+		conn, err := grpc.Dial(lis.Addr().String(), opts...)
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+		resp, err := healthpb.NewHealthClient(conn).Check(context.Background(), &healthpb.HealthCheckRequest{Service: "protos.Peer"})
+		if err != nil {
+			return err
+		}
+		if resp.Status != healthpb.HealthCheckResponse_SERVING {
+			t.Fatalf("Expected the service to be serving, got %s", resp.Status)
+		}
+		return nil
+	}
+
+	if err := check(); err != nil {
+		t.Fatalf("Expected a plain gRPC client to be served: %s", err)
+	}
+	for _, name := range CodecNames() {
+		codec, _ := GetCodec(name)
+		if err := check(CodecDialOptions(codec)...); err != nil {
+			t.Fatalf("Expected a client of codec %s to be served: %s", name, err)
+		}
+	}
+
+	// A connection negotiating an unknown codec is closed
+	conn, err := net.Dial("tcp", lis.Addr().String())
+	if err != nil {
+		t.Fatalf("Failed to connect: %s", err)
+	}
+	defer conn.Close()
+	conn.Write([]byte(CodecPreamble + "xml\n"))
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := conn.Read(make([]byte, 1)); err == nil {
+		t.Fatalf("Expected the connection negotiating an unknown codec to be closed")
+	}
+}
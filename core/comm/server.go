@@ -0,0 +1,211 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package comm
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+)
+
+// The codec of a connection is negotiated by the client, which opens the
+// connection with a preamble naming the codec, before the TLS handshake if
+// any:
+//
+//	FABRIC-CODEC <name>\n
+//
+// A connection without preamble, as opened by any gRPC client, uses the
+// protobuf codec. The vendored gRPC fixes the codec of a server, so a server
+// is run for every codec, all of them registering the same services, and each
+// connection is handed to the server of the codec it negotiated.
+
+// CodecPreamble starts the preamble of a connection negotiating its codec
+const CodecPreamble = "FABRIC-CODEC "
+
+const (
+	// time allowed to the client to send the preamble
+	negotiationTimeout = 10 * time.Second
+	maxCodecNameLength = 64
+)
+
+// Server serves gRPC services over every registered codec
+type Server struct {
+	servers map[string]*grpc.Server
+}
+
+// NewServer creates a gRPC server with the given options for every codec
+func NewServer(opts ...grpc.ServerOption) *Server {
+	s := &Server{servers: make(map[string]*grpc.Server)}
+	for _, name := range CodecNames() {
+		codec, _ := GetCodec(name)
+		s.servers[name] = grpc.NewServer(append(opts, grpc.CustomCodec(codec))...)
+	}
+	return s
+}
+
+// Default returns the gRPC server of the protobuf codec. Services only spoken
+// by components which use protobuf, as the chaincode shim, are registered on
+// it alone.
+func (s *Server) Default() *grpc.Server {
+	return s.servers[ProtobufCodecName]
+}
+
+// Register registers services on the gRPC server of every codec
+func (s *Server) Register(register func(grpcServer *grpc.Server)) {
+	for _, grpcServer := range s.servers {
+		register(grpcServer)
+	}
+}
+
+// Serve accepts the connections of lis, handing each of them to the gRPC
+// server of the codec it negotiates. It returns when lis fails or is closed.
+func (s *Server) Serve(lis net.Listener) error {
+	listeners := make(map[string]*codecListener)
+	for name, grpcServer := range s.servers {
+		l := &codecListener{addr: lis.Addr(), conns: make(chan net.Conn), closed: make(chan struct{})}
+		listeners[name] = l
+		go grpcServer.Serve(l)
+	}
+	defer func() {
+		for _, l := range listeners {
+			l.Close()
+		}
+	}()
+
+	for {
+		conn, err := lis.Accept()
+		if err != nil {
+			return err
+		}
+		go func() {
+			codec, negotiated, err := negotiateCodec(conn)
+			if err != nil {
+				commLogger.Warningf("Closing connection from %s: %s", conn.RemoteAddr(), err)
+				conn.Close()
+				return
+			}
+			listeners[codec].deliver(negotiated)
+		}()
+	}
+}
+
+// Stop stops the gRPC server of every codec
+func (s *Server) Stop() {
+	for _, grpcServer := range s.servers {
+		grpcServer.Stop()
+	}
+}
+
+// negotiateCodec reads the preamble of conn, if any, and returns the name of
+// the codec it negotiates and the connection to read the rest of the stream
+// from
+func negotiateCodec(conn net.Conn) (string, net.Conn, error) {
+	conn.SetReadDeadline(time.Now().Add(negotiationTimeout))
+	defer conn.SetReadDeadline(time.Time{})
+
+	r := bufio.NewReaderSize(conn, len(CodecPreamble)+maxCodecNameLength+1)
+	prefix, err := r.Peek(len(CodecPreamble))
+	if err != nil {
+		return "", nil, err
+	}
+	negotiated := &bufferedConn{Conn: conn, r: r}
+	if !bytes.Equal(prefix, []byte(CodecPreamble)) {
+		return ProtobufCodecName, negotiated, nil
+	}
+	line, err := r.ReadSlice('\n')
+	if err != nil {
+		return "", nil, fmt.Errorf("Invalid codec preamble: %s", err)
+	}
+	name := strings.TrimSpace(string(line[len(CodecPreamble):]))
+	if _, err := GetCodec(name); err != nil {
+		return "", nil, err
+	}
+	return name, negotiated, nil
+}
+
+// bufferedConn reads the bytes buffered while negotiating the codec before
+// the rest of the connection
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *bufferedConn) Read(b []byte) (int, error) {
+	return c.r.Read(b)
+}
+
+// codecListener hands the connections which negotiated a codec to its server
+type codecListener struct {
+	addr      net.Addr
+	conns     chan net.Conn
+	closeOnce sync.Once
+	closed    chan struct{}
+}
+
+func (l *codecListener) deliver(conn net.Conn) {
+	select {
+	case l.conns <- conn:
+	case <-l.closed:
+		conn.Close()
+	}
+}
+
+func (l *codecListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.closed:
+		return nil, errors.New("listener closed")
+	}
+}
+
+func (l *codecListener) Close() error {
+	l.closeOnce.Do(func() { close(l.closed) })
+	return nil
+}
+
+func (l *codecListener) Addr() net.Addr {
+	return l.addr
+}
+
+// CodecDialOptions returns the options dialing a connection which negotiates
+// the codec
+func CodecDialOptions(codec Codec) []grpc.DialOption {
+	if codec.String() == ProtobufCodecName {
+		return nil
+	}
+	preamble := []byte(CodecPreamble + codec.String() + "\n")
+	dialer := func(addr string, timeout time.Duration) (net.Conn, error) {
+		conn, err := net.DialTimeout("tcp", addr, timeout)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := conn.Write(preamble); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		return conn, nil
+	}
+	return []grpc.DialOption{grpc.WithCodec(codec), grpc.WithDialer(dialer)}
+}
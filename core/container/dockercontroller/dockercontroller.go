@@ -21,18 +21,21 @@ import (
 	"fmt"
 	"io"
 	"strings"
+	"sync"
 
 	"github.com/fsouza/go-dockerclient"
 	"github.com/hyperledger/fabric/core/container/ccintf"
 	cutil "github.com/hyperledger/fabric/core/container/util"
+	"github.com/hyperledger/fabric/core/startup"
 	"github.com/op/go-logging"
 	"github.com/spf13/viper"
 	"golang.org/x/net/context"
 )
 
 var (
-	dockerLogger = logging.MustGetLogger("dockercontroller")
-	hostConfig   = new(docker.HostConfig)
+	dockerLogger   = logging.MustGetLogger("dockercontroller")
+	hostConfig     *docker.HostConfig
+	hostConfigOnce sync.Once
 )
 
 //DockerVM is a vm. It is identified by an image id
@@ -40,20 +43,28 @@ type DockerVM struct {
 	id string
 }
 
-func init() {
-	err := viper.UnmarshalKey("vm.docker.hostConfig", hostConfig)
-	if err != nil {
-		dockerLogger.Fatalf("Load docker HostConfig wrong, error: %s", err.Error())
-	}
+// getDockerHostConfig loads the host config of the chaincode containers when
+// the first one is started, as peers which run no chaincode in Docker never
+// need it
+func getDockerHostConfig() *docker.HostConfig {
+	hostConfigOnce.Do(func() {
+		defer startup.Lazy("docker_host_config")()
+		hostConfig = new(docker.HostConfig)
+		err := viper.UnmarshalKey("vm.docker.hostConfig", hostConfig)
+		if err != nil {
+			dockerLogger.Fatalf("Load docker HostConfig wrong, error: %s", err.Error())
+		}
 
-	if hostConfig.NetworkMode == "" {
-		hostConfig.NetworkMode = "host"
-	}
+		if hostConfig.NetworkMode == "" {
+			hostConfig.NetworkMode = "host"
+		}
 
-	// not support customize
-	hostConfig.Privileged = false
+		// not support customize
+		hostConfig.Privileged = false
 
-	dockerLogger.Debug("Load docker HostConfig: %+v", hostConfig)
+		dockerLogger.Debug("Load docker HostConfig: %+v", hostConfig)
+	})
+	return hostConfig
 }
 
 func (vm *DockerVM) createContainer(ctxt context.Context, client *docker.Client, imageID string, containerID string, args []string, env []string, attachstdin bool, attachstdout bool) error {
@@ -146,7 +157,7 @@ func (vm *DockerVM) Start(ctxt context.Context, ccid ccintf.CCID, args []string,
 		}
 	}
 
-	err = client.StartContainer(containerID, hostConfig)
+	err = client.StartContainer(containerID, getDockerHostConfig())
 	if err != nil {
 		dockerLogger.Errorf("start-could not start container %s", err)
 		return err
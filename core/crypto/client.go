@@ -129,7 +129,7 @@ func CloseAllClients() (bool, []error) {
 // Private Methods
 
 func newClient() *clientImpl {
-	return &clientImpl{&nodeImpl{}, false, nil, nil, nil, nil}
+	return &clientImpl{&nodeImpl{}, false, nil, nil, nil, nil, tCertDerivation{}}
 }
 
 func closeClientInternal(client Client, force bool) error {
@@ -137,8 +137,9 @@ func (client *clientImpl) encryptTxVersion1_2(tx *obc.Transaction) error {
 
 		break
 	case obc.Transaction_CHAINCODE_INVOKE:
-		// Prepare chaincode stateKey and privateKey
-		stateKey = make([]byte, 0)
+		// Prepare chaincode privateKey. The validators encrypt the payloads
+		// of the chaincode events of the transaction under stateKey
+		stateKey = client.eventKey(tx)
 
 		privBytes, err = client.eciesSPI.SerializePrivateKey(ccPrivateKey)
 		if err != nil {
@@ -36,6 +36,7 @@ type clientImpl struct {
 	// TCA KDFKey
 	tCertOwnerKDFKey []byte
 	tCertPool        tCertPool
+	tCertDerivation  tCertDerivation
 }
 
 // NewChaincodeDeployTransaction is used to deploy chaincode.
@@ -46,7 +47,7 @@ func (client *clientImpl) NewChaincodeDeployTransaction(chaincodeDeploymentSpec
 	}
 
 	// Get next available (not yet used) transaction certificate
-	tCerts, err := client.tCertPool.GetNextTCerts(1, attributes...)
+	tCerts, err := client.getNextTxTCerts(attributes...)
 	if err != nil {
 		client.Errorf("Failed to obtain a (not yet used) TCert for Chaincode Deploy[%s].", err.Error())
 		return nil, err
@@ -93,7 +94,7 @@ func (client *clientImpl) NewChaincodeExecute(chaincodeInvocation *obc.Chaincode
 	}
 
 	// Get next available (not yet used) transaction certificate
-	tBlocks, err := client.tCertPool.GetNextTCerts(1, attributes...)
+	tBlocks, err := client.getNextTxTCerts(attributes...)
 	if err != nil {
 		client.Errorf("Failed to obtain a (not yet used) TCert [%s].", err.Error())
 		return nil, err
@@ -116,7 +117,7 @@ func (client *clientImpl) NewChaincodeQuery(chaincodeInvocation *obc.ChaincodeIn
 	}
 
 	// Get next available (not yet used) transaction certificate
-	tBlocks, err := client.tCertPool.GetNextTCerts(1, attributes...)
+	tBlocks, err := client.getNextTxTCerts(attributes...)
 	if err != nil {
 		client.Errorf("Failed to obtain a (not yet used) TCert [%s].", err.Error())
 		return nil, err
@@ -131,6 +132,18 @@ func (client *clientImpl) NewChaincodeQuery(chaincodeInvocation *obc.ChaincodeIn
 	return client.newChaincodeQueryUsingTCert(chaincodeInvocation, uuid, attributes, tBlocks[0].tCert, nil)
 }
 
+// NewAnonymousChaincodeExecute is used to execute chaincode's functions
+// anonymously, signing for a ring of the given members and this client.
+func (client *clientImpl) NewAnonymousChaincodeExecute(chaincodeInvocation *obc.ChaincodeInvocationSpec, uuid string, members ...string) (*obc.Transaction, error) {
+	// Verify that the client is initialized
+	if !client.isInitialized {
+		return nil, utils.ErrNotInitialized
+	}
+
+	// Create Transaction
+	return client.newChaincodeExecuteUsingRing(chaincodeInvocation, uuid, members)
+}
+
 // GetEnrollmentCertHandler returns a CertificateHandler whose certificate is the enrollment certificate
 func (client *clientImpl) GetEnrollmentCertificateHandler() (CertificateHandler, error) {
 	// Verify that the client is initialized
@@ -0,0 +1,112 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package crypto
+
+import (
+	"bytes"
+	"sort"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/hyperledger/fabric/core/crypto/primitives"
+	membersrvc "github.com/hyperledger/fabric/membersrvc/protos"
+	obc "github.com/hyperledger/fabric/protos"
+	"golang.org/x/net/context"
+)
+
+// getMemberEnrollmentCert reads the enrollment certificate of a member from
+// the ECA
+func (client *clientImpl) getMemberEnrollmentCert(enrollID string) ([]byte, error) {
+	req := &membersrvc.ECertReadReq{Id: &membersrvc.Identity{Id: enrollID}}
+	response, err := client.callECAReadCertificate(context.Background(), req)
+	if err != nil {
+		client.Errorf("Failed reading enrollment certificate of [%s] [%s].", enrollID, err.Error())
+		return nil, err
+	}
+
+	return response.Sign, nil
+}
+
+// getRing returns the enrollment certificates of the members and of this
+// client, sorted so that the position of the client in the ring tells
+// nothing about it
+func (client *clientImpl) getRing(members []string) ([][]byte, error) {
+	ring := [][]byte{client.enrollCert.Raw}
+	for _, member := range members {
+		if member == client.enrollID {
+			continue
+		}
+		cert, err := client.getMemberEnrollmentCert(member)
+		if err != nil {
+			return nil, err
+		}
+		ring = append(ring, cert)
+	}
+	sort.Sort(certsByDER(ring))
+
+	return ring, nil
+}
+
+type certsByDER [][]byte
+
+func (c certsByDER) Len() int           { return len(c) }
+func (c certsByDER) Swap(i, j int)      { c[i], c[j] = c[j], c[i] }
+func (c certsByDER) Less(i, j int) bool { return bytes.Compare(c[i], c[j]) < 0 }
+
+func (client *clientImpl) newChaincodeExecuteUsingRing(chaincodeInvocation *obc.ChaincodeInvocationSpec, uuid string, members []string) (*obc.Transaction, error) {
+	ring, err := client.getRing(members)
+	if err != nil {
+		return nil, err
+	}
+	keys, err := client.getRingKeys(ring)
+	if err != nil {
+		client.Errorf("Failed checking the ring [%s].", err.Error())
+		return nil, err
+	}
+
+	// Create a new transaction, without attributes as they would be read
+	// from a TCert
+	tx, err := client.createExecuteTx(chaincodeInvocation, uuid, nil, nil)
+	if err != nil {
+		client.Errorf("Failed creating new execute transaction [%s].", err.Error())
+		return nil, err
+	}
+
+	// Append the ring to the transaction, in place of the certificate
+	tx.Ring = ring
+
+	// Sign the transaction and append the signature
+	// 1. Marshall tx to bytes
+	rawTx, err := proto.Marshal(tx)
+	if err != nil {
+		client.Errorf("Failed marshaling tx [%s].", err.Error())
+		return nil, err
+	}
+
+	// 2. Ring sign rawTx
+	rawSignature, err := primitives.RingSign(keys, client.enrollPrivKey, rawTx)
+	if err != nil {
+		client.Errorf("Failed creating ring signature [% x]: [%s].", rawTx, err.Error())
+		return nil, err
+	}
+
+	// 3. Append the signature
+	tx.Signature = rawSignature
+
+	client.Debugf("Appending ring signature [% x].", rawSignature)
+
+	return tx, nil
+}
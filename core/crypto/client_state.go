@@ -68,3 +68,55 @@ func (client *clientImpl) DecryptQueryResult(queryTx *obc.Transaction, ct []byte
 	}
 	return out, nil
 }
+
+// eventKey returns the key the validators encrypt the payloads of the
+// chaincode events of executeTx under
+func (client *clientImpl) eventKey(executeTx *obc.Transaction) []byte {
+	if executeTx.ConfidentialityProtocolVersion == "1.1" {
+		return primitives.HMACAESTruncated(client.enrollChainKey.([]byte), append([]byte{7}, executeTx.Nonce...))
+	}
+	return primitives.HMACAESTruncated(client.queryStateKey, append([]byte{7}, executeTx.Nonce...))
+}
+
+// GetChaincodeEventKey returns the key the payloads of the chaincode events
+// of executeTx are encrypted under
+func (client *clientImpl) GetChaincodeEventKey(executeTx *obc.Transaction) ([]byte, error) {
+	// Verify that the client is initialized
+	if !client.isInitialized {
+		return nil, utils.ErrNotInitialized
+	}
+
+	switch executeTx.ConfidentialityProtocolVersion {
+	case "1.1", "1.2":
+		return client.eventKey(executeTx), nil
+	}
+
+	return nil, utils.ErrInvalidConfidentialityProtocol
+}
+
+// DecryptChaincodeEvent is used to decrypt the payload of a chaincode event
+// of an execute transaction
+func (client *clientImpl) DecryptChaincodeEvent(executeTx *obc.Transaction, payload []byte) ([]byte, error) {
+	eventKey, err := client.GetChaincodeEventKey(executeTx)
+	if err != nil {
+		return nil, err
+	}
+
+	out, err := DecryptChaincodeEventPayload(eventKey, payload)
+	if err != nil {
+		client.Errorf("Failed decrypting chaincode event [%s].", err.Error())
+		return nil, err
+	}
+	return out, nil
+}
+
+// DecryptChaincodeEventPayload decrypts the payload of a chaincode event
+// under the key returned by Client.GetChaincodeEventKey, for the subscribers
+// the submitter of the transaction handed it to
+func DecryptChaincodeEventPayload(eventKey, payload []byte) ([]byte, error) {
+	ee, err := newEventEncryptor(eventKey)
+	if err != nil {
+		return nil, err
+	}
+	return ee.Decrypt(payload)
+}
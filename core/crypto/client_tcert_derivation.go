@@ -0,0 +1,158 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package crypto
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/x509"
+	"errors"
+	"sync"
+
+	"github.com/hyperledger/fabric/core/crypto/primitives"
+	"github.com/hyperledger/fabric/core/crypto/utils"
+	obc "github.com/hyperledger/fabric/protos"
+)
+
+// Rather than getting a TCert from the TCA for every transaction, a client may
+// sign several transactions with one-time keys it derives locally from the
+// secret key of a single TCert. The derivation is hardened, so the derived
+// keys cannot be computed from the TCert. Transactions carry the index of the
+// key they are signed with, the derived public key, and the signature of its
+// delegation by the key of the TCert, which validators check before verifying
+// the transaction against the derived key. The transactions signed with keys
+// derived from a TCert are linkable through it.
+
+// derivedTCert signs with the one-time key of some index derived from the key
+// of a TCert
+type derivedTCert struct {
+	tCert
+	index      uint64
+	sk         *ecdsa.PrivateKey
+	delegation []byte // signature of the delegation of sk by the TCert key
+}
+
+// Sign signs a msg with the derived secret key and returns the signature.
+func (tCert *derivedTCert) Sign(msg []byte) ([]byte, error) {
+	return primitives.ECDSASign(tCert.sk, msg)
+}
+
+// Verify verifies signature and message using the derived public key.
+func (tCert *derivedTCert) Verify(signature, msg []byte) error {
+	ok, err := primitives.ECDSAVerify(&tCert.sk.PublicKey, msg, signature)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return utils.ErrInvalidSignature
+	}
+	return nil
+}
+
+// setCertKey records in tx the derived key tCert signs with, if any
+func setCertKey(tx *obc.Transaction, tCert tCert) {
+	derived, ok := tCert.(*derivedTCert)
+	if !ok {
+		return
+	}
+	pk := &derived.sk.PublicKey
+	tx.CertKeyIndex = derived.index
+	tx.CertKey = elliptic.Marshal(pk.Curve, pk.X, pk.Y)
+	tx.CertKeySignature = derived.delegation
+}
+
+// tCertDerivation hands out TCerts signing with derived keys, taking a new
+// TCert from the pool once all the keys of the current one were handed out
+type tCertDerivation struct {
+	sync.Mutex
+	current map[string]*derivedTCert // last handed out, by attributes hash
+}
+
+// getNextTxTCerts returns the TCert to sign the next transaction with
+func (client *clientImpl) getNextTxTCerts(attributes ...string) ([]*TCertBlock, error) {
+	keys := client.conf.getTCertDerivedKeys()
+	if keys <= 0 {
+		return client.tCertPool.GetNextTCerts(1, attributes...)
+	}
+
+	attributesHash := calculateAttributesHash(attributes)
+	client.tCertDerivation.Lock()
+	defer client.tCertDerivation.Unlock()
+	if client.tCertDerivation.current == nil {
+		client.tCertDerivation.current = make(map[string]*derivedTCert)
+	}
+
+	current := client.tCertDerivation.current[attributesHash]
+	if current == nil || current.index >= uint64(keys) {
+		tBlocks, err := client.tCertPool.GetNextTCerts(1, attributes...)
+		if err != nil || len(tBlocks) != 1 {
+			return tBlocks, err
+		}
+		tCert, ok := tBlocks[0].tCert.(*tCertImpl)
+		if !ok {
+			return nil, errors.New("Failed deriving keys from TCert. Unexpected TCert type.")
+		}
+		if _, ok := tCert.sk.(*ecdsa.PrivateKey); !ok {
+			return nil, errors.New("Failed deriving keys from TCert. Unexpected secret key type.")
+		}
+		current = &derivedTCert{tCert: tCert}
+	}
+
+	// the keys are derived from that of the TCert, whatever the index
+	base := current.tCert.(*tCertImpl)
+	baseSK := base.sk.(*ecdsa.PrivateKey)
+	next := &derivedTCert{tCert: base, index: current.index + 1}
+	next.sk = primitives.DeriveHardenedKey(baseSK, next.index)
+	delegation, err := primitives.ECDSASign(baseSK, primitives.DerivedKeyDelegation(&next.sk.PublicKey, next.index))
+	if err != nil {
+		return nil, err
+	}
+	next.delegation = delegation
+	client.tCertDerivation.current[attributesHash] = next
+	client.Debugf("Signing with key [%d] derived from TCert [% x].", next.index, base.cert.Raw)
+
+	return []*TCertBlock{{tCert: next, attributesHash: attributesHash}}, nil
+}
+
+// txVerificationKey returns the public key the signature of tx, carrying
+// certificate cert, verifies against, after checking the delegation of a
+// derived key by the certified key
+func txVerificationKey(cert *x509.Certificate, tx *obc.Transaction) (interface{}, error) {
+	if tx.CertKeyIndex == 0 {
+		if len(tx.CertKey) != 0 || len(tx.CertKeySignature) != 0 {
+			return nil, errors.New("Failed getting the transaction key. A derived key is carried without its index.")
+		}
+		return cert.PublicKey, nil
+	}
+	pk, ok := cert.PublicKey.(*ecdsa.PublicKey)
+	if !ok {
+		return nil, errors.New("Failed getting the transaction key. The certificate key is not an ECDSA key.")
+	}
+	x, y := elliptic.Unmarshal(pk.Curve, tx.CertKey)
+	if x == nil {
+		return nil, errors.New("Failed getting the transaction key. Invalid derived key.")
+	}
+	derived := &ecdsa.PublicKey{Curve: pk.Curve, X: x, Y: y}
+	ok, err := primitives.ECDSAVerify(pk, primitives.DerivedKeyDelegation(derived, tx.CertKeyIndex), tx.CertKeySignature)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, errors.New("Failed getting the transaction key. The derived key is not delegated by the certified key.")
+	}
+	return derived, nil
+}
@@ -199,6 +199,7 @@ func (client *clientImpl) newChaincodeDeployUsingTCert(chaincodeDeploymentSpec *
 	// Append the certificate to the transaction
 	client.Debugf("Appending certificate [% x].", tCert.GetCertificate().Raw)
 	tx.Cert = tCert.GetCertificate().Raw
+	setCertKey(tx, tCert)
 
 	// Sign the transaction and append the signature
 	// 1. Marshall tx to bytes
@@ -236,6 +237,7 @@ func (client *clientImpl) newChaincodeExecuteUsingTCert(chaincodeInvocation *obc
 	// Append the certificate to the transaction
 	client.Debugf("Appending certificate [% x].", tCert.GetCertificate().Raw)
 	tx.Cert = tCert.GetCertificate().Raw
+	setCertKey(tx, tCert)
 
 	// Sign the transaction and append the signature
 	// 1. Marshall tx to bytes
@@ -273,6 +275,7 @@ func (client *clientImpl) newChaincodeQueryUsingTCert(chaincodeInvocation *obc.C
 	// Append the certificate to the transaction
 	client.Debugf("Appending certificate [% x].", tCert.GetCertificate().Raw)
 	tx.Cert = tCert.GetCertificate().Raw
+	setCertKey(tx, tCert)
 
 	// Sign the transaction and append the signature
 	// 1. Marshall tx to bytes
@@ -420,6 +423,10 @@ func (client *clientImpl) checkTransaction(tx *obc.Transaction) error {
 		return utils.ErrTransactionMissingCert
 	}
 
+	if len(tx.Ring) != 0 {
+		return client.verifyRingSignature(tx)
+	}
+
 	if tx.Cert != nil && tx.Signature != nil {
 		// Verify the transaction
 		// 1. Unmarshal cert
@@ -441,7 +448,12 @@ func (client *clientImpl) checkTransaction(tx *obc.Transaction) error {
 		tx.Signature = signature
 
 		// 2. Verify signature
-		ver, err := client.verify(cert.PublicKey, rawTx, tx.Signature)
+		verKey, err := txVerificationKey(cert, tx)
+		if err != nil {
+			client.Errorf("Failed getting the verification key [%s].", err.Error())
+			return err
+		}
+		ver, err := client.verify(verKey, rawTx, tx.Signature)
 		if err != nil {
 			client.Errorf("Failed marshaling tx [%s].", err.Error())
 			return err
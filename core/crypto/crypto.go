@@ -57,9 +57,23 @@ type Client interface {
 	// NewChaincodeQuery is used to query chaincode's functions.
 	NewChaincodeQuery(chaincodeInvocation *obc.ChaincodeInvocationSpec, uuid string, attributes ...string) (*obc.Transaction, error)
 
+	// NewAnonymousChaincodeExecute is used to execute chaincode's functions
+	// anonymously: the transaction proves that one of the members, or this
+	// client, issued it without revealing which one.
+	NewAnonymousChaincodeExecute(chaincodeInvocation *obc.ChaincodeInvocationSpec, uuid string, members ...string) (*obc.Transaction, error)
+
 	// DecryptQueryResult is used to decrypt the result of a query transaction
 	DecryptQueryResult(queryTx *obc.Transaction, result []byte) ([]byte, error)
 
+	// GetChaincodeEventKey returns the key the payloads of the chaincode
+	// events of executeTx are encrypted under, to be handed to the
+	// subscribers authorized to read them
+	GetChaincodeEventKey(executeTx *obc.Transaction) ([]byte, error)
+
+	// DecryptChaincodeEvent is used to decrypt the payload of a chaincode
+	// event of an execute transaction
+	DecryptChaincodeEvent(executeTx *obc.Transaction, payload []byte) ([]byte, error)
+
 	// GetEnrollmentCertHandler returns a CertificateHandler whose certificate is the enrollment certificate
 	GetEnrollmentCertificateHandler() (CertificateHandler, error)
 
@@ -109,6 +123,11 @@ type Peer interface {
 	// executeTx can also correspond to a deploy transaction.
 	GetStateEncryptor(deployTx, executeTx *obc.Transaction) (StateEncryptor, error)
 
+	// GetEventEncryptor returns a StateEncryptor for the payloads of the
+	// chaincode events of executeTx, under a key its submitter derives.
+	// It returns utils.ErrNoEventKey if executeTx carries no such key.
+	GetEventEncryptor(executeTx *obc.Transaction) (StateEncryptor, error)
+
 	GetTransactionBinding(tx *obc.Transaction) ([]byte, error)
 }
 
@@ -29,11 +29,13 @@ import (
 	"reflect"
 	"testing"
 
+	"crypto/elliptic"
 	"crypto/rand"
 
 	"runtime"
 	"time"
 
+	"github.com/golang/protobuf/proto"
 	"github.com/hyperledger/fabric/core/crypto/attributes"
 	"github.com/hyperledger/fabric/core/crypto/primitives"
 	"github.com/hyperledger/fabric/core/crypto/utils"
@@ -1078,6 +1080,116 @@ func TestValidatorExecuteTransaction(t *testing.T) {
 	}
 }
 
+func TestValidatorDerivedKeyTransaction(t *testing.T) {
+	initNodes()
+	defer closeNodes()
+
+	conf := invoker.(*clientImpl).conf
+	conf.tCertDerivedKeys = 2
+	defer func() { conf.tCertDerivedKeys = 0 }()
+
+	var certs [][]byte
+	for i := 0; i < 3; i++ {
+		_, tx, err := createPublicExecuteTransaction(t)
+		if err != nil {
+			t.Fatalf("Failed creating execute transaction [%s].", err)
+		}
+		if tx.CertKeyIndex != uint64(i%2+1) {
+			t.Fatalf("Transaction [%d] should be signed with derived key [%d], got [%d].", i, i%2+1, tx.CertKeyIndex)
+		}
+		certs = append(certs, tx.Cert)
+
+		if _, err := validator.TransactionPreValidation(tx); err != nil {
+			t.Fatalf("Transaction signed with a derived key should validate [%s].", err)
+		}
+
+		tx.CertKeyIndex++
+		if _, err := validator.TransactionPreValidation(tx); err == nil {
+			t.Fatalf("Transaction claiming the wrong derived key should not validate.")
+		}
+		tx.CertKeyIndex--
+
+		// a key not delegated by the certified key, signing the transaction
+		rogue, err := primitives.NewECDSAKey()
+		if err != nil {
+			t.Fatalf("Failed generating key [%s].", err)
+		}
+		tx.CertKey = elliptic.Marshal(rogue.Curve, rogue.X, rogue.Y)
+		tx.Signature = nil
+		rawTx, err := proto.Marshal(tx)
+		if err != nil {
+			t.Fatalf("Failed marshaling tx [%s].", err)
+		}
+		if tx.Signature, err = primitives.ECDSASign(rogue, rawTx); err != nil {
+			t.Fatalf("Failed signing tx [%s].", err)
+		}
+		if _, err := validator.TransactionPreValidation(tx); err == nil {
+			t.Fatalf("Transaction signed with a key the certified key did not delegate should not validate.")
+		}
+	}
+
+	if !reflect.DeepEqual(certs[0], certs[1]) {
+		t.Fatalf("The first two transactions should be signed with keys derived from the same TCert.")
+	}
+	if reflect.DeepEqual(certs[1], certs[2]) {
+		t.Fatalf("A new TCert should be used once its keys were all used.")
+	}
+}
+
+func TestValidatorAnonymousTransaction(t *testing.T) {
+	initNodes()
+	defer closeNodes()
+
+	deployerConf := utils.NodeConfiguration{Type: "client", Name: "user1"}
+	deployerID := deployerConf.GetEnrollmentID()
+	cis := &obc.ChaincodeInvocationSpec{
+		ChaincodeSpec: &obc.ChaincodeSpec{
+			Type:                 obc.ChaincodeSpec_GOLANG,
+			ChaincodeID:          &obc.ChaincodeID{Path: "Contract001"},
+			CtorMsg:              nil,
+			ConfidentialityLevel: obc.ConfidentialityLevel_PUBLIC,
+		},
+	}
+
+	tx, err := invoker.NewAnonymousChaincodeExecute(cis, util.GenerateUUID(), deployerID)
+	if err != nil {
+		t.Fatalf("Failed creating anonymous execute transaction [%s].", err)
+	}
+	if tx.Cert != nil || len(tx.Ring) != 2 {
+		t.Fatalf("Anonymous transaction should carry a ring of two members and no certificate.")
+	}
+	if _, err := validator.TransactionPreValidation(tx); err != nil {
+		t.Fatalf("Anonymous transaction should validate [%s].", err)
+	}
+	if err := invoker.(*clientImpl).checkTransaction(tx); err != nil {
+		t.Fatalf("Anonymous transaction should check [%s].", err)
+	}
+
+	// A ring the signer is not a member of does not validate
+	ring := tx.Ring
+	tx.Ring = [][]byte{ring[0], ring[0]}
+	if _, err := validator.TransactionPreValidation(tx); err == nil {
+		t.Fatalf("Transaction with a ring holding the same member twice should not validate.")
+	}
+	tx.Ring = [][]byte{ring[1], ring[0]}
+	if _, err := validator.TransactionPreValidation(tx); err == nil {
+		t.Fatalf("Transaction with a reordered ring should not validate.")
+	}
+	tx.Ring = ring
+
+	// A ring too small does not validate
+	tx.Ring = ring[:1]
+	if _, err := validator.TransactionPreValidation(tx); err == nil {
+		t.Fatalf("Transaction with a ring of a single member should not validate.")
+	}
+	tx.Ring = ring
+
+	tx.Payload = []byte("tampered")
+	if _, err := validator.TransactionPreValidation(tx); err == nil {
+		t.Fatalf("Tampered anonymous transaction should not validate.")
+	}
+}
+
 func TestValidatorQueryTransaction(t *testing.T) {
 	initNodes()
 	defer closeNodes()
@@ -1297,6 +1409,77 @@ func TestValidatorStateEncryptor(t *testing.T) {
 
 }
 
+func TestValidatorEventEncryptor(t *testing.T) {
+	initNodes()
+	defer closeNodes()
+
+	_, invokeTx, err := createConfidentialExecuteTransaction(t)
+	if err != nil {
+		t.Fatalf("Failed creating invoke transaction [%s]", err)
+	}
+
+	// Transactions must be PreExecuted by the validators before getting the event encryptor
+	if _, err = validator.TransactionPreValidation(invokeTx); err != nil {
+		t.Fatalf("Failed pre-validating invoke transaction [%s].", err)
+	}
+	execTx, err := validator.TransactionPreExecution(invokeTx)
+	if err != nil {
+		t.Fatalf("Failed pre-executing invoke transaction [%s].", err)
+	}
+
+	ee, err := validator.GetEventEncryptor(execTx)
+	if err != nil {
+		t.Fatalf("Failed creating event encryptor [%s].", err)
+	}
+	pt := []byte("Hello World")
+	ct, err := ee.Encrypt(pt)
+	if err != nil {
+		t.Fatalf("Failed encrypting event payload [%s].", err)
+	}
+	if bytes.Contains(ct, pt) {
+		t.Fatal("Encrypted event payload must not contain the payload")
+	}
+
+	// All the validators must record the same event
+	ct2, err := ee.Encrypt(pt)
+	if err != nil {
+		t.Fatalf("Failed encrypting event payload [%s].", err)
+	}
+	if !bytes.Equal(ct, ct2) {
+		t.Fatal("Encryption of event payloads must be deterministic")
+	}
+
+	aPt, err := invoker.DecryptChaincodeEvent(invokeTx, ct)
+	if err != nil {
+		t.Fatalf("Failed decrypting event payload [%s].", err)
+	}
+	if !bytes.Equal(pt, aPt) {
+		t.Fatalf("Failed decrypting event payload [%s != %s]", string(pt), string(aPt))
+	}
+
+	// Subscribers handed the event key can decrypt too
+	eventKey, err := invoker.GetChaincodeEventKey(invokeTx)
+	if err != nil {
+		t.Fatalf("Failed getting event key [%s].", err)
+	}
+	aPt, err = DecryptChaincodeEventPayload(eventKey, ct)
+	if err != nil {
+		t.Fatalf("Failed decrypting event payload [%s].", err)
+	}
+	if !bytes.Equal(pt, aPt) {
+		t.Fatalf("Failed decrypting event payload [%s != %s]", string(pt), string(aPt))
+	}
+
+	// The key of another transaction must not decrypt it
+	_, otherTx, err := createConfidentialExecuteTransaction(t)
+	if err != nil {
+		t.Fatalf("Failed creating invoke transaction [%s]", err)
+	}
+	if _, err = invoker.DecryptChaincodeEvent(otherTx, ct); err == nil {
+		t.Fatal("Decrypting with the key of another transaction should fail")
+	}
+}
+
 func TestValidatorSignVerify(t *testing.T) {
 	initNodes()
 	defer closeNodes()
@@ -61,8 +61,10 @@ type configuration struct {
 
 	tlsServerName string
 
-	multiThreading bool
-	tCertBatchSize int
+	multiThreading   bool
+	tCertBatchSize   int
+	tCertDerivedKeys int
+	minRingSize      int
 }
 
 func (conf *configuration) init() error {
@@ -146,6 +148,15 @@ func (conf *configuration) init() error {
 		}
 	}
 
+	// Set tCertDerivedKeys, zero disables key derivation
+	conf.tCertDerivedKeys = viper.GetInt("security.tcert.derivation.keys")
+
+	// Set minRingSize, a ring of one member would reveal the signer
+	conf.minRingSize = viper.GetInt("security.anonymity.minRingSize")
+	if conf.minRingSize < 2 {
+		conf.minRingSize = 2
+	}
+
 	// Set multithread
 	conf.multiThreading = false
 	if viper.IsSet("security.multithreading.enabled") {
@@ -291,6 +302,14 @@ func (conf *configuration) getTCertBatchSize() int {
 	return conf.tCertBatchSize
 }
 
+func (conf *configuration) getTCertDerivedKeys() int {
+	return conf.tCertDerivedKeys
+}
+
+func (conf *configuration) getMinRingSize() int {
+	return conf.minRingSize
+}
+
 func (conf *configuration) GetConfidentialityProtocolVersion() string {
 	return conf.confidentialityProtocolVersion
 }
@@ -0,0 +1,105 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package crypto
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"fmt"
+	"strconv"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/hyperledger/fabric/core/crypto/primitives"
+	"github.com/hyperledger/fabric/core/crypto/utils"
+	membersrvc "github.com/hyperledger/fabric/membersrvc/protos"
+	obc "github.com/hyperledger/fabric/protos"
+)
+
+// getRingKeys returns the keys certified by the enrollment certificates of a
+// ring, after checking that the ECA issued them to distinct clients
+func (node *nodeImpl) getRingKeys(ring [][]byte) ([]*ecdsa.PublicKey, error) {
+	if len(ring) < node.conf.getMinRingSize() {
+		return nil, fmt.Errorf("Ring of [%d] members is too small, at least [%d] are required.", len(ring), node.conf.getMinRingSize())
+	}
+
+	keys := make([]*ecdsa.PublicKey, len(ring))
+	for i, raw := range ring {
+		for _, other := range ring[:i] {
+			if bytes.Equal(raw, other) {
+				return nil, fmt.Errorf("Ring member [%d] appears twice.", i)
+			}
+		}
+
+		cert, err := primitives.DERToX509Certificate(raw)
+		if err != nil {
+			return nil, fmt.Errorf("Failed parsing certificate of ring member [%d]: [%s]", i, err)
+		}
+		// Parsing the role marks its critical extension as handled
+		roleRaw, err := primitives.GetCriticalExtension(cert, ECertSubjectRole)
+		if err != nil {
+			return nil, fmt.Errorf("Failed parsing role of ring member [%d]: [%s]", i, err)
+		}
+		role, err := strconv.ParseInt(string(roleRaw), 10, 32)
+		if err != nil || membersrvc.Role(role) != membersrvc.Role_CLIENT {
+			return nil, fmt.Errorf("Ring member [%d] is not a client.", i)
+		}
+		if _, err := primitives.CheckCertAgainRoot(cert, node.ecaCertPool); err != nil {
+			return nil, fmt.Errorf("Certificate of ring member [%d] is not an enrollment certificate: [%s]", i, err)
+		}
+		key, ok := cert.PublicKey.(*ecdsa.PublicKey)
+		if !ok {
+			return nil, fmt.Errorf("Ring member [%d] does not hold an ECDSA key.", i)
+		}
+		keys[i] = key
+	}
+
+	return keys, nil
+}
+
+// verifyRingSignature verifies that the anonymous transaction tx is signed
+// by one of the members of its ring
+func (node *nodeImpl) verifyRingSignature(tx *obc.Transaction) error {
+	if tx.Cert != nil {
+		return fmt.Errorf("Anonymous transaction must not carry a certificate.")
+	}
+	if tx.Signature == nil {
+		return utils.ErrTransactionSignature
+	}
+
+	keys, err := node.getRingKeys(tx.Ring)
+	if err != nil {
+		return err
+	}
+
+	signature := tx.Signature
+	tx.Signature = nil
+	rawTx, err := proto.Marshal(tx)
+	tx.Signature = signature
+	if err != nil {
+		return err
+	}
+
+	ok, err := primitives.RingVerify(keys, rawTx, signature)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return utils.ErrInvalidTransactionSignature
+	}
+
+	return nil
+}
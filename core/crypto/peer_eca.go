@@ -100,9 +100,9 @@ func (peer *peerImpl) getEnrollmentCertByHashFromECA(id []byte) ([]byte, []byte,
 	}
 
 	if membersrvc.Role(role) != membersrvc.Role_VALIDATOR && membersrvc.Role(role) != membersrvc.Role_PEER {
-		peer.Errorf("Invalid ECertSubjectRole in enrollment certificate for signing. Not a validator or peer: [%s]", err)
+		peer.Errorf("Invalid ECertSubjectRole in enrollment certificate for signing. Not a validator or peer: [%d]", role)
 
-		return nil, nil, err
+		return nil, nil, fmt.Errorf("Invalid ECertSubjectRole in enrollment certificate for signing. Not a validator or peer: [%d]", role)
 	}
 
 	return response.Sign, response.Enc, nil
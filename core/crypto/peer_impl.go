@@ -60,7 +60,14 @@ func (peer *peerImpl) TransactionPreValidation(tx *obc.Transaction) (*obc.Transa
 	//	peer.debug("Pre validating [%s].", tx.String())
 	peer.Debugf("Tx confdential level [%s].", tx.ConfidentialityLevel.String())
 
-	if tx.Cert != nil && tx.Signature != nil {
+	if len(tx.Ring) != 0 {
+		// Verify that one of the members of the ring signed the transaction
+		if err := peer.verifyRingSignature(tx); err != nil {
+			peer.Errorf("TransactionPreValidation: failed verifying anonymous tx [%s].", err.Error())
+			return tx, err
+		}
+		peer.Debugf("Tx [%s] signed anonymously by one of [%d] members.", tx.Uuid, len(tx.Ring))
+	} else if tx.Cert != nil && tx.Signature != nil {
 		// Verify the transaction
 		// 1. Unmarshal cert
 		cert, err := primitives.DERToX509Certificate(tx.Cert)
@@ -81,8 +88,17 @@ func (peer *peerImpl) TransactionPreValidation(tx *obc.Transaction) (*obc.Transa
 		}
 		tx.Signature = signature
 
-		// 2. Verify signature
-		ok, err := peer.verify(cert.PublicKey, rawTx, tx.Signature)
+		// 2. Verify signature, against the key derived from the certified one
+		// when the transaction is signed with a derived key
+		verKey, err := txVerificationKey(cert, tx)
+		if err != nil {
+			peer.Errorf("TransactionPreExecution: failed deriving the transaction key [%s].", err.Error())
+			return tx, err
+		}
+		if tx.CertKeyIndex != 0 {
+			peer.Debugf("Tx [%s] signed with key [%d] derived from its certificate.", tx.Uuid, tx.CertKeyIndex)
+		}
+		ok, err := peer.verify(verKey, rawTx, tx.Signature)
 		if err != nil {
 			peer.Errorf("TransactionPreExecution: failed marshaling tx [%s].", err.Error())
 			return tx, err
@@ -161,6 +177,11 @@ func (peer *peerImpl) GetStateEncryptor(deployTx, invokeTx *obc.Transaction) (St
 	return nil, utils.ErrNotImplemented
 }
 
+// GetEventEncryptor is not implemented by a non-validating peer
+func (peer *peerImpl) GetEventEncryptor(executeTx *obc.Transaction) (StateEncryptor, error) {
+	return nil, utils.ErrNotImplemented
+}
+
 func (peer *peerImpl) GetTransactionBinding(tx *obc.Transaction) ([]byte, error) {
 	return primitives.Hash(append(tx.Cert, tx.Nonce...)), nil
 }
@@ -18,8 +18,10 @@ package primitives
 
 import (
 	"crypto/ecdsa"
+	"crypto/elliptic"
 	"crypto/rand"
 	"encoding/asn1"
+	"encoding/binary"
 	"math/big"
 )
 
@@ -113,3 +115,36 @@ func VerifySignCapability(tempSK interface{}, certPK interface{}) error {
 	*/
 	return nil
 }
+
+// Keys derived from a certified key are hardened: the key with a given index
+// is computed from the certified secret key, which only its owner holds, and
+// bears no relation to the certified public key. Neither the certificate nor
+// a leaked derived key thus reveals the certified key or the other derived
+// keys. The owner vouches for a derived key by signing its delegation with
+// the certified key.
+
+// DeriveHardenedKey returns the secret key with the given index derived from
+// sk, the secret key of a certified key
+func DeriveHardenedKey(sk *ecdsa.PrivateKey, index uint64) *ecdsa.PrivateKey {
+	var buf [8]byte
+	binary.BigEndian.PutUint64(buf[:], index)
+
+	// 1 <= d <= N-1
+	d := new(big.Int).SetBytes(HMAC(sk.D.Bytes(), append([]byte("hardened key"), buf[:]...)))
+	one := new(big.Int).SetInt64(1)
+	n := new(big.Int).Sub(sk.Params().N, one)
+	d.Mod(d, n)
+	d.Add(d, one)
+	x, y := sk.Curve.ScalarBaseMult(d.Bytes())
+	return &ecdsa.PrivateKey{PublicKey: ecdsa.PublicKey{Curve: sk.Curve, X: x, Y: y}, D: d}
+}
+
+// DerivedKeyDelegation returns the message the owner of a certified key signs
+// to vouch for pk, the public key with the given index derived from it
+func DerivedKeyDelegation(pk *ecdsa.PublicKey, index uint64) []byte {
+	var buf [8]byte
+	binary.BigEndian.PutUint64(buf[:], index)
+
+	msg := append([]byte("derived key delegation"), buf[:]...)
+	return append(msg, elliptic.Marshal(pk.Curve, pk.X, pk.Y)...)
+}
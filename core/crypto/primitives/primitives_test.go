@@ -195,6 +195,53 @@ func TestECDSA(t *testing.T) {
 	}
 }
 
+func TestECDSADerivedKeys(t *testing.T) {
+	key, err := NewECDSAKey()
+	if err != nil {
+		t.Fatalf("Failed generating ECDSA key [%s]", err)
+	}
+	other, err := NewECDSAKey()
+	if err != nil {
+		t.Fatalf("Failed generating ECDSA key [%s]", err)
+	}
+	msg := []byte("message")
+
+	for index := uint64(1); index < 10; index++ {
+		sk := DeriveHardenedKey(key, index)
+		if again := DeriveHardenedKey(key, index); sk.D.Cmp(again.D) != 0 {
+			t.Fatalf("Derivation should be deterministic")
+		}
+		if sk.D.Cmp(key.D) == 0 || sk.D.Cmp(DeriveHardenedKey(key, index+1).D) == 0 {
+			t.Fatalf("Derived key should differ from the certified key and from the other derived keys")
+		}
+		if sk.D.Cmp(DeriveHardenedKey(other, index).D) == 0 {
+			t.Fatalf("Keys derived from different keys should differ")
+		}
+
+		sigma, err := ECDSASign(sk, msg)
+		if err != nil {
+			t.Fatalf("Failed signing [%s]", err)
+		}
+		if ok, _ := ECDSAVerify(&sk.PublicKey, msg, sigma); !ok {
+			t.Fatalf("Failed verification.")
+		}
+
+		delegation, err := ECDSASign(key, DerivedKeyDelegation(&sk.PublicKey, index))
+		if err != nil {
+			t.Fatalf("Failed signing delegation [%s]", err)
+		}
+		if ok, _ := ECDSAVerify(&key.PublicKey, DerivedKeyDelegation(&sk.PublicKey, index), delegation); !ok {
+			t.Fatalf("Failed verifying delegation.")
+		}
+		if ok, _ := ECDSAVerify(&key.PublicKey, DerivedKeyDelegation(&sk.PublicKey, index+1), delegation); ok {
+			t.Fatalf("Delegation of another index should not verify.")
+		}
+		if ok, _ := ECDSAVerify(&other.PublicKey, DerivedKeyDelegation(&sk.PublicKey, index), delegation); ok {
+			t.Fatalf("Delegation should not verify against another certified key.")
+		}
+	}
+}
+
 func TestECDSAKeys(t *testing.T) {
 	key, err := NewECDSAKey()
 	if err != nil {
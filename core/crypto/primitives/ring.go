@@ -0,0 +1,152 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package primitives
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/asn1"
+	"errors"
+	"math/big"
+)
+
+// Ring signatures prove that a message was signed by the owner of one of the
+// keys of a ring, without revealing which one. They are Schnorr ring
+// signatures (Abe, Ohkubo and Suzuki) over the curve of the ECDSA keys: the
+// signer closes a chain of challenges c_{i+1} = H(m, ring, s_i*G + c_i*P_i)
+// running through all the keys of the ring, which only the owner of one of
+// them can do.
+
+// RingSignature represents a ring signature, C0 being the challenge of the
+// first key of the ring and S holding a response per key
+type RingSignature struct {
+	C0 *big.Int
+	S  []*big.Int
+}
+
+// ringChallenge returns H(prefix, x, y) mod N, prefix binding the message and
+// the ring
+func ringChallenge(curve elliptic.Curve, prefix []byte, x, y *big.Int) *big.Int {
+	h := NewHash()
+	h.Write(prefix)
+	h.Write(elliptic.Marshal(curve, x, y))
+	c := new(big.Int).SetBytes(h.Sum(nil))
+	return c.Mod(c, curve.Params().N)
+}
+
+func ringPrefix(ring []*ecdsa.PublicKey, msg []byte) ([]byte, error) {
+	if len(ring) == 0 {
+		return nil, errors.New("Invalid ring. It is empty.")
+	}
+	curve := ring[0].Curve
+	h := NewHash()
+	h.Write(msg)
+	prefix := h.Sum(nil)
+	for _, pk := range ring {
+		if pk.Curve != curve {
+			return nil, errors.New("Invalid ring. Keys must be on the same curve.")
+		}
+		prefix = append(prefix, elliptic.Marshal(curve, pk.X, pk.Y)...)
+	}
+	return prefix, nil
+}
+
+func randomScalar(curve elliptic.Curve) (*big.Int, error) {
+	n := new(big.Int).Sub(curve.Params().N, big.NewInt(1))
+	k, err := rand.Int(rand.Reader, n)
+	if err != nil {
+		return nil, err
+	}
+	return k.Add(k, big.NewInt(1)), nil
+}
+
+// RingSign signs msg with signKey, whose public key must be in ring
+func RingSign(ring []*ecdsa.PublicKey, signKey *ecdsa.PrivateKey, msg []byte) ([]byte, error) {
+	prefix, err := ringPrefix(ring, msg)
+	if err != nil {
+		return nil, err
+	}
+	signer := -1
+	for i, pk := range ring {
+		if pk.X.Cmp(signKey.X) == 0 && pk.Y.Cmp(signKey.Y) == 0 {
+			signer = i
+			break
+		}
+	}
+	if signer < 0 {
+		return nil, errors.New("Invalid ring. It does not hold the key of the signer.")
+	}
+	curve := signKey.Curve
+	n := curve.Params().N
+
+	u, err := randomScalar(curve)
+	if err != nil {
+		return nil, err
+	}
+	c := make([]*big.Int, len(ring))
+	s := make([]*big.Int, len(ring))
+	x, y := curve.ScalarBaseMult(u.Bytes())
+	for j := 1; j <= len(ring); j++ {
+		i := (signer + j) % len(ring)
+		c[i] = ringChallenge(curve, prefix, x, y)
+		if i == signer {
+			break
+		}
+		if s[i], err = randomScalar(curve); err != nil {
+			return nil, err
+		}
+		x, y = curve.ScalarBaseMult(s[i].Bytes())
+		cx, cy := curve.ScalarMult(ring[i].X, ring[i].Y, c[i].Bytes())
+		x, y = curve.Add(x, y, cx, cy)
+	}
+	// s_signer = u - c_signer*sk closes the chain
+	s[signer] = new(big.Int).Mul(c[signer], signKey.D)
+	s[signer].Sub(u, s[signer])
+	s[signer].Mod(s[signer], n)
+
+	return asn1.Marshal(RingSignature{c[0], s})
+}
+
+// RingVerify verifies that signature is a ring signature of msg by the owner
+// of one of the keys of ring
+func RingVerify(ring []*ecdsa.PublicKey, msg, signature []byte) (bool, error) {
+	prefix, err := ringPrefix(ring, msg)
+	if err != nil {
+		return false, err
+	}
+	sig := new(RingSignature)
+	if _, err := asn1.Unmarshal(signature, sig); err != nil {
+		return false, nil
+	}
+	if sig.C0 == nil || len(sig.S) != len(ring) {
+		return false, nil
+	}
+	curve := ring[0].Curve
+	n := curve.Params().N
+	c := sig.C0
+	for i, pk := range ring {
+		if sig.S[i].Sign() < 0 || sig.S[i].Cmp(n) >= 0 {
+			return false, nil
+		}
+		x, y := curve.ScalarBaseMult(sig.S[i].Bytes())
+		cx, cy := curve.ScalarMult(pk.X, pk.Y, c.Bytes())
+		x, y = curve.Add(x, y, cx, cy)
+		c = ringChallenge(curve, prefix, x, y)
+	}
+	return c.Cmp(sig.C0) == 0, nil
+}
@@ -76,6 +76,9 @@ var (
 	// ErrInvalidConfidentialityLevel Invalid confidentiality level
 	ErrInvalidConfidentialityLevel = errors.New("Invalid confidentiality level")
 
+	// ErrNoEventKey Transaction carries no chaincode event key
+	ErrNoEventKey = errors.New("Transaction carries no chaincode event key.")
+
 	// ErrInvalidConfidentialityProtocol Invalid confidentiality level
 	ErrInvalidConfidentialityProtocol = errors.New("Invalid confidentiality protocol")
 
@@ -390,3 +390,82 @@ func (se *queryStateEncryptor) Decrypt(raw []byte) ([]byte, error) {
 	}
 	return out, nil
 }
+
+// GetEventEncryptor returns a StateEncryptor for the payloads of the
+// chaincode events of executeTx. Under protocol 1.2 the key comes from the
+// message to the validators, and only the submitter of executeTx, who derived
+// it from its query state key, can decrypt them. Under protocol 1.1 the key
+// is derived from the chain key, as the query keys are.
+func (validator *validatorImpl) GetEventEncryptor(executeTx *obc.Transaction) (StateEncryptor, error) {
+	if executeTx.Nonce == nil || len(executeTx.Nonce) == 0 {
+		return nil, errors.New("Invalid execute nonce.")
+	}
+
+	var eventKey []byte
+	switch executeTx.ConfidentialityProtocolVersion {
+	case "1.1":
+		enrollChainKey := validator.enrollChainKey.([]byte)
+		eventKey = primitives.HMACTruncated(enrollChainKey, append([]byte{7}, executeTx.Nonce...), primitives.AESKeyLength)
+	case "1.2":
+		// The state key of a deploy transaction is the one of the chaincode
+		if executeTx.Type != obc.Transaction_CHAINCODE_INVOKE {
+			return nil, utils.ErrNoEventKey
+		}
+		var err error
+		if eventKey, err = validator.getStateKeyFromTransaction(executeTx); err != nil {
+			return nil, err
+		}
+		// Clients which predate the encryption of the events send none
+		if len(eventKey) == 0 {
+			return nil, utils.ErrNoEventKey
+		}
+	default:
+		return nil, utils.ErrInvalidConfidentialityLevel
+	}
+
+	return newEventEncryptor(eventKey)
+}
+
+// eventEncryptor encrypts the payloads of chaincode events. The encryption
+// is deterministic, the nonce being derived from the key and the payload, so
+// that all the validators produce the same events.
+type eventEncryptor struct {
+	eventKey []byte
+	gcm      cipher.AEAD
+}
+
+func newEventEncryptor(eventKey []byte) (*eventEncryptor, error) {
+	c, err := aes.NewCipher(eventKey)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(c)
+	if err != nil {
+		return nil, err
+	}
+	return &eventEncryptor{eventKey: eventKey, gcm: gcm}, nil
+}
+
+func (ee *eventEncryptor) Encrypt(msg []byte) ([]byte, error) {
+	nonce := primitives.HMACTruncated(ee.eventKey, append([]byte{8}, msg...), ee.gcm.NonceSize())
+
+	// The ciphertext is appended to the nonce
+	return ee.gcm.Seal(nonce, nonce, msg, nil), nil
+}
+
+func (ee *eventEncryptor) Decrypt(raw []byte) ([]byte, error) {
+	if len(raw) == 0 {
+		// A nil ciphertext decrypts to nil
+		return nil, nil
+	}
+
+	if len(raw) < ee.gcm.NonceSize() {
+		return nil, utils.ErrDecrypt
+	}
+
+	out, err := ee.gcm.Open(nil, raw[:ee.gcm.NonceSize()], raw[ee.gcm.NonceSize():], nil)
+	if err != nil {
+		return nil, utils.ErrDecrypt
+	}
+	return out, nil
+}
@@ -0,0 +1,240 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package db
+
+import (
+	"encoding/binary"
+	"fmt"
+	"path/filepath"
+
+	"github.com/spf13/viper"
+	"github.com/tecbot/gorocksdb"
+)
+
+// The blocks and their indexes may be stored apart from the state, in a DB of
+// their own under 'ledger.blockstore.path', for instance on cheaper storage,
+// and tuned on their own under 'ledger.blockstore.db'. The commit of a block
+// then takes two writes, one to each DB, which are no longer atomic. The
+// state, which records the height of the chain it is at, is written first:
+// a peer which stopped in between finds its state one block ahead of its
+// blocks, and the ledger rolls the state back when it starts. A state which
+// is further ahead, or behind, was restored from another backup than the
+// blocks, and the ledger refuses to start.
+
+var blockColumnfamilies = []string{blockchainCF, indexesCF}
+var stateColumnfamilies = []string{stateCF, stateDeltaCF, persistCF}
+
+// The height of the chain the state is at is stamped in the default column
+// family of the DB holding the state. It is unknown while the state is
+// synchronized from other peers, or after blocks were added without
+// committing them
+var stateHeightKey = []byte("stateHeight")
+
+// getBlockStorePath returns the path of the DB holding the blocks when they
+// are stored apart from the state, an empty string otherwise
+func getBlockStorePath() string {
+	blockStorePath := viper.GetString("ledger.blockstore.path")
+	if blockStorePath == "" {
+		return ""
+	}
+	blockStorePath = filepath.Join(blockStorePath, "blocks")
+	if filepath.Clean(blockStorePath) == filepath.Clean(getDBPath()) {
+		return ""
+	}
+	return blockStorePath
+}
+
+// createBlockStoreIfMissing creates the DB holding the blocks, when they are
+// stored apart and it does not exist yet
+func createBlockStoreIfMissing() error {
+	blockStorePath := getBlockStorePath()
+	if blockStorePath == "" {
+		return nil
+	}
+	missing, err := dirMissingOrEmpty(blockStorePath)
+	if err != nil || !missing {
+		return err
+	}
+	return createDBAt(blockStorePath)
+}
+
+// checkNoBlocksAt fails if the DB at dbPath, which is to hold the state
+// only, holds the blocks, as it was created with the blocks stored along with
+// the state
+func checkNoBlocksAt(dbPath string, tuning *dbTuning) error {
+	opts := tuning.newOptions(nil)
+	defer opts.Destroy()
+	cfs, err := gorocksdb.ListColumnFamilies(opts, dbPath)
+	if err != nil {
+		return err
+	}
+	for _, cf := range cfs {
+		if cf == blockchainCF {
+			return fmt.Errorf("The DB at [%s] holds the block chain along with the state, while 'ledger.blockstore.path' stores the blocks apart. Unset 'ledger.blockstore.path', or start from an empty DB", dbPath)
+		}
+	}
+	return nil
+}
+
+// openBlockStore opens the DB holding the blocks apart from the state
+func (openchainDB *OpenchainDB) openBlockStore(blockStorePath string) error {
+	tuning, err := getBlockStoreTuning()
+	if err != nil {
+		return err
+	}
+	db, handles, blockCache, err := openColumnFamilies(blockStorePath, tuning, blockColumnfamilies)
+	if err != nil {
+		return err
+	}
+	openchainDB.BlockDB = db
+	openchainDB.BlockchainCF = handles[blockchainCF]
+	openchainDB.IndexesCF = handles[indexesCF]
+	openchainDB.blockStoreCache = blockCache
+	dbLogger.Infof("Blocks stored apart from the state, at [%s]", blockStorePath)
+	return nil
+}
+
+// BlocksApart returns whether the blocks are stored apart from the state
+func (openchainDB *OpenchainDB) BlocksApart() bool {
+	return openchainDB.BlockDB != openchainDB.DB
+}
+
+// dbFor returns the DB holding a column family
+func (openchainDB *OpenchainDB) dbFor(cfHandler *gorocksdb.ColumnFamilyHandle) *gorocksdb.DB {
+	if cfHandler == openchainDB.BlockchainCF || cfHandler == openchainDB.IndexesCF {
+		return openchainDB.BlockDB
+	}
+	return openchainDB.DB
+}
+
+// CommitBatch holds the changes of the commit of a block. Blocks and State
+// are the same write batch, unless the blocks are stored apart
+type CommitBatch struct {
+	Blocks *gorocksdb.WriteBatch // changes to blockchainCF and indexesCF
+	State  *gorocksdb.WriteBatch // changes to the other column families
+}
+
+// NewCommitBatch returns an empty commit batch. You MUST call Destroy() when
+// you are done with it
+func (openchainDB *OpenchainDB) NewCommitBatch() *CommitBatch {
+	batch := &CommitBatch{Blocks: gorocksdb.NewWriteBatch()}
+	batch.State = batch.Blocks
+	if openchainDB.BlocksApart() {
+		batch.State = gorocksdb.NewWriteBatch()
+	}
+	return batch
+}
+
+// Destroy releases the write batches
+func (batch *CommitBatch) Destroy() {
+	if batch.State != batch.Blocks {
+		batch.State.Destroy()
+	}
+	batch.Blocks.Destroy()
+}
+
+// WriteCommitBatch writes the changes of the commit of a block, after which
+// the chain is height blocks high. The state is written first, and synced
+// when the blocks are stored apart, so that a crash never leaves the block
+// store ahead of the state
+func (openchainDB *OpenchainDB) WriteCommitBatch(batch *CommitBatch, height uint64) error {
+	batch.State.Put(stateHeightKey, encodeHeight(height))
+	opt := gorocksdb.NewDefaultWriteOptions()
+	defer opt.Destroy()
+	if batch.Blocks == batch.State {
+		return openchainDB.DB.Write(opt, batch.State)
+	}
+	stateOpt := gorocksdb.NewDefaultWriteOptions()
+	defer stateOpt.Destroy()
+	stateOpt.SetSync(true)
+	if err := openchainDB.DB.Write(stateOpt, batch.State); err != nil {
+		return err
+	}
+	return openchainDB.BlockDB.Write(opt, batch.Blocks)
+}
+
+// WriteBlocks writes a batch of changes to blockchainCF and indexesCF
+func (openchainDB *OpenchainDB) WriteBlocks(writeBatch *gorocksdb.WriteBatch) error {
+	opt := gorocksdb.NewDefaultWriteOptions()
+	defer opt.Destroy()
+	return openchainDB.BlockDB.Write(opt, writeBatch)
+}
+
+// GetStateHeight returns the height of the chain the state is at, if known
+func (openchainDB *OpenchainDB) GetStateHeight() (uint64, bool, error) {
+	opt := gorocksdb.NewDefaultReadOptions()
+	defer opt.Destroy()
+	return readStateHeight(openchainDB.DB, opt)
+}
+
+// GetStateHeightFromSnapshot returns the height of the chain the state of a
+// snapshot is at, if known
+func (openchainDB *OpenchainDB) GetStateHeightFromSnapshot(snapshot *gorocksdb.Snapshot) (uint64, bool, error) {
+	opt := gorocksdb.NewDefaultReadOptions()
+	defer opt.Destroy()
+	opt.SetSnapshot(snapshot)
+	return readStateHeight(openchainDB.DB, opt)
+}
+
+// SetStateHeight records the height of the chain the state is at
+func (openchainDB *OpenchainDB) SetStateHeight(height uint64) error {
+	opt := gorocksdb.NewDefaultWriteOptions()
+	defer opt.Destroy()
+	return openchainDB.DB.Put(opt, stateHeightKey, encodeHeight(height))
+}
+
+// ClearStateHeight records that the height of the chain the state is at is
+// unknown, before the state or the blocks change outside of a commit
+func (openchainDB *OpenchainDB) ClearStateHeight() error {
+	opt := gorocksdb.NewDefaultWriteOptions()
+	defer opt.Destroy()
+	return openchainDB.DB.Delete(opt, stateHeightKey)
+}
+
+// stampStateHeight records the height of the state of a DB which is not open
+func stampStateHeight(dbPath string, height uint64) error {
+	opts := gorocksdb.NewDefaultOptions()
+	defer opts.Destroy()
+	db, err := gorocksdb.OpenDb(opts, dbPath)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	opt := gorocksdb.NewDefaultWriteOptions()
+	defer opt.Destroy()
+	return db.Put(opt, stateHeightKey, encodeHeight(height))
+}
+
+func readStateHeight(db *gorocksdb.DB, opt *gorocksdb.ReadOptions) (uint64, bool, error) {
+	heightBytes, err := db.GetBytes(opt, stateHeightKey)
+	if err != nil {
+		return 0, false, err
+	}
+	if heightBytes == nil {
+		return 0, false, nil
+	}
+	if len(heightBytes) != 8 {
+		return 0, false, fmt.Errorf("Invalid state height stamp [%x]", heightBytes)
+	}
+	return binary.BigEndian.Uint64(heightBytes), true, nil
+}
+
+func encodeHeight(height uint64) []byte {
+	heightBytes := make([]byte, 8)
+	binary.BigEndian.PutUint64(heightBytes, height)
+	return heightBytes
+}
@@ -0,0 +1,186 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package db
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/tecbot/gorocksdb"
+)
+
+// ErrCompactionInProgress is returned when a compaction is requested while
+// another one is running
+var ErrCompactionInProgress = errors.New("A compaction of the DB is already in progress")
+
+// compactionCheckInterval is how often the scheduler checks whether it entered
+// a quiet window
+const compactionCheckInterval = time.Minute
+
+// CompactionProgressFunc is called by Compact once it is done with a column
+// family, done out of total column families have been compacted
+type CompactionProgressFunc func(columnFamily string, done int, total int)
+
+// Compact compacts the whole key range of every column family in turn, which
+// is heavy on IO. Only one compaction runs at a time.
+func (openchainDB *OpenchainDB) Compact(progress CompactionProgressFunc) error {
+	if !atomic.CompareAndSwapInt32(&openchainDB.compacting, 0, 1) {
+		return ErrCompactionInProgress
+	}
+	defer atomic.StoreInt32(&openchainDB.compacting, 0)
+
+	openchainDB.cfLock.RLock()
+	total := len(openchainDB.namedColumnFamilies())
+	openchainDB.cfLock.RUnlock()
+	for i := 0; i < total; i++ {
+		name := openchainDB.compactColumnFamily(i)
+		if progress != nil {
+			progress(name, i+1, total)
+		}
+	}
+	atomic.StoreInt64(&openchainDB.lastCompaction, time.Now().UnixNano())
+	return nil
+}
+
+// compactColumnFamily compacts the i-th column family and returns its name.
+// DeleteState must not drop the column family while it is being compacted,
+// but may drop the state between two column families.
+func (openchainDB *OpenchainDB) compactColumnFamily(i int) string {
+	openchainDB.cfLock.RLock()
+	defer openchainDB.cfLock.RUnlock()
+	cf := openchainDB.namedColumnFamilies()[i]
+	start := time.Now()
+	openchainDB.dbFor(cf.handle).CompactRangeCF(cf.handle, gorocksdb.Range{})
+	dbLogger.Infof("Compacted column family [%s] in %s", cf.name, time.Since(start))
+	return cf.name
+}
+
+// compactionWindow is a quiet period of every day, as offsets from midnight
+// local time. A window whose end precedes its start spans midnight.
+type compactionWindow struct {
+	start time.Duration
+	end   time.Duration
+}
+
+// parseCompactionWindow parses a window written as HH:MM-HH:MM
+func parseCompactionWindow(s string) (compactionWindow, error) {
+	bounds := strings.Split(s, "-")
+	if len(bounds) != 2 {
+		return compactionWindow{}, fmt.Errorf("Invalid compaction window [%s], expected HH:MM-HH:MM", s)
+	}
+	var offsets [2]time.Duration
+	for i, bound := range bounds {
+		t, err := time.Parse("15:04", strings.TrimSpace(bound))
+		if err != nil {
+			return compactionWindow{}, fmt.Errorf("Invalid compaction window [%s]: %s", s, err)
+		}
+		offsets[i] = time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute
+	}
+	if offsets[0] == offsets[1] {
+		return compactionWindow{}, fmt.Errorf("Compaction window [%s] is empty", s)
+	}
+	return compactionWindow{offsets[0], offsets[1]}, nil
+}
+
+// occurrence returns the start of the occurrence of the window t falls in, if
+// it falls in one
+func (w compactionWindow) occurrence(t time.Time) (time.Time, bool) {
+	midnight := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	offset := t.Sub(midnight)
+	switch {
+	case w.start < w.end && offset >= w.start && offset < w.end:
+		return midnight.Add(w.start), true
+	case w.start > w.end && offset >= w.start:
+		return midnight.Add(w.start), true
+	case w.start > w.end && offset < w.end:
+		return midnight.AddDate(0, 0, -1).Add(w.start), true
+	}
+	return time.Time{}, false
+}
+
+// getCompactionWindows returns the quiet windows of the configuration
+func getCompactionWindows() ([]compactionWindow, error) {
+	var windows []compactionWindow
+	for _, s := range viper.GetStringSlice("ledger.db.compaction.windows") {
+		w, err := parseCompactionWindow(s)
+		if err != nil {
+			return nil, err
+		}
+		windows = append(windows, w)
+	}
+	return windows, nil
+}
+
+// scheduleCompactions compacts the DB in every occurrence of the quiet windows
+// the DB was not already compacted in, until stop is closed. As RocksDB does
+// not compact in the background meanwhile, the DB is also compacted once
+// writes slow down for the level-0 files piling up.
+func (openchainDB *OpenchainDB) scheduleCompactions(windows []compactionWindow, stop chan struct{}) {
+	ticker := time.NewTicker(compactionCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C:
+			if openchainDB.compactionDue(windows, now) {
+				dbLogger.Info("Starting scheduled compaction of the DB")
+				if err := openchainDB.Compact(nil); err != nil {
+					dbLogger.Warningf("Skipping scheduled compaction: %s", err)
+				}
+			} else if name, files := openchainDB.level0Backlog(); files >= openchainDB.tuning.level0SlowdownWritesTrigger {
+				dbLogger.Warningf("Compacting the DB outside of the quiet windows, as column family [%s] holds %d level-0 files", name, files)
+				if err := openchainDB.Compact(nil); err != nil {
+					dbLogger.Warningf("Skipping compaction: %s", err)
+				}
+			}
+		case <-stop:
+			return
+		}
+	}
+}
+
+// level0Backlog returns the column family holding the most level-0 files,
+// and how many
+func (openchainDB *OpenchainDB) level0Backlog() (string, int) {
+	openchainDB.cfLock.RLock()
+	defer openchainDB.cfLock.RUnlock()
+	var name string
+	var max int
+	for _, cf := range openchainDB.namedColumnFamilies() {
+		files, err := strconv.Atoi(openchainDB.dbFor(cf.handle).GetPropertyCF("rocksdb.num-files-at-level0", cf.handle))
+		if err == nil && files > max {
+			name, max = cf.name, files
+		}
+	}
+	return name, max
+}
+
+// compactionDue reports whether now falls in an occurrence of a quiet window
+// the DB was not compacted in yet
+func (openchainDB *OpenchainDB) compactionDue(windows []compactionWindow, now time.Time) bool {
+	lastCompaction := time.Unix(0, atomic.LoadInt64(&openchainDB.lastCompaction))
+	for _, w := range windows {
+		if start, ok := w.occurrence(now); ok && lastCompaction.Before(start) {
+			return true
+		}
+	}
+	return false
+}
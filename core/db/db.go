@@ -22,6 +22,7 @@ import (
 	"os"
 	"path"
 	"strings"
+	"sync"
 
 	"github.com/op/go-logging"
 	"github.com/spf13/viper"
@@ -46,20 +47,64 @@ var columnfamilies = []string{
 
 // OpenchainDB encapsulates rocksdb's structures
 type OpenchainDB struct {
-	DB           *gorocksdb.DB
+	DB *gorocksdb.DB
+	// BlockDB holds the block chain and its indexes. It is DB itself, unless
+	// the blocks are stored apart from the state, see blockstore.go
+	BlockDB      *gorocksdb.DB
 	BlockchainCF *gorocksdb.ColumnFamilyHandle
 	StateCF      *gorocksdb.ColumnFamilyHandle
 	StateDeltaCF *gorocksdb.ColumnFamilyHandle
 	IndexesCF    *gorocksdb.ColumnFamilyHandle
 	PersistCF    *gorocksdb.ColumnFamilyHandle
+
+	tuning     *dbTuning
+	blockCache *gorocksdb.Cache
+	// blockStoreCache is the block cache of BlockDB, when stored apart
+	blockStoreCache *gorocksdb.Cache
+	// cfLock keeps the stats logger and compactions off the state column
+	// families while DeleteState replaces them
+	cfLock sync.RWMutex
+	// compacting is 1 while a compaction runs, lastCompaction is the time the
+	// last one completed in nanoseconds
+	compacting     int32
+	lastCompaction int64
+	// stopBackground stops the stats logger and compaction scheduler
+	stopBackground chan struct{}
+	background     sync.WaitGroup
 }
 
 var openchainDB *OpenchainDB
 var isOpen bool
 
-// CreateDB creates a rocks db database
+// beforeClose holds the functions stopping the work reading the db in the
+// background, called when the db is next closed
+var beforeClose struct {
+	sync.Mutex
+	stops []func()
+}
+
+// BeforeClose registers stop to be called when the db is next closed, before
+// it closes, so that the work reading the db in the background is done by
+// then. stop must wait for that work to be done.
+func BeforeClose(stop func()) {
+	beforeClose.Lock()
+	defer beforeClose.Unlock()
+	beforeClose.stops = append(beforeClose.stops, stop)
+}
+
+// CreateDB creates a rocks db database, along with the block store if the
+// blocks are stored apart and it does not exist yet
 func CreateDB() error {
-	dbPath := getDBPath()
+	if err := createDBAt(getDBPath()); err != nil {
+		return err
+	}
+	if err := stampStateHeight(getDBPath(), 0); err != nil {
+		return err
+	}
+	return createBlockStoreIfMissing()
+}
+
+func createDBAt(dbPath string) error {
 	dbLogger.Debugf("Creating DB at [%s]", dbPath)
 	missing, err := dirMissingOrEmpty(dbPath)
 	if err != nil {
@@ -113,8 +158,13 @@ func (openchainDB *OpenchainDB) GetFromBlockchainCF(key []byte) ([]byte, error)
 	return openchainDB.Get(openchainDB.BlockchainCF, key)
 }
 
-// GetFromBlockchainCFSnapshot get value for given key from column family in a DB snapshot - blockchainCF
+// GetFromBlockchainCFSnapshot get value for given key from column family in a DB snapshot - blockchainCF.
+// The snapshot only covers the state when the blocks are stored apart, the
+// value is then read from the current block store
 func (openchainDB *OpenchainDB) GetFromBlockchainCFSnapshot(snapshot *gorocksdb.Snapshot, key []byte) ([]byte, error) {
+	if openchainDB.BlocksApart() {
+		return openchainDB.Get(openchainDB.BlockchainCF, key)
+	}
 	return openchainDB.getFromSnapshot(snapshot, openchainDB.BlockchainCF, key)
 }
 
@@ -155,8 +205,8 @@ func (openchainDB *OpenchainDB) GetStateDeltaCFIterator() *gorocksdb.Iterator {
 	return openchainDB.GetIterator(openchainDB.StateDeltaCF)
 }
 
-// GetSnapshot returns a point-in-time view of the DB. You MUST call snapshot.Release()
-// when you are done with the snapshot.
+// GetSnapshot returns a point-in-time view of the DB, which holds the state.
+// You MUST call snapshot.Release() when you are done with the snapshot.
 func (openchainDB *OpenchainDB) GetSnapshot() *gorocksdb.Snapshot {
 	return openchainDB.DB.NewSnapshot()
 }
@@ -185,7 +235,7 @@ func createDBIfDBPathEmpty() error {
 			return nil
 		}
 	}
-	return nil
+	return createBlockStoreIfMissing()
 }
 
 func openDB() (*OpenchainDB, error) {
@@ -193,15 +243,99 @@ func openDB() (*OpenchainDB, error) {
 		return openchainDB, nil
 	}
 
+	db, err := openDBNoVersionCheck()
+	if err != nil {
+		return nil, err
+	}
+	if err = db.checkFormatVersion(); err != nil {
+		db.CloseDB()
+		return nil, err
+	}
+	windows, err := getCompactionWindows()
+	if err != nil {
+		db.CloseDB()
+		return nil, err
+	}
+
+	db.stopBackground = make(chan struct{})
+	if interval := viper.GetDuration("ledger.db.statsInterval"); interval > 0 {
+		db.background.Add(1)
+		go func() {
+			defer db.background.Done()
+			db.logStatsPeriodically(interval, db.stopBackground)
+		}()
+	}
+	if len(windows) > 0 {
+		db.background.Add(1)
+		go func() {
+			defer db.background.Done()
+			db.scheduleCompactions(windows, db.stopBackground)
+		}()
+	}
+	return db, nil
+}
+
+func openDBNoVersionCheck() (*OpenchainDB, error) {
 	dbPath := getDBPath()
-	opts := gorocksdb.NewDefaultOptions()
+	tuning, err := getDBTuning()
+	if err != nil {
+		return nil, err
+	}
+	blockStorePath := getBlockStorePath()
+	cfs := columnfamilies
+	if blockStorePath != "" {
+		if err = checkNoBlocksAt(dbPath, tuning); err != nil {
+			return nil, err
+		}
+		cfs = stateColumnfamilies
+	}
+
+	db, handles, blockCache, err := openColumnFamilies(dbPath, tuning, cfs)
+	if err != nil {
+		return nil, err
+	}
+	openchainDB := &OpenchainDB{
+		DB:           db,
+		BlockDB:      db,
+		StateCF:      handles[stateCF],
+		StateDeltaCF: handles[stateDeltaCF],
+		PersistCF:    handles[persistCF],
+		tuning:       tuning,
+		blockCache:   blockCache,
+	}
+	if blockStorePath == "" {
+		openchainDB.BlockchainCF = handles[blockchainCF]
+		openchainDB.IndexesCF = handles[indexesCF]
+		isOpen = true
+		return openchainDB, nil
+	}
+
+	if err = openchainDB.openBlockStore(blockStorePath); err != nil {
+		openchainDB.StateCF.Destroy()
+		openchainDB.StateDeltaCF.Destroy()
+		openchainDB.PersistCF.Destroy()
+		db.Close()
+		if blockCache != nil {
+			blockCache.Destroy()
+		}
+		return nil, err
+	}
+	isOpen = true
+	return openchainDB, nil
+}
+
+// openColumnFamilies opens the DB at dbPath with the given column families,
+// creating those missing, and returns their handles by name
+func openColumnFamilies(dbPath string, tuning *dbTuning, cfs []string) (*gorocksdb.DB, map[string]*gorocksdb.ColumnFamilyHandle, *gorocksdb.Cache, error) {
+	blockCache := tuning.newBlockCache()
+	opts := tuning.newOptions(blockCache)
 	defer opts.Destroy()
 
 	opts.SetCreateIfMissing(false)
 	opts.SetCreateIfMissingColumnFamilies(true)
 
 	cfNames := []string{"default"}
-	cfNames = append(cfNames, columnfamilies...)
+	cfNames = append(cfNames, cfs...)
 	var cfOpts []*gorocksdb.Options
 	for range cfNames {
 		cfOpts = append(cfOpts, opts)
@@ -211,21 +345,47 @@ func openDB() (*OpenchainDB, error) {
 
 	if err != nil {
 		fmt.Println("Error opening DB", err)
-		return nil, err
+		if blockCache != nil {
+			blockCache.Destroy()
+		}
+		return nil, nil, nil, err
 	}
-	isOpen = true
 	// XXX should we close cfHandlers[0]?
-	return &OpenchainDB{db, cfHandlers[1], cfHandlers[2], cfHandlers[3], cfHandlers[4], cfHandlers[5]}, nil
+	handles := make(map[string]*gorocksdb.ColumnFamilyHandle)
+	for i, name := range cfNames {
+		handles[name] = cfHandlers[i]
+	}
+	return db, handles, blockCache, nil
 }
 
 // CloseDB releases all column family handles and closes rocksdb
 func (openchainDB *OpenchainDB) CloseDB() {
+	beforeClose.Lock()
+	stops := beforeClose.stops
+	beforeClose.stops = nil
+	beforeClose.Unlock()
+	for _, stop := range stops {
+		stop()
+	}
+	if openchainDB.stopBackground != nil {
+		close(openchainDB.stopBackground)
+		openchainDB.background.Wait()
+	}
 	openchainDB.BlockchainCF.Destroy()
 	openchainDB.StateCF.Destroy()
 	openchainDB.StateDeltaCF.Destroy()
 	openchainDB.IndexesCF.Destroy()
 	openchainDB.PersistCF.Destroy()
+	if openchainDB.BlocksApart() {
+		openchainDB.BlockDB.Close()
+	}
 	openchainDB.DB.Close()
+	if openchainDB.blockCache != nil {
+		openchainDB.blockCache.Destroy()
+	}
+	if openchainDB.blockStoreCache != nil {
+		openchainDB.blockStoreCache.Destroy()
+	}
 	isOpen = false
 }
 
@@ -233,6 +393,11 @@ func (openchainDB *OpenchainDB) CloseDB() {
 // only used during state synchronization when creating a new state from
 // a snapshot.
 func (openchainDB *OpenchainDB) DeleteState() error {
+	openchainDB.cfLock.Lock()
+	defer openchainDB.cfLock.Unlock()
+	if err := openchainDB.ClearStateHeight(); err != nil {
+		return err
+	}
 	err := openchainDB.DB.DropColumnFamily(openchainDB.StateCF)
 	if err != nil {
 		dbLogger.Errorf("Error dropping state CF: %s", err)
@@ -243,7 +408,7 @@ func (openchainDB *OpenchainDB) DeleteState() error {
 		dbLogger.Errorf("Error dropping state delta CF: %s", err)
 		return err
 	}
-	opts := gorocksdb.NewDefaultOptions()
+	opts := openchainDB.tuning.newOptions(openchainDB.blockCache)
 	defer opts.Destroy()
 	openchainDB.StateCF, err = openchainDB.DB.CreateColumnFamily(opts, stateCF)
 	if err != nil {
@@ -262,7 +427,7 @@ func (openchainDB *OpenchainDB) DeleteState() error {
 func (openchainDB *OpenchainDB) Get(cfHandler *gorocksdb.ColumnFamilyHandle, key []byte) ([]byte, error) {
 	opt := gorocksdb.NewDefaultReadOptions()
 	defer opt.Destroy()
-	slice, err := openchainDB.DB.GetCF(opt, cfHandler, key)
+	slice, err := openchainDB.dbFor(cfHandler).GetCF(opt, cfHandler, key)
 	if err != nil {
 		fmt.Println("Error while trying to retrieve key:", key)
 		return nil, err
@@ -279,7 +444,7 @@ func (openchainDB *OpenchainDB) Get(cfHandler *gorocksdb.ColumnFamilyHandle, key
 func (openchainDB *OpenchainDB) Put(cfHandler *gorocksdb.ColumnFamilyHandle, key []byte, value []byte) error {
 	opt := gorocksdb.NewDefaultWriteOptions()
 	defer opt.Destroy()
-	err := openchainDB.DB.PutCF(opt, cfHandler, key, value)
+	err := openchainDB.dbFor(cfHandler).PutCF(opt, cfHandler, key, value)
 	if err != nil {
 		fmt.Println("Error while trying to write key:", key)
 		return err
@@ -291,7 +456,7 @@ func (openchainDB *OpenchainDB) Put(cfHandler *gorocksdb.ColumnFamilyHandle, key
 func (openchainDB *OpenchainDB) Delete(cfHandler *gorocksdb.ColumnFamilyHandle, key []byte) error {
 	opt := gorocksdb.NewDefaultWriteOptions()
 	defer opt.Destroy()
-	err := openchainDB.DB.DeleteCF(opt, cfHandler, key)
+	err := openchainDB.dbFor(cfHandler).DeleteCF(opt, cfHandler, key)
 	if err != nil {
 		fmt.Println("Error while trying to delete key:", key)
 		return err
@@ -318,7 +483,7 @@ func (openchainDB *OpenchainDB) GetIterator(cfHandler *gorocksdb.ColumnFamilyHan
 	opt := gorocksdb.NewDefaultReadOptions()
 	opt.SetFillCache(true)
 	defer opt.Destroy()
-	return openchainDB.DB.NewIteratorCF(opt, cfHandler)
+	return openchainDB.dbFor(cfHandler).NewIteratorCF(opt, cfHandler)
 }
 
 func (openchainDB *OpenchainDB) getSnapshotIterator(snapshot *gorocksdb.Snapshot, cfHandler *gorocksdb.ColumnFamilyHandle) *gorocksdb.Iterator {
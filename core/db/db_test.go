@@ -20,7 +20,9 @@ import (
 	"bytes"
 	"io/ioutil"
 	"os"
+	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/spf13/viper"
 	"github.com/tecbot/gorocksdb"
@@ -132,6 +134,150 @@ func TestDeleteState(t *testing.T) {
 	}
 }
 
+func TestDBTuning(t *testing.T) {
+	defer viper.Set("ledger.db.profile", "")
+	defer viper.Set("ledger.db.writeBufferSize", 0)
+	defer viper.Set("ledger.db.statsInterval", 0)
+
+	tuning, err := getDBTuning()
+	if err != nil {
+		t.Fatalf("Error getting default tuning: %s", err)
+	}
+	if *tuning != (dbTuning{}) {
+		t.Fatalf("Expected RocksDB defaults without a profile, got %+v", *tuning)
+	}
+
+	viper.Set("ledger.db.profile", "low-memory")
+	viper.Set("ledger.db.writeBufferSize", 4)
+	tuning, err = getDBTuning()
+	if err != nil {
+		t.Fatalf("Error getting tuning: %s", err)
+	}
+	expected := tuningProfiles["low-memory"]
+	expected.writeBufferSize = 4
+	if *tuning != expected {
+		t.Fatalf("Expected %+v, got %+v", expected, *tuning)
+	}
+
+	// the tuned DB must open, survive dropping the state and close while its
+	// stats are being logged
+	viper.Set("ledger.db.statsInterval", "1ms")
+	testDBWrapper := NewTestDBWrapper()
+	testDBWrapper.CreateFreshDB(t)
+	defer testDBWrapper.cleanup()
+	performBasicReadWrite(t)
+	if err = GetDBHandle().DeleteState(); err != nil {
+		t.Fatalf("Error deleting state of tuned DB: %s", err)
+	}
+	time.Sleep(5 * time.Millisecond)
+
+	viper.Set("ledger.db.profile", "nvme")
+	if _, err = getDBTuning(); err == nil {
+		t.Fatal("An unknown profile should be rejected")
+	}
+	viper.Set("ledger.db.profile", "")
+
+	// compacting in quiet windows disables the background compactions
+	viper.Set("ledger.db.compaction.windows", []string{"01:00-05:00"})
+	defer viper.Set("ledger.db.compaction.windows", []string{})
+	tuning, err = getDBTuning()
+	if err != nil {
+		t.Fatalf("Error getting tuning: %s", err)
+	}
+	if !tuning.disableAutoCompactions || tuning.level0SlowdownWritesTrigger != defaultDeferredLevel0SlowdownWritesTrigger ||
+		tuning.level0StopWritesTrigger != defaultDeferredLevel0StopWritesTrigger {
+		t.Fatalf("Expected the background compactions to be deferred, got %+v", *tuning)
+	}
+	viper.Set("ledger.db.compaction.level0StopWritesTrigger", 8)
+	defer viper.Set("ledger.db.compaction.level0StopWritesTrigger", 0)
+	if _, err = getDBTuning(); err == nil {
+		t.Fatal("Writes stopping before they slow down should be rejected")
+	}
+}
+
+func TestCompactionWindows(t *testing.T) {
+	for _, s := range []string{"01:00", "01:00-02:00-03:00", "1am-2am", "24:00-01:00", "03:00-03:00"} {
+		if _, err := parseCompactionWindow(s); err == nil {
+			t.Fatalf("Window [%s] should be rejected", s)
+		}
+	}
+
+	day := func(hour, min int) time.Time {
+		return time.Date(2016, 6, 2, hour, min, 0, 0, time.Local)
+	}
+	w, err := parseCompactionWindow("01:00-05:30")
+	if err != nil {
+		t.Fatalf("Error parsing window: %s", err)
+	}
+	if start, ok := w.occurrence(day(5, 29)); !ok || !start.Equal(day(1, 0)) {
+		t.Fatalf("Expected 05:29 to fall in the window starting at %s, got %s, %t", day(1, 0), start, ok)
+	}
+	if _, ok := w.occurrence(day(5, 30)); ok {
+		t.Fatal("05:30 should fall outside of the window")
+	}
+
+	// a window spanning midnight
+	w, err = parseCompactionWindow("22:00 - 02:00")
+	if err != nil {
+		t.Fatalf("Error parsing window: %s", err)
+	}
+	if start, ok := w.occurrence(day(23, 0)); !ok || !start.Equal(day(22, 0)) {
+		t.Fatalf("Expected 23:00 to fall in the window starting at %s, got %s, %t", day(22, 0), start, ok)
+	}
+	yesterday := time.Date(2016, 6, 1, 22, 0, 0, 0, time.Local)
+	if start, ok := w.occurrence(day(1, 0)); !ok || !start.Equal(yesterday) {
+		t.Fatalf("Expected 01:00 to fall in the window starting at %s, got %s, %t", yesterday, start, ok)
+	}
+	if _, ok := w.occurrence(day(12, 0)); ok {
+		t.Fatal("12:00 should fall outside of the window")
+	}
+}
+
+func TestCompact(t *testing.T) {
+	testDBWrapper := NewTestDBWrapper()
+	testDBWrapper.CreateFreshDB(t)
+	defer testDBWrapper.cleanup()
+	performBasicReadWrite(t)
+	openchainDB := GetDBHandle()
+
+	windows := []compactionWindow{{time.Hour, 5 * time.Hour}}
+	now := time.Date(2016, 6, 2, 3, 0, 0, 0, time.Local)
+	if !openchainDB.compactionDue(windows, now) {
+		t.Fatal("A compaction should be due in a window the DB was never compacted in")
+	}
+	if openchainDB.compactionDue(windows, now.Add(3*time.Hour)) {
+		t.Fatal("No compaction should be due outside of the windows")
+	}
+	allDay := []compactionWindow{{0, 24 * time.Hour}}
+	if !openchainDB.compactionDue(allDay, time.Now()) {
+		t.Fatal("A compaction should be due before the DB is compacted")
+	}
+
+	var compacted []string
+	err := openchainDB.Compact(func(columnFamily string, done int, total int) {
+		compacted = append(compacted, columnFamily)
+		if done != len(compacted) || total != len(columnfamilies) {
+			t.Fatalf("Unexpected progress %d/%d after compacting %v", done, total, compacted)
+		}
+	})
+	if err != nil {
+		t.Fatalf("Error compacting DB: %s", err)
+	}
+	if len(compacted) != len(columnfamilies) {
+		t.Fatalf("Expected every column family to be compacted, got %v", compacted)
+	}
+	if openchainDB.compactionDue(allDay, time.Now()) {
+		t.Fatal("No compaction should be due in a window the DB was already compacted in")
+	}
+	performBasicReadWrite(t)
+
+	openchainDB.compacting = 1
+	defer func() { openchainDB.compacting = 0 }()
+	if err = openchainDB.Compact(nil); err != ErrCompactionInProgress {
+		t.Fatalf("Expected ErrCompactionInProgress, got %v", err)
+	}
+}
+
 func TestDBSnapshot(t *testing.T) {
 	testDBWrapper := NewTestDBWrapper()
 	testDBWrapper.CreateFreshDB(t)
@@ -266,6 +412,52 @@ func deleteTestDBPath() {
 	os.RemoveAll(dbPath)
 }
 
+func TestBlockStoreApart(t *testing.T) {
+	viper.Set("ledger.blockstore.path", filepath.Join(viper.GetString("peer.fileSystemPath"), "store"))
+	defer viper.Set("ledger.blockstore.path", "")
+	testDBWrapper := NewTestDBWrapper()
+	testDBWrapper.CreateFreshDB(t)
+	defer testDBWrapper.cleanup()
+	openchainDB := GetDBHandle()
+	if !openchainDB.BlocksApart() {
+		t.Fatal("Expected the blocks to be stored apart from the state")
+	}
+
+	batch := openchainDB.NewCommitBatch()
+	defer batch.Destroy()
+	batch.Blocks.PutCF(openchainDB.BlockchainCF, []byte("key1"), []byte("value1"))
+	batch.State.PutCF(openchainDB.StateCF, []byte("key2"), []byte("value2"))
+	if err := openchainDB.WriteCommitBatch(batch, 1); err != nil {
+		t.Fatalf("Error writing commit batch: %s", err)
+	}
+	if value, _ := openchainDB.GetFromBlockchainCF([]byte("key1")); !bytes.Equal(value, []byte("value1")) {
+		t.Fatalf("Expected [value1] from the block store, found [%s]", value)
+	}
+	if value, _ := openchainDB.GetFromStateCF([]byte("key2")); !bytes.Equal(value, []byte("value2")) {
+		t.Fatalf("Expected [value2] from the state, found [%s]", value)
+	}
+	if height, known, err := openchainDB.GetStateHeight(); err != nil || !known || height != 1 {
+		t.Fatalf("Expected the state at height 1, found height=%d, known=%t, err=%v", height, known, err)
+	}
+	if err := openchainDB.ClearStateHeight(); err != nil {
+		t.Fatalf("Error clearing state height: %s", err)
+	}
+	if _, known, _ := openchainDB.GetStateHeight(); known {
+		t.Fatal("Expected the height of the state to be unknown")
+	}
+
+	// a DB created with the blocks along with the state is not opened with them apart
+	testDBWrapper.cleanup()
+	viper.Set("ledger.blockstore.path", "")
+	testDBWrapper.CreateFreshDB(t)
+	GetDBHandle()
+	testDBWrapper.cleanup()
+	viper.Set("ledger.blockstore.path", filepath.Join(viper.GetString("peer.fileSystemPath"), "store"))
+	if _, err := openDB(); err == nil {
+		t.Fatal("Opening a DB holding the blocks with the blocks stored apart should fail")
+	}
+}
+
 func deleteTestDB() {
 	GetDBHandle().CloseDB()
 	deleteTestDBPath()
@@ -326,3 +518,61 @@ func performBasicReadWrite(t *testing.T) {
 		t.Fatalf("read error. Bytes not equal. Expected [%s], found [%s]", "dummyValue3", value)
 	}
 }
+
+func TestDBFormatVersion(t *testing.T) {
+	testDBWrapper := NewTestDBWrapper()
+	testDBWrapper.CreateFreshDB(t)
+	openchainDB := GetDBHandle()
+	version, err := openchainDB.GetFormatVersion()
+	if err != nil {
+		t.Fatalf("Error getting format version: %s", err)
+	}
+	if version != CurrentFormatVersion {
+		t.Fatalf("Expected format version [%d], found [%d]", CurrentFormatVersion, version)
+	}
+
+	// a db written by a newer peer must not be opened
+	openchainDB.setFormatVersion(CurrentFormatVersion + 1)
+	openchainDB.CloseDB()
+	if _, err = openDB(); err == nil {
+		t.Fatal("Opening a DB with a newer format version should fail")
+	}
+	if _, _, err = UpgradeDB(); err == nil {
+		t.Fatal("Upgrading a DB with a newer format version should fail")
+	}
+
+	// a db written by an older peer must be upgraded before it can be opened
+	openchainDB, _ = openDBNoVersionCheck()
+	openchainDB.setFormatVersion(CurrentFormatVersion - 1)
+	openchainDB.CloseDB()
+	if _, err = openDB(); err == nil {
+		t.Fatal("Opening a DB with an older format version should fail")
+	}
+	if _, _, err = UpgradeDB(); err == nil {
+		t.Fatal("Upgrading without a registered migration should fail")
+	}
+
+	migrated := false
+	err = RegisterMigration(Migration{FromVersion: CurrentFormatVersion - 1, Description: "test migration",
+		Migrate: func(openchainDB *OpenchainDB) error {
+			migrated = true
+			return nil
+		}})
+	if err != nil {
+		t.Fatalf("Error registering migration: %s", err)
+	}
+	defer delete(migrations, CurrentFormatVersion-1)
+
+	from, to, err := UpgradeDB()
+	if err != nil {
+		t.Fatalf("Error upgrading DB: %s", err)
+	}
+	if !migrated || from != CurrentFormatVersion-1 || to != CurrentFormatVersion {
+		t.Fatalf("Unexpected upgrade result: migrated=%t, from=%d, to=%d", migrated, from, to)
+	}
+	openchainDB, err = openDB()
+	if err != nil {
+		t.Fatalf("Error opening upgraded DB: %s", err)
+	}
+	openchainDB.CloseDB()
+}
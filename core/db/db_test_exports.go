@@ -129,10 +129,10 @@ func (testDB *TestDBWrapper) CloseDB(t testing.TB) {
 func (testDB *TestDBWrapper) GetEstimatedNumKeys(t testing.TB) map[string]string {
 	openchainDB := GetDBHandle()
 	result := make(map[string]string, 5)
-	result["stateCF"] = openchainDB.DB.GetPropertyCF("rocksdb.estimate-num-keys", openchainDB.StateCF)
-	result["stateDeltaCF"] = openchainDB.DB.GetPropertyCF("rocksdb.estimate-num-keys", openchainDB.StateDeltaCF)
-	result["blockchainCF"] = openchainDB.DB.GetPropertyCF("rocksdb.estimate-num-keys", openchainDB.BlockchainCF)
-	result["indexCF"] = openchainDB.DB.GetPropertyCF("rocksdb.estimate-num-keys", openchainDB.IndexesCF)
+	result["stateCF"] = openchainDB.dbFor(openchainDB.StateCF).GetPropertyCF("rocksdb.estimate-num-keys", openchainDB.StateCF)
+	result["stateDeltaCF"] = openchainDB.dbFor(openchainDB.StateDeltaCF).GetPropertyCF("rocksdb.estimate-num-keys", openchainDB.StateDeltaCF)
+	result["blockchainCF"] = openchainDB.dbFor(openchainDB.BlockchainCF).GetPropertyCF("rocksdb.estimate-num-keys", openchainDB.BlockchainCF)
+	result["indexCF"] = openchainDB.dbFor(openchainDB.IndexesCF).GetPropertyCF("rocksdb.estimate-num-keys", openchainDB.IndexesCF)
 	return result
 }
 
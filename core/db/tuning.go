@@ -0,0 +1,277 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package db
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/tecbot/gorocksdb"
+)
+
+// dbTuning holds the RocksDB options the DB is opened with. A zero value keeps
+// the RocksDB default of the option.
+type dbTuning struct {
+	blockCacheSize                 int // MB, shared by all column families
+	blockSize                      int // KB
+	bloomBitsPerKey                int
+	writeBufferSize                int // MB
+	maxWriteBufferNumber           int
+	maxBackgroundCompactions       int
+	maxOpenFiles                   int // -1 keeps all files open
+	level0FileNumCompactionTrigger int
+	targetFileSizeBase             int // MB
+
+	// set when the DB is compacted in quiet windows rather than in the
+	// background
+	disableAutoCompactions      bool
+	level0SlowdownWritesTrigger int
+	level0StopWritesTrigger     int
+}
+
+// Level-0 files at which writes slow down and stop, while compactions wait
+// for a quiet window, unless set in the configuration
+const (
+	defaultDeferredLevel0SlowdownWritesTrigger = 256
+	defaultDeferredLevel0StopWritesTrigger     = 512
+)
+
+// tuningProfiles are the presets selected through 'ledger.db.profile'
+var tuningProfiles = map[string]dbTuning{
+	// Fast random reads, so small blocks and many parallel compactions
+	"ssd": {
+		blockCacheSize:                 512,
+		blockSize:                      16,
+		bloomBitsPerKey:                10,
+		writeBufferSize:                64,
+		maxWriteBufferNumber:           4,
+		maxBackgroundCompactions:       4,
+		maxOpenFiles:                   -1,
+		level0FileNumCompactionTrigger: 4,
+		targetFileSizeBase:             64,
+	},
+	// Seeks are expensive, so large blocks and files, and fewer, larger
+	// compactions
+	"hdd": {
+		blockCacheSize:                 256,
+		blockSize:                      64,
+		bloomBitsPerKey:                10,
+		writeBufferSize:                128,
+		maxWriteBufferNumber:           4,
+		maxBackgroundCompactions:       2,
+		maxOpenFiles:                   1024,
+		level0FileNumCompactionTrigger: 8,
+		targetFileSizeBase:             256,
+	},
+	// Bounds the memory held by caches and memtables
+	"low-memory": {
+		blockCacheSize:                 16,
+		blockSize:                      4,
+		bloomBitsPerKey:                10,
+		writeBufferSize:                8,
+		maxWriteBufferNumber:           2,
+		maxBackgroundCompactions:       1,
+		maxOpenFiles:                   256,
+		level0FileNumCompactionTrigger: 4,
+		targetFileSizeBase:             16,
+	},
+}
+
+// getDBTuning returns the tuning of the profile named in the configuration,
+// with the options set in the configuration overriding those of the profile
+func getDBTuning() (*dbTuning, error) {
+	tuning := &dbTuning{}
+	if err := tuning.configure("ledger.db"); err != nil {
+		return nil, err
+	}
+	if err := tuning.deferCompactions(); err != nil {
+		return nil, err
+	}
+	return tuning, nil
+}
+
+// getBlockStoreTuning returns the tuning of the block store, when stored
+// apart. It is the tuning of the DB, as changed by the profile and the
+// options set under 'ledger.blockstore.db'
+func getBlockStoreTuning() (*dbTuning, error) {
+	tuning, err := getDBTuning()
+	if err != nil {
+		return nil, err
+	}
+	if err = tuning.configure("ledger.blockstore.db"); err != nil {
+		return nil, err
+	}
+	if err = tuning.deferCompactions(); err != nil {
+		return nil, err
+	}
+	return tuning, nil
+}
+
+// configure applies the profile and the options set under prefix
+func (tuning *dbTuning) configure(prefix string) error {
+	if profile := viper.GetString(prefix + ".profile"); profile != "" {
+		preset, ok := tuningProfiles[profile]
+		if !ok {
+			return fmt.Errorf("Unknown DB tuning profile [%s], expected one of 'ssd', 'hdd' or 'low-memory'", profile)
+		}
+		*tuning = preset
+	}
+
+	overrides := map[string]*int{
+		".blockCacheSize":                 &tuning.blockCacheSize,
+		".blockSize":                      &tuning.blockSize,
+		".bloomBitsPerKey":                &tuning.bloomBitsPerKey,
+		".writeBufferSize":                &tuning.writeBufferSize,
+		".maxWriteBufferNumber":           &tuning.maxWriteBufferNumber,
+		".maxBackgroundCompactions":       &tuning.maxBackgroundCompactions,
+		".maxOpenFiles":                   &tuning.maxOpenFiles,
+		".level0FileNumCompactionTrigger": &tuning.level0FileNumCompactionTrigger,
+		".targetFileSizeBase":             &tuning.targetFileSizeBase,
+	}
+	for key, option := range overrides {
+		if value := viper.GetInt(prefix + key); value != 0 {
+			*option = value
+		}
+	}
+	return nil
+}
+
+// deferCompactions disables the background compactions of RocksDB when the
+// DB is compacted in quiet windows, and raises the number of level-0 files at
+// which writes slow down and stop, as they pile up until the next window
+func (tuning *dbTuning) deferCompactions() error {
+	if len(viper.GetStringSlice("ledger.db.compaction.windows")) == 0 {
+		return nil
+	}
+	tuning.disableAutoCompactions = true
+	tuning.level0SlowdownWritesTrigger = viper.GetInt("ledger.db.compaction.level0SlowdownWritesTrigger")
+	if tuning.level0SlowdownWritesTrigger <= 0 {
+		tuning.level0SlowdownWritesTrigger = defaultDeferredLevel0SlowdownWritesTrigger
+	}
+	tuning.level0StopWritesTrigger = viper.GetInt("ledger.db.compaction.level0StopWritesTrigger")
+	if tuning.level0StopWritesTrigger <= 0 {
+		tuning.level0StopWritesTrigger = defaultDeferredLevel0StopWritesTrigger
+	}
+	if tuning.level0StopWritesTrigger <= tuning.level0SlowdownWritesTrigger {
+		return fmt.Errorf("'ledger.db.compaction.level0StopWritesTrigger' [%d] must be above 'ledger.db.compaction.level0SlowdownWritesTrigger' [%d]",
+			tuning.level0StopWritesTrigger, tuning.level0SlowdownWritesTrigger)
+	}
+	return nil
+}
+
+// newBlockCache returns the block cache shared by the column families, or nil
+// if the RocksDB default cache is used
+func (tuning *dbTuning) newBlockCache() *gorocksdb.Cache {
+	if tuning.blockCacheSize <= 0 {
+		return nil
+	}
+	return gorocksdb.NewLRUCache(tuning.blockCacheSize << 20)
+}
+
+// newOptions returns options set up according to the tuning. blockCache may be
+// nil.
+func (tuning *dbTuning) newOptions(blockCache *gorocksdb.Cache) *gorocksdb.Options {
+	opts := gorocksdb.NewDefaultOptions()
+
+	if blockCache != nil || tuning.blockSize > 0 || tuning.bloomBitsPerKey > 0 {
+		bbto := gorocksdb.NewDefaultBlockBasedTableOptions()
+		if blockCache != nil {
+			bbto.SetBlockCache(blockCache)
+		}
+		if tuning.blockSize > 0 {
+			bbto.SetBlockSize(tuning.blockSize << 10)
+		}
+		if tuning.bloomBitsPerKey > 0 {
+			bbto.SetFilterPolicy(gorocksdb.NewBloomFilter(tuning.bloomBitsPerKey))
+		}
+		// The table factory copies the table options
+		opts.SetBlockBasedTableFactory(bbto)
+		bbto.Destroy()
+	}
+	if tuning.writeBufferSize > 0 {
+		opts.SetWriteBufferSize(tuning.writeBufferSize << 20)
+	}
+	if tuning.maxWriteBufferNumber > 0 {
+		opts.SetMaxWriteBufferNumber(tuning.maxWriteBufferNumber)
+	}
+	if tuning.maxBackgroundCompactions > 0 {
+		opts.SetMaxBackgroundCompactions(tuning.maxBackgroundCompactions)
+	}
+	if tuning.maxOpenFiles != 0 {
+		opts.SetMaxOpenFiles(tuning.maxOpenFiles)
+	}
+	if tuning.level0FileNumCompactionTrigger > 0 {
+		opts.SetLevel0FileNumCompactionTrigger(tuning.level0FileNumCompactionTrigger)
+	}
+	if tuning.targetFileSizeBase > 0 {
+		opts.SetTargetFileSizeBase(uint64(tuning.targetFileSizeBase) << 20)
+	}
+	if tuning.disableAutoCompactions {
+		opts.SetDisableAutoCompactions(true)
+		opts.SetLevel0SlowdownWritesTrigger(tuning.level0SlowdownWritesTrigger)
+		opts.SetLevel0StopWritesTrigger(tuning.level0StopWritesTrigger)
+	}
+	return opts
+}
+
+// namedColumnFamily is a column family of the DB along with its name
+type namedColumnFamily struct {
+	name   string
+	handle *gorocksdb.ColumnFamilyHandle
+}
+
+// namedColumnFamilies returns the column families of the DB, the caller must
+// hold cfLock
+func (openchainDB *OpenchainDB) namedColumnFamilies() []namedColumnFamily {
+	return []namedColumnFamily{
+		{blockchainCF, openchainDB.BlockchainCF},
+		{stateCF, openchainDB.StateCF},
+		{stateDeltaCF, openchainDB.StateDeltaCF},
+		{indexesCF, openchainDB.IndexesCF},
+		{persistCF, openchainDB.PersistCF},
+	}
+}
+
+// GetCompactionStats returns the compaction statistics RocksDB keeps for each
+// column family, keyed by column family name
+func (openchainDB *OpenchainDB) GetCompactionStats() map[string]string {
+	openchainDB.cfLock.RLock()
+	defer openchainDB.cfLock.RUnlock()
+	stats := make(map[string]string)
+	for _, cf := range openchainDB.namedColumnFamilies() {
+		stats[cf.name] = openchainDB.dbFor(cf.handle).GetPropertyCF("rocksdb.cfstats", cf.handle)
+	}
+	return stats
+}
+
+// logStatsPeriodically logs the compaction statistics every interval until
+// stop is closed
+func (openchainDB *OpenchainDB) logStatsPeriodically(interval time.Duration, stop chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			for cf, stats := range openchainDB.GetCompactionStats() {
+				dbLogger.Infof("Compaction stats of column family [%s]:\n%s", cf, stats)
+			}
+		case <-stop:
+			return
+		}
+	}
+}
@@ -0,0 +1,167 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package db
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/tecbot/gorocksdb"
+)
+
+// CurrentFormatVersion is the version of the on-disk format written by this
+// code. It must be incremented, and a migration registered from the previous
+// version, whenever a change is made to the way data is laid out in the db.
+const CurrentFormatVersion uint64 = 1
+
+// The format version is stamped in the default column family, which is not
+// used for anything else
+var formatVersionKey = []byte("formatVersion")
+
+// Migration upgrades the data in the db from FromVersion to FromVersion+1
+type Migration struct {
+	FromVersion uint64
+	Description string
+	Migrate     func(openchainDB *OpenchainDB) error
+}
+
+var (
+	migrationsLock sync.Mutex
+	migrations     = make(map[uint64]Migration)
+)
+
+// RegisterMigration registers a migration to be run by UpgradeDB. Only one
+// migration may be registered from a given version
+func RegisterMigration(migration Migration) error {
+	migrationsLock.Lock()
+	defer migrationsLock.Unlock()
+	if migration.FromVersion >= CurrentFormatVersion {
+		return fmt.Errorf("Cannot register migration from version [%d], current format version is [%d]", migration.FromVersion, CurrentFormatVersion)
+	}
+	if _, ok := migrations[migration.FromVersion]; ok {
+		return fmt.Errorf("A migration from version [%d] is already registered", migration.FromVersion)
+	}
+	migrations[migration.FromVersion] = migration
+	return nil
+}
+
+// GetFormatVersion returns the format version stamped in the db. A db created
+// before format versions were introduced is reported as version 1, the layout
+// of which it already follows
+func (openchainDB *OpenchainDB) GetFormatVersion() (uint64, error) {
+	opt := gorocksdb.NewDefaultReadOptions()
+	defer opt.Destroy()
+	versionBytes, err := openchainDB.DB.GetBytes(opt, formatVersionKey)
+	if err != nil {
+		return 0, err
+	}
+	if versionBytes == nil {
+		return 1, nil
+	}
+	if len(versionBytes) != 8 {
+		return 0, fmt.Errorf("Invalid format version stamp [%x]", versionBytes)
+	}
+	return binary.BigEndian.Uint64(versionBytes), nil
+}
+
+func (openchainDB *OpenchainDB) setFormatVersion(version uint64) error {
+	opt := gorocksdb.NewDefaultWriteOptions()
+	defer opt.Destroy()
+	versionBytes := make([]byte, 8)
+	binary.BigEndian.PutUint64(versionBytes, version)
+	return openchainDB.DB.Put(opt, formatVersionKey, versionBytes)
+}
+
+// checkFormatVersion verifies that the db can be used by this code. The db is
+// stamped with the current version if it does not carry a stamp yet
+func (openchainDB *OpenchainDB) checkFormatVersion() error {
+	version, err := openchainDB.GetFormatVersion()
+	if err != nil {
+		return err
+	}
+	if version > CurrentFormatVersion {
+		return fmt.Errorf("DB format version [%d] is newer than the version supported by this peer [%d]. Refusing to open the DB", version, CurrentFormatVersion)
+	}
+	if version < CurrentFormatVersion {
+		return fmt.Errorf("DB format version [%d] is older than the version supported by this peer [%d]. Run 'peer ledger upgrade' to migrate the DB", version, CurrentFormatVersion)
+	}
+	return openchainDB.setFormatVersion(version)
+}
+
+// UpgradeDB runs the registered migrations needed to bring the db to the
+// current format version. The version stamp is updated after each migration,
+// so that an interrupted upgrade resumes from the last completed migration.
+// The db must not be open
+func UpgradeDB() (from uint64, to uint64, err error) {
+	if isOpen {
+		return 0, 0, fmt.Errorf("The DB must not be open while it is upgraded")
+	}
+	if err = createDBIfDBPathEmpty(); err != nil {
+		return 0, 0, err
+	}
+	openchainDB, err := openDBNoVersionCheck()
+	if err != nil {
+		return 0, 0, err
+	}
+	defer openchainDB.CloseDB()
+
+	from, err = openchainDB.GetFormatVersion()
+	if err != nil {
+		return 0, 0, err
+	}
+	if from > CurrentFormatVersion {
+		return from, from, fmt.Errorf("DB format version [%d] is newer than the version supported by this peer [%d]", from, CurrentFormatVersion)
+	}
+
+	migrationsLock.Lock()
+	defer migrationsLock.Unlock()
+	pending := []uint64{}
+	for version := range migrations {
+		if version >= from {
+			pending = append(pending, version)
+		}
+	}
+	sort.Sort(uint64Slice(pending))
+
+	to = from
+	for _, version := range pending {
+		if version != to {
+			return from, to, fmt.Errorf("No migration registered from DB format version [%d]", to)
+		}
+		migration := migrations[version]
+		dbLogger.Infof("Migrating DB from format version [%d] to [%d]: %s", version, version+1, migration.Description)
+		if err = migration.Migrate(openchainDB); err != nil {
+			return from, to, fmt.Errorf("Error migrating DB from format version [%d]: %s", version, err)
+		}
+		to = version + 1
+		if err = openchainDB.setFormatVersion(to); err != nil {
+			return from, to, err
+		}
+	}
+	if to != CurrentFormatVersion {
+		return from, to, fmt.Errorf("No migration registered from DB format version [%d]", to)
+	}
+	return from, to, openchainDB.setFormatVersion(to)
+}
+
+type uint64Slice []uint64
+
+func (s uint64Slice) Len() int           { return len(s) }
+func (s uint64Slice) Less(i, j int) bool { return s[i] < s[j] }
+func (s uint64Slice) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
@@ -34,7 +34,9 @@ import (
 	"github.com/hyperledger/fabric/core/container"
 	crypto "github.com/hyperledger/fabric/core/crypto"
 	"github.com/hyperledger/fabric/core/peer"
-	"github.com/hyperledger/fabric/core/util"
+	"github.com/hyperledger/fabric/core/requestid"
+	"github.com/hyperledger/fabric/core/txid"
+	"github.com/hyperledger/fabric/core/watchdog"
 	pb "github.com/hyperledger/fabric/protos"
 )
 
@@ -123,10 +125,11 @@ func (*Devops) Build(context context.Context, spec *pb.ChaincodeSpec) (*pb.Chain
 
 // get chaincode bytes
 func (*Devops) getChaincodeBytes(context context.Context, spec *pb.ChaincodeSpec) (*pb.ChaincodeDeploymentSpec, error) {
+	log := requestid.Log(context, devopsLogger)
 	mode := viper.GetString("chaincode.mode")
 	var codePackageBytes []byte
 	if mode != chaincode.DevModeUserRunsChaincode {
-		devopsLogger.Debugf("Received build request for chaincode spec: %v", spec)
+		log.Debugf("Received build request for chaincode spec: %v", spec)
 		var err error
 		if err = CheckSpec(spec); err != nil {
 			return nil, err
@@ -135,7 +138,7 @@ func (*Devops) getChaincodeBytes(context context.Context, spec *pb.ChaincodeSpec
 		codePackageBytes, err = container.GetChaincodePackageBytes(spec)
 		if err != nil {
 			err = fmt.Errorf("Error getting chaincode package bytes: %s", err)
-			devopsLogger.Error(fmt.Sprintf("%s", err))
+			log.Error(fmt.Sprintf("%s", err))
 			return nil, err
 		}
 	}
@@ -145,11 +148,26 @@ func (*Devops) getChaincodeBytes(context context.Context, spec *pb.ChaincodeSpec
 
 // Deploy deploys the supplied chaincode image to the validators through a transaction
 func (d *Devops) Deploy(ctx context.Context, spec *pb.ChaincodeSpec) (*pb.ChaincodeDeploymentSpec, error) {
+	ctx = requestid.Ensure(ctx)
+	log := requestid.Log(ctx, devopsLogger)
+
+	if err := watchdog.AdmitTransaction(); err != nil {
+		return nil, err
+	}
+
+	// reject a malformed manifest, or constructor arguments which do not match it
+	if err := chaincode.CheckManifest(spec.Manifest); err != nil {
+		return nil, err
+	}
+	if err := chaincode.CheckArgs(spec.Manifest, spec.CtorMsg); err != nil {
+		return nil, err
+	}
+
 	// get the deployment spec
 	chaincodeDeploymentSpec, err := d.getChaincodeBytes(ctx, spec)
 
 	if err != nil {
-		devopsLogger.Error(fmt.Sprintf("Error deploying chaincode spec: %v\n\n error: %s", spec, err))
+		log.Error(fmt.Sprintf("Error deploying chaincode spec: %v\n\n error: %s", spec, err))
 		return nil, err
 	}
 
@@ -161,8 +179,8 @@ func (d *Devops) Deploy(ctx context.Context, spec *pb.ChaincodeSpec) (*pb.Chainc
 	var sec crypto.Client
 
 	if peer.SecurityEnabled() {
-		if devopsLogger.IsEnabledFor(logging.DEBUG) {
-			devopsLogger.Debugf("Initializing secure devops using context %s", spec.SecureContext)
+		if log.IsEnabledFor(logging.DEBUG) {
+			log.Debugf("Initializing secure devops using context %s", spec.SecureContext)
 		}
 		sec, err = crypto.InitClient(spec.SecureContext, nil)
 		defer crypto.CloseClient(sec)
@@ -174,16 +192,16 @@ func (d *Devops) Deploy(ctx context.Context, spec *pb.ChaincodeSpec) (*pb.Chainc
 			return nil, err
 		}
 
-		if devopsLogger.IsEnabledFor(logging.DEBUG) {
-			devopsLogger.Debugf("Creating secure transaction %s", transID)
+		if log.IsEnabledFor(logging.DEBUG) {
+			log.Debugf("Creating secure transaction %s", transID)
 		}
 		tx, err = sec.NewChaincodeDeployTransaction(chaincodeDeploymentSpec, transID, spec.Attributes...)
 		if nil != err {
 			return nil, err
 		}
 	} else {
-		if devopsLogger.IsEnabledFor(logging.DEBUG) {
-			devopsLogger.Debugf("Creating deployment transaction (%s)", transID)
+		if log.IsEnabledFor(logging.DEBUG) {
+			log.Debugf("Creating deployment transaction (%s)", transID)
 		}
 		tx, err = pb.NewChaincodeDeployTransaction(chaincodeDeploymentSpec, transID)
 		if err != nil {
@@ -191,8 +209,13 @@ func (d *Devops) Deploy(ctx context.Context, spec *pb.ChaincodeSpec) (*pb.Chainc
 		}
 	}
 
-	if devopsLogger.IsEnabledFor(logging.DEBUG) {
-		devopsLogger.Debugf("Sending deploy transaction (%s) to validator", tx.Uuid)
+	if log.IsEnabledFor(logging.DEBUG) {
+		log.Debugf("Sending deploy transaction (%s) to validator", tx.Uuid)
+	}
+	// packaging the chaincode may take a while, don't submit the deployment
+	// if the client gave up in the meantime
+	if err = chaincode.CheckContext(ctx, chaincode.CancelStageSubmit); err != nil {
+		return nil, err
 	}
 	resp := d.coord.ExecuteTransaction(tx)
 	if resp.Status == pb.Response_FAILURE {
@@ -202,20 +225,41 @@ func (d *Devops) Deploy(ctx context.Context, spec *pb.ChaincodeSpec) (*pb.Chainc
 	return chaincodeDeploymentSpec, err
 }
 
-func (d *Devops) invokeOrQuery(ctx context.Context, chaincodeInvocationSpec *pb.ChaincodeInvocationSpec, attributes []string, invoke bool) (*pb.Response, error) {
+func (d *Devops) invokeOrQuery(ctx context.Context, chaincodeInvocationSpec *pb.ChaincodeInvocationSpec, attributes []string, invoke bool, sink func([]byte) error) (*pb.Response, error) {
+	log := requestid.Log(ctx, devopsLogger)
 
 	if chaincodeInvocationSpec.ChaincodeSpec.ChaincodeID.Name == "" {
 		return nil, fmt.Errorf("name not given for invoke/query")
 	}
 
+	// an overloaded peer sheds queries before transactions
+	admit := watchdog.AdmitQuery
+	if invoke {
+		admit = watchdog.AdmitTransaction
+	}
+	if err := admit(); err != nil {
+		return nil, err
+	}
+
+	// reject arguments which do not match the manifest of the chaincode before the transaction is ordered
+	manifest, err := chaincode.GetManifest(chaincodeInvocationSpec.ChaincodeSpec.ChaincodeID.Name)
+	if err != nil {
+		log.Warningf("Could not get the manifest of chaincode %s: %s", chaincodeInvocationSpec.ChaincodeSpec.ChaincodeID.Name, err)
+	} else if err = chaincode.CheckArgs(manifest, chaincodeInvocationSpec.ChaincodeSpec.CtorMsg); err != nil {
+		return nil, err
+	}
+
+	// don't submit the request of a client which already gave up
+	if err = chaincode.CheckContext(ctx, chaincode.CancelStageSubmit); err != nil {
+		return nil, err
+	}
+
 	// Now create the Transactions message and send to Peer.
-	uuid := util.GenerateUUID()
 	var transaction *pb.Transaction
-	var err error
 	var sec crypto.Client
 	if peer.SecurityEnabled() {
-		if devopsLogger.IsEnabledFor(logging.DEBUG) {
-			devopsLogger.Debugf("Initializing secure devops using context %s", chaincodeInvocationSpec.ChaincodeSpec.SecureContext)
+		if log.IsEnabledFor(logging.DEBUG) {
+			log.Debugf("Initializing secure devops using context %s", chaincodeInvocationSpec.ChaincodeSpec.SecureContext)
 		}
 		sec, err = crypto.InitClient(chaincodeInvocationSpec.ChaincodeSpec.SecureContext, nil)
 		defer crypto.CloseClient(sec)
@@ -226,20 +270,46 @@ func (d *Devops) invokeOrQuery(ctx context.Context, chaincodeInvocationSpec *pb.
 		}
 	}
 
+	uuid, err := txid.GenerateForInvocation(chaincodeInvocationSpec)
+	if err != nil {
+		return nil, err
+	}
 	transaction, err = d.createExecTx(chaincodeInvocationSpec, attributes, uuid, invoke, sec)
 	if err != nil {
 		return nil, err
 	}
-	if devopsLogger.IsEnabledFor(logging.DEBUG) {
-		devopsLogger.Debugf("Sending invocation transaction (%s) to validator", transaction.Uuid)
+	if log.IsEnabledFor(logging.DEBUG) {
+		log.Debugf("Sending invocation transaction (%s) to validator", transaction.Uuid)
+	}
+	decrypt := !invoke && nil != sec && viper.GetBool("security.privacy")
+	var resp *pb.Response
+	if streamer, ok := d.coord.(peer.QueryStreamer); ok && !invoke {
+		// the query is abandoned if the client gives up on it
+		var streamSink func([]byte) error
+		if sink != nil {
+			streamSink = func(chunk []byte) error {
+				if decrypt {
+					var errDecrypt error
+					if chunk, errDecrypt = sec.DecryptQueryResult(transaction, chunk); nil != errDecrypt {
+						return errDecrypt
+					}
+				}
+				return sink(chunk)
+			}
+		}
+		resp = streamer.StreamQuery(ctx, transaction, streamSink)
+	} else {
+		resp = d.coord.ExecuteTransaction(transaction)
 	}
-	resp := d.coord.ExecuteTransaction(transaction)
 	if resp.Status == pb.Response_FAILURE {
 		err = fmt.Errorf(string(resp.Msg))
+	} else if resp.Height < transaction.MinHeight {
+		// peers predating the attestation answer without checking the height
+		err = fmt.Errorf("The peer answering the query did not attest the required block height %d", transaction.MinHeight)
 	} else {
-		if !invoke && nil != sec && viper.GetBool("security.privacy") {
+		if decrypt {
 			if resp.Msg, err = sec.DecryptQueryResult(transaction, resp.Msg); nil != err {
-				devopsLogger.Debugf("Failed decrypting query transaction result %s", string(resp.Msg[:]))
+				log.Debugf("Failed decrypting query transaction result %s", string(resp.Msg[:]))
 				//resp = &pb.Response{Status: pb.Response_FAILURE, Msg: []byte(err.Error())}
 			}
 		}
@@ -284,12 +354,35 @@ func (d *Devops) createExecTx(spec *pb.ChaincodeInvocationSpec, attributes []str
 
 // Invoke performs the supplied invocation on the specified chaincode through a transaction
 func (d *Devops) Invoke(ctx context.Context, chaincodeInvocationSpec *pb.ChaincodeInvocationSpec) (*pb.Response, error) {
-	return d.invokeOrQuery(ctx, chaincodeInvocationSpec, chaincodeInvocationSpec.ChaincodeSpec.Attributes, true)
+	return d.invokeOrQuery(requestid.Ensure(ctx), chaincodeInvocationSpec, chaincodeInvocationSpec.ChaincodeSpec.Attributes, true, nil)
 }
 
 // Query performs the supplied query on the specified chaincode through a transaction
 func (d *Devops) Query(ctx context.Context, chaincodeInvocationSpec *pb.ChaincodeInvocationSpec) (*pb.Response, error) {
-	return d.invokeOrQuery(ctx, chaincodeInvocationSpec, chaincodeInvocationSpec.ChaincodeSpec.Attributes, false)
+	return d.invokeOrQuery(requestid.Ensure(ctx), chaincodeInvocationSpec, chaincodeInvocationSpec.ChaincodeSpec.Attributes, false, nil)
+}
+
+// StreamQuery performs the supplied query on the specified chaincode, passing the
+// result to sink in the chunks the chaincode emits it. When the query cannot be
+// streamed, as when this peer forwards it to a validator, the whole result is
+// passed to sink at once
+func (d *Devops) StreamQuery(ctx context.Context, chaincodeInvocationSpec *pb.ChaincodeInvocationSpec, sink func(chunk []byte) error) error {
+	resp, err := d.invokeOrQuery(requestid.Ensure(ctx), chaincodeInvocationSpec, chaincodeInvocationSpec.ChaincodeSpec.Attributes, false, sink)
+	if err != nil {
+		return err
+	}
+	if len(resp.Msg) > 0 {
+		return sink(resp.Msg)
+	}
+	return nil
+}
+
+// QueryStream performs the supplied query on the specified chaincode, sending the
+// result in the chunks the chaincode emits it
+func (d *Devops) QueryStream(chaincodeInvocationSpec *pb.ChaincodeInvocationSpec, stream pb.Devops_QueryStreamServer) error {
+	return d.StreamQuery(stream.Context(), chaincodeInvocationSpec, func(chunk []byte) error {
+		return stream.Send(&pb.Response{Status: pb.Response_SUCCESS, Msg: chunk})
+	})
 }
 
 // CheckSpec to see if chaincode resides within current package capture for language.
@@ -436,7 +529,10 @@ func (d *Devops) EXP_ExecuteWithBinding(ctx context.Context, executeWithBinding
 			return &pb.Response{Status: pb.Response_FAILURE, Msg: []byte(err.Error())}, nil
 		}
 
-		tid := util.GenerateUUID()
+		tid, err := txid.GenerateForInvocation(executeWithBinding.ChaincodeInvocationSpec)
+		if err != nil {
+			return nil, err
+		}
 
 		tx, err := txHandler.NewChaincodeExecute(executeWithBinding.ChaincodeInvocationSpec, tid)
 		if err != nil {
@@ -452,6 +548,48 @@ func (d *Devops) EXP_ExecuteWithBinding(ctx context.Context, executeWithBinding
 	return &pb.Response{Status: pb.Response_FAILURE, Msg: []byte("Security NOT enabled")}, nil
 }
 
+// Simulate executes the supplied invocation on the specified chaincode without
+// committing its changes, and returns the keys it would change and the event
+// it would emit
+func (d *Devops) Simulate(ctx context.Context, chaincodeInvocationSpec *pb.ChaincodeInvocationSpec) (*pb.SimulationResult, error) {
+	ctx = requestid.Ensure(ctx)
+	simulator, ok := d.coord.(peer.Simulator)
+	if !ok {
+		return nil, fmt.Errorf("The peer cannot simulate transactions")
+	}
+	if chaincodeInvocationSpec.ChaincodeSpec.ChaincodeID.Name == "" {
+		return nil, fmt.Errorf("name not given for simulation")
+	}
+	// a simulation costs as much as a query, and is shed as one
+	if err := watchdog.AdmitQuery(); err != nil {
+		return nil, err
+	}
+	manifest, err := chaincode.GetManifest(chaincodeInvocationSpec.ChaincodeSpec.ChaincodeID.Name)
+	if err != nil {
+		requestid.Log(ctx, devopsLogger).Warningf("Could not get the manifest of chaincode %s: %s", chaincodeInvocationSpec.ChaincodeSpec.ChaincodeID.Name, err)
+	} else if err = chaincode.CheckArgs(manifest, chaincodeInvocationSpec.ChaincodeSpec.CtorMsg); err != nil {
+		return nil, err
+	}
+	var sec crypto.Client
+	if peer.SecurityEnabled() {
+		sec, err = crypto.InitClient(chaincodeInvocationSpec.ChaincodeSpec.SecureContext, nil)
+		defer crypto.CloseClient(sec)
+		chaincodeInvocationSpec.ChaincodeSpec.SecureContext = ""
+		if nil != err {
+			return nil, err
+		}
+	}
+	uuid, err := txid.GenerateForInvocation(chaincodeInvocationSpec)
+	if err != nil {
+		return nil, err
+	}
+	transaction, err := d.createExecTx(chaincodeInvocationSpec, chaincodeInvocationSpec.ChaincodeSpec.Attributes, uuid, true, sec)
+	if err != nil {
+		return nil, err
+	}
+	return simulator.Simulate(ctx, transaction)
+}
+
 // GetTransactionResult request a TransactionResult.  The Response.Msg will contain the TransactionResult if successfully found the transaction in the chain.
 func (d *Devops) GetTransactionResult(ctx context.Context, txRequest *pb.TransactionRequest) (*pb.Response, error) {
 	txResult, err := d.coord.GetTransactionResultByUUID(txRequest.TransactionUuid)
@@ -0,0 +1,110 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package health implements the standard gRPC health checking service
+// (grpc.health.v1.Health) so that load balancers and tools such as grpcurl
+// can probe the servers of the peer.
+//
+// Note that the gRPC server reflection service is not provided. It requires
+// the file descriptors of the registered services, which neither the vendored
+// grpc nor the code generated for the protos in this tree make available.
+package health
+
+import (
+	"sync"
+
+	"github.com/op/go-logging"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+
+	pb "github.com/hyperledger/fabric/core/health/grpc_health_v1"
+)
+
+var logger = logging.MustGetLogger("health")
+
+// Server implements the health service for a single gRPC server. Every service
+// registered on the gRPC server is tracked individually. The overall health,
+// which is reported for the empty service name, is SERVING only when all the
+// tracked services are SERVING.
+type Server struct {
+	mutex     sync.RWMutex
+	statusMap map[string]pb.HealthCheckResponse_ServingStatus
+}
+
+// NewServer creates a health server tracking the given services, all of which
+// start as NOT_SERVING until their component reports it is ready
+func NewServer(services ...string) *Server {
+	s := &Server{statusMap: make(map[string]pb.HealthCheckResponse_ServingStatus)}
+	for _, service := range services {
+		s.statusMap[service] = pb.HealthCheckResponse_NOT_SERVING
+	}
+	return s
+}
+
+// Register creates a health server tracking the given services and registers
+// it on the gRPC server
+func Register(grpcServer *grpc.Server, services ...string) *Server {
+	s := NewServer(services...)
+	pb.RegisterHealthServer(grpcServer, s)
+	return s
+}
+
+// Check implements the Check RPC of the health service
+func (s *Server) Check(ctx context.Context, in *pb.HealthCheckRequest) (*pb.HealthCheckResponse, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	if in.Service == "" {
+		return &pb.HealthCheckResponse{Status: s.overallStatus()}, nil
+	}
+	status, ok := s.statusMap[in.Service]
+	if !ok {
+		return nil, grpc.Errorf(codes.NotFound, "unknown service %s", in.Service)
+	}
+	return &pb.HealthCheckResponse{Status: status}, nil
+}
+
+// SetServingStatus records the status of the given service, adding the
+// service to the ones being tracked if needed
+func (s *Server) SetServingStatus(service string, status pb.HealthCheckResponse_ServingStatus) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	logger.Debugf("Health status of service [%s] set to %s", service, status)
+	s.statusMap[service] = status
+}
+
+// SetAllServingStatus records the same status for all the tracked services.
+// This is typically used to report NOT_SERVING on shutdown
+func (s *Server) SetAllServingStatus(status pb.HealthCheckResponse_ServingStatus) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	logger.Debugf("Health status of all services set to %s", status)
+	for service := range s.statusMap {
+		s.statusMap[service] = status
+	}
+}
+
+func (s *Server) overallStatus() pb.HealthCheckResponse_ServingStatus {
+	if len(s.statusMap) == 0 {
+		return pb.HealthCheckResponse_UNKNOWN
+	}
+	for _, status := range s.statusMap {
+		if status != pb.HealthCheckResponse_SERVING {
+			return pb.HealthCheckResponse_NOT_SERVING
+		}
+	}
+	return pb.HealthCheckResponse_SERVING
+}
@@ -0,0 +1,61 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package health
+
+import (
+	"testing"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+
+	pb "github.com/hyperledger/fabric/core/health/grpc_health_v1"
+)
+
+func checkStatus(t *testing.T, s *Server, service string, expected pb.HealthCheckResponse_ServingStatus) {
+	resp, err := s.Check(context.Background(), &pb.HealthCheckRequest{Service: service})
+	if err != nil {
+		t.Fatalf("Error checking health of service [%s]: %s", service, err)
+	}
+	if resp.Status != expected {
+		t.Fatalf("Expected status of service [%s] to be %s, got %s", service, expected, resp.Status)
+	}
+}
+
+func TestHealthServer(t *testing.T) {
+	s := NewServer("protos.Peer", "protos.Admin")
+	checkStatus(t, s, "protos.Peer", pb.HealthCheckResponse_NOT_SERVING)
+	checkStatus(t, s, "", pb.HealthCheckResponse_NOT_SERVING)
+
+	s.SetServingStatus("protos.Peer", pb.HealthCheckResponse_SERVING)
+	checkStatus(t, s, "protos.Peer", pb.HealthCheckResponse_SERVING)
+	checkStatus(t, s, "", pb.HealthCheckResponse_NOT_SERVING)
+
+	s.SetServingStatus("protos.Admin", pb.HealthCheckResponse_SERVING)
+	checkStatus(t, s, "", pb.HealthCheckResponse_SERVING)
+
+	s.SetAllServingStatus(pb.HealthCheckResponse_NOT_SERVING)
+	checkStatus(t, s, "protos.Admin", pb.HealthCheckResponse_NOT_SERVING)
+	checkStatus(t, s, "", pb.HealthCheckResponse_NOT_SERVING)
+
+	_, err := s.Check(context.Background(), &pb.HealthCheckRequest{Service: "protos.Unknown"})
+	if grpc.Code(err) != codes.NotFound {
+		t.Fatalf("Expected NotFound for unknown service, got %v", err)
+	}
+
+	checkStatus(t, NewServer(), "", pb.HealthCheckResponse_UNKNOWN)
+}
@@ -0,0 +1,224 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ledger
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"golang.org/x/crypto/sha3"
+	google_protobuf "google/protobuf"
+
+	"github.com/hyperledger/fabric/protos"
+)
+
+// ArchiveSummary describes the content of a verified archive
+type ArchiveSummary struct {
+	// FirstBlock and LastBlock are the first and last blocks holding a
+	// transaction of the range
+	FirstBlock uint64
+	LastBlock  uint64
+	// AnchorBlock is the number of the block whose hash is the anchor
+	AnchorBlock uint64
+	// Transactions are the transactions of the range, in chain order
+	Transactions []*protos.Transaction
+}
+
+// ArchiveExport describes an archive written by ExportArchive
+type ArchiveExport struct {
+	// FirstBlock and LastBlock are the first and last blocks of the archive,
+	// the last one being the chain head at export time
+	FirstBlock uint64
+	LastBlock  uint64
+	// Anchor is the hash of the last block
+	Anchor []byte
+	// Digest is the hash the signature of the archive covers
+	Digest []byte
+}
+
+// ExportArchive writes to w an archive of the transactions whose timestamp
+// falls within [from, to), along with the blocks needed to link them to the
+// current chain head, one block at a time. The archive written is a
+// ChainArchive with signer set and no signature, which the caller appends
+// with WriteArchiveSignature. ErrResourceNotFound is returned, and nothing
+// written, if there is no such transaction.
+func (ledger *Ledger) ExportArchive(from, to time.Time, signer []byte, w io.Writer) (*ArchiveExport, error) {
+	size := ledger.GetBlockchainSize()
+
+	// Transaction timestamps are set by the clients, so any block may hold a
+	// transaction of the range
+	var first uint64
+	found := false
+	for n := uint64(0); n < size && !found; n++ {
+		block, err := ledger.GetBlockByNumber(n)
+		if err != nil {
+			return nil, err
+		}
+		if len(transactionsInRange(block, from, to)) > 0 {
+			first = n
+			found = true
+		}
+	}
+	if !found {
+		return nil, ErrResourceNotFound
+	}
+
+	// The fields are written in field number order, as proto.Marshal does, so
+	// that the digest is that of the marshalled archive without signature
+	hash := sha3.NewShake256()
+	out := io.MultiWriter(w, hash)
+	header, err := proto.Marshal(&protos.ChainArchive{From: toTimestamp(from), To: toTimestamp(to), FirstBlock: first})
+	if err != nil {
+		return nil, err
+	}
+	if _, err = out.Write(header); err != nil {
+		return nil, err
+	}
+	export := &ArchiveExport{FirstBlock: first, LastBlock: size - 1}
+	field := proto.NewBuffer(nil)
+	for n := first; n < size; n++ {
+		block, err := ledger.GetBlockByNumber(n)
+		if err != nil {
+			return nil, err
+		}
+		blockBytes, err := proto.Marshal(block)
+		if err != nil {
+			return nil, err
+		}
+		// blocks is field 4 of the archive
+		field.Reset()
+		field.EncodeVarint(uint64(4<<3 | proto.WireBytes))
+		field.EncodeRawBytes(blockBytes)
+		if _, err = out.Write(field.Bytes()); err != nil {
+			return nil, err
+		}
+		if n == size-1 {
+			if export.Anchor, err = block.GetHash(); err != nil {
+				return nil, err
+			}
+		}
+	}
+	trailer, err := proto.Marshal(&protos.ChainArchive{Signer: signer})
+	if err != nil {
+		return nil, err
+	}
+	if _, err = out.Write(trailer); err != nil {
+		return nil, err
+	}
+
+	export.Digest = make([]byte, 64)
+	hash.Read(export.Digest)
+	return export, nil
+}
+
+// WriteArchiveSignature appends the signature to an archive written by
+// ExportArchive
+func WriteArchiveSignature(w io.Writer, signature []byte) error {
+	field, err := proto.Marshal(&protos.ChainArchive{Signature: signature})
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(field)
+	return err
+}
+
+// ArchiveDigest returns the hash the signature of the archive covers, that of
+// the archive with the signature field unset
+func ArchiveDigest(archive *protos.ChainArchive) ([]byte, error) {
+	unsigned := *archive
+	unsigned.Signature = nil
+	unsignedBytes, err := proto.Marshal(&unsigned)
+	if err != nil {
+		return nil, err
+	}
+	digest := make([]byte, 64)
+	sha3.ShakeSum256(digest, unsignedBytes)
+	return digest, nil
+}
+
+// VerifyArchive checks that the blocks of the archive form a hash chain, and
+// that the hash of the block at or after the last block holding a transaction
+// of the range is the given chain-head anchor. The signature of the archive is
+// not checked, as this requires the certificate of the signer.
+func VerifyArchive(archive *protos.ChainArchive, anchor []byte) (*ArchiveSummary, error) {
+	if len(archive.Blocks) == 0 {
+		return nil, fmt.Errorf("Archive holds no blocks")
+	}
+	from, to := fromTimestamp(archive.From), fromTimestamp(archive.To)
+	if len(transactionsInRange(archive.Blocks[0], from, to)) == 0 {
+		return nil, fmt.Errorf("First block %d of the archive holds no transaction within its range", archive.FirstBlock)
+	}
+
+	summary := &ArchiveSummary{FirstBlock: archive.FirstBlock}
+	anchored := false
+	var previousHash []byte
+	for i, block := range archive.Blocks {
+		number := archive.FirstBlock + uint64(i)
+		if i > 0 && !bytes.Equal(block.PreviousBlockHash, previousHash) {
+			return nil, fmt.Errorf("Block %d does not link to block %d", number, number-1)
+		}
+		hash, err := block.GetHash()
+		if err != nil {
+			return nil, err
+		}
+		previousHash = hash
+
+		if txs := transactionsInRange(block, from, to); len(txs) > 0 {
+			summary.LastBlock = number
+			summary.Transactions = append(summary.Transactions, txs...)
+			// The anchor must come after every transaction it vouches for
+			anchored = false
+		}
+		if !anchored && bytes.Equal(hash, anchor) {
+			summary.AnchorBlock = number
+			anchored = true
+		}
+	}
+
+	if !anchored {
+		return nil, fmt.Errorf("No block at or after block %d matches anchor %x", summary.LastBlock, anchor)
+	}
+	return summary, nil
+}
+
+func transactionsInRange(block *protos.Block, from, to time.Time) []*protos.Transaction {
+	var txs []*protos.Transaction
+	for _, tx := range block.GetTransactions() {
+		if tx.Timestamp == nil {
+			continue
+		}
+		t := fromTimestamp(tx.Timestamp)
+		if !t.Before(from) && t.Before(to) {
+			txs = append(txs, tx)
+		}
+	}
+	return txs
+}
+
+func toTimestamp(t time.Time) *google_protobuf.Timestamp {
+	return &google_protobuf.Timestamp{Seconds: t.Unix(), Nanos: int32(t.Nanosecond())}
+}
+
+func fromTimestamp(ts *google_protobuf.Timestamp) time.Time {
+	if ts == nil {
+		return time.Time{}
+	}
+	return time.Unix(ts.Seconds, int64(ts.Nanos)).UTC()
+}
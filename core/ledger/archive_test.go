@@ -0,0 +1,111 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ledger
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/hyperledger/fabric/core/ledger/testutil"
+	"github.com/hyperledger/fabric/protos"
+)
+
+func TestLedgerArchive(t *testing.T) {
+	ledgerTestWrapper := createFreshDBAndTestLedgerWrapper(t)
+	ledger := ledgerTestWrapper.ledger
+
+	// Block i holds a transaction issued i hours after base
+	base := time.Date(2016, 6, 1, 0, 0, 0, 0, time.UTC)
+	uuids := make([]string, 4)
+	for i := 0; i < 4; i++ {
+		ledger.BeginTxBatch(i)
+		tx, uuid := buildTestTx(t)
+		tx.Timestamp = toTimestamp(base.Add(time.Duration(i) * time.Hour))
+		uuids[i] = uuid
+		testutil.AssertNoError(t, ledger.CommitTxBatch(i, []*protos.Transaction{tx}, nil, []byte("proof")), "Error committing block")
+	}
+	hashes := make([][]byte, 4)
+	for i := range hashes {
+		hash, err := ledgerTestWrapper.GetBlockByNumber(uint64(i)).GetHash()
+		testutil.AssertNoError(t, err, "Error computing block hash")
+		hashes[i] = hash
+	}
+
+	var buf bytes.Buffer
+	_, err := ledger.ExportArchive(base.Add(-2*time.Hour), base, []byte("signer"), &buf)
+	testutil.AssertSame(t, err, ErrResourceNotFound)
+	testutil.AssertEquals(t, buf.Len(), 0)
+
+	export, err := ledger.ExportArchive(base.Add(time.Hour), base.Add(3*time.Hour), []byte("signer"), &buf)
+	testutil.AssertNoError(t, err, "Error exporting archive")
+	testutil.AssertEquals(t, export.FirstBlock, uint64(1))
+	testutil.AssertEquals(t, export.LastBlock, uint64(3))
+	testutil.AssertEquals(t, export.Anchor, hashes[3])
+	testutil.AssertNoError(t, WriteArchiveSignature(&buf, []byte("signature")), "Error writing signature")
+
+	archive := &protos.ChainArchive{}
+	testutil.AssertNoError(t, proto.Unmarshal(buf.Bytes(), archive), "Error unmarshalling archive")
+	testutil.AssertEquals(t, archive.FirstBlock, uint64(1))
+	testutil.AssertEquals(t, len(archive.Blocks), 3)
+	testutil.AssertEquals(t, archive.Signer, []byte("signer"))
+	testutil.AssertEquals(t, archive.Signature, []byte("signature"))
+	digest, err := ArchiveDigest(archive)
+	testutil.AssertNoError(t, err, "Error computing digest")
+	testutil.AssertEquals(t, digest, export.Digest)
+
+	summary, err := VerifyArchive(archive, hashes[3])
+	testutil.AssertNoError(t, err, "Error verifying archive against the chain head")
+	testutil.AssertEquals(t, summary.FirstBlock, uint64(1))
+	testutil.AssertEquals(t, summary.LastBlock, uint64(2))
+	testutil.AssertEquals(t, summary.AnchorBlock, uint64(3))
+	testutil.AssertEquals(t, len(summary.Transactions), 2)
+	testutil.AssertEquals(t, summary.Transactions[0].Uuid, uuids[1])
+	testutil.AssertEquals(t, summary.Transactions[1].Uuid, uuids[2])
+
+	// An anchor published before the export is fine as long as it follows the
+	// transactions of the range
+	summary, err = VerifyArchive(archive, hashes[2])
+	testutil.AssertNoError(t, err, "Error verifying archive against an earlier anchor")
+	testutil.AssertEquals(t, summary.AnchorBlock, uint64(2))
+	_, err = VerifyArchive(archive, hashes[1])
+	testutil.AssertError(t, err, "Anchor preceding a transaction of the range should be rejected")
+	_, err = VerifyArchive(archive, []byte("NotAnActualHash"))
+	testutil.AssertError(t, err, "Unknown anchor should be rejected")
+
+	// A tampered transaction breaks the hash chain
+	tampered := proto.Clone(archive).(*protos.ChainArchive)
+	tampered.Blocks[1].Transactions[0].Payload = []byte("tampered")
+	_, err = VerifyArchive(tampered, hashes[3])
+	testutil.AssertError(t, err, "Tampered archive should be rejected")
+
+	// The signature covers the whole archive but itself, the signer included
+	archive.Signature = []byte("other signature")
+	signedDigest, err := ArchiveDigest(archive)
+	testutil.AssertNoError(t, err, "Error computing digest")
+	testutil.AssertEquals(t, signedDigest, digest)
+	archive.Signer = []byte("other signer")
+	signedDigest, err = ArchiveDigest(archive)
+	testutil.AssertNoError(t, err, "Error computing digest")
+	testutil.AssertNotEquals(t, signedDigest, digest)
+	archive.Signer = []byte("signer")
+	archive.To = toTimestamp(base.Add(4 * time.Hour))
+	signedDigest, err = ArchiveDigest(archive)
+	testutil.AssertNoError(t, err, "Error computing digest")
+	testutil.AssertNotEquals(t, signedDigest, digest)
+}
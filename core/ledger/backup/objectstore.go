@@ -0,0 +1,257 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package backup
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// ErrObjectNotFound is returned by ObjectStore.Get for a missing object
+var ErrObjectNotFound = errors.New("backup: object not found")
+
+// ObjectStore is the bucket the ledger is backed up to
+type ObjectStore interface {
+	// Put stores data under key, replacing any previous object
+	Put(key string, data []byte) error
+	// Get returns the data stored under key, ErrObjectNotFound if there is none
+	Get(key string) ([]byte, error)
+	// List returns the keys starting with prefix, in lexical order
+	List(prefix string) ([]string, error)
+}
+
+// s3Store is an ObjectStore speaking the S3 REST API, signed with AWS
+// signature version 4, so that it works with any S3-compatible storage. The
+// bucket is addressed path-style, as not all of them support virtual hosts.
+type s3Store struct {
+	endpoint  *url.URL
+	region    string
+	bucket    string
+	accessKey string
+	secretKey string
+	client    *http.Client
+}
+
+// NewObjectStore returns the object store configured under ledger.backup
+func NewObjectStore() (ObjectStore, error) {
+	endpoint, err := url.Parse(viper.GetString("ledger.backup.endpoint"))
+	if err != nil || endpoint.Host == "" {
+		return nil, fmt.Errorf("Invalid object storage endpoint '%s'", viper.GetString("ledger.backup.endpoint"))
+	}
+	bucket := viper.GetString("ledger.backup.bucket")
+	if bucket == "" {
+		return nil, errors.New("No bucket configured to back up the ledger to")
+	}
+	region := viper.GetString("ledger.backup.region")
+	if region == "" {
+		region = "us-east-1"
+	}
+	return &s3Store{
+		endpoint:  endpoint,
+		region:    region,
+		bucket:    bucket,
+		accessKey: viper.GetString("ledger.backup.accessKey"),
+		secretKey: viper.GetString("ledger.backup.secretKey"),
+		client:    &http.Client{Timeout: time.Minute},
+	}, nil
+}
+
+func (s *s3Store) objectURL(key string) *url.URL {
+	u := *s.endpoint
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/" + s.bucket + "/" + key
+	return &u
+}
+
+func (s *s3Store) do(method string, u *url.URL, body []byte) ([]byte, int, error) {
+	req, err := http.NewRequest(method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, 0, err
+	}
+	signV4(req, body, s.accessKey, s.secretKey, s.region, time.Now())
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, resp.StatusCode, err
+	}
+	return data, resp.StatusCode, nil
+}
+
+func (s *s3Store) Put(key string, data []byte) error {
+	resp, status, err := s.do("PUT", s.objectURL(key), data)
+	if err != nil {
+		return fmt.Errorf("Error storing %s: %s", key, err)
+	}
+	if status != http.StatusOK {
+		return fmt.Errorf("Error storing %s: status %d: %s", key, status, resp)
+	}
+	return nil
+}
+
+func (s *s3Store) Get(key string) ([]byte, error) {
+	resp, status, err := s.do("GET", s.objectURL(key), nil)
+	if err != nil {
+		return nil, fmt.Errorf("Error retrieving %s: %s", key, err)
+	}
+	switch status {
+	case http.StatusOK:
+		return resp, nil
+	case http.StatusNotFound:
+		return nil, ErrObjectNotFound
+	default:
+		return nil, fmt.Errorf("Error retrieving %s: status %d: %s", key, status, resp)
+	}
+}
+
+type listBucketResult struct {
+	Contents []struct {
+		Key string
+	}
+	IsTruncated           bool
+	NextContinuationToken string
+}
+
+func (s *s3Store) List(prefix string) ([]string, error) {
+	var keys []string
+	token := ""
+	for {
+		u := s.objectURL("")
+		query := url.Values{"list-type": {"2"}, "prefix": {prefix}}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+		u.RawQuery = query.Encode()
+		resp, status, err := s.do("GET", u, nil)
+		if err != nil {
+			return nil, fmt.Errorf("Error listing %s: %s", prefix, err)
+		}
+		if status != http.StatusOK {
+			return nil, fmt.Errorf("Error listing %s: status %d: %s", prefix, status, resp)
+		}
+		result := &listBucketResult{}
+		if err := xml.Unmarshal(resp, result); err != nil {
+			return nil, fmt.Errorf("Error listing %s: %s", prefix, err)
+		}
+		for _, c := range result.Contents {
+			keys = append(keys, c.Key)
+		}
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			return keys, nil
+		}
+		token = result.NextContinuationToken
+	}
+}
+
+// signV4 signs req, whose body is payload, with AWS signature version 4.
+// Every header already set on req is signed, along with the host.
+func signV4(req *http.Request, payload []byte, accessKey, secretKey, region string, now time.Time) {
+	const service = "s3"
+	now = now.UTC()
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	payloadHash := sha256.Sum256(payload)
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", hex.EncodeToString(payloadHash[:]))
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		headers[strings.ToLower(name)] = strings.TrimSpace(strings.Join(values, ","))
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders bytes.Buffer
+	for _, name := range names {
+		fmt.Fprintf(&canonicalHeaders, "%s:%s\n", name, headers[name])
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		uriEncode(req.URL.Path, false),
+		canonicalQuery(req.URL.Query()),
+		canonicalHeaders.String(),
+		signedHeaders,
+		hex.EncodeToString(payloadHash[:]),
+	}, "\n")
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	scope := date + "/" + region + "/" + service + "/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+
+	key := hmacSHA256([]byte("AWS4"+secretKey), date)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, service)
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		accessKey, scope, signedHeaders, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+func canonicalQuery(query url.Values) string {
+	params := make([]string, 0, len(query))
+	for name, values := range query {
+		for _, value := range values {
+			params = append(params, uriEncode(name, true)+"="+uriEncode(value, true))
+		}
+	}
+	sort.Strings(params)
+	return strings.Join(params, "&")
+}
+
+// uriEncode percent-encodes s as signature version 4 expects, leaving '/'
+// alone in paths
+func uriEncode(s string, encodeSlash bool) string {
+	var buf bytes.Buffer
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~':
+			buf.WriteByte(c)
+		case c == '/' && !encodeSlash:
+			buf.WriteByte(c)
+		default:
+			fmt.Fprintf(&buf, "%%%02X", c)
+		}
+	}
+	return buf.String()
+}
@@ -0,0 +1,283 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package backup continuously replicates the ledger of the peer to an
+// S3-compatible bucket, and rebuilds a ledger from the bucket.
+//
+// The replicator ships every committed block, and every so many blocks a
+// snapshot of the state. A snapshot is split in chunks of state deltas, and
+// its manifest is stored once all of its chunks and blocks are, so that a
+// snapshot with a manifest can always be restored. The bucket is laid out as
+//
+//	<prefix>blocks/<block number>
+//	<prefix>snapshots/<block number>/<chunk number>
+//	<prefix>snapshots/<block number>/manifest
+//	<prefix>head
+//
+// where numbers are zero-padded so that keys sort in numerical order, and
+// head records how far the replicator got.
+package backup
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/op/go-logging"
+	"github.com/spf13/viper"
+
+	"github.com/hyperledger/fabric/core/ledger"
+	"github.com/hyperledger/fabric/core/ledger/statemgmt"
+)
+
+var logger = logging.MustGetLogger("backup")
+
+// Head records the progress of the replication
+type Head struct {
+	// Height is the number of blocks shipped
+	Height uint64 `json:"height"`
+	// Snapshot is the block number of the latest complete snapshot, if any
+	Snapshot *uint64 `json:"snapshot,omitempty"`
+}
+
+// Manifest describes a complete snapshot of the state
+type Manifest struct {
+	BlockNumber uint64 `json:"blockNumber"`
+	Chunks      int    `json:"chunks"`
+	Keys        int    `json:"keys"`
+}
+
+func blockKey(prefix string, blockNumber uint64) string {
+	return fmt.Sprintf("%sblocks/%020d", prefix, blockNumber)
+}
+
+func snapshotPrefix(prefix string) string {
+	return prefix + "snapshots/"
+}
+
+func chunkKey(prefix string, blockNumber uint64, chunk int) string {
+	return fmt.Sprintf("%s%020d/%08d", snapshotPrefix(prefix), blockNumber, chunk)
+}
+
+func manifestKey(prefix string, blockNumber uint64) string {
+	return fmt.Sprintf("%s%020d/manifest", snapshotPrefix(prefix), blockNumber)
+}
+
+func headKey(prefix string) string {
+	return prefix + "head"
+}
+
+// Replicator ships the blocks and state snapshots of a ledger to a bucket
+type Replicator struct {
+	store            ObjectStore
+	ledger           *ledger.Ledger
+	prefix           string
+	interval         time.Duration
+	snapshotInterval uint64 // blocks between snapshots, 0 for no snapshots
+	chunkSize        int    // keys per snapshot chunk
+
+	head Head
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// NewReplicator returns a replicator of lgr to store, configured under
+// ledger.backup
+func NewReplicator(store ObjectStore, lgr *ledger.Ledger) (*Replicator, error) {
+	interval, err := time.ParseDuration(viper.GetString("ledger.backup.interval"))
+	if err != nil || interval <= 0 {
+		return nil, fmt.Errorf("Invalid backup interval '%s'", viper.GetString("ledger.backup.interval"))
+	}
+	chunkSize := viper.GetInt("ledger.backup.snapshotChunkSize")
+	if chunkSize <= 0 {
+		chunkSize = 10000
+	}
+	return &Replicator{
+		store:            store,
+		ledger:           lgr,
+		prefix:           viper.GetString("ledger.backup.prefix"),
+		interval:         interval,
+		snapshotInterval: uint64(viper.GetInt("ledger.backup.snapshotInterval")),
+		chunkSize:        chunkSize,
+		stop:             make(chan struct{}),
+		done:             make(chan struct{}),
+	}, nil
+}
+
+// Start resumes the replication from where the bucket is at, and keeps
+// replicating in the background until Stop is called
+func (r *Replicator) Start() error {
+	head, err := readHead(r.store, r.prefix)
+	if err != nil {
+		return err
+	}
+	r.head = *head
+	// Blocks the local ledger does not hold yet are shipped again once it
+	// holds them, which overwrites them with the same blocks
+	if size := r.ledger.GetBlockchainSize(); r.head.Height > size {
+		r.head.Height = size
+	}
+	logger.Infof("Replicating the ledger to the bucket every %v, resuming at block %d", r.interval, r.head.Height)
+	go r.run()
+	return nil
+}
+
+// Stop stops the replication, waiting for the current round to complete
+func (r *Replicator) Stop() {
+	close(r.stop)
+	<-r.done
+}
+
+func (r *Replicator) run() {
+	defer close(r.done)
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+	for {
+		if err := r.replicate(); err != nil {
+			logger.Warningf("Ledger replication failed, retrying in %v: %s", r.interval, err)
+		}
+		select {
+		case <-r.stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// replicate ships the blocks committed since the previous round, and a
+// snapshot if one is due
+func (r *Replicator) replicate() error {
+	if err := r.shipBlocks(r.ledger.GetBlockchainSize()); err != nil {
+		return err
+	}
+	if r.snapshotDue() {
+		if err := r.shipSnapshot(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *Replicator) shipBlocks(height uint64) error {
+	start := r.head.Height
+	for r.head.Height < height {
+		block, err := r.ledger.GetBlockByNumber(r.head.Height)
+		if err != nil {
+			return err
+		}
+		data, err := proto.Marshal(block)
+		if err != nil {
+			return err
+		}
+		if err := r.store.Put(blockKey(r.prefix, r.head.Height), data); err != nil {
+			return err
+		}
+		r.head.Height++
+	}
+	if r.head.Height == start {
+		return nil
+	}
+	logger.Debugf("Shipped blocks %d to %d", start, r.head.Height-1)
+	return writeHead(r.store, r.prefix, &r.head)
+}
+
+func (r *Replicator) snapshotDue() bool {
+	if r.snapshotInterval == 0 || r.head.Height == 0 {
+		return false
+	}
+	if r.head.Snapshot == nil {
+		return true
+	}
+	return r.head.Height-1 >= *r.head.Snapshot+r.snapshotInterval
+}
+
+func (r *Replicator) shipSnapshot() error {
+	snapshot, err := r.ledger.GetStateSnapshot()
+	if err != nil {
+		return err
+	}
+	defer snapshot.Release()
+	blockNumber := snapshot.GetBlockNumber()
+
+	manifest := &Manifest{BlockNumber: blockNumber}
+	delta := statemgmt.NewStateDelta()
+	keys := 0
+	flush := func() error {
+		if err := r.store.Put(chunkKey(r.prefix, blockNumber, manifest.Chunks), delta.Marshal()); err != nil {
+			return err
+		}
+		manifest.Chunks++
+		delta = statemgmt.NewStateDelta()
+		keys = 0
+		return nil
+	}
+	for snapshot.Next() {
+		k, v := snapshot.GetRawKeyValue()
+		chaincodeID, key := statemgmt.DecodeCompositeKey(k)
+		delta.Set(chaincodeID, key, v, nil)
+		keys++
+		manifest.Keys++
+		if keys == r.chunkSize {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+	}
+	if keys > 0 || manifest.Chunks == 0 {
+		if err := flush(); err != nil {
+			return err
+		}
+	}
+
+	// The snapshot may have been taken after blocks committed meanwhile
+	if err := r.shipBlocks(blockNumber + 1); err != nil {
+		return err
+	}
+	data, err := json.Marshal(manifest)
+	if err != nil {
+		return err
+	}
+	if err := r.store.Put(manifestKey(r.prefix, blockNumber), data); err != nil {
+		return err
+	}
+	r.head.Snapshot = &blockNumber
+	logger.Infof("Shipped a snapshot of the state at block %d, %d keys in %d chunks", blockNumber, manifest.Keys, manifest.Chunks)
+	return writeHead(r.store, r.prefix, &r.head)
+}
+
+func readHead(store ObjectStore, prefix string) (*Head, error) {
+	data, err := store.Get(headKey(prefix))
+	if err == ErrObjectNotFound {
+		return &Head{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+	head := &Head{}
+	if err := json.Unmarshal(data, head); err != nil {
+		return nil, fmt.Errorf("Invalid head of the backup: %s", err)
+	}
+	return head, nil
+}
+
+func writeHead(store ObjectStore, prefix string, head *Head) error {
+	data, err := json.Marshal(head)
+	if err != nil {
+		return err
+	}
+	return store.Put(headKey(prefix), data)
+}
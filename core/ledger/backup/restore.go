@@ -0,0 +1,128 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package backup
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/golang/protobuf/proto"
+
+	"github.com/hyperledger/fabric/core/ledger"
+	"github.com/hyperledger/fabric/core/ledger/statemgmt"
+	"github.com/hyperledger/fabric/protos"
+)
+
+// ErrNoSnapshot is returned by Restore when the bucket holds no complete
+// snapshot
+var ErrNoSnapshot = errors.New("backup: no complete snapshot in the bucket")
+
+// latestManifest returns the manifest of the latest complete snapshot
+func latestManifest(store ObjectStore, prefix string) (*Manifest, error) {
+	keys, err := store.List(snapshotPrefix(prefix))
+	if err != nil {
+		return nil, err
+	}
+	latest := ""
+	for _, key := range keys {
+		if strings.HasSuffix(key, "/manifest") && key > latest {
+			latest = key
+		}
+	}
+	if latest == "" {
+		return nil, ErrNoSnapshot
+	}
+	data, err := store.Get(latest)
+	if err != nil {
+		return nil, err
+	}
+	manifest := &Manifest{}
+	if err := json.Unmarshal(data, manifest); err != nil {
+		return nil, fmt.Errorf("Invalid snapshot manifest %s: %s", latest, err)
+	}
+	return manifest, nil
+}
+
+// Restore rebuilds the ledger lgr, which must be empty, from the latest
+// complete snapshot in the bucket, and returns the number of blocks restored.
+// Blocks committed after that snapshot are not restored, as their state
+// cannot be derived without executing them: the peer catches up with the
+// network through state transfer once it starts.
+func Restore(store ObjectStore, prefix string, lgr *ledger.Ledger) (uint64, error) {
+	if size := lgr.GetBlockchainSize(); size != 0 {
+		return 0, fmt.Errorf("The ledger already holds %d blocks, can only restore into an empty ledger", size)
+	}
+	manifest, err := latestManifest(store, prefix)
+	if err != nil {
+		return 0, err
+	}
+	logger.Infof("Restoring the ledger from the snapshot at block %d", manifest.BlockNumber)
+
+	var head *protos.Block
+	for n := uint64(0); n <= manifest.BlockNumber; n++ {
+		data, err := store.Get(blockKey(prefix, n))
+		if err != nil {
+			return 0, fmt.Errorf("Error retrieving block %d: %s", n, err)
+		}
+		block := &protos.Block{}
+		if err := proto.Unmarshal(data, block); err != nil {
+			return 0, fmt.Errorf("Invalid block %d: %s", n, err)
+		}
+		if err := lgr.PutRawBlock(block, n); err != nil {
+			return 0, err
+		}
+		head = block
+	}
+	if lowest, err := lgr.VerifyChain(manifest.BlockNumber, 0); err != nil {
+		return 0, err
+	} else if lowest != 0 {
+		return 0, fmt.Errorf("The restored blocks do not form a chain, block %d does not link to the previous one", lowest)
+	}
+
+	if err := lgr.DeleteALLStateKeysAndValues(); err != nil {
+		return 0, err
+	}
+	for chunk := 0; chunk < manifest.Chunks; chunk++ {
+		data, err := store.Get(chunkKey(prefix, manifest.BlockNumber, chunk))
+		if err != nil {
+			return 0, fmt.Errorf("Error retrieving chunk %d of the snapshot: %s", chunk, err)
+		}
+		delta := statemgmt.NewStateDelta()
+		if err := delta.Unmarshal(data); err != nil {
+			return 0, fmt.Errorf("Invalid chunk %d of the snapshot: %s", chunk, err)
+		}
+		if err := lgr.ApplyStateDelta(chunk, delta); err != nil {
+			return 0, err
+		}
+		if err := lgr.CommitStateDelta(chunk); err != nil {
+			return 0, err
+		}
+	}
+
+	stateHash, err := lgr.GetTempStateHash()
+	if err != nil {
+		return 0, err
+	}
+	if !bytes.Equal(stateHash, head.StateHash) {
+		return 0, fmt.Errorf("The restored state hashes to %x, block %d expects %x", stateHash, manifest.BlockNumber, head.StateHash)
+	}
+	logger.Infof("Restored %d blocks and %d keys of state", manifest.BlockNumber+1, manifest.Keys)
+	return manifest.BlockNumber + 1, nil
+}
@@ -278,13 +278,11 @@ func (blockchain *blockchain) persistRawBlock(block *protos.Block, blockNumber u
 		blockchain.indexer.createIndexesSync(block, blockNumber, blockHash, writeBatch)
 	}
 
-	opt := gorocksdb.NewDefaultWriteOptions()
-	defer opt.Destroy()
-	err = db.GetDBHandle().DB.Write(opt, writeBatch)
-	if err != nil {
+	// The block is not committed along with its state
+	if err = db.GetDBHandle().ClearStateHeight(); err != nil {
 		return err
 	}
-	return nil
+	return db.GetDBHandle().WriteBlocks(writeBatch)
 }
 
 func fetchBlockFromDB(blockNumber uint64) (*protos.Block, error) {
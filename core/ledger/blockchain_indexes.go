@@ -17,6 +17,8 @@ limitations under the License.
 package ledger
 
 import (
+	"bytes"
+	"encoding/binary"
 	"fmt"
 
 	"github.com/golang/protobuf/proto"
@@ -30,6 +32,7 @@ var indexLogger = logging.MustGetLogger("indexes")
 var prefixBlockHashKey = byte(1)
 var prefixTxUUIDKey = byte(2)
 var prefixAddressBlockNumCompositeKey = byte(3)
+var prefixTagTxKey = byte(4)
 
 type blockchainIndexer interface {
 	isSynchronous() bool
@@ -38,6 +41,7 @@ type blockchainIndexer interface {
 	createIndexesAsync(block *protos.Block, blockNumber uint64, blockHash []byte) error
 	fetchBlockNumberByBlockHash(blockHash []byte) (uint64, error)
 	fetchTransactionIndexByUUID(txUUID string) (uint64, uint64, error)
+	fetchTransactionsByTag(key string, value string, from TagPosition, limit int) ([]TaggedTransaction, error)
 	stop()
 }
 
@@ -74,6 +78,10 @@ func (indexer *blockchainIndexerSync) fetchTransactionIndexByUUID(txUUID string)
 	return fetchTransactionIndexByUUIDFromDB(txUUID)
 }
 
+func (indexer *blockchainIndexerSync) fetchTransactionsByTag(key string, value string, from TagPosition, limit int) ([]TaggedTransaction, error) {
+	return fetchTransactionsByTagFromDB(key, value, from, limit)
+}
+
 func (indexer *blockchainIndexerSync) stop() {
 	return
 }
@@ -95,6 +103,12 @@ func addIndexDataForPersistence(block *protos.Block, blockNumber uint64, blockHa
 		// add TxUUID -> (blockNumber,indexWithinBlock)
 		writeBatch.PutCF(cf, encodeTxUUIDKey(tx.Uuid), encodeBlockNumTxIndex(blockNumber, uint64(txIndex)))
 
+		// add (tagKey,tagValue,blockNumber,indexWithinBlock) -> TxUUID
+		for _, tag := range tx.Tags {
+			position := TagPosition{BlockNumber: blockNumber, TxIndex: uint64(txIndex)}
+			writeBatch.PutCF(cf, encodeTagTxKey(tag.Key, tag.Value, position), []byte(tx.Uuid))
+		}
+
 		txExecutingAddress := getTxExecutingAddress(tx)
 		addressToTxIndexesMap[txExecutingAddress] = append(addressToTxIndexesMap[txExecutingAddress], uint64(txIndex))
 
@@ -137,6 +151,29 @@ func fetchTransactionIndexByUUIDFromDB(txUUID string) (uint64, uint64, error) {
 	return decodeBlockNumTxIndex(blockNumTxIndexBytes)
 }
 
+// fetchTransactionsByTagFromDB returns at most limit transactions tagged with
+// key and value, in the order of the chain, starting at position from
+func fetchTransactionsByTagFromDB(key string, value string, from TagPosition, limit int) ([]TaggedTransaction, error) {
+	openchainDB := db.GetDBHandle()
+	itr := openchainDB.GetIterator(openchainDB.IndexesCF)
+	defer itr.Close()
+
+	tagPrefix := encodeTagPrefix(key, value)
+	var txs []TaggedTransaction
+	for itr.Seek(encodeTagTxKey(key, value, from)); itr.ValidForPrefix(tagPrefix) && len(txs) < limit; itr.Next() {
+		k, v := itr.Key(), itr.Value()
+		position, err := decodeTagPosition(k.Data()[len(tagPrefix):])
+		uuid := string(v.Data())
+		k.Free()
+		v.Free()
+		if err != nil {
+			return nil, err
+		}
+		txs = append(txs, TaggedTransaction{TagPosition: position, UUID: uuid})
+	}
+	return txs, nil
+}
+
 func getTxExecutingAddress(tx *protos.Transaction) string {
 	// TODO Fetch address form tx
 	return "address1"
@@ -203,6 +240,30 @@ func encodeAddressBlockNumCompositeKey(address string, blockNumber uint64) []byt
 	return b.Bytes()
 }
 
+// encode TagTxKey. Block number and index within the block are encoded with a
+// fixed length, so that the transactions of a tag sort in the order of the chain
+func encodeTagPrefix(key string, value string) []byte {
+	b := proto.NewBuffer([]byte{prefixTagTxKey})
+	b.EncodeRawBytes([]byte(key))
+	b.EncodeRawBytes([]byte(value))
+	return b.Bytes()
+}
+
+func encodeTagTxKey(key string, value string, position TagPosition) []byte {
+	var buf bytes.Buffer
+	buf.Write(encodeTagPrefix(key, value))
+	binary.Write(&buf, binary.BigEndian, position.BlockNumber)
+	binary.Write(&buf, binary.BigEndian, position.TxIndex)
+	return buf.Bytes()
+}
+
+func decodeTagPosition(b []byte) (TagPosition, error) {
+	if len(b) != 16 {
+		return TagPosition{}, fmt.Errorf("Invalid tag index key suffix [%x]", b)
+	}
+	return TagPosition{BlockNumber: binary.BigEndian.Uint64(b[:8]), TxIndex: binary.BigEndian.Uint64(b[8:])}, nil
+}
+
 func encodeListTxIndexes(listTx []uint64) []byte {
 	b := proto.NewBuffer([]byte{})
 	for i := range listTx {
@@ -116,9 +116,7 @@ func (indexer *blockchainIndexerAsync) createIndexesInternal(block *protos.Block
 	defer writeBatch.Destroy()
 	addIndexDataForPersistence(block, blockNumber, blockHash, writeBatch)
 	writeBatch.PutCF(openchainDB.IndexesCF, lastIndexedBlockKey, encodeBlockNumber(blockNumber))
-	opt := gorocksdb.NewDefaultWriteOptions()
-	defer opt.Destroy()
-	err := openchainDB.DB.Write(opt, writeBatch)
+	err := openchainDB.WriteBlocks(writeBatch)
 	if err != nil {
 		return err
 	}
@@ -145,6 +143,15 @@ func (indexer *blockchainIndexerAsync) fetchTransactionIndexByUUID(txUUID string
 	return fetchTransactionIndexByUUIDFromDB(txUUID)
 }
 
+func (indexer *blockchainIndexerAsync) fetchTransactionsByTag(key string, value string, from TagPosition, limit int) ([]TaggedTransaction, error) {
+	err := indexer.indexerState.checkError()
+	if err != nil {
+		return nil, err
+	}
+	indexer.indexerState.waitForLastCommittedBlock()
+	return fetchTransactionsByTagFromDB(key, value, from, limit)
+}
+
 func (indexer *blockchainIndexerAsync) indexPendingBlocks() error {
 	blockchain := indexer.blockchain
 	if blockchain.getSize() == 0 {
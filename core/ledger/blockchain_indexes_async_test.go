@@ -69,6 +69,13 @@ func TestIndexesAsync_GetTransactionByUUID(t *testing.T) {
 	testIndexesGetTransactionByUUID(t)
 }
 
+func TestIndexesAsync_GetTransactionsByTag(t *testing.T) {
+	defaultSetting := indexBlockDataSynchronously
+	indexBlockDataSynchronously = false
+	defer func() { indexBlockDataSynchronously = defaultSetting }()
+	testIndexesGetTransactionsByTag(t)
+}
+
 func TestIndexesAsync_IndexingErrorScenario(t *testing.T) {
 	defaultSetting := indexBlockDataSynchronously
 	indexBlockDataSynchronously = false
@@ -167,6 +174,9 @@ func (noop *NoopIndexer) fetchBlockNumberByBlockHash(blockHash []byte) (uint64,
 func (noop *NoopIndexer) fetchTransactionIndexByUUID(txUUID string) (uint64, uint64, error) {
 	return 0, 0, nil
 }
+func (noop *NoopIndexer) fetchTransactionsByTag(key string, value string, from TagPosition, limit int) ([]TaggedTransaction, error) {
+	return nil, nil
+}
 func (noop *NoopIndexer) stop() {
 }
 
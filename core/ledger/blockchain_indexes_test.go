@@ -65,6 +65,13 @@ func TestIndexes_GetTransactionByUUID(t *testing.T) {
 	testIndexesGetTransactionByUUID(t)
 }
 
+func TestIndexes_GetTransactionsByTag(t *testing.T) {
+	defaultSetting := indexBlockDataSynchronously
+	indexBlockDataSynchronously = true
+	defer func() { indexBlockDataSynchronously = defaultSetting }()
+	testIndexesGetTransactionsByTag(t)
+}
+
 func testIndexesGetBlockByBlockNumber(t *testing.T) {
 	testDBWrapper.CreateFreshDB(t)
 	testBlockchainWrapper := newTestBlockchainWrapper(t)
@@ -159,3 +166,44 @@ func testIndexesGetTransactionByUUID(t *testing.T) {
 	testutil.AssertEquals(t, testBlockchainWrapper.getTransactionByUUID(uuid3), tx3)
 	testutil.AssertEquals(t, testBlockchainWrapper.getTransactionByUUID(uuid4), tx4)
 }
+
+func testIndexesGetTransactionsByTag(t *testing.T) {
+	testDBWrapper.CreateFreshDB(t)
+	testBlockchainWrapper := newTestBlockchainWrapper(t)
+	defer func() { testBlockchainWrapper.blockchain.indexer.stop() }()
+	ledger := &Ledger{blockchain: testBlockchainWrapper.blockchain}
+
+	var tagged []string
+	for i := 0; i < 3; i++ {
+		tx1, uuid1 := buildTestTx(t)
+		tx1.Tags = []*protos.TransactionTag{{Key: "order", Value: "42"}}
+		tx2, _ := buildTestTx(t)
+		tx2.Tags = []*protos.TransactionTag{{Key: "order", Value: "43"}, {Key: "customer", Value: "42"}}
+		tx3, uuid3 := buildTestTx(t)
+		tx3.Tags = []*protos.TransactionTag{{Key: "order", Value: "42"}}
+		testBlockchainWrapper.addNewBlock(protos.NewBlock([]*protos.Transaction{tx1, tx2, tx3}, nil), []byte("stateHash"))
+		tagged = append(tagged, uuid1, uuid3)
+	}
+
+	// walk the pages of the tag
+	var found []string
+	cursor := ""
+	for pages := 0; ; pages++ {
+		page, err := ledger.GetTransactionsByTag("order", "42", cursor, 4)
+		testutil.AssertNoError(t, err, "Error fetching transactions by tag")
+		found = append(found, page.UUIDs...)
+		if page.Next == "" {
+			testutil.AssertEquals(t, pages, 1)
+			break
+		}
+		cursor = page.Next
+	}
+	testutil.AssertEquals(t, found, tagged)
+
+	page, err := ledger.GetTransactionsByTag("customer", "43", "", 10)
+	testutil.AssertNoError(t, err, "Error fetching transactions by tag")
+	testutil.AssertEquals(t, len(page.UUIDs), 0)
+
+	_, err = ledger.GetTransactionsByTag("order", "42", "not a cursor", 10)
+	testutil.AssertError(t, err, "Expected an invalid cursor to be rejected")
+}
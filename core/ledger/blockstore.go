@@ -0,0 +1,77 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ledger
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/hyperledger/fabric/core/db"
+	"github.com/hyperledger/fabric/core/ledger/statemgmt/state"
+)
+
+// reconcileStateHeight checks, when the blocks are stored apart from the
+// state, that the state is at the height of the chain. A state one block
+// ahead, left by a commit which did not reach the blocks, is rolled back.
+// Otherwise the two stores were restored from different backups, and the
+// ledger does not start
+func reconcileStateHeight(blockchain *blockchain, st *state.State) error {
+	openchainDB := db.GetDBHandle()
+	if !openchainDB.BlocksApart() {
+		return nil
+	}
+	height, known, err := openchainDB.GetStateHeight()
+	if err != nil {
+		return err
+	}
+	size := blockchain.getSize()
+	if !known || height == size {
+		return nil
+	}
+	if height != size+1 || size == 0 {
+		return fmt.Errorf("The state is at block height [%d] while the block store holds [%d] blocks. Restore the state and the block store from the same backup", height, size)
+	}
+
+	ledgerLogger.Warningf("The state is one block ahead of the block store, the commit of block [%d] did not complete. Rolling the state back", size)
+	delta, err := st.FetchStateDeltaFromDB(size)
+	if err != nil {
+		return err
+	}
+	if delta == nil {
+		return fmt.Errorf("The state is at block height [%d] while the block store holds [%d] blocks, and the state delta of block [%d] is not available to roll it back", height, size, size)
+	}
+	delta.RollBackwards = true
+	st.ApplyStateDelta(delta)
+	if err = st.CommitStateDelta(); err != nil {
+		st.ClearInMemoryChanges(false)
+		return err
+	}
+	st.ClearInMemoryChanges(true)
+
+	stateHash, err := st.GetHash()
+	if err != nil {
+		return err
+	}
+	lastBlock, err := fetchBlockFromDB(size - 1)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(stateHash, lastBlock.StateHash) {
+		return fmt.Errorf("The state rolled back to block height [%d] has hash [%x], while block [%d] expects [%x]. Restore the state and the block store from the same backup", size, stateHash, size-1, lastBlock.StateHash)
+	}
+	return openchainDB.SetStateHeight(size)
+}
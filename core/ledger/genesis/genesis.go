@@ -40,9 +40,18 @@ func MakeGenesis() error {
 
 		if ledger.GetBlockchainSize() == 0 {
 			genesisLogger.Info("Creating genesis block.")
-			if makeGenesisError = ledger.BeginTxBatch(0); makeGenesisError == nil {
-				makeGenesisError = ledger.CommitTxBatch(0, nil, nil, nil)
+			var entries map[string][]byte
+			if entries, makeGenesisError = systemMetadata(getGenesis()); makeGenesisError != nil {
+				return
 			}
+			if makeGenesisError = ledger.BeginTxBatch(0); makeGenesisError != nil {
+				return
+			}
+			if makeGenesisError = writeSystemMetadata(ledger, entries); makeGenesisError != nil {
+				ledger.RollbackTxBatch(0)
+				return
+			}
+			makeGenesisError = ledger.CommitTxBatch(0, nil, nil, nil)
 		}
 	})
 	return makeGenesisError
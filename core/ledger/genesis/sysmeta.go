@@ -0,0 +1,88 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package genesis
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"github.com/hyperledger/fabric/core/ledger"
+	"github.com/hyperledger/fabric/protos"
+	"github.com/spf13/cast"
+)
+
+// systemMetadataUUID is the uuid the entries of the system metadata namespace
+// are written in the genesis block under
+const systemMetadataUUID = "genesis-system-metadata"
+
+// systemMetadata returns the entries of the system metadata namespace
+// configured for the genesis block, under genesisBlock.validators and
+// genesisBlock.config. Without them the namespace is left empty, and the
+// genesis block is the same as that of peers which predate it.
+func systemMetadata(genesis map[string]interface{}) (map[string][]byte, error) {
+	entries := make(map[string][]byte)
+
+	if validators, ok := genesis["validators"]; ok && validators != nil {
+		ids, err := cast.ToStringSliceE(validators)
+		if err != nil {
+			return nil, fmt.Errorf("The genesis validators must be a list: %s", err)
+		}
+		value, err := json.Marshal(ids)
+		if err != nil {
+			return nil, err
+		}
+		entries[protos.SystemMetadataValidators] = value
+	}
+
+	if config, ok := genesis["config"]; ok && config != nil {
+		values, err := cast.ToStringMapE(config)
+		if err != nil {
+			return nil, fmt.Errorf("The genesis config must be a map: %s", err)
+		}
+		for name, value := range values {
+			entries[protos.SystemMetadataConfigPrefix+name] = []byte(cast.ToString(value))
+		}
+	}
+
+	return entries, nil
+}
+
+// writeSystemMetadata puts the entries of the system metadata namespace in
+// the ongoing transaction batch
+func writeSystemMetadata(l *ledger.Ledger, entries map[string][]byte) error {
+	if len(entries) == 0 {
+		return nil
+	}
+
+	names := make([]string, 0, len(entries))
+	for name := range entries {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	l.TxBegin(systemMetadataUUID)
+	for _, name := range names {
+		genesisLogger.Infof("Setting system metadata %s to %s", name, entries[name])
+		if err := l.SetState(protos.SystemMetadataNamespace, name, entries[name]); err != nil {
+			l.TxFinished(systemMetadataUUID, false)
+			return err
+		}
+	}
+	l.TxFinished(systemMetadataUUID, true)
+	return nil
+}
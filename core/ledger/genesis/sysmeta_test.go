@@ -0,0 +1,79 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package genesis
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/hyperledger/fabric/core/ledger"
+	"github.com/hyperledger/fabric/protos"
+)
+
+func TestSystemMetadata(t *testing.T) {
+	entries, err := systemMetadata(map[string]interface{}{
+		"validators": []interface{}{"vp0", "vp1"},
+		"config":     map[interface{}]interface{}{"quorum": 3, "name": "test"},
+	})
+	if err != nil {
+		t.Fatalf("Failed to get the system metadata: %s", err)
+	}
+	expected := map[string]string{
+		protos.SystemMetadataValidators:              `["vp0","vp1"]`,
+		protos.SystemMetadataConfigPrefix + "quorum": "3",
+		protos.SystemMetadataConfigPrefix + "name":   "test",
+	}
+	if len(entries) != len(expected) {
+		t.Fatalf("Expected %d entries, got %v", len(expected), entries)
+	}
+	for name, value := range expected {
+		if string(entries[name]) != value {
+			t.Errorf("Expected %s to be %s, got %s", name, value, entries[name])
+		}
+	}
+
+	if entries, err = systemMetadata(map[string]interface{}{"chaincode": nil}); err != nil || len(entries) != 0 {
+		t.Errorf("Expected no entries without validators or config, got %v (%v)", entries, err)
+	}
+
+	if _, err = systemMetadata(map[string]interface{}{"config": "quorum"}); err == nil {
+		t.Errorf("Expected a config which is not a map to be refused")
+	}
+}
+
+func TestWriteSystemMetadata(t *testing.T) {
+	l := ledger.InitTestLedger(t)
+
+	if err := l.BeginTxBatch(1); err != nil {
+		t.Fatalf("Failed to begin the batch: %s", err)
+	}
+	entries := map[string][]byte{protos.SystemMetadataValidators: []byte(`["vp0"]`)}
+	if err := writeSystemMetadata(l, entries); err != nil {
+		t.Fatalf("Failed to write the system metadata: %s", err)
+	}
+	if err := l.CommitTxBatch(1, nil, nil, nil); err != nil {
+		t.Fatalf("Failed to commit the batch: %s", err)
+	}
+
+	value, err := l.GetState(protos.SystemMetadataNamespace, protos.SystemMetadataValidators, true)
+	if err != nil {
+		t.Fatalf("Failed to get the validators: %s", err)
+	}
+	if !bytes.Equal(value, entries[protos.SystemMetadataValidators]) {
+		t.Errorf("Expected the validators %s, got %s", entries[protos.SystemMetadataValidators], value)
+	}
+}
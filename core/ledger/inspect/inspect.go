@@ -0,0 +1,355 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package inspect renders blocks, transactions and state deltas for human
+// inspection, as JSON in which every field is decoded: the payloads of
+// transactions become the chaincode specs they hold, enums their names,
+// timestamps RFC 3339 times, and hashes and signatures hex strings. Opaque
+// bytes, such as state values, render as a string when they are printable
+// UTF-8, and as an object {"hex": ...} otherwise. Unset fields are omitted.
+//
+// Marshal encodes the renderings canonically, so that the same block always
+// renders to the same bytes and renderings can be compared with diff.
+package inspect
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/golang/protobuf/proto"
+	google_protobuf "google/protobuf"
+
+	"github.com/hyperledger/fabric/core/ledger/statemgmt"
+	pb "github.com/hyperledger/fabric/protos"
+)
+
+// Marshal returns the canonical JSON encoding of a rendering: keys of objects
+// sorted, no insignificant white space and no escaping of HTML characters
+func Marshal(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(v); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), nil
+}
+
+// NumberedBlock renders the block with the given number and hash, along with
+// the changes it made to the state if delta is not nil
+func NumberedBlock(number uint64, block *pb.Block, hash []byte, delta *statemgmt.StateDelta) map[string]interface{} {
+	m := Block(block, hash)
+	m["number"] = number
+	if delta != nil {
+		m["stateDelta"] = StateDelta(delta)
+	}
+	return m
+}
+
+// Block renders a block whose number is not known. The hash is that of the
+// block as committed, which the rendered block may no longer hash to once its
+// code packages are trimmed.
+func Block(block *pb.Block, hash []byte) map[string]interface{} {
+	m := make(map[string]interface{})
+	setHex(m, "hash", hash)
+	setUint(m, "version", uint64(block.Version))
+	setTimestamp(m, "timestamp", block.Timestamp)
+	if len(block.Transactions) > 0 {
+		txs := make([]interface{}, len(block.Transactions))
+		for i, tx := range block.Transactions {
+			txs[i] = Transaction(tx)
+		}
+		m["transactions"] = txs
+	}
+	setHex(m, "stateHash", block.StateHash)
+	setHex(m, "previousBlockHash", block.PreviousBlockHash)
+	setOpaque(m, "consensusMetadata", block.ConsensusMetadata)
+	if block.NonHashData != nil {
+		m["nonHashData"] = nonHashData(block.NonHashData)
+	}
+	return m
+}
+
+func nonHashData(data *pb.NonHashData) map[string]interface{} {
+	m := make(map[string]interface{})
+	setTimestamp(m, "localLedgerCommitTimestamp", data.LocalLedgerCommitTimestamp)
+	if len(data.TransactionResults) > 0 {
+		results := make([]interface{}, len(data.TransactionResults))
+		for i, tr := range data.TransactionResults {
+			results[i] = transactionResult(tr)
+		}
+		m["transactionResults"] = results
+	}
+	return m
+}
+
+func transactionResult(tr *pb.TransactionResult) map[string]interface{} {
+	m := make(map[string]interface{})
+	setString(m, "uuid", tr.Uuid)
+	setOpaque(m, "result", tr.Result)
+	setUint(m, "errorCode", uint64(tr.ErrorCode))
+	setString(m, "error", tr.Error)
+	if ce := tr.ChaincodeEvent; ce != nil {
+		event := make(map[string]interface{})
+		setString(event, "chaincodeID", ce.ChaincodeID)
+		setString(event, "txID", ce.TxID)
+		setString(event, "eventName", ce.EventName)
+		setOpaque(event, "payload", ce.Payload)
+		m["chaincodeEvent"] = event
+	}
+	return m
+}
+
+// Transaction renders a transaction. The chaincode ID, payload and metadata of
+// confidential transactions are encrypted, and render as opaque bytes.
+func Transaction(tx *pb.Transaction) map[string]interface{} {
+	m := make(map[string]interface{})
+	m["type"] = tx.Type.String()
+	setString(m, "uuid", tx.Uuid)
+	setTimestamp(m, "timestamp", tx.Timestamp)
+	m["confidentialityLevel"] = tx.ConfidentialityLevel.String()
+	setString(m, "confidentialityProtocolVersion", tx.ConfidentialityProtocolVersion)
+
+	if tx.ConfidentialityLevel == pb.ConfidentialityLevel_CONFIDENTIAL {
+		setOpaque(m, "chaincodeID", tx.ChaincodeID)
+		setOpaque(m, "payload", tx.Payload)
+	} else {
+		cID := &pb.ChaincodeID{}
+		if err := proto.Unmarshal(tx.ChaincodeID, cID); err == nil && len(tx.ChaincodeID) > 0 {
+			m["chaincodeID"] = chaincodeID(cID)
+		} else {
+			setOpaque(m, "chaincodeID", tx.ChaincodeID)
+		}
+		setPayload(m, tx)
+	}
+	setOpaque(m, "metadata", tx.Metadata)
+
+	setHex(m, "nonce", tx.Nonce)
+	setOpaque(m, "toValidators", tx.ToValidators)
+	if len(tx.Cert) > 0 {
+		m["cert"] = certificate(tx.Cert)
+	}
+	setUint(m, "certKeyIndex", tx.CertKeyIndex)
+	setHex(m, "certKey", tx.CertKey)
+	setHex(m, "certKeySignature", tx.CertKeySignature)
+	if len(tx.Ring) > 0 {
+		ring := make([]interface{}, len(tx.Ring))
+		for i, cert := range tx.Ring {
+			ring[i] = certificate(cert)
+		}
+		m["ring"] = ring
+	}
+	setHex(m, "signature", tx.Signature)
+	setTags(m, tx.Tags)
+	return m
+}
+
+// setPayload renders the payload of a transaction as the chaincode spec it
+// holds, or as opaque bytes if it does not decode
+func setPayload(m map[string]interface{}, tx *pb.Transaction) {
+	switch tx.Type {
+	case pb.Transaction_CHAINCODE_DEPLOY:
+		spec := &pb.ChaincodeDeploymentSpec{}
+		if err := proto.Unmarshal(tx.Payload, spec); err == nil {
+			m["payload"] = deploymentSpec(spec)
+			return
+		}
+	case pb.Transaction_CHAINCODE_INVOKE, pb.Transaction_CHAINCODE_QUERY, pb.Transaction_CHAINCODE_TERMINATE:
+		spec := &pb.ChaincodeInvocationSpec{}
+		if err := proto.Unmarshal(tx.Payload, spec); err == nil {
+			invocation := make(map[string]interface{})
+			if spec.ChaincodeSpec != nil {
+				invocation["chaincodeSpec"] = ChaincodeSpec(spec.ChaincodeSpec)
+			}
+			m["payload"] = invocation
+			return
+		}
+	}
+	setOpaque(m, "payload", tx.Payload)
+}
+
+// deploymentSpec renders a deployment spec. The code package renders as its
+// size and hash, as the REST API trims it from the blocks it returns.
+func deploymentSpec(spec *pb.ChaincodeDeploymentSpec) map[string]interface{} {
+	m := make(map[string]interface{})
+	if spec.ChaincodeSpec != nil {
+		m["chaincodeSpec"] = ChaincodeSpec(spec.ChaincodeSpec)
+	}
+	setTimestamp(m, "effectiveDate", spec.EffectiveDate)
+	if len(spec.CodePackage) > 0 {
+		hash := sha256.Sum256(spec.CodePackage)
+		m["codePackage"] = map[string]interface{}{
+			"size":   len(spec.CodePackage),
+			"sha256": hex.EncodeToString(hash[:]),
+		}
+	}
+	m["execEnv"] = spec.ExecEnv.String()
+	return m
+}
+
+// ChaincodeSpec renders a chaincode spec
+func ChaincodeSpec(spec *pb.ChaincodeSpec) map[string]interface{} {
+	m := make(map[string]interface{})
+	m["type"] = spec.Type.String()
+	if spec.ChaincodeID != nil {
+		m["chaincodeID"] = chaincodeID(spec.ChaincodeID)
+	}
+	if input := spec.CtorMsg; input != nil {
+		ctor := make(map[string]interface{})
+		setString(ctor, "function", input.Function)
+		if len(input.Args) > 0 {
+			ctor["args"] = input.Args
+		}
+		m["ctorMsg"] = ctor
+	}
+	setUint(m, "timeout", uint64(spec.Timeout))
+	setString(m, "secureContext", spec.SecureContext)
+	m["confidentialityLevel"] = spec.ConfidentialityLevel.String()
+	setOpaque(m, "metadata", spec.Metadata)
+	if len(spec.Attributes) > 0 {
+		m["attributes"] = spec.Attributes
+	}
+	if manifest := spec.Manifest; manifest != nil {
+		functions := make([]interface{}, len(manifest.Functions))
+		for i, f := range manifest.Functions {
+			function := map[string]interface{}{"name": f.Name}
+			if len(f.Args) > 0 {
+				function["args"] = f.Args
+			}
+			if f.Variadic {
+				function["variadic"] = true
+			}
+			functions[i] = function
+		}
+		rendered := map[string]interface{}{"functions": functions}
+		if manifest.Strict {
+			rendered["strict"] = true
+		}
+		setUint(rendered, "queryConcurrency", uint64(manifest.QueryConcurrency))
+		m["manifest"] = rendered
+	}
+	setTags(m, spec.Tags)
+	setUint(m, "minHeight", spec.MinHeight)
+	return m
+}
+
+// StateDelta renders the changes a block made to the state, by chaincode and
+// key. Deleted keys render with "deleted": true.
+func StateDelta(delta *statemgmt.StateDelta) map[string]interface{} {
+	m := make(map[string]interface{})
+	for _, cID := range delta.GetUpdatedChaincodeIds(false) {
+		updates := make(map[string]interface{})
+		for key, value := range delta.GetUpdates(cID) {
+			update := make(map[string]interface{})
+			if value.IsDelete() {
+				update["deleted"] = true
+			} else {
+				update["value"] = opaque(value.GetValue())
+			}
+			setOpaque(update, "previousValue", value.GetPreviousValue())
+			updates[key] = update
+		}
+		m[cID] = updates
+	}
+	return m
+}
+
+func chaincodeID(cID *pb.ChaincodeID) map[string]interface{} {
+	m := make(map[string]interface{})
+	setString(m, "path", cID.Path)
+	setString(m, "name", cID.Name)
+	return m
+}
+
+// certificate renders a certificate by its subject, issuer and serial number
+// along with its fingerprint, or as opaque bytes if it does not parse
+func certificate(raw []byte) interface{} {
+	cert, err := x509.ParseCertificate(raw)
+	if err != nil {
+		return opaque(raw)
+	}
+	fingerprint := sha256.Sum256(raw)
+	return map[string]interface{}{
+		"subject": cert.Subject.CommonName,
+		"issuer":  cert.Issuer.CommonName,
+		"serial":  cert.SerialNumber.Text(16),
+		"sha256":  hex.EncodeToString(fingerprint[:]),
+	}
+}
+
+// opaque renders bytes of unknown structure
+func opaque(raw []byte) interface{} {
+	if utf8.Valid(raw) {
+		printable := true
+		for _, r := range string(raw) {
+			if !unicode.IsPrint(r) && r != '\n' && r != '\t' {
+				printable = false
+				break
+			}
+		}
+		if printable {
+			return string(raw)
+		}
+	}
+	return map[string]interface{}{"hex": hex.EncodeToString(raw)}
+}
+
+func setOpaque(m map[string]interface{}, key string, raw []byte) {
+	if len(raw) > 0 {
+		m[key] = opaque(raw)
+	}
+}
+
+func setHex(m map[string]interface{}, key string, raw []byte) {
+	if len(raw) > 0 {
+		m[key] = hex.EncodeToString(raw)
+	}
+}
+
+func setString(m map[string]interface{}, key string, s string) {
+	if s != "" {
+		m[key] = s
+	}
+}
+
+func setUint(m map[string]interface{}, key string, n uint64) {
+	if n != 0 {
+		m[key] = n
+	}
+}
+
+func setTimestamp(m map[string]interface{}, key string, ts *google_protobuf.Timestamp) {
+	if ts != nil {
+		m[key] = time.Unix(ts.Seconds, int64(ts.Nanos)).UTC().Format(time.RFC3339Nano)
+	}
+}
+
+func setTags(m map[string]interface{}, tags []*pb.TransactionTag) {
+	if len(tags) == 0 {
+		return
+	}
+	rendered := make([]interface{}, len(tags))
+	for i, tag := range tags {
+		rendered[i] = map[string]interface{}{"key": tag.Key, "value": tag.Value}
+	}
+	m["tags"] = rendered
+}
@@ -0,0 +1,118 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package inspect
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	google_protobuf "google/protobuf"
+
+	"github.com/hyperledger/fabric/core/ledger/statemgmt"
+	"github.com/hyperledger/fabric/core/ledger/testutil"
+	pb "github.com/hyperledger/fabric/protos"
+)
+
+func TestRenderBlock(t *testing.T) {
+	invoke, err := pb.NewChaincodeExecute(&pb.ChaincodeInvocationSpec{ChaincodeSpec: &pb.ChaincodeSpec{
+		Type:        pb.ChaincodeSpec_GOLANG,
+		ChaincodeID: &pb.ChaincodeID{Name: "mycc"},
+		CtorMsg:     &pb.ChaincodeInput{Function: "transfer", Args: []string{"a", "b", "10"}},
+	}}, "uuid1", pb.Transaction_CHAINCODE_INVOKE)
+	testutil.AssertNoError(t, err, "Error building invoke transaction")
+	invoke.Timestamp = &google_protobuf.Timestamp{Seconds: time.Date(2016, 6, 1, 12, 0, 0, 0, time.UTC).Unix()}
+
+	deploySpec, err := proto.Marshal(&pb.ChaincodeDeploymentSpec{
+		ChaincodeSpec: &pb.ChaincodeSpec{Type: pb.ChaincodeSpec_GOLANG, ChaincodeID: &pb.ChaincodeID{Path: "github.com/mycc"}},
+		CodePackage:   []byte("package"),
+	})
+	testutil.AssertNoError(t, err, "Error marshalling deployment spec")
+	deploy := &pb.Transaction{Type: pb.Transaction_CHAINCODE_DEPLOY, Uuid: "uuid2", Payload: deploySpec, Signature: []byte{0xca, 0xfe}}
+
+	block := &pb.Block{
+		Transactions:      []*pb.Transaction{invoke, deploy},
+		StateHash:         []byte{0x01, 0x02},
+		PreviousBlockHash: []byte{0x03},
+		ConsensusMetadata: []byte{0x08, 0x01},
+		NonHashData: &pb.NonHashData{TransactionResults: []*pb.TransactionResult{
+			{Uuid: "uuid1", ChaincodeEvent: &pb.ChaincodeEvent{ChaincodeID: "mycc", TxID: "uuid1", EventName: "transferred", Payload: []byte("10")}},
+			{Uuid: "uuid2", ErrorCode: 1, Error: "failed"},
+		}},
+	}
+	delta := statemgmt.NewStateDelta()
+	delta.Set("mycc", "a", []byte("90"), []byte("100"))
+	delta.Delete("mycc", "c", []byte{0xff})
+
+	raw, err := Marshal(NumberedBlock(7, block, []byte{0xab}, delta))
+	testutil.AssertNoError(t, err, "Error marshalling rendering")
+	again, err := Marshal(NumberedBlock(7, block, []byte{0xab}, delta))
+	testutil.AssertNoError(t, err, "Error marshalling rendering")
+	testutil.AssertEquals(t, again, raw)
+
+	var rendered map[string]interface{}
+	testutil.AssertNoError(t, json.Unmarshal(raw, &rendered), "Error unmarshalling rendering")
+	testutil.AssertEquals(t, rendered["number"], float64(7))
+	testutil.AssertEquals(t, rendered["hash"], "ab")
+	testutil.AssertEquals(t, rendered["stateHash"], "0102")
+	testutil.AssertEquals(t, rendered["consensusMetadata"], map[string]interface{}{"hex": "0801"})
+
+	txs := rendered["transactions"].([]interface{})
+	tx := txs[0].(map[string]interface{})
+	testutil.AssertEquals(t, tx["type"], "CHAINCODE_INVOKE")
+	testutil.AssertEquals(t, tx["timestamp"], "2016-06-01T12:00:00Z")
+	testutil.AssertEquals(t, tx["chaincodeID"], map[string]interface{}{"name": "mycc"})
+	spec := tx["payload"].(map[string]interface{})["chaincodeSpec"].(map[string]interface{})
+	testutil.AssertEquals(t, spec["ctorMsg"], map[string]interface{}{"function": "transfer", "args": []interface{}{"a", "b", "10"}})
+
+	tx = txs[1].(map[string]interface{})
+	testutil.AssertEquals(t, tx["signature"], "cafe")
+	deployment := tx["payload"].(map[string]interface{})
+	testutil.AssertEquals(t, deployment["execEnv"], "DOCKER")
+	testutil.AssertEquals(t, deployment["codePackage"].(map[string]interface{})["size"], float64(7))
+
+	results := rendered["nonHashData"].(map[string]interface{})["transactionResults"].([]interface{})
+	event := results[0].(map[string]interface{})["chaincodeEvent"].(map[string]interface{})
+	testutil.AssertEquals(t, event["payload"], "10")
+	testutil.AssertEquals(t, results[1].(map[string]interface{})["error"], "failed")
+
+	testutil.AssertEquals(t, rendered["stateDelta"], map[string]interface{}{"mycc": map[string]interface{}{
+		"a": map[string]interface{}{"value": "90", "previousValue": "100"},
+		"c": map[string]interface{}{"deleted": true, "previousValue": map[string]interface{}{"hex": "ff"}},
+	}})
+}
+
+func TestRenderConfidentialTransaction(t *testing.T) {
+	tx := &pb.Transaction{
+		Type:                 pb.Transaction_CHAINCODE_INVOKE,
+		ConfidentialityLevel: pb.ConfidentialityLevel_CONFIDENTIAL,
+		ChaincodeID:          []byte{0x00, 0x01},
+		Payload:              []byte{0x02, 0x03},
+		Cert:                 []byte("not a certificate"),
+	}
+	rendered := Transaction(tx)
+	testutil.AssertEquals(t, rendered["chaincodeID"], map[string]interface{}{"hex": "0001"})
+	testutil.AssertEquals(t, rendered["payload"], map[string]interface{}{"hex": "0203"})
+	testutil.AssertEquals(t, rendered["cert"], "not a certificate")
+}
+
+func TestMarshalCanonical(t *testing.T) {
+	raw, err := Marshal(map[string]interface{}{"b": "<&>", "a": map[string]interface{}{"d": 1, "c": 2}})
+	testutil.AssertNoError(t, err, "Error marshalling")
+	testutil.AssertEquals(t, string(raw), `{"a":{"c":2,"d":1},"b":"<&>"}`)
+}
@@ -20,18 +20,22 @@ import (
 	"bytes"
 	"fmt"
 	"reflect"
+	"sort"
 	"sync"
 
 	"github.com/golang/protobuf/proto"
+	"github.com/hyperledger/fabric/core/chaos"
 	"github.com/hyperledger/fabric/core/db"
 	"github.com/hyperledger/fabric/core/ledger/statemgmt"
 	"github.com/hyperledger/fabric/core/ledger/statemgmt/state"
+	"github.com/hyperledger/fabric/core/startup"
 	"github.com/hyperledger/fabric/events/producer"
 	"github.com/op/go-logging"
-	"github.com/tecbot/gorocksdb"
+	"github.com/spf13/viper"
 
 	"github.com/hyperledger/fabric/protos"
 	"golang.org/x/net/context"
+	google_protobuf "google/protobuf"
 )
 
 var ledgerLogger = logging.MustGetLogger("ledger")
@@ -82,6 +86,16 @@ type Ledger struct {
 	blockchain *blockchain
 	state      *state.State
 	currentID  interface{}
+
+	// consensus time of the current transaction-batch, nil if the consensus
+	// does not stamp batches
+	currentTimestamp *google_protobuf.Timestamp
+	// random value of the current transaction-batch, nil if the consensus
+	// gives it none
+	currentRandom []byte
+
+	statsLock sync.RWMutex
+	stats     *ledgerStats
 }
 
 var ledger *Ledger
@@ -104,7 +118,13 @@ func GetNewLedger() (*Ledger, error) {
 	}
 
 	state := state.NewState()
-	return &Ledger{blockchain, state, nil}, nil
+	if viper.GetBool("peer.validator.events.writeSets") {
+		state.KeepTxStateDeltas()
+	}
+	if err = reconcileStateHeight(blockchain, state); err != nil {
+		return nil, err
+	}
+	return &Ledger{blockchain: blockchain, state: state}, nil
 }
 
 /////////////////// Transaction-batch related methods ///////////////////////////////
@@ -117,6 +137,11 @@ func (ledger *Ledger) BeginTxBatch(id interface{}) error {
 		return err
 	}
 	ledger.currentID = id
+	err = ledger.state.ExpireKeys(ledger.blockchain.getSize())
+	if err != nil {
+		ledger.resetForNextTxGroup(false)
+		return err
+	}
 	return nil
 }
 
@@ -135,11 +160,47 @@ func (ledger *Ledger) GetTXBatchPreviewBlockInfo(id interface{},
 	if err != nil {
 		return nil, err
 	}
-	block := ledger.blockchain.buildBlock(protos.NewBlock(transactions, metadata), stateHash)
+	block := protos.NewBlock(transactions, metadata)
+	block.Timestamp = ledger.currentTimestamp
+	block = ledger.blockchain.buildBlock(block, stateHash)
 	info := ledger.blockchain.getBlockchainInfoForBlock(ledger.blockchain.getSize()+1, block)
 	return info, nil
 }
 
+// SetTxBatchTimestamp sets the consensus time of the current transaction-batch,
+// which the block committing it carries
+func (ledger *Ledger) SetTxBatchTimestamp(id interface{}, timestamp *google_protobuf.Timestamp) error {
+	err := ledger.checkValidIDCommitORRollback(id)
+	if err != nil {
+		return err
+	}
+	ledger.currentTimestamp = timestamp
+	return nil
+}
+
+// SetTxBatchRandom sets the random value of the current transaction-batch,
+// which all validators agree on
+func (ledger *Ledger) SetTxBatchRandom(id interface{}, random []byte) error {
+	err := ledger.checkValidIDCommitORRollback(id)
+	if err != nil {
+		return err
+	}
+	ledger.currentRandom = random
+	return nil
+}
+
+// GetTxBatchRandom returns the random value of the current
+// transaction-batch, nil if it has none
+func (ledger *Ledger) GetTxBatchRandom() []byte {
+	return ledger.currentRandom
+}
+
+// GetTxBatchTimestamp returns the consensus time of the current
+// transaction-batch, nil if it has none
+func (ledger *Ledger) GetTxBatchTimestamp() *google_protobuf.Timestamp {
+	return ledger.currentTimestamp
+}
+
 // CommitTxBatch - gets invoked when the current transaction-batch needs to be committed
 // This function returns successfully iff the transactions details and state changes (that
 // may have happened during execution of this transaction-batch) have been committed to permanent storage
@@ -148,6 +209,7 @@ func (ledger *Ledger) CommitTxBatch(id interface{}, transactions []*protos.Trans
 	if err != nil {
 		return err
 	}
+	chaos.DelayCommit()
 
 	stateHash, err := ledger.state.GetHash()
 	if err != nil {
@@ -155,31 +217,47 @@ func (ledger *Ledger) CommitTxBatch(id interface{}, transactions []*protos.Trans
 		ledger.blockchain.blockPersistenceStatus(false)
 		return err
 	}
+	// the statistics are loaded before the block is added, if not yet loaded
+	stats, err := ledger.getStats()
+	if err != nil {
+		ledger.resetForNextTxGroup(false)
+		ledger.blockchain.blockPersistenceStatus(false)
+		return err
+	}
+	stats = stats.clone()
 
-	writeBatch := gorocksdb.NewWriteBatch()
-	defer writeBatch.Destroy()
+	commitBatch := db.GetDBHandle().NewCommitBatch()
+	defer commitBatch.Destroy()
 	block := protos.NewBlock(transactions, metadata)
+	block.Timestamp = ledger.currentTimestamp
 	block.NonHashData = &protos.NonHashData{TransactionResults: transactionResults}
-	newBlockNumber, err := ledger.blockchain.addPersistenceChangesForNewBlock(context.TODO(), block, stateHash, writeBatch)
+	newBlockNumber, err := ledger.blockchain.addPersistenceChangesForNewBlock(context.TODO(), block, stateHash, commitBatch.Blocks)
 	if err != nil {
 		ledger.resetForNextTxGroup(false)
 		ledger.blockchain.blockPersistenceStatus(false)
 		return err
 	}
-	ledger.state.AddChangesForPersistence(newBlockNumber, writeBatch)
-	opt := gorocksdb.NewDefaultWriteOptions()
-	defer opt.Destroy()
-	dbErr := db.GetDBHandle().DB.Write(opt, writeBatch)
+	ledger.state.AddChangesForPersistence(newBlockNumber, commitBatch.State)
+	stats.applyStateDelta(ledger.state.GetStateDelta())
+	stats.addBlock(uint64(proto.Size(block)))
+	if err = stats.addChangesForPersistence(commitBatch.Blocks); err != nil {
+		ledger.resetForNextTxGroup(false)
+		ledger.blockchain.blockPersistenceStatus(false)
+		return err
+	}
+	dbErr := db.GetDBHandle().WriteCommitBatch(commitBatch, newBlockNumber+1)
 	if dbErr != nil {
 		ledger.resetForNextTxGroup(false)
 		ledger.blockchain.blockPersistenceStatus(false)
 		return dbErr
 	}
 
+	writeSets := ledger.getWriteSets(transactions)
+	ledger.setStats(stats)
 	ledger.resetForNextTxGroup(true)
 	ledger.blockchain.blockPersistenceStatus(true)
 
-	sendProducerBlockEvent(block)
+	sendProducerBlockEvent(block, writeSets)
 	return nil
 }
 
@@ -238,6 +316,13 @@ func (ledger *Ledger) GetStateRangeScanIterator(chaincodeID string, startKey str
 	return ledger.state.GetRangeScanIterator(chaincodeID, startKey, endKey, committed)
 }
 
+// GetStateRangeScanIteratorAfter returns an iterator resuming a range scan of the
+// committed state right after lastKey, a key returned by an earlier scan of the
+// range, in the order the scan returns keys in.
+func (ledger *Ledger) GetStateRangeScanIteratorAfter(chaincodeID string, startKey string, endKey string, lastKey string, committed bool) (statemgmt.RangeScanIterator, error) {
+	return ledger.state.GetRangeScanIteratorAfter(chaincodeID, startKey, endKey, lastKey, committed)
+}
+
 // SetState sets state to given value for chaincodeID and key. Does not immideatly writes to DB
 func (ledger *Ledger) SetState(chaincodeID string, key string, value []byte) error {
 	if key == "" || value == nil {
@@ -247,11 +332,104 @@ func (ledger *Ledger) SetState(chaincodeID string, key string, value []byte) err
 	return ledger.state.Set(chaincodeID, key, value)
 }
 
+// SetStateWithTTL sets state to given value for chaincodeID and key, and schedules the key to be
+// deleted once ttl more blocks have been added to the chain. The expiry is applied at the
+// beginning of the tx-batch that constructs the expiry block, so that all validators expire
+// the key deterministically. A ttl of zero is the same as calling SetState.
+// Does not immideatly writes to DB
+func (ledger *Ledger) SetStateWithTTL(chaincodeID string, key string, value []byte, ttl uint64) error {
+	if ttl == 0 {
+		return ledger.SetState(chaincodeID, key, value)
+	}
+	if key == "" || value == nil {
+		return newLedgerError(ErrorTypeInvalidArgument,
+			fmt.Sprintf("An empty string key or a nil value is not supported. Method invoked with key='%s', value='%#v'", key, value))
+	}
+	return ledger.state.SetWithTTL(chaincodeID, key, value, ledger.blockchain.getSize()+ttl)
+}
+
+// GetStateExpiry returns the block number at which the key for chaincodeID expires. The second
+// return value is false if the key was not written with a TTL
+func (ledger *Ledger) GetStateExpiry(chaincodeID string, key string, committed bool) (uint64, bool, error) {
+	return ledger.state.GetExpiry(chaincodeID, key, committed)
+}
+
+// GetStatistics returns the number of keys and bytes of state held by each chaincode, and the
+// distribution of the sizes of the blocks
+func (ledger *Ledger) GetStatistics() (*protos.LedgerStatistics, error) {
+	stats, err := ledger.getStats()
+	if err != nil {
+		return nil, err
+	}
+	return stats.toProto(), nil
+}
+
+// getStats returns the statistics of the ledger, loading them on first use
+// rather than when the ledger is opened, as rebuilding them for a ledger which
+// predates them scans the whole state
+func (ledger *Ledger) getStats() (*ledgerStats, error) {
+	ledger.statsLock.RLock()
+	stats := ledger.stats
+	ledger.statsLock.RUnlock()
+	if stats != nil {
+		return stats, nil
+	}
+
+	ledger.statsLock.Lock()
+	defer ledger.statsLock.Unlock()
+	if ledger.stats == nil {
+		done := startup.Lazy("ledger_statistics")
+		stats, err := loadLedgerStats(ledger.blockchain, ledger.state)
+		if err != nil {
+			return nil, err
+		}
+		done()
+		ledger.stats = stats
+	}
+	return ledger.stats, nil
+}
+
+func (ledger *Ledger) setStats(stats *ledgerStats) {
+	ledger.statsLock.Lock()
+	defer ledger.statsLock.Unlock()
+	ledger.stats = stats
+}
+
+// updateStats persists stats on its own, for changes committed outside of a tx-batch
+func (ledger *Ledger) updateStats(stats *ledgerStats) error {
+	if err := stats.persist(); err != nil {
+		return err
+	}
+	ledger.setStats(stats)
+	return nil
+}
+
+// GetStateUsage returns the number of bytes of committed state held by chaincodeID along with the
+// quota configured for it. A quota of zero means that the usage is not limited
+func (ledger *Ledger) GetStateUsage(chaincodeID string) (uint64, uint64, error) {
+	if !state.IsQuotaEnabled() {
+		return 0, 0, fmt.Errorf("State usage accounting is not enabled. Please check 'ledger.state.quota.enabled'")
+	}
+	usage, err := ledger.state.GetUsage(chaincodeID, true)
+	if err != nil {
+		return 0, 0, err
+	}
+	return usage, state.GetQuota(chaincodeID), nil
+}
+
 // DeleteState tracks the deletion of state for chaincodeID and key. Does not immideatly writes to DB
 func (ledger *Ledger) DeleteState(chaincodeID string, key string) error {
 	return ledger.state.Delete(chaincodeID, key)
 }
 
+// DeleteStateAtBlockBoundary tracks the deletion of state for chaincodeID and key
+// between the txs of the current tx-batch, on behalf of the validator rather than
+// of a tx. All validators must make the same deletions at the same point of the
+// tx-batch. Does not immideatly writes to DB
+func (ledger *Ledger) DeleteStateAtBlockBoundary(chaincodeID string, key string) error {
+	return ledger.state.DeleteAtBlockBoundary(chaincodeID, key)
+}
+
 // CopyState copies all the key-values from sourceChaincodeID to destChaincodeID
 func (ledger *Ledger) CopyState(sourceChaincodeID string, destChaincodeID string) error {
 	return ledger.state.CopyState(sourceChaincodeID, destChaincodeID)
@@ -274,7 +452,12 @@ func (ledger *Ledger) SetStateMultipleKeys(chaincodeID string, kvs map[string][]
 // stateSnapshot.Release() once you are done with the snapsnot to free up resources.
 func (ledger *Ledger) GetStateSnapshot() (*state.StateSnapshot, error) {
 	dbSnapshot := db.GetDBHandle().GetSnapshot()
-	blockHeight, err := fetchBlockchainSizeFromSnapshot(dbSnapshot)
+	// With the blocks stored apart, the snapshot does not cover them, the
+	// height the state of the snapshot is at is read from the snapshot itself
+	blockHeight, known, err := db.GetDBHandle().GetStateHeightFromSnapshot(dbSnapshot)
+	if err == nil && !known {
+		blockHeight, err = fetchBlockchainSizeFromSnapshot(dbSnapshot)
+	}
 	if err != nil {
 		dbSnapshot.Release()
 		return nil, err
@@ -331,7 +514,16 @@ func (ledger *Ledger) CommitStateDelta(id interface{}) error {
 		return err
 	}
 	defer ledger.resetForNextTxGroup(true)
-	return ledger.state.CommitStateDelta()
+	stats, err := ledger.getStats()
+	if err != nil {
+		return err
+	}
+	stats = stats.clone()
+	stats.applyStateDelta(ledger.state.GetStateDelta())
+	if err = ledger.state.CommitStateDelta(); err != nil {
+		return err
+	}
+	return ledger.updateStats(stats)
 }
 
 // RollbackStateDelta will discard the state delta passed
@@ -349,7 +541,16 @@ func (ledger *Ledger) RollbackStateDelta(id interface{}) error {
 // This is generally only used during state synchronization when creating a
 // new state from a snapshot.
 func (ledger *Ledger) DeleteALLStateKeysAndValues() error {
-	return ledger.state.DeleteState()
+	if err := ledger.state.DeleteState(); err != nil {
+		return err
+	}
+	stats, err := ledger.getStats()
+	if err != nil {
+		return err
+	}
+	stats = stats.clone()
+	stats.clearState()
+	return ledger.updateStats(stats)
 }
 
 /////////////////// blockchain related methods /////////////////////////////////////
@@ -361,6 +562,20 @@ func (ledger *Ledger) GetBlockchainInfo() (*protos.BlockchainInfo, error) {
 	return ledger.blockchain.getBlockchainInfo()
 }
 
+// GetChainTime returns the consensus time of the blockchain, that is the
+// timestamp of its latest block
+func (ledger *Ledger) GetChainTime() (*protos.ChainTime, error) {
+	size := ledger.GetBlockchainSize()
+	if size == 0 {
+		return nil, ErrOutOfBounds
+	}
+	block, err := ledger.blockchain.getBlock(size - 1)
+	if err != nil {
+		return nil, err
+	}
+	return &protos.ChainTime{BlockNumber: size - 1, Timestamp: block.Timestamp}, nil
+}
+
 // GetBlockByNumber return block given the number of the block on blockchain.
 // Lowest block on chain is block number zero
 func (ledger *Ledger) GetBlockByNumber(blockNumber uint64) (*protos.Block, error) {
@@ -370,6 +585,16 @@ func (ledger *Ledger) GetBlockByNumber(blockNumber uint64) (*protos.Block, error
 	return ledger.blockchain.getBlock(blockNumber)
 }
 
+// GetBlockByHash returns the block with the given hash. ErrResourceNotFound is
+// returned if there is none
+func (ledger *Ledger) GetBlockByHash(blockHash []byte) (*protos.Block, error) {
+	block, err := ledger.blockchain.getBlockByHash(blockHash)
+	if ledgerErr, ok := err.(*Error); ok && ledgerErr.Type() == ErrorTypeBlockNotFound {
+		return nil, ErrResourceNotFound
+	}
+	return block, err
+}
+
 // GetBlockchainSize returns number of blocks in blockchain
 func (ledger *Ledger) GetBlockchainSize() uint64 {
 	return ledger.blockchain.getSize()
@@ -380,6 +605,13 @@ func (ledger *Ledger) GetTransactionByUUID(txUUID string) (*protos.Transaction,
 	return ledger.blockchain.getTransactionByUUID(txUUID)
 }
 
+// GetTransactionBlockNumberByUUID returns the number of the block that holds the
+// transaction with the given uuid. ErrResourceNotFound is returned if there is none
+func (ledger *Ledger) GetTransactionBlockNumberByUUID(txUUID string) (uint64, error) {
+	blockNumber, _, err := ledger.blockchain.indexer.fetchTransactionIndexByUUID(txUUID)
+	return blockNumber, err
+}
+
 // GetTransactionByUUID return transaction by it's uuid
 func (ledger *Ledger) GetTransactionResultByUUID(txUUID string) (*protos.TransactionResult, error) {
 	return ledger.blockchain.getTransactionResultByUUID(txUUID)
@@ -388,11 +620,27 @@ func (ledger *Ledger) GetTransactionResultByUUID(txUUID string) (*protos.Transac
 // PutRawBlock puts a raw block on the chain. This function should only be
 // used for synchronization between peers.
 func (ledger *Ledger) PutRawBlock(block *protos.Block, blockNumber uint64) error {
-	err := ledger.blockchain.persistRawBlock(block, blockNumber)
+	existing, err := fetchBlockFromDB(blockNumber)
+	if err != nil {
+		return err
+	}
+	// the statistics are loaded before the block is put, if not yet loaded
+	stats, err := ledger.getStats()
 	if err != nil {
 		return err
 	}
-	sendProducerBlockEvent(block)
+	err = ledger.blockchain.persistRawBlock(block, blockNumber)
+	if err != nil {
+		return err
+	}
+	if existing == nil {
+		stats = stats.clone()
+		stats.addBlock(uint64(proto.Size(block)))
+		if err = ledger.updateStats(stats); err != nil {
+			return err
+		}
+	}
+	sendProducerBlockEvent(block, nil)
 	return nil
 }
 
@@ -464,10 +712,50 @@ func (ledger *Ledger) checkValidIDCommitORRollback(id interface{}) error {
 func (ledger *Ledger) resetForNextTxGroup(txCommited bool) {
 	ledgerLogger.Debug("resetting ledger state for next transaction batch")
 	ledger.currentID = nil
+	ledger.currentTimestamp = nil
+	ledger.currentRandom = nil
 	ledger.state.ClearInMemoryChanges(txCommited)
 }
 
-func sendProducerBlockEvent(block *protos.Block) {
+// getWriteSets returns the writes to the state of the transactions of the
+// ongoing tx-batch, for those which changed it, in block order. It returns nil
+// unless peer.validator.events.writeSets is set.
+func (ledger *Ledger) getWriteSets(transactions []*protos.Transaction) []*protos.TransactionWriteSet {
+	deltas := ledger.state.GetTxStateDeltas()
+	if deltas == nil {
+		return nil
+	}
+	writeSets := []*protos.TransactionWriteSet{}
+	for _, transaction := range transactions {
+		delta, ok := deltas[transaction.Uuid]
+		if !ok {
+			continue
+		}
+		writeSet := &protos.TransactionWriteSet{TxUuid: transaction.Uuid}
+		for _, chaincodeID := range delta.GetUpdatedChaincodeIds(true) {
+			updates := delta.GetUpdates(chaincodeID)
+			keys := make([]string, 0, len(updates))
+			for key := range updates {
+				keys = append(keys, key)
+			}
+			sort.Strings(keys)
+			for _, key := range keys {
+				writeSet.Writes = append(writeSet.Writes, &protos.StateWrite{
+					ChaincodeID: chaincodeID,
+					Key:         key,
+					Value:       updates[key].GetValue(),
+					IsDelete:    updates[key].IsDelete(),
+				})
+			}
+		}
+		writeSets = append(writeSets, writeSet)
+	}
+	return writeSets
+}
+
+// sendProducerBlockEvent sends the block event of a block, along with the
+// writes of its transactions if known
+func sendProducerBlockEvent(block *protos.Block, writeSets []*protos.TransactionWriteSet) {
 
 	// Remove payload from deploy transactions. This is done to make block
 	// events more lightweight as the payload for these types of transactions
@@ -491,7 +779,9 @@ func sendProducerBlockEvent(block *protos.Block) {
 		}
 	}
 
-	producer.Send(producer.CreateBlockEvent(block))
+	blockEvent := producer.CreateBlockEvent(block)
+	blockEvent.WriteSets = writeSets
+	producer.Send(blockEvent)
 
 	//when we send block event, send chaincode events as well
 	sendChaincodeEvents(block)
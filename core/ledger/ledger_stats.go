@@ -0,0 +1,228 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ledger
+
+import (
+	"sort"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/hyperledger/fabric/core/db"
+	"github.com/hyperledger/fabric/core/ledger/statemgmt"
+	"github.com/hyperledger/fabric/core/ledger/statemgmt/state"
+	"github.com/hyperledger/fabric/protos"
+	"github.com/tecbot/gorocksdb"
+)
+
+// The ledger statistics are maintained incrementally as blocks and state
+// deltas are committed, and persisted along with them. They are local to the
+// peer and not part of the state hash: a peer upgraded from a version which
+// did not keep them rebuilds them from its ledger when it starts.
+
+var ledgerStatsKey = []byte("ledgerStatistics")
+
+// blockSizeBounds are the upper bounds of the buckets of the block size
+// histogram, in bytes
+var blockSizeBounds = []uint64{1 << 10, 10 << 10, 100 << 10, 1 << 20, 10 << 20}
+
+type chaincodeStats struct {
+	keys  uint64
+	bytes uint64
+}
+
+type ledgerStats struct {
+	chaincodes      map[string]*chaincodeStats
+	blocks          uint64
+	blockBytes      uint64
+	blockSizeCounts []uint64 // one more than blockSizeBounds
+}
+
+func newLedgerStats() *ledgerStats {
+	return &ledgerStats{
+		chaincodes:      make(map[string]*chaincodeStats),
+		blockSizeCounts: make([]uint64, len(blockSizeBounds)+1),
+	}
+}
+
+func (stats *ledgerStats) clone() *ledgerStats {
+	c := newLedgerStats()
+	for chaincodeID, ccStats := range stats.chaincodes {
+		ccCopy := *ccStats
+		c.chaincodes[chaincodeID] = &ccCopy
+	}
+	c.blocks = stats.blocks
+	c.blockBytes = stats.blockBytes
+	copy(c.blockSizeCounts, stats.blockSizeCounts)
+	return c
+}
+
+func (stats *ledgerStats) addBlock(size uint64) {
+	stats.blocks++
+	stats.blockBytes += size
+	i := sort.Search(len(blockSizeBounds), func(i int) bool { return size < blockSizeBounds[i] })
+	stats.blockSizeCounts[i]++
+}
+
+func (stats *ledgerStats) addKey(chaincodeID string, key string, value []byte) {
+	ccStats, ok := stats.chaincodes[chaincodeID]
+	if !ok {
+		ccStats = &chaincodeStats{}
+		stats.chaincodes[chaincodeID] = ccStats
+	}
+	ccStats.keys++
+	ccStats.bytes += uint64(len(key) + len(value))
+}
+
+func (stats *ledgerStats) removeKey(chaincodeID string, key string, value []byte) {
+	ccStats, ok := stats.chaincodes[chaincodeID]
+	if !ok {
+		return
+	}
+	size := uint64(len(key) + len(value))
+	if ccStats.keys <= 1 || ccStats.bytes <= size {
+		delete(stats.chaincodes, chaincodeID)
+		return
+	}
+	ccStats.keys--
+	ccStats.bytes -= size
+}
+
+// applyStateDelta accounts for the keys written and deleted by delta
+func (stats *ledgerStats) applyStateDelta(delta *statemgmt.StateDelta) {
+	for _, chaincodeID := range delta.GetUpdatedChaincodeIds(false) {
+		for key, updated := range delta.GetUpdates(chaincodeID) {
+			if updated.PreviousValue != nil {
+				stats.removeKey(chaincodeID, key, updated.PreviousValue)
+			}
+			if !updated.IsDelete() {
+				stats.addKey(chaincodeID, key, updated.Value)
+			}
+		}
+	}
+}
+
+// clearState forgets about all the keys, when the state is deleted
+func (stats *ledgerStats) clearState() {
+	stats.chaincodes = make(map[string]*chaincodeStats)
+}
+
+func (stats *ledgerStats) toProto() *protos.LedgerStatistics {
+	msg := &protos.LedgerStatistics{
+		Blocks:          stats.blocks,
+		BlockBytes:      stats.blockBytes,
+		BlockSizeBounds: append([]uint64{}, blockSizeBounds...),
+		BlockSizeCounts: append([]uint64{}, stats.blockSizeCounts...),
+	}
+	for chaincodeID, ccStats := range stats.chaincodes {
+		msg.Chaincodes = append(msg.Chaincodes, &protos.LedgerStatistics_Chaincode{
+			ChaincodeID: chaincodeID,
+			Keys:        ccStats.keys,
+			Bytes:       ccStats.bytes,
+		})
+		msg.Keys += ccStats.keys
+		msg.Bytes += ccStats.bytes
+	}
+	sort.Sort(chaincodeStatsByID(msg.Chaincodes))
+	return msg
+}
+
+type chaincodeStatsByID []*protos.LedgerStatistics_Chaincode
+
+func (a chaincodeStatsByID) Len() int           { return len(a) }
+func (a chaincodeStatsByID) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a chaincodeStatsByID) Less(i, j int) bool { return a[i].ChaincodeID < a[j].ChaincodeID }
+
+func (stats *ledgerStats) addChangesForPersistence(writeBatch *gorocksdb.WriteBatch) error {
+	statsBytes, err := proto.Marshal(stats.toProto())
+	if err != nil {
+		return err
+	}
+	writeBatch.PutCF(db.GetDBHandle().BlockchainCF, ledgerStatsKey, statsBytes)
+	return nil
+}
+
+func (stats *ledgerStats) persist() error {
+	writeBatch := gorocksdb.NewWriteBatch()
+	defer writeBatch.Destroy()
+	if err := stats.addChangesForPersistence(writeBatch); err != nil {
+		return err
+	}
+	return db.GetDBHandle().WriteBlocks(writeBatch)
+}
+
+// loadLedgerStats reads the statistics persisted with the ledger, rebuilding
+// them if the ledger predates them
+func loadLedgerStats(blockchain *blockchain, st *state.State) (*ledgerStats, error) {
+	statsBytes, err := db.GetDBHandle().GetFromBlockchainCF(ledgerStatsKey)
+	if err != nil {
+		return nil, err
+	}
+	if statsBytes == nil {
+		return rebuildLedgerStats(blockchain, st)
+	}
+	msg := &protos.LedgerStatistics{}
+	if err = proto.Unmarshal(statsBytes, msg); err != nil {
+		return nil, err
+	}
+	stats := newLedgerStats()
+	for _, ccStats := range msg.Chaincodes {
+		stats.chaincodes[ccStats.ChaincodeID] = &chaincodeStats{keys: ccStats.Keys, bytes: ccStats.Bytes}
+	}
+	stats.blocks = msg.Blocks
+	stats.blockBytes = msg.BlockBytes
+	copy(stats.blockSizeCounts, msg.BlockSizeCounts)
+	return stats, nil
+}
+
+// rebuildLedgerStats computes the statistics by going through all the blocks
+// and the whole state, and persists them
+func rebuildLedgerStats(blockchain *blockchain, st *state.State) (*ledgerStats, error) {
+	stats := newLedgerStats()
+	size := blockchain.getSize()
+	if size == 0 {
+		return stats, nil
+	}
+	ledgerLogger.Infof("Computing the statistics of a ledger of %d blocks", size)
+	start := time.Now()
+	for blockNumber := uint64(0); blockNumber < size; blockNumber++ {
+		block, err := fetchBlockFromDB(blockNumber)
+		if err != nil {
+			return nil, err
+		}
+		if block != nil {
+			stats.addBlock(uint64(proto.Size(block)))
+		}
+	}
+
+	dbSnapshot := db.GetDBHandle().GetSnapshot()
+	snapshot, err := st.GetSnapshot(size-1, dbSnapshot)
+	if err != nil {
+		dbSnapshot.Release()
+		return nil, err
+	}
+	defer snapshot.Release()
+	for snapshot.Next() {
+		compositeKey, value := snapshot.GetRawKeyValue()
+		chaincodeID, key := statemgmt.DecodeCompositeKey(compositeKey)
+		stats.addKey(chaincodeID, key, value)
+	}
+	if err := stats.persist(); err != nil {
+		return nil, err
+	}
+	ledgerLogger.Infof("Computed the statistics of the ledger in %s", time.Since(start))
+	return stats, nil
+}
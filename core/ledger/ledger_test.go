@@ -24,6 +24,7 @@ import (
 	"github.com/hyperledger/fabric/core/ledger/statemgmt"
 	"github.com/hyperledger/fabric/core/ledger/testutil"
 	"github.com/hyperledger/fabric/protos"
+	google_protobuf "google/protobuf"
 )
 
 func TestLedgerCommit(t *testing.T) {
@@ -41,6 +42,40 @@ func TestLedgerCommit(t *testing.T) {
 	testutil.AssertEquals(t, ledgerTestWrapper.GetState("chaincode1", "key1", true), []byte("value1"))
 }
 
+func TestLedgerWriteSets(t *testing.T) {
+	ledgerTestWrapper := createFreshDBAndTestLedgerWrapper(t)
+	ledger := ledgerTestWrapper.ledger
+	testutil.AssertNil(t, ledger.getWriteSets(nil))
+	ledger.state.KeepTxStateDeltas()
+
+	ledger.BeginTxBatch(1)
+	ledger.TxBegin("tx1")
+	ledger.SetState("chaincode2", "key2", []byte("value2"))
+	ledger.SetState("chaincode1", "key1", []byte("value1"))
+	ledger.TxFinished("tx1", true)
+	ledger.TxBegin("tx2")
+	ledger.SetState("chaincode1", "key3", []byte("value3"))
+	ledger.TxFinished("tx2", false)
+	ledger.TxBegin("tx3")
+	ledger.DeleteState("chaincode1", "key1")
+	ledger.TxFinished("tx3", true)
+	transactions := []*protos.Transaction{{Uuid: "tx1"}, {Uuid: "tx2"}, {Uuid: "tx3"}}
+
+	expected := []*protos.TransactionWriteSet{
+		{TxUuid: "tx1", Writes: []*protos.StateWrite{
+			{ChaincodeID: "chaincode1", Key: "key1", Value: []byte("value1")},
+			{ChaincodeID: "chaincode2", Key: "key2", Value: []byte("value2")},
+		}},
+		{TxUuid: "tx3", Writes: []*protos.StateWrite{
+			{ChaincodeID: "chaincode1", Key: "key1", IsDelete: true},
+		}},
+	}
+	testutil.AssertEquals(t, ledger.getWriteSets(transactions), expected)
+
+	ledger.CommitTxBatch(1, transactions, nil, []byte("proof"))
+	testutil.AssertEquals(t, ledger.getWriteSets(transactions), []*protos.TransactionWriteSet{})
+}
+
 func TestLedgerRollback(t *testing.T) {
 	ledgerTestWrapper := createFreshDBAndTestLedgerWrapper(t)
 	ledger := ledgerTestWrapper.ledger
@@ -950,3 +985,136 @@ func TestLedgerInvalidInput(t *testing.T) {
 	value, _ := l.GetState("chaincodeID1", "key1", true)
 	testutil.AssertEquals(t, value, []byte("value1"))
 }
+
+func TestLedgerStateTTL(t *testing.T) {
+	ledgerTestWrapper := createFreshDBAndTestLedgerWrapper(t)
+	l := ledgerTestWrapper.ledger
+	commitBatch := func(id int, f func()) {
+		l.BeginTxBatch(id)
+		l.TxBegin("txUUID")
+		if f != nil {
+			f()
+		}
+		l.TxFinished("txUUID", true)
+		tx, _ := buildTestTx(t)
+		err := l.CommitTxBatch(id, []*protos.Transaction{tx}, nil, nil)
+		testutil.AssertNoError(t, err, "Error committing tx batch")
+	}
+
+	// block 0 sets key1 to expire at block 2 and key2 to expire at block 3
+	commitBatch(0, func() {
+		l.SetStateWithTTL("chaincodeID1", "key1", []byte("value1"), 2)
+		l.SetStateWithTTL("chaincodeID1", "key2", []byte("value2"), 3)
+		l.SetStateWithTTL("chaincodeID1", "key3", []byte("value3"), 2)
+	})
+	expiry, ok, _ := l.GetStateExpiry("chaincodeID1", "key1", true)
+	testutil.AssertEquals(t, ok, true)
+	testutil.AssertEquals(t, expiry, uint64(2))
+
+	// block 1 overwrites key3 without a ttl, which clears its expiry
+	commitBatch(1, func() {
+		l.SetState("chaincodeID1", "key3", []byte("value3_new"))
+	})
+	_, ok, _ = l.GetStateExpiry("chaincodeID1", "key3", true)
+	testutil.AssertEquals(t, ok, false)
+	testutil.AssertEquals(t, ledgerTestWrapper.GetState("chaincodeID1", "key1", true), []byte("value1"))
+
+	commitBatch(2, nil)
+	testutil.AssertNil(t, ledgerTestWrapper.GetState("chaincodeID1", "key1", true))
+	testutil.AssertEquals(t, ledgerTestWrapper.GetState("chaincodeID1", "key2", true), []byte("value2"))
+	testutil.AssertEquals(t, ledgerTestWrapper.GetState("chaincodeID1", "key3", true), []byte("value3_new"))
+	_, ok, _ = l.GetStateExpiry("chaincodeID1", "key1", true)
+	testutil.AssertEquals(t, ok, false)
+
+	commitBatch(3, nil)
+	testutil.AssertNil(t, ledgerTestWrapper.GetState("chaincodeID1", "key2", true))
+
+	// the expiry is part of the state delta of the block and therefore replayable
+	delta := ledgerTestWrapper.GetStateDelta(3)
+	testutil.AssertEquals(t, delta.Get("chaincodeID1", "key2").IsDelete(), true)
+}
+
+func TestLedgerStatistics(t *testing.T) {
+	ledgerTestWrapper := createFreshDBAndTestLedgerWrapper(t)
+	l := ledgerTestWrapper.ledger
+	commitBatch := func(id int, f func()) {
+		l.BeginTxBatch(id)
+		l.TxBegin("txUUID")
+		f()
+		l.TxFinished("txUUID", true)
+		tx, _ := buildTestTx(t)
+		err := l.CommitTxBatch(id, []*protos.Transaction{tx}, nil, nil)
+		testutil.AssertNoError(t, err, "Error committing tx batch")
+	}
+
+	commitBatch(0, func() {
+		l.SetState("chaincode1", "key1", []byte("value1"))
+		l.SetState("chaincode1", "key2", []byte("value2"))
+		l.SetState("chaincode2", "key1", []byte("value1"))
+	})
+	commitBatch(1, func() {
+		l.SetState("chaincode1", "key1", []byte("value1_new"))
+		l.DeleteState("chaincode2", "key1")
+	})
+
+	stats, err := l.GetStatistics()
+	testutil.AssertNoError(t, err, "Error getting the ledger statistics")
+	testutil.AssertEquals(t, len(stats.Chaincodes), 1)
+	testutil.AssertEquals(t, stats.Chaincodes[0].ChaincodeID, "chaincode1")
+	testutil.AssertEquals(t, stats.Chaincodes[0].Keys, uint64(2))
+	testutil.AssertEquals(t, stats.Chaincodes[0].Bytes, uint64(len("key1value1_new")+len("key2value2")))
+	testutil.AssertEquals(t, stats.Keys, uint64(2))
+	testutil.AssertEquals(t, stats.Blocks, uint64(2))
+	testutil.AssertEquals(t, stats.BlockSizeCounts[0], uint64(2))
+
+	// statistics rebuilt from the ledger match the ones maintained at commit time
+	rebuilt, err := rebuildLedgerStats(l.blockchain, l.state)
+	testutil.AssertNoError(t, err, "Error rebuilding ledger statistics")
+	testutil.AssertEquals(t, rebuilt.toProto(), stats)
+
+	// a ledger opened afterwards loads the statistics on first use
+	reopened, err := GetNewLedger()
+	testutil.AssertNoError(t, err, "Error opening the ledger")
+	testutil.AssertNil(t, reopened.stats)
+	loaded, err := reopened.GetStatistics()
+	testutil.AssertNoError(t, err, "Error loading the ledger statistics")
+	testutil.AssertEquals(t, loaded, stats)
+}
+
+func TestLedgerBatchTimestamp(t *testing.T) {
+	ledgerTestWrapper := createFreshDBAndTestLedgerWrapper(t)
+	l := ledgerTestWrapper.ledger
+	commitBatch := func(id int, timestamp *google_protobuf.Timestamp) {
+		l.BeginTxBatch(id)
+		testutil.AssertNoError(t, l.SetTxBatchTimestamp(id, timestamp), "Error setting the tx batch timestamp")
+		testutil.AssertEquals(t, l.GetTxBatchTimestamp(), timestamp)
+		l.TxBegin("txUUID")
+		l.SetState("chaincode1", "key1", []byte("value1"))
+		l.TxFinished("txUUID", true)
+		tx, _ := buildTestTx(t)
+		testutil.AssertNoError(t, l.CommitTxBatch(id, []*protos.Transaction{tx}, nil, nil), "Error committing tx batch")
+	}
+
+	_, err := l.GetChainTime()
+	testutil.AssertSame(t, err, ErrOutOfBounds)
+
+	commitBatch(0, nil)
+	chainTime, err := l.GetChainTime()
+	testutil.AssertNoError(t, err, "Error getting the chain time")
+	testutil.AssertEquals(t, chainTime.BlockNumber, uint64(0))
+	testutil.AssertNil(t, chainTime.Timestamp)
+
+	timestamp := &google_protobuf.Timestamp{Seconds: 1234, Nanos: 5678}
+	commitBatch(1, timestamp)
+	block, err := l.GetBlockByNumber(1)
+	testutil.AssertNoError(t, err, "Error getting block")
+	testutil.AssertEquals(t, block.Timestamp, timestamp)
+	chainTime, err = l.GetChainTime()
+	testutil.AssertNoError(t, err, "Error getting the chain time")
+	testutil.AssertEquals(t, chainTime.BlockNumber, uint64(1))
+	testutil.AssertEquals(t, chainTime.Timestamp, timestamp)
+
+	// the timestamp does not outlive its batch
+	testutil.AssertNil(t, l.GetTxBatchTimestamp())
+	testutil.AssertError(t, l.SetTxBatchTimestamp(2, timestamp), "Expected an error setting the timestamp of a batch not begun")
+}
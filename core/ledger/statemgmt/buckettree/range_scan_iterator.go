@@ -46,6 +46,22 @@ func newRangeScanIterator(chaincodeID string, startKey string, endKey string) (*
 	return itr, nil
 }
 
+// newRangeScanIteratorAfter returns an iterator resuming the scan of the range
+// after lastKey. Keys are scanned bucket by bucket, so the scan resumes in the
+// bucket of lastKey, right after it.
+func newRangeScanIteratorAfter(chaincodeID string, startKey string, endKey string, lastKey string) (*RangeScanIterator, error) {
+	dbItr := db.GetDBHandle().GetStateCFIterator()
+	itr := &RangeScanIterator{
+		dbItr:       dbItr,
+		chaincodeID: chaincodeID,
+		startKey:    startKey,
+		endKey:      endKey,
+	}
+	itr.currentBucketNumber = newDataKey(chaincodeID, lastKey).getBucketKey().bucketNumber
+	itr.dbItr.Seek(minimumPossibleDataKeyBytes(itr.currentBucketNumber, chaincodeID, lastKey+"\x00"))
+	return itr, nil
+}
+
 // Next - see interface 'statemgmt.RangeScanIterator' for details
 func (itr *RangeScanIterator) Next() bool {
 	if itr.done {
@@ -17,6 +17,7 @@ limitations under the License.
 package buckettree
 
 import (
+	"fmt"
 	"testing"
 
 	"github.com/hyperledger/fabric/core/ledger/statemgmt"
@@ -147,3 +148,34 @@ func TestRangeScanIteratorEmptyArray(t *testing.T) {
 	testutil.AssertEquals(t, results["key3"], []byte{})
 	rangeScanItr.Close()
 }
+
+func TestRangeScanIteratorAfter(t *testing.T) {
+	testDBWrapper.CreateFreshDB(t)
+	stateImplTestWrapper := newStateImplTestWrapper(t)
+	stateDelta := statemgmt.NewStateDelta()
+	for i := 0; i < 20; i++ {
+		stateDelta.Set("chaincodeID1", fmt.Sprintf("key%02d", i), []byte(fmt.Sprintf("value%02d", i)), nil)
+	}
+	stateDelta.Set("chaincodeID2", "key05", []byte("value05"), nil)
+	stateImplTestWrapper.prepareWorkingSet(stateDelta)
+	stateImplTestWrapper.persistChangesAndResetInMemoryChanges()
+
+	scan := func(itr statemgmt.RangeScanIterator) []string {
+		defer itr.Close()
+		var keys []string
+		for itr.Next() {
+			key, _ := itr.GetKeyValue()
+			keys = append(keys, key)
+		}
+		return keys
+	}
+
+	// Keys come bucket by bucket, resuming after any of them returns the rest in the same order
+	all := scan(stateImplTestWrapper.getRangeScanIterator("chaincodeID1", "key02", "key17"))
+	testutil.AssertEquals(t, len(all), 16)
+	for i, lastKey := range all {
+		itr, err := stateImplTestWrapper.stateImpl.GetRangeScanIteratorAfter("chaincodeID1", "key02", "key17", lastKey)
+		testutil.AssertNoError(t, err, "Error while resuming range scan")
+		testutil.AssertEquals(t, scan(itr), append([]string(nil), all[i+1:]...))
+	}
+}
@@ -300,3 +300,8 @@ func (stateImpl *StateImpl) GetStateSnapshotIterator(snapshot *gorocksdb.Snapsho
 func (stateImpl *StateImpl) GetRangeScanIterator(chaincodeID string, startKey string, endKey string) (statemgmt.RangeScanIterator, error) {
 	return newRangeScanIterator(chaincodeID, startKey, endKey)
 }
+
+// GetRangeScanIteratorAfter - method implementation for interface 'statemgmt.ResumableRangeScanner'
+func (stateImpl *StateImpl) GetRangeScanIteratorAfter(chaincodeID string, startKey string, endKey string, lastKey string) (statemgmt.RangeScanIterator, error) {
+	return newRangeScanIteratorAfter(chaincodeID, startKey, endKey, lastKey)
+}
@@ -82,6 +82,15 @@ type StateSnapshotIterator interface {
 	Close()
 }
 
+// ResumableRangeScanner is implemented by the HashableState implementations
+// whose range scans can resume right after a key returned by an earlier scan
+// of the same range, whatever the order the implementation returns keys in
+type ResumableRangeScanner interface {
+	// GetRangeScanIteratorAfter returns an iterator over the key-values of the
+	// range which a scan of the range returns after lastKey
+	GetRangeScanIteratorAfter(chaincodeID string, startKey string, endKey string, lastKey string) (RangeScanIterator, error)
+}
+
 // RangeScanIterator - is to be implemented by the return value of
 // GetRangeScanIterator method in the implementation of HashableState interface
 type RangeScanIterator interface {
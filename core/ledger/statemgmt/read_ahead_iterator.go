@@ -0,0 +1,119 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package statemgmt
+
+// ReadAheadIterator reads the key-values of a RangeScanIterator in batches, a
+// batch ahead of its consumer, so that the reads from storage overlap with the
+// processing of the key-values already read
+type ReadAheadIterator struct {
+	itr       RangeScanIterator
+	batchSize int
+	batches   chan []readAheadKeyValue
+	stop      chan struct{}
+	stopped   chan struct{}
+	batch     []readAheadKeyValue
+	current   readAheadKeyValue
+	started   bool
+	closed    bool
+}
+
+type readAheadKeyValue struct {
+	key   string
+	value []byte
+}
+
+// NewReadAheadIterator returns an iterator reading the key-values of itr
+// batchSize at a time, ahead of the consumer. It returns itr itself if
+// batchSize is not positive. The iterator only starts reading on the first
+// call to Next.
+func NewReadAheadIterator(itr RangeScanIterator, batchSize int) RangeScanIterator {
+	if batchSize <= 0 {
+		return itr
+	}
+	return &ReadAheadIterator{
+		itr:       itr,
+		batchSize: batchSize,
+		batches:   make(chan []readAheadKeyValue, 1),
+		stop:      make(chan struct{}),
+		stopped:   make(chan struct{}),
+	}
+}
+
+// readAhead reads batches from the underlying iterator until it is exhausted
+// or the iterator is closed. It keeps at most one batch ready besides the one
+// being consumed.
+func (itr *ReadAheadIterator) readAhead() {
+	defer close(itr.stopped)
+	defer close(itr.batches)
+	for {
+		batch := make([]readAheadKeyValue, 0, itr.batchSize)
+		for len(batch) < itr.batchSize && itr.itr.Next() {
+			key, value := itr.itr.GetKeyValue()
+			batch = append(batch, readAheadKeyValue{key, value})
+		}
+		if len(batch) == 0 {
+			return
+		}
+		select {
+		case itr.batches <- batch:
+		case <-itr.stop:
+			return
+		}
+		if len(batch) < itr.batchSize {
+			return
+		}
+	}
+}
+
+// Next - see interface 'RangeScanIterator' for details
+func (itr *ReadAheadIterator) Next() bool {
+	if itr.closed {
+		return false
+	}
+	if !itr.started {
+		itr.started = true
+		go itr.readAhead()
+	}
+	if len(itr.batch) == 0 {
+		batch, ok := <-itr.batches
+		if !ok {
+			return false
+		}
+		itr.batch = batch
+	}
+	itr.current, itr.batch = itr.batch[0], itr.batch[1:]
+	return true
+}
+
+// GetKeyValue - see interface 'RangeScanIterator' for details
+func (itr *ReadAheadIterator) GetKeyValue() (string, []byte) {
+	return itr.current.key, itr.current.value
+}
+
+// Close - see interface 'RangeScanIterator' for details. It waits for the
+// batch being read to complete before closing the underlying iterator.
+func (itr *ReadAheadIterator) Close() {
+	if itr.closed {
+		return
+	}
+	itr.closed = true
+	if itr.started {
+		close(itr.stop)
+		<-itr.stopped
+	}
+	itr.itr.Close()
+}
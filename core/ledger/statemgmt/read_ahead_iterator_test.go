@@ -0,0 +1,67 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package statemgmt
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/hyperledger/fabric/core/ledger/testutil"
+)
+
+type closeCountingIterator struct {
+	RangeScanIterator
+	closed int
+}
+
+func (itr *closeCountingIterator) Close() {
+	itr.closed++
+	itr.RangeScanIterator.Close()
+}
+
+func TestReadAheadIterator(t *testing.T) {
+	delta := NewStateDelta()
+	expected := make(map[string][]byte)
+	for i := 0; i < 10; i++ {
+		key, value := fmt.Sprintf("key%d", i), []byte(fmt.Sprintf("value%d", i))
+		delta.Set("chaincodeID1", key, value, nil)
+		expected[key] = value
+	}
+	delta.Set("chaincodeID2", "key", []byte("value"), nil)
+
+	for _, batchSize := range []int{1, 3, 10, 20} {
+		itr := NewReadAheadIterator(NewStateDeltaRangeScanIterator(delta, "chaincodeID1", "", ""), batchSize)
+		AssertIteratorContains(t, itr, expected)
+		testutil.AssertEquals(t, itr.Next(), false)
+		itr.Close()
+	}
+
+	// Closing halfway stops reading ahead and closes the underlying iterator once
+	counting := &closeCountingIterator{RangeScanIterator: NewStateDeltaRangeScanIterator(delta, "chaincodeID1", "", "")}
+	itr := NewReadAheadIterator(counting, 2)
+	for i := 0; i < 3; i++ {
+		testutil.AssertEquals(t, itr.Next(), true)
+	}
+	itr.Close()
+	itr.Close()
+	testutil.AssertEquals(t, counting.closed, 1)
+	testutil.AssertEquals(t, itr.Next(), false)
+
+	// Read-ahead is disabled by a batch size of 0
+	plain := NewStateDeltaRangeScanIterator(delta, "chaincodeID1", "", "")
+	testutil.AssertSame(t, NewReadAheadIterator(plain, 0), plain)
+}
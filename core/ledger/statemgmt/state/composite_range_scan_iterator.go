@@ -88,3 +88,14 @@ func (itr *CompositeRangeScanIterator) GetKeyValue() (string, []byte) {
 func (itr *CompositeRangeScanIterator) Close() {
 	itr.itrs[2].Close()
 }
+
+// NewOverlayRangeScanIterator returns an iterator over the key-values of
+// chaincodeID between startKey and endKey which delta puts, followed by the
+// key-values of implItr whose key delta does not change
+func NewOverlayRangeScanIterator(delta *statemgmt.StateDelta, chaincodeID string, startKey string, endKey string,
+	implItr statemgmt.RangeScanIterator) statemgmt.RangeScanIterator {
+	return newCompositeRangeScanIterator(
+		statemgmt.NewStateDeltaRangeScanIterator(delta, chaincodeID, startKey, endKey),
+		statemgmt.NewStateDeltaRangeScanIterator(statemgmt.NewStateDelta(), chaincodeID, startKey, endKey),
+		implItr)
+}
@@ -28,6 +28,11 @@ var loadConfigOnce sync.Once
 var stateImplName string
 var stateImplConfigs map[string]interface{}
 var deltaHistorySize int
+var rangeScanReadAhead int
+var backgroundHashing bool
+var stateQuotaEnabled bool
+var defaultStateQuota uint64
+var stateQuotas map[string]uint64
 
 func initConfig() {
 	loadConfigOnce.Do(func() { loadConfig() })
@@ -51,4 +56,27 @@ func loadConfig() {
 	if deltaHistorySize < 0 {
 		panic(fmt.Errorf("Delta history size must be greater than or equal to 0. Current value is %d.", deltaHistorySize))
 	}
+
+	rangeScanReadAhead = viper.GetInt("ledger.state.rangeScanReadAhead")
+	if rangeScanReadAhead < 0 {
+		panic(fmt.Errorf("Range scan read-ahead must be greater than or equal to 0. Current value is %d.", rangeScanReadAhead))
+	}
+
+	backgroundHashing = viper.GetBool("ledger.state.backgroundHashing")
+
+	stateQuotaEnabled = viper.GetBool("ledger.state.quota.enabled")
+	quota := viper.GetInt("ledger.state.quota.default")
+	if quota < 0 {
+		panic(fmt.Errorf("State quota must be greater than or equal to 0. Current value is %d.", quota))
+	}
+	defaultStateQuota = uint64(quota)
+	stateQuotas = make(map[string]uint64)
+	for chaincodeID := range viper.GetStringMap("ledger.state.quota.chaincodes") {
+		quota := viper.GetInt("ledger.state.quota.chaincodes." + chaincodeID)
+		if quota < 0 {
+			panic(fmt.Errorf("State quota for chaincode [%s] must be greater than or equal to 0. Current value is %d.", chaincodeID, quota))
+		}
+		stateQuotas[chaincodeID] = uint64(quota)
+	}
+	logger.Infof("State quotas loaded. enabled=[%t], default=[%d], overrides=%v", stateQuotaEnabled, defaultStateQuota, stateQuotas)
 }
@@ -46,6 +46,8 @@ type State struct {
 	txStateDeltaHash      map[string][]byte
 	updateStateImpl       bool
 	historyStateDeltaSize uint64
+	txStateDeltas         map[string]*statemgmt.StateDelta // nil unless the changes of each tx are kept
+	hasher                *stateHasher                     // nil unless the state is hashed in the background
 }
 
 // NewState constructs a new State. This Initializes encapsulated state implementation
@@ -66,8 +68,12 @@ func NewState() *State {
 	if err != nil {
 		panic(fmt.Errorf("Error during initialization of state implementation: %s", err))
 	}
-	return &State{stateImpl, statemgmt.NewStateDelta(), statemgmt.NewStateDelta(), "", make(map[string][]byte),
-		false, uint64(deltaHistorySize)}
+	state := &State{stateImpl, statemgmt.NewStateDelta(), statemgmt.NewStateDelta(), "", make(map[string][]byte),
+		false, uint64(deltaHistorySize), nil, nil}
+	if backgroundHashing {
+		state.hasher = newStateHasher(state)
+	}
+	return state
 }
 
 // TxBegin marks begin of a new tx. If a tx is already in progress, this call panics
@@ -88,9 +94,11 @@ func (state *State) TxFinish(txUUID string, txSuccessful bool) {
 	if txSuccessful {
 		if !state.currentTxStateDelta.IsEmpty() {
 			logger.Debugf("txFinish() for txUuid [%s] merging state changes", txUUID)
-			state.stateDelta.ApplyChanges(state.currentTxStateDelta)
+			state.changeStateDelta(func() { state.stateDelta.ApplyChanges(state.currentTxStateDelta) })
 			state.txStateDeltaHash[txUUID] = state.currentTxStateDelta.ComputeCryptoHash()
-			state.updateStateImpl = true
+			if state.txStateDeltas != nil {
+				state.txStateDeltas[txUUID] = state.currentTxStateDelta
+			}
 		} else {
 			state.txStateDeltaHash[txUUID] = nil
 		}
@@ -120,12 +128,14 @@ func (state *State) Get(chaincodeID string, key string, committed bool) ([]byte,
 }
 
 // GetRangeScanIterator returns an iterator to get all the keys (and values) between startKey and endKey
-// (assuming lexical order of the keys) for a chaincodeID.
+// (assuming lexical order of the keys) for a chaincodeID. The committed key-values are read ahead from
+// the DB in batches of 'ledger.state.rangeScanReadAhead'.
 func (state *State) GetRangeScanIterator(chaincodeID string, startKey string, endKey string, committed bool) (statemgmt.RangeScanIterator, error) {
 	stateImplItr, err := state.stateImpl.GetRangeScanIterator(chaincodeID, startKey, endKey)
 	if err != nil {
 		return nil, err
 	}
+	stateImplItr = statemgmt.NewReadAheadIterator(stateImplItr, rangeScanReadAhead)
 
 	if committed {
 		return stateImplItr, nil
@@ -136,6 +146,25 @@ func (state *State) GetRangeScanIterator(chaincodeID string, startKey string, en
 		stateImplItr), nil
 }
 
+// GetRangeScanIteratorAfter returns an iterator resuming a scan of the committed
+// key-values between startKey and endKey right after lastKey, a key returned by
+// an earlier scan of the range. Scans merged with the in-memory changes of the
+// block cannot be resumed.
+func (state *State) GetRangeScanIteratorAfter(chaincodeID string, startKey string, endKey string, lastKey string, committed bool) (statemgmt.RangeScanIterator, error) {
+	resumable, ok := state.stateImpl.(statemgmt.ResumableRangeScanner)
+	if !ok {
+		return nil, fmt.Errorf("The state implementation cannot resume range scans")
+	}
+	if !committed {
+		return nil, fmt.Errorf("Range scans can only be resumed on committed state, in queries")
+	}
+	stateImplItr, err := resumable.GetRangeScanIteratorAfter(chaincodeID, startKey, endKey, lastKey)
+	if err != nil {
+		return nil, err
+	}
+	return statemgmt.NewReadAheadIterator(stateImplItr, rangeScanReadAhead), nil
+}
+
 // Set sets state to given value for chaincodeID and key. Does not immideatly writes to DB
 func (state *State) Set(chaincodeID string, key string, value []byte) error {
 	logger.Debugf("set() chaincodeID=[%s], key=[%s], value=[%#v]", chaincodeID, key, value)
@@ -143,6 +172,10 @@ func (state *State) Set(chaincodeID string, key string, value []byte) error {
 		panic("State can be changed only in context of a tx.")
 	}
 
+	if err := state.chargeUsage(chaincodeID, key, value); err != nil {
+		return err
+	}
+
 	// Check if a previous value is already set in the state delta
 	if state.currentTxStateDelta.IsUpdatedValueSet(chaincodeID, key) {
 		// No need to bother looking up the previous value as we will not
@@ -157,7 +190,7 @@ func (state *State) Set(chaincodeID string, key string, value []byte) error {
 		state.currentTxStateDelta.Set(chaincodeID, key, value, previousValue)
 	}
 
-	return nil
+	return state.clearTTL(chaincodeID, key)
 }
 
 // Delete tracks the deletion of state for chaincodeID and key. Does not immideatly writes to DB
@@ -167,6 +200,10 @@ func (state *State) Delete(chaincodeID string, key string) error {
 		panic("State can be changed only in context of a tx.")
 	}
 
+	if err := state.chargeUsage(chaincodeID, key, nil); err != nil {
+		return err
+	}
+
 	// Check if a previous value is already set in the state delta
 	if state.currentTxStateDelta.IsUpdatedValueSet(chaincodeID, key) {
 		// No need to bother looking up the previous value as we will not
@@ -181,7 +218,7 @@ func (state *State) Delete(chaincodeID string, key string) error {
 		state.currentTxStateDelta.Delete(chaincodeID, key, previousValue)
 	}
 
-	return nil
+	return state.clearTTL(chaincodeID, key)
 }
 
 // CopyState copies all the key-values from sourceChaincodeID to destChaincodeID
@@ -229,11 +266,7 @@ func (state *State) SetMultipleKeys(chaincodeID string, kvs map[string][]byte) e
 // Recomputes only if stateDelta has changed after most recent call to this function
 func (state *State) GetHash() ([]byte, error) {
 	logger.Debug("Enter - GetHash()")
-	if state.updateStateImpl {
-		logger.Debug("updating stateImpl with working-set")
-		state.stateImpl.PrepareWorkingSet(state.stateDelta)
-		state.updateStateImpl = false
-	}
+	state.prepareWorkingSet()
 	hash, err := state.stateImpl.ComputeCryptoHash()
 	if err != nil {
 		return nil, err
@@ -242,6 +275,31 @@ func (state *State) GetHash() ([]byte, error) {
 	return hash, nil
 }
 
+// changeStateDelta makes a change to the state delta of the ongoing tx-batch,
+// after which the working set of the state implementation is out of date
+func (state *State) changeStateDelta(change func()) {
+	if state.hasher != nil {
+		state.hasher.change(change)
+		return
+	}
+	change()
+	state.updateStateImpl = true
+}
+
+// prepareWorkingSet brings the working set of the state implementation up to
+// date with the state delta, waiting for the hashing in the background if any
+func (state *State) prepareWorkingSet() {
+	if state.hasher != nil {
+		state.hasher.wait()
+		return
+	}
+	if state.updateStateImpl {
+		logger.Debug("updating stateImpl with working-set")
+		state.stateImpl.PrepareWorkingSet(state.stateDelta)
+		state.updateStateImpl = false
+	}
+}
+
 // GetTxStateDeltaHash return the hash of the StateDelta
 func (state *State) GetTxStateDeltaHash() map[string][]byte {
 	return state.txStateDeltaHash
@@ -249,16 +307,41 @@ func (state *State) GetTxStateDeltaHash() map[string][]byte {
 
 // ClearInMemoryChanges remove from memory all the changes to state
 func (state *State) ClearInMemoryChanges(changesPersisted bool) {
+	if state.hasher != nil {
+		state.hasher.wait()
+	}
 	state.stateDelta = statemgmt.NewStateDelta()
 	state.txStateDeltaHash = make(map[string][]byte)
+	if state.txStateDeltas != nil {
+		state.txStateDeltas = make(map[string]*statemgmt.StateDelta)
+	}
 	state.stateImpl.ClearWorkingSet(changesPersisted)
 }
 
+// KeepTxStateDeltas makes the state keep the changes of each successful tx of
+// the ongoing tx-batch, until the call to ClearInMemoryChanges
+func (state *State) KeepTxStateDeltas() {
+	state.txStateDeltas = make(map[string]*statemgmt.StateDelta)
+}
+
+// GetTxStateDeltas returns the changes of the successful txs of the ongoing
+// tx-batch which changed the state, by tx uuid, nil unless KeepTxStateDeltas
+// was called
+func (state *State) GetTxStateDeltas() map[string]*statemgmt.StateDelta {
+	return state.txStateDeltas
+}
+
 // getStateDelta get changes in state after most recent call to method clearInMemoryChanges
 func (state *State) getStateDelta() *statemgmt.StateDelta {
 	return state.stateDelta
 }
 
+// GetStateDelta returns the changes in state since the last call to ClearInMemoryChanges,
+// that is those of the ongoing tx-batch or of the state delta being applied
+func (state *State) GetStateDelta() *statemgmt.StateDelta {
+	return state.stateDelta
+}
+
 // GetSnapshot returns a snapshot of the global state for the current block. stateSnapshot.Release()
 // must be called once you are done.
 func (state *State) GetSnapshot(blockNumber uint64, dbSnapshot *gorocksdb.Snapshot) (*StateSnapshot, error) {
@@ -282,10 +365,7 @@ func (state *State) FetchStateDeltaFromDB(blockNumber uint64) (*statemgmt.StateD
 // AddChangesForPersistence adds key-value pairs to writeBatch
 func (state *State) AddChangesForPersistence(blockNumber uint64, writeBatch *gorocksdb.WriteBatch) {
 	logger.Debug("state.addChangesForPersistence()...start")
-	if state.updateStateImpl {
-		state.stateImpl.PrepareWorkingSet(state.stateDelta)
-		state.updateStateImpl = false
-	}
+	state.prepareWorkingSet()
 	state.stateImpl.AddChangesForPersistence(writeBatch)
 
 	serializedStateDelta := state.stateDelta.Marshal()
@@ -307,21 +387,21 @@ func (state *State) AddChangesForPersistence(blockNumber uint64, writeBatch *gor
 // This is an in memory change only. state.CommitStateDelta must be used to
 // commit the state to the DB. This method is to be used in state transfer.
 func (state *State) ApplyStateDelta(delta *statemgmt.StateDelta) {
-	state.stateDelta = delta
-	state.updateStateImpl = true
+	state.changeStateDelta(func() { state.stateDelta = delta })
 }
 
 // CommitStateDelta commits the changes from state.ApplyStateDelta to the
 // DB.
 func (state *State) CommitStateDelta() error {
-	if state.updateStateImpl {
-		state.stateImpl.PrepareWorkingSet(state.stateDelta)
-		state.updateStateImpl = false
-	}
+	state.prepareWorkingSet()
 
 	writeBatch := gorocksdb.NewWriteBatch()
 	defer writeBatch.Destroy()
 	state.stateImpl.AddChangesForPersistence(writeBatch)
+	// The state no longer matches the height of the chain it was committed at
+	if err := db.GetDBHandle().ClearStateHeight(); err != nil {
+		return err
+	}
 	opt := gorocksdb.NewDefaultWriteOptions()
 	defer opt.Destroy()
 	return db.GetDBHandle().DB.Write(opt, writeBatch)
@@ -331,6 +411,7 @@ func (state *State) CommitStateDelta() error {
 // only used during state synchronization when creating a new state from
 // a snapshot.
 func (state *State) DeleteState() error {
+	// waits for the hashing in the background, which reads the state
 	state.ClearInMemoryChanges(false)
 	err := db.GetDBHandle().DeleteState()
 	if err != nil {
@@ -0,0 +1,126 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package state
+
+import (
+	"sync"
+
+	"github.com/hyperledger/fabric/core/db"
+)
+
+// stateHasher keeps the crypto-hash of the state up to date in the background
+// while the txs of a tx-batch change the state delta, so that computing the
+// hash for the block only waits for the changes made since the hashing last
+// started rather than for the whole tx-batch.
+// The hash is computed by the state implementation from the state delta alone,
+// so it does not depend on when, or how many times, it has been computed.
+// The working set of the state implementation must only be touched by the
+// caller once wait has returned.
+// The hashing reads the db, so it stops before the db is closed; the changes
+// made from then on are only prepared by wait.
+type stateHasher struct {
+	state   *State
+	lock    sync.Mutex
+	idle    *sync.Cond
+	running bool
+	stopped bool
+	changed bool // the state delta changed since the working set was prepared
+}
+
+func newStateHasher(state *State) *stateHasher {
+	hasher := &stateHasher{state: state}
+	hasher.idle = sync.NewCond(&hasher.lock)
+	db.BeforeClose(hasher.stop)
+	return hasher
+}
+
+// change makes a change to the state delta and starts hashing it in the
+// background, unless the hashing is already running, in which case it is
+// picked up by the hashing once done with the previous changes
+func (hasher *stateHasher) change(change func()) {
+	hasher.lock.Lock()
+	defer hasher.lock.Unlock()
+	change()
+	hasher.changed = true
+	if !hasher.running && !hasher.stopped {
+		hasher.running = true
+		go hasher.run()
+	}
+}
+
+func (hasher *stateHasher) run() {
+	hasher.lock.Lock()
+	for hasher.changed && !hasher.stopped {
+		hasher.changed = false
+		// the working set is prepared under the lock, as it reads the state
+		// delta, which changes under the lock
+		hasher.state.stateImpl.PrepareWorkingSet(hasher.state.stateDelta)
+		hasher.lock.Unlock()
+		ok := hasher.computeHash()
+		hasher.lock.Lock()
+		if !ok {
+			// leave the working set for wait to prepare again
+			hasher.changed = true
+			break
+		}
+	}
+	hasher.running = false
+	hasher.idle.Broadcast()
+	hasher.lock.Unlock()
+}
+
+// computeHash hashes the working set, and reports whether it succeeded. On
+// failure, the hash is computed again, and the error returned or the panic
+// raised, by the caller of wait.
+func (hasher *stateHasher) computeHash() (ok bool) {
+	defer func() {
+		if r := recover(); r != nil {
+			logger.Warningf("Panic computing the state hash in the background: %v", r)
+			ok = false
+		}
+	}()
+	if _, err := hasher.state.stateImpl.ComputeCryptoHash(); err != nil {
+		logger.Warningf("Error computing the state hash in the background: %s", err)
+		return false
+	}
+	return true
+}
+
+// wait waits until the working set of the state implementation holds all
+// the changes made to the state delta and the hashing of these is done
+func (hasher *stateHasher) wait() {
+	hasher.lock.Lock()
+	defer hasher.lock.Unlock()
+	for hasher.running {
+		hasher.idle.Wait()
+	}
+	if hasher.changed {
+		hasher.state.stateImpl.PrepareWorkingSet(hasher.state.stateDelta)
+		hasher.changed = false
+	}
+}
+
+// stop waits for the hashing in the background to be done, and keeps it from
+// starting again
+func (hasher *stateHasher) stop() {
+	hasher.lock.Lock()
+	defer hasher.lock.Unlock()
+	hasher.stopped = true
+	for hasher.running {
+		hasher.idle.Wait()
+	}
+}
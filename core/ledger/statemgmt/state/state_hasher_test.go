@@ -0,0 +1,112 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package state
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/hyperledger/fabric/core/ledger/statemgmt"
+	"github.com/hyperledger/fabric/core/ledger/testutil"
+)
+
+func TestStateBackgroundHashing(t *testing.T) {
+	hashes := make(map[bool][][]byte)
+	for _, background := range []bool{false, true} {
+		stateTestWrapper, state := createFreshDBAndConstructState(t)
+		if background {
+			state.hasher = newStateHasher(state)
+		} else {
+			state.hasher = nil
+		}
+		for block := uint64(0); block < 3; block++ {
+			for i := 0; i < 10; i++ {
+				txUUID := fmt.Sprintf("txUuid_%d_%d", block, i)
+				state.TxBegin(txUUID)
+				state.Set("chaincode1", fmt.Sprintf("key%d", i), []byte(fmt.Sprintf("value_%d_%d", block, i)))
+				if i%3 == 0 {
+					state.Delete("chaincode2", fmt.Sprintf("key%d", i-3))
+				}
+				state.Set("chaincode2", fmt.Sprintf("key%d", i), []byte(txUUID))
+				state.TxFinish(txUUID, i%4 != 0)
+			}
+			hash, err := state.GetHash()
+			testutil.AssertNoError(t, err, "Error computing the state hash")
+			hashes[background] = append(hashes[background], hash)
+			stateTestWrapper.persistAndClearInMemoryChanges(block)
+		}
+		testutil.AssertEquals(t, stateTestWrapper.get("chaincode1", "key9", true), []byte("value_2_9"))
+	}
+	testutil.AssertEquals(t, hashes[true], hashes[false])
+}
+
+func TestStateBackgroundHashingRollback(t *testing.T) {
+	stateTestWrapper, state := createFreshDBAndConstructState(t)
+	state.hasher = newStateHasher(state)
+
+	state.TxBegin("txUuid")
+	state.Set("chaincode1", "key1", []byte("value1"))
+	state.TxFinish("txUuid", true)
+	stateTestWrapper.persistAndClearInMemoryChanges(0)
+	committedHash, err := state.GetHash()
+	testutil.AssertNoError(t, err, "Error computing the state hash")
+
+	state.TxBegin("txUuid")
+	state.Set("chaincode1", "key1", []byte("value2"))
+	state.TxFinish("txUuid", true)
+	state.ClearInMemoryChanges(false)
+
+	hash, err := state.GetHash()
+	testutil.AssertNoError(t, err, "Error computing the state hash")
+	testutil.AssertEquals(t, hash, committedHash)
+	testutil.AssertEquals(t, stateTestWrapper.get("chaincode1", "key1", false), []byte("value1"))
+}
+
+// panickingState panics computing the hash, as when the db is closed under
+// the hashing
+type panickingState struct {
+	statemgmt.HashableState
+	prepared int
+}
+
+func (ps *panickingState) PrepareWorkingSet(stateDelta *statemgmt.StateDelta) error {
+	ps.prepared++
+	return nil
+}
+
+func (ps *panickingState) ComputeCryptoHash() ([]byte, error) {
+	panic("Could not open openchain db")
+}
+
+func TestStateBackgroundHashingPanic(t *testing.T) {
+	impl := &panickingState{}
+	state := &State{stateImpl: impl, stateDelta: statemgmt.NewStateDelta()}
+	state.hasher = newStateHasher(state)
+
+	state.changeStateDelta(func() {})
+	state.prepareWorkingSet()
+	if impl.prepared != 2 {
+		t.Fatalf("Expected the working set to be prepared again after the hashing failed, prepared %d times", impl.prepared)
+	}
+
+	// once stopped, the changes are only prepared by the caller
+	state.hasher.stop()
+	state.changeStateDelta(func() {})
+	testutil.AssertEquals(t, state.hasher.running, false)
+	state.prepareWorkingSet()
+	testutil.AssertEquals(t, impl.prepared, 3)
+}
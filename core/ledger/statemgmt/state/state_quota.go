@@ -0,0 +1,142 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package state
+
+import (
+	"fmt"
+)
+
+// usageChaincodeID is the reserved namespace in the world state that holds the
+// number of bytes (keys plus values) each chaincode has stored. As with the ttl
+// bookkeeping, keeping the usage in the world state makes it part of the state
+// hash, so that a write that exceeds the quota fails on every validator alike.
+const usageChaincodeID = "__usage"
+
+// QuotaExceededError is returned when a write would take the state usage of a
+// chaincode beyond its configured quota
+type QuotaExceededError struct {
+	ChaincodeID string
+	Usage       uint64
+	Quota       uint64
+}
+
+func (e *QuotaExceededError) Error() string {
+	return fmt.Sprintf("State quota exceeded for chaincode [%s]. Usage after write would be [%d] bytes, quota is [%d] bytes",
+		e.ChaincodeID, e.Usage, e.Quota)
+}
+
+// GetUsage returns the number of bytes of state that are held by the given chaincode.
+// If committed is true, the in-memory changes of the ongoing tx-batch are ignored
+func (state *State) GetUsage(chaincodeID string, committed bool) (uint64, error) {
+	usageBytes, err := state.Get(usageChaincodeID, chaincodeID, committed)
+	if err != nil || usageBytes == nil {
+		return 0, err
+	}
+	return decodeToUint64(usageBytes), nil
+}
+
+// GetQuota returns the configured state quota for the given chaincode. Zero means unlimited
+func GetQuota(chaincodeID string) uint64 {
+	initConfig()
+	if quota, ok := stateQuotas[chaincodeID]; ok {
+		return quota
+	}
+	return defaultStateQuota
+}
+
+// IsQuotaEnabled returns true if the state usage of chaincodes is being accounted for
+func IsQuotaEnabled() bool {
+	initConfig()
+	return stateQuotaEnabled
+}
+
+func isReservedChaincodeID(chaincodeID string) bool {
+	return chaincodeID == ttlChaincodeID || chaincodeID == usageChaincodeID
+}
+
+func stateEntrySize(key string, value []byte) uint64 {
+	if value == nil {
+		return 0
+	}
+	return uint64(len(key) + len(value))
+}
+
+// computeUsage returns the usage of the chaincode after replacing the current value of
+// key with newValue. If enforceQuota is true and the write grows the usage beyond the
+// quota, a QuotaExceededError is returned. Writes that do not grow the usage are always
+// allowed so that a chaincode over its quota can still free up space
+func (state *State) computeUsage(chaincodeID string, key string, newValue []byte, enforceQuota bool) (uint64, error) {
+	currentValue, err := state.Get(chaincodeID, key, false)
+	if err != nil {
+		return 0, err
+	}
+	usage, err := state.GetUsage(chaincodeID, false)
+	if err != nil {
+		return 0, err
+	}
+	oldSize := stateEntrySize(key, currentValue)
+	newSize := stateEntrySize(key, newValue)
+	// keys written before the accounting was in place are not part of the usage
+	if oldSize > usage {
+		usage = 0
+	} else {
+		usage -= oldSize
+	}
+	usage += newSize
+	if quota := GetQuota(chaincodeID); enforceQuota && quota > 0 && newSize > oldSize && usage > quota {
+		return 0, &QuotaExceededError{chaincodeID, usage, quota}
+	}
+	return usage, nil
+}
+
+// chargeUsage updates the usage of the chaincode within the ongoing tx for a write of
+// newValue (nil for a delete) to key
+func (state *State) chargeUsage(chaincodeID string, key string, newValue []byte) error {
+	if !stateQuotaEnabled || isReservedChaincodeID(chaincodeID) {
+		return nil
+	}
+	usage, err := state.computeUsage(chaincodeID, key, newValue, true)
+	if err != nil {
+		return err
+	}
+	return state.setUsage(chaincodeID, usage, state.Set, state.Delete)
+}
+
+// releaseUsageAtBlockBoundary updates the usage of the chaincode for the deletion
+// of key outside of a tx, such as when the key expires
+func (state *State) releaseUsageAtBlockBoundary(chaincodeID string, key string) error {
+	if !stateQuotaEnabled || isReservedChaincodeID(chaincodeID) {
+		return nil
+	}
+	usage, err := state.computeUsage(chaincodeID, key, nil, false)
+	if err != nil {
+		return err
+	}
+	return state.setUsage(chaincodeID, usage, state.setAtBlockBoundary, state.deleteAtBlockBoundary)
+}
+
+func (state *State) setUsage(chaincodeID string, usage uint64,
+	set func(string, string, []byte) error, del func(string, string) error) error {
+	current, err := state.GetUsage(chaincodeID, false)
+	if err != nil || current == usage {
+		return err
+	}
+	if usage == 0 {
+		return del(usageChaincodeID, chaincodeID)
+	}
+	return set(usageChaincodeID, chaincodeID, encodeUint64(usage))
+}
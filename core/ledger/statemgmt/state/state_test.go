@@ -169,3 +169,42 @@ func TestStateDeltaSizeSetting(t *testing.T) {
 		t.Fatalf("Error reading historyStateDeltaSize. Expected 500, but got %d", state.historyStateDeltaSize)
 	}
 }
+
+func TestStateQuota(t *testing.T) {
+	stateTestWrapper, state := createFreshDBAndConstructState(t)
+	stateQuotaEnabled = true
+	stateQuotas["chaincode1"] = 20
+	defer func() {
+		stateQuotaEnabled = false
+		delete(stateQuotas, "chaincode1")
+	}()
+
+	state.TxBegin("txUuid")
+	testutil.AssertNoError(t, state.Set("chaincode1", "key1", []byte("value1")), "Error setting state within quota")
+	testutil.AssertNoError(t, state.Set("chaincode2", "key1", []byte("value1_with_no_quota")), "Error setting state without quota")
+	state.TxFinish("txUuid", true)
+	stateTestWrapper.persistAndClearInMemoryChanges(0)
+
+	usage, _ := state.GetUsage("chaincode1", true)
+	testutil.AssertEquals(t, usage, uint64(10))
+
+	// the write would take the usage to 21 bytes
+	state.TxBegin("txUuid")
+	err := state.Set("chaincode1", "key2", []byte("value2_"))
+	if _, ok := err.(*QuotaExceededError); !ok {
+		t.Fatalf("Expected a QuotaExceededError, received [%v]", err)
+	}
+	testutil.AssertNil(t, stateTestWrapper.get("chaincode1", "key2", false))
+
+	// shrinking and deleting keys is always allowed
+	testutil.AssertNoError(t, state.Set("chaincode1", "key1", []byte("v")), "Error shrinking a value")
+	testutil.AssertNoError(t, state.Set("chaincode1", "key2", []byte("value2")), "Error setting state within quota")
+	usage, _ = state.GetUsage("chaincode1", false)
+	testutil.AssertEquals(t, usage, uint64(15))
+	testutil.AssertNoError(t, state.Delete("chaincode1", "key1"), "Error deleting state")
+	state.TxFinish("txUuid", true)
+	stateTestWrapper.persistAndClearInMemoryChanges(1)
+
+	usage, _ = state.GetUsage("chaincode1", true)
+	testutil.AssertEquals(t, usage, uint64(10))
+}
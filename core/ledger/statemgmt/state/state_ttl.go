@@ -0,0 +1,217 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package state
+
+import (
+	"fmt"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/hyperledger/fabric/core/ledger/statemgmt"
+)
+
+// ttlChaincodeID is the reserved namespace in the world state that holds the
+// expiry bookkeeping for keys written with a TTL. Keeping the bookkeeping in
+// the world state (rather than in a side column family) makes it part of the
+// state hash and of the state deltas, so that every validator - including the
+// ones catching up via state transfer - expires exactly the same keys at
+// exactly the same block.
+const ttlChaincodeID = "__ttl"
+
+// Two kinds of entries are kept in the ttl namespace
+// "b" + <blockNumber> -> list of composite keys that expire at that block
+// "k" + <compositeKey> -> the block at which the composite key expires
+const (
+	ttlBlockKeyPrefix = "b"
+	ttlEntryKeyPrefix = "k"
+)
+
+// SetWithTTL sets state to given value for chaincodeID and key, and schedules
+// the key for deletion when block number expiryBlock is constructed.
+// Does not immideatly writes to DB
+func (state *State) SetWithTTL(chaincodeID string, key string, value []byte, expiryBlock uint64) error {
+	if err := state.Set(chaincodeID, key, value); err != nil {
+		return err
+	}
+	compositeKey := string(statemgmt.ConstructCompositeKey(chaincodeID, key))
+	blockKey := encodeTTLBlockKey(expiryBlock)
+	expiringKeys, err := state.getExpiringKeys(blockKey)
+	if err != nil {
+		return err
+	}
+	expiringKeys = append(expiringKeys, compositeKey)
+	if err := state.Set(ttlChaincodeID, blockKey, marshalExpiringKeys(expiringKeys)); err != nil {
+		return err
+	}
+	return state.Set(ttlChaincodeID, ttlEntryKeyPrefix+compositeKey, encodeUint64(expiryBlock))
+}
+
+// GetExpiry returns the block number at which the given key expires. The
+// second return value is false if no TTL is associated with the key
+func (state *State) GetExpiry(chaincodeID string, key string, committed bool) (uint64, bool, error) {
+	entryKey := ttlEntryKeyPrefix + string(statemgmt.ConstructCompositeKey(chaincodeID, key))
+	expiryBytes, err := state.Get(ttlChaincodeID, entryKey, committed)
+	if err != nil {
+		return 0, false, err
+	}
+	if expiryBytes == nil {
+		return 0, false, nil
+	}
+	return decodeToUint64(expiryBytes), true, nil
+}
+
+// ExpireKeys deletes all the keys that are scheduled to expire at the given
+// block number. This must be invoked at the beginning of a tx-batch, before any
+// tx is executed, so that all the validators apply the expiry at the same
+// point in the sequence of state changes.
+func (state *State) ExpireKeys(blockNumber uint64) error {
+	if state.txInProgress() {
+		panic(fmt.Errorf("A tx [%s] is in progress. Keys can only be expired at block boundaries", state.currentTxUUID))
+	}
+	blockKey := encodeTTLBlockKey(blockNumber)
+	expiringKeys, err := state.getExpiringKeys(blockKey)
+	if err != nil {
+		return err
+	}
+	if len(expiringKeys) == 0 {
+		return nil
+	}
+	logger.Debugf("Expiring [%d] keys at block number [%d]", len(expiringKeys), blockNumber)
+	for _, compositeKey := range expiringKeys {
+		entryKey := ttlEntryKeyPrefix + compositeKey
+		expiryBytes, err := state.Get(ttlChaincodeID, entryKey, false)
+		if err != nil {
+			return err
+		}
+		// The key may have been overwritten or deleted after the ttl was set, in
+		// which case the entry is either gone or points to some other block
+		if expiryBytes == nil || decodeToUint64(expiryBytes) != blockNumber {
+			continue
+		}
+		chaincodeID, key := statemgmt.DecodeCompositeKey([]byte(compositeKey))
+		if err := state.releaseUsageAtBlockBoundary(chaincodeID, key); err != nil {
+			return err
+		}
+		if err := state.deleteAtBlockBoundary(chaincodeID, key); err != nil {
+			return err
+		}
+		if err := state.deleteAtBlockBoundary(ttlChaincodeID, entryKey); err != nil {
+			return err
+		}
+	}
+	return state.deleteAtBlockBoundary(ttlChaincodeID, blockKey)
+}
+
+// DeleteAtBlockBoundary deletes the given key outside of a tx, at the beginning
+// of a tx-batch or between its txs, like ExpireKeys does for the keys whose
+// TTL runs out
+func (state *State) DeleteAtBlockBoundary(chaincodeID string, key string) error {
+	if state.txInProgress() {
+		panic(fmt.Errorf("A tx [%s] is in progress. Keys can only be deleted at block boundaries", state.currentTxUUID))
+	}
+	if err := state.releaseUsageAtBlockBoundary(chaincodeID, key); err != nil {
+		return err
+	}
+	return state.deleteAtBlockBoundary(chaincodeID, key)
+}
+
+// clearTTL removes the expiry associated with a key, if any. This is invoked
+// whenever a key is written or deleted without a TTL
+func (state *State) clearTTL(chaincodeID string, key string) error {
+	if isReservedChaincodeID(chaincodeID) {
+		return nil
+	}
+	entryKey := ttlEntryKeyPrefix + string(statemgmt.ConstructCompositeKey(chaincodeID, key))
+	expiryBytes, err := state.Get(ttlChaincodeID, entryKey, false)
+	if err != nil || expiryBytes == nil {
+		return err
+	}
+	// The list of keys for the expiry block is left as is and the stale item
+	// is skipped by ExpireKeys when the entry does not match
+	return state.Delete(ttlChaincodeID, entryKey)
+}
+
+// setAtBlockBoundary and deleteAtBlockBoundary change the state outside of a tx. These
+// changes are applied directly to the state delta of the ongoing tx-batch
+func (state *State) setAtBlockBoundary(chaincodeID string, key string, value []byte) error {
+	previousValue, err := state.getPreviousValueAtBlockBoundary(chaincodeID, key)
+	if err != nil {
+		return err
+	}
+	state.changeStateDelta(func() { state.stateDelta.Set(chaincodeID, key, value, previousValue) })
+	return nil
+}
+
+func (state *State) deleteAtBlockBoundary(chaincodeID string, key string) error {
+	previousValue, err := state.getPreviousValueAtBlockBoundary(chaincodeID, key)
+	if err != nil {
+		return err
+	}
+	state.changeStateDelta(func() { state.stateDelta.Delete(chaincodeID, key, previousValue) })
+	return nil
+}
+
+func (state *State) getPreviousValueAtBlockBoundary(chaincodeID string, key string) ([]byte, error) {
+	if state.stateDelta.IsUpdatedValueSet(chaincodeID, key) {
+		// The previous value is retained by the existing entry in the delta
+		return nil, nil
+	}
+	return state.Get(chaincodeID, key, true)
+}
+
+func (state *State) getExpiringKeys(blockKey string) ([]string, error) {
+	listBytes, err := state.Get(ttlChaincodeID, blockKey, false)
+	if err != nil || listBytes == nil {
+		return nil, err
+	}
+	return unmarshalExpiringKeys(listBytes)
+}
+
+func encodeTTLBlockKey(blockNumber uint64) string {
+	return ttlBlockKeyPrefix + string(encodeUint64(blockNumber))
+}
+
+func marshalExpiringKeys(keys []string) []byte {
+	buffer := proto.NewBuffer([]byte{})
+	err := buffer.EncodeVarint(uint64(len(keys)))
+	if err != nil {
+		panic(fmt.Errorf("This error should not occur: %s", err))
+	}
+	for _, k := range keys {
+		err = buffer.EncodeStringBytes(k)
+		if err != nil {
+			panic(fmt.Errorf("This error should not occur: %s", err))
+		}
+	}
+	return buffer.Bytes()
+}
+
+func unmarshalExpiringKeys(b []byte) ([]string, error) {
+	buffer := proto.NewBuffer(b)
+	size, err := buffer.DecodeVarint()
+	if err != nil {
+		return nil, fmt.Errorf("Error unmarshaling expiring keys: %s", err)
+	}
+	keys := make([]string, 0, size)
+	for i := uint64(0); i < size; i++ {
+		k, err := buffer.DecodeStringBytes()
+		if err != nil {
+			return nil, fmt.Errorf("Error unmarshaling expiring keys: %s", err)
+		}
+		keys = append(keys, k)
+	}
+	return keys, nil
+}
@@ -121,3 +121,24 @@ func TestRangeScanIterator(t *testing.T) {
 	testutil.AssertEquals(t, results["key7"], []byte("value7"))
 	rangeScanItr.Close()
 }
+
+func TestRangeScanIteratorAfter(t *testing.T) {
+	testDBWrapper.CreateFreshDB(t)
+	stateTrieTestWrapper := newStateTrieTestWrapper(t)
+	stateTrie := stateTrieTestWrapper.stateTrie
+	stateDelta := statemgmt.NewStateDelta()
+	for _, key := range []string{"key1", "key2", "key2a", "key3", "key4"} {
+		stateDelta.Set("chaincodeID1", key, []byte("value"), nil)
+	}
+	stateTrie.PrepareWorkingSet(stateDelta)
+	stateTrieTestWrapper.PersistChangesAndResetInMemoryChanges()
+
+	rangeScanItr, _ := stateTrie.GetRangeScanIteratorAfter("chaincodeID1", "key1", "key3", "key2")
+	var keys []string
+	for rangeScanItr.Next() {
+		key, _ := rangeScanItr.GetKeyValue()
+		keys = append(keys, key)
+	}
+	rangeScanItr.Close()
+	testutil.AssertEquals(t, keys, []string{"key2a", "key3"})
+}
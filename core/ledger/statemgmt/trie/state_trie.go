@@ -188,3 +188,12 @@ func (stateTrie *StateTrie) GetStateSnapshotIterator(snapshot *gorocksdb.Snapsho
 func (stateTrie *StateTrie) GetRangeScanIterator(chaincodeID string, startKey string, endKey string) (statemgmt.RangeScanIterator, error) {
 	return newRangeScanIterator(chaincodeID, startKey, endKey)
 }
+
+// GetRangeScanIteratorAfter resumes the range scan after lastKey. The trie
+// returns keys in lexical order, so the scan resumes at the smallest greater key.
+func (stateTrie *StateTrie) GetRangeScanIteratorAfter(chaincodeID string, startKey string, endKey string, lastKey string) (statemgmt.RangeScanIterator, error) {
+	if next := lastKey + "\x00"; next > startKey {
+		startKey = next
+	}
+	return newRangeScanIterator(chaincodeID, startKey, endKey)
+}
@@ -0,0 +1,87 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ledger
+
+import (
+	"fmt"
+)
+
+// TagPosition is the position of a transaction in the chain
+type TagPosition struct {
+	BlockNumber uint64
+	TxIndex     uint64
+}
+
+// String returns the position as a cursor, <blockNumber>.<txIndex>
+func (p TagPosition) String() string {
+	return fmt.Sprintf("%d.%d", p.BlockNumber, p.TxIndex)
+}
+
+// ParseTagCursor parses a cursor returned by GetTransactionsByTag. The empty
+// cursor is the start of the chain.
+func ParseTagCursor(cursor string) (TagPosition, error) {
+	var p TagPosition
+	if cursor == "" {
+		return p, nil
+	}
+	var rest string
+	if n, _ := fmt.Sscanf(cursor, "%d.%d%s", &p.BlockNumber, &p.TxIndex, &rest); n != 2 {
+		return p, fmt.Errorf("Invalid cursor %q", cursor)
+	}
+	return p, nil
+}
+
+// TaggedTransaction is a transaction found by its tag
+type TaggedTransaction struct {
+	TagPosition
+	UUID string
+}
+
+// TaggedTransactions is a page of the transactions carrying a tag
+type TaggedTransactions struct {
+	// UUIDs of the transactions, in chain order
+	UUIDs []string
+	// Next is the cursor of the next page, empty on the last page
+	Next string
+}
+
+// GetTransactionsByTag returns at most limit transactions of the chain tagged
+// with key and value, starting at cursor, which is either empty or the Next
+// cursor of the previous page
+func (ledger *Ledger) GetTransactionsByTag(key string, value string, cursor string, limit int) (*TaggedTransactions, error) {
+	if limit <= 0 {
+		return nil, fmt.Errorf("Invalid limit %d", limit)
+	}
+	from, err := ParseTagCursor(cursor)
+	if err != nil {
+		return nil, err
+	}
+	// one more transaction tells whether there is a next page
+	txs, err := ledger.blockchain.indexer.fetchTransactionsByTag(key, value, from, limit+1)
+	if err != nil {
+		return nil, err
+	}
+	page := &TaggedTransactions{UUIDs: []string{}}
+	if len(txs) > limit {
+		page.Next = txs[limit].TagPosition.String()
+		txs = txs[:limit]
+	}
+	for _, tx := range txs {
+		page.UUIDs = append(page.UUIDs, tx.UUID)
+	}
+	return page, nil
+}
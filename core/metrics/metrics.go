@@ -0,0 +1,343 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+/*
+Package metrics keeps counters, gauges and histograms of the peer, and serves
+them in the Prometheus text exposition format, for operators to scrape and
+alert on. The metrics are registered once, usually in package variables, on
+the default registry, which the profiling server serves under /metrics.
+*/
+package metrics
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefaultBuckets are the upper bounds of the buckets of latency histograms,
+// in seconds
+var DefaultBuckets = []float64{.001, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// collector writes the samples of a metric
+type collector interface {
+	name() string
+	write(w io.Writer, labels []string)
+}
+
+// Registry holds metrics under unique names
+type Registry struct {
+	lock       sync.Mutex
+	collectors map[string]collector
+	labels     map[string]string // added to the samples of every metric
+}
+
+// NewRegistry returns an empty registry
+func NewRegistry() *Registry {
+	return &Registry{collectors: make(map[string]collector), labels: make(map[string]string)}
+}
+
+// DefaultRegistry is the registry of the metrics of the peer
+var DefaultRegistry = NewRegistry()
+
+func (r *Registry) register(c collector) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if _, ok := r.collectors[c.name()]; ok {
+		panic(fmt.Errorf("Metric %s is already registered", c.name()))
+	}
+	r.collectors[c.name()] = c
+}
+
+// SetLabel adds the label name, of the given value, to the samples of every
+// metric of r, such as the organization of the peer. An empty value removes
+// the label
+func (r *Registry) SetLabel(name, value string) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if value == "" {
+		delete(r.labels, name)
+		return
+	}
+	r.labels[name] = value
+}
+
+// WriteTo writes the samples of the metrics of r, sorted by name, in the
+// Prometheus text format
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	r.lock.Lock()
+	names := make([]string, 0, len(r.collectors))
+	for name := range r.collectors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	collectors := make([]collector, len(names))
+	for i, name := range names {
+		collectors[i] = r.collectors[name]
+	}
+	labels := make([]string, 0, len(r.labels))
+	for name, value := range r.labels {
+		labels = append(labels, formatLabel(name, value))
+	}
+	r.lock.Unlock()
+	sort.Strings(labels)
+
+	var buf bytes.Buffer
+	for _, c := range collectors {
+		c.write(&buf, labels)
+	}
+	return buf.WriteTo(w)
+}
+
+// Handler serves the metrics of the default registry
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		DefaultRegistry.WriteTo(w)
+	})
+}
+
+type desc struct {
+	metric string
+	help   string
+	kind   string
+}
+
+func (d *desc) name() string {
+	return d.metric
+}
+
+func (d *desc) writeHeader(w io.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", d.metric, strings.Replace(d.help, "\n", " ", -1), d.metric, d.kind)
+}
+
+func formatValue(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+func formatLabel(name, value string) string {
+	value = strings.Replace(value, `\`, `\\`, -1)
+	value = strings.Replace(value, "\n", `\n`, -1)
+	value = strings.Replace(value, `"`, `\"`, -1)
+	return fmt.Sprintf(`%s="%s"`, name, value)
+}
+
+// formatLabels returns the label set of a sample, with the labels of the
+// registry first, or nothing if the sample has no labels
+func formatLabels(labels []string, more ...string) string {
+	all := append(append([]string{}, labels...), more...)
+	if len(all) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(all, ",") + "}"
+}
+
+// Counter is a value which only goes up, such as a number of events
+type Counter struct {
+	lock  sync.Mutex
+	value float64
+}
+
+// Inc adds 1 to the counter
+func (c *Counter) Inc() {
+	c.Add(1)
+}
+
+// Add adds v, which must not be negative, to the counter
+func (c *Counter) Add(v float64) {
+	if v < 0 {
+		panic(fmt.Errorf("Counters cannot decrease, got %v", v))
+	}
+	c.lock.Lock()
+	c.value += v
+	c.lock.Unlock()
+}
+
+// Value returns the value of the counter
+func (c *Counter) Value() float64 {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.value
+}
+
+type counter struct {
+	desc
+	*Counter
+}
+
+func (c *counter) write(w io.Writer, labels []string) {
+	c.writeHeader(w)
+	fmt.Fprintf(w, "%s%s %s\n", c.metric, formatLabels(labels), formatValue(c.Value()))
+}
+
+// NewCounter registers a counter on the default registry
+func NewCounter(name, help string) *Counter {
+	c := &counter{desc{name, help, "counter"}, &Counter{}}
+	DefaultRegistry.register(c)
+	return c.Counter
+}
+
+// CounterVec is a set of counters told apart by the value of a label
+type CounterVec struct {
+	desc
+	label    string
+	lock     sync.Mutex
+	counters map[string]*Counter
+}
+
+// NewCounterVec registers on the default registry a set of counters told
+// apart by the value of label
+func NewCounterVec(name, help, label string) *CounterVec {
+	c := &CounterVec{
+		desc:     desc{name, help, "counter"},
+		label:    label,
+		counters: make(map[string]*Counter),
+	}
+	DefaultRegistry.register(c)
+	return c
+}
+
+// With returns the counter of the label value, creating it if needed
+func (c *CounterVec) With(value string) *Counter {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	counter, ok := c.counters[value]
+	if !ok {
+		counter = &Counter{}
+		c.counters[value] = counter
+	}
+	return counter
+}
+
+func (c *CounterVec) write(w io.Writer, labels []string) {
+	c.lock.Lock()
+	values := make([]string, 0, len(c.counters))
+	for value := range c.counters {
+		values = append(values, value)
+	}
+	c.lock.Unlock()
+	sort.Strings(values)
+
+	c.writeHeader(w)
+	for _, value := range values {
+		fmt.Fprintf(w, "%s%s %s\n", c.metric, formatLabels(labels, formatLabel(c.label, value)), formatValue(c.With(value).Value()))
+	}
+}
+
+// Gauge is a value which goes up and down, such as a size
+type Gauge struct {
+	desc
+	lock  sync.Mutex
+	value float64
+}
+
+// NewGauge registers a gauge on the default registry
+func NewGauge(name, help string) *Gauge {
+	g := &Gauge{desc: desc{name, help, "gauge"}}
+	DefaultRegistry.register(g)
+	return g
+}
+
+// Set sets the value of the gauge
+func (g *Gauge) Set(v float64) {
+	g.lock.Lock()
+	g.value = v
+	g.lock.Unlock()
+}
+
+// Value returns the value of the gauge
+func (g *Gauge) Value() float64 {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	return g.value
+}
+
+func (g *Gauge) write(w io.Writer, labels []string) {
+	g.writeHeader(w)
+	fmt.Fprintf(w, "%s%s %s\n", g.metric, formatLabels(labels), formatValue(g.Value()))
+}
+
+// Histogram counts observations, such as latencies, in buckets of
+// increasing upper bounds
+type Histogram struct {
+	desc
+	bounds []float64
+	lock   sync.Mutex
+	counts []uint64 // per bucket, not cumulative, the last one for +Inf
+	count  uint64
+	sum    float64
+}
+
+// NewHistogram registers on the default registry a histogram of the given
+// bucket upper bounds, which must be increasing
+func NewHistogram(name, help string, buckets []float64) *Histogram {
+	if !sort.Float64sAreSorted(buckets) {
+		panic(fmt.Errorf("Buckets of histogram %s are not increasing: %v", name, buckets))
+	}
+	h := &Histogram{
+		desc:   desc{name, help, "histogram"},
+		bounds: buckets,
+		counts: make([]uint64, len(buckets)+1),
+	}
+	DefaultRegistry.register(h)
+	return h
+}
+
+// Observe counts v in the bucket it falls in
+func (h *Histogram) Observe(v float64) {
+	i := sort.SearchFloat64s(h.bounds, v)
+	h.lock.Lock()
+	h.counts[i]++
+	h.count++
+	h.sum += v
+	h.lock.Unlock()
+}
+
+// Count returns the number of observations
+func (h *Histogram) Count() uint64 {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	return h.count
+}
+
+func (h *Histogram) write(w io.Writer, labels []string) {
+	h.lock.Lock()
+	counts := append([]uint64{}, h.counts...)
+	count, sum := h.count, h.sum
+	h.lock.Unlock()
+
+	h.writeHeader(w)
+	var cumulative uint64
+	for i, bound := range h.bounds {
+		cumulative += counts[i]
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.metric, formatLabels(labels, formatLabel("le", formatValue(bound))), cumulative)
+	}
+	fmt.Fprintf(w, "%s_bucket%s %d\n", h.metric, formatLabels(labels, formatLabel("le", "+Inf")), count)
+	fmt.Fprintf(w, "%s_sum%s %s\n", h.metric, formatLabels(labels), formatValue(sum))
+	fmt.Fprintf(w, "%s_count%s %d\n", h.metric, formatLabels(labels), count)
+}
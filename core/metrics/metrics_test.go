@@ -0,0 +1,107 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metrics
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestExposition(t *testing.T) {
+	c := NewCounter("test_events_total", "Events.")
+	c.Inc()
+	c.Add(2)
+	v := NewCounterVec("test_messages_total", "Messages by type.", "type")
+	v.With("prepare").Inc()
+	v.With(`a"b`).Inc()
+	g := NewGauge("test_size", "Size.")
+	g.Set(7)
+	h := NewHistogram("test_latency_seconds", "Latency.", []float64{.1, 1})
+	h.Observe(.05)
+	h.Observe(.1)
+	h.Observe(5)
+
+	rec := httptest.NewRecorder()
+	Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	expected := `# HELP test_events_total Events.
+# TYPE test_events_total counter
+test_events_total 3
+# HELP test_latency_seconds Latency.
+# TYPE test_latency_seconds histogram
+test_latency_seconds_bucket{le="0.1"} 2
+test_latency_seconds_bucket{le="1"} 2
+test_latency_seconds_bucket{le="+Inf"} 3
+test_latency_seconds_sum 5.15
+test_latency_seconds_count 3
+# HELP test_messages_total Messages by type.
+# TYPE test_messages_total counter
+test_messages_total{type="a\"b"} 1
+test_messages_total{type="prepare"} 1
+# HELP test_size Size.
+# TYPE test_size gauge
+test_size 7
+`
+	if body := rec.Body.String(); body != expected {
+		t.Fatalf("Expected\n%s\ngot\n%s", expected, body)
+	}
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain") {
+		t.Fatalf("Expected the text format, got %s", ct)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Fatalf("Expected a second registration under the same name to panic")
+		}
+	}()
+	NewGauge("test_size", "Size.")
+}
+
+func TestRegistryLabels(t *testing.T) {
+	r := NewRegistry()
+	c := &counter{desc{"test_events_total", "Events.", "counter"}, &Counter{}}
+	c.Inc()
+	r.register(c)
+	h := &Histogram{desc: desc{"test_latency_seconds", "Latency.", "histogram"}, bounds: []float64{1}, counts: make([]uint64, 2)}
+	h.Observe(.5)
+	r.register(h)
+	r.SetLabel("org", "org1")
+
+	var buf bytes.Buffer
+	r.WriteTo(&buf)
+	expected := `# HELP test_events_total Events.
+# TYPE test_events_total counter
+test_events_total{org="org1"} 1
+# HELP test_latency_seconds Latency.
+# TYPE test_latency_seconds histogram
+test_latency_seconds_bucket{org="org1",le="1"} 1
+test_latency_seconds_bucket{org="org1",le="+Inf"} 1
+test_latency_seconds_sum{org="org1"} 0.5
+test_latency_seconds_count{org="org1"} 1
+`
+	if buf.String() != expected {
+		t.Fatalf("Expected\n%s\ngot\n%s", expected, buf.String())
+	}
+
+	r.SetLabel("org", "")
+	buf.Reset()
+	r.WriteTo(&buf)
+	if !strings.Contains(buf.String(), "\ntest_events_total 1\n") {
+		t.Fatalf("Expected the label to be removed, got\n%s", buf.String())
+	}
+}
@@ -0,0 +1,402 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package peer
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/spf13/viper"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/metadata"
+
+	pb "github.com/hyperledger/fabric/protos"
+)
+
+// Validators exchange consensus messages over the Chat stream they share with
+// the block sync and discovery traffic, so a consensus message may wait
+// behind a batch of blocks being transferred. With consensus channels, each
+// pair of validators opens a second connection, dedicated to consensus
+// messages, with its own send buffer and keepalive. The validator with the
+// lower ID opens it once the Chat between them is established, and reopens it
+// should it fail, for as long as the Chat lasts. The channel is a Chat stream
+// flagged in its metadata, which starts with a signed hello from each side,
+// and then only carries consensus messages and keepalives. Consensus messages
+// go through the main Chat whenever there is no channel.
+
+const (
+	consensusChannelKey   = "channel"
+	consensusChannelValue = "consensus"
+)
+
+// consensusChannelConfig holds the settings of the consensus channels
+type consensusChannelConfig struct {
+	bufferSize int           // messages queued for sending before they are rejected
+	keepalive  time.Duration // how often a keepalive is sent
+	timeout    time.Duration // how long the channel may stay silent before it is closed
+}
+
+// consensusChannel is a connection to a validator dedicated to consensus
+// messages
+type consensusChannel struct {
+	to     *pb.PeerEndpoint
+	stream ChatStream
+	queue  chan *pb.Message
+	done   chan struct{}
+	once   sync.Once
+
+	lock     sync.Mutex
+	lastRecv time.Time
+}
+
+// consensusChannels holds the consensus channels of a validator by the ID
+// of the validator at their other end
+type consensusChannels struct {
+	sync.RWMutex
+	config  consensusChannelConfig
+	m       map[pb.PeerID]*consensusChannel
+	dialing map[pb.PeerID]bool
+}
+
+func newConsensusChannels() *consensusChannels {
+	if !ValidatorEnabled() || !viper.GetBool("peer.validator.consensus.channel.enabled") {
+		return nil
+	}
+	config := consensusChannelConfig{
+		bufferSize: viper.GetInt("peer.validator.consensus.channel.buffersize"),
+		keepalive:  viper.GetDuration("peer.validator.consensus.channel.keepalive.interval"),
+		timeout:    viper.GetDuration("peer.validator.consensus.channel.keepalive.timeout"),
+	}
+	if config.bufferSize <= 0 || config.keepalive <= 0 || config.timeout <= config.keepalive {
+		panic(fmt.Errorf("Invalid consensus channel settings, buffersize and keepalive.interval must be positive, keepalive.timeout greater than keepalive.interval"))
+	}
+	return &consensusChannels{
+		config:  config,
+		m:       make(map[pb.PeerID]*consensusChannel),
+		dialing: make(map[pb.PeerID]bool),
+	}
+}
+
+func (cc *consensusChannels) get(id *pb.PeerID) *consensusChannel {
+	cc.RLock()
+	defer cc.RUnlock()
+	return cc.m[*id]
+}
+
+// put registers a channel, replacing any previous one to the same validator
+func (cc *consensusChannels) put(c *consensusChannel) {
+	cc.Lock()
+	old := cc.m[*c.to.ID]
+	cc.m[*c.to.ID] = c
+	cc.Unlock()
+	if old != nil {
+		old.close()
+	}
+}
+
+// remove deregisters a channel, unless it was replaced already
+func (cc *consensusChannels) remove(c *consensusChannel) {
+	cc.Lock()
+	defer cc.Unlock()
+	if cc.m[*c.to.ID] == c {
+		delete(cc.m, *c.to.ID)
+	}
+}
+
+// closeTo closes the channel to a validator, if there is one
+func (cc *consensusChannels) closeTo(id *pb.PeerID) {
+	if c := cc.get(id); c != nil {
+		c.close()
+	}
+}
+
+// startDialing returns whether we should dial a validator, that is, when we
+// are not dialing it already
+func (cc *consensusChannels) startDialing(id *pb.PeerID) bool {
+	cc.Lock()
+	defer cc.Unlock()
+	if cc.dialing[*id] {
+		return false
+	}
+	cc.dialing[*id] = true
+	return true
+}
+
+func (cc *consensusChannels) stopDialing(id *pb.PeerID) {
+	cc.Lock()
+	defer cc.Unlock()
+	delete(cc.dialing, *id)
+}
+
+func newConsensusChannel(to *pb.PeerEndpoint, stream ChatStream, config consensusChannelConfig) *consensusChannel {
+	return &consensusChannel{
+		to:       to,
+		stream:   stream,
+		queue:    make(chan *pb.Message, config.bufferSize),
+		done:     make(chan struct{}),
+		lastRecv: time.Now(),
+	}
+}
+
+// send queues a message, and fails rather than wait when the queue is full
+func (c *consensusChannel) send(msg *pb.Message) error {
+	select {
+	case <-c.done:
+		return fmt.Errorf("Consensus channel to %s closed", c.to.ID)
+	default:
+	}
+	select {
+	case c.queue <- msg:
+		return nil
+	default:
+		return fmt.Errorf("Consensus channel to %s full, rejecting", c.to.ID)
+	}
+}
+
+func (c *consensusChannel) close() {
+	c.once.Do(func() { close(c.done) })
+}
+
+func (c *consensusChannel) touch() {
+	c.lock.Lock()
+	c.lastRecv = time.Now()
+	c.lock.Unlock()
+}
+
+func (c *consensusChannel) silentFor() time.Duration {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return time.Since(c.lastRecv)
+}
+
+// sendLoop sends the queued messages, and keepalives every interval, until
+// the channel is closed, or stays silent for longer than timeout
+func (c *consensusChannel) sendLoop(keepalive time.Duration, timeout time.Duration) {
+	defer c.close()
+	ticker := time.NewTicker(keepalive)
+	defer ticker.Stop()
+	for {
+		select {
+		case msg := <-c.queue:
+			if err := c.stream.Send(msg); err != nil {
+				peerLogger.Warningf("Error sending through consensus channel to %s: %s", c.to.ID, err)
+				return
+			}
+		case <-ticker.C:
+			if silent := c.silentFor(); silent > timeout {
+				peerLogger.Warningf("Consensus channel to %s silent for %v, closing it", c.to.ID, silent)
+				return
+			}
+			if err := c.stream.Send(&pb.Message{Type: pb.Message_UNDEFINED}); err != nil {
+				peerLogger.Warningf("Error sending keepalive through consensus channel to %s: %s", c.to.ID, err)
+				return
+			}
+		case <-c.done:
+			return
+		}
+	}
+}
+
+// recvLoop passes the consensus messages received to deliver, until the
+// channel is closed
+func (c *consensusChannel) recvLoop(deliver func(msg *pb.Message)) {
+	defer c.close()
+	for {
+		msg, err := c.stream.Recv()
+		if err != nil {
+			select {
+			case <-c.done:
+			default:
+				peerLogger.Warningf("Error receiving from consensus channel to %s: %s", c.to.ID, err)
+			}
+			return
+		}
+		c.touch()
+		if msg.Type == pb.Message_CONSENSUS {
+			deliver(msg)
+		}
+	}
+}
+
+// isConsensusChannel returns whether the Chat of ctx is a consensus channel
+func isConsensusChannel(ctx context.Context) bool {
+	md, ok := metadata.FromContext(ctx)
+	if !ok {
+		return false
+	}
+	for _, v := range md[consensusChannelKey] {
+		if v == consensusChannelValue {
+			return true
+		}
+	}
+	return false
+}
+
+// sendTo sends a message to the peer of msgHandler, through the consensus
+// channel to it for consensus messages, if there is one
+func (p *PeerImpl) sendTo(msgHandler MessageHandler, msg *pb.Message) error {
+	if p.consensusChannels != nil && msg.Type == pb.Message_CONSENSUS {
+		if to, err := msgHandler.To(); err == nil {
+			if c := p.consensusChannels.get(to.ID); c != nil {
+				return c.send(msg)
+			}
+		}
+	}
+	return msgHandler.SendMessage(msg)
+}
+
+// deliverConsensus hands a message received through the consensus channel
+// to the handler of the Chat with its sender
+func (p *PeerImpl) deliverConsensus(from *pb.PeerID) func(msg *pb.Message) {
+	return func(msg *pb.Message) {
+		msgHandler, err := p.getMessageHandler(from)
+		if err != nil {
+			peerLogger.Warningf("Dropping consensus message from %s, no Chat with it: %s", from, err)
+			return
+		}
+		if err := msgHandler.HandleMessage(msg); err != nil {
+			peerLogger.Errorf("Error handling consensus message from %s: %s", from, err)
+		}
+	}
+}
+
+// maybeDialConsensusChannel opens the consensus channel to the validator at
+// the other end of a Chat, if we are the one to open it
+func (p *PeerImpl) maybeDialConsensusChannel(to pb.PeerEndpoint) {
+	if p.consensusChannels == nil || to.Type != pb.PeerEndpoint_VALIDATOR {
+		return
+	}
+	self, err := GetPeerEndpoint()
+	if err != nil || self.ID.Name >= to.ID.Name {
+		return
+	}
+	if p.consensusChannels.startDialing(to.ID) {
+		go p.dialConsensusChannel(&to)
+	}
+}
+
+// dialConsensusChannel keeps a consensus channel open to a validator, for as
+// long as we have a Chat with it
+func (p *PeerImpl) dialConsensusChannel(to *pb.PeerEndpoint) {
+	defer p.consensusChannels.stopDialing(to.ID)
+	for {
+		if _, err := p.getMessageHandler(to.ID); err != nil {
+			return
+		}
+		if err := p.openConsensusChannel(to); err != nil {
+			peerLogger.Warningf("Consensus channel to %s at %s failed: %s", to.ID, to.Address, err)
+		}
+		time.Sleep(1 * time.Second)
+	}
+}
+
+// openConsensusChannel opens a consensus channel to a validator, and returns
+// once it is closed
+func (p *PeerImpl) openConsensusChannel(to *pb.PeerEndpoint) error {
+	conn, err := NewPeerClientConnectionWithAddress(to.Address)
+	if err != nil {
+		return fmt.Errorf("Error creating connection: %s", err)
+	}
+	defer conn.Close()
+	ctx := metadata.NewContext(context.Background(), metadata.Pairs(consensusChannelKey, consensusChannelValue))
+	stream, err := pb.NewPeerClient(conn).Chat(ctx)
+	if err != nil {
+		return fmt.Errorf("Error establishing chat: %s", err)
+	}
+	defer stream.CloseSend()
+
+	if err := p.sendConsensusChannelHello(stream); err != nil {
+		return err
+	}
+	hello, err := p.recvConsensusChannelHello(stream)
+	if err != nil {
+		return err
+	}
+	if !proto.Equal(hello.PeerEndpoint.ID, to.ID) {
+		return fmt.Errorf("Expected hello from %s, got %s", to.ID, hello.PeerEndpoint.ID)
+	}
+	p.runConsensusChannel(newConsensusChannel(to, stream, p.consensusChannels.config))
+	return nil
+}
+
+// acceptConsensusChannel serves a consensus channel opened by a validator we
+// have a Chat with, and returns once it is closed
+func (p *PeerImpl) acceptConsensusChannel(stream ChatStream) error {
+	if p.consensusChannels == nil {
+		return fmt.Errorf("Consensus channels are not enabled")
+	}
+	hello, err := p.recvConsensusChannelHello(stream)
+	if err != nil {
+		return err
+	}
+	if _, err := p.getMessageHandler(hello.PeerEndpoint.ID); err != nil {
+		return fmt.Errorf("Refusing consensus channel from %s: %s", hello.PeerEndpoint.ID, err)
+	}
+	if err := p.sendConsensusChannelHello(stream); err != nil {
+		return err
+	}
+	p.runConsensusChannel(newConsensusChannel(hello.PeerEndpoint, stream, p.consensusChannels.config))
+	return nil
+}
+
+func (p *PeerImpl) runConsensusChannel(c *consensusChannel) {
+	peerLogger.Infof("Consensus channel to %s established", c.to.ID)
+	p.consensusChannels.put(c)
+	go c.sendLoop(p.consensusChannels.config.keepalive, p.consensusChannels.config.timeout)
+	go c.recvLoop(p.deliverConsensus(c.to.ID))
+	<-c.done
+	p.consensusChannels.remove(c)
+	peerLogger.Infof("Consensus channel to %s closed", c.to.ID)
+}
+
+func (p *PeerImpl) sendConsensusChannelHello(stream ChatStream) error {
+	hello, err := p.NewOpenchainDiscoveryHello()
+	if err != nil {
+		return fmt.Errorf("Error getting new HelloMessage: %s", err)
+	}
+	if err := stream.Send(hello); err != nil {
+		return fmt.Errorf("Error sending %s: %s", pb.Message_DISC_HELLO, err)
+	}
+	return nil
+}
+
+// recvConsensusChannelHello receives the hello opening a consensus channel,
+// and checks it comes from a validator
+func (p *PeerImpl) recvConsensusChannelHello(stream ChatStream) (*pb.HelloMessage, error) {
+	msg, err := stream.Recv()
+	if err != nil {
+		return nil, fmt.Errorf("Error receiving %s: %s", pb.Message_DISC_HELLO, err)
+	}
+	if msg.Type != pb.Message_DISC_HELLO {
+		return nil, fmt.Errorf("Expected %s, got %s", pb.Message_DISC_HELLO, msg.Type)
+	}
+	hello := &pb.HelloMessage{}
+	if err := proto.Unmarshal(msg.Payload, hello); err != nil {
+		return nil, fmt.Errorf("Error unmarshalling HelloMessage: %s", err)
+	}
+	if hello.PeerEndpoint == nil || hello.PeerEndpoint.ID == nil || hello.PeerEndpoint.Type != pb.PeerEndpoint_VALIDATOR {
+		return nil, fmt.Errorf("Consensus channel hello not from a validator: %s", hello.PeerEndpoint)
+	}
+	if SecurityEnabled() {
+		if err := p.secHelper.Verify(hello.PeerEndpoint.PkiID, msg.Signature, msg.Payload); err != nil {
+			return nil, fmt.Errorf("Error Verifying signature for received HelloMessage: %s", err)
+		}
+	}
+	return hello, nil
+}
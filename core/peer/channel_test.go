@@ -0,0 +1,134 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package peer
+
+import (
+	"io"
+	"testing"
+	"time"
+
+	pb "github.com/hyperledger/fabric/protos"
+)
+
+// mockChatStream records the messages sent, and receives those queued in in
+type mockChatStream struct {
+	sent chan *pb.Message
+	in   chan *pb.Message
+}
+
+func newMockChatStream() *mockChatStream {
+	return &mockChatStream{sent: make(chan *pb.Message, 10), in: make(chan *pb.Message, 10)}
+}
+
+func (s *mockChatStream) Send(msg *pb.Message) error {
+	s.sent <- msg
+	return nil
+}
+
+func (s *mockChatStream) Recv() (*pb.Message, error) {
+	msg, ok := <-s.in
+	if !ok {
+		return nil, io.EOF
+	}
+	return msg, nil
+}
+
+// mockMessageHandler records the messages sent through the main Chat
+type mockMessageHandler struct {
+	MessageHandler
+	to   pb.PeerEndpoint
+	sent []*pb.Message
+}
+
+func (h *mockMessageHandler) To() (pb.PeerEndpoint, error) {
+	return h.to, nil
+}
+
+func (h *mockMessageHandler) SendMessage(msg *pb.Message) error {
+	h.sent = append(h.sent, msg)
+	return nil
+}
+
+func TestConsensusChannelRouting(t *testing.T) {
+	to := pb.PeerEndpoint{ID: &pb.PeerID{Name: "vp1"}, Type: pb.PeerEndpoint_VALIDATOR}
+	handler := &mockMessageHandler{to: to}
+	p := &PeerImpl{
+		handlerMap:        &handlerMap{m: map[pb.PeerID]MessageHandler{*to.ID: handler}},
+		consensusChannels: &consensusChannels{config: consensusChannelConfig{bufferSize: 1}, m: make(map[pb.PeerID]*consensusChannel)},
+	}
+
+	// Without channel, consensus messages go through the Chat
+	if err := p.Unicast(&pb.Message{Type: pb.Message_CONSENSUS}, to.ID); err != nil {
+		t.Fatalf("Unicast failed: %s", err)
+	}
+	if len(handler.sent) != 1 {
+		t.Fatalf("Expected the consensus message to go through the Chat without channel")
+	}
+
+	c := newConsensusChannel(&to, newMockChatStream(), p.consensusChannels.config)
+	p.consensusChannels.put(c)
+	if err := p.Unicast(&pb.Message{Type: pb.Message_CONSENSUS}, to.ID); err != nil {
+		t.Fatalf("Unicast failed: %s", err)
+	}
+	if err := p.Unicast(&pb.Message{Type: pb.Message_SYNC_GET_BLOCKS}, to.ID); err != nil {
+		t.Fatalf("Unicast failed: %s", err)
+	}
+	if len(handler.sent) != 2 || handler.sent[1].Type != pb.Message_SYNC_GET_BLOCKS {
+		t.Fatalf("Expected only the sync message to go through the Chat, got %v", handler.sent)
+	}
+	if len(c.queue) != 1 {
+		t.Fatalf("Expected the consensus message to be queued on the channel")
+	}
+
+	// The buffer of the channel is full
+	if err := p.Unicast(&pb.Message{Type: pb.Message_CONSENSUS}, to.ID); err == nil {
+		t.Errorf("Expected a consensus message to be rejected by a full channel")
+	}
+
+	c.close()
+	p.consensusChannels.remove(c)
+	if p.consensusChannels.get(to.ID) != nil {
+		t.Errorf("Expected the closed channel to be removed")
+	}
+}
+
+func TestConsensusChannelKeepalive(t *testing.T) {
+	to := &pb.PeerEndpoint{ID: &pb.PeerID{Name: "vp1"}}
+	stream := newMockChatStream()
+	c := newConsensusChannel(to, stream, consensusChannelConfig{bufferSize: 10})
+
+	var delivered []*pb.Message
+	stream.in <- &pb.Message{Type: pb.Message_UNDEFINED}
+	stream.in <- &pb.Message{Type: pb.Message_CONSENSUS}
+	close(stream.in)
+	c.recvLoop(func(msg *pb.Message) { delivered = append(delivered, msg) })
+	if len(delivered) != 1 {
+		t.Fatalf("Expected only the consensus message to be delivered, got %v", delivered)
+	}
+
+	// A silent channel is closed after sending keepalives
+	c = newConsensusChannel(to, stream, consensusChannelConfig{bufferSize: 10})
+	go c.sendLoop(10*time.Millisecond, 35*time.Millisecond)
+	select {
+	case <-c.done:
+	case <-time.After(time.Second):
+		t.Fatalf("Expected the silent channel to be closed")
+	}
+	if len(stream.sent) == 0 || (<-stream.sent).Type != pb.Message_UNDEFINED {
+		t.Errorf("Expected keepalives to be sent")
+	}
+}
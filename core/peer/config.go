@@ -53,6 +53,7 @@ var syncStateSnapshotChannelSize int
 var syncStateDeltasChannelSize int
 var syncBlocksChannelSize int
 var validatorEnabled bool
+var replicaEnabled bool
 
 // Note: There is some kind of circular import issue that prevents us from
 // importing the "core" package into the "peer" package. The
@@ -95,7 +96,8 @@ func CacheConfiguration() (err error) {
 		} else {
 			peerType = pb.PeerEndpoint_NON_VALIDATOR
 		}
-		return &pb.PeerEndpoint{ID: &pb.PeerID{Name: viper.GetString("peer.id")}, Address: peerAddress, Type: peerType}, nil
+		return &pb.PeerEndpoint{ID: &pb.PeerID{Name: viper.GetString("peer.id")}, Address: peerAddress, Type: peerType,
+			Organization: viper.GetString("peer.organization")}, nil
 	}
 
 	localAddress, localAddressError = getLocalAddress()
@@ -105,6 +107,7 @@ func CacheConfiguration() (err error) {
 	syncStateDeltasChannelSize = viper.GetInt("peer.sync.state.deltas.channelSize")
 	syncBlocksChannelSize = viper.GetInt("peer.sync.blocks.channelSize")
 	validatorEnabled = viper.GetBool("peer.validator.enabled")
+	replicaEnabled = !validatorEnabled && viper.GetBool("peer.replica.enabled")
 
 	securityEnabled = viper.GetBool("security.enabled")
 
@@ -174,6 +177,15 @@ func ValidatorEnabled() bool {
 	return validatorEnabled
 }
 
+// ReplicaEnabled returns the peer.replica.enabled property. It is always false
+// for validating peers
+func ReplicaEnabled() bool {
+	if !configurationCached {
+		cacheConfiguration()
+	}
+	return replicaEnabled
+}
+
 func SecurityEnabled() bool {
 	if !configurationCached {
 		cacheConfiguration()
@@ -0,0 +1,187 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package peer
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/spf13/viper"
+
+	"github.com/hyperledger/fabric/core/util"
+	pb "github.com/hyperledger/fabric/protos"
+)
+
+// An operator responding to a compromised peer may deny-list it on their own
+// peer, through the Admin service, without waiting for the network to be
+// reconfigured. A denied peer is cut off right away: its handler is
+// deregistered, so that nothing is sent to it and its consensus channel is
+// closed, and its Chat is closed on the next message it sends, which is
+// dropped. It cannot register a handler again until it is allowed. The
+// deny-list is kept under peer.fileSystemPath, so that it survives restarts.
+
+// denyList holds the peers denied by the operator, by peer.id
+type denyList struct {
+	sync.RWMutex
+	path  string
+	peers map[string]*pb.DeniedPeer
+}
+
+// denyListPath returns the file of the deny-list,
+// <peer.fileSystemPath>/denylist
+func denyListPath() string {
+	return filepath.Join(viper.GetString("peer.fileSystemPath"), "denylist")
+}
+
+// loadDenyList reads the deny-list kept in path, if any
+func loadDenyList(path string) (*denyList, error) {
+	d := &denyList{path: path, peers: make(map[string]*pb.DeniedPeer)}
+	raw, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return d, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Error reading deny-list %s: %s", path, err)
+	}
+	denied := &pb.DeniedPeers{}
+	if err := proto.Unmarshal(raw, denied); err != nil {
+		return nil, fmt.Errorf("Error unmarshalling deny-list %s: %s", path, err)
+	}
+	for _, peer := range denied.Peers {
+		d.peers[peer.Name] = peer
+	}
+	return d, nil
+}
+
+// denied returns whether a peer is denied. A nil deny-list denies no peer.
+func (d *denyList) denied(name string) bool {
+	if d == nil {
+		return false
+	}
+	d.RLock()
+	defer d.RUnlock()
+	_, ok := d.peers[name]
+	return ok
+}
+
+// deny adds a peer to the deny-list, or updates its reason if it is already
+// denied
+func (d *denyList) deny(name, reason string) error {
+	if name == "" {
+		return fmt.Errorf("Expected the peer.id of the peer to deny")
+	}
+	d.Lock()
+	defer d.Unlock()
+	d.peers[name] = &pb.DeniedPeer{Name: name, Reason: reason, Since: util.CreateUtcTimestamp()}
+	return d.save()
+}
+
+// allow removes a peer from the deny-list
+func (d *denyList) allow(name string) error {
+	d.Lock()
+	defer d.Unlock()
+	if _, ok := d.peers[name]; !ok {
+		return fmt.Errorf("Peer %s is not denied", name)
+	}
+	delete(d.peers, name)
+	return d.save()
+}
+
+// list returns the peers denied, sorted by name
+func (d *denyList) list() *pb.DeniedPeers {
+	d.RLock()
+	defer d.RUnlock()
+	denied := &pb.DeniedPeers{}
+	for _, peer := range d.peers {
+		denied.Peers = append(denied.Peers, peer)
+	}
+	sort.Sort(deniedPeersByName(denied.Peers))
+	return denied
+}
+
+// save writes the deny-list to its file, which it replaces at once, so that
+// a crash leaves either the former or the new deny-list. Called with the lock
+// held.
+func (d *denyList) save() error {
+	denied := &pb.DeniedPeers{}
+	for _, peer := range d.peers {
+		denied.Peers = append(denied.Peers, peer)
+	}
+	raw, err := proto.Marshal(denied)
+	if err != nil {
+		return fmt.Errorf("Error marshalling deny-list: %s", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(d.path), 0755); err != nil {
+		return fmt.Errorf("Error creating the directory of deny-list %s: %s", d.path, err)
+	}
+	tmp := d.path + ".tmp"
+	if err := ioutil.WriteFile(tmp, raw, 0644); err != nil {
+		return fmt.Errorf("Error writing deny-list %s: %s", d.path, err)
+	}
+	if err := os.Rename(tmp, d.path); err != nil {
+		return fmt.Errorf("Error writing deny-list %s: %s", d.path, err)
+	}
+	return nil
+}
+
+type deniedPeersByName []*pb.DeniedPeer
+
+func (s deniedPeersByName) Len() int           { return len(s) }
+func (s deniedPeersByName) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s deniedPeersByName) Less(i, j int) bool { return s[i].Name < s[j].Name }
+
+// deniedHandler returns whether the peer at the other end of a handler is
+// denied, once it said hello
+func (p *PeerImpl) deniedHandler(handler MessageHandler) bool {
+	to, err := handler.To()
+	return err == nil && to.ID != nil && p.denyList.denied(to.ID.Name)
+}
+
+// DenyPeer cuts the peer named name off, with the operator's reason, until
+// it is allowed again, and returns the peers denied
+func (p *PeerImpl) DenyPeer(name, reason string) (*pb.DeniedPeers, error) {
+	if err := p.denyList.deny(name, reason); err != nil {
+		return nil, err
+	}
+	peerLogger.Warningf("Denied peer %s: %s", name, reason)
+	if handler, err := p.getMessageHandler(&pb.PeerID{Name: name}); err == nil {
+		if err := p.DeregisterHandler(handler); err != nil {
+			peerLogger.Warningf("Error deregistering the handler of denied peer %s: %s", name, err)
+		}
+	}
+	return p.denyList.list(), nil
+}
+
+// AllowPeer lets a denied peer connect again, and returns the peers still
+// denied
+func (p *PeerImpl) AllowPeer(name string) (*pb.DeniedPeers, error) {
+	if err := p.denyList.allow(name); err != nil {
+		return nil, err
+	}
+	peerLogger.Warningf("Allowed peer %s again", name)
+	return p.denyList.list(), nil
+}
+
+// DeniedPeers returns the peers denied
+func (p *PeerImpl) DeniedPeers() *pb.DeniedPeers {
+	return p.denyList.list()
+}
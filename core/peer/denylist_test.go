@@ -0,0 +1,81 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package peer
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	pb "github.com/hyperledger/fabric/protos"
+)
+
+func TestDenyPeer(t *testing.T) {
+	dir, err := ioutil.TempDir("", "denylist")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "denylist")
+
+	to := pb.PeerEndpoint{ID: &pb.PeerID{Name: "vp1"}, Type: pb.PeerEndpoint_VALIDATOR}
+	handler := &mockMessageHandler{to: to}
+	p := &PeerImpl{handlerMap: &handlerMap{m: map[pb.PeerID]MessageHandler{*to.ID: handler}}}
+	if p.denyList, err = loadDenyList(path); err != nil {
+		t.Fatalf("Failed to load an absent deny-list: %s", err)
+	}
+
+	denied, err := p.DenyPeer("vp1", "compromised")
+	if err != nil {
+		t.Fatalf("Failed to deny vp1: %s", err)
+	}
+	if len(denied.Peers) != 1 || denied.Peers[0].Name != "vp1" || denied.Peers[0].Reason != "compromised" || denied.Peers[0].Since == nil {
+		t.Fatalf("Expected vp1 to be denied, got %v", denied)
+	}
+	if _, err := p.getMessageHandler(to.ID); err == nil {
+		t.Fatalf("Expected the handler of the denied peer to be deregistered")
+	}
+	if err := p.Unicast(&pb.Message{Type: pb.Message_CONSENSUS}, to.ID); err == nil || len(handler.sent) != 0 {
+		t.Fatalf("Expected nothing to be sent to the denied peer")
+	}
+	if err := p.RegisterHandler(handler); err == nil {
+		t.Fatalf("Expected the denied peer not to register a handler again")
+	}
+	if !p.deniedHandler(handler) {
+		t.Fatalf("Expected the Chat with the denied peer to be closed")
+	}
+
+	// The deny-list survives restarts
+	restarted, err := loadDenyList(path)
+	if err != nil {
+		t.Fatalf("Failed to load the deny-list: %s", err)
+	}
+	if !restarted.denied("vp1") {
+		t.Fatalf("Expected vp1 to still be denied after a restart")
+	}
+
+	if denied, err = p.AllowPeer("vp1"); err != nil || len(denied.Peers) != 0 {
+		t.Fatalf("Expected vp1 to be allowed, got %v, %v", denied, err)
+	}
+	if _, err = p.AllowPeer("vp1"); err == nil {
+		t.Fatalf("Expected an error allowing a peer which is not denied")
+	}
+	if err := p.RegisterHandler(handler); err != nil {
+		t.Fatalf("Expected the allowed peer to register a handler: %s", err)
+	}
+}
@@ -25,6 +25,7 @@ import (
 	"github.com/looplab/fsm"
 	"github.com/spf13/viper"
 
+	"github.com/hyperledger/fabric/core/chaos"
 	"github.com/hyperledger/fabric/core/ledger/statemgmt"
 	pb "github.com/hyperledger/fabric/protos"
 )
@@ -271,6 +272,7 @@ func (d *Handler) SendMessage(msg *pb.Message) error {
 	d.chatMutex.Lock()
 	defer d.chatMutex.Unlock()
 	peerLogger.Debugf("Sending message to stream of type: %s ", msg.Type)
+	chaos.DelayMessage()
 	err := d.ChatStream.Send(msg)
 	if err != nil {
 		return fmt.Errorf("Error Sending message through ChatStream: %s", err)
@@ -17,6 +17,7 @@ limitations under the License.
 package peer
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
 	"io"
@@ -32,12 +33,15 @@ import (
 	"github.com/op/go-logging"
 	"github.com/spf13/viper"
 
+	"github.com/hyperledger/fabric/core/chaos"
 	"github.com/hyperledger/fabric/core/comm"
 	"github.com/hyperledger/fabric/core/crypto"
 	"github.com/hyperledger/fabric/core/ledger"
 	"github.com/hyperledger/fabric/core/ledger/statemgmt"
 	"github.com/hyperledger/fabric/core/ledger/statemgmt/state"
+	"github.com/hyperledger/fabric/core/rejections"
 	"github.com/hyperledger/fabric/core/util"
+	"github.com/hyperledger/fabric/core/watchdog"
 	"github.com/hyperledger/fabric/discovery"
 	pb "github.com/hyperledger/fabric/protos"
 )
@@ -189,14 +193,19 @@ type EngineFactory func(MessageHandlerCoordinator) (Engine, error)
 
 // PeerImpl implementation of the Peer service
 type PeerImpl struct {
-	handlerFactory HandlerFactory
-	handlerMap     *handlerMap
-	ledgerWrapper  *ledgerWrapper
-	secHelper      crypto.Peer
-	engine         Engine
-	isValidator    bool
-	discoverySvc   discovery.Discovery
-	reconnectOnce  sync.Once
+	handlerFactory    HandlerFactory
+	handlerMap        *handlerMap
+	ledgerWrapper     *ledgerWrapper
+	secHelper         crypto.Peer
+	engine            Engine
+	isValidator       bool
+	isReplica         bool
+	attestRelays      bool
+	relayVerifier     *relayVerifier
+	consensusChannels *consensusChannels // nil unless validators have dedicated consensus connections
+	discoverySvc      discovery.Discovery
+	reconnectOnce     sync.Once
+	denyList          *denyList // peers denied by the operator
 }
 
 // TransactionProccesor responsible for processing of Transactions
@@ -212,6 +221,22 @@ type Engine interface {
 	//GetInputChannel() (chan<- *pb.Transaction, error)
 }
 
+// QueryStreamer is optionally implemented by an Engine, and implemented by the
+// Peer, to execute a query within the context of the request of the client, and
+// to pass its result to sink in the chunks the chaincode emits it. The returned
+// Response holds the part of the result emitted last, or the whole result if
+// sink is nil. The query is abandoned once ctx is done.
+type QueryStreamer interface {
+	StreamQuery(ctx context.Context, transaction *pb.Transaction, sink func(chunk []byte) error) *pb.Response
+}
+
+// Simulator is optionally implemented by an Engine, and implemented by the
+// Peer, to execute an invocation without committing its changes, returning
+// the keys it would change and the event it would emit
+type Simulator interface {
+	Simulate(ctx context.Context, transaction *pb.Transaction) (*pb.SimulationResult, error)
+}
+
 // NewPeerWithHandler returns a Peer which uses the supplied handler factory function for creating new handlers on new Chat service invocations.
 func NewPeerWithHandler(secHelperFunc func() crypto.Peer, handlerFact HandlerFactory, discInstance discovery.Discovery) (*PeerImpl, error) {
 	peer := new(PeerImpl)
@@ -223,6 +248,11 @@ func NewPeerWithHandler(secHelperFunc func() crypto.Peer, handlerFact HandlerFac
 	}
 	peer.handlerFactory = handlerFact
 	peer.handlerMap = &handlerMap{m: make(map[pb.PeerID]MessageHandler)}
+	denyList, err := loadDenyList(denyListPath())
+	if err != nil {
+		return nil, err
+	}
+	peer.denyList = denyList
 
 	peer.secHelper = secHelperFunc()
 
@@ -252,7 +282,14 @@ func NewPeerWithEngine(secHelperFunc func() crypto.Peer, engFactory EngineFactor
 	peer.handlerMap = &handlerMap{m: make(map[pb.PeerID]MessageHandler)}
 
 	peer.isValidator = ValidatorEnabled()
+	peer.isReplica = ReplicaEnabled()
 	peer.secHelper = secHelperFunc()
+	peer.attestRelays = !peer.isValidator && viper.GetBool("peer.relay.attest")
+	peer.relayVerifier = newRelayVerifier(viper.GetFloat64("peer.validator.relay.spotCheckRatio"), peer.isConnectedPeer)
+	peer.consensusChannels = newConsensusChannels()
+	if peer.denyList, err = loadDenyList(denyListPath()); err != nil {
+		return nil, err
+	}
 
 	// Install security object for peer
 	if SecurityEnabled() {
@@ -284,24 +321,63 @@ func NewPeerWithEngine(secHelperFunc func() crypto.Peer, engFactory EngineFactor
 
 // Chat implementation of the the Chat bidi streaming RPC function
 func (p *PeerImpl) Chat(stream pb.Peer_ChatServer) error {
+	if isConsensusChannel(stream.Context()) {
+		return p.acceptConsensusChannel(stream)
+	}
 	return p.handleChat(stream.Context(), stream, false)
 }
 
 // ProcessTransaction implementation of the ProcessTransaction RPC function
 func (p *PeerImpl) ProcessTransaction(ctx context.Context, tx *pb.Transaction) (response *pb.Response, err error) {
 	peerLogger.Debugf("ProcessTransaction processing transaction uuid = %s", tx.Uuid)
+	// Shed the load of an overloaded peer, queries first
+	admit := watchdog.AdmitTransaction
+	if tx.Type == pb.Transaction_CHAINCODE_QUERY {
+		admit = watchdog.AdmitQuery
+	}
+	if err = admit(); err != nil {
+		return &pb.Response{Status: pb.Response_FAILURE, Msg: []byte(err.Error())}, nil
+	}
 	// Need to validate the Tx's signature if we are a validator.
 	if p.isValidator {
 		// Verify transaction signature if security is enabled
 		secHelper := p.secHelper
 		if nil != secHelper {
-			peerLogger.Debugf("Verifying transaction signature %s", tx.Uuid)
-			if tx, err = secHelper.TransactionPreValidation(tx); err != nil {
-				peerLogger.Errorf("ProcessTransaction failed to verify transaction %v", err)
-				return &pb.Response{Status: pb.Response_FAILURE, Msg: []byte(err.Error())}, nil
+			relayer := p.relayVerifier.attestedBy(ctx, secHelper, tx)
+			if relayer != nil && !p.relayVerifier.spotCheck() {
+				peerLogger.Debugf("Skipping verification of transaction signature %s, attested by peer %x", tx.Uuid, relayer)
+			} else {
+				peerLogger.Debugf("Verifying transaction signature %s", tx.Uuid)
+				uuid := tx.Uuid
+				if tx, err = secHelper.TransactionPreValidation(tx); err != nil {
+					peerLogger.Errorf("ProcessTransaction failed to verify transaction %v", err)
+					rejections.Record(uuid, rejections.StageValidation, rejections.ReasonInvalidSignature, err)
+					if relayer != nil {
+						p.relayVerifier.distrust(relayer)
+					}
+					return &pb.Response{Status: pb.Response_FAILURE, Msg: []byte(err.Error())}, nil
+				}
 			}
 		}
 
+	} else if p.attestRelays && p.secHelper != nil && !p.isReplica {
+		// Verify the transaction signature on behalf of the validator
+		peerLogger.Debugf("Verifying transaction signature %s before relaying it", tx.Uuid)
+		uuid := tx.Uuid
+		if tx, err = p.secHelper.TransactionPreValidation(tx); err != nil {
+			peerLogger.Errorf("ProcessTransaction failed to verify transaction %v", err)
+			rejections.Record(uuid, rejections.StageValidation, rejections.ReasonInvalidSignature, err)
+			return &pb.Response{Status: pb.Response_FAILURE, Msg: []byte(err.Error())}, nil
+		}
+		attested, err := attestRelay(p.secHelper, tx)
+		if err != nil {
+			return &pb.Response{Status: pb.Response_FAILURE, Msg: []byte(err.Error())}, nil
+		}
+		return p.sendTransactionToPeer(attested, p.discoverySvc.GetRandomNode(), tx), nil
+	}
+	if tx.Type == pb.Transaction_CHAINCODE_QUERY {
+		// a query is abandoned if the peer which forwarded it gives up
+		return p.StreamQuery(ctx, tx, nil), err
 	}
 	return p.ExecuteTransaction(tx), err
 }
@@ -322,6 +398,19 @@ func (p *PeerImpl) GetPeers() (*pb.PeersMessage, error) {
 	return peersMessage, nil
 }
 
+// isConnectedPeer reports whether the peer with pkiID is connected to us,
+// its handshake having proved it holds the matching enrollment certificate
+func (p *PeerImpl) isConnectedPeer(pkiID []byte) bool {
+	p.handlerMap.RLock()
+	defer p.handlerMap.RUnlock()
+	for _, msgHandler := range p.handlerMap.m {
+		if peerEndpoint, err := msgHandler.To(); err == nil && bytes.Equal(peerEndpoint.PkiID, pkiID) {
+			return true
+		}
+	}
+	return false
+}
+
 // GetRemoteLedger returns the RemoteLedger interface for the remote Peer Endpoint
 func (p *PeerImpl) GetRemoteLedger(receiverHandle *pb.PeerID) (RemoteLedger, error) {
 	p.handlerMap.RLock()
@@ -371,14 +460,22 @@ func (p *PeerImpl) RegisterHandler(messageHandler MessageHandler) error {
 	if err != nil {
 		return fmt.Errorf("Error registering handler: %s", err)
 	}
+	if p.denyList.denied(key.Name) {
+		return fmt.Errorf("Refusing handler of denied peer %s", key.Name)
+	}
 	p.handlerMap.Lock()
-	defer p.handlerMap.Unlock()
 	if _, ok := p.handlerMap.m[*key]; ok == true {
 		// Duplicate, return error
+		p.handlerMap.Unlock()
 		return newDuplicateHandlerError(messageHandler)
 	}
 	p.handlerMap.m[*key] = messageHandler
+	p.handlerMap.Unlock()
 	peerLogger.Debugf("registered handler with key: %s", key)
+
+	if to, err := messageHandler.To(); err == nil {
+		p.maybeDialConsensusChannel(to)
+	}
 	return nil
 }
 
@@ -396,6 +493,9 @@ func (p *PeerImpl) DeregisterHandler(messageHandler MessageHandler) error {
 	}
 	delete(p.handlerMap.m, *key)
 	peerLogger.Debugf("Deregistered handler with key: %s", key)
+	if p.consensusChannels != nil {
+		p.consensusChannels.closeTo(key)
+	}
 	return nil
 }
 
@@ -433,7 +533,7 @@ func (p *PeerImpl) Broadcast(msg *pb.Message, typ pb.PeerEndpoint_Type) []error
 			defer bcWG.Done()
 			host, _ := msgHandler.To()
 			t1 := time.Now()
-			err := msgHandler.SendMessage(msg)
+			err := p.sendTo(msgHandler, msg)
 			if err != nil {
 				toPeerEndpoint, _ := msgHandler.To()
 				errorsFromHandlers <- fmt.Errorf("Error broadcasting msg (%s) to PeerEndpoint (%s): %s", msg.Type, toPeerEndpoint, err)
@@ -472,7 +572,7 @@ func (p *PeerImpl) Unicast(msg *pb.Message, receiverHandle *pb.PeerID) error {
 	if err != nil {
 		return err
 	}
-	err = msgHandler.SendMessage(msg)
+	err = p.sendTo(msgHandler, msg)
 	if err != nil {
 		toPeerEndpoint, _ := msgHandler.To()
 		return fmt.Errorf("Error unicasting msg (%s) to PeerEndpoint (%s): %s", msg.Type, toPeerEndpoint, err)
@@ -482,6 +582,12 @@ func (p *PeerImpl) Unicast(msg *pb.Message, receiverHandle *pb.PeerID) error {
 
 // SendTransactionsToPeer forwards transactions to the specified peer address.
 func (p *PeerImpl) SendTransactionsToPeer(peerAddress string, transaction *pb.Transaction) (response *pb.Response) {
+	return p.sendTransactionToPeer(context.Background(), peerAddress, transaction)
+}
+
+// sendTransactionToPeer forwards a transaction to the specified peer address,
+// in the context ctx of the call
+func (p *PeerImpl) sendTransactionToPeer(ctx context.Context, peerAddress string, transaction *pb.Transaction) (response *pb.Response) {
 	conn, err := NewPeerClientConnectionWithAddress(peerAddress)
 	if err != nil {
 		return &pb.Response{Status: pb.Response_FAILURE, Msg: []byte(fmt.Sprintf("Error creating client to peer address=%s:  %s", peerAddress, err))}
@@ -489,7 +595,7 @@ func (p *PeerImpl) SendTransactionsToPeer(peerAddress string, transaction *pb.Tr
 	defer conn.Close()
 	serverClient := pb.NewPeerClient(conn)
 	peerLogger.Debugf("Sending TX to Peer: %s", peerAddress)
-	response, err = serverClient.ProcessTransaction(context.Background(), transaction)
+	response, err = serverClient.ProcessTransaction(ctx, transaction)
 	if err != nil {
 		return &pb.Response{Status: pb.Response_FAILURE, Msg: []byte(fmt.Sprintf("Error calling ProcessTransaction on remote peer at address=%s:  %s", peerAddress, err))}
 	}
@@ -633,6 +739,12 @@ func (p *PeerImpl) handleChat(ctx context.Context, stream ChatStream, initiatedS
 			peerLogger.Error(e.Error())
 			return e
 		}
+		if chaos.DropConnection() {
+			return fmt.Errorf("Chat connection dropped by chaos mode")
+		}
+		if p.deniedHandler(handler) {
+			return fmt.Errorf("Closing chat with denied peer, dropping its %s message", in.Type)
+		}
 		err = handler.HandleMessage(in)
 		if err != nil {
 			peerLogger.Errorf("Error handling message: %s", err)
@@ -641,9 +753,22 @@ func (p *PeerImpl) handleChat(ctx context.Context, stream ChatStream, initiatedS
 	}
 }
 
+// Simulate executes an invocation on the local engine without committing its
+// changes. Only validators execute chaincodes, so other peers cannot simulate.
+func (p *PeerImpl) Simulate(ctx context.Context, transaction *pb.Transaction) (*pb.SimulationResult, error) {
+	if !p.isValidator && !p.isReplica {
+		return nil, fmt.Errorf("Transactions can only be simulated on a validating peer")
+	}
+	simulator, ok := p.engine.(Simulator)
+	if !ok {
+		return nil, fmt.Errorf("The engine of the peer cannot simulate transactions")
+	}
+	return simulator.Simulate(ctx, transaction)
+}
+
 //ExecuteTransaction executes transactions decides to do execute in dev or prod mode
 func (p *PeerImpl) ExecuteTransaction(transaction *pb.Transaction) (response *pb.Response) {
-	if p.isValidator {
+	if p.isValidator || p.isReplica {
 		response = p.sendTransactionsToLocalEngine(transaction)
 	} else {
 		peerAddress := p.discoverySvc.GetRandomNode()
@@ -652,6 +777,20 @@ func (p *PeerImpl) ExecuteTransaction(transaction *pb.Transaction) (response *pb
 	return response
 }
 
+// StreamQuery executes a query within ctx, streaming its result to sink when
+// the local engine supports it. Otherwise the whole result is returned in the
+// Response
+func (p *PeerImpl) StreamQuery(ctx context.Context, transaction *pb.Transaction, sink func(chunk []byte) error) *pb.Response {
+	if p.isValidator || p.isReplica {
+		if streamer, ok := p.engine.(QueryStreamer); ok {
+			return streamer.StreamQuery(ctx, transaction, sink)
+		}
+		return p.sendTransactionsToLocalEngine(transaction)
+	}
+	// forwarded within ctx, so that the validator abandons the query too
+	return p.sendTransactionToPeer(ctx, p.discoverySvc.GetRandomNode(), transaction)
+}
+
 // GetPeerEndpoint returns the endpoint for this peer
 func (p *PeerImpl) GetPeerEndpoint() (*pb.PeerEndpoint, error) {
 	ep, err := GetPeerEndpoint()
@@ -0,0 +1,154 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package peer
+
+import (
+	"encoding/base64"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/metadata"
+
+	"github.com/hyperledger/fabric/core/crypto"
+	pb "github.com/hyperledger/fabric/protos"
+)
+
+// Non-validating peers may verify the client signature of the transactions
+// they relay, so that garbage transactions are dropped before they reach the
+// validators. The relaying peer then attests the transaction by signing it
+// with its enrollment key, and passes the attestation along with the
+// transaction. Validators only verify the client signature, and the transaction
+// certificate it comes with, for a share of the attested transactions, and stop
+// trusting the attestations of a peer caught attesting an invalid transaction.
+// Only the attestations of the peers connected to the validator are trusted:
+// their handshake proved they hold an enrollment certificate with the peer or
+// validator role, so that a client cannot attest its own transactions.
+
+// Metadata keys of the ProcessTransaction call carrying the attestation
+const (
+	relayPeerMetadataKey        = "relay-peer"
+	relayAttestationMetadataKey = "relay-attestation"
+)
+
+// attestRelay returns a context carrying the attestation of tx by secHelper,
+// for the call relaying tx to a validator
+func attestRelay(secHelper crypto.Peer, tx *pb.Transaction) (context.Context, error) {
+	raw, err := proto.Marshal(tx)
+	if err != nil {
+		return nil, fmt.Errorf("Error marshalling transaction %s: %s", tx.Uuid, err)
+	}
+	signature, err := secHelper.Sign(raw)
+	if err != nil {
+		return nil, fmt.Errorf("Error attesting transaction %s: %s", tx.Uuid, err)
+	}
+	md := metadata.Pairs(
+		relayPeerMetadataKey, base64.StdEncoding.EncodeToString(secHelper.GetID()),
+		relayAttestationMetadataKey, base64.StdEncoding.EncodeToString(signature),
+	)
+	return metadata.NewContext(context.Background(), md), nil
+}
+
+// maxDistrusted bounds the peers whose attestations are ignored, the peer
+// distrusted first being trusted again to make room
+const maxDistrusted = 1024
+
+// relayVerifier checks the attestations of relayed transactions on validators
+type relayVerifier struct {
+	sync.Mutex
+	spotCheckRatio float64                   // share of attested transactions verified anyway
+	known          func(relayer []byte) bool // whether relayer may attest transactions
+	random         *rand.Rand                // guarded by the lock
+	distrusted     map[string]bool           // peers caught attesting invalid transactions
+	distrustOrder  []string                  // distrusted peers, in the order they were caught
+}
+
+func newRelayVerifier(spotCheckRatio float64, known func(relayer []byte) bool) *relayVerifier {
+	return &relayVerifier{
+		spotCheckRatio: spotCheckRatio,
+		known:          known,
+		random:         rand.New(rand.NewSource(time.Now().UnixNano())),
+		distrusted:     make(map[string]bool),
+	}
+}
+
+// attestedBy returns the ID of the peer which attested tx in the context of
+// the call relaying it, or nil if tx comes without an attestation we trust
+func (rv *relayVerifier) attestedBy(ctx context.Context, secHelper crypto.Peer, tx *pb.Transaction) []byte {
+	md, ok := metadata.FromContext(ctx)
+	if !ok || len(md[relayPeerMetadataKey]) != 1 || len(md[relayAttestationMetadataKey]) != 1 {
+		return nil
+	}
+	relayer, err := base64.StdEncoding.DecodeString(md[relayPeerMetadataKey][0])
+	if err != nil {
+		return nil
+	}
+	signature, err := base64.StdEncoding.DecodeString(md[relayAttestationMetadataKey][0])
+	if err != nil {
+		return nil
+	}
+
+	rv.Lock()
+	distrusted := rv.distrusted[string(relayer)]
+	rv.Unlock()
+	if distrusted {
+		peerLogger.Debugf("Ignoring attestation of transaction %s by distrusted peer %x", tx.Uuid, relayer)
+		return nil
+	}
+
+	if !rv.known(relayer) {
+		peerLogger.Debugf("Ignoring attestation of transaction %s by peer %x, which is not connected to us", tx.Uuid, relayer)
+		return nil
+	}
+
+	raw, err := proto.Marshal(tx)
+	if err != nil {
+		return nil
+	}
+	if err = secHelper.Verify(relayer, signature, raw); err != nil {
+		peerLogger.Warningf("Invalid attestation of transaction %s by peer %x: %s", tx.Uuid, relayer, err)
+		return nil
+	}
+	return relayer
+}
+
+// spotCheck reports whether the client signature of an attested transaction
+// should be verified anyway
+func (rv *relayVerifier) spotCheck() bool {
+	rv.Lock()
+	defer rv.Unlock()
+	return rv.random.Float64() < rv.spotCheckRatio
+}
+
+// distrust ignores the attestations of relayer from now on
+func (rv *relayVerifier) distrust(relayer []byte) {
+	peerLogger.Warningf("Peer %x attested a transaction with an invalid client signature, no longer trusting its attestations", relayer)
+	rv.Lock()
+	defer rv.Unlock()
+	if rv.distrusted[string(relayer)] {
+		return
+	}
+	if len(rv.distrustOrder) >= maxDistrusted {
+		delete(rv.distrusted, rv.distrustOrder[0])
+		rv.distrustOrder = rv.distrustOrder[1:]
+	}
+	rv.distrusted[string(relayer)] = true
+	rv.distrustOrder = append(rv.distrustOrder, string(relayer))
+}
@@ -0,0 +1,110 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package peer
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"golang.org/x/net/context"
+
+	"github.com/hyperledger/fabric/core/crypto"
+	"github.com/hyperledger/fabric/core/util"
+	pb "github.com/hyperledger/fabric/protos"
+)
+
+// mockRelayPeer signs messages with the hash of its ID and the message
+type mockRelayPeer struct {
+	crypto.Peer
+	id []byte
+}
+
+func (mp *mockRelayPeer) GetID() []byte {
+	return mp.id
+}
+
+func (mp *mockRelayPeer) Sign(msg []byte) ([]byte, error) {
+	return util.ComputeCryptoHash(append(append([]byte{}, mp.id...), msg...)), nil
+}
+
+func (mp *mockRelayPeer) Verify(vkID, signature, message []byte) error {
+	if !bytes.Equal(signature, util.ComputeCryptoHash(append(append([]byte{}, vkID...), message...))) {
+		return fmt.Errorf("invalid signature")
+	}
+	return nil
+}
+
+func TestRelayAttestation(t *testing.T) {
+	relayer := &mockRelayPeer{id: []byte("nvp0")}
+	validator := &mockRelayPeer{id: []byte("vp0")}
+	tx := &pb.Transaction{Uuid: "tx", Payload: []byte("payload"), Signature: []byte("client signature")}
+
+	ctx, err := attestRelay(relayer, tx)
+	if err != nil {
+		t.Fatalf("Failed to attest transaction: %s", err)
+	}
+
+	known := func(id []byte) bool { return bytes.Equal(id, relayer.id) }
+	rv := newRelayVerifier(0, known)
+	if id := rv.attestedBy(ctx, validator, tx); !bytes.Equal(id, relayer.id) {
+		t.Fatalf("Expected transaction to be attested by %s, got %s", relayer.id, id)
+	}
+	if id := rv.attestedBy(context.Background(), validator, tx); id != nil {
+		t.Errorf("Expected transaction without attestation not to be attested, got %s", id)
+	}
+	tampered := &pb.Transaction{Uuid: "tx", Payload: []byte("other payload"), Signature: []byte("client signature")}
+	if id := rv.attestedBy(ctx, validator, tampered); id != nil {
+		t.Errorf("Expected tampered transaction not to be attested, got %s", id)
+	}
+
+	// A client attesting its own transaction
+	client := &mockRelayPeer{id: []byte("client")}
+	selfAttested, _ := attestRelay(client, tx)
+	if id := rv.attestedBy(selfAttested, validator, tx); id != nil {
+		t.Errorf("Expected attestations of a peer we do not know to be ignored, got %s", id)
+	}
+
+	rv.distrust(relayer.id)
+	if id := rv.attestedBy(ctx, validator, tx); id != nil {
+		t.Errorf("Expected attestations of distrusted peer to be ignored, got %s", id)
+	}
+}
+
+func TestRelayDistrustBounded(t *testing.T) {
+	rv := newRelayVerifier(0, func([]byte) bool { return true })
+	for i := 0; i < maxDistrusted+10; i++ {
+		rv.distrust([]byte(fmt.Sprintf("nvp%d", i)))
+	}
+	if len(rv.distrusted) != maxDistrusted || len(rv.distrustOrder) != maxDistrusted {
+		t.Fatalf("Expected %d distrusted peers, got %d", maxDistrusted, len(rv.distrusted))
+	}
+	if rv.distrusted["nvp0"] || !rv.distrusted[fmt.Sprintf("nvp%d", maxDistrusted+9)] {
+		t.Errorf("Expected the peers distrusted first to be trusted again")
+	}
+}
+
+func TestRelaySpotCheck(t *testing.T) {
+	for _, ratio := range []float64{0, 1} {
+		rv := newRelayVerifier(ratio, nil)
+		for i := 0; i < 100; i++ {
+			if rv.spotCheck() != (ratio == 1) {
+				t.Fatalf("Expected spot checks with ratio %v to be %v", ratio, ratio == 1)
+			}
+		}
+	}
+}
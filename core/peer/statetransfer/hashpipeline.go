@@ -0,0 +1,93 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package statetransfer
+
+import (
+	"github.com/hyperledger/fabric/protos"
+)
+
+// Hashing a block marshals it whole, which dominates the cost of verifying
+// the blocks received during a sync. The hash pipeline hashes the blocks of
+// the messages a peer sends on a pool of goroutines, and hands them back in
+// the order they were received, so that the chain of hashes is still checked
+// and the blocks still put one after the other.
+
+// hashedSyncBlocks is a SyncBlocks message along with the hashes of its blocks
+type hashedSyncBlocks struct {
+	*protos.SyncBlocks
+	hashes [][]byte
+	err    error // set if a block could not be hashed, hashes then stops short of it
+}
+
+// hashSyncBlocks hashes the blocks of syncBlocks on workers goroutines. The
+// returned channel delivers the messages of syncBlocks in order, and is
+// closed once syncBlocks is, or once done is closed.
+func (sts *coordinatorImpl) hashSyncBlocks(syncBlocks <-chan *protos.SyncBlocks, workers int, done <-chan struct{}) <-chan *hashedSyncBlocks {
+	hashed := make(chan *hashedSyncBlocks)
+	// Each message hashing or hashed, but not yet delivered, holds a slot
+	// of pending, which bounds the messages in flight to workers
+	pending := make(chan chan *hashedSyncBlocks, workers-1)
+
+	go func() {
+		defer close(pending)
+		for {
+			select {
+			case msg, ok := <-syncBlocks:
+				if !ok {
+					return
+				}
+				result := make(chan *hashedSyncBlocks, 1)
+				select {
+				case pending <- result:
+				case <-done:
+					return
+				}
+				go func() {
+					result <- sts.hashBlocks(msg)
+				}()
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	go func() {
+		defer close(hashed)
+		for result := range pending {
+			select {
+			case hashed <- <-result:
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	return hashed
+}
+
+func (sts *coordinatorImpl) hashBlocks(msg *protos.SyncBlocks) *hashedSyncBlocks {
+	h := &hashedSyncBlocks{SyncBlocks: msg}
+	for _, block := range msg.Blocks {
+		hash, err := sts.stack.HashBlock(block)
+		if err != nil {
+			h.err = err
+			break
+		}
+		h.hashes = append(h.hashes, hash)
+	}
+	return h
+}
@@ -0,0 +1,116 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package statetransfer
+
+import (
+	"sync"
+	"time"
+)
+
+// Progress is a snapshot of the progress of the latest state transfer
+type Progress struct {
+	InProgress    bool
+	StartBlock    uint64 // Block height when the state transfer started
+	TargetBlock   uint64 // Block number the state transfer is moving to
+	BlocksFetched uint64
+	BytesFetched  uint64 // Size of the blocks, state deltas and state snapshot fetched
+	Started       time.Time
+	Updated       time.Time
+	Err           string // Error of the latest attempt, which is retried while in progress
+}
+
+// BlocksTotal returns the number of blocks the state transfer has to fetch
+func (p Progress) BlocksTotal() uint64 {
+	if p.TargetBlock < p.StartBlock {
+		return 0
+	}
+	return p.TargetBlock - p.StartBlock + 1
+}
+
+// ETA estimates the time left to fetch the remaining blocks, from the rate the
+// blocks were fetched at so far, 0 if unknown
+func (p Progress) ETA() time.Duration {
+	total := p.BlocksTotal()
+	if !p.InProgress || p.BlocksFetched == 0 || p.BlocksFetched >= total {
+		return 0
+	}
+	perBlock := p.Updated.Sub(p.Started) / time.Duration(p.BlocksFetched)
+	return perBlock * time.Duration(total-p.BlocksFetched)
+}
+
+// progressTracker holds the progress of the state transfer of the peer
+type progressTracker struct {
+	lock     sync.Mutex
+	progress Progress
+}
+
+var tracker = &progressTracker{}
+
+// GetProgress returns the progress of the latest state transfer
+func GetProgress() Progress {
+	return tracker.get()
+}
+
+func (pt *progressTracker) get() Progress {
+	pt.lock.Lock()
+	defer pt.lock.Unlock()
+	return pt.progress
+}
+
+func (pt *progressTracker) update(change func(p *Progress)) {
+	pt.lock.Lock()
+	defer pt.lock.Unlock()
+	change(&pt.progress)
+	pt.progress.Updated = time.Now()
+}
+
+// begin is invoked as a state transfer to targetBlock starts from height, or
+// moves to a new target
+func (pt *progressTracker) begin(height, targetBlock uint64) {
+	pt.update(func(p *Progress) {
+		if !p.InProgress {
+			*p = Progress{
+				InProgress: true,
+				StartBlock: height,
+				Started:    time.Now(),
+			}
+		}
+		p.TargetBlock = targetBlock
+	})
+}
+
+// fetched counts blocks and bytes fetched from other peers
+func (pt *progressTracker) fetched(blocks uint64, bytes int) {
+	pt.update(func(p *Progress) {
+		p.BlocksFetched += blocks
+		p.BytesFetched += uint64(bytes)
+	})
+}
+
+// attempted records the outcome of an attempt to reach the target, the state
+// transfer ending unless the attempt failed and will be retried
+func (pt *progressTracker) attempted(err error, recoverable bool) {
+	pt.update(func(p *Progress) {
+		p.Err = ""
+		if err != nil {
+			p.Err = err.Error()
+		}
+		if err == nil || !recoverable {
+			p.InProgress = false
+		}
+	})
+}
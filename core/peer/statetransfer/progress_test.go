@@ -0,0 +1,57 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package statetransfer
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestProgress(t *testing.T) {
+	pt := &progressTracker{}
+
+	pt.begin(10, 19)
+	pt.fetched(1, 100)
+	pt.fetched(1, 100)
+	pt.fetched(0, 50)
+
+	p := pt.get()
+	if !p.InProgress || p.StartBlock != 10 || p.TargetBlock != 19 || p.BlocksFetched != 2 || p.BytesFetched != 250 {
+		t.Fatalf("Unexpected progress %+v", p)
+	}
+	if p.BlocksTotal() != 10 {
+		t.Fatalf("Expected 10 blocks to fetch, got %d", p.BlocksTotal())
+	}
+	p.Started = p.Updated.Add(-2 * time.Second)
+	if eta := p.ETA(); eta != 8*time.Second {
+		t.Fatalf("Expected 8s left for 8 more blocks at 1s per block, got %v", eta)
+	}
+
+	// A failed attempt which is retried does not end the state transfer, nor
+	// does a new target restart it
+	pt.attempted(fmt.Errorf("timed out"), true)
+	pt.begin(12, 29)
+	if p := pt.get(); !p.InProgress || p.StartBlock != 10 || p.TargetBlock != 29 || p.BlocksFetched != 2 || p.Err != "timed out" {
+		t.Fatalf("Unexpected progress %+v", p)
+	}
+
+	pt.attempted(nil, true)
+	if p := pt.get(); p.InProgress || p.Err != "" || p.ETA() != 0 {
+		t.Fatalf("Unexpected progress %+v", p)
+	}
+}
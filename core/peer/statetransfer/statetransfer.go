@@ -20,11 +20,14 @@ import (
 	"bytes"
 	"fmt"
 	"math/rand"
+	"runtime"
 	"sort"
 	"time"
 
 	_ "github.com/hyperledger/fabric/core" // Logging format init
 
+	"github.com/golang/protobuf/proto"
+	"github.com/hyperledger/fabric/core/chaos"
 	"github.com/hyperledger/fabric/core/ledger/statemgmt"
 	"github.com/hyperledger/fabric/core/peer"
 	"github.com/hyperledger/fabric/protos"
@@ -94,6 +97,7 @@ type coordinatorImpl struct {
 	StateSnapshotRequestTimeout time.Duration // How long to wait for a peer to respond to a state snapshot request
 
 	maxStateDeltas     int    // The maximum number of state deltas to attempt to retrieve before giving up and performing a full state snapshot retrieval
+	hashWorkers        int    // The number of blocks received during a sync to hash at once
 	maxBlockRange      uint64 // The maximum number blocks to attempt to retrieve at once, to prevent from overflowing the peer's buffer
 	maxStateDeltaRange uint64 // The maximum number of state deltas to attempt to retrieve at once, to prevent from overflowing the peer's buffer
 
@@ -105,6 +109,7 @@ type coordinatorImpl struct {
 // If the call returns an error, a boolean is included which indicates if the error may be transient and the caller should retry
 func (sts *coordinatorImpl) SyncToTarget(blockNumber uint64, blockHash []byte, peerIDs []*protos.PeerID) (error, bool) {
 	logger.Debugf("%v attempting to sync to target %x for block number %d with peers %v", sts.id, blockHash, blockNumber, peerIDs)
+	chaos.WaitResumed(chaos.BlockSync, sts.threadExit)
 	bhr := &blockHashReply{
 		syncMark: syncMark{
 			blockNumber: blockNumber,
@@ -117,8 +122,10 @@ func (sts *coordinatorImpl) SyncToTarget(blockNumber uint64, blockHash []byte, p
 		sts.currentStateBlockNumber = sts.stack.GetBlockchainSize() - 1 // The block height is one more than the latest block number
 		sts.inProgress = true
 	}
+	tracker.begin(sts.stack.GetBlockchainSize(), blockNumber)
 
 	err, recoverable := sts.attemptStateTransfer(bhr)
+	tracker.attempted(err, recoverable)
 
 	if err == nil {
 		sts.inProgress = false
@@ -198,6 +205,14 @@ func NewCoordinatorImpl(stack PartialStack) Coordinator {
 		panic(fmt.Errorf("sts.maxdeltas must be greater than 0"))
 	}
 
+	sts.hashWorkers = viper.GetInt("statetransfer.hashworkers")
+	if sts.hashWorkers < 0 {
+		panic(fmt.Errorf("statetransfer.hashworkers must not be negative"))
+	}
+	if sts.hashWorkers == 0 {
+		sts.hashWorkers = runtime.NumCPU()
+	}
+
 	tmp := viper.GetInt("peer.sync.blocks.channelSize")
 	if tmp <= 0 {
 		panic(fmt.Errorf("peer.sync.blocks.channelSize must be greater than 0"))
@@ -323,9 +338,16 @@ func (sts *coordinatorImpl) syncBlocks(highBlock, lowBlock uint64, highHash []by
 	var goodRange *blockRange
 
 	err := sts.tryOverPeers(peerIDs, func(peerID *protos.PeerID) error {
+		var done chan struct{}
+		defer func() {
+			if done != nil {
+				close(done)
+			}
+		}()
+
 		for {
 			intermediateBlock := blockCursor + 1
-			var blockChan <-chan *protos.SyncBlocks
+			var blockChan <-chan *hashedSyncBlocks
 			var err error
 			for {
 
@@ -340,7 +362,15 @@ func (sts *coordinatorImpl) syncBlocks(highBlock, lowBlock uint64, highHash []by
 						intermediateBlock = lowBlock
 					}
 					logger.Debugf("%v requesting block range from %d to %d", sts.id, blockCursor, intermediateBlock)
-					blockChan, err = sts.GetRemoteBlocks(peerID, blockCursor, intermediateBlock)
+					var syncBlocks <-chan *protos.SyncBlocks
+					syncBlocks, err = sts.GetRemoteBlocks(peerID, blockCursor, intermediateBlock)
+					if nil == err {
+						if done != nil {
+							close(done)
+						}
+						done = make(chan struct{})
+						blockChan = sts.hashSyncBlocks(syncBlocks, sts.hashWorkers, done)
+					}
 				}
 
 				if nil != err {
@@ -368,11 +398,11 @@ func (sts *coordinatorImpl) syncBlocks(highBlock, lowBlock uint64, highHash []by
 							return fmt.Errorf("%v received a block out of order, indicating a buffer overflow or other corruption: start=%d, end=%d, wanted %d", sts.id, syncBlockMessage.Range.Start, syncBlockMessage.Range.End, blockCursor)
 						}
 
-						testHash, err := sts.stack.HashBlock(block)
-						if nil != err {
+						if i >= len(syncBlockMessage.hashes) {
 							return fmt.Errorf("%v got a block %d which could not hash from %v: %s",
-								sts.id, blockCursor, peerID, err)
+								sts.id, blockCursor, peerID, syncBlockMessage.err)
 						}
+						testHash := syncBlockMessage.hashes[i]
 
 						if !bytes.Equal(testHash, validBlockHash) {
 							return fmt.Errorf("%v got block %d from %v with hash %x, was expecting hash %x",
@@ -400,6 +430,7 @@ func (sts *coordinatorImpl) syncBlocks(highBlock, lowBlock uint64, highHash []by
 						} else {
 							sts.stack.PutBlock(blockCursor, block)
 						}
+						tracker.fetched(1, proto.Size(block))
 
 						goodRange = &blockRange{
 							highBlock:   highBlock,
@@ -751,6 +782,7 @@ func (sts *coordinatorImpl) playStateUpToBlockNumber(fromBlockNumber, toBlockNum
 						return fmt.Errorf("%v received a corrupt state delta from %v : %s", sts.id, peerID, err)
 					}
 					sts.stack.ApplyStateDelta(deltaMessage, umDelta)
+					tracker.fetched(0, len(delta))
 				}
 
 				success := false
@@ -849,6 +881,7 @@ func (sts *coordinatorImpl) syncStateSnapshot(minBlockNumber uint64, peerIDs []*
 					return fmt.Errorf("%v received a corrupt delta from %v after %d deltas : %s", sts.id, peerID, counter, err)
 				}
 				sts.stack.ApplyStateDelta(piece, umDelta)
+				tracker.fetched(0, len(piece.Delta))
 				currentStateBlock = piece.BlockNumber
 				if err := sts.stack.CommitStateDelta(piece); nil != err {
 					return fmt.Errorf("%v could not commit state delta from %v after %d deltas: %s", sts.id, peerID, counter, err)
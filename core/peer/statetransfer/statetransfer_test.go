@@ -18,8 +18,10 @@ package statetransfer
 
 import (
 	"bytes"
+	"crypto/sha256"
 	"fmt"
 	"os"
+	"runtime"
 	"sort"
 	"sync"
 	"testing"
@@ -636,3 +638,43 @@ func TestBlockRangeOrdering(t *testing.T) {
 		t.Fatalf("Low range should come third")
 	}
 }
+
+// slowHashStack costs each block hash about what hashing a block of a few
+// hundred transactions costs on a real ledger
+type slowHashStack struct {
+	PartialStack
+}
+
+var slowHashPadding = make([]byte, 256*1024)
+
+func (s slowHashStack) HashBlock(block *protos.Block) ([]byte, error) {
+	sha256.Sum256(slowHashPadding)
+	return s.PartialStack.HashBlock(block)
+}
+
+func benchmarkSyncBlocks(b *testing.B, workers int) {
+	logging.SetLevel(logging.WARNING, "")
+	defer logging.SetLevel(logging.DEBUG, "")
+
+	const height = 200
+	for n := 0; n < b.N; n++ {
+		mrls := createRemoteLedgers(1, 1)
+		for peerID := range mrls.remoteLedgers {
+			mrls.GetMockRemoteLedgerByPeerID(&peerID).blockHeight = height
+		}
+		ml := NewMockLedger(mrls, nil, nil)
+		sts := NewCoordinatorImpl(slowHashStack{newPartialStack(ml, mrls)}).(*coordinatorImpl)
+		sts.hashWorkers = workers
+		if _, _, err := sts.syncBlocks(height-1, 0, SimpleGetBlockHash(height-1), nil); err != nil {
+			b.Fatalf("Error syncing blocks: %s", err)
+		}
+	}
+}
+
+func BenchmarkSyncBlocksSerialHash(b *testing.B) {
+	benchmarkSyncBlocks(b, 1)
+}
+
+func BenchmarkSyncBlocksParallelHash(b *testing.B) {
+	benchmarkSyncBlocks(b, runtime.NumCPU())
+}
@@ -0,0 +1,182 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package profiling
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/pprof"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/op/go-logging"
+	"github.com/spf13/viper"
+
+	pb "github.com/hyperledger/fabric/protos"
+	google_protobuf "google/protobuf"
+)
+
+var logger = logging.MustGetLogger("profiling")
+
+// Reasons a snapshot is captured for
+const (
+	// ReasonRequest is a capture requested through the Admin service
+	ReasonRequest = "request"
+	// ReasonHeapThreshold is a capture triggered by the heap growing over the threshold
+	ReasonHeapThreshold = "heap_threshold"
+)
+
+const (
+	// defaultRetain is the number of snapshots of each type kept if not configured
+	defaultRetain = 10
+	// defaultHeapCheckInterval is how often the heap is checked if not configured
+	defaultHeapCheckInterval = 10 * time.Second
+)
+
+// captureLock serializes the captures, so that pruning sees every snapshot
+var captureLock sync.Mutex
+
+// profileName returns the name of the runtime/pprof profile of a type
+func profileName(typ pb.ProfileRequest_Type) (string, error) {
+	switch typ {
+	case pb.ProfileRequest_HEAP:
+		return "heap", nil
+	case pb.ProfileRequest_GOROUTINE:
+		return "goroutine", nil
+	}
+	return "", fmt.Errorf("Unknown profile type %s", typ)
+}
+
+// SnapshotDir returns the directory snapshots are stored in,
+// <peer.fileSystemPath>/profiles
+func SnapshotDir() string {
+	return filepath.Join(viper.GetString("peer.fileSystemPath"), "profiles")
+}
+
+// Capture writes a profile of the peer to the snapshot directory, keeping the
+// peer.profile.snapshots.retain latest snapshots of its type
+func Capture(typ pb.ProfileRequest_Type, reason string) (*pb.ProfileSnapshot, error) {
+	name, err := profileName(typ)
+	if err != nil {
+		return nil, err
+	}
+	profile := pprof.Lookup(name)
+	if profile == nil {
+		return nil, fmt.Errorf("Profile %s not available", name)
+	}
+
+	captureLock.Lock()
+	defer captureLock.Unlock()
+
+	dir := SnapshotDir()
+	if err = os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("Error creating the snapshot directory: %s", err)
+	}
+	now := time.Now()
+	if typ == pb.ProfileRequest_HEAP {
+		// the heap profile reports the allocations as of the last collection
+		runtime.GC()
+	}
+	path := filepath.Join(dir, fmt.Sprintf("%s-%d.pprof", name, now.UnixNano()))
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("Error creating the snapshot: %s", err)
+	}
+	err = profile.WriteTo(f, 0)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(path)
+		return nil, fmt.Errorf("Error writing the %s profile: %s", name, err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	logger.Infof("Captured %s profile to %s (%s)", name, path, reason)
+
+	retain := viper.GetInt("peer.profile.snapshots.retain")
+	if retain <= 0 {
+		retain = defaultRetain
+	}
+	prune(dir, name, retain)
+
+	return &pb.ProfileSnapshot{
+		Type:      typ,
+		Path:      path,
+		Size:      uint64(info.Size()),
+		Timestamp: &google_protobuf.Timestamp{Seconds: now.Unix(), Nanos: int32(now.Nanosecond())},
+		Reason:    reason,
+	}, nil
+}
+
+// prune removes the oldest snapshots of a profile beyond the retain latest
+func prune(dir string, name string, retain int) {
+	paths, err := filepath.Glob(filepath.Join(dir, name+"-*.pprof"))
+	if err != nil || len(paths) <= retain {
+		return
+	}
+	// names hold the capture time in nanoseconds, which sort as text
+	sort.Strings(paths)
+	for _, path := range paths[:len(paths)-retain] {
+		if err := os.Remove(path); err != nil {
+			logger.Warningf("Error removing the snapshot %s: %s", path, err)
+		}
+	}
+}
+
+// WatchHeap captures a heap and a goroutine profile when the heap in use grows
+// over thresholdMB, checking every interval until stop is closed. It captures
+// again only once the heap has dropped under the threshold, so that a peer
+// staying over it does not fill the disk.
+func WatchHeap(thresholdMB uint64, interval time.Duration, stop <-chan struct{}) {
+	threshold := thresholdMB << 20
+	if interval <= 0 {
+		interval = defaultHeapCheckInterval
+	}
+	armed := true
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+		var stats runtime.MemStats
+		runtime.ReadMemStats(&stats)
+		if stats.HeapAlloc < threshold {
+			armed = true
+			continue
+		}
+		if !armed {
+			continue
+		}
+		armed = false
+		logger.Warningf("Heap in use (%d MB) over the threshold of %d MB, capturing profiles", stats.HeapAlloc>>20, thresholdMB)
+		for _, typ := range []pb.ProfileRequest_Type{pb.ProfileRequest_HEAP, pb.ProfileRequest_GOROUTINE} {
+			if _, err := Capture(typ, ReasonHeapThreshold); err != nil {
+				logger.Errorf("Error capturing the %s profile: %s", strings.ToLower(typ.String()), err)
+			}
+		}
+	}
+}
@@ -0,0 +1,130 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package profiling
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+
+	pb "github.com/hyperledger/fabric/protos"
+)
+
+func setupSnapshotDir(t *testing.T) func() {
+	dir, err := ioutil.TempDir("", "profiling")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %s", err)
+	}
+	viper.Set("peer.fileSystemPath", dir)
+	viper.Set("peer.profile.snapshots.retain", 2)
+	return func() { os.RemoveAll(dir) }
+}
+
+func TestCapture(t *testing.T) {
+	defer setupSnapshotDir(t)()
+
+	for i := 0; i < 3; i++ {
+		snapshot, err := Capture(pb.ProfileRequest_HEAP, ReasonRequest)
+		if err != nil {
+			t.Fatalf("Failed to capture a heap profile: %s", err)
+		}
+		info, err := os.Stat(snapshot.Path)
+		if err != nil || uint64(info.Size()) != snapshot.Size || snapshot.Size == 0 {
+			t.Fatalf("Expected the profile to be written to %s", snapshot.Path)
+		}
+	}
+	if _, err := Capture(pb.ProfileRequest_GOROUTINE, ReasonRequest); err != nil {
+		t.Fatalf("Failed to capture a goroutine profile: %s", err)
+	}
+
+	heaps, _ := filepath.Glob(filepath.Join(SnapshotDir(), "heap-*.pprof"))
+	goroutines, _ := filepath.Glob(filepath.Join(SnapshotDir(), "goroutine-*.pprof"))
+	if len(heaps) != 2 || len(goroutines) != 1 {
+		t.Fatalf("Expected the 2 latest heap profiles and 1 goroutine profile to be kept, got %v and %v", heaps, goroutines)
+	}
+
+	if _, err := Capture(pb.ProfileRequest_Type(42), ReasonRequest); err == nil {
+		t.Fatalf("Expected an unknown profile type to be rejected")
+	}
+}
+
+func TestWatchHeap(t *testing.T) {
+	defer setupSnapshotDir(t)()
+
+	// any heap is over a threshold of 0 MB
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		WatchHeap(0, 10*time.Millisecond, stop)
+		close(done)
+	}()
+	time.Sleep(200 * time.Millisecond)
+	close(stop)
+	<-done
+
+	// captured once, the heap never dropping under the threshold
+	heaps, _ := filepath.Glob(filepath.Join(SnapshotDir(), "heap-*.pprof"))
+	goroutines, _ := filepath.Glob(filepath.Join(SnapshotDir(), "goroutine-*.pprof"))
+	if len(heaps) != 1 || len(goroutines) != 1 {
+		t.Fatalf("Expected one heap and one goroutine profile, got %v and %v", heaps, goroutines)
+	}
+}
+
+func TestHandler(t *testing.T) {
+	server := httptest.NewServer(Handler("admin", "secret"))
+	defer server.Close()
+
+	get := func(user, password string) int {
+		req, _ := http.NewRequest("GET", server.URL+"/debug/pprof/goroutine", nil)
+		if user != "" {
+			req.SetBasicAuth(user, password)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("Request failed: %s", err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	if status := get("", ""); status != http.StatusUnauthorized {
+		t.Fatalf("Expected an unauthenticated request to be refused, got %d", status)
+	}
+	if status := get("admin", "wrong"); status != http.StatusUnauthorized {
+		t.Fatalf("Expected a wrong password to be refused, got %d", status)
+	}
+	if status := get("admin", "secret"); status != http.StatusOK {
+		t.Fatalf("Expected an authenticated request to be served, got %d", status)
+	}
+
+	if err := CheckListenAddress("0.0.0.0:6060", ""); err == nil {
+		t.Fatalf("Expected a public address without password to be refused")
+	}
+	for _, address := range []string{"127.0.0.1:6060", "localhost:6060", "[::1]:6060"} {
+		if err := CheckListenAddress(address, ""); err != nil {
+			t.Fatalf("Expected the loopback address %s to be accepted: %s", address, err)
+		}
+	}
+	if err := CheckListenAddress("0.0.0.0:6060", "secret"); err != nil {
+		t.Fatalf("Expected a public address with a password to be accepted: %s", err)
+	}
+}
@@ -0,0 +1,214 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package rejections keeps a log of the transactions the peer rejected, with
+// the reason of their rejection, so that the submitter of a transaction that
+// never committed can find out why. The log is local to the peer, persisted
+// along with its database, and bounded: once it holds peer.rejections.size
+// transactions, the oldest rejections are forgotten.
+package rejections
+
+import (
+	"encoding/binary"
+	"sync"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/op/go-logging"
+	"github.com/spf13/viper"
+	"github.com/tecbot/gorocksdb"
+
+	"github.com/hyperledger/fabric/core/db"
+	"github.com/hyperledger/fabric/core/util"
+	pb "github.com/hyperledger/fabric/protos"
+)
+
+var logger = logging.MustGetLogger("rejections")
+
+// Stages at which a transaction is rejected
+const (
+	// StageValidation is the verification of the transaction before it is ordered
+	StageValidation = "validation"
+	// StageConsensus is the submission of the transaction to the consenter
+	StageConsensus = "consensus"
+	// StageExecution is the execution of the ordered transaction
+	StageExecution = "execution"
+)
+
+// Reason codes of the rejection of a transaction
+const (
+	// ReasonInvalidSignature is a transaction whose signature or certificate does not verify
+	ReasonInvalidSignature = "invalid_signature"
+	// ReasonInvalidArguments is a transaction whose arguments break the manifest of its chaincode
+	ReasonInvalidArguments = "invalid_arguments"
+	// ReasonInvalidID is a transaction whose ID does not follow the transaction ID scheme of the network
+	ReasonInvalidID = "invalid_id"
+	// ReasonNotOrdered is a transaction the consenter did not accept
+	ReasonNotOrdered = "not_ordered"
+	// ReasonBackpressure is a transaction turned down while the consenter has too many waiting to be ordered
+	ReasonBackpressure = "backpressure"
+	// ReasonDuplicate is a transaction rejected by the dedup window of its chaincode, or whose ID was already used
+	ReasonDuplicate = "duplicate"
+	// ReasonBreakerOpen is an invoke of a chaincode disabled after repeated failed invokes
+	ReasonBreakerOpen = "breaker_open"
+	// ReasonExecutionFailed is a transaction whose chaincode returned an error
+	ReasonExecutionFailed = "execution_failed"
+)
+
+// The log is kept in the persist column family, as a record by transaction
+// uuid and the uuids by order of rejection
+var (
+	txKeyPrefix  = []byte("rejected.tx.")
+	seqKeyPrefix = []byte("rejected.seq.")
+)
+
+func txKey(uuid string) []byte {
+	return append(append([]byte{}, txKeyPrefix...), uuid...)
+}
+
+func seqKey(seq uint64) []byte {
+	key := make([]byte, len(seqKeyPrefix)+8)
+	copy(key, seqKeyPrefix)
+	binary.BigEndian.PutUint64(key[len(seqKeyPrefix):], seq)
+	return key
+}
+
+type rejectionLog struct {
+	sync.Mutex
+	size  int
+	seqs  map[string]uint64 // sequence number of the rejection of each uuid
+	first uint64            // sequence number of the oldest rejection
+	next  uint64
+}
+
+var theLog *rejectionLog
+var theLogLock sync.Mutex
+
+// getLog returns the log, loading it from the database on first use
+func getLog() *rejectionLog {
+	theLogLock.Lock()
+	defer theLogLock.Unlock()
+	if theLog == nil {
+		theLog = loadLog(viper.GetInt("peer.rejections.size"))
+	}
+	return theLog
+}
+
+func loadLog(size int) *rejectionLog {
+	l := &rejectionLog{size: size, seqs: make(map[string]uint64)}
+	it := db.GetDBHandle().GetIterator(db.GetDBHandle().PersistCF)
+	defer it.Close()
+	for it.Seek(seqKeyPrefix); it.ValidForPrefix(seqKeyPrefix); it.Next() {
+		seq := binary.BigEndian.Uint64(it.Key().Data()[len(seqKeyPrefix):])
+		if len(l.seqs) == 0 {
+			l.first = seq
+		}
+		l.seqs[string(it.Value().Data())] = seq
+		l.next = seq + 1
+	}
+	logger.Debugf("Loaded the log of %d rejected transactions", len(l.seqs))
+	return l
+}
+
+func (l *rejectionLog) record(rejected *pb.RejectedTransaction) error {
+	value, err := proto.Marshal(rejected)
+	if err != nil {
+		return err
+	}
+
+	l.Lock()
+	defer l.Unlock()
+	openchainDB := db.GetDBHandle()
+	writeBatch := gorocksdb.NewWriteBatch()
+	defer writeBatch.Destroy()
+
+	// a transaction rejected again moves to the end of the log
+	count := len(l.seqs)
+	if seq, ok := l.seqs[rejected.Uuid]; ok {
+		writeBatch.DeleteCF(openchainDB.PersistCF, seqKey(seq))
+	} else {
+		count++
+	}
+	writeBatch.PutCF(openchainDB.PersistCF, txKey(rejected.Uuid), value)
+	writeBatch.PutCF(openchainDB.PersistCF, seqKey(l.next), []byte(rejected.Uuid))
+
+	// forget about the oldest rejections beyond the size of the log
+	first := l.first
+	var evicted []string
+	for ; count > l.size && first < l.next; first++ {
+		uuidBytes, err := openchainDB.Get(openchainDB.PersistCF, seqKey(first))
+		if err != nil {
+			return err
+		}
+		uuid := string(uuidBytes)
+		if uuidBytes == nil || uuid == rejected.Uuid || l.seqs[uuid] != first {
+			continue
+		}
+		writeBatch.DeleteCF(openchainDB.PersistCF, seqKey(first))
+		writeBatch.DeleteCF(openchainDB.PersistCF, txKey(uuid))
+		evicted = append(evicted, uuid)
+		count--
+	}
+
+	opt := gorocksdb.NewDefaultWriteOptions()
+	defer opt.Destroy()
+	if err := openchainDB.DB.Write(opt, writeBatch); err != nil {
+		return err
+	}
+	for _, uuid := range evicted {
+		delete(l.seqs, uuid)
+	}
+	l.seqs[rejected.Uuid] = l.next
+	l.next++
+	l.first = first
+	return nil
+}
+
+// Record logs that the transaction of the given uuid was rejected at stage
+// for reason, with error err. Failing to log the rejection does not affect
+// the processing of the transaction, it is only reported in the peer log.
+func Record(uuid string, stage string, reason string, err error) {
+	l := getLog()
+	if l.size <= 0 || uuid == "" {
+		return
+	}
+	rejected := &pb.RejectedTransaction{
+		Uuid:      uuid,
+		Stage:     stage,
+		Reason:    reason,
+		Timestamp: util.CreateUtcTimestamp(),
+	}
+	if err != nil {
+		rejected.Error = err.Error()
+	}
+	logger.Debugf("Transaction %s rejected at %s stage (%s): %s", uuid, stage, reason, rejected.Error)
+	if err := l.record(rejected); err != nil {
+		logger.Warningf("Failed logging the rejection of transaction %s: %s", uuid, err)
+	}
+}
+
+// Get returns why the transaction of the given uuid was rejected, or nil if
+// the log holds no rejection of this transaction
+func Get(uuid string) (*pb.RejectedTransaction, error) {
+	value, err := db.GetDBHandle().Get(db.GetDBHandle().PersistCF, txKey(uuid))
+	if err != nil || value == nil {
+		return nil, err
+	}
+	rejected := &pb.RejectedTransaction{}
+	if err := proto.Unmarshal(value, rejected); err != nil {
+		return nil, err
+	}
+	return rejected, nil
+}
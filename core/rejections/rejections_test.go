@@ -0,0 +1,89 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rejections
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/hyperledger/fabric/core/db"
+	"github.com/spf13/viper"
+)
+
+func TestMain(m *testing.M) {
+	tempDir, err := ioutil.TempDir("", "rejections-test")
+	if err != nil {
+		panic(err)
+	}
+	viper.Set("peer.fileSystemPath", tempDir)
+	ret := m.Run()
+	os.RemoveAll(tempDir)
+	os.Exit(ret)
+}
+
+func TestRejectionLog(t *testing.T) {
+	dbTestWrapper := db.NewTestDBWrapper()
+	dbTestWrapper.CreateFreshDB(t)
+	defer dbTestWrapper.CloseDB(t)
+	viper.Set("peer.rejections.size", 3)
+	theLog = nil
+
+	Record("tx0", StageValidation, ReasonInvalidSignature, errors.New("bad signature"))
+	rejected, err := Get("tx0")
+	if err != nil || rejected == nil {
+		t.Fatalf("Expected the rejection of tx0 to be logged, got %v: %v", rejected, err)
+	}
+	if rejected.Stage != StageValidation || rejected.Reason != ReasonInvalidSignature || rejected.Error != "bad signature" || rejected.Timestamp == nil {
+		t.Fatalf("Unexpected rejection of tx0: %v", rejected)
+	}
+	if rejected, err := Get("unknown"); err != nil || rejected != nil {
+		t.Fatalf("Expected no rejection of an unknown transaction, got %v: %v", rejected, err)
+	}
+
+	for i := 1; i < 3; i++ {
+		Record(fmt.Sprintf("tx%d", i), StageExecution, ReasonExecutionFailed, errors.New("failed"))
+	}
+	// tx0 rejected again is the most recent rejection
+	Record("tx0", StageExecution, ReasonDuplicate, errors.New("duplicate"))
+	Record("tx3", StageConsensus, ReasonNotOrdered, errors.New("not ordered"))
+
+	expectLogged := func(expected map[string]bool) {
+		for uuid, logged := range expected {
+			rejected, err := Get(uuid)
+			if err != nil || (rejected != nil) != logged {
+				t.Fatalf("Expected rejection of %s to be logged: %v, got %v: %v", uuid, logged, rejected, err)
+			}
+		}
+	}
+	expectLogged(map[string]bool{"tx0": true, "tx1": false, "tx2": true, "tx3": true})
+	if rejected, _ := Get("tx0"); rejected.Reason != ReasonDuplicate {
+		t.Fatalf("Expected the latest rejection of tx0, got %v", rejected)
+	}
+
+	// the log is reloaded from the database
+	theLog = nil
+	Record("tx4", StageExecution, ReasonExecutionFailed, nil)
+	expectLogged(map[string]bool{"tx0": true, "tx2": false, "tx3": true, "tx4": true})
+
+	viper.Set("peer.rejections.size", 0)
+	theLog = nil
+	Record("tx5", StageExecution, ReasonExecutionFailed, nil)
+	expectLogged(map[string]bool{"tx5": false})
+}
@@ -0,0 +1,264 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package replica implements the engine of a read replica. A read replica is
+// a non-validating peer that keeps a full copy of the blockchain and of the
+// world state by following a validating peer: it subscribes to the block
+// events of the validator and pulls every new block, along with its state
+// delta, through state transfer. Queries are executed against the local world
+// state, while transactions are rejected.
+package replica
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/op/go-logging"
+	"github.com/spf13/viper"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+
+	"google/protobuf"
+
+	"github.com/hyperledger/fabric/core/chaincode"
+	"github.com/hyperledger/fabric/core/peer"
+	"github.com/hyperledger/fabric/core/peer/statetransfer"
+	"github.com/hyperledger/fabric/events/consumer"
+	pb "github.com/hyperledger/fabric/protos"
+)
+
+var logger = logging.MustGetLogger("replica")
+
+// Engine implements peer.Engine for a read replica
+type Engine struct {
+	coord peer.MessageHandlerCoordinator
+	sts   statetransfer.Coordinator
+
+	validatorAddress string
+	eventsAddress    string
+	pollInterval     time.Duration
+
+	validatorConn *grpc.ClientConn
+	syncChan      chan struct{}
+
+	lock  sync.RWMutex
+	valid bool // Set once the replica has caught up with the validator, reset when a sync fails
+}
+
+var engineOnce sync.Once
+
+var engine *Engine
+
+// GetEngine returns the read replica engine, creating it on first use
+func GetEngine(coord peer.MessageHandlerCoordinator) (peer.Engine, error) {
+	var err error
+	engineOnce.Do(func() {
+		engine, err = newEngine(coord)
+		if err != nil {
+			return
+		}
+		engine.start()
+	})
+	if err != nil {
+		return nil, err
+	}
+	return engine, nil
+}
+
+func newEngine(coord peer.MessageHandlerCoordinator) (*Engine, error) {
+	eng := &Engine{
+		coord:            coord,
+		validatorAddress: viper.GetString("peer.replica.validator"),
+		eventsAddress:    viper.GetString("peer.replica.events.address"),
+		pollInterval:     viper.GetDuration("peer.replica.pollInterval"),
+		syncChan:         make(chan struct{}, 1),
+	}
+	if eng.validatorAddress == "" {
+		eng.validatorAddress = viper.GetString("peer.discovery.rootnode")
+	}
+	if eng.validatorAddress == "" {
+		return nil, fmt.Errorf("A read replica needs the address of a validating peer to follow, set peer.replica.validator or peer.discovery.rootnode")
+	}
+	if eng.pollInterval <= 0 {
+		return nil, fmt.Errorf("peer.replica.pollInterval must be greater than 0")
+	}
+	eng.sts = statetransfer.NewCoordinatorImpl(coord)
+	return eng, nil
+}
+
+func (eng *Engine) start() {
+	logger.Infof("Starting read replica following validator %s", eng.validatorAddress)
+	eng.sts.Start()
+	go eng.syncLoop()
+	go eng.subscribe()
+}
+
+// GetHandlerFactory returns the handler factory of a non-validating peer
+func (eng *Engine) GetHandlerFactory() peer.HandlerFactory {
+	return peer.NewPeerHandler
+}
+
+// ProcessTransactionMsg executes queries against the local world state and
+// rejects any other transaction
+func (eng *Engine) ProcessTransactionMsg(msg *pb.Message, tx *pb.Transaction) *pb.Response {
+	if tx.Type != pb.Transaction_CHAINCODE_QUERY {
+		logger.Warningf("Rejecting transaction %s, read replicas only serve queries", tx.Uuid)
+		return &pb.Response{Status: pb.Response_FAILURE,
+			Msg: []byte("Error: this peer is a read replica and only serves queries, submit transactions to a validating peer")}
+	}
+	return eng.executeQuery(context.Background(), tx)
+}
+
+// StreamQuery executes a query within ctx against the local world state,
+// passing its result to sink in the chunks the chaincode emits it, if sink is
+// not nil
+func (eng *Engine) StreamQuery(ctx context.Context, tx *pb.Transaction, sink func(chunk []byte) error) *pb.Response {
+	if tx.Type != pb.Transaction_CHAINCODE_QUERY {
+		return eng.ProcessTransactionMsg(nil, tx)
+	}
+	if sink != nil {
+		ctx = chaincode.WithQueryResultSink(ctx, sink)
+	}
+	return eng.executeQuery(ctx, tx)
+}
+
+func (eng *Engine) executeQuery(ctx context.Context, tx *pb.Transaction) *pb.Response {
+	if !eng.isValid() {
+		logger.Warning("Rejecting query because the replica has not caught up with the validator")
+		return &pb.Response{Status: pb.Response_FAILURE,
+			Msg: []byte("Error: state may be inconsistent, cannot query")}
+	}
+
+	// query will ignore events as these are not stored on ledger
+	result, _, err := chaincode.Execute(ctx, chaincode.GetChain(chaincode.DefaultChain), tx)
+	if err != nil {
+		return &pb.Response{Status: pb.Response_FAILURE, Msg: []byte(fmt.Sprintf("Error:%s", err))}
+	}
+	return &pb.Response{Status: pb.Response_SUCCESS, Msg: result}
+}
+
+func (eng *Engine) isValid() bool {
+	eng.lock.RLock()
+	defer eng.lock.RUnlock()
+	return eng.valid
+}
+
+func (eng *Engine) setValid(valid bool) {
+	eng.lock.Lock()
+	defer eng.lock.Unlock()
+	eng.valid = valid
+}
+
+// requestSync asks the sync loop to catch up with the validator. Requests made
+// while a sync is pending are coalesced
+func (eng *Engine) requestSync() {
+	select {
+	case eng.syncChan <- struct{}{}:
+	default:
+	}
+}
+
+func (eng *Engine) syncLoop() {
+	ticker := time.NewTicker(eng.pollInterval)
+	defer ticker.Stop()
+	eng.requestSync()
+	for {
+		select {
+		case <-eng.syncChan:
+		case <-ticker.C:
+		}
+		if err := eng.sync(); err != nil {
+			logger.Errorf("Error syncing with validator %s: %s", eng.validatorAddress, err)
+		}
+	}
+}
+
+// sync moves the local blockchain and world state to the head of the
+// validator. Queries keep being served while blocks are being applied, as the
+// world state is consistent with some block in between every commit
+func (eng *Engine) sync() error {
+	info, err := eng.getValidatorBlockchainInfo()
+	if err != nil {
+		eng.setValid(false)
+		return err
+	}
+	if info.Height == 0 {
+		return nil
+	}
+	if height := eng.coord.GetBlockchainSize(); height >= info.Height {
+		eng.setValid(true)
+		return nil
+	}
+	logger.Debugf("Syncing to block %d of validator %s", info.Height-1, eng.validatorAddress)
+	if err, _ = eng.sts.SyncToTarget(info.Height-1, info.CurrentBlockHash, nil); err != nil {
+		eng.setValid(false)
+		return err
+	}
+	eng.setValid(true)
+	logger.Infof("Read replica synced to block %d", info.Height-1)
+	return nil
+}
+
+func (eng *Engine) getValidatorBlockchainInfo() (*pb.BlockchainInfo, error) {
+	if eng.validatorConn == nil {
+		conn, err := peer.NewPeerClientConnectionWithAddress(eng.validatorAddress)
+		if err != nil {
+			return nil, err
+		}
+		eng.validatorConn = conn
+	}
+	return pb.NewOpenchainClient(eng.validatorConn).GetBlockchainInfo(context.Background(), &google_protobuf.Empty{})
+}
+
+// subscribe registers for the block events of the validator, reconnecting
+// whenever the event stream is lost
+func (eng *Engine) subscribe() {
+	for {
+		disconnected := make(chan struct{})
+		client := consumer.NewEventsClient(eng.eventsAddress, &blockAdapter{eng: eng, disconnected: disconnected})
+		if err := client.Start(); err != nil {
+			logger.Warningf("Could not subscribe to block events at %s, relying on polling: %s", eng.eventsAddress, err)
+			time.Sleep(eng.pollInterval)
+			continue
+		}
+		logger.Infof("Subscribed to block events at %s", eng.eventsAddress)
+		<-disconnected
+		time.Sleep(eng.pollInterval)
+	}
+}
+
+// blockAdapter triggers a sync whenever the validator reports a new block
+type blockAdapter struct {
+	eng          *Engine
+	disconnected chan struct{}
+}
+
+func (a *blockAdapter) GetInterestedEvents() ([]*pb.Interest, error) {
+	return []*pb.Interest{{EventType: pb.EventType_BLOCK}}, nil
+}
+
+func (a *blockAdapter) Recv(msg *pb.Event) (bool, error) {
+	if _, ok := msg.Event.(*pb.Event_Block); ok {
+		a.eng.requestSync()
+	}
+	return true, nil
+}
+
+func (a *blockAdapter) Disconnected(err error) {
+	logger.Warningf("Block event stream from %s disconnected: %v", a.eng.eventsAddress, err)
+	close(a.disconnected)
+}
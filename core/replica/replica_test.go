@@ -0,0 +1,61 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package replica
+
+import (
+	"testing"
+
+	pb "github.com/hyperledger/fabric/protos"
+)
+
+func TestReplicaRejectsTransactions(t *testing.T) {
+	eng := &Engine{syncChan: make(chan struct{}, 1)}
+	for _, txType := range []pb.Transaction_Type{pb.Transaction_CHAINCODE_DEPLOY, pb.Transaction_CHAINCODE_INVOKE} {
+		resp := eng.ProcessTransactionMsg(nil, &pb.Transaction{Type: txType, Uuid: "tx"})
+		if resp.Status != pb.Response_FAILURE {
+			t.Fatalf("Expected %s transaction to be rejected by the replica", txType)
+		}
+	}
+}
+
+func TestReplicaRejectsQueriesUntilSynced(t *testing.T) {
+	eng := &Engine{syncChan: make(chan struct{}, 1)}
+	resp := eng.ProcessTransactionMsg(nil, &pb.Transaction{Type: pb.Transaction_CHAINCODE_QUERY, Uuid: "tx"})
+	if resp.Status != pb.Response_FAILURE {
+		t.Fatal("Expected query to be rejected before the replica has synced")
+	}
+}
+
+func TestReplicaCoalescesSyncRequests(t *testing.T) {
+	eng := &Engine{syncChan: make(chan struct{}, 1)}
+	eng.requestSync()
+	eng.requestSync()
+	if len(eng.syncChan) != 1 {
+		t.Fatalf("Expected a single pending sync request, found %d", len(eng.syncChan))
+	}
+}
+
+func TestReplicaInvalidWhenValidatorUnreachable(t *testing.T) {
+	eng := &Engine{syncChan: make(chan struct{}, 1), validatorAddress: "127.0.0.1:1"}
+	eng.setValid(true)
+	if err := eng.sync(); err == nil {
+		t.Fatal("Expected sync with an unreachable validator to fail")
+	}
+	if eng.isValid() {
+		t.Fatal("Expected the replica to stop serving queries after failing to reach its validator")
+	}
+}
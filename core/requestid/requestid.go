@@ -0,0 +1,181 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package requestid tags every request entering the peer through one of its
+// client APIs with an identifier. The identifier is returned to the client,
+// in the X-Request-Id header of REST responses and in the x-request-id header
+// metadata of gRPC responses, and prefixes the log lines written on behalf of
+// the request, so that a client reporting a failure can point at the exact
+// log lines of its request. A client may choose the identifier itself by
+// sending it along with the request; otherwise the peer generates one.
+package requestid
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+
+	"github.com/op/go-logging"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+const (
+	// HeaderName is the HTTP header carrying the request ID of REST requests
+	HeaderName = "X-Request-Id"
+	// MetadataKey is the gRPC metadata key carrying the request ID
+	MetadataKey = "x-request-id"
+
+	maxLength = 64
+)
+
+type contextKey struct{}
+
+// New generates a new random request ID
+func New() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		panic(fmt.Errorf("Could not generate a request ID: %s", err))
+	}
+	return hex.EncodeToString(b)
+}
+
+// Valid reports whether id is acceptable as a request ID chosen by a client:
+// between 1 and 64 letters, digits, dots, dashes or underscores. Anything
+// else is replaced by a generated ID rather than copied into the logs.
+func Valid(id string) bool {
+	if len(id) == 0 || len(id) > maxLength {
+		return false
+	}
+	for _, c := range id {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case c == '.', c == '-', c == '_':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// NewContext returns a copy of ctx carrying the request ID id
+func NewContext(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, contextKey{}, id)
+}
+
+// FromContext returns the request ID carried by ctx, if any
+func FromContext(ctx context.Context) (string, bool) {
+	if ctx == nil {
+		return "", false
+	}
+	id, ok := ctx.Value(contextKey{}).(string)
+	return id, ok
+}
+
+// Ensure returns ctx if it already carries a request ID, as it does when a
+// REST handler calls into the gRPC services. Otherwise it picks the ID sent
+// by the client in the gRPC metadata, or generates one, returns it to the
+// client in the response header and returns a copy of ctx carrying it. It
+// must be called before the gRPC method sends its response.
+func Ensure(ctx context.Context) context.Context {
+	if _, ok := FromContext(ctx); ok {
+		return ctx
+	}
+	var id string
+	if md, ok := metadata.FromContext(ctx); ok {
+		if ids := md[MetadataKey]; len(ids) > 0 && Valid(ids[0]) {
+			id = ids[0]
+		}
+	}
+	if id == "" {
+		id = New()
+	}
+	// Fails outside of a gRPC server stream, where there is no one to tell
+	grpc.SendHeader(ctx, metadata.Pairs(MetadataKey, id))
+	return NewContext(ctx, id)
+}
+
+// Logger writes to a go-logging logger, prefixing every line with the ID of
+// a request.
+type Logger struct {
+	logger *logging.Logger
+	prefix string
+}
+
+// Log returns a Logger writing to logger on behalf of the request of ctx. If
+// ctx carries no request ID, the lines are written unprefixed.
+func Log(ctx context.Context, logger *logging.Logger) *Logger {
+	l := &Logger{logger: logging.MustGetLogger(logger.Module)}
+	l.logger.ExtraCalldepth = logger.ExtraCalldepth + 1
+	if id, ok := FromContext(ctx); ok {
+		l.prefix = "[" + id + "] "
+	}
+	return l
+}
+
+// IsEnabledFor returns true if the underlying logger is enabled for level
+func (l *Logger) IsEnabledFor(level logging.Level) bool {
+	return l.logger.IsEnabledFor(level)
+}
+
+// Debug logs a message using DEBUG as log level
+func (l *Logger) Debug(args ...interface{}) {
+	if l.logger.IsEnabledFor(logging.DEBUG) {
+		l.logger.Debugf("%s%s", l.prefix, fmt.Sprint(args...))
+	}
+}
+
+// Debugf logs a message using DEBUG as log level
+func (l *Logger) Debugf(format string, args ...interface{}) {
+	l.logger.Debugf(l.prefix+format, args...)
+}
+
+// Info logs a message using INFO as log level
+func (l *Logger) Info(args ...interface{}) {
+	if l.logger.IsEnabledFor(logging.INFO) {
+		l.logger.Infof("%s%s", l.prefix, fmt.Sprint(args...))
+	}
+}
+
+// Infof logs a message using INFO as log level
+func (l *Logger) Infof(format string, args ...interface{}) {
+	l.logger.Infof(l.prefix+format, args...)
+}
+
+// Warning logs a message using WARNING as log level
+func (l *Logger) Warning(args ...interface{}) {
+	if l.logger.IsEnabledFor(logging.WARNING) {
+		l.logger.Warningf("%s%s", l.prefix, fmt.Sprint(args...))
+	}
+}
+
+// Warningf logs a message using WARNING as log level
+func (l *Logger) Warningf(format string, args ...interface{}) {
+	l.logger.Warningf(l.prefix+format, args...)
+}
+
+// Error logs a message using ERROR as log level
+func (l *Logger) Error(args ...interface{}) {
+	if l.logger.IsEnabledFor(logging.ERROR) {
+		l.logger.Errorf("%s%s", l.prefix, fmt.Sprint(args...))
+	}
+}
+
+// Errorf logs a message using ERROR as log level
+func (l *Logger) Errorf(format string, args ...interface{}) {
+	l.logger.Errorf(l.prefix+format, args...)
+}
@@ -0,0 +1,59 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package requestid
+
+import (
+	"strings"
+	"testing"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/metadata"
+)
+
+func TestValid(t *testing.T) {
+	for _, id := range []string{"a", "0123abcd", "client-1.req_2", strings.Repeat("x", 64), New()} {
+		if !Valid(id) {
+			t.Errorf("Expected %q to be a valid request ID", id)
+		}
+	}
+	for _, id := range []string{"", strings.Repeat("x", 65), "with space", "new\nline", "100%s"} {
+		if Valid(id) {
+			t.Errorf("Expected %q to be an invalid request ID", id)
+		}
+	}
+}
+
+func TestEnsure(t *testing.T) {
+	ctx := Ensure(context.Background())
+	id, ok := FromContext(ctx)
+	if !ok || !Valid(id) {
+		t.Fatalf("Expected a generated request ID, got %q", id)
+	}
+	if again, _ := FromContext(Ensure(ctx)); again != id {
+		t.Fatalf("Expected the request ID %q to be kept, got %q", id, again)
+	}
+
+	ctx = metadata.NewContext(context.Background(), metadata.Pairs(MetadataKey, "from-client"))
+	if id, _ := FromContext(Ensure(ctx)); id != "from-client" {
+		t.Fatalf("Expected the request ID of the client, got %q", id)
+	}
+
+	ctx = metadata.NewContext(context.Background(), metadata.Pairs(MetadataKey, "not valid"))
+	if id, _ := FromContext(Ensure(ctx)); id == "not valid" || !Valid(id) {
+		t.Fatalf("Expected an invalid request ID to be replaced, got %q", id)
+	}
+}
@@ -26,12 +26,16 @@ import (
 
 	"github.com/golang/protobuf/proto"
 	"github.com/hyperledger/fabric/core/ledger"
+	"github.com/hyperledger/fabric/core/ledger/inspect"
+	"github.com/hyperledger/fabric/core/rejections"
 	pb "github.com/hyperledger/fabric/protos"
 )
 
 var (
 	// ErrNotFound is returned if a requested resource does not exist
 	ErrNotFound = errors.New("openchain: resource not found")
+	// ErrInvalidTagCursor is returned if the cursor of a tag search is malformed
+	ErrInvalidTagCursor = errors.New("openchain: invalid tag cursor")
 )
 
 // PeerInfo defines API to peer info data
@@ -86,38 +90,87 @@ func (s *ServerOpenchain) GetBlockchainInfo(ctx context.Context, e *google_proto
 // GetBlockByNumber returns the data contained within a specific block in the
 // blockchain. The genesis block is block zero.
 func (s *ServerOpenchain) GetBlockByNumber(ctx context.Context, num *pb.BlockNumber) (*pb.Block, error) {
-	block, err := s.ledger.GetBlockByNumber(num.Number)
+	block, _, err := s.getBlockByNumber(num.Number)
+	return block, err
+}
+
+// getBlockByNumber returns the block with the given number along with its hash,
+// which is computed before the deploy payloads are removed from the block.
+func (s *ServerOpenchain) getBlockByNumber(number uint64) (*pb.Block, []byte, error) {
+	block, err := s.ledger.GetBlockByNumber(number)
 	if err != nil {
 		switch err {
 		case ledger.ErrOutOfBounds:
+			return nil, nil, ErrNotFound
+		default:
+			return nil, nil, fmt.Errorf("Error retrieving block from blockchain: %s", err)
+		}
+	}
+
+	blockHash, err := block.GetHash()
+	if err != nil {
+		return nil, nil, fmt.Errorf("Error computing block hash: %s", err)
+	}
+	if err = trimDeployPayloads(block); err != nil {
+		return nil, nil, err
+	}
+
+	return block, blockHash, nil
+}
+
+// renderBlock returns the decoded rendering of the block with the given number
+// and hash, along with the changes it made to the state if the peer still
+// keeps them.
+func (s *ServerOpenchain) renderBlock(number uint64, block *pb.Block, blockHash []byte) (map[string]interface{}, error) {
+	delta, err := s.ledger.GetStateDelta(number)
+	if err != nil {
+		return nil, fmt.Errorf("Error retrieving state delta of block %d: %s", number, err)
+	}
+	return inspect.NumberedBlock(number, block, blockHash, delta), nil
+}
+
+// GetBlockByHash returns the data contained within the block with the given
+// hash.
+func (s *ServerOpenchain) GetBlockByHash(ctx context.Context, blockHash []byte) (*pb.Block, error) {
+	block, err := s.ledger.GetBlockByHash(blockHash)
+	if err != nil {
+		switch err {
+		case ledger.ErrResourceNotFound:
 			return nil, ErrNotFound
 		default:
 			return nil, fmt.Errorf("Error retrieving block from blockchain: %s", err)
 		}
 	}
 
-	// Remove payload from deploy transactions. This is done to make rest api
-	// calls more lightweight as the payload for these types of transactions
-	// can be very large. If the payload is needed, the caller should fetch the
-	// individual transaction.
+	if err = trimDeployPayloads(block); err != nil {
+		return nil, err
+	}
+
+	return block, nil
+}
+
+// trimDeployPayloads removes the code package from deploy transactions. This is
+// done to make rest api calls more lightweight as the payload for these types
+// of transactions can be very large. If the payload is needed, the caller should
+// fetch the individual transaction.
+func trimDeployPayloads(block *pb.Block) error {
 	blockTransactions := block.GetTransactions()
 	for _, transaction := range blockTransactions {
 		if transaction.Type == pb.Transaction_CHAINCODE_DEPLOY {
 			deploymentSpec := &pb.ChaincodeDeploymentSpec{}
 			err := proto.Unmarshal(transaction.Payload, deploymentSpec)
 			if err != nil {
-				return nil, err
+				return err
 			}
 			deploymentSpec.CodePackage = nil
 			deploymentSpecBytes, err := proto.Marshal(deploymentSpec)
 			if err != nil {
-				return nil, err
+				return err
 			}
 			transaction.Payload = deploymentSpecBytes
 		}
 	}
-
-	return block, nil
+	return nil
 }
 
 // GetBlockCount returns the current number of blocks in the blockchain data
@@ -137,6 +190,24 @@ func (s *ServerOpenchain) GetBlockCount(ctx context.Context, e *google_protobuf.
 	return nil, fmt.Errorf("No blocks in blockchain.")
 }
 
+// GetLedgerStatistics returns the key counts, state size and block size distribution of the ledger
+func (s *ServerOpenchain) GetLedgerStatistics(ctx context.Context, e *google_protobuf.Empty) (*pb.LedgerStatistics, error) {
+	return s.ledger.GetStatistics()
+}
+
+// GetChainTime returns the consensus time of the blockchain, that is the
+// timestamp of its latest block.
+func (s *ServerOpenchain) GetChainTime(ctx context.Context, e *google_protobuf.Empty) (*pb.ChainTime, error) {
+	chainTime, err := s.ledger.GetChainTime()
+	if err != nil {
+		return nil, fmt.Errorf("Error retrieving the time of the blockchain: %s", err)
+	}
+	if chainTime.Timestamp == nil {
+		return nil, ErrNotFound
+	}
+	return chainTime, nil
+}
+
 // GetState returns the value for a particular chaincode ID and key
 func (s *ServerOpenchain) GetState(ctx context.Context, chaincodeID, key string) ([]byte, error) {
 	return s.ledger.GetState(chaincodeID, key, true)
@@ -156,6 +227,31 @@ func (s *ServerOpenchain) GetTransactionByUUID(ctx context.Context, txUUID strin
 	return transaction, nil
 }
 
+// GetTransactionsByTag returns a page of the transactions tagged with key and
+// value, starting at cursor
+func (s *ServerOpenchain) GetTransactionsByTag(ctx context.Context, key, value, cursor string, limit int) (*ledger.TaggedTransactions, error) {
+	if _, err := ledger.ParseTagCursor(cursor); err != nil {
+		return nil, ErrInvalidTagCursor
+	}
+	page, err := s.ledger.GetTransactionsByTag(key, value, cursor, limit)
+	if err != nil {
+		return nil, fmt.Errorf("Error retrieving transactions by tag: %s", err)
+	}
+	return page, nil
+}
+
+// GetTransactionRejection returns why the peer rejected the transaction matching the specified UUID
+func (s *ServerOpenchain) GetTransactionRejection(ctx context.Context, txUUID string) (*pb.RejectedTransaction, error) {
+	rejected, err := rejections.Get(txUUID)
+	if err != nil {
+		return nil, fmt.Errorf("Error retrieving the rejection of the transaction: %s", err)
+	}
+	if rejected == nil {
+		return nil, ErrNotFound
+	}
+	return rejected, nil
+}
+
 // GetPeers returns a list of all peer nodes currently connected to the target peer.
 func (s *ServerOpenchain) GetPeers(ctx context.Context, e *google_protobuf.Empty) (*pb.PeersMessage, error) {
 	return s.peerInfo.GetPeers()
@@ -0,0 +1,164 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rest
+
+import (
+	"encoding/json"
+	"fmt"
+	"google/protobuf"
+	"net/http"
+	"strconv"
+
+	"github.com/gocraft/web"
+	"github.com/spf13/viper"
+
+	"github.com/hyperledger/fabric/core/ledger/inspect"
+)
+
+// A request for a range of blocks is charged for the blocks it scans and the
+// bytes of JSON it serializes. Once either would exceed its limit, set by
+// rest.query.maxBlocks and rest.query.maxBytes, the response stops short of
+// the end of the range and carries the number of the next block to request,
+// so that a client walks a long range in several bounded requests instead of
+// holding the peer busy with a single unbounded one. The first block of the
+// range is always returned, so that every request makes progress.
+const (
+	defaultQueryMaxBlocks = 100
+	defaultQueryMaxBytes  = 4 * 1024 * 1024
+)
+
+// queryCost accounts for the cost of a request
+type queryCost struct {
+	Blocks    int `json:"blocks"`
+	Bytes     int `json:"bytes"`
+	maxBlocks int
+	maxBytes  int
+}
+
+func newQueryCost() *queryCost {
+	c := &queryCost{
+		maxBlocks: viper.GetInt("rest.query.maxBlocks"),
+		maxBytes:  viper.GetInt("rest.query.maxBytes"),
+	}
+	if c.maxBlocks <= 0 {
+		c.maxBlocks = defaultQueryMaxBlocks
+	}
+	if c.maxBytes <= 0 {
+		c.maxBytes = defaultQueryMaxBytes
+	}
+	return c
+}
+
+// exhausted returns whether a further block can not be scanned
+func (c *queryCost) exhausted() bool {
+	return c.Blocks >= c.maxBlocks
+}
+
+// charge records the scan of a block serialized to size bytes and returns
+// whether it fits in the limit of bytes.
+func (c *queryCost) charge(size int) bool {
+	c.Blocks++
+	if c.Bytes > 0 && c.Bytes+size > c.maxBytes {
+		return false
+	}
+	c.Bytes += size
+	return true
+}
+
+// blockRange is the response to a request for a range of blocks
+type blockRange struct {
+	Blocks []json.RawMessage `json:"blocks"`
+	Next   uint64            `json:"next,omitempty"`
+	Cost   *queryCost        `json:"cost"`
+}
+
+// GetBlocks returns the blocks from the start to the end query parameters,
+// both included. The end defaults to the latest block. When the cost of the
+// request reaches its limits, the response holds the first blocks of the range
+// only, and its next field is the start of the request for the rest.
+func (s *ServerOpenchainREST) GetBlocks(rw web.ResponseWriter, req *web.Request) {
+	query := req.URL.Query()
+	start, err := strconv.ParseUint(query.Get("start"), 10, 64)
+	if err != nil {
+		rw.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(rw, "{\"Error\": \"Start must be an integer (uint64).\"}")
+		return
+	}
+
+	info, err := s.server.GetBlockchainInfo(req.Context(), &google_protobuf.Empty{})
+	if err != nil {
+		rw.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprintf(rw, "{\"Error\": \"%s\"}", err)
+		s.log().Errorf("{\"Error\": \"Error retrieving the height of the blockchain: %s\"}", err)
+		return
+	}
+	if start >= info.Height {
+		rw.WriteHeader(http.StatusNotFound)
+		fmt.Fprintf(rw, "{\"Error\": \"Start is beyond the latest block %d.\"}", info.Height-1)
+		return
+	}
+	end := info.Height - 1
+	if e := query.Get("end"); e != "" {
+		n, err := strconv.ParseUint(e, 10, 64)
+		if err != nil || n < start {
+			rw.WriteHeader(http.StatusBadRequest)
+			fmt.Fprintf(rw, "{\"Error\": \"End must be an integer (uint64) no less than start.\"}")
+			return
+		}
+		if n < end {
+			end = n
+		}
+	}
+
+	decode := decodeRequested(req.Request)
+	cost := newQueryCost()
+	result := blockRange{Blocks: []json.RawMessage{}, Cost: cost}
+	for number := start; number <= end; number++ {
+		if cost.exhausted() || req.Context().Err() != nil {
+			result.Next = number
+			break
+		}
+		block, blockHash, err := s.server.getBlockByNumber(number)
+		if err == nil && block == nil {
+			err = ErrNotFound
+		}
+		var raw []byte
+		if err == nil && decode {
+			var rendered map[string]interface{}
+			if rendered, err = s.server.renderBlock(number, block, blockHash); err == nil {
+				raw, err = inspect.Marshal(rendered)
+			}
+		} else if err == nil {
+			raw, err = json.Marshal(block)
+		}
+		if err != nil {
+			rw.WriteHeader(http.StatusInternalServerError)
+			fmt.Fprintf(rw, "{\"Error\": \"%s\"}", err)
+			s.log().Errorf("{\"Error\": \"Error retrieving block %d: %s\"}", number, err)
+			return
+		}
+		if !cost.charge(len(raw)) {
+			result.Next = number
+			break
+		}
+		result.Blocks = append(result.Blocks, raw)
+	}
+
+	rw.WriteHeader(http.StatusOK)
+	json.NewEncoder(rw).Encode(result)
+	s.log().Infof("Successfully retrieved %d blocks from %d, scanning %d blocks", len(result.Blocks), start, cost.Blocks)
+}
@@ -0,0 +1,120 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rest
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/gocraft/web"
+	"github.com/hyperledger/fabric/core/ledger"
+	"github.com/spf13/viper"
+)
+
+func TestServerOpenchainREST_GetBlocks(t *testing.T) {
+	ledger1 := ledger.InitTestLedger(t)
+	buildTestLedger1(ledger1, t)
+	server, err := NewOpenchainServerWithPeerInfo(new(peerInfo))
+	if err != nil {
+		t.Fatalf("Error creating OpenchainServer: %s", err)
+	}
+	server.ledger = ledger1
+	height := ledger1.GetBlockchainSize()
+
+	router := web.New(ServerOpenchainREST{})
+	router.Middleware(func(s *ServerOpenchainREST, rw web.ResponseWriter, req *web.Request, next web.NextMiddlewareFunc) {
+		s.server = server
+		next(rw, req)
+	})
+	router.Get("/chain/blocks", (*ServerOpenchainREST).GetBlocks)
+
+	get := func(query string) (int, *struct {
+		Blocks []json.RawMessage
+		Next   uint64
+		Cost   struct{ Blocks, Bytes int }
+	}) {
+		recorder := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/chain/blocks?"+query, nil)
+		router.ServeHTTP(recorder, req)
+		if recorder.Code != http.StatusOK {
+			return recorder.Code, nil
+		}
+		result := &struct {
+			Blocks []json.RawMessage
+			Next   uint64
+			Cost   struct{ Blocks, Bytes int }
+		}{}
+		if err := json.Unmarshal(recorder.Body.Bytes(), result); err != nil {
+			t.Fatalf("Error unmarshalling the response: %s", err)
+		}
+		return recorder.Code, result
+	}
+
+	defer viper.Set("rest.query.maxBlocks", viper.GetInt("rest.query.maxBlocks"))
+	defer viper.Set("rest.query.maxBytes", viper.GetInt("rest.query.maxBytes"))
+	viper.Set("rest.query.maxBlocks", 0)
+	viper.Set("rest.query.maxBytes", 0)
+
+	code, result := get("start=0")
+	if code != http.StatusOK || uint64(len(result.Blocks)) != height || result.Next != 0 {
+		t.Fatalf("Expected the %d blocks of the chain, got status %d and %v", height, code, result)
+	}
+	if result.Cost.Blocks != int(height) || result.Cost.Bytes == 0 {
+		t.Fatalf("Expected the cost of %d blocks, got %v", height, result.Cost)
+	}
+
+	// Walk the chain two blocks at a time
+	viper.Set("rest.query.maxBlocks", 2)
+	var walked uint64
+	for start := "0"; ; {
+		_, result = get("start=" + start)
+		if len(result.Blocks) > 2 {
+			t.Fatalf("Expected at most 2 blocks, got %d", len(result.Blocks))
+		}
+		walked += uint64(len(result.Blocks))
+		if result.Next == 0 {
+			break
+		}
+		if result.Next != walked {
+			t.Fatalf("Expected the next block to be %d, got %d", walked, result.Next)
+		}
+		start = strconv.FormatUint(result.Next, 10)
+	}
+	if walked != height {
+		t.Fatalf("Expected to walk %d blocks, walked %d", height, walked)
+	}
+
+	// The first block is returned even when it exceeds the limit of bytes
+	viper.Set("rest.query.maxBytes", 1)
+	if _, result = get("start=1&end=2"); len(result.Blocks) != 1 || result.Next != 2 {
+		t.Fatalf("Expected the first block only, got %d blocks and next %d", len(result.Blocks), result.Next)
+	}
+
+	for query, expected := range map[string]int{
+		"":                 http.StatusBadRequest,
+		"start=x":          http.StatusBadRequest,
+		"start=2&end=1":    http.StatusBadRequest,
+		"start=1000000000": http.StatusNotFound,
+	} {
+		if code, _ := get(query); code != expected {
+			t.Fatalf("Expected status %d for %q, got %d", expected, query, code)
+		}
+	}
+}
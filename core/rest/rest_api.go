@@ -17,6 +17,7 @@ limitations under the License.
 package rest
 
 import (
+	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -28,6 +29,7 @@ import (
 	"os"
 	"strconv"
 	"strings"
+	"time"
 
 	"golang.org/x/net/context"
 
@@ -41,6 +43,8 @@ import (
 	"github.com/hyperledger/fabric/core/comm"
 	"github.com/hyperledger/fabric/core/crypto"
 	"github.com/hyperledger/fabric/core/crypto/primitives"
+	"github.com/hyperledger/fabric/core/ledger/inspect"
+	"github.com/hyperledger/fabric/core/requestid"
 	pb "github.com/hyperledger/fabric/protos"
 )
 
@@ -59,6 +63,7 @@ var serverDevops *core.Devops
 type ServerOpenchainREST struct {
 	server *ServerOpenchain
 	devops *core.Devops
+	reqLog *requestid.Logger
 }
 
 // restResult defines the response payload for a general REST interface request.
@@ -75,6 +80,14 @@ type rpcRequest struct {
 	ID      *rpcID            `json:"id,omitempty"`
 }
 
+// rpcBatchRequest defines the request payload for the /chaincode/batch endpoint.
+// If FailOnFirstError is set, no request in the batch is submitted unless all
+// of them pass validation.
+type rpcBatchRequest struct {
+	Requests         []*rpcRequest `json:"requests"`
+	FailOnFirstError bool          `json:"failOnFirstError,omitempty"`
+}
+
 type rpcID struct {
 	StringValue *string
 	IntValue    *int64
@@ -118,6 +131,10 @@ type rpcResult struct {
 	Status  string    `json:"status,omitempty"`
 	Message string    `json:"message,omitempty"`
 	Error   *rpcError `json:"error,omitempty"`
+	// For queries, the block height and base64 state hash of the peer when
+	// it answered
+	Height    uint64 `json:"height,omitempty"`
+	StateHash string `json:"stateHash,omitempty"`
 }
 
 // rpcError defines the structure for an rpc error.
@@ -147,6 +164,7 @@ var (
 	ChaincodeDeployError     = &rpcError{Code: -32001, Message: "Deployment failure", Data: "Chaincode deployment has failed."}
 	ChaincodeInvokeError     = &rpcError{Code: -32002, Message: "Invocation failure", Data: "Chaincode invocation has failed."}
 	ChaincodeQueryError      = &rpcError{Code: -32003, Message: "Query failure", Data: "Chaincode query has failed."}
+	BatchAbortedError        = &rpcError{Code: -32004, Message: "Batch aborted", Data: "Request was not submitted because another request in the batch failed validation."}
 )
 
 // SetOpenchainServer is a middleware function that sets the pointer to the
@@ -158,6 +176,33 @@ func (s *ServerOpenchainREST) SetOpenchainServer(rw web.ResponseWriter, req *web
 	next(rw, req)
 }
 
+// SetRequestID is a middleware function that tags the request with a request
+// ID, either the one sent by the client in the X-Request-Id header or a newly
+// generated one. The ID is returned in the X-Request-Id header of the response,
+// carried by the context of the request down to the Devops service and
+// prefixes every log line written while serving the request.
+func (s *ServerOpenchainREST) SetRequestID(rw web.ResponseWriter, req *web.Request, next web.NextMiddlewareFunc) {
+	id := req.Header.Get(requestid.HeaderName)
+	if !requestid.Valid(id) {
+		id = requestid.New()
+	}
+	rw.Header().Set(requestid.HeaderName, id)
+	req.Request = req.WithContext(requestid.NewContext(req.Context(), id))
+	s.reqLog = requestid.Log(req.Context(), restLogger)
+
+	start := time.Now()
+	next(rw, req)
+	s.log().Debugf("%s %s: %d in %s", req.Method, req.URL.Path, rw.StatusCode(), time.Since(start))
+}
+
+// log returns the logger of the request being served.
+func (s *ServerOpenchainREST) log() *requestid.Logger {
+	if s.reqLog == nil {
+		s.reqLog = requestid.Log(context.Background(), restLogger)
+	}
+	return s.reqLog
+}
+
 // SetResponseType is a middleware function that sets the appropriate response
 // headers. Currently, it is setting the "Content-Type" to "application/json" as
 // well as the necessary headers in order to enable CORS for Swagger usage.
@@ -166,7 +211,8 @@ func (s *ServerOpenchainREST) SetResponseType(rw web.ResponseWriter, req *web.Re
 
 	// Enable CORS
 	rw.Header().Set("Access-Control-Allow-Origin", "*")
-	rw.Header().Set("Access-Control-Allow-Headers", "accept, content-type")
+	rw.Header().Set("Access-Control-Allow-Headers", "accept, content-type, "+requestid.HeaderName)
+	rw.Header().Set("Access-Control-Expose-Headers", requestid.HeaderName)
 
 	next(rw, req)
 }
@@ -185,7 +231,7 @@ func getRESTFilePath() string {
 // Register confirms the enrollmentID and secret password of the client with the
 // CA and stores the enrollment certificate and key in the Devops server.
 func (s *ServerOpenchainREST) Register(rw web.ResponseWriter, req *web.Request) {
-	restLogger.Info("REST client login...")
+	s.log().Info("REST client login...")
 
 	// Decode the incoming JSON payload
 	var loginSpec pb.Secret
@@ -202,11 +248,11 @@ func (s *ServerOpenchainREST) Register(rw web.ResponseWriter, req *web.Request)
 		if err == io.EOF {
 			rw.WriteHeader(http.StatusBadRequest)
 			fmt.Fprintf(rw, "{\"Error\": \"Payload must contain object Secret with enrollId and enrollSecret fields.\"}")
-			restLogger.Error("{\"Error\": \"Payload must contain object Secret with enrollId and enrollSecret fields.\"}")
+			s.log().Error("{\"Error\": \"Payload must contain object Secret with enrollId and enrollSecret fields.\"}")
 		} else {
 			rw.WriteHeader(http.StatusBadRequest)
 			fmt.Fprintf(rw, "{\"Error\": \"%s\"}", errVal)
-			restLogger.Errorf("{\"Error\": \"%s\"}", errVal)
+			s.log().Errorf("{\"Error\": \"%s\"}", errVal)
 		}
 
 		return
@@ -216,7 +262,7 @@ func (s *ServerOpenchainREST) Register(rw web.ResponseWriter, req *web.Request)
 	if (loginSpec.EnrollId == "") || (loginSpec.EnrollSecret == "") {
 		rw.WriteHeader(http.StatusBadRequest)
 		fmt.Fprintf(rw, "{\"Error\": \"enrollId and enrollSecret may not be blank.\"}")
-		restLogger.Error("{\"Error\": \"enrollId and enrollSecret may not be blank.\"}")
+		s.log().Error("{\"Error\": \"enrollId and enrollSecret may not be blank.\"}")
 
 		return
 	}
@@ -224,21 +270,21 @@ func (s *ServerOpenchainREST) Register(rw web.ResponseWriter, req *web.Request)
 	// Retrieve the REST data storage path
 	// Returns /var/hyperledger/production/client/
 	localStore := getRESTFilePath()
-	restLogger.Infof("Local data store for client loginToken: %s", localStore)
+	s.log().Infof("Local data store for client loginToken: %s", localStore)
 
 	// If the user is already logged in, return
 	if _, err := os.Stat(localStore + "loginToken_" + loginSpec.EnrollId); err == nil {
 		rw.WriteHeader(http.StatusOK)
 		fmt.Fprintf(rw, "{\"OK\": \"User %s is already logged in.\"}", loginSpec.EnrollId)
-		restLogger.Infof("User '%s' is already logged in.\n", loginSpec.EnrollId)
+		s.log().Infof("User '%s' is already logged in.\n", loginSpec.EnrollId)
 
 		return
 	}
 
 	// User is not logged in, proceed with login
-	restLogger.Infof("Logging in user '%s' on REST interface...\n", loginSpec.EnrollId)
+	s.log().Infof("Logging in user '%s' on REST interface...\n", loginSpec.EnrollId)
 
-	loginResult, err := s.devops.Login(context.Background(), &loginSpec)
+	loginResult, err := s.devops.Login(req.Context(), &loginSpec)
 
 	// Check if login is successful
 	if loginResult.Status == pb.Response_SUCCESS {
@@ -260,7 +306,7 @@ func (s *ServerOpenchainREST) Register(rw web.ResponseWriter, req *web.Request)
 		}
 
 		// Store client security context into a file
-		restLogger.Infof("Storing login token for user '%s'.\n", loginSpec.EnrollId)
+		s.log().Infof("Storing login token for user '%s'.\n", loginSpec.EnrollId)
 		err = ioutil.WriteFile(localStore+"loginToken_"+loginSpec.EnrollId, []byte(loginSpec.EnrollId), 0755)
 		if err != nil {
 			rw.WriteHeader(http.StatusInternalServerError)
@@ -270,13 +316,13 @@ func (s *ServerOpenchainREST) Register(rw web.ResponseWriter, req *web.Request)
 
 		rw.WriteHeader(http.StatusOK)
 		fmt.Fprintf(rw, "{\"OK\": \"Login successful for user '%s'.\"}", loginSpec.EnrollId)
-		restLogger.Infof("Login successful for user '%s'.\n", loginSpec.EnrollId)
+		s.log().Infof("Login successful for user '%s'.\n", loginSpec.EnrollId)
 	} else {
 		loginErr := strings.Replace(string(loginResult.Msg), "\"", "'", -1)
 
 		rw.WriteHeader(http.StatusUnauthorized)
 		fmt.Fprintf(rw, "{\"Error\": \"%s\"}", loginErr)
-		restLogger.Errorf("Error on client login: %s", loginErr)
+		s.log().Errorf("Error on client login: %s", loginErr)
 	}
 
 	return
@@ -296,11 +342,11 @@ func (s *ServerOpenchainREST) GetEnrollmentID(rw web.ResponseWriter, req *web.Re
 	if _, err := os.Stat(localStore + "loginToken_" + enrollmentID); err == nil {
 		rw.WriteHeader(http.StatusOK)
 		fmt.Fprintf(rw, "{\"OK\": \"User %s is already logged in.\"}", enrollmentID)
-		restLogger.Infof("User '%s' is already logged in.\n", enrollmentID)
+		s.log().Infof("User '%s' is already logged in.\n", enrollmentID)
 	} else {
 		rw.WriteHeader(http.StatusUnauthorized)
 		fmt.Fprintf(rw, "{\"Error\": \"User %s must log in.\"}", enrollmentID)
-		restLogger.Infof("User '%s' must log in.\n", enrollmentID)
+		s.log().Infof("User '%s' must log in.\n", enrollmentID)
 	}
 
 	return
@@ -333,7 +379,7 @@ func (s *ServerOpenchainREST) DeleteEnrollmentID(rw web.ResponseWriter, req *web
 	if os.IsNotExist(err1) && os.IsNotExist(err2) {
 		rw.WriteHeader(http.StatusOK)
 		fmt.Fprintf(rw, "{\"OK\": \"User %s is not logged in.\"}", enrollmentID)
-		restLogger.Infof("User '%s' is not logged in.\n", enrollmentID)
+		s.log().Infof("User '%s' is not logged in.\n", enrollmentID)
 
 		return
 	}
@@ -342,7 +388,7 @@ func (s *ServerOpenchainREST) DeleteEnrollmentID(rw web.ResponseWriter, req *web
 	if err := os.RemoveAll(loginTok); err != nil {
 		rw.WriteHeader(http.StatusInternalServerError)
 		fmt.Fprintf(rw, "{\"Error\": \"Error trying to delete login token for user %s: %s\"}", enrollmentID, err)
-		restLogger.Errorf("{\"Error\": \"Error trying to delete login token for user %s: %s\"}", enrollmentID, err)
+		s.log().Errorf("{\"Error\": \"Error trying to delete login token for user %s: %s\"}", enrollmentID, err)
 
 		return
 	}
@@ -351,14 +397,14 @@ func (s *ServerOpenchainREST) DeleteEnrollmentID(rw web.ResponseWriter, req *web
 	if err := os.RemoveAll(cryptoDir); err != nil {
 		rw.WriteHeader(http.StatusInternalServerError)
 		fmt.Fprintf(rw, "{\"Error\": \"Error trying to delete login directory for user %s: %s\"}", enrollmentID, err)
-		restLogger.Errorf("{\"Error\": \"Error trying to delete login directory for user %s: %s\"}", enrollmentID, err)
+		s.log().Errorf("{\"Error\": \"Error trying to delete login directory for user %s: %s\"}", enrollmentID, err)
 
 		return
 	}
 
 	rw.WriteHeader(http.StatusOK)
 	fmt.Fprintf(rw, "{\"OK\": \"Deleted login token and directory for user %s.\"}", enrollmentID)
-	restLogger.Infof("Deleted login token and directory for user %s.\n", enrollmentID)
+	s.log().Infof("Deleted login token and directory for user %s.\n", enrollmentID)
 
 	return
 }
@@ -368,12 +414,12 @@ func (s *ServerOpenchainREST) GetEnrollmentCert(rw web.ResponseWriter, req *web.
 	// Parse out the user enrollment ID
 	enrollmentID := req.PathParams["id"]
 
-	restLogger.Debugf("REST received enrollment certificate retrieval request for registrationID '%s'", enrollmentID)
+	s.log().Debugf("REST received enrollment certificate retrieval request for registrationID '%s'", enrollmentID)
 
 	// If security is enabled, initialize the crypto client
 	if core.SecurityEnabled() {
-		if restLogger.IsEnabledFor(logging.DEBUG) {
-			restLogger.Debugf("Initializing secure client using context '%s'", enrollmentID)
+		if s.log().IsEnabledFor(logging.DEBUG) {
+			s.log().Debugf("Initializing secure client using context '%s'", enrollmentID)
 		}
 
 		// Initialize the security client
@@ -381,7 +427,7 @@ func (s *ServerOpenchainREST) GetEnrollmentCert(rw web.ResponseWriter, req *web.
 		if err != nil {
 			rw.WriteHeader(http.StatusBadRequest)
 			fmt.Fprintf(rw, "{\"Error\": \"%s\"}", err)
-			restLogger.Errorf("{\"Error\": \"%s\"}", err)
+			s.log().Errorf("{\"Error\": \"%s\"}", err)
 
 			return
 		}
@@ -391,7 +437,7 @@ func (s *ServerOpenchainREST) GetEnrollmentCert(rw web.ResponseWriter, req *web.
 		if err != nil {
 			rw.WriteHeader(http.StatusInternalServerError)
 			fmt.Fprintf(rw, "{\"Error\": \"%s\"}", err)
-			restLogger.Errorf("{\"Error\": \"%s\"}", err)
+			s.log().Errorf("{\"Error\": \"%s\"}", err)
 
 			return
 		}
@@ -400,7 +446,7 @@ func (s *ServerOpenchainREST) GetEnrollmentCert(rw web.ResponseWriter, req *web.
 		if handler == nil {
 			rw.WriteHeader(http.StatusInternalServerError)
 			fmt.Fprintf(rw, "{\"Error\": \"Error retrieving certificate handler.\"}")
-			restLogger.Error("{\"Error\": \"Error retrieving certificate handler.\"}")
+			s.log().Error("{\"Error\": \"Error retrieving certificate handler.\"}")
 
 			return
 		}
@@ -412,7 +458,7 @@ func (s *ServerOpenchainREST) GetEnrollmentCert(rw web.ResponseWriter, req *web.
 		if certDER == nil {
 			rw.WriteHeader(http.StatusInternalServerError)
 			fmt.Fprintf(rw, "{\"Error\": \"Enrollment certificate is nil.\"}")
-			restLogger.Error("{\"Error\": \"Enrollment certificate is nil.\"}")
+			s.log().Error("{\"Error\": \"Enrollment certificate is nil.\"}")
 
 			return
 		}
@@ -421,7 +467,7 @@ func (s *ServerOpenchainREST) GetEnrollmentCert(rw web.ResponseWriter, req *web.
 		if len(certDER) == 0 {
 			rw.WriteHeader(http.StatusInternalServerError)
 			fmt.Fprintf(rw, "{\"Error\": \"Enrollment certificate length is 0.\"}")
-			restLogger.Error("{\"Error\": \"Enrollment certificate length is 0.\"}")
+			s.log().Error("{\"Error\": \"Enrollment certificate length is 0.\"}")
 
 			return
 		}
@@ -437,12 +483,12 @@ func (s *ServerOpenchainREST) GetEnrollmentCert(rw web.ResponseWriter, req *web.
 
 		rw.WriteHeader(http.StatusOK)
 		fmt.Fprintf(rw, "{\"OK\": \"%s\"}", urlEncodedCert)
-		restLogger.Debugf("Successfully retrieved enrollment certificate for secure context '%s'", enrollmentID)
+		s.log().Debugf("Successfully retrieved enrollment certificate for secure context '%s'", enrollmentID)
 	} else {
 		// Security must be enabled to request enrollment certificates
 		rw.WriteHeader(http.StatusBadRequest)
 		fmt.Fprintf(rw, "{\"Error\": \"Security functionality must be enabled before requesting client certificates.\"}")
-		restLogger.Error("{\"Error\": \"Security functionality must be enabled before requesting client certificates.\"}")
+		s.log().Error("{\"Error\": \"Security functionality must be enabled before requesting client certificates.\"}")
 
 		return
 	}
@@ -453,7 +499,7 @@ func (s *ServerOpenchainREST) GetTransactionCert(rw web.ResponseWriter, req *web
 	// Parse out the user enrollment ID
 	enrollmentID := req.PathParams["id"]
 
-	restLogger.Debugf("REST received transaction certificate retrieval request for registrationID '%s'", enrollmentID)
+	s.log().Debugf("REST received transaction certificate retrieval request for registrationID '%s'", enrollmentID)
 
 	// Parse out the count query parameter
 	req.ParseForm()
@@ -472,7 +518,7 @@ func (s *ServerOpenchainREST) GetTransactionCert(rw web.ResponseWriter, req *web
 		if err != nil {
 			rw.WriteHeader(http.StatusBadRequest)
 			fmt.Fprintf(rw, "{\"Error\": \"Count query parameter must be a non-negative integer.\"}")
-			restLogger.Error("{\"Error\": \"Count query parameter must be a non-negative integer.\"}")
+			s.log().Error("{\"Error\": \"Count query parameter must be a non-negative integer.\"}")
 
 			return
 		}
@@ -490,8 +536,8 @@ func (s *ServerOpenchainREST) GetTransactionCert(rw web.ResponseWriter, req *web
 
 	// If security is enabled, initialize the crypto client
 	if core.SecurityEnabled() {
-		if restLogger.IsEnabledFor(logging.DEBUG) {
-			restLogger.Debugf("Initializing secure client using context '%s'", enrollmentID)
+		if s.log().IsEnabledFor(logging.DEBUG) {
+			s.log().Debugf("Initializing secure client using context '%s'", enrollmentID)
 		}
 
 		// Initialize the security client
@@ -499,7 +545,7 @@ func (s *ServerOpenchainREST) GetTransactionCert(rw web.ResponseWriter, req *web
 		if err != nil {
 			rw.WriteHeader(http.StatusBadRequest)
 			fmt.Fprintf(rw, "{\"Error\": \"%s\"}", err)
-			restLogger.Errorf("{\"Error\": \"%s\"}", err)
+			s.log().Errorf("{\"Error\": \"%s\"}", err)
 
 			return
 		}
@@ -511,7 +557,7 @@ func (s *ServerOpenchainREST) GetTransactionCert(rw web.ResponseWriter, req *web
 		if err != nil {
 			rw.WriteHeader(http.StatusInternalServerError)
 			fmt.Fprintf(rw, "{\"Error\": \"%s\"}", err)
-			restLogger.Errorf("{\"Error\": \"%s\"}", err)
+			s.log().Errorf("{\"Error\": \"%s\"}", err)
 
 			return
 		}
@@ -520,7 +566,7 @@ func (s *ServerOpenchainREST) GetTransactionCert(rw web.ResponseWriter, req *web
 		if handler == nil {
 			rw.WriteHeader(http.StatusInternalServerError)
 			fmt.Fprintf(rw, "{\"Error\": \"Error retrieving certificate handler.\"}")
-			restLogger.Error("{\"Error\": \"Error retrieving certificate handler.\"}")
+			s.log().Error("{\"Error\": \"Error retrieving certificate handler.\"}")
 
 			return
 		}
@@ -536,7 +582,7 @@ func (s *ServerOpenchainREST) GetTransactionCert(rw web.ResponseWriter, req *web
 			if certDER == nil {
 				rw.WriteHeader(http.StatusInternalServerError)
 				fmt.Fprintf(rw, "{\"Error\": \"Transaction certificate is nil.\"}")
-				restLogger.Error("{\"Error\": \"Transaction certificate is nil.\"}")
+				s.log().Error("{\"Error\": \"Transaction certificate is nil.\"}")
 
 				return
 			}
@@ -545,7 +591,7 @@ func (s *ServerOpenchainREST) GetTransactionCert(rw web.ResponseWriter, req *web
 			if len(certDER) == 0 {
 				rw.WriteHeader(http.StatusInternalServerError)
 				fmt.Fprintf(rw, "{\"Error\": \"Transaction certificate length is 0.\"}")
-				restLogger.Error("{\"Error\": \"Transaction certificate length is 0.\"}")
+				s.log().Error("{\"Error\": \"Transaction certificate length is 0.\"}")
 
 				return
 			}
@@ -568,19 +614,19 @@ func (s *ServerOpenchainREST) GetTransactionCert(rw web.ResponseWriter, req *web
 		if err != nil {
 			rw.WriteHeader(http.StatusInternalServerError)
 			fmt.Fprintf(rw, "{\"Error\": \"%s\"}", err)
-			restLogger.Errorf("{\"Error marshalling TCert array\": \"%s\"}", err)
+			s.log().Errorf("{\"Error marshalling TCert array\": \"%s\"}", err)
 
 			return
 		}
 
 		rw.WriteHeader(http.StatusOK)
 		fmt.Fprintf(rw, "{\"OK\": %s}", string(jsonResponse))
-		restLogger.Debugf("Successfully retrieved transaction certificates for secure context '%s'", enrollmentID)
+		s.log().Debugf("Successfully retrieved transaction certificates for secure context '%s'", enrollmentID)
 	} else {
 		// Security must be enabled to request transaction certificates
 		rw.WriteHeader(http.StatusBadRequest)
 		fmt.Fprintf(rw, "{\"Error\": \"Security functionality must be enabled before requesting client certificates.\"}")
-		restLogger.Error("{\"Error\": \"Security functionality must be enabled before requesting client certificates.\"}")
+		s.log().Error("{\"Error\": \"Security functionality must be enabled before requesting client certificates.\"}")
 
 		return
 	}
@@ -589,7 +635,7 @@ func (s *ServerOpenchainREST) GetTransactionCert(rw web.ResponseWriter, req *web
 // GetBlockchainInfo returns information about the blockchain ledger such as
 // height, current block hash, and previous block hash.
 func (s *ServerOpenchainREST) GetBlockchainInfo(rw web.ResponseWriter, req *web.Request) {
-	info, err := s.server.GetBlockchainInfo(context.Background(), &google_protobuf.Empty{})
+	info, err := s.server.GetBlockchainInfo(req.Context(), &google_protobuf.Empty{})
 
 	encoder := json.NewEncoder(rw)
 
@@ -600,11 +646,58 @@ func (s *ServerOpenchainREST) GetBlockchainInfo(rw web.ResponseWriter, req *web.
 		fmt.Fprintf(rw, "{\"Error\": \"%s\"}", err)
 	} else {
 		// Success
+		if checkNotModified(rw, req.Request, blockETag(info.CurrentBlockHash), cacheRevalidate) {
+			return
+		}
 		rw.WriteHeader(http.StatusOK)
 		encoder.Encode(info)
 	}
 }
 
+// GetLedgerStatistics returns the number of keys and bytes of state held by
+// each chaincode, and the distribution of the sizes of the blocks.
+func (s *ServerOpenchainREST) GetLedgerStatistics(rw web.ResponseWriter, req *web.Request) {
+	stats, err := s.server.GetLedgerStatistics(req.Context(), &google_protobuf.Empty{})
+
+	encoder := json.NewEncoder(rw)
+
+	// Check for error
+	if err != nil {
+		// Failure
+		rw.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(rw, "{\"Error\": \"%s\"}", err)
+	} else {
+		// Success
+		rw.WriteHeader(http.StatusOK)
+		encoder.Encode(stats)
+	}
+}
+
+// GetChainTime returns the consensus time of the blockchain, that is the
+// timestamp of its latest block, along with the number of this block.
+func (s *ServerOpenchainREST) GetChainTime(rw web.ResponseWriter, req *web.Request) {
+	chainTime, err := s.server.GetChainTime(req.Context(), &google_protobuf.Empty{})
+
+	encoder := json.NewEncoder(rw)
+
+	// Check for error
+	if err != nil {
+		switch err {
+		case ErrNotFound:
+			rw.WriteHeader(http.StatusNotFound)
+			fmt.Fprintf(rw, "{\"Error\": \"The latest block carries no timestamp, the consensus does not stamp blocks.\"}")
+		default:
+			rw.WriteHeader(http.StatusInternalServerError)
+			fmt.Fprintf(rw, "{\"Error\": \"%s\"}", err)
+			s.log().Errorf("{\"Error\": \"%s\"}", err)
+		}
+	} else {
+		// Success
+		rw.WriteHeader(http.StatusOK)
+		encoder.Encode(chainTime)
+	}
+}
+
 // GetBlockByNumber returns the data contained within a specific block in the
 // blockchain. The genesis block is block zero.
 func (s *ServerOpenchainREST) GetBlockByNumber(rw web.ResponseWriter, req *web.Request) {
@@ -618,7 +711,7 @@ func (s *ServerOpenchainREST) GetBlockByNumber(rw web.ResponseWriter, req *web.R
 		fmt.Fprintf(rw, "{\"Error\": \"Block id must be an integer (uint64).\"}")
 	} else {
 		// Retrieve Block from blockchain
-		block, err := s.server.GetBlockByNumber(context.Background(), &pb.BlockNumber{Number: blockNumber})
+		block, blockHash, err := s.server.getBlockByNumber(blockNumber)
 
 		// Check for error
 		if err != nil || block == nil {
@@ -631,21 +724,86 @@ func (s *ServerOpenchainREST) GetBlockByNumber(rw web.ResponseWriter, req *web.R
 			}
 			fmt.Fprintf(rw, "{\"Error\": \"%s\"}", err)
 		} else {
-			// Success
+			// Success. The block at a given height may still be replaced during
+			// state transfer, so clients must revalidate.
+			decode := decodeRequested(req.Request)
+			etag := blockETag(blockHash)
+			if decode {
+				etag = decodedBlockETag(blockHash)
+			}
+			if checkNotModified(rw, req.Request, etag, cacheRevalidate) {
+				return
+			}
+			var body interface{} = block
+			if decode {
+				if body, err = s.server.renderBlock(blockNumber, block, blockHash); err != nil {
+					rw.WriteHeader(http.StatusInternalServerError)
+					fmt.Fprintf(rw, "{\"Error\": \"%s\"}", err)
+					return
+				}
+			}
 			rw.WriteHeader(http.StatusOK)
 			encoder := json.NewEncoder(rw)
-			encoder.Encode(block)
+			encoder.Encode(body)
 		}
 	}
 }
 
+// GetBlockByHash returns the data contained within the block with the given
+// hash, in hex or base64. Such a block never changes, so the response may be
+// cached indefinitely.
+func (s *ServerOpenchainREST) GetBlockByHash(rw web.ResponseWriter, req *web.Request) {
+	// Parse out the Block hash
+	blockHash, err := decodeBlockHash(req.PathParams["hash"])
+
+	// Check for proper Block hash syntax
+	if err != nil || len(blockHash) == 0 {
+		// Failure
+		rw.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(rw, "{\"Error\": \"Block hash must be hex or base64 encoded.\"}")
+		return
+	}
+
+	// Retrieve Block from blockchain
+	block, err := s.server.GetBlockByHash(req.Context(), blockHash)
+
+	// Check for error
+	if err != nil {
+		// Failure
+		switch err {
+		case ErrNotFound:
+			rw.WriteHeader(http.StatusNotFound)
+		default:
+			rw.WriteHeader(http.StatusInternalServerError)
+		}
+		fmt.Fprintf(rw, "{\"Error\": \"%s\"}", err)
+		return
+	}
+
+	// Success
+	if decodeRequested(req.Request) {
+		if checkNotModified(rw, req.Request, decodedBlockETag(blockHash), cacheImmutable) {
+			return
+		}
+		rw.WriteHeader(http.StatusOK)
+		json.NewEncoder(rw).Encode(inspect.Block(block, blockHash))
+		return
+	}
+	if checkNotModified(rw, req.Request, blockETag(blockHash), cacheImmutable) {
+		return
+	}
+	rw.WriteHeader(http.StatusOK)
+	encoder := json.NewEncoder(rw)
+	encoder.Encode(block)
+}
+
 // GetTransactionByUUID returns a transaction matching the specified UUID
 func (s *ServerOpenchainREST) GetTransactionByUUID(rw web.ResponseWriter, req *web.Request) {
 	// Parse out the transaction UUID
 	txUUID := req.PathParams["uuid"]
 
 	// Retrieve the transaction matching the UUID
-	tx, err := s.server.GetTransactionByUUID(context.Background(), txUUID)
+	tx, err := s.server.GetTransactionByUUID(req.Context(), txUUID)
 
 	// Check for Error
 	if err != nil {
@@ -656,21 +814,99 @@ func (s *ServerOpenchainREST) GetTransactionByUUID(rw web.ResponseWriter, req *w
 		default:
 			rw.WriteHeader(http.StatusInternalServerError)
 			fmt.Fprintf(rw, "{\"Error\": \"Error retrieving transaction %s: %s.\"}", txUUID, err)
-			restLogger.Errorf("{\"Error\": \"Error retrieving transaction %s: %s.\"}", txUUID, err)
+			s.log().Errorf("{\"Error\": \"Error retrieving transaction %s: %s.\"}", txUUID, err)
 		}
 	} else {
 		// Return existing transaction
+		var body interface{} = tx
+		if decodeRequested(req.Request) {
+			body = inspect.Transaction(tx)
+		}
 		rw.WriteHeader(http.StatusOK)
 		encoder := json.NewEncoder(rw)
-		encoder.Encode(tx)
-		restLogger.Infof("Successfully retrieved transaction: %s", txUUID)
+		encoder.Encode(body)
+		s.log().Infof("Successfully retrieved transaction: %s", txUUID)
 	}
 }
 
+// GetTransactionRejection returns why the peer rejected the transaction
+// matching the UUID, if it still remembers it.
+func (s *ServerOpenchainREST) GetTransactionRejection(rw web.ResponseWriter, req *web.Request) {
+	// Parse out the transaction UUID
+	txUUID := req.PathParams["uuid"]
+
+	// Retrieve the rejection of the transaction matching the UUID
+	rejected, err := s.server.GetTransactionRejection(req.Context(), txUUID)
+
+	// Check for Error
+	if err != nil {
+		switch err {
+		case ErrNotFound:
+			rw.WriteHeader(http.StatusNotFound)
+			fmt.Fprintf(rw, "{\"Error\": \"No rejection of transaction %s is known.\"}", txUUID)
+		default:
+			rw.WriteHeader(http.StatusInternalServerError)
+			fmt.Fprintf(rw, "{\"Error\": \"Error retrieving the rejection of transaction %s: %s.\"}", txUUID, err)
+			s.log().Errorf("{\"Error\": \"Error retrieving the rejection of transaction %s: %s.\"}", txUUID, err)
+		}
+	} else {
+		rw.WriteHeader(http.StatusOK)
+		encoder := json.NewEncoder(rw)
+		encoder.Encode(rejected)
+		s.log().Infof("Successfully retrieved the rejection of transaction: %s", txUUID)
+	}
+}
+
+// Page sizes of the search of transactions by tag
+const (
+	defaultTagSearchLimit = 20
+	maxTagSearchLimit     = 100
+)
+
+// GetTransactionsByTag returns a page of the UUIDs of the transactions tagged
+// with the key and value of the path, in chain order. The optional limit and
+// cursor query parameters select the size of the page and where it starts.
+func (s *ServerOpenchainREST) GetTransactionsByTag(rw web.ResponseWriter, req *web.Request) {
+	key, value := req.PathParams["key"], req.PathParams["value"]
+	query := req.URL.Query()
+
+	limit := defaultTagSearchLimit
+	if l := query.Get("limit"); l != "" {
+		n, err := strconv.Atoi(l)
+		if err != nil || n <= 0 || n > maxTagSearchLimit {
+			rw.WriteHeader(http.StatusBadRequest)
+			fmt.Fprintf(rw, "{\"Error\": \"Limit must be an integer between 1 and %d.\"}", maxTagSearchLimit)
+			return
+		}
+		limit = n
+	}
+
+	page, err := s.server.GetTransactionsByTag(req.Context(), key, value, query.Get("cursor"), limit)
+	if err != nil {
+		switch err {
+		case ErrInvalidTagCursor:
+			rw.WriteHeader(http.StatusBadRequest)
+			fmt.Fprintf(rw, "{\"Error\": \"Cursor must be the next cursor of a previous page.\"}")
+		default:
+			rw.WriteHeader(http.StatusInternalServerError)
+			fmt.Fprintf(rw, "{\"Error\": \"%s\"}", err)
+			s.log().Errorf("{\"Error\": \"Error retrieving transactions tagged %s=%s: %s.\"}", key, value, err)
+		}
+		return
+	}
+	rw.WriteHeader(http.StatusOK)
+	encoder := json.NewEncoder(rw)
+	encoder.Encode(struct {
+		UUIDs []string `json:"uuids"`
+		Next  string   `json:"next,omitempty"`
+	}{page.UUIDs, page.Next})
+	s.log().Infof("Successfully retrieved %d transactions tagged %s=%s", len(page.UUIDs), key, value)
+}
+
 // Deploy first builds the chaincode package and subsequently deploys it to the
 // blockchain.
 func (s *ServerOpenchainREST) Deploy(rw web.ResponseWriter, req *web.Request) {
-	restLogger.Info("REST deploying chaincode...")
+	s.log().Info("REST deploying chaincode...")
 
 	// This endpoint has been deprecated. Add a warning header to all responses.
 	rw.Header().Set("Warning", "299 - /devops/deploy endpoint has been deprecated. Use /chaincode endpoint instead.")
@@ -690,11 +926,11 @@ func (s *ServerOpenchainREST) Deploy(rw web.ResponseWriter, req *web.Request) {
 		if err == io.EOF {
 			rw.WriteHeader(http.StatusBadRequest)
 			fmt.Fprintf(rw, "{\"Error\": \"Payload must contain a ChaincodeSpec.\"}")
-			restLogger.Error("{\"Error\": \"Payload must contain a ChaincodeSpec.\"}")
+			s.log().Error("{\"Error\": \"Payload must contain a ChaincodeSpec.\"}")
 		} else {
 			rw.WriteHeader(http.StatusBadRequest)
 			fmt.Fprintf(rw, "{\"Error\": \"%s\"}", errVal)
-			restLogger.Errorf("{\"Error\": \"%s\"}", errVal)
+			s.log().Errorf("{\"Error\": \"%s\"}", errVal)
 		}
 
 		return
@@ -704,7 +940,7 @@ func (s *ServerOpenchainREST) Deploy(rw web.ResponseWriter, req *web.Request) {
 	if spec.ChaincodeID == nil {
 		rw.WriteHeader(http.StatusBadRequest)
 		fmt.Fprintf(rw, "{\"Error\": \"Payload must contain a ChaincodeID.\"}")
-		restLogger.Error("{\"Error\": \"Payload must contain a ChaincodeID.\"}")
+		s.log().Error("{\"Error\": \"Payload must contain a ChaincodeID.\"}")
 
 		return
 	}
@@ -719,7 +955,7 @@ func (s *ServerOpenchainREST) Deploy(rw web.ResponseWriter, req *web.Request) {
 		if spec.ChaincodeID.Name == "" {
 			rw.WriteHeader(http.StatusBadRequest)
 			fmt.Fprintf(rw, "{\"Error\": \"Chaincode name may not be blank in development mode.\"}")
-			restLogger.Error("{\"Error\": \"Chaincode name may not be blank in development mode.\"}")
+			s.log().Error("{\"Error\": \"Chaincode name may not be blank in development mode.\"}")
 
 			return
 		}
@@ -728,7 +964,7 @@ func (s *ServerOpenchainREST) Deploy(rw web.ResponseWriter, req *web.Request) {
 		if spec.ChaincodeID.Path == "" {
 			rw.WriteHeader(http.StatusBadRequest)
 			fmt.Fprintf(rw, "{\"Error\": \"Chaincode path may not be blank.\"}")
-			restLogger.Error("{\"Error\": \"Chaincode path may not be blank.\"}")
+			s.log().Error("{\"Error\": \"Chaincode path may not be blank.\"}")
 
 			return
 		}
@@ -738,7 +974,7 @@ func (s *ServerOpenchainREST) Deploy(rw web.ResponseWriter, req *web.Request) {
 	if (spec.CtorMsg == nil) || (spec.CtorMsg.Function == "") {
 		rw.WriteHeader(http.StatusBadRequest)
 		fmt.Fprintf(rw, "{\"Error\": \"Payload must contain a CtorMsg with a Chaincode function name.\"}")
-		restLogger.Error("{\"Error\": \"Payload must contain a CtorMsg with a Chaincode function name.\"}")
+		s.log().Error("{\"Error\": \"Payload must contain a CtorMsg with a Chaincode function name.\"}")
 
 		return
 	}
@@ -749,7 +985,7 @@ func (s *ServerOpenchainREST) Deploy(rw web.ResponseWriter, req *web.Request) {
 		if chaincodeUsr == "" {
 			rw.WriteHeader(http.StatusBadRequest)
 			fmt.Fprintf(rw, "{\"Error\": \"Must supply username for chaincode when security is enabled.\"}")
-			restLogger.Error("{\"Error\": \"Must supply username for chaincode when security is enabled.\"}")
+			s.log().Error("{\"Error\": \"Must supply username for chaincode when security is enabled.\"}")
 
 			return
 		}
@@ -760,7 +996,7 @@ func (s *ServerOpenchainREST) Deploy(rw web.ResponseWriter, req *web.Request) {
 
 		// Check if the user is logged in before sending transaction
 		if _, err := os.Stat(localStore + "loginToken_" + chaincodeUsr); err == nil {
-			restLogger.Infof("Local user '%s' is already logged in. Retrieving login token.\n", chaincodeUsr)
+			s.log().Infof("Local user '%s' is already logged in. Retrieving login token.\n", chaincodeUsr)
 
 			// Read in the login token
 			token, err := ioutil.ReadFile(localStore + "loginToken_" + chaincodeUsr)
@@ -782,7 +1018,7 @@ func (s *ServerOpenchainREST) Deploy(rw web.ResponseWriter, req *web.Request) {
 			if os.IsNotExist(err) {
 				rw.WriteHeader(http.StatusUnauthorized)
 				fmt.Fprintf(rw, "{\"Error\": \"User not logged in. Use the '/registrar' endpoint to obtain a security token.\"}")
-				restLogger.Error("{\"Error\": \"User not logged in. Use the '/registrar' endpoint to obtain a security token.\"}")
+				s.log().Error("{\"Error\": \"User not logged in. Use the '/registrar' endpoint to obtain a security token.\"}")
 
 				return
 			}
@@ -794,14 +1030,14 @@ func (s *ServerOpenchainREST) Deploy(rw web.ResponseWriter, req *web.Request) {
 	}
 
 	// Deploy the ChaincodeSpec
-	chaincodeDeploymentSpec, err := s.devops.Deploy(context.Background(), &spec)
+	chaincodeDeploymentSpec, err := s.devops.Deploy(req.Context(), &spec)
 	if err != nil {
 		// Replace " characters with '
 		errVal := strings.Replace(err.Error(), "\"", "'", -1)
 
 		rw.WriteHeader(http.StatusBadRequest)
 		fmt.Fprintf(rw, "{\"Error\": \"%s\"}", errVal)
-		restLogger.Errorf("{\"Error\": \"Deploying Chaincode -- %s\"}", errVal)
+		s.log().Errorf("{\"Error\": \"Deploying Chaincode -- %s\"}", errVal)
 
 		return
 	}
@@ -811,12 +1047,12 @@ func (s *ServerOpenchainREST) Deploy(rw web.ResponseWriter, req *web.Request) {
 
 	rw.WriteHeader(http.StatusOK)
 	fmt.Fprintf(rw, "{\"OK\": \"Successfully deployed chainCode.\",\"message\":\""+chainID+"\"}")
-	restLogger.Infof("Successfully deployed chainCode: %s \n", chainID)
+	s.log().Infof("Successfully deployed chainCode: %s \n", chainID)
 }
 
 // Invoke executes a specified function within a target Chaincode.
 func (s *ServerOpenchainREST) Invoke(rw web.ResponseWriter, req *web.Request) {
-	restLogger.Info("REST invoking chaincode...")
+	s.log().Info("REST invoking chaincode...")
 
 	// This endpoint has been deprecated. Add a warning header to all responses.
 	rw.Header().Set("Warning", "299 - /devops/invoke endpoint has been deprecated. Use /chaincode endpoint instead.")
@@ -836,11 +1072,11 @@ func (s *ServerOpenchainREST) Invoke(rw web.ResponseWriter, req *web.Request) {
 		if err == io.EOF {
 			rw.WriteHeader(http.StatusBadRequest)
 			fmt.Fprintf(rw, "{\"Error\": \"Payload must contain a ChaincodeInvocationSpec.\"}")
-			restLogger.Error("{\"Error\": \"Payload must contain a ChaincodeInvocationSpec.\"}")
+			s.log().Error("{\"Error\": \"Payload must contain a ChaincodeInvocationSpec.\"}")
 		} else {
 			rw.WriteHeader(http.StatusBadRequest)
 			fmt.Fprintf(rw, "{\"Error\": \"%s\"}", errVal)
-			restLogger.Errorf("{\"Error\": \"%s\"}", errVal)
+			s.log().Errorf("{\"Error\": \"%s\"}", errVal)
 		}
 
 		return
@@ -850,7 +1086,7 @@ func (s *ServerOpenchainREST) Invoke(rw web.ResponseWriter, req *web.Request) {
 	if spec.ChaincodeSpec == nil {
 		rw.WriteHeader(http.StatusBadRequest)
 		fmt.Fprintf(rw, "{\"Error\": \"Payload must contain a ChaincodeSpec.\"}")
-		restLogger.Error("{\"Error\": \"Payload must contain a ChaincodeSpec.\"}")
+		s.log().Error("{\"Error\": \"Payload must contain a ChaincodeSpec.\"}")
 
 		return
 	}
@@ -859,7 +1095,7 @@ func (s *ServerOpenchainREST) Invoke(rw web.ResponseWriter, req *web.Request) {
 	if spec.ChaincodeSpec.ChaincodeID == nil {
 		rw.WriteHeader(http.StatusBadRequest)
 		fmt.Fprintf(rw, "{\"Error\": \"Payload must contain a ChaincodeID.\"}")
-		restLogger.Error("{\"Error\": \"Payload must contain a ChaincodeID.\"}")
+		s.log().Error("{\"Error\": \"Payload must contain a ChaincodeID.\"}")
 
 		return
 	}
@@ -868,7 +1104,7 @@ func (s *ServerOpenchainREST) Invoke(rw web.ResponseWriter, req *web.Request) {
 	if spec.ChaincodeSpec.ChaincodeID.Name == "" {
 		rw.WriteHeader(http.StatusBadRequest)
 		fmt.Fprintf(rw, "{\"Error\": \"Chaincode name may not be blank.\"}")
-		restLogger.Error("{\"Error\": \"Chaincode name may not be blank.\"}")
+		s.log().Error("{\"Error\": \"Chaincode name may not be blank.\"}")
 
 		return
 	}
@@ -877,7 +1113,7 @@ func (s *ServerOpenchainREST) Invoke(rw web.ResponseWriter, req *web.Request) {
 	if (spec.ChaincodeSpec.CtorMsg == nil) || (spec.ChaincodeSpec.CtorMsg.Function == "") {
 		rw.WriteHeader(http.StatusBadRequest)
 		fmt.Fprintf(rw, "{\"Error\": \"Payload must contain a CtorMsg with a Chaincode function name.\"}")
-		restLogger.Error("{\"Error\": \"Payload must contain a CtorMsg with a Chaincode function name.\"}")
+		s.log().Error("{\"Error\": \"Payload must contain a CtorMsg with a Chaincode function name.\"}")
 
 		return
 	}
@@ -888,7 +1124,7 @@ func (s *ServerOpenchainREST) Invoke(rw web.ResponseWriter, req *web.Request) {
 		if chaincodeUsr == "" {
 			rw.WriteHeader(http.StatusBadRequest)
 			fmt.Fprintf(rw, "{\"Error\": \"Must supply username for chaincode when security is enabled.\"}")
-			restLogger.Error("{\"Error\": \"Must supply username for chaincode when security is enabled.\"}")
+			s.log().Error("{\"Error\": \"Must supply username for chaincode when security is enabled.\"}")
 
 			return
 		}
@@ -899,7 +1135,7 @@ func (s *ServerOpenchainREST) Invoke(rw web.ResponseWriter, req *web.Request) {
 
 		// Check if the user is logged in before sending transaction
 		if _, err := os.Stat(localStore + "loginToken_" + chaincodeUsr); err == nil {
-			restLogger.Infof("Local user '%s' is already logged in. Retrieving login token.\n", chaincodeUsr)
+			s.log().Infof("Local user '%s' is already logged in. Retrieving login token.\n", chaincodeUsr)
 
 			// Read in the login token
 			token, err := ioutil.ReadFile(localStore + "loginToken_" + chaincodeUsr)
@@ -921,7 +1157,7 @@ func (s *ServerOpenchainREST) Invoke(rw web.ResponseWriter, req *web.Request) {
 			if os.IsNotExist(err) {
 				rw.WriteHeader(http.StatusUnauthorized)
 				fmt.Fprintf(rw, "{\"Error\": \"User not logged in. Use the '/registrar' endpoint to obtain a security token.\"}")
-				restLogger.Error("{\"Error\": \"User not logged in. Use the '/registrar' endpoint to obtain a security token.\"}")
+				s.log().Error("{\"Error\": \"User not logged in. Use the '/registrar' endpoint to obtain a security token.\"}")
 
 				return
 			}
@@ -933,14 +1169,14 @@ func (s *ServerOpenchainREST) Invoke(rw web.ResponseWriter, req *web.Request) {
 	}
 
 	// Invoke the chainCode
-	resp, err := s.devops.Invoke(context.Background(), &spec)
+	resp, err := s.devops.Invoke(req.Context(), &spec)
 	if err != nil {
 		// Replace " characters with '
 		errVal := strings.Replace(err.Error(), "\"", "'", -1)
 
 		rw.WriteHeader(http.StatusBadRequest)
 		fmt.Fprintf(rw, "{\"Error\": \"%s\"}", errVal)
-		restLogger.Errorf("{\"Error\": \"Invoking Chaincode -- %s\"}", errVal)
+		s.log().Errorf("{\"Error\": \"Invoking Chaincode -- %s\"}", errVal)
 
 		return
 	}
@@ -951,12 +1187,12 @@ func (s *ServerOpenchainREST) Invoke(rw web.ResponseWriter, req *web.Request) {
 	rw.WriteHeader(http.StatusOK)
 	// Make a clarification in the invoke response message, that the transaction has been successfully submitted but not completed
 	fmt.Fprintf(rw, "{\"OK\": \"Successfully submitted invoke transaction.\",\"message\": \"%s\"}", string(txuuid))
-	restLogger.Infof("Successfully submitted invoke transaction (%s).\n", string(txuuid))
+	s.log().Infof("Successfully submitted invoke transaction (%s).\n", string(txuuid))
 }
 
 // Query performs the requested query on the target Chaincode.
 func (s *ServerOpenchainREST) Query(rw web.ResponseWriter, req *web.Request) {
-	restLogger.Info("REST querying chaincode...")
+	s.log().Info("REST querying chaincode...")
 
 	// This endpoint has been deprecated. Add a warning header to all responses.
 	rw.Header().Set("Warning", "299 - /devops/query endpoint has been deprecated. Use /chaincode endpoint instead.")
@@ -976,11 +1212,11 @@ func (s *ServerOpenchainREST) Query(rw web.ResponseWriter, req *web.Request) {
 		if err == io.EOF {
 			rw.WriteHeader(http.StatusBadRequest)
 			fmt.Fprintf(rw, "{\"Error\": \"Payload must contain a ChaincodeInvocationSpec.\"}")
-			restLogger.Error("{\"Error\": \"Payload must contain a ChaincodeInvocationSpec.\"}")
+			s.log().Error("{\"Error\": \"Payload must contain a ChaincodeInvocationSpec.\"}")
 		} else {
 			rw.WriteHeader(http.StatusBadRequest)
 			fmt.Fprintf(rw, "{\"Error\": \"%s\"}", errVal)
-			restLogger.Errorf("{\"Error\": \"%s\"}", errVal)
+			s.log().Errorf("{\"Error\": \"%s\"}", errVal)
 		}
 
 		return
@@ -990,7 +1226,7 @@ func (s *ServerOpenchainREST) Query(rw web.ResponseWriter, req *web.Request) {
 	if spec.ChaincodeSpec == nil {
 		rw.WriteHeader(http.StatusBadRequest)
 		fmt.Fprintf(rw, "{\"Error\": \"Payload must contain a ChaincodeSpec.\"}")
-		restLogger.Error("{\"Error\": \"Payload must contain a ChaincodeSpec.\"}")
+		s.log().Error("{\"Error\": \"Payload must contain a ChaincodeSpec.\"}")
 
 		return
 	}
@@ -999,7 +1235,7 @@ func (s *ServerOpenchainREST) Query(rw web.ResponseWriter, req *web.Request) {
 	if spec.ChaincodeSpec.ChaincodeID == nil {
 		rw.WriteHeader(http.StatusBadRequest)
 		fmt.Fprintf(rw, "{\"Error\": \"Payload must contain a ChaincodeID.\"}")
-		restLogger.Error("{\"Error\": \"Payload must contain a ChaincodeID.\"}")
+		s.log().Error("{\"Error\": \"Payload must contain a ChaincodeID.\"}")
 
 		return
 	}
@@ -1008,7 +1244,7 @@ func (s *ServerOpenchainREST) Query(rw web.ResponseWriter, req *web.Request) {
 	if spec.ChaincodeSpec.ChaincodeID.Name == "" {
 		rw.WriteHeader(http.StatusBadRequest)
 		fmt.Fprintf(rw, "{\"Error\": \"Chaincode name may not be blank.\"}")
-		restLogger.Error("{\"Error\": \"Chaincode name may not be blank.\"}")
+		s.log().Error("{\"Error\": \"Chaincode name may not be blank.\"}")
 
 		return
 	}
@@ -1017,7 +1253,7 @@ func (s *ServerOpenchainREST) Query(rw web.ResponseWriter, req *web.Request) {
 	if (spec.ChaincodeSpec.CtorMsg == nil) || (spec.ChaincodeSpec.CtorMsg.Function == "") {
 		rw.WriteHeader(http.StatusBadRequest)
 		fmt.Fprintf(rw, "{\"Error\": \"Payload must contain a CtorMsg with a Chaincode function name.\"}")
-		restLogger.Error("{\"Error\": \"Payload must contain a CtorMsg with a Chaincode function name.\"}")
+		s.log().Error("{\"Error\": \"Payload must contain a CtorMsg with a Chaincode function name.\"}")
 
 		return
 	}
@@ -1028,7 +1264,7 @@ func (s *ServerOpenchainREST) Query(rw web.ResponseWriter, req *web.Request) {
 		if chaincodeUsr == "" {
 			rw.WriteHeader(http.StatusBadRequest)
 			fmt.Fprintf(rw, "{\"Error\": \"Must supply username for chaincode when security is enabled.\"}")
-			restLogger.Error("{\"Error\": \"Must supply username for chaincode when security is enabled.\"}")
+			s.log().Error("{\"Error\": \"Must supply username for chaincode when security is enabled.\"}")
 
 			return
 		}
@@ -1039,7 +1275,7 @@ func (s *ServerOpenchainREST) Query(rw web.ResponseWriter, req *web.Request) {
 
 		// Check if the user is logged in before sending transaction
 		if _, err := os.Stat(localStore + "loginToken_" + chaincodeUsr); err == nil {
-			restLogger.Infof("Local user '%s' is already logged in. Retrieving login token.\n", chaincodeUsr)
+			s.log().Infof("Local user '%s' is already logged in. Retrieving login token.\n", chaincodeUsr)
 
 			// Read in the login token
 			token, err := ioutil.ReadFile(localStore + "loginToken_" + chaincodeUsr)
@@ -1061,7 +1297,7 @@ func (s *ServerOpenchainREST) Query(rw web.ResponseWriter, req *web.Request) {
 			if os.IsNotExist(err) {
 				rw.WriteHeader(http.StatusUnauthorized)
 				fmt.Fprintf(rw, "{\"Error\": \"User not logged in. Use the '/registrar' endpoint to obtain a security token.\"}")
-				restLogger.Error("{\"Error\": \"User not logged in. Use the '/registrar' endpoint to obtain a security token.\"}")
+				s.log().Error("{\"Error\": \"User not logged in. Use the '/registrar' endpoint to obtain a security token.\"}")
 
 				return
 			}
@@ -1073,14 +1309,14 @@ func (s *ServerOpenchainREST) Query(rw web.ResponseWriter, req *web.Request) {
 	}
 
 	// Query the chainCode
-	resp, err := s.devops.Query(context.Background(), &spec)
+	resp, err := s.devops.Query(req.Context(), &spec)
 	if err != nil {
 		// Replace " characters with '
 		errVal := strings.Replace(err.Error(), "\"", "'", -1)
 
 		rw.WriteHeader(http.StatusBadRequest)
 		fmt.Fprintf(rw, "{\"Error\": \"%s\"}", errVal)
-		restLogger.Errorf("{\"Error\": \"Querying Chaincode -- %s\"}", errVal)
+		s.log().Errorf("{\"Error\": \"Querying Chaincode -- %s\"}", errVal)
 
 		return
 	}
@@ -1096,7 +1332,7 @@ func (s *ServerOpenchainREST) Query(rw web.ResponseWriter, req *web.Request) {
 		if err != nil {
 			rw.WriteHeader(http.StatusInternalServerError)
 			fmt.Fprintf(rw, "{\"Error\": \"%s\"}", err)
-			restLogger.Errorf("{\"Error marshalling query response\": \"%s\"}", err)
+			s.log().Errorf("{\"Error marshalling query response\": \"%s\"}", err)
 
 			return
 		}
@@ -1108,7 +1344,7 @@ func (s *ServerOpenchainREST) Query(rw web.ResponseWriter, req *web.Request) {
 
 // ProcessChaincode implements JSON RPC 2.0 specification for chaincode deploy, invoke, and query.
 func (s *ServerOpenchainREST) ProcessChaincode(rw web.ResponseWriter, req *web.Request) {
-	restLogger.Info("REST processing chaincode request...")
+	s.log().Info("REST processing chaincode request...")
 
 	// Read in the incoming request payload
 	reqBody, err := ioutil.ReadAll(req.Body)
@@ -1121,7 +1357,7 @@ func (s *ServerOpenchainREST) ProcessChaincode(rw web.ResponseWriter, req *web.R
 
 		rw.WriteHeader(http.StatusInternalServerError)
 		fmt.Fprintf(rw, string(jsonResponse))
-		restLogger.Error("Internal JSON-RPC error when reading request body.")
+		s.log().Error("Internal JSON-RPC error when reading request body.")
 
 		return
 	}
@@ -1136,7 +1372,7 @@ func (s *ServerOpenchainREST) ProcessChaincode(rw web.ResponseWriter, req *web.R
 
 		rw.WriteHeader(http.StatusBadRequest)
 		fmt.Fprintf(rw, string(jsonResponse))
-		restLogger.Error("Client must supply a payload for chaincode requests.")
+		s.log().Error("Client must supply a payload for chaincode requests.")
 
 		return
 	}
@@ -1156,7 +1392,7 @@ func (s *ServerOpenchainREST) ProcessChaincode(rw web.ResponseWriter, req *web.R
 
 		rw.WriteHeader(http.StatusBadRequest)
 		fmt.Fprintf(rw, string(jsonResponse))
-		restLogger.Errorf("Error unmarshalling chaincode request payload: %s", err)
+		s.log().Errorf("Error unmarshalling chaincode request payload: %s", err)
 
 		return
 	}
@@ -1188,7 +1424,7 @@ func (s *ServerOpenchainREST) ProcessChaincode(rw web.ResponseWriter, req *web.R
 			rw.WriteHeader(http.StatusBadRequest)
 			fmt.Fprintf(rw, string(jsonResponse))
 		}
-		restLogger.Error("Missing JSON RPC version string.")
+		s.log().Error("Missing JSON RPC version string.")
 
 		return
 	} else if *(requestPayload.Jsonrpc) != "2.0" {
@@ -1203,7 +1439,7 @@ func (s *ServerOpenchainREST) ProcessChaincode(rw web.ResponseWriter, req *web.R
 			rw.WriteHeader(http.StatusBadRequest)
 			fmt.Fprintf(rw, string(jsonResponse))
 		}
-		restLogger.Error("Invalid JSON RPC version string. Must be 2.0.")
+		s.log().Error("Invalid JSON RPC version string. Must be 2.0.")
 
 		return
 	}
@@ -1221,7 +1457,7 @@ func (s *ServerOpenchainREST) ProcessChaincode(rw web.ResponseWriter, req *web.R
 			rw.WriteHeader(http.StatusBadRequest)
 			fmt.Fprintf(rw, string(jsonResponse))
 		}
-		restLogger.Error("Missing JSON RPC 2.0 method string.")
+		s.log().Error("Missing JSON RPC 2.0 method string.")
 
 		return
 	} else if (*(requestPayload.Method) != "deploy") && (*(requestPayload.Method) != "invoke") && (*(requestPayload.Method) != "query") {
@@ -1236,7 +1472,7 @@ func (s *ServerOpenchainREST) ProcessChaincode(rw web.ResponseWriter, req *web.R
 			rw.WriteHeader(http.StatusNotFound)
 			fmt.Fprintf(rw, string(jsonResponse))
 		}
-		restLogger.Error("Requested method does not exist.")
+		s.log().Error("Requested method does not exist.")
 
 		return
 	}
@@ -1267,7 +1503,7 @@ func (s *ServerOpenchainREST) ProcessChaincode(rw web.ResponseWriter, req *web.R
 				rw.WriteHeader(http.StatusBadRequest)
 				fmt.Fprintf(rw, string(jsonResponse))
 			}
-			restLogger.Error("Client must supply ChaincodeSpec for chaincode deploy request.")
+			s.log().Error("Client must supply ChaincodeSpec for chaincode deploy request.")
 
 			return
 		}
@@ -1276,7 +1512,7 @@ func (s *ServerOpenchainREST) ProcessChaincode(rw web.ResponseWriter, req *web.R
 		deploySpec := requestPayload.Params
 
 		// Process the chaincode deployment request and record the result
-		result = s.processChaincodeDeploy(deploySpec)
+		result = s.processChaincodeDeploy(req.Context(), deploySpec)
 	} else {
 
 		//
@@ -1301,13 +1537,13 @@ func (s *ServerOpenchainREST) ProcessChaincode(rw web.ResponseWriter, req *web.R
 				rw.WriteHeader(http.StatusBadRequest)
 				fmt.Fprintf(rw, string(jsonResponse))
 			}
-			restLogger.Error("Client must supply ChaincodeSpec for chaincode invoke or query request.")
+			s.log().Error("Client must supply ChaincodeSpec for chaincode invoke or query request.")
 
 			return
 		}
 
 		// Process the chaincode invoke/query request and record the result
-		result = s.processChaincodeInvokeOrQuery(*(requestPayload.Method), invokequeryPayload)
+		result = s.processChaincodeInvokeOrQuery(req.Context(), *(requestPayload.Method), invokequeryPayload)
 	}
 
 	//
@@ -1325,23 +1561,251 @@ func (s *ServerOpenchainREST) ProcessChaincode(rw web.ResponseWriter, req *web.R
 
 	// Make a clarification in the invoke response message, that the transaction has been successfully submitted but not completed
 	if *(requestPayload.Method) == "invoke" {
-		restLogger.Infof("REST successfully submitted invoke transaction: %s", string(jsonResponse))
+		s.log().Infof("REST successfully submitted invoke transaction: %s", string(jsonResponse))
 	} else {
-		restLogger.Infof("REST successfully %s chaincode: %s", *(requestPayload.Method), string(jsonResponse))
+		s.log().Infof("REST successfully %s chaincode: %s", *(requestPayload.Method), string(jsonResponse))
 	}
 
 	return
 }
 
-// processChaincodeDeploy triggers chaincode deploy and returns a result or an error
-func (s *ServerOpenchainREST) processChaincodeDeploy(spec *pb.ChaincodeSpec) rpcResult {
-	restLogger.Info("REST deploying chaincode...")
+// ProcessChaincodeBatch submits a batch of JSON RPC 2.0 chaincode requests. The
+// requests are processed in order and a response is returned for each request
+// that is not a notification, in the same order. If failOnFirstError is set in
+// the payload, all requests are validated before any is submitted and the whole
+// batch is rejected when one of them is invalid.
+func (s *ServerOpenchainREST) ProcessChaincodeBatch(rw web.ResponseWriter, req *web.Request) {
+	s.log().Info("REST processing chaincode batch request...")
+
+	// Read in the incoming request payload
+	reqBody, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		error := formatRPCError(InternalError.Code, InternalError.Message, "Internal JSON-RPC error when reading request body.")
+		jsonResponse, _ := json.Marshal(formatRPCResponse(error, nil))
+
+		rw.WriteHeader(http.StatusInternalServerError)
+		rw.Write(jsonResponse)
+		s.log().Error("Internal JSON-RPC error when reading request body.")
+
+		return
+	}
+
+	// Payload must conform to the following structure
+	var batchPayload rpcBatchRequest
+	err = json.Unmarshal(reqBody, &batchPayload)
+	if err != nil {
+		error := formatRPCError(ParseError.Code, ParseError.Message, fmt.Sprintf("Error unmarshalling chaincode batch request payload: %s", err))
+		jsonResponse, _ := json.Marshal(formatRPCResponse(error, nil))
+
+		rw.WriteHeader(http.StatusBadRequest)
+		rw.Write(jsonResponse)
+		s.log().Errorf("Error unmarshalling chaincode batch request payload: %s", err)
+
+		return
+	}
+
+	// The batch may not be empty
+	if len(batchPayload.Requests) == 0 {
+		error := formatRPCError(InvalidRequest.Code, InvalidRequest.Message, "Client must supply at least one request in a chaincode batch.")
+		jsonResponse, _ := json.Marshal(formatRPCResponse(error, nil))
+
+		rw.WriteHeader(http.StatusBadRequest)
+		rw.Write(jsonResponse)
+		s.log().Error("Client must supply at least one request in a chaincode batch.")
+
+		return
+	}
+
+	// Validate every request up front, so that the whole batch can be rejected
+	// before anything is submitted if requested by the client
+	invalid := make([]*rpcResult, len(batchPayload.Requests))
+	firstInvalid := -1
+	for i, item := range batchPayload.Requests {
+		invalid[i] = validateRPCRequest(item)
+		if invalid[i] != nil && firstInvalid < 0 {
+			firstInvalid = i
+		}
+	}
+
+	status := http.StatusOK
+	responses := []rpcResponse{}
+	submitted := 0
+	for i, item := range batchPayload.Requests {
+		var result rpcResult
+		if invalid[i] != nil {
+			result = *invalid[i]
+		} else if batchPayload.FailOnFirstError && firstInvalid >= 0 {
+			result = formatRPCError(BatchAbortedError.Code, BatchAbortedError.Message,
+				fmt.Sprintf("Request was not submitted because request %d in the batch failed validation.", firstInvalid))
+		} else if *(item.Method) == "deploy" {
+			result = s.processChaincodeDeploy(req.Context(), item.Params)
+			submitted++
+		} else {
+			result = s.processChaincodeInvokeOrQuery(req.Context(), *(item.Method), &pb.ChaincodeInvocationSpec{ChaincodeSpec: item.Params})
+			submitted++
+		}
+
+		// No response is produced for notifications
+		if item == nil || item.ID == nil {
+			continue
+		}
+		responses = append(responses, formatRPCResponse(result, item.ID))
+	}
+
+	if batchPayload.FailOnFirstError && firstInvalid >= 0 {
+		status = http.StatusBadRequest
+		s.log().Errorf("REST rejected chaincode batch, request %d failed validation.", firstInvalid)
+	} else {
+		s.log().Infof("REST successfully processed chaincode batch, submitted %d of %d requests.", submitted, len(batchPayload.Requests))
+	}
+
+	jsonResponse, _ := json.Marshal(responses)
+	rw.WriteHeader(status)
+	rw.Write(jsonResponse)
+}
+
+// StreamQuery performs a JSON RPC 2.0 chaincode query and streams the result with
+// chunked transfer encoding in the chunks the chaincode emits it, rather than
+// returning it inside a JSON RPC 2.0 response. Requests rejected before any of
+// the result has been sent receive a JSON RPC 2.0 error response. A query which
+// fails once the result has started streaming is reported in the
+// X-Query-Error trailer.
+func (s *ServerOpenchainREST) StreamQuery(rw web.ResponseWriter, req *web.Request) {
+	s.log().Info("REST streaming chaincode query...")
+
+	writeError := func(status int, error rpcResult, id *rpcID) {
+		jsonResponse, _ := json.Marshal(formatRPCResponse(error, id))
+		rw.WriteHeader(status)
+		rw.Write(jsonResponse)
+		s.log().Errorf("REST chaincode query stream rejected: %s", error.Error.Data)
+	}
+
+	// Payload must be a single JSON RPC 2.0 query request
+	var requestPayload rpcRequest
+	if err := json.NewDecoder(req.Body).Decode(&requestPayload); err != nil {
+		writeError(http.StatusBadRequest, formatRPCError(ParseError.Code, ParseError.Message, fmt.Sprintf("Error unmarshalling chaincode request payload: %s", err)), nil)
+		return
+	}
+	if invalid := validateRPCRequest(&requestPayload); invalid != nil {
+		writeError(http.StatusBadRequest, *invalid, requestPayload.ID)
+		return
+	}
+	if *(requestPayload.Method) != "query" {
+		writeError(http.StatusNotFound, formatRPCError(MethodNotFound.Code, MethodNotFound.Message, "Only query results can be streamed."), requestPayload.ID)
+		return
+	}
+	if error := addLoginToken(requestPayload.Params); error != nil {
+		writeError(http.StatusBadRequest, *error, requestPayload.ID)
+		return
+	}
+
+	closed := rw.CloseNotify()
+	streaming := false
+	sink := func(chunk []byte) error {
+		select {
+		case <-closed:
+			return errors.New("client closed the connection")
+		default:
+		}
+		if !streaming {
+			rw.Header().Set("Content-Type", "application/octet-stream")
+			rw.Header().Set("Trailer", "X-Query-Error")
+			rw.WriteHeader(http.StatusOK)
+			streaming = true
+		}
+		if _, err := rw.Write(chunk); err != nil {
+			return err
+		}
+		rw.Flush()
+		return nil
+	}
+
+	err := s.devops.StreamQuery(req.Context(), &pb.ChaincodeInvocationSpec{ChaincodeSpec: requestPayload.Params}, sink)
+	if err != nil {
+		// Replace " characters with ' within the chaincode response
+		errVal := strings.Replace(err.Error(), "\"", "'", -1)
+		if !streaming {
+			writeError(http.StatusOK, formatRPCError(ChaincodeQueryError.Code, ChaincodeQueryError.Message, fmt.Sprintf("Error when querying chaincode: %s", errVal)), requestPayload.ID)
+			return
+		}
+		rw.Header().Set("X-Query-Error", errVal)
+		s.log().Errorf("Error when streaming chaincode query: %s", errVal)
+		return
+	}
+	if !streaming {
+		// The query produced an empty result
+		rw.Header().Set("Content-Type", "application/octet-stream")
+		rw.WriteHeader(http.StatusOK)
+	}
+	s.log().Infof("REST successfully streamed chaincode query, %d bytes", rw.Size())
+}
+
+// validateRPCRequest checks that a JSON RPC 2.0 chaincode request is well formed
+// without submitting it. It returns nil if the request is valid or the error
+// result otherwise.
+func validateRPCRequest(item *rpcRequest) *rpcResult {
+	invalidRequest := func(msg string) *rpcResult {
+		error := formatRPCError(InvalidRequest.Code, InvalidRequest.Message, msg)
+		return &error
+	}
+	invalidParams := func(msg string) *rpcResult {
+		error := formatRPCError(InvalidParams.Code, InvalidParams.Message, msg)
+		return &error
+	}
+
+	if item == nil {
+		return invalidRequest("Batch entries must be JSON RPC 2.0 request objects.")
+	}
+	if item.Jsonrpc == nil {
+		return invalidRequest("Missing JSON RPC 2.0 version string.")
+	}
+	if *(item.Jsonrpc) != "2.0" {
+		return invalidRequest("Invalid JSON RPC 2.0 version string. Must be 2.0.")
+	}
+	if item.Method == nil {
+		return invalidRequest("Missing JSON RPC 2.0 method string.")
+	}
+	method := *(item.Method)
+	if (method != "deploy") && (method != "invoke") && (method != "query") {
+		error := formatRPCError(MethodNotFound.Code, MethodNotFound.Message, "Requested method does not exist.")
+		return &error
+	}
+	if item.Params == nil {
+		return invalidParams(fmt.Sprintf("Client must supply ChaincodeSpec for chaincode %s request.", method))
+	}
+	if item.Params.ChaincodeID == nil {
+		return invalidParams("Payload must contain a ChaincodeID.")
+	}
+	if method == "deploy" {
+		if viper.GetString("chaincode.mode") == chaincode.DevModeUserRunsChaincode {
+			if item.Params.ChaincodeID.Name == "" {
+				return invalidParams("Chaincode name may not be blank in development mode.")
+			}
+		} else if item.Params.ChaincodeID.Path == "" {
+			return invalidParams("Chaincode path may not be blank.")
+		}
+	} else if item.Params.ChaincodeID.Name == "" {
+		return invalidParams("Chaincode name may not be blank.")
+	}
+	if (item.Params.CtorMsg == nil) || (item.Params.CtorMsg.Function == "") {
+		return invalidParams("Payload must contain a CtorMsg with a Chaincode function name.")
+	}
+	if core.SecurityEnabled() && item.Params.SecureContext == "" {
+		return invalidParams("Must supply username for chaincode when security is enabled.")
+	}
+
+	return nil
+}
+
+// processChaincodeDeploy triggers chaincode deploy within ctx and returns a result or an error
+func (s *ServerOpenchainREST) processChaincodeDeploy(ctx context.Context, spec *pb.ChaincodeSpec) rpcResult {
+	s.log().Info("REST deploying chaincode...")
 
 	// Check that the ChaincodeID is not nil.
 	if spec.ChaincodeID == nil {
 		// Format the error appropriately for further processing
 		error := formatRPCError(InvalidParams.Code, InvalidParams.Message, "Payload must contain a ChaincodeID.")
-		restLogger.Error("Payload must contain a ChaincodeID.")
+		s.log().Error("Payload must contain a ChaincodeID.")
 
 		return error
 	}
@@ -1360,7 +1824,7 @@ func (s *ServerOpenchainREST) processChaincodeDeploy(spec *pb.ChaincodeSpec) rpc
 		if spec.ChaincodeID.Name == "" {
 			// Format the error appropriately for further processing
 			error := formatRPCError(InvalidParams.Code, InvalidParams.Message, "Chaincode name may not be blank in development mode.")
-			restLogger.Error("Chaincode name may not be blank in development mode.")
+			s.log().Error("Chaincode name may not be blank in development mode.")
 
 			return error
 		}
@@ -1373,7 +1837,7 @@ func (s *ServerOpenchainREST) processChaincodeDeploy(spec *pb.ChaincodeSpec) rpc
 		if spec.ChaincodeID.Path == "" {
 			// Format the error appropriately for further processing
 			error := formatRPCError(InvalidParams.Code, InvalidParams.Message, "Chaincode path may not be blank.")
-			restLogger.Error("Chaincode path may not be blank.")
+			s.log().Error("Chaincode path may not be blank.")
 
 			return error
 		}
@@ -1383,7 +1847,7 @@ func (s *ServerOpenchainREST) processChaincodeDeploy(spec *pb.ChaincodeSpec) rpc
 	if (spec.CtorMsg == nil) || (spec.CtorMsg.Function == "") {
 		// Format the error appropriately for further processing
 		error := formatRPCError(InvalidParams.Code, InvalidParams.Message, "Payload must contain a CtorMsg with a Chaincode function name.")
-		restLogger.Error("Payload must contain a CtorMsg with a Chaincode function name.")
+		s.log().Error("Payload must contain a CtorMsg with a Chaincode function name.")
 
 		return error
 	}
@@ -1398,7 +1862,7 @@ func (s *ServerOpenchainREST) processChaincodeDeploy(spec *pb.ChaincodeSpec) rpc
 		if chaincodeUsr == "" {
 			// Format the error appropriately for further processing
 			error := formatRPCError(InvalidParams.Code, InvalidParams.Message, "Must supply username for chaincode when security is enabled.")
-			restLogger.Error("Must supply username for chaincode when security is enabled.")
+			s.log().Error("Must supply username for chaincode when security is enabled.")
 
 			return error
 		}
@@ -1410,14 +1874,14 @@ func (s *ServerOpenchainREST) processChaincodeDeploy(spec *pb.ChaincodeSpec) rpc
 		// Check if the user is logged in before sending transaction
 		if _, err := os.Stat(localStore + "loginToken_" + chaincodeUsr); err == nil {
 			// No error returned, therefore token exists so user is already logged in
-			restLogger.Infof("Local user '%s' is already logged in. Retrieving login token.", chaincodeUsr)
+			s.log().Infof("Local user '%s' is already logged in. Retrieving login token.", chaincodeUsr)
 
 			// Read in the login token
 			token, err := ioutil.ReadFile(localStore + "loginToken_" + chaincodeUsr)
 			if err != nil {
 				// Format the error appropriately for further processing
 				error := formatRPCError(InternalError.Code, InternalError.Message, fmt.Sprintf("Fatal error when reading client login token: %s", err))
-				restLogger.Errorf("Fatal error when reading client login token: %s", err)
+				s.log().Errorf("Fatal error when reading client login token: %s", err)
 
 				return error
 			}
@@ -1434,14 +1898,14 @@ func (s *ServerOpenchainREST) processChaincodeDeploy(spec *pb.ChaincodeSpec) rpc
 			if os.IsNotExist(err) {
 				// Format the error appropriately for further processing
 				error := formatRPCError(MissingRegistrationError.Code, MissingRegistrationError.Message, MissingRegistrationError.Data)
-				restLogger.Error(MissingRegistrationError.Data)
+				s.log().Error(MissingRegistrationError.Data)
 
 				return error
 			}
 			// Unexpected error
 			// Format the error appropriately for further processing
 			error := formatRPCError(InternalError.Code, InternalError.Message, fmt.Sprintf("Unexpected fatal error when checking for client login token: %s", err))
-			restLogger.Errorf("Unexpected fatal error when checking for client login token: %s", err)
+			s.log().Errorf("Unexpected fatal error when checking for client login token: %s", err)
 
 			return error
 		}
@@ -1450,7 +1914,7 @@ func (s *ServerOpenchainREST) processChaincodeDeploy(spec *pb.ChaincodeSpec) rpc
 	//
 	// Trigger the chaincode deployment through the devops service
 	//
-	chaincodeDeploymentSpec, err := s.devops.Deploy(context.Background(), spec)
+	chaincodeDeploymentSpec, err := s.devops.Deploy(ctx, spec)
 
 	//
 	// Deployment failed
@@ -1462,7 +1926,7 @@ func (s *ServerOpenchainREST) processChaincodeDeploy(spec *pb.ChaincodeSpec) rpc
 
 		// Format the error appropriately for further processing
 		error := formatRPCError(ChaincodeDeployError.Code, ChaincodeDeployError.Message, fmt.Sprintf("Error when deploying chaincode: %s", errVal))
-		restLogger.Errorf("Error when deploying chaincode: %s", errVal)
+		s.log().Errorf("Error when deploying chaincode: %s", errVal)
 
 		return error
 	}
@@ -1479,20 +1943,20 @@ func (s *ServerOpenchainREST) processChaincodeDeploy(spec *pb.ChaincodeSpec) rpc
 	//
 
 	result := formatRPCOK(chainID)
-	restLogger.Infof("Successfully deployed chainCode: %s", chainID)
+	s.log().Infof("Successfully deployed chainCode: %s", chainID)
 
 	return result
 }
 
-// processChaincodeInvokeOrQuery triggers chaincode invoke or query and returns a result or an error
-func (s *ServerOpenchainREST) processChaincodeInvokeOrQuery(method string, spec *pb.ChaincodeInvocationSpec) rpcResult {
-	restLogger.Infof("REST %s chaincode...", method)
+// processChaincodeInvokeOrQuery triggers chaincode invoke or query within ctx and returns a result or an error
+func (s *ServerOpenchainREST) processChaincodeInvokeOrQuery(ctx context.Context, method string, spec *pb.ChaincodeInvocationSpec) rpcResult {
+	s.log().Infof("REST %s chaincode...", method)
 
 	// Check that the ChaincodeID is not nil.
 	if spec.ChaincodeSpec.ChaincodeID == nil {
 		// Format the error appropriately for further processing
 		error := formatRPCError(InvalidParams.Code, InvalidParams.Message, "Payload must contain a ChaincodeID.")
-		restLogger.Error("Payload must contain a ChaincodeID.")
+		s.log().Error("Payload must contain a ChaincodeID.")
 
 		return error
 	}
@@ -1501,7 +1965,7 @@ func (s *ServerOpenchainREST) processChaincodeInvokeOrQuery(method string, spec
 	if spec.ChaincodeSpec.ChaincodeID.Name == "" {
 		// Format the error appropriately for further processing
 		error := formatRPCError(InvalidParams.Code, InvalidParams.Message, "Chaincode name may not be blank.")
-		restLogger.Error("Chaincode name may not be blank.")
+		s.log().Error("Chaincode name may not be blank.")
 
 		return error
 	}
@@ -1510,68 +1974,14 @@ func (s *ServerOpenchainREST) processChaincodeInvokeOrQuery(method string, spec
 	if (spec.ChaincodeSpec.CtorMsg == nil) || (spec.ChaincodeSpec.CtorMsg.Function == "") {
 		// Format the error appropriately for further processing
 		error := formatRPCError(InvalidParams.Code, InvalidParams.Message, "Payload must contain a CtorMsg with a Chaincode function name.")
-		restLogger.Error("Payload must contain a CtorMsg with a Chaincode function name.")
+		s.log().Error("Payload must contain a CtorMsg with a Chaincode function name.")
 
 		return error
 	}
 
-	//
-	// Check if security is enabled
-	//
-
-	if core.SecurityEnabled() {
-		// User registrationID must be present inside request payload with security enabled
-		chaincodeUsr := spec.ChaincodeSpec.SecureContext
-		if chaincodeUsr == "" {
-			// Format the error appropriately for further processing
-			error := formatRPCError(InvalidParams.Code, InvalidParams.Message, "Must supply username for chaincode when security is enabled.")
-			restLogger.Error("Must supply username for chaincode when security is enabled.")
-
-			return error
-		}
-
-		// Retrieve the REST data storage path
-		// Returns /var/hyperledger/production/client/
-		localStore := getRESTFilePath()
-
-		// Check if the user is logged in before sending transaction
-		if _, err := os.Stat(localStore + "loginToken_" + chaincodeUsr); err == nil {
-			// No error returned, therefore token exists so user is already logged in
-			restLogger.Infof("Local user '%s' is already logged in. Retrieving login token.\n", chaincodeUsr)
-
-			// Read in the login token
-			token, err := ioutil.ReadFile(localStore + "loginToken_" + chaincodeUsr)
-			if err != nil {
-				// Format the error appropriately for further processing
-				error := formatRPCError(InternalError.Code, InternalError.Message, fmt.Sprintf("Fatal error when reading client login token: %s", err))
-				restLogger.Errorf("Fatal error when reading client login token: %s", err)
-
-				return error
-			}
-
-			// Add the login token to the chaincodeSpec
-			spec.ChaincodeSpec.SecureContext = string(token)
-
-			// If privacy is enabled, mark chaincode as confidential
-			if viper.GetBool("security.privacy") {
-				spec.ChaincodeSpec.ConfidentialityLevel = pb.ConfidentialityLevel_CONFIDENTIAL
-			}
-		} else {
-			// Check if the token is not there and fail
-			if os.IsNotExist(err) {
-				// Format the error appropriately for further processing
-				error := formatRPCError(MissingRegistrationError.Code, MissingRegistrationError.Message, MissingRegistrationError.Data)
-				restLogger.Error(MissingRegistrationError.Data)
-
-				return error
-			}
-			// Unexpected error
-			// Format the error appropriately for further processing
-			error := formatRPCError(InternalError.Code, InternalError.Message, fmt.Sprintf("Unexpected fatal error when checking for client login token: %s", err))
-			restLogger.Errorf("Unexpected fatal error when checking for client login token: %s", err)
-
-			return error
-		}
+	// If security is enabled, add client login token
+	if error := addLoginToken(spec.ChaincodeSpec); error != nil {
+		return *error
 	}
 
 	//
@@ -1586,7 +1996,7 @@ func (s *ServerOpenchainREST) processChaincodeInvokeOrQuery(method string, spec
 		// Trigger the chaincode invoke through the devops service
 		//
 
-		resp, err := s.devops.Invoke(context.Background(), spec)
+		resp, err := s.devops.Invoke(ctx, spec)
 
 		//
 		// Invocation failed
@@ -1598,7 +2008,7 @@ func (s *ServerOpenchainREST) processChaincodeInvokeOrQuery(method string, spec
 
 			// Format the error appropriately for further processing
 			error := formatRPCError(ChaincodeInvokeError.Code, ChaincodeInvokeError.Message, fmt.Sprintf("Error when invoking chaincode: %s", errVal))
-			restLogger.Errorf("Error when invoking chaincode: %s", errVal)
+			s.log().Errorf("Error when invoking chaincode: %s", errVal)
 
 			return error
 		}
@@ -1616,7 +2026,7 @@ func (s *ServerOpenchainREST) processChaincodeInvokeOrQuery(method string, spec
 
 		result = formatRPCOK(txuuid)
 		// Make a clarification in the invoke response message, that the transaction has been successfully submitted but not completed
-		restLogger.Infof("Successfully submitted invoke transaction with txuuid (%s)", txuuid)
+		s.log().Infof("Successfully submitted invoke transaction with txuuid (%s)", txuuid)
 	}
 
 	if method == "query" {
@@ -1625,7 +2035,7 @@ func (s *ServerOpenchainREST) processChaincodeInvokeOrQuery(method string, spec
 		// Trigger the chaincode query through the devops service
 		//
 
-		resp, err := s.devops.Query(context.Background(), spec)
+		resp, err := s.devops.Query(ctx, spec)
 
 		//
 		// Query failed
@@ -1637,7 +2047,7 @@ func (s *ServerOpenchainREST) processChaincodeInvokeOrQuery(method string, spec
 
 			// Format the error appropriately for further processing
 			error := formatRPCError(ChaincodeQueryError.Code, ChaincodeQueryError.Message, fmt.Sprintf("Error when querying chaincode: %s", errVal))
-			restLogger.Errorf("Error when querying chaincode: %s", errVal)
+			s.log().Errorf("Error when querying chaincode: %s", errVal)
 
 			return error
 		}
@@ -1654,16 +2064,82 @@ func (s *ServerOpenchainREST) processChaincodeInvokeOrQuery(method string, spec
 		//
 
 		result = formatRPCOK(val)
-		restLogger.Infof("Successfully queried chaincode: %s", val)
+		result.Height = resp.Height
+		if resp.StateHash != nil {
+			result.StateHash = base64.StdEncoding.EncodeToString(resp.StateHash)
+		}
+		s.log().Infof("Successfully queried chaincode at block height %d: %s", resp.Height, val)
 	}
 
 	return result
 }
 
+// addLoginToken sets the login token of the user named in the secure context of
+// spec when security is enabled. It returns nil on success or the error result
+// otherwise.
+func addLoginToken(spec *pb.ChaincodeSpec) *rpcResult {
+	if core.SecurityEnabled() {
+		// User registrationID must be present inside request payload with security enabled
+		chaincodeUsr := spec.SecureContext
+		if chaincodeUsr == "" {
+			// Format the error appropriately for further processing
+			error := formatRPCError(InvalidParams.Code, InvalidParams.Message, "Must supply username for chaincode when security is enabled.")
+			restLogger.Error("Must supply username for chaincode when security is enabled.")
+
+			return &error
+		}
+
+		// Retrieve the REST data storage path
+		// Returns /var/hyperledger/production/client/
+		localStore := getRESTFilePath()
+
+		// Check if the user is logged in before sending transaction
+		if _, err := os.Stat(localStore + "loginToken_" + chaincodeUsr); err == nil {
+			// No error returned, therefore token exists so user is already logged in
+			restLogger.Infof("Local user '%s' is already logged in. Retrieving login token.\n", chaincodeUsr)
+
+			// Read in the login token
+			token, err := ioutil.ReadFile(localStore + "loginToken_" + chaincodeUsr)
+			if err != nil {
+				// Format the error appropriately for further processing
+				error := formatRPCError(InternalError.Code, InternalError.Message, fmt.Sprintf("Fatal error when reading client login token: %s", err))
+				restLogger.Errorf("Fatal error when reading client login token: %s", err)
+
+				return &error
+			}
+
+			// Add the login token to the chaincodeSpec
+			spec.SecureContext = string(token)
+
+			// If privacy is enabled, mark chaincode as confidential
+			if viper.GetBool("security.privacy") {
+				spec.ConfidentialityLevel = pb.ConfidentialityLevel_CONFIDENTIAL
+			}
+		} else {
+			// Check if the token is not there and fail
+			if os.IsNotExist(err) {
+				// Format the error appropriately for further processing
+				error := formatRPCError(MissingRegistrationError.Code, MissingRegistrationError.Message, MissingRegistrationError.Data)
+				restLogger.Error(MissingRegistrationError.Data)
+
+				return &error
+			}
+			// Unexpected error
+			// Format the error appropriately for further processing
+			error := formatRPCError(InternalError.Code, InternalError.Message, fmt.Sprintf("Unexpected fatal error when checking for client login token: %s", err))
+			restLogger.Errorf("Unexpected fatal error when checking for client login token: %s", err)
+
+			return &error
+		}
+	}
+
+	return nil
+}
+
 // GetPeers returns a list of all peer nodes currently connected to the target peer, including itself
 func (s *ServerOpenchainREST) GetPeers(rw web.ResponseWriter, req *web.Request) {
-	peers, err := s.server.GetPeers(context.Background(), &google_protobuf.Empty{})
-	currentPeer, err1 := s.server.GetPeerEndpoint(context.Background(), &google_protobuf.Empty{})
+	peers, err := s.server.GetPeers(req.Context(), &google_protobuf.Empty{})
+	currentPeer, err1 := s.server.GetPeerEndpoint(req.Context(), &google_protobuf.Empty{})
 
 	encoder := json.NewEncoder(rw)
 
@@ -1672,12 +2148,12 @@ func (s *ServerOpenchainREST) GetPeers(rw web.ResponseWriter, req *web.Request)
 		// Failure
 		rw.WriteHeader(http.StatusBadRequest)
 		fmt.Fprintf(rw, "{\"Error\": \"%s\"}", err)
-		restLogger.Errorf("{\"Error\": \"Querying network peers -- %s\"}", err)
+		s.log().Errorf("{\"Error\": \"Querying network peers -- %s\"}", err)
 	} else if err1 != nil {
 		// Failure
 		rw.WriteHeader(http.StatusBadRequest)
 		fmt.Fprintf(rw, "{\"Error\": \"%s\"}", err1)
-		restLogger.Errorf("{\"Error\": \"Accesing target peer endpoint data  -- %s\"}", err1)
+		s.log().Errorf("{\"Error\": \"Accesing target peer endpoint data  -- %s\"}", err1)
 	} else {
 		currentPeerFound := false
 		peersList := peers.Peers
@@ -1692,6 +2168,11 @@ func (s *ServerOpenchainREST) GetPeers(rw web.ResponseWriter, req *web.Request)
 			peersList = append(peersList, currentPeer.Peers...)
 		}
 		peersMessage := &pb.PeersMessage{Peers: peersList}
+		// Only list the peers of an organization if one is requested
+		req.ParseForm()
+		if req.Form["org"] != nil {
+			peersMessage.Peers = peersMessage.OfOrganization(req.Form["org"][0])
+		}
 		// Success
 		rw.WriteHeader(http.StatusOK)
 		encoder.Encode(peersMessage)
@@ -1717,6 +2198,7 @@ func StartOpenchainRESTServer(server *ServerOpenchain, devops *core.Devops) {
 	serverDevops = devops
 
 	// Add middleware
+	router.Middleware((*ServerOpenchainREST).SetRequestID)
 	router.Middleware((*ServerOpenchainREST).SetOpenchainServer)
 	router.Middleware((*ServerOpenchainREST).SetResponseType)
 
@@ -1728,7 +2210,11 @@ func StartOpenchainRESTServer(server *ServerOpenchain, devops *core.Devops) {
 	router.Get("/registrar/:id/tcert", (*ServerOpenchainREST).GetTransactionCert)
 
 	router.Get("/chain", (*ServerOpenchainREST).GetBlockchainInfo)
+	router.Get("/chain/statistics", (*ServerOpenchainREST).GetLedgerStatistics)
+	router.Get("/chain/time", (*ServerOpenchainREST).GetChainTime)
+	router.Get("/chain/blocks", (*ServerOpenchainREST).GetBlocks)
 	router.Get("/chain/blocks/:id", (*ServerOpenchainREST).GetBlockByNumber)
+	router.Get("/chain/blocks/hash/:hash", (*ServerOpenchainREST).GetBlockByHash)
 
 	// The /devops endpoint is now considered deprecated and superseded by the /chaincode endpoint
 	router.Post("/devops/deploy", (*ServerOpenchainREST).Deploy)
@@ -1737,8 +2223,12 @@ func StartOpenchainRESTServer(server *ServerOpenchain, devops *core.Devops) {
 
 	// The /chaincode endpoint which superceedes the /devops endpoint from above
 	router.Post("/chaincode", (*ServerOpenchainREST).ProcessChaincode)
+	router.Post("/chaincode/batch", (*ServerOpenchainREST).ProcessChaincodeBatch)
+	router.Post("/chaincode/stream", (*ServerOpenchainREST).StreamQuery)
 
 	router.Get("/transactions/:uuid", (*ServerOpenchainREST).GetTransactionByUUID)
+	router.Get("/transactions/:uuid/rejection", (*ServerOpenchainREST).GetTransactionRejection)
+	router.Get("/transactions/tags/:key/:value", (*ServerOpenchainREST).GetTransactionsByTag)
 
 	router.Get("/network/peers", (*ServerOpenchainREST).GetPeers)
 
@@ -0,0 +1,282 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rest
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gocraft/web"
+	"github.com/hyperledger/fabric/core/ledger"
+	"github.com/hyperledger/fabric/core/requestid"
+)
+
+func TestServerOpenchainREST_ValidateRPCRequest(t *testing.T) {
+	valid := `{"jsonrpc":"2.0","method":"invoke","params":{"type":1,"chaincodeID":{"name":"mycc"},"ctorMsg":{"function":"invoke","args":["a","b","10"]}},"id":1}`
+	invalid := map[string]int64{
+		`{"method":"invoke","params":{"chaincodeID":{"name":"mycc"},"ctorMsg":{"function":"invoke"}},"id":1}`:                 InvalidRequest.Code,
+		`{"jsonrpc":"1.0","method":"invoke","params":{"chaincodeID":{"name":"mycc"},"ctorMsg":{"function":"invoke"}},"id":1}`: InvalidRequest.Code,
+		`{"jsonrpc":"2.0","method":"delete","params":{"chaincodeID":{"name":"mycc"},"ctorMsg":{"function":"invoke"}},"id":1}`: MethodNotFound.Code,
+		`{"jsonrpc":"2.0","method":"invoke","id":1}`: InvalidParams.Code,
+		`{"jsonrpc":"2.0","method":"query","params":{"chaincodeID":{"name":""},"ctorMsg":{"function":"query"}},"id":1}`: InvalidParams.Code,
+		`{"jsonrpc":"2.0","method":"invoke","params":{"chaincodeID":{"name":"mycc"},"ctorMsg":{"function":""}},"id":1}`: InvalidParams.Code,
+		`{"jsonrpc":"2.0","method":"deploy","params":{"chaincodeID":{"path":""},"ctorMsg":{"function":"init"}},"id":1}`: InvalidParams.Code,
+	}
+
+	var request rpcRequest
+	if err := json.Unmarshal([]byte(valid), &request); err != nil {
+		t.Fatalf("Error unmarshalling request: %s", err)
+	}
+	if result := validateRPCRequest(&request); result != nil {
+		t.Fatalf("Expected request to be valid, got error: %v", result.Error)
+	}
+
+	for payload, code := range invalid {
+		var request rpcRequest
+		if err := json.Unmarshal([]byte(payload), &request); err != nil {
+			t.Fatalf("Error unmarshalling request: %s", err)
+		}
+		result := validateRPCRequest(&request)
+		if result == nil {
+			t.Fatalf("Expected request to be invalid: %s", payload)
+		}
+		if result.Error.Code != code {
+			t.Fatalf("Expected error code %d, got %d for request: %s", code, result.Error.Code, payload)
+		}
+	}
+}
+
+func TestServerOpenchainREST_ProcessChaincodeBatch_FailOnFirstError(t *testing.T) {
+	router := web.New(ServerOpenchainREST{})
+	router.Post("/chaincode/batch", (*ServerOpenchainREST).ProcessChaincodeBatch)
+
+	batch := `{"failOnFirstError":true,"requests":[
+		{"jsonrpc":"2.0","method":"invoke","params":{"chaincodeID":{"name":"mycc"},"ctorMsg":{"function":"invoke"}},"id":1},
+		{"jsonrpc":"2.0","method":"invoke","params":{"chaincodeID":{"name":"mycc"},"ctorMsg":{"function":""}},"id":2},
+		{"jsonrpc":"2.0","method":"invoke","params":{"chaincodeID":{"name":"mycc"},"ctorMsg":{"function":"invoke"}}}]}`
+
+	recorder := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/chaincode/batch", strings.NewReader(batch))
+	router.ServeHTTP(recorder, req)
+
+	if recorder.Code != http.StatusBadRequest {
+		t.Fatalf("Expected status %d, got %d", http.StatusBadRequest, recorder.Code)
+	}
+
+	var responses []rpcResponse
+	if err := json.Unmarshal(recorder.Body.Bytes(), &responses); err != nil {
+		t.Fatalf("Error unmarshalling batch response: %s", err)
+	}
+	// The notification does not get a response
+	if len(responses) != 2 {
+		t.Fatalf("Expected 2 responses, got %d", len(responses))
+	}
+	if responses[0].Error == nil || responses[0].Error.Code != BatchAbortedError.Code {
+		t.Fatalf("Expected first request to be aborted, got %v", responses[0])
+	}
+	if responses[1].Error == nil || responses[1].Error.Code != InvalidParams.Code {
+		t.Fatalf("Expected second request to be invalid, got %v", responses[1])
+	}
+}
+
+func TestServerOpenchainREST_GetBlockByHash_ETag(t *testing.T) {
+	ledger1 := ledger.InitTestLedger(t)
+	buildTestLedger1(ledger1, t)
+	server, err := NewOpenchainServerWithPeerInfo(new(peerInfo))
+	if err != nil {
+		t.Fatalf("Error creating OpenchainServer: %s", err)
+	}
+	server.ledger = ledger1
+
+	block, err := ledger1.GetBlockByNumber(1)
+	if err != nil {
+		t.Fatalf("Error retrieving block: %s", err)
+	}
+	blockHash, err := block.GetHash()
+	if err != nil {
+		t.Fatalf("Error computing block hash: %s", err)
+	}
+
+	router := web.New(ServerOpenchainREST{})
+	router.Middleware(func(s *ServerOpenchainREST, rw web.ResponseWriter, req *web.Request, next web.NextMiddlewareFunc) {
+		s.server = server
+		next(rw, req)
+	})
+	router.Get("/chain/blocks/:id", (*ServerOpenchainREST).GetBlockByNumber)
+	router.Get("/chain/blocks/hash/:hash", (*ServerOpenchainREST).GetBlockByHash)
+
+	get := func(path string, ifNoneMatch string) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", path, nil)
+		if ifNoneMatch != "" {
+			req.Header.Set("If-None-Match", ifNoneMatch)
+		}
+		router.ServeHTTP(recorder, req)
+		return recorder
+	}
+
+	byHash := "/chain/blocks/hash/" + hex.EncodeToString(blockHash)
+	recorder := get(byHash, "")
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, recorder.Code, recorder.Body.String())
+	}
+	etag := recorder.Header().Get("ETag")
+	if etag != blockETag(blockHash) {
+		t.Fatalf("Expected ETag %s, got %s", blockETag(blockHash), etag)
+	}
+	if cc := recorder.Header().Get("Cache-Control"); cc != cacheImmutable {
+		t.Fatalf("Expected Cache-Control %s, got %s", cacheImmutable, cc)
+	}
+
+	// The block looked up by number has the same tag, but must be revalidated
+	recorder = get("/chain/blocks/1", "")
+	if recorder.Code != http.StatusOK || recorder.Header().Get("ETag") != etag {
+		t.Fatalf("Expected status %d and ETag %s, got %d and %s", http.StatusOK, etag, recorder.Code, recorder.Header().Get("ETag"))
+	}
+	if cc := recorder.Header().Get("Cache-Control"); cc != cacheRevalidate {
+		t.Fatalf("Expected Cache-Control %s, got %s", cacheRevalidate, cc)
+	}
+
+	for _, ifNoneMatch := range []string{etag, strings.TrimPrefix(etag, "W/"), `"other", ` + etag, "*"} {
+		for _, path := range []string{byHash, "/chain/blocks/1"} {
+			recorder = get(path, ifNoneMatch)
+			if recorder.Code != http.StatusNotModified || recorder.Body.Len() != 0 {
+				t.Fatalf("Expected status %d with no body for %s with If-None-Match %s, got %d", http.StatusNotModified, path, ifNoneMatch, recorder.Code)
+			}
+		}
+	}
+
+	recorder = get("/chain/blocks/2", etag)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("Expected status %d for a different block, got %d", http.StatusOK, recorder.Code)
+	}
+
+	recorder = get("/chain/blocks/hash/"+hex.EncodeToString([]byte("NotAnActualHash")), "")
+	if recorder.Code != http.StatusNotFound {
+		t.Fatalf("Expected status %d, got %d", http.StatusNotFound, recorder.Code)
+	}
+	recorder = get("/chain/blocks/hash/!!", "")
+	if recorder.Code != http.StatusBadRequest {
+		t.Fatalf("Expected status %d, got %d", http.StatusBadRequest, recorder.Code)
+	}
+}
+
+func TestServerOpenchainREST_GetBlockByNumber_Decode(t *testing.T) {
+	ledger1 := ledger.InitTestLedger(t)
+	buildTestLedger1(ledger1, t)
+	server, err := NewOpenchainServerWithPeerInfo(new(peerInfo))
+	if err != nil {
+		t.Fatalf("Error creating OpenchainServer: %s", err)
+	}
+	server.ledger = ledger1
+
+	block, err := ledger1.GetBlockByNumber(1)
+	if err != nil {
+		t.Fatalf("Error retrieving block: %s", err)
+	}
+	blockHash, err := block.GetHash()
+	if err != nil {
+		t.Fatalf("Error computing block hash: %s", err)
+	}
+
+	router := web.New(ServerOpenchainREST{})
+	router.Middleware(func(s *ServerOpenchainREST, rw web.ResponseWriter, req *web.Request, next web.NextMiddlewareFunc) {
+		s.server = server
+		next(rw, req)
+	})
+	router.Get("/chain/blocks/:id", (*ServerOpenchainREST).GetBlockByNumber)
+	router.Get("/chain/blocks/hash/:hash", (*ServerOpenchainREST).GetBlockByHash)
+	router.Get("/transactions/:uuid", (*ServerOpenchainREST).GetTransactionByUUID)
+
+	get := func(path string) (*httptest.ResponseRecorder, map[string]interface{}) {
+		recorder := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", path, nil)
+		router.ServeHTTP(recorder, req)
+		if recorder.Code != http.StatusOK {
+			t.Fatalf("Expected status %d for %s, got %d: %s", http.StatusOK, path, recorder.Code, recorder.Body.String())
+		}
+		var body map[string]interface{}
+		if err := json.Unmarshal(recorder.Body.Bytes(), &body); err != nil {
+			t.Fatalf("Error unmarshalling the response to %s: %s", path, err)
+		}
+		return recorder, body
+	}
+
+	recorder, body := get("/chain/blocks/1?decode=true")
+	if etag := recorder.Header().Get("ETag"); etag != decodedBlockETag(blockHash) {
+		t.Fatalf("Expected ETag %s, got %s", decodedBlockETag(blockHash), etag)
+	}
+	if body["number"] != float64(1) || body["hash"] != hex.EncodeToString(blockHash) {
+		t.Fatalf("Expected the number and hash of block 1, got %v and %v", body["number"], body["hash"])
+	}
+	delta, _ := body["stateDelta"].(map[string]interface{})
+	if update, _ := delta["MyContract1"].(map[string]interface{}); update == nil || update["code"].(map[string]interface{})["value"] != "code example" {
+		t.Fatalf("Expected the state delta of block 1, got %v", body["stateDelta"])
+	}
+	txs, _ := body["transactions"].([]interface{})
+	if len(txs) != 1 {
+		t.Fatalf("Expected 1 transaction, got %v", body["transactions"])
+	}
+	uuid := txs[0].(map[string]interface{})["uuid"].(string)
+
+	_, body = get("/chain/blocks/hash/" + hex.EncodeToString(blockHash) + "?decode=1")
+	if body["hash"] != hex.EncodeToString(blockHash) || body["number"] != nil {
+		t.Fatalf("Expected the hash of block 1 and no number, got %v and %v", body["hash"], body["number"])
+	}
+
+	_, body = get("/transactions/" + uuid + "?decode=true")
+	if body["uuid"] != uuid || body["chaincodeID"].(map[string]interface{})["path"] != "Contracts" {
+		t.Fatalf("Expected transaction %s of chaincode Contracts, got %v", uuid, body)
+	}
+}
+
+func TestServerOpenchainREST_SetRequestID(t *testing.T) {
+	var seen string
+	router := web.New(ServerOpenchainREST{})
+	router.Middleware((*ServerOpenchainREST).SetRequestID)
+	router.Get("/chain", func(s *ServerOpenchainREST, rw web.ResponseWriter, req *web.Request) {
+		seen, _ = requestid.FromContext(req.Context())
+	})
+
+	get := func(id string) string {
+		recorder := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/chain", nil)
+		if id != "" {
+			req.Header.Set(requestid.HeaderName, id)
+		}
+		router.ServeHTTP(recorder, req)
+		returned := recorder.Header().Get(requestid.HeaderName)
+		if returned != seen {
+			t.Fatalf("Expected the request ID %q seen by the handler to be returned, got %q", seen, returned)
+		}
+		return returned
+	}
+
+	if id := get(""); !requestid.Valid(id) {
+		t.Fatalf("Expected a generated request ID, got %q", id)
+	}
+	if id := get("from-client"); id != "from-client" {
+		t.Fatalf("Expected the request ID of the client, got %q", id)
+	}
+	if id := get("not valid"); id == "not valid" || !requestid.Valid(id) {
+		t.Fatalf("Expected an invalid request ID to be replaced, got %q", id)
+	}
+}
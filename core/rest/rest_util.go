@@ -16,7 +16,22 @@ limitations under the License.
 
 package rest
 
-import "encoding/json"
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Cache-Control values for the responses of read endpoints. Blocks looked up by
+// hash never change, everything else may and must be revalidated.
+const (
+	cacheImmutable  = "public, max-age=31536000, immutable"
+	cacheRevalidate = "no-cache"
+)
 
 // isJSON is a helper function to determine if a given string is proper JSON.
 func isJSON(s string) bool {
@@ -24,6 +39,55 @@ func isJSON(s string) bool {
 	return json.Unmarshal([]byte(s), &js) == nil
 }
 
+// blockETag returns the entity tag of a block, derived from its hash. The tag is
+// weak as the non hash data of a block differs between peers.
+func blockETag(blockHash []byte) string {
+	return fmt.Sprintf("W/\"%x\"", blockHash)
+}
+
+// decodedBlockETag returns the entity tag of the decoded rendering of a block
+func decodedBlockETag(blockHash []byte) string {
+	return fmt.Sprintf("W/\"%x-decoded\"", blockHash)
+}
+
+// decodeRequested reports whether a request asks, with the decode query
+// parameter, for blocks and transactions rendered with their fields decoded
+// rather than as the JSON encoding of their protobuf messages.
+func decodeRequested(req *http.Request) bool {
+	decode, _ := strconv.ParseBool(req.URL.Query().Get("decode"))
+	return decode
+}
+
+// checkNotModified sets the ETag and Cache-Control headers of a response and
+// reports whether the request carries an If-None-Match header matching the ETag,
+// in which case the 304 response has been written and nothing else may be.
+func checkNotModified(rw http.ResponseWriter, req *http.Request, etag string, cacheControl string) bool {
+	rw.Header().Set("ETag", etag)
+	rw.Header().Set("Cache-Control", cacheControl)
+
+	// If-None-Match uses the weak comparison, which ignores the W/ prefix
+	for _, candidate := range strings.Split(req.Header.Get("If-None-Match"), ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/") {
+			rw.WriteHeader(http.StatusNotModified)
+			return true
+		}
+	}
+	return false
+}
+
+// decodeBlockHash decodes a block hash given in a request path, either in hex or
+// in base64 as blocks hashes appear in JSON responses.
+func decodeBlockHash(s string) ([]byte, error) {
+	if hash, err := hex.DecodeString(s); err == nil {
+		return hash, nil
+	}
+	if hash, err := base64.URLEncoding.DecodeString(s); err == nil {
+		return hash, nil
+	}
+	return base64.StdEncoding.DecodeString(s)
+}
+
 // formatRPCError formats the ERROR response to aid in JSON RPC 2.0 implementation
 func formatRPCError(code int64, msg string, data string) rpcResult {
 	err := &rpcError{Code: code, Message: msg, Data: data}
@@ -0,0 +1,75 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package startup
+
+import (
+	"expvar"
+	"time"
+
+	"github.com/op/go-logging"
+)
+
+// The phases of the start of the peer are timed, logged, and published with
+// expvar under "startup", in milliseconds, which the profiling server serves
+// under /debug/vars. Components which the peer does not need to serve its
+// first requests are initialized on first use instead; the time their
+// initialization takes is published alike, as "lazy.<component>".
+
+var logger = logging.MustGetLogger("startup")
+
+var durations = expvar.NewMap("startup")
+
+// processStart approximates the start of the process, as packages are
+// initialized before main runs
+var processStart = time.Now()
+
+// Phase starts timing a phase of the start of the peer, and returns the
+// function ending it
+func Phase(name string) func() {
+	begin := time.Now()
+	return func() {
+		record(name, time.Since(begin))
+	}
+}
+
+// Lazy starts timing the lazy initialization of a component, and returns the
+// function ending it
+func Lazy(component string) func() {
+	return Phase("lazy." + component)
+}
+
+// Complete records the time elapsed since the start of the process, once the
+// peer is ready to serve
+func Complete() {
+	record("total", time.Since(processStart))
+}
+
+// GetDuration returns the duration recorded for a phase
+func GetDuration(name string) (time.Duration, bool) {
+	v, ok := durations.Get(name).(*expvar.Float)
+	if !ok {
+		return 0, false
+	}
+	return time.Duration(v.Value() * float64(time.Millisecond)), true
+}
+
+func record(name string, d time.Duration) {
+	logger.Infof("Startup phase %s took %s", name, d)
+	ms := new(expvar.Float)
+	ms.Set(float64(d) / float64(time.Millisecond))
+	durations.Set(name, ms)
+}
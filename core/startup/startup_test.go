@@ -0,0 +1,45 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package startup
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPhase(t *testing.T) {
+	if _, ok := GetDuration("test"); ok {
+		t.Fatalf("Expected no duration before the phase ends")
+	}
+	done := Phase("test")
+	time.Sleep(10 * time.Millisecond)
+	done()
+	d, ok := GetDuration("test")
+	if !ok || d < 10*time.Millisecond {
+		t.Fatalf("Expected the phase to last at least 10ms, got %s", d)
+	}
+
+	Lazy("component")()
+	if _, ok := GetDuration("lazy.component"); !ok {
+		t.Fatalf("Expected the lazy initialization to be recorded")
+	}
+
+	Complete()
+	if total, _ := GetDuration("total"); total < d {
+		t.Fatalf("Expected the total to include the phases, got %s", total)
+	}
+}
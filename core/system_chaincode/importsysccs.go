@@ -17,17 +17,29 @@ limitations under the License.
 package system_chaincode
 
 import (
+	"github.com/spf13/viper"
+
 	"github.com/hyperledger/fabric/core/system_chaincode/api"
 	//import system chain codes here
+	"github.com/hyperledger/fabric/core/system_chaincode/scheduler"
 )
 
 //see systemchaincode_test.go for an example using "sample_syscc"
-var systemChaincodes = []*api.SystemChaincode{}
+var systemChaincodes = []*api.SystemChaincode{
+	{
+		Name:      scheduler.ChaincodeName,
+		Path:      "github.com/hyperledger/fabric/core/system_chaincode/scheduler",
+		InitArgs:  []string{},
+		Chaincode: &scheduler.SchedulerSysCC{},
+	},
+}
 
 //RegisterSysCCs is the hook for system chaincodes where system chaincodes are registered with the fabric
 //note the chaincode must still be deployed and launched like a user chaincode will be
 func RegisterSysCCs() {
 	for _, sysCC := range systemChaincodes {
+		//chaincode.system.<name> in the configuration enables a system chaincode
+		sysCC.Enabled = sysCC.Enabled || viper.GetBool("chaincode.system."+sysCC.Name)
 		api.RegisterSysCC(sysCC)
 	}
 }
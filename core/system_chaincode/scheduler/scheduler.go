@@ -0,0 +1,274 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package scheduler
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/op/go-logging"
+	"github.com/spf13/viper"
+	google_protobuf "google/protobuf"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/core/ledger"
+	pb "github.com/hyperledger/fabric/protos"
+)
+
+var logger = logging.MustGetLogger("scheduler")
+
+// The scheduler holds invoke transactions until a future block: either the
+// block of a given number, or the first block whose consensus time reaches a
+// given time. The transactions are kept in the state of the scheduler, and
+// every validating peer removes the transactions due at the beginning of the
+// batch building a block and executes them ahead of the transactions of the
+// batch, in the order of their key. As the scheduled transactions are part
+// of the state, all validating peers, including those catching up through
+// state transfer, execute the same transactions in the same blocks.
+//
+// The transactions are executed as they were submitted, and are checked
+// like any other then; when security is enabled, their signature must still
+// verify at the time they execute.
+
+// ChaincodeName is the name the scheduler is deployed with, and the ledger
+// namespace holding the scheduled transactions
+const ChaincodeName = "scheduler"
+
+// Keys of the scheduled transactions
+// "height/" + <block number> + "/" + <uuid> -> transaction due at that block
+// "time/" + <unix time in ns> + "/" + <uuid> -> transaction due at that time
+const (
+	heightPrefix = "height/"
+	timePrefix   = "time/"
+)
+
+func heightKey(blockNumber uint64, uuid string) string {
+	return fmt.Sprintf("%s%020d/%s", heightPrefix, blockNumber, uuid)
+}
+
+func timeKey(nanos int64, uuid string) string {
+	return fmt.Sprintf("%s%020d/%s", timePrefix, nanos, uuid)
+}
+
+// Enabled returns whether the scheduler is enabled on this peer. The
+// validating peers of a network must agree on it.
+func Enabled() bool {
+	return viper.GetBool("chaincode.system.scheduler")
+}
+
+// SchedulerSysCC is the system chaincode holding the scheduled transactions
+type SchedulerSysCC struct {
+}
+
+// Init does nothing, the scheduler starts with no transactions
+func (t *SchedulerSysCC) Init(stub *shim.ChaincodeStub, function string, args []string) ([]byte, error) {
+	return nil, nil
+}
+
+// Invoke schedules a transaction, given as the base64 of its protobuf
+// encoding: "atHeight" with the number of the block to execute it in, or
+// "atTime" with the RFC 3339 time from which to execute it. It returns the
+// key of the scheduled transaction.
+func (t *SchedulerSysCC) Invoke(stub *shim.ChaincodeStub, function string, args []string) ([]byte, error) {
+	if len(args) != 2 {
+		return nil, errors.New("Incorrect number of arguments. Expecting the block number or time, and the transaction")
+	}
+	tx, err := parseTransaction(args[1])
+	if err != nil {
+		return nil, err
+	}
+
+	var key string
+	switch function {
+	case "atHeight":
+		blockNumber, err := strconv.ParseUint(args[0], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid block number %s: %s", args[0], err)
+		}
+		// The transaction goes into the block numbered after the current height
+		height, err := stub.GetBlockHeight()
+		if err != nil {
+			return nil, err
+		}
+		if blockNumber <= height {
+			return nil, fmt.Errorf("Block %d is not a future block, the current block is %d", blockNumber, height)
+		}
+		key = heightKey(blockNumber, tx.Uuid)
+	case "atTime":
+		at, err := time.Parse(time.RFC3339Nano, args[0])
+		if err != nil {
+			return nil, fmt.Errorf("Invalid time %s: %s", args[0], err)
+		}
+		key = timeKey(at.UnixNano(), tx.Uuid)
+	default:
+		return nil, errors.New("Invalid invoke function name. Expecting \"atHeight\" or \"atTime\"")
+	}
+
+	existing, err := stub.GetState(key)
+	if err != nil {
+		return nil, err
+	}
+	if existing != nil {
+		return nil, fmt.Errorf("Transaction %s is already scheduled", tx.Uuid)
+	}
+	raw, err := proto.Marshal(tx)
+	if err != nil {
+		return nil, err
+	}
+	if err = stub.PutState(key, raw); err != nil {
+		return nil, err
+	}
+	return []byte(key), nil
+}
+
+// parseTransaction decodes a transaction to schedule, which must invoke a
+// chaincode other than the scheduler
+func parseTransaction(encoded string) (*pb.Transaction, error) {
+	raw, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid transaction encoding: %s", err)
+	}
+	tx := &pb.Transaction{}
+	if err = proto.Unmarshal(raw, tx); err != nil {
+		return nil, fmt.Errorf("Invalid transaction: %s", err)
+	}
+	if tx.Type != pb.Transaction_CHAINCODE_INVOKE {
+		return nil, fmt.Errorf("Only invoke transactions can be scheduled, got %s", tx.Type)
+	}
+	if tx.Uuid == "" {
+		return nil, errors.New("The transaction has no uuid")
+	}
+	chaincodeID := &pb.ChaincodeID{}
+	if err = proto.Unmarshal(tx.ChaincodeID, chaincodeID); err != nil {
+		return nil, fmt.Errorf("Invalid chaincode ID of the transaction: %s", err)
+	}
+	if chaincodeID.Name == ChaincodeName {
+		return nil, errors.New("The scheduler cannot schedule its own transactions")
+	}
+	return tx, nil
+}
+
+// scheduledTransaction describes a scheduled transaction in the reply of the
+// "list" query
+type scheduledTransaction struct {
+	Key  string `json:"key"`
+	UUID string `json:"uuid"`
+}
+
+// Query "list" returns the JSON array of the scheduled transactions, in the
+// order they execute in
+func (t *SchedulerSysCC) Query(stub *shim.ChaincodeStub, function string, args []string) ([]byte, error) {
+	if function != "list" {
+		return nil, errors.New("Invalid query function name. Expecting \"list\"")
+	}
+	list := []scheduledTransaction{}
+	for _, prefix := range []string{heightPrefix, timePrefix} {
+		iter, err := stub.RangeQueryState(prefix, prefix+"~")
+		if err != nil {
+			return nil, err
+		}
+		var scheduled []scheduledTransaction
+		for iter.HasNext() {
+			key, value, err := iter.Next()
+			if err != nil {
+				iter.Close()
+				return nil, err
+			}
+			tx := &pb.Transaction{}
+			if err = proto.Unmarshal(value, tx); err != nil {
+				iter.Close()
+				return nil, fmt.Errorf("Invalid scheduled transaction %s: %s", key, err)
+			}
+			scheduled = append(scheduled, scheduledTransaction{Key: key, UUID: tx.Uuid})
+		}
+		iter.Close()
+		sort.Sort(byKey(scheduled))
+		list = append(list, scheduled...)
+	}
+	return json.Marshal(list)
+}
+
+type byKey []scheduledTransaction
+
+func (a byKey) Len() int           { return len(a) }
+func (a byKey) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a byKey) Less(i, j int) bool { return a[i].Key < a[j].Key }
+
+// DueTransactions removes the transactions due in the block the current
+// tx-batch builds from the ledger, and returns them in the order to execute
+// them: those scheduled for the block, then those scheduled for a time up to
+// timestamp, the consensus time of the batch, each by key. It is called
+// between the txs of the tx-batch. Without timestamp, only the transactions
+// scheduled for the block are due.
+func DueTransactions(ledgerObj *ledger.Ledger, timestamp *google_protobuf.Timestamp) ([]*pb.Transaction, error) {
+	// Range scans include their end key, no key equals the prefix of the keys
+	// of the next block or nanosecond
+	ranges := [][2]string{{heightPrefix, heightKey(ledgerObj.GetBlockchainSize()+1, "")}}
+	if timestamp != nil {
+		now := time.Unix(timestamp.Seconds, int64(timestamp.Nanos)).UnixNano()
+		ranges = append(ranges, [2]string{timePrefix, timeKey(now+1, "")})
+	}
+
+	var txs []*pb.Transaction
+	for _, r := range ranges {
+		keys, values, err := scanDue(ledgerObj, r[0], r[1])
+		if err != nil {
+			return nil, err
+		}
+		for _, key := range keys {
+			if err = ledgerObj.DeleteStateAtBlockBoundary(ChaincodeName, key); err != nil {
+				return nil, err
+			}
+			tx := &pb.Transaction{}
+			if err = proto.Unmarshal(values[key], tx); err != nil {
+				logger.Warningf("Dropping invalid scheduled transaction %s: %s", key, err)
+				continue
+			}
+			logger.Debugf("Executing scheduled transaction %s", key)
+			txs = append(txs, tx)
+		}
+	}
+	return txs, nil
+}
+
+// scanDue returns the keys between start and end, sorted, and their values
+func scanDue(ledgerObj *ledger.Ledger, start string, end string) ([]string, map[string][]byte, error) {
+	iter, err := ledgerObj.GetStateRangeScanIterator(ChaincodeName, start, end, false)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer iter.Close()
+	var keys []string
+	values := make(map[string][]byte)
+	for iter.Next() {
+		key, value := iter.GetKeyValue()
+		if value == nil {
+			// Deleted earlier in the tx-batch
+			continue
+		}
+		keys = append(keys, key)
+		values[key] = value
+	}
+	sort.Strings(keys)
+	return keys, values, nil
+}
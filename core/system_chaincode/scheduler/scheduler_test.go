@@ -0,0 +1,143 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package scheduler
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/spf13/viper"
+	google_protobuf "google/protobuf"
+
+	"github.com/hyperledger/fabric/core/ledger"
+	pb "github.com/hyperledger/fabric/protos"
+)
+
+func TestMain(m *testing.M) {
+	viper.SetConfigName("core")
+	viper.AddConfigPath("./../../../peer")
+	if err := viper.ReadInConfig(); err != nil {
+		panic(fmt.Errorf("Fatal error config file: %s \n", err))
+	}
+	tempDir, err := ioutil.TempDir("", "scheduler-test")
+	if err != nil {
+		panic(err)
+	}
+	viper.Set("peer.fileSystemPath", tempDir)
+	ret := m.Run()
+	os.RemoveAll(tempDir)
+	os.Exit(ret)
+}
+
+func newInvoke(t *testing.T, chaincode string, uuid string) *pb.Transaction {
+	tx, err := pb.NewChaincodeExecute(&pb.ChaincodeInvocationSpec{
+		ChaincodeSpec: &pb.ChaincodeSpec{ChaincodeID: &pb.ChaincodeID{Name: chaincode}},
+	}, uuid, pb.Transaction_CHAINCODE_INVOKE)
+	if err != nil {
+		t.Fatalf("Failed to create transaction: %s", err)
+	}
+	return tx
+}
+
+func encode(t *testing.T, tx *pb.Transaction) string {
+	raw, err := proto.Marshal(tx)
+	if err != nil {
+		t.Fatalf("Failed to marshal transaction: %s", err)
+	}
+	return base64.StdEncoding.EncodeToString(raw)
+}
+
+func TestParseTransaction(t *testing.T) {
+	if tx, err := parseTransaction(encode(t, newInvoke(t, "mycc", "tx1"))); err != nil || tx.Uuid != "tx1" {
+		t.Fatalf("Expected the invoke to be accepted, got %v, %v", tx, err)
+	}
+
+	query := newInvoke(t, "mycc", "tx2")
+	query.Type = pb.Transaction_CHAINCODE_QUERY
+	for name, encoded := range map[string]string{
+		"not base64": "!",
+		"query":      encode(t, query),
+		"no uuid":    encode(t, newInvoke(t, "mycc", "")),
+		"scheduler":  encode(t, newInvoke(t, ChaincodeName, "tx3")),
+	} {
+		if _, err := parseTransaction(encoded); err == nil {
+			t.Errorf("Expected the %s transaction to be rejected", name)
+		}
+	}
+}
+
+func TestDueTransactions(t *testing.T) {
+	ledgerObj := ledger.InitTestLedger(t)
+
+	// Block 0 schedules the transactions
+	scheduled := map[string]string{
+		heightKey(1, "b"): "b",
+		heightKey(1, "a"): "a",
+		heightKey(2, "c"): "c",
+		timeKey(100, "d"): "d",
+		timeKey(200, "e"): "e",
+	}
+	ledgerObj.BeginTxBatch(0)
+	ledgerObj.TxBegin("schedule")
+	for key, uuid := range scheduled {
+		raw, _ := proto.Marshal(newInvoke(t, "mycc", uuid))
+		if err := ledgerObj.SetState(ChaincodeName, key, raw); err != nil {
+			t.Fatalf("Failed to set state: %s", err)
+		}
+	}
+	ledgerObj.TxFinished("schedule", true)
+	if err := ledgerObj.CommitTxBatch(0, []*pb.Transaction{newInvoke(t, ChaincodeName, "schedule")}, nil, nil); err != nil {
+		t.Fatalf("Failed to commit: %s", err)
+	}
+
+	due := func(id int, timestamp *google_protobuf.Timestamp, expected ...string) {
+		ledgerObj.BeginTxBatch(id)
+		txs, err := DueTransactions(ledgerObj, timestamp)
+		if err != nil {
+			t.Fatalf("Failed to get the due transactions: %s", err)
+		}
+		// Asking again within the batch returns nothing, the transactions are removed
+		if again, _ := DueTransactions(ledgerObj, timestamp); len(again) != 0 {
+			t.Errorf("Expected the due transactions to be removed, got %d again", len(again))
+		}
+		if err = ledgerObj.CommitTxBatch(id, txs, nil, nil); err != nil {
+			t.Fatalf("Failed to commit: %s", err)
+		}
+		if len(txs) != len(expected) {
+			t.Fatalf("Block %d: expected %d transactions, got %d", id, len(expected), len(txs))
+		}
+		for i, tx := range txs {
+			if tx.Uuid != expected[i] {
+				t.Errorf("Block %d: expected transaction %d to be %s, got %s", id, i, expected[i], tx.Uuid)
+			}
+		}
+	}
+
+	due(1, &google_protobuf.Timestamp{Nanos: 50}, "a", "b")
+	due(2, &google_protobuf.Timestamp{Nanos: 200}, "c", "d", "e")
+	due(3, &google_protobuf.Timestamp{Seconds: 1})
+
+	for key := range scheduled {
+		if value, _ := ledgerObj.GetState(ChaincodeName, key, true); value != nil {
+			t.Errorf("Expected %s to be deleted", key)
+		}
+	}
+}
@@ -0,0 +1,260 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package txid generates the IDs of the transactions the peer creates, and
+// checks the IDs of the invoke transactions submitted to it before they are
+// ordered. The scheme of the IDs, peer.txid.scheme, is chosen per network:
+// with the uuid scheme, IDs are random RFC 4122 UUIDs; with the hash scheme,
+// an ID is a random salt followed by the hash of the salt and the payload of
+// the transaction, so that an ID cannot be reused for another payload. An ID
+// which is already committed within the last peer.txid.window blocks, or
+// which the peer already admitted within that window, is turned down.
+package txid
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"regexp"
+	"sync"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/op/go-logging"
+	"github.com/spf13/viper"
+
+	"github.com/hyperledger/fabric/core/ledger"
+	"github.com/hyperledger/fabric/core/util"
+	pb "github.com/hyperledger/fabric/protos"
+)
+
+var logger = logging.MustGetLogger("txid")
+
+// Schemes of transaction IDs
+const (
+	// SchemeUUID generates random RFC 4122 UUIDs
+	SchemeUUID = "uuid"
+	// SchemeHash generates a random salt followed by the hash of the salt and the payload
+	SchemeHash = "hash"
+)
+
+const saltLength = 16
+
+var (
+	uuidFormat = regexp.MustCompile("^[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}$")
+	hashFormat = regexp.MustCompile("^[0-9a-f]{32}-[0-9a-f]{128}$")
+)
+
+// InvalidIDError is reported for a transaction whose ID does not follow the
+// scheme of the network
+type InvalidIDError struct {
+	UUID   string
+	Scheme string
+	Reason string
+}
+
+func (e *InvalidIDError) Error() string {
+	return fmt.Sprintf("Transaction ID [%s] is not a valid %s ID: %s", e.UUID, e.Scheme, e.Reason)
+}
+
+// DuplicateIDError is reported for a transaction whose ID was already used
+// within the window. BlockNumber is the block which committed it, if it was
+type DuplicateIDError struct {
+	UUID        string
+	Committed   bool
+	BlockNumber uint64
+}
+
+func (e *DuplicateIDError) Error() string {
+	if e.Committed {
+		return fmt.Sprintf("Transaction ID [%s] was already used, by a transaction committed in block [%d]", e.UUID, e.BlockNumber)
+	}
+	return fmt.Sprintf("Transaction ID [%s] was already used, by a transaction submitted to this peer", e.UUID)
+}
+
+// index is the part of the ledger the checker looks IDs up in
+type index interface {
+	GetBlockchainSize() uint64
+	GetTransactionBlockNumberByUUID(txUUID string) (uint64, error)
+}
+
+type checker struct {
+	sync.Mutex
+	scheme   string
+	window   uint64 // In blocks, 0 disables the uniqueness check
+	index    index
+	admitted map[string]uint64 // Height of the chain when each ID was admitted
+	pruned   uint64            // Height of the chain when admitted was last pruned
+}
+
+var theChecker *checker
+var theCheckerLock sync.Mutex
+
+// getChecker returns the checker, configured on first use
+func getChecker() *checker {
+	theCheckerLock.Lock()
+	defer theCheckerLock.Unlock()
+	if theChecker == nil {
+		window := viper.GetInt("peer.txid.window")
+		if window < 0 {
+			panic(fmt.Errorf("Transaction ID window must be greater than or equal to 0. Current value is %d.", window))
+		}
+		var idx index
+		if lgr, err := ledger.GetLedger(); err != nil {
+			logger.Warningf("Transaction IDs are not checked against the ledger, which is not available: %s", err)
+		} else {
+			idx = lgr
+		}
+		theChecker = newChecker(Scheme(), uint64(window), idx)
+		logger.Infof("Transaction IDs follow the %s scheme, unique within %d blocks", theChecker.scheme, theChecker.window)
+	}
+	return theChecker
+}
+
+func newChecker(scheme string, window uint64, idx index) *checker {
+	return &checker{
+		scheme:   scheme,
+		window:   window,
+		index:    idx,
+		admitted: make(map[string]uint64),
+	}
+}
+
+// Scheme returns the scheme of the transaction IDs of the network
+func Scheme() string {
+	switch scheme := viper.GetString("peer.txid.scheme"); scheme {
+	case "", SchemeUUID:
+		return SchemeUUID
+	case SchemeHash:
+		return SchemeHash
+	default:
+		panic(fmt.Errorf("Transaction ID scheme must be '%s' or '%s'. Current value is '%s'.", SchemeUUID, SchemeHash, scheme))
+	}
+}
+
+// Generate returns a new ID for a transaction carrying payload, following the
+// scheme of the network
+func Generate(payload []byte) string {
+	return generate(Scheme(), payload)
+}
+
+// GenerateForInvocation returns a new ID for the transaction of spec
+func GenerateForInvocation(spec *pb.ChaincodeInvocationSpec) (string, error) {
+	payload, err := proto.Marshal(spec)
+	if err != nil {
+		return "", err
+	}
+	return Generate(payload), nil
+}
+
+func generate(scheme string, payload []byte) string {
+	if scheme != SchemeHash {
+		return util.GenerateUUID()
+	}
+	salt := make([]byte, saltLength)
+	if _, err := rand.Read(salt); err != nil {
+		panic(fmt.Errorf("Could not generate a transaction ID: %s", err))
+	}
+	return hashID(salt, payload)
+}
+
+func hashID(salt, payload []byte) string {
+	return hex.EncodeToString(salt) + "-" + hex.EncodeToString(util.ComputeCryptoHash(append(append([]byte{}, salt...), payload...)))
+}
+
+// Check verifies the ID of an invoke transaction submitted to the peer, and
+// admits it, so that the ID is not used again within the window
+func Check(tx *pb.Transaction) error {
+	if tx.Type != pb.Transaction_CHAINCODE_INVOKE {
+		return nil
+	}
+	return getChecker().check(tx)
+}
+
+// Forget releases the ID of a transaction the peer did not get ordered after
+// all, so that it may be submitted again
+func Forget(uuid string) {
+	c := getChecker()
+	c.Lock()
+	defer c.Unlock()
+	delete(c.admitted, uuid)
+}
+
+func (c *checker) check(tx *pb.Transaction) error {
+	if err := c.checkFormat(tx); err != nil {
+		return err
+	}
+	if c.window == 0 {
+		return nil
+	}
+
+	c.Lock()
+	defer c.Unlock()
+	var height uint64
+	if c.index != nil {
+		height = c.index.GetBlockchainSize()
+	}
+	c.prune(height)
+	if _, ok := c.admitted[tx.Uuid]; ok {
+		return &DuplicateIDError{UUID: tx.Uuid}
+	}
+	if c.index != nil {
+		blockNumber, err := c.index.GetTransactionBlockNumberByUUID(tx.Uuid)
+		if err == nil && height-blockNumber <= c.window {
+			return &DuplicateIDError{UUID: tx.Uuid, Committed: true, BlockNumber: blockNumber}
+		}
+		if err != nil && err != ledger.ErrResourceNotFound {
+			logger.Warningf("Failed to look up transaction ID [%s]: %s", tx.Uuid, err)
+		}
+	}
+	c.admitted[tx.Uuid] = height
+	return nil
+}
+
+func (c *checker) checkFormat(tx *pb.Transaction) error {
+	switch c.scheme {
+	case SchemeHash:
+		if !hashFormat.MatchString(tx.Uuid) {
+			return &InvalidIDError{tx.Uuid, c.scheme, "expected a salt and a hash"}
+		}
+		// The payload of confidential transactions is encrypted, only the
+		// submitter can tell what the ID was derived from
+		if tx.ConfidentialityLevel != pb.ConfidentialityLevel_PUBLIC {
+			return nil
+		}
+		salt, _ := hex.DecodeString(tx.Uuid[:2*saltLength])
+		if hashID(salt, tx.Payload) != tx.Uuid {
+			return &InvalidIDError{tx.Uuid, c.scheme, "it does not match the payload"}
+		}
+	default:
+		if !uuidFormat.MatchString(tx.Uuid) {
+			return &InvalidIDError{tx.Uuid, c.scheme, "expected an RFC 4122 UUID"}
+		}
+	}
+	return nil
+}
+
+// prune forgets the IDs admitted before the window, once the chain grew
+func (c *checker) prune(height uint64) {
+	if height == c.pruned {
+		return
+	}
+	c.pruned = height
+	for uuid, admitted := range c.admitted {
+		if height-admitted > c.window {
+			delete(c.admitted, uuid)
+		}
+	}
+}
@@ -0,0 +1,100 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package txid
+
+import (
+	"testing"
+
+	"github.com/hyperledger/fabric/core/ledger"
+	pb "github.com/hyperledger/fabric/protos"
+)
+
+type mockIndex struct {
+	height    uint64
+	committed map[string]uint64
+}
+
+func (m *mockIndex) GetBlockchainSize() uint64 {
+	return m.height
+}
+
+func (m *mockIndex) GetTransactionBlockNumberByUUID(txUUID string) (uint64, error) {
+	if blockNumber, ok := m.committed[txUUID]; ok {
+		return blockNumber, nil
+	}
+	return 0, ledger.ErrResourceNotFound
+}
+
+func invoke(uuid string, payload []byte) *pb.Transaction {
+	return &pb.Transaction{Type: pb.Transaction_CHAINCODE_INVOKE, Uuid: uuid, Payload: payload}
+}
+
+func TestCheckUUID(t *testing.T) {
+	idx := &mockIndex{height: 10, committed: map[string]uint64{}}
+	c := newChecker(SchemeUUID, 5, idx)
+
+	if err := c.check(invoke("not-a-uuid", nil)); err == nil {
+		t.Fatalf("Expected a malformed ID to be rejected")
+	}
+	uuid := generate(SchemeUUID, nil)
+	if err := c.check(invoke(uuid, nil)); err != nil {
+		t.Fatalf("Expected a fresh ID to be admitted, got %s", err)
+	}
+	if _, ok := c.check(invoke(uuid, nil)).(*DuplicateIDError); !ok {
+		t.Fatalf("Expected an ID admitted before to be rejected")
+	}
+
+	// IDs are only unique within the window
+	committed := generate(SchemeUUID, nil)
+	idx.committed[committed] = 6
+	if _, ok := c.check(invoke(committed, nil)).(*DuplicateIDError); !ok {
+		t.Fatalf("Expected an ID committed within the window to be rejected")
+	}
+	idx.height = 20
+	if err := c.check(invoke(committed, nil)); err != nil {
+		t.Fatalf("Expected an ID committed before the window to be admitted, got %s", err)
+	}
+	if err := c.check(invoke(uuid, nil)); err != nil {
+		t.Fatalf("Expected an ID admitted before the window to be admitted again, got %s", err)
+	}
+}
+
+func TestCheckHash(t *testing.T) {
+	c := newChecker(SchemeHash, 5, nil)
+
+	payload := []byte("payload")
+	id := generate(SchemeHash, payload)
+	if other := generate(SchemeHash, payload); other == id {
+		t.Fatalf("Expected IDs of the same payload to differ, got %s twice", id)
+	}
+	if _, ok := c.check(invoke(id, []byte("other payload"))).(*InvalidIDError); !ok {
+		t.Fatalf("Expected an ID derived from another payload to be rejected")
+	}
+	if _, ok := c.check(invoke(generate(SchemeUUID, nil), payload)).(*InvalidIDError); !ok {
+		t.Fatalf("Expected a UUID to be rejected")
+	}
+	if err := c.check(invoke(id, payload)); err != nil {
+		t.Fatalf("Expected an ID derived from the payload to be admitted, got %s", err)
+	}
+
+	confidential := invoke(id, []byte("encrypted payload"))
+	confidential.ConfidentialityLevel = pb.ConfidentialityLevel_CONFIDENTIAL
+	confidential.Uuid = generate(SchemeHash, payload)
+	if err := c.check(confidential); err != nil {
+		t.Fatalf("Expected the payload of a confidential transaction not to be checked, got %s", err)
+	}
+}
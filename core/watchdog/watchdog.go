@@ -0,0 +1,315 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package watchdog protects the peer from overload. It samples the number of
+// goroutines, the heap in use and the fill of the queues of the peer, and
+// sheds load in a defined order as they cross their thresholds: queries are
+// rejected first, then the new transactions of clients. Consensus traffic is
+// never shed, since the network cannot make progress without it. Each change
+// of level is logged, and the level and the samples are exported as metrics
+// for operators to alert on.
+package watchdog
+
+import (
+	"errors"
+	"fmt"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/op/go-logging"
+	"github.com/spf13/viper"
+
+	"github.com/hyperledger/fabric/core/metrics"
+)
+
+var logger = logging.MustGetLogger("watchdog")
+
+// Level is the load shedding level of the peer
+type Level int32
+
+const (
+	// LevelNormal admits all requests
+	LevelNormal Level = iota
+	// LevelShedQueries rejects queries
+	LevelShedQueries
+	// LevelShedTransactions rejects queries and new client transactions
+	LevelShedTransactions
+)
+
+func (l Level) String() string {
+	switch l {
+	case LevelNormal:
+		return "normal"
+	case LevelShedQueries:
+		return "shedding queries"
+	case LevelShedTransactions:
+		return "shedding transactions"
+	}
+	return fmt.Sprintf("level %d", int32(l))
+}
+
+// ErrOverloaded is returned for the requests the watchdog sheds
+var ErrOverloaded = errors.New("Peer is overloaded, try again later")
+
+var (
+	levelGauge = metrics.NewGauge("watchdog_level",
+		"Load shedding level: 0 normal, 1 shedding queries, 2 shedding queries and transactions.")
+	goroutinesGauge = metrics.NewGauge("watchdog_goroutines",
+		"Goroutines of the peer, as last sampled by the watchdog.")
+	heapGauge = metrics.NewGauge("watchdog_heap_bytes",
+		"Heap in use by the peer, as last sampled by the watchdog.")
+	queueGauge = metrics.NewGauge("watchdog_queue_fill_ratio",
+		"Fill of the fullest queue of the peer, as last sampled by the watchdog.")
+	shed = metrics.NewCounterVec("watchdog_shed_total",
+		"Requests rejected by the watchdog, by kind.", "kind")
+)
+
+// QueueDepth returns the number of items in a queue and its capacity
+type QueueDepth func() (depth, capacity int)
+
+// Thresholds are the resource usages over which a level of shedding applies.
+// A zero threshold is not checked.
+type Thresholds struct {
+	Goroutines int
+	HeapMB     uint64
+	// QueuePercent is the fill of the fullest queue, in percent of its capacity
+	QueuePercent int
+}
+
+// sample is the usage of the resources of the peer at one point in time
+type sample struct {
+	goroutines int
+	heap       uint64
+	queue      string // the fullest queue
+	fill       float64
+}
+
+// exceeded returns the resources of s over t scaled by scale
+func (t Thresholds) exceeded(s sample, scale float64) []string {
+	var over []string
+	if t.Goroutines > 0 && float64(s.goroutines) >= float64(t.Goroutines)*scale {
+		over = append(over, fmt.Sprintf("%d goroutines", s.goroutines))
+	}
+	if t.HeapMB > 0 && float64(s.heap) >= float64(t.HeapMB<<20)*scale {
+		over = append(over, fmt.Sprintf("%d MB of heap", s.heap>>20))
+	}
+	if t.QueuePercent > 0 && s.fill*100 >= float64(t.QueuePercent)*scale {
+		over = append(over, fmt.Sprintf("queue %s %.0f%% full", s.queue, s.fill*100))
+	}
+	return over
+}
+
+// Watchdog tracks the load shedding level of the peer
+type Watchdog struct {
+	level        int32 // atomic, a Level
+	queries      Thresholds
+	transactions Thresholds
+	recovery     float64
+
+	lock   sync.Mutex
+	queues map[string]QueueDepth
+}
+
+// New returns a watchdog shedding queries over queries, and transactions over
+// transactions. It returns to a lower level once all the resources are under
+// recovery times the thresholds of the current level, so that a peer hovering
+// around a threshold does not flap.
+func New(queries, transactions Thresholds, recovery float64) *Watchdog {
+	if recovery <= 0 || recovery > 1 {
+		recovery = 1
+	}
+	return &Watchdog{
+		queries:      queries,
+		transactions: transactions,
+		recovery:     recovery,
+		queues:       make(map[string]QueueDepth),
+	}
+}
+
+// theWatchdog is the watchdog of the peer. Until it is configured by Run, it
+// has no thresholds, and admits all requests.
+var theWatchdog = New(Thresholds{}, Thresholds{}, 1)
+
+// Level returns the current load shedding level of the peer
+func (w *Watchdog) Level() Level {
+	return Level(atomic.LoadInt32(&w.level))
+}
+
+// RegisterQueue adds a queue whose fill the watchdog samples, replacing any
+// queue registered under the same name
+func (w *Watchdog) RegisterQueue(name string, depth QueueDepth) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	w.queues[name] = depth
+}
+
+// UnregisterQueue stops sampling the named queue
+func (w *Watchdog) UnregisterQueue(name string) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	delete(w.queues, name)
+}
+
+// AdmitQuery returns ErrOverloaded if queries are shed
+func (w *Watchdog) AdmitQuery() error {
+	if w.Level() >= LevelShedQueries {
+		shed.With("query").Inc()
+		return ErrOverloaded
+	}
+	return nil
+}
+
+// AdmitTransaction returns ErrOverloaded if new client transactions are shed
+func (w *Watchdog) AdmitTransaction() error {
+	if w.Level() >= LevelShedTransactions {
+		shed.With("transaction").Inc()
+		return ErrOverloaded
+	}
+	return nil
+}
+
+// sample reads the current usage of the resources of the peer
+func (w *Watchdog) sample() sample {
+	var stats runtime.MemStats
+	runtime.ReadMemStats(&stats)
+	s := sample{goroutines: runtime.NumGoroutine(), heap: stats.HeapAlloc}
+
+	w.lock.Lock()
+	names := make([]string, 0, len(w.queues))
+	for name := range w.queues {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		depth, capacity := w.queues[name]()
+		if capacity <= 0 {
+			continue
+		}
+		if fill := float64(depth) / float64(capacity); s.queue == "" || fill > s.fill {
+			s.queue, s.fill = name, fill
+		}
+	}
+	w.lock.Unlock()
+	return s
+}
+
+// observe moves to the level s calls for, and returns it
+func (w *Watchdog) observe(s sample) Level {
+	goroutinesGauge.Set(float64(s.goroutines))
+	heapGauge.Set(float64(s.heap))
+	queueGauge.Set(s.fill)
+
+	current := w.Level()
+	next := LevelNormal
+	var over []string
+	if over = w.transactions.exceeded(s, 1); len(over) > 0 {
+		next = LevelShedTransactions
+	} else if over = w.queries.exceeded(s, 1); len(over) > 0 {
+		next = LevelShedQueries
+	}
+
+	if next < current {
+		// Only step down once clear of the thresholds of the current level
+		if current == LevelShedTransactions && len(w.transactions.exceeded(s, w.recovery)) > 0 {
+			next = LevelShedTransactions
+		} else if next == LevelNormal && len(w.queries.exceeded(s, w.recovery)) > 0 {
+			next = LevelShedQueries
+		}
+	}
+	if next == current {
+		return current
+	}
+
+	atomic.StoreInt32(&w.level, int32(next))
+	levelGauge.Set(float64(next))
+	if next > current {
+		logger.Warningf("Peer overloaded with %s: %s", strings.Join(over, ", "), next)
+	} else {
+		logger.Infof("Peer load decreased to %d goroutines, %d MB of heap and %.0f%% queue fill: %s",
+			s.goroutines, s.heap>>20, s.fill*100, next)
+	}
+	return next
+}
+
+// Watch samples the resources of the peer every interval, and adjusts the
+// load shedding level, until stop is closed
+func (w *Watchdog) Watch(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+		w.observe(w.sample())
+	}
+}
+
+func configThresholds(key string) Thresholds {
+	return Thresholds{
+		Goroutines:   viper.GetInt(key + ".goroutines"),
+		HeapMB:       uint64(viper.GetInt(key + ".heap")),
+		QueuePercent: viper.GetInt(key + ".queue"),
+	}
+}
+
+// Run configures the watchdog of the peer from peer.watchdog, and watches the
+// resources of the peer until stop is closed
+func Run(stop <-chan struct{}) {
+	queries := configThresholds("peer.watchdog.queries")
+	transactions := configThresholds("peer.watchdog.transactions")
+	interval := viper.GetDuration("peer.watchdog.interval")
+	if interval <= 0 {
+		interval = time.Second
+	}
+
+	// The thresholds are only read by the goroutine watching
+	w := theWatchdog
+	w.queries, w.transactions = queries, transactions
+	w.recovery = viper.GetFloat64("peer.watchdog.recovery")
+	if w.recovery <= 0 || w.recovery > 1 {
+		w.recovery = 1
+	}
+
+	logger.Infof("Watching the load of the peer every %s, shedding queries over %+v and transactions over %+v", interval, queries, transactions)
+	w.Watch(interval, stop)
+}
+
+// RegisterQueue adds a queue of the peer for its watchdog to sample
+func RegisterQueue(name string, depth QueueDepth) {
+	theWatchdog.RegisterQueue(name, depth)
+}
+
+// UnregisterQueue removes a queue of the peer from its watchdog
+func UnregisterQueue(name string) {
+	theWatchdog.UnregisterQueue(name)
+}
+
+// AdmitQuery returns ErrOverloaded if the peer sheds queries
+func AdmitQuery() error {
+	return theWatchdog.AdmitQuery()
+}
+
+// AdmitTransaction returns ErrOverloaded if the peer sheds new client
+// transactions
+func AdmitTransaction() error {
+	return theWatchdog.AdmitTransaction()
+}
@@ -0,0 +1,72 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package watchdog
+
+import (
+	"testing"
+)
+
+func TestShedOrder(t *testing.T) {
+	w := New(Thresholds{Goroutines: 100, QueuePercent: 50}, Thresholds{Goroutines: 200, HeapMB: 64}, 0.5)
+	steps := []struct {
+		sample sample
+		level  Level
+	}{
+		{sample{goroutines: 10}, LevelNormal},
+		{sample{goroutines: 10, queue: "consensus", fill: 0.6}, LevelShedQueries},
+		{sample{goroutines: 10, heap: 64 << 20}, LevelShedTransactions},
+		// stays until clear of half the thresholds of its level
+		{sample{goroutines: 150}, LevelShedTransactions},
+		{sample{goroutines: 90}, LevelShedQueries},
+		{sample{goroutines: 60}, LevelShedQueries},
+		{sample{goroutines: 40}, LevelNormal},
+	}
+	for i, step := range steps {
+		if level := w.observe(step.sample); level != step.level {
+			t.Fatalf("Step %d: expected %s, got %s", i, step.level, level)
+		}
+	}
+}
+
+func TestAdmit(t *testing.T) {
+	w := New(Thresholds{Goroutines: 100}, Thresholds{Goroutines: 200}, 1)
+	if w.AdmitQuery() != nil || w.AdmitTransaction() != nil {
+		t.Fatal("Expected a peer under its thresholds to admit all requests")
+	}
+	w.observe(sample{goroutines: 100})
+	if w.AdmitQuery() != ErrOverloaded || w.AdmitTransaction() != nil {
+		t.Fatal("Expected only queries to be shed")
+	}
+	w.observe(sample{goroutines: 200})
+	if w.AdmitQuery() != ErrOverloaded || w.AdmitTransaction() != ErrOverloaded {
+		t.Fatal("Expected queries and transactions to be shed")
+	}
+}
+
+func TestSampleQueues(t *testing.T) {
+	w := New(Thresholds{}, Thresholds{}, 1)
+	w.RegisterQueue("events", func() (int, int) { return 10, 100 })
+	w.RegisterQueue("consensus", func() (int, int) { return 30, 100 })
+	w.RegisterQueue("closed", func() (int, int) { return 0, 0 })
+	if s := w.sample(); s.queue != "consensus" || s.fill != 0.3 || s.goroutines == 0 || s.heap == 0 {
+		t.Fatalf("Unexpected sample %+v", s)
+	}
+	w.UnregisterQueue("consensus")
+	if s := w.sample(); s.queue != "events" {
+		t.Fatalf("Expected the events queue to be the fullest, got %+v", s)
+	}
+}
@@ -0,0 +1,416 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package bridge
+
+import (
+	"crypto/ecdsa"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/op/go-logging"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+
+	"github.com/hyperledger/fabric/core/comm"
+	"github.com/hyperledger/fabric/core/crypto"
+	"github.com/hyperledger/fabric/events/consumer"
+	pb "github.com/hyperledger/fabric/protos"
+)
+
+var logger = logging.MustGetLogger("bridge")
+
+// A Bridge relays chaincode events from a source network to a target
+// network. Each event a route matches becomes a Message, which the bridge
+// signs and delivers as the arguments of an invocation of the target
+// chaincode. Every message is delivered exactly once:
+//
+// - the bridge follows the blocks of the source network, resuming after a
+// restart from the block it was processing, and recognizes the events it
+// turned into messages already by the IDs of the messages, which derive from
+// the source network, block and transaction;
+//
+// - the invocation delivering a message has the ID of the message for uuid,
+// so that the dedup window of the target chaincode catches the invocation
+// when the bridge submits it again, not knowing whether it went through;
+//
+// - the bridge follows the blocks of the target network, and a message is
+// delivered once a block holds its invocation;
+//
+// - the target chaincode checks the messages with CheckMessage, which
+// verifies the signature of the bridge, and refuses a message it was
+// delivered before, so that a message cannot be replayed by whoever obtains
+// it.
+//
+// The state of the deliveries is kept in a file, rewritten as it changes.
+
+// Route relays the events of a chaincode of the source network to a chaincode
+// of the target network
+type Route struct {
+	SourceChaincode string // chaincode whose events are relayed
+	EventName       string // name of the events relayed, all of them if empty
+	TargetChaincode string // chaincode invoked with the messages
+	Function        string // function invoked
+}
+
+// Config configures a Bridge
+type Config struct {
+	Network       string                 // name of the source network, part of the IDs of the messages
+	Source        []consumer.PeerAddress // peers of the source network, in order of preference
+	Target        []consumer.PeerAddress // peers of the target network, in order of preference
+	Routes        []Route
+	Key           *ecdsa.PrivateKey // signs the messages
+	Client        crypto.Client     // signs the invocations if the target network runs with security, nil otherwise
+	StateFile     string            // keeps the state of the deliveries
+	RetryInterval time.Duration     // how long a message may take to be delivered before it is submitted again
+}
+
+// Bridge relays the chaincode events of a source network to a target network
+type Bridge struct {
+	config Config
+
+	lock       sync.Mutex
+	state      *state
+	sourceHash []byte // hash of the source block being processed
+
+	source      *consumer.FailoverClient
+	target      *consumer.FailoverClient
+	sourceBlock func() uint64 // number of the source block being processed
+	targetBlock func() uint64 // number of the target block being processed
+	submitter   func(tx *pb.Transaction) error
+
+	submitLock sync.Mutex // serializes the submissions, and protects peer
+	peer       int        // index of the target peer which accepted the last submission
+
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+// New returns a bridge with the given configuration, resuming from the state
+// it kept in the state file
+func New(config Config) (*Bridge, error) {
+	if config.Network == "" {
+		return nil, errors.New("must supply the name of the source network")
+	}
+	if len(config.Source) == 0 || len(config.Target) == 0 {
+		return nil, errors.New("must supply peers of the source and target networks")
+	}
+	if len(config.Routes) == 0 {
+		return nil, errors.New("must supply routes")
+	}
+	if config.Key == nil {
+		return nil, errors.New("must supply the key signing the messages")
+	}
+	if config.StateFile == "" {
+		return nil, errors.New("must supply the state file")
+	}
+	if config.RetryInterval <= 0 {
+		config.RetryInterval = 30 * time.Second
+	}
+	s, err := loadState(config.StateFile)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read the state file %s: %s", config.StateFile, err)
+	}
+
+	b := &Bridge{
+		config: config,
+		state:  s,
+		stop:   make(chan struct{}),
+	}
+	b.source = consumer.NewFailoverClient(config.Source, &sourceAdapter{b})
+	b.target = consumer.NewFailoverClient(config.Target, &targetAdapter{b})
+	if s.SourceStarted {
+		b.source.ResumeFrom(s.SourceBlock)
+	}
+	if s.TargetStarted {
+		b.target.ResumeFrom(s.TargetBlock)
+	}
+	b.sourceBlock = b.source.CurrentBlock
+	b.targetBlock = b.target.CurrentBlock
+	b.submitter = b.submitToTarget
+	return b, nil
+}
+
+// Start follows the target network, then the source network, and submits the
+// messages not delivered yet again as their retry interval elapses
+func (b *Bridge) Start() error {
+	if err := b.target.Start(); err != nil {
+		return fmt.Errorf("cannot follow the target network: %s", err)
+	}
+	if err := b.source.Start(); err != nil {
+		b.target.Stop()
+		return fmt.Errorf("cannot follow the source network: %s", err)
+	}
+	go b.retry()
+	return nil
+}
+
+// Stop stops following the networks and submitting messages
+func (b *Bridge) Stop() {
+	b.stopOnce.Do(func() {
+		close(b.stop)
+		b.source.Stop()
+		b.target.Stop()
+	})
+}
+
+// recvSourceBlock starts processing a block of the source network
+func (b *Bridge) recvSourceBlock(block *pb.Block) error {
+	hash, err := block.GetHash()
+	if err != nil {
+		return err
+	}
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.sourceHash = hash
+	b.state.nextSourceBlock(b.sourceBlock())
+	return b.state.save(b.config.StateFile)
+}
+
+// recvSourceEvent turns a chaincode event of the source network into a
+// message for each route it matches, and submits them
+func (b *Bridge) recvSourceEvent(ce *pb.ChaincodeEvent) error {
+	var submit []*delivery
+	b.lock.Lock()
+	for _, r := range b.config.Routes {
+		if r.SourceChaincode != ce.ChaincodeID || (r.EventName != "" && r.EventName != ce.EventName) {
+			continue
+		}
+		m := &Message{
+			Network:   b.config.Network,
+			Block:     b.state.SourceBlock,
+			BlockHash: b.sourceHash,
+			TxID:      ce.TxID,
+			Chaincode: ce.ChaincodeID,
+			Event:     ce.EventName,
+			Payload:   ce.Payload,
+			Target:    r.TargetChaincode,
+		}
+		if b.state.known(m.ID()) {
+			logger.Debugf("Skipping message %s, seen already", m.ID())
+			continue
+		}
+		args, err := signMessage(b.config.Key, m)
+		if err != nil {
+			b.lock.Unlock()
+			return fmt.Errorf("cannot sign message %s: %s", m.ID(), err)
+		}
+		d := &delivery{Message: m, Chaincode: r.TargetChaincode, Function: r.Function, Args: args}
+		b.state.Pending = append(b.state.Pending, d)
+		submit = append(submit, d)
+		logger.Infof("Relaying event %s of transaction %s in block %d to chaincode %s as message %s", m.Event, m.TxID, m.Block, m.Target, m.ID())
+	}
+	err := b.state.save(b.config.StateFile)
+	b.lock.Unlock()
+	if err != nil {
+		return err
+	}
+
+	for _, d := range submit {
+		b.submit(d)
+	}
+	return nil
+}
+
+// recvTargetBlock records the messages delivered by a block of the target
+// network, and those the target chaincode refused
+func (b *Bridge) recvTargetBlock(block *pb.Block) error {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	number := b.targetBlock()
+	for _, tr := range block.GetNonHashData().GetTransactionResults() {
+		i := b.state.pending(tr.Uuid)
+		if i < 0 {
+			continue
+		}
+		d := b.state.Pending[i]
+		b.state.Pending = append(b.state.Pending[:i], b.state.Pending[i+1:]...)
+		if tr.ErrorCode == 0 {
+			logger.Infof("Message %s delivered in block %d of the target network", tr.Uuid, number)
+			b.state.Delivered[tr.Uuid] = d.Message.Block
+		} else {
+			logger.Warningf("Message %s refused by chaincode %s in block %d of the target network: %s", tr.Uuid, d.Chaincode, number, tr.Error)
+			d.Error = tr.Error
+			b.state.Failed = append(b.state.Failed, d)
+		}
+	}
+	b.state.TargetBlock, b.state.TargetStarted = number+1, true
+	return b.state.save(b.config.StateFile)
+}
+
+// submit submits the invocation delivering a message to the target network
+func (b *Bridge) submit(d *delivery) {
+	tx, err := b.newTransaction(d)
+	if err == nil {
+		err = b.submitter(tx)
+	}
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	d.Submitted = time.Now()
+	d.Attempts++
+	if err != nil {
+		logger.Warningf("Could not submit message %s, retrying in %s: %s", d.Message.ID(), b.config.RetryInterval, err)
+	}
+	if err = b.state.save(b.config.StateFile); err != nil {
+		logger.Errorf("Could not save the state of the bridge: %s", err)
+	}
+}
+
+// newTransaction returns the invocation delivering a message
+func (b *Bridge) newTransaction(d *delivery) (*pb.Transaction, error) {
+	spec := &pb.ChaincodeInvocationSpec{ChaincodeSpec: &pb.ChaincodeSpec{
+		ChaincodeID: &pb.ChaincodeID{Name: d.Chaincode},
+		CtorMsg:     &pb.ChaincodeInput{Function: d.Function, Args: d.Args},
+	}}
+	if b.config.Client != nil {
+		return b.config.Client.NewChaincodeExecute(spec, d.Message.ID())
+	}
+	return pb.NewChaincodeExecute(spec, d.Message.ID(), pb.Transaction_CHAINCODE_INVOKE)
+}
+
+// submitToTarget hands a transaction to the first peer of the target network
+// accepting it, starting with the one which accepted the last one
+func (b *Bridge) submitToTarget(tx *pb.Transaction) error {
+	b.submitLock.Lock()
+	defer b.submitLock.Unlock()
+	var errs []string
+	for n := 0; n < len(b.config.Target); n++ {
+		i := (b.peer + n) % len(b.config.Target)
+		err := processTransaction(b.config.Target[i].API, tx)
+		if err == nil {
+			b.peer = i
+			return nil
+		}
+		errs = append(errs, fmt.Sprintf("%s: %s", b.config.Target[i].API, err))
+	}
+	return fmt.Errorf("no peer of the target network accepted the transaction: %v", errs)
+}
+
+func processTransaction(address string, tx *pb.Transaction) error {
+	conn, err := newClientConnectionWithAddress(address)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	resp, err := pb.NewPeerClient(conn).ProcessTransaction(context.Background(), tx)
+	if err != nil {
+		return err
+	}
+	if resp.Status != pb.Response_SUCCESS {
+		return fmt.Errorf("%s", resp.Msg)
+	}
+	return nil
+}
+
+func newClientConnectionWithAddress(address string) (*grpc.ClientConn, error) {
+	if comm.TLSEnabled() {
+		return comm.NewClientConnectionWithAddress(address, true, true, comm.InitTLSForPeer())
+	}
+	return comm.NewClientConnectionWithAddress(address, true, false, nil)
+}
+
+// retry submits the messages not delivered within the retry interval again
+func (b *Bridge) retry() {
+	ticker := time.NewTicker(b.config.RetryInterval)
+	defer ticker.Stop()
+	for {
+		for _, d := range b.due(time.Now()) {
+			b.submit(d)
+		}
+		select {
+		case <-ticker.C:
+		case <-b.stop:
+			return
+		}
+	}
+}
+
+// due returns the messages not delivered within the retry interval
+func (b *Bridge) due(now time.Time) []*delivery {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	var due []*delivery
+	for _, d := range b.state.Pending {
+		if now.Sub(d.Submitted) >= b.config.RetryInterval {
+			due = append(due, d)
+		}
+	}
+	return due
+}
+
+// sourceAdapter receives the events of the source network
+type sourceAdapter struct {
+	b *Bridge
+}
+
+func (a *sourceAdapter) GetInterestedEvents() ([]*pb.Interest, error) {
+	interests := []*pb.Interest{{EventType: pb.EventType_BLOCK}}
+	for _, r := range a.b.config.Routes {
+		interests = append(interests, &pb.Interest{
+			EventType: pb.EventType_CHAINCODE,
+			RegInfo:   &pb.Interest_ChaincodeRegInfo{ChaincodeRegInfo: &pb.ChaincodeReg{ChaincodeID: r.SourceChaincode, EventName: r.EventName}},
+		})
+	}
+	return interests, nil
+}
+
+func (a *sourceAdapter) Recv(msg *pb.Event) (bool, error) {
+	var err error
+	if block := msg.GetBlock(); block != nil {
+		err = a.b.recvSourceBlock(block)
+	} else if ce := msg.GetChaincodeEvent(); ce != nil {
+		err = a.b.recvSourceEvent(ce)
+	}
+	if err != nil {
+		// Going on would lose track of the messages
+		logger.Errorf("Bridge stops following the source network: %s", err)
+		return false, err
+	}
+	return true, nil
+}
+
+func (a *sourceAdapter) Disconnected(err error) {
+	logger.Infof("Bridge disconnected from the source network")
+}
+
+// targetAdapter receives the blocks of the target network
+type targetAdapter struct {
+	b *Bridge
+}
+
+func (a *targetAdapter) GetInterestedEvents() ([]*pb.Interest, error) {
+	return []*pb.Interest{{EventType: pb.EventType_BLOCK}}, nil
+}
+
+func (a *targetAdapter) Recv(msg *pb.Event) (bool, error) {
+	block := msg.GetBlock()
+	if block == nil {
+		return true, nil
+	}
+	if err := a.b.recvTargetBlock(block); err != nil {
+		// Going on would lose track of the messages
+		logger.Errorf("Bridge stops following the target network: %s", err)
+		return false, err
+	}
+	return true, nil
+}
+
+func (a *targetAdapter) Disconnected(err error) {
+	logger.Infof("Bridge disconnected from the target network")
+}
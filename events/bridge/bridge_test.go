@@ -0,0 +1,198 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package bridge
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+
+	"github.com/hyperledger/fabric/events/consumer"
+	pb "github.com/hyperledger/fabric/protos"
+)
+
+func newTestBridge(t *testing.T, dir string, key *ecdsa.PrivateKey, submitted *[]*pb.Transaction) (*Bridge, *uint64, *uint64) {
+	b, err := New(Config{
+		Network:   "source",
+		Source:    []consumer.PeerAddress{{Events: "source:7053", API: "source:30303"}},
+		Target:    []consumer.PeerAddress{{Events: "target:7053", API: "target:30303"}},
+		Routes:    []Route{{SourceChaincode: "escrow", EventName: "locked", TargetChaincode: "mint", Function: "mint"}},
+		Key:       key,
+		StateFile: filepath.Join(dir, "bridge.json"),
+	})
+	if err != nil {
+		t.Fatalf("Failed to create the bridge: %s", err)
+	}
+	var source, target uint64
+	b.sourceBlock = func() uint64 { return source }
+	b.targetBlock = func() uint64 { return target }
+	b.submitter = func(tx *pb.Transaction) error {
+		*submitted = append(*submitted, tx)
+		return nil
+	}
+	return b, &source, &target
+}
+
+func invocationArgs(t *testing.T, tx *pb.Transaction) []string {
+	spec := &pb.ChaincodeInvocationSpec{}
+	if err := proto.Unmarshal(tx.Payload, spec); err != nil {
+		t.Fatalf("Failed to unmarshal the invocation: %s", err)
+	}
+	return spec.ChaincodeSpec.CtorMsg.Args
+}
+
+func TestBridgeDelivery(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bridge")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var submitted []*pb.Transaction
+	b, source, _ := newTestBridge(t, dir, key, &submitted)
+	*source = 4
+	if err = b.recvSourceBlock(&pb.Block{}); err != nil {
+		t.Fatalf("Failed to process source block: %s", err)
+	}
+	events := []*pb.ChaincodeEvent{
+		{ChaincodeID: "escrow", TxID: "tx1", EventName: "locked", Payload: []byte("10")},
+		{ChaincodeID: "escrow", TxID: "tx2", EventName: "released"},
+		{ChaincodeID: "escrow", TxID: "tx3", EventName: "locked", Payload: []byte("20")},
+	}
+	for _, ce := range events {
+		if err = b.recvSourceEvent(ce); err != nil {
+			t.Fatalf("Failed to process source event: %s", err)
+		}
+	}
+	if len(submitted) != 2 {
+		t.Fatalf("Expected the two locked events to be submitted, got %d transactions", len(submitted))
+	}
+	first := submitted[0]
+	m, err := VerifyMessage(&key.PublicKey, invocationArgs(t, first))
+	if err != nil {
+		t.Fatalf("Failed to verify the message: %s", err)
+	}
+	if m.ID() != first.Uuid || m.Block != 4 || m.TxID != "tx1" || string(m.Payload) != "10" {
+		t.Fatalf("Unexpected message %+v in transaction %s", m, first.Uuid)
+	}
+
+	// A restarted bridge processes block 4 again, and recognizes the
+	// messages it submitted
+	submitted = nil
+	b, source, target := newTestBridge(t, dir, key, &submitted)
+	if !b.state.SourceStarted || b.state.SourceBlock != 4 {
+		t.Fatalf("Expected the bridge to resume from source block 4, got %d", b.state.SourceBlock)
+	}
+	*source = 4
+	b.recvSourceBlock(&pb.Block{})
+	for _, ce := range events {
+		b.recvSourceEvent(ce)
+	}
+	if len(submitted) != 0 || len(b.state.Pending) != 2 {
+		t.Fatalf("Expected no message to be submitted again, got %d transactions and %d pending messages", len(submitted), len(b.state.Pending))
+	}
+
+	// The target network delivers the first message and refuses the second
+	*target = 7
+	second := b.state.Pending[1].Message.ID()
+	block := &pb.Block{NonHashData: &pb.NonHashData{TransactionResults: []*pb.TransactionResult{
+		{Uuid: "unrelated"},
+		{Uuid: first.Uuid},
+		{Uuid: second, ErrorCode: 1, Error: "Message was delivered already"},
+	}}}
+	if err = b.recvTargetBlock(block); err != nil {
+		t.Fatalf("Failed to process target block: %s", err)
+	}
+	if len(b.state.Pending) != 0 || len(b.state.Failed) != 1 || b.state.Delivered[first.Uuid] != 4 {
+		t.Fatalf("Expected one message delivered and one failed, got state %+v", b.state)
+	}
+	if b.state.Failed[0].Error != "Message was delivered already" || b.state.TargetBlock != 8 {
+		t.Fatalf("Unexpected state %+v", b.state)
+	}
+
+	// Delivered messages are forgotten once their source block is behind
+	*source = 5
+	b.recvSourceBlock(&pb.Block{})
+	if len(b.state.Delivered) != 0 {
+		t.Fatalf("Expected the delivered messages to be forgotten, got %v", b.state.Delivered)
+	}
+}
+
+func TestBridgeRetry(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bridge")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var submitted []*pb.Transaction
+	b, _, _ := newTestBridge(t, dir, key, &submitted)
+	b.recvSourceEvent(&pb.ChaincodeEvent{ChaincodeID: "escrow", TxID: "tx1", EventName: "locked"})
+	d := b.state.Pending[0]
+	if due := b.due(d.Submitted.Add(b.config.RetryInterval / 2)); len(due) != 0 {
+		t.Fatalf("Expected no message to be due yet, got %d", len(due))
+	}
+	due := b.due(d.Submitted.Add(b.config.RetryInterval))
+	if len(due) != 1 {
+		t.Fatalf("Expected the message to be due, got %d", len(due))
+	}
+	b.submit(due[0])
+	if len(submitted) != 2 || submitted[0].Uuid != submitted[1].Uuid || d.Attempts != 2 {
+		t.Fatalf("Expected the message to be submitted again with the same uuid")
+	}
+}
+
+func TestVerifyMessage(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := &Message{Network: "source", Block: 3, TxID: "tx1", Chaincode: "escrow", Event: "locked", Payload: []byte("10"), Target: "mint"}
+	args, err := signMessage(key, m)
+	if err != nil {
+		t.Fatalf("Failed to sign the message: %s", err)
+	}
+	if _, err = VerifyMessage(&key.PublicKey, args); err != nil {
+		t.Fatalf("Failed to verify the message: %s", err)
+	}
+	if _, err = VerifyMessage(&other.PublicKey, args); err == nil {
+		t.Fatalf("Expected the message not to verify against another key")
+	}
+	tampered := []string{strings.Replace(args[0], `"block":3`, `"block":2`, 1), args[1]}
+	if _, err = VerifyMessage(&key.PublicKey, tampered); err == nil {
+		t.Fatalf("Expected the tampered message not to verify")
+	}
+}
@@ -0,0 +1,169 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package bridge
+
+import (
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// Message is a chaincode event of the source network, as delivered to the
+// target network
+type Message struct {
+	Network   string `json:"network"`   // name of the source network
+	Block     uint64 `json:"block"`     // number of the source block holding the event
+	BlockHash []byte `json:"blockHash"` // hash of that block
+	TxID      string `json:"txID"`      // uuid of the transaction which emitted the event
+	Chaincode string `json:"chaincode"` // chaincode which emitted the event
+	Event     string `json:"event"`     // name of the event
+	Payload   []byte `json:"payload"`
+	Target    string `json:"target"` // chaincode of the target network the message is for
+}
+
+// ID identifies the message across restarts of the bridge. It is the uuid of
+// the transactions delivering the message.
+func (m *Message) ID() string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\x00%d\x00%s\x00%s", m.Network, m.Block, m.TxID, m.Target)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+type ecdsaSignature struct {
+	R, S *big.Int
+}
+
+// signMessage returns the arguments of the invocation delivering m: its JSON
+// encoding, and the base64 of the signature of the bridge over it
+func signMessage(key *ecdsa.PrivateKey, m *Message) ([]string, error) {
+	raw, err := json.Marshal(m)
+	if err != nil {
+		return nil, err
+	}
+	digest := sha256.Sum256(raw)
+	r, s, err := ecdsa.Sign(rand.Reader, key, digest[:])
+	if err != nil {
+		return nil, err
+	}
+	sig, err := asn1.Marshal(ecdsaSignature{r, s})
+	if err != nil {
+		return nil, err
+	}
+	return []string{string(raw), base64.StdEncoding.EncodeToString(sig)}, nil
+}
+
+// VerifyMessage checks the arguments of an invocation delivering a message
+// against the public key of the bridge, and returns the message
+func VerifyMessage(pub *ecdsa.PublicKey, args []string) (*Message, error) {
+	if len(args) != 2 {
+		return nil, errors.New("Expecting the message and its signature")
+	}
+	raw, err := base64.StdEncoding.DecodeString(args[1])
+	if err != nil {
+		return nil, fmt.Errorf("Invalid signature encoding: %s", err)
+	}
+	sig := &ecdsaSignature{}
+	if _, err = asn1.Unmarshal(raw, sig); err != nil || sig.R == nil || sig.S == nil {
+		return nil, errors.New("Invalid signature")
+	}
+	digest := sha256.Sum256([]byte(args[0]))
+	if !ecdsa.Verify(pub, digest[:], sig.R, sig.S) {
+		return nil, errors.New("The signature of the message does not verify")
+	}
+	m := &Message{}
+	if err = json.Unmarshal([]byte(args[0]), m); err != nil {
+		return nil, fmt.Errorf("Invalid message: %s", err)
+	}
+	return m, nil
+}
+
+// messageKey is the key under which CheckMessage records a message
+func messageKey(id string) string {
+	return "bridge/" + id
+}
+
+// CheckMessage is called by a target chaincode on the arguments of an
+// invocation from the bridge. It verifies the message like VerifyMessage and
+// refuses a message the chaincode was delivered before, by whoever submitted
+// it; it records the message in the state of the chaincode, under
+// "bridge/<id>", for the lifetime of the chaincode.
+func CheckMessage(stub *shim.ChaincodeStub, pub *ecdsa.PublicKey, args []string) (*Message, error) {
+	m, err := VerifyMessage(pub, args)
+	if err != nil {
+		return nil, err
+	}
+	key := messageKey(m.ID())
+	seen, err := stub.GetState(key)
+	if err != nil {
+		return nil, err
+	}
+	if seen != nil {
+		return nil, fmt.Errorf("Message %s was delivered already", m.ID())
+	}
+	if err = stub.PutState(key, []byte{1}); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// ParsePrivateKey reads the PEM encoded EC private key of the bridge
+func ParsePrivateKey(raw []byte) (*ecdsa.PrivateKey, error) {
+	block, _ := pem.Decode(raw)
+	if block == nil {
+		return nil, errors.New("No PEM block found")
+	}
+	if key, err := x509.ParseECPrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid private key: %s", err)
+	}
+	ecKey, ok := key.(*ecdsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("The private key is not an ECDSA key")
+	}
+	return ecKey, nil
+}
+
+// ParsePublicKey reads the PEM encoded public key of the bridge
+func ParsePublicKey(raw []byte) (*ecdsa.PublicKey, error) {
+	block, _ := pem.Decode(raw)
+	if block == nil {
+		return nil, errors.New("No PEM block found")
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid public key: %s", err)
+	}
+	ecKey, ok := key.(*ecdsa.PublicKey)
+	if !ok {
+		return nil, errors.New("The public key is not an ECDSA key")
+	}
+	return ecKey, nil
+}
@@ -0,0 +1,127 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package bridge
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"time"
+)
+
+// delivery is a message on its way to the target network
+type delivery struct {
+	Message   *Message  `json:"message"`
+	Chaincode string    `json:"chaincode"` // target chaincode
+	Function  string    `json:"function"`
+	Args      []string  `json:"args"`                // signed message
+	Submitted time.Time `json:"submitted,omitempty"` // when it was last submitted
+	Attempts  int       `json:"attempts"`
+	Error     string    `json:"error,omitempty"` // why the target chaincode refused it
+}
+
+// state is what the bridge keeps across restarts
+type state struct {
+	// block of the source network being processed, from which to resume
+	SourceBlock   uint64 `json:"sourceBlock"`
+	SourceStarted bool   `json:"sourceStarted"`
+	// next block of the target network to look for deliveries in
+	TargetBlock   uint64 `json:"targetBlock"`
+	TargetStarted bool   `json:"targetStarted"`
+
+	Pending []*delivery `json:"pending"` // messages not delivered yet, in the order of the source chain
+	Failed  []*delivery `json:"failed"`  // messages the target chaincode refused
+	// messages delivered, with the source block they come from, kept while
+	// the bridge may process that block again
+	Delivered map[string]uint64 `json:"delivered"`
+}
+
+func newState() *state {
+	return &state{Delivered: make(map[string]uint64)}
+}
+
+// loadState reads the state kept in file, a new state if there is none yet
+func loadState(file string) (*state, error) {
+	raw, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		return newState(), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	s := newState()
+	if err = json.Unmarshal(raw, s); err != nil {
+		return nil, err
+	}
+	if s.Delivered == nil {
+		s.Delivered = make(map[string]uint64)
+	}
+	return s, nil
+}
+
+// save replaces the state kept in file, so that a crash leaves either the
+// previous state or this one
+func (s *state) save(file string) error {
+	raw, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := file + ".tmp"
+	if err = ioutil.WriteFile(tmp, raw, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, file)
+}
+
+// known returns whether the message with the given ID was seen already
+func (s *state) known(id string) bool {
+	if _, ok := s.Delivered[id]; ok {
+		return true
+	}
+	return s.pending(id) >= 0 || s.failed(id)
+}
+
+// pending returns the index of the pending message with the given ID, -1 if
+// there is none
+func (s *state) pending(id string) int {
+	for i, d := range s.Pending {
+		if d.Message.ID() == id {
+			return i
+		}
+	}
+	return -1
+}
+
+func (s *state) failed(id string) bool {
+	for _, d := range s.Failed {
+		if d.Message.ID() == id {
+			return true
+		}
+	}
+	return false
+}
+
+// nextSourceBlock records that the bridge processes the source block with the
+// given number, and forgets the messages delivered from the blocks before it
+func (s *state) nextSourceBlock(number uint64) {
+	s.SourceBlock, s.SourceStarted = number, true
+	for id, block := range s.Delivered {
+		if block < number {
+			delete(s.Delivered, id)
+		}
+	}
+}
@@ -30,14 +30,23 @@ import (
 
 //EventsClient holds the stream and adapter for consumer to work with
 type EventsClient struct {
-	peerAddress string
-	stream      ehpb.Events_ChatClient
-	adapter     EventAdapter
+	peerAddress  string
+	stream       ehpb.Events_ChatClient
+	adapter      EventAdapter
+	subscription string
 }
 
 //NewEventsClient Returns a new grpc.ClientConn to the configured local PEER.
 func NewEventsClient(peerAddress string, adapter EventAdapter) *EventsClient {
-	return &EventsClient{peerAddress, nil, adapter}
+	return &EventsClient{peerAddress: peerAddress, adapter: adapter}
+}
+
+// NewDurableEventsClient returns a client of the durable subscription with the
+// given name, which the peer creates on Start if it does not exist yet. The
+// peer delivers the events of the blocks from the one after the last block
+// acknowledged with Ack, and sets the block number of the events.
+func NewDurableEventsClient(peerAddress string, subscription string, adapter EventAdapter) *EventsClient {
+	return &EventsClient{peerAddress: peerAddress, adapter: adapter, subscription: subscription}
 }
 
 //newEventsClientConnectionWithAddress Returns a new grpc.ClientConn to the configured local PEER.
@@ -49,7 +58,7 @@ func newEventsClientConnectionWithAddress(peerAddress string) (*grpc.ClientConn,
 }
 
 func (ec *EventsClient) register(ies []*ehpb.Interest) error {
-	emsg := &ehpb.Event{Event: &ehpb.Event_Register{Register: &ehpb.Register{Events: ies}}}
+	emsg := &ehpb.Event{Event: &ehpb.Event_Register{Register: &ehpb.Register{Events: ies, Subscription: ec.subscription}}}
 	var err error
 	if err = ec.stream.Send(emsg); err != nil {
 		fmt.Printf("error on Register send %s\n", err)
@@ -137,6 +146,26 @@ func (ec *EventsClient) Start() error {
 	return nil
 }
 
+// Ack tells the peer the events of the blocks up to blockNumber, included, were
+// processed, so that the durable subscription resumes after it. It must not
+// be invoked concurrently with itself or Unsubscribe; the adapter typically
+// invokes it from Recv.
+func (ec *EventsClient) Ack(blockNumber uint64) error {
+	if ec.subscription == "" {
+		return fmt.Errorf("not a durable subscription")
+	}
+	return ec.stream.Send(&ehpb.Event{Event: &ehpb.Event_Ack{Ack: &ehpb.Ack{Subscription: ec.subscription, BlockNumber: blockNumber}}})
+}
+
+// Unsubscribe deletes the durable subscription of the client from the peer,
+// which stops delivering its events
+func (ec *EventsClient) Unsubscribe() error {
+	if ec.subscription == "" {
+		return fmt.Errorf("not a durable subscription")
+	}
+	return ec.stream.Send(&ehpb.Event{Event: &ehpb.Event_Unsubscribe{Unsubscribe: &ehpb.Unsubscribe{Subscription: ec.subscription}}})
+}
+
 //Stop terminates connection with event hub
 func (ec *EventsClient) Stop() error {
 	return ec.stream.CloseSend()
@@ -0,0 +1,403 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package consumer
+
+import (
+	"bytes"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/op/go-logging"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	google_protobuf "google/protobuf"
+
+	ehpb "github.com/hyperledger/fabric/protos"
+)
+
+var consumerLogger = logging.MustGetLogger("eventhub_consumer")
+
+// A FailoverClient follows the blocks of the chain rather than the events of
+// a single peer. It subscribes to the block events of one peer of a list and,
+// when that peer goes away, to those of the next one. Blocks committed in the
+// meantime are fetched through the API of the new peer, and blocks delivered
+// already are recognized by their hash, so that the adapter receives the
+// events of every block exactly once and in order. Chaincode events are
+// derived from the transaction results of the blocks, which is how they reach
+// the adapter after a failover too.
+
+// recentBlocks is the number of delivered blocks remembered to recognize
+// block events received twice
+const recentBlocks = 100
+
+// PeerAddress holds the addresses a FailoverClient reaches a peer at
+type PeerAddress struct {
+	Events string // address of the events server, peer.validator.events.address
+	API    string // address of the gRPC API of the peer, peer.address
+}
+
+// peerConn is the connection of a FailoverClient to a peer
+type peerConn interface {
+	// Recv returns the next block event of the peer
+	Recv() (*ehpb.Event, error)
+	GetBlockchainInfo() (*ehpb.BlockchainInfo, error)
+	GetBlockByNumber(number uint64) (*ehpb.Block, error)
+	Close()
+}
+
+// FailoverClient delivers the events an adapter is interested in from the
+// first reachable peer of a list, failing over to the other peers
+type FailoverClient struct {
+	peers         []PeerAddress
+	adapter       EventAdapter
+	retryInterval time.Duration
+	dial          func(PeerAddress) (peerConn, error)
+
+	wantBlocks bool
+	chaincodes []*ehpb.ChaincodeReg
+
+	lock    sync.Mutex
+	conn    peerConn
+	stopped bool
+	stop    chan struct{}
+
+	// the following are only accessed by the goroutine delivering events,
+	// but for current which the adapter may read while receiving them
+	next    uint64 // number of the next block to deliver
+	started bool   // whether next is known
+	current uint64 // number of the block whose events are delivered
+	last    []byte // hash of the last block delivered
+	recent  map[string]uint64
+	order   [][]byte // hashes of recent, oldest first
+}
+
+// NewFailoverClient returns a client delivering the events adapter is
+// interested in from peers, in order of preference
+func NewFailoverClient(peers []PeerAddress, adapter EventAdapter) *FailoverClient {
+	return &FailoverClient{
+		peers:         peers,
+		adapter:       adapter,
+		retryInterval: 5 * time.Second,
+		dial:          dialPeer,
+		stop:          make(chan struct{}),
+		recent:        make(map[string]uint64),
+	}
+}
+
+// SetRetryInterval sets how long the client waits after failing to reach any
+// of the peers before trying them again
+func (fc *FailoverClient) SetRetryInterval(interval time.Duration) {
+	fc.retryInterval = interval
+}
+
+// ResumeFrom makes the client deliver the events of the blocks from the given
+// block number on, e.g. the one after the last block an application processed
+// before it was restarted. By default the client starts with the blocks
+// committed after it connected. It must be invoked before Start.
+func (fc *FailoverClient) ResumeFrom(blockNumber uint64) {
+	fc.next, fc.started = blockNumber, true
+}
+
+// CurrentBlock returns the number of the block whose events are being
+// delivered to the adapter, or were delivered last
+func (fc *FailoverClient) CurrentBlock() uint64 {
+	fc.lock.Lock()
+	defer fc.lock.Unlock()
+	return fc.current
+}
+
+// Start connects to the first reachable peer, and delivers events to the
+// adapter until Stop is invoked or the adapter declines an event. It returns
+// an error if none of the peers can be reached.
+func (fc *FailoverClient) Start() error {
+	if len(fc.peers) == 0 {
+		return fmt.Errorf("must supply peers")
+	}
+	ies, err := fc.adapter.GetInterestedEvents()
+	if err != nil {
+		return fmt.Errorf("error getting interested events:%s", err)
+	}
+	if len(ies) == 0 {
+		return fmt.Errorf("must supply interested events")
+	}
+	for _, ie := range ies {
+		switch ie.EventType {
+		case ehpb.EventType_BLOCK:
+			fc.wantBlocks = true
+		case ehpb.EventType_CHAINCODE:
+			if ie.GetChaincodeRegInfo() == nil || ie.GetChaincodeRegInfo().ChaincodeID == "" {
+				return fmt.Errorf("chaincode ID not specified")
+			}
+			fc.chaincodes = append(fc.chaincodes, ie.GetChaincodeRegInfo())
+		default:
+			return fmt.Errorf("invalid event type %s", ie.EventType)
+		}
+	}
+
+	conn, i, err := fc.connect(0)
+	if err != nil {
+		return err
+	}
+	go fc.run(conn, i)
+	return nil
+}
+
+// Stop disconnects the client from the peer it follows
+func (fc *FailoverClient) Stop() error {
+	fc.lock.Lock()
+	defer fc.lock.Unlock()
+	if fc.stopped {
+		return nil
+	}
+	fc.stopped = true
+	close(fc.stop)
+	if fc.conn != nil {
+		fc.conn.Close()
+	}
+	return nil
+}
+
+// connect connects to the first reachable peer from the one with the given
+// index on, and returns its connection and index
+func (fc *FailoverClient) connect(from int) (peerConn, int, error) {
+	var errs []string
+	for n := 0; n < len(fc.peers); n++ {
+		i := (from + n) % len(fc.peers)
+		conn, err := fc.dial(fc.peers[i])
+		if err != nil {
+			consumerLogger.Warningf("Could not subscribe to the events of peer %s: %s", fc.peers[i].Events, err)
+			errs = append(errs, fmt.Sprintf("%s: %s", fc.peers[i].Events, err))
+			continue
+		}
+		fc.lock.Lock()
+		if fc.stopped {
+			fc.lock.Unlock()
+			conn.Close()
+			return nil, 0, fmt.Errorf("client stopped")
+		}
+		fc.conn = conn
+		fc.lock.Unlock()
+		return conn, i, nil
+	}
+	return nil, 0, fmt.Errorf("could not subscribe to the events of any peer: %v", errs)
+}
+
+func (fc *FailoverClient) isStopped() bool {
+	fc.lock.Lock()
+	defer fc.lock.Unlock()
+	return fc.stopped
+}
+
+// run follows the peer connected to, and fails over to the next peers when
+// it goes away
+func (fc *FailoverClient) run(conn peerConn, i int) {
+	for {
+		cont, err := fc.follow(conn)
+		if !cont {
+			// the adapter declined an event
+			fc.Stop()
+			return
+		}
+		conn.Close()
+		if fc.isStopped() {
+			fc.adapter.Disconnected(nil)
+			return
+		}
+		consumerLogger.Warningf("Lost the events of peer %s, failing over: %s", fc.peers[i].Events, err)
+
+		for {
+			if conn, i, err = fc.connect(i + 1); err == nil {
+				break
+			}
+			if fc.isStopped() {
+				fc.adapter.Disconnected(nil)
+				return
+			}
+			consumerLogger.Warningf("%s, retrying in %s", err, fc.retryInterval)
+			select {
+			case <-time.After(fc.retryInterval):
+			case <-fc.stop:
+				fc.adapter.Disconnected(nil)
+				return
+			}
+		}
+	}
+}
+
+// follow delivers the blocks the peer missed, then those it receives events
+// of. It returns whether the client should fail over to another peer.
+func (fc *FailoverClient) follow(conn peerConn) (bool, error) {
+	// The subscription to the events precedes this, so that no block is
+	// committed unnoticed between the catch up and the first event
+	info, err := conn.GetBlockchainInfo()
+	if err != nil {
+		return true, err
+	}
+	if !fc.started {
+		fc.next, fc.started = info.Height, true
+	}
+	if cont, err := fc.catchUp(conn, info.Height, nil); !cont || err != nil {
+		return cont, err
+	}
+
+	for {
+		ev, err := conn.Recv()
+		if err != nil {
+			return true, err
+		}
+		block := ev.GetBlock()
+		if block == nil {
+			continue
+		}
+		hash, err := block.GetHash()
+		if err != nil {
+			return true, err
+		}
+		if _, ok := fc.recent[string(hash)]; ok {
+			continue
+		}
+		if fc.last != nil && bytes.Equal(block.PreviousBlockHash, fc.last) {
+			if cont, err := fc.deliver(block, hash); !cont {
+				return false, err
+			}
+			continue
+		}
+
+		// The block does not follow the last one delivered: blocks were
+		// missed, or the previous block held deployments, which the hash of
+		// the block it was delivered with does not cover
+		if info, err = conn.GetBlockchainInfo(); err != nil {
+			return true, err
+		}
+		if cont, err := fc.catchUp(conn, info.Height, hash); !cont || err != nil {
+			return cont, err
+		}
+	}
+}
+
+// catchUp delivers the blocks from the next one up to height, or up to the
+// one with the given hash
+func (fc *FailoverClient) catchUp(conn peerConn, height uint64, until []byte) (bool, error) {
+	for fc.next < height {
+		block, err := conn.GetBlockByNumber(fc.next)
+		if err != nil {
+			return true, err
+		}
+		hash, err := block.GetHash()
+		if err != nil {
+			return true, err
+		}
+		if cont, err := fc.deliver(block, hash); !cont {
+			return false, err
+		}
+		if until != nil && bytes.Equal(hash, until) {
+			break
+		}
+	}
+	return true, nil
+}
+
+// deliver hands the events of the next block over to the adapter, and
+// returns whether the adapter wants further events
+func (fc *FailoverClient) deliver(block *ehpb.Block, hash []byte) (bool, error) {
+	fc.lock.Lock()
+	fc.current = fc.next
+	fc.lock.Unlock()
+	fc.next++
+	fc.last = hash
+	fc.recent[string(hash)] = fc.current
+	fc.order = append(fc.order, hash)
+	if len(fc.order) > recentBlocks {
+		delete(fc.recent, string(fc.order[0]))
+		fc.order = fc.order[1:]
+	}
+
+	if fc.wantBlocks {
+		if cont, err := fc.adapter.Recv(&ehpb.Event{Event: &ehpb.Event_Block{Block: block}}); !cont {
+			return false, err
+		}
+	}
+	for _, tr := range block.GetNonHashData().GetTransactionResults() {
+		if tr.ChaincodeEvent == nil || !fc.wantChaincodeEvent(tr.ChaincodeEvent) {
+			continue
+		}
+		if cont, err := fc.adapter.Recv(&ehpb.Event{Event: &ehpb.Event_ChaincodeEvent{ChaincodeEvent: tr.ChaincodeEvent}}); !cont {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// wantChaincodeEvent returns whether the adapter registered interest in ce,
+// matching chaincode events as the events server does
+func (fc *FailoverClient) wantChaincodeEvent(ce *ehpb.ChaincodeEvent) bool {
+	for _, reg := range fc.chaincodes {
+		if reg.ChaincodeID == ce.ChaincodeID && (reg.EventName == ce.EventName || reg.EventName == "") {
+			return true
+		}
+	}
+	return false
+}
+
+// grpcPeerConn is the connection to a peer over gRPC
+type grpcPeerConn struct {
+	eventsConn *grpc.ClientConn
+	apiConn    *grpc.ClientConn
+	stream     ehpb.Events_ChatClient
+	api        ehpb.OpenchainClient
+}
+
+func dialPeer(addr PeerAddress) (peerConn, error) {
+	eventsConn, err := newEventsClientConnectionWithAddress(addr.Events)
+	if err != nil {
+		return nil, fmt.Errorf("Could not create client conn to %s", addr.Events)
+	}
+	apiConn, err := newEventsClientConnectionWithAddress(addr.API)
+	if err != nil {
+		eventsConn.Close()
+		return nil, fmt.Errorf("Could not create client conn to %s", addr.API)
+	}
+	pc := &grpcPeerConn{eventsConn: eventsConn, apiConn: apiConn, api: ehpb.NewOpenchainClient(apiConn)}
+	if pc.stream, err = ehpb.NewEventsClient(eventsConn).Chat(context.Background()); err != nil {
+		pc.Close()
+		return nil, fmt.Errorf("Could not create client conn to %s", addr.Events)
+	}
+	ec := &EventsClient{peerAddress: addr.Events, stream: pc.stream}
+	if err = ec.register([]*ehpb.Interest{{EventType: ehpb.EventType_BLOCK}}); err != nil {
+		pc.Close()
+		return nil, err
+	}
+	return pc, nil
+}
+
+func (pc *grpcPeerConn) Recv() (*ehpb.Event, error) {
+	return pc.stream.Recv()
+}
+
+func (pc *grpcPeerConn) GetBlockchainInfo() (*ehpb.BlockchainInfo, error) {
+	return pc.api.GetBlockchainInfo(context.Background(), &google_protobuf.Empty{})
+}
+
+func (pc *grpcPeerConn) GetBlockByNumber(number uint64) (*ehpb.Block, error) {
+	return pc.api.GetBlockByNumber(context.Background(), &ehpb.BlockNumber{Number: number})
+}
+
+func (pc *grpcPeerConn) Close() {
+	pc.eventsConn.Close()
+	pc.apiConn.Close()
+}
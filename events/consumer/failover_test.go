@@ -0,0 +1,185 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package consumer
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	ehpb "github.com/hyperledger/fabric/protos"
+)
+
+// mockChain is the chain all the mock peers hold
+type mockChain struct {
+	sync.Mutex
+	blocks []*ehpb.Block
+}
+
+func (c *mockChain) add() *ehpb.Block {
+	c.Lock()
+	defer c.Unlock()
+	n := len(c.blocks)
+	block := ehpb.NewBlock([]*ehpb.Transaction{{Uuid: fmt.Sprintf("tx%d", n)}}, nil)
+	block.NonHashData = &ehpb.NonHashData{TransactionResults: []*ehpb.TransactionResult{
+		{Uuid: fmt.Sprintf("tx%d", n), ChaincodeEvent: &ehpb.ChaincodeEvent{ChaincodeID: "mycc", EventName: "event", Payload: []byte{byte(n)}}},
+	}}
+	if n > 0 {
+		block.PreviousBlockHash, _ = c.blocks[n-1].GetHash()
+	}
+	c.blocks = append(c.blocks, block)
+	return block
+}
+
+type mockPeerConn struct {
+	chain  *mockChain
+	events chan *ehpb.Event
+	closed chan struct{}
+	once   sync.Once
+}
+
+func newMockPeerConn(chain *mockChain) *mockPeerConn {
+	return &mockPeerConn{chain: chain, events: make(chan *ehpb.Event, 10), closed: make(chan struct{})}
+}
+
+func (pc *mockPeerConn) send(block *ehpb.Block) {
+	pc.events <- &ehpb.Event{Event: &ehpb.Event_Block{Block: block}}
+}
+
+func (pc *mockPeerConn) Recv() (*ehpb.Event, error) {
+	select {
+	case ev := <-pc.events:
+		return ev, nil
+	case <-pc.closed:
+		return nil, fmt.Errorf("connection closed")
+	}
+}
+
+func (pc *mockPeerConn) GetBlockchainInfo() (*ehpb.BlockchainInfo, error) {
+	pc.chain.Lock()
+	defer pc.chain.Unlock()
+	return &ehpb.BlockchainInfo{Height: uint64(len(pc.chain.blocks))}, nil
+}
+
+func (pc *mockPeerConn) GetBlockByNumber(number uint64) (*ehpb.Block, error) {
+	pc.chain.Lock()
+	defer pc.chain.Unlock()
+	if number >= uint64(len(pc.chain.blocks)) {
+		return nil, fmt.Errorf("block %d not found", number)
+	}
+	return pc.chain.blocks[number], nil
+}
+
+func (pc *mockPeerConn) Close() {
+	pc.once.Do(func() { close(pc.closed) })
+}
+
+type mockAdapter struct {
+	interests []*ehpb.Interest
+	fc        *FailoverClient
+	blocks    chan uint64
+	payloads  chan byte
+}
+
+func (a *mockAdapter) GetInterestedEvents() ([]*ehpb.Interest, error) {
+	return a.interests, nil
+}
+
+func (a *mockAdapter) Recv(msg *ehpb.Event) (bool, error) {
+	if msg.GetBlock() != nil {
+		a.blocks <- a.fc.CurrentBlock()
+	}
+	if ce := msg.GetChaincodeEvent(); ce != nil {
+		a.payloads <- ce.Payload[0]
+	}
+	return true, nil
+}
+
+func (a *mockAdapter) Disconnected(err error) {}
+
+func (a *mockAdapter) expectBlocks(t *testing.T, numbers ...uint64) {
+	for _, n := range numbers {
+		select {
+		case got := <-a.blocks:
+			if got != n {
+				t.Fatalf("Expected block %d, got %d", n, got)
+			}
+			if p := <-a.payloads; uint64(p) != n {
+				t.Fatalf("Expected chaincode event of block %d, got that of %d", n, p)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("Expected block %d to be delivered", n)
+		}
+	}
+	select {
+	case got := <-a.blocks:
+		t.Fatalf("Expected no further block, got %d", got)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestFailoverClient(t *testing.T) {
+	chain := &mockChain{}
+	chain.add()
+	chain.add()
+
+	conns := map[string]*mockPeerConn{"vp0": newMockPeerConn(chain), "vp1": newMockPeerConn(chain)}
+	dialed := make(chan string, 10)
+	adapter := &mockAdapter{
+		interests: []*ehpb.Interest{
+			{EventType: ehpb.EventType_BLOCK},
+			{EventType: ehpb.EventType_CHAINCODE, RegInfo: &ehpb.Interest_ChaincodeRegInfo{ChaincodeRegInfo: &ehpb.ChaincodeReg{ChaincodeID: "mycc", EventName: "event"}}},
+		},
+		blocks:   make(chan uint64, 10),
+		payloads: make(chan byte, 10),
+	}
+	fc := NewFailoverClient([]PeerAddress{{Events: "vp0"}, {Events: "vp1"}}, adapter)
+	adapter.fc = fc
+	fc.dial = func(addr PeerAddress) (peerConn, error) {
+		dialed <- addr.Events
+		return conns[addr.Events], nil
+	}
+	fc.ResumeFrom(1)
+	if err := fc.Start(); err != nil {
+		t.Fatalf("Failed to start the client: %s", err)
+	}
+	defer fc.Stop()
+
+	// catch up from block 1, then follow the events of vp0
+	adapter.expectBlocks(t, 1)
+	conns["vp0"].send(chain.add())
+	adapter.expectBlocks(t, 2)
+
+	// vp0 goes away while blocks 3 and 4 are committed
+	b3 := chain.add()
+	chain.add()
+	conns["vp0"].Close()
+	adapter.expectBlocks(t, 3, 4)
+	if <-dialed != "vp0" || <-dialed != "vp1" {
+		t.Fatalf("Expected the client to fail over from vp0 to vp1")
+	}
+
+	// events of blocks delivered already are ignored
+	conns["vp1"].send(b3)
+	adapter.expectBlocks(t)
+
+	// an event whose previous block was missed triggers a catch up
+	chain.add()
+	conns["vp1"].send(chain.add())
+	adapter.expectBlocks(t, 5, 6)
+}
@@ -18,8 +18,10 @@ package events
 
 import (
 	"fmt"
+	"io/ioutil"
 	"net"
 	"os"
+	"path/filepath"
 	"sync"
 	"testing"
 	"time"
@@ -48,6 +50,7 @@ func (a *Adapter) GetInterestedEvents() ([]*ehpb.Interest, error) {
 		&ehpb.Interest{EventType: ehpb.EventType_BLOCK},
 		&ehpb.Interest{EventType: ehpb.EventType_CHAINCODE, RegInfo: &ehpb.Interest_ChaincodeRegInfo{ChaincodeRegInfo: &ehpb.ChaincodeReg{ChaincodeID: "0xffffffff", EventName: "event1"}}},
 		&ehpb.Interest{EventType: ehpb.EventType_CHAINCODE, RegInfo: &ehpb.Interest_ChaincodeRegInfo{ChaincodeRegInfo: &ehpb.ChaincodeReg{ChaincodeID: "0xffffffff", EventName: ""}}},
+		&ehpb.Interest{EventType: ehpb.EventType_CONSENSUS},
 	}, nil
 	//return []*ehpb.Interest{&ehpb.Interest{EventType: ehpb.EventType_BLOCK}}, nil
 }
@@ -57,6 +60,7 @@ func (a *Adapter) Recv(msg *ehpb.Event) (bool, error) {
 	switch x := msg.Event.(type) {
 	case *ehpb.Event_Block:
 	case *ehpb.Event_ChaincodeEvent:
+	case *ehpb.Event_ConsensusEvent:
 	case nil:
 		// The field is not set.
 		fmt.Printf("event not set\n")
@@ -145,6 +149,18 @@ func TestReceiveAnyMessage(t *testing.T) {
 	}
 }
 
+func TestReceiveConsensusEvent(t *testing.T) {
+	adapter.count = 1
+	producer.ConsensusSubscriber{}.ConsensusEvent(&ehpb.ConsensusEvent{Type: ehpb.ConsensusEvent_COMMITTED, SeqNo: 1})
+
+	select {
+	case <-adapter.notfy:
+	case <-time.After(5 * time.Second):
+		t.Fail()
+		t.Logf("timed out on consensus event")
+	}
+}
+
 func TestFailReceive(t *testing.T) {
 	var err error
 
@@ -163,6 +179,128 @@ func TestFailReceive(t *testing.T) {
 	}
 }
 
+type testChain struct {
+	sync.Mutex
+	blocks []*ehpb.Block
+}
+
+func (c *testChain) GetBlockchainSize() uint64 {
+	c.Lock()
+	defer c.Unlock()
+	return uint64(len(c.blocks))
+}
+
+func (c *testChain) GetBlockByNumber(blockNumber uint64) (*ehpb.Block, error) {
+	c.Lock()
+	defer c.Unlock()
+	if blockNumber >= uint64(len(c.blocks)) {
+		return nil, fmt.Errorf("no block %d", blockNumber)
+	}
+	return c.blocks[blockNumber], nil
+}
+
+// commit appends a block to the chain, with a chaincode event, and sends its
+// block event
+func (c *testChain) commit(t *testing.T, chaincodeID string) {
+	block := &ehpb.Block{NonHashData: &ehpb.NonHashData{TransactionResults: []*ehpb.TransactionResult{
+		{ChaincodeEvent: &ehpb.ChaincodeEvent{ChaincodeID: chaincodeID, EventName: "event1"}},
+	}}}
+	c.Lock()
+	c.blocks = append(c.blocks, block)
+	c.Unlock()
+	if err := producer.Send(producer.CreateBlockEvent(block)); err != nil {
+		t.Fatalf("Error sending block event: %s", err)
+	}
+}
+
+var chain = &testChain{blocks: []*ehpb.Block{{}}}
+
+type durableAdapter struct {
+	events chan *ehpb.Event
+}
+
+func (a *durableAdapter) GetInterestedEvents() ([]*ehpb.Interest, error) {
+	return []*ehpb.Interest{
+		{EventType: ehpb.EventType_BLOCK},
+		{EventType: ehpb.EventType_CHAINCODE, RegInfo: &ehpb.Interest_ChaincodeRegInfo{ChaincodeRegInfo: &ehpb.ChaincodeReg{ChaincodeID: "durablecc"}}},
+	}, nil
+}
+
+func (a *durableAdapter) Recv(msg *ehpb.Event) (bool, error) {
+	a.events <- msg
+	return true, nil
+}
+
+func (a *durableAdapter) Disconnected(err error) {}
+
+func startDurable(t *testing.T, a *durableAdapter) *consumer.EventsClient {
+	var err error
+	// The peer may not have noticed the previous consumer left yet
+	for i := 0; i < 20; i++ {
+		client := consumer.NewDurableEventsClient(peerAddress, "durable1", a)
+		if err = client.Start(); err == nil {
+			return client
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	t.Fatalf("Error starting durable subscription: %s", err)
+	return nil
+}
+
+func expectEvents(t *testing.T, a *durableAdapter, blockNumber uint64) {
+	for _, isBlock := range []bool{true, false} {
+		select {
+		case ev := <-a.events:
+			if (ev.GetBlock() != nil) != isBlock || ev.BlockNumber != blockNumber {
+				t.Fatalf("Expected the block event (%v) of block %d, got %v of block %d", isBlock, blockNumber, ev.Event, ev.BlockNumber)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Timed out waiting for the events of block %d", blockNumber)
+		}
+	}
+}
+
+func TestDurableSubscription(t *testing.T) {
+	// The block events reach the other consumer too
+	adapter.Lock()
+	adapter.count = 1000
+	adapter.Unlock()
+
+	a := &durableAdapter{events: make(chan *ehpb.Event, 10)}
+	client := startDurable(t, a)
+	// A new subscription starts with the next block
+	chain.commit(t, "durablecc")
+	expectEvents(t, a, 1)
+	chain.commit(t, "othercc")
+	select {
+	case ev := <-a.events:
+		if ev.GetBlock() == nil || ev.BlockNumber != 2 {
+			t.Fatalf("Expected the block event of block 2, got %v", ev)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Timed out waiting for block 2")
+	}
+	if err := client.Ack(1); err != nil {
+		t.Fatalf("Error acknowledging block 1: %s", err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	client.Stop()
+
+	// Delivery resumes after the last block acknowledged, including the
+	// blocks committed in the meantime
+	chain.commit(t, "durablecc")
+	a = &durableAdapter{events: make(chan *ehpb.Event, 10)}
+	client = startDurable(t, a)
+	if ev := <-a.events; ev.BlockNumber != 2 {
+		t.Fatalf("Expected to resume at block 2, got block %d", ev.BlockNumber)
+	}
+	expectEvents(t, a, 3)
+	if err := client.Unsubscribe(); err != nil {
+		t.Fatalf("Error unsubscribing: %s", err)
+	}
+	client.Stop()
+}
+
 func BenchmarkMessages(b *testing.B) {
 	numMessages := 10000
 
@@ -216,6 +354,15 @@ func TestMain(m *testing.M) {
 	// use a buffer of 100 and blocking timeout
 	ehServer := producer.NewEventsServer(100, 0)
 	ehpb.RegisterEventsServer(grpcServer, ehServer)
+	tempDir, err := ioutil.TempDir("", "events-test")
+	if err != nil {
+		fmt.Printf("Error creating directory %s....not doing tests", err)
+		return
+	}
+	if err = ehServer.EnableSubscriptions(filepath.Join(tempDir, "subscriptions.json"), chain); err != nil {
+		fmt.Printf("Error enabling subscriptions %s....not doing tests", err)
+		return
+	}
 
 	fmt.Printf("Starting events server\n")
 	go grpcServer.Serve(lis)
@@ -231,5 +378,7 @@ func TestMain(m *testing.M) {
 
 	time.Sleep(2 * time.Second)
 
-	os.Exit(m.Run())
+	ret := m.Run()
+	os.RemoveAll(tempDir)
+	os.Exit(ret)
 }
@@ -29,3 +29,19 @@ func CreateBlockEvent(te *ehpb.Block) *ehpb.Event {
 func CreateChaincodeEvent(te *ehpb.ChaincodeEvent) *ehpb.Event {
 	return &ehpb.Event{Event: &ehpb.Event_ChaincodeEvent{ChaincodeEvent: te}}
 }
+
+// CreateConsensusEvent creates a Event from a ConsensusEvent
+func CreateConsensusEvent(te *ehpb.ConsensusEvent) *ehpb.Event {
+	return &ehpb.Event{Event: &ehpb.Event_ConsensusEvent{ConsensusEvent: te}}
+}
+
+// ConsensusSubscriber forwards the lifecycle events of the consensus plugin
+// to the consumers registered for CONSENSUS events
+type ConsensusSubscriber struct{}
+
+// ConsensusEvent sends a lifecycle event of the consensus plugin
+func (ConsensusSubscriber) ConsensusEvent(te *ehpb.ConsensusEvent) {
+	if err := Send(CreateConsensusEvent(te)); err != nil {
+		producerLogger.Warningf("Error sending consensus event: %s", err)
+	}
+}
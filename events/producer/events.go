@@ -21,6 +21,8 @@ import (
 	"sync"
 	"time"
 
+	"github.com/hyperledger/fabric/core/chaos"
+	"github.com/hyperledger/fabric/core/watchdog"
 	pb "github.com/hyperledger/fabric/protos"
 )
 
@@ -218,6 +220,7 @@ func (ep *eventProcessor) start() {
 	for {
 		//wait for event
 		e := <-ep.eventChannel
+		chaos.WaitResumed(chaos.EventHub, nil)
 
 		var hl handlerList
 		eType := getMessageType(e)
@@ -230,6 +233,10 @@ func (ep *eventProcessor) start() {
 		//lock the handler map lock
 		ep.Unlock()
 
+		if eType == pb.EventType_BLOCK && gSubscriptions != nil {
+			gSubscriptions.notify()
+		}
+
 		hl.foreach(e, func(h *handler) {
 			if e.Event != nil {
 				h.SendMessage(e)
@@ -249,6 +256,10 @@ func initializeEvents(bufferSize uint, tout int) {
 
 	addInternalEventTypes()
 
+	watchdog.RegisterQueue("events", func() (int, int) {
+		return len(gEventProcessor.eventChannel), cap(gEventProcessor.eventChannel)
+	})
+
 	//start the event processor
 	go gEventProcessor.start()
 }
@@ -263,7 +274,7 @@ func AddEventType(eventType pb.EventType) error {
 	}
 
 	switch eventType {
-	case pb.EventType_BLOCK:
+	case pb.EventType_BLOCK, pb.EventType_CONSENSUS:
 		gEventProcessor.eventConsumers[eventType] = &genericHandlerList{handlers: make(map[*handler]bool)}
 	case pb.EventType_CHAINCODE:
 		gEventProcessor.eventConsumers[eventType] = &chaincodeHandlerList{handlers: make(map[string]map[string]map[*handler]bool)}
@@ -28,13 +28,16 @@ type handler struct {
 	registered bool
 	// PM: this should be a list, add/del, iterate
 	interestedEvents []*pb.Interest
+	// durable subscription the consumer registered, if any
+	durable *durable
 }
 
 func newEventHandler(stream pb.Events_ChatServer) (*handler, error) {
 	d := &handler{
 		ChatStream: stream,
 	}
-	d.doneChan = make(chan bool)
+	// Stop must not block the end of the chat, nothing waits for it
+	d.doneChan = make(chan bool, 1)
 	return d, nil
 }
 
@@ -83,15 +86,75 @@ func (d *handler) deregister() {
 	}
 	// PM the following should release slice and its elements for GC?
 	d.interestedEvents = nil
+	if d.durable != nil {
+		gSubscriptions.release(d.durable)
+		d.durable = nil
+	}
+}
+
+// subscribe connects the consumer to a durable subscription, and starts
+// delivering its events once the registration is acknowledged
+func (d *handler) subscribe(msg *pb.Event) error {
+	reg := msg.GetRegister()
+	if gSubscriptions == nil {
+		return subscriptionError{fmt.Errorf("durable subscriptions are not enabled")}
+	}
+	if d.registered {
+		return subscriptionError{fmt.Errorf("already registered")}
+	}
+	dur, err := gSubscriptions.subscribe(reg.Subscription, reg.Events, d)
+	if err != nil {
+		return subscriptionError{err}
+	}
+	if err = d.ChatStream.Send(msg); err != nil {
+		gSubscriptions.release(dur)
+		return fmt.Errorf("Error sending response to %v:  %s", msg, err)
+	}
+	d.durable = dur
+	d.registered = true
+	go dur.run(gSubscriptions)
+	return nil
+}
+
+// ack moves the cursor of the durable subscription of the consumer
+func (d *handler) ack(ack *pb.Ack) error {
+	if d.durable == nil || d.durable.name != ack.Subscription {
+		return fmt.Errorf("not subscribed to %s", ack.Subscription)
+	}
+	return gSubscriptions.ack(d.durable, ack.BlockNumber)
+}
+
+// unsubscribe deletes a durable subscription
+func (d *handler) unsubscribe(unsub *pb.Unsubscribe) error {
+	if gSubscriptions == nil {
+		return fmt.Errorf("durable subscriptions are not enabled")
+	}
+	if err := gSubscriptions.unsubscribe(unsub.Subscription, d); err != nil {
+		return err
+	}
+	if d.durable != nil && d.durable.name == unsub.Subscription {
+		d.durable = nil
+		d.registered = false
+	}
+	return nil
 }
 
 // HandleMessage handles the Openchain messages for the Peer.
 func (d *handler) HandleMessage(msg *pb.Event) error {
 	producerLogger.Debug("Handling Event")
+	if ack := msg.GetAck(); ack != nil {
+		return d.ack(ack)
+	}
+	if unsub := msg.GetUnsubscribe(); unsub != nil {
+		return d.unsubscribe(unsub)
+	}
 	eventsObj := msg.GetRegister()
 	if eventsObj == nil {
 		return fmt.Errorf("Invalid object from consumer %v", msg.GetEvent())
 	}
+	if eventsObj.Subscription != "" {
+		return d.subscribe(msg)
+	}
 
 	if err := d.register(eventsObj.Events); err != nil {
 		return fmt.Errorf("Could not register events %s", err)
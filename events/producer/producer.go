@@ -68,6 +68,10 @@ func (p *EventsServer) Chat(stream pb.Events_ChatServer) error {
 		err = handler.HandleMessage(in)
 		if err != nil {
 			producerLogger.Errorf("Error handling message: %s", err)
+			if _, ok := err.(subscriptionError); ok {
+				// The consumer waits for its registration
+				return err
+			}
 			//return err
 		}
 
@@ -0,0 +1,293 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package producer
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	pb "github.com/hyperledger/fabric/protos"
+)
+
+// A durable subscription is named by its consumer and kept by the peer, with
+// its cursor, the number of the first block whose events the consumer did not
+// acknowledge yet. Its events are not those passed to Send, but derived from
+// the blocks of the chain, as the consumer may be away when they are sent: the
+// block event of each block, then the chaincode events of its transactions
+// the consumer is interested in. When the consumer connects again, delivery
+// resumes at the cursor, so the events it did not acknowledge are delivered
+// again.
+
+// durablePollInterval is how often the blocks of the chain are checked for
+// durable subscriptions, in case the block event of a new block was dropped
+const durablePollInterval = 5 * time.Second
+
+// BlockSource gives access to the blocks of the chain
+type BlockSource interface {
+	GetBlockchainSize() uint64
+	GetBlockByNumber(blockNumber uint64) (*pb.Block, error)
+}
+
+// subscription is what the peer keeps of a durable subscription
+type subscription struct {
+	Blocks     bool               `json:"blocks"`
+	Chaincodes []*pb.ChaincodeReg `json:"chaincodes,omitempty"`
+	Cursor     uint64             `json:"cursor"`
+}
+
+// wants returns whether the subscription is interested in ce, matching
+// chaincode events as chaincodeHandlerList does
+func (s *subscription) wants(ce *pb.ChaincodeEvent) bool {
+	for _, reg := range s.Chaincodes {
+		if reg.ChaincodeID == ce.ChaincodeID && (reg.EventName == ce.EventName || reg.EventName == "") {
+			return true
+		}
+	}
+	return false
+}
+
+// subscriptionError is returned by a handler which could not register a
+// durable subscription, for the chat to end with it
+type subscriptionError struct {
+	error
+}
+
+// subscriptionRegistry holds the durable subscriptions, and those a consumer
+// is connected to
+type subscriptionRegistry struct {
+	sync.Mutex
+	file   string
+	blocks BlockSource
+	subs   map[string]*subscription
+	active map[string]*durable
+}
+
+// gSubscriptions is set once durable subscriptions are enabled
+var gSubscriptions *subscriptionRegistry
+
+// EnableSubscriptions lets consumers register durable subscriptions, which
+// are kept in file and whose events are derived from the blocks of blocks
+func (p *EventsServer) EnableSubscriptions(file string, blocks BlockSource) error {
+	reg := &subscriptionRegistry{
+		file:   file,
+		blocks: blocks,
+		subs:   make(map[string]*subscription),
+		active: make(map[string]*durable),
+	}
+	raw, err := ioutil.ReadFile(file)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err == nil {
+		if err = json.Unmarshal(raw, &reg.subs); err != nil {
+			return fmt.Errorf("Error reading the durable subscriptions from %s: %s", file, err)
+		}
+	}
+	gSubscriptions = reg
+	return nil
+}
+
+// save replaces the subscriptions kept in the file, so that a crash leaves
+// either the previous subscriptions or these
+func (reg *subscriptionRegistry) save() error {
+	raw, err := json.MarshalIndent(reg.subs, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(filepath.Dir(reg.file), 0755); err != nil {
+		return err
+	}
+	tmp := reg.file + ".tmp"
+	if err = ioutil.WriteFile(tmp, raw, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, reg.file)
+}
+
+// subscribe connects the handler to the durable subscription with the given
+// name, which is created if it does not exist yet, from the next block on
+func (reg *subscriptionRegistry) subscribe(name string, interests []*pb.Interest, h *handler) (*durable, error) {
+	sub := &subscription{}
+	for _, ie := range interests {
+		switch ie.EventType {
+		case pb.EventType_BLOCK:
+			sub.Blocks = true
+		case pb.EventType_CHAINCODE:
+			if ie.GetChaincodeRegInfo() == nil || ie.GetChaincodeRegInfo().ChaincodeID == "" {
+				return nil, fmt.Errorf("chaincode ID not provided for registering")
+			}
+			sub.Chaincodes = append(sub.Chaincodes, ie.GetChaincodeRegInfo())
+		default:
+			return nil, fmt.Errorf("%s events cannot be delivered to a durable subscription", ie.EventType)
+		}
+	}
+
+	reg.Lock()
+	defer reg.Unlock()
+	if reg.active[name] != nil {
+		return nil, fmt.Errorf("subscription %s is in use", name)
+	}
+	if old, ok := reg.subs[name]; ok {
+		sub.Cursor = old.Cursor
+	} else {
+		sub.Cursor = reg.blocks.GetBlockchainSize()
+	}
+	reg.subs[name] = sub
+	if err := reg.save(); err != nil {
+		return nil, fmt.Errorf("Error saving subscription %s: %s", name, err)
+	}
+	d := &durable{
+		name: name,
+		sub:  sub,
+		h:    h,
+		next: sub.Cursor,
+		wake: make(chan struct{}, 1),
+		stop: make(chan struct{}),
+	}
+	reg.active[name] = d
+	producerLogger.Infof("Resuming durable subscription %s at block %d", name, sub.Cursor)
+	return d, nil
+}
+
+// ack moves the cursor of the subscription past blockNumber
+func (reg *subscriptionRegistry) ack(d *durable, blockNumber uint64) error {
+	reg.Lock()
+	defer reg.Unlock()
+	if blockNumber >= d.next {
+		return fmt.Errorf("block %d of subscription %s was not delivered yet", blockNumber, d.name)
+	}
+	if blockNumber < d.sub.Cursor {
+		return nil
+	}
+	d.sub.Cursor = blockNumber + 1
+	return reg.save()
+}
+
+// unsubscribe deletes the durable subscription with the given name, unless
+// another consumer is connected to it
+func (reg *subscriptionRegistry) unsubscribe(name string, h *handler) error {
+	reg.Lock()
+	defer reg.Unlock()
+	if d := reg.active[name]; d != nil {
+		if d.h != h {
+			return fmt.Errorf("subscription %s is in use", name)
+		}
+		delete(reg.active, name)
+		close(d.stop)
+	}
+	if _, ok := reg.subs[name]; !ok {
+		return fmt.Errorf("subscription %s does not exist", name)
+	}
+	delete(reg.subs, name)
+	return reg.save()
+}
+
+// release disconnects the handler from its durable subscription
+func (reg *subscriptionRegistry) release(d *durable) {
+	reg.Lock()
+	defer reg.Unlock()
+	if reg.active[d.name] == d {
+		delete(reg.active, d.name)
+		close(d.stop)
+	}
+}
+
+// notify wakes up the durable subscriptions once a block is committed
+func (reg *subscriptionRegistry) notify() {
+	reg.Lock()
+	defer reg.Unlock()
+	for _, d := range reg.active {
+		select {
+		case d.wake <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// durable delivers the events of a durable subscription to the handler of
+// the consumer connected to it
+type durable struct {
+	name string
+	sub  *subscription
+	h    *handler
+	next uint64 // number of the next block to deliver, guarded by the registry
+	wake chan struct{}
+	stop chan struct{}
+}
+
+// run delivers the events of the blocks from the cursor on, until the
+// consumer disconnects
+func (d *durable) run(reg *subscriptionRegistry) {
+	for {
+		reg.Lock()
+		next := d.next
+		reg.Unlock()
+		for height := reg.blocks.GetBlockchainSize(); next < height; next++ {
+			block, err := reg.blocks.GetBlockByNumber(next)
+			if err != nil {
+				producerLogger.Errorf("Error getting block %d for subscription %s: %s", next, d.name, err)
+				break
+			}
+			if err = d.deliver(block, next); err != nil {
+				producerLogger.Warningf("Error delivering block %d to subscription %s: %s", next, d.name, err)
+				return
+			}
+			reg.Lock()
+			d.next = next + 1
+			reg.Unlock()
+		}
+
+		select {
+		case <-d.wake:
+		case <-time.After(durablePollInterval):
+		case <-d.stop:
+			return
+		}
+	}
+}
+
+// deliver sends the events of the block the subscription is interested in
+func (d *durable) deliver(block *pb.Block, number uint64) error {
+	select {
+	case <-d.stop:
+		return fmt.Errorf("subscription released")
+	default:
+	}
+	if d.sub.Blocks {
+		e := CreateBlockEvent(block)
+		e.BlockNumber = number
+		if err := d.h.SendMessage(e); err != nil {
+			return err
+		}
+	}
+	for _, tr := range block.GetNonHashData().GetTransactionResults() {
+		if tr.ChaincodeEvent == nil || !d.sub.wants(tr.ChaincodeEvent) {
+			continue
+		}
+		e := CreateChaincodeEvent(tr.ChaincodeEvent)
+		e.BlockNumber = number
+		if err := d.h.SendMessage(e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
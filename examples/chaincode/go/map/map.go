@@ -20,6 +20,7 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"strconv"
 
 	"github.com/hyperledger/fabric/core/chaincode/shim"
 )
@@ -34,6 +35,8 @@ import (
 // Query operations
 // get - requires one argument, a key, and returns a value
 // keys - requires no arguments, returns all keys
+// keysPage - requires a page size and optionally the bookmark of the previous
+// page, returns a page of keys and the bookmark of the next page
 
 // SimpleChaincode example simple Chaincode implementation
 type SimpleChaincode struct {
@@ -123,6 +126,47 @@ func (t *SimpleChaincode) Query(stub *shim.ChaincodeStub, function string, args
 
 		return jsonKeys, nil
 
+	case "keysPage":
+		if len(args) < 1 {
+			return nil, errors.New("keysPage operation must include a page size")
+		}
+		size, err := strconv.Atoi(args[0])
+		if err != nil || size < 1 {
+			return nil, errors.New("keysPage operation must include a positive page size")
+		}
+		bookmark := ""
+		if len(args) > 1 {
+			bookmark = args[1]
+		}
+
+		keysIter, err := stub.RangeQueryStateFrom("", "", bookmark)
+		if err != nil {
+			return nil, fmt.Errorf("keysPage operation failed. Error accessing state: %s", err)
+		}
+		defer keysIter.Close()
+
+		page := struct {
+			Keys     []string `json:"keys"`
+			Bookmark string   `json:"bookmark,omitempty"`
+		}{Keys: []string{}}
+		for len(page.Keys) < size && keysIter.HasNext() {
+			key, _, iterErr := keysIter.Next()
+			if iterErr != nil {
+				return nil, fmt.Errorf("keysPage operation failed. Error accessing state: %s", iterErr)
+			}
+			page.Keys = append(page.Keys, key)
+		}
+		if keysIter.HasNext() {
+			page.Bookmark = keysIter.Bookmark()
+		}
+
+		jsonPage, err := json.Marshal(page)
+		if err != nil {
+			return nil, fmt.Errorf("keysPage operation failed. Error marshaling JSON: %s", err)
+		}
+
+		return jsonPage, nil
+
 	default:
 		return nil, errors.New("Unsupported operation")
 	}
@@ -408,19 +408,28 @@ func (ecap *ECAP) ReadCertificatePair(ctx context.Context, in *pb.ECertReadReq)
 	Trace.Println("gRPC ECAP:ReadCertificate")
 
 	rows, err := ecap.eca.readCertificates(in.Id.Id)
+	if err != nil {
+		return nil, err
+	}
 	defer rows.Close()
 
 	var certs [][]byte
+	for rows.Next() && err == nil {
+		var raw, kdfKey []byte
+		err = rows.Scan(&raw, &kdfKey)
+		certs = append(certs, raw)
+	}
 	if err == nil {
-		for rows.Next() {
-			var raw []byte
-			err = rows.Scan(&raw)
-			certs = append(certs, raw)
-		}
 		err = rows.Err()
 	}
+	if err != nil {
+		return nil, err
+	}
+	if len(certs) < 2 {
+		return nil, errors.New("No enrollment certificates found for " + in.Id.Id + ".")
+	}
 
-	return &pb.CertPair{Sign: certs[0], Enc: certs[1]}, err
+	return &pb.CertPair{Sign: certs[0], Enc: certs[1]}, nil
 }
 
 // ReadCertificateByHash reads a single enrollment certificate by hash from the ECA.
@@ -310,24 +310,25 @@ func TestReadCertificatePair(t *testing.T) {
 
 	req := &pb.ECertReadReq{Id: &pb.Identity{Id: testUser.enrollID}}
 
-	_, err := ecap.ReadCertificatePair(context.Background(), req)
+	pair, err := ecap.ReadCertificatePair(context.Background(), req)
 
 	if err != nil {
 		t.Errorf("Failed to read certificate pair: [%s]", err.Error())
+	} else if _, err := x509.ParseCertificate(pair.Sign); err != nil {
+		t.Errorf("Failed to parse the signing certificate of the pair: [%s]", err.Error())
 	}
 
 }
 
 func TestReadCertificatePairBadIdentity(t *testing.T) {
-	t.SkipNow() //need to fix error
 	ecap := &ECAP{eca}
 
 	req := &pb.ECertReadReq{Id: &pb.Identity{Id: "badUser"}}
 
 	_, err := ecap.ReadCertificatePair(context.Background(), req)
 
-	if err != nil {
-		t.Errorf("Failed to read certificate pair: [%s]", err.Error())
+	if err == nil {
+		t.Error("Reading the certificate pair of an unknown user should fail.")
 	}
 
 }
@@ -17,10 +17,13 @@ limitations under the License.
 package main
 
 import (
+	"bufio"
 	"bytes"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net"
 	"os"
@@ -32,11 +35,14 @@ import (
 	"sync"
 	"syscall"
 	"time"
+	"unicode/utf8"
 
+	"golang.org/x/crypto/ssh/terminal"
 	"golang.org/x/net/context"
 
 	"google/protobuf"
 
+	"github.com/golang/protobuf/proto"
 	"github.com/howeyc/gopass"
 	"github.com/op/go-logging"
 	"github.com/spf13/cobra"
@@ -46,17 +52,31 @@ import (
 	"google.golang.org/grpc/grpclog"
 
 	"net/http"
-	_ "net/http/pprof"
 
+	"github.com/hyperledger/fabric/consensus"
+	"github.com/hyperledger/fabric/consensus/compare"
+	"github.com/hyperledger/fabric/consensus/controller"
 	"github.com/hyperledger/fabric/consensus/helper"
+	"github.com/hyperledger/fabric/consensus/obcpbft"
 	"github.com/hyperledger/fabric/core"
 	"github.com/hyperledger/fabric/core/chaincode"
 	"github.com/hyperledger/fabric/core/comm"
 	"github.com/hyperledger/fabric/core/crypto"
+	"github.com/hyperledger/fabric/core/db"
+	"github.com/hyperledger/fabric/core/health"
+	healthpb "github.com/hyperledger/fabric/core/health/grpc_health_v1"
+	"github.com/hyperledger/fabric/core/ledger"
+	"github.com/hyperledger/fabric/core/ledger/backup"
 	"github.com/hyperledger/fabric/core/ledger/genesis"
+	"github.com/hyperledger/fabric/core/ledger/inspect"
+	"github.com/hyperledger/fabric/core/metrics"
 	"github.com/hyperledger/fabric/core/peer"
+	"github.com/hyperledger/fabric/core/profiling"
+	"github.com/hyperledger/fabric/core/replica"
 	"github.com/hyperledger/fabric/core/rest"
+	"github.com/hyperledger/fabric/core/startup"
 	"github.com/hyperledger/fabric/core/system_chaincode"
+	"github.com/hyperledger/fabric/core/watchdog"
 	"github.com/hyperledger/fabric/events/producer"
 	pb "github.com/hyperledger/fabric/protos"
 )
@@ -68,6 +88,9 @@ const fabric = "hyperledger"
 const nodeFuncName = "node"
 const networkFuncName = "network"
 const chainFuncName = "chaincode"
+const ledgerFuncName = "ledger"
+const consensusFuncName = "consensus"
+const blockchainFuncName = "chain"
 const cmdRoot = "core"
 const undefinedParamValue = ""
 
@@ -120,6 +143,72 @@ var nodeStopCmd = &cobra.Command{
 	},
 }
 
+// Profile related variables.
+var profileType string
+
+var nodeProfileCmd = &cobra.Command{
+	Use:   "profile",
+	Short: "Captures a profile of the local peer.",
+	Long:  `Captures a heap or goroutine profile of the local peer, which the peer stores in its profiles directory, and prints where it was written.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return nodeProfile()
+	},
+}
+
+var nodePauseCmd = &cobra.Command{
+	Use:   "pause <subsystem>",
+	Short: "Pauses a subsystem of the local peer.",
+	Long:  `Pauses the eventhub, blocksync or chaincode subsystem of the local peer until it is resumed, to observe how the rest of the peer degrades. Only available in peers built with the chaos build tag.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return nodeSubsystem(args, true)
+	},
+}
+
+var nodeResumeCmd = &cobra.Command{
+	Use:   "resume <subsystem>",
+	Short: "Resumes a paused subsystem of the local peer.",
+	Long:  `Resumes a subsystem of the local peer paused with pause, releasing the work it held.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return nodeSubsystem(args, false)
+	},
+}
+
+var nodeDenyCmd = &cobra.Command{
+	Use:   "deny <peer.id> [reason]",
+	Short: "Cuts a peer off from the local peer.",
+	Long:  `Drops the messages and connections of the peer of the given peer.id on the local peer right away, until it is allowed again, for instance to respond to a compromised validator before the network is reconfigured. The reason is recorded with the peer in the deny-list, which survives restarts. Other peers are not affected.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return nodeDeny(args)
+	},
+}
+
+var nodeAllowCmd = &cobra.Command{
+	Use:   "allow <peer.id>",
+	Short: "Lets a denied peer connect to the local peer again.",
+	Long:  `Removes the peer of the given peer.id from the deny-list of the local peer, which accepts its connections again.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return nodeAllow(args)
+	},
+}
+
+var nodeDeniedCmd = &cobra.Command{
+	Use:   "denied",
+	Short: "Lists the peers denied by the local peer.",
+	Long:  `Lists the peers in the deny-list of the local peer, with the reason and time they were denied.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return nodeDenied()
+	},
+}
+
+var nodeSyncCmd = &cobra.Command{
+	Use:   "sync [peer.id]",
+	Short: "Catches the local peer up with the network.",
+	Long:  `Starts a state transfer of the local validating peer to the latest state the network attests to, fetched from the peer of the given peer.id, or any peer attesting to it, and prints its progress until it completes. The progress of the latest state transfer is also reported by status.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return nodeSync(args)
+	},
+}
+
 var networkCmd = &cobra.Command{
 	Use:   networkFuncName,
 	Short: fmt.Sprintf("%s specific commands.", networkFuncName),
@@ -166,6 +255,173 @@ var networkListCmd = &cobra.Command{
 	},
 }
 
+// Network list related variables.
+var (
+	networkListOrg   string
+	networkListMyOrg bool
+)
+
+var ledgerCmd = &cobra.Command{
+	Use:   ledgerFuncName,
+	Short: fmt.Sprintf("%s specific commands.", ledgerFuncName),
+	Long:  fmt.Sprintf("%s specific commands.", ledgerFuncName),
+	PersistentPreRun: func(cmd *cobra.Command, args []string) {
+		core.LoggingInit(ledgerFuncName)
+	},
+}
+
+var ledgerUpgradeCmd = &cobra.Command{
+	Use:   "upgrade",
+	Short: "Upgrades the on-disk format of the ledger.",
+	Long:  `Runs the migrations needed to bring the ledger data of the local peer to the format supported by this peer. The peer must be stopped.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return ledgerUpgrade()
+	},
+}
+
+var ledgerCompactCmd = &cobra.Command{
+	Use:   "compact",
+	Short: "Compacts the ledger DB of the local peer.",
+	Long:  `Compacts every column family of the ledger DB of the running local peer, reporting progress as each one completes. Compaction is heavy on IO, see 'ledger.db.compaction.windows' to schedule it in quiet periods instead.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return ledgerCompact()
+	},
+}
+
+var ledgerRestoreCmd = &cobra.Command{
+	Use:   "restore",
+	Short: "Rebuilds the ledger of the local peer from its backup.",
+	Long:  `Rebuilds the empty ledger of the local peer from the latest snapshot of the bucket configured under 'ledger.backup'. Blocks committed after that snapshot are caught up through state transfer once the peer starts. The peer must be stopped.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return ledgerRestore()
+	},
+}
+
+var ledgerStatsCmd = &cobra.Command{
+	Use:   "stats",
+	Short: "Prints statistics of the ledger of the local peer.",
+	Long:  `Prints the number of keys and bytes of state held by each chaincode of the running local peer, along with the number of blocks and the distribution of their sizes.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return ledgerStats()
+	},
+}
+
+var consensusCmd = &cobra.Command{
+	Use:   consensusFuncName,
+	Short: fmt.Sprintf("%s specific commands.", consensusFuncName),
+	Long:  fmt.Sprintf("%s specific commands.", consensusFuncName),
+	PersistentPreRun: func(cmd *cobra.Command, args []string) {
+		core.LoggingInit(consensusFuncName)
+	},
+}
+
+var consensusStatusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Dumps the consensus state of the local peer.",
+	Long:  `Returns a snapshot of the internal state of the consensus plugin of the local validating peer, such as its view, watermarks, outstanding requests and certificates, formatted as JSON.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return consensusStatus()
+	},
+}
+
+// Consensus trace related variables.
+var (
+	traceTypes string
+	traceView  int64
+	traceSeqNo int64
+)
+
+var consensusTraceCmd = &cobra.Command{
+	Use:   "trace",
+	Short: "Streams the consensus messages of the local peer.",
+	Long:  `Streams the consensus messages the local validating peer sends and receives, decoded, until interrupted. Messages may be filtered by type, view and sequence number.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return consensusTrace()
+	},
+}
+
+var consensusReplayCmd = &cobra.Command{
+	Use:   "replay <rejects>",
+	Short: "Replays rejected consensus messages to the local peer.",
+	Long:  `Hands the consensus messages kept in a rejects directory, or in one of its files, to the local validating peer as if their senders had sent them again, in the order they were rejected, and reports whether the peer rejects them again and why. The peer logs how it handles them.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return consensusReplay(args)
+	},
+}
+
+var consensusReconfigureCmd = &cobra.Command{
+	Use:   "reconfigure <N> <f>",
+	Short: "Reconfigures the validating network to N validators tolerating f faults.",
+	Long:  `Asks the local validating peer to request the reconfiguration of the validating network to N validators, vp0 to vp<N-1>, tolerating f faults. The request is ordered through consensus as the vote of the peer; once 2f+1 validators requested the same N and f, the reconfiguration takes effect on all validators at the next stable checkpoint. Validators joining the network must be started with the new N and f.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return consensusReconfigure(args)
+	},
+}
+
+// Consensus comparison related variables.
+var (
+	comparePlugins      string
+	compareValidators   int
+	compareTransactions int
+	compareInterval     time.Duration
+	compareSpeedup      float64
+	compareTimeout      time.Duration
+)
+
+var consensusCompareCmd = &cobra.Command{
+	Use:   "compare [trace]",
+	Short: "Compares consensus plugins running the same workload.",
+	Long:  `Runs the same workload through each consensus plugin on an in-process network of validators, and reports their throughput, latency and message counts side by side. The workload is the client transactions of a message trace recorded by a validator, at their recorded pace, or a synthetic workload without a trace. The plugins read their configuration as in the peer. No peer needs to be running.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return consensusCompare(args)
+	},
+}
+
+// Chain archive related variables.
+var (
+	archiveFrom   string
+	archiveTo     string
+	archiveFile   string
+	archiveAnchor string
+	inspectTxUUID string
+)
+
+var blockchainCmd = &cobra.Command{
+	Use:   blockchainFuncName,
+	Short: fmt.Sprintf("%s specific commands.", blockchainFuncName),
+	Long:  fmt.Sprintf("%s specific commands.", blockchainFuncName),
+	PersistentPreRun: func(cmd *cobra.Command, args []string) {
+		core.LoggingInit(blockchainFuncName)
+	},
+}
+
+var blockchainExportCmd = &cobra.Command{
+	Use:   "export",
+	Short: "Exports the transactions of a time range to a signed archive.",
+	Long:  `Writes the transactions whose timestamp falls within the given range to an archive signed by the local peer, along with the blocks which prove their inclusion in the chain up to the current chain head. The peer must be stopped.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return blockchainExport()
+	},
+}
+
+var blockchainVerifyCmd = &cobra.Command{
+	Use:   "verify <archive>",
+	Short: "Verifies an archive against a published chain-head anchor.",
+	Long:  `Checks that the blocks of an archive form a hash chain ending in a block whose hash is the given anchor, and that the archive is signed by a peer of the network, then lists the transactions of the archive.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return blockchainVerify(args)
+	},
+}
+
+var blockchainInspectCmd = &cobra.Command{
+	Use:   "inspect [block number]",
+	Short: "Prints a block or a transaction as decoded JSON.",
+	Long:  `Prints the block with the given number, the latest block by default, along with the changes it made to the state, or the transaction with the given UUID, as canonical JSON in which the transactions, chaincode specs and state values are decoded. The peer must be stopped.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return blockchainInspect(args)
+	},
+}
+
 // login related variables.
 var (
 	loginPW string
@@ -181,7 +437,10 @@ var (
 	chaincodeUsr            string
 	chaincodeQueryRaw       bool
 	chaincodeQueryHex       bool
+	chaincodeQueryMinHeight uint64
 	chaincodeAttributesJSON string
+	chaincodeManifestFile   string
+	chaincodeSimulateColor  bool
 )
 
 var chaincodeCmd = &cobra.Command{
@@ -225,6 +484,27 @@ var chaincodeQueryCmd = &cobra.Command{
 	},
 }
 
+var chaincodeSimulateCmd = &cobra.Command{
+	Use:   "simulate",
+	Short: fmt.Sprintf("Simulate an invocation of the specified %s.", chainFuncName),
+	Long: fmt.Sprintf(`Run an invocation of the specified %s against the current state of the peer, without submitting a transaction,
+and show the keys it would change, with their values before and after, the result and the event it would emit.`, chainFuncName),
+	ValidArgs: []string{"1"},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return chaincodeSimulate(cmd, args)
+	},
+}
+
+var chaincodeResetCmd = &cobra.Command{
+	Use:   "reset",
+	Short: fmt.Sprintf("Enables the specified %s again on the local peer after repeated failed invokes.", chainFuncName),
+	Long: fmt.Sprintf(`Resets the breaker of the specified %s on the local peer, which rejects the invokes of a %s whose last
+chaincode.breaker.threshold invokes failed, until it is reset or deployed again.`, chainFuncName, chainFuncName),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return chaincodeReset()
+	},
+}
+
 func main() {
 	// For environment variables.
 	viper.SetEnvPrefix(cmdRoot)
@@ -277,6 +557,15 @@ func main() {
 	nodeStopCmd.Flags().StringVarP(&stopPidFile, "stop-peer-pid-file", "", viper.GetString("peer.fileSystemPath"), "Location of peer pid local file, for forces kill")
 	nodeCmd.AddCommand(nodeStopCmd)
 
+	nodeProfileCmd.Flags().StringVarP(&profileType, "type", "t", "heap", "Type of the profile, heap or goroutine")
+	nodeCmd.AddCommand(nodeProfileCmd)
+	nodeCmd.AddCommand(nodePauseCmd)
+	nodeCmd.AddCommand(nodeResumeCmd)
+	nodeCmd.AddCommand(nodeDenyCmd)
+	nodeCmd.AddCommand(nodeAllowCmd)
+	nodeCmd.AddCommand(nodeDeniedCmd)
+	nodeCmd.AddCommand(nodeSyncCmd)
+
 	mainCmd.AddCommand(nodeCmd)
 
 	// Set the flags on the login command.
@@ -287,6 +576,8 @@ func main() {
 	// vmCmd.AddCommand(vmPrimeCmd)
 	// mainCmd.AddCommand(vmCmd)
 
+	networkListCmd.Flags().StringVarP(&networkListOrg, "org", "o", undefinedParamValue, "Only list the peers announcing this organization. Peers announce the organization they are configured with, which is not checked against their enrollment certificate")
+	networkListCmd.Flags().BoolVarP(&networkListMyOrg, "my-org", "m", false, "Only list the peers announcing the organization configured in peer.organization, which is not checked against their enrollment certificate. Incompatible with --org")
 	networkCmd.AddCommand(networkListCmd)
 
 	mainCmd.AddCommand(networkCmd)
@@ -298,15 +589,59 @@ func main() {
 	chaincodeCmd.PersistentFlags().StringVarP(&chaincodeName, "name", "n", undefinedParamValue, fmt.Sprintf("Name of the chaincode returned by the deploy transaction"))
 	chaincodeCmd.PersistentFlags().StringVarP(&chaincodeUsr, "username", "u", undefinedParamValue, fmt.Sprintf("Username for chaincode operations when security is enabled"))
 
+	chaincodeDeployCmd.Flags().StringVarP(&chaincodeManifestFile, "manifest", "m", undefinedParamValue, fmt.Sprintf("Path to a JSON file declaring the typed arguments of the %s functions", chainFuncName))
+
 	chaincodeQueryCmd.Flags().BoolVarP(&chaincodeQueryRaw, "raw", "r", false, "If true, output the query value as raw bytes, otherwise format as a printable string")
 	chaincodeQueryCmd.Flags().BoolVarP(&chaincodeQueryHex, "hex", "x", false, "If true, output the query value byte array in hexadecimal. Incompatible with --raw")
+	chaincodeQueryCmd.Flags().Uint64Var(&chaincodeQueryMinHeight, "min-height", 0, "Block height the peer must have reached to answer the query")
 
 	chaincodeCmd.AddCommand(chaincodeDeployCmd)
 	chaincodeCmd.AddCommand(chaincodeInvokeCmd)
 	chaincodeCmd.AddCommand(chaincodeQueryCmd)
+	chaincodeSimulateCmd.Flags().BoolVar(&chaincodeSimulateColor, "color", terminal.IsTerminal(int(os.Stdout.Fd())), "Color the changed values. Defaults to true if the output is a terminal")
+	chaincodeCmd.AddCommand(chaincodeSimulateCmd)
+	chaincodeCmd.AddCommand(chaincodeResetCmd)
 
 	mainCmd.AddCommand(chaincodeCmd)
 
+	ledgerCmd.AddCommand(ledgerUpgradeCmd)
+	ledgerCmd.AddCommand(ledgerCompactCmd)
+	ledgerCmd.AddCommand(ledgerRestoreCmd)
+	ledgerCmd.AddCommand(ledgerStatsCmd)
+
+	mainCmd.AddCommand(ledgerCmd)
+
+	consensusCmd.AddCommand(consensusStatusCmd)
+
+	consensusTraceCmd.Flags().StringVarP(&traceTypes, "type", "t", undefinedParamValue, "Comma separated message types to trace, e.g. pre_prepare,commit. Defaults to all types")
+	consensusTraceCmd.Flags().Int64VarP(&traceView, "view", "v", -1, "Only trace the messages of this view")
+	consensusTraceCmd.Flags().Int64VarP(&traceSeqNo, "seqNo", "s", -1, "Only trace the messages of this sequence number")
+	consensusCmd.AddCommand(consensusTraceCmd)
+	consensusCmd.AddCommand(consensusReplayCmd)
+	consensusCmd.AddCommand(consensusReconfigureCmd)
+	consensusCompareCmd.Flags().StringVarP(&comparePlugins, "plugins", "p", undefinedParamValue, "Comma separated plugins to compare. Defaults to all registered plugins")
+	consensusCompareCmd.Flags().IntVarP(&compareValidators, "validators", "n", 4, "Number of validators of each network, which must match the configuration of the plugins")
+	consensusCompareCmd.Flags().IntVar(&compareTransactions, "transactions", 100, "Number of transactions of the synthetic workload")
+	consensusCompareCmd.Flags().DurationVar(&compareInterval, "interval", 10*time.Millisecond, "Time between the transactions of the synthetic workload")
+	consensusCompareCmd.Flags().Float64Var(&compareSpeedup, "speedup", 1, "Factor the recorded pace of the trace is sped up by")
+	consensusCompareCmd.Flags().DurationVar(&compareTimeout, "timeout", 30*time.Second, "Time to wait for the transactions to commit after the last one is submitted")
+	consensusCmd.AddCommand(consensusCompareCmd)
+
+	mainCmd.AddCommand(consensusCmd)
+
+	blockchainExportCmd.Flags().StringVarP(&archiveFrom, "from", "f", undefinedParamValue, "Start of the time range, inclusive, as an RFC 3339 time or a date (2006-01-02)")
+	blockchainExportCmd.Flags().StringVarP(&archiveTo, "to", "t", undefinedParamValue, "End of the time range, exclusive, as an RFC 3339 time or a date (2006-01-02). Defaults to now")
+	blockchainExportCmd.Flags().StringVarP(&archiveFile, "output", "o", undefinedParamValue, "Path of the archive to write")
+	blockchainVerifyCmd.Flags().StringVarP(&archiveAnchor, "anchor", "a", undefinedParamValue, "Published chain-head block hash, in hex, the archive must link to")
+
+	blockchainInspectCmd.Flags().StringVarP(&inspectTxUUID, "transaction", "x", undefinedParamValue, "UUID of the transaction to print instead of a block")
+
+	blockchainCmd.AddCommand(blockchainExportCmd)
+	blockchainCmd.AddCommand(blockchainVerifyCmd)
+	blockchainCmd.AddCommand(blockchainInspectCmd)
+
+	mainCmd.AddCommand(blockchainCmd)
+
 	runtime.GOMAXPROCS(viper.GetInt("peer.gomaxprocs"))
 
 	// Init the crypto layer
@@ -345,6 +680,19 @@ func createEventHubServer() (net.Listener, *grpc.Server, error) {
 		grpcServer = grpc.NewServer(opts...)
 		ehServer := producer.NewEventsServer(uint(viper.GetInt("peer.validator.events.buffersize")), viper.GetInt("peer.validator.events.timeout"))
 		pb.RegisterEventsServer(grpcServer, ehServer)
+		consensus.SubscribeLifecycle(producer.ConsensusSubscriber{})
+
+		lgr, err := ledger.GetLedger()
+		if err != nil {
+			return nil, nil, fmt.Errorf("Failed to get the ledger: %s", err)
+		}
+		if err = ehServer.EnableSubscriptions(filepath.Join(viper.GetString("peer.fileSystemPath"), "events", "subscriptions.json"), lgr); err != nil {
+			return nil, nil, err
+		}
+
+		// The events server is ready as soon as the producer is created
+		ehHealth := health.Register(grpcServer, "protos.Events")
+		ehHealth.SetServingStatus("protos.Events", healthpb.HealthCheckResponse_SERVING)
 	}
 	return lis, grpcServer, err
 }
@@ -396,191 +744,930 @@ func serve(args []string) error {
 		logger.Info("Disable loading validity system chaincode")
 
 		viper.Set("peer.validator.enabled", "true")
-		viper.Set("peer.validator.consensus", "noops")
+		viper.Set("peer.validator.consensus.plugin", "noops")
 		viper.Set("chaincode.mode", chaincode.DevModeUserRunsChaincode)
 
 	}
 
+	// Each phase of the start is timed, see core/startup
+	endPhase := startup.Phase("config")
 	if err := peer.CacheConfiguration(); err != nil {
 		return err
 	}
+	// An unknown consensus plugin or a bad plugin configuration stops the
+	// peer here, rather than once it joined the network
+	if peer.ValidatorEnabled() {
+		if err := controller.CheckConsenter(); err != nil {
+			return err
+		}
+	}
 
 	peerEndpoint, err := peer.GetPeerEndpoint()
 	if err != nil {
 		err = fmt.Errorf("Failed to get Peer Endpoint: %s", err)
 		return err
 	}
+	endPhase()
 
 	listenAddr := viper.GetString("peer.listenAddress")
 
-	if "" == listenAddr {
-		logger.Debug("Listen address not specified, using peer endpoint address")
-		listenAddr = peerEndpoint.Address
+	if "" == listenAddr {
+		logger.Debug("Listen address not specified, using peer endpoint address")
+		listenAddr = peerEndpoint.Address
+	}
+
+	endPhase = startup.Phase("listen")
+	lis, err := net.Listen("tcp", listenAddr)
+	if err != nil {
+		grpclog.Fatalf("Failed to listen: %v", err)
+	}
+
+	ehubLis, ehubGrpcServer, err := createEventHubServer()
+	if err != nil {
+		grpclog.Fatalf("Failed to create ehub server: %v", err)
+	}
+	endPhase()
+
+	logger.Infof("Security enabled status: %t", core.SecurityEnabled())
+	if viper.GetBool("security.privacy") {
+		if core.SecurityEnabled() {
+			logger.Infof("Privacy enabled status: true")
+		} else {
+			panic(errors.New("Privacy cannot be enabled as requested because security is disabled"))
+		}
+	} else {
+		logger.Infof("Privacy enabled status: false")
+	}
+
+	var opts []grpc.ServerOption
+	if comm.TLSEnabled() {
+		creds, err := credentials.NewServerTLSFromFile(viper.GetString("peer.tls.cert.file"), viper.GetString("peer.tls.key.file"))
+		if err != nil {
+			grpclog.Fatalf("Failed to generate credentials %v", err)
+		}
+		opts = []grpc.ServerOption{grpc.Creds(creds)}
+	}
+
+	// Clients negotiate the wire format of their connection, services spoken
+	// by clients are registered for every codec
+	codecServer := comm.NewServer(opts...)
+	grpcServer := codecServer.Default()
+
+	// Register the health service. Each service is reported as NOT_SERVING
+	// until the component behind it has been initialized
+	healthServer := health.NewServer("protos.ChaincodeSupport", "protos.Peer",
+		"protos.Admin", "protos.Devops", "protos.Openchain")
+	codecServer.Register(func(g *grpc.Server) { healthpb.RegisterHealthServer(g, healthServer) })
+
+	endPhase = startup.Phase("security")
+	secHelper, err := getSecHelper()
+	if err != nil {
+		return err
+	}
+	endPhase()
+
+	secHelperFunc := func() crypto.Peer {
+		return secHelper
+	}
+
+	endPhase = startup.Phase("chaincode_support")
+	registerChaincodeSupport(chaincode.DefaultChain, grpcServer, secHelper)
+	healthServer.SetServingStatus("protos.ChaincodeSupport", healthpb.HealthCheckResponse_SERVING)
+	endPhase()
+
+	var peerServer *peer.PeerImpl
+
+	discInstance := core.NewStaticDiscovery(viper.GetString("peer.discovery.rootnode"))
+
+	//create the peerServer....
+	if peer.ValidatorEnabled() {
+		logger.Debug("Running as validating peer - making genesis block if needed")
+		endPhase = startup.Phase("genesis")
+		makeGenesisError := genesis.MakeGenesis()
+		if makeGenesisError != nil {
+			return makeGenesisError
+		}
+		endPhase()
+		logger.Debugf("Running as validating peer - installing consensus %s", viper.GetString("peer.validator.consensus.plugin"))
+		endPhase = startup.Phase("peer")
+		peerServer, err = peer.NewPeerWithEngine(secHelperFunc, helper.GetEngine, discInstance)
+	} else if peer.ReplicaEnabled() {
+		logger.Debug("Running as read replica")
+		endPhase = startup.Phase("peer")
+		peerServer, err = peer.NewPeerWithEngine(secHelperFunc, replica.GetEngine, discInstance)
+	} else {
+		logger.Debug("Running as non-validating peer")
+		endPhase = startup.Phase("peer")
+		peerServer, err = peer.NewPeerWithHandler(secHelperFunc, peer.NewPeerHandler, discInstance)
+	}
+
+	if err != nil {
+		logger.Fatalf("Failed creating new peer with handler %v", err)
+
+		return err
+	}
+	endPhase()
+
+	// Register the Peer server
+	endPhase = startup.Phase("services")
+	//pb.RegisterPeerServer(grpcServer, openchain.NewPeer())
+	codecServer.Register(func(g *grpc.Server) { pb.RegisterPeerServer(g, peerServer) })
+	healthServer.SetServingStatus("protos.Peer", healthpb.HealthCheckResponse_SERVING)
+
+	// Register the Admin server
+	adminServer := core.NewAdminServer()
+	adminServer.SetPeerDenyList(peerServer)
+	if peer.ValidatorEnabled() {
+		adminServer.SetConsensusStatusFunc(helper.GetConsensusStatus)
+		adminServer.SetConsensusTraceFunc(helper.TraceConsensus)
+		adminServer.SetConsensusReplayFunc(helper.ReplayConsensus)
+		adminServer.SetConsensusReconfigureFunc(helper.ReconfigureConsensus)
+		adminServer.SetStateTransferFunc(helper.CatchUpConsensus, helper.StateTransferProgress)
+	}
+	codecServer.Register(func(g *grpc.Server) { pb.RegisterAdminServer(g, adminServer) })
+	healthServer.SetServingStatus("protos.Admin", healthpb.HealthCheckResponse_SERVING)
+
+	// Register Devops server
+	serverDevops := core.NewDevopsServer(peerServer)
+	codecServer.Register(func(g *grpc.Server) { pb.RegisterDevopsServer(g, serverDevops) })
+	healthServer.SetServingStatus("protos.Devops", healthpb.HealthCheckResponse_SERVING)
+
+	// Register the ServerOpenchain server
+	serverOpenchain, err := rest.NewOpenchainServerWithPeerInfo(peerServer)
+	if err != nil {
+		err = fmt.Errorf("Error creating OpenchainServer: %s", err)
+		return err
+	}
+
+	codecServer.Register(func(g *grpc.Server) { pb.RegisterOpenchainServer(g, serverOpenchain) })
+	healthServer.SetServingStatus("protos.Openchain", healthpb.HealthCheckResponse_SERVING)
+	endPhase()
+
+	// Start replicating the ledger to object storage if configured
+	if viper.GetBool("ledger.backup.enabled") {
+		endPhase = startup.Phase("ledger_backup")
+		if err := startLedgerBackup(); err != nil {
+			return err
+		}
+		endPhase()
+	}
+
+	// Create and register the REST service if configured
+	if viper.GetBool("rest.enabled") {
+		go rest.StartOpenchainRESTServer(serverOpenchain, serverDevops)
+	}
+
+	rootNodes := discInstance.GetRootNodes()
+
+	logger.Infof("Starting peer with id=%s, network id=%s, address=%s, discovery.rootnode=[%v], validator=%v",
+		peerEndpoint.ID, viper.GetString("peer.networkId"),
+		peerEndpoint.Address, rootNodes, peer.ValidatorEnabled())
+
+	// Start the grpc server. Done in a goroutine so we can deploy the
+	// genesis block if needed.
+	serve := make(chan error)
+
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		sig := <-sigs
+		fmt.Println()
+		fmt.Println(sig)
+		// Let load balancers drain the peer while it shuts down
+		healthServer.SetAllServingStatus(healthpb.HealthCheckResponse_NOT_SERVING)
+		serve <- nil
+	}()
+
+	go func() {
+		var grpcErr error
+		if grpcErr = codecServer.Serve(lis); grpcErr != nil {
+			grpcErr = fmt.Errorf("grpc server exited with error: %s", grpcErr)
+		} else {
+			logger.Info("grpc server exited")
+		}
+		serve <- grpcErr
+	}()
+
+	if err := writePid(viper.GetString("peer.fileSystemPath")+"/peer.pid", os.Getpid()); err != nil {
+		return err
+	}
+
+	//start the event hub server
+	if ehubGrpcServer != nil && ehubLis != nil {
+		go ehubGrpcServer.Serve(ehubLis)
+	}
+
+	if viper.GetBool("peer.profile.enabled") {
+		go serveProfiles()
+	}
+
+	// Capture profiles when the heap grows over the threshold, if set
+	if threshold := viper.GetInt("peer.profile.heapThreshold"); threshold > 0 {
+		go profiling.WatchHeap(uint64(threshold), viper.GetDuration("peer.profile.heapCheckInterval"), nil)
+	}
+
+	// Shed load in order as the peer runs out of resources
+	if viper.GetBool("peer.watchdog.enabled") {
+		go watchdog.Run(nil)
+	}
+
+	startup.Complete()
+
+	// Block until grpc server exits
+	return <-serve
+}
+
+// serveProfiles serves the pprof endpoints, expvar variables and metrics, requiring the
+// configured credentials, over TLS if the peer uses TLS
+func serveProfiles() {
+	address := viper.GetString("peer.profile.listenAddress")
+	username := viper.GetString("peer.profile.username")
+	password := viper.GetString("peer.profile.password")
+	if err := profiling.CheckListenAddress(address, password); err != nil {
+		logger.Errorf("Error starting profiler: %s", err)
+		return
+	}
+	logger.Infof("Starting profiling server with listenAddress = %s, authentication %s", address,
+		(map[bool]string{true: "enabled", false: "disabled"})[password != ""])
+	// Label the metrics with the organization of the peer, so that those of the
+	// peers of an organization can be told apart
+	metrics.DefaultRegistry.SetLabel("org", viper.GetString("peer.organization"))
+	handler := profiling.Handler(username, password)
+	var err error
+	if comm.TLSEnabled() {
+		err = http.ListenAndServeTLS(address, viper.GetString("peer.tls.cert.file"), viper.GetString("peer.tls.key.file"), handler)
+	} else {
+		err = http.ListenAndServe(address, handler)
+	}
+	if err != nil {
+		logger.Errorf("Error starting profiler: %s", err)
+	}
+}
+
+func ledgerUpgrade() error {
+	from, to, err := db.UpgradeDB()
+	if err != nil {
+		err = fmt.Errorf("Error upgrading ledger: %s", err)
+		logger.Error(err.Error())
+		return err
+	}
+	if from == to {
+		logger.Infof("Ledger is already at format version %d", to)
+	} else {
+		logger.Infof("Ledger upgraded from format version %d to %d", from, to)
+	}
+	return nil
+}
+
+func startLedgerBackup() error {
+	store, err := backup.NewObjectStore()
+	if err != nil {
+		return fmt.Errorf("Error configuring the ledger backup: %s", err)
+	}
+	lgr, err := ledger.GetLedger()
+	if err != nil {
+		return fmt.Errorf("Error opening the ledger: %s", err)
+	}
+	replicator, err := backup.NewReplicator(store, lgr)
+	if err != nil {
+		return fmt.Errorf("Error configuring the ledger backup: %s", err)
+	}
+	if err := replicator.Start(); err != nil {
+		return fmt.Errorf("Error starting the ledger backup: %s", err)
+	}
+	return nil
+}
+
+func ledgerRestore() error {
+	store, err := backup.NewObjectStore()
+	if err != nil {
+		return fmt.Errorf("Error configuring the ledger backup: %s", err)
+	}
+	lgr, err := ledger.GetLedger()
+	if err != nil {
+		return fmt.Errorf("Error opening the ledger: %s", err)
+	}
+	height, err := backup.Restore(store, viper.GetString("ledger.backup.prefix"), lgr)
+	if err != nil {
+		err = fmt.Errorf("Error restoring the ledger: %s", err)
+		logger.Error(err.Error())
+		return err
+	}
+	logger.Infof("Restored blocks 0 to %d from the backup", height-1)
+	return nil
+}
+
+func ledgerStats() (err error) {
+	clientConn, err := peer.NewPeerClientConnection()
+	if err != nil {
+		logger.Infof("Error trying to connect to local peer: %s", err)
+		return fmt.Errorf("Error trying to connect to local peer: %s", err)
+	}
+
+	serverClient := pb.NewAdminClient(clientConn)
+
+	stats, err := serverClient.GetLedgerStatistics(context.Background(), &google_protobuf.Empty{})
+	if err != nil {
+		return fmt.Errorf("Error trying to get the ledger statistics of the local peer: %s", err)
+	}
+
+	fmt.Printf("Blocks: %d, %d bytes\n", stats.Blocks, stats.BlockBytes)
+	for i, count := range stats.BlockSizeCounts {
+		if i < len(stats.BlockSizeBounds) {
+			fmt.Printf("  < %-10d bytes: %d\n", stats.BlockSizeBounds[i], count)
+		} else if i > 0 {
+			fmt.Printf("  >= %-9d bytes: %d\n", stats.BlockSizeBounds[i-1], count)
+		}
+	}
+	fmt.Printf("State: %d keys, %d bytes\n", stats.Keys, stats.Bytes)
+	for _, cc := range stats.Chaincodes {
+		fmt.Printf("  %s: %d keys, %d bytes\n", cc.ChaincodeID, cc.Keys, cc.Bytes)
+	}
+	return nil
+}
+
+func ledgerCompact() (err error) {
+	clientConn, err := peer.NewPeerClientConnection()
+	if err != nil {
+		logger.Infof("Error trying to connect to local peer: %s", err)
+		return fmt.Errorf("Error trying to connect to local peer: %s", err)
+	}
+
+	serverClient := pb.NewAdminClient(clientConn)
+
+	stream, err := serverClient.CompactLedger(context.Background(), &google_protobuf.Empty{})
+	if err != nil {
+		return fmt.Errorf("Error trying to compact the ledger of the local peer: %s", err)
+	}
+	for {
+		progress, err := stream.Recv()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("Error compacting the ledger of the local peer: %s", err)
+		}
+		if progress.ColumnFamily == "" {
+			fmt.Println("Compaction started")
+			continue
+		}
+		fmt.Printf("Compacted %s (%d/%d) after %s\n", progress.ColumnFamily, progress.Done, progress.Total,
+			time.Duration(progress.ElapsedMs)*time.Millisecond)
+	}
+}
+
+func status() (err error) {
+	clientConn, err := peer.NewPeerClientConnection()
+	if err != nil {
+		logger.Infof("Error trying to connect to local peer: %s", err)
+		err = fmt.Errorf("Error trying to connect to local peer: %s", err)
+		fmt.Println(&pb.ServerStatus{Status: pb.ServerStatus_UNKNOWN})
+		return err
+	}
+
+	serverClient := pb.NewAdminClient(clientConn)
+
+	status, err := serverClient.GetStatus(context.Background(), &google_protobuf.Empty{})
+	if err != nil {
+		logger.Infof("Error trying to get status from local peer: %s", err)
+		err = fmt.Errorf("Error trying to connect to local peer: %s", err)
+		fmt.Println(&pb.ServerStatus{Status: pb.ServerStatus_UNKNOWN})
+		return err
+	}
+	fmt.Println(status)
+	if status.StateTransfer != nil {
+		printStateTransferProgress(status.StateTransfer)
+	}
+	return nil
+}
+
+func nodeProfile() (err error) {
+	typ, ok := pb.ProfileRequest_Type_value[strings.ToUpper(profileType)]
+	if !ok {
+		return fmt.Errorf("Unknown profile type %s, expected heap or goroutine", profileType)
+	}
+
+	clientConn, err := peer.NewPeerClientConnection()
+	if err != nil {
+		logger.Infof("Error trying to connect to local peer: %s", err)
+		return fmt.Errorf("Error trying to connect to local peer: %s", err)
+	}
+
+	serverClient := pb.NewAdminClient(clientConn)
+
+	snapshot, err := serverClient.CaptureProfile(context.Background(), &pb.ProfileRequest{Type: pb.ProfileRequest_Type(typ)})
+	if err != nil {
+		return fmt.Errorf("Error trying to capture a profile of the local peer: %s", err)
+	}
+	fmt.Printf("Captured %s profile to %s on the peer, %d bytes\n", strings.ToLower(snapshot.Type.String()), snapshot.Path, snapshot.Size)
+	return nil
+}
+
+func nodeSubsystem(args []string, pause bool) (err error) {
+	if len(args) != 1 {
+		return fmt.Errorf("Expected a subsystem, one of eventhub, blocksync or chaincode")
+	}
+
+	clientConn, err := peer.NewPeerClientConnection()
+	if err != nil {
+		logger.Infof("Error trying to connect to local peer: %s", err)
+		return fmt.Errorf("Error trying to connect to local peer: %s", err)
+	}
+
+	serverClient := pb.NewAdminClient(clientConn)
+
+	var paused *pb.PausedSubsystems
+	if pause {
+		paused, err = serverClient.PauseSubsystem(context.Background(), &pb.Subsystem{Name: args[0]})
+	} else {
+		paused, err = serverClient.ResumeSubsystem(context.Background(), &pb.Subsystem{Name: args[0]})
+	}
+	if err != nil {
+		return fmt.Errorf("Error trying to change subsystem %s of the local peer: %s", args[0], err)
+	}
+	if len(paused.Names) == 0 {
+		fmt.Println("No subsystem paused")
+		return nil
+	}
+	fmt.Printf("Subsystems paused: %s\n", strings.Join(paused.Names, ", "))
+	return nil
+}
+
+func nodeDeny(args []string) (err error) {
+	if len(args) < 1 || len(args) > 2 {
+		return fmt.Errorf("Expected the peer.id of the peer to deny, and optionally a reason")
+	}
+	req := &pb.DeniedPeer{Name: args[0]}
+	if len(args) == 2 {
+		req.Reason = args[1]
+	}
+	serverClient, err := newAdminClient()
+	if err != nil {
+		return err
+	}
+	denied, err := serverClient.DenyPeer(context.Background(), req)
+	if err != nil {
+		return fmt.Errorf("Error trying to deny peer %s: %s", args[0], err)
+	}
+	printDeniedPeers(denied)
+	return nil
+}
+
+func nodeAllow(args []string) (err error) {
+	if len(args) != 1 {
+		return fmt.Errorf("Expected the peer.id of the peer to allow")
+	}
+	serverClient, err := newAdminClient()
+	if err != nil {
+		return err
+	}
+	denied, err := serverClient.AllowPeer(context.Background(), &pb.DeniedPeer{Name: args[0]})
+	if err != nil {
+		return fmt.Errorf("Error trying to allow peer %s: %s", args[0], err)
+	}
+	printDeniedPeers(denied)
+	return nil
+}
+
+func nodeDenied() (err error) {
+	serverClient, err := newAdminClient()
+	if err != nil {
+		return err
+	}
+	denied, err := serverClient.GetDeniedPeers(context.Background(), &google_protobuf.Empty{})
+	if err != nil {
+		return fmt.Errorf("Error trying to get the denied peers: %s", err)
+	}
+	printDeniedPeers(denied)
+	return nil
+}
+
+// newAdminClient connects to the Admin service of the local peer
+func newAdminClient() (pb.AdminClient, error) {
+	clientConn, err := peer.NewPeerClientConnection()
+	if err != nil {
+		logger.Infof("Error trying to connect to local peer: %s", err)
+		return nil, fmt.Errorf("Error trying to connect to local peer: %s", err)
+	}
+	return pb.NewAdminClient(clientConn), nil
+}
+
+func nodeSync(args []string) (err error) {
+	if len(args) > 1 {
+		return fmt.Errorf("Expected at most the peer.id of the peer to fetch the state from")
+	}
+	req := &pb.StateTransferRequest{}
+	if len(args) == 1 {
+		req.Peer = args[0]
+	}
+	serverClient, err := newAdminClient()
+	if err != nil {
+		return err
+	}
+	stream, err := serverClient.SyncState(context.Background(), req)
+	if err != nil {
+		return fmt.Errorf("Error trying to start state transfer on the local peer: %s", err)
+	}
+	for {
+		progress, err := stream.Recv()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("Error transferring state to the local peer: %s", err)
+		}
+		printStateTransferProgress(progress)
+	}
+}
+
+func printStateTransferProgress(progress *pb.StateTransferProgress) {
+	state := "completed"
+	if progress.InProgress {
+		state = "in progress"
+	} else if progress.Error != "" {
+		state = "failed"
+	}
+	fmt.Printf("State transfer %s: block %d to %d, %d blocks and %d bytes fetched in %s",
+		state, progress.StartBlock, progress.TargetBlock, progress.BlocksFetched, progress.BytesFetched,
+		time.Duration(progress.ElapsedMs)*time.Millisecond)
+	if progress.EtaMs != 0 {
+		fmt.Printf(", %s left", time.Duration(progress.EtaMs)*time.Millisecond)
+	}
+	if progress.Error != "" {
+		fmt.Printf(", last error: %s", progress.Error)
+	}
+	fmt.Println()
+}
+
+func printDeniedPeers(denied *pb.DeniedPeers) {
+	if len(denied.Peers) == 0 {
+		fmt.Println("No peer denied")
+		return
+	}
+	for _, p := range denied.Peers {
+		since := ""
+		if p.Since != nil {
+			since = time.Unix(p.Since.Seconds, int64(p.Since.Nanos)).UTC().Format(time.RFC3339)
+		}
+		fmt.Printf("%s\tdenied since %s\t%s\n", p.Name, since, p.Reason)
+	}
+}
+
+func consensusStatus() (err error) {
+	clientConn, err := peer.NewPeerClientConnection()
+	if err != nil {
+		logger.Infof("Error trying to connect to local peer: %s", err)
+		return fmt.Errorf("Error trying to connect to local peer: %s", err)
+	}
+
+	serverClient := pb.NewAdminClient(clientConn)
+
+	status, err := serverClient.GetConsensusStatus(context.Background(), &google_protobuf.Empty{})
+	if err != nil {
+		logger.Infof("Error trying to get consensus status from local peer: %s", err)
+		return fmt.Errorf("Error trying to get consensus status from local peer: %s", err)
+	}
+
+	var out bytes.Buffer
+	if err = json.Indent(&out, []byte(status.Status), "", "  "); err != nil {
+		return fmt.Errorf("Error formatting consensus status of plugin %s: %s", status.Plugin, err)
+	}
+	fmt.Println(out.String())
+	return nil
+}
+
+func consensusTrace() (err error) {
+	req := &pb.ConsensusTraceRequest{}
+	if traceTypes != undefinedParamValue {
+		req.Types = strings.Split(traceTypes, ",")
+	}
+	if traceView >= 0 {
+		req.FilterView, req.View = true, uint64(traceView)
+	}
+	if traceSeqNo >= 0 {
+		req.FilterSeqNo, req.SeqNo = true, uint64(traceSeqNo)
+	}
+
+	clientConn, err := peer.NewPeerClientConnection()
+	if err != nil {
+		logger.Infof("Error trying to connect to local peer: %s", err)
+		return fmt.Errorf("Error trying to connect to local peer: %s", err)
+	}
+
+	serverClient := pb.NewAdminClient(clientConn)
+
+	stream, err := serverClient.TraceConsensus(context.Background(), req)
+	if err != nil {
+		return fmt.Errorf("Error trying to trace consensus messages of local peer: %s", err)
+	}
+	for {
+		ev, err := stream.Recv()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("Error tracing consensus messages of local peer: %s", err)
+		}
+		if ev.Dropped > 0 {
+			fmt.Printf("... %d messages dropped\n", ev.Dropped)
+		}
+		peerDesc := "to all"
+		if !ev.Sent {
+			peerDesc = fmt.Sprintf("from %d", ev.Replica)
+		} else if ev.Replica >= 0 {
+			peerDesc = fmt.Sprintf("to %d", ev.Replica)
+		}
+		position := ""
+		if ev.HasView {
+			position += fmt.Sprintf(" view=%d", ev.View)
+		}
+		if ev.HasSeqNo {
+			position += fmt.Sprintf(" seqNo=%d", ev.SeqNo)
+		}
+		timestamp := time.Unix(ev.Timestamp.Seconds, int64(ev.Timestamp.Nanos))
+		fmt.Printf("%s %-7s %s%s %s\n", timestamp.Format("15:04:05.000"), peerDesc, ev.Type, position, ev.Message)
 	}
+}
 
-	lis, err := net.Listen("tcp", listenAddr)
+func consensusReplay(args []string) (err error) {
+	if len(args) != 1 {
+		return errors.New("Must supply the rejects directory or file to replay")
+	}
+	rejected, err := obcpbft.ReadRejectedMessages(args[0])
 	if err != nil {
-		grpclog.Fatalf("Failed to listen: %v", err)
+		return fmt.Errorf("Error reading rejected messages: %s", err)
 	}
 
-	ehubLis, ehubGrpcServer, err := createEventHubServer()
+	clientConn, err := peer.NewPeerClientConnection()
 	if err != nil {
-		grpclog.Fatalf("Failed to create ehub server: %v", err)
+		logger.Infof("Error trying to connect to local peer: %s", err)
+		return fmt.Errorf("Error trying to connect to local peer: %s", err)
 	}
 
-	logger.Infof("Security enabled status: %t", core.SecurityEnabled())
-	if viper.GetBool("security.privacy") {
-		if core.SecurityEnabled() {
-			logger.Infof("Privacy enabled status: true")
-		} else {
-			panic(errors.New("Privacy cannot be enabled as requested because security is disabled"))
+	serverClient := pb.NewAdminClient(clientConn)
+
+	for _, msg := range rejected {
+		result, err := serverClient.ReplayConsensus(context.Background(), &pb.ConsensusReplayRequest{Payload: msg.Payload, Sender: msg.Sender})
+		if err != nil {
+			return fmt.Errorf("Error trying to replay consensus message to local peer: %s", err)
 		}
-	} else {
-		logger.Infof("Privacy enabled status: false")
+		outcome := "accepted"
+		if !result.Accepted {
+			outcome = "rejected: " + result.Reason
+		}
+		fmt.Printf("%s from %s (%d bytes), first rejected with: %s\n    %s\n", msg.Time.Format(time.RFC3339), msg.Sender, len(msg.Payload), msg.Reason, outcome)
 	}
+	return nil
+}
 
-	var opts []grpc.ServerOption
-	if comm.TLSEnabled() {
-		creds, err := credentials.NewServerTLSFromFile(viper.GetString("peer.tls.cert.file"), viper.GetString("peer.tls.key.file"))
+func consensusCompare(args []string) (err error) {
+	if len(args) > 1 {
+		return errors.New("Must supply at most one message trace")
+	}
+	var workload *compare.Workload
+	if len(args) == 1 {
+		trace, err := os.Open(args[0])
 		if err != nil {
-			grpclog.Fatalf("Failed to generate credentials %v", err)
+			return fmt.Errorf("Error opening message trace: %s", err)
 		}
-		opts = []grpc.ServerOption{grpc.Creds(creds)}
+		defer trace.Close()
+		if workload, err = compare.ReadWorkload(trace); err != nil {
+			return fmt.Errorf("Error reading message trace: %s", err)
+		}
+	} else if workload, err = compare.SyntheticWorkload(compareTransactions, compareInterval); err != nil {
+		return err
 	}
 
-	grpcServer := grpc.NewServer(opts...)
+	plugins := consensus.PluginNames()
+	if comparePlugins != undefinedParamValue {
+		plugins = strings.Split(comparePlugins, ",")
+	}
+	reports := compare.Compare(plugins, workload, compare.Options{
+		Validators: compareValidators,
+		Speedup:    compareSpeedup,
+		Timeout:    compareTimeout,
+	})
+	return compare.WriteReports(os.Stdout, reports)
+}
 
-	secHelper, err := getSecHelper()
+func consensusReconfigure(args []string) (err error) {
+	if len(args) != 2 {
+		return errors.New("Must supply the number of validators N and of faults tolerated f")
+	}
+	N, err := strconv.ParseUint(args[0], 10, 32)
 	if err != nil {
-		return err
+		return fmt.Errorf("Invalid number of validators %s: %s", args[0], err)
+	}
+	f, err := strconv.ParseUint(args[1], 10, 32)
+	if err != nil {
+		return fmt.Errorf("Invalid number of faults %s: %s", args[1], err)
 	}
 
-	secHelperFunc := func() crypto.Peer {
-		return secHelper
+	clientConn, err := peer.NewPeerClientConnection()
+	if err != nil {
+		logger.Infof("Error trying to connect to local peer: %s", err)
+		return fmt.Errorf("Error trying to connect to local peer: %s", err)
 	}
 
-	registerChaincodeSupport(chaincode.DefaultChain, grpcServer, secHelper)
+	serverClient := pb.NewAdminClient(clientConn)
 
-	var peerServer *peer.PeerImpl
+	if _, err = serverClient.ReconfigureConsensus(context.Background(), &pb.ConsensusReconfiguration{N: uint32(N), F: uint32(f)}); err != nil {
+		return fmt.Errorf("Error trying to reconfigure the validating network: %s", err)
+	}
+	fmt.Printf("Requested the reconfiguration of the validating network to N=%d, f=%d\n", N, f)
+	return nil
+}
 
-	discInstance := core.NewStaticDiscovery(viper.GetString("peer.discovery.rootnode"))
+// parseArchiveTime parses a bound of the time range of an archive
+func parseArchiveTime(value string, defaultTime time.Time) (time.Time, error) {
+	if value == undefinedParamValue {
+		return defaultTime, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	return time.Parse("2006-01-02", value)
+}
 
-	//create the peerServer....
-	if peer.ValidatorEnabled() {
-		logger.Debug("Running as validating peer - making genesis block if needed")
-		makeGenesisError := genesis.MakeGenesis()
-		if makeGenesisError != nil {
-			return makeGenesisError
+func blockchainExport() (err error) {
+	if archiveFile == undefinedParamValue {
+		return errors.New("Must supply the path of the archive to write")
+	}
+	from, err := parseArchiveTime(archiveFrom, time.Unix(0, 0))
+	if err != nil {
+		return fmt.Errorf("Invalid start of the time range: %s", err)
+	}
+	to, err := parseArchiveTime(archiveTo, time.Now())
+	if err != nil {
+		return fmt.Errorf("Invalid end of the time range: %s", err)
+	}
+	if !from.Before(to) {
+		return fmt.Errorf("The time range [%s, %s) is empty", from, to)
+	}
+
+	lgr, err := ledger.GetLedger()
+	if err != nil {
+		return fmt.Errorf("Error opening the ledger: %s", err)
+	}
+	var secHelper crypto.Peer
+	var signer []byte
+	if core.SecurityEnabled() {
+		if secHelper, err = getSecHelper(); err != nil {
+			return fmt.Errorf("Error initializing the security layer: %s", err)
 		}
-		logger.Debugf("Running as validating peer - installing consensus %s", viper.GetString("peer.validator.consensus"))
-		peerServer, err = peer.NewPeerWithEngine(secHelperFunc, helper.GetEngine, discInstance)
+		signer = secHelper.GetID()
 	} else {
-		logger.Debug("Running as non-validating peer")
-		peerServer, err = peer.NewPeerWithHandler(secHelperFunc, peer.NewPeerHandler, discInstance)
+		logger.Warning("Security is disabled, the archive is not signed")
 	}
 
+	file, err := os.Create(archiveFile)
 	if err != nil {
-		logger.Fatalf("Failed creating new peer with handler %v", err)
-
-		return err
+		return fmt.Errorf("Error creating the archive: %s", err)
 	}
+	defer func() {
+		if closeErr := file.Close(); err == nil && closeErr != nil {
+			err = fmt.Errorf("Error writing the archive: %s", closeErr)
+		}
+		if err != nil {
+			os.Remove(archiveFile)
+		}
+	}()
 
-	// Register the Peer server
-	//pb.RegisterPeerServer(grpcServer, openchain.NewPeer())
-	pb.RegisterPeerServer(grpcServer, peerServer)
+	// The blocks are written as they are read, so that the archive of a long
+	// chain does not have to fit in memory
+	out := bufio.NewWriter(file)
+	export, err := lgr.ExportArchive(from, to, signer, out)
+	if err == ledger.ErrResourceNotFound {
+		return fmt.Errorf("No transaction within [%s, %s)", from, to)
+	} else if err != nil {
+		return fmt.Errorf("Error exporting the transactions within [%s, %s): %s", from, to, err)
+	}
+	if secHelper != nil {
+		signature, err := secHelper.Sign(export.Digest)
+		if err != nil {
+			return fmt.Errorf("Error signing the archive: %s", err)
+		}
+		if err = ledger.WriteArchiveSignature(out, signature); err != nil {
+			return fmt.Errorf("Error writing the archive: %s", err)
+		}
+	}
+	if err = out.Flush(); err != nil {
+		return fmt.Errorf("Error writing the archive: %s", err)
+	}
 
-	// Register the Admin server
-	pb.RegisterAdminServer(grpcServer, core.NewAdminServer())
+	fmt.Printf("Exported blocks %d to %d to %s, chain-head anchor is %x\n",
+		export.FirstBlock, export.LastBlock, archiveFile, export.Anchor)
+	return nil
+}
 
-	// Register Devops server
-	serverDevops := core.NewDevopsServer(peerServer)
-	pb.RegisterDevopsServer(grpcServer, serverDevops)
+func blockchainVerify(args []string) (err error) {
+	if len(args) != 1 {
+		return errors.New("Must supply the path of the archive to verify")
+	}
+	anchor, err := hex.DecodeString(archiveAnchor)
+	if err != nil || len(anchor) == 0 {
+		return errors.New("Must supply the chain-head anchor as a hex block hash")
+	}
 
-	// Register the ServerOpenchain server
-	serverOpenchain, err := rest.NewOpenchainServerWithPeerInfo(peerServer)
+	archiveBytes, err := ioutil.ReadFile(args[0])
 	if err != nil {
-		err = fmt.Errorf("Error creating OpenchainServer: %s", err)
-		return err
+		return fmt.Errorf("Error reading the archive: %s", err)
 	}
-
-	pb.RegisterOpenchainServer(grpcServer, serverOpenchain)
-
-	// Create and register the REST service if configured
-	if viper.GetBool("rest.enabled") {
-		go rest.StartOpenchainRESTServer(serverOpenchain, serverDevops)
+	archive := &pb.ChainArchive{}
+	if err = proto.Unmarshal(archiveBytes, archive); err != nil {
+		return fmt.Errorf("Error unmarshalling the archive: %s", err)
 	}
 
-	rootNodes := discInstance.GetRootNodes()
-
-	logger.Infof("Starting peer with id=%s, network id=%s, address=%s, discovery.rootnode=[%v], validator=%v",
-		peerEndpoint.ID, viper.GetString("peer.networkId"),
-		peerEndpoint.Address, rootNodes, peer.ValidatorEnabled())
-
-	// Start the grpc server. Done in a goroutine so we can deploy the
-	// genesis block if needed.
-	serve := make(chan error)
-
-	sigs := make(chan os.Signal, 1)
-	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
-	go func() {
-		sig := <-sigs
-		fmt.Println()
-		fmt.Println(sig)
-		serve <- nil
-	}()
+	summary, err := ledger.VerifyArchive(archive, anchor)
+	if err != nil {
+		return fmt.Errorf("Invalid archive: %s", err)
+	}
 
-	go func() {
-		var grpcErr error
-		if grpcErr = grpcServer.Serve(lis); grpcErr != nil {
-			grpcErr = fmt.Errorf("grpc server exited with error: %s", grpcErr)
-		} else {
-			logger.Info("grpc server exited")
+	switch {
+	case len(archive.Signature) == 0:
+		logger.Warning("The archive is not signed")
+	case !core.SecurityEnabled():
+		logger.Warning("Security is disabled, the signature of the archive is not checked")
+	default:
+		secHelper, err := getSecHelper()
+		if err != nil {
+			return fmt.Errorf("Error initializing the security layer: %s", err)
 		}
-		serve <- grpcErr
-	}()
+		digest, err := ledger.ArchiveDigest(archive)
+		if err != nil {
+			return err
+		}
+		if err = secHelper.Verify(archive.Signer, archive.Signature, digest); err != nil {
+			return fmt.Errorf("Invalid archive signature: %s", err)
+		}
+	}
 
-	if err := writePid(viper.GetString("peer.fileSystemPath")+"/peer.pid", os.Getpid()); err != nil {
-		return err
+	fmt.Printf("Archive verified, %d transactions in blocks %d to %d, anchored at block %d\n",
+		len(summary.Transactions), summary.FirstBlock, summary.LastBlock, summary.AnchorBlock)
+	for _, tx := range summary.Transactions {
+		fmt.Printf("%s\t%s\t%s\n", tx.Uuid, time.Unix(tx.Timestamp.Seconds, int64(tx.Timestamp.Nanos)).UTC().Format(time.RFC3339Nano), tx.Type)
 	}
+	return nil
+}
 
-	//start the event hub server
-	if ehubGrpcServer != nil && ehubLis != nil {
-		go ehubGrpcServer.Serve(ehubLis)
+func blockchainInspect(args []string) (err error) {
+	if len(args) > 1 || (len(args) == 1 && inspectTxUUID != undefinedParamValue) {
+		return errors.New("Must supply either a block number or a transaction UUID")
+	}
+	lgr, err := ledger.GetLedger()
+	if err != nil {
+		return fmt.Errorf("Error opening the ledger: %s", err)
 	}
 
-	if viper.GetBool("peer.profile.enabled") {
-		go func() {
-			profileListenAddress := viper.GetString("peer.profile.listenAddress")
-			logger.Infof("Starting profiling server with listenAddress = %s", profileListenAddress)
-			if profileErr := http.ListenAndServe(profileListenAddress, nil); profileErr != nil {
-				logger.Errorf("Error starting profiler: %s", profileErr)
+	var rendered map[string]interface{}
+	if inspectTxUUID != undefinedParamValue {
+		tx, err := lgr.GetTransactionByUUID(inspectTxUUID)
+		if err == ledger.ErrResourceNotFound {
+			return fmt.Errorf("Transaction %s not found", inspectTxUUID)
+		} else if err != nil {
+			return fmt.Errorf("Error retrieving transaction %s: %s", inspectTxUUID, err)
+		}
+		rendered = inspect.Transaction(tx)
+		if number, err := lgr.GetTransactionBlockNumberByUUID(inspectTxUUID); err == nil {
+			rendered["blockNumber"] = number
+		}
+	} else {
+		size := lgr.GetBlockchainSize()
+		if size == 0 {
+			return errors.New("The chain holds no block")
+		}
+		number := size - 1
+		if len(args) == 1 {
+			if number, err = strconv.ParseUint(args[0], 10, 64); err != nil {
+				return fmt.Errorf("Invalid block number %s", args[0])
 			}
-		}()
+		}
+		block, err := lgr.GetBlockByNumber(number)
+		if err == ledger.ErrOutOfBounds {
+			return fmt.Errorf("Block %d not found, the chain holds %d blocks", number, size)
+		} else if err != nil {
+			return fmt.Errorf("Error retrieving block %d: %s", number, err)
+		}
+		hash, err := block.GetHash()
+		if err != nil {
+			return err
+		}
+		delta, err := lgr.GetStateDelta(number)
+		if err != nil {
+			return fmt.Errorf("Error retrieving the state delta of block %d: %s", number, err)
+		}
+		rendered = inspect.NumberedBlock(number, block, hash, delta)
 	}
 
-	// Block until grpc server exits
-	return <-serve
-}
-
-func status() (err error) {
-	clientConn, err := peer.NewPeerClientConnection()
+	raw, err := inspect.Marshal(rendered)
 	if err != nil {
-		logger.Infof("Error trying to connect to local peer: %s", err)
-		err = fmt.Errorf("Error trying to connect to local peer: %s", err)
-		fmt.Println(&pb.ServerStatus{Status: pb.ServerStatus_UNKNOWN})
 		return err
 	}
-
-	serverClient := pb.NewAdminClient(clientConn)
-
-	status, err := serverClient.GetStatus(context.Background(), &google_protobuf.Empty{})
-	if err != nil {
-		logger.Infof("Error trying to get status from local peer: %s", err)
-		err = fmt.Errorf("Error trying to connect to local peer: %s", err)
-		fmt.Println(&pb.ServerStatus{Status: pb.ServerStatus_UNKNOWN})
+	var out bytes.Buffer
+	if err = json.Indent(&out, raw, "", "  "); err != nil {
 		return err
 	}
-	fmt.Println(status)
+	fmt.Println(out.String())
 	return nil
 }
 
@@ -823,9 +1910,24 @@ func chaincodeDeploy(cmd *cobra.Command, args []string) (err error) {
 		return
 	}
 
+	var manifest *pb.ChaincodeManifest
+	if chaincodeManifestFile != undefinedParamValue {
+		manifestJSON, ferr := ioutil.ReadFile(chaincodeManifestFile)
+		if ferr != nil {
+			err = fmt.Errorf("Error reading chaincode manifest: %s", ferr)
+			return
+		}
+		manifest = &pb.ChaincodeManifest{}
+		if err = json.Unmarshal(manifestJSON, manifest); err != nil {
+			err = fmt.Errorf("Chaincode manifest error: %s", err)
+			return
+		}
+	}
+
 	chaincodeLang = strings.ToUpper(chaincodeLang)
 	spec := &pb.ChaincodeSpec{Type: pb.ChaincodeSpec_Type(pb.ChaincodeSpec_Type_value[chaincodeLang]),
-		ChaincodeID: &pb.ChaincodeID{Path: chaincodePath, Name: chaincodeName}, CtorMsg: input, Attributes: attributes}
+		ChaincodeID: &pb.ChaincodeID{Path: chaincodePath, Name: chaincodeName}, CtorMsg: input, Attributes: attributes,
+		Manifest: manifest}
 
 	// If security is enabled, add client login token
 	if core.SecurityEnabled() {
@@ -893,14 +1995,97 @@ func chaincodeQuery(cmd *cobra.Command, args []string) error {
 	return chaincodeInvokeOrQuery(cmd, args, false)
 }
 
-// chaincodeInvokeOrQuery invokes or queries the chaincode. If successful, the
-// INVOKE form prints the transaction ID on STDOUT, and the QUERY form prints
-// the query result on STDOUT. A command-line flag (-r, --raw) determines
-// whether the query result is output as raw bytes, or as a printable string.
-// The printable form is optionally (-x, --hex) a hexadecimal representation
-// of the query response. If the query response is NIL, nothing is output.
-func chaincodeInvokeOrQuery(cmd *cobra.Command, args []string, invoke bool) (err error) {
+// chaincodeSimulate runs the invocation against the state of the peer, without
+// submitting a transaction, and outputs the changes it would make to the state
+func chaincodeSimulate(cmd *cobra.Command, args []string) error {
+	devopsClient, invocation, err := chaincodeInvocation(cmd, true)
+	if err != nil {
+		return err
+	}
+	result, err := devopsClient.Simulate(context.Background(), invocation)
+	if err != nil {
+		return fmt.Errorf("Error simulating %s: %s\n", chainFuncName, err)
+	}
+	logger.Infof("Successfully simulated transaction: %s", invocation)
+	printSimulation(os.Stdout, result, chaincodeSimulateColor)
+	return nil
+}
+
+func chaincodeReset() error {
+	if chaincodeName == undefinedParamValue {
+		return fmt.Errorf("Must supply the name of the %s to reset", chainFuncName)
+	}
+	serverClient, err := newAdminClient()
+	if err != nil {
+		return err
+	}
+	breaker, err := serverClient.ResetChaincodeBreaker(context.Background(), &pb.ChaincodeID{Name: chaincodeName})
+	if err != nil {
+		return fmt.Errorf("Error trying to reset %s %s: %s", chainFuncName, chaincodeName, err)
+	}
+	if breaker.Threshold == 0 {
+		fmt.Printf("The %s breaker is disabled on the local peer\n", chainFuncName)
+		return nil
+	}
+	fmt.Printf("%s %s enabled, it is disabled again after %d consecutive failed invokes\n", chainFuncName, chaincodeName, breaker.Threshold)
+	return nil
+}
+
+const (
+	colorRed   = "\x1b[31m"
+	colorGreen = "\x1b[32m"
+	colorReset = "\x1b[0m"
+)
+
+// printSimulation outputs the changes of a simulation as a diff of the values
+// of each key, followed by the result and the event of the invocation
+func printSimulation(w io.Writer, result *pb.SimulationResult, color bool) {
+	line := func(sign, c string, value []byte) {
+		if color {
+			fmt.Fprintf(w, "%s%s %s%s\n", c, sign, printableValue(value), colorReset)
+		} else {
+			fmt.Fprintf(w, "%s %s\n", sign, printableValue(value))
+		}
+	}
+	if len(result.Changes) == 0 {
+		fmt.Fprintln(w, "No changes")
+	}
+	for _, change := range result.Changes {
+		switch {
+		case !change.Existed:
+			fmt.Fprintf(w, "%s (created)\n", change.Key)
+		case change.Deleted:
+			fmt.Fprintf(w, "%s (deleted)\n", change.Key)
+		default:
+			fmt.Fprintf(w, "%s\n", change.Key)
+		}
+		if change.Existed {
+			line("-", colorRed, change.Before)
+		}
+		if !change.Deleted {
+			line("+", colorGreen, change.After)
+		}
+	}
+	if len(result.Result) > 0 {
+		fmt.Fprintf(w, "Result: %s\n", printableValue(result.Result))
+	}
+	if event := result.GetEvent(); event != nil && event.EventName != "" {
+		fmt.Fprintf(w, "Event %s: %s\n", event.EventName, printableValue(event.Payload))
+	}
+}
+
+// printableValue returns the value as a quoted string if it is valid UTF-8,
+// and in hexadecimal otherwise
+func printableValue(value []byte) string {
+	if utf8.Valid(value) {
+		return strconv.Quote(string(value))
+	}
+	return "0x" + hex.EncodeToString(value)
+}
 
+// chaincodeInvocation builds the invocation of the chaincode the command
+// line specifies, and returns it with a client of the peer to send it to
+func chaincodeInvocation(cmd *cobra.Command, invoke bool) (devopsClient pb.DevopsClient, invocation *pb.ChaincodeInvocationSpec, err error) {
 	if err = checkChaincodeCmdParams(cmd); err != nil {
 		return
 	}
@@ -910,7 +2095,7 @@ func chaincodeInvokeOrQuery(cmd *cobra.Command, args []string, invoke bool) (err
 		return
 	}
 
-	devopsClient, err := getDevopsClient(cmd)
+	devopsClient, err = getDevopsClient(cmd)
 	if err != nil {
 		err = fmt.Errorf("Error building %s: %s", chainFuncName, err)
 		return
@@ -931,6 +2116,9 @@ func chaincodeInvokeOrQuery(cmd *cobra.Command, args []string, invoke bool) (err
 	chaincodeLang = strings.ToUpper(chaincodeLang)
 	spec := &pb.ChaincodeSpec{Type: pb.ChaincodeSpec_Type(pb.ChaincodeSpec_Type_value[chaincodeLang]),
 		ChaincodeID: &pb.ChaincodeID{Name: chaincodeName}, CtorMsg: input, Attributes: attributes}
+	if !invoke {
+		spec.MinHeight = chaincodeQueryMinHeight
+	}
 
 	// If security is enabled, add client login token
 	if core.SecurityEnabled() {
@@ -980,7 +2168,22 @@ func chaincodeInvokeOrQuery(cmd *cobra.Command, args []string, invoke bool) (err
 	}
 
 	// Build the ChaincodeInvocationSpec message
-	invocation := &pb.ChaincodeInvocationSpec{ChaincodeSpec: spec}
+	invocation = &pb.ChaincodeInvocationSpec{ChaincodeSpec: spec}
+	return
+}
+
+// chaincodeInvokeOrQuery invokes or queries the chaincode. If successful, the
+// INVOKE form prints the transaction ID on STDOUT, and the QUERY form prints
+// the query result on STDOUT. A command-line flag (-r, --raw) determines
+// whether the query result is output as raw bytes, or as a printable string.
+// The printable form is optionally (-x, --hex) a hexadecimal representation
+// of the query response. If the query response is NIL, nothing is output.
+func chaincodeInvokeOrQuery(cmd *cobra.Command, args []string, invoke bool) (err error) {
+
+	devopsClient, invocation, err := chaincodeInvocation(cmd, invoke)
+	if err != nil {
+		return
+	}
 
 	var resp *pb.Response
 	if invoke {
@@ -1004,6 +2207,7 @@ func chaincodeInvokeOrQuery(cmd *cobra.Command, args []string, invoke bool) (err
 	} else {
 		logger.Infof("Successfully queried transaction: %s", invocation)
 		if resp != nil {
+			logger.Infof("Query answered at block height %d with state hash %x", resp.Height, resp.StateHash)
 			if chaincodeQueryRaw {
 				if chaincodeQueryHex {
 					err = errors.New("Options --raw (-r) and --hex (-x) are not compatible\n")
@@ -1025,6 +2229,9 @@ func chaincodeInvokeOrQuery(cmd *cobra.Command, args []string, invoke bool) (err
 // Show a list of all existing network connections for the target peer node,
 // includes both validating and non-validating peers
 func networkList() (err error) {
+	if networkListMyOrg && networkListOrg != undefinedParamValue {
+		return errors.New("Options --org and --my-org are incompatible")
+	}
 	clientConn, err := peer.NewPeerClientConnection()
 	if err != nil {
 		err = fmt.Errorf("Error trying to connect to local peer: %s", err)
@@ -1038,6 +2245,13 @@ func networkList() (err error) {
 		return
 	}
 
+	if networkListMyOrg {
+		networkListOrg = viper.GetString("peer.organization")
+	}
+	if networkListMyOrg || networkListOrg != undefinedParamValue {
+		peers.Peers = peers.OfOrganization(networkListOrg)
+	}
+
 	jsonOutput, _ := json.Marshal(peers)
 	fmt.Println(string(jsonOutput))
 	return nil
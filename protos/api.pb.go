@@ -21,11 +21,14 @@ It has these top-level messages:
 	ChaincodeID
 	ChaincodeInput
 	ChaincodeSpec
+	TransactionTag
 	ChaincodeDeploymentSpec
 	ChaincodeInvocationSpec
 	ChaincodeSecurityContext
 	ChaincodeMessage
+	ChaincodeHandshake
 	PutStateInfo
+	GetStateResponse
 	RangeQueryState
 	RangeQueryStateNext
 	RangeQueryStateClose
@@ -44,8 +47,11 @@ It has these top-level messages:
 	Transaction
 	TransactionBlock
 	TransactionResult
+	RejectedTransaction
 	Block
+	ChainArchive
 	BlockchainInfo
+	ChainTime
 	NonHashData
 	PeerAddress
 	PeerID
@@ -0,0 +1,124 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package protos
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strings"
+)
+
+// ChaincodeProtocolVersion is the version of the shim protocol spoken by the
+// shim and the peer of this code base, announced in the REGISTER handshake.
+// Version 1 introduced the handshake. Version 2 answers GET_STATE with a
+// GetStateResponse.
+const ChaincodeProtocolVersion uint32 = 2
+
+// Optional features of the shim protocol. A shim only uses a feature the peer
+// announced in the handshake, as peers predating it silently ignore it.
+const (
+	// ChaincodeCapabilityStateTTL is the expiry of keys put with a TTL
+	ChaincodeCapabilityStateTTL = "state_ttl"
+	// ChaincodeCapabilityQueryResultChunks is the streaming of query results
+	// in QUERY_RESULT_CHUNK messages
+	ChaincodeCapabilityQueryResultChunks = "query_result_chunks"
+	// ChaincodeCapabilityRangeQueryBookmarks is the resumption of range
+	// queries from the bookmark of an earlier scan
+	ChaincodeCapabilityRangeQueryBookmarks = "range_query_bookmarks"
+	// ChaincodeCapabilitySystemMetadata is the reading of the system metadata
+	// namespace through the reserved keys of SystemMetadataPrefix
+	ChaincodeCapabilitySystemMetadata = "system_metadata"
+)
+
+// ChaincodeCapabilities are the optional features of the shim protocol
+// supported by the shim and the peer of this code base
+var ChaincodeCapabilities = []string{ChaincodeCapabilityStateTTL, ChaincodeCapabilityQueryResultChunks, ChaincodeCapabilityRangeQueryBookmarks, ChaincodeCapabilitySystemMetadata}
+
+// NewChaincodeHandshake returns the handshake announcing the protocol
+// version and capabilities of this code base
+func NewChaincodeHandshake(chaincodeID *ChaincodeID) *ChaincodeHandshake {
+	hs := &ChaincodeHandshake{
+		ProtocolVersion: ChaincodeProtocolVersion,
+		Capabilities:    append([]string{}, ChaincodeCapabilities...),
+	}
+	if chaincodeID != nil {
+		hs.Path, hs.Name = chaincodeID.Path, chaincodeID.Name
+	}
+	return hs
+}
+
+// GetChaincodeID returns the ID of the chaincode registering
+func (m *ChaincodeHandshake) GetChaincodeID() *ChaincodeID {
+	return &ChaincodeID{Path: m.Path, Name: m.Name}
+}
+
+// HasCapability returns whether the sender of the handshake supports the
+// given optional feature of the shim protocol
+func (m *ChaincodeHandshake) HasCapability(capability string) bool {
+	if m == nil {
+		return false
+	}
+	for _, c := range m.Capabilities {
+		if c == capability {
+			return true
+		}
+	}
+	return false
+}
+
+// NewRangeQueryBookmark returns the bookmark of a range query which returned
+// key last. A scan of the same range resumed from the bookmark returns the keys
+// the scan returns after key. The bookmark is opaque to chaincodes, which hand
+// it over to their clients to page through a range in several queries.
+func NewRangeQueryBookmark(key string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(key))
+}
+
+// ParseRangeQueryBookmark returns the key a range query bookmark was taken at
+func ParseRangeQueryBookmark(bookmark string) (string, error) {
+	key, err := base64.RawURLEncoding.DecodeString(bookmark)
+	if err != nil {
+		return "", fmt.Errorf("Invalid range query bookmark %q", bookmark)
+	}
+	return string(key), nil
+}
+
+// The system metadata namespace holds the validator set and configuration of
+// the network. Chaincodes read it with GetState on the reserved keys made of
+// SystemMetadataPrefix and the name of an entry, and cannot write them.
+const (
+	// SystemMetadataPrefix starts the reserved keys of the system metadata
+	SystemMetadataPrefix = "\x00system/"
+	// SystemMetadataNamespace is the ledger namespace the entries are stored
+	// in, apart from the block height which the peer answers from the chain
+	SystemMetadataNamespace = "__system"
+
+	// SystemMetadataHeight is the number of blocks in the chain, in decimal.
+	// During a transaction, the block it goes into is not counted.
+	SystemMetadataHeight = "height"
+	// SystemMetadataValidators is the JSON array of the identities of the
+	// validating peers
+	SystemMetadataValidators = "validators"
+	// SystemMetadataConfigPrefix starts the names of configuration values
+	SystemMetadataConfigPrefix = "config/"
+)
+
+// IsSystemMetadataKey returns whether key is a reserved key of the system
+// metadata namespace
+func IsSystemMetadataKey(key string) bool {
+	return strings.HasPrefix(key, SystemMetadataPrefix)
+}
@@ -109,6 +109,7 @@ const (
 	ChaincodeMessage_RANGE_QUERY_STATE       ChaincodeMessage_Type = 17
 	ChaincodeMessage_RANGE_QUERY_STATE_NEXT  ChaincodeMessage_Type = 18
 	ChaincodeMessage_RANGE_QUERY_STATE_CLOSE ChaincodeMessage_Type = 19
+	ChaincodeMessage_QUERY_RESULT_CHUNK      ChaincodeMessage_Type = 20
 )
 
 var ChaincodeMessage_Type_name = map[int32]string{
@@ -132,6 +133,7 @@ var ChaincodeMessage_Type_name = map[int32]string{
 	17: "RANGE_QUERY_STATE",
 	18: "RANGE_QUERY_STATE_NEXT",
 	19: "RANGE_QUERY_STATE_CLOSE",
+	20: "QUERY_RESULT_CHUNK",
 }
 var ChaincodeMessage_Type_value = map[string]int32{
 	"UNDEFINED":               0,
@@ -154,6 +156,7 @@ var ChaincodeMessage_Type_value = map[string]int32{
 	"RANGE_QUERY_STATE":       17,
 	"RANGE_QUERY_STATE_NEXT":  18,
 	"RANGE_QUERY_STATE_CLOSE": 19,
+	"QUERY_RESULT_CHUNK":      20,
 }
 
 func (x ChaincodeMessage_Type) String() string {
@@ -200,6 +203,13 @@ type ChaincodeSpec struct {
 	ConfidentialityLevel ConfidentialityLevel `protobuf:"varint,6,opt,name=confidentialityLevel,enum=protos.ConfidentialityLevel" json:"confidentialityLevel,omitempty"`
 	Metadata             []byte               `protobuf:"bytes,7,opt,name=metadata,proto3" json:"metadata,omitempty"`
 	Attributes           []string             `protobuf:"bytes,8,rep,name=attributes" json:"attributes,omitempty"`
+	// Only used by deploy, declares the typed arguments of the chaincode functions
+	Manifest *ChaincodeManifest `protobuf:"bytes,9,opt,name=manifest" json:"manifest,omitempty"`
+	// copied to the transaction, by which it can then be searched
+	Tags []*TransactionTag `protobuf:"bytes,10,rep,name=tags" json:"tags,omitempty"`
+	// Only used by query, copied to the transaction: the block height the
+	// peer answering the query must have reached
+	MinHeight uint64 `protobuf:"varint,11,opt,name=minHeight" json:"minHeight,omitempty"`
 }
 
 func (m *ChaincodeSpec) Reset()         { *m = ChaincodeSpec{} }
@@ -220,6 +230,68 @@ func (m *ChaincodeSpec) GetCtorMsg() *ChaincodeInput {
 	return nil
 }
 
+func (m *ChaincodeSpec) GetManifest() *ChaincodeManifest {
+	if m != nil {
+		return m.Manifest
+	}
+	return nil
+}
+
+func (m *ChaincodeSpec) GetTags() []*TransactionTag {
+	if m != nil {
+		return m.Tags
+	}
+	return nil
+}
+
+// Declares the type of the arguments of the functions of a chaincode. Arguments
+// are still carried as strings, the type states how each of them is encoded:
+// "string", "int", "uint", "float", "bool", "bytes" (base64) or "json".
+// Invocations which do not match the manifest of their chaincode are rejected
+// when they are submitted, before they are ordered.
+type ChaincodeManifest struct {
+	Functions []*ChaincodeManifest_Function `protobuf:"bytes,1,rep,name=functions" json:"functions,omitempty"`
+	// reject the invocations of functions which are not declared
+	Strict bool `protobuf:"varint,2,opt,name=strict" json:"strict,omitempty"`
+	// number of queries the chaincode may execute concurrently, 0 for the
+	// default of the peer
+	QueryConcurrency uint32 `protobuf:"varint,3,opt,name=queryConcurrency" json:"queryConcurrency,omitempty"`
+}
+
+func (m *ChaincodeManifest) Reset()         { *m = ChaincodeManifest{} }
+func (m *ChaincodeManifest) String() string { return proto.CompactTextString(m) }
+func (*ChaincodeManifest) ProtoMessage()    {}
+
+func (m *ChaincodeManifest) GetFunctions() []*ChaincodeManifest_Function {
+	if m != nil {
+		return m.Functions
+	}
+	return nil
+}
+
+type ChaincodeManifest_Function struct {
+	Name string   `protobuf:"bytes,1,opt,name=name" json:"name,omitempty"`
+	Args []string `protobuf:"bytes,2,rep,name=args" json:"args,omitempty"`
+	// the type of the last argument applies to any number of trailing arguments
+	Variadic bool `protobuf:"varint,3,opt,name=variadic" json:"variadic,omitempty"`
+}
+
+func (m *ChaincodeManifest_Function) Reset()         { *m = ChaincodeManifest_Function{} }
+func (m *ChaincodeManifest_Function) String() string { return proto.CompactTextString(m) }
+func (*ChaincodeManifest_Function) ProtoMessage()    {}
+
+// An application-defined tag of a transaction, such as an order id. Tags are
+// not encrypted, even for confidential transactions, as the ledger indexes
+// the transactions by tag when they commit.
+type TransactionTag struct {
+	Key   string `protobuf:"bytes,1,opt,name=key" json:"key,omitempty"`
+	Value string `protobuf:"bytes,2,opt,name=value" json:"value,omitempty"`
+}
+
+func (m *TransactionTag) Reset()         { *m = TransactionTag{} }
+func (m *TransactionTag) String() string { return proto.CompactTextString(m) }
+func (*TransactionTag) ProtoMessage()    {}
+
 // Specify the deployment of a chaincode.
 // TODO: Define `codePackage`.
 type ChaincodeDeploymentSpec struct {
@@ -276,6 +348,12 @@ type ChaincodeSecurityContext struct {
 	Metadata       []byte                     `protobuf:"bytes,5,opt,name=metadata,proto3" json:"metadata,omitempty"`
 	ParentMetadata []byte                     `protobuf:"bytes,6,opt,name=parentMetadata,proto3" json:"parentMetadata,omitempty"`
 	TxTimestamp    *google_protobuf.Timestamp `protobuf:"bytes,7,opt,name=txTimestamp" json:"txTimestamp,omitempty"`
+	// consensus time of the block the transaction executes in, or for
+	// queries of the latest block
+	BlockTimestamp *google_protobuf.Timestamp `protobuf:"bytes,8,opt,name=blockTimestamp" json:"blockTimestamp,omitempty"`
+	// random value of the block the transaction executes in, unset for
+	// queries
+	BlockRandom []byte `protobuf:"bytes,9,opt,name=blockRandom,proto3" json:"blockRandom,omitempty"`
 }
 
 func (m *ChaincodeSecurityContext) Reset()         { *m = ChaincodeSecurityContext{} }
@@ -289,6 +367,13 @@ func (m *ChaincodeSecurityContext) GetTxTimestamp() *google_protobuf.Timestamp {
 	return nil
 }
 
+func (m *ChaincodeSecurityContext) GetBlockTimestamp() *google_protobuf.Timestamp {
+	if m != nil {
+		return m.BlockTimestamp
+	}
+	return nil
+}
+
 type ChaincodeMessage struct {
 	Type            ChaincodeMessage_Type      `protobuf:"varint,1,opt,name=type,enum=protos.ChaincodeMessage_Type" json:"type,omitempty"`
 	Timestamp       *google_protobuf.Timestamp `protobuf:"bytes,2,opt,name=timestamp" json:"timestamp,omitempty"`
@@ -326,18 +411,56 @@ func (m *ChaincodeMessage) GetChaincodeEvent() *ChaincodeEvent {
 	return nil
 }
 
+// Payload of the REGISTER message a shim sends and of the REGISTERED message
+// the peer answers it with. Its first fields are those of ChaincodeID, which
+// shims predating the handshake register with, and peers predating it ignore
+// the other fields.
+type ChaincodeHandshake struct {
+	// chaincode registering, only set in REGISTER
+	Path string `protobuf:"bytes,1,opt,name=path" json:"path,omitempty"`
+	Name string `protobuf:"bytes,2,opt,name=name" json:"name,omitempty"`
+	// version of the shim protocol the sender speaks, zero if it predates
+	// the handshake
+	ProtocolVersion uint32 `protobuf:"varint,3,opt,name=protocolVersion" json:"protocolVersion,omitempty"`
+	// optional features of the shim protocol the sender supports
+	Capabilities []string `protobuf:"bytes,4,rep,name=capabilities" json:"capabilities,omitempty"`
+}
+
+func (m *ChaincodeHandshake) Reset()         { *m = ChaincodeHandshake{} }
+func (m *ChaincodeHandshake) String() string { return proto.CompactTextString(m) }
+func (*ChaincodeHandshake) ProtoMessage()    {}
+
 type PutStateInfo struct {
 	Key   string `protobuf:"bytes,1,opt,name=key" json:"key,omitempty"`
 	Value []byte `protobuf:"bytes,2,opt,name=value,proto3" json:"value,omitempty"`
+	// number of blocks after which the key expires, zero means no expiry
+	Ttl uint64 `protobuf:"varint,3,opt,name=ttl" json:"ttl,omitempty"`
 }
 
 func (m *PutStateInfo) Reset()         { *m = PutStateInfo{} }
 func (m *PutStateInfo) String() string { return proto.CompactTextString(m) }
 func (*PutStateInfo) ProtoMessage()    {}
 
+// Payload of the RESPONSE to GET_STATE, from version 2 of the shim protocol
+// on. Earlier versions carry the bare value, which does not tell a key
+// holding an empty value from a missing key.
+type GetStateResponse struct {
+	Value []byte `protobuf:"bytes,1,opt,name=value,proto3" json:"value,omitempty"`
+	Found bool   `protobuf:"varint,2,opt,name=found" json:"found,omitempty"`
+}
+
+func (m *GetStateResponse) Reset()         { *m = GetStateResponse{} }
+func (m *GetStateResponse) String() string { return proto.CompactTextString(m) }
+func (*GetStateResponse) ProtoMessage()    {}
+
 type RangeQueryState struct {
 	StartKey string `protobuf:"bytes,1,opt,name=startKey" json:"startKey,omitempty"`
 	EndKey   string `protobuf:"bytes,2,opt,name=endKey" json:"endKey,omitempty"`
+	// Resumes the scan after the key the bookmark was taken at
+	Bookmark string `protobuf:"bytes,3,opt,name=bookmark" json:"bookmark,omitempty"`
+	// Number of key-values the chaincode expects to read per response, 0 for
+	// the default of the peer
+	Prefetch uint32 `protobuf:"varint,4,opt,name=prefetch" json:"prefetch,omitempty"`
 }
 
 func (m *RangeQueryState) Reset()         { *m = RangeQueryState{} }
@@ -345,7 +468,8 @@ func (m *RangeQueryState) String() string { return proto.CompactTextString(m) }
 func (*RangeQueryState) ProtoMessage()    {}
 
 type RangeQueryStateNext struct {
-	ID string `protobuf:"bytes,1,opt,name=ID" json:"ID,omitempty"`
+	ID       string `protobuf:"bytes,1,opt,name=ID" json:"ID,omitempty"`
+	Prefetch uint32 `protobuf:"varint,2,opt,name=prefetch" json:"prefetch,omitempty"`
 }
 
 func (m *RangeQueryStateNext) Reset()         { *m = RangeQueryStateNext{} }
@@ -120,6 +120,49 @@ func (m *TransactionRequest) Reset()         { *m = TransactionRequest{} }
 func (m *TransactionRequest) String() string { return proto.CompactTextString(m) }
 func (*TransactionRequest) ProtoMessage()    {}
 
+// SimulationResult is the outcome of an invocation executed without its
+// changes being committed
+type SimulationResult struct {
+	// response of the chaincode
+	Result []byte `protobuf:"bytes,1,opt,name=result,proto3" json:"result,omitempty"`
+	// in the order of the keys
+	Changes []*SimulationResult_KeyChange `protobuf:"bytes,2,rep,name=changes" json:"changes,omitempty"`
+	Event   *ChaincodeEvent               `protobuf:"bytes,3,opt,name=event" json:"event,omitempty"`
+}
+
+func (m *SimulationResult) Reset()         { *m = SimulationResult{} }
+func (m *SimulationResult) String() string { return proto.CompactTextString(m) }
+func (*SimulationResult) ProtoMessage()    {}
+
+func (m *SimulationResult) GetChanges() []*SimulationResult_KeyChange {
+	if m != nil {
+		return m.Changes
+	}
+	return nil
+}
+
+func (m *SimulationResult) GetEvent() *ChaincodeEvent {
+	if m != nil {
+		return m.Event
+	}
+	return nil
+}
+
+// KeyChange is a key of the chaincode the invocation would change
+type SimulationResult_KeyChange struct {
+	Key string `protobuf:"bytes,1,opt,name=key" json:"key,omitempty"`
+	// whether the key exists before the invocation, and its value
+	Existed bool   `protobuf:"varint,2,opt,name=existed" json:"existed,omitempty"`
+	Before  []byte `protobuf:"bytes,3,opt,name=before,proto3" json:"before,omitempty"`
+	// whether the invocation deletes the key, or else the value it puts
+	Deleted bool   `protobuf:"varint,4,opt,name=deleted" json:"deleted,omitempty"`
+	After   []byte `protobuf:"bytes,5,opt,name=after,proto3" json:"after,omitempty"`
+}
+
+func (m *SimulationResult_KeyChange) Reset()         { *m = SimulationResult_KeyChange{} }
+func (m *SimulationResult_KeyChange) String() string { return proto.CompactTextString(m) }
+func (*SimulationResult_KeyChange) ProtoMessage()    {}
+
 func init() {
 	proto.RegisterEnum("protos.BuildResult_StatusCode", BuildResult_StatusCode_name, BuildResult_StatusCode_value)
 }
@@ -142,7 +185,13 @@ type DevopsClient interface {
 	Invoke(ctx context.Context, in *ChaincodeInvocationSpec, opts ...grpc.CallOption) (*Response, error)
 	// Invoke chaincode.
 	Query(ctx context.Context, in *ChaincodeInvocationSpec, opts ...grpc.CallOption) (*Response, error)
+	// Query chaincode, receiving the result in the chunks the chaincode emits
+	// them rather than in a single Response.
+	QueryStream(ctx context.Context, in *ChaincodeInvocationSpec, opts ...grpc.CallOption) (Devops_QueryStreamClient, error)
 	// Request a TransactionResult.  The Response.Msg will contain the TransactionResult if successfully found the transaction in the chain.
+	// Execute an invocation without committing its changes, returning the
+	// keys it would change and the event it would emit.
+	Simulate(ctx context.Context, in *ChaincodeInvocationSpec, opts ...grpc.CallOption) (*SimulationResult, error)
 	GetTransactionResult(ctx context.Context, in *TransactionRequest, opts ...grpc.CallOption) (*Response, error)
 	// Retrieve a TCert.
 	EXP_GetApplicationTCert(ctx context.Context, in *Secret, opts ...grpc.CallOption) (*Response, error)
@@ -207,6 +256,47 @@ func (c *devopsClient) Query(ctx context.Context, in *ChaincodeInvocationSpec, o
 	return out, nil
 }
 
+func (c *devopsClient) QueryStream(ctx context.Context, in *ChaincodeInvocationSpec, opts ...grpc.CallOption) (Devops_QueryStreamClient, error) {
+	stream, err := grpc.NewClientStream(ctx, &_Devops_serviceDesc.Streams[0], c.cc, "/protos.Devops/QueryStream", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &devopsQueryStreamClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type Devops_QueryStreamClient interface {
+	Recv() (*Response, error)
+	grpc.ClientStream
+}
+
+type devopsQueryStreamClient struct {
+	grpc.ClientStream
+}
+
+func (x *devopsQueryStreamClient) Recv() (*Response, error) {
+	m := new(Response)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *devopsClient) Simulate(ctx context.Context, in *ChaincodeInvocationSpec, opts ...grpc.CallOption) (*SimulationResult, error) {
+	out := new(SimulationResult)
+	err := grpc.Invoke(ctx, "/protos.Devops/Simulate", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 func (c *devopsClient) GetTransactionResult(ctx context.Context, in *TransactionRequest, opts ...grpc.CallOption) (*Response, error) {
 	out := new(Response)
 	err := grpc.Invoke(ctx, "/protos.Devops/GetTransactionResult", in, out, c.cc, opts...)
@@ -266,7 +356,13 @@ type DevopsServer interface {
 	Invoke(context.Context, *ChaincodeInvocationSpec) (*Response, error)
 	// Invoke chaincode.
 	Query(context.Context, *ChaincodeInvocationSpec) (*Response, error)
+	// Query chaincode, receiving the result in the chunks the chaincode emits
+	// them rather than in a single Response.
+	QueryStream(*ChaincodeInvocationSpec, Devops_QueryStreamServer) error
 	// Request a TransactionResult.  The Response.Msg will contain the TransactionResult if successfully found the transaction in the chain.
+	// Execute an invocation without committing its changes, returning the
+	// keys it would change and the event it would emit.
+	Simulate(context.Context, *ChaincodeInvocationSpec) (*SimulationResult, error)
 	GetTransactionResult(context.Context, *TransactionRequest) (*Response, error)
 	// Retrieve a TCert.
 	EXP_GetApplicationTCert(context.Context, *Secret) (*Response, error)
@@ -342,6 +438,39 @@ func _Devops_Query_Handler(srv interface{}, ctx context.Context, dec func(interf
 	return out, nil
 }
 
+func _Devops_QueryStream_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(ChaincodeInvocationSpec)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(DevopsServer).QueryStream(m, &devopsQueryStreamServer{stream})
+}
+
+type Devops_QueryStreamServer interface {
+	Send(*Response) error
+	grpc.ServerStream
+}
+
+type devopsQueryStreamServer struct {
+	grpc.ServerStream
+}
+
+func (x *devopsQueryStreamServer) Send(m *Response) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _Devops_Simulate_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error) (interface{}, error) {
+	in := new(ChaincodeInvocationSpec)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	out, err := srv.(DevopsServer).Simulate(ctx, in)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 func _Devops_GetTransactionResult_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error) (interface{}, error) {
 	in := new(TransactionRequest)
 	if err := dec(in); err != nil {
@@ -426,6 +555,10 @@ var _Devops_serviceDesc = grpc.ServiceDesc{
 			MethodName: "Query",
 			Handler:    _Devops_Query_Handler,
 		},
+		{
+			MethodName: "Simulate",
+			Handler:    _Devops_Simulate_Handler,
+		},
 		{
 			MethodName: "GetTransactionResult",
 			Handler:    _Devops_GetTransactionResult_Handler,
@@ -447,5 +580,11 @@ var _Devops_serviceDesc = grpc.ServiceDesc{
 			Handler:    _Devops_EXP_ExecuteWithBinding_Handler,
 		},
 	},
-	Streams: []grpc.StreamDesc{},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "QueryStream",
+			Handler:       _Devops_QueryStream_Handler,
+			ServerStreams: true,
+		},
+	},
 }
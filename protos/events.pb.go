@@ -7,6 +7,7 @@ package protos
 import proto "github.com/golang/protobuf/proto"
 import fmt "fmt"
 import math "math"
+import google_protobuf "google/protobuf"
 
 import (
 	context "golang.org/x/net/context"
@@ -24,17 +25,20 @@ const (
 	EventType_REGISTER  EventType = 0
 	EventType_BLOCK     EventType = 1
 	EventType_CHAINCODE EventType = 2
+	EventType_CONSENSUS EventType = 3
 )
 
 var EventType_name = map[int32]string{
 	0: "REGISTER",
 	1: "BLOCK",
 	2: "CHAINCODE",
+	3: "CONSENSUS",
 }
 var EventType_value = map[string]int32{
 	"REGISTER":  0,
 	"BLOCK":     1,
 	"CHAINCODE": 2,
+	"CONSENSUS": 3,
 }
 
 func (x EventType) String() string {
@@ -136,6 +140,11 @@ func _Interest_OneofUnmarshaler(msg proto.Message, tag, wire int, b *proto.Buffe
 // string type - "register"
 type Register struct {
 	Events []*Interest `protobuf:"bytes,1,rep,name=events" json:"events,omitempty"`
+	// name of a durable subscription to create or resume. The peer keeps
+	// the cursor of a durable subscription across reconnections and
+	// restarts, and delivers its events from the block after the last one
+	// acknowledged, setting their block number
+	Subscription string `protobuf:"bytes,2,opt,name=subscription" json:"subscription,omitempty"`
 }
 
 func (m *Register) Reset()         { *m = Register{} }
@@ -149,15 +158,131 @@ func (m *Register) GetEvents() []*Interest {
 	return nil
 }
 
+// Ack is sent by consumers of a durable subscription once they processed
+// the events of the blocks up to blockNumber, included
+type Ack struct {
+	Subscription string `protobuf:"bytes,1,opt,name=subscription" json:"subscription,omitempty"`
+	BlockNumber  uint64 `protobuf:"varint,2,opt,name=blockNumber" json:"blockNumber,omitempty"`
+}
+
+func (m *Ack) Reset()         { *m = Ack{} }
+func (m *Ack) String() string { return proto.CompactTextString(m) }
+func (*Ack) ProtoMessage()    {}
+
+// Unsubscribe is sent by consumers to delete a durable subscription
+type Unsubscribe struct {
+	Subscription string `protobuf:"bytes,1,opt,name=subscription" json:"subscription,omitempty"`
+}
+
+func (m *Unsubscribe) Reset()         { *m = Unsubscribe{} }
+func (m *Unsubscribe) String() string { return proto.CompactTextString(m) }
+func (*Unsubscribe) ProtoMessage()    {}
+
+// StateWrite is a write of a transaction to the state
+type StateWrite struct {
+	ChaincodeID string `protobuf:"bytes,1,opt,name=chaincodeID" json:"chaincodeID,omitempty"`
+	Key         string `protobuf:"bytes,2,opt,name=key" json:"key,omitempty"`
+	Value       []byte `protobuf:"bytes,3,opt,name=value,proto3" json:"value,omitempty"`
+	IsDelete    bool   `protobuf:"varint,4,opt,name=isDelete" json:"isDelete,omitempty"`
+}
+
+func (m *StateWrite) Reset()         { *m = StateWrite{} }
+func (m *StateWrite) String() string { return proto.CompactTextString(m) }
+func (*StateWrite) ProtoMessage()    {}
+
+// TransactionWriteSet holds the writes of a transaction to the state
+type TransactionWriteSet struct {
+	TxUuid string        `protobuf:"bytes,1,opt,name=txUuid" json:"txUuid,omitempty"`
+	Writes []*StateWrite `protobuf:"bytes,2,rep,name=writes" json:"writes,omitempty"`
+}
+
+func (m *TransactionWriteSet) Reset()         { *m = TransactionWriteSet{} }
+func (m *TransactionWriteSet) String() string { return proto.CompactTextString(m) }
+func (*TransactionWriteSet) ProtoMessage()    {}
+
+func (m *TransactionWriteSet) GetWrites() []*StateWrite {
+	if m != nil {
+		return m.Writes
+	}
+	return nil
+}
+
+type ConsensusEvent_Type int32
+
+const (
+	ConsensusEvent_UNDEFINED               ConsensusEvent_Type = 0
+	ConsensusEvent_NEW_VIEW                ConsensusEvent_Type = 1
+	ConsensusEvent_PRE_PREPARED            ConsensusEvent_Type = 2
+	ConsensusEvent_COMMITTED               ConsensusEvent_Type = 3
+	ConsensusEvent_CHECKPOINT_STABLE       ConsensusEvent_Type = 4
+	ConsensusEvent_STATE_TRANSFER_STARTED  ConsensusEvent_Type = 5
+	ConsensusEvent_STATE_TRANSFER_FINISHED ConsensusEvent_Type = 6
+)
+
+var ConsensusEvent_Type_name = map[int32]string{
+	0: "UNDEFINED",
+	1: "NEW_VIEW",
+	2: "PRE_PREPARED",
+	3: "COMMITTED",
+	4: "CHECKPOINT_STABLE",
+	5: "STATE_TRANSFER_STARTED",
+	6: "STATE_TRANSFER_FINISHED",
+}
+var ConsensusEvent_Type_value = map[string]int32{
+	"UNDEFINED":               0,
+	"NEW_VIEW":                1,
+	"PRE_PREPARED":            2,
+	"COMMITTED":               3,
+	"CHECKPOINT_STABLE":       4,
+	"STATE_TRANSFER_STARTED":  5,
+	"STATE_TRANSFER_FINISHED": 6,
+}
+
+func (x ConsensusEvent_Type) String() string {
+	return proto.EnumName(ConsensusEvent_Type_name, int32(x))
+}
+
+// ConsensusEvent marks the progress of the consensus plugin of a validator
+type ConsensusEvent struct {
+	Type      ConsensusEvent_Type        `protobuf:"varint,1,opt,name=type,enum=protos.ConsensusEvent_Type" json:"type,omitempty"`
+	Timestamp *google_protobuf.Timestamp `protobuf:"bytes,2,opt,name=timestamp" json:"timestamp,omitempty"`
+	Replica   uint64                     `protobuf:"varint,3,opt,name=replica" json:"replica,omitempty"`
+	View      uint64                     `protobuf:"varint,4,opt,name=view" json:"view,omitempty"`
+	SeqNo     uint64                     `protobuf:"varint,5,opt,name=seqNo" json:"seqNo,omitempty"`
+	Digest    string                     `protobuf:"bytes,6,opt,name=digest" json:"digest,omitempty"`
+	Success   bool                       `protobuf:"varint,7,opt,name=success" json:"success,omitempty"`
+}
+
+func (m *ConsensusEvent) Reset()         { *m = ConsensusEvent{} }
+func (m *ConsensusEvent) String() string { return proto.CompactTextString(m) }
+func (*ConsensusEvent) ProtoMessage()    {}
+
+func (m *ConsensusEvent) GetTimestamp() *google_protobuf.Timestamp {
+	if m != nil {
+		return m.Timestamp
+	}
+	return nil
+}
+
 // Event is used by
 //  - consumers (adapters) to send Register
 //  - producer to advertise supported types and events
 type Event struct {
 	// Types that are valid to be assigned to Event:
 	//	*Event_Register
+	//	*Event_Ack
+	//	*Event_Unsubscribe
 	//	*Event_Block
 	//	*Event_ChaincodeEvent
+	//	*Event_ConsensusEvent
 	Event isEvent_Event `protobuf_oneof:"Event"`
+	// writes to the state of the transactions of the block which changed it,
+	// in block order, sent with block events if peer.validator.events.writeSets
+	// is set
+	WriteSets []*TransactionWriteSet `protobuf:"bytes,4,rep,name=writeSets" json:"writeSets,omitempty"`
+	// number of the block the event comes from, set on the events of durable
+	// subscriptions
+	BlockNumber uint64 `protobuf:"varint,8,opt,name=blockNumber" json:"blockNumber,omitempty"`
 }
 
 func (m *Event) Reset()         { *m = Event{} }
@@ -171,16 +296,28 @@ type isEvent_Event interface {
 type Event_Register struct {
 	Register *Register `protobuf:"bytes,1,opt,name=register,oneof"`
 }
+type Event_Ack struct {
+	Ack *Ack `protobuf:"bytes,6,opt,name=ack,oneof"`
+}
+type Event_Unsubscribe struct {
+	Unsubscribe *Unsubscribe `protobuf:"bytes,7,opt,name=unsubscribe,oneof"`
+}
 type Event_Block struct {
 	Block *Block `protobuf:"bytes,2,opt,name=block,oneof"`
 }
 type Event_ChaincodeEvent struct {
 	ChaincodeEvent *ChaincodeEvent `protobuf:"bytes,3,opt,name=chaincodeEvent,oneof"`
 }
+type Event_ConsensusEvent struct {
+	ConsensusEvent *ConsensusEvent `protobuf:"bytes,5,opt,name=consensusEvent,oneof"`
+}
 
 func (*Event_Register) isEvent_Event()       {}
+func (*Event_Ack) isEvent_Event()            {}
+func (*Event_Unsubscribe) isEvent_Event()    {}
 func (*Event_Block) isEvent_Event()          {}
 func (*Event_ChaincodeEvent) isEvent_Event() {}
+func (*Event_ConsensusEvent) isEvent_Event() {}
 
 func (m *Event) GetEvent() isEvent_Event {
 	if m != nil {
@@ -196,6 +333,20 @@ func (m *Event) GetRegister() *Register {
 	return nil
 }
 
+func (m *Event) GetAck() *Ack {
+	if x, ok := m.GetEvent().(*Event_Ack); ok {
+		return x.Ack
+	}
+	return nil
+}
+
+func (m *Event) GetUnsubscribe() *Unsubscribe {
+	if x, ok := m.GetEvent().(*Event_Unsubscribe); ok {
+		return x.Unsubscribe
+	}
+	return nil
+}
+
 func (m *Event) GetBlock() *Block {
 	if x, ok := m.GetEvent().(*Event_Block); ok {
 		return x.Block
@@ -210,12 +361,29 @@ func (m *Event) GetChaincodeEvent() *ChaincodeEvent {
 	return nil
 }
 
+func (m *Event) GetConsensusEvent() *ConsensusEvent {
+	if x, ok := m.GetEvent().(*Event_ConsensusEvent); ok {
+		return x.ConsensusEvent
+	}
+	return nil
+}
+
+func (m *Event) GetWriteSets() []*TransactionWriteSet {
+	if m != nil {
+		return m.WriteSets
+	}
+	return nil
+}
+
 // XXX_OneofFuncs is for the internal use of the proto package.
 func (*Event) XXX_OneofFuncs() (func(msg proto.Message, b *proto.Buffer) error, func(msg proto.Message, tag, wire int, b *proto.Buffer) (bool, error), []interface{}) {
 	return _Event_OneofMarshaler, _Event_OneofUnmarshaler, []interface{}{
 		(*Event_Register)(nil),
+		(*Event_Ack)(nil),
+		(*Event_Unsubscribe)(nil),
 		(*Event_Block)(nil),
 		(*Event_ChaincodeEvent)(nil),
+		(*Event_ConsensusEvent)(nil),
 	}
 }
 
@@ -228,6 +396,16 @@ func _Event_OneofMarshaler(msg proto.Message, b *proto.Buffer) error {
 		if err := b.EncodeMessage(x.Register); err != nil {
 			return err
 		}
+	case *Event_Ack:
+		b.EncodeVarint(6<<3 | proto.WireBytes)
+		if err := b.EncodeMessage(x.Ack); err != nil {
+			return err
+		}
+	case *Event_Unsubscribe:
+		b.EncodeVarint(7<<3 | proto.WireBytes)
+		if err := b.EncodeMessage(x.Unsubscribe); err != nil {
+			return err
+		}
 	case *Event_Block:
 		b.EncodeVarint(2<<3 | proto.WireBytes)
 		if err := b.EncodeMessage(x.Block); err != nil {
@@ -238,6 +416,11 @@ func _Event_OneofMarshaler(msg proto.Message, b *proto.Buffer) error {
 		if err := b.EncodeMessage(x.ChaincodeEvent); err != nil {
 			return err
 		}
+	case *Event_ConsensusEvent:
+		b.EncodeVarint(5<<3 | proto.WireBytes)
+		if err := b.EncodeMessage(x.ConsensusEvent); err != nil {
+			return err
+		}
 	case nil:
 	default:
 		return fmt.Errorf("Event.Event has unexpected type %T", x)
@@ -272,6 +455,30 @@ func _Event_OneofUnmarshaler(msg proto.Message, tag, wire int, b *proto.Buffer)
 		err := b.DecodeMessage(msg)
 		m.Event = &Event_ChaincodeEvent{msg}
 		return true, err
+	case 5: // Event.consensusEvent
+		if wire != proto.WireBytes {
+			return true, proto.ErrInternalBadWireType
+		}
+		msg := new(ConsensusEvent)
+		err := b.DecodeMessage(msg)
+		m.Event = &Event_ConsensusEvent{msg}
+		return true, err
+	case 6: // Event.ack
+		if wire != proto.WireBytes {
+			return true, proto.ErrInternalBadWireType
+		}
+		msg := new(Ack)
+		err := b.DecodeMessage(msg)
+		m.Event = &Event_Ack{msg}
+		return true, err
+	case 7: // Event.unsubscribe
+		if wire != proto.WireBytes {
+			return true, proto.ErrInternalBadWireType
+		}
+		msg := new(Unsubscribe)
+		err := b.DecodeMessage(msg)
+		m.Event = &Event_Unsubscribe{msg}
+		return true, err
 	default:
 		return false, nil
 	}
@@ -279,6 +486,7 @@ func _Event_OneofUnmarshaler(msg proto.Message, tag, wire int, b *proto.Buffer)
 
 func init() {
 	proto.RegisterEnum("protos.EventType", EventType_name, EventType_value)
+	proto.RegisterEnum("protos.ConsensusEvent_Type", ConsensusEvent_Type_name, ConsensusEvent_Type_value)
 }
 
 // Reference imports to suppress errors if they are not otherwise used.
@@ -179,6 +179,23 @@ type Transaction struct {
 	ToValidators                   []byte                     `protobuf:"bytes,10,opt,name=toValidators,proto3" json:"toValidators,omitempty"`
 	Cert                           []byte                     `protobuf:"bytes,11,opt,name=cert,proto3" json:"cert,omitempty"`
 	Signature                      []byte                     `protobuf:"bytes,12,opt,name=signature,proto3" json:"signature,omitempty"`
+	// when non-zero, the transaction is signed with the one-time key of this
+	// index derived from the key certified by cert, rather than with that key
+	CertKeyIndex uint64 `protobuf:"varint,13,opt,name=certKeyIndex" json:"certKeyIndex,omitempty"`
+	// when not empty, the transaction carries no cert and is signed by one of
+	// the members whose enrollment certificates ring holds, without revealing
+	// which one
+	Ring [][]byte `protobuf:"bytes,14,rep,name=ring,proto3" json:"ring,omitempty"`
+	// application-defined tags, by which the ledger indexes the transaction
+	Tags []*TransactionTag `protobuf:"bytes,15,rep,name=tags" json:"tags,omitempty"`
+	// for queries, the block height the peer answering the query must have
+	// reached, or the query fails
+	MinHeight uint64 `protobuf:"varint,16,opt,name=minHeight" json:"minHeight,omitempty"`
+	// with certKeyIndex, the derived public key, marshalled as an
+	// uncompressed point, and the signature of its delegation by the key
+	// certified by cert
+	CertKey          []byte `protobuf:"bytes,17,opt,name=certKey,proto3" json:"certKey,omitempty"`
+	CertKeySignature []byte `protobuf:"bytes,18,opt,name=certKeySignature,proto3" json:"certKeySignature,omitempty"`
 }
 
 func (m *Transaction) Reset()         { *m = Transaction{} }
@@ -192,6 +209,13 @@ func (m *Transaction) GetTimestamp() *google_protobuf.Timestamp {
 	return nil
 }
 
+func (m *Transaction) GetTags() []*TransactionTag {
+	if m != nil {
+		return m.Tags
+	}
+	return nil
+}
+
 // TransactionBlock carries a batch of transactions.
 type TransactionBlock struct {
 	Transactions []*Transaction `protobuf:"bytes,1,rep,name=transactions" json:"transactions,omitempty"`
@@ -234,6 +258,32 @@ func (m *TransactionResult) GetChaincodeEvent() *ChaincodeEvent {
 	return nil
 }
 
+// RejectedTransaction records why a peer rejected a transaction.
+// uuid - The unique identifier of the transaction.
+// stage - Where the transaction was rejected: validation, consensus or
+// execution.
+// reason - The reason code of the rejection.
+// error - The error the transaction was rejected with.
+// timestamp - The time at which the transaction was rejected.
+type RejectedTransaction struct {
+	Uuid      string                     `protobuf:"bytes,1,opt,name=uuid" json:"uuid,omitempty"`
+	Stage     string                     `protobuf:"bytes,2,opt,name=stage" json:"stage,omitempty"`
+	Reason    string                     `protobuf:"bytes,3,opt,name=reason" json:"reason,omitempty"`
+	Error     string                     `protobuf:"bytes,4,opt,name=error" json:"error,omitempty"`
+	Timestamp *google_protobuf.Timestamp `protobuf:"bytes,5,opt,name=timestamp" json:"timestamp,omitempty"`
+}
+
+func (m *RejectedTransaction) Reset()         { *m = RejectedTransaction{} }
+func (m *RejectedTransaction) String() string { return proto.CompactTextString(m) }
+func (*RejectedTransaction) ProtoMessage()    {}
+
+func (m *RejectedTransaction) GetTimestamp() *google_protobuf.Timestamp {
+	if m != nil {
+		return m.Timestamp
+	}
+	return nil
+}
+
 // Block carries The data that describes a block in the blockchain.
 // version - Version used to track any protocol changes.
 // timestamp - The time at which the block or transaction order
@@ -281,6 +331,51 @@ func (m *Block) GetNonHashData() *NonHashData {
 	return nil
 }
 
+// ChainArchive is a verifiable export of the transactions whose timestamp falls
+// within [from, to).
+// firstBlock - The number of the first block of the archive.
+// blocks - The blocks from the first block holding a transaction of the range
+// up to the chain head at export time. Each block is the inclusion proof of its
+// transactions, and links to the published chain-head anchors through the
+// previousBlockHash of the blocks following it.
+// signer - The identifier of the exporting peer.
+// signature - The signature of the exporting peer over the SHAKE256 hash, 64
+// bytes long, of the archive with the signature field unset. Archives are
+// written field by field, in field number order, as they are exported.
+type ChainArchive struct {
+	From       *google_protobuf.Timestamp `protobuf:"bytes,1,opt,name=from" json:"from,omitempty"`
+	To         *google_protobuf.Timestamp `protobuf:"bytes,2,opt,name=to" json:"to,omitempty"`
+	FirstBlock uint64                     `protobuf:"varint,3,opt,name=firstBlock" json:"firstBlock,omitempty"`
+	Blocks     []*Block                   `protobuf:"bytes,4,rep,name=blocks" json:"blocks,omitempty"`
+	Signer     []byte                     `protobuf:"bytes,5,opt,name=signer,proto3" json:"signer,omitempty"`
+	Signature  []byte                     `protobuf:"bytes,6,opt,name=signature,proto3" json:"signature,omitempty"`
+}
+
+func (m *ChainArchive) Reset()         { *m = ChainArchive{} }
+func (m *ChainArchive) String() string { return proto.CompactTextString(m) }
+func (*ChainArchive) ProtoMessage()    {}
+
+func (m *ChainArchive) GetFrom() *google_protobuf.Timestamp {
+	if m != nil {
+		return m.From
+	}
+	return nil
+}
+
+func (m *ChainArchive) GetTo() *google_protobuf.Timestamp {
+	if m != nil {
+		return m.To
+	}
+	return nil
+}
+
+func (m *ChainArchive) GetBlocks() []*Block {
+	if m != nil {
+		return m.Blocks
+	}
+	return nil
+}
+
 // Contains information about the blockchain ledger such as height, current
 // block hash, and previous block hash.
 type BlockchainInfo struct {
@@ -293,6 +388,27 @@ func (m *BlockchainInfo) Reset()         { *m = BlockchainInfo{} }
 func (m *BlockchainInfo) String() string { return proto.CompactTextString(m) }
 func (*BlockchainInfo) ProtoMessage()    {}
 
+// ChainTime is the consensus time of the blockchain: the timestamp of its
+// latest block, which the validators agreed on when ordering it.
+// blockNumber - The number of the latest block.
+// timestamp - The timestamp of the latest block, unset if the consensus
+// does not stamp blocks.
+type ChainTime struct {
+	BlockNumber uint64                     `protobuf:"varint,1,opt,name=blockNumber" json:"blockNumber,omitempty"`
+	Timestamp   *google_protobuf.Timestamp `protobuf:"bytes,2,opt,name=timestamp" json:"timestamp,omitempty"`
+}
+
+func (m *ChainTime) Reset()         { *m = ChainTime{} }
+func (m *ChainTime) String() string { return proto.CompactTextString(m) }
+func (*ChainTime) ProtoMessage()    {}
+
+func (m *ChainTime) GetTimestamp() *google_protobuf.Timestamp {
+	if m != nil {
+		return m.Timestamp
+	}
+	return nil
+}
+
 // NonHashData is data that is recorded on the block, but not included in
 // the block hash when verifying the blockchain.
 // localLedgerCommitTimestamp - The time at which the block was added
@@ -343,6 +459,10 @@ type PeerEndpoint struct {
 	Address string            `protobuf:"bytes,2,opt,name=address" json:"address,omitempty"`
 	Type    PeerEndpoint_Type `protobuf:"varint,3,opt,name=type,enum=protos.PeerEndpoint_Type" json:"type,omitempty"`
 	PkiID   []byte            `protobuf:"bytes,4,opt,name=pkiID,proto3" json:"pkiID,omitempty"`
+	// Organization the peer belongs to, as configured on the peer. It is
+	// not authenticated: nothing ties it to the enrollment certificate of
+	// the peer, so a peer can announce any organization
+	Organization string `protobuf:"bytes,5,opt,name=organization" json:"organization,omitempty"`
 }
 
 func (m *PeerEndpoint) Reset()         { *m = PeerEndpoint{} }
@@ -412,9 +532,47 @@ func (m *Message) GetTimestamp() *google_protobuf.Timestamp {
 	return nil
 }
 
+// RecordedMessage is a message delivered to the consensus plugin of a
+// validator, as recorded in a message trace for replay
+type RecordedMessage struct {
+	// when the validator received the message
+	Timestamp *google_protobuf.Timestamp `protobuf:"bytes,1,opt,name=timestamp" json:"timestamp,omitempty"`
+	Sender    *PeerID                    `protobuf:"bytes,2,opt,name=sender" json:"sender,omitempty"`
+	Msg       *Message                   `protobuf:"bytes,3,opt,name=msg" json:"msg,omitempty"`
+}
+
+func (m *RecordedMessage) Reset()         { *m = RecordedMessage{} }
+func (m *RecordedMessage) String() string { return proto.CompactTextString(m) }
+func (*RecordedMessage) ProtoMessage()    {}
+
+func (m *RecordedMessage) GetTimestamp() *google_protobuf.Timestamp {
+	if m != nil {
+		return m.Timestamp
+	}
+	return nil
+}
+
+func (m *RecordedMessage) GetSender() *PeerID {
+	if m != nil {
+		return m.Sender
+	}
+	return nil
+}
+
+func (m *RecordedMessage) GetMsg() *Message {
+	if m != nil {
+		return m.Msg
+	}
+	return nil
+}
+
 type Response struct {
 	Status Response_StatusCode `protobuf:"varint,1,opt,name=status,enum=protos.Response_StatusCode" json:"status,omitempty"`
 	Msg    []byte              `protobuf:"bytes,2,opt,name=msg,proto3" json:"msg,omitempty"`
+	// for queries, the block height and state hash of the peer when it
+	// answered, which the answer is at least as recent as
+	Height    uint64 `protobuf:"varint,3,opt,name=height" json:"height,omitempty"`
+	StateHash []byte `protobuf:"bytes,4,opt,name=stateHash,proto3" json:"stateHash,omitempty"`
 }
 
 func (m *Response) Reset()         { *m = Response{} }
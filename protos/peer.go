@@ -0,0 +1,29 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package protos
+
+// OfOrganization returns the peers of the message which belong to
+// organization org
+func (m *PeersMessage) OfOrganization(org string) []*PeerEndpoint {
+	var peers []*PeerEndpoint
+	for _, peer := range m.GetPeers() {
+		if peer.Organization == org {
+			peers = append(peers, peer)
+		}
+	}
+	return peers
+}
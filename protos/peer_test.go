@@ -0,0 +1,41 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package protos
+
+import (
+	"testing"
+)
+
+func Test_PeersMessage_OfOrganization(t *testing.T) {
+	peers := &PeersMessage{Peers: []*PeerEndpoint{
+		{ID: &PeerID{Name: "vp0"}, Organization: "org1"},
+		{ID: &PeerID{Name: "vp1"}, Organization: "org2"},
+		{ID: &PeerID{Name: "vp2"}, Organization: "org1"},
+		{ID: &PeerID{Name: "vp3"}},
+	}}
+
+	org1 := peers.OfOrganization("org1")
+	if len(org1) != 2 || org1[0].ID.Name != "vp0" || org1[1].ID.Name != "vp2" {
+		t.Fatalf("Expected peers vp0 and vp2 in org1, got %v", org1)
+	}
+	if none := peers.OfOrganization(""); len(none) != 1 || none[0].ID.Name != "vp3" {
+		t.Fatalf("Expected peer vp3 without organization, got %v", none)
+	}
+	if unknown := peers.OfOrganization("org3"); len(unknown) != 0 {
+		t.Fatalf("Expected no peers in org3, got %v", unknown)
+	}
+}
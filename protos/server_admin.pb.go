@@ -51,16 +51,364 @@ func (x ServerStatus_StatusCode) String() string {
 	return proto.EnumName(ServerStatus_StatusCode_name, int32(x))
 }
 
+type ProfileRequest_Type int32
+
+const (
+	ProfileRequest_HEAP      ProfileRequest_Type = 0
+	ProfileRequest_GOROUTINE ProfileRequest_Type = 1
+)
+
+var ProfileRequest_Type_name = map[int32]string{
+	0: "HEAP",
+	1: "GOROUTINE",
+}
+var ProfileRequest_Type_value = map[string]int32{
+	"HEAP":      0,
+	"GOROUTINE": 1,
+}
+
+func (x ProfileRequest_Type) String() string {
+	return proto.EnumName(ProfileRequest_Type_name, int32(x))
+}
+
 type ServerStatus struct {
 	Status ServerStatus_StatusCode `protobuf:"varint,1,opt,name=status,enum=protos.ServerStatus_StatusCode" json:"status,omitempty"`
+	// progress of the latest state transfer, only set on validating peers
+	StateTransfer *StateTransferProgress `protobuf:"bytes,2,opt,name=stateTransfer" json:"stateTransfer,omitempty"`
 }
 
 func (m *ServerStatus) Reset()         { *m = ServerStatus{} }
 func (m *ServerStatus) String() string { return proto.CompactTextString(m) }
 func (*ServerStatus) ProtoMessage()    {}
 
+func (m *ServerStatus) GetStateTransfer() *StateTransferProgress {
+	if m != nil {
+		return m.StateTransfer
+	}
+	return nil
+}
+
+type StateUsage struct {
+	ChaincodeID string `protobuf:"bytes,1,opt,name=chaincodeID" json:"chaincodeID,omitempty"`
+	// number of bytes (keys plus values) held in the world state
+	Usage uint64 `protobuf:"varint,2,opt,name=usage" json:"usage,omitempty"`
+	// maximum number of bytes allowed, 0 if unlimited
+	Quota uint64 `protobuf:"varint,3,opt,name=quota" json:"quota,omitempty"`
+}
+
+func (m *StateUsage) Reset()         { *m = StateUsage{} }
+func (m *StateUsage) String() string { return proto.CompactTextString(m) }
+func (*StateUsage) ProtoMessage()    {}
+
+type ChaosConfig struct {
+	// maximum random delay applied to every message sent to another peer
+	MessageDelayMs uint64 `protobuf:"varint,1,opt,name=messageDelayMs" json:"messageDelayMs,omitempty"`
+	// probability (0 to 1) of dropping a connection when a message is received on it
+	DropConnectionProbability float64 `protobuf:"fixed64,2,opt,name=dropConnectionProbability" json:"dropConnectionProbability,omitempty"`
+	// delay applied to every commit of a block
+	CommitDelayMs uint64 `protobuf:"varint,3,opt,name=commitDelayMs" json:"commitDelayMs,omitempty"`
+	// force the consensus plugin into a view change, not retained
+	ForceViewChange bool `protobuf:"varint,4,opt,name=forceViewChange" json:"forceViewChange,omitempty"`
+}
+
+func (m *ChaosConfig) Reset()         { *m = ChaosConfig{} }
+func (m *ChaosConfig) String() string { return proto.CompactTextString(m) }
+func (*ChaosConfig) ProtoMessage()    {}
+
+type DedupStats struct {
+	ChaincodeID string `protobuf:"bytes,1,opt,name=chaincodeID" json:"chaincodeID,omitempty"`
+	// number of blocks a transaction uuid is remembered for, 0 if disabled
+	Window uint64 `protobuf:"varint,2,opt,name=window" json:"window,omitempty"`
+	// either "reply" or "reject"
+	Policy string `protobuf:"bytes,3,opt,name=policy" json:"policy,omitempty"`
+	// number of invoke transactions checked against the window
+	Lookups uint64 `protobuf:"varint,4,opt,name=lookups" json:"lookups,omitempty"`
+	// number of invoke transactions found to be duplicates
+	Hits uint64 `protobuf:"varint,5,opt,name=hits" json:"hits,omitempty"`
+}
+
+func (m *DedupStats) Reset()         { *m = DedupStats{} }
+func (m *DedupStats) String() string { return proto.CompactTextString(m) }
+func (*DedupStats) ProtoMessage()    {}
+
+type ConsensusStatus struct {
+	Plugin string `protobuf:"bytes,1,opt,name=plugin" json:"plugin,omitempty"`
+	// JSON encoded snapshot, its layout is specific to the plugin
+	Status string `protobuf:"bytes,2,opt,name=status" json:"status,omitempty"`
+}
+
+func (m *ConsensusStatus) Reset()         { *m = ConsensusStatus{} }
+func (m *ConsensusStatus) String() string { return proto.CompactTextString(m) }
+func (*ConsensusStatus) ProtoMessage()    {}
+
+type CompactionProgress struct {
+	// column family which was just compacted, empty when the compaction starts
+	ColumnFamily string `protobuf:"bytes,1,opt,name=columnFamily" json:"columnFamily,omitempty"`
+	// number of column families compacted so far, out of total
+	Done  uint32 `protobuf:"varint,2,opt,name=done" json:"done,omitempty"`
+	Total uint32 `protobuf:"varint,3,opt,name=total" json:"total,omitempty"`
+	// time spent compacting so far
+	ElapsedMs uint64 `protobuf:"varint,4,opt,name=elapsedMs" json:"elapsedMs,omitempty"`
+}
+
+func (m *CompactionProgress) Reset()         { *m = CompactionProgress{} }
+func (m *CompactionProgress) String() string { return proto.CompactTextString(m) }
+func (*CompactionProgress) ProtoMessage()    {}
+
+type ConsensusTraceRequest struct {
+	// message types to trace, e.g. "pre_prepare", all types if empty
+	Types []string `protobuf:"bytes,1,rep,name=types" json:"types,omitempty"`
+	// only trace the messages of a view, if filterView is set
+	FilterView bool   `protobuf:"varint,2,opt,name=filterView" json:"filterView,omitempty"`
+	View       uint64 `protobuf:"varint,3,opt,name=view" json:"view,omitempty"`
+	// only trace the messages of a sequence number, if filterSeqNo is set
+	FilterSeqNo bool   `protobuf:"varint,4,opt,name=filterSeqNo" json:"filterSeqNo,omitempty"`
+	SeqNo       uint64 `protobuf:"varint,5,opt,name=seqNo" json:"seqNo,omitempty"`
+}
+
+func (m *ConsensusTraceRequest) Reset()         { *m = ConsensusTraceRequest{} }
+func (m *ConsensusTraceRequest) String() string { return proto.CompactTextString(m) }
+func (*ConsensusTraceRequest) ProtoMessage()    {}
+
+type ConsensusTraceEvent struct {
+	Timestamp *google_protobuf1.Timestamp `protobuf:"bytes,1,opt,name=timestamp" json:"timestamp,omitempty"`
+	// true for messages the peer sent, false for messages it received
+	Sent bool `protobuf:"varint,2,opt,name=sent" json:"sent,omitempty"`
+	// replica the message came from or was sent to, -1 for broadcasts
+	Replica int64  `protobuf:"varint,3,opt,name=replica" json:"replica,omitempty"`
+	Type    string `protobuf:"bytes,4,opt,name=type" json:"type,omitempty"`
+	// set for the message types which carry a view or sequence number
+	HasView  bool   `protobuf:"varint,5,opt,name=hasView" json:"hasView,omitempty"`
+	View     uint64 `protobuf:"varint,6,opt,name=view" json:"view,omitempty"`
+	HasSeqNo bool   `protobuf:"varint,7,opt,name=hasSeqNo" json:"hasSeqNo,omitempty"`
+	SeqNo    uint64 `protobuf:"varint,8,opt,name=seqNo" json:"seqNo,omitempty"`
+	// JSON encoded message
+	Message string `protobuf:"bytes,9,opt,name=message" json:"message,omitempty"`
+	// number of events dropped before this one, the client being too slow
+	Dropped uint64 `protobuf:"varint,10,opt,name=dropped" json:"dropped,omitempty"`
+}
+
+func (m *ConsensusTraceEvent) Reset()         { *m = ConsensusTraceEvent{} }
+func (m *ConsensusTraceEvent) String() string { return proto.CompactTextString(m) }
+func (*ConsensusTraceEvent) ProtoMessage()    {}
+
+func (m *ConsensusTraceEvent) GetTimestamp() *google_protobuf1.Timestamp {
+	if m != nil {
+		return m.Timestamp
+	}
+	return nil
+}
+
+type LedgerStatistics struct {
+	// chaincodes holding state, by chaincode ID
+	Chaincodes []*LedgerStatistics_Chaincode `protobuf:"bytes,1,rep,name=chaincodes" json:"chaincodes,omitempty"`
+	Keys       uint64                        `protobuf:"varint,2,opt,name=keys" json:"keys,omitempty"`
+	Bytes      uint64                        `protobuf:"varint,3,opt,name=bytes" json:"bytes,omitempty"`
+	Blocks     uint64                        `protobuf:"varint,4,opt,name=blocks" json:"blocks,omitempty"`
+	// number of bytes of all the blocks
+	BlockBytes uint64 `protobuf:"varint,5,opt,name=blockBytes" json:"blockBytes,omitempty"`
+	// blockSizeCounts[i] is the number of blocks smaller than
+	// blockSizeBounds[i] bytes and not counted before, the last count being
+	// that of the blocks larger than all the bounds
+	BlockSizeBounds []uint64 `protobuf:"varint,6,rep,name=blockSizeBounds" json:"blockSizeBounds,omitempty"`
+	BlockSizeCounts []uint64 `protobuf:"varint,7,rep,name=blockSizeCounts" json:"blockSizeCounts,omitempty"`
+}
+
+func (m *LedgerStatistics) Reset()         { *m = LedgerStatistics{} }
+func (m *LedgerStatistics) String() string { return proto.CompactTextString(m) }
+func (*LedgerStatistics) ProtoMessage()    {}
+
+func (m *LedgerStatistics) GetChaincodes() []*LedgerStatistics_Chaincode {
+	if m != nil {
+		return m.Chaincodes
+	}
+	return nil
+}
+
+type LedgerStatistics_Chaincode struct {
+	ChaincodeID string `protobuf:"bytes,1,opt,name=chaincodeID" json:"chaincodeID,omitempty"`
+	// number of keys held in the world state
+	Keys uint64 `protobuf:"varint,2,opt,name=keys" json:"keys,omitempty"`
+	// number of bytes (keys plus values) held in the world state
+	Bytes uint64 `protobuf:"varint,3,opt,name=bytes" json:"bytes,omitempty"`
+}
+
+func (m *LedgerStatistics_Chaincode) Reset()         { *m = LedgerStatistics_Chaincode{} }
+func (m *LedgerStatistics_Chaincode) String() string { return proto.CompactTextString(m) }
+func (*LedgerStatistics_Chaincode) ProtoMessage()    {}
+
+type ProfileRequest struct {
+	Type ProfileRequest_Type `protobuf:"varint,1,opt,name=type,enum=protos.ProfileRequest_Type" json:"type,omitempty"`
+}
+
+func (m *ProfileRequest) Reset()         { *m = ProfileRequest{} }
+func (m *ProfileRequest) String() string { return proto.CompactTextString(m) }
+func (*ProfileRequest) ProtoMessage()    {}
+
+type ProfileSnapshot struct {
+	Type ProfileRequest_Type `protobuf:"varint,1,opt,name=type,enum=protos.ProfileRequest_Type" json:"type,omitempty"`
+	// file the profile was written to on the peer, in the pprof format
+	Path      string                      `protobuf:"bytes,2,opt,name=path" json:"path,omitempty"`
+	Size      uint64                      `protobuf:"varint,3,opt,name=size" json:"size,omitempty"`
+	Timestamp *google_protobuf1.Timestamp `protobuf:"bytes,4,opt,name=timestamp" json:"timestamp,omitempty"`
+	// "request", or "heap_threshold" if captured as the heap grew over the
+	// configured threshold
+	Reason string `protobuf:"bytes,5,opt,name=reason" json:"reason,omitempty"`
+}
+
+func (m *ProfileSnapshot) Reset()         { *m = ProfileSnapshot{} }
+func (m *ProfileSnapshot) String() string { return proto.CompactTextString(m) }
+func (*ProfileSnapshot) ProtoMessage()    {}
+
+func (m *ProfileSnapshot) GetTimestamp() *google_protobuf1.Timestamp {
+	if m != nil {
+		return m.Timestamp
+	}
+	return nil
+}
+
+type ConsensusReplayRequest struct {
+	// payload of the consensus message, as received
+	Payload []byte `protobuf:"bytes,1,opt,name=payload,proto3" json:"payload,omitempty"`
+	// name of the peer the message is replayed as coming from
+	Sender string `protobuf:"bytes,2,opt,name=sender" json:"sender,omitempty"`
+}
+
+func (m *ConsensusReplayRequest) Reset()         { *m = ConsensusReplayRequest{} }
+func (m *ConsensusReplayRequest) String() string { return proto.CompactTextString(m) }
+func (*ConsensusReplayRequest) ProtoMessage()    {}
+
+type ConsensusReplayResult struct {
+	// whether the consensus plugin accepted the message for processing
+	Accepted bool `protobuf:"varint,1,opt,name=accepted" json:"accepted,omitempty"`
+	// why the consensus plugin rejected the message, if it did
+	Reason string `protobuf:"bytes,2,opt,name=reason" json:"reason,omitempty"`
+}
+
+func (m *ConsensusReplayResult) Reset()         { *m = ConsensusReplayResult{} }
+func (m *ConsensusReplayResult) String() string { return proto.CompactTextString(m) }
+func (*ConsensusReplayResult) ProtoMessage()    {}
+
+type ConsensusReconfiguration struct {
+	// number of validators of the network
+	N uint32 `protobuf:"varint,1,opt,name=n" json:"n,omitempty"`
+	// number of faulty validators tolerated
+	F uint32 `protobuf:"varint,2,opt,name=f" json:"f,omitempty"`
+}
+
+func (m *ConsensusReconfiguration) Reset()         { *m = ConsensusReconfiguration{} }
+func (m *ConsensusReconfiguration) String() string { return proto.CompactTextString(m) }
+func (*ConsensusReconfiguration) ProtoMessage()    {}
+
+type Subsystem struct {
+	// one of "eventhub", "blocksync" or "chaincode"
+	Name string `protobuf:"bytes,1,opt,name=name" json:"name,omitempty"`
+}
+
+func (m *Subsystem) Reset()         { *m = Subsystem{} }
+func (m *Subsystem) String() string { return proto.CompactTextString(m) }
+func (*Subsystem) ProtoMessage()    {}
+
+type PausedSubsystems struct {
+	// subsystems paused, sorted
+	Names []string `protobuf:"bytes,1,rep,name=names" json:"names,omitempty"`
+}
+
+func (m *PausedSubsystems) Reset()         { *m = PausedSubsystems{} }
+func (m *PausedSubsystems) String() string { return proto.CompactTextString(m) }
+func (*PausedSubsystems) ProtoMessage()    {}
+
+type DeniedPeer struct {
+	// peer.id of the peer
+	Name string `protobuf:"bytes,1,opt,name=name" json:"name,omitempty"`
+	// why the peer was denied, as given by the operator
+	Reason string `protobuf:"bytes,2,opt,name=reason" json:"reason,omitempty"`
+	// when the peer was denied, set by the peer
+	Since *google_protobuf1.Timestamp `protobuf:"bytes,3,opt,name=since" json:"since,omitempty"`
+}
+
+func (m *DeniedPeer) Reset()         { *m = DeniedPeer{} }
+func (m *DeniedPeer) String() string { return proto.CompactTextString(m) }
+func (*DeniedPeer) ProtoMessage()    {}
+
+func (m *DeniedPeer) GetSince() *google_protobuf1.Timestamp {
+	if m != nil {
+		return m.Since
+	}
+	return nil
+}
+
+type DeniedPeers struct {
+	// peers denied, sorted by name
+	Peers []*DeniedPeer `protobuf:"bytes,1,rep,name=peers" json:"peers,omitempty"`
+}
+
+func (m *DeniedPeers) Reset()         { *m = DeniedPeers{} }
+func (m *DeniedPeers) String() string { return proto.CompactTextString(m) }
+func (*DeniedPeers) ProtoMessage()    {}
+
+func (m *DeniedPeers) GetPeers() []*DeniedPeer {
+	if m != nil {
+		return m.Peers
+	}
+	return nil
+}
+
+type ChaincodeBreaker struct {
+	ChaincodeID string `protobuf:"bytes,1,opt,name=chaincodeID" json:"chaincodeID,omitempty"`
+	// number of consecutive failed invokes which disable the chaincode, 0 if never
+	Threshold uint64 `protobuf:"varint,2,opt,name=threshold" json:"threshold,omitempty"`
+	// number of consecutive failed invokes of the chaincode
+	Failures uint64 `protobuf:"varint,3,opt,name=failures" json:"failures,omitempty"`
+	// whether the invokes of the chaincode are rejected
+	Open bool `protobuf:"varint,4,opt,name=open" json:"open,omitempty"`
+}
+
+func (m *ChaincodeBreaker) Reset()         { *m = ChaincodeBreaker{} }
+func (m *ChaincodeBreaker) String() string { return proto.CompactTextString(m) }
+func (*ChaincodeBreaker) ProtoMessage()    {}
+
+type StateTransferRequest struct {
+	// peer.id of the peer to fetch the state from, any peer attesting to the
+	// state if empty
+	Peer string `protobuf:"bytes,1,opt,name=peer" json:"peer,omitempty"`
+}
+
+func (m *StateTransferRequest) Reset()         { *m = StateTransferRequest{} }
+func (m *StateTransferRequest) String() string { return proto.CompactTextString(m) }
+func (*StateTransferRequest) ProtoMessage()    {}
+
+type StateTransferProgress struct {
+	InProgress bool `protobuf:"varint,1,opt,name=inProgress" json:"inProgress,omitempty"`
+	// block height when the state transfer started
+	StartBlock uint64 `protobuf:"varint,2,opt,name=startBlock" json:"startBlock,omitempty"`
+	// block number the state transfer moves to
+	TargetBlock   uint64 `protobuf:"varint,3,opt,name=targetBlock" json:"targetBlock,omitempty"`
+	BlocksFetched uint64 `protobuf:"varint,4,opt,name=blocksFetched" json:"blocksFetched,omitempty"`
+	// number of bytes of the blocks, state deltas and state snapshot fetched
+	BytesFetched uint64                      `protobuf:"varint,5,opt,name=bytesFetched" json:"bytesFetched,omitempty"`
+	Started      *google_protobuf1.Timestamp `protobuf:"bytes,6,opt,name=started" json:"started,omitempty"`
+	ElapsedMs    uint64                      `protobuf:"varint,7,opt,name=elapsedMs" json:"elapsedMs,omitempty"`
+	// estimated time left to fetch the remaining blocks, 0 if unknown
+	EtaMs uint64 `protobuf:"varint,8,opt,name=etaMs" json:"etaMs,omitempty"`
+	// error of the latest attempt, which is retried while in progress
+	Error string `protobuf:"bytes,9,opt,name=error" json:"error,omitempty"`
+}
+
+func (m *StateTransferProgress) Reset()         { *m = StateTransferProgress{} }
+func (m *StateTransferProgress) String() string { return proto.CompactTextString(m) }
+func (*StateTransferProgress) ProtoMessage()    {}
+
+func (m *StateTransferProgress) GetStarted() *google_protobuf1.Timestamp {
+	if m != nil {
+		return m.Started
+	}
+	return nil
+}
+
 func init() {
 	proto.RegisterEnum("protos.ServerStatus_StatusCode", ServerStatus_StatusCode_name, ServerStatus_StatusCode_value)
+	proto.RegisterEnum("protos.ProfileRequest_Type", ProfileRequest_Type_name, ProfileRequest_Type_value)
 }
 
 // Reference imports to suppress errors if they are not otherwise used.
@@ -74,6 +422,45 @@ type AdminClient interface {
 	GetStatus(ctx context.Context, in *google_protobuf1.Empty, opts ...grpc.CallOption) (*ServerStatus, error)
 	StartServer(ctx context.Context, in *google_protobuf1.Empty, opts ...grpc.CallOption) (*ServerStatus, error)
 	StopServer(ctx context.Context, in *google_protobuf1.Empty, opts ...grpc.CallOption) (*ServerStatus, error)
+	// Return the state usage and quota of a chaincode.
+	GetStateUsage(ctx context.Context, in *ChaincodeID, opts ...grpc.CallOption) (*StateUsage, error)
+	// Change the faults injected by the peer. Only available in peers built
+	// with the chaos build tag.
+	SetChaosConfig(ctx context.Context, in *ChaosConfig, opts ...grpc.CallOption) (*ChaosConfig, error)
+	// Return the dedup window and cache hit statistics of a chaincode.
+	GetDedupStats(ctx context.Context, in *ChaincodeID, opts ...grpc.CallOption) (*DedupStats, error)
+	// Return a snapshot of the internal state of the consensus plugin.
+	GetConsensusStatus(ctx context.Context, in *google_protobuf1.Empty, opts ...grpc.CallOption) (*ConsensusStatus, error)
+	// Compact the ledger DB, reporting progress after each column family.
+	CompactLedger(ctx context.Context, in *google_protobuf1.Empty, opts ...grpc.CallOption) (Admin_CompactLedgerClient, error)
+	// Stream the consensus messages the peer sends and receives, decoded.
+	TraceConsensus(ctx context.Context, in *ConsensusTraceRequest, opts ...grpc.CallOption) (Admin_TraceConsensusClient, error)
+	// Return the key counts, state size and block size distribution of the ledger.
+	GetLedgerStatistics(ctx context.Context, in *google_protobuf1.Empty, opts ...grpc.CallOption) (*LedgerStatistics, error)
+	// Capture a heap or goroutine profile of the peer, stored on the peer.
+	CaptureProfile(ctx context.Context, in *ProfileRequest, opts ...grpc.CallOption) (*ProfileSnapshot, error)
+	// Hand a consensus message to the consensus plugin as if a peer had sent
+	// it, to reproduce how the plugin handles it.
+	ReplayConsensus(ctx context.Context, in *ConsensusReplayRequest, opts ...grpc.CallOption) (*ConsensusReplayResult, error)
+	// Request the reconfiguration of the validating network to a number of
+	// validators and of faults tolerated, ordered through consensus.
+	ReconfigureConsensus(ctx context.Context, in *ConsensusReconfiguration, opts ...grpc.CallOption) (*google_protobuf1.Empty, error)
+	// Pause or resume a subsystem of the peer, to observe how the rest of it
+	// degrades. Only available in peers built with the chaos build tag.
+	PauseSubsystem(ctx context.Context, in *Subsystem, opts ...grpc.CallOption) (*PausedSubsystems, error)
+	ResumeSubsystem(ctx context.Context, in *Subsystem, opts ...grpc.CallOption) (*PausedSubsystems, error)
+	// Deny-list a peer on this peer only: drop its messages and connections
+	// right away, until it is allowed again. The deny-list survives restarts.
+	DenyPeer(ctx context.Context, in *DeniedPeer, opts ...grpc.CallOption) (*DeniedPeers, error)
+	AllowPeer(ctx context.Context, in *DeniedPeer, opts ...grpc.CallOption) (*DeniedPeers, error)
+	GetDeniedPeers(ctx context.Context, in *google_protobuf1.Empty, opts ...grpc.CallOption) (*DeniedPeers, error)
+	// Return the breaker of a chaincode, which disables the chaincode after
+	// repeated failed invokes, or reset it to enable the chaincode again.
+	GetChaincodeBreaker(ctx context.Context, in *ChaincodeID, opts ...grpc.CallOption) (*ChaincodeBreaker, error)
+	ResetChaincodeBreaker(ctx context.Context, in *ChaincodeID, opts ...grpc.CallOption) (*ChaincodeBreaker, error)
+	// Catch up with the network through state transfer, from a chosen peer
+	// or any peer, streaming its progress until it completes.
+	SyncState(ctx context.Context, in *StateTransferRequest, opts ...grpc.CallOption) (Admin_SyncStateClient, error)
 }
 
 type adminClient struct {
@@ -111,6 +498,237 @@ func (c *adminClient) StopServer(ctx context.Context, in *google_protobuf1.Empty
 	return out, nil
 }
 
+func (c *adminClient) GetStateUsage(ctx context.Context, in *ChaincodeID, opts ...grpc.CallOption) (*StateUsage, error) {
+	out := new(StateUsage)
+	err := grpc.Invoke(ctx, "/protos.Admin/GetStateUsage", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *adminClient) SetChaosConfig(ctx context.Context, in *ChaosConfig, opts ...grpc.CallOption) (*ChaosConfig, error) {
+	out := new(ChaosConfig)
+	err := grpc.Invoke(ctx, "/protos.Admin/SetChaosConfig", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *adminClient) GetDedupStats(ctx context.Context, in *ChaincodeID, opts ...grpc.CallOption) (*DedupStats, error) {
+	out := new(DedupStats)
+	err := grpc.Invoke(ctx, "/protos.Admin/GetDedupStats", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *adminClient) GetConsensusStatus(ctx context.Context, in *google_protobuf1.Empty, opts ...grpc.CallOption) (*ConsensusStatus, error) {
+	out := new(ConsensusStatus)
+	err := grpc.Invoke(ctx, "/protos.Admin/GetConsensusStatus", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *adminClient) CompactLedger(ctx context.Context, in *google_protobuf1.Empty, opts ...grpc.CallOption) (Admin_CompactLedgerClient, error) {
+	stream, err := grpc.NewClientStream(ctx, &_Admin_serviceDesc.Streams[0], c.cc, "/protos.Admin/CompactLedger", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &adminCompactLedgerClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type Admin_CompactLedgerClient interface {
+	Recv() (*CompactionProgress, error)
+	grpc.ClientStream
+}
+
+type adminCompactLedgerClient struct {
+	grpc.ClientStream
+}
+
+func (x *adminCompactLedgerClient) Recv() (*CompactionProgress, error) {
+	m := new(CompactionProgress)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *adminClient) TraceConsensus(ctx context.Context, in *ConsensusTraceRequest, opts ...grpc.CallOption) (Admin_TraceConsensusClient, error) {
+	stream, err := grpc.NewClientStream(ctx, &_Admin_serviceDesc.Streams[1], c.cc, "/protos.Admin/TraceConsensus", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &adminTraceConsensusClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type Admin_TraceConsensusClient interface {
+	Recv() (*ConsensusTraceEvent, error)
+	grpc.ClientStream
+}
+
+type adminTraceConsensusClient struct {
+	grpc.ClientStream
+}
+
+func (x *adminTraceConsensusClient) Recv() (*ConsensusTraceEvent, error) {
+	m := new(ConsensusTraceEvent)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *adminClient) GetLedgerStatistics(ctx context.Context, in *google_protobuf1.Empty, opts ...grpc.CallOption) (*LedgerStatistics, error) {
+	out := new(LedgerStatistics)
+	err := grpc.Invoke(ctx, "/protos.Admin/GetLedgerStatistics", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *adminClient) CaptureProfile(ctx context.Context, in *ProfileRequest, opts ...grpc.CallOption) (*ProfileSnapshot, error) {
+	out := new(ProfileSnapshot)
+	err := grpc.Invoke(ctx, "/protos.Admin/CaptureProfile", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *adminClient) ReplayConsensus(ctx context.Context, in *ConsensusReplayRequest, opts ...grpc.CallOption) (*ConsensusReplayResult, error) {
+	out := new(ConsensusReplayResult)
+	err := grpc.Invoke(ctx, "/protos.Admin/ReplayConsensus", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *adminClient) ReconfigureConsensus(ctx context.Context, in *ConsensusReconfiguration, opts ...grpc.CallOption) (*google_protobuf1.Empty, error) {
+	out := new(google_protobuf1.Empty)
+	err := grpc.Invoke(ctx, "/protos.Admin/ReconfigureConsensus", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *adminClient) PauseSubsystem(ctx context.Context, in *Subsystem, opts ...grpc.CallOption) (*PausedSubsystems, error) {
+	out := new(PausedSubsystems)
+	err := grpc.Invoke(ctx, "/protos.Admin/PauseSubsystem", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *adminClient) ResumeSubsystem(ctx context.Context, in *Subsystem, opts ...grpc.CallOption) (*PausedSubsystems, error) {
+	out := new(PausedSubsystems)
+	err := grpc.Invoke(ctx, "/protos.Admin/ResumeSubsystem", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *adminClient) DenyPeer(ctx context.Context, in *DeniedPeer, opts ...grpc.CallOption) (*DeniedPeers, error) {
+	out := new(DeniedPeers)
+	err := grpc.Invoke(ctx, "/protos.Admin/DenyPeer", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *adminClient) AllowPeer(ctx context.Context, in *DeniedPeer, opts ...grpc.CallOption) (*DeniedPeers, error) {
+	out := new(DeniedPeers)
+	err := grpc.Invoke(ctx, "/protos.Admin/AllowPeer", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *adminClient) GetDeniedPeers(ctx context.Context, in *google_protobuf1.Empty, opts ...grpc.CallOption) (*DeniedPeers, error) {
+	out := new(DeniedPeers)
+	err := grpc.Invoke(ctx, "/protos.Admin/GetDeniedPeers", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *adminClient) GetChaincodeBreaker(ctx context.Context, in *ChaincodeID, opts ...grpc.CallOption) (*ChaincodeBreaker, error) {
+	out := new(ChaincodeBreaker)
+	err := grpc.Invoke(ctx, "/protos.Admin/GetChaincodeBreaker", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *adminClient) ResetChaincodeBreaker(ctx context.Context, in *ChaincodeID, opts ...grpc.CallOption) (*ChaincodeBreaker, error) {
+	out := new(ChaincodeBreaker)
+	err := grpc.Invoke(ctx, "/protos.Admin/ResetChaincodeBreaker", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *adminClient) SyncState(ctx context.Context, in *StateTransferRequest, opts ...grpc.CallOption) (Admin_SyncStateClient, error) {
+	stream, err := grpc.NewClientStream(ctx, &_Admin_serviceDesc.Streams[2], c.cc, "/protos.Admin/SyncState", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &adminSyncStateClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type Admin_SyncStateClient interface {
+	Recv() (*StateTransferProgress, error)
+	grpc.ClientStream
+}
+
+type adminSyncStateClient struct {
+	grpc.ClientStream
+}
+
+func (x *adminSyncStateClient) Recv() (*StateTransferProgress, error) {
+	m := new(StateTransferProgress)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
 // Server API for Admin service
 
 type AdminServer interface {
@@ -118,6 +736,45 @@ type AdminServer interface {
 	GetStatus(context.Context, *google_protobuf1.Empty) (*ServerStatus, error)
 	StartServer(context.Context, *google_protobuf1.Empty) (*ServerStatus, error)
 	StopServer(context.Context, *google_protobuf1.Empty) (*ServerStatus, error)
+	// Return the state usage and quota of a chaincode.
+	GetStateUsage(context.Context, *ChaincodeID) (*StateUsage, error)
+	// Change the faults injected by the peer. Only available in peers built
+	// with the chaos build tag.
+	SetChaosConfig(context.Context, *ChaosConfig) (*ChaosConfig, error)
+	// Return the dedup window and cache hit statistics of a chaincode.
+	GetDedupStats(context.Context, *ChaincodeID) (*DedupStats, error)
+	// Return a snapshot of the internal state of the consensus plugin.
+	GetConsensusStatus(context.Context, *google_protobuf1.Empty) (*ConsensusStatus, error)
+	// Compact the ledger DB, reporting progress after each column family.
+	CompactLedger(*google_protobuf1.Empty, Admin_CompactLedgerServer) error
+	// Stream the consensus messages the peer sends and receives, decoded.
+	TraceConsensus(*ConsensusTraceRequest, Admin_TraceConsensusServer) error
+	// Return the key counts, state size and block size distribution of the ledger.
+	GetLedgerStatistics(context.Context, *google_protobuf1.Empty) (*LedgerStatistics, error)
+	// Capture a heap or goroutine profile of the peer, stored on the peer.
+	CaptureProfile(context.Context, *ProfileRequest) (*ProfileSnapshot, error)
+	// Hand a consensus message to the consensus plugin as if a peer had sent
+	// it, to reproduce how the plugin handles it.
+	ReplayConsensus(context.Context, *ConsensusReplayRequest) (*ConsensusReplayResult, error)
+	// Request the reconfiguration of the validating network to a number of
+	// validators and of faults tolerated, ordered through consensus.
+	ReconfigureConsensus(context.Context, *ConsensusReconfiguration) (*google_protobuf1.Empty, error)
+	// Pause or resume a subsystem of the peer, to observe how the rest of it
+	// degrades. Only available in peers built with the chaos build tag.
+	PauseSubsystem(context.Context, *Subsystem) (*PausedSubsystems, error)
+	ResumeSubsystem(context.Context, *Subsystem) (*PausedSubsystems, error)
+	// Deny-list a peer on this peer only: drop its messages and connections
+	// right away, until it is allowed again. The deny-list survives restarts.
+	DenyPeer(context.Context, *DeniedPeer) (*DeniedPeers, error)
+	AllowPeer(context.Context, *DeniedPeer) (*DeniedPeers, error)
+	GetDeniedPeers(context.Context, *google_protobuf1.Empty) (*DeniedPeers, error)
+	// Return the breaker of a chaincode, which disables the chaincode after
+	// repeated failed invokes, or reset it to enable the chaincode again.
+	GetChaincodeBreaker(context.Context, *ChaincodeID) (*ChaincodeBreaker, error)
+	ResetChaincodeBreaker(context.Context, *ChaincodeID) (*ChaincodeBreaker, error)
+	// Catch up with the network through state transfer, from a chosen peer
+	// or any peer, streaming its progress until it completes.
+	SyncState(*StateTransferRequest, Admin_SyncStateServer) error
 }
 
 func RegisterAdminServer(s *grpc.Server, srv AdminServer) {
@@ -160,6 +817,249 @@ func _Admin_StopServer_Handler(srv interface{}, ctx context.Context, dec func(in
 	return out, nil
 }
 
+func _Admin_GetStateUsage_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error) (interface{}, error) {
+	in := new(ChaincodeID)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	out, err := srv.(AdminServer).GetStateUsage(ctx, in)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func _Admin_SetChaosConfig_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error) (interface{}, error) {
+	in := new(ChaosConfig)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	out, err := srv.(AdminServer).SetChaosConfig(ctx, in)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func _Admin_GetDedupStats_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error) (interface{}, error) {
+	in := new(ChaincodeID)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	out, err := srv.(AdminServer).GetDedupStats(ctx, in)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func _Admin_GetConsensusStatus_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error) (interface{}, error) {
+	in := new(google_protobuf1.Empty)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	out, err := srv.(AdminServer).GetConsensusStatus(ctx, in)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func _Admin_CompactLedger_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(google_protobuf1.Empty)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(AdminServer).CompactLedger(m, &adminCompactLedgerServer{stream})
+}
+
+type Admin_CompactLedgerServer interface {
+	Send(*CompactionProgress) error
+	grpc.ServerStream
+}
+
+type adminCompactLedgerServer struct {
+	grpc.ServerStream
+}
+
+func (x *adminCompactLedgerServer) Send(m *CompactionProgress) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _Admin_TraceConsensus_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(ConsensusTraceRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(AdminServer).TraceConsensus(m, &adminTraceConsensusServer{stream})
+}
+
+type Admin_TraceConsensusServer interface {
+	Send(*ConsensusTraceEvent) error
+	grpc.ServerStream
+}
+
+type adminTraceConsensusServer struct {
+	grpc.ServerStream
+}
+
+func (x *adminTraceConsensusServer) Send(m *ConsensusTraceEvent) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _Admin_GetLedgerStatistics_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error) (interface{}, error) {
+	in := new(google_protobuf1.Empty)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	out, err := srv.(AdminServer).GetLedgerStatistics(ctx, in)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func _Admin_CaptureProfile_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error) (interface{}, error) {
+	in := new(ProfileRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	out, err := srv.(AdminServer).CaptureProfile(ctx, in)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func _Admin_ReplayConsensus_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error) (interface{}, error) {
+	in := new(ConsensusReplayRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	out, err := srv.(AdminServer).ReplayConsensus(ctx, in)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func _Admin_ReconfigureConsensus_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error) (interface{}, error) {
+	in := new(ConsensusReconfiguration)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	out, err := srv.(AdminServer).ReconfigureConsensus(ctx, in)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func _Admin_PauseSubsystem_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error) (interface{}, error) {
+	in := new(Subsystem)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	out, err := srv.(AdminServer).PauseSubsystem(ctx, in)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func _Admin_ResumeSubsystem_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error) (interface{}, error) {
+	in := new(Subsystem)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	out, err := srv.(AdminServer).ResumeSubsystem(ctx, in)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func _Admin_DenyPeer_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error) (interface{}, error) {
+	in := new(DeniedPeer)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	out, err := srv.(AdminServer).DenyPeer(ctx, in)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func _Admin_AllowPeer_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error) (interface{}, error) {
+	in := new(DeniedPeer)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	out, err := srv.(AdminServer).AllowPeer(ctx, in)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func _Admin_GetDeniedPeers_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error) (interface{}, error) {
+	in := new(google_protobuf1.Empty)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	out, err := srv.(AdminServer).GetDeniedPeers(ctx, in)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func _Admin_GetChaincodeBreaker_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error) (interface{}, error) {
+	in := new(ChaincodeID)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	out, err := srv.(AdminServer).GetChaincodeBreaker(ctx, in)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func _Admin_ResetChaincodeBreaker_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error) (interface{}, error) {
+	in := new(ChaincodeID)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	out, err := srv.(AdminServer).ResetChaincodeBreaker(ctx, in)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func _Admin_SyncState_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(StateTransferRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(AdminServer).SyncState(m, &adminSyncStateServer{stream})
+}
+
+type Admin_SyncStateServer interface {
+	Send(*StateTransferProgress) error
+	grpc.ServerStream
+}
+
+type adminSyncStateServer struct {
+	grpc.ServerStream
+}
+
+func (x *adminSyncStateServer) Send(m *StateTransferProgress) error {
+	return x.ServerStream.SendMsg(m)
+}
+
 var _Admin_serviceDesc = grpc.ServiceDesc{
 	ServiceName: "protos.Admin",
 	HandlerType: (*AdminServer)(nil),
@@ -176,6 +1076,82 @@ var _Admin_serviceDesc = grpc.ServiceDesc{
 			MethodName: "StopServer",
 			Handler:    _Admin_StopServer_Handler,
 		},
+		{
+			MethodName: "GetStateUsage",
+			Handler:    _Admin_GetStateUsage_Handler,
+		},
+		{
+			MethodName: "SetChaosConfig",
+			Handler:    _Admin_SetChaosConfig_Handler,
+		},
+		{
+			MethodName: "GetDedupStats",
+			Handler:    _Admin_GetDedupStats_Handler,
+		},
+		{
+			MethodName: "GetConsensusStatus",
+			Handler:    _Admin_GetConsensusStatus_Handler,
+		},
+		{
+			MethodName: "GetLedgerStatistics",
+			Handler:    _Admin_GetLedgerStatistics_Handler,
+		},
+		{
+			MethodName: "CaptureProfile",
+			Handler:    _Admin_CaptureProfile_Handler,
+		},
+		{
+			MethodName: "ReplayConsensus",
+			Handler:    _Admin_ReplayConsensus_Handler,
+		},
+		{
+			MethodName: "ReconfigureConsensus",
+			Handler:    _Admin_ReconfigureConsensus_Handler,
+		},
+		{
+			MethodName: "PauseSubsystem",
+			Handler:    _Admin_PauseSubsystem_Handler,
+		},
+		{
+			MethodName: "ResumeSubsystem",
+			Handler:    _Admin_ResumeSubsystem_Handler,
+		},
+		{
+			MethodName: "DenyPeer",
+			Handler:    _Admin_DenyPeer_Handler,
+		},
+		{
+			MethodName: "AllowPeer",
+			Handler:    _Admin_AllowPeer_Handler,
+		},
+		{
+			MethodName: "GetDeniedPeers",
+			Handler:    _Admin_GetDeniedPeers_Handler,
+		},
+		{
+			MethodName: "GetChaincodeBreaker",
+			Handler:    _Admin_GetChaincodeBreaker_Handler,
+		},
+		{
+			MethodName: "ResetChaincodeBreaker",
+			Handler:    _Admin_ResetChaincodeBreaker_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "CompactLedger",
+			Handler:       _Admin_CompactLedger_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "TraceConsensus",
+			Handler:       _Admin_TraceConsensus_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "SyncState",
+			Handler:       _Admin_SyncState_Handler,
+			ServerStreams: true,
+		},
 	},
-	Streams: []grpc.StreamDesc{},
 }
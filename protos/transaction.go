@@ -69,6 +69,7 @@ func NewChaincodeDeployTransaction(chaincodeDeploymentSpec *ChaincodeDeploymentS
 	transaction.Type = Transaction_CHAINCODE_DEPLOY
 	transaction.Uuid = uuid
 	transaction.Timestamp = util.CreateUtcTimestamp()
+	transaction.Tags = chaincodeDeploymentSpec.ChaincodeSpec.GetTags()
 	cID := chaincodeDeploymentSpec.ChaincodeSpec.GetChaincodeID()
 	if cID != nil {
 		data, err := proto.Marshal(cID)
@@ -96,6 +97,10 @@ func NewChaincodeExecute(chaincodeInvocationSpec *ChaincodeInvocationSpec, uuid
 	transaction.Type = typ
 	transaction.Uuid = uuid
 	transaction.Timestamp = util.CreateUtcTimestamp()
+	transaction.Tags = chaincodeInvocationSpec.ChaincodeSpec.GetTags()
+	if typ == Transaction_CHAINCODE_QUERY && chaincodeInvocationSpec.ChaincodeSpec != nil {
+		transaction.MinHeight = chaincodeInvocationSpec.ChaincodeSpec.MinHeight
+	}
 	cID := chaincodeInvocationSpec.ChaincodeSpec.GetChaincodeID()
 	if cID != nil {
 		data, err := proto.Marshal(cID)
@@ -111,3 +116,27 @@ func NewChaincodeExecute(chaincodeInvocationSpec *ChaincodeInvocationSpec, uuid
 	transaction.Payload = data
 	return transaction, nil
 }
+
+// Limits on the tags of a transaction, which are stored in the index of the
+// ledger as given
+const (
+	MaxTransactionTags = 16
+	MaxTagLength       = 256
+)
+
+// CheckTags returns an error if the tags of the transaction are not within
+// the limits
+func (transaction *Transaction) CheckTags() error {
+	if len(transaction.Tags) > MaxTransactionTags {
+		return fmt.Errorf("Transaction has %d tags, at most %d allowed", len(transaction.Tags), MaxTransactionTags)
+	}
+	for _, tag := range transaction.Tags {
+		if tag == nil || tag.Key == "" {
+			return fmt.Errorf("Transaction tag has an empty key")
+		}
+		if len(tag.Key) > MaxTagLength || len(tag.Value) > MaxTagLength {
+			return fmt.Errorf("Transaction tag %s exceeds %d bytes", tag.Key, MaxTagLength)
+		}
+	}
+	return nil
+}
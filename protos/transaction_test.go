@@ -46,3 +46,53 @@ func Test_Transaction_CreateNew(t *testing.T) {
 	}
 
 }
+
+func Test_Transaction_Tags(t *testing.T) {
+	tags := []*TransactionTag{{Key: "order", Value: "42"}}
+	spec := &ChaincodeInvocationSpec{ChaincodeSpec: &ChaincodeSpec{ChaincodeID: &ChaincodeID{Name: "mycc"}, Tags: tags}}
+	tx, err := NewChaincodeExecute(spec, "uuid", Transaction_CHAINCODE_INVOKE)
+	if err != nil {
+		t.Fatalf("Could not create transaction: %s", err)
+	}
+	if len(tx.Tags) != 1 || tx.Tags[0].Key != "order" || tx.Tags[0].Value != "42" {
+		t.Fatalf("Expected the tags of the spec to be copied to the transaction, got %v", tx.Tags)
+	}
+	if err := tx.CheckTags(); err != nil {
+		t.Fatalf("Expected the tags to be valid: %s", err)
+	}
+
+	tx.Tags = []*TransactionTag{{Key: "", Value: "42"}}
+	if tx.CheckTags() == nil {
+		t.Fatalf("Expected a tag with an empty key to be rejected")
+	}
+	tx.Tags = []*TransactionTag{{Key: "order", Value: string(make([]byte, MaxTagLength+1))}}
+	if tx.CheckTags() == nil {
+		t.Fatalf("Expected a tag exceeding %d bytes to be rejected", MaxTagLength)
+	}
+	tx.Tags = make([]*TransactionTag, MaxTransactionTags+1)
+	for i := range tx.Tags {
+		tx.Tags[i] = &TransactionTag{Key: "order"}
+	}
+	if tx.CheckTags() == nil {
+		t.Fatalf("Expected more than %d tags to be rejected", MaxTransactionTags)
+	}
+}
+
+func Test_Transaction_MinHeight(t *testing.T) {
+	spec := &ChaincodeInvocationSpec{ChaincodeSpec: &ChaincodeSpec{ChaincodeID: &ChaincodeID{Name: "mycc"}, MinHeight: 7}}
+	tx, err := NewChaincodeExecute(spec, "uuid", Transaction_CHAINCODE_QUERY)
+	if err != nil {
+		t.Fatalf("Could not create transaction: %s", err)
+	}
+	if tx.MinHeight != 7 {
+		t.Fatalf("Expected the minimum height of the spec to be copied to the query, got %d", tx.MinHeight)
+	}
+
+	tx, err = NewChaincodeExecute(spec, "uuid", Transaction_CHAINCODE_INVOKE)
+	if err != nil {
+		t.Fatalf("Could not create transaction: %s", err)
+	}
+	if tx.MinHeight != 0 {
+		t.Fatalf("Expected no minimum height on an invoke, got %d", tx.MinHeight)
+	}
+}
@@ -0,0 +1,143 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package proof lets client applications verify what a peer tells them about
+// the ledger without trusting the peer: that blocks chain up to a block they
+// trust, that a block was declared final by a quorum of validators, that a
+// transaction is part of a block, and that a key holds a value in the state a
+// block commits to. It only depends on the protos package, and may be embedded
+// in any client application.
+package proof
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"encoding/asn1"
+	"fmt"
+	"hash"
+	"math/big"
+
+	"github.com/golang/protobuf/proto"
+	"golang.org/x/crypto/sha3"
+
+	pb "github.com/hyperledger/fabric/protos"
+)
+
+// VerifyChain verifies that blocks, in increasing block number, are linked by
+// their previous block hashes and that the last of them hashes to trustedHash
+func VerifyChain(trustedHash []byte, blocks []*pb.Block) error {
+	expected := trustedHash
+	for i := len(blocks) - 1; i >= 0; i-- {
+		hash, err := blocks[i].GetHash()
+		if err != nil {
+			return err
+		}
+		if !bytes.Equal(hash, expected) {
+			return fmt.Errorf("Block %d of the chain hashes to %x, expected %x", i, hash, expected)
+		}
+		expected = blocks[i].PreviousBlockHash
+	}
+	return nil
+}
+
+// VerifyTransaction verifies that block hashes to blockHash and returns its
+// transaction with the given UUID
+func VerifyTransaction(blockHash []byte, block *pb.Block, txUUID string) (*pb.Transaction, error) {
+	hash, err := block.GetHash()
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(hash, blockHash) {
+		return nil, fmt.Errorf("Block hashes to %x, expected %x", hash, blockHash)
+	}
+	for _, tx := range block.Transactions {
+		if tx.Uuid == txUUID {
+			return tx, nil
+		}
+	}
+	return nil, fmt.Errorf("Transaction %s is not part of block %x", txUUID, blockHash)
+}
+
+// FinalitySignature is the signature of a validator declaring a block final
+type FinalitySignature struct {
+	Validator string
+	Signature []byte // ASN.1 encoded ECDSA signature of the finality message
+}
+
+// FinalityMessage returns the message validators sign to declare the block
+// with the given number and hash final
+func FinalityMessage(blockNumber uint64, blockHash []byte) []byte {
+	return append(proto.EncodeVarint(blockNumber), blockHash...)
+}
+
+// ValidatorSet holds the keys of the validators a client trusts to declare
+// blocks final, and how many of them must agree
+type ValidatorSet struct {
+	Keys   map[string]*ecdsa.PublicKey // by validator
+	Quorum int                         // signatures needed for finality
+	Hash   func() hash.Hash            // digest of signed messages, SHA3-256 if nil
+}
+
+type ecdsaSignature struct {
+	R, S *big.Int
+}
+
+// VerifyFinality verifies that a quorum of distinct validators of the set
+// signed the finality message of the block with the given number and hash
+func (vs *ValidatorSet) VerifyFinality(blockNumber uint64, blockHash []byte, signatures []FinalitySignature) error {
+	if vs.Quorum < 1 {
+		return fmt.Errorf("Validator set quorum must be positive, got %d", vs.Quorum)
+	}
+	newHash := vs.Hash
+	if newHash == nil {
+		newHash = sha3.New256
+	}
+	h := newHash()
+	h.Write(FinalityMessage(blockNumber, blockHash))
+	digest := h.Sum(nil)
+
+	signed := make(map[string]bool)
+	for _, sig := range signatures {
+		key, ok := vs.Keys[sig.Validator]
+		if !ok || signed[sig.Validator] {
+			continue
+		}
+		es := &ecdsaSignature{}
+		if _, err := asn1.Unmarshal(sig.Signature, es); err != nil || es.R == nil || es.S == nil {
+			continue
+		}
+		if ecdsa.Verify(key, digest, es.R, es.S) {
+			signed[sig.Validator] = true
+		}
+	}
+	if len(signed) < vs.Quorum {
+		return fmt.Errorf("Block %d (%x) is signed by %d validators, %d needed for finality", blockNumber, blockHash, len(signed), vs.Quorum)
+	}
+	return nil
+}
+
+// VerifyBlock verifies that block, with the given number, was declared final
+// by a quorum of validators, and returns its hash
+func (vs *ValidatorSet) VerifyBlock(blockNumber uint64, block *pb.Block, signatures []FinalitySignature) ([]byte, error) {
+	hash, err := block.GetHash()
+	if err != nil {
+		return nil, err
+	}
+	if err = vs.VerifyFinality(blockNumber, hash, signatures); err != nil {
+		return nil, err
+	}
+	return hash, nil
+}
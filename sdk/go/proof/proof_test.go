@@ -0,0 +1,239 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proof
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/asn1"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"testing"
+
+	"golang.org/x/crypto/sha3"
+
+	pb "github.com/hyperledger/fabric/protos"
+)
+
+func makeChain(t *testing.T, n int) []*pb.Block {
+	var blocks []*pb.Block
+	var previous []byte
+	for i := 0; i < n; i++ {
+		block := pb.NewBlock([]*pb.Transaction{{Uuid: fmt.Sprintf("tx%d", i)}}, nil)
+		block.PreviousBlockHash = previous
+		block.NonHashData = &pb.NonHashData{}
+		hash, err := block.GetHash()
+		if err != nil {
+			t.Fatalf("Failed to hash block: %s", err)
+		}
+		previous = hash
+		blocks = append(blocks, block)
+	}
+	return blocks
+}
+
+func TestVerifyChain(t *testing.T) {
+	blocks := makeChain(t, 3)
+	tip, _ := blocks[2].GetHash()
+	if err := VerifyChain(tip, blocks); err != nil {
+		t.Fatalf("Expected chain to verify: %s", err)
+	}
+	if err := VerifyChain(tip, blocks[1:]); err != nil {
+		t.Fatalf("Expected end of chain to verify: %s", err)
+	}
+	if err := VerifyChain(blocks[2].PreviousBlockHash, blocks); err == nil {
+		t.Errorf("Expected chain not ending with the trusted hash to be rejected")
+	}
+	blocks[0].Transactions[0].Uuid = "forged"
+	if err := VerifyChain(tip, blocks); err == nil {
+		t.Errorf("Expected chain with tampered block to be rejected")
+	}
+}
+
+func TestVerifyTransaction(t *testing.T) {
+	block := makeChain(t, 1)[0]
+	hash, _ := block.GetHash()
+	if tx, err := VerifyTransaction(hash, block, "tx0"); err != nil || tx.Uuid != "tx0" {
+		t.Fatalf("Expected transaction tx0 to verify, got %v: %v", tx, err)
+	}
+	if _, err := VerifyTransaction(hash, block, "tx1"); err == nil {
+		t.Errorf("Expected missing transaction to be rejected")
+	}
+	block.Transactions = append(block.Transactions, &pb.Transaction{Uuid: "tx1"})
+	if _, err := VerifyTransaction(hash, block, "tx1"); err == nil {
+		t.Errorf("Expected transaction added to the block to be rejected")
+	}
+}
+
+func TestVerifyFinality(t *testing.T) {
+	keys := make(map[string]*ecdsa.PublicKey)
+	var signers []*ecdsa.PrivateKey
+	for i := 0; i < 4; i++ {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			t.Fatalf("Failed to generate key: %s", err)
+		}
+		keys[fmt.Sprintf("vp%d", i)] = &key.PublicKey
+		signers = append(signers, key)
+	}
+	vs := &ValidatorSet{Keys: keys, Quorum: 3}
+
+	blockHash := []byte("block hash")
+	digest := sha3.Sum256(FinalityMessage(7, blockHash))
+	var signatures []FinalitySignature
+	for i, key := range signers {
+		r, s, err := ecdsa.Sign(rand.Reader, key, digest[:])
+		if err != nil {
+			t.Fatalf("Failed to sign: %s", err)
+		}
+		raw, _ := asn1.Marshal(ecdsaSignature{r, s})
+		signatures = append(signatures, FinalitySignature{Validator: fmt.Sprintf("vp%d", i), Signature: raw})
+	}
+
+	if err := vs.VerifyFinality(7, blockHash, signatures[:3]); err != nil {
+		t.Fatalf("Expected quorum of signatures to verify: %s", err)
+	}
+	if err := vs.VerifyFinality(8, blockHash, signatures); err == nil {
+		t.Errorf("Expected signatures of another block number to be rejected")
+	}
+	duplicated := []FinalitySignature{signatures[0], signatures[0], signatures[1]}
+	if err := vs.VerifyFinality(7, blockHash, duplicated); err == nil {
+		t.Errorf("Expected signatures of the same validator to count once")
+	}
+	impostor := []FinalitySignature{signatures[0], signatures[1], {Validator: "vp3", Signature: signatures[2].Signature}}
+	if err := vs.VerifyFinality(7, blockHash, impostor); err == nil {
+		t.Errorf("Expected signature under the wrong validator to be rejected")
+	}
+}
+
+// testTree is a bucket tree held in memory, for building state proofs
+type testTree struct {
+	config  TreeConfig
+	buckets map[int][]KeyValue
+	hashes  [][][]byte // by level from the lowest one, then bucket number - 1
+}
+
+func newTestTree(config TreeConfig, kvs []KeyValue) *testTree {
+	sort.Sort(byCompositeKey(kvs))
+	tree := &testTree{config: config, buckets: make(map[int][]KeyValue)}
+	for _, kv := range kvs {
+		b := config.bucketOf(compositeKey(kv.ChaincodeID, kv.Key))
+		tree.buckets[b] = append(tree.buckets[b], kv)
+	}
+	lowest := make([][]byte, config.NumBuckets)
+	for i := range lowest {
+		lowest[i] = bucketHash(tree.buckets[i+1])
+	}
+	tree.hashes = [][][]byte{lowest}
+	for level := lowest; len(level) > 1; {
+		g := config.MaxGroupingAtEachLevel
+		parents := make([][]byte, (len(level)+g-1)/g)
+		for p := range parents {
+			parents[p] = nodeHash(tree.children(level, p+1))
+		}
+		tree.hashes = append(tree.hashes, parents)
+		level = parents
+	}
+	return tree
+}
+
+// children returns the crypto-hashes of the children of parent
+func (tree *testTree) children(level [][]byte, parent int) [][]byte {
+	g := tree.config.MaxGroupingAtEachLevel
+	children := make([][]byte, g)
+	for i := range children {
+		if n := (parent-1)*g + i; n < len(level) {
+			children[i] = level[n]
+		}
+	}
+	return children
+}
+
+func (tree *testTree) root() []byte {
+	return tree.hashes[len(tree.hashes)-1][0]
+}
+
+func (tree *testTree) prove(chaincodeID string, key string) *StateProof {
+	bucket := tree.config.bucketOf(compositeKey(chaincodeID, key))
+	proof := &StateProof{Bucket: tree.buckets[bucket]}
+	g := tree.config.MaxGroupingAtEachLevel
+	for _, level := range tree.hashes[:len(tree.hashes)-1] {
+		bucket = (bucket + g - 1) / g
+		proof.Children = append(proof.Children, tree.children(level, bucket))
+	}
+	return proof
+}
+
+type byCompositeKey []KeyValue
+
+func (kvs byCompositeKey) Len() int      { return len(kvs) }
+func (kvs byCompositeKey) Swap(i, j int) { kvs[i], kvs[j] = kvs[j], kvs[i] }
+func (kvs byCompositeKey) Less(i, j int) bool {
+	return bytes.Compare(compositeKey(kvs[i].ChaincodeID, kvs[i].Key), compositeKey(kvs[j].ChaincodeID, kvs[j].Key)) < 0
+}
+
+func TestVerifyState(t *testing.T) {
+	config := TreeConfig{NumBuckets: 26, MaxGroupingAtEachLevel: 3}
+	var kvs []KeyValue
+	for i := 0; i < 12; i++ {
+		kvs = append(kvs, KeyValue{fmt.Sprintf("chaincode%d", i%3), fmt.Sprintf("key%d", i), []byte(fmt.Sprintf("value%d", i))})
+	}
+	tree := newTestTree(config, kvs)
+
+	// Root hash the bucket tree state implementation computes for these keys
+	expectedRoot, _ := hex.DecodeString("3c2fb60e52e6603a8adb0095c386b1a8681d1633a62dcd01a4195f813e87550d24086e76581a5ee0498f86977e1aa4ef8d97c374627fd28769d8d852e5aa3097")
+	if !bytes.Equal(tree.root(), expectedRoot) {
+		t.Fatalf("Expected state hash %x, got %x", expectedRoot, tree.root())
+	}
+
+	for _, kv := range kvs {
+		value, found, err := config.VerifyState(expectedRoot, kv.ChaincodeID, kv.Key, tree.prove(kv.ChaincodeID, kv.Key))
+		if err != nil || !found || !bytes.Equal(value, kv.Value) {
+			t.Fatalf("Expected key %s of chaincode %s to verify with value %s, got %s (%v): %v", kv.Key, kv.ChaincodeID, kv.Value, value, found, err)
+		}
+	}
+
+	if _, found, err := config.VerifyState(expectedRoot, "chaincode0", "missing", tree.prove("chaincode0", "missing")); err != nil || found {
+		t.Fatalf("Expected missing key to be proven absent, got %v: %v", found, err)
+	}
+
+	proof := tree.prove("chaincode0", "key0")
+	proof.Bucket = append([]KeyValue{}, proof.Bucket...)
+	for i := range proof.Bucket {
+		if proof.Bucket[i].Key == "key0" {
+			proof.Bucket[i].Value = []byte("forged")
+		}
+	}
+	if _, _, err := config.VerifyState(expectedRoot, "chaincode0", "key0", proof); err == nil {
+		t.Errorf("Expected proof with forged value to be rejected")
+	}
+
+	proof = tree.prove("chaincode0", "key0")
+	var others []KeyValue
+	for _, kv := range proof.Bucket {
+		if kv.Key != "key0" {
+			others = append(others, kv)
+		}
+	}
+	proof.Bucket = others
+	if _, _, err := config.VerifyState(expectedRoot, "chaincode0", "key0", proof); err == nil {
+		t.Errorf("Expected proof hiding the key to be rejected")
+	}
+}
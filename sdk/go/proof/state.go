@@ -0,0 +1,200 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proof
+
+import (
+	"bytes"
+	"fmt"
+	"hash/fnv"
+
+	"github.com/golang/protobuf/proto"
+	"golang.org/x/crypto/sha3"
+)
+
+// State proofs follow the bucket tree state implementation of the ledger. A
+// key falls in the lowest-level bucket picked by the FNV hash of its composite
+// key, the hash of a bucket covers all the keys and values it holds, and the
+// hash of a bucket higher up the tree is computed from the hashes of its
+// children. A proof thus carries the contents of the bucket of the key, and
+// the hashes of the children of each bucket on the way up to the root, whose
+// hash is the state hash of the block.
+//
+// No peer API serves state proofs yet. A peer serving them must send a
+// StateProof encoded as JSON, as encoding/json does with the tags below:
+//
+//	{
+//	  "bucket": [{"chaincodeID": "mycc", "key": "a", "value": "<base64>"}, ...],
+//	  "children": [["<base64>", null, ...], ...]
+//	}
+//
+// "bucket" lists every key of the lowest-level bucket of the proven key, the
+// key itself included if set, sorted by composite key (the chaincode ID, a
+// 0x00 byte, then the key). A key proven absent still comes with the other
+// keys of its bucket, which may be none. "children" has one entry per level
+// above the lowest one, from the parent of the bucket of the key up to the
+// root. Each entry lists the 64-byte crypto-hashes of all
+// MaxGroupingAtEachLevel children of the bucket on the path, in bucket
+// order, null or "" for an empty child or one past the last bucket of the
+// level. The hash of the child on the path may be sent as anything, as it is
+// recomputed by the verifier. The proof is for the state of a block whose
+// StateHash the client verified along the chain.
+
+// TreeConfig is the configuration of the bucket tree of the peers
+type TreeConfig struct {
+	NumBuckets             int
+	MaxGroupingAtEachLevel int
+}
+
+// DefaultTreeConfig is the bucket tree configuration peers run by default
+var DefaultTreeConfig = TreeConfig{NumBuckets: 10009, MaxGroupingAtEachLevel: 10}
+
+// KeyValue is a key of a chaincode, along with its value
+type KeyValue struct {
+	ChaincodeID string `json:"chaincodeID"`
+	Key         string `json:"key"`
+	Value       []byte `json:"value"`
+}
+
+// StateProof proves the value of a key, or its absence, in a state
+type StateProof struct {
+	// Bucket holds all the keys of the lowest-level bucket of the key, in
+	// increasing composite key order
+	Bucket []KeyValue `json:"bucket"`
+	// Children holds, for each level from the parent of the bucket of the key
+	// up to the root, the crypto-hashes of the children of the bucket on the
+	// path. The hash of the child on the path is computed by the verifier.
+	Children [][][]byte `json:"children"`
+}
+
+func compositeKey(chaincodeID string, key string) []byte {
+	return bytes.Join([][]byte{[]byte(chaincodeID), []byte(key)}, []byte{0x00})
+}
+
+func cryptoHash(data []byte) []byte {
+	hash := make([]byte, 64)
+	sha3.ShakeSum256(hash, data)
+	return hash
+}
+
+// bucketOf returns the lowest-level bucket holding the composite key ck
+func (c TreeConfig) bucketOf(ck []byte) int {
+	h := fnv.New32a()
+	h.Write(ck)
+	return int(h.Sum32())%c.NumBuckets + 1
+}
+
+// levels returns the number of levels above the lowest one
+func (c TreeConfig) levels() int {
+	levels := 0
+	for n := c.NumBuckets; n > 1; levels++ {
+		n = (n + c.MaxGroupingAtEachLevel - 1) / c.MaxGroupingAtEachLevel
+	}
+	return levels
+}
+
+// bucketHash returns the crypto-hash of a lowest-level bucket, as computed
+// by the bucket tree, or nil for an empty bucket
+func bucketHash(kvs []KeyValue) []byte {
+	var data []byte
+	appendSize := func(size int) {
+		data = append(data, proto.EncodeVarint(uint64(size))...)
+	}
+	appendSizeAndData := func(b []byte) {
+		appendSize(len(b))
+		data = append(data, b...)
+	}
+	for i := 0; i < len(kvs); {
+		j := i
+		for j < len(kvs) && kvs[j].ChaincodeID == kvs[i].ChaincodeID {
+			j++
+		}
+		appendSizeAndData([]byte(kvs[i].ChaincodeID))
+		appendSize(j - i)
+		for ; i < j; i++ {
+			appendSizeAndData([]byte(kvs[i].Key))
+			appendSizeAndData(kvs[i].Value)
+		}
+	}
+	if data == nil {
+		return nil
+	}
+	return cryptoHash(data)
+}
+
+// nodeHash returns the crypto-hash of a bucket above the lowest level, as
+// computed by the bucket tree, from the crypto-hashes of its children
+func nodeHash(children [][]byte) []byte {
+	var data []byte
+	numChildren := 0
+	for _, child := range children {
+		if len(child) != 0 {
+			numChildren++
+			data = append(data, child...)
+		}
+	}
+	switch numChildren {
+	case 0:
+		return nil
+	case 1:
+		return data
+	}
+	return cryptoHash(data)
+}
+
+// VerifyState verifies proof against stateHash, and returns the value of the
+// key of the chaincode, and whether the key is set at all
+func (c TreeConfig) VerifyState(stateHash []byte, chaincodeID string, key string, proof *StateProof) (value []byte, found bool, err error) {
+	if c.NumBuckets < 2 || c.MaxGroupingAtEachLevel < 2 {
+		return nil, false, fmt.Errorf("Invalid bucket tree configuration %+v", c)
+	}
+	levels := c.levels()
+	if len(proof.Children) != levels {
+		return nil, false, fmt.Errorf("State proof holds %d levels, the bucket tree has %d", len(proof.Children), levels)
+	}
+
+	bucket := c.bucketOf(compositeKey(chaincodeID, key))
+	var last []byte
+	for _, kv := range proof.Bucket {
+		ck := compositeKey(kv.ChaincodeID, kv.Key)
+		if b := c.bucketOf(ck); b != bucket {
+			return nil, false, fmt.Errorf("State proof holds key %s of chaincode %s from bucket %d, expected bucket %d", kv.Key, kv.ChaincodeID, b, bucket)
+		}
+		if last != nil && bytes.Compare(last, ck) >= 0 {
+			return nil, false, fmt.Errorf("State proof keys are not in increasing order")
+		}
+		last = ck
+		if kv.ChaincodeID == chaincodeID && kv.Key == key {
+			value, found = kv.Value, true
+		}
+	}
+
+	hash := bucketHash(proof.Bucket)
+	for _, children := range proof.Children {
+		if len(children) != c.MaxGroupingAtEachLevel {
+			return nil, false, fmt.Errorf("State proof holds %d children for a bucket, expected %d", len(children), c.MaxGroupingAtEachLevel)
+		}
+		withChild := make([][]byte, len(children))
+		copy(withChild, children)
+		withChild[(bucket-1)%c.MaxGroupingAtEachLevel] = hash
+		hash = nodeHash(withChild)
+		bucket = (bucket + c.MaxGroupingAtEachLevel - 1) / c.MaxGroupingAtEachLevel
+	}
+	if !bytes.Equal(hash, stateHash) {
+		return nil, false, fmt.Errorf("State proof leads to state hash %x, expected %x", hash, stateHash)
+	}
+	return value, found, nil
+}
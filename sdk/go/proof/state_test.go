@@ -0,0 +1,129 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proof
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/hyperledger/fabric/core/db"
+	"github.com/hyperledger/fabric/core/ledger/statemgmt"
+	"github.com/hyperledger/fabric/core/ledger/statemgmt/buckettree"
+	"github.com/spf13/viper"
+	"github.com/tecbot/gorocksdb"
+)
+
+// commitState applies delta to the bucket tree state and persists it, and
+// returns the state hash
+func commitState(t *testing.T, testDB *db.TestDBWrapper, state *buckettree.StateImpl, delta *statemgmt.StateDelta) []byte {
+	if err := state.PrepareWorkingSet(delta); err != nil {
+		t.Fatalf("Error preparing working set: %s", err)
+	}
+	stateHash, err := state.ComputeCryptoHash()
+	if err != nil {
+		t.Fatalf("Error computing state hash: %s", err)
+	}
+	writeBatch := gorocksdb.NewWriteBatch()
+	defer writeBatch.Destroy()
+	if err = state.AddChangesForPersistence(writeBatch); err != nil {
+		t.Fatalf("Error adding changes for persistence: %s", err)
+	}
+	testDB.WriteToDB(t, writeBatch)
+	state.ClearWorkingSet(true)
+	return stateHash
+}
+
+// TestVerifyStateBucketTree tests proofs against the state hash the bucket
+// tree state implementation of the ledger computes, across commits
+func TestVerifyStateBucketTree(t *testing.T) {
+	dir, err := ioutil.TempDir("", "proof")
+	if err != nil {
+		t.Fatalf("Error creating DB directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	viper.Set("peer.fileSystemPath", dir)
+	testDB := db.NewTestDBWrapper()
+	testDB.CreateFreshDB(t)
+	defer testDB.CloseDB(t)
+
+	config := TreeConfig{NumBuckets: 26, MaxGroupingAtEachLevel: 3}
+	state := buckettree.NewStateImpl()
+	err = state.Initialize(map[string]interface{}{
+		buckettree.ConfigNumBuckets:             config.NumBuckets,
+		buckettree.ConfigMaxGroupingAtEachLevel: config.MaxGroupingAtEachLevel,
+	})
+	if err != nil {
+		t.Fatalf("Error initializing state: %s", err)
+	}
+
+	keys := make(map[string]KeyValue)
+	delta := statemgmt.NewStateDelta()
+	for i := 0; i < 40; i++ {
+		kv := KeyValue{fmt.Sprintf("chaincode%d", i%3), fmt.Sprintf("key%d", i), []byte(fmt.Sprintf("value%d", i))}
+		delta.Set(kv.ChaincodeID, kv.Key, kv.Value, nil)
+		keys[kv.ChaincodeID+"/"+kv.Key] = kv
+	}
+	verify := func(stateHash []byte) {
+		var kvs []KeyValue
+		for _, kv := range keys {
+			kvs = append(kvs, kv)
+		}
+		tree := newTestTree(config, kvs)
+		if !bytes.Equal(tree.root(), stateHash) {
+			t.Fatalf("Expected state hash %x, got %x", stateHash, tree.root())
+		}
+		for _, kv := range keys {
+			// proofs go through their wire format
+			encoded, err := json.Marshal(tree.prove(kv.ChaincodeID, kv.Key))
+			if err != nil {
+				t.Fatalf("Error encoding proof: %s", err)
+			}
+			proof := &StateProof{}
+			if err = json.Unmarshal(encoded, proof); err != nil {
+				t.Fatalf("Error decoding proof: %s", err)
+			}
+			value, found, err := config.VerifyState(stateHash, kv.ChaincodeID, kv.Key, proof)
+			if err != nil || !found || !bytes.Equal(value, kv.Value) {
+				t.Fatalf("Expected key %s of chaincode %s to verify with value %s, got %s (%v): %v", kv.Key, kv.ChaincodeID, kv.Value, value, found, err)
+			}
+		}
+		if _, found, err := config.VerifyState(stateHash, "chaincode0", "missing", tree.prove("chaincode0", "missing")); err != nil || found {
+			t.Fatalf("Expected missing key to be proven absent, got %v: %v", found, err)
+		}
+	}
+	verify(commitState(t, testDB, state, delta))
+
+	// a later block updating and deleting keys
+	delta = statemgmt.NewStateDelta()
+	for i := 0; i < 40; i += 4 {
+		kv := keys[fmt.Sprintf("chaincode%d/key%d", i%3, i)]
+		delta.Delete(kv.ChaincodeID, kv.Key, kv.Value)
+		delete(keys, kv.ChaincodeID+"/"+kv.Key)
+	}
+	for i := 1; i < 40; i += 4 {
+		kv := keys[fmt.Sprintf("chaincode%d/key%d", i%3, i)]
+		previous := kv.Value
+		kv.Value = []byte(fmt.Sprintf("updated%d", i))
+		delta.Set(kv.ChaincodeID, kv.Key, kv.Value, previous)
+		keys[kv.ChaincodeID+"/"+kv.Key] = kv
+	}
+	verify(commitState(t, testDB, state, delta))
+}
@@ -0,0 +1,109 @@
+/*
+Copyright IBM Corp. 2016 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/op/go-logging"
+	"github.com/spf13/viper"
+
+	"github.com/hyperledger/fabric/core/crypto"
+	"github.com/hyperledger/fabric/events/bridge"
+)
+
+var logger = logging.MustGetLogger("bridge")
+
+func main() {
+	configFile := flag.String("config", "bridge.yaml", "configuration of the bridge")
+	flag.Parse()
+
+	config, err := loadConfig(*configFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error reading %s: %s\n", *configFile, err)
+		os.Exit(1)
+	}
+
+	b, err := bridge.New(config)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error creating the bridge: %s\n", err)
+		os.Exit(1)
+	}
+	if err = b.Start(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error starting the bridge: %s\n", err)
+		os.Exit(1)
+	}
+	logger.Infof("Bridge relaying the events of network %s", config.Network)
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	<-signals
+	b.Stop()
+}
+
+// loadConfig reads the configuration of the bridge. The connections to the
+// peers and the crypto client are configured by core.yaml, read from the
+// working directory.
+func loadConfig(file string) (config bridge.Config, err error) {
+	v := viper.New()
+	v.SetConfigFile(file)
+	if err = v.ReadInConfig(); err != nil {
+		return
+	}
+
+	config.Network = v.GetString("network")
+	if err = v.UnmarshalKey("source", &config.Source); err != nil {
+		return
+	}
+	if err = v.UnmarshalKey("target", &config.Target); err != nil {
+		return
+	}
+	if err = v.UnmarshalKey("routes", &config.Routes); err != nil {
+		return
+	}
+	config.StateFile = v.GetString("stateFile")
+	config.RetryInterval = v.GetDuration("retryInterval")
+
+	raw, err := ioutil.ReadFile(v.GetString("key"))
+	if err != nil {
+		return
+	}
+	if config.Key, err = bridge.ParsePrivateKey(raw); err != nil {
+		return
+	}
+
+	viper.SetConfigName("core")
+	viper.AddConfigPath("./")
+	if err = viper.ReadInConfig(); err != nil {
+		return
+	}
+	if enrollID := v.GetString("enrollID"); enrollID != "" {
+		crypto.Init()
+		if err = crypto.RegisterClient(enrollID, nil, enrollID, v.GetString("enrollSecret")); err != nil {
+			return
+		}
+		if config.Client, err = crypto.InitClient(enrollID, nil); err != nil {
+			return
+		}
+	}
+	return
+}
@@ -80,6 +80,9 @@ func printLiveFilesMetaData(openchainDB *db.OpenchainDB) {
 	fmt.Println("------ Details of LiveFilesMetaData ---")
 	db := openchainDB.DB
 	liveFileMetadata := db.GetLiveFilesMetaData()
+	if openchainDB.BlocksApart() {
+		liveFileMetadata = append(liveFileMetadata, openchainDB.BlockDB.GetLiveFilesMetaData()...)
+	}
 	for _, file := range liveFileMetadata {
 		fmt.Printf("file.Name=[%s], file.Level=[%d], file.Size=[%d]\n",
 			file.Name, file.Level, file.Size)
@@ -89,26 +92,27 @@ func printLiveFilesMetaData(openchainDB *db.OpenchainDB) {
 func printProperties(openchainDB *db.OpenchainDB) {
 	fmt.Println("------ Details of Properties ---")
 	db := openchainDB.DB
+	blockDB := openchainDB.BlockDB
 	fmt.Printf("rocksdb.estimate-live-data-size:- BlockchainCF:%s, StateCF:%s, StateDeltaCF:%s, IndexesCF:%s, PersistCF:%s\n\n",
-		db.GetPropertyCF("rocksdb.estimate-live-data-size", openchainDB.BlockchainCF),
+		blockDB.GetPropertyCF("rocksdb.estimate-live-data-size", openchainDB.BlockchainCF),
 		db.GetPropertyCF("rocksdb.estimate-live-data-size", openchainDB.StateCF),
 		db.GetPropertyCF("rocksdb.estimate-live-data-size", openchainDB.StateDeltaCF),
-		db.GetPropertyCF("rocksdb.estimate-live-data-size", openchainDB.IndexesCF),
+		blockDB.GetPropertyCF("rocksdb.estimate-live-data-size", openchainDB.IndexesCF),
 		db.GetPropertyCF("rocksdb.estimate-live-data-size", openchainDB.PersistCF))
 	fmt.Printf("Default:%s\n", db.GetProperty("rocksdb.estimate-live-data-size"))
 
 	fmt.Printf("rocksdb.num-live-versions:- BlockchainCF:%s, StateCF:%s, StateDeltaCF:%s, IndexesCF:%s, PersistCF:%s\n\n",
-		db.GetPropertyCF("rocksdb.num-live-versions", openchainDB.BlockchainCF),
+		blockDB.GetPropertyCF("rocksdb.num-live-versions", openchainDB.BlockchainCF),
 		db.GetPropertyCF("rocksdb.num-live-versions", openchainDB.StateCF),
 		db.GetPropertyCF("rocksdb.num-live-versions", openchainDB.StateDeltaCF),
-		db.GetPropertyCF("rocksdb.num-live-versions", openchainDB.IndexesCF),
+		blockDB.GetPropertyCF("rocksdb.num-live-versions", openchainDB.IndexesCF),
 		db.GetPropertyCF("rocksdb.num-live-versions", openchainDB.PersistCF))
 
 	fmt.Printf("rocksdb.cfstats:\n %s %s %s %s %s\n\n",
-		db.GetPropertyCF("rocksdb.cfstats", openchainDB.BlockchainCF),
+		blockDB.GetPropertyCF("rocksdb.cfstats", openchainDB.BlockchainCF),
 		db.GetPropertyCF("rocksdb.cfstats", openchainDB.StateCF),
 		db.GetPropertyCF("rocksdb.cfstats", openchainDB.StateDeltaCF),
-		db.GetPropertyCF("rocksdb.cfstats", openchainDB.IndexesCF),
+		blockDB.GetPropertyCF("rocksdb.cfstats", openchainDB.IndexesCF),
 		db.GetPropertyCF("rocksdb.cfstats", openchainDB.PersistCF))
 }
 